@@ -0,0 +1,44 @@
+// Admin CLI used by operators to send requests to the admin APIs of a running backend
+// (like looking up a player, granting energy, banning a player, or taking a backup) with the admin token
+package main
+
+import (
+	"context"
+	"errors"
+	"example.com/dice-game-backend/internal/admincli"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+func main() {
+
+	host := flag.String("host", "localhost", "the host the services of the target environment run on (on their usual ports)")
+	token := flag.String("token", os.Getenv(constants.AdminTokenEnvVar), "the admin token (read from "+constants.AdminTokenEnvVar+" if not set)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: admincli [-host host] [-token token] <command>\n\n%v\n\nflags:\n", admincli.Usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *token == "" {
+		fmt.Fprintf(os.Stderr, "no admin token, set it with -token or %v\n", constants.AdminTokenEnvVar)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cli := admincli.NewCLI(admincli.DefaultTargets(*host), *token, os.Stdout)
+	err := cli.Run(ctx, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		stop()
+		if errors.As(err, &admincli.UsageErr{}) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
@@ -1,35 +1,39 @@
 // Convenience runner used to spin up all the different microservices from a single
-// terminal command, and then wait on user input to shut them all down when done
+// terminal command, and then wait on user input to shut them all down when done.
+// Since all the servers live in the same process, they are wired together directly
+// (in-process clients), so there are no internal network hops between the services
 package main
 
 import (
+	"context"
 	"example.com/dice-game-backend/internal/auth"
 	"example.com/dice-game-backend/internal/config"
 	"example.com/dice-game-backend/internal/data"
 	"example.com/dice-game-backend/internal/gameplay"
+	"example.com/dice-game-backend/internal/guilds"
+	"example.com/dice-game-backend/internal/match"
+	"example.com/dice-game-backend/internal/notifications"
 	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/promo"
+	"example.com/dice-game-backend/internal/referral"
+	"example.com/dice-game-backend/internal/shared/clock"
 	"example.com/dice-game-backend/internal/shared/constants"
-	"example.com/dice-game-backend/internal/shared/validation"
+	"example.com/dice-game-backend/internal/shared/i18n"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shop"
 	"example.com/dice-game-backend/internal/stats"
+	"example.com/dice-game-backend/internal/webhooks"
 	"fmt"
-	"net/http"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"log"
+	"os"
+	"sync"
 	"time"
 )
 
-// the request validator struct implements a wrapper around the common method
-// that propagates session based validation requests to the auth service
-type requestValidator struct {
-}
-
-func (rv *requestValidator) ValidateRequest(req *http.Request) error {
-
-	if rv == nil {
-		return fmt.Errorf("the validator is nil")
-	}
-
-	return validation.ValidateRequest(req)
-}
-
 // This function loops till the player inputs the given quit keys ('0', or 'q', or 'Q')
 // or manually interrupts (ctrl+c) the terminal window
 func waitLoop() {
@@ -55,29 +59,220 @@ func waitLoop() {
 }
 
 func main() {
+
+	// the startup config (request timeout, max energy) comes from the defaults, a yaml file, the environment
+	// and the flags, run with -print-config to see it (the services keep their designated ports)
+	startupConfig, printConfig, err := startup.Load(startup.AllServices, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(startupConfig)
+		return
+	}
+	startup.Apply(startupConfig)
+	if startupConfig.MaxEnergy > 0 {
+		config.Config.MaxEnergy = startupConfig.MaxEnergy
+	}
+
 	fmt.Println("starting all the servers...")
 
-	rv := &requestValidator{}
+	shutdownTracing, err := tracing.Init("dice-game-backend")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
 
-	authServer := auth.NewServer()
-	go authServer.Run(constants.AuthServerPort)
+	// all the servers share this process, so a random service secret (if none is set) keeps the internal endpoints to it
+	err = identity.InitInProcess("dice-game-backend")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the namespace keeps the data of this service apart from other environments / game titles sharing the data service
+	err = namespace.EnableFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// player facing text (config text and error messages) can come from a translations directory
+	err = i18n.EnableTranslationsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the levels can come from a content directory (which is checked for new levels while running)
+	err = config.EnableLevelContentFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the servers run till the wait loop ends, then they are all shut down gracefully (see middleware.Serve)
+	ctx, shutDown := context.WithCancel(context.Background())
+	servers := &sync.WaitGroup{}
+	serve := func(run func(ctx context.Context, addr string) error, port string) {
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			err := run(ctx, constants.CommonHost+":"+port)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
 
 	dataServer := data.NewServer()
-	go dataServer.Run(constants.DataServerPort)
+	err = dataServer.EnableArchivalFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dataServer.EnableBackupsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dataServer.EnableEventSourcingFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dataServer.EnableRedisFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dataServer.EnablePostgresFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serve(dataServer.Serve, constants.DataServerPort)
 
-	configServer := config.NewServer(rv)
-	go configServer.Run(constants.ConfigServerPort)
+	// the auth server validates sessions for the other servers directly,
+	// the login request is verified by the built-in passwords, or an LDAP directory / identity provider if set
+	authProviders, err := auth.ProvidersFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	authServer := auth.NewServer(dataServer, authProviders...)
+	err = authServer.EnableSocialLoginFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	profileServer := profile.NewServer(rv)
-	go profileServer.Run(constants.ProfileServerPort)
+	// logins after a threshold (if set) wait in a queue, so a stampede after maintenance does not overload the services
+	err = authServer.EnableLoginQueueFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// state changing requests need a single use nonce of their session (if enabled), so they cannot be replayed
+	err = authServer.EnableRequestNoncesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// outdated clients (by the client compatibility matrix of the game config) are told to update when they log in
+	authServer.EnableClientGate(config.Config)
+
+	// in the time offset mode (if enabled), admins can move the clock of the auth, profile and gameplay servers
+	// ahead or back together (with the time offset request of any of them), to test timed events
+	timeOffsetMode, err := clock.OffsetModeFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var timeOffset *clock.Offset
+	if timeOffsetMode {
+		timeOffset = clock.NewOffset(clock.System, log.New(os.Stdout, "clock: ", log.Ltime|log.LUTC|log.Lmsgprefix))
+	}
+	authServer.EnableTimeOffset(timeOffset)
+	serve(authServer.Serve, constants.AuthServerPort)
+
+	configServer := config.NewServer(authServer)
+	serve(configServer.Serve, constants.ConfigServerPort)
+
+	// the newer features check the feature flags of the config server directly
+	flagChecker := config.NewFlagChecker(configServer)
+
+	// logins creating an account pass a challenge (if set) while the login-challenge flag is on, when abuse is detected
+	err = authServer.EnableLoginChallengeFromEnv(flagChecker)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the gameplay, stats and match servers publish their events to the webhooks server directly
+	webhooksServer := webhooks.NewServer()
+	serve(webhooksServer.Serve, constants.WebhooksServerPort)
+
+	profileServer := profile.NewServer(authServer, dataServer)
+	err = profileServer.EnableEnergyReconcileFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	profileServer.EnableFeatureFlags(flagChecker)
+	profileServer.EnableTimeOffset(timeOffset)
+	serve(profileServer.Serve, constants.ProfileServerPort)
 
-	statsServer := stats.NewServer(rv)
-	go statsServer.Run(constants.StatsServerPort)
+	statsServer := stats.NewServer(authServer, dataServer)
+	statsServer.EnableFeatureFlags(flagChecker)
+	statsServer.EnableWebhooks(webhooksServer)
+	statsServer.EnableMilestoneRewards(profileServer)
+	err = statsServer.EnableExportFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = statsServer.EnableLeaderboardCacheFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serve(statsServer.Serve, constants.StatsServerPort)
+
+	referralServer := referral.NewServer(authServer, dataServer, profileServer)
+	serve(referralServer.Serve, constants.ReferralServerPort)
+
+	gameplayServer := gameplay.NewServer(authServer, profileServer, statsServer, dataServer)
+	err = gameplayServer.EnableAsyncStatsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = gameplayServer.EnableOutboxFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = gameplayServer.EnableDynamicDifficultyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = gameplayServer.EnableThrottlesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gameplayServer.EnableReferrals(referralServer)
+	gameplayServer.EnableWebhooks(webhooksServer)
+	gameplayServer.EnableFeatureFlags(flagChecker)
+	gameplayServer.EnableTimeOffset(timeOffset)
+	serve(gameplayServer.Serve, constants.GameplayServerPort)
+
+	shopServer := shop.NewServer(authServer, dataServer, profileServer)
+	serve(shopServer.Serve, constants.ShopServerPort)
 
-	gameplayServer := gameplay.NewServer(rv)
-	go gameplayServer.Run(constants.GameplayServerPort)
+	promoServer := promo.NewServer(authServer, dataServer, profileServer)
+	serve(promoServer.Serve, constants.PromoServerPort)
+
+	matchServer := match.NewServer(authServer, dataServer, profileServer, statsServer)
+	err = matchServer.EnableSeededRNGFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	matchServer.EnableWebhooks(webhooksServer)
+	serve(matchServer.Serve, constants.MatchServerPort)
+
+	notificationsServer := notifications.NewServer(authServer, profileServer)
+	serve(notificationsServer.Serve, constants.NotificationsServerPort)
+
+	guildsServer := guilds.NewServer(authServer, dataServer, profileServer)
+	serve(guildsServer.Serve, constants.GuildsServerPort)
 
 	time.Sleep(500 * time.Millisecond) // wait some time so that the following instructions to exit the loop are on the last line
 	fmt.Println("at any point, press 0 or q or Q (followed by Enter) to quit...")
 	waitLoop()
+
+	shutDown()
+	servers.Wait()
 }
@@ -2,13 +2,99 @@
 package main
 
 import (
+	"context"
 	"example.com/dice-game-backend/internal/auth"
-	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/i18n"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"fmt"
+	"log"
+	"os"
 )
 
 func main() {
+
+	// the startup config (port, request timeout, downstream addresses...) comes from the defaults, a yaml file,
+	// the environment and the flags, run with -print-config to see it
+	startupConfig, printConfig, err := startup.Load("auth", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(startupConfig)
+		return
+	}
+	startup.Apply(startupConfig)
+
 	fmt.Println("starting the auth server...")
-	authServer := auth.NewServer()
-	authServer.Run(constants.AuthServerPort)
+
+	shutdownTracing, err := tracing.Init("auth")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// internal requests carry (and internal endpoints check) service tokens signed with the shared service secret
+	err = identity.Init("auth")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the namespace keeps the data of this service apart from other environments / game titles sharing the data service
+	err = namespace.EnableFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// player facing text (config text and error messages) can come from a translations directory
+	err = i18n.EnableTranslationsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the login request is verified by the built-in passwords, or an LDAP directory / identity provider if set
+	authProviders, err := auth.ProvidersFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	authServer := auth.NewServer(data.NewHTTPClient(), authProviders...)
+
+	// players can sign in with Google / Apple, for the providers whose client ids are set
+	err = authServer.EnableSocialLoginFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// logins after a threshold (if set) wait in a queue, so a stampede after maintenance does not overload the services
+	err = authServer.EnableLoginQueueFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// state changing requests need a single use nonce of their session (if enabled), so they cannot be replayed
+	err = authServer.EnableRequestNoncesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// outdated clients (by the client compatibility matrix of the game config) are told to update when they log in
+	authServer.EnableClientGate(config.Config)
+
+	// logins creating an account pass a challenge (if set) while the login-challenge flag is on, when abuse is detected
+	err = authServer.EnableLoginChallengeFromEnv(config.NewFlagChecker(config.NewHTTPClient()))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authServer.SetSweepPeriod(startupConfig.SweepPeriod())
+	// in the time offset mode (if enabled), admins can move the clock of the server ahead or back, to test timed events
+	err = authServer.EnableTimeOffsetFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	authServer.Run(startupConfig.Port)
 }
@@ -0,0 +1,62 @@
+// QA runner used to spin up a number of bots (simulated players) against a running backend,
+// each of them going through realistic sessions as one of the behavior profiles says,
+// and then print a summary of the requests they made once they are all done (or on ctrl+c)
+package main
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/bots"
+	"example.com/dice-game-backend/internal/shared/rng"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+
+	botCount := flag.Int("bots", 10, "the number of bots to run")
+	host := flag.String("host", "localhost", "the host the services of the target environment run on (on their usual ports)")
+	profilesPath := flag.String("profiles", "", "a json file with the behavior profiles of the bots (the default profiles are used if not set)")
+	prefix := flag.String("prefix", fmt.Sprintf("bot%v-", time.Now().UTC().Unix()), "the prefix of the bot usernames (should be new to the target, as the bots sign up)")
+	seed := flag.Uint64("seed", 0, "a seed for the choices of the bots, to repeat a run (random if not set)")
+	flag.Parse()
+
+	profiles := bots.DefaultProfiles
+	if *profilesPath != "" {
+		var err error
+		profiles, err = bots.LoadProfiles(*profilesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var random rng.RNG = rng.NewCryptoRNG()
+	if *seed != 0 {
+		random = rng.NewSeededRNG(*seed)
+	}
+
+	simulation, err := bots.NewSimulation(bots.DefaultTargets(*host), profiles, *botCount, *prefix, random)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// an interrupt stops the bots (their open sessions are still logged out)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("starting %v bots against %v...\n", *botCount, *host)
+	start := time.Now()
+
+	report := simulation.Run(ctx)
+
+	fmt.Printf("bots done in %v\n", time.Since(start).Round(time.Millisecond))
+	fmt.Print(report)
+
+	if report.Failures() > 0 {
+		stop()
+		os.Exit(1)
+	}
+}
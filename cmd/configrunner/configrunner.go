@@ -2,11 +2,18 @@
 package main
 
 import (
+	"context"
 	"example.com/dice-game-backend/internal/config"
-	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/i18n"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"example.com/dice-game-backend/internal/shared/validation"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 )
 
 // the request validator struct implements a wrapper around the common method
@@ -14,17 +21,64 @@ import (
 type requestValidator struct {
 }
 
-func (rv *requestValidator) ValidateRequest(req *http.Request) error {
+func (rv *requestValidator) ValidateRequest(req *http.Request) (string, error) {
 
 	if rv == nil {
-		return fmt.Errorf("the validator is nil")
+		return "", fmt.Errorf("the validator is nil")
 	}
 
 	return validation.ValidateRequest(req)
 }
 
 func main() {
+
+	// the startup config (port, request timeout, downstream addresses...) comes from the defaults, a yaml file,
+	// the environment and the flags, run with -print-config to see it
+	startupConfig, printConfig, err := startup.Load("config", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(startupConfig)
+		return
+	}
+	startup.Apply(startupConfig)
+	if startupConfig.MaxEnergy > 0 {
+		config.Config.MaxEnergy = startupConfig.MaxEnergy
+	}
+
 	fmt.Println("starting the config server...")
+
+	shutdownTracing, err := tracing.Init("config")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// internal requests carry (and internal endpoints check) service tokens signed with the shared service secret
+	err = identity.Init("config")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the namespace keeps the data of this service apart from other environments / game titles sharing the data service
+	err = namespace.EnableFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// player facing text (config text and error messages) can come from a translations directory
+	err = i18n.EnableTranslationsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the levels can come from a content directory (which is checked for new levels while running)
+	err = config.EnableLevelContentFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	configServer := config.NewServer(&requestValidator{})
-	configServer.Run(constants.ConfigServerPort)
+	configServer.Run(startupConfig.Port)
 }
@@ -2,13 +2,77 @@
 package main
 
 import (
+	"context"
 	"example.com/dice-game-backend/internal/data"
-	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"fmt"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"log"
+	"os"
 )
 
 func main() {
+
+	// the startup config (port, request timeout, downstream addresses...) comes from the defaults, a yaml file,
+	// the environment and the flags, run with -print-config to see it
+	startupConfig, printConfig, err := startup.Load("data", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(startupConfig)
+		return
+	}
+	startup.Apply(startupConfig)
+
 	fmt.Println("starting the data server...")
+
+	shutdownTracing, err := tracing.Init("data")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// internal requests carry (and internal endpoints check) service tokens signed with the shared service secret
+	err = identity.Init("data")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// internal requests without a namespace are handled in the namespace of the data service itself
+	err = namespace.EnableFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	dataServer := data.NewServer()
-	dataServer.Run(constants.DataServerPort)
+	err = dataServer.EnableArchivalFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dataServer.EnableBackupsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dataServer.EnableEventSourcingFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dataServer.EnableRedisFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dataServer.EnablePostgresFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// a primary ships its writes of players and stats to its followers, which serve reads a few writes behind it
+	err = dataServer.EnableReplicationFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dataServer.Run(startupConfig.Port)
 }
@@ -2,27 +2,103 @@
 package main
 
 import (
+	"context"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
 	"example.com/dice-game-backend/internal/profile"
-	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"example.com/dice-game-backend/internal/shared/validation"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 )
 
 // the request validator struct implements a wrapper around the common method
 // that propagates session based validation requests to the auth service
 type requestValidator struct{}
 
-func (rv *requestValidator) ValidateRequest(req *http.Request) error {
+func (rv *requestValidator) ValidateRequest(req *http.Request) (string, error) {
 
 	if rv == nil {
-		return fmt.Errorf("the validator is nil")
+		return "", fmt.Errorf("the validator is nil")
 	}
 	return validation.ValidateRequest(req)
 }
 
+func (rv *requestValidator) RevokePlayerSessions(ctx context.Context, playerID string) error {
+
+	if rv == nil {
+		return fmt.Errorf("the validator is nil")
+	}
+	return validation.RevokePlayerSessions(ctx, playerID)
+}
+
 func main() {
+
+	// the startup config (port, request timeout, downstream addresses...) comes from the defaults, a yaml file,
+	// the environment and the flags, run with -print-config to see it
+	startupConfig, printConfig, err := startup.Load("profile", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(startupConfig)
+		return
+	}
+	startup.Apply(startupConfig)
+	if startupConfig.MaxEnergy > 0 {
+		config.Config.MaxEnergy = startupConfig.MaxEnergy
+	}
+
 	fmt.Println("starting the profile server...")
-	profileServer := profile.NewServer(&requestValidator{})
-	profileServer.Run(constants.ProfileServerPort)
+
+	shutdownTracing, err := tracing.Init("profile")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// internal requests carry (and internal endpoints check) service tokens signed with the shared service secret
+	err = identity.Init("profile")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the namespace keeps the data of this service apart from other environments / game titles sharing the data service
+	err = namespace.EnableFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the levels can come from a content directory (which is checked for new levels while running)
+	err = config.EnableLevelContentFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// players and stats read from the data service can be kept in an in-memory cache (for a short time)
+	dataClient, err := data.WithCacheFromEnv(data.NewHTTPClient())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	profileServer := profile.NewServer(&requestValidator{}, dataClient)
+
+	// the stored energy of the players can be brought up to date periodically (instead of only when they are read)
+	err = profileServer.EnableEnergyReconcileFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the newer features check the feature flags served by the config service
+	profileServer.EnableFeatureFlags(config.NewFlagChecker(config.NewHTTPClient()))
+	// in the time offset mode (if enabled), admins can move the clock of the server ahead or back, to test timed events
+	err = profileServer.EnableTimeOffsetFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	profileServer.Run(startupConfig.Port)
 }
@@ -0,0 +1,75 @@
+// Used to spin up a referral server as an independent microservice on the given port
+package main
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/referral"
+	"example.com/dice-game-backend/internal/shared/i18n"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// the request validator struct implements a wrapper around the common method
+// that propagates session based validation requests to the auth service
+type requestValidator struct{}
+
+func (rv *requestValidator) ValidateRequest(req *http.Request) (string, error) {
+
+	if rv == nil {
+		return "", fmt.Errorf("the validator is nil")
+	}
+	return validation.ValidateRequest(req)
+}
+
+func main() {
+
+	// the startup config (port, request timeout, downstream addresses...) comes from the defaults, a yaml file,
+	// the environment and the flags, run with -print-config to see it
+	startupConfig, printConfig, err := startup.Load("referral", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(startupConfig)
+		return
+	}
+	startup.Apply(startupConfig)
+
+	fmt.Println("starting the referral server...")
+
+	shutdownTracing, err := tracing.Init("referral")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// internal requests carry (and internal endpoints check) service tokens signed with the shared service secret
+	err = identity.Init("referral")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the namespace keeps the data of this service apart from other environments / game titles sharing the data service
+	err = namespace.EnableFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// player facing text (config text and error messages) can come from a translations directory
+	err = i18n.EnableTranslationsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	referralServer := referral.NewServer(&requestValidator{}, data.NewHTTPClient(), profile.NewHTTPClient())
+	referralServer.Run(startupConfig.Port)
+}
@@ -2,27 +2,101 @@
 package main
 
 import (
-	"example.com/dice-game-backend/internal/shared/constants"
+	"context"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"example.com/dice-game-backend/internal/shared/validation"
 	"example.com/dice-game-backend/internal/stats"
+	"example.com/dice-game-backend/internal/webhooks"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 )
 
 // the request validator struct implements a wrapper around the common method
 // that propagates session based validation requests to the auth service
 type requestValidator struct{}
 
-func (rv *requestValidator) ValidateRequest(req *http.Request) error {
+func (rv *requestValidator) ValidateRequest(req *http.Request) (string, error) {
 
 	if rv == nil {
-		return fmt.Errorf("the validator is nil")
+		return "", fmt.Errorf("the validator is nil")
 	}
 	return validation.ValidateRequest(req)
 }
 
 func main() {
+
+	// the startup config (port, request timeout, downstream addresses...) comes from the defaults, a yaml file,
+	// the environment and the flags, run with -print-config to see it
+	startupConfig, printConfig, err := startup.Load("stats", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(startupConfig)
+		return
+	}
+	startup.Apply(startupConfig)
+
 	fmt.Println("starting the stats server...")
-	statsServer := stats.NewServer(&requestValidator{})
-	statsServer.Run(constants.StatsServerPort)
+
+	shutdownTracing, err := tracing.Init("stats")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// internal requests carry (and internal endpoints check) service tokens signed with the shared service secret
+	err = identity.Init("stats")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the namespace keeps the data of this service apart from other environments / game titles sharing the data service
+	err = namespace.EnableFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// players and stats read from the data service can be kept in an in-memory cache (for a short time)
+	dataClient, err := data.WithCacheFromEnv(data.NewHTTPClient())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	statsServer := stats.NewServer(&requestValidator{}, dataClient)
+	// the newer features check the feature flags served by the config service
+	statsServer.EnableFeatureFlags(config.NewFlagChecker(config.NewHTTPClient()))
+	statsServer.EnableWebhooks(webhooks.NewHTTPClient())
+	// the energy rewards of the claimed milestones are granted by the profile service
+	statsServer.EnableMilestoneRewards(profile.NewHTTPClient())
+	// all the player stats can be exported to csv files for offline analysis
+	err = statsServer.EnableExportFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the read heavy requests (the rating leaderboard) can go to a data follower instead of the primary
+	replicaClient, err := data.NewReplicaHTTPClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if replicaClient != nil {
+		statsServer.EnableReadReplica(replicaClient)
+	}
+
+	// the rating leaderboard can be served from a snapshot refreshed in the background (after the read replica is set,
+	// which the refreshes read from)
+	err = statsServer.EnableLeaderboardCacheFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	statsServer.Run(startupConfig.Port)
 }
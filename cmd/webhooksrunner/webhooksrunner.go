@@ -0,0 +1,46 @@
+// Used to spin up a webhooks server as an independent microservice on the given port
+package main
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/webhooks"
+	"fmt"
+	"log"
+	"os"
+)
+
+func main() {
+
+	// the startup config (port, request timeout, downstream addresses...) comes from the defaults, a yaml file,
+	// the environment and the flags, run with -print-config to see it
+	startupConfig, printConfig, err := startup.Load("webhooks", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		fmt.Print(startupConfig)
+		return
+	}
+	startup.Apply(startupConfig)
+
+	fmt.Println("starting the webhooks server...")
+
+	shutdownTracing, err := tracing.Init("webhooks")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// internal requests carry (and internal endpoints check) service tokens signed with the shared service secret
+	err = identity.Init("webhooks")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webhooksServer := webhooks.NewServer()
+	webhooksServer.SetSweepPeriod(startupConfig.SweepPeriod())
+	webhooksServer.Run(startupConfig.Port)
+}
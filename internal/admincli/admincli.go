@@ -0,0 +1,316 @@
+// Package admincli: the commands of the admin CLI, each of which sends a request to an admin API of one of the services
+// (with the admin token), so operators do not have to put the requests together by hand
+package admincli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Usage describes the commands of the admin CLI
+const Usage = `commands:
+  player get <player id>                       show the data of a player
+  player set <player id> <level> <energy>      overwrite the level and energy of a player
+  grant-energy <player id> <energy>            give energy to a player
+  ftue reset <player id>                       take a player back to the start of the FTUE (tutorial)
+  annotate [-kind note] [-reference ref] [-author name] <player id> <text>
+                                               attach a note, support-ticket, compensation or suspicion annotation to a player
+  unannotate <player id> <annotation id>       remove an annotation of a player
+  ban [-reason text] [-duration 24h] <player id>   ban a player (suspend them, if a duration is given)
+  unban <player id>                            lift the ban of a player
+  stats reset <player id>                      clear the level stats of a player
+  stats audit [player id]                      check the level, stats and attempt history of a player (or of the first page of players) agree
+  config reload                                reload the levels directory of the config service
+  backup [-format json|gob]                    take a backup of the data service`
+
+// requestTimeout is generous, since taking a backup of a large data service can take a while
+const requestTimeout = time.Minute
+
+// UsageErr is returned when the arguments do not make up a valid command
+type UsageErr struct {
+	Problem string
+}
+
+func (err UsageErr) Error() string {
+	return fmt.Sprintf("%v\n%v", err.Problem, Usage)
+}
+
+// Targets are the base urls of the services the admin requests are sent to
+type Targets struct {
+	Auth    string
+	Config  string
+	Data    string
+	Profile string
+	Stats   string
+}
+
+// DefaultTargets returns the targets for the services running on the given host, on their usual ports
+func DefaultTargets(host string) Targets {
+
+	baseURL := func(port string) string {
+		return constants.CommonProtocol + "://" + host + ":" + port
+	}
+
+	return Targets{
+		Auth:    baseURL(constants.AuthServerPort),
+		Config:  baseURL(constants.ConfigServerPort),
+		Data:    baseURL(constants.DataServerPort),
+		Profile: baseURL(constants.ProfileServerPort),
+		Stats:   baseURL(constants.StatsServerPort),
+	}
+}
+
+// CLI runs the admin commands against the given targets, writing the responses to its output
+type CLI struct {
+	targets    Targets
+	token      string
+	httpClient *http.Client
+	out        io.Writer
+}
+
+// NewCLI returns an initialized pointer to a CLI which sends the given admin token with its requests
+func NewCLI(targets Targets, token string, out io.Writer) *CLI {
+	return &CLI{
+		targets:    targets,
+		token:      token,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		out:        out,
+	}
+}
+
+// Run runs the command in the given arguments (see Usage)
+func (c *CLI) Run(ctx context.Context, args []string) error {
+
+	if len(args) == 0 {
+		return UsageErr{Problem: "no command given"}
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "player":
+		return c.runPlayer(ctx, args)
+	case "grant-energy":
+		return c.runGrantEnergy(ctx, args)
+	case "ftue":
+		if len(args) != 2 || args[0] != "reset" {
+			return UsageErr{Problem: "ftue needs the reset subcommand and a player id"}
+		}
+		return c.send(ctx, "POST", c.targets.Profile+"/profile/admin/ftue/reset", &profile.FTUERequestBody{PlayerID: args[1]})
+	case "annotate":
+		return c.runAnnotate(ctx, args)
+	case "unannotate":
+		if len(args) != 2 {
+			return UsageErr{Problem: "unannotate needs a player id and an annotation id"}
+		}
+		return c.send(ctx, "DELETE", c.targets.Profile+"/profile/admin/annotations/"+url.PathEscape(args[0])+"/"+url.PathEscape(args[1]), nil)
+	case "ban":
+		return c.runBan(ctx, args)
+	case "unban":
+		if len(args) != 1 {
+			return UsageErr{Problem: "unban needs a player id"}
+		}
+		return c.send(ctx, "DELETE", c.targets.Auth+"/auth/admin/ban/"+url.PathEscape(args[0]), nil)
+	case "stats":
+		return c.runStats(ctx, args)
+	case "config":
+		if len(args) != 1 || args[0] != "reload" {
+			return UsageErr{Problem: "config needs the reload subcommand"}
+		}
+		return c.send(ctx, "POST", c.targets.Config+"/config/admin/reload", nil)
+	case "backup":
+		return c.runBackup(ctx, args)
+	default:
+		return UsageErr{Problem: fmt.Sprintf("unknown command: %v", command)}
+	}
+}
+
+// runPlayer runs the player get / set subcommands
+func (c *CLI) runPlayer(ctx context.Context, args []string) error {
+
+	if len(args) == 2 && args[0] == "get" {
+		return c.send(ctx, "GET", c.targets.Profile+"/profile/admin/player/"+url.PathEscape(args[1]), nil)
+	}
+
+	if len(args) == 4 && args[0] == "set" {
+		level, err := parseInt32(args[2], "level")
+		if err != nil {
+			return err
+		}
+		energy, err := parseInt32(args[3], "energy")
+		if err != nil {
+			return err
+		}
+		return c.send(ctx, "PUT", c.targets.Profile+"/profile/admin/player", &profile.PlayerOverride{PlayerID: args[1], Level: level, Energy: energy})
+	}
+
+	return UsageErr{Problem: "player needs the get subcommand and a player id, or the set subcommand, a player id, a level and an energy"}
+}
+
+// runGrantEnergy runs the grant-energy command
+func (c *CLI) runGrantEnergy(ctx context.Context, args []string) error {
+
+	if len(args) != 2 {
+		return UsageErr{Problem: "grant-energy needs a player id and an energy"}
+	}
+
+	energy, err := parseInt32(args[1], "energy")
+	if err != nil {
+		return err
+	}
+
+	return c.send(ctx, "POST", c.targets.Profile+"/profile/admin/grant-energy", &profile.EnergyGrant{PlayerID: args[0], Energy: energy})
+}
+
+// runStats runs the stats reset / audit subcommands
+func (c *CLI) runStats(ctx context.Context, args []string) error {
+
+	if len(args) == 2 && args[0] == "reset" {
+		return c.send(ctx, "POST", c.targets.Stats+"/stats/admin/reset/"+url.PathEscape(args[1]), nil)
+	}
+
+	if len(args) == 1 && args[0] == "audit" {
+		return c.send(ctx, "GET", c.targets.Stats+"/stats/admin/consistency-audit", nil)
+	}
+
+	if len(args) == 2 && args[0] == "audit" {
+		return c.send(ctx, "GET", c.targets.Stats+"/stats/admin/consistency-audit?id="+url.QueryEscape(args[1]), nil)
+	}
+
+	return UsageErr{Problem: "stats needs the reset subcommand and a player id, or the audit subcommand (and optionally a player id)"}
+}
+
+// runAnnotate runs the annotate command, the words after the player id make up the text of the annotation
+func (c *CLI) runAnnotate(ctx context.Context, args []string) error {
+
+	flags := flag.NewFlagSet("annotate", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	kind := flags.String("kind", "note", "the kind of annotation (note, support-ticket, compensation or suspicion)")
+	reference := flags.String("reference", "", "a reference for the annotation, like a support ticket id")
+	author := flags.String("author", "", "who added the annotation (admin, if not set)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return UsageErr{Problem: "invalid annotate flags: " + err.Error()}
+	}
+
+	if flags.NArg() < 2 {
+		return UsageErr{Problem: "annotate needs a player id and a text"}
+	}
+
+	annotationReq := &profile.AnnotationRequestBody{PlayerID: flags.Arg(0), Kind: *kind, Text: strings.Join(flags.Args()[1:], " "), Reference: *reference, Author: *author}
+	return c.send(ctx, "POST", c.targets.Profile+"/profile/admin/annotations", annotationReq)
+}
+
+// runBan runs the ban command, without a duration the ban is permanent
+func (c *CLI) runBan(ctx context.Context, args []string) error {
+
+	flags := flag.NewFlagSet("ban", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	reason := flags.String("reason", "", "the reason for the ban")
+	duration := flags.Duration("duration", 0, "how long the player is suspended for (permanent if not set)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return UsageErr{Problem: "invalid ban flags: " + err.Error()}
+	}
+
+	if flags.NArg() != 1 {
+		return UsageErr{Problem: "ban needs a player id"}
+	}
+
+	if *duration < 0 {
+		return UsageErr{Problem: "the ban duration cannot be negative"}
+	}
+
+	banReq := &auth.BanRequestBody{PlayerID: flags.Arg(0), Reason: *reason, DurationSeconds: int64(*duration / time.Second)}
+	return c.send(ctx, "POST", c.targets.Auth+"/auth/admin/ban", banReq)
+}
+
+// runBackup runs the backup command
+func (c *CLI) runBackup(ctx context.Context, args []string) error {
+
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	format := flags.String("format", "json", "the format of the backup (json or gob)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return UsageErr{Problem: "invalid backup flags: " + err.Error()}
+	}
+
+	if flags.NArg() != 0 {
+		return UsageErr{Problem: "backup takes no arguments"}
+	}
+
+	return c.send(ctx, "POST", c.targets.Data+"/data/admin/backup?format="+url.QueryEscape(*format), nil)
+}
+
+// send sends an admin request (with the given body encoded as json, if any) and writes the response to the output,
+// indenting it if it is json. A response which is not a success is returned as an error
+func (c *CLI) send(ctx context.Context, method string, reqURL string, body any) error {
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode the request body: %v", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Admin-Token", c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read the response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v %v was not successful, status code %v: %s", method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+
+	indented := &bytes.Buffer{}
+	if json.Indent(indented, respBody, "", "  ") == nil {
+		respBody = indented.Bytes()
+	}
+
+	_, err = fmt.Fprintf(c.out, "%s\n", bytes.TrimSpace(respBody))
+	return err
+}
+
+// parseInt32 parses the given argument as a number, returning a usage error if it is not one
+func parseInt32(arg string, name string) (int32, error) {
+
+	value, err := strconv.ParseInt(arg, 10, 32)
+	if err != nil {
+		return 0, UsageErr{Problem: fmt.Sprintf("invalid %v: %v", name, arg)}
+	}
+
+	return int32(value), nil
+}
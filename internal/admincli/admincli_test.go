@@ -0,0 +1,88 @@
+package admincli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Run(t *testing.T) {
+
+	// the fake services respond with the request they got, so the test can check what was sent
+	var gotToken string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotToken = r.Header.Get("Admin-Token")
+		if strings.Contains(r.URL.Path, "missing") {
+			http.Error(w, "player not found", http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+	}))
+	defer server.Close()
+
+	targets := Targets{Auth: server.URL, Config: server.URL, Data: server.URL, Profile: server.URL, Stats: server.URL}
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantOut   string
+		wantUsage bool
+		wantErr   bool
+	}{
+		{"no command", nil, "", true, true},
+		{"unknown command", []string{"promote"}, "", true, true},
+		{"player get", []string{"player", "get", "player1"}, "GET /profile/admin/player/player1\n", false, false},
+		{"player get missing id", []string{"player", "get"}, "", true, true},
+		{"player set", []string{"player", "set", "player1", "3", "20"}, `PUT /profile/admin/player {"playerID":"player1","level":3,"energy":20}` + "\n", false, false},
+		{"player set invalid level", []string{"player", "set", "player1", "three", "20"}, "", true, true},
+		{"grant energy", []string{"grant-energy", "player1", "10"}, `POST /profile/admin/grant-energy {"playerID":"player1","energy":10}` + "\n", false, false},
+		{"ftue reset", []string{"ftue", "reset", "player1"}, `POST /profile/admin/ftue/reset {"playerID":"player1"}` + "\n", false, false},
+		{"annotate", []string{"annotate", "-kind", "support-ticket", "-reference", "TICKET-42", "player1", "lost", "energy"}, `POST /profile/admin/annotations {"playerID":"player1","kind":"support-ticket","text":"lost energy","reference":"TICKET-42"}` + "\n", false, false},
+		{"annotate without a text", []string{"annotate", "player1"}, "", true, true},
+		{"unannotate", []string{"unannotate", "player1", "annotation-1"}, "DELETE /profile/admin/annotations/player1/annotation-1\n", false, false},
+		{"ban", []string{"ban", "-reason", "cheating", "-duration", "24h", "player1"}, `POST /auth/admin/ban {"playerID":"player1","reason":"cheating","durationSeconds":86400}` + "\n", false, false},
+		{"permanent ban", []string{"ban", "player1"}, `POST /auth/admin/ban {"playerID":"player1","reason":"","durationSeconds":0}` + "\n", false, false},
+		{"ban invalid duration", []string{"ban", "-duration", "soon", "player1"}, "", true, true},
+		{"unban", []string{"unban", "player1"}, "DELETE /auth/admin/ban/player1\n", false, false},
+		{"stats reset", []string{"stats", "reset", "player1"}, "POST /stats/admin/reset/player1\n", false, false},
+		{"stats audit", []string{"stats", "audit"}, "GET /stats/admin/consistency-audit\n", false, false},
+		{"stats audit of a player", []string{"stats", "audit", "player1"}, "GET /stats/admin/consistency-audit?id=player1\n", false, false},
+		{"stats other subcommand", []string{"stats", "repair", "player1"}, "", true, true},
+		{"config reload", []string{"config", "reload"}, "POST /config/admin/reload\n", false, false},
+		{"backup", []string{"backup"}, "POST /data/admin/backup?format=json\n", false, false},
+		{"gob backup", []string{"backup", "-format", "gob"}, "POST /data/admin/backup?format=gob\n", false, false},
+		{"unsuccessful response", []string{"player", "get", "missing"}, "", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			out := &bytes.Buffer{}
+			gotToken = ""
+
+			err := NewCLI(targets, "token", out).Run(context.Background(), test.args)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Run() gave incorrect error, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			if gotUsage := errors.As(err, &UsageErr{}); gotUsage != test.wantUsage {
+				t.Errorf("Run() gave incorrect error, want usage error: %v, got: %v", test.wantUsage, err)
+			}
+
+			if out.String() != test.wantOut {
+				t.Errorf("Run() gave incorrect output, want: %q, got: %q", test.wantOut, out.String())
+			}
+
+			if !test.wantUsage && gotToken != "token" {
+				t.Errorf("Run() sent incorrect admin token, want: %v, got: %v", "token", gotToken)
+			}
+		})
+	}
+}
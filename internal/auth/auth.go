@@ -3,15 +3,27 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
-	"encoding/base64"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/clock"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/validation"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,6 +34,15 @@ import (
 const sessionSweepPeriod time.Duration = 6 * time.Hour
 const sessionExpirySeconds int64 = 24 * 60 * 60 // 1 day
 
+// a player can be logged in on this many devices at once, logging in on another one signs out the oldest session
+const maxSessionsPerPlayer = 5
+
+// session ids are a selector, which the session is looked up by, and a verifier, which only the client holds (the
+// session keeps a hash of it), joined by the separator. Each is this many random bytes, hex encoded
+const sessionSelectorBytes = 8
+const sessionVerifierBytes = 16
+const sessionIDSeparator = "."
+
 // Auth Specific Errors:
 var serverNilError = fmt.Errorf("provided auth server pointer is nil")
 var missingSessionIDError = fmt.Errorf("no session id header in the request")
@@ -30,78 +51,301 @@ var invalidSessionError = fmt.Errorf("invalid session in request")
 type LoginRequestBody struct {
 	IsNewUser     bool   `json:"IsNewUser"`
 	ServerVersion string `json:"serverVersion"`
+	Device        string `json:"device"`        // optional description of the device, like its model (shown in the player's sessions)
+	TwoFactorCode string `json:"twoFactorCode"` // needed if the player has two factor authentication enabled (or a recovery code)
+	Bootstrap     bool   `json:"bootstrap"`     // optional, asks for the bootstrap bundle along with the response
+	QueueTicket   string `json:"queueTicket"`   // the login queue ticket, when the login was queued before
+	ClientVersion string `json:"clientVersion"` // the version of the client (like 1.4.2), checked against the client gate
+	Platform      string `json:"platform"`      // the platform of the client (like ios or android)
+
+	// the response to the login challenge, when the login had to pass one (see EnableLoginChallenge)
+	ChallengeResponse string `json:"challengeResponse"`
 }
 
 type LoginResponse struct {
-	PlayerID      string `json:"playerID"`
-	ServerVersion string `json:"serverVersion"`
+	PlayerID      string           `json:"playerID"`
+	ServerVersion string           `json:"serverVersion"`
+	Bootstrap     *BootstrapBundle `json:"bootstrap,omitempty"`
+}
+
+// BanRequestBody is used by the admin request to ban / suspend a player
+// (a duration of 0 seconds is a permanent ban, anything more is a suspension)
+type BanRequestBody struct {
+	PlayerID        string `json:"playerID"`
+	Reason          string `json:"reason"`
+	DurationSeconds int64  `json:"durationSeconds"`
 }
 
+// SessionData is an active session, along with the device it was created from
+// (the public id identifies the session to the player, since the session id itself is a secret)
 type SessionData struct {
 	PlayerID       string
-	SessionID      string
+	SessionID      string // the selector of the session id (see findSession)
 	LastActionTime int64
+	PublicID       string
+	Device         string
+	UserAgent      string
+	IP             string
+	LoginTime      int64
+
+	// the unused nonces issued for the session, oldest first (see EnableRequestNonces)
+	nonces []requestNonce
+
+	// the sha256 hash of the verifier of the session id
+	verifierHash []byte
 }
 
 // Server is the core auth service provider
 type Server struct {
-	credentials map[string]string
+	// the providers verifying the credentials of the login request, keyed by authorization scheme (in lower case)
+	providers map[string]AuthProvider
 
+	// the active sessions, keyed by the selector of their session id
 	sessions map[string]*SessionData
 
-	// like a reverse map to the one above it, keyed by player id, values are session ids,
-	// used to prevent multiple sessions by the same player
-	activePlayerIDs map[string]string
+	// like a reverse map to the one above it, keyed by player id, values are the session selectors of the player
+	// (oldest first), used to limit the number of sessions of the same player
+	playerSessions map[string][]string
+
+	// two factor authentication state, keyed by player id
+	twoFactor map[string]*twoFactorData
+
+	// the identity providers enabled for social login, keyed by name
+	identityProviders map[string]*IdentityProvider
+
+	// the player ids of the provider accounts (logged in with, or linked), keyed by provider and subject ("provider:subject")
+	socialLinks map[string]string
+
+	// unix times of the recent logins (within the last minute), used for the live stats
+	recentLogins []int64
 
 	authMutex sync.Mutex
 
+	// base urls of the services whose live stats are aggregated, keyed by service name
+	liveStatsURLs map[string]string
+
+	// queues the logins when too many are running at once, nil when disabled (see EnableLoginQueue)
+	loginQueue *loginQueue
+
+	// whether state changing requests need a nonce issued for their session (see EnableRequestNonces)
+	requestNonces bool
+
+	// decides which client versions can log in, nil when disabled (see EnableClientGate)
+	clientGate ClientGate
+
+	// issues and verifies the login challenges, and decides when they are needed, nil when disabled (see EnableLoginChallenge)
+	challengeVerifier ChallengeVerifier
+	challengePolicy   ChallengePolicy
+
+	// base urls of the services the bootstrap bundle of the login response is assembled from, keyed by service name
+	bootstrapURLs map[string]string
+
 	serverVersion string
 
+	dataClient data.DataClient
+
+	auditRecorder *audit.Recorder
+
+	// how often stale sessions are swept, see SetSweepPeriod
+	sweepPeriod time.Duration
+
+	// the time is read from the clock, which is an offset clock admins can move in the time offset mode
+	// (see EnableTimeOffset), except for the two factor codes and the identity provider tokens, which follow the real time
+	clock      clock.Clock
+	timeOffset *clock.Offset
+
 	logger *log.Logger
 }
 
-// NewServer returns an initialized pointer to the auth server
-func NewServer() *Server {
+// NewServer returns an initialized pointer to the auth server, the login request is verified by the given providers
+// (a later provider replaces an earlier one with the same scheme), or the built-in username / password one if there are none
+func NewServer(dc data.DataClient, providers ...AuthProvider) *Server {
+
+	logger := log.New(redact.Stdout, "auth: ", log.Ltime|log.LUTC|log.Lmsgprefix)
+
+	if len(providers) == 0 {
+		providers = []AuthProvider{NewBasicProvider()}
+	}
+
+	providerSet := map[string]AuthProvider{}
+	for _, provider := range providers {
+		providerSet[strings.ToLower(provider.Scheme())] = provider
+	}
+
 	return &Server{
-		credentials:     map[string]string{},
-		sessions:        map[string]*SessionData{},
-		activePlayerIDs: map[string]string{},
+		providers:      providerSet,
+		sessions:       map[string]*SessionData{},
+		playerSessions: map[string][]string{},
+		twoFactor:      map[string]*twoFactorData{},
+
+		identityProviders: map[string]*IdentityProvider{},
+		socialLinks:       map[string]string{},
 
 		authMutex: sync.Mutex{},
 
+		liveStatsURLs: defaultLiveStatsURLs(),
+		bootstrapURLs: defaultBootstrapURLs(),
+
 		serverVersion: strconv.FormatInt(time.Now().UTC().Unix(), 10),
 
-		logger: log.New(os.Stdout, "auth: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+		dataClient: dc,
+
+		auditRecorder: audit.NewRecorder(dc, logger),
+
+		sweepPeriod: sessionSweepPeriod,
+
+		clock: clock.System,
+
+		logger: logger,
+	}
+}
+
+// SetSweepPeriod changes how often stale sessions are swept (used by the startup config), it should be called before Run
+func (as *Server) SetSweepPeriod(period time.Duration) {
+
+	if as == nil || period <= 0 {
+		return
 	}
+
+	as.sweepPeriod = period
+}
+
+// SetClock changes the clock the server reads the time from (the system clock by default),
+// tests use a frozen clock (see clock.Frozen), it should be called before Run
+func (as *Server) SetClock(c clock.Clock) {
+
+	if as == nil || c == nil {
+		return
+	}
+
+	as.clock = c
+	as.shareClock()
+}
+
+// shareClock gives the clock of the server to the providers which read the time (to check the expiry of id tokens)
+func (as *Server) shareClock() {
+	for _, provider := range as.providers {
+		if idpProvider, ok := provider.(*IdPProvider); ok {
+			idpProvider.clock = as.clock
+		}
+	}
+}
+
+// EnableTimeOffsetFromEnv enables the time offset mode if the time offset environment variable
+// (see constants.TimeOffsetEnvVar) is set to true, the server reads the time of its clock as it is if it is not set
+func (as *Server) EnableTimeOffsetFromEnv() error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	enabled, err := clock.OffsetModeFromEnv()
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		as.EnableTimeOffset(clock.NewOffset(as.clock, as.logger))
+	}
+	return nil
+}
+
+// EnableTimeOffset makes the server read the time from the given offset clock, which admins can move ahead or back
+// with the time offset request (servers given the same offset clock move together), it should be called before Run
+func (as *Server) EnableTimeOffset(oc *clock.Offset) {
+
+	if as == nil || oc == nil {
+		return
+	}
+
+	as.logger.Println("the time offset mode is enabled, admins can move the clock of the server")
+	as.clock = oc
+	as.timeOffset = oc
+	as.shareClock()
+}
+
+// HandleTimeOffsetRequest responds with the time offset of the server (admin only), after changing it for PUT requests,
+// see clock.Offset.HandleOffsetRequest, it responds with 503 if the time offset mode is not enabled
+func (as *Server) HandleTimeOffsetRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	as.timeOffset.HandleOffsetRequest(w, r)
 }
 
 // Run runs a given auth server on the given port
 func (as *Server) Run(port string) {
+	log.Fatal(as.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the auth server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (as *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return as.serve(ctx, listener)
+}
+
+// RunWithListener runs the auth server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (as *Server) RunWithListener(listener net.Listener) error {
+	return as.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the auth server, and serves them on the given listener till the context is done
+func (as *Server) serve(ctx context.Context, listener net.Listener) error {
 
 	if as == nil {
-		fmt.Println(serverNilError)
+		return serverNilError
 	}
 
-	as.StartPeriodicSessionSweep(sessionSweepPeriod, sessionExpirySeconds)
+	as.StartPeriodicSessionSweep(ctx, as.sweepPeriod, sessionExpirySeconds)
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /auth/login", as.HandleLoginRequest)
-	mux.HandleFunc("DELETE /auth/logout", as.HandleLogoutRequest)
-
-	mux.HandleFunc("POST /auth/validation-internal", as.HandleValidateRequest)
+	mux.Handle("POST /auth/login", middleware.WithLimits(as.HandleLoginRequest, middleware.DefaultLimits))
+	mux.Handle("GET /auth/login-queue/{ticket}", middleware.WithLimits(as.HandleLoginQueueRequest, middleware.DefaultLimits))
+	mux.Handle("POST /auth/social-login", middleware.WithLimits(as.HandleSocialLoginRequest, middleware.DefaultLimits))
+	mux.Handle("POST /auth/link", middleware.WithLimits(validation.WithSession(as, as.HandleLinkAccountRequest), middleware.DefaultLimits))
+	mux.Handle("DELETE /auth/logout", middleware.WithLimits(validation.WithSession(as, as.HandleLogoutRequest), middleware.DefaultLimits))
+	mux.Handle("POST /auth/nonce", middleware.WithLimits(as.HandleNonceRequest, middleware.DefaultLimits))
+	mux.Handle("POST /auth/heartbeat", middleware.WithLimits(as.HandleHeartbeatRequest, middleware.DefaultLimits))
+	mux.Handle("GET /auth/sessions", middleware.WithLimits(validation.WithSession(as, as.HandleListSessionsRequest), middleware.DefaultLimits))
+	mux.Handle("DELETE /auth/sessions/{id}", middleware.WithLimits(validation.WithSession(as, as.HandleRevokeSessionRequest), middleware.DefaultLimits))
+	mux.Handle("POST /auth/2fa/enroll", middleware.WithLimits(validation.WithSession(as, as.HandleEnrollTwoFactorRequest), middleware.DefaultLimits))
+	mux.Handle("POST /auth/2fa/confirm", middleware.WithLimits(validation.WithSession(as, as.HandleConfirmTwoFactorRequest), middleware.DefaultLimits))
+	mux.Handle("POST /auth/2fa/disable", middleware.WithLimits(validation.WithSession(as, as.HandleDisableTwoFactorRequest), middleware.DefaultLimits))
+
+	mux.Handle("POST /auth/validation-internal", middleware.WithLimits(as.HandleValidateRequest, middleware.DefaultLimits))
+	mux.Handle("POST /auth/player-sessions-revoke-internal/{id}", middleware.WithLimits(as.HandleRevokePlayerSessionsRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /auth/admin/ban", middleware.WithLimits(as.HandleBanRequest, middleware.DefaultLimits))
+	mux.Handle("GET /auth/admin/ban/{id}", middleware.WithLimits(as.HandleGetBanRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /auth/admin/ban/{id}", middleware.WithLimits(as.HandleUnbanRequest, middleware.DefaultLimits))
+	mux.Handle("GET /auth/admin/audit", middleware.WithLimits(as.auditRecorder.HandleQueryRequest, middleware.DefaultLimits))
+	mux.Handle("GET /auth/admin/live-stats", middleware.WithLimits(as.HandleLiveStatsRequest, middleware.DefaultLimits))
+	mux.Handle("GET /auth/admin/slo", middleware.WithLimits(as.HandleSLORequest, middleware.DefaultLimits))
+	mux.Handle("GET /auth/admin/time-offset", middleware.WithLimits(as.HandleTimeOffsetRequest, middleware.DefaultLimits))
+	mux.Handle("PUT /auth/admin/time-offset", middleware.WithLimits(as.HandleTimeOffsetRequest, middleware.DefaultLimits))
 
 	as.logger.Println("the auth server is up and running...")
 
-	addr := constants.CommonHost + ":" + port
-	log.Fatal(http.ListenAndServe(addr, mux))
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("auth", middleware.WithAccessLog("auth", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
 }
 
 // HandleLoginRequest responds with a player id if successful
 func (as *Server) HandleLoginRequest(w http.ResponseWriter, r *http.Request) {
 
 	if as == nil {
-		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
@@ -110,16 +354,20 @@ func (as *Server) HandleLoginRequest(w http.ResponseWriter, r *http.Request) {
 	if authHeader == nil {
 		errMsg := "error: received login request without the required header"
 		as.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
 		return
 	}
 
-	// get the username and password from the base 64 encoded data in the auth header
-	usr, pwd, err := as.decodeAuthHeaderPayload(authHeader[0])
+	// the scheme of the auth header picks the provider which verifies the credentials
+	scheme, credentials, err := splitAuthHeader(authHeader[0])
 	if err != nil {
-		errMsg := "error: cannot decode the given credentials: %v" + err.Error()
-		as.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		as.writeLoginError(w, r, err)
+		return
+	}
+
+	provider, ok := as.providers[strings.ToLower(scheme)]
+	if !ok {
+		as.writeLoginError(w, r, UnsupportedSchemeErr{Scheme: scheme})
 		return
 	}
 
@@ -129,7 +377,12 @@ func (as *Server) HandleLoginRequest(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errMsg := "error: could not decode request body: " + err.Error()
 		as.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// outdated clients are told to update before anything else (they do not take a place in the login queue either)
+	if !as.checkClientVersion(w, r, lrb.Platform, lrb.ClientVersion) {
 		return
 	}
 
@@ -148,33 +401,35 @@ func (as *Server) HandleLoginRequest(w http.ResponseWriter, r *http.Request) {
 
 	as.logger.Printf("received auth login request, is it for a new user? %v", isNewUser)
 
-	as.authMutex.Lock()
-	defer as.authMutex.Unlock()
-
-	if isNewUser {
+	// a login creating an account may have to pass a challenge first (when abuse is detected), before it takes a place
+	// in the login queue, or reaches the provider
+	if !as.checkLoginChallenge(w, r, isNewUser, lrb.ChallengeResponse) {
+		return
+	}
 
-		// username should not exist in credentials already
-		_, exists := as.credentials[usr]
-		if exists {
-			errMsg := "error: username already exists, cannot create new user"
+	// when too many logins are running at once, the login waits in the queue, the client polls its ticket and
+	// sends the login again once admitted
+	if as.loginQueue != nil {
+		queued, admitted, err := as.loginQueue.enter(lrb.QueueTicket, as.clock.Now().UTC().Unix())
+		if err != nil {
+			errMsg := "error: could not queue the login: " + err.Error()
 			as.logger.Println(errMsg)
-			http.Error(w, errMsg, http.StatusBadRequest)
+			w.Header().Set("Retry-After", strconv.Itoa(loginQueuePollSeconds))
+			apierror.Write(w, r, http.StatusServiceUnavailable, apierror.CodeUnavailable)
 			return
 		}
-
-		// add a new entry in the credentials map
-		as.credentials[usr] = pwd
-
-	} else {
-
-		// username should exist in credentials already, and passwords should match
-		password, ok := as.credentials[usr]
-		if !ok || password != pwd {
-			errMsg := "error: invalid credentials"
-			as.logger.Println(errMsg)
-			http.Error(w, errMsg, http.StatusBadRequest)
+		if !admitted {
+			as.writeLoginQueued(w, http.StatusAccepted, queued)
 			return
 		}
+		defer func() { as.loginQueue.leave(as.clock.Now().UTC().Unix()) }()
+	}
+
+	// verify the credentials (a new user of a provider which keeps the credentials is registered)
+	usr, err := provider.Verify(r.Context(), credentials, isNewUser)
+	if err != nil {
+		as.writeLoginError(w, r, err)
+		return
 	}
 
 	// generate the player id
@@ -182,38 +437,72 @@ func (as *Server) HandleLoginRequest(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errMsg := "error: could not generate player id: " + err.Error()
 		as.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
-	// generate a new session id from current unix epoch in microseconds
-	sID := strconv.FormatInt(time.Now().UTC().UnixMicro(), 10)
+	// a player id retired by a merge logs in to the player it was merged into
+	pID, err = as.followRedirect(r.Context(), pID)
+	if err != nil {
+		errMsg := "error: could not check player redirect: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
 
-	// check that player id doesn't have an already existing session
-	otherSession, exists := as.activePlayerIDs[pID]
-	if exists {
-		// if they do, delete that session,
-		as.logger.Printf("found an already existing session for the player id %v, deleting it", pID)
-		delete(as.sessions, otherSession)
+	// banned / suspended players cannot log in
+	ban, err := as.dataClient.ReadBan(r.Context(), pID)
+	if err != nil && !errors.Is(err, data.BanNotFoundErr{PlayerID: pID}) {
+		errMsg := "error: could not check ban status: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+	if err == nil && ban.IsActive(as.clock.Now().UTC().Unix()) {
+		errMsg := fmt.Sprintf("error: player is banned, reason: %v, expiry time: %v", ban.Reason, ban.ExpiryTime)
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeBanned, "{reason}", ban.Reason, "{expiryTime}", strconv.FormatInt(ban.ExpiryTime, 10))
+		return
+	}
+
+	as.authMutex.Lock()
+
+	if !isNewUser {
+
+		// players with two factor authentication enabled also need a valid code
+		err = as.checkTwoFactorLogin(pID, lrb.TwoFactorCode, as.clock.Now())
+		if err != nil {
+			as.authMutex.Unlock()
+			errMsg := "error: two factor check failed: " + err.Error()
+			as.logger.Println(errMsg)
+			as.writeTwoFactorError(w, r, pID, err)
+			return
+		}
 	}
 
-	// add a new entry to the sessions map
-	as.sessions[sID] = &SessionData{pID, sID, time.Now().UTC().Unix()}
+	sID := as.startSession(r, pID, lrb.Device)
+	as.authMutex.Unlock()
 
-	// and tie this new session to the player id
-	as.activePlayerIDs[pID] = sID
+	as.auditRecorder.Record(r.Context(), pID, audit.ActionLogin, pID, map[string]bool{"isNewUser": isNewUser})
 
 	// provide the session id in the response header
 	w.Header().Set("Session-Id", sID)
 
+	// the bootstrap bundle is assembled with the new session, after the auth mutex is released,
+	// since the services validate the session with auth
+	response := &LoginResponse{PlayerID: pID, ServerVersion: as.serverVersion}
+	if lrb.Bootstrap {
+		response.Bootstrap = as.bootstrap(r.Context(), pID, sID)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
-	// provide the player id and server version in the response body
-	err = json.NewEncoder(w).Encode(&LoginResponse{pID, as.serverVersion})
+	// provide the player id and server version (and the bootstrap bundle if asked for) in the response body
+	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
 		errMsg := "error: could not create response: " + err.Error()
 		as.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 }
@@ -221,67 +510,300 @@ func (as *Server) HandleLoginRequest(w http.ResponseWriter, r *http.Request) {
 // HandleLogoutRequest deletes the session if successful
 func (as *Server) HandleLogoutRequest(w http.ResponseWriter, r *http.Request) {
 
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	as.logger.Printf("received auth logout request \n")
+
+	// the session validation middleware guarantees that we have an active session which matches the Session-Id header
+	// so we can just delete the required entry
+	pID, err := as.deleteSession(sessionSelector(r.Header.Get("Session-Id")))
+	if err != nil {
+		errMsg := "error: could not delete session: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	as.auditRecorder.Record(r.Context(), pID, audit.ActionLogout, pID, nil)
+
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+}
+
+// HandleBanRequest bans / suspends a player (admin only), their active sessions (if any) are deleted,
+// so any further requests made with them will fail validation, and they cannot log in till the ban expires
+func (as *Server) HandleBanRequest(w http.ResponseWriter, r *http.Request) {
+
 	if as == nil {
 		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// session based validation
-	err := as.ValidateRequest(r)
+	err := admin.ValidateRequest(r)
 	if err != nil {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
-		errMsg := "error: session validation error: " + err.Error()
+		errMsg := "error: admin validation error: " + err.Error()
 		as.logger.Println(errMsg)
 		http.Error(w, errMsg, http.StatusUnauthorized)
 		return
 	}
 
-	as.logger.Printf("received auth logout request \n")
+	// decode the request
+	banReq := &BanRequestBody{}
+	err = json.NewDecoder(r.Body).Decode(banReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
 
-	// the above validation guarantees that we have an active session which matches the Session-Id header
-	// so we can just delete the required entry
-	sIDHeader := r.Header["Session-Id"]
-	sID := sIDHeader[0]
+	if banReq.PlayerID == "" || banReq.DurationSeconds < 0 {
+		errMsg := "error: invalid player id or duration in the ban request"
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	as.logger.Printf("received ban request for player id: %v, duration: %v seconds", banReq.PlayerID, banReq.DurationSeconds)
 
-	err = as.deleteSession(sID)
+	unixNow := as.clock.Now().UTC().Unix()
+	ban := &data.BanData{
+		PlayerID:   banReq.PlayerID,
+		Reason:     banReq.Reason,
+		BanTime:    unixNow,
+		ExpiryTime: 0,
+	}
+	if banReq.DurationSeconds > 0 {
+		ban.ExpiryTime = unixNow + banReq.DurationSeconds
+	}
+
+	// store the ban state in the data service
+	err = as.dataClient.WriteBan(r.Context(), ban)
 	if err != nil {
-		errMsg := "error: could not delete session: " + err.Error()
+		errMsg := "DB write error: " + err.Error()
 		as.logger.Println(errMsg)
 		http.Error(w, errMsg, http.StatusInternalServerError)
 		return
 	}
 
-	_, err = fmt.Fprint(w, "success")
+	// invalidate the existing session of the player (if any)
+	as.deletePlayerSessions(banReq.PlayerID)
+
+	as.auditRecorder.Record(r.Context(), audit.ActorAdmin, audit.ActionBan, banReq.PlayerID, ban)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(ban)
 	if err != nil {
-		errMsg := "error: could not write response: " + err.Error()
+		errMsg := "error: could not create response: " + err.Error()
 		as.logger.Println(errMsg)
 		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// HandleGetBanRequest responds with the ban state of the requested player (admin only)
+func (as *Server) HandleGetBanRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
 		return
 	}
+
+	id := r.PathValue("id")
+
+	ban, err := as.dataClient.ReadBan(r.Context(), id)
+	if err != nil {
+		errMsg := "get ban error: " + err.Error()
+		as.logger.Println(errMsg)
+		if errors.Is(err, data.BanNotFoundErr{PlayerID: id}) {
+			http.Error(w, errMsg, http.StatusNotFound)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(ban)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
 }
 
-// decodeAuthHeaderPayload will take the authorization header and return a username and password if successful
-// reference: https://en.wikipedia.org/wiki/Basic_access_authentication
-func (as *Server) decodeAuthHeaderPayload(encodedCred string) (string, string, error) {
+// HandleUnbanRequest lifts the ban / suspension of the requested player (admin only)
+func (as *Server) HandleUnbanRequest(w http.ResponseWriter, r *http.Request) {
 
 	if as == nil {
-		return "", "", serverNilError
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
 	}
 
-	// trim away the first 6 elements which are the prefix 'Basic '
-	encodedCred = encodedCred[6:]
+	id := r.PathValue("id")
+	as.logger.Printf("received unban request for player id: %v", id)
 
-	// decode the base64 data
-	decodedCred, err := base64.StdEncoding.DecodeString(encodedCred)
+	err = as.dataClient.DeleteBan(r.Context(), id)
+	if err != nil {
+		errMsg := "delete ban error: " + err.Error()
+		as.logger.Println(errMsg)
+		if errors.Is(err, data.BanNotFoundErr{PlayerID: id}) {
+			http.Error(w, errMsg, http.StatusNotFound)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	as.auditRecorder.Record(r.Context(), audit.ActorAdmin, audit.ActionUnban, id, nil)
 
+	_, err = fmt.Fprint(w, "success")
 	if err != nil {
-		return "", "", fmt.Errorf("cannot decode the given credentials: %v \n", err.Error())
+		errMsg := "error: could not write response: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// startSession creates a new session for the player, recording the device of the login request it came from,
+// and returns its id (the auth mutex should be held by the caller)
+func (as *Server) startSession(r *http.Request, pID string, device string) string {
+
+	selector := as.newSessionSelector()
+	verifier := randomHex(sessionVerifierBytes)
+	verifierHash := sha256.Sum256([]byte(verifier))
+	unixNow := as.clock.Now().UTC().Unix()
+
+	// add a new entry to the sessions map (keyed by the selector), recording the device it was created from
+	as.sessions[selector] = &SessionData{
+		PlayerID:       pID,
+		SessionID:      selector,
+		LastActionTime: unixNow,
+		PublicID:       newPublicSessionID(),
+		Device:         truncateDeviceInfo(device),
+		UserAgent:      truncateDeviceInfo(r.UserAgent()),
+		IP:             clientIP(r),
+		LoginTime:      unixNow,
+		verifierHash:   verifierHash[:],
+	}
+
+	// and tie this new session to the player id, signing out the oldest session if the player has too many
+	as.playerSessions[pID] = append(as.playerSessions[pID], selector)
+	if len(as.playerSessions[pID]) > maxSessionsPerPlayer {
+		as.logger.Printf("player id %v has more than %v sessions, deleting the oldest one", pID, maxSessionsPerPlayer)
+		delete(as.sessions, as.playerSessions[pID][0])
+		as.playerSessions[pID] = as.playerSessions[pID][1:]
 	}
 
-	// separate the username and password
-	decodedStrings := strings.Split(string(decodedCred), ":")
+	as.recordLogin(as.clock.Now().UTC().Unix())
+
+	return selector + sessionIDSeparator + verifier
+}
+
+// newSessionSelector returns a new random session selector, which is not in use already
+// (the auth mutex should be held by the caller)
+func (as *Server) newSessionSelector() string {
+
+	for {
+		selector := randomHex(sessionSelectorBytes)
+		if _, exists := as.sessions[selector]; !exists {
+			return selector
+		}
+	}
+}
+
+// randomHex returns the given number of random bytes, hex encoded
+func randomHex(byteCount int) string {
+	randomBytes := make([]byte, byteCount)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+	return hex.EncodeToString(randomBytes)
+}
+
+// findSession returns the session of the given session id, which is looked up by its selector. The hash of its
+// verifier is then compared with the one the session keeps in constant time, so the time the check takes does not
+// tell how much of a guessed verifier was right (the caller has to hold the auth mutex)
+func (as *Server) findSession(sessionID string) (*SessionData, bool) {
+
+	selector, verifier, _ := strings.Cut(sessionID, sessionIDSeparator)
+	session, ok := as.sessions[selector]
+	if !ok {
+		return nil, false
+	}
+
+	verifierHash := sha256.Sum256([]byte(verifier))
+	if subtle.ConstantTimeCompare(verifierHash[:], session.verifierHash) != 1 {
+		return nil, false
+	}
+	return session, true
+}
+
+// sessionSelector returns the selector of the given session id
+func sessionSelector(sessionID string) string {
+	selector, _, _ := strings.Cut(sessionID, sessionIDSeparator)
+	return selector
+}
+
+// writeLoginError writes the response for an error verifying the credentials of the login request
+func (as *Server) writeLoginError(w http.ResponseWriter, r *http.Request, err error) {
+
+	errMsg := "error: " + err.Error()
+	as.logger.Println(errMsg)
+
+	if err == usernameTakenError {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeUsernameTaken)
+		return
+	}
+	if err == invalidCredentialsError {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidCredentials)
+		return
+	}
+
+	switch err.(type) {
+	case MalformedCredentialsErr, UnsupportedSchemeErr:
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+	default:
+		apierror.Write(w, r, http.StatusBadGateway, apierror.CodeUnavailable)
+	}
+}
+
+// followRedirect returns the id of the player the given player id was merged into, if it was retired by a merge
+// (see profile.Server.MergePlayers), or else the given player id
+func (as *Server) followRedirect(ctx context.Context, pID string) (string, error) {
 
-	return decodedStrings[0], decodedStrings[1], nil
+	redirect, err := as.dataClient.ReadRedirect(ctx, pID)
+	if err != nil {
+		if errors.Is(err, data.RedirectNotFoundErr{PlayerID: pID}) {
+			return pID, nil
+		}
+		return "", err
+	}
+
+	as.logger.Printf("player id %v was merged into player id %v, logging in to it", pID, redirect.MergedInto)
+	return redirect.MergedInto, nil
 }
 
 // generatePlayerID generates a sha 256 hash from the username,
@@ -302,16 +824,23 @@ func (as *Server) generatePlayerID(input string) (string, error) {
 }
 
 // ValidateRequest checks for the session id header in other requests, and the validity of the session if present
-func (as *Server) ValidateRequest(req *http.Request) error {
+// (and, if request nonces are enabled, that a state changing request carries an unused nonce of the session),
+// returning the player id of the session
+func (as *Server) ValidateRequest(req *http.Request) (string, error) {
 
 	if as == nil {
-		return serverNilError
+		return "", serverNilError
 	}
 
-	sessionIdHeader := req.Header["Session-Id"]
+	return as.validateSession(req.Header["Session-Id"], req.Method, req.Header.Get(constants.RequestNonceHeader))
+}
+
+// validateSession checks the given session id header, for a request with the given method and nonce,
+// and returns the player id of the session
+func (as *Server) validateSession(sessionIdHeader []string, method string, nonce string) (string, error) {
 
 	if sessionIdHeader == nil {
-		return missingSessionIDError
+		return "", missingSessionIDError
 	}
 
 	// get the session id from the header
@@ -321,23 +850,31 @@ func (as *Server) ValidateRequest(req *http.Request) error {
 	defer as.authMutex.Unlock()
 
 	// check for an active session
-	activeSession, ok := as.sessions[sID]
-	if !ok || sID != activeSession.SessionID {
-		return invalidSessionError
+	activeSession, ok := as.findSession(sID)
+	if !ok {
+		return "", invalidSessionError
 	}
 
-	// update the last action time for that session
-	as.sessions[sID] = &SessionData{
-		activeSession.PlayerID,
-		activeSession.SessionID,
-		time.Now().UTC().Unix(),
+	unixNow := as.clock.Now().UTC().Unix()
+
+	// a state changing request uses up its nonce, so the same request is rejected if it is sent again
+	if as.needsNonce(method) {
+		err := activeSession.useNonce(nonce, unixNow)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	return nil
+	// update the last action time for that session
+	activeSession.LastActionTime = unixNow
+
+	return activeSession.PlayerID, nil
 }
 
 // HandleValidateRequest is a wrapper around the above method, used when the server is fielding
-// internal requests for session validation from other servers
+// internal requests for session validation from other servers (which pass on the method and the nonce of the
+// request being validated, a validation request without the method is checked like a state changing one),
+// the response is the player id of the session
 func (as *Server) HandleValidateRequest(w http.ResponseWriter, r *http.Request) {
 
 	if as == nil {
@@ -345,7 +882,12 @@ func (as *Server) HandleValidateRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := as.ValidateRequest(r)
+	method := r.Header.Get(constants.RequestMethodHeader)
+	if method == "" {
+		method = r.Method
+	}
+
+	playerID, err := as.validateSession(r.Header["Session-Id"], method, r.Header.Get(constants.RequestNonceHeader))
 	if err != nil {
 		errMsg := "error: session validation failed: " + err.Error()
 		as.logger.Println(errMsg)
@@ -353,8 +895,8 @@ func (as *Server) HandleValidateRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// provide the success response, if the status is 200, validation will be considered to be successful
-	_, err = fmt.Fprint(w, "success")
+	// provide the player id of the session, if the status is 200, validation will be considered to be successful
+	_, err = fmt.Fprint(w, playerID)
 	if err != nil {
 		errMsg := "error: could not write response: " + err.Error()
 		as.logger.Println(errMsg)
@@ -363,46 +905,85 @@ func (as *Server) HandleValidateRequest(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-// deleteSession deletes the session from the session map, and the player ID entry from the active player ID map
-func (as *Server) deleteSession(sessionID string) error {
+// deleteSession deletes the session with the given selector from the session map, and from the sessions of its player,
+// returning the player ID the session belonged to
+func (as *Server) deleteSession(selector string) (string, error) {
 
 	as.authMutex.Lock()
 	defer as.authMutex.Unlock()
 
-	session, ok := as.sessions[sessionID]
+	session, ok := as.sessions[selector]
 	if !ok {
-		return invalidSessionError
+		return "", invalidSessionError
 	}
 
-	delete(as.activePlayerIDs, session.PlayerID) // delete the association between the player id and the session
-	delete(as.sessions, sessionID)               // delete the session
+	// delete the association between the player id and the session
+	remaining := slices.DeleteFunc(as.playerSessions[session.PlayerID], func(sID string) bool {
+		return sID == selector
+	})
+	if len(remaining) == 0 {
+		delete(as.playerSessions, session.PlayerID)
+	} else {
+		as.playerSessions[session.PlayerID] = remaining
+	}
 
-	return nil
+	delete(as.sessions, selector) // delete the session
+
+	return session.PlayerID, nil
+}
+
+// deletePlayerSessions deletes all the active sessions of the given player id (if any)
+func (as *Server) deletePlayerSessions(playerID string) {
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	sIDs, ok := as.playerSessions[playerID]
+	if !ok {
+		return
+	}
+
+	as.logger.Printf("deleting the active sessions for player id: %v", playerID)
+	delete(as.playerSessions, playerID)
+	for _, sID := range sIDs {
+		delete(as.sessions, sID)
+	}
 }
 
-// deleteAllStaleSessions deletes stale sessions based on their last action time
+// deleteAllStaleSessions deletes stale sessions based on their last action time, recording each one in the audit log
+// as expired by inactivity (so they can be told apart from the sessions which were logged out)
 func (as *Server) deleteAllStaleSessions(timeNow time.Time, expirySeconds int64) error {
 
 	unixNow := timeNow.UTC().Unix()
 
-	for sID, session := range as.sessions {
+	as.authMutex.Lock()
+	stale := []SessionData{}
+	for _, session := range as.sessions {
+		if (unixNow - session.LastActionTime) > expirySeconds {
+			stale = append(stale, *session)
+		}
+	}
+	as.authMutex.Unlock()
 
-		stale := (unixNow - session.LastActionTime) > expirySeconds
+	for _, session := range stale {
 
-		if stale {
-			as.logger.Printf("found an old session for player id: %v, deleting it", session.PlayerID)
-			err := as.deleteSession(sID)
-			if err != nil {
-				return err
-			}
+		as.logger.Printf("found an old session for player id: %v, deleting it", session.PlayerID)
+		_, err := as.deleteSession(session.SessionID)
+		if err != nil {
+			continue // logged out (or signed out) since it was found
 		}
+
+		as.auditRecorder.Record(context.Background(), audit.ActorSystem, audit.ActionSessionExpire, session.PlayerID, map[string]any{
+			"idleSeconds": unixNow - session.LastActionTime, "sessionSeconds": unixNow - session.LoginTime, "device": session.Device,
+		})
 	}
 
 	return nil
 }
 
-// StartPeriodicSessionSweep creates a ticker that will periodically check for stale sessions and delete them
-func (as *Server) StartPeriodicSessionSweep(sweepPeriod time.Duration, sessionExpirySeconds int64) {
+// StartPeriodicSessionSweep creates a ticker that will periodically check for stale sessions (by the clock of the server)
+// and delete them, till the given context is done
+func (as *Server) StartPeriodicSessionSweep(ctx context.Context, sweepPeriod time.Duration, sessionExpirySeconds int64) {
 
 	if as == nil {
 		return
@@ -411,14 +992,19 @@ func (as *Server) StartPeriodicSessionSweep(sweepPeriod time.Duration, sessionEx
 	ticker := time.NewTicker(sweepPeriod)
 
 	go func() {
+		defer ticker.Stop()
 		for {
-			timeNow := <-ticker.C
-			as.logger.Println("periodic session sweep tick...")
-			err := as.deleteAllStaleSessions(timeNow, sessionExpirySeconds)
-			if err != nil {
-				errMsg := "error in the periodic session sweep, abort"
-				as.logger.Println(errMsg)
+			select {
+			case <-ctx.Done():
 				return
+			case <-ticker.C:
+				as.logger.Println("periodic session sweep tick...")
+				err := as.deleteAllStaleSessions(as.clock.Now(), sessionExpirySeconds)
+				if err != nil {
+					errMsg := "error in the periodic session sweep, abort"
+					as.logger.Println(errMsg)
+					return
+				}
 			}
 		}
 	}()
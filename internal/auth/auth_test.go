@@ -2,34 +2,67 @@ package auth
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/clock"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/validation"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
+// withSession is testsetup.WithSession with the server as its own validator (testsetup imports this package,
+// so its tests cannot use it)
+func withSession(server *Server, handler http.HandlerFunc) http.HandlerFunc {
+
+	if server == nil {
+		return handler
+	}
+	return validation.WithSession(server, handler)
+}
+
+// addTestSession adds the given session (its session id being the selector) with the given verifier to the server,
+// and returns the session id a client of the session sends
+func addTestSession(server *Server, session *SessionData, verifier string) string {
+
+	verifierHash := sha256.Sum256([]byte(verifier))
+	session.verifierHash = verifierHash[:]
+	server.sessions[session.SessionID] = session
+	return session.SessionID + sessionIDSeparator + verifier
+}
+
 func TestNewAuthServer(t *testing.T) {
-	authServer := NewServer()
+	authServer := NewServer(data.NewServer())
 
 	if authServer == nil {
 		t.Fatal("new auth server should not return a nil server pointer")
 	}
 
-	if authServer.credentials == nil {
-		t.Fatal("new auth server should not contain a nil credentials pointer")
+	if _, ok := authServer.providers["basic"].(*BasicProvider); !ok {
+		t.Fatal("new auth server should verify the basic scheme with the built-in provider by default")
 	}
 
 	if authServer.sessions == nil {
 		t.Fatal("new auth server should not contain a nil credentials pointer")
 	}
 
-	if authServer.activePlayerIDs == nil {
-		t.Fatal("new auth server should not contain a nil active player IDs pointer")
+	if authServer.playerSessions == nil {
+		t.Fatal("new auth server should not contain a nil player sessions pointer")
 	}
 
 	if authServer.serverVersion != strconv.FormatInt(time.Now().UTC().Unix(), 10) {
@@ -39,14 +72,22 @@ func TestNewAuthServer(t *testing.T) {
 
 func TestServer_HandleLoginRequest(t *testing.T) {
 
-	as := NewServer()
+	basic := NewBasicProvider()
+	as := NewServer(data.NewServer(), basic)
 
-	as.credentials["test2"] = "pass2"
-	as.credentials["test3"] = "pass3"
+	basic.credentials["test2"] = "pass2"
+	basic.credentials["test3"] = "pass3"
+	basic.credentials["test4"] = "pass4"
+
+	// test4 hashes to the player id a4e624d6
+	err := as.dataClient.WriteBan(context.Background(), &data.BanData{PlayerID: "a4e624d6", Reason: "cheating", BanTime: 1, ExpiryTime: 0})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
 
 	unixMicroString := strconv.FormatInt(time.Now().UTC().Unix(), 10)
-	as.sessions[unixMicroString] = &SessionData{"fd61a03a", unixMicroString, time.Now().UTC().Unix() - 60}
-	as.activePlayerIDs["fd61a03a"] = unixMicroString
+	as.sessions[unixMicroString] = &SessionData{PlayerID: "fd61a03a", SessionID: unixMicroString, LastActionTime: time.Now().UTC().Unix() - 60}
+	as.playerSessions["fd61a03a"] = []string{unixMicroString}
 
 	tests := []struct {
 		name             string
@@ -61,9 +102,10 @@ func TestServer_HandleLoginRequest(t *testing.T) {
 	}{
 		{"nil server", nil, true, "", "", nil, http.StatusInternalServerError, "", nil},
 		{"no auth header", as, false, "", "", &LoginRequestBody{IsNewUser: true, ServerVersion: "0"}, http.StatusBadRequest, "", nil},
-		{"blank credentials", as, true, "", "", &LoginRequestBody{IsNewUser: true, ServerVersion: "0"}, http.StatusInternalServerError, "", nil},
+		{"blank credentials", as, true, "", "", &LoginRequestBody{IsNewUser: true, ServerVersion: "0"}, http.StatusBadRequest, "", nil},
 		{"invalid credentials", as, true, "test0", "pass0", &LoginRequestBody{IsNewUser: false, ServerVersion: as.serverVersion}, http.StatusBadRequest, "", nil},
 		{"used credentials", as, true, "test2", "pass2", &LoginRequestBody{IsNewUser: true, ServerVersion: as.serverVersion}, http.StatusBadRequest, "", nil},
+		{"banned user", as, true, "test4", "pass4", &LoginRequestBody{IsNewUser: false, ServerVersion: as.serverVersion}, http.StatusForbidden, "", nil},
 
 		{"new user", as, true, "test1", "pass1", &LoginRequestBody{IsNewUser: true, ServerVersion: "0"}, http.StatusOK, "application/json", &LoginResponse{
 			PlayerID:      "1b4f0e98",
@@ -152,7 +194,7 @@ func TestServer_HandleLogoutRequest(t *testing.T) {
 			respRec := httptest.NewRecorder()
 
 			authServer := test.server
-			authServer.HandleLogoutRequest(respRec, newReq)
+			withSession(authServer, authServer.HandleLogoutRequest)(respRec, newReq)
 
 			gotStatus := respRec.Result().StatusCode
 
@@ -173,12 +215,12 @@ func TestServer_HandleLogoutRequest(t *testing.T) {
 
 func TestServer_ValidateRequest(t *testing.T) {
 
-	as := NewServer()
-	as.sessions["testsessionid3"] = &SessionData{
-		PlayerID:       "",
-		SessionID:      "testsessionid3",
+	as := NewServer(data.NewServer())
+	sID := addTestSession(as, &SessionData{
+		PlayerID:       "testplayer3",
+		SessionID:      "testselector3",
 		LastActionTime: 0,
-	}
+	}, "testverifier3")
 
 	newAuthReq := httptest.NewRequest(http.MethodPost, "/test/", nil)
 
@@ -186,42 +228,98 @@ func TestServer_ValidateRequest(t *testing.T) {
 	newAuthReq2.Header.Set("Session-Id", "test")
 
 	newAuthReq3 := httptest.NewRequest(http.MethodPost, "/test/", nil)
-	newAuthReq3.Header.Set("Session-Id", "testsessionid3")
+	newAuthReq3.Header.Set("Session-Id", sID)
+
+	// the selector alone, or with the wrong verifier, is not a session id
+	newAuthReq4 := httptest.NewRequest(http.MethodPost, "/test/", nil)
+	newAuthReq4.Header.Set("Session-Id", "testselector3")
+
+	newAuthReq5 := httptest.NewRequest(http.MethodPost, "/test/", nil)
+	newAuthReq5.Header.Set("Session-Id", "testselector3"+sessionIDSeparator+"testverifier4")
 
 	tests := []struct {
 		name        string
 		server      *Server
 		httpRequest *http.Request
+		expPlayerID string
 		expError    error
 	}{
-		{"nil server", nil, nil, serverNilError},
-		{"blank session id", as, newAuthReq, missingSessionIDError},
-		{"invalid session", as, newAuthReq2, invalidSessionError},
-		{"valid session", as, newAuthReq3, nil},
+		{"nil server", nil, nil, "", serverNilError},
+		{"blank session id", as, newAuthReq, "", missingSessionIDError},
+		{"invalid session", as, newAuthReq2, "", invalidSessionError},
+		{"valid session", as, newAuthReq3, "testplayer3", nil},
+		{"missing verifier", as, newAuthReq4, "", invalidSessionError},
+		{"guessed verifier", as, newAuthReq5, "", invalidSessionError},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			gotErr := test.server.ValidateRequest(test.httpRequest)
+			gotPlayerID, gotErr := test.server.ValidateRequest(test.httpRequest)
 			if gotErr != nil {
 				if errors.Is(gotErr, test.expError) {
 					fmt.Println(gotErr)
 				} else {
 					t.Fatalf("ValidateRequest() failed with an unexpected error, %v", gotErr)
 				}
+			} else if gotPlayerID != test.expPlayerID {
+				t.Fatalf("ValidateRequest() gave an incorrect player id, want: %v, got: %v", test.expPlayerID, gotPlayerID)
 			}
 		})
 	}
 }
 
+func TestServer_NewSessionSelector(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+
+	seen := map[string]bool{}
+	for range 100 {
+		selector := as.newSessionSelector()
+		decoded, err := hex.DecodeString(selector)
+		if err != nil || len(decoded) != sessionSelectorBytes {
+			t.Fatalf("newSessionSelector() gave an incorrect selector: %v", selector)
+		}
+		if seen[selector] {
+			t.Fatalf("newSessionSelector() gave a repeated selector: %v", selector)
+		}
+		seen[selector] = true
+	}
+}
+
+func TestServer_startSession(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+
+	as.authMutex.Lock()
+	sID := as.startSession(httptest.NewRequest(http.MethodPost, "/auth/login", nil), "player1", "phone")
+	as.authMutex.Unlock()
+
+	// the session is kept by its selector, with only the hash of its verifier
+	selector, verifier, _ := strings.Cut(sID, sessionIDSeparator)
+	decodedVerifier, err := hex.DecodeString(verifier)
+	if err != nil || len(decodedVerifier) != sessionVerifierBytes {
+		t.Fatalf("startSession() gave an incorrect session id: %v", sID)
+	}
+
+	session, ok := as.sessions[selector]
+	verifierHash := sha256.Sum256([]byte(verifier))
+	if !ok || session.SessionID != selector || !bytes.Equal(session.verifierHash, verifierHash[:]) {
+		t.Fatalf("startSession() gave incorrect results, want a session with the hash of the verifier, got: %+v", session)
+	}
+
+	if found, ok := as.findSession(sID); !ok || found.PlayerID != "player1" {
+		t.Errorf("findSession() should find the new session, got: %v, %v", found, ok)
+	}
+}
+
 func TestServer_ValidateRequestHandler(t *testing.T) {
-	as := NewServer()
-	as.sessions["testsessionid3"] = &SessionData{
+	as := NewServer(data.NewServer())
+	sID := addTestSession(as, &SessionData{
 		PlayerID:       "",
-		SessionID:      "testsessionid3",
+		SessionID:      "testselector3",
 		LastActionTime: 0,
-	}
+	}, "testverifier3")
 
 	newAuthReq := httptest.NewRequest(http.MethodPost, "/auth/validate-internal/", nil)
 
@@ -229,7 +327,7 @@ func TestServer_ValidateRequestHandler(t *testing.T) {
 	newAuthReq2.Header.Set("Session-Id", "test")
 
 	newAuthReq3 := httptest.NewRequest(http.MethodPost, "/auth/validate-internal/", nil)
-	newAuthReq3.Header.Set("Session-Id", "testsessionid3")
+	newAuthReq3.Header.Set("Session-Id", sID)
 
 	tests := []struct {
 		name            string
@@ -271,44 +369,174 @@ func TestServer_ValidateRequestHandler(t *testing.T) {
 
 func TestServer_StartPeriodicSessionSweep(t *testing.T) {
 
-	as1 := NewServer()
+	as1 := NewServer(data.NewServer())
 	as1.sessions["sessionID1"] = &SessionData{
 		PlayerID:       "playerID1",
 		SessionID:      "sessionID1",
 		LastActionTime: time.Now().UTC().Unix() - 10,
 	}
-	as1.activePlayerIDs["playerID1"] = "sessionID1"
+	as1.playerSessions["playerID1"] = []string{"sessionID1"}
 
-	as2 := NewServer()
+	as2 := NewServer(data.NewServer())
 	as2.sessions["sessionID2"] = &SessionData{
 		PlayerID:       "playerID2",
 		SessionID:      "sessionID2",
 		LastActionTime: time.Now().UTC().Unix() - 10,
 	}
-	as2.activePlayerIDs["playerID2"] = "sessionID2"
+	as2.playerSessions["playerID2"] = []string{"sessionID2"}
+
+	as3 := NewServer(data.NewServer())
+	as3.sessions["sessionID3"] = &SessionData{
+		PlayerID:       "playerID3",
+		SessionID:      "sessionID3",
+		LastActionTime: time.Now().UTC().Unix() - 10,
+	}
+	as3.playerSessions["playerID3"] = []string{"sessionID3"}
+
+	// the session is only stale by the clock of the server, which is a minute ahead
+	as4 := NewServer(data.NewServer())
+	as4.SetClock(clock.NewFrozen(time.Now().Add(time.Minute)))
+	as4.sessions["sessionID4"] = &SessionData{
+		PlayerID:       "playerID4",
+		SessionID:      "sessionID4",
+		LastActionTime: time.Now().UTC().Unix() - 10,
+	}
+	as4.playerSessions["playerID4"] = []string{"sessionID4"}
 
 	tests := []struct {
-		name                string
-		server              *Server
-		period              time.Duration
-		expirySeconds       int64
-		wantSessions        map[string]*SessionData
-		wantActivePlayerIDs map[string]string
+		name               string
+		server             *Server
+		period             time.Duration
+		expirySeconds      int64
+		stopped            bool
+		wantSessions       map[string]*SessionData
+		wantPlayerSessions map[string][]string
 	}{
-		{"stale session", as1, 25 * time.Millisecond, 5, map[string]*SessionData{}, map[string]string{}},
-		{"active session", as2, 25 * time.Millisecond, 20, map[string]*SessionData{"sessionID2": {"playerID2", "sessionID2", time.Now().UTC().Unix() - 10}}, map[string]string{"playerID2": "sessionID2"}},
+		{"stale session", as1, 25 * time.Millisecond, 5, false, map[string]*SessionData{}, map[string][]string{}},
+		{"active session", as2, 25 * time.Millisecond, 20, false, map[string]*SessionData{"sessionID2": {PlayerID: "playerID2", SessionID: "sessionID2", LastActionTime: time.Now().UTC().Unix() - 10}}, map[string][]string{"playerID2": {"sessionID2"}}},
+		{"stale by the clock of the server", as4, 25 * time.Millisecond, 20, false, map[string]*SessionData{}, map[string][]string{}},
+		{"stopped sweep", as3, 25 * time.Millisecond, 5, true, map[string]*SessionData{"sessionID3": {PlayerID: "playerID3", SessionID: "sessionID3", LastActionTime: time.Now().UTC().Unix() - 10}}, map[string][]string{"playerID3": {"sessionID3"}}},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			test.server.StartPeriodicSessionSweep(test.period, test.expirySeconds)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.stopped {
+				cancel()
+			}
+
+			test.server.StartPeriodicSessionSweep(ctx, test.period, test.expirySeconds)
 			time.Sleep(test.period + 10*time.Millisecond)
 
 			if !reflect.DeepEqual(test.server.sessions, test.wantSessions) {
 				t.Errorf("StartPeriodicSessionSweep() gave incorrect results, want: %v, got: %v", test.wantSessions, test.server.sessions)
 			}
 
-			if !reflect.DeepEqual(test.server.activePlayerIDs, test.wantActivePlayerIDs) {
-				t.Errorf("StartPeriodicSessionSweep() gave incorrect results, want: %v, got: %v", test.wantActivePlayerIDs, test.server.activePlayerIDs)
+			if !reflect.DeepEqual(test.server.playerSessions, test.wantPlayerSessions) {
+				t.Errorf("StartPeriodicSessionSweep() gave incorrect results, want: %v, got: %v", test.wantPlayerSessions, test.server.playerSessions)
+			}
+		})
+	}
+}
+
+func TestServer_deleteAllStaleSessions(t *testing.T) {
+
+	ds := data.NewServer()
+	as := NewServer(ds)
+
+	unixNow := time.Now().UTC().Unix()
+	as.sessions["sessionID1"] = &SessionData{PlayerID: "playerID1", SessionID: "sessionID1", LastActionTime: unixNow - 100, LoginTime: unixNow - 300, Device: "phone"}
+	as.sessions["sessionID2"] = &SessionData{PlayerID: "playerID2", SessionID: "sessionID2", LastActionTime: unixNow - 10, LoginTime: unixNow - 300}
+	as.playerSessions["playerID1"] = []string{"sessionID1"}
+	as.playerSessions["playerID2"] = []string{"sessionID2"}
+
+	err := as.deleteAllStaleSessions(time.Unix(unixNow, 0), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := as.sessions["sessionID1"]; ok {
+		t.Error("deleteAllStaleSessions() should delete the idle session")
+	}
+	if _, ok := as.sessions["sessionID2"]; !ok {
+		t.Error("deleteAllStaleSessions() should keep the active session")
+	}
+
+	// the expired session is recorded as expired by inactivity, not as a logout
+	page, err := ds.ReadAuditEntries(context.Background(), &data.AuditQuery{PlayerID: "playerID1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Action != audit.ActionSessionExpire || page.Entries[0].Actor != audit.ActorSystem {
+		t.Fatalf("deleteAllStaleSessions() gave incorrect audit entries, want one %v entry, got: %v", audit.ActionSessionExpire, page.Entries)
+	}
+
+	gotPayload := map[string]any{}
+	err = json.Unmarshal(page.Entries[0].Payload, &gotPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPayload := map[string]any{"idleSeconds": 100.0, "sessionSeconds": 300.0, "device": "phone"}
+	if !reflect.DeepEqual(gotPayload, wantPayload) {
+		t.Errorf("deleteAllStaleSessions() gave incorrect audit payload, want: %v, got: %v", wantPayload, gotPayload)
+	}
+}
+
+func TestServer_HandleHeartbeatRequest(t *testing.T) {
+
+	as, sID, err := setupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	// the clock is frozen, and the session has been idle for a while before the first heartbeat
+	frozenClock := clock.NewFrozen(time.Unix(as.sessions[sessionSelector(sID)].LastActionTime, 0))
+	as.SetClock(frozenClock)
+	frozenClock.Advance(30 * time.Second)
+
+	tests := []struct {
+		name       string
+		server     *Server
+		sessionID  string
+		wantStatus int
+		wantIdle   int64
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, 0},
+		{"blank session id", as, "", http.StatusUnauthorized, 0},
+		{"invalid session id", as, "testSessionID", http.StatusUnauthorized, 0},
+		{"idle session", as, sID, http.StatusOK, 30},
+		{"active session", as, sID, http.StatusOK, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/auth/heartbeat", nil)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			authServer := test.server
+			authServer.HandleHeartbeatRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				heartbeat := &HeartbeatResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(heartbeat)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if heartbeat.IdleSeconds != test.wantIdle {
+					t.Errorf("handler gave incorrect results, want idle seconds: %v, got: %v", test.wantIdle, heartbeat.IdleSeconds)
+				}
+
+				if heartbeat.ExpiryTime != frozenClock.Now().UTC().Unix()+sessionExpirySeconds {
+					t.Errorf("handler gave incorrect results, got expiry time: %v", heartbeat.ExpiryTime)
+				}
 			}
 		})
 	}
@@ -326,9 +554,724 @@ func setupTestAuth() (*Server, string, error) {
 	newAuthReq.SetBasicAuth("user1", "pass1")
 	authRespRec := httptest.NewRecorder()
 
-	as := NewServer()
+	as := NewServer(data.NewServer())
 	as.HandleLoginRequest(authRespRec, newAuthReq)
 	sID := authRespRec.Header().Get("Session-Id")
 
 	return as, sID, nil
 }
+
+func TestServer_HandleBanRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	as, sID, err := setupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+	pID := as.sessions[sessionSelector(sID)].PlayerID
+
+	tests := []struct {
+		name        string
+		server      *Server
+		adminToken  string
+		requestBody *BanRequestBody
+		wantStatus  int
+	}{
+		{"nil server", nil, "", nil, http.StatusInternalServerError},
+		{"invalid admin token", as, "testToken", &BanRequestBody{PlayerID: pID, Reason: "cheating", DurationSeconds: 0}, http.StatusUnauthorized},
+		{"blank player id", as, "adminToken", &BanRequestBody{PlayerID: "", Reason: "cheating", DurationSeconds: 0}, http.StatusBadRequest},
+		{"negative duration", as, "adminToken", &BanRequestBody{PlayerID: pID, Reason: "cheating", DurationSeconds: -1}, http.StatusBadRequest},
+		{"success", as, "adminToken", &BanRequestBody{PlayerID: pID, Reason: "cheating", DurationSeconds: 60}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err = json.NewEncoder(buf).Encode(test.requestBody)
+			if err != nil {
+				t.Fatal("could not encode request body")
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/auth/admin/ban", buf)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			authServer := test.server
+			authServer.HandleBanRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &data.BanData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotResponseBody.ExpiryTime != gotResponseBody.BanTime+test.requestBody.DurationSeconds {
+					t.Errorf("handler gave incorrect expiry time, want: %v, got: %v", gotResponseBody.BanTime+test.requestBody.DurationSeconds, gotResponseBody.ExpiryTime)
+				}
+
+				// the existing session should have been invalidated
+				validateReq := httptest.NewRequest(http.MethodPost, "/test/", nil)
+				validateReq.Header.Set("Session-Id", sID)
+				if _, err := as.ValidateRequest(validateReq); !errors.Is(err, invalidSessionError) {
+					t.Error("the session of a banned player should not pass validation")
+				}
+
+				// and the player should not be able to log in again
+				loginBuf := &bytes.Buffer{}
+				err = json.NewEncoder(loginBuf).Encode(&LoginRequestBody{IsNewUser: false, ServerVersion: as.serverVersion})
+				if err != nil {
+					t.Fatal("could not encode request body")
+				}
+				loginReq := httptest.NewRequest(http.MethodPost, "/auth/login", loginBuf)
+				loginReq.SetBasicAuth("user1", "pass1")
+				loginRespRec := httptest.NewRecorder()
+				as.HandleLoginRequest(loginRespRec, loginReq)
+
+				if loginRespRec.Result().StatusCode != http.StatusForbidden {
+					t.Errorf("login of a banned player gave incorrect results, want: %v, got: %v", http.StatusForbidden, loginRespRec.Result().StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleUnbanRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ds := data.NewServer()
+	as := NewServer(ds)
+
+	err := ds.WriteBan(context.Background(), &data.BanData{PlayerID: "player2", Reason: "cheating", BanTime: 1, ExpiryTime: 0})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		playerID   string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", as, "testToken", "player2", http.StatusUnauthorized},
+		{"player without ban", as, "adminToken", "player1", http.StatusNotFound},
+		{"banned player", as, "adminToken", "player2", http.StatusOK},
+		{"unbanned player", as, "adminToken", "player2", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodDelete, "/auth/admin/ban/", nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			authServer := test.server
+			authServer.HandleUnbanRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
+
+func TestServer_HandleLiveStatsRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	as, _, err := setupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	// only a test gameplay service is reachable, with two levels being played
+	middleware.RegisterLiveGauge("gameplay", "levelsBeingPlayed", func() int64 { return 2 })
+	gameplayServer := httptest.NewServer(middleware.WithLiveStats("gameplay", http.NewServeMux()))
+	defer gameplayServer.Close()
+
+	as.liveStatsURLs = map[string]string{"gameplay": gameplayServer.URL}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		wantStatus int
+		wantReport *LiveStatsReport
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil},
+		{"invalid admin token", as, "testToken", http.StatusUnauthorized, nil},
+		{"success", as, "adminToken", http.StatusOK, &LiveStatsReport{OnlinePlayers: 1, LoginsPerMinute: 1, LevelsBeingPlayed: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/auth/admin/live-stats", nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			authServer := test.server
+			authServer.HandleLiveStatsRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if test.wantReport != nil {
+				gotReport := &LiveStatsReport{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotReport)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotReport.OnlinePlayers != test.wantReport.OnlinePlayers || gotReport.LoginsPerMinute != test.wantReport.LoginsPerMinute ||
+					gotReport.LevelsBeingPlayed != test.wantReport.LevelsBeingPlayed {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantReport, gotReport)
+				}
+
+				// auth and every other service should be in the report, with only auth and gameplay reachable
+				if len(gotReport.Services) != len(liveStatsServices)+1 {
+					t.Fatalf("handler gave incorrect number of services, want: %v, got: %v", len(liveStatsServices)+1, len(gotReport.Services))
+				}
+				for _, service := range gotReport.Services {
+					wantReachable := service.Service == "auth" || service.Service == "gameplay"
+					if service.Reachable != wantReachable {
+						t.Errorf("handler gave incorrect reachability for %v, want: %v, got: %v", service.Service, wantReachable, service.Reachable)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleSLORequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	as, _, err := setupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	// only a test gameplay service is reachable, with a failing result route
+	gameplayMux := http.NewServeMux()
+	gameplayMux.HandleFunc("POST /gameplay/result", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "error: test failure", http.StatusInternalServerError)
+	})
+	gameplayHandler := middleware.WithLiveStats("gameplay", gameplayMux)
+	for range constants.SLOMinRequests {
+		gameplayHandler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/gameplay/result", nil))
+	}
+
+	gameplayServer := httptest.NewServer(gameplayHandler)
+	defer gameplayServer.Close()
+
+	as.liveStatsURLs = map[string]string{"gameplay": gameplayServer.URL}
+
+	tests := []struct {
+		name         string
+		server       *Server
+		adminToken   string
+		wantStatus   int
+		wantBreaches []SLOBreach
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil},
+		{"invalid admin token", as, "testToken", http.StatusUnauthorized, nil},
+		{"success", as, "adminToken", http.StatusOK, []SLOBreach{{Service: "gameplay", Route: "POST /gameplay/result", Objectives: []string{"success-rate"}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/auth/admin/slo", nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			authServer := test.server
+			authServer.HandleSLORequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotReport := &SLOReport{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotReport)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotReport.Breaches, test.wantBreaches) {
+					t.Errorf("handler gave incorrect breaches, want: %v, got: %v", test.wantBreaches, gotReport.Breaches)
+				}
+
+				// auth and every other service should be in the report, with only auth and gameplay reachable
+				if len(gotReport.Services) != len(liveStatsServices)+1 {
+					t.Fatalf("handler gave incorrect number of services, want: %v, got: %v", len(liveStatsServices)+1, len(gotReport.Services))
+				}
+				for _, service := range gotReport.Services {
+					wantReachable := service.Service == "auth" || service.Service == "gameplay"
+					if service.Reachable != wantReachable {
+						t.Errorf("handler gave incorrect reachability for %v, want: %v, got: %v", service.Service, wantReachable, service.Reachable)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestServer_RecentLogins(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+
+	as.recordLogin(100)
+	as.recordLogin(130)
+	as.recordLogin(159)
+
+	tests := []struct {
+		name    string
+		unixNow int64
+		want    int
+	}{
+		{"all recent", 159, 3},
+		{"oldest forgotten", 160, 2},
+		{"all forgotten", 300, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			as.forgetOldLogins(test.unixNow)
+			got := len(as.recentLogins)
+			if got != test.want {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_HandleLoginRequest_Localized(t *testing.T) {
+
+	as, _, err := setupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		wantBody       string
+	}{
+		{"default language", "", "error: invalid username or password"},
+		{"spanish", "es", "error: nombre de usuario o contraseña incorrectos"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err = json.NewEncoder(buf).Encode(&LoginRequestBody{IsNewUser: false, ServerVersion: as.serverVersion})
+			if err != nil {
+				t.Fatal("could not encode request body")
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/auth/login", buf)
+			newReq.SetBasicAuth("user1", "wrongPassword")
+			newReq.Header.Set("Accept-Language", test.acceptLanguage)
+			respRec := httptest.NewRecorder()
+
+			as.HandleLoginRequest(respRec, newReq)
+
+			gotError := &apierror.Response{}
+			err = json.NewDecoder(respRec.Body).Decode(gotError)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			if respRec.Code != http.StatusBadRequest || gotError.Code != apierror.CodeInvalidCredentials || gotError.Error != test.wantBody {
+				t.Errorf("handler gave incorrect results, want: %v %v %q, got: %v %v %q", http.StatusBadRequest, apierror.CodeInvalidCredentials, test.wantBody, respRec.Code, gotError.Code, gotError.Error)
+			}
+		})
+	}
+}
+
+// loginTestDevice logs the given user in from a device, and returns the session id
+func loginTestDevice(t *testing.T, as *Server, username string, isNewUser bool, device string) string {
+
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(&LoginRequestBody{IsNewUser: isNewUser, ServerVersion: as.serverVersion, Device: device})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newAuthReq := httptest.NewRequest(http.MethodPost, "/auth/login", buf)
+	newAuthReq.SetBasicAuth(username, "pass1")
+	newAuthReq.Header.Set("User-Agent", "dice-client/1.0")
+	respRec := httptest.NewRecorder()
+
+	as.HandleLoginRequest(respRec, newAuthReq)
+	if respRec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("login failed with status: %v", respRec.Result().StatusCode)
+	}
+	return respRec.Header().Get("Session-Id")
+}
+
+func TestServer_HandleListSessionsRequest(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+	phoneSID := loginTestDevice(t, as, "user1", true, "phone")
+	tabletSID := loginTestDevice(t, as, "user1", false, "tablet")
+	otherSID := loginTestDevice(t, as, "user2", true, "laptop")
+
+	tests := []struct {
+		name        string
+		server      *Server
+		sessionID   string
+		wantStatus  int
+		wantDevices []string
+		wantCurrent int
+	}{
+		{"nil server", nil, phoneSID, http.StatusInternalServerError, nil, 0},
+		{"invalid session", as, "test", http.StatusUnauthorized, nil, 0},
+		{"phone session", as, phoneSID, http.StatusOK, []string{"phone", "tablet"}, 0},
+		{"tablet session", as, tabletSID, http.StatusOK, []string{"phone", "tablet"}, 1},
+		{"other player", as, otherSID, http.StatusOK, []string{"laptop"}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/auth/sessions", nil)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			authServer := test.server
+			withSession(authServer, authServer.HandleListSessionsRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				sessions := []SessionInfo{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(&sessions)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				gotDevices := []string{}
+				for i, session := range sessions {
+					gotDevices = append(gotDevices, session.Device)
+					if session.Current != (i == test.wantCurrent) || session.UserAgent != "dice-client/1.0" || session.IP == "" || session.ID == "" {
+						t.Errorf("handler gave incorrect results, got session: %v", session)
+					}
+					if session.ID == test.sessionID {
+						t.Error("the session id should not be shown in the list of sessions")
+					}
+				}
+
+				if !reflect.DeepEqual(gotDevices, test.wantDevices) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantDevices, gotDevices)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleRevokeSessionRequest(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+	phoneSID := loginTestDevice(t, as, "user1", true, "phone")
+	tabletSID := loginTestDevice(t, as, "user1", false, "tablet")
+	otherSID := loginTestDevice(t, as, "user2", true, "laptop")
+
+	tabletID := as.sessions[sessionSelector(tabletSID)].PublicID
+	otherID := as.sessions[sessionSelector(otherSID)].PublicID
+
+	tests := []struct {
+		name       string
+		server     *Server
+		sessionID  string
+		publicID   string
+		wantStatus int
+	}{
+		{"nil server", nil, phoneSID, tabletID, http.StatusInternalServerError},
+		{"invalid session", as, "test", tabletID, http.StatusUnauthorized},
+		{"other player's session", as, phoneSID, otherID, http.StatusNotFound},
+		{"sign out the tablet", as, phoneSID, tabletID, http.StatusOK},
+		{"already signed out", as, phoneSID, tabletID, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodDelete, "/auth/sessions/", nil)
+			newReq.SetPathValue("id", test.publicID)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			authServer := test.server
+			withSession(authServer, authServer.HandleRevokeSessionRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	// the signed out session cannot be used anymore, the others still can
+	validationReq := httptest.NewRequest(http.MethodPost, "/auth/validation-internal", nil)
+	validationReq.Header.Set("Session-Id", tabletSID)
+	if _, err := as.ValidateRequest(validationReq); !errors.Is(err, invalidSessionError) {
+		t.Errorf("expected an invalid session error for the signed out session, got: %v", err)
+	}
+
+	for _, sID := range []string{phoneSID, otherSID} {
+		validationReq.Header.Set("Session-Id", sID)
+		if _, err := as.ValidateRequest(validationReq); err != nil {
+			t.Errorf("session should still be valid, got: %v", err)
+		}
+	}
+}
+
+func TestServer_MaxSessionsPerPlayer(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+
+	sIDs := []string{}
+	for i := 0; i <= maxSessionsPerPlayer; i++ {
+		sIDs = append(sIDs, loginTestDevice(t, as, "user1", i == 0, fmt.Sprintf("device%v", i)))
+	}
+
+	// logging in on one device too many signs out the oldest session
+	if _, ok := as.sessions[sessionSelector(sIDs[0])]; ok {
+		t.Error("the oldest session should have been deleted")
+	}
+
+	selectors := []string{}
+	for _, sID := range sIDs[1:] {
+		selectors = append(selectors, sessionSelector(sID))
+	}
+
+	pID := as.sessions[selectors[0]].PlayerID
+	if !reflect.DeepEqual(as.playerSessions[pID], selectors) {
+		t.Errorf("incorrect sessions for the player, want: %v, got: %v", selectors, as.playerSessions[pID])
+	}
+}
+
+func TestDecodeBasicCredentials(t *testing.T) {
+
+	encode := func(credentials string) string {
+		return base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	tests := []struct {
+		name         string
+		credentials  string
+		wantUsername string
+		wantPassword string
+		wantErr      bool
+	}{
+		{"valid credentials", encode("usr:pwd"), "usr", "pwd", false},
+		{"password with a colon", encode("usr:p:wd"), "usr", "p:wd", false},
+		{"invalid base64", "usr:pwd", "", "", true},
+		{"no separator", encode("usrpwd"), "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotUsername, gotPassword, err := decodeBasicCredentials(test.credentials)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("decodeBasicCredentials() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			// the errors are logged, so they should never have the credentials in them
+			if err != nil && (strings.Contains(err.Error(), "usr") || strings.Contains(err.Error(), "pwd")) {
+				t.Errorf("decodeBasicCredentials() gave an error with the credentials in it: %v", err)
+			}
+
+			if gotUsername != test.wantUsername || gotPassword != test.wantPassword {
+				t.Errorf("decodeBasicCredentials() gave incorrect results, want: %v and %v, got: %v and %v", test.wantUsername, test.wantPassword, gotUsername, gotPassword)
+			}
+		})
+	}
+}
+
+func TestServer_HandleLoginRequest_Bootstrap(t *testing.T) {
+
+	basic := NewBasicProvider()
+	basic.credentials["test1"] = "pass1"
+	as := NewServer(data.NewServer(), basic)
+
+	// one test server stands in for the profile, stats and config services, and validates the session like they do
+	mux := http.NewServeMux()
+	handle := func(pattern string, respond func(w http.ResponseWriter, r *http.Request)) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			_, err := as.ValidateRequest(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			respond(w, r)
+		})
+	}
+	handle("GET /profile/player-data/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"playerID":"` + r.PathValue("id") + `"}`))
+	})
+	handle("GET /stats/player-stats/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"levelStats":[]}`))
+	})
+	handle("GET /config/game-config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"hash1"`)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	handle("GET /config/announcements", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	})
+	servicesServer := httptest.NewServer(mux)
+	defer servicesServer.Close()
+
+	as.bootstrapURLs = map[string]string{"profile": servicesServer.URL, "stats": servicesServer.URL, "config": servicesServer.URL}
+
+	tests := []struct {
+		name          string
+		requestBody   *LoginRequestBody
+		bootstrapURLs map[string]string
+		wantBundle    *BootstrapBundle
+	}{
+		{"no bootstrap", &LoginRequestBody{ServerVersion: as.serverVersion}, as.bootstrapURLs, nil},
+		{"bootstrap", &LoginRequestBody{ServerVersion: as.serverVersion, Bootstrap: true}, as.bootstrapURLs, &BootstrapBundle{
+			PlayerData:    json.RawMessage(`{"playerID":"1b4f0e98"}`),
+			Stats:         json.RawMessage(`{"levelStats":[]}`),
+			ConfigHash:    `"hash1"`,
+			Announcements: json.RawMessage(`[]`),
+		}},
+		{"unreachable stats", &LoginRequestBody{ServerVersion: as.serverVersion, Bootstrap: true}, map[string]string{"profile": servicesServer.URL, "config": servicesServer.URL}, &BootstrapBundle{
+			PlayerData:    json.RawMessage(`{"playerID":"1b4f0e98"}`),
+			ConfigHash:    `"hash1"`,
+			Announcements: json.RawMessage(`[]`),
+			Errors:        map[string]string{"stats": "no url for the stats service"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			as.bootstrapURLs = test.bootstrapURLs
+
+			buf := &bytes.Buffer{}
+			err := json.NewEncoder(buf).Encode(test.requestBody)
+			if err != nil {
+				t.Fatal("could not encode request body")
+			}
+
+			newAuthReq := httptest.NewRequest(http.MethodPost, "/auth/login", buf)
+			newAuthReq.SetBasicAuth("test1", "pass1")
+			authRespRec := httptest.NewRecorder()
+
+			as.HandleLoginRequest(authRespRec, newAuthReq)
+
+			gotStatus := authRespRec.Result().StatusCode
+			if gotStatus != http.StatusOK {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusOK, gotStatus)
+			}
+
+			gotResponseBody := &LoginResponse{}
+			err = json.NewDecoder(authRespRec.Result().Body).Decode(gotResponseBody)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			if !reflect.DeepEqual(gotResponseBody.Bootstrap, test.wantBundle) {
+				t.Errorf("handler gave incorrect bootstrap bundle, want: %+v, got: %+v", test.wantBundle, gotResponseBody.Bootstrap)
+			}
+		})
+	}
+}
+
+func TestServer_HandleLoginRequest_ClientGate(t *testing.T) {
+
+	basic := NewBasicProvider()
+	basic.credentials["test1"] = "pass1"
+	as := NewServer(data.NewServer(), basic)
+
+	as.EnableClientGate(&config.GameConfig{Clients: config.ClientConfig{Platforms: []config.PlatformClientConfig{
+		{Platform: "ios", MinVersion: "1.4.0", StoreURL: "https://apps.apple.com/app/dice-game"},
+	}}})
+
+	tests := []struct {
+		name            string
+		requestBody     *LoginRequestBody
+		wantStatus      int
+		wantForceUpdate *ForceUpdateResponse
+	}{
+		{"no client version", &LoginRequestBody{ServerVersion: as.serverVersion}, http.StatusOK, nil},
+		{"other platform", &LoginRequestBody{ServerVersion: as.serverVersion, Platform: "android", ClientVersion: "0.1"}, http.StatusOK, nil},
+		{"current client", &LoginRequestBody{ServerVersion: as.serverVersion, Platform: "ios", ClientVersion: "1.4.2"}, http.StatusOK, nil},
+		{"outdated client", &LoginRequestBody{ServerVersion: as.serverVersion, Platform: "ios", ClientVersion: "1.3.9"}, http.StatusUpgradeRequired, &ForceUpdateResponse{
+			Code:          apierror.CodeForceUpdate,
+			ForceUpdate:   true,
+			Platform:      "ios",
+			ClientVersion: "1.3.9",
+			MinVersion:    "1.4.0",
+			StoreURL:      "https://apps.apple.com/app/dice-game",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err := json.NewEncoder(buf).Encode(test.requestBody)
+			if err != nil {
+				t.Fatal("could not encode request body")
+			}
+
+			newAuthReq := httptest.NewRequest(http.MethodPost, "/auth/login", buf)
+			newAuthReq.SetBasicAuth("test1", "pass1")
+			authRespRec := httptest.NewRecorder()
+
+			as.HandleLoginRequest(authRespRec, newAuthReq)
+
+			gotStatus := authRespRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if test.wantForceUpdate != nil {
+				gotForceUpdate := &ForceUpdateResponse{}
+				err = json.NewDecoder(authRespRec.Result().Body).Decode(gotForceUpdate)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotForceUpdate.Error == "" {
+					t.Errorf("handler gave incorrect results, the force update response has no error message")
+				}
+				gotForceUpdate.Error = ""
+				if *gotForceUpdate != *test.wantForceUpdate {
+					t.Errorf("handler gave incorrect results, want: %+v, got: %+v", test.wantForceUpdate, gotForceUpdate)
+				}
+			}
+		})
+	}
+}
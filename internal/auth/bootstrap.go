@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// bootstrapServices are the services asked for the parts of the bootstrap bundle
+var bootstrapServices = []string{"profile", "stats", "config"}
+
+// BootstrapBundle is the initial state of the game, sent along with the login response when the login request asks for it,
+// so the client can start with one round trip instead of four. The parts are the responses of the requests the client
+// would have made itself (with the new session), a part which could not be fetched is left out, with the reason in Errors
+// (like the player data of a new user, which does not exist until the new player request), and the client asks for it separately
+type BootstrapBundle struct {
+	PlayerData    json.RawMessage   `json:"playerData,omitempty"`
+	Stats         json.RawMessage   `json:"stats,omitempty"`
+	ConfigHash    string            `json:"configHash,omitempty"` // the ETag of the game config, the client's cached config is current if it matches
+	Announcements json.RawMessage   `json:"announcements,omitempty"`
+	Errors        map[string]string `json:"errors,omitempty"`
+}
+
+// defaultBootstrapURLs returns the base urls of the services the bootstrap bundle is assembled from, at their addresses in the startup config
+func defaultBootstrapURLs() map[string]string {
+	urls := map[string]string{}
+	for _, service := range bootstrapServices {
+		urls[service] = startup.ServiceURL(service)
+	}
+	return urls
+}
+
+// bootstrap assembles the bootstrap bundle of the player, asking the services for the parts concurrently with the given session
+// (the auth mutex should not be held by the caller, since the services validate the session with auth)
+func (as *Server) bootstrap(ctx context.Context, playerID string, sessionID string) *BootstrapBundle {
+
+	ctx, span := tracing.Start(ctx, "auth.bootstrap")
+	defer span.End()
+
+	bundle := &BootstrapBundle{}
+	bundleMutex := sync.Mutex{}
+	addError := func(part string, err error) {
+		bundleMutex.Lock()
+		defer bundleMutex.Unlock()
+		if bundle.Errors == nil {
+			bundle.Errors = map[string]string{}
+		}
+		bundle.Errors[part] = err.Error()
+	}
+
+	wg := sync.WaitGroup{}
+	fetch := func(part string, service string, method string, path string, use func(resp *http.Response, body []byte)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			baseURL, ok := as.bootstrapURLs[service]
+			if !ok {
+				addError(part, fmt.Errorf("no url for the %v service", service))
+				return
+			}
+
+			resp, body, err := bootstrapRequest(ctx, method, baseURL+path, sessionID)
+			if err != nil {
+				addError(part, err)
+				return
+			}
+
+			bundleMutex.Lock()
+			defer bundleMutex.Unlock()
+			use(resp, body)
+		}()
+	}
+
+	fetch("playerData", "profile", http.MethodGet, "/profile/player-data/"+playerID, func(resp *http.Response, body []byte) {
+		bundle.PlayerData = body
+	})
+	fetch("stats", "stats", http.MethodGet, "/stats/player-stats/"+playerID, func(resp *http.Response, body []byte) {
+		bundle.Stats = body
+	})
+	// only the hash of the config is needed, so there is no need for the body
+	fetch("configHash", "config", http.MethodHead, "/config/game-config", func(resp *http.Response, body []byte) {
+		bundle.ConfigHash = resp.Header.Get("ETag")
+	})
+	fetch("announcements", "config", http.MethodGet, "/config/announcements", func(resp *http.Response, body []byte) {
+		bundle.Announcements = body
+	})
+	wg.Wait()
+
+	return bundle
+}
+
+// bootstrapRequest makes a request for a part of the bootstrap bundle with the given session, and returns the response and its body
+func bootstrapRequest(ctx context.Context, method string, reqURL string, sessionID string) (*http.Response, []byte, error) {
+
+	// each part gets the deadline of its own service
+	ctx, cancel := tracing.InternalContext(ctx, reqURL)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Session-Id", sessionID)
+
+	resp, err := tracing.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("request was not successful, status code %v", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the parts are embedded as they are, so they have to be valid json
+	if method != http.MethodHead && !json.Valid(body) {
+		return nil, nil, fmt.Errorf("response is not valid json")
+	}
+
+	return resp, body, nil
+}
@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the kinds of login challenges (see ChallengeVerifier)
+const ChallengeKindProofOfWork = "pow"
+const ChallengeKindCaptcha = "captcha"
+
+// the random part of a proof of work challenge is this many bytes, hex encoded
+const powChallengeBytes = 16
+
+// captchaVerifyTimeout is how long checking a captcha token with the captcha provider can take
+const captchaVerifyTimeout = 10 * time.Second
+
+var invalidChallengeError = fmt.Errorf("invalid, expired or already used challenge response")
+
+// captchaClient is used to check the captcha tokens with the captcha provider, which is external
+// (so it does not send the service tokens and namespace of the internal requests)
+var captchaClient = &http.Client{Timeout: captchaVerifyTimeout}
+
+// Challenge is a challenge a client has to pass before the login creating its account goes through, the fields
+// other than the kind depend on it: a proof of work challenge has the challenge, its difficulty and its expiry time,
+// while a captcha challenge has the site key of the captcha widget to show
+type Challenge struct {
+	Kind       string `json:"kind"`
+	Challenge  string `json:"challenge,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+	ExpiryTime int64  `json:"expiryTime,omitempty"`
+	SiteKey    string `json:"siteKey,omitempty"`
+}
+
+// ChallengeRequiredResponse is the response to a login which has to pass a challenge first (or sent a wrong response
+// to one), with a new challenge, the client sends the login again with its response as challengeResponse
+type ChallengeRequiredResponse struct {
+	Code      apierror.Code `json:"code"`
+	Error     string        `json:"error"`
+	Challenge *Challenge    `json:"challenge"`
+}
+
+// ChallengeVerifier implementor issues login challenges, and verifies the responses of the clients to them. Verify gets
+// the challenge response of the login request and the ip address of the client, and fails with invalidChallengeError
+// when the response is wrong (any other error means the response could not be checked). Both get the current time
+// from the clock of the auth server
+type ChallengeVerifier interface {
+	NewChallenge(now time.Time) (*Challenge, error)
+	Verify(ctx context.Context, response string, remoteIP string, now time.Time) error
+}
+
+// ChallengePolicy decides whether the logins creating an account have to pass the challenge right now
+// (the feature flags implement it with the login-challenge flag, so it can be turned on when abuse is detected)
+type ChallengePolicy interface {
+	LoginChallengeRequired(ctx context.Context) bool
+}
+
+// EnableLoginChallengeFromEnv enables the login challenge with the verifier picked by the login challenge environment
+// variable (see constants.LoginChallengeEnvVar), and the given policy, it stays disabled if the variable is not set
+func (as *Server) EnableLoginChallengeFromEnv(policy ChallengePolicy) error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	kind := os.Getenv(constants.LoginChallengeEnvVar)
+	switch kind {
+	case "":
+		return nil
+
+	case ChallengeKindProofOfWork:
+		as.EnableLoginChallenge(NewProofOfWorkVerifier(constants.LoginChallengeDifficultyBits, constants.LoginChallengeExpirySeconds), policy)
+		return nil
+
+	case ChallengeKindCaptcha:
+		verifyURL, secret := os.Getenv(constants.CaptchaVerifyURLEnvVar), os.Getenv(constants.CaptchaSecretEnvVar)
+		if verifyURL == "" || secret == "" {
+			return fmt.Errorf("the captcha login challenge needs %v and %v as well", constants.CaptchaVerifyURLEnvVar, constants.CaptchaSecretEnvVar)
+		}
+		as.EnableLoginChallenge(NewCaptchaVerifier(verifyURL, secret, os.Getenv(constants.CaptchaSiteKeyEnvVar)), policy)
+		return nil
+
+	default:
+		return fmt.Errorf("%v should be %v or %v, got: %v", constants.LoginChallengeEnvVar, ChallengeKindProofOfWork, ChallengeKindCaptcha, kind)
+	}
+}
+
+// EnableLoginChallenge makes the logins creating an account pass a challenge of the given verifier first,
+// whenever the given policy requires it, which makes mass account creation by scripts costly
+func (as *Server) EnableLoginChallenge(verifier ChallengeVerifier, policy ChallengePolicy) {
+
+	if as == nil || verifier == nil || policy == nil {
+		return
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	as.challengeVerifier = verifier
+	as.challengePolicy = policy
+	as.logger.Println("login challenge enabled")
+}
+
+// checkLoginChallenge checks the challenge response of a login creating an account, when the policy requires a challenge,
+// and responds with a new challenge (and returns false) if the response is missing or wrong
+func (as *Server) checkLoginChallenge(w http.ResponseWriter, r *http.Request, isNewUser bool, response string) bool {
+
+	if !isNewUser {
+		return true
+	}
+
+	as.authMutex.Lock()
+	verifier, policy := as.challengeVerifier, as.challengePolicy
+	as.authMutex.Unlock()
+
+	if verifier == nil || !policy.LoginChallengeRequired(r.Context()) {
+		return true
+	}
+
+	code := apierror.CodeChallengeRequired
+	if response != "" {
+		err := verifier.Verify(r.Context(), response, clientIP(r), as.clock.Now())
+		if err == nil {
+			return true
+		}
+
+		as.logger.Println("error: login challenge failed: " + err.Error())
+		if !errors.Is(err, invalidChallengeError) {
+			apierror.Write(w, r, http.StatusBadGateway, apierror.CodeUnavailable)
+			return false
+		}
+		code = apierror.CodeInvalidChallenge
+	}
+
+	challenge, err := verifier.NewChallenge(as.clock.Now())
+	if err != nil {
+		as.logger.Println("error: could not issue a login challenge: " + err.Error())
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionRequired)
+	err = json.NewEncoder(w).Encode(&ChallengeRequiredResponse{Code: code, Error: apierror.Message(r, code), Challenge: challenge})
+	if err != nil {
+		as.logger.Println("error: could not encode the challenge response: " + err.Error())
+	}
+
+	return false
+}
+
+// ProofOfWorkVerifier is the ChallengeVerifier which makes the client find a solution whose hash has a number of leading
+// zero bits (see SolveProofOfWork), which takes a client a moment, but a script creating many accounts a lot of work.
+// Challenges are signed (HMAC) by the verifier, so they are not stored, only the ones already used are, until they expire
+type ProofOfWorkVerifier struct {
+	difficulty    int
+	expirySeconds int64
+	key           []byte
+
+	// the expiry times of the challenges already used, keyed by challenge
+	used  map[string]int64
+	mutex sync.Mutex
+}
+
+// NewProofOfWorkVerifier returns an initialized pointer to a proof of work verifier, whose challenges need the given
+// number of leading zero bits, and expire after the given number of seconds (the signing key is generated)
+func NewProofOfWorkVerifier(difficulty int, expirySeconds int64) *ProofOfWorkVerifier {
+
+	key := make([]byte, 32)
+	_, _ = rand.Read(key) // never returns an error
+
+	return &ProofOfWorkVerifier{difficulty: difficulty, expirySeconds: expirySeconds, key: key, used: map[string]int64{}}
+}
+
+// NewChallenge issues a challenge at the given time: a random value and its expiry time, signed by the verifier
+func (pv *ProofOfWorkVerifier) NewChallenge(now time.Time) (*Challenge, error) {
+
+	random := make([]byte, powChallengeBytes)
+	_, err := rand.Read(random)
+	if err != nil {
+		return nil, err
+	}
+
+	expiryTime := now.UTC().Unix() + pv.expirySeconds
+	payload := hex.EncodeToString(random) + "." + strconv.FormatInt(expiryTime, 10)
+
+	return &Challenge{
+		Kind:       ChallengeKindProofOfWork,
+		Challenge:  payload + "." + pv.sign(payload),
+		Difficulty: pv.difficulty,
+		ExpiryTime: expiryTime,
+	}, nil
+}
+
+// Verify checks a response in the "<challenge>:<solution>" form: the challenge has to be one the verifier issued,
+// which has not expired (at the given time) and was not used before, and the solution has to have enough leading zero bits
+func (pv *ProofOfWorkVerifier) Verify(ctx context.Context, response string, remoteIP string, now time.Time) error {
+
+	challenge, solution, ok := strings.Cut(response, ":")
+	if !ok {
+		return invalidChallengeError
+	}
+
+	// the challenge is "<random>.<expiry time>.<signature>"
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 3 {
+		return invalidChallengeError
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(pv.sign(payload)), []byte(parts[2])) {
+		return invalidChallengeError
+	}
+
+	expiryTime, err := strconv.ParseInt(parts[1], 10, 64)
+	unixNow := now.UTC().Unix()
+	if err != nil || expiryTime < unixNow {
+		return invalidChallengeError
+	}
+
+	if ProofOfWorkBits(challenge, solution) < pv.difficulty {
+		return invalidChallengeError
+	}
+
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+
+	// the used challenges which have expired would be rejected anyway, so they are dropped
+	for used, usedExpiry := range pv.used {
+		if usedExpiry < unixNow {
+			delete(pv.used, used)
+		}
+	}
+
+	if _, used := pv.used[challenge]; used {
+		return invalidChallengeError
+	}
+	pv.used[challenge] = expiryTime
+
+	return nil
+}
+
+// sign returns the signature of the given challenge payload (hex encoded HMAC-SHA256)
+func (pv *ProofOfWorkVerifier) sign(payload string) string {
+
+	mac := hmac.New(sha256.New, pv.key)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ProofOfWorkBits returns the number of leading zero bits of the hash (sha256) of "<challenge>:<solution>"
+func ProofOfWorkBits(challenge string, solution string) int {
+
+	hash := sha256.Sum256([]byte(challenge + ":" + solution))
+
+	zeroBits := 0
+	for _, b := range hash {
+		zeroBits += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+
+	return zeroBits
+}
+
+// SolveProofOfWork finds a solution to the given proof of work challenge (what a client does), and returns the response
+// to send in the login request. It takes about 2^difficulty hashes
+func SolveProofOfWork(challenge *Challenge) string {
+
+	for counter := uint64(0); ; counter++ {
+		solution := strconv.FormatUint(counter, 10)
+		if ProofOfWorkBits(challenge.Challenge, solution) >= challenge.Difficulty {
+			return challenge.Challenge + ":" + solution
+		}
+	}
+}
+
+// CaptchaVerifier is the ChallengeVerifier which makes the client solve a captcha (the response is the token of the
+// captcha widget), checked with the siteverify api of the captcha provider (the one of reCAPTCHA, hCaptcha and Turnstile)
+type CaptchaVerifier struct {
+	verifyURL string
+	secret    string
+	siteKey   string
+}
+
+// captchaVerifyResponse is the part of the response of the siteverify api the verifier uses
+type captchaVerifyResponse struct {
+	Success bool `json:"success"`
+}
+
+// NewCaptchaVerifier returns an initialized pointer to a captcha verifier, which checks the tokens with the siteverify
+// api at the given url with the given secret, and sends the given site key (of the captcha widget) to the clients
+func NewCaptchaVerifier(verifyURL string, secret string, siteKey string) *CaptchaVerifier {
+	return &CaptchaVerifier{verifyURL: verifyURL, secret: secret, siteKey: siteKey}
+}
+
+// NewChallenge returns the captcha challenge, the client shows the captcha widget with the site key
+func (cv *CaptchaVerifier) NewChallenge(now time.Time) (*Challenge, error) {
+	return &Challenge{Kind: ChallengeKindCaptcha, SiteKey: cv.siteKey}, nil
+}
+
+// Verify checks the captcha token with the captcha provider (which also rejects tokens used before, and expired ones,
+// so the time is not needed)
+func (cv *CaptchaVerifier) Verify(ctx context.Context, response string, remoteIP string, now time.Time) error {
+
+	if response == "" {
+		return invalidChallengeError
+	}
+
+	form := url.Values{"secret": {cv.secret}, "response": {response}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cv.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := captchaClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach the captcha provider: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the captcha provider responded with status: %v", resp.StatusCode)
+	}
+
+	verifyResponse := &captchaVerifyResponse{}
+	err = json.NewDecoder(resp.Body).Decode(verifyResponse)
+	if err != nil {
+		return fmt.Errorf("could not decode the captcha provider's response: %v", err)
+	}
+
+	if !verifyResponse.Success {
+		return invalidChallengeError
+	}
+
+	return nil
+}
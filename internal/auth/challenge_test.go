@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/clock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// challengeSwitch is a ChallengePolicy which requires the challenge while it is on
+type challengeSwitch bool
+
+func (cs *challengeSwitch) LoginChallengeRequired(ctx context.Context) bool {
+	return bool(*cs)
+}
+
+func TestProofOfWorkVerifier(t *testing.T) {
+
+	pv := NewProofOfWorkVerifier(8, 60)
+	now := time.Now()
+
+	challenge, err := pv.NewChallenge(now)
+	if err != nil || challenge.Kind != ChallengeKindProofOfWork || challenge.Difficulty != 8 || challenge.ExpiryTime == 0 {
+		t.Fatalf("could not issue a challenge, got: %+v, %v", challenge, err)
+	}
+
+	response := SolveProofOfWork(challenge)
+	_, solution, _ := strings.Cut(response, ":")
+	if ProofOfWorkBits(challenge.Challenge, solution) < 8 {
+		t.Fatalf("the solution does not have enough leading zero bits: %v", response)
+	}
+
+	// a solution which is not good enough, a made up challenge, and a tampered expiry time are rejected
+	wrong := "0"
+	for ProofOfWorkBits(challenge.Challenge, wrong) >= 8 {
+		wrong += "0"
+	}
+	parts := strings.Split(challenge.Challenge, ".")
+	for _, response := range []string{
+		"",
+		challenge.Challenge,
+		challenge.Challenge + ":" + wrong,
+		"madeUp.123.abc:" + solution,
+		parts[0] + ".9999999999." + parts[2] + ":" + solution,
+	} {
+		err = pv.Verify(context.Background(), response, "", now)
+		if !errors.Is(err, invalidChallengeError) {
+			t.Errorf("the response %q should have been rejected, got: %v", response, err)
+		}
+	}
+
+	// the right solution passes, once
+	err = pv.Verify(context.Background(), response, "", now)
+	if err != nil {
+		t.Fatalf("the solution should have passed, got: %v", err)
+	}
+	err = pv.Verify(context.Background(), response, "", now)
+	if !errors.Is(err, invalidChallengeError) {
+		t.Fatalf("a used challenge should have been rejected, got: %v", err)
+	}
+
+	// an expired challenge is rejected, even when solved
+	expired, _ := pv.NewChallenge(now)
+	err = pv.Verify(context.Background(), SolveProofOfWork(expired), "", now.Add(61*time.Second))
+	if !errors.Is(err, invalidChallengeError) {
+		t.Fatalf("an expired challenge should have been rejected, got: %v", err)
+	}
+}
+
+func TestCaptchaVerifier(t *testing.T) {
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "secret1" {
+			http.Error(w, "wrong secret", http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&captchaVerifyResponse{Success: r.FormValue("response") == "token1" && r.FormValue("remoteip") == "10.0.0.1"})
+	}))
+	defer provider.Close()
+
+	cv := NewCaptchaVerifier(provider.URL, "secret1", "site1")
+	challenge, err := cv.NewChallenge(time.Now())
+	if err != nil || challenge.Kind != ChallengeKindCaptcha || challenge.SiteKey != "site1" {
+		t.Fatalf("could not issue a challenge, got: %+v, %v", challenge, err)
+	}
+
+	tests := []struct {
+		name     string
+		verifier *CaptchaVerifier
+		response string
+		wantErr  error // invalidChallengeError when the token is rejected, or any other error when it is not checked
+	}{
+		{"solved", cv, "token1", nil},
+		{"no token", cv, "", invalidChallengeError},
+		{"wrong token", cv, "token2", invalidChallengeError},
+		{"wrong secret", NewCaptchaVerifier(provider.URL, "secret2", "site1"), "token1", http.ErrNotSupported},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.verifier.Verify(context.Background(), test.response, "10.0.0.1", time.Now())
+			if (err == nil) != (test.wantErr == nil) || errors.Is(err, invalidChallengeError) != errors.Is(test.wantErr, invalidChallengeError) {
+				t.Fatalf("verifier gave incorrect results, want: %v, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServer_HandleLoginRequest_LoginChallenge(t *testing.T) {
+
+	basic := NewBasicProvider()
+	basic.credentials["test1"] = "pass1"
+	as := NewServer(data.NewServer(), basic)
+
+	// the challenges expire by the clock of the server
+	frozenClock := clock.NewFrozen(time.Now())
+	as.SetClock(frozenClock)
+
+	required := challengeSwitch(false)
+	as.EnableLoginChallenge(NewProofOfWorkVerifier(8, 60), &required)
+
+	login := func(username string, isNewUser bool, challengeResponse string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(&LoginRequestBody{ServerVersion: as.serverVersion, IsNewUser: isNewUser, ChallengeResponse: challengeResponse})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body))
+		req.SetBasicAuth(username, "pass1")
+		respRec := httptest.NewRecorder()
+		as.HandleLoginRequest(respRec, req)
+		return respRec
+	}
+	challengeOf := func(respRec *httptest.ResponseRecorder, wantCode apierror.Code) *Challenge {
+		if respRec.Code != http.StatusPreconditionRequired {
+			t.Fatalf("the login should have needed a challenge, got: %v", respRec.Code)
+		}
+		resp := &ChallengeRequiredResponse{}
+		err := json.NewDecoder(respRec.Body).Decode(resp)
+		if err != nil || resp.Code != wantCode || resp.Error == "" || resp.Challenge == nil {
+			t.Fatalf("the response should have had a %v challenge, got: %+v, %v", wantCode, resp, err)
+		}
+		return resp.Challenge
+	}
+
+	// while the policy does not require it, new users log in without a challenge
+	respRec := login("test2", true, "")
+	if respRec.Code != http.StatusOK {
+		t.Fatalf("the login should have passed without a challenge, got: %v", respRec.Code)
+	}
+
+	// once required, a new user gets a challenge (and a new one for a wrong response), and logs in with its solution
+	required = true
+	challenge := challengeOf(login("test3", true, ""), apierror.CodeChallengeRequired)
+	challengeOf(login("test3", true, "madeUp.123.abc:0"), apierror.CodeInvalidChallenge)
+
+	// a solution sent after the challenge expired gets a new challenge
+	frozenClock.Advance(61 * time.Second)
+	challenge = challengeOf(login("test3", true, SolveProofOfWork(challenge)), apierror.CodeInvalidChallenge)
+	if challenge.ExpiryTime != frozenClock.Now().Unix()+60 {
+		t.Errorf("the challenge should expire by the clock of the server, want: %v, got: %v", frozenClock.Now().Unix()+60, challenge.ExpiryTime)
+	}
+
+	respRec = login("test3", true, SolveProofOfWork(challenge))
+	if respRec.Code != http.StatusOK {
+		t.Fatalf("the login should have passed with the solved challenge, got: %v", respRec.Code)
+	}
+
+	// existing users are not challenged
+	respRec = login("test1", false, "")
+	if respRec.Code != http.StatusOK {
+		t.Fatalf("the login of an existing user should not have been challenged, got: %v", respRec.Code)
+	}
+}
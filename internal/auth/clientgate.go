@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"net/http"
+)
+
+// ClientGate decides which client versions can log in (the game config implements it with its client compatibility matrix),
+// returning the min version and the store url of the platform, and whether the client has to update first
+type ClientGate interface {
+	ClientUpdate(platform string, version string) (minVersion string, storeURL string, needed bool)
+}
+
+// ForceUpdateResponse is the response to a login from an outdated client, which should send the player to the store url
+type ForceUpdateResponse struct {
+	Code          apierror.Code `json:"code"`
+	Error         string        `json:"error"`
+	ForceUpdate   bool          `json:"forceUpdate"`
+	Platform      string        `json:"platform"`
+	ClientVersion string        `json:"clientVersion"`
+	MinVersion    string        `json:"minVersion"`
+	StoreURL      string        `json:"storeURL"`
+}
+
+// EnableClientGate rejects logins (basic and social) from the client versions the given gate says are outdated,
+// with a force update response, rather than letting them in to fail on requests they no longer understand
+func (as *Server) EnableClientGate(gate ClientGate) {
+
+	if as == nil || gate == nil {
+		return
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	as.clientGate = gate
+	as.logger.Println("client version gate enabled")
+}
+
+// checkClientVersion responds with a force update (and returns false) if the client of the login has to update first
+func (as *Server) checkClientVersion(w http.ResponseWriter, r *http.Request, platform string, clientVersion string) bool {
+
+	as.authMutex.Lock()
+	gate := as.clientGate
+	as.authMutex.Unlock()
+
+	if gate == nil {
+		return true
+	}
+
+	minVersion, storeURL, needed := gate.ClientUpdate(platform, clientVersion)
+	if !needed {
+		return true
+	}
+
+	as.logger.Printf("error: outdated client, platform: %v, version: %v, min version: %v", platform, clientVersion, minVersion)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUpgradeRequired)
+	err := json.NewEncoder(w).Encode(&ForceUpdateResponse{
+		Code:          apierror.CodeForceUpdate,
+		Error:         apierror.Message(r, apierror.CodeForceUpdate, "{minVersion}", minVersion),
+		ForceUpdate:   true,
+		Platform:      platform,
+		ClientVersion: clientVersion,
+		MinVersion:    minVersion,
+		StoreURL:      storeURL,
+	})
+	if err != nil {
+		as.logger.Println("error: could not encode the force update response: " + err.Error())
+	}
+
+	return false
+}
@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// an LDAP bind gets this long to complete
+const ldapTimeout = 10 * time.Second
+
+// BER tags of the LDAP messages used for the bind (https://www.rfc-editor.org/rfc/rfc4511)
+const (
+	berSequence     byte = 0x30
+	berInteger      byte = 0x02
+	berOctetString  byte = 0x04
+	berEnumerated   byte = 0x0a
+	berSimpleAuth   byte = 0x80 // [0] primitive, the simple (password) authentication choice
+	ldapBindRequest byte = 0x60 // [APPLICATION 0] constructed
+	ldapBindResp    byte = 0x61 // [APPLICATION 1] constructed
+)
+
+// result codes of the bind response (https://www.rfc-editor.org/rfc/rfc4511#appendix-A)
+const ldapResultSuccess = 0
+const ldapResultInvalidCredentials = 49
+
+// LDAPProvider verifies usernames and passwords (sent with the basic scheme) with a simple bind to an LDAP directory,
+// as the entry named by the bind dn template, with {username} replaced by the (escaped) username.
+// There is nothing to register, so new and existing users are verified the same way
+type LDAPProvider struct {
+	Address   string      // host:port of the directory
+	UseTLS    bool        // ldaps
+	BindDN    string      // like uid={username},ou=people,dc=example,dc=com
+	TLSConfig *tls.Config // for ldaps, nil uses the system roots
+}
+
+// NewLDAPProvider returns a provider for the directory at the given url (ldap://host:port or ldaps://host:port),
+// the bind dn template needs a {username} placeholder
+func NewLDAPProvider(rawURL string, bindDN string) (*LDAPProvider, error) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url: %v", err)
+	}
+
+	lp := &LDAPProvider{Address: u.Host, BindDN: bindDN}
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			lp.Address = net.JoinHostPort(u.Hostname(), "389")
+		}
+	case "ldaps":
+		lp.UseTLS = true
+		if u.Port() == "" {
+			lp.Address = net.JoinHostPort(u.Hostname(), "636")
+		}
+	default:
+		return nil, fmt.Errorf("invalid ldap url: the scheme should be ldap or ldaps")
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ldap url: no host")
+	}
+	if !strings.Contains(bindDN, "{username}") {
+		return nil, fmt.Errorf("the ldap bind dn should have a {username} placeholder")
+	}
+
+	return lp, nil
+}
+
+func (lp *LDAPProvider) Scheme() string {
+	return SchemeBasic
+}
+
+// Verify binds to the directory as the user, and returns the username with an "ldap:" prefix
+func (lp *LDAPProvider) Verify(ctx context.Context, credentials string, isNewUser bool) (string, error) {
+
+	usr, pwd, err := decodeBasicCredentials(credentials)
+	if err != nil {
+		return "", err
+	}
+	if usr == "" {
+		return "", MalformedCredentialsErr{Reason: "blank username"}
+	}
+
+	// directories treat a bind with an empty password as an anonymous one, which succeeds without checking anything
+	if pwd == "" {
+		return "", invalidCredentialsError
+	}
+
+	dn := strings.ReplaceAll(lp.BindDN, "{username}", escapeDNValue(usr))
+	err = lp.bind(ctx, dn, pwd)
+	if err != nil {
+		return "", err
+	}
+
+	return "ldap:" + usr, nil
+}
+
+// bind does a simple bind as the given dn, and returns invalidCredentialsError if the directory turns it down
+func (lp *LDAPProvider) bind(ctx context.Context, dn string, password string) error {
+
+	ctx, cancel := context.WithTimeout(ctx, ldapTimeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	dial := dialer.DialContext
+	if lp.UseTLS {
+		tlsDialer := &tls.Dialer{Config: lp.TLSConfig}
+		dial = tlsDialer.DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", lp.Address)
+	if err != nil {
+		return fmt.Errorf("could not reach the ldap directory: %v", err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return fmt.Errorf("could not reach the ldap directory: %v", err)
+	}
+
+	// the connection is only used for this bind, so the message id can always be 1
+	msgID := int32(1)
+
+	bindRequest := berElement(ldapBindRequest, berInt(berInteger, 3), berElement(berOctetString, []byte(dn)), berElement(berSimpleAuth, []byte(password)))
+	_, err = conn.Write(berElement(berSequence, berInt(berInteger, msgID), bindRequest))
+	if err != nil {
+		return fmt.Errorf("could not send the ldap bind request: %v", err)
+	}
+
+	resultCode, err := readBindResponse(bufio.NewReader(conn), msgID)
+	if err != nil {
+		return fmt.Errorf("invalid ldap bind response: %v", err)
+	}
+
+	switch resultCode {
+	case ldapResultSuccess:
+		return nil
+	case ldapResultInvalidCredentials:
+		return invalidCredentialsError
+	default:
+		return fmt.Errorf("the ldap bind failed with result code %v", resultCode)
+	}
+}
+
+// readBindResponse reads the bind response with the given message id, and returns its result code
+func readBindResponse(reader *bufio.Reader, msgID int32) (int64, error) {
+
+	tag, message, err := readBERElement(reader)
+	if err != nil {
+		return 0, err
+	}
+	if tag != berSequence {
+		return 0, fmt.Errorf("expected an ldap message")
+	}
+
+	tag, gotID, message, err := nextBERElement(message)
+	if err != nil || tag != berInteger || berIntValue(gotID) != int64(msgID) {
+		return 0, fmt.Errorf("unexpected message id")
+	}
+
+	tag, response, _, err := nextBERElement(message)
+	if err != nil || tag != ldapBindResp {
+		return 0, fmt.Errorf("expected a bind response")
+	}
+
+	tag, resultCode, _, err := nextBERElement(response)
+	if err != nil || tag != berEnumerated {
+		return 0, fmt.Errorf("expected a result code")
+	}
+
+	return berIntValue(resultCode), nil
+}
+
+// readBERElement reads a whole element (tag, length and contents) from the reader
+func readBERElement(reader *bufio.Reader) (byte, []byte, error) {
+
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size := int(length)
+	if length&0x80 != 0 {
+		// long form, the low bits are the number of length bytes (a bind response is never that big)
+		count := int(length & 0x7f)
+		if count == 0 || count > 3 {
+			return 0, nil, fmt.Errorf("unsupported element length")
+		}
+		size = 0
+		for range count {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			size = size<<8 | int(b)
+		}
+	}
+
+	contents := make([]byte, size)
+	_, err = io.ReadFull(reader, contents)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return tag, contents, nil
+}
+
+// nextBERElement splits the first element off the given contents, and returns its tag and contents, and the rest
+func nextBERElement(data []byte) (byte, []byte, []byte, error) {
+
+	tag, contents, err := readBERElement(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	// the header is the tag, and one length byte, or one more for each length byte of the long form
+	header := 2
+	if data[1]&0x80 != 0 {
+		header += int(data[1] & 0x7f)
+	}
+
+	return tag, contents, data[header+len(contents):], nil
+}
+
+// berElement encodes an element with the given tag, and the given encoded elements (or bytes) as its contents
+func berElement(tag byte, parts ...[]byte) []byte {
+
+	var contents []byte
+	for _, part := range parts {
+		contents = append(contents, part...)
+	}
+
+	element := []byte{tag}
+	switch size := len(contents); {
+	case size < 0x80:
+		element = append(element, byte(size))
+	case size <= 0xff:
+		element = append(element, 0x81, byte(size))
+	case size <= 0xffff:
+		element = append(element, 0x82, byte(size>>8), byte(size))
+	default:
+		element = append(element, 0x83, byte(size>>16), byte(size>>8), byte(size))
+	}
+
+	return append(element, contents...)
+}
+
+// berInt encodes a small non negative integer with the given tag
+func berInt(tag byte, value int32) []byte {
+
+	var contents []byte
+	for {
+		contents = append([]byte{byte(value)}, contents...)
+		value >>= 8
+		if value == 0 {
+			break
+		}
+	}
+
+	// keep it positive
+	if contents[0]&0x80 != 0 {
+		contents = append([]byte{0}, contents...)
+	}
+
+	return berElement(tag, contents)
+}
+
+// berIntValue decodes the contents of an integer (or enumerated) element
+func berIntValue(contents []byte) int64 {
+
+	var value int64
+	for i, b := range contents {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int64(b)
+	}
+
+	return value
+}
+
+// escapeDNValue escapes the characters with a special meaning in a distinguished name (https://www.rfc-editor.org/rfc/rfc4514)
+func escapeDNValue(value string) string {
+
+	builder := strings.Builder{}
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			builder.WriteByte('\\')
+			builder.WriteRune(c)
+		case c == 0:
+			builder.WriteString("\\00")
+		default:
+			builder.WriteRune(c)
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"net/http"
+	"sync"
+)
+
+// liveStatsServices are the services (other than auth itself) whose live stats are aggregated
+var liveStatsServices = []string{"data", "config", "profile", "stats", "gameplay", "shop", "promo", "match", "notifications", "referral", "guilds", "webhooks"}
+
+// ServiceLiveStats are the live stats of a single service as part of the live stats report,
+// a service which could not be reached is reported with the error, and without any stats
+type ServiceLiveStats struct {
+	Service          string `json:"service"`
+	Reachable        bool   `json:"reachable"`
+	RequestsInFlight int64  `json:"requestsInFlight"`
+	Error            string `json:"error,omitempty"`
+}
+
+// LiveStatsReport is the response to the admin live stats request, meant for live ops dashboards
+type LiveStatsReport struct {
+	OnlinePlayers     int                `json:"onlinePlayers"`
+	LoginsPerMinute   int                `json:"loginsPerMinute"`
+	LevelsBeingPlayed int64              `json:"levelsBeingPlayed"`
+	Services          []ServiceLiveStats `json:"services"`
+	GeneratedAt       int64              `json:"generatedAt"`
+}
+
+// defaultLiveStatsURLs returns the base urls of the services whose live stats are aggregated, at their addresses in the startup config
+func defaultLiveStatsURLs() map[string]string {
+	urls := map[string]string{}
+	for _, service := range liveStatsServices {
+		urls[service] = startup.ServiceURL(service)
+	}
+	return urls
+}
+
+// recordLogin adds a login at the given unix time to the recent logins, and forgets the ones older than a minute
+// (the auth mutex should be held by the caller)
+func (as *Server) recordLogin(unixTime int64) {
+	as.recentLogins = append(as.recentLogins, unixTime)
+	as.forgetOldLogins(unixTime)
+}
+
+// forgetOldLogins removes the logins older than a minute (before the given unix time) from the recent logins
+// (the auth mutex should be held by the caller)
+func (as *Server) forgetOldLogins(unixNow int64) {
+	firstRecent := 0
+	for firstRecent < len(as.recentLogins) && unixNow-as.recentLogins[firstRecent] >= 60 {
+		firstRecent++
+	}
+	as.recentLogins = as.recentLogins[firstRecent:]
+}
+
+// HandleLiveStatsRequest responds with the live stats report (admin only)
+func (as *Server) HandleLiveStatsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	report, err := as.LiveStats(r.Context())
+	if err != nil {
+		errMsg := "live stats error: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// LiveStats returns the live stats report: the players currently online (with a session), the logins in the last minute,
+// the levels being played (as reported by the gameplay service), and the requests in flight per service
+func (as *Server) LiveStats(ctx context.Context) (*LiveStatsReport, error) {
+
+	if as == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "auth.LiveStats")
+	defer span.End()
+
+	unixNow := as.clock.Now().UTC().Unix()
+
+	as.authMutex.Lock()
+	as.forgetOldLogins(unixNow)
+	report := &LiveStatsReport{
+		OnlinePlayers:   len(as.playerSessions),
+		LoginsPerMinute: len(as.recentLogins),
+		GeneratedAt:     unixNow,
+	}
+	as.authMutex.Unlock()
+
+	authStats := middleware.CurrentLiveStats("auth")
+	report.Services = append(report.Services, ServiceLiveStats{
+		Service:          authStats.Service,
+		Reachable:        true,
+		RequestsInFlight: authStats.RequestsInFlight,
+	})
+
+	// ask the other services for their live stats concurrently, so an unreachable one does not hold up the rest
+	serviceStats := make([]ServiceLiveStats, len(liveStatsServices))
+	wg := sync.WaitGroup{}
+	for i, service := range liveStatsServices {
+		serviceStats[i].Service = service
+
+		baseURL, ok := as.liveStatsURLs[service]
+		if !ok {
+			serviceStats[i].Error = "no url for the service"
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			stats, err := middleware.ReadLiveStats(ctx, baseURL, service)
+			if err != nil {
+				serviceStats[i].Error = err.Error()
+				return
+			}
+
+			serviceStats[i].Reachable = true
+			serviceStats[i].RequestsInFlight = stats.RequestsInFlight
+
+			if service == "gameplay" {
+				report.LevelsBeingPlayed = stats.Gauges["levelsBeingPlayed"]
+			}
+		}()
+	}
+	wg.Wait()
+
+	report.Services = append(report.Services, serviceStats...)
+	return report, nil
+}
+
+// ServiceSLOReport is the state of the SLOs of the routes of a single service as part of the SLO report,
+// a service which could not be reached is reported with the error, and without any routes
+type ServiceSLOReport struct {
+	Service   string                `json:"service"`
+	Reachable bool                  `json:"reachable"`
+	Routes    []middleware.RouteSLO `json:"routes,omitempty"`
+	Error     string                `json:"error,omitempty"`
+}
+
+// SLOBreach is a route which currently breaches (some of the objectives of) its SLO
+type SLOBreach struct {
+	Service    string   `json:"service"`
+	Route      string   `json:"route"`
+	Objectives []string `json:"objectives"`
+}
+
+// SLOReport is the response to the admin SLO request, with the SLOs of the routes of every service
+// over the last SLOWindowMinutes, and a list of the routes breaching their SLO
+type SLOReport struct {
+	WindowMinutes int                `json:"windowMinutes"`
+	Breaches      []SLOBreach        `json:"breaches"`
+	Services      []ServiceSLOReport `json:"services"`
+	GeneratedAt   int64              `json:"generatedAt"`
+}
+
+// HandleSLORequest responds with the SLO report (admin only)
+func (as *Server) HandleSLORequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	report, err := as.SLOReport(r.Context())
+	if err != nil {
+		errMsg := "slo report error: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// SLOReport returns the SLO report: the SLOs of the routes of auth itself and of the other services
+// (asked for concurrently, like the live stats), and a list of the routes breaching their SLO
+func (as *Server) SLOReport(ctx context.Context) (*SLOReport, error) {
+
+	if as == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "auth.SLOReport")
+	defer span.End()
+
+	authSLO := middleware.CurrentSLO("auth")
+	serviceReports := make([]ServiceSLOReport, len(liveStatsServices)+1)
+	serviceReports[0] = ServiceSLOReport{Service: "auth", Reachable: true, Routes: authSLO.Routes}
+
+	wg := sync.WaitGroup{}
+	for i, service := range liveStatsServices {
+		serviceReport := &serviceReports[i+1]
+		serviceReport.Service = service
+
+		baseURL, ok := as.liveStatsURLs[service]
+		if !ok {
+			serviceReport.Error = "no url for the service"
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			slo, err := middleware.ReadSLO(ctx, baseURL, service)
+			if err != nil {
+				serviceReport.Error = err.Error()
+				return
+			}
+
+			serviceReport.Reachable = true
+			serviceReport.Routes = slo.Routes
+		}()
+	}
+	wg.Wait()
+
+	report := &SLOReport{
+		WindowMinutes: constants.SLOWindowMinutes,
+		Breaches:      []SLOBreach{},
+		Services:      serviceReports,
+		GeneratedAt:   as.clock.Now().UTC().Unix(),
+	}
+
+	for _, serviceReport := range serviceReports {
+		for _, routeSLO := range serviceReport.Routes {
+			if len(routeSLO.Breaches) > 0 {
+				report.Breaches = append(report.Breaches, SLOBreach{Service: serviceReport.Service, Route: routeSLO.Route, Objectives: routeSLO.Breaches})
+			}
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// a queued player is asked to poll (or retry the login) this often
+const loginQueuePollSeconds = 5
+
+// a waiting ticket which is not polled for this long (or an admitted one not used for a login) is dropped,
+// so abandoned tickets do not hold up the queue
+const loginQueueTicketExpirySeconds = 30
+
+// the queue holds at most this many waiting players, the logins after that are turned away
+const maxLoginQueueLength = 100000
+
+// Login Queue Errors:
+var loginQueueFullError = fmt.Errorf("the login queue is full")
+
+// LoginQueueTicketNotFoundErr is returned when the ticket is not in the login queue (or has expired)
+type LoginQueueTicketNotFoundErr struct {
+	Ticket string
+}
+
+func (err LoginQueueTicketNotFoundErr) Error() string {
+	return fmt.Sprintf("no login queue ticket %v", err.Ticket)
+}
+
+// LoginQueueResponse is the state of a ticket in the login queue, sent (with a 202) to a login which was queued,
+// and to the polls of the ticket. Once admitted, the login is sent again with the ticket in the login request body
+type LoginQueueResponse struct {
+	Ticket            string `json:"ticket"`
+	Position          int    `json:"position"` // 1 is the next to be admitted, 0 once admitted
+	Admitted          bool   `json:"admitted"`
+	RetryAfterSeconds int    `json:"retryAfterSeconds"`
+}
+
+// queueTicket is a player waiting in (or admitted by) the login queue
+type queueTicket struct {
+	id       string
+	lastSeen int64
+	admitted bool
+}
+
+// loginQueue lets up to threshold logins run at once, the ones after that wait in line (first come, first served),
+// and are admitted as the running ones finish. An admitted ticket holds its place among the running logins until used
+type loginQueue struct {
+	threshold int
+	inFlight  int
+
+	// the tickets waiting to be admitted, in order
+	waiting []*queueTicket
+
+	// all the tickets (waiting and admitted), keyed by id
+	tickets map[string]*queueTicket
+
+	mutex sync.Mutex
+}
+
+// EnableLoginQueueFromEnv enables the login queue with the threshold in the environment (see constants.LoginQueueThresholdEnvVar),
+// it stays disabled if the threshold is not set
+func (as *Server) EnableLoginQueueFromEnv() error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	thresholdEnv := os.Getenv(constants.LoginQueueThresholdEnvVar)
+	if thresholdEnv == "" {
+		return nil
+	}
+
+	threshold, err := strconv.Atoi(thresholdEnv)
+	if err != nil || threshold <= 0 {
+		return fmt.Errorf("%v should be a positive number of logins, got: %v", constants.LoginQueueThresholdEnvVar, thresholdEnv)
+	}
+
+	as.EnableLoginQueue(threshold)
+	return nil
+}
+
+// EnableLoginQueue queues the logins when more than the given number of them are running at once,
+// protecting auth (and the services the client goes to next) from a stampede, like after a maintenance window
+func (as *Server) EnableLoginQueue(threshold int) {
+
+	if as == nil {
+		return
+	}
+
+	as.loginQueue = &loginQueue{threshold: threshold, tickets: map[string]*queueTicket{}}
+	as.logger.Printf("login queue enabled, for more than %v logins at once", threshold)
+}
+
+// enter lets the login with the given ticket (or none) run, and reports true (the caller has to leave the queue when done),
+// or responds with the state of its ticket in the queue, which is a new one if it had none (or an expired one)
+func (lq *loginQueue) enter(ticketID string, unixNow int64) (*LoginQueueResponse, bool, error) {
+
+	lq.mutex.Lock()
+	defer lq.mutex.Unlock()
+
+	lq.update(unixNow)
+
+	ticket, ok := lq.tickets[ticketID]
+	if ok && ticket.admitted {
+		delete(lq.tickets, ticketID)
+		lq.inFlight++
+		return nil, true, nil
+	}
+	if ok {
+		ticket.lastSeen = unixNow
+		return lq.status(ticket), false, nil
+	}
+
+	// nobody is waiting, and there is room (admitted tickets count as running)
+	admitted := len(lq.tickets) - len(lq.waiting)
+	if len(lq.waiting) == 0 && lq.inFlight+admitted < lq.threshold {
+		lq.inFlight++
+		return nil, true, nil
+	}
+
+	if len(lq.waiting) >= maxLoginQueueLength {
+		return nil, false, loginQueueFullError
+	}
+
+	ticket = &queueTicket{id: lq.newTicketID(), lastSeen: unixNow}
+	lq.tickets[ticket.id] = ticket
+	lq.waiting = append(lq.waiting, ticket)
+	return lq.status(ticket), false, nil
+}
+
+// leave is called when a running login is done, which makes room for the next one in line
+func (lq *loginQueue) leave(unixNow int64) {
+
+	lq.mutex.Lock()
+	defer lq.mutex.Unlock()
+
+	lq.inFlight--
+	lq.update(unixNow)
+}
+
+// poll responds with the state of the given ticket, and keeps it from expiring
+func (lq *loginQueue) poll(ticketID string, unixNow int64) (*LoginQueueResponse, error) {
+
+	lq.mutex.Lock()
+	defer lq.mutex.Unlock()
+
+	lq.update(unixNow)
+
+	ticket, ok := lq.tickets[ticketID]
+	if !ok {
+		return nil, LoginQueueTicketNotFoundErr{Ticket: ticketID}
+	}
+
+	ticket.lastSeen = unixNow
+	return lq.status(ticket), nil
+}
+
+// update drops the expired tickets, and admits the tickets at the front of the line while there is room
+// (the queue mutex should be held by the caller)
+func (lq *loginQueue) update(unixNow int64) {
+
+	for ticketID, ticket := range lq.tickets {
+		if unixNow-ticket.lastSeen > loginQueueTicketExpirySeconds {
+			delete(lq.tickets, ticketID)
+		}
+	}
+	lq.waiting = slices.DeleteFunc(lq.waiting, func(ticket *queueTicket) bool {
+		_, ok := lq.tickets[ticket.id]
+		return !ok
+	})
+
+	admitted := len(lq.tickets) - len(lq.waiting)
+	for len(lq.waiting) > 0 && lq.inFlight+admitted < lq.threshold {
+		// the admitted ticket gets a fresh expiry to come back with the login
+		lq.waiting[0].admitted = true
+		lq.waiting[0].lastSeen = unixNow
+		lq.waiting = lq.waiting[1:]
+		admitted++
+	}
+}
+
+// status returns the state of the given ticket (the queue mutex should be held by the caller)
+func (lq *loginQueue) status(ticket *queueTicket) *LoginQueueResponse {
+
+	if ticket.admitted {
+		return &LoginQueueResponse{Ticket: ticket.id, Admitted: true}
+	}
+
+	return &LoginQueueResponse{
+		Ticket:            ticket.id,
+		Position:          slices.Index(lq.waiting, ticket) + 1,
+		RetryAfterSeconds: loginQueuePollSeconds,
+	}
+}
+
+// newTicketID returns a new random ticket id (the queue mutex should be held by the caller)
+func (lq *loginQueue) newTicketID() string {
+
+	for {
+		ticketID := randomHex(sessionVerifierBytes)
+		if _, exists := lq.tickets[ticketID]; !exists {
+			return ticketID
+		}
+	}
+}
+
+// writeLoginQueued responds to a queued login (or a poll) with the state of its ticket
+func (as *Server) writeLoginQueued(w http.ResponseWriter, status int, response *LoginQueueResponse) {
+
+	if response.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(response.RetryAfterSeconds))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		as.logger.Println("error: could not create response: " + err.Error())
+	}
+}
+
+// HandleLoginQueueRequest responds with the state of the requested ticket in the login queue
+func (as *Server) HandleLoginQueueRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	if as.loginQueue == nil {
+		errMsg := "error: the login queue is not enabled"
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		return
+	}
+
+	response, err := as.loginQueue.poll(r.PathValue("ticket"), as.clock.Now().UTC().Unix())
+	if err != nil {
+		errMsg := "login queue error: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		return
+	}
+
+	as.writeLoginQueued(w, http.StatusOK, response)
+}
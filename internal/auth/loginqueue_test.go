@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoginQueue(t *testing.T) {
+
+	lq := &loginQueue{threshold: 2, tickets: map[string]*queueTicket{}}
+
+	// the first two logins run right away, the next two wait in line
+	for i := range 2 {
+		_, admitted, err := lq.enter("", 100)
+		if err != nil || !admitted {
+			t.Fatalf("login %v should have run right away, got: %v, %v", i, admitted, err)
+		}
+	}
+
+	first, admitted, err := lq.enter("", 100)
+	if err != nil || admitted || first.Position != 1 || first.RetryAfterSeconds != loginQueuePollSeconds {
+		t.Fatalf("the third login should be first in line, got: %+v, %v, %v", first, admitted, err)
+	}
+	second, admitted, err := lq.enter("", 100)
+	if err != nil || admitted || second.Position != 2 {
+		t.Fatalf("the fourth login should be second in line, got: %+v, %v, %v", second, admitted, err)
+	}
+
+	// a new login cannot jump the line, even with a made up ticket
+	third, admitted, err := lq.enter("madeUp", 100)
+	if err != nil || admitted || third.Position != 3 {
+		t.Fatalf("a new login should be third in line, got: %+v, %v, %v", third, admitted, err)
+	}
+
+	// a waiting ticket cannot log in yet
+	_, admitted, _ = lq.enter(first.Ticket, 101)
+	if admitted {
+		t.Fatal("a waiting ticket should not be admitted")
+	}
+
+	// a running login finishing admits the first in line
+	lq.leave(102)
+	got, err := lq.poll(first.Ticket, 102)
+	if err != nil || !got.Admitted || got.Position != 0 {
+		t.Fatalf("the first ticket should be admitted, got: %+v, %v", got, err)
+	}
+	got, err = lq.poll(second.Ticket, 102)
+	if err != nil || got.Admitted || got.Position != 1 {
+		t.Fatalf("the second ticket should be first in line, got: %+v, %v", got, err)
+	}
+
+	// the admitted ticket logs in (once)
+	_, admitted, _ = lq.enter(first.Ticket, 103)
+	if !admitted {
+		t.Fatal("the admitted ticket should be let in")
+	}
+	_, err = lq.poll(first.Ticket, 103)
+	if err == nil {
+		t.Fatal("the used ticket should be gone")
+	}
+
+	// the second ticket is abandoned, so the third one moves up, and is admitted once there is room
+	lq.poll(third.Ticket, 100+loginQueueTicketExpirySeconds)
+	got, err = lq.poll(third.Ticket, 103+loginQueueTicketExpirySeconds)
+	if err != nil || got.Position != 1 {
+		t.Fatalf("the third ticket should be first in line, got: %+v, %v", got, err)
+	}
+	_, err = lq.poll(second.Ticket, 103+loginQueueTicketExpirySeconds)
+	if err == nil {
+		t.Fatal("the abandoned ticket should have expired")
+	}
+
+	lq.leave(104 + loginQueueTicketExpirySeconds)
+	got, err = lq.poll(third.Ticket, 104+loginQueueTicketExpirySeconds)
+	if err != nil || !got.Admitted {
+		t.Fatalf("the third ticket should be admitted, got: %+v, %v", got, err)
+	}
+}
+
+func TestServer_HandleLoginQueueRequest(t *testing.T) {
+
+	basic := NewBasicProvider()
+	basic.credentials["test1"] = "pass1"
+	as := NewServer(data.NewServer(), basic)
+	as.EnableLoginQueue(1)
+
+	login := func(ticket string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(&LoginRequestBody{ServerVersion: as.serverVersion, QueueTicket: ticket})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body))
+		req.SetBasicAuth("test1", "pass1")
+		respRec := httptest.NewRecorder()
+		as.HandleLoginRequest(respRec, req)
+		return respRec
+	}
+	poll := func(server *Server, ticket string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/login-queue/"+ticket, nil)
+		req.SetPathValue("ticket", ticket)
+		respRec := httptest.NewRecorder()
+		server.HandleLoginQueueRequest(respRec, req)
+		return respRec
+	}
+
+	// a login holds the only slot, so the next one is queued
+	as.loginQueue.inFlight = 1
+	respRec := login("")
+	if respRec.Code != http.StatusAccepted || respRec.Header().Get("Retry-After") != "5" {
+		t.Fatalf("the login should have been queued, got: %v, retry after: %v", respRec.Code, respRec.Header().Get("Retry-After"))
+	}
+	queued := &LoginQueueResponse{}
+	err := json.NewDecoder(respRec.Body).Decode(queued)
+	if err != nil || queued.Position != 1 || queued.Ticket == "" {
+		t.Fatalf("the queued login should have a ticket first in line, got: %+v, %v", queued, err)
+	}
+
+	tests := []struct {
+		name         string
+		server       *Server
+		ticket       string
+		finishLogin  bool
+		wantStatus   int
+		wantAdmitted bool
+	}{
+		{"nil server", nil, queued.Ticket, false, http.StatusInternalServerError, false},
+		{"queue not enabled", NewServer(data.NewServer()), queued.Ticket, false, http.StatusNotFound, false},
+		{"unknown ticket", as, "unknown", false, http.StatusNotFound, false},
+		{"waiting", as, queued.Ticket, false, http.StatusOK, false},
+		{"admitted", as, queued.Ticket, true, http.StatusOK, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if test.finishLogin {
+				as.loginQueue.leave(as.loginQueue.tickets[queued.Ticket].lastSeen)
+			}
+
+			respRec := poll(test.server, test.ticket)
+			if respRec.Code != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, respRec.Code)
+			}
+
+			if respRec.Code == http.StatusOK {
+				got := &LoginQueueResponse{}
+				err := json.NewDecoder(respRec.Body).Decode(got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Admitted != test.wantAdmitted {
+					t.Errorf("handler gave incorrect results, want admitted: %v, got: %v", test.wantAdmitted, got.Admitted)
+				}
+			}
+		})
+	}
+
+	// the admitted ticket logs in, and frees its slot when done
+	respRec = login(queued.Ticket)
+	if respRec.Code != http.StatusOK {
+		t.Fatalf("the admitted login should succeed, got: %v", respRec.Code)
+	}
+	if as.loginQueue.inFlight != 0 || len(as.loginQueue.tickets) != 0 {
+		t.Errorf("the login queue should be empty, got %v in flight and %v tickets", as.loginQueue.inFlight, len(as.loginQueue.tickets))
+	}
+}
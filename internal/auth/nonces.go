@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// request nonces are this many random bytes, hex encoded
+const requestNonceBytes = 16
+
+var missingNonceError = fmt.Errorf("no request nonce header in the state changing request")
+var invalidNonceError = fmt.Errorf("invalid, expired or already used request nonce")
+
+// requestNonce is a nonce issued for a session, which a single state changing request can use before it expires (unix time)
+type requestNonce struct {
+	nonce      string
+	expiryTime int64
+}
+
+// NonceResponse is the response to the nonce request, with the nonce to send in the request nonce header
+// of the next state changing request, and when it expires (unix time)
+type NonceResponse struct {
+	Nonce      string `json:"nonce"`
+	ExpiryTime int64  `json:"expiryTime"`
+}
+
+// EnableRequestNoncesFromEnv enables request nonces if the request nonces environment variable
+// (see constants.RequestNoncesEnvVar) is set to true, requests are validated by their session alone if it is not set
+func (as *Server) EnableRequestNoncesFromEnv() error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	noncesEnv := os.Getenv(constants.RequestNoncesEnvVar)
+	if noncesEnv == "" {
+		return nil
+	}
+
+	enabled, err := strconv.ParseBool(noncesEnv)
+	if err != nil {
+		return fmt.Errorf("%v should be true or false, got: %v", constants.RequestNoncesEnvVar, noncesEnv)
+	}
+
+	if enabled {
+		as.EnableRequestNonces()
+	}
+	return nil
+}
+
+// EnableRequestNonces makes the validation of state changing (POST / PUT) requests also require an unused nonce
+// issued for their session (in the request nonce header), so a captured request cannot be sent again
+func (as *Server) EnableRequestNonces() {
+
+	if as == nil {
+		return
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	as.requestNonces = true
+	as.logger.Println("request nonces enabled")
+}
+
+// needsNonce reports whether a request with the given method has to carry a nonce
+func (as *Server) needsNonce(method string) bool {
+	return as.requestNonces && (method == http.MethodPost || method == http.MethodPut)
+}
+
+// HandleNonceRequest issues a nonce for the session of the request, to be used by its next state changing request
+func (as *Server) HandleNonceRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	nonce, err := as.IssueNonce(r.Header.Get("Session-Id"), as.clock.Now().UTC().Unix())
+	if err != nil {
+		errMsg := "error: session validation error: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(nonce)
+	if err != nil {
+		errMsg := "error: could not encode nonce: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// IssueNonce issues a new nonce for the given session (at the given unix time), dropping its expired nonces,
+// and its oldest one if it already holds the most unused nonces a session can hold
+// (the nonce request is not itself a state changing request, so it needs no nonce)
+func (as *Server) IssueNonce(sessionID string, unixNow int64) (*NonceResponse, error) {
+
+	if as == nil {
+		return nil, serverNilError
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	session, ok := as.findSession(sessionID)
+	if !ok {
+		return nil, invalidSessionError
+	}
+
+	nonces := session.nonces[:0]
+	for _, nonce := range session.nonces {
+		if nonce.expiryTime > unixNow {
+			nonces = append(nonces, nonce)
+		}
+	}
+	if len(nonces) >= constants.MaxRequestNoncesPerSession {
+		nonces = nonces[len(nonces)-constants.MaxRequestNoncesPerSession+1:]
+	}
+
+	randomBytes := make([]byte, requestNonceBytes)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+
+	issued := requestNonce{nonce: hex.EncodeToString(randomBytes), expiryTime: unixNow + constants.RequestNonceExpirySeconds}
+	session.nonces = append(nonces, issued)
+	session.LastActionTime = unixNow
+
+	return &NonceResponse{Nonce: issued.nonce, ExpiryTime: issued.expiryTime}, nil
+}
+
+// useNonce uses up the given nonce of the given session (at the given unix time), it has to be one which was issued
+// for the session, and has not expired or been used yet (the caller has to hold the auth mutex)
+func (session *SessionData) useNonce(nonce string, unixNow int64) error {
+
+	if nonce == "" {
+		return missingNonceError
+	}
+
+	for i, issued := range session.nonces {
+		if subtle.ConstantTimeCompare([]byte(nonce), []byte(issued.nonce)) != 1 {
+			continue
+		}
+
+		session.nonces = append(session.nonces[:i], session.nonces[i+1:]...)
+		if issued.expiryTime <= unixNow {
+			return invalidNonceError
+		}
+		return nil
+	}
+
+	return invalidNonceError
+}
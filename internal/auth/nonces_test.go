@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_RequestNonces(t *testing.T) {
+
+	as, sID, err := setupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+	as.EnableRequestNonces()
+
+	issueNonce := func(sessionID string) (int, string) {
+		newReq := httptest.NewRequest(http.MethodPost, "/auth/nonce", nil)
+		newReq.Header.Set("Session-Id", sessionID)
+		respRec := httptest.NewRecorder()
+		as.HandleNonceRequest(respRec, newReq)
+
+		nonce := &NonceResponse{}
+		if respRec.Result().StatusCode == http.StatusOK {
+			err2 := json.NewDecoder(respRec.Result().Body).Decode(nonce)
+			if err2 != nil {
+				t.Fatal("could not decode the nonce response body")
+			}
+		}
+		return respRec.Result().StatusCode, nonce.Nonce
+	}
+
+	if gotStatus, _ := issueNonce("testSessionID"); gotStatus != http.StatusUnauthorized {
+		t.Errorf("nonce handler gave incorrect results, want: %v, got: %v", http.StatusUnauthorized, gotStatus)
+	}
+
+	_, nonce := issueNonce(sID)
+	_, otherNonce := issueNonce(sID)
+
+	// an expired nonce is rejected as well
+	_, expiredNonce := issueNonce(sID)
+	as.sessions[sessionSelector(sID)].nonces[2].expiryTime = time.Now().UTC().Unix()
+
+	tests := []struct {
+		name    string
+		method  string
+		nonce   string
+		wantErr error
+	}{
+		{"read without a nonce", http.MethodGet, "", nil},
+		{"write without a nonce", http.MethodPost, "", missingNonceError},
+		{"write with an unknown nonce", http.MethodPut, "testNonce", invalidNonceError},
+		{"write with a nonce", http.MethodPost, nonce, nil},
+		{"replayed write", http.MethodPost, nonce, invalidNonceError},
+		{"write with another nonce", http.MethodPut, otherNonce, nil},
+		{"write with an expired nonce", http.MethodPost, expiredNonce, invalidNonceError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(test.method, "/test/", nil)
+			newReq.Header.Set("Session-Id", sID)
+			if test.nonce != "" {
+				newReq.Header.Set(constants.RequestNonceHeader, test.nonce)
+			}
+
+			_, gotErr := as.ValidateRequest(newReq)
+			if !errors.Is(gotErr, test.wantErr) {
+				t.Errorf("ValidateRequest() gave incorrect results, want: %v, got: %v", test.wantErr, gotErr)
+			}
+		})
+	}
+
+	// the internal validation request checks the method and the nonce passed on from the request being validated
+	_, nonce = issueNonce(sID)
+
+	validate := func(method string, nonce string) int {
+		newReq := httptest.NewRequest(http.MethodPost, "/auth/validation-internal", nil)
+		newReq.Header.Set("Session-Id", sID)
+		if method != "" {
+			newReq.Header.Set(constants.RequestMethodHeader, method)
+		}
+		newReq.Header.Set(constants.RequestNonceHeader, nonce)
+		respRec := httptest.NewRecorder()
+		as.HandleValidateRequest(respRec, newReq)
+		return respRec.Result().StatusCode
+	}
+
+	if gotStatus := validate(http.MethodGet, ""); gotStatus != http.StatusOK {
+		t.Errorf("validation handler gave incorrect results for a read, want: %v, got: %v", http.StatusOK, gotStatus)
+	}
+	if gotStatus := validate("", ""); gotStatus != http.StatusUnauthorized {
+		t.Errorf("validation handler gave incorrect results without a method, want: %v, got: %v", http.StatusUnauthorized, gotStatus)
+	}
+	if gotStatus := validate(http.MethodPost, nonce); gotStatus != http.StatusOK {
+		t.Errorf("validation handler gave incorrect results for a write, want: %v, got: %v", http.StatusOK, gotStatus)
+	}
+	if gotStatus := validate(http.MethodPost, nonce); gotStatus != http.StatusUnauthorized {
+		t.Errorf("validation handler gave incorrect results for a replayed write, want: %v, got: %v", http.StatusUnauthorized, gotStatus)
+	}
+
+	// a session only holds so many unused nonces, the oldest ones are dropped
+	for range constants.MaxRequestNoncesPerSession + 5 {
+		issueNonce(sID)
+	}
+	if got := len(as.sessions[sessionSelector(sID)].nonces); got != constants.MaxRequestNoncesPerSession {
+		t.Errorf("IssueNonce() kept an incorrect number of nonces, want: %v, got: %v", constants.MaxRequestNoncesPerSession, got)
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"example.com/dice-game-backend/internal/shared/clock"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the authorization schemes the providers verify the credentials of
+const SchemeBasic = "Basic"
+const SchemeBearer = "Bearer"
+
+// Provider Errors:
+var usernameTakenError = fmt.Errorf("username already exists, cannot create new user")
+var invalidCredentialsError = fmt.Errorf("invalid credentials")
+
+// MalformedCredentialsErr is returned when the credentials in the authorization header cannot be decoded
+// (the reason never includes the credentials, so it is safe to log)
+type MalformedCredentialsErr struct {
+	Reason string
+}
+
+func (err MalformedCredentialsErr) Error() string {
+	return "cannot decode the given credentials: " + err.Reason
+}
+
+// UnsupportedSchemeErr is returned when no provider verifies the credentials of the scheme in the authorization header
+type UnsupportedSchemeErr struct {
+	Scheme string
+}
+
+func (err UnsupportedSchemeErr) Error() string {
+	return fmt.Sprintf("unsupported authorization scheme: %v", err.Scheme)
+}
+
+// AuthProvider verifies the credentials sent with a login request, for one authorization scheme.
+// Verify gets the credentials (the authorization header without the scheme), and returns the username they belong to,
+// which the player id is generated from. Usernames of providers other than the built-in password one have a
+// "provider:" prefix, so they never clash with each other (or with the password ones, which cannot have a colon)
+type AuthProvider interface {
+	Scheme() string
+	Verify(ctx context.Context, credentials string, isNewUser bool) (string, error)
+}
+
+// ProvidersFromEnv returns the providers for the login request: the built-in username / password one,
+// replaced by an LDAP directory when constants.LDAPURLEnvVar is set, and an external identity provider
+// (with the bearer scheme) when constants.AuthIDPIssuerEnvVar is set
+func ProvidersFromEnv() ([]AuthProvider, error) {
+
+	providers := []AuthProvider{NewBasicProvider()}
+
+	ldapURL := os.Getenv(constants.LDAPURLEnvVar)
+	if ldapURL != "" {
+		provider, err := NewLDAPProvider(ldapURL, os.Getenv(constants.LDAPBindDNEnvVar))
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	issuer := os.Getenv(constants.AuthIDPIssuerEnvVar)
+	if issuer != "" {
+		jwksURL := os.Getenv(constants.AuthIDPJWKSURLEnvVar)
+		clientID := os.Getenv(constants.AuthIDPClientIDEnvVar)
+		if jwksURL == "" || clientID == "" {
+			return nil, fmt.Errorf("the external identity provider needs %v and %v as well", constants.AuthIDPJWKSURLEnvVar, constants.AuthIDPClientIDEnvVar)
+		}
+		providers = append(providers, &IdPProvider{Provider: &IdentityProvider{
+			Name:     "idp",
+			Issuers:  []string{issuer},
+			JWKSURL:  jwksURL,
+			ClientID: clientID,
+		}})
+	}
+
+	return providers, nil
+}
+
+// BasicProvider is the built-in provider, with the usernames and passwords of the players kept in memory,
+// new users register with the credentials they send
+type BasicProvider struct {
+	credentials map[string]string
+	mutex       sync.Mutex
+}
+
+// NewBasicProvider returns an initialized pointer to the username / password provider
+func NewBasicProvider() *BasicProvider {
+	return &BasicProvider{credentials: map[string]string{}}
+}
+
+func (bp *BasicProvider) Scheme() string {
+	return SchemeBasic
+}
+
+// Verify registers the credentials of a new user, or checks them against the registered ones
+func (bp *BasicProvider) Verify(ctx context.Context, credentials string, isNewUser bool) (string, error) {
+
+	usr, pwd, err := decodeBasicCredentials(credentials)
+	if err != nil {
+		return "", err
+	}
+	if usr == "" {
+		return "", MalformedCredentialsErr{Reason: "blank username"}
+	}
+
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	if isNewUser {
+
+		// username should not exist in credentials already
+		_, exists := bp.credentials[usr]
+		if exists {
+			return "", usernameTakenError
+		}
+
+		// add a new entry in the credentials map
+		bp.credentials[usr] = pwd
+		return usr, nil
+	}
+
+	// username should exist in credentials already, and passwords should match
+	password, ok := bp.credentials[usr]
+	if !ok || password != pwd {
+		return "", invalidCredentialsError
+	}
+
+	return usr, nil
+}
+
+// IdPProvider verifies the id tokens of an external identity provider (like a company's single sign on),
+// sent with the bearer scheme, there is nothing to register, so new and existing users are verified the same way.
+// The expiry of the tokens is checked by the clock of the auth server the provider is given to
+type IdPProvider struct {
+	Provider *IdentityProvider
+
+	clock clock.Clock // the system clock if not set
+}
+
+func (ip *IdPProvider) Scheme() string {
+	return SchemeBearer
+}
+
+// Verify checks the id token, and returns the provider's name and subject as the username
+func (ip *IdPProvider) Verify(ctx context.Context, credentials string, isNewUser bool) (string, error) {
+
+	now := time.Now()
+	if ip.clock != nil {
+		now = ip.clock.Now()
+	}
+
+	subject, err := ip.Provider.verify(ctx, credentials, now.UTC())
+	if err != nil {
+		if _, ok := err.(InvalidIDTokenErr); ok {
+			return "", invalidCredentialsError
+		}
+		return "", err
+	}
+
+	return ip.Provider.Name + ":" + subject, nil
+}
+
+// decodeBasicCredentials will take the base 64 encoded credentials of the basic scheme and return a username and password if successful
+// reference: https://en.wikipedia.org/wiki/Basic_access_authentication
+func decodeBasicCredentials(encodedCred string) (string, string, error) {
+
+	// decode the base64 data
+	decodedCred, err := base64.StdEncoding.DecodeString(encodedCred)
+	if err != nil {
+		return "", "", MalformedCredentialsErr{Reason: "invalid base64 data"}
+	}
+
+	// separate the username and password
+	usr, pwd, ok := strings.Cut(string(decodedCred), ":")
+	if !ok {
+		return "", "", MalformedCredentialsErr{Reason: "expected username:password"}
+	}
+
+	return usr, pwd, nil
+}
+
+// splitAuthHeader separates the scheme of the authorization header from the credentials
+func splitAuthHeader(header string) (string, string, error) {
+
+	scheme, credentials, ok := strings.Cut(header, " ")
+	if !ok || credentials == "" {
+		return "", "", MalformedCredentialsErr{Reason: "expected the scheme and the credentials"}
+	}
+
+	return scheme, credentials, nil
+}
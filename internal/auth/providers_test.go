@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/clock"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestLDAPServer runs a directory which only takes binds, for the entries and passwords in the given map
+func newTestLDAPServer(t *testing.T, passwords map[string]string) string {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				_, message, err := readBERElement(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				_, msgID, message, _ := nextBERElement(message)
+				_, bindRequest, _, _ := nextBERElement(message)
+				_, _, bindRequest, _ = nextBERElement(bindRequest)
+				_, dn, bindRequest, _ := nextBERElement(bindRequest)
+				_, password, _, _ := nextBERElement(bindRequest)
+
+				resultCode := int32(ldapResultInvalidCredentials)
+				wantPassword, ok := passwords[string(dn)]
+				if ok && wantPassword == string(password) {
+					resultCode = ldapResultSuccess
+				}
+
+				bindResponse := berElement(ldapBindResp, berInt(berEnumerated, resultCode), berElement(berOctetString), berElement(berOctetString))
+				_, _ = conn.Write(berElement(berSequence, berElement(berInteger, msgID), bindResponse))
+			}()
+		}
+	}()
+
+	return "ldap://" + listener.Addr().String()
+}
+
+func TestServer_HandleLoginRequest_Providers(t *testing.T) {
+
+	ldapURL := newTestLDAPServer(t, map[string]string{
+		"uid=alice,ou=people,dc=example,dc=com":  "secret",
+		"uid=b\\,ob,ou=people,dc=example,dc=com": "secret",
+	})
+	ldapProvider, err := NewLDAPProvider(ldapURL, "uid={username},ou=people,dc=example,dc=com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tip := newTestIdentityProvider(t)
+	idpProvider := &IdPProvider{Provider: tip.provider}
+
+	basic := NewBasicProvider()
+	basic.credentials["alice"] = "password"
+
+	// the ldap provider replaces the built-in one for the basic scheme
+	as := NewServer(data.NewServer(), basic, ldapProvider, idpProvider)
+	basicOnly := NewServer(data.NewServer(), basic)
+
+	// the id tokens expire by the clock of the server, which is two hours ahead
+	aheadServer := NewServer(data.NewServer(), &IdPProvider{Provider: tip.provider})
+	aheadServer.SetClock(clock.NewFrozen(time.Now().Add(2 * time.Hour)))
+
+	basicHeader := func(username string, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	playerID := func(username string) string {
+		pID, err := as.generatePlayerID(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pID
+	}
+
+	tests := []struct {
+		name         string
+		server       *Server
+		header       string
+		isNewUser    bool
+		wantStatus   int
+		wantPlayerID string
+	}{
+		{"ldap user", as, basicHeader("alice", "secret"), false, http.StatusOK, playerID("ldap:alice")},
+		{"ldap user, new on this server", as, basicHeader("alice", "secret"), true, http.StatusOK, playerID("ldap:alice")},
+		{"ldap user, escaped dn", as, basicHeader("b,ob", "secret"), false, http.StatusOK, playerID("ldap:b,ob")},
+		{"ldap user, wrong password", as, basicHeader("alice", "password"), false, http.StatusBadRequest, ""},
+		{"ldap user, empty password", as, basicHeader("alice", ""), false, http.StatusBadRequest, ""},
+		{"built-in user", basicOnly, basicHeader("alice", "password"), false, http.StatusOK, playerID("alice")},
+		{"lower case scheme", basicOnly, "basic " + base64.StdEncoding.EncodeToString([]byte("alice:password")), false, http.StatusOK, playerID("alice")},
+		{"idp user", as, "Bearer " + tip.validToken(t, "subject1"), false, http.StatusOK, playerID("google:subject1")},
+		{"idp user, invalid token", as, "Bearer abc.def.ghi", false, http.StatusBadRequest, ""},
+		{"idp user, expired by the clock of the server", aheadServer, "Bearer " + tip.validToken(t, "subject1"), false, http.StatusBadRequest, ""},
+		{"idp not enabled", basicOnly, "Bearer " + tip.validToken(t, "subject1"), false, http.StatusBadRequest, ""},
+		{"unsupported scheme", as, "Digest abc", false, http.StatusBadRequest, ""},
+		{"no credentials", as, "Basic", false, http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			body, err := json.Marshal(&LoginRequestBody{IsNewUser: test.isNewUser, ServerVersion: test.server.serverVersion})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body))
+			req.Header.Set("Authorization", test.header)
+			respRec := httptest.NewRecorder()
+
+			test.server.HandleLoginRequest(respRec, req)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v, body: %v", test.wantStatus, gotStatus, respRec.Body.String())
+			}
+
+			if gotStatus == http.StatusOK {
+				resp := &LoginResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.PlayerID != test.wantPlayerID {
+					t.Errorf("handler gave incorrect results, want player id: %v, got: %v", test.wantPlayerID, resp.PlayerID)
+				}
+			}
+		})
+	}
+}
+
+func TestEscapeDNValue(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "alice", "alice"},
+		{"separators", "a,b+c;d", "a\\,b\\+c\\;d"},
+		{"injection", "x,ou=admins", "x\\,ou\\=admins"},
+		{"leading hash and trailing space", "#a ", "\\#a\\ "},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := escapeDNValue(test.value)
+			if got != test.want {
+				t.Errorf("escapeDNValue() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
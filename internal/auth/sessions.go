@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxDeviceInfoLength is the maximum length of the device and user agent recorded for a session
+const maxDeviceInfoLength = 128
+
+type SessionNotFoundErr struct {
+	PublicID string
+}
+
+func (err SessionNotFoundErr) Error() string {
+	return fmt.Sprintf("session with id: %v was not found among the player's sessions", err.PublicID)
+}
+
+// SessionInfo describes an active session of a player (the response of the sessions request lists them),
+// identified by its public id, and marked as current if it is the session the request was made with
+type SessionInfo struct {
+	ID             string `json:"id"`
+	Device         string `json:"device"`
+	UserAgent      string `json:"userAgent"`
+	IP             string `json:"ip"`
+	LoginTime      int64  `json:"loginTime"`
+	LastActionTime int64  `json:"lastActionTime"`
+	IdleSeconds    int64  `json:"idleSeconds"`
+	Current        bool   `json:"current"`
+}
+
+// HeartbeatResponse is the response to a heartbeat, with how long the session had been idle before it,
+// and when the session expires (unix time) if it stays idle from now on
+type HeartbeatResponse struct {
+	IdleSeconds int64 `json:"idleSeconds"`
+	ExpiryTime  int64 `json:"expiryTime"`
+}
+
+// HandleHeartbeatRequest keeps the session of the request alive, for clients which are open but idle
+// (like on a menu), and responds with how long it had been idle
+func (as *Server) HandleHeartbeatRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	heartbeat, err := as.Heartbeat(r.Header.Get("Session-Id"), as.clock.Now().UTC().Unix())
+	if err != nil {
+		errMsg := "error: session validation error: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(heartbeat)
+	if err != nil {
+		errMsg := "error: could not encode heartbeat: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// Heartbeat marks the given session as active at the given unix time (like any validated request does),
+// and returns how long it had been idle before that
+func (as *Server) Heartbeat(sessionID string, unixNow int64) (*HeartbeatResponse, error) {
+
+	if as == nil {
+		return nil, serverNilError
+	}
+
+	if sessionID == "" {
+		return nil, missingSessionIDError
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	session, ok := as.findSession(sessionID)
+	if !ok {
+		return nil, invalidSessionError
+	}
+
+	idleSeconds := max(unixNow-session.LastActionTime, 0)
+	session.LastActionTime = unixNow
+
+	return &HeartbeatResponse{IdleSeconds: idleSeconds, ExpiryTime: unixNow + sessionExpirySeconds}, nil
+}
+
+// HandleListSessionsRequest responds with all the active sessions of the player making the request, oldest first
+func (as *Server) HandleListSessionsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	sessions, err := as.PlayerSessions(r.Header.Get("Session-Id"))
+	if err != nil {
+		errMsg := "error: could not list sessions: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(sessions)
+	if err != nil {
+		errMsg := "error: could not encode sessions: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandleRevokeSessionRequest signs out the session with the given public id, which has to be one of the sessions
+// of the player making the request (like one on another device, signing out the current session is a logout)
+func (as *Server) HandleRevokeSessionRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	publicID := r.PathValue("id")
+	as.logger.Printf("received sign out request for session: %v", publicID)
+
+	revoked, err := as.RevokeSession(r.Header.Get("Session-Id"), publicID)
+	if err != nil {
+		errMsg := "error: could not sign out session: " + err.Error()
+		as.logger.Println(errMsg)
+		switch err.(type) {
+		case SessionNotFoundErr:
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		default:
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		}
+		return
+	}
+
+	as.auditRecorder.Record(r.Context(), revoked.PlayerID, audit.ActionSignOut, revoked.PlayerID, map[string]string{"device": revoked.Device, "userAgent": revoked.UserAgent, "ip": revoked.IP})
+
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+}
+
+// HandleRevokePlayerSessionsRequest signs out all the sessions of the player in the path (used by the profile service
+// once the player id is retired by a merge)
+func (as *Server) HandleRevokePlayerSessionsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	playerID := r.PathValue("id")
+	as.logger.Printf("received request to sign out all the sessions of player id: %v", playerID)
+
+	err := as.RevokePlayerSessions(r.Context(), playerID)
+	if err != nil {
+		errMsg := "error: could not sign out the sessions: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		as.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// RevokePlayerSessions signs out all the active sessions of the given player (if any), like when their player id
+// is retired by a merge, so the sessions do not keep passing validation for a player which is gone
+func (as *Server) RevokePlayerSessions(ctx context.Context, playerID string) error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	if playerID == "" {
+		return fmt.Errorf("cannot sign out the sessions of a blank player id")
+	}
+
+	as.deletePlayerSessions(playerID)
+	return nil
+}
+
+// PlayerSessions returns all the active sessions of the player the given session belongs to, oldest first
+func (as *Server) PlayerSessions(sessionID string) ([]SessionInfo, error) {
+
+	if as == nil {
+		return nil, serverNilError
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	current, ok := as.findSession(sessionID)
+	if !ok {
+		return nil, invalidSessionError
+	}
+
+	unixNow := as.clock.Now().UTC().Unix()
+	sessions := []SessionInfo{}
+	for _, sID := range as.playerSessions[current.PlayerID] {
+		session, ok := as.sessions[sID]
+		if !ok {
+			continue
+		}
+
+		sessions = append(sessions, SessionInfo{
+			ID:             session.PublicID,
+			Device:         session.Device,
+			UserAgent:      session.UserAgent,
+			IP:             session.IP,
+			LoginTime:      session.LoginTime,
+			LastActionTime: session.LastActionTime,
+			IdleSeconds:    max(unixNow-session.LastActionTime, 0),
+			Current:        sID == current.SessionID,
+		})
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession deletes the session with the given public id, if it belongs to the same player as the given session,
+// and returns a copy of the deleted session
+func (as *Server) RevokeSession(sessionID string, publicID string) (*SessionData, error) {
+
+	if as == nil {
+		return nil, serverNilError
+	}
+
+	as.authMutex.Lock()
+	current, ok := as.findSession(sessionID)
+	if !ok {
+		as.authMutex.Unlock()
+		return nil, invalidSessionError
+	}
+
+	var revoked *SessionData
+	for _, sID := range as.playerSessions[current.PlayerID] {
+		if session, ok := as.sessions[sID]; ok && session.PublicID == publicID {
+			sessionCopy := *session
+			revoked = &sessionCopy
+			break
+		}
+	}
+	as.authMutex.Unlock()
+
+	if revoked == nil {
+		return nil, SessionNotFoundErr{PublicID: publicID}
+	}
+
+	_, err := as.deleteSession(revoked.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
+
+// newPublicSessionID returns a random id to show a session to the player with (the session id itself is a secret,
+// and is not derived from it, since session ids are based on the login time, which is shown as well)
+func newPublicSessionID() string {
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+	return hex.EncodeToString(randomBytes)
+}
+
+// clientIP returns the ip address a request came from
+func clientIP(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// truncateDeviceInfo cuts the given device info (set by the client) down to the maximum length recorded
+func truncateDeviceInfo(info string) string {
+
+	if len(info) <= maxDeviceInfoLength {
+		return info
+	}
+
+	// the cut can land in the middle of a multi byte character
+	return strings.ToValidUTF8(info[:maxDeviceInfoLength], "")
+}
@@ -0,0 +1,562 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the supported identity providers
+const (
+	ProviderGoogle = "google"
+	ProviderApple  = "apple"
+)
+
+// jwksFetchTimeout is how long fetching the signing keys of an identity provider can take
+const jwksFetchTimeout = 10 * time.Second
+
+// jwksMinRefetchSeconds is the minimum time between fetching the signing keys of a provider again because a token was
+// signed with an unknown key (providers rotate their keys), so tokens with made up key ids cannot flood the provider
+const jwksMinRefetchSeconds = 60
+
+var socialLoginDisabledError = fmt.Errorf("social login is not enabled")
+
+// jwksClient is used to fetch the signing keys of the identity providers, which are external
+// (so it does not send the service tokens and namespace of the internal requests)
+var jwksClient = &http.Client{Timeout: jwksFetchTimeout}
+
+type UnknownProviderErr struct {
+	Provider string
+}
+
+func (err UnknownProviderErr) Error() string {
+	return fmt.Sprintf("identity provider: %v is unknown or not enabled", err.Provider)
+}
+
+type InvalidIDTokenErr struct {
+	Reason string
+}
+
+func (err InvalidIDTokenErr) Error() string {
+	return fmt.Sprintf("invalid id token: %v", err.Reason)
+}
+
+type AccountAlreadyLinkedErr struct {
+	Provider string
+}
+
+func (err AccountAlreadyLinkedErr) Error() string {
+	return fmt.Sprintf("the %v account is already linked to a player", err.Provider)
+}
+
+// IdentityProvider describes an OpenID Connect identity provider whose id tokens can be used to log in:
+// tokens have to be signed with one of the keys from its JWKS url (RS256), issued by one of its issuers,
+// and meant for the game's client id
+type IdentityProvider struct {
+	Name     string
+	Issuers  []string
+	JWKSURL  string
+	ClientID string
+
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt int64
+	keysMutex     sync.Mutex
+}
+
+// SocialLoginRequestBody is used as the request body of the social login request, the id token is the one the client
+// got from signing in with the provider (the device, two factor code, client version and platform are as in the basic login request)
+type SocialLoginRequestBody struct {
+	Provider      string `json:"provider"`
+	IDToken       string `json:"idToken"`
+	Device        string `json:"device"`
+	TwoFactorCode string `json:"twoFactorCode"`
+	ClientVersion string `json:"clientVersion"`
+	Platform      string `json:"platform"`
+}
+
+// SocialLoginResponse is the response of the social login request, unlike the basic login, the server decides
+// whether it is a new user (the first login with that provider account), so the client knows to create the player
+type SocialLoginResponse struct {
+	PlayerID      string `json:"playerID"`
+	ServerVersion string `json:"serverVersion"`
+	IsNewUser     bool   `json:"isNewUser"`
+}
+
+// LinkAccountRequestBody is used as the request body of the request to link a provider account to the player
+type LinkAccountRequestBody struct {
+	Provider string `json:"provider"`
+	IDToken  string `json:"idToken"`
+}
+
+// jsonWebKey is a (RSA) key from the JWKS of an identity provider
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// idTokenClaims are the claims of an id token which are checked
+type idTokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// audience is the aud claim of a token, which can be a single string or a list of them
+type audience []string
+
+func (aud *audience) UnmarshalJSON(encoded []byte) error {
+
+	var single string
+	if json.Unmarshal(encoded, &single) == nil {
+		*aud = audience{single}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(encoded, &list)
+	if err != nil {
+		return err
+	}
+	*aud = list
+	return nil
+}
+
+// EnableSocialLoginFromEnv enables sign in with Google and / or Apple, for the providers whose client ids are set
+// in the environment (see constants.GoogleClientIDEnvVar and constants.AppleClientIDEnvVar)
+func (as *Server) EnableSocialLoginFromEnv() error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	clientID := os.Getenv(constants.GoogleClientIDEnvVar)
+	if clientID != "" {
+		as.EnableIdentityProvider(&IdentityProvider{
+			Name:     ProviderGoogle,
+			Issuers:  []string{"https://accounts.google.com", "accounts.google.com"},
+			JWKSURL:  "https://www.googleapis.com/oauth2/v3/certs",
+			ClientID: clientID,
+		})
+	}
+
+	clientID = os.Getenv(constants.AppleClientIDEnvVar)
+	if clientID != "" {
+		as.EnableIdentityProvider(&IdentityProvider{
+			Name:     ProviderApple,
+			Issuers:  []string{"https://appleid.apple.com"},
+			JWKSURL:  "https://appleid.apple.com/auth/keys",
+			ClientID: clientID,
+		})
+	}
+
+	return nil
+}
+
+// EnableIdentityProvider lets players log in with (and link their accounts to) the given identity provider
+func (as *Server) EnableIdentityProvider(provider *IdentityProvider) {
+
+	if as == nil {
+		return
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	as.identityProviders[provider.Name] = provider
+	as.logger.Printf("social login enabled for provider: %v", provider.Name)
+}
+
+// HandleSocialLoginRequest logs a player in with an id token from an identity provider, the first login with
+// a provider account creates a new player (unless the account was linked to an existing player before)
+func (as *Server) HandleSocialLoginRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	slrb := &SocialLoginRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(slrb)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	as.logger.Printf("received social login request, provider: %v", slrb.Provider)
+
+	if !as.checkClientVersion(w, r, slrb.Platform, slrb.ClientVersion) {
+		return
+	}
+
+	subject, err := as.verifyIDToken(r.Context(), slrb.Provider, slrb.IDToken)
+	if err != nil {
+		as.writeSocialLoginError(w, r, err)
+		return
+	}
+
+	// the player of a provider account is the one it is linked to, or else a new one with an id derived from it
+	// (the ':' keeps it apart from the ids derived from usernames, since usernames cannot contain one)
+	linkKey := slrb.Provider + ":" + subject
+
+	as.authMutex.Lock()
+	pID, linked := as.socialLinks[linkKey]
+	as.authMutex.Unlock()
+
+	if !linked {
+		pID, err = as.generatePlayerID(linkKey)
+		if err != nil {
+			errMsg := "error: could not generate player id: " + err.Error()
+			as.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			return
+		}
+	}
+
+	// a new user from the server's point of view, if the provider account was never seen since the server started
+	isNewUser := !linked
+
+	// a player id retired by a merge logs in to the player it was merged into (and the account is linked to it)
+	lookedUpID := pID
+	pID, err = as.followRedirect(r.Context(), pID)
+	if err != nil {
+		errMsg := "error: could not check player redirect: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// banned / suspended players cannot log in
+	ban, err := as.dataClient.ReadBan(r.Context(), pID)
+	if err != nil && !errors.Is(err, data.BanNotFoundErr{PlayerID: pID}) {
+		errMsg := "error: could not check ban status: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+	if err == nil && ban.IsActive(as.clock.Now().UTC().Unix()) {
+		errMsg := fmt.Sprintf("error: player is banned, reason: %v, expiry time: %v", ban.Reason, ban.ExpiryTime)
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeBanned, "{reason}", ban.Reason, "{expiryTime}", strconv.FormatInt(ban.ExpiryTime, 10))
+		return
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	// players with two factor authentication enabled also need a valid code
+	err = as.checkTwoFactorLogin(pID, slrb.TwoFactorCode, as.clock.Now())
+	if err != nil {
+		errMsg := "error: two factor check failed: " + err.Error()
+		as.logger.Println(errMsg)
+		as.writeTwoFactorError(w, r, pID, err)
+		return
+	}
+
+	// the provider account could have been linked to another player since it was looked up
+	if linkedID, ok := as.socialLinks[linkKey]; ok && linkedID != lookedUpID {
+		errMsg := "error: the account was linked to another player during login"
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict)
+		return
+	}
+	as.socialLinks[linkKey] = pID
+
+	sID := as.startSession(r, pID, slrb.Device)
+
+	as.auditRecorder.Record(r.Context(), pID, audit.ActionLogin, pID, map[string]any{"isNewUser": isNewUser, "provider": slrb.Provider})
+
+	// provide the session id in the response header
+	w.Header().Set("Session-Id", sID)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(w).Encode(&SocialLoginResponse{pID, as.serverVersion, isNewUser})
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+}
+
+// HandleLinkAccountRequest links a provider account to the player making the request, after which logging in with
+// that provider account reaches the same player (as the basic login, or the provider account logged in with before)
+func (as *Server) HandleLinkAccountRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	playerID, ok := playerctx.PlayerID(r.Context())
+	if !ok {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		return
+	}
+
+	// decode the request
+	larb := &LinkAccountRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(larb)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	as.logger.Printf("received link account request for id: %v, provider: %v", playerID, larb.Provider)
+
+	subject, err := as.verifyIDToken(r.Context(), larb.Provider, larb.IDToken)
+	if err != nil {
+		as.writeSocialLoginError(w, r, err)
+		return
+	}
+
+	err = as.LinkAccount(playerID, larb.Provider, subject)
+	if err != nil {
+		errMsg := "error: could not link account: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict)
+		return
+	}
+
+	as.auditRecorder.Record(r.Context(), playerID, audit.ActionAccountLink, playerID, map[string]string{"provider": larb.Provider})
+
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+}
+
+// LinkAccount links the provider account with the given subject to the player,
+// a provider account which already belongs to a player (by logging in with it, or linking) cannot be linked
+func (as *Server) LinkAccount(playerID string, provider string, subject string) error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	linkKey := provider + ":" + subject
+	if _, ok := as.socialLinks[linkKey]; ok {
+		return AccountAlreadyLinkedErr{Provider: provider}
+	}
+
+	as.socialLinks[linkKey] = playerID
+	return nil
+}
+
+// writeSocialLoginError writes the response for an error verifying an id token
+func (as *Server) writeSocialLoginError(w http.ResponseWriter, r *http.Request, err error) {
+
+	errMsg := "error: could not verify the id token: " + err.Error()
+	as.logger.Println(errMsg)
+
+	if err == socialLoginDisabledError {
+		apierror.Write(w, r, http.StatusServiceUnavailable, apierror.CodeUnavailable)
+		return
+	}
+
+	switch err.(type) {
+	case UnknownProviderErr:
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+	case InvalidIDTokenErr:
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSocialLogin)
+	default:
+		apierror.Write(w, r, http.StatusBadGateway, apierror.CodeUnavailable)
+	}
+}
+
+// verifyIDToken verifies the given id token of the provider, and returns the subject (the provider's id of the user)
+func (as *Server) verifyIDToken(ctx context.Context, providerName string, token string) (string, error) {
+
+	as.authMutex.Lock()
+	provider, ok := as.identityProviders[providerName]
+	enabled := len(as.identityProviders) > 0
+	as.authMutex.Unlock()
+
+	if !enabled {
+		return "", socialLoginDisabledError
+	}
+	if !ok {
+		return "", UnknownProviderErr{Provider: providerName}
+	}
+
+	return provider.verify(ctx, token, as.clock.Now().UTC())
+}
+
+// verify checks the signature (RS256) and the claims of the given id token, and returns its subject
+func (provider *IdentityProvider) verify(ctx context.Context, token string, timeNow time.Time) (string, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", InvalidIDTokenErr{Reason: "malformed token"}
+	}
+
+	header := struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}{}
+	err := decodeTokenPart(parts[0], &header)
+	if err != nil {
+		return "", InvalidIDTokenErr{Reason: "malformed header"}
+	}
+	if header.Algorithm != "RS256" {
+		return "", InvalidIDTokenErr{Reason: "unsupported algorithm: " + header.Algorithm}
+	}
+
+	key, err := provider.signingKey(ctx, header.KeyID, timeNow)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", InvalidIDTokenErr{Reason: "malformed signature"}
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return "", InvalidIDTokenErr{Reason: "invalid signature"}
+	}
+
+	claims := &idTokenClaims{}
+	err = decodeTokenPart(parts[1], claims)
+	if err != nil {
+		return "", InvalidIDTokenErr{Reason: "malformed claims"}
+	}
+
+	switch {
+	case !slices.Contains(provider.Issuers, claims.Issuer):
+		return "", InvalidIDTokenErr{Reason: "unexpected issuer: " + claims.Issuer}
+	case !slices.Contains(claims.Audience, provider.ClientID):
+		return "", InvalidIDTokenErr{Reason: "token is meant for another client"}
+	case claims.ExpiresAt <= timeNow.Unix():
+		return "", InvalidIDTokenErr{Reason: "token has expired"}
+	case claims.Subject == "":
+		return "", InvalidIDTokenErr{Reason: "no subject"}
+	}
+
+	return claims.Subject, nil
+}
+
+// signingKey returns the provider's key with the given id, fetching the provider's keys if they are stale,
+// or if the key is not among them (at most once every jwksMinRefetchSeconds)
+func (provider *IdentityProvider) signingKey(ctx context.Context, keyID string, timeNow time.Time) (*rsa.PublicKey, error) {
+
+	provider.keysMutex.Lock()
+	defer provider.keysMutex.Unlock()
+
+	unixNow := timeNow.Unix()
+	key, ok := provider.keys[keyID]
+	stale := unixNow-provider.keysFetchedAt >= constants.JWKSRefreshMinutes*60
+	if ok && !stale {
+		return key, nil
+	}
+
+	if stale || unixNow-provider.keysFetchedAt >= jwksMinRefetchSeconds {
+		keys, err := fetchJWKS(ctx, provider.JWKSURL)
+		if err != nil {
+			// keep using the keys fetched before, if the provider cannot be reached
+			if ok {
+				return key, nil
+			}
+			return nil, fmt.Errorf("could not fetch the signing keys of %v: %v", provider.Name, err)
+		}
+
+		provider.keys = keys
+		provider.keysFetchedAt = unixNow
+		key, ok = provider.keys[keyID]
+	}
+
+	if !ok {
+		return nil, InvalidIDTokenErr{Reason: "unknown signing key: " + keyID}
+	}
+	return key, nil
+}
+
+// fetchJWKS fetches the (RSA) keys from the given JWKS url, keyed by their key ids
+func fetchJWKS(ctx context.Context, jwksURL string) (map[string]*rsa.PublicKey, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := jwksClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %v", resp.StatusCode)
+	}
+
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("malformed key: %v", jwk.KeyID)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("malformed key: %v", jwk.KeyID)
+		}
+
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	return keys, nil
+}
+
+// decodeTokenPart decodes the given (base64url encoded json) part of a token into the given value
+func decodeTokenPart(part string, value any) error {
+
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, value)
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdentityProvider runs a JWKS server with a single test key, and signs id tokens with it
+type testIdentityProvider struct {
+	key      *rsa.PrivateKey
+	server   *httptest.Server
+	provider *IdentityProvider
+}
+
+func newTestIdentityProvider(t *testing.T) *testIdentityProvider {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			KeyType: "RSA",
+			KeyID:   "key1",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(server.Close)
+
+	return &testIdentityProvider{
+		key:    key,
+		server: server,
+		provider: &IdentityProvider{
+			Name:     ProviderGoogle,
+			Issuers:  []string{"https://accounts.google.com"},
+			JWKSURL:  server.URL,
+			ClientID: "testClient",
+		},
+	}
+}
+
+// token returns an id token with the given header and claims, signed with the test key
+func (tip *testIdentityProvider) token(t *testing.T, header map[string]any, claims map[string]any) string {
+
+	encode := func(value any) string {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(encoded)
+	}
+
+	signingInput := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, tip.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validToken returns a valid id token for the given subject
+func (tip *testIdentityProvider) validToken(t *testing.T, subject string) string {
+	return tip.token(t, map[string]any{"alg": "RS256", "kid": "key1"}, map[string]any{
+		"iss": "https://accounts.google.com", "sub": subject, "aud": "testClient", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+}
+
+func TestIdentityProvider_Verify(t *testing.T) {
+
+	tip := newTestIdentityProvider(t)
+	validHeader := map[string]any{"alg": "RS256", "kid": "key1"}
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	validToken := tip.validToken(t, "subject1")
+	parts := strings.Split(validToken, ".")
+
+	tests := []struct {
+		name        string
+		token       string
+		wantSubject string
+		wantErr     bool
+	}{
+		{"valid token", validToken, "subject1", false},
+		{"audience list", tip.token(t, validHeader, map[string]any{"iss": "https://accounts.google.com", "sub": "subject2", "aud": []string{"other", "testClient"}, "exp": expiry}), "subject2", false},
+		{"malformed token", "abc.def", "", true},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"subject3"}`)) + "." + parts[2], "", true},
+		{"unsupported algorithm", tip.token(t, map[string]any{"alg": "none", "kid": "key1"}, map[string]any{"iss": "https://accounts.google.com", "sub": "subject1", "aud": "testClient", "exp": expiry}), "", true},
+		{"unknown key", tip.token(t, map[string]any{"alg": "RS256", "kid": "key2"}, map[string]any{"iss": "https://accounts.google.com", "sub": "subject1", "aud": "testClient", "exp": expiry}), "", true},
+		{"wrong issuer", tip.token(t, validHeader, map[string]any{"iss": "https://example.com", "sub": "subject1", "aud": "testClient", "exp": expiry}), "", true},
+		{"wrong audience", tip.token(t, validHeader, map[string]any{"iss": "https://accounts.google.com", "sub": "subject1", "aud": "otherClient", "exp": expiry}), "", true},
+		{"expired", tip.token(t, validHeader, map[string]any{"iss": "https://accounts.google.com", "sub": "subject1", "aud": "testClient", "exp": time.Now().Add(-time.Minute).Unix()}), "", true},
+		{"no subject", tip.token(t, validHeader, map[string]any{"iss": "https://accounts.google.com", "aud": "testClient", "exp": expiry}), "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotSubject, err := tip.provider.verify(t.Context(), test.token, time.Now())
+			if (err != nil) != test.wantErr {
+				t.Fatalf("verify() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if gotSubject != test.wantSubject {
+				t.Errorf("verify() gave incorrect results, want subject: %v, got: %v", test.wantSubject, gotSubject)
+			}
+		})
+	}
+}
+
+// socialLogin logs in with the given id token, and returns the response status, session id and response
+func socialLogin(t *testing.T, as *Server, provider string, idToken string) (int, string, *SocialLoginResponse) {
+
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(&SocialLoginRequestBody{Provider: provider, IDToken: idToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReq := httptest.NewRequest(http.MethodPost, "/auth/social-login", buf)
+	respRec := httptest.NewRecorder()
+
+	as.HandleSocialLoginRequest(respRec, newReq)
+
+	resp := &SocialLoginResponse{}
+	if respRec.Result().StatusCode == http.StatusOK {
+		err = json.NewDecoder(respRec.Body).Decode(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return respRec.Result().StatusCode, respRec.Header().Get("Session-Id"), resp
+}
+
+func TestServer_HandleSocialLoginRequest(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+	tip := newTestIdentityProvider(t)
+
+	// social login is not available until a provider is enabled
+	if gotStatus, _, _ := socialLogin(t, as, ProviderGoogle, tip.validToken(t, "subject1")); gotStatus != http.StatusServiceUnavailable {
+		t.Errorf("incorrect status before enabling, want: %v, got: %v", http.StatusServiceUnavailable, gotStatus)
+	}
+
+	as.EnableIdentityProvider(tip.provider)
+
+	tests := []struct {
+		name          string
+		provider      string
+		idToken       string
+		wantStatus    int
+		wantIsNewUser bool
+	}{
+		{"unknown provider", ProviderApple, tip.validToken(t, "subject1"), http.StatusBadRequest, false},
+		{"invalid token", ProviderGoogle, "abc.def.ghi", http.StatusUnauthorized, false},
+		{"first login", ProviderGoogle, tip.validToken(t, "subject1"), http.StatusOK, true},
+		{"second login", ProviderGoogle, tip.validToken(t, "subject1"), http.StatusOK, false},
+		{"another account", ProviderGoogle, tip.validToken(t, "subject2"), http.StatusOK, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotStatus, gotSessionID, gotResp := socialLogin(t, as, test.provider, test.idToken)
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+			if gotStatus != http.StatusOK {
+				return
+			}
+
+			if gotResp.IsNewUser != test.wantIsNewUser {
+				t.Errorf("handler gave incorrect results, want new user: %v, got: %v", test.wantIsNewUser, gotResp.IsNewUser)
+			}
+			if session, ok := as.sessions[sessionSelector(gotSessionID)]; !ok || session.PlayerID != gotResp.PlayerID {
+				t.Errorf("no session was created for the player")
+			}
+		})
+	}
+}
+
+func TestServer_HandleLinkAccountRequest(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+	tip := newTestIdentityProvider(t)
+	as.EnableIdentityProvider(tip.provider)
+
+	sID := loginTestDevice(t, as, "user1", true, "phone")
+	pID := as.sessions[sessionSelector(sID)].PlayerID
+
+	// an account which was already used to log in belongs to its own player
+	_, _, _ = socialLogin(t, as, ProviderGoogle, tip.validToken(t, "subject2"))
+
+	tests := []struct {
+		name       string
+		sessionID  string
+		idToken    string
+		wantStatus int
+	}{
+		{"invalid session", "test", tip.validToken(t, "subject1"), http.StatusUnauthorized},
+		{"invalid token", sID, "abc.def.ghi", http.StatusUnauthorized},
+		{"link", sID, tip.validToken(t, "subject1"), http.StatusOK},
+		{"link again", sID, tip.validToken(t, "subject1"), http.StatusConflict},
+		{"account of another player", sID, tip.validToken(t, "subject2"), http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err := json.NewEncoder(buf).Encode(&LinkAccountRequestBody{Provider: ProviderGoogle, IDToken: test.idToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/auth/link", buf)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			withSession(as, as.HandleLinkAccountRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	// the linked account now reaches the basic auth player
+	gotStatus, _, gotResp := socialLogin(t, as, ProviderGoogle, tip.validToken(t, "subject1"))
+	if gotStatus != http.StatusOK || gotResp.PlayerID != pID || gotResp.IsNewUser {
+		t.Errorf("social login gave incorrect results, want player: %v, got status: %v, response: %+v", pID, gotStatus, gotResp)
+	}
+}
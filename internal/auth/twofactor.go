@@ -0,0 +1,386 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// two factor authentication (TOTP, RFC 6238) related constants
+const totpPeriodSeconds int64 = 30
+const totpSkewSteps int64 = 1 // codes of the steps right before and after the current one are accepted too (clock drift)
+const totpSecretBytes = 20
+const recoveryCodeCount = 8
+const twoFactorIssuer = "DiceGame"
+
+// after this many invalid codes in a row, the codes of the player are not checked at all for the lockout duration
+// (three codes are valid at any time, so guessing one would otherwise only take about 333k attempts)
+const twoFactorMaxFailedCodes = 5
+const twoFactorLockoutSeconds int64 = 15 * 60
+
+var twoFactorRequiredError = fmt.Errorf("a two factor code is required")
+var invalidTwoFactorCodeError = fmt.Errorf("invalid two factor code")
+var twoFactorEnabledError = fmt.Errorf("two factor authentication is already enabled")
+var twoFactorNotEnrolledError = fmt.Errorf("two factor authentication is not enrolled")
+var twoFactorNotEnabledError = fmt.Errorf("two factor authentication is not enabled")
+var twoFactorLockedError = fmt.Errorf("two factor verification is locked after too many invalid codes")
+var twoFactorLockoutStartedError = fmt.Errorf("too many invalid two factor codes: %w", twoFactorLockedError)
+
+// base32 without padding, as used by authenticator apps
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// twoFactorData is the two factor state of a player: the TOTP secret, the hashes of the unused recovery codes,
+// the last time step a code was accepted for (so the same code cannot be used twice), and the number of invalid
+// codes sent since the last valid one, with the time the verification is locked till after too many of them
+type twoFactorData struct {
+	secret             []byte
+	enabled            bool
+	recoveryCodeHashes []string
+	lastUsedStep       int64
+	failedCodes        int
+	lockedUntil        int64
+}
+
+// TwoFactorEnrollment is the response of the enroll request: the secret for the authenticator app
+// (also as an otpauth uri, to be shown as a QR code), and the recovery codes, which are only shown this once
+type TwoFactorEnrollment struct {
+	Secret        string   `json:"secret"`
+	OTPAuthURI    string   `json:"otpauthURI"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TwoFactorCodeBody is used as the request body for the requests to confirm or disable two factor authentication
+type TwoFactorCodeBody struct {
+	Code string `json:"code"`
+}
+
+// HandleEnrollTwoFactorRequest starts two factor enrollment for the player making the request,
+// responding with a new secret and recovery codes. It is only enabled once a code is confirmed
+func (as *Server) HandleEnrollTwoFactorRequest(w http.ResponseWriter, r *http.Request) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	playerID, ok := playerctx.PlayerID(r.Context())
+	if !ok {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		return
+	}
+
+	as.logger.Printf("two factor enroll request for id: %v", playerID)
+
+	enrollment, err := as.EnrollTwoFactor(playerID)
+	if err != nil {
+		errMsg := "error: could not enroll two factor authentication: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(enrollment)
+	if err != nil {
+		errMsg := "error: could not encode two factor enrollment: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandleConfirmTwoFactorRequest enables two factor authentication for the player making the request,
+// once they send a valid code from their authenticator app
+func (as *Server) HandleConfirmTwoFactorRequest(w http.ResponseWriter, r *http.Request) {
+	as.handleTwoFactorChange(w, r, true)
+}
+
+// HandleDisableTwoFactorRequest disables two factor authentication for the player making the request,
+// which needs a valid code (or a recovery code)
+func (as *Server) HandleDisableTwoFactorRequest(w http.ResponseWriter, r *http.Request) {
+	as.handleTwoFactorChange(w, r, false)
+}
+
+// handleTwoFactorChange enables or disables two factor authentication for the player making the request
+func (as *Server) handleTwoFactorChange(w http.ResponseWriter, r *http.Request, enable bool) {
+
+	if as == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	playerID, ok := playerctx.PlayerID(r.Context())
+	if !ok {
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		return
+	}
+
+	// decode the request body, which should be a TwoFactorCodeBody struct
+	decodedReq := &TwoFactorCodeBody{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	as.logger.Printf("two factor change request for id: %v, enable: %v", playerID, enable)
+
+	action := audit.ActionTwoFactorEnable
+	if enable {
+		err = as.ConfirmTwoFactor(playerID, decodedReq.Code, as.clock.Now())
+	} else {
+		action = audit.ActionTwoFactorDisable
+		err = as.DisableTwoFactor(playerID, decodedReq.Code, as.clock.Now())
+	}
+	if err != nil {
+		errMsg := "error: could not change two factor authentication: " + err.Error()
+		as.logger.Println(errMsg)
+		if err == invalidTwoFactorCodeError || errors.Is(err, twoFactorLockedError) {
+			as.writeTwoFactorError(w, r, playerID, err)
+		} else {
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		}
+		return
+	}
+
+	as.auditRecorder.Record(r.Context(), playerID, action, playerID, nil)
+
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		as.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+}
+
+// EnrollTwoFactor creates a new secret and recovery codes for the player (replacing any earlier enrollment
+// which was not confirmed), two factor authentication cannot be enrolled again while it is enabled
+func (as *Server) EnrollTwoFactor(playerID string) (*TwoFactorEnrollment, error) {
+
+	if as == nil {
+		return nil, serverNilError
+	}
+
+	secret := make([]byte, totpSecretBytes)
+	_, _ = rand.Read(secret) // crypto/rand never returns an error
+
+	enrollment := &TwoFactorEnrollment{
+		Secret:        secretEncoding.EncodeToString(secret),
+		RecoveryCodes: make([]string, 0, recoveryCodeCount),
+	}
+	enrollment.OTPAuthURI = fmt.Sprintf("otpauth://totp/%v:%v?secret=%v&issuer=%v&algorithm=SHA1&digits=6&period=%v",
+		url.PathEscape(twoFactorIssuer), url.PathEscape(playerID), enrollment.Secret, url.QueryEscape(twoFactorIssuer), totpPeriodSeconds)
+
+	tfd := &twoFactorData{secret: secret}
+	for range recoveryCodeCount {
+		code := newRecoveryCode()
+		enrollment.RecoveryCodes = append(enrollment.RecoveryCodes, code)
+		tfd.recoveryCodeHashes = append(tfd.recoveryCodeHashes, hashRecoveryCode(code))
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	if existing, ok := as.twoFactor[playerID]; ok && existing.enabled {
+		return nil, twoFactorEnabledError
+	}
+	as.twoFactor[playerID] = tfd
+
+	return enrollment, nil
+}
+
+// ConfirmTwoFactor enables the player's enrolled two factor authentication, if the given code
+// (from the authenticator app, recovery codes are not accepted) is valid
+func (as *Server) ConfirmTwoFactor(playerID string, code string, timeNow time.Time) error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	tfd, ok := as.twoFactor[playerID]
+	if !ok {
+		return twoFactorNotEnrolledError
+	}
+	if tfd.enabled {
+		return twoFactorEnabledError
+	}
+
+	err := tfd.check(code, timeNow, false)
+	if err != nil {
+		return err
+	}
+
+	tfd.enabled = true
+	return nil
+}
+
+// DisableTwoFactor disables the player's two factor authentication, if the given code (or recovery code) is valid
+func (as *Server) DisableTwoFactor(playerID string, code string, timeNow time.Time) error {
+
+	if as == nil {
+		return serverNilError
+	}
+
+	as.authMutex.Lock()
+	defer as.authMutex.Unlock()
+
+	tfd, ok := as.twoFactor[playerID]
+	if !ok || !tfd.enabled {
+		return twoFactorNotEnabledError
+	}
+
+	err := tfd.check(code, timeNow, true)
+	if err != nil {
+		return err
+	}
+
+	delete(as.twoFactor, playerID)
+	return nil
+}
+
+// checkTwoFactorLogin checks the two factor code of a login, if the player has two factor authentication enabled
+// (it should be called with the auth mutex held)
+func (as *Server) checkTwoFactorLogin(playerID string, code string, timeNow time.Time) error {
+
+	tfd, ok := as.twoFactor[playerID]
+	if !ok || !tfd.enabled {
+		return nil
+	}
+
+	if code == "" {
+		return twoFactorRequiredError
+	}
+
+	return tfd.check(code, timeNow, true)
+}
+
+// writeTwoFactorError responds with the error of a failed two factor check of the given player, a lockout is
+// answered with a 429 (and recorded in the audit log when this check started it), the other errors with a 401
+func (as *Server) writeTwoFactorError(w http.ResponseWriter, r *http.Request, playerID string, err error) {
+
+	if err == twoFactorLockoutStartedError {
+		as.auditRecorder.Record(r.Context(), audit.ActorSystem, audit.ActionTwoFactorLockout, playerID, map[string]int64{
+			"failedCodes": twoFactorMaxFailedCodes, "lockoutSeconds": twoFactorLockoutSeconds,
+		})
+	}
+
+	if errors.Is(err, twoFactorLockedError) {
+		apierror.Write(w, r, http.StatusTooManyRequests, apierror.CodeTooManyRequests)
+		return
+	}
+
+	code := apierror.CodeInvalidTwoFactorCode
+	if err == twoFactorRequiredError {
+		code = apierror.CodeTwoFactorRequired
+	}
+	apierror.Write(w, r, http.StatusUnauthorized, code)
+}
+
+// check verifies the given code (see verify) unless the verification is locked, and counts the invalid codes:
+// a valid one resets the count, and reaching twoFactorMaxFailedCodes locks the verification for the lockout duration
+func (tfd *twoFactorData) check(code string, timeNow time.Time, allowRecovery bool) error {
+
+	unixNow := timeNow.Unix()
+	if unixNow < tfd.lockedUntil {
+		return twoFactorLockedError
+	}
+
+	if tfd.verify(code, timeNow, allowRecovery) {
+		tfd.failedCodes = 0
+		return nil
+	}
+
+	tfd.failedCodes++
+	if tfd.failedCodes < twoFactorMaxFailedCodes {
+		return invalidTwoFactorCodeError
+	}
+
+	tfd.failedCodes = 0
+	tfd.lockedUntil = unixNow + twoFactorLockoutSeconds
+	return twoFactorLockoutStartedError
+}
+
+// verify checks the given code: a TOTP code is accepted for a time step after the last one used (within the allowed
+// skew of the current one), and a recovery code (if allowed) is accepted once, after which it is removed
+func (tfd *twoFactorData) verify(code string, timeNow time.Time, allowRecovery bool) bool {
+
+	code = strings.TrimSpace(code)
+	currentStep := timeNow.Unix() / totpPeriodSeconds
+
+	for step := currentStep - totpSkewSteps; step <= currentStep+totpSkewSteps; step++ {
+		if step > tfd.lastUsedStep && subtle.ConstantTimeCompare([]byte(totpCode(tfd.secret, step)), []byte(code)) == 1 {
+			tfd.lastUsedStep = step
+			return true
+		}
+	}
+
+	if !allowRecovery {
+		return false
+	}
+
+	codeHash := hashRecoveryCode(code)
+	for i, recoveryCodeHash := range tfd.recoveryCodeHashes {
+		if subtle.ConstantTimeCompare([]byte(recoveryCodeHash), []byte(codeHash)) == 1 {
+			tfd.recoveryCodeHashes = append(tfd.recoveryCodeHashes[:i], tfd.recoveryCodeHashes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// totpCode returns the 6 digit TOTP code of the given secret for the given time step (RFC 6238, with HMAC-SHA1)
+func totpCode(secret []byte, step int64) string {
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation (RFC 4226)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// newRecoveryCode returns a random recovery code, in the form XXXX-XXXX
+func newRecoveryCode() string {
+
+	randomBytes := make([]byte, 5)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+
+	code := secretEncoding.EncodeToString(randomBytes) // 8 characters
+	return code[:4] + "-" + code[4:]
+}
+
+// hashRecoveryCode returns the hash a recovery code is stored as (recovery codes are not case sensitive,
+// and the dash is optional)
+func hashRecoveryCode(code string) string {
+
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/clock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTotpCode(t *testing.T) {
+
+	// the SHA1 test vectors of RFC 6238 (truncated to 6 digits)
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		name     string
+		unixTime int64
+		want     string
+	}{
+		{"time 59", 59, "287082"},
+		{"time 1111111109", 1111111109, "081804"},
+		{"time 1234567890", 1234567890, "005924"},
+		{"time 2000000000", 2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := totpCode(secret, test.unixTime/totpPeriodSeconds)
+			if got != test.want {
+				t.Errorf("incorrect code, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+// loginTwoFactor attempts to log the given existing user in with a two factor code, and returns the response status
+func loginTwoFactor(t *testing.T, as *Server, username string, code string) int {
+
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(&LoginRequestBody{ServerVersion: as.serverVersion, TwoFactorCode: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newAuthReq := httptest.NewRequest(http.MethodPost, "/auth/login", buf)
+	newAuthReq.SetBasicAuth(username, "pass1")
+	respRec := httptest.NewRecorder()
+
+	as.HandleLoginRequest(respRec, newAuthReq)
+	return respRec.Result().StatusCode
+}
+
+// sendTwoFactorCode sends the given code to one of the two factor change handlers, and returns the response status
+func sendTwoFactorCode(t *testing.T, handler http.HandlerFunc, sessionID string, code string) int {
+
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(&TwoFactorCodeBody{Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReq := httptest.NewRequest(http.MethodPost, "/auth/2fa/", buf)
+	newReq.Header.Set("Session-Id", sessionID)
+	respRec := httptest.NewRecorder()
+
+	handler(respRec, newReq)
+	return respRec.Result().StatusCode
+}
+
+func TestServer_TwoFactor(t *testing.T) {
+
+	as := NewServer(data.NewServer())
+	sID := loginTestDevice(t, as, "user1", true, "phone")
+
+	// enroll
+	enrollReq := httptest.NewRequest(http.MethodPost, "/auth/2fa/enroll", nil)
+	enrollReq.Header.Set("Session-Id", sID)
+	respRec := httptest.NewRecorder()
+	withSession(as, as.HandleEnrollTwoFactorRequest)(respRec, enrollReq)
+	if respRec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("enroll failed with status: %v", respRec.Result().StatusCode)
+	}
+
+	enrollment := &TwoFactorEnrollment{}
+	err := json.NewDecoder(respRec.Body).Decode(enrollment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollment.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("incorrect number of recovery codes, want: %v, got: %v", recoveryCodeCount, len(enrollment.RecoveryCodes))
+	}
+	if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/") || !strings.Contains(enrollment.OTPAuthURI, "secret="+enrollment.Secret) {
+		t.Errorf("incorrect otpauth uri: %v", enrollment.OTPAuthURI)
+	}
+
+	secret, err := secretEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := time.Now().Unix() / totpPeriodSeconds
+
+	// recovery codes are stored hashed
+	storedHashes := as.twoFactor[as.sessions[sessionSelector(sID)].PlayerID].recoveryCodeHashes
+	for i, code := range enrollment.RecoveryCodes {
+		if storedHashes[i] != hashRecoveryCode(code) {
+			t.Errorf("recovery code %v is not stored as its hash", code)
+		}
+	}
+
+	// logging in does not need a code until two factor authentication is confirmed
+	if got := loginTwoFactor(t, as, "user1", ""); got != http.StatusOK {
+		t.Errorf("login before confirmation should succeed, got status: %v", got)
+	}
+
+	tests := []struct {
+		name       string
+		send       func() int
+		wantStatus int
+	}{
+		{"confirm with an invalid code", func() int {
+			return sendTwoFactorCode(t, withSession(as, as.HandleConfirmTwoFactorRequest), sID, "000000x")
+		}, http.StatusUnauthorized},
+		{"confirm with a recovery code", func() int {
+			return sendTwoFactorCode(t, withSession(as, as.HandleConfirmTwoFactorRequest), sID, enrollment.RecoveryCodes[0])
+		}, http.StatusUnauthorized},
+		{"confirm with an invalid session", func() int {
+			return sendTwoFactorCode(t, withSession(as, as.HandleConfirmTwoFactorRequest), "test", totpCode(secret, step))
+		}, http.StatusUnauthorized},
+		{"confirm", func() int {
+			return sendTwoFactorCode(t, withSession(as, as.HandleConfirmTwoFactorRequest), sID, totpCode(secret, step))
+		}, http.StatusOK},
+		{"enroll again", func() int {
+			enrollRec := httptest.NewRecorder()
+			withSession(as, as.HandleEnrollTwoFactorRequest)(enrollRec, enrollReq)
+			return enrollRec.Result().StatusCode
+		}, http.StatusConflict},
+		{"login without a code", func() int {
+			return loginTwoFactor(t, as, "user1", "")
+		}, http.StatusUnauthorized},
+		{"login with a used code", func() int {
+			return loginTwoFactor(t, as, "user1", totpCode(secret, step))
+		}, http.StatusUnauthorized},
+		{"login with the next code", func() int {
+			return loginTwoFactor(t, as, "user1", totpCode(secret, step+1))
+		}, http.StatusOK},
+		{"login with a recovery code", func() int {
+			return loginTwoFactor(t, as, "user1", strings.ToLower(enrollment.RecoveryCodes[0]))
+		}, http.StatusOK},
+		{"login with a used recovery code", func() int {
+			return loginTwoFactor(t, as, "user1", enrollment.RecoveryCodes[0])
+		}, http.StatusUnauthorized},
+		{"disable with an invalid code", func() int {
+			return sendTwoFactorCode(t, withSession(as, as.HandleDisableTwoFactorRequest), sID, "123")
+		}, http.StatusUnauthorized},
+		{"disable with a recovery code", func() int {
+			return sendTwoFactorCode(t, withSession(as, as.HandleDisableTwoFactorRequest), sID, enrollment.RecoveryCodes[1])
+		}, http.StatusOK},
+		{"disable again", func() int {
+			return sendTwoFactorCode(t, withSession(as, as.HandleDisableTwoFactorRequest), sID, enrollment.RecoveryCodes[2])
+		}, http.StatusBadRequest},
+		{"login after disabling", func() int {
+			return loginTwoFactor(t, as, "user1", "")
+		}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotStatus := test.send()
+			if gotStatus != test.wantStatus {
+				t.Errorf("incorrect status, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
+
+func TestServer_TwoFactor_Lockout(t *testing.T) {
+
+	ds := data.NewServer()
+	as := NewServer(ds)
+	frozenClock := clock.NewFrozen(time.Now())
+	as.SetClock(frozenClock)
+
+	sID := loginTestDevice(t, as, "user1", true, "phone")
+	playerID := as.sessions[sessionSelector(sID)].PlayerID
+
+	enrollment, err := as.EnrollTwoFactor(playerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := secretEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := frozenClock.Now().Unix() / totpPeriodSeconds
+	err = as.ConfirmTwoFactor(playerID, totpCode(secret, step), frozenClock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a valid code resets the count of invalid ones
+	for range twoFactorMaxFailedCodes - 1 {
+		if got := loginTwoFactor(t, as, "user1", "000000x"); got != http.StatusUnauthorized {
+			t.Fatalf("login with an invalid code should fail with status: %v, got: %v", http.StatusUnauthorized, got)
+		}
+	}
+	if got := loginTwoFactor(t, as, "user1", enrollment.RecoveryCodes[0]); got != http.StatusOK {
+		t.Fatalf("login with a recovery code should succeed, got status: %v", got)
+	}
+
+	for range twoFactorMaxFailedCodes - 1 {
+		if got := loginTwoFactor(t, as, "user1", "000000x"); got != http.StatusUnauthorized {
+			t.Fatalf("login with an invalid code should fail with status: %v, got: %v", http.StatusUnauthorized, got)
+		}
+	}
+	if got := loginTwoFactor(t, as, "user1", "000000x"); got != http.StatusTooManyRequests {
+		t.Fatalf("the invalid code reaching the limit should lock the verification, want status: %v, got: %v", http.StatusTooManyRequests, got)
+	}
+
+	// while locked, not even a correct code (or recovery code) is accepted
+	tests := []struct {
+		name string
+		send func() int
+	}{
+		{"login with the next code", func() int { return loginTwoFactor(t, as, "user1", totpCode(secret, step+1)) }},
+		{"login with a recovery code", func() int { return loginTwoFactor(t, as, "user1", enrollment.RecoveryCodes[1]) }},
+		{"disable with a recovery code", func() int {
+			return sendTwoFactorCode(t, withSession(as, as.HandleDisableTwoFactorRequest), sID, enrollment.RecoveryCodes[1])
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.send(); got != http.StatusTooManyRequests {
+				t.Errorf("incorrect status while locked, want: %v, got: %v", http.StatusTooManyRequests, got)
+			}
+		})
+	}
+
+	// the lockout is recorded once, when it starts
+	page, err := ds.ReadAuditEntries(context.Background(), &data.AuditQuery{PlayerID: playerID, Action: audit.ActionTwoFactorLockout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Actor != audit.ActorSystem {
+		t.Errorf("the lockout gave incorrect audit entries, want one %v entry, got: %v", audit.ActionTwoFactorLockout, page.Entries)
+	}
+
+	// once the lockout is over, the codes are checked again
+	frozenClock.Advance(time.Duration(twoFactorLockoutSeconds) * time.Second)
+	step = frozenClock.Now().Unix() / totpPeriodSeconds
+	if got := loginTwoFactor(t, as, "user1", totpCode(secret, step)); got != http.StatusOK {
+		t.Errorf("login after the lockout should succeed, got status: %v", got)
+	}
+}
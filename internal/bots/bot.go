@@ -0,0 +1,390 @@
+package bots
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/gameplay"
+	"example.com/dice-game-backend/internal/profile"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// the device the bots log in from (shown in their sessions)
+const botDevice = "bot"
+
+// errOutOfEnergy ends a session early, like a player would stop playing once they run out of energy
+var errOutOfEnergy = fmt.Errorf("out of energy")
+
+// bot is a single simulated player, which plays its sessions one after the other
+type bot struct {
+	sim      *Simulation
+	report   *Report
+	profile  *Profile
+	username string
+	password string
+
+	signedUp      bool // whether the bot has an account on the target already (the first session signs it up)
+	playerID      string
+	sessionID     string
+	serverVersion string
+	levels        []config.LevelConfig
+	player        data.PlayerData
+}
+
+// run plays the sessions of the bot's profile, a session which fails is ended early and the bot goes on to the next one
+func (b *bot) run(ctx context.Context) {
+
+	for session := range b.profile.Sessions {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := b.playSession(ctx)
+		if err != nil {
+			b.sim.logger.Printf("bot %v (%v): session %v ended early: %v", b.username, b.profile.Name, session+1, err)
+		}
+	}
+}
+
+// playSession goes through the flow of the client: login, fetch the config, load (or create) the player,
+// load the stats, play the levels, and logout
+func (b *bot) playSession(ctx context.Context) error {
+
+	err := b.login(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the session is closed even if the simulation is stopped halfway through it
+	defer b.logout(context.WithoutCancel(ctx))
+
+	err = b.fetchConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if b.signedUp {
+		err = b.loadPlayer(ctx)
+	} else {
+		err = b.createPlayer(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	b.signedUp = true
+
+	err = b.loadStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	for range b.profile.LevelsPerSession {
+		err = b.playLevel(ctx)
+		if err == errOutOfEnergy {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// login logs the bot in (signing it up in its first session), and keeps the session id for the next requests
+func (b *bot) login(ctx context.Context) error {
+
+	body := &auth.LoginRequestBody{IsNewUser: !b.signedUp, ServerVersion: b.serverVersion, Device: botDevice}
+	req, err := b.newRequest(ctx, http.MethodPost, b.sim.targets.Auth+"/auth/login", body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.username, b.password)
+
+	response := &auth.LoginResponse{}
+	_, header, err := b.do(req, "POST /auth/login", response)
+	if err != nil {
+		return err
+	}
+
+	b.playerID = response.PlayerID
+	b.serverVersion = response.ServerVersion
+	b.sessionID = header.Get("Session-Id")
+	return nil
+}
+
+// logout ends the current session of the bot
+func (b *bot) logout(ctx context.Context) {
+
+	req, err := b.newRequest(ctx, http.MethodDelete, b.sim.targets.Auth+"/auth/logout", nil)
+	if err == nil {
+		_, _, err = b.do(req, "DELETE /auth/logout", nil)
+	}
+	if err != nil {
+		b.sim.logger.Printf("bot %v (%v): logout failed: %v", b.username, b.profile.Name, err)
+	}
+
+	b.sessionID = ""
+}
+
+// fetchConfig fetches the game config, for the levels the bot can play
+func (b *bot) fetchConfig(ctx context.Context) error {
+
+	req, err := b.newRequest(ctx, http.MethodGet, b.sim.targets.Config+"/config/game-config", nil)
+	if err != nil {
+		return err
+	}
+
+	response := &struct {
+		Levels []config.LevelConfig `json:"levels"`
+	}{}
+	_, _, err = b.do(req, "GET /config/game-config", response)
+	if err != nil {
+		return err
+	}
+
+	if len(response.Levels) == 0 {
+		return fmt.Errorf("the config has no levels")
+	}
+
+	b.levels = response.Levels
+	return nil
+}
+
+// createPlayer creates the player data of a bot which just signed up
+func (b *bot) createPlayer(ctx context.Context) error {
+
+	req, err := b.newRequest(ctx, http.MethodPost, b.sim.targets.Profile+"/profile/new-player", &profile.NewPlayerRequestBody{PlayerID: b.playerID})
+	if err != nil {
+		return err
+	}
+
+	_, _, err = b.do(req, "POST /profile/new-player", &b.player)
+	return err
+}
+
+// loadPlayer loads the player data of the bot
+func (b *bot) loadPlayer(ctx context.Context) error {
+
+	req, err := b.newRequest(ctx, http.MethodGet, b.sim.targets.Profile+"/profile/player-data/"+b.playerID, nil)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = b.do(req, "GET /profile/player-data/{id}", &b.player)
+	return err
+}
+
+// loadStats loads the stats of the bot (which are only shown, so they are not kept)
+func (b *bot) loadStats(ctx context.Context) error {
+
+	req, err := b.newRequest(ctx, http.MethodGet, b.sim.targets.Stats+"/stats/player-stats/"+b.playerID, nil)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = b.do(req, "GET /stats/player-stats/{id}", &data.PlayerStatsWithID{})
+	return err
+}
+
+// playLevel enters the next level, rolls the dice till the target is hit (or the rolls run out), and sends the result.
+// A level which cannot be entered yet (because of its entry limits) is skipped, and errOutOfEnergy is returned
+// if the bot does not have enough energy to enter it
+func (b *bot) playLevel(ctx context.Context) error {
+
+	levelConfig := b.nextLevel()
+
+	mode := gameplay.EntryModeNormal
+	if float64(b.sim.random.Int32N(1000)) < b.profile.PracticeChance*1000 {
+		mode = gameplay.EntryModePractice
+	}
+
+	entryBody := &gameplay.EnterLevelRequestBody{PlayerID: b.playerID, Level: levelConfig.Level, Mode: mode}
+	req, err := b.newRequest(ctx, http.MethodPost, b.sim.targets.Gameplay+"/gameplay/entry", entryBody)
+	if err != nil {
+		return err
+	}
+
+	entryResponse := &gameplay.EnterLevelResponse{}
+	status, _, err := b.do(req, "POST /gameplay/entry", entryResponse, http.StatusConflict, http.StatusTooManyRequests)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == http.StatusConflict:
+		return errOutOfEnergy
+	case status == http.StatusTooManyRequests || !entryResponse.AccessGranted:
+		return nil
+	}
+	b.player = entryResponse.Player
+
+	rolls := []int32{}
+	for range levelConfig.TotalRolls {
+		roll := b.roll(&levelConfig)
+		rolls = append(rolls, roll)
+		if roll == levelConfig.Target {
+			break
+		}
+	}
+
+	resultBody := &gameplay.LevelResultRequestBody{PlayerID: b.playerID, Level: levelConfig.Level, Rolls: rolls, EntryToken: entryResponse.EntryToken, AttemptID: entryResponse.AttemptID}
+	req, err = b.newRequest(ctx, http.MethodPost, b.sim.targets.Gameplay+"/gameplay/result", resultBody)
+	if err != nil {
+		return err
+	}
+
+	resultResponse := &gameplay.LevelResultResponse{}
+	_, _, err = b.do(req, "POST /gameplay/result", resultResponse)
+	if err != nil {
+		return err
+	}
+
+	b.player = resultResponse.Player
+	return nil
+}
+
+// nextLevel picks the next level to play from the unlocked levels, as the bot's profile says
+func (b *bot) nextLevel() config.LevelConfig {
+
+	unlocked := min(int32(len(b.levels)), max(b.player.Level, 1))
+
+	level := unlocked
+	if b.profile.LevelChoice == LevelChoiceRandom {
+		level = 1 + b.sim.random.Int32N(unlocked)
+	}
+
+	index := slices.IndexFunc(b.levels, func(lc config.LevelConfig) bool { return lc.Level == level })
+	if index < 0 {
+		return b.levels[0]
+	}
+	return b.levels[index]
+}
+
+// roll rolls the dice of the given level, and returns the sum of their faces (taking the face weights into account)
+func (b *bot) roll(levelConfig *config.LevelConfig) int32 {
+
+	sides, count := levelConfig.Dice()
+
+	weights := make([]int32, sides)
+	totalWeight := int32(0)
+	for face := range sides {
+		weights[face] = 1
+		if levelConfig.FaceWeights != nil {
+			weights[face] = 0
+			if int(face) < len(levelConfig.FaceWeights) {
+				weights[face] = max(levelConfig.FaceWeights[face], 0)
+			}
+		}
+		totalWeight += weights[face]
+	}
+
+	sum := int32(0)
+	for range count {
+		pick := b.sim.random.Int32N(totalWeight)
+		for face, weight := range weights {
+			if pick < weight {
+				sum += int32(face) + 1
+				break
+			}
+			pick -= weight
+		}
+	}
+
+	return sum
+}
+
+// newRequest creates a request with the given body encoded as json (if any), carrying the bot's session id (if any)
+func (b *bot) newRequest(ctx context.Context, method string, url string, body any) (*http.Request, error) {
+
+	var reader io.Reader
+	if body != nil {
+		buf := &bytes.Buffer{}
+		err := json.NewEncoder(buf).Encode(body)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode request body: %v", err)
+		}
+		reader = buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %v", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.sessionID != "" {
+		req.Header.Set("Session-Id", b.sessionID)
+	}
+
+	return req, nil
+}
+
+// do waits for the bot's think time, then sends the given request and records it in the report under the given endpoint.
+// An ok response is decoded into the given response value (if any), any of the given expected statuses
+// is not a failure (and not decoded), every other status is. Returns the status and the headers of the response
+func (b *bot) do(req *http.Request, endpoint string, response any, expectedStatuses ...int) (int, http.Header, error) {
+
+	err := b.think(req.Context())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	start := time.Now()
+	resp, err := b.sim.client.Do(req)
+	if err != nil {
+		b.report.record(endpoint, time.Since(start), true)
+		return 0, nil, fmt.Errorf("%v request failed: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		failed := !slices.Contains(expectedStatuses, resp.StatusCode)
+		b.report.record(endpoint, time.Since(start), failed)
+		if failed {
+			return resp.StatusCode, nil, fmt.Errorf("%v request failed with status %v", endpoint, resp.StatusCode)
+		}
+		return resp.StatusCode, resp.Header, nil
+	}
+
+	if response != nil {
+		err = json.NewDecoder(resp.Body).Decode(response)
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	b.report.record(endpoint, time.Since(start), err != nil)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("could not decode %v response: %v", endpoint, err)
+	}
+
+	return resp.StatusCode, resp.Header, nil
+}
+
+// think pauses for 50% to 150% of the think time of the bot's profile (or till the context is done)
+func (b *bot) think(ctx context.Context) error {
+
+	if b.profile.ThinkTimeMillis == 0 {
+		return ctx.Err()
+	}
+
+	millis := b.profile.ThinkTimeMillis/2 + b.sim.random.Int32N(b.profile.ThinkTimeMillis+1)
+
+	timer := time.NewTimer(time.Duration(millis) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,297 @@
+// Package bots simulates players for QA: each bot goes through realistic sessions (login, fetch the config,
+// load its player data and stats, play some levels, logout) against the public endpoints of a target environment,
+// following one of the behavior profiles, to put concurrent load on the services (especially profile and stats)
+package bots
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/rng"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the ways a bot can pick the next level to play
+const (
+	LevelChoiceHighest = "highest" // always the highest unlocked level
+	LevelChoiceRandom  = "random"  // any of the unlocked levels
+)
+
+// Profile scripts the behavior of the bots which follow it
+type Profile struct {
+	Name             string  `json:"name"`
+	Weight           int32   `json:"weight"`           // share of the bots following this profile (relative to the weights of the other profiles)
+	Sessions         int32   `json:"sessions"`         // sessions each bot plays before it is done
+	LevelsPerSession int32   `json:"levelsPerSession"` // levels played per session (fewer if the bot runs out of energy)
+	ThinkTimeMillis  int32   `json:"thinkTimeMillis"`  // average pause before each request (the actual pause is 50% to 150% of it)
+	LevelChoice      string  `json:"levelChoice"`      // how the next level is picked, see LevelChoiceHighest / LevelChoiceRandom
+	PracticeChance   float64 `json:"practiceChance"`   // chance of playing a level in practice mode (between 0 and 1)
+}
+
+// Validate checks that the profile can be followed
+func (p *Profile) Validate() error {
+
+	if p == nil {
+		return fmt.Errorf("the profile is nil")
+	}
+
+	if p.Name == "" {
+		return fmt.Errorf("profile has no name")
+	}
+	if p.Weight <= 0 || p.Sessions <= 0 || p.LevelsPerSession <= 0 {
+		return fmt.Errorf("profile %v: weight, sessions and levels per session should be positive", p.Name)
+	}
+	if p.ThinkTimeMillis < 0 {
+		return fmt.Errorf("profile %v: think time cannot be negative", p.Name)
+	}
+	if p.LevelChoice != LevelChoiceHighest && p.LevelChoice != LevelChoiceRandom {
+		return fmt.Errorf("profile %v: unknown level choice: %v", p.Name, p.LevelChoice)
+	}
+	if p.PracticeChance < 0 || p.PracticeChance > 1 {
+		return fmt.Errorf("profile %v: practice chance should be between 0 and 1", p.Name)
+	}
+
+	return nil
+}
+
+// DefaultProfiles are followed when no profiles file is given:
+// mostly casual players, and some players grinding through the levels as fast as they can
+var DefaultProfiles = []Profile{
+	{Name: "casual", Weight: 3, Sessions: 2, LevelsPerSession: 3, ThinkTimeMillis: 1500, LevelChoice: LevelChoiceRandom, PracticeChance: 0.2},
+	{Name: "grinder", Weight: 1, Sessions: 5, LevelsPerSession: 10, ThinkTimeMillis: 200, LevelChoice: LevelChoiceHighest},
+}
+
+// LoadProfiles reads the profiles from the given json file (a list of profiles), and validates them
+func LoadProfiles(path string) ([]Profile, error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the profiles file: %v", err)
+	}
+
+	profiles := []Profile{}
+	err = json.Unmarshal(content, &profiles)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode the profiles file: %v", err)
+	}
+
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("the profiles file has no profiles")
+	}
+
+	for i := range profiles {
+		err = profiles[i].Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return profiles, nil
+}
+
+// Targets are the base urls of the services the bots send their requests to
+type Targets struct {
+	Auth     string
+	Config   string
+	Profile  string
+	Stats    string
+	Gameplay string
+}
+
+// DefaultTargets returns the targets for the services running on the given host, on their usual ports
+func DefaultTargets(host string) Targets {
+
+	baseURL := func(port string) string {
+		return constants.CommonProtocol + "://" + host + ":" + port
+	}
+
+	return Targets{
+		Auth:     baseURL(constants.AuthServerPort),
+		Config:   baseURL(constants.ConfigServerPort),
+		Profile:  baseURL(constants.ProfileServerPort),
+		Stats:    baseURL(constants.StatsServerPort),
+		Gameplay: baseURL(constants.GameplayServerPort),
+	}
+}
+
+// EndpointReport sums up the requests the bots sent to a single endpoint
+type EndpointReport struct {
+	Requests      int64
+	Failures      int64 // requests which failed, or got an unexpected response
+	TotalDuration time.Duration
+}
+
+// Report sums up the requests the bots sent, per endpoint, safe for concurrent use
+type Report struct {
+	endpoints map[string]*EndpointReport
+	mutex     sync.Mutex
+}
+
+// newReport returns an initialized pointer to an empty report
+func newReport() *Report {
+	return &Report{endpoints: map[string]*EndpointReport{}}
+}
+
+// record adds a request to the given endpoint to the report
+func (rp *Report) record(endpoint string, duration time.Duration, failed bool) {
+
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+
+	entry, ok := rp.endpoints[endpoint]
+	if !ok {
+		entry = &EndpointReport{}
+		rp.endpoints[endpoint] = entry
+	}
+
+	entry.Requests++
+	entry.TotalDuration += duration
+	if failed {
+		entry.Failures++
+	}
+}
+
+// Endpoint returns the summary of the requests to the given endpoint
+func (rp *Report) Endpoint(endpoint string) EndpointReport {
+
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+
+	if entry, ok := rp.endpoints[endpoint]; ok {
+		return *entry
+	}
+	return EndpointReport{}
+}
+
+// Failures returns the number of failed requests across all the endpoints
+func (rp *Report) Failures() int64 {
+
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+
+	failures := int64(0)
+	for _, entry := range rp.endpoints {
+		failures += entry.Failures
+	}
+	return failures
+}
+
+// String returns the report as a table, with a line per endpoint (sorted by the endpoint)
+func (rp *Report) String() string {
+
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+
+	endpoints := []string{}
+	for endpoint := range rp.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	slices.Sort(endpoints)
+
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "%-36v %10v %10v %14v\n", "endpoint", "requests", "failures", "avg latency")
+	for _, endpoint := range endpoints {
+		entry := rp.endpoints[endpoint]
+		average := entry.TotalDuration / time.Duration(entry.Requests)
+		fmt.Fprintf(builder, "%-36v %10v %10v %14v\n", endpoint, entry.Requests, entry.Failures, average.Round(time.Microsecond))
+	}
+
+	return builder.String()
+}
+
+// Simulation runs a number of bots concurrently against the targets
+type Simulation struct {
+	targets        Targets
+	profiles       []Profile
+	botCount       int
+	usernamePrefix string
+
+	client *http.Client
+	random rng.RNG
+	logger *log.Logger
+}
+
+// NewSimulation returns an initialized pointer to a simulation of the given number of bots, which are spread over the
+// given profiles by their weights. The usernames of the bots start with the given prefix (which should be new to the
+// target, as the bots sign up in their first session), and the given random number generator drives their choices
+func NewSimulation(targets Targets, profiles []Profile, botCount int, usernamePrefix string, random rng.RNG) (*Simulation, error) {
+
+	if botCount <= 0 {
+		return nil, fmt.Errorf("the number of bots should be positive")
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("at least one profile is needed")
+	}
+
+	for i := range profiles {
+		err := profiles[i].Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Simulation{
+		targets:        targets,
+		profiles:       profiles,
+		botCount:       botCount,
+		usernamePrefix: usernamePrefix,
+
+		client: &http.Client{Timeout: 10 * time.Second},
+		random: random,
+		logger: log.New(redact.Stdout, "bots: ", log.Ltime|log.Lmicroseconds),
+	}, nil
+}
+
+// profileFor returns the profile of the bot with the given index, the profiles get consecutive bots in proportion
+// to their weights (so every profile gets its share, even with only a few bots)
+func (sim *Simulation) profileFor(index int) *Profile {
+
+	totalWeight := int32(0)
+	for i := range sim.profiles {
+		totalWeight += sim.profiles[i].Weight
+	}
+
+	slot := int32(index) % totalWeight
+	for i := range sim.profiles {
+		if slot < sim.profiles[i].Weight {
+			return &sim.profiles[i]
+		}
+		slot -= sim.profiles[i].Weight
+	}
+
+	return &sim.profiles[len(sim.profiles)-1]
+}
+
+// Run starts all the bots, and returns the report once they are all done (or the context is done)
+func (sim *Simulation) Run(ctx context.Context) *Report {
+
+	report := newReport()
+	wg := sync.WaitGroup{}
+
+	for i := range sim.botCount {
+		newBot := &bot{
+			sim:      sim,
+			report:   report,
+			profile:  sim.profileFor(i),
+			username: fmt.Sprintf("%v%v", sim.usernamePrefix, i),
+			password: fmt.Sprintf("%v%v-pass", sim.usernamePrefix, i),
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newBot.run(ctx)
+		}()
+	}
+
+	wg.Wait()
+	return report
+}
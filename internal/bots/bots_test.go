@@ -0,0 +1,298 @@
+package bots
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/gameplay"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/rng"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLoadProfiles(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		content   string
+		wantCount int
+		wantErr   bool
+	}{
+		{"valid", `[{"name":"casual","weight":1,"sessions":1,"levelsPerSession":2,"levelChoice":"random","practiceChance":0.5}]`, 1, false},
+		{"not json", `abc`, 0, true},
+		{"no profiles", `[]`, 0, true},
+		{"no name", `[{"weight":1,"sessions":1,"levelsPerSession":1,"levelChoice":"random"}]`, 0, true},
+		{"zero sessions", `[{"name":"a","weight":1,"levelsPerSession":1,"levelChoice":"random"}]`, 0, true},
+		{"unknown level choice", `[{"name":"a","weight":1,"sessions":1,"levelsPerSession":1,"levelChoice":"lowest"}]`, 0, true},
+		{"invalid practice chance", `[{"name":"a","weight":1,"sessions":1,"levelsPerSession":1,"levelChoice":"highest","practiceChance":2}]`, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "profiles.json")
+			err := os.WriteFile(path, []byte(test.content), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			profiles, err := LoadProfiles(path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("LoadProfiles() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if len(profiles) != test.wantCount {
+				t.Errorf("LoadProfiles() gave incorrect results, want: %v profiles, got: %v", test.wantCount, len(profiles))
+			}
+		})
+	}
+}
+
+func TestSimulation_ProfileFor(t *testing.T) {
+
+	sim, err := NewSimulation(DefaultTargets("localhost"), DefaultProfiles, 8, "bot", rng.NewSeededRNG(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	for i := range 8 {
+		counts[sim.profileFor(i).Name]++
+	}
+
+	if counts["casual"] != 6 || counts["grinder"] != 2 {
+		t.Errorf("profileFor() gave incorrect results, want 6 casual and 2 grinder bots, got: %v", counts)
+	}
+}
+
+// testTarget is a minimal stand in for the public endpoints of an environment, which checks that the bots
+// follow the client flow (sessions are used by the right player, results are sent for entered levels)
+type testTarget struct {
+	levels []config.LevelConfig
+
+	sessions map[string]string // session id -> player id
+	entries  map[string]int32  // entry token -> level
+	players  map[string]*data.PlayerData
+	nextID   int
+	mutex    sync.Mutex
+}
+
+func newTestTarget(t *testing.T) (*testTarget, Targets) {
+
+	target := &testTarget{
+		levels: []config.LevelConfig{
+			{Level: 1, EnergyCost: 1, TotalRolls: 3, Target: 2, DiceSides: 2, DiceCount: 1},
+			{Level: 2, EnergyCost: 2, TotalRolls: 3, Target: 12, DiceSides: 6, DiceCount: 2, FaceWeights: []int32{0, 0, 0, 0, 0, 1}},
+		},
+		sessions: map[string]string{},
+		entries:  map[string]int32{},
+		players:  map[string]*data.PlayerData{},
+	}
+
+	// the player of the session, or an unauthorized response
+	player := func(w http.ResponseWriter, r *http.Request) (string, bool) {
+		target.mutex.Lock()
+		defer target.mutex.Unlock()
+
+		pID, ok := target.sessions[r.Header.Get("Session-Id")]
+		if !ok {
+			http.Error(w, "error: invalid session", http.StatusUnauthorized)
+		}
+		return pID, ok
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/login", func(w http.ResponseWriter, r *http.Request) {
+		usr, _, _ := r.BasicAuth()
+		target.mutex.Lock()
+		target.nextID++
+		sID := fmt.Sprintf("session%v", target.nextID)
+		target.sessions[sID] = "player-" + usr
+		target.mutex.Unlock()
+
+		w.Header().Set("Session-Id", sID)
+		_ = json.NewEncoder(w).Encode(&auth.LoginResponse{PlayerID: "player-" + usr, ServerVersion: "1"})
+	})
+	mux.HandleFunc("DELETE /auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := player(w, r); ok {
+			target.mutex.Lock()
+			delete(target.sessions, r.Header.Get("Session-Id"))
+			target.mutex.Unlock()
+		}
+	})
+	mux.HandleFunc("GET /config/game-config", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"levels": target.levels})
+	})
+	mux.HandleFunc("POST /profile/new-player", func(w http.ResponseWriter, r *http.Request) {
+		pID, ok := player(w, r)
+		req := &profile.NewPlayerRequestBody{}
+		if !ok || json.NewDecoder(r.Body).Decode(req) != nil || req.PlayerID != pID {
+			http.Error(w, "error: invalid request", http.StatusBadRequest)
+			return
+		}
+
+		target.mutex.Lock()
+		defer target.mutex.Unlock()
+		newPlayer := &data.PlayerData{PlayerID: pID, Level: 1, Energy: 3}
+		target.players[pID] = newPlayer
+		_ = json.NewEncoder(w).Encode(newPlayer)
+	})
+	mux.HandleFunc("GET /profile/player-data/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := player(w, r); !ok {
+			return
+		}
+
+		target.mutex.Lock()
+		defer target.mutex.Unlock()
+		_ = json.NewEncoder(w).Encode(target.players[r.PathValue("id")])
+	})
+	mux.HandleFunc("GET /stats/player-stats/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := player(w, r); ok {
+			_ = json.NewEncoder(w).Encode(&data.PlayerStatsWithID{PlayerID: r.PathValue("id")})
+		}
+	})
+	mux.HandleFunc("POST /gameplay/entry", func(w http.ResponseWriter, r *http.Request) {
+		pID, ok := player(w, r)
+		req := &gameplay.EnterLevelRequestBody{}
+		if !ok || json.NewDecoder(r.Body).Decode(req) != nil || req.PlayerID != pID {
+			http.Error(w, "error: invalid request", http.StatusBadRequest)
+			return
+		}
+
+		target.mutex.Lock()
+		defer target.mutex.Unlock()
+
+		pl := target.players[pID]
+		cost := target.levels[req.Level-1].EnergyCost
+		if req.Level > pl.Level {
+			http.Error(w, "error: level is locked", http.StatusBadRequest)
+			return
+		}
+		if pl.Energy < cost {
+			http.Error(w, "error: not enough energy", http.StatusConflict)
+			return
+		}
+		pl.Energy -= cost
+
+		target.nextID++
+		token := fmt.Sprintf("token%v", target.nextID)
+		target.entries[token] = req.Level
+		_ = json.NewEncoder(w).Encode(&gameplay.EnterLevelResponse{AccessGranted: true, Player: *pl, EntryToken: token})
+	})
+	mux.HandleFunc("POST /gameplay/result", func(w http.ResponseWriter, r *http.Request) {
+		pID, ok := player(w, r)
+		req := &gameplay.LevelResultRequestBody{}
+		if !ok || json.NewDecoder(r.Body).Decode(req) != nil || req.PlayerID != pID {
+			http.Error(w, "error: invalid request", http.StatusBadRequest)
+			return
+		}
+
+		target.mutex.Lock()
+		defer target.mutex.Unlock()
+
+		level, entered := target.entries[req.EntryToken]
+		levelConfig := target.levels[req.Level-1]
+		if !entered || level != req.Level || len(req.Rolls) == 0 || int32(len(req.Rolls)) > levelConfig.TotalRolls {
+			http.Error(w, "error: invalid result", http.StatusBadRequest)
+			return
+		}
+		for _, roll := range req.Rolls {
+			if !levelConfig.IsValidRoll(roll) {
+				http.Error(w, "error: invalid roll", http.StatusBadRequest)
+				return
+			}
+		}
+		delete(target.entries, req.EntryToken)
+
+		pl := target.players[pID]
+		won := req.Rolls[len(req.Rolls)-1] == levelConfig.Target
+		if won && req.Level == pl.Level && pl.Level < int32(len(target.levels)) {
+			pl.Level++
+		}
+		_ = json.NewEncoder(w).Encode(&gameplay.LevelResultResponse{LevelResult: gameplay.LevelResult{Won: won}, Player: *pl})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return target, Targets{Auth: server.URL, Config: server.URL, Profile: server.URL, Stats: server.URL, Gameplay: server.URL}
+}
+
+func TestSimulation_Run(t *testing.T) {
+
+	target, targets := newTestTarget(t)
+
+	profiles := []Profile{
+		{Name: "highest", Weight: 1, Sessions: 2, LevelsPerSession: 5, LevelChoice: LevelChoiceHighest},
+		{Name: "random", Weight: 1, Sessions: 2, LevelsPerSession: 2, LevelChoice: LevelChoiceRandom, PracticeChance: 0.5},
+	}
+
+	sim, err := NewSimulation(targets, profiles, 10, "bot", rng.NewSeededRNG(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := sim.Run(t.Context())
+
+	if failures := report.Failures(); failures != 0 {
+		t.Errorf("Run() gave incorrect results, want no failures, got: %v \n%v", failures, report)
+	}
+
+	tests := []struct {
+		endpoint     string
+		wantRequests int64
+	}{
+		{"POST /auth/login", 20},
+		{"DELETE /auth/logout", 20},
+		{"GET /config/game-config", 20},
+		{"POST /profile/new-player", 10},
+		{"GET /profile/player-data/{id}", 10},
+		{"GET /stats/player-stats/{id}", 20},
+	}
+
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			if got := report.Endpoint(test.endpoint).Requests; got != test.wantRequests {
+				t.Errorf("Run() gave incorrect results, want: %v requests, got: %v", test.wantRequests, got)
+			}
+		})
+	}
+
+	// every entry gets a result, and the bots only stop playing once they run out of energy
+	entries, results := report.Endpoint("POST /gameplay/entry"), report.Endpoint("POST /gameplay/result")
+	if results.Requests == 0 || entries.Requests < results.Requests {
+		t.Errorf("Run() gave incorrect results, got: %v entries, %v results", entries.Requests, results.Requests)
+	}
+	if len(target.sessions) != 0 {
+		t.Errorf("Run() gave incorrect results, want all sessions logged out, got: %v open sessions", len(target.sessions))
+	}
+}
+
+func TestSimulation_RunCancelled(t *testing.T) {
+
+	target, targets := newTestTarget(t)
+
+	profiles := []Profile{{Name: "slow", Weight: 1, Sessions: 3, LevelsPerSession: 1, ThinkTimeMillis: 60000, LevelChoice: LevelChoiceHighest}}
+	sim, err := NewSimulation(targets, profiles, 3, "bot", rng.NewSeededRNG(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	report := sim.Run(ctx)
+	if got := report.Endpoint("POST /auth/login").Requests; got != 0 {
+		t.Errorf("Run() gave incorrect results, want no requests after the context is done, got: %v logins", got)
+	}
+	if len(target.sessions) != 0 {
+		t.Errorf("Run() gave incorrect results, want no open sessions, got: %v", len(target.sessions))
+	}
+}
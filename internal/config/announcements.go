@@ -0,0 +1,393 @@
+package config
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// the severities of an announcement, which clients can show differently (like a banner for info, a popup for critical)
+const (
+	AnnouncementSeverityInfo     = "info"
+	AnnouncementSeverityWarning  = "warning"
+	AnnouncementSeverityCritical = "critical"
+)
+
+// maxAnnouncementLength is the maximum length of the message of an announcement (in characters)
+const maxAnnouncementLength = 500
+
+// announcementEventsKeepAlive is how often a keep alive comment is sent on an open announcement events stream
+const announcementEventsKeepAlive time.Duration = 15 * time.Second
+
+// announcementEventName is the name of the server-sent event which pushes an announcement once it is active
+const announcementEventName = "announcement"
+
+// AnnouncementNotFoundErr is returned when an announcement with the given id does not exist
+type AnnouncementNotFoundErr struct {
+	AnnouncementID string
+}
+
+func (err AnnouncementNotFoundErr) Error() string {
+	return fmt.Sprintf("no announcement with id: %v was found", err.AnnouncementID)
+}
+
+// AnnouncementRequestBody is used as the request body for the admin request to schedule an announcement,
+// a blank start time means right away
+type AnnouncementRequestBody struct {
+	Message   string `json:"message"`
+	Severity  string `json:"severity"`
+	StartTime int64  `json:"startTime,omitempty"`
+	EndTime   int64  `json:"endTime"`
+}
+
+// Announcement is a message broadcast to all the players while it is active, from its start time (unix, inclusive)
+// till its end time (unix, exclusive)
+type Announcement struct {
+	AnnouncementID string `json:"announcementID"`
+	Message        string `json:"message"`
+	Severity       string `json:"severity"`
+	StartTime      int64  `json:"startTime"`
+	EndTime        int64  `json:"endTime"`
+}
+
+// AnnouncementList is used as the response for the public and admin announcement list requests
+type AnnouncementList struct {
+	Announcements []Announcement `json:"announcements"`
+}
+
+// ScheduleAnnouncement adds an announcement, which is broadcast between its start and end times,
+// the open announcement events streams are told about it right away
+func (cs *Server) ScheduleAnnouncement(request *AnnouncementRequestBody) (*Announcement, error) {
+
+	if cs == nil {
+		return nil, fmt.Errorf("provided config server pointer is nil")
+	}
+
+	if request == nil {
+		return nil, fmt.Errorf("provided announcement pointer is nil")
+	}
+
+	message := strings.TrimSpace(request.Message)
+	if message == "" || utf8.RuneCountInString(message) > maxAnnouncementLength {
+		return nil, fmt.Errorf("the message should have between 1 and %v characters", maxAnnouncementLength)
+	}
+
+	switch request.Severity {
+	case AnnouncementSeverityInfo, AnnouncementSeverityWarning, AnnouncementSeverityCritical:
+	default:
+		return nil, fmt.Errorf("%q is not a known severity", request.Severity)
+	}
+
+	unixNow := time.Now().UTC().Unix()
+	startTime := request.StartTime
+	if startTime == 0 {
+		startTime = unixNow
+	}
+
+	if request.EndTime <= max(startTime, unixNow) {
+		return nil, fmt.Errorf("the end time (%v) should be after the start time (%v) and in the future", request.EndTime, startTime)
+	}
+
+	announcement := &Announcement{
+		AnnouncementID: newAnnouncementID(),
+		Message:        message,
+		Severity:       request.Severity,
+		StartTime:      startTime,
+		EndTime:        request.EndTime,
+	}
+
+	cs.announcementsMutex.Lock()
+	cs.announcements[announcement.AnnouncementID] = announcement
+	cs.notifyAnnouncementsChanged()
+	cs.announcementsMutex.Unlock()
+
+	return announcement, nil
+}
+
+// DeleteAnnouncement removes the announcement with the given id (clients which already got it keep it till its end time)
+func (cs *Server) DeleteAnnouncement(announcementID string) error {
+
+	if cs == nil {
+		return fmt.Errorf("provided config server pointer is nil")
+	}
+
+	cs.announcementsMutex.Lock()
+	defer cs.announcementsMutex.Unlock()
+
+	if _, ok := cs.announcements[announcementID]; !ok {
+		return AnnouncementNotFoundErr{AnnouncementID: announcementID}
+	}
+
+	delete(cs.announcements, announcementID)
+	cs.notifyAnnouncementsChanged()
+	return nil
+}
+
+// Announcements returns all the announcements (scheduled, active and ended ones), ordered by start time
+func (cs *Server) Announcements() []Announcement {
+
+	cs.announcementsMutex.RLock()
+	defer cs.announcementsMutex.RUnlock()
+
+	announcements := make([]Announcement, 0, len(cs.announcements))
+	for _, announcement := range cs.announcements {
+		announcements = append(announcements, *announcement)
+	}
+
+	sortAnnouncements(announcements)
+	return announcements
+}
+
+// ActiveAnnouncements returns the announcements which are active at the given time (unix), ordered by start time,
+// along with the time the next scheduled announcement starts (0 if there is none), and a channel which is closed
+// the next time the announcements change
+func (cs *Server) ActiveAnnouncements(now int64) ([]Announcement, int64, <-chan struct{}) {
+
+	cs.announcementsMutex.RLock()
+	defer cs.announcementsMutex.RUnlock()
+
+	active := []Announcement{}
+	nextStart := int64(0)
+	for _, announcement := range cs.announcements {
+		switch {
+		case announcement.StartTime <= now && now < announcement.EndTime:
+			active = append(active, *announcement)
+		case announcement.StartTime > now && (nextStart == 0 || announcement.StartTime < nextStart):
+			nextStart = announcement.StartTime
+		}
+	}
+
+	sortAnnouncements(active)
+	return active, nextStart, cs.announcementsChanged
+}
+
+// notifyAnnouncementsChanged wakes up the open announcement events streams,
+// it should be called with the announcements mutex held
+func (cs *Server) notifyAnnouncementsChanged() {
+	close(cs.announcementsChanged)
+	cs.announcementsChanged = make(chan struct{})
+}
+
+// HandleAnnouncementsRequest responds with the announcements which are active right now
+func (cs *Server) HandleAnnouncementsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	active, _, _ := cs.ActiveAnnouncements(time.Now().UTC().Unix())
+	cs.writeAnnouncements(w, r, active)
+}
+
+// HandleAnnouncementEventsRequest opens a server-sent events stream, which sends an 'announcement' event
+// for every active announcement right away, and for every other one as soon as it becomes active
+// (the stream is woken up when admins schedule an announcement, or when a scheduled one starts)
+func (cs *Server) HandleAnnouncementEventsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errMsg := "error: streaming is not supported"
+		cs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// each announcement is only sent once on a stream
+	sent := map[string]bool{}
+	for {
+		now := time.Now().UTC().Unix()
+		active, nextStart, changed := cs.ActiveAnnouncements(now)
+
+		for _, announcement := range active {
+			if sent[announcement.AnnouncementID] {
+				continue
+			}
+
+			encoded, encodeErr := json.Marshal(announcement)
+			if encodeErr != nil {
+				cs.logger.Println("error: could not encode announcement: " + encodeErr.Error())
+				return
+			}
+
+			_, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", announcementEventName, encoded)
+			if err != nil {
+				return
+			}
+			sent[announcement.AnnouncementID] = true
+		}
+		flusher.Flush()
+
+		// wait till the announcements change, or the next scheduled one starts (or till the next keep alive)
+		wait := announcementEventsKeepAlive
+		if nextStart > 0 {
+			wait = min(wait, time.Duration(nextStart-now)*time.Second)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// HandleScheduleAnnouncementRequest schedules the announcement in the request body (admin only),
+// and responds with it (along with its id)
+func (cs *Server) HandleScheduleAnnouncementRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	// decode the request body, which should be an AnnouncementRequestBody struct
+	decodedReq := &AnnouncementRequestBody{}
+	err = json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	announcement, err := cs.ScheduleAnnouncement(decodedReq)
+	if err != nil {
+		errMsg := "error: could not schedule the announcement: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	cs.logger.Printf("scheduled announcement %v (%v) from %v till %v", announcement.AnnouncementID, announcement.Severity, announcement.StartTime, announcement.EndTime)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(announcement)
+	if err != nil {
+		errMsg := "error: could not encode the announcement: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// HandleListAnnouncementsRequest responds with all the announcements, including the scheduled and ended ones (admin only)
+func (cs *Server) HandleListAnnouncementsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	cs.writeAnnouncements(w, r, cs.Announcements())
+}
+
+// HandleDeleteAnnouncementRequest deletes the announcement with the id in the request path (admin only)
+func (cs *Server) HandleDeleteAnnouncementRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	id := r.PathValue("id")
+	cs.logger.Printf("delete announcement request for id: %v", id)
+
+	err = cs.DeleteAnnouncement(id)
+	if err != nil {
+		errMsg := "error: could not delete the announcement: " + err.Error()
+		cs.logger.Println(errMsg)
+		switch err.(type) {
+		case AnnouncementNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// writeAnnouncements responds with the given announcements
+func (cs *Server) writeAnnouncements(w http.ResponseWriter, r *http.Request, announcements []Announcement) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&AnnouncementList{Announcements: announcements})
+	if err != nil {
+		errMsg := "error: could not encode the announcements: " + err.Error()
+		cs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// sortAnnouncements orders the announcements by start time (ties by id)
+func sortAnnouncements(announcements []Announcement) {
+	slices.SortFunc(announcements, func(a, b Announcement) int {
+		return cmp.Or(cmp.Compare(a.StartTime, b.StartTime), strings.Compare(a.AnnouncementID, b.AnnouncementID))
+	})
+}
+
+// newAnnouncementID returns a random announcement id
+func newAnnouncementID() string {
+
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+
+	return "announcement-" + hex.EncodeToString(randomBytes)
+}
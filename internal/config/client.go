@@ -0,0 +1,71 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+var clientNilError = fmt.Errorf("provided config client pointer is nil")
+
+// FlagClient implementor can return the current feature flags
+// (implemented by the config Server itself for in-process use, and by HTTPClient
+// when the config service runs as its own microservice)
+type FlagClient interface {
+	ReadFlags(ctx context.Context) (map[string]FlagValue, error)
+}
+
+// HTTPClient is the FlagClient implementation which makes internal (server to server) requests to the config service
+type HTTPClient struct {
+	baseURL string
+}
+
+// NewHTTPClient returns an initialized pointer to a config client that talks to the config service at its address in the startup config (see startup.ServiceURL)
+func NewHTTPClient() *HTTPClient {
+	return &HTTPClient{
+		baseURL: startup.ServiceURL("config"),
+	}
+}
+
+// ReadFlags makes an internal request to the config service for the current feature flags
+func (hc *HTTPClient) ReadFlags(ctx context.Context) (map[string]FlagValue, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "GET", hc.baseURL+"/config/flags-internal", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read flags request was not successful, status code %v", resp.StatusCode)
+	}
+
+	// decode the response for the flags
+	flags := map[string]FlagValue{}
+	err = json.NewDecoder(resp.Body).Decode(&flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return flags, nil
+}
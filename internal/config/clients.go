@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ClientUpdate checks the given client version of the given platform against the client compatibility matrix,
+// returning the min version and the store url of the platform, and whether the client has to update before it can log in.
+// Clients of platforms which are not in the matrix, or without a version, do not have to update, while a version which
+// cannot be parsed (which no released client has) has to
+func (gc *GameConfig) ClientUpdate(platform string, version string) (minVersion string, storeURL string, needed bool) {
+
+	if platform == "" || version == "" {
+		return "", "", false
+	}
+
+	for _, platformConfig := range gc.Clients.Platforms {
+		if platformConfig.Platform != platform {
+			continue
+		}
+
+		minParts, err := parseClientVersion(platformConfig.MinVersion)
+		if err != nil {
+			// an invalid min version does not pass validation, so it cannot lock every client out
+			return "", "", false
+		}
+
+		parts, err := parseClientVersion(version)
+		needed = err != nil || compareClientVersions(parts, minParts) < 0
+		return platformConfig.MinVersion, platformConfig.StoreURL, needed
+	}
+
+	return "", "", false
+}
+
+// parseClientVersion splits a client version like 1.4.2 into its numbers
+func parseClientVersion(version string) ([]int, error) {
+
+	if version == "" {
+		return nil, fmt.Errorf("the version is blank")
+	}
+
+	parts := []int{}
+	for _, part := range strings.Split(version, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return nil, fmt.Errorf("invalid version: %q", version)
+		}
+		parts = append(parts, number)
+	}
+
+	return parts, nil
+}
+
+// compareClientVersions returns -1, 0 or 1 when the first version is older than, the same as, or newer than the second
+// (missing numbers count as 0, so 1.2 is the same as 1.2.0)
+func compareClientVersions(a []int, b []int) int {
+
+	for i := range max(len(a), len(b)) {
+		partA, partB := 0, 0
+		if i < len(a) {
+			partA = a[i]
+		}
+		if i < len(b) {
+			partB = b[i]
+		}
+		if partA != partB {
+			if partA < partB {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -2,105 +2,680 @@
 package config
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"example.com/dice-game-backend/internal/shared/apierror"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/redact"
 	"example.com/dice-game-backend/internal/shared/validation"
+	"example.com/dice-game-backend/pkg/rules"
 	"fmt"
 	"log"
+	"maps"
+	"math"
+	"net"
 	"net/http"
-	"os"
+	"strings"
+	"sync"
 )
 
+// configCacheControl lets clients (but not shared caches, since the config needs a session) store the config,
+// as long as they revalidate it with its ETag before using it again
+const configCacheControl = "private, no-cache"
+
+// dice used by a level when its config does not specify them
+const DefaultDiceSides int32 = 6
+const DefaultDiceCount int32 = 1
+
+// LevelConfig holds the settings of a single level, each roll in a level is the sum of the faces of its dice.
+// FaceWeights is optional, when present it holds one (relative) weight per face, starting from face 1,
+// and faces with a weight of 0 can never be rolled.
+// CooldownSeconds and MaxAttemptsPerDay are optional too, they limit how soon a player can enter the level again,
+// and how many times a player can enter it per (UTC) day (0 means no limit)
 type LevelConfig struct {
-	Level        int32 `json:"level"`
-	EnergyCost   int32 `json:"energyCost"`
-	TotalRolls   int32 `json:"totalRolls"`
-	Target       int32 `json:"target"`
-	EnergyReward int32 `json:"energyRewards"`
+	Level             int32   `json:"level"`
+	Name              string  `json:"name,omitempty"`
+	Description       string  `json:"description,omitempty"`
+	EnergyCost        int32   `json:"energyCost"`
+	TotalRolls        int32   `json:"totalRolls"`
+	Target            int32   `json:"target"`
+	EnergyReward      int32   `json:"energyRewards"`
+	DiceSides         int32   `json:"diceSides"`
+	DiceCount         int32   `json:"diceCount"`
+	FaceWeights       []int32 `json:"faceWeights,omitempty"`
+	CooldownSeconds   int64   `json:"cooldownSeconds,omitempty"`
+	MaxAttemptsPerDay int32   `json:"maxAttemptsPerDay,omitempty"`
+	RequiredFTUEStep  int32   `json:"requiredFtueStep,omitempty"` // the FTUE step a player has to complete before entering the level
+}
+
+// Rules returns the settings of the level used by the rules package
+func (lc *LevelConfig) Rules() rules.Level {
+	return rules.Level{Level: lc.Level, TotalRolls: lc.TotalRolls, Target: lc.Target, EnergyReward: lc.EnergyReward}
+}
+
+// Dice returns the number of sides and the number of dice used in the level (falling back to the defaults)
+func (lc *LevelConfig) Dice() (int32, int32) {
+
+	sides, count := lc.DiceSides, lc.DiceCount
+	if sides <= 0 {
+		sides = DefaultDiceSides
+	}
+	if count <= 0 {
+		count = DefaultDiceCount
+	}
+
+	return sides, count
+}
+
+// IsValidRoll checks whether the given roll (the sum of the faces of the level's dice)
+// can actually be rolled with the dice of the level, taking face weights into account
+func (lc *LevelConfig) IsValidRoll(roll int32) bool {
+
+	sides, count := lc.Dice()
+	if roll < count || roll > sides*count {
+		return false
+	}
+
+	// faces which can be rolled (all of them, unless some have a weight of 0)
+	faces := []int32{}
+	for face := int32(1); face <= sides; face++ {
+		if lc.FaceWeights == nil || (int(face) <= len(lc.FaceWeights) && lc.FaceWeights[face-1] > 0) {
+			faces = append(faces, face)
+		}
+	}
+
+	// find all the sums reachable by adding up one face for each die
+	reachable := map[int32]bool{0: true}
+	for range count {
+		next := map[int32]bool{}
+		for sum := range reachable {
+			for _, face := range faces {
+				next[sum+face] = true
+			}
+		}
+		reachable = next
+	}
+
+	return reachable[roll]
+}
+
+// RollProbability returns the probability of the given roll (the sum of the faces of the level's dice)
+// with the dice of the level, taking face weights into account
+func (lc *LevelConfig) RollProbability(roll int32) float64 {
+
+	sides, count := lc.Dice()
+
+	// the probability of each face of a single die
+	faceProbabilities := make([]float64, sides+1)
+	totalWeight := 0.0
+	for face := int32(1); face <= sides; face++ {
+		weight := 1.0
+		if lc.FaceWeights != nil {
+			weight = 0
+			if int(face) <= len(lc.FaceWeights) && lc.FaceWeights[face-1] > 0 {
+				weight = float64(lc.FaceWeights[face-1])
+			}
+		}
+		faceProbabilities[face] = weight
+		totalWeight += weight
+	}
+	if totalWeight == 0 {
+		return 0
+	}
+
+	// the probability of every sum, adding one die at a time
+	sums := map[int32]float64{0: 1}
+	for range count {
+		next := map[int32]float64{}
+		for sum, probability := range sums {
+			for face := int32(1); face <= sides; face++ {
+				if faceProbabilities[face] > 0 {
+					next[sum+face] += probability * faceProbabilities[face] / totalWeight
+				}
+			}
+		}
+		sums = next
+	}
+
+	return sums[roll]
+}
+
+// WinProbability returns the probability of hitting the target of the level within its total rolls
+func (lc *LevelConfig) WinProbability() float64 {
+	return 1 - math.Pow(1-lc.RollProbability(lc.Target), float64(lc.TotalRolls))
+}
+
+// kinds of items sold in the shop
+const (
+	ShopItemKindEnergyPack  = "energy-pack"
+	ShopItemKindDiceSkin    = "dice-skin"
+	ShopItemKindSkipTicket  = "skip-ticket"
+	ShopItemKindEnergyBoost = "energy-boost"
+)
+
+// SkipTicketItemID is the id of the skip ticket item, which a player can use to unlock the next level without winning the current one
+const SkipTicketItemID = "skip-ticket"
+
+// ShopItemConfig holds the settings of a single shop item,
+// EnergyAmount is only used by energy packs, RegenMultiplier and BoostSeconds only by energy boosts
+type ShopItemConfig struct {
+	ItemID          string `json:"itemID"`
+	Name            string `json:"name"`
+	Kind            string `json:"kind"`
+	Price           int64  `json:"price"`
+	EnergyAmount    int32  `json:"energyAmount,omitempty"`
+	RegenMultiplier int32  `json:"regenMultiplier,omitempty"`
+	BoostSeconds    int64  `json:"boostSeconds,omitempty"`
+}
+
+// MatchConfig holds the settings of head-to-head matches: both players roll the default dice
+// for the same (random) target, and have to submit their rolls before the timeout.
+// Match results update the (ELO) ratings of the players, starting from the default rating
+type MatchConfig struct {
+	TotalRolls         int32 `json:"totalRolls"`
+	WinnerEnergyReward int32 `json:"winnerEnergyReward"`
+	WinnerCoinReward   int64 `json:"winnerCoinReward"`
+	TimeoutSeconds     int32 `json:"timeoutSeconds"`
+	DefaultRating      int32 `json:"defaultRating"`
+	RatingKFactor      int32 `json:"ratingKFactor"`
+}
+
+// ReferralConfig holds the rewards of the referral program, given to both the referrer and the referee
+// once the referee wins their first level
+type ReferralConfig struct {
+	ReferrerEnergyReward int32 `json:"referrerEnergyReward"`
+	ReferrerCoinReward   int64 `json:"referrerCoinReward"`
+	RefereeEnergyReward  int32 `json:"refereeEnergyReward"`
+	RefereeCoinReward    int64 `json:"refereeCoinReward"`
+}
+
+// DifficultyConfig holds the settings of the dynamic difficulty (when it is enabled in the gameplay service), which adjusts
+// the levels for each player based on their win rate over their latest RecentAttempts attempts (at any level).
+// Players with fewer than MinAttempts attempts are not adjusted, the others get the first step their win rate is within
+type DifficultyConfig struct {
+	RecentAttempts int32            `json:"recentAttempts"`
+	MinAttempts    int32            `json:"minAttempts"`
+	Steps          []DifficultyStep `json:"steps"`
+}
+
+// PrestigeConfig holds the prestige ranks: players at the last level can prestige (go back to the default level)
+// to reach the next rank, which multiplies the energy rewards of their level wins by its reward multiplier for good.
+// Rank N has the Nth reward multiplier, so there are as many ranks as multipliers (none turns prestige off)
+type PrestigeConfig struct {
+	RewardMultipliers []float64 `json:"rewardMultipliers"`
 }
 
+// MaxRank returns the highest prestige rank
+func (pc *PrestigeConfig) MaxRank() int32 {
+	return int32(len(pc.RewardMultipliers))
+}
+
+// RewardMultiplier returns the reward multiplier of the given prestige rank, which is 1 for players who never
+// prestiged (ranks above the highest one, like after the config dropped a rank, keep the highest multiplier)
+func (pc *PrestigeConfig) RewardMultiplier(rank int32) float64 {
+
+	if rank <= 0 || len(pc.RewardMultipliers) == 0 {
+		return 1
+	}
+
+	return pc.RewardMultipliers[min(rank, pc.MaxRank())-1]
+}
+
+// secondsPerDay is used to convert the days of the player segments
+const secondsPerDay = 24 * 60 * 60
+
+// the segments a player can be in, based on their activity, the players in neither of them are in the default segment (blank)
+const (
+	SegmentNew    = "new"    // players created within the last NewPlayerDays days
+	SegmentLapsed = "lapsed" // players who came back within the last ReturnDays days, after at least LapsedDays days away
+)
+
+// SegmentConfig holds the player segments, and the energy tuning of the segments (when the energy-segments flag is on
+// for a player, see FlagEnergySegments). Players who are new and lapsed at once are in the new segment,
+// and the segments without tuning (like the default segment) play with the energy of the levels as configured
+type SegmentConfig struct {
+	NewPlayerDays int32           `json:"newPlayerDays"`
+	LapsedDays    int32           `json:"lapsedDays"`
+	ReturnDays    int32           `json:"returnDays"`
+	Tuning        []SegmentTuning `json:"tuning"`
+}
+
+// SegmentTuning multiplies the energy cost of entering a level, and the energy reward of winning it,
+// for the players in the segment (the results are rounded, and a cost never goes below 1)
+type SegmentTuning struct {
+	Segment                string  `json:"segment"`
+	EnergyCostMultiplier   float64 `json:"energyCostMultiplier"`
+	EnergyRewardMultiplier float64 `json:"energyRewardMultiplier"`
+}
+
+// SegmentOf returns the segment of a player created at the given (unix) time, who came back after a lapse at the given time
+// (0 if they never did), at the given time. A player created before the creation time was tracked (0) is never new
+func (sc *SegmentConfig) SegmentOf(createdTime int64, returnTime int64, now int64) string {
+
+	switch {
+	case createdTime > 0 && now-createdTime < int64(sc.NewPlayerDays)*secondsPerDay:
+		return SegmentNew
+	case returnTime > 0 && now-returnTime < int64(sc.ReturnDays)*secondsPerDay:
+		return SegmentLapsed
+	default:
+		return ""
+	}
+}
+
+// Lapsed returns whether a player who was last updated at the given (unix) time is coming back after a lapse at the given time
+func (sc *SegmentConfig) Lapsed(lastUpdateTime int64, now int64) bool {
+	return sc.LapsedDays > 0 && lastUpdateTime > 0 && now-lastUpdateTime >= int64(sc.LapsedDays)*secondsPerDay
+}
+
+// EnergyCost returns the energy cost of a level with the given cost, for the players in the given segment
+func (sc *SegmentConfig) EnergyCost(segment string, energyCost int32) int32 {
+
+	tuning, ok := sc.tuningOf(segment)
+	if !ok {
+		return energyCost
+	}
+
+	return max(int32(math.Round(float64(energyCost)*tuning.EnergyCostMultiplier)), 1)
+}
+
+// EnergyReward returns the energy reward of a level with the given reward, for the players in the given segment
+func (sc *SegmentConfig) EnergyReward(segment string, energyReward int32) int32 {
+	return rules.ScaleReward(energyReward, sc.EnergyRewardMultiplier(segment))
+}
+
+// EnergyRewardMultiplier returns the multiplier of the energy rewards of the players in the given segment (1, if it has no tuning)
+func (sc *SegmentConfig) EnergyRewardMultiplier(segment string) float64 {
+
+	tuning, ok := sc.tuningOf(segment)
+	if !ok {
+		return 1
+	}
+
+	return tuning.EnergyRewardMultiplier
+}
+
+// tuningOf returns the tuning of the given segment, if it has one
+func (sc *SegmentConfig) tuningOf(segment string) (*SegmentTuning, bool) {
+
+	for i := range sc.Tuning {
+		if sc.Tuning[i].Segment == segment {
+			return &sc.Tuning[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// BonusConfig holds the bonus rules of level wins: a win on the first roll gets FirstRollEnergy extra energy, and each
+// win in a row (at any level) raises the combo multiplier of the player by ComboStep, up to MaxComboMultiplier, which
+// multiplies the energy reward of their next win. A loss resets the combo (a ComboStep of 0 turns combos off)
+type BonusConfig struct {
+	FirstRollEnergy    int32   `json:"firstRollEnergy"`
+	ComboStep          float64 `json:"comboStep"`
+	MaxComboMultiplier float64 `json:"maxComboMultiplier"`
+}
+
+// NextComboMultiplier returns the combo multiplier of a player after a win, given their combo multiplier before it
+// (0 when they have no combo yet)
+func (bc *BonusConfig) NextComboMultiplier(comboMultiplier float64) float64 {
+	return bc.Rules().NextComboMultiplier(comboMultiplier)
+}
+
+// Rules returns the bonus rules, as used by the rules package
+func (bc *BonusConfig) Rules() rules.Bonuses {
+	return rules.Bonuses{FirstRollEnergy: bc.FirstRollEnergy, ComboStep: bc.ComboStep, MaxComboMultiplier: bc.MaxComboMultiplier}
+}
+
+// ActionThrottleConfig limits an action of a player to MaxActions in any WindowSeconds (a MaxActions of 0 is no limit)
+type ActionThrottleConfig struct {
+	MaxActions    int32 `json:"maxActions"`
+	WindowSeconds int64 `json:"windowSeconds"`
+}
+
+// ThrottleConfig holds the soft rate limits of the gameplay actions of each player (level entries and level results),
+// which blunt automation and clients stuck in a loop, they are only enforced when the gameplay throttles are enabled
+type ThrottleConfig struct {
+	Entry  ActionThrottleConfig `json:"entry"`
+	Result ActionThrottleConfig `json:"result"`
+}
+
+// PlatformClientConfig holds the oldest version of the client of a platform (like ios or android) which can still log in,
+// and the store url where outdated clients get the update. Versions are dot separated numbers, like 1.4.2
+type PlatformClientConfig struct {
+	Platform   string `json:"platform"`
+	MinVersion string `json:"minVersion"`
+	StoreURL   string `json:"storeURL"`
+}
+
+// ClientConfig is the compatibility matrix of the game clients, one entry per platform.
+// Clients of platforms which are not in it, or which do not send their version, are not gated
+type ClientConfig struct {
+	Platforms []PlatformClientConfig `json:"platforms"`
+}
+
+// kinds of progression milestones, by the progress they track
+const (
+	MilestoneKindLevel     = "level"      // the highest level reached (the level after the highest one won)
+	MilestoneKindWins      = "wins"       // the levels won, in total
+	MilestoneKindWinStreak = "win-streak" // the levels won in a row, at any level
+)
+
+// MilestoneConfig holds a progression milestone, which a player reaches once their progress of its kind gets to its target
+// (it stays reached, even if the progress drops later, like a win streak), its rewards are granted when the player claims it
+type MilestoneConfig struct {
+	MilestoneID  string `json:"milestoneID"`
+	Name         string `json:"name"`
+	Kind         string `json:"kind"`
+	Target       int32  `json:"target"`
+	EnergyReward int32  `json:"energyReward"`
+	CoinReward   int64  `json:"coinReward"`
+}
+
+// DifficultyStep adjusts the target and the total rolls of a level, for players whose recent win rate is
+// between MinWinRate and MaxWinRate (both inclusive). Extra rolls make a level easier, while the effect of moving
+// the target depends on the dice of the level (a target which cannot be rolled with them is left as it is)
+type DifficultyStep struct {
+	MinWinRate  float64 `json:"minWinRate"`
+	MaxWinRate  float64 `json:"maxWinRate"`
+	TargetDelta int32   `json:"targetDelta"`
+	RollsDelta  int32   `json:"rollsDelta"`
+}
+
+// GameConfig holds all the settings of the game, the levels should be read with Level() and LevelCount(),
+// since new levels can be added while the services are running (see EnableLevelContentFromEnv).
+// Energy rewards above the max energy are banked up to the EnergyBankCap (0 turns the energy bank off),
+// and FTUESteps is the number of steps of the first time user experience (the tutorial), which levels can require
 type GameConfig struct {
-	Levels             []LevelConfig `json:"levels"`
-	DefaultLevel       int32         `json:"defaultLevel"`
-	MaxEnergy          int32         `json:"maxEnergy"`
-	EnergyRegenSeconds int32         `json:"energyRegenSeconds"`
-	DefaultLevelScore  int32         `json:"defaultLevelScore"`
+	Levels             []LevelConfig     `json:"levels"`
+	DefaultLevel       int32             `json:"defaultLevel"`
+	MaxEnergy          int32             `json:"maxEnergy"`
+	EnergyRegenSeconds int32             `json:"energyRegenSeconds"`
+	EnergyBankCap      int32             `json:"energyBankCap"`
+	DefaultLevelScore  int32             `json:"defaultLevelScore"`
+	DefaultCoins       int64             `json:"defaultCoins"`
+	FTUESteps          int32             `json:"ftueSteps"`
+	ShopItems          []ShopItemConfig  `json:"shopItems"`
+	Match              MatchConfig       `json:"match"`
+	Referral           ReferralConfig    `json:"referral"`
+	Difficulty         DifficultyConfig  `json:"difficulty"`
+	Prestige           PrestigeConfig    `json:"prestige"`
+	Segments           SegmentConfig     `json:"segments"`
+	Milestones         []MilestoneConfig `json:"milestones"`
+	Bonuses            BonusConfig       `json:"bonuses"`
+	Throttles          ThrottleConfig    `json:"throttles"`
+	Clients            ClientConfig      `json:"clients"`
+
+	levelsMutex sync.RWMutex
+}
+
+// ShopItem returns the config of the shop item with the given id
+func (gc *GameConfig) ShopItem(itemID string) (*ShopItemConfig, bool) {
+
+	for i := range gc.ShopItems {
+		if gc.ShopItems[i].ItemID == itemID {
+			return &gc.ShopItems[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Milestone returns the config of the milestone with the given id
+func (gc *GameConfig) Milestone(milestoneID string) (*MilestoneConfig, bool) {
+
+	for i := range gc.Milestones {
+		if gc.Milestones[i].MilestoneID == milestoneID {
+			return &gc.Milestones[i], true
+		}
+	}
+
+	return nil, false
 }
 
 // Server is the core config service provider
 type Server struct {
 	requestValidator validation.RequestValidator
-	logger           *log.Logger
+
+	// used to sign the config served to clients
+	signingKey ed25519.PrivateKey
+
+	// the problems found in the game config when the server was created, an invalid config is not served
+	configErr error
+
+	// the feature flags served to the other services (see DefaultFlags)
+	flags      map[string]FlagValue
+	flagsMutex sync.RWMutex
+
+	// the announcements scheduled by admins, and a channel which is closed (and replaced) every time they change
+	announcements        map[string]*Announcement
+	announcementsChanged chan struct{}
+	announcementsMutex   sync.RWMutex
+
+	// the staged rollout of a candidate config to a percentage of the sessions, nil when none is running (see StartRollout)
+	rollout      *configRollout
+	rolloutMutex sync.RWMutex
+
+	logger *log.Logger
 }
 
-// NewServer returns an initialized pointer to the config server
+// NewServer returns an initialized pointer to the config server, the game config is validated first
+// (see GameConfig.Validate), and if it is invalid, every problem is logged, and the server refuses to serve it
 func NewServer(rv validation.RequestValidator) *Server {
+
+	logger := log.New(redact.Stdout, "config: ", log.Ltime|log.LUTC|log.Lmsgprefix)
+
+	signingKey, err := newConfigSigningKey()
+	if err != nil {
+		logger.Printf("error: could not load the config signing key: %v, using a random key instead", err)
+		_, signingKey, _ = ed25519.GenerateKey(rand.Reader)
+	}
+
+	configErr := Config.Validate()
+	if invalidErr, ok := configErr.(InvalidConfigErr); ok {
+		logger.Printf("error: the game config is invalid, it will not be served till it is fixed, %v problem(s):", len(invalidErr.Problems))
+		for _, problem := range invalidErr.Problems {
+			logger.Printf("error: invalid game config: %v", problem)
+		}
+	}
+
 	return &Server{
 		requestValidator: rv,
-		logger:           log.New(os.Stdout, "config: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+
+		signingKey: signingKey,
+
+		configErr: configErr,
+
+		flags: maps.Clone(DefaultFlags),
+
+		announcements:        map[string]*Announcement{},
+		announcementsChanged: make(chan struct{}),
+
+		logger: logger,
 	}
 }
 
-// Config is the global config used across services, also provided to the client via the GetConfig() public API call
+// checkConfig responds with a 503 (and returns false) if the game config is invalid
+func (cs *Server) checkConfig(w http.ResponseWriter, r *http.Request) bool {
+
+	if cs.configErr == nil {
+		return true
+	}
+
+	errMsg := "error: the game config is not served: " + cs.configErr.Error()
+	cs.logger.Println(errMsg)
+	apierror.Write(w, r, http.StatusServiceUnavailable, apierror.CodeUnavailable)
+	return false
+}
+
+// Config is the global config used across services, also provided to the client via the GetConfig() public API call.
+// The levels are loaded from the level content files (see LoadLevels)
 var Config = &GameConfig{
-	Levels: []LevelConfig{
-		{Level: 1, EnergyCost: 3, TotalRolls: 2, Target: 6, EnergyReward: 5},
-		{Level: 2, EnergyCost: 3, TotalRolls: 3, Target: 4, EnergyReward: 5},
-		{Level: 3, EnergyCost: 4, TotalRolls: 4, Target: 2, EnergyReward: 6},
-		{Level: 4, EnergyCost: 4, TotalRolls: 3, Target: 1, EnergyReward: 6},
-		{Level: 5, EnergyCost: 4, TotalRolls: 2, Target: 5, EnergyReward: 6},
-		{Level: 6, EnergyCost: 5, TotalRolls: 4, Target: 3, EnergyReward: 7},
-		{Level: 7, EnergyCost: 5, TotalRolls: 3, Target: 4, EnergyReward: 7},
-		{Level: 8, EnergyCost: 5, TotalRolls: 2, Target: 1, EnergyReward: 7},
-		{Level: 9, EnergyCost: 6, TotalRolls: 4, Target: 2, EnergyReward: 8},
-		{Level: 10, EnergyCost: 6, TotalRolls: 3, Target: 6, EnergyReward: 8},
-	},
 	DefaultLevel:       1,
 	MaxEnergy:          50,
 	EnergyRegenSeconds: 5,
 	DefaultLevelScore:  99,
+	DefaultCoins:       100,
+	FTUESteps:          3,
+	ShopItems: []ShopItemConfig{
+		{ItemID: "energy-small", Name: "Small Energy Pack", Kind: ShopItemKindEnergyPack, Price: 20, EnergyAmount: 10},
+		{ItemID: "energy-large", Name: "Large Energy Pack", Kind: ShopItemKindEnergyPack, Price: 50, EnergyAmount: 30},
+		{ItemID: "skin-golden", Name: "Golden Dice", Kind: ShopItemKindDiceSkin, Price: 150},
+		{ItemID: "skin-crystal", Name: "Crystal Dice", Kind: ShopItemKindDiceSkin, Price: 250},
+		{ItemID: SkipTicketItemID, Name: "Level Skip Ticket", Kind: ShopItemKindSkipTicket, Price: 80},
+		{ItemID: "boost-regen-2x", Name: "Double Energy Regen (1 hour)", Kind: ShopItemKindEnergyBoost, Price: 60, RegenMultiplier: 2, BoostSeconds: 3600},
+	},
+	Match:    MatchConfig{TotalRolls: 3, WinnerEnergyReward: 10, WinnerCoinReward: 20, TimeoutSeconds: 120, DefaultRating: 1000, RatingKFactor: 32},
+	Referral: ReferralConfig{ReferrerEnergyReward: 20, ReferrerCoinReward: 50, RefereeEnergyReward: 10, RefereeCoinReward: 50},
+	Difficulty: DifficultyConfig{RecentAttempts: 10, MinAttempts: 5, Steps: []DifficultyStep{
+		{MinWinRate: 0, MaxWinRate: 0.2, RollsDelta: 1},
+		{MinWinRate: 0.9, MaxWinRate: 1, RollsDelta: -1},
+	}},
+	Prestige: PrestigeConfig{RewardMultipliers: []float64{1.2, 1.5, 2}},
+	Segments: SegmentConfig{NewPlayerDays: 3, LapsedDays: 14, ReturnDays: 3, Tuning: []SegmentTuning{
+		{Segment: SegmentNew, EnergyCostMultiplier: 0.5, EnergyRewardMultiplier: 1},
+		{Segment: SegmentLapsed, EnergyCostMultiplier: 1, EnergyRewardMultiplier: 2},
+	}},
+	Milestones: []MilestoneConfig{
+		{MilestoneID: "reach-level-5", Name: "Reach Level 5", Kind: MilestoneKindLevel, Target: 5, EnergyReward: 20, CoinReward: 50},
+		{MilestoneID: "win-100", Name: "Win 100 Games", Kind: MilestoneKindWins, Target: 100, EnergyReward: 30, CoinReward: 200},
+		{MilestoneID: "win-streak-10", Name: "10 Win Streak", Kind: MilestoneKindWinStreak, Target: 10, EnergyReward: 30, CoinReward: 100},
+	},
+	Bonuses:   BonusConfig{FirstRollEnergy: 2, ComboStep: 0.1, MaxComboMultiplier: 1.5},
+	Throttles: ThrottleConfig{Entry: ActionThrottleConfig{MaxActions: 1, WindowSeconds: 2}, Result: ActionThrottleConfig{MaxActions: 20, WindowSeconds: 60}},
+	Clients: ClientConfig{Platforms: []PlatformClientConfig{
+		{Platform: "ios", MinVersion: "1.0.0", StoreURL: "https://apps.apple.com/app/dice-game"},
+		{Platform: "android", MinVersion: "1.0.0", StoreURL: "https://play.google.com/store/apps/details?id=com.example.dicegame"},
+	}},
 }
 
 // Run runs a given config server on the given port
 func (cs *Server) Run(port string) {
+	log.Fatal(cs.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the config server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (cs *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return cs.serve(ctx, listener)
+}
+
+// RunWithListener runs the config server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (cs *Server) RunWithListener(listener net.Listener) error {
+	return cs.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the config server, and serves them on the given listener till the context is done
+func (cs *Server) serve(ctx context.Context, listener net.Listener) error {
 
 	if cs == nil {
-		fmt.Println("the given config server pointer is nil")
+		return fmt.Errorf("the given config server pointer is nil")
 	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /config/game-config", cs.HandleConfigRequest)
+	mux.Handle("GET /config/game-config", middleware.WithLimits(validation.WithSession(cs.requestValidator, cs.HandleConfigRequest), middleware.DefaultLimits))
+	mux.Handle("GET /config/localized-config", middleware.WithLimits(validation.WithSession(cs.requestValidator, cs.HandleLocalizedConfigRequest), middleware.DefaultLimits))
+	mux.Handle("GET /config/public-key", middleware.WithLimits(validation.WithSession(cs.requestValidator, cs.HandlePublicKeyRequest), middleware.DefaultLimits))
+	mux.Handle("GET /config/server-time", middleware.WithLimits(validation.WithSession(cs.requestValidator, cs.HandleServerTimeRequest), middleware.DefaultLimits))
+	mux.Handle("GET /config/error-catalog", middleware.WithLimits(cs.HandleErrorCatalogRequest, middleware.DefaultLimits))
+	mux.Handle("POST /config/admin/reload", middleware.WithLimits(cs.HandleReloadLevelsRequest, middleware.DefaultLimits))
+	mux.Handle("GET /config/flags-internal", middleware.WithLimits(cs.HandleFlagsRequest, middleware.DefaultLimits))
+	mux.Handle("PUT /config/admin/flags/{name}", middleware.WithLimits(cs.HandleSetFlagRequest, middleware.DefaultLimits))
+	mux.Handle("POST /config/admin/config-diff", middleware.WithLimits(cs.HandleConfigDiffRequest, rolloutLimits))
+	mux.Handle("PUT /config/admin/rollout", middleware.WithLimits(cs.HandleStartRolloutRequest, rolloutLimits))
+	mux.Handle("GET /config/admin/rollout", middleware.WithLimits(cs.HandleRolloutStatusRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /config/admin/rollout", middleware.WithLimits(cs.HandleStopRolloutRequest, middleware.DefaultLimits))
+	mux.Handle("GET /config/announcements", middleware.WithLimits(validation.WithSession(cs.requestValidator, cs.HandleAnnouncementsRequest), middleware.DefaultLimits))
+	// the announcement events stream stays open while the client is connected, so it is not given a timeout
+	mux.Handle("GET /config/announcement-events", middleware.WithLimits(validation.WithSession(cs.requestValidator, cs.HandleAnnouncementEventsRequest), middleware.RouteLimits{MaxBodyBytes: constants.DefaultMaxRequestBodyBytes}))
+	mux.Handle("POST /config/admin/announcements", middleware.WithLimits(cs.HandleScheduleAnnouncementRequest, middleware.DefaultLimits))
+	mux.Handle("GET /config/admin/announcements", middleware.WithLimits(cs.HandleListAnnouncementsRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /config/admin/announcements/{id}", middleware.WithLimits(cs.HandleDeleteAnnouncementRequest, middleware.DefaultLimits))
 
 	cs.logger.Println("the config server is up and running...")
 
-	addr := constants.CommonHost + ":" + port
-	log.Fatal(http.ListenAndServe(addr, mux))
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("config", middleware.WithAccessLog("config", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
 }
 
-// HandleConfigRequest responds with a game config
+// HandleConfigRequest responds with a game config (signed, see HandlePublicKeyRequest), which is the candidate config
+// for the sessions in a staged rollout (see StartRollout), and the active config for the others
 func (cs *Server) HandleConfigRequest(w http.ResponseWriter, r *http.Request) {
 
 	if cs == nil {
-		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	cs.logger.Print("config requested... \n")
+
+	if !cs.checkConfig(w, r) {
 		return
 	}
 
-	err := cs.requestValidator.ValidateRequest(r)
+	body, err := cs.servedConfig(r.Context()).encode()
 	if err != nil {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
-		errMsg := "error: session validation error: " + err.Error()
+		errMsg := "error: could not encode game config"
 		cs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusUnauthorized)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
-	cs.logger.Print("config requested... \n")
+	cs.writeConfig(w, r, body)
+}
+
+// writeConfig writes the given encoded config with its ETag and signature (or a 304 if the client has it already)
+func (cs *Server) writeConfig(w http.ResponseWriter, r *http.Request, body []byte) {
+
+	// the ETag is a hash of the encoded config, so a client which already has this config
+	// (and sends its ETag in the If-None-Match header) gets a 304 without the body
+	etag := configETag(body)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", configCacheControl)
+
+	// the signature of the body lets clients verify that the config was not tampered with on the way
+	w.Header().Set(ConfigSignatureHeader, cs.signConfig(body))
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	err = json.NewEncoder(w).Encode(Config)
+	_, err := w.Write(body)
 	if err != nil {
-		errMsg := "error: could not encode game config"
+		errMsg := "error: could not write game config"
 		cs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// configETag returns the (strong) ETag of the given encoded config
+func configETag(body []byte) string {
+	hash := sha256.Sum256(body)
+	return "\"" + hex.EncodeToString(hash[:16]) + "\""
+}
+
+// etagMatches returns whether the given If-None-Match header value matches the given ETag,
+// the header can hold a comma separated list of (possibly weak) ETags, or "*"
+func etagMatches(ifNoneMatch string, etag string) bool {
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
 	}
+
+	return false
 }
@@ -1,18 +1,34 @@
 package config
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
 	"example.com/dice-game-backend/internal/shared/testsetup"
+	"fmt"
+	"io/fs"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestNewConfigServer(t *testing.T) {
 
-	configServer := NewServer(auth.NewServer())
+	configServer := NewServer(auth.NewServer(data.NewServer()))
 
 	if configServer == nil {
 		t.Fatal("new config server should not return a nil server pointer")
@@ -32,6 +48,11 @@ func TestBasicConfigValidation(t *testing.T) {
 		t.Fatal("config should not contain a game config with empty levels")
 	}
 
+	err := Config.Validate()
+	if err != nil {
+		t.Errorf("the game config should be valid, got: %v", err)
+	}
+
 	// additional value checks
 	if Config.DefaultLevel <= 0 {
 		t.Errorf("invalid default level in the config: %v, value should be greater than 0", Config.DefaultLevel)
@@ -64,8 +85,31 @@ func TestBasicConfigValidation(t *testing.T) {
 			t.Errorf("invalid total rolls for level %v in the config: %v, value should be greater than 0", val.Level, val.TotalRolls)
 		}
 
-		if val.Target < 1 || val.Target > 6 {
-			t.Errorf("invalid target for level %v in the config: %v, value should be between 1 and 6", val.Level, val.Target)
+		if val.DiceSides < 0 || val.DiceCount < 0 {
+			t.Errorf("invalid dice for level %v in the config: %v sides, %v count, values cannot be negative", val.Level, val.DiceSides, val.DiceCount)
+		}
+
+		sides, _ := val.Dice()
+		if val.FaceWeights != nil {
+			if int32(len(val.FaceWeights)) != sides {
+				t.Errorf("invalid face weights for level %v in the config: %v, there should be one weight per side (%v)", val.Level, val.FaceWeights, sides)
+			}
+
+			weightSum := int32(0)
+			for _, weight := range val.FaceWeights {
+				if weight < 0 {
+					t.Errorf("invalid face weights for level %v in the config: %v, weights cannot be negative", val.Level, val.FaceWeights)
+				}
+				weightSum += weight
+			}
+
+			if weightSum <= 0 {
+				t.Errorf("invalid face weights for level %v in the config: %v, at least one weight should be greater than 0", val.Level, val.FaceWeights)
+			}
+		}
+
+		if !val.IsValidRoll(val.Target) {
+			t.Errorf("invalid target for level %v in the config: %v, value should be possible to roll with the level's dice", val.Level, val.Target)
 		}
 
 		if val.EnergyReward <= 0 {
@@ -76,6 +120,108 @@ func TestBasicConfigValidation(t *testing.T) {
 			t.Errorf("invalid energy reward for level %v in the config: %v, value should be less than player's maximum energy (%v)", val.Level, val.EnergyReward, Config.MaxEnergy)
 		}
 	}
+
+	if Config.DefaultCoins < 0 {
+		t.Errorf("invalid default coins in the config: %v, value cannot be negative", Config.DefaultCoins)
+	}
+
+	if Config.Match.TotalRolls <= 0 || Config.Match.TimeoutSeconds <= 0 {
+		t.Errorf("invalid match config: %v, total rolls and timeout seconds should be greater than 0", Config.Match)
+	}
+
+	if Config.Match.WinnerEnergyReward < 0 || Config.Match.WinnerCoinReward < 0 {
+		t.Errorf("invalid match config: %v, rewards cannot be negative", Config.Match)
+	}
+
+	if Config.Match.DefaultRating <= 0 || Config.Match.RatingKFactor <= 0 {
+		t.Errorf("invalid match config: %v, default rating and rating k-factor should be greater than 0", Config.Match)
+	}
+
+	if Config.Referral.ReferrerEnergyReward < 0 || Config.Referral.ReferrerCoinReward < 0 ||
+		Config.Referral.RefereeEnergyReward < 0 || Config.Referral.RefereeCoinReward < 0 {
+		t.Errorf("invalid referral config: %v, rewards cannot be negative", Config.Referral)
+	}
+
+	// per shop item checks
+	itemIDs := map[string]bool{}
+	for _, val := range Config.ShopItems {
+		if val.ItemID == "" || itemIDs[val.ItemID] {
+			t.Errorf("invalid id for shop item %v in the config, value should be unique and not blank", val.Name)
+		}
+		itemIDs[val.ItemID] = true
+
+		if val.Price <= 0 {
+			t.Errorf("invalid price for shop item %v in the config: %v, value should be greater than 0", val.ItemID, val.Price)
+		}
+
+		switch val.Kind {
+		case ShopItemKindEnergyPack:
+			if val.EnergyAmount <= 0 {
+				t.Errorf("invalid energy amount for shop item %v in the config: %v, value should be greater than 0", val.ItemID, val.EnergyAmount)
+			}
+		case ShopItemKindEnergyBoost:
+			if val.RegenMultiplier <= 1 || val.BoostSeconds <= 0 {
+				t.Errorf("invalid boost for shop item %v in the config: %v, %v, the regen multiplier should be greater than 1 and the duration greater than 0", val.ItemID, val.RegenMultiplier, val.BoostSeconds)
+			}
+		case ShopItemKindDiceSkin, ShopItemKindSkipTicket:
+		default:
+			t.Errorf("invalid kind for shop item %v in the config: %v", val.ItemID, val.Kind)
+		}
+	}
+}
+
+func TestLevelConfig_IsValidRoll(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		levelConfig *LevelConfig
+		roll        int32
+		want        bool
+	}{
+		{"default dice, valid roll", &LevelConfig{Level: 1}, 6, true},
+		{"default dice, roll too high", &LevelConfig{Level: 1}, 7, false},
+		{"default dice, roll too low", &LevelConfig{Level: 1}, 0, false},
+		{"d20, valid roll", &LevelConfig{Level: 1, DiceSides: 20, DiceCount: 1}, 17, true},
+		{"two d6, valid roll", &LevelConfig{Level: 1, DiceSides: 6, DiceCount: 2}, 12, true},
+		{"two d6, roll too low", &LevelConfig{Level: 1, DiceSides: 6, DiceCount: 2}, 1, false},
+		{"weighted, possible face", &LevelConfig{Level: 1, DiceSides: 4, DiceCount: 1, FaceWeights: []int32{1, 0, 2, 1}}, 3, true},
+		{"weighted, zero weight face", &LevelConfig{Level: 1, DiceSides: 4, DiceCount: 1, FaceWeights: []int32{1, 0, 2, 1}}, 2, false},
+		{"weighted two dice, possible sum", &LevelConfig{Level: 1, DiceSides: 3, DiceCount: 2, FaceWeights: []int32{0, 1, 1}}, 5, true},
+		{"weighted two dice, impossible sum", &LevelConfig{Level: 1, DiceSides: 3, DiceCount: 2, FaceWeights: []int32{0, 1, 1}}, 3, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.levelConfig.IsValidRoll(test.roll)
+			if got != test.want {
+				t.Errorf("IsValidRoll gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLevelConfig_WinProbability(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		levelConfig *LevelConfig
+		want        float64
+	}{
+		{"default dice, one roll", &LevelConfig{Level: 1, TotalRolls: 1, Target: 3}, 1.0 / 6},
+		{"default dice, two rolls", &LevelConfig{Level: 1, TotalRolls: 2, Target: 3}, 11.0 / 36},
+		{"two d6, one roll", &LevelConfig{Level: 1, TotalRolls: 1, Target: 7, DiceSides: 6, DiceCount: 2}, 6.0 / 36},
+		{"weighted, one roll", &LevelConfig{Level: 1, TotalRolls: 1, Target: 3, DiceSides: 4, DiceCount: 1, FaceWeights: []int32{1, 0, 2, 1}}, 0.5},
+		{"weighted, impossible target", &LevelConfig{Level: 1, TotalRolls: 3, Target: 2, DiceSides: 4, DiceCount: 1, FaceWeights: []int32{1, 0, 2, 1}}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.levelConfig.WinProbability()
+			if math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("WinProbability gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
 }
 
 func TestHandleConfigRequest(t *testing.T) {
@@ -101,21 +247,53 @@ func TestHandleConfigRequest(t *testing.T) {
 		{"valid server, blank session id", cs2, "", http.StatusUnauthorized, "application/json", nil},
 		{"valid server, valid session id", cs2, sID, http.StatusOK, "application/json", &GameConfig{
 			Levels: []LevelConfig{
-				{Level: 1, EnergyCost: 3, TotalRolls: 2, Target: 6, EnergyReward: 5},
-				{Level: 2, EnergyCost: 3, TotalRolls: 3, Target: 4, EnergyReward: 5},
-				{Level: 3, EnergyCost: 4, TotalRolls: 4, Target: 2, EnergyReward: 6},
-				{Level: 4, EnergyCost: 4, TotalRolls: 3, Target: 1, EnergyReward: 6},
-				{Level: 5, EnergyCost: 4, TotalRolls: 2, Target: 5, EnergyReward: 6},
-				{Level: 6, EnergyCost: 5, TotalRolls: 4, Target: 3, EnergyReward: 7},
-				{Level: 7, EnergyCost: 5, TotalRolls: 3, Target: 4, EnergyReward: 7},
-				{Level: 8, EnergyCost: 5, TotalRolls: 2, Target: 1, EnergyReward: 7},
-				{Level: 9, EnergyCost: 6, TotalRolls: 4, Target: 2, EnergyReward: 8},
-				{Level: 10, EnergyCost: 6, TotalRolls: 3, Target: 6, EnergyReward: 8},
+				{Level: 1, EnergyCost: 3, TotalRolls: 2, Target: 6, EnergyReward: 5, DiceSides: 6, DiceCount: 1},
+				{Level: 2, EnergyCost: 3, TotalRolls: 3, Target: 4, EnergyReward: 5, DiceSides: 6, DiceCount: 1},
+				{Level: 3, EnergyCost: 4, TotalRolls: 4, Target: 2, EnergyReward: 6, DiceSides: 6, DiceCount: 1},
+				{Level: 4, EnergyCost: 4, TotalRolls: 3, Target: 1, EnergyReward: 6, DiceSides: 6, DiceCount: 1},
+				{Level: 5, EnergyCost: 4, TotalRolls: 2, Target: 5, EnergyReward: 6, DiceSides: 6, DiceCount: 1},
+				{Level: 6, EnergyCost: 5, TotalRolls: 4, Target: 3, EnergyReward: 7, DiceSides: 6, DiceCount: 1},
+				{Level: 7, EnergyCost: 5, TotalRolls: 3, Target: 4, EnergyReward: 7, DiceSides: 6, DiceCount: 1},
+				{Level: 8, EnergyCost: 5, TotalRolls: 2, Target: 1, EnergyReward: 7, DiceSides: 6, DiceCount: 1},
+				{Level: 9, EnergyCost: 6, TotalRolls: 4, Target: 2, EnergyReward: 8, DiceSides: 6, DiceCount: 1},
+				{Level: 10, EnergyCost: 6, TotalRolls: 3, Target: 6, EnergyReward: 8, DiceSides: 6, DiceCount: 1},
 			},
 			DefaultLevel:       1,
 			MaxEnergy:          50,
 			EnergyRegenSeconds: 5,
 			DefaultLevelScore:  99,
+			DefaultCoins:       100,
+			FTUESteps:          3,
+			ShopItems: []ShopItemConfig{
+				{ItemID: "energy-small", Name: "Small Energy Pack", Kind: ShopItemKindEnergyPack, Price: 20, EnergyAmount: 10},
+				{ItemID: "energy-large", Name: "Large Energy Pack", Kind: ShopItemKindEnergyPack, Price: 50, EnergyAmount: 30},
+				{ItemID: "skin-golden", Name: "Golden Dice", Kind: ShopItemKindDiceSkin, Price: 150},
+				{ItemID: "skin-crystal", Name: "Crystal Dice", Kind: ShopItemKindDiceSkin, Price: 250},
+				{ItemID: "skip-ticket", Name: "Level Skip Ticket", Kind: ShopItemKindSkipTicket, Price: 80},
+				{ItemID: "boost-regen-2x", Name: "Double Energy Regen (1 hour)", Kind: ShopItemKindEnergyBoost, Price: 60, RegenMultiplier: 2, BoostSeconds: 3600},
+			},
+			Match:    MatchConfig{TotalRolls: 3, WinnerEnergyReward: 10, WinnerCoinReward: 20, TimeoutSeconds: 120, DefaultRating: 1000, RatingKFactor: 32},
+			Referral: ReferralConfig{ReferrerEnergyReward: 20, ReferrerCoinReward: 50, RefereeEnergyReward: 10, RefereeCoinReward: 50},
+			Difficulty: DifficultyConfig{RecentAttempts: 10, MinAttempts: 5, Steps: []DifficultyStep{
+				{MinWinRate: 0, MaxWinRate: 0.2, RollsDelta: 1},
+				{MinWinRate: 0.9, MaxWinRate: 1, RollsDelta: -1},
+			}},
+			Prestige: PrestigeConfig{RewardMultipliers: []float64{1.2, 1.5, 2}},
+			Segments: SegmentConfig{NewPlayerDays: 3, LapsedDays: 14, ReturnDays: 3, Tuning: []SegmentTuning{
+				{Segment: SegmentNew, EnergyCostMultiplier: 0.5, EnergyRewardMultiplier: 1},
+				{Segment: SegmentLapsed, EnergyCostMultiplier: 1, EnergyRewardMultiplier: 2},
+			}},
+			Milestones: []MilestoneConfig{
+				{MilestoneID: "reach-level-5", Name: "Reach Level 5", Kind: MilestoneKindLevel, Target: 5, EnergyReward: 20, CoinReward: 50},
+				{MilestoneID: "win-100", Name: "Win 100 Games", Kind: MilestoneKindWins, Target: 100, EnergyReward: 30, CoinReward: 200},
+				{MilestoneID: "win-streak-10", Name: "10 Win Streak", Kind: MilestoneKindWinStreak, Target: 10, EnergyReward: 30, CoinReward: 100},
+			},
+			Bonuses:   BonusConfig{FirstRollEnergy: 2, ComboStep: 0.1, MaxComboMultiplier: 1.5},
+			Throttles: ThrottleConfig{Entry: ActionThrottleConfig{MaxActions: 1, WindowSeconds: 2}, Result: ActionThrottleConfig{MaxActions: 20, WindowSeconds: 60}},
+			Clients: ClientConfig{Platforms: []PlatformClientConfig{
+				{Platform: "ios", MinVersion: "1.0.0", StoreURL: "https://apps.apple.com/app/dice-game"},
+				{Platform: "android", MinVersion: "1.0.0", StoreURL: "https://play.google.com/store/apps/details?id=com.example.dicegame"},
+			}},
 		}},
 	}
 
@@ -127,7 +305,7 @@ func TestHandleConfigRequest(t *testing.T) {
 			respRec := httptest.NewRecorder()
 
 			configServer := test.server
-			configServer.HandleConfigRequest(respRec, newReq)
+			testsetup.WithSession(configServer, as, configServer.HandleConfigRequest)(respRec, newReq)
 
 			gotStatus := respRec.Result().StatusCode
 
@@ -155,3 +333,1375 @@ func TestHandleConfigRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleLocalizedConfigRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs := NewServer(as)
+
+	tests := []struct {
+		name             string
+		acceptLanguage   string
+		wantLanguage     string
+		wantLevelName    string
+		wantShopItemName string
+	}{
+		{"no language", "", "en", "First Roll", "Small Energy Pack"},
+		{"spanish", "es-MX,es;q=0.9", "es", "Primera Tirada", "Paquete de Energía Pequeño"},
+		{"unsupported language", "fr", "en", "First Roll", "Small Energy Pack"},
+	}
+
+	etags := map[string]string{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/config/localized-config", nil)
+			newReq.Header.Set("Session-Id", sID)
+			newReq.Header.Set("Accept-Language", test.acceptLanguage)
+			respRec := httptest.NewRecorder()
+
+			testsetup.WithSession(cs, as, cs.HandleLocalizedConfigRequest)(respRec, newReq)
+
+			if respRec.Code != http.StatusOK {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusOK, respRec.Code)
+			}
+
+			gotLanguage := respRec.Header().Get("Content-Language")
+			if gotLanguage != test.wantLanguage {
+				t.Errorf("handler gave incorrect content language, want: %v, got: %v", test.wantLanguage, gotLanguage)
+			}
+
+			gotConfig := &GameConfig{}
+			err = json.NewDecoder(respRec.Body).Decode(gotConfig)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			if gotConfig.Levels[0].Name != test.wantLevelName || gotConfig.Levels[0].Description == "" {
+				t.Errorf("handler gave incorrect level text, want name: %v, got: %v", test.wantLevelName, gotConfig.Levels[0])
+			}
+			if gotConfig.ShopItems[0].Name != test.wantShopItemName {
+				t.Errorf("handler gave incorrect shop item name, want: %v, got: %v", test.wantShopItemName, gotConfig.ShopItems[0].Name)
+			}
+
+			// each language is its own version of the config
+			etags[gotLanguage] = respRec.Header().Get("ETag")
+		})
+	}
+
+	if len(etags) != 2 || etags["en"] == etags["es"] {
+		t.Errorf("handler should give a different etag per language, got: %v", etags)
+	}
+
+	// the plain config is left as it was
+	if Config.Levels[0].Name != "" || Config.ShopItems[0].Name != "Small Energy Pack" {
+		t.Errorf("the localized config should not change the game config")
+	}
+}
+
+func TestHandleConfigRequest_ETag(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs := NewServer(as)
+
+	// the first request gets the ETag of the current config
+	newReq := httptest.NewRequest(http.MethodGet, "/config/game-config", nil)
+	newReq.Header.Set("Session-Id", sID)
+	respRec := httptest.NewRecorder()
+	testsetup.WithSession(cs, as, cs.HandleConfigRequest)(respRec, newReq)
+
+	etag := respRec.Result().Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("config response should have an ETag header")
+	}
+
+	gotCacheControl := respRec.Result().Header.Get("Cache-Control")
+	if gotCacheControl != configCacheControl {
+		t.Errorf("handler gave incorrect cache control header, want: %v, got: %v", configCacheControl, gotCacheControl)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"no If-None-Match", "", http.StatusOK},
+		{"stale ETag", "\"0123456789abcdef\"", http.StatusOK},
+		{"matching ETag", etag, http.StatusNotModified},
+		{"matching weak ETag", "W/" + etag, http.StatusNotModified},
+		{"matching ETag in a list", "\"0123456789abcdef\", " + etag, http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq = httptest.NewRequest(http.MethodGet, "/config/game-config", nil)
+			newReq.Header.Set("Session-Id", sID)
+			if test.ifNoneMatch != "" {
+				newReq.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+			respRec = httptest.NewRecorder()
+
+			testsetup.WithSession(cs, as, cs.HandleConfigRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusNotModified && respRec.Body.Len() != 0 {
+				t.Errorf("a not modified response should not have a body")
+			}
+
+			gotETag := respRec.Result().Header.Get("ETag")
+			if gotETag != etag {
+				t.Errorf("handler gave incorrect ETag, want: %v, got: %v", etag, gotETag)
+			}
+		})
+	}
+}
+
+func TestHandlePublicKeyRequest(t *testing.T) {
+
+	var cs1, cs2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs2 = NewServer(as)
+
+	tests := []struct {
+		name       string
+		server     *Server
+		sessionID  string
+		wantStatus int
+	}{
+		{"nil server", cs1, "", http.StatusInternalServerError},
+		{"valid server, blank session id", cs2, "", http.StatusUnauthorized},
+		{"valid server, valid session id", cs2, sID, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/config/public-key", nil)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			configServer := test.server
+			testsetup.WithSession(configServer, as, configServer.HandlePublicKeyRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
+
+func TestHandleServerTimeRequest(t *testing.T) {
+
+	var cs1, cs2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs2 = NewServer(as)
+
+	tests := []struct {
+		name       string
+		server     *Server
+		sessionID  string
+		wantStatus int
+	}{
+		{"nil server", cs1, "", http.StatusInternalServerError},
+		{"valid server, blank session id", cs2, "", http.StatusUnauthorized},
+		{"valid server, valid session id", cs2, sID, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/config/server-time", nil)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			before := time.Now().UTC().UnixMilli()
+			configServer := test.server
+			testsetup.WithSession(configServer, as, configServer.HandleServerTimeRequest)(respRec, newReq)
+			after := time.Now().UTC().UnixMilli()
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &ServerTimeResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotResponseBody.ServerTimeMillis < before || gotResponseBody.ServerTimeMillis > after || gotResponseBody.ServerTime != gotResponseBody.ServerTimeMillis/1000 {
+					t.Errorf("handler gave an incorrect server time, want between: %v and %v, got: %+v", before, after, gotResponseBody)
+				}
+
+				if gotResponseBody.EnergyRegenSeconds != Config.EnergyRegenSeconds || gotResponseBody.MaxEnergy != Config.MaxEnergy || gotResponseBody.MaxDriftSeconds != constants.MaxClientTimeDriftSeconds {
+					t.Errorf("handler gave incorrect parameters, got: %+v", gotResponseBody)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_RunWithListener(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs := NewServer(as)
+
+	// the server runs on an ephemeral port, till its listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("could not listen on an ephemeral port: " + err.Error())
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- cs.RunWithListener(listener)
+	}()
+
+	newReq, err := http.NewRequest(http.MethodGet, "http://"+listener.Addr().String()+"/config/server-time", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReq.Header.Set("Session-Id", sID)
+
+	resp, err := http.DefaultClient.Do(newReq)
+	if err != nil {
+		t.Fatal("could not send the request: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("server gave incorrect results, want: %v, got: %v", http.StatusOK, resp.StatusCode)
+	}
+
+	_ = listener.Close()
+	if err = <-runErr; err == nil {
+		t.Errorf("RunWithListener() should fail once its listener is closed")
+	}
+}
+
+func TestHandleErrorCatalogRequest(t *testing.T) {
+
+	var cs1, cs2 *Server
+
+	as, _, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs2 = NewServer(as)
+
+	tests := []struct {
+		name           string
+		server         *Server
+		acceptLanguage string
+		wantStatus     int
+		wantLanguage   string
+		wantMessage    string
+	}{
+		{"nil server", cs1, "", http.StatusInternalServerError, "", ""},
+		{"default language, no session", cs2, "", http.StatusOK, "en", "this guild is full"},
+		{"spanish", cs2, "es-ES,es;q=0.9", http.StatusOK, "es", "este gremio está lleno"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/config/error-catalog", nil)
+			newReq.Header.Set("Accept-Language", test.acceptLanguage)
+			respRec := httptest.NewRecorder()
+
+			configServer := test.server
+			configServer.HandleErrorCatalogRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotCatalog := &apierror.Catalog{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotCatalog)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotCatalog.Language != test.wantLanguage || respRec.Result().Header.Get("Content-Language") != test.wantLanguage ||
+					gotCatalog.Messages[apierror.CodeGuildFull] != test.wantMessage || len(gotCatalog.Messages) != len(apierror.Codes()) {
+					t.Errorf("handler gave an incorrect catalog, want language: %v, message: %v, got: %+v", test.wantLanguage, test.wantMessage, gotCatalog)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleConfigRequest_Signature(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	// a server with a key from the environment, and one with a random key
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	t.Setenv(constants.ConfigSigningKeyEnvVar, base64.StdEncoding.EncodeToString(seed))
+	envKeyServer := NewServer(as)
+	t.Setenv(constants.ConfigSigningKeyEnvVar, "")
+	randomKeyServer := NewServer(as)
+
+	// get the public key, and the config along with its signature
+	fetch := func(cs *Server) (ed25519.PublicKey, []byte, []byte) {
+
+		newReq := httptest.NewRequest(http.MethodGet, "/config/public-key", nil)
+		newReq.Header.Set("Session-Id", sID)
+		respRec := httptest.NewRecorder()
+		testsetup.WithSession(cs, as, cs.HandlePublicKeyRequest)(respRec, newReq)
+
+		publicKeyResponse := &PublicKeyResponse{}
+		err = json.NewDecoder(respRec.Result().Body).Decode(publicKeyResponse)
+		if err != nil {
+			t.Fatal("could not decode the public key response")
+		}
+
+		publicKey, decodeErr := base64.StdEncoding.DecodeString(publicKeyResponse.PublicKey)
+		if decodeErr != nil || publicKeyResponse.Algorithm != SignatureAlgorithm {
+			t.Fatalf("invalid public key response: %v", publicKeyResponse)
+		}
+
+		newReq = httptest.NewRequest(http.MethodGet, "/config/game-config", nil)
+		newReq.Header.Set("Session-Id", sID)
+		respRec = httptest.NewRecorder()
+		testsetup.WithSession(cs, as, cs.HandleConfigRequest)(respRec, newReq)
+
+		signature, decodeErr := base64.StdEncoding.DecodeString(respRec.Result().Header.Get(ConfigSignatureHeader))
+		if decodeErr != nil {
+			t.Fatal("could not decode the config signature")
+		}
+
+		return publicKey, respRec.Body.Bytes(), signature
+	}
+
+	envPublicKey, body, signature := fetch(envKeyServer)
+	randomPublicKey, _, _ := fetch(randomKeyServer)
+
+	tests := []struct {
+		name      string
+		publicKey ed25519.PublicKey
+		body      []byte
+		want      bool
+	}{
+		{"matching key", envPublicKey, body, true},
+		{"key derived from the seed", ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey), body, true},
+		{"other server's key", randomPublicKey, body, false},
+		{"tampered config", envPublicKey, bytes.Replace(body, []byte(`"maxEnergy":50`), []byte(`"maxEnergy":500`), 1), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ed25519.Verify(test.publicKey, test.body, signature)
+			if got != test.want {
+				t.Errorf("signature verification gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadLevels(t *testing.T) {
+
+	level := func(n int) string {
+		return fmt.Sprintf(`{"level": %v, "energyCost": 3, "totalRolls": 2, "target": 6, "energyRewards": 5, "diceSides": 6, "diceCount": 1}`, n)
+	}
+
+	// hundreds of levels, split across single level and multi level files
+	manyLevels := fstest.MapFS{"level-001.json": {Data: []byte(level(1))}}
+	for file := 0; file < 3; file++ {
+		fileLevels := []string{}
+		for n := 2 + file*100; n < 2+(file+1)*100; n++ {
+			fileLevels = append(fileLevels, level(n))
+		}
+		manyLevels[fmt.Sprintf("levels-%v.json", file)] = &fstest.MapFile{Data: []byte("[" + strings.Join(fileLevels, ",") + "]")}
+	}
+
+	tests := []struct {
+		name       string
+		content    fstest.MapFS
+		wantLevels int
+		wantErr    bool
+	}{
+		{"no files", fstest.MapFS{}, 0, true},
+		{"single level", fstest.MapFS{"level-001.json": {Data: []byte(level(1))}}, 1, false},
+		{"other files are ignored", fstest.MapFS{"level-001.json": {Data: []byte(level(1))}, "notes.txt": {Data: []byte("notes")}}, 1, false},
+		{"files out of order", fstest.MapFS{"a.json": {Data: []byte(level(2))}, "b.json": {Data: []byte(level(1))}}, 2, false},
+		{"hundreds of levels", manyLevels, 301, false},
+		{"gap", fstest.MapFS{"a.json": {Data: []byte(level(1))}, "b.json": {Data: []byte(level(3))}}, 0, true},
+		{"duplicate", fstest.MapFS{"a.json": {Data: []byte(level(1))}, "b.json": {Data: []byte(level(1))}}, 0, true},
+		{"malformed json", fstest.MapFS{"a.json": {Data: []byte(`{"level": 1,`)}}, 0, true},
+		{"target out of dice range", fstest.MapFS{"a.json": {Data: []byte(`{"level": 1, "energyCost": 3, "totalRolls": 2, "target": 7, "energyRewards": 5}`)}}, 0, true},
+		{"zero reward", fstest.MapFS{"a.json": {Data: []byte(`{"level": 1, "energyCost": 3, "totalRolls": 2, "target": 6, "energyRewards": 0}`)}}, 0, true},
+		{"entry limits", fstest.MapFS{"a.json": {Data: []byte(`{"level": 1, "energyCost": 3, "totalRolls": 2, "target": 6, "energyRewards": 5, "cooldownSeconds": 60, "maxAttemptsPerDay": 10}`)}}, 1, false},
+		{"negative cooldown", fstest.MapFS{"a.json": {Data: []byte(`{"level": 1, "energyCost": 3, "totalRolls": 2, "target": 6, "energyRewards": 5, "cooldownSeconds": -1}`)}}, 0, true},
+		{"cost over max energy", fstest.MapFS{"a.json": {Data: []byte(`{"level": 1, "energyCost": 51, "totalRolls": 2, "target": 6, "energyRewards": 5}`)}}, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			levels, err := LoadLevels(test.content, 50)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("LoadLevels gave incorrect error, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			if len(levels) != test.wantLevels {
+				t.Errorf("LoadLevels gave incorrect results, want: %v levels, got: %v", test.wantLevels, len(levels))
+			}
+
+			for i, levelConfig := range levels {
+				if levelConfig.Level != int32(i+1) {
+					t.Fatalf("LoadLevels gave levels out of order, want: %v, got: %v", i+1, levelConfig.Level)
+				}
+			}
+		})
+	}
+}
+
+func TestGameConfig_Validate(t *testing.T) {
+
+	// a valid config with two levels, which each test breaks in its own way
+	validConfig := func() *GameConfig {
+		return &GameConfig{
+			Levels: []LevelConfig{
+				{Level: 1, EnergyCost: 3, TotalRolls: 2, Target: 6, EnergyReward: 5, DiceSides: 6, DiceCount: 1},
+				{Level: 2, EnergyCost: 3, TotalRolls: 3, Target: 12, EnergyReward: 5, DiceSides: 6, DiceCount: 2},
+			},
+			DefaultLevel:       1,
+			MaxEnergy:          50,
+			EnergyRegenSeconds: 5,
+			DefaultLevelScore:  99,
+			ShopItems: []ShopItemConfig{
+				{ItemID: "energy-small", Kind: ShopItemKindEnergyPack, Price: 20, EnergyAmount: 10},
+				{ItemID: "boost-regen-2x", Kind: ShopItemKindEnergyBoost, Price: 60, RegenMultiplier: 2, BoostSeconds: 3600},
+			},
+			Match: MatchConfig{TotalRolls: 3, TimeoutSeconds: 120, DefaultRating: 1000, RatingKFactor: 32},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		breakIt    func(gc *GameConfig)
+		wantFields []string
+	}{
+		{"valid config", func(gc *GameConfig) {}, nil},
+		{"no levels", func(gc *GameConfig) { gc.Levels = nil }, []string{"levels", "defaultLevel"}},
+		{"levels out of order", func(gc *GameConfig) { gc.Levels[0], gc.Levels[1] = gc.Levels[1], gc.Levels[0] }, []string{"levels[0].level", "levels[1].level"}},
+		{"level gap", func(gc *GameConfig) { gc.Levels[1].Level = 3 }, []string{"levels[1].level"}},
+		{"target out of dice range", func(gc *GameConfig) { gc.Levels[1].Target = 13 }, []string{"levels[1].target"}},
+		{"negative cost and zero reward", func(gc *GameConfig) { gc.Levels[0].EnergyCost, gc.Levels[0].EnergyReward = -1, 0 }, []string{"levels[0].energyCost", "levels[0].energyRewards"}},
+		{"invalid face weights", func(gc *GameConfig) { gc.Levels[0].FaceWeights = []int32{1, 1} }, []string{"levels[0].faceWeights"}},
+		{"zero regen", func(gc *GameConfig) { gc.EnergyRegenSeconds = 0 }, []string{"energyRegenSeconds"}},
+		{"negative energy bank cap", func(gc *GameConfig) { gc.EnergyBankCap = -10 }, []string{"energyBankCap"}},
+		{"level requires a missing FTUE step", func(gc *GameConfig) { gc.Levels[1].RequiredFTUEStep = 4 }, []string{"levels[1].requiredFtueStep"}},
+		{"default level score too low", func(gc *GameConfig) { gc.DefaultLevelScore = 3 }, []string{"defaultLevelScore"}},
+		{"duplicate shop item", func(gc *GameConfig) { gc.ShopItems[1].ItemID = "energy-small" }, []string{"shopItems[1].itemID"}},
+		{"unknown shop item kind", func(gc *GameConfig) { gc.ShopItems[0].Kind = "mystery-box" }, []string{"shopItems[0].kind"}},
+		{"negative referral reward", func(gc *GameConfig) { gc.Referral.RefereeCoinReward = -5 }, []string{"referral.refereeCoinReward"}},
+		{"difficulty min attempts above recent attempts", func(gc *GameConfig) { gc.Difficulty = DifficultyConfig{RecentAttempts: 5, MinAttempts: 6} }, []string{"difficulty.minAttempts"}},
+		{"inverted difficulty step", func(gc *GameConfig) {
+			gc.Difficulty.Steps = []DifficultyStep{{MinWinRate: 0.8, MaxWinRate: 0.2, RollsDelta: 1}}
+		}, []string{"difficulty.steps[0]"}},
+		{"decreasing prestige multiplier", func(gc *GameConfig) { gc.Prestige.RewardMultipliers = []float64{1.5, 1.2} }, []string{"prestige.rewardMultipliers[1]"}},
+		{"negative lapsed days", func(gc *GameConfig) { gc.Segments.LapsedDays = -1 }, []string{"segments.lapsedDays"}},
+		{"invalid segment tuning", func(gc *GameConfig) {
+			gc.Segments.Tuning = []SegmentTuning{
+				{Segment: SegmentNew, EnergyCostMultiplier: 0.5, EnergyRewardMultiplier: 1},
+				{Segment: SegmentNew, EnergyCostMultiplier: 0, EnergyRewardMultiplier: 1},
+				{Segment: "whales", EnergyCostMultiplier: 1, EnergyRewardMultiplier: -1},
+			}
+		}, []string{"segments.tuning[1].segment", "segments.tuning[1].energyCostMultiplier", "segments.tuning[2].segment", "segments.tuning[2].energyRewardMultiplier"}},
+		{"invalid milestones", func(gc *GameConfig) {
+			gc.Milestones = []MilestoneConfig{
+				{MilestoneID: "win-10", Kind: MilestoneKindWins, Target: 10},
+				{MilestoneID: "win-10", Kind: "login-days", Target: 0, CoinReward: -1},
+			}
+		}, []string{"milestones[1].milestoneID", "milestones[1].kind", "milestones[1].target", "milestones[1].coinReward"}},
+		{"invalid bonuses", func(gc *GameConfig) {
+			gc.Bonuses = BonusConfig{FirstRollEnergy: -1, ComboStep: 0.5, MaxComboMultiplier: 0.5}
+		}, []string{"bonuses.firstRollEnergy", "bonuses.maxComboMultiplier"}},
+		{"invalid throttles", func(gc *GameConfig) {
+			gc.Throttles = ThrottleConfig{Entry: ActionThrottleConfig{MaxActions: 1}, Result: ActionThrottleConfig{MaxActions: -1, WindowSeconds: 60}}
+		}, []string{"throttles.entry.windowSeconds", "throttles.result.maxActions"}},
+		{"invalid clients", func(gc *GameConfig) {
+			gc.Clients = ClientConfig{Platforms: []PlatformClientConfig{
+				{Platform: "ios", MinVersion: "1.2", StoreURL: "https://apps.apple.com/app/dice-game"},
+				{Platform: "ios", MinVersion: "1.x", StoreURL: "apps.apple.com"},
+			}}
+		}, []string{"clients.platforms[1].platform", "clients.platforms[1].minVersion", "clients.platforms[1].storeURL"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gc := validConfig()
+			test.breakIt(gc)
+
+			err := gc.Validate()
+
+			gotFields := []string(nil)
+			if err != nil {
+				invalidErr, ok := err.(InvalidConfigErr)
+				if !ok {
+					t.Fatalf("Validate() gave an incorrect error type: %T", err)
+				}
+				for _, problem := range invalidErr.Problems {
+					gotFields = append(gotFields, problem.Field)
+				}
+			}
+
+			if !reflect.DeepEqual(gotFields, test.wantFields) {
+				t.Errorf("Validate() gave incorrect results, want fields: %v, got: %v (%v)", test.wantFields, gotFields, err)
+			}
+		})
+	}
+}
+
+func TestSegmentConfig(t *testing.T) {
+
+	sc := &SegmentConfig{NewPlayerDays: 3, LapsedDays: 14, ReturnDays: 3, Tuning: []SegmentTuning{
+		{Segment: SegmentNew, EnergyCostMultiplier: 0.5, EnergyRewardMultiplier: 1},
+		{Segment: SegmentLapsed, EnergyCostMultiplier: 1, EnergyRewardMultiplier: 1.5},
+	}}
+
+	now := int64(100 * secondsPerDay)
+
+	tests := []struct {
+		name        string
+		createdTime int64
+		returnTime  int64
+		wantSegment string
+		wantCost    int32
+		wantReward  int32
+	}{
+		{"untracked creation time", 0, 0, "", 3, 5},
+		{"new player", now - 2*secondsPerDay, 0, SegmentNew, 2, 5},
+		{"new and returned player", now - 2*secondsPerDay, now - secondsPerDay, SegmentNew, 2, 5},
+		{"returned player", now - 50*secondsPerDay, now - secondsPerDay, SegmentLapsed, 3, 8},
+		{"returned long ago", now - 50*secondsPerDay, now - 3*secondsPerDay, "", 3, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotSegment := sc.SegmentOf(test.createdTime, test.returnTime, now)
+			gotCost := sc.EnergyCost(gotSegment, 3)
+			gotReward := sc.EnergyReward(gotSegment, 5)
+
+			if gotSegment != test.wantSegment || gotCost != test.wantCost || gotReward != test.wantReward {
+				t.Errorf("SegmentOf() gave incorrect results, want: %q (cost %v, reward %v), got: %q (cost %v, reward %v)", test.wantSegment, test.wantCost, test.wantReward, gotSegment, gotCost, gotReward)
+			}
+		})
+	}
+
+	if !sc.Lapsed(now-14*secondsPerDay, now) || sc.Lapsed(now-13*secondsPerDay, now) || sc.Lapsed(0, now) {
+		t.Errorf("Lapsed() gave incorrect results, a player is lapsed after 14 days without an update")
+	}
+}
+
+func TestGameConfig_ClientUpdate(t *testing.T) {
+
+	gc := &GameConfig{Clients: ClientConfig{Platforms: []PlatformClientConfig{
+		{Platform: "ios", MinVersion: "1.4", StoreURL: "https://apps.apple.com/app/dice-game"},
+		{Platform: "android", MinVersion: "2.0.1", StoreURL: "https://play.google.com/store/apps/details?id=com.example.dicegame"},
+	}}}
+
+	tests := []struct {
+		name           string
+		platform       string
+		version        string
+		wantMinVersion string
+		wantNeeded     bool
+	}{
+		{"no version", "ios", "", "", false},
+		{"no platform", "", "1.0.0", "", false},
+		{"unknown platform", "web", "0.1", "", false},
+		{"older", "ios", "1.3.9", "1.4", true},
+		{"same, with a patch number", "ios", "1.4.0", "1.4", false},
+		{"newer", "ios", "1.10", "1.4", false},
+		{"older patch", "android", "2.0", "2.0.1", true},
+		{"invalid version", "android", "2.x", "2.0.1", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotMinVersion, _, gotNeeded := gc.ClientUpdate(test.platform, test.version)
+			if gotMinVersion != test.wantMinVersion || gotNeeded != test.wantNeeded {
+				t.Errorf("ClientUpdate() gave incorrect results, want: %q, %v, got: %q, %v", test.wantMinVersion, test.wantNeeded, gotMinVersion, gotNeeded)
+			}
+		})
+	}
+}
+
+func TestNewServer_InvalidConfig(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	// a server created while the config is invalid refuses to serve it
+	regenSeconds := Config.EnergyRegenSeconds
+	Config.EnergyRegenSeconds = 0
+	cs := NewServer(as)
+	Config.EnergyRegenSeconds = regenSeconds
+
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+	}{
+		{"game config", testsetup.WithSession(cs, as, cs.HandleConfigRequest)},
+		{"localized config", testsetup.WithSession(cs, as, cs.HandleLocalizedConfigRequest)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/config/game-config", nil)
+			newReq.Header.Set("Session-Id", sID)
+			respRec := httptest.NewRecorder()
+
+			test.handler(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != http.StatusServiceUnavailable {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", http.StatusServiceUnavailable, gotStatus)
+			}
+
+			// the invalid field is only logged, the client gets the unavailable code
+			gotError := &apierror.Response{}
+			err = json.NewDecoder(respRec.Body).Decode(gotError)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+			if gotError.Code != apierror.CodeUnavailable || strings.Contains(gotError.Error, "energyRegenSeconds") {
+				t.Errorf("handler gave incorrect results, want the %v code without the details, got: %+v", apierror.CodeUnavailable, gotError)
+			}
+		})
+	}
+}
+
+func TestGameConfig_SetLevels(t *testing.T) {
+
+	gc := &GameConfig{Levels: []LevelConfig{{Level: 1}, {Level: 2}}}
+
+	err := gc.SetLevels([]LevelConfig{{Level: 1}})
+	if err == nil {
+		t.Errorf("setting fewer levels should fail")
+	}
+
+	err = gc.SetLevels([]LevelConfig{{Level: 1}, {Level: 2}, {Level: 3}})
+	if err != nil {
+		t.Fatalf("setting more levels should not fail: %v", err)
+	}
+
+	if gc.LevelCount() != 3 {
+		t.Errorf("incorrect level count, want: %v, got: %v", 3, gc.LevelCount())
+	}
+
+	if _, ok := gc.Level(3); !ok {
+		t.Errorf("the added level should be found")
+	}
+
+	if _, ok := gc.Level(4); ok {
+		t.Errorf("a level past the last one should not be found")
+	}
+}
+
+func TestServer_HandleReloadLevelsRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	cs := NewServer(auth.NewServer(data.NewServer()))
+
+	defaultContent, err := fs.Sub(defaultLevelContent, "levels")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousContent := levelContent
+	t.Cleanup(func() { levelContent = previousContent })
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		content    fs.FS
+		wantStatus int
+	}{
+		{"nil server", nil, "", nil, http.StatusInternalServerError},
+		{"invalid admin token", cs, "testToken", defaultContent, http.StatusUnauthorized},
+		{"no levels directory", cs, "adminToken", nil, http.StatusBadRequest},
+		{"invalid levels", cs, "adminToken", fstest.MapFS{"a.json": {Data: []byte(`{"level": 1,`)}}, http.StatusBadRequest},
+		{"unchanged levels", cs, "adminToken", defaultContent, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			levelContent = test.content
+			levelCount := Config.LevelCount()
+
+			newReq := httptest.NewRequest(http.MethodPost, "/config/admin/reload", nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			configServer := test.server
+			configServer.HandleReloadLevelsRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if Config.LevelCount() != levelCount {
+				t.Errorf("handler changed the levels, want: %v levels, got: %v", levelCount, Config.LevelCount())
+			}
+		})
+	}
+}
+
+func TestFlagValue_JSON(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		encoded     string
+		wantValue   FlagValue
+		wantEncoded string
+		wantErr     bool
+	}{
+		{"on", "true", FlagOn, "true", false},
+		{"off", "false", FlagOff, "false", false},
+		{"percentage", "25", 25, "25", false},
+		{"full percentage", "100", FlagOn, "true", false},
+		{"not a flag", `"yes"`, FlagOff, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var gotValue FlagValue
+			err := json.Unmarshal([]byte(test.encoded), &gotValue)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("UnmarshalJSON() gave incorrect error, want error: %v, got: %v", test.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if gotValue != test.wantValue {
+				t.Errorf("UnmarshalJSON() gave incorrect results, want: %v, got: %v", test.wantValue, gotValue)
+			}
+
+			gotEncoded, err := json.Marshal(gotValue)
+			if err != nil || string(gotEncoded) != test.wantEncoded {
+				t.Errorf("MarshalJSON() gave incorrect results, want: %v, got: %s (%v)", test.wantEncoded, gotEncoded, err)
+			}
+		})
+	}
+}
+
+func TestFlagValue_Includes(t *testing.T) {
+
+	// a percentage rollout includes about that share of the keys, and raising it keeps the keys already included
+	included := map[FlagValue]int{}
+	for i := range 1000 {
+		key := fmt.Sprintf("player%v", i)
+		for _, value := range []FlagValue{FlagOff, 10, 50, FlagOn} {
+			if value.Includes("test-flag", key) {
+				included[value] += 1
+			}
+		}
+
+		if FlagValue(10).Includes("test-flag", key) && !FlagValue(50).Includes("test-flag", key) {
+			t.Fatalf("Includes() dropped %v from the rollout when the percentage was raised", key)
+		}
+	}
+
+	if included[FlagOff] != 0 || included[FlagOn] != 1000 {
+		t.Errorf("Includes() gave incorrect results, want 0 keys when off and 1000 when on, got: %v and %v", included[FlagOff], included[FlagOn])
+	}
+
+	if included[10] < 50 || included[10] > 150 || included[50] < 400 || included[50] > 600 {
+		t.Errorf("Includes() gave an uneven rollout, want about 100 and 500 keys, got: %v and %v", included[10], included[50])
+	}
+}
+
+func TestServer_HandleSetFlagRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	cs := NewServer(auth.NewServer(data.NewServer()))
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		flag       string
+		body       string
+		wantStatus int
+		wantValue  FlagValue
+	}{
+		{"nil server", nil, "", "", "", http.StatusInternalServerError, FlagOff},
+		{"invalid admin token", cs, "testToken", FlagEnergyBank, "false", http.StatusUnauthorized, FlagOff},
+		{"invalid value", cs, "adminToken", FlagEnergyBank, `"off"`, http.StatusBadRequest, FlagOff},
+		{"percentage out of range", cs, "adminToken", FlagEnergyBank, "150", http.StatusBadRequest, FlagOff},
+		{"turn off", cs, "adminToken", FlagEnergyBank, "false", http.StatusOK, FlagOff},
+		{"partial rollout", cs, "adminToken", FlagDynamicDifficulty, "20", http.StatusOK, 20},
+		{"new flag", cs, "adminToken", "new-feature", "true", http.StatusOK, FlagOn},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPut, "/config/admin/flags/", strings.NewReader(test.body))
+			newReq.SetPathValue("name", test.flag)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			configServer := test.server
+			configServer.HandleSetFlagRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotFlags := map[string]FlagValue{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(&gotFlags)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotFlags[test.flag] != test.wantValue {
+					t.Errorf("handler gave incorrect results, want %v: %v, got: %v", test.flag, test.wantValue, gotFlags)
+				}
+			}
+		})
+	}
+}
+
+// testFlagClient serves the given flags, or the given error, and counts the reads
+type testFlagClient struct {
+	flags map[string]FlagValue
+	err   error
+	reads int
+}
+
+func (fc *testFlagClient) ReadFlags(ctx context.Context) (map[string]FlagValue, error) {
+	fc.reads += 1
+	return fc.flags, fc.err
+}
+
+func TestFlagChecker_Enabled(t *testing.T) {
+
+	var nilChecker *FlagChecker
+	if !nilChecker.Enabled(context.Background(), FlagEnergyBank, "player1") {
+		t.Errorf("Enabled() gave incorrect results, a nil checker should use the default flags")
+	}
+
+	// the config service is down at first, so the default flags are used till the flags can be read
+	client := &testFlagClient{err: fmt.Errorf("connection refused")}
+	checker := NewFlagChecker(client)
+
+	tests := []struct {
+		name        string
+		before      func()
+		flag        string
+		wantEnabled bool
+		wantReads   int
+	}{
+		{"default flags", func() {}, FlagEnergyBank, true, 1},
+		{"unknown flag", func() {}, "new-feature", false, 1},
+		{"kept till the refresh", func() { client.flags, client.err = map[string]FlagValue{FlagEnergyBank: FlagOff}, nil }, FlagEnergyBank, true, 1},
+		{"refreshed", func() { checker.readTime = time.Time{} }, FlagEnergyBank, false, 2},
+		{"previous flags kept on an error", func() { client.err, checker.readTime = fmt.Errorf("timeout"), time.Time{} }, FlagEnergyBank, false, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			test.before()
+
+			gotEnabled := checker.Enabled(context.Background(), test.flag, "player1")
+			if gotEnabled != test.wantEnabled || client.reads != test.wantReads {
+				t.Errorf("Enabled() gave incorrect results, want: %v after %v reads, got: %v after %v reads", test.wantEnabled, test.wantReads, gotEnabled, client.reads)
+			}
+		})
+	}
+}
+
+func TestHTTPClient_ReadFlags(t *testing.T) {
+
+	cs := NewServer(auth.NewServer(data.NewServer()))
+	err := cs.SetFlag(FlagDynamicDifficulty, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config/flags-internal", cs.HandleFlagsRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	var hc1 *HTTPClient
+	_, err = hc1.ReadFlags(context.Background())
+	if err != clientNilError {
+		t.Errorf("ReadFlags() gave incorrect error, want: %v, got: %v", clientNilError, err)
+	}
+
+	hc2 := &HTTPClient{baseURL: testServer.URL}
+	gotFlags, err := hc2.ReadFlags(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantFlags := map[string]FlagValue{FlagDynamicDifficulty: 30, FlagEnergyBank: FlagOn, FlagLevelDistribution: FlagOn, FlagEnergySegments: FlagOff, FlagLoginChallenge: FlagOff}
+	if !reflect.DeepEqual(gotFlags, wantFlags) {
+		t.Errorf("ReadFlags() gave incorrect results, want: %v, got: %v", wantFlags, gotFlags)
+	}
+}
+
+func TestServer_HandleScheduleAnnouncementRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	as, _, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs := NewServer(as)
+	end := time.Now().UTC().Unix() + 3600
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", cs, "testToken", fmt.Sprintf(`{"message":"hi","severity":"info","endTime":%v}`, end), http.StatusUnauthorized},
+		{"blank message", cs, "adminToken", fmt.Sprintf(`{"message":"  ","severity":"info","endTime":%v}`, end), http.StatusBadRequest},
+		{"long message", cs, "adminToken", fmt.Sprintf(`{"message":"%v","severity":"info","endTime":%v}`, strings.Repeat("a", maxAnnouncementLength+1), end), http.StatusBadRequest},
+		{"unknown severity", cs, "adminToken", fmt.Sprintf(`{"message":"hi","severity":"urgent","endTime":%v}`, end), http.StatusBadRequest},
+		{"end before start", cs, "adminToken", fmt.Sprintf(`{"message":"hi","severity":"info","startTime":%v,"endTime":%v}`, end, end-1), http.StatusBadRequest},
+		{"ended", cs, "adminToken", `{"message":"hi","severity":"info","startTime":1,"endTime":2}`, http.StatusBadRequest},
+		{"valid announcement", cs, "adminToken", fmt.Sprintf(`{"message":" Maintenance tonight ","severity":"warning","endTime":%v}`, end), http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/config/admin/announcements", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			configServer := test.server
+			configServer.HandleScheduleAnnouncementRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotAnnouncement := &Announcement{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotAnnouncement)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotAnnouncement.AnnouncementID == "" || gotAnnouncement.Message != "Maintenance tonight" || gotAnnouncement.StartTime == 0 || gotAnnouncement.EndTime != end {
+					t.Errorf("handler gave incorrect results, got: %+v", gotAnnouncement)
+				}
+
+				listed := cs.Announcements()
+				if len(listed) != 1 || listed[0] != *gotAnnouncement {
+					t.Errorf("Announcements() gave incorrect results, got: %+v", listed)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ActiveAnnouncements(t *testing.T) {
+
+	cs := NewServer(nil)
+
+	now := time.Now().UTC().Unix()
+	active, err := cs.ScheduleAnnouncement(&AnnouncementRequestBody{Message: "active", Severity: AnnouncementSeverityInfo, EndTime: now + 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := cs.ScheduleAnnouncement(&AnnouncementRequestBody{Message: "later", Severity: AnnouncementSeverityCritical, StartTime: now + 50, EndTime: now + 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cs.ScheduleAnnouncement(&AnnouncementRequestBody{Message: "latest", Severity: AnnouncementSeverityWarning, StartTime: now + 150, EndTime: now + 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		at            int64
+		wantActive    []string
+		wantNextStart int64
+	}{
+		{"before the first one", active.StartTime - 1, []string{}, active.StartTime},
+		{"first one active", now, []string{"active"}, now + 50},
+		{"both active", now + 50, []string{"active", "later"}, now + 150},
+		{"first one ended", now + 100, []string{"later"}, now + 150},
+		{"last one active", now + 200, []string{"latest"}, 0},
+		{"all ended", now + 300, []string{}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotActive, gotNextStart, _ := cs.ActiveAnnouncements(test.at)
+
+			gotMessages := []string{}
+			for _, announcement := range gotActive {
+				gotMessages = append(gotMessages, announcement.Message)
+			}
+
+			if !reflect.DeepEqual(gotMessages, test.wantActive) || gotNextStart != test.wantNextStart {
+				t.Errorf("ActiveAnnouncements() gave incorrect results, want: %v and %v, got: %v and %v", test.wantActive, test.wantNextStart, gotMessages, gotNextStart)
+			}
+		})
+	}
+
+	// deleting an announcement wakes up the streams waiting on the announcements
+	_, _, changed := cs.ActiveAnnouncements(now)
+	err = cs.DeleteAnnouncement(later.AnnouncementID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Error("the announcements changed channel should be closed after a delete")
+	}
+
+	err = cs.DeleteAnnouncement(later.AnnouncementID)
+	if _, ok := err.(AnnouncementNotFoundErr); !ok {
+		t.Errorf("DeleteAnnouncement() gave incorrect results, want: AnnouncementNotFoundErr, got: %v", err)
+	}
+}
+
+func TestServer_HandleAnnouncementEventsRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs := NewServer(as)
+	now := time.Now().UTC().Unix()
+	active, err := cs.ScheduleAnnouncement(&AnnouncementRequestBody{Message: "active", Severity: AnnouncementSeverityInfo, EndTime: now + 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventsServer := httptest.NewServer(http.HandlerFunc(testsetup.WithSession(cs, as, cs.HandleAnnouncementEventsRequest)))
+	defer eventsServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newReq, err := http.NewRequestWithContext(ctx, http.MethodGet, eventsServer.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReq.Header.Set("Session-Id", sID)
+
+	resp, err := http.DefaultClient.Do(newReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("handler gave incorrect results, got status: %v, content type: %v", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	nextAnnouncement := func() string {
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+
+			announcement := &Announcement{}
+			err = json.Unmarshal([]byte(data), announcement)
+			if err != nil {
+				t.Fatal("could not decode the announcement event")
+			}
+			return announcement.AnnouncementID
+		}
+		t.Fatalf("the stream ended early: %v", scanner.Err())
+		return ""
+	}
+
+	// the active announcement is sent right away, and a new one as soon as it is scheduled
+	gotID := nextAnnouncement()
+	if gotID != active.AnnouncementID {
+		t.Errorf("handler gave incorrect results, want: %v, got: %v", active.AnnouncementID, gotID)
+	}
+
+	pushed, err := cs.ScheduleAnnouncement(&AnnouncementRequestBody{Message: "pushed", Severity: AnnouncementSeverityCritical, EndTime: now + 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotID = nextAnnouncement()
+	if gotID != pushed.AnnouncementID {
+		t.Errorf("handler gave incorrect results, want: %v, got: %v", pushed.AnnouncementID, gotID)
+	}
+}
+
+// testConfigCopy returns a copy of the global config, which can be changed without changing the global one
+func testConfigCopy(t *testing.T) *GameConfig {
+
+	body, err := Config.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gameConfig := &GameConfig{}
+	err = json.Unmarshal(body, gameConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gameConfig
+}
+
+func TestDiffConfigs(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		change      func(gc *GameConfig)
+		wantLevels  []LevelDiff
+		wantChanges []FieldChange
+		wantValid   bool
+	}{
+		{"same config", func(gc *GameConfig) {}, []LevelDiff{}, []FieldChange{}, true},
+		{"changed level and settings", func(gc *GameConfig) {
+			gc.Levels[1].EnergyCost += 2
+			gc.Levels[1].Target = 6
+			gc.MaxEnergy = 60
+			gc.ShopItems[0].Price = 25
+		}, []LevelDiff{
+			{Level: 2, Status: LevelChanged, Changes: []FieldChange{{"energyCost", 3.0, 5.0}, {"target", 4.0, 6.0}}, EnergyCostDelta: 2, OldWinProbability: Config.Levels[1].WinProbability(), NewWinProbability: 1 - math.Pow(5.0/6, 3)},
+		}, []FieldChange{{"maxEnergy", 50.0, 60.0}, {"shopItems[0].price", 20.0, 25.0}}, true},
+		{"added level", func(gc *GameConfig) {
+			gc.Levels = append(gc.Levels, LevelConfig{Level: 11, EnergyCost: 7, TotalRolls: 3, Target: 5, EnergyReward: 9, DiceSides: 6, DiceCount: 1})
+		}, []LevelDiff{
+			{Level: 11, Status: LevelAdded, EnergyCostDelta: 7, EnergyRewardDelta: 9, NewWinProbability: 1 - math.Pow(5.0/6, 3)},
+		}, []FieldChange{}, true},
+		{"removed level, invalid default level", func(gc *GameConfig) {
+			gc.Levels = gc.Levels[:9]
+			gc.DefaultLevel = 10
+		}, []LevelDiff{
+			{Level: 10, Status: LevelRemoved, EnergyCostDelta: -6, EnergyRewardDelta: -8, OldWinProbability: Config.Levels[9].WinProbability()},
+		}, []FieldChange{{"defaultLevel", 1.0, 10.0}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			proposed := testConfigCopy(t)
+			test.change(proposed)
+
+			diff, err := DiffConfigs(Config, proposed)
+			if err != nil {
+				t.Fatalf("DiffConfigs() failed with an unexpected error, %v", err)
+			}
+
+			for i := range diff.Levels {
+				diff.Levels[i].OldWinProbability = math.Round(diff.Levels[i].OldWinProbability*1e9) / 1e9
+				diff.Levels[i].NewWinProbability = math.Round(diff.Levels[i].NewWinProbability*1e9) / 1e9
+			}
+			for i := range test.wantLevels {
+				test.wantLevels[i].OldWinProbability = math.Round(test.wantLevels[i].OldWinProbability*1e9) / 1e9
+				test.wantLevels[i].NewWinProbability = math.Round(test.wantLevels[i].NewWinProbability*1e9) / 1e9
+			}
+
+			if !reflect.DeepEqual(diff.Levels, test.wantLevels) {
+				t.Errorf("DiffConfigs() gave incorrect level diffs, want: %+v, got: %+v", test.wantLevels, diff.Levels)
+			}
+			if !reflect.DeepEqual(diff.Changes, test.wantChanges) {
+				t.Errorf("DiffConfigs() gave incorrect changes, want: %+v, got: %+v", test.wantChanges, diff.Changes)
+			}
+			if diff.Valid != test.wantValid || diff.Valid != (len(diff.Problems) == 0) {
+				t.Errorf("DiffConfigs() gave incorrect validation results, want valid: %v, got: %v, %v", test.wantValid, diff.Valid, diff.Problems)
+			}
+		})
+	}
+}
+
+func TestServer_HandleRolloutRequests(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	cs := NewServer(as)
+
+	candidate := testConfigCopy(t)
+	candidate.MaxEnergy = 60
+	invalid := testConfigCopy(t)
+	invalid.MaxEnergy = 0
+
+	encode := func(body any) string {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(encoded)
+	}
+
+	// servedMaxEnergy returns the max energy of the config served to the test session
+	servedMaxEnergy := func() int32 {
+		newReq := httptest.NewRequest(http.MethodGet, "/config/game-config", nil)
+		newReq.Header.Set("Session-Id", sID)
+		respRec := httptest.NewRecorder()
+		testsetup.WithSession(cs, as, cs.HandleConfigRequest)(respRec, newReq)
+
+		served := &GameConfig{}
+		err := json.NewDecoder(respRec.Result().Body).Decode(served)
+		if err != nil {
+			t.Fatal("could not decode the config")
+		}
+		return served.MaxEnergy
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		adminToken    string
+		body          string
+		handler       http.HandlerFunc
+		wantStatus    int
+		wantMaxEnergy int32
+	}{
+		{"invalid admin token", http.MethodPost, "testToken", encode(candidate), cs.HandleConfigDiffRequest, http.StatusUnauthorized, 50},
+		{"diff", http.MethodPost, "adminToken", encode(candidate), cs.HandleConfigDiffRequest, http.StatusOK, 50},
+		{"diff of an invalid config", http.MethodPost, "adminToken", encode(invalid), cs.HandleConfigDiffRequest, http.StatusOK, 50},
+		{"no rollout", http.MethodGet, "adminToken", "", cs.HandleRolloutStatusRequest, http.StatusNotFound, 50},
+		{"rollout of an invalid config", http.MethodPut, "adminToken", encode(&RolloutRequest{Config: invalid, Percentage: 100}), cs.HandleStartRolloutRequest, http.StatusBadRequest, 50},
+		{"rollout to no sessions", http.MethodPut, "adminToken", encode(&RolloutRequest{Config: candidate, Percentage: 0}), cs.HandleStartRolloutRequest, http.StatusBadRequest, 50},
+		{"rollout to every session", http.MethodPut, "adminToken", encode(&RolloutRequest{Config: candidate, Percentage: 100}), cs.HandleStartRolloutRequest, http.StatusOK, 60},
+		{"rollout status", http.MethodGet, "adminToken", "", cs.HandleRolloutStatusRequest, http.StatusOK, 60},
+		{"stop the rollout", http.MethodDelete, "adminToken", "", cs.HandleStopRolloutRequest, http.StatusNoContent, 50},
+		{"stop again", http.MethodDelete, "adminToken", "", cs.HandleStopRolloutRequest, http.StatusNotFound, 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(test.method, "/config/admin/rollout", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			test.handler(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v, %v", test.wantStatus, gotStatus, respRec.Body.String())
+			}
+
+			if gotStatus == http.StatusOK && test.method != http.MethodPost {
+				status := &RolloutStatus{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(status)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+				if status.Percentage != 100 || status.StartTime == 0 || !reflect.DeepEqual(status.Diff.Changes, []FieldChange{{"maxEnergy", 50.0, 60.0}}) {
+					t.Errorf("handler gave incorrect rollout status, got: %+v", status)
+				}
+			}
+
+			gotMaxEnergy := servedMaxEnergy()
+			if gotMaxEnergy != test.wantMaxEnergy {
+				t.Errorf("the session got the wrong config, want max energy: %v, got: %v", test.wantMaxEnergy, gotMaxEnergy)
+			}
+		})
+	}
+}
+
+func TestServer_servedConfig(t *testing.T) {
+
+	cs := NewServer(auth.NewServer(data.NewServer()))
+	err := cs.StartRollout(testConfigCopy(t), 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// about the rollout percentage of the sessions get the candidate config, and each session keeps getting the same config
+	candidateSessions := 0
+	for i := range 1000 {
+		ctx := playerctx.WithSessionID(context.Background(), fmt.Sprintf("session%v", i))
+		served := cs.servedConfig(ctx)
+		if served != Config {
+			candidateSessions++
+		}
+		if cs.servedConfig(ctx) != served {
+			t.Fatalf("the session %v got a different config", i)
+		}
+	}
+	if candidateSessions < 250 || candidateSessions > 350 {
+		t.Errorf("the candidate config should be served to about 30%% of the sessions, got: %v out of 1000", candidateSessions)
+	}
+
+	// requests without a session get the active config
+	if cs.servedConfig(context.Background()) != Config {
+		t.Errorf("a request without a session should get the active config")
+	}
+}
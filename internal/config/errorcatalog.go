@@ -0,0 +1,33 @@
+package config
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/i18n"
+	"net/http"
+)
+
+// HandleErrorCatalogRequest responds with the message of every error code (see apierror.Catalog), in the language asked
+// for in the Accept-Language header. It needs no session, since the client needs the messages of the login errors too
+func (cs *Server) HandleErrorCatalogRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	language := i18n.RequestLanguage(r)
+	cs.logger.Printf("error catalog requested, language: %v", language)
+
+	// the body depends on the Accept-Language header, so caches have to keep a copy per language
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", language)
+	w.Header().Add("Vary", "Accept-Language")
+
+	err := json.NewEncoder(w).Encode(apierror.NewCatalog(language))
+	if err != nil {
+		errMsg := "error: could not encode the error catalog: " + err.Error()
+		cs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
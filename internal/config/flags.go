@@ -0,0 +1,258 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the feature flags checked by the services, which let newer features be rolled out gradually, and turned off right away
+const (
+	FlagDynamicDifficulty = "dynamic-difficulty" // gameplay: adjusting levels by the recent win rate (per player)
+	FlagEnergyBank        = "energy-bank"        // profile: claiming banked energy (per player)
+	FlagLevelDistribution = "level-distribution" // stats: the level distribution request (not per player)
+	FlagEnergySegments    = "energy-segments"    // gameplay: the energy tuning of the player segments (per player, an experiment)
+	FlagLoginChallenge    = "login-challenge"    // auth: the challenge of the logins creating an account (not per player, a switch)
+)
+
+// DefaultFlags are the feature flags the config service starts with (every feature fully rolled out, while the
+// experiments start off, to be rolled out to a percentage of the players, and so do the switches turned on when abuse
+// is detected), they are also used by the services
+// which cannot read the flags from the config service
+var DefaultFlags = map[string]FlagValue{
+	FlagDynamicDifficulty: FlagOn,
+	FlagEnergyBank:        FlagOn,
+	FlagLevelDistribution: FlagOn,
+	FlagEnergySegments:    FlagOff,
+	FlagLoginChallenge:    FlagOff,
+}
+
+// FlagValue is the rollout percentage of a feature flag, from FlagOff (0) to FlagOn (100),
+// in json it is a bool when it is fully on or off, and a number otherwise (either can be sent)
+type FlagValue int32
+
+const (
+	FlagOff FlagValue = 0
+	FlagOn  FlagValue = 100
+)
+
+// MarshalJSON encodes a flag which is fully on or off as a bool, and the others as their percentage
+func (value FlagValue) MarshalJSON() ([]byte, error) {
+
+	switch value {
+	case FlagOff:
+		return []byte("false"), nil
+	case FlagOn:
+		return []byte("true"), nil
+	default:
+		return json.Marshal(int32(value))
+	}
+}
+
+// UnmarshalJSON decodes a flag from a bool (true is FlagOn), or from a percentage
+func (value *FlagValue) UnmarshalJSON(encoded []byte) error {
+
+	var enabled bool
+	if json.Unmarshal(encoded, &enabled) == nil {
+		*value = FlagOff
+		if enabled {
+			*value = FlagOn
+		}
+		return nil
+	}
+
+	var percentage int32
+	err := json.Unmarshal(encoded, &percentage)
+	if err != nil {
+		return fmt.Errorf("a flag should be true, false or a percentage, got: %s", encoded)
+	}
+
+	*value = FlagValue(percentage)
+	return nil
+}
+
+// Includes returns whether the given key (like a player id) is in the rollout of the given flag with this value,
+// each key gets a fixed bucket (0 to 99) per flag, so raising the percentage only ever adds keys to the rollout
+func (value FlagValue) Includes(flag string, key string) bool {
+
+	if value >= FlagOn {
+		return true
+	}
+	if value <= FlagOff {
+		return false
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(flag + "/" + key))
+	return FlagValue(hash.Sum32()%100) < value
+}
+
+// ReadFlags returns a copy of the current feature flags
+func (cs *Server) ReadFlags(ctx context.Context) (map[string]FlagValue, error) {
+
+	if cs == nil {
+		return nil, fmt.Errorf("provided config server pointer is nil")
+	}
+
+	cs.flagsMutex.RLock()
+	defer cs.flagsMutex.RUnlock()
+
+	return maps.Clone(cs.flags), nil
+}
+
+// SetFlag sets the rollout percentage of the given feature flag (adding it if it is new)
+func (cs *Server) SetFlag(flag string, value FlagValue) error {
+
+	if cs == nil {
+		return fmt.Errorf("provided config server pointer is nil")
+	}
+
+	if flag == "" {
+		return fmt.Errorf("the flag name cannot be blank")
+	}
+
+	if value < FlagOff || value > FlagOn {
+		return fmt.Errorf("the flag percentage should be between %v and %v, got: %v", FlagOff, FlagOn, int32(value))
+	}
+
+	cs.flagsMutex.Lock()
+	defer cs.flagsMutex.Unlock()
+
+	cs.flags[flag] = value
+	return nil
+}
+
+// HandleFlagsRequest is a wrapper around the ReadFlags() method which will
+// be used to field internal (server to server) requests for the feature flags
+func (cs *Server) HandleFlagsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	flags, err := cs.ReadFlags(r.Context())
+	if err != nil {
+		errMsg := "error: could not read the flags: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	cs.writeFlags(w, flags)
+}
+
+// HandleSetFlagRequest sets the feature flag in the path to the value in the request body (admin only),
+// which is true / false, or a rollout percentage, and responds with all the flags
+func (cs *Server) HandleSetFlagRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	var value FlagValue
+	err = json.NewDecoder(r.Body).Decode(&value)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	flag := r.PathValue("name")
+	cs.logger.Printf("received set flag request for flag: %v, value: %v", flag, int32(value))
+
+	err = cs.SetFlag(flag, value)
+	if err != nil {
+		errMsg := "error: could not set the flag: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	flags, _ := cs.ReadFlags(r.Context())
+	cs.writeFlags(w, flags)
+}
+
+// writeFlags responds with the given feature flags
+func (cs *Server) writeFlags(w http.ResponseWriter, flags map[string]FlagValue) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(flags)
+	if err != nil {
+		errMsg := "error: could not encode the flags: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// FlagChecker is used by the services to check the feature flags, it keeps the flags read with its flag client
+// for constants.FlagsRefreshSeconds. When the flags cannot be read, the last flags read are kept
+// (the default flags if none were read yet), so an unreachable config service does not turn features off
+type FlagChecker struct {
+	client FlagClient
+
+	flags      map[string]FlagValue
+	readTime   time.Time
+	flagsMutex sync.Mutex
+
+	logger *log.Logger
+}
+
+// NewFlagChecker returns an initialized pointer to a flag checker, which reads the flags with the given flag client
+func NewFlagChecker(client FlagClient) *FlagChecker {
+	return &FlagChecker{
+		client: client,
+		flags:  DefaultFlags,
+		logger: log.New(redact.Stdout, "flags: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+	}
+}
+
+// Enabled returns whether the given feature flag is on for the given key (a player id, or blank for the features
+// which are not per player). Flags which are not known are off, and a nil checker uses the default flags
+func (fc *FlagChecker) Enabled(ctx context.Context, flag string, key string) bool {
+
+	if fc == nil {
+		return DefaultFlags[flag].Includes(flag, key)
+	}
+
+	fc.flagsMutex.Lock()
+	defer fc.flagsMutex.Unlock()
+
+	// the read time is moved on even when the read fails, so a config service which is down is not asked on every check
+	if time.Since(fc.readTime) >= constants.FlagsRefreshSeconds*time.Second {
+		flags, err := fc.client.ReadFlags(ctx)
+		if err != nil {
+			fc.logger.Printf("error: could not read the flags, keeping the previous ones: %v", err)
+		} else {
+			fc.flags = flags
+		}
+		fc.readTime = time.Now()
+	}
+
+	return fc.flags[flag].Includes(flag, key)
+}
+
+// LoginChallengeRequired returns whether the logins creating an account have to pass the login challenge,
+// which makes the flag checker the policy of the auth service's login challenge
+func (fc *FlagChecker) LoginChallengeRequired(ctx context.Context) bool {
+	return fc.Enabled(ctx, FlagLoginChallenge, "")
+}
@@ -0,0 +1,293 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// defaultLevelContent holds the level definitions shipped with the backend,
+// which are used unless a levels directory is set in the environment
+//
+//go:embed levels/*.json
+var defaultLevelContent embed.FS
+
+// levelContent is the levels directory set in the environment (nil if it is not set), kept so it can be reloaded on demand
+var levelContent fs.FS
+
+func init() {
+
+	content, err := fs.Sub(defaultLevelContent, "levels")
+	if err != nil {
+		panic(err)
+	}
+
+	levels, err := LoadLevels(content, Config.MaxEnergy)
+	if err != nil {
+		panic("invalid default level content: " + err.Error())
+	}
+	Config.Levels = levels
+}
+
+// Validate checks that the level can be played and won: the energy cost and reward are positive
+// (and within the max energy), the dice and face weights are valid, the entry limits are not negative,
+// and the target can be rolled with the dice. The first problem found is returned, with the path of the field
+// in the game config the level would have (levels[level - 1])
+func (lc *LevelConfig) Validate(maxEnergy int32) error {
+
+	problems := lc.fieldErrors(fmt.Sprintf("levels[%v]", lc.Level-1), maxEnergy)
+	if len(problems) > 0 {
+		return problems[0]
+	}
+
+	return nil
+}
+
+// LoadLevels reads the level definitions from the json files at the root of the given file system
+// (each file holds a single level, or a list of levels), and validates them.
+// The levels have to be numbered from 1, without gaps or duplicates, and are returned in order
+func LoadLevels(content fs.FS, maxEnergy int32) ([]LevelConfig, error) {
+
+	fileNames, err := fs.Glob(content, "*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	levels := []LevelConfig{}
+	for _, fileName := range fileNames {
+
+		fileLevels, readErr := readLevelFile(content, fileName)
+		if readErr != nil {
+			return nil, fmt.Errorf("level file %v: %v", fileName, readErr)
+		}
+
+		for i := range fileLevels {
+			readErr = fileLevels[i].Validate(maxEnergy)
+			if readErr != nil {
+				return nil, fmt.Errorf("level file %v: %v", fileName, readErr)
+			}
+		}
+
+		levels = append(levels, fileLevels...)
+	}
+
+	if len(levels) == 0 {
+		return nil, fmt.Errorf("no levels found")
+	}
+
+	slices.SortFunc(levels, func(a, b LevelConfig) int {
+		return int(a.Level - b.Level)
+	})
+
+	for i, levelConfig := range levels {
+		if levelConfig.Level != int32(i+1) {
+			return nil, fmt.Errorf("levels should be numbered from 1 without gaps or duplicates, found level %v in place of level %v", levelConfig.Level, i+1)
+		}
+	}
+
+	return levels, nil
+}
+
+// readLevelFile decodes the level (or the list of levels) in the given file
+func readLevelFile(content fs.FS, fileName string) ([]LevelConfig, error) {
+
+	fileBytes, err := fs.ReadFile(content, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	fileBytes = bytes.TrimSpace(fileBytes)
+	if bytes.HasPrefix(fileBytes, []byte("[")) {
+		levels := []LevelConfig{}
+		err = json.Unmarshal(fileBytes, &levels)
+		return levels, err
+	}
+
+	level := LevelConfig{}
+	err = json.Unmarshal(fileBytes, &level)
+	return []LevelConfig{level}, err
+}
+
+// EnableLevelContentFromEnv replaces the default levels with the ones in the directory set in the environment (if it is set),
+// and keeps checking that directory, so that new levels can be added without restarting the services.
+// Changed content only replaces the current levels if it is valid, and does not remove any level
+func EnableLevelContentFromEnv() error {
+
+	levelsDir := os.Getenv(constants.LevelsDirEnvVar)
+	if levelsDir == "" {
+		return nil
+	}
+
+	content := os.DirFS(levelsDir)
+	levelContent = content
+
+	levels, err := LoadLevels(content, Config.MaxEnergy)
+	if err != nil {
+		return fmt.Errorf("could not load the levels from %v: %v", levelsDir, err)
+	}
+
+	err = Config.validateWith(levels)
+	if err != nil {
+		return fmt.Errorf("the levels from %v would make the %v", levelsDir, err)
+	}
+
+	// the content of the directory replaces the default levels as it is (even if it has fewer levels)
+	Config.levelsMutex.Lock()
+	Config.Levels = levels
+	Config.levelsMutex.Unlock()
+
+	logger := log.New(redact.Stdout, "config: ", log.Ltime|log.LUTC|log.Lmsgprefix)
+	logger.Printf("loaded %v levels from %v", len(levels), levelsDir)
+
+	ticker := time.NewTicker(constants.LevelContentReloadSeconds * time.Second)
+
+	go func() {
+		for {
+			<-ticker.C
+			err := reloadLevels(content, logger)
+			if err != nil {
+				logger.Printf("error: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// ReloadLevels reads the levels directory set in the environment again (without waiting for the next check),
+// and applies it if it changed
+func ReloadLevels(logger *log.Logger) error {
+
+	if levelContent == nil {
+		return fmt.Errorf("no levels directory has been set, the default levels cannot be reloaded")
+	}
+
+	return reloadLevels(levelContent, logger)
+}
+
+// HandleReloadLevelsRequest reloads the levels directory set in the environment on demand (admin only),
+// the other services running in separate processes pick the change up on their next check
+func (cs *Server) HandleReloadLevelsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	cs.logger.Println("received levels reload request")
+
+	err = ReloadLevels(cs.logger)
+	if err != nil {
+		errMsg := "error: could not reload the levels: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// reloadLevels reads the level content again, and applies it if it changed
+func reloadLevels(content fs.FS, logger *log.Logger) error {
+
+	levels, err := LoadLevels(content, Config.MaxEnergy)
+	if err != nil {
+		return fmt.Errorf("could not reload the levels: %v", err)
+	}
+
+	previousCount := Config.LevelCount()
+
+	Config.levelsMutex.RLock()
+	unchanged := reflect.DeepEqual(levels, Config.Levels)
+	Config.levelsMutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	err = Config.validateWith(levels)
+	if err != nil {
+		return fmt.Errorf("the reloaded levels would make the %v", err)
+	}
+
+	err = Config.SetLevels(levels)
+	if err != nil {
+		return fmt.Errorf("could not apply the reloaded levels: %v", err)
+	}
+
+	logger.Printf("reloaded the levels, %v levels now (%v before)", len(levels), previousCount)
+	return nil
+}
+
+// Level returns the config of the given level (levels are numbered from 1)
+func (gc *GameConfig) Level(level int32) (*LevelConfig, bool) {
+
+	gc.levelsMutex.RLock()
+	defer gc.levelsMutex.RUnlock()
+
+	if level <= 0 || level > int32(len(gc.Levels)) {
+		return nil, false
+	}
+
+	levelConfig := gc.Levels[level-1]
+	return &levelConfig, true
+}
+
+// LevelCount returns the number of levels
+func (gc *GameConfig) LevelCount() int32 {
+
+	gc.levelsMutex.RLock()
+	defer gc.levelsMutex.RUnlock()
+
+	return int32(len(gc.Levels))
+}
+
+// SetLevels replaces the levels with the given (already validated) ones,
+// levels cannot be removed, since players may have already unlocked them
+func (gc *GameConfig) SetLevels(levels []LevelConfig) error {
+
+	gc.levelsMutex.Lock()
+	defer gc.levelsMutex.Unlock()
+
+	if len(levels) < len(gc.Levels) {
+		return fmt.Errorf("levels cannot be removed, there are %v levels, and the new content only has %v", len(gc.Levels), len(levels))
+	}
+
+	gc.Levels = levels
+	return nil
+}
+
+// encode returns the json encoding of the game config (guarded against levels being set at the same time)
+func (gc *GameConfig) encode() ([]byte, error) {
+
+	gc.levelsMutex.RLock()
+	defer gc.levelsMutex.RUnlock()
+
+	return json.Marshal(gc)
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/i18n"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// HandleLocalizedConfigRequest responds with the game config like HandleConfigRequest, with the level names and
+// descriptions, and the shop item names, in the language asked for in the Accept-Language header (see i18n.Store.Negotiate)
+func (cs *Server) HandleLocalizedConfigRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	language := i18n.RequestLanguage(r)
+	cs.logger.Printf("localized config requested, language: %v", language)
+
+	if !cs.checkConfig(w, r) {
+		return
+	}
+
+	body, err := cs.servedConfig(r.Context()).encodeLocalized(i18n.Current(), language)
+	if err != nil {
+		errMsg := "error: could not encode game config"
+		cs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// the body depends on the Accept-Language header, so caches have to keep a copy per language
+	w.Header().Set("Content-Language", language)
+	w.Header().Add("Vary", "Accept-Language")
+
+	cs.writeConfig(w, r, body)
+}
+
+// encodeLocalized returns the json encoding of the game config, with the level names and descriptions,
+// the shop item names and the milestone names, in the given language (texts without a translation are left as they are)
+func (gc *GameConfig) encodeLocalized(translations *i18n.Store, language string) ([]byte, error) {
+
+	gc.levelsMutex.RLock()
+	levels := slices.Clone(gc.Levels)
+	gc.levelsMutex.RUnlock()
+
+	for i := range levels {
+		if name, ok := translations.Lookup(language, fmt.Sprintf("level.%v.name", levels[i].Level)); ok {
+			levels[i].Name = name
+		}
+		if description, ok := translations.Lookup(language, fmt.Sprintf("level.%v.description", levels[i].Level)); ok {
+			levels[i].Description = description
+		}
+	}
+
+	shopItems := slices.Clone(gc.ShopItems)
+	for i := range shopItems {
+		if name, ok := translations.Lookup(language, fmt.Sprintf("shopItem.%v.name", shopItems[i].ItemID)); ok {
+			shopItems[i].Name = name
+		}
+	}
+
+	milestones := slices.Clone(gc.Milestones)
+	for i := range milestones {
+		if name, ok := translations.Lookup(language, fmt.Sprintf("milestone.%v.name", milestones[i].MilestoneID)); ok {
+			milestones[i].Name = name
+		}
+	}
+
+	return json.Marshal(&GameConfig{
+		Levels:             levels,
+		DefaultLevel:       gc.DefaultLevel,
+		MaxEnergy:          gc.MaxEnergy,
+		EnergyRegenSeconds: gc.EnergyRegenSeconds,
+		DefaultLevelScore:  gc.DefaultLevelScore,
+		DefaultCoins:       gc.DefaultCoins,
+		ShopItems:          shopItems,
+		Match:              gc.Match,
+		Referral:           gc.Referral,
+		Milestones:         milestones,
+	})
+}
@@ -0,0 +1,503 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// the sessions of a rollout are bucketed like the keys of a feature flag with this name (see FlagValue.Includes)
+const rolloutBucket = "config-rollout"
+
+// rolloutLimits allow larger bodies than the default, since the config diff and rollout requests hold a whole game config
+var rolloutLimits = middleware.RouteLimits{
+	Timeout:      constants.DefaultRequestTimeoutSeconds * time.Second,
+	MaxBodyBytes: 1024 * 1024, // 1 MB
+}
+
+// the statuses of a level in a config diff
+const (
+	LevelAdded   = "added"
+	LevelRemoved = "removed"
+	LevelChanged = "changed"
+)
+
+var noRolloutError = fmt.Errorf("no config rollout is running")
+
+// FieldChange is a single field which differs between two game configs, given by its json path (like match.totalRolls,
+// or shopItems[1].price), with its old and new values (null for a field which was added or removed)
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// LevelDiff holds the changes of a level (matched by its level number) between two game configs, the fields of the
+// changes are relative to the level. The deltas are the new value minus the old one (a missing level counts as 0),
+// and the win probabilities are the chances of a player hitting the target (0 for a missing level)
+type LevelDiff struct {
+	Level             int32         `json:"level"`
+	Status            string        `json:"status"` // added, removed or changed
+	Changes           []FieldChange `json:"changes,omitempty"`
+	EnergyCostDelta   int32         `json:"energyCostDelta"`
+	EnergyRewardDelta int32         `json:"energyRewardDelta"`
+	OldWinProbability float64       `json:"oldWinProbability"`
+	NewWinProbability float64       `json:"newWinProbability"`
+}
+
+// ConfigDiff is the response to the admin request to compare a proposed game config with the active one: the levels
+// which differ (in level order), the changes of the rest of the config, and whether the proposed config is valid
+// (with every problem found, see GameConfig.Validate)
+type ConfigDiff struct {
+	Levels   []LevelDiff   `json:"levels"`
+	Changes  []FieldChange `json:"changes"`
+	Valid    bool          `json:"valid"`
+	Problems []FieldError  `json:"problems"`
+}
+
+// RolloutRequest is used as the request body for the admin request to start (or change) a staged rollout,
+// where the candidate config is served (instead of the active one) to the given percentage of the sessions
+type RolloutRequest struct {
+	Config     *GameConfig `json:"config"`
+	Percentage int32       `json:"percentage"`
+}
+
+// RolloutStatus is the response to the admin rollout requests, with the percentage of the sessions getting the
+// candidate config, when the rollout started, and the diff of the candidate config versus the active one
+type RolloutStatus struct {
+	Percentage int32       `json:"percentage"`
+	StartTime  int64       `json:"startTime"`
+	Diff       *ConfigDiff `json:"diff"`
+}
+
+// configRollout is a staged rollout of a candidate config
+type configRollout struct {
+	candidate  *GameConfig
+	percentage FlagValue
+	startTime  int64
+}
+
+// DiffConfigs compares the proposed game config with the active one, and validates the proposed one
+func DiffConfigs(active *GameConfig, proposed *GameConfig) (*ConfigDiff, error) {
+
+	if active == nil || proposed == nil {
+		return nil, fmt.Errorf("provided game config pointer is nil")
+	}
+
+	diff := &ConfigDiff{Levels: []LevelDiff{}, Changes: []FieldChange{}, Valid: true, Problems: []FieldError{}}
+
+	err := proposed.Validate()
+	if invalidErr, ok := err.(InvalidConfigErr); ok {
+		diff.Valid = false
+		diff.Problems = invalidErr.Problems
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the levels are matched by their level number
+	activeLevels, proposedLevels := active.levelsByNumber(), proposed.levelsByNumber()
+	levelNumbers := slices.Sorted(maps.Keys(activeLevels))
+	for number := range proposedLevels {
+		if _, ok := activeLevels[number]; !ok {
+			levelNumbers = append(levelNumbers, number)
+		}
+	}
+	slices.Sort(levelNumbers)
+
+	for _, number := range levelNumbers {
+		levelDiff, err := diffLevels(number, activeLevels[number], proposedLevels[number])
+		if err != nil {
+			return nil, err
+		}
+		if levelDiff != nil {
+			diff.Levels = append(diff.Levels, *levelDiff)
+		}
+	}
+
+	// the rest of the config is compared field by field, by its json encoding
+	activeFields, err := decodedFields(active)
+	if err != nil {
+		return nil, err
+	}
+	proposedFields, err := decodedFields(proposed)
+	if err != nil {
+		return nil, err
+	}
+	delete(activeFields, "levels")
+	delete(proposedFields, "levels")
+
+	diff.Changes = diffValues(diff.Changes, "", activeFields, proposedFields)
+	return diff, nil
+}
+
+// levelsByNumber returns copies of the levels of the game config, keyed by their level number
+func (gc *GameConfig) levelsByNumber() map[int32]*LevelConfig {
+
+	gc.levelsMutex.RLock()
+	defer gc.levelsMutex.RUnlock()
+
+	levels := make(map[int32]*LevelConfig, len(gc.Levels))
+	for _, level := range gc.Levels {
+		levels[level.Level] = &level
+	}
+	return levels
+}
+
+// diffLevels returns the diff of the old and new configs of the given level (either can be nil, when the level
+// is added or removed), or nil if they are the same
+func diffLevels(number int32, oldLevel *LevelConfig, newLevel *LevelConfig) (*LevelDiff, error) {
+
+	levelDiff := &LevelDiff{Level: number, Status: LevelChanged}
+
+	var oldFields, newFields any
+	if oldLevel != nil {
+		levelDiff.EnergyCostDelta -= oldLevel.EnergyCost
+		levelDiff.EnergyRewardDelta -= oldLevel.EnergyReward
+		levelDiff.OldWinProbability = oldLevel.WinProbability()
+		err := reencode(oldLevel, &oldFields)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		levelDiff.Status = LevelAdded
+	}
+
+	if newLevel != nil {
+		levelDiff.EnergyCostDelta += newLevel.EnergyCost
+		levelDiff.EnergyRewardDelta += newLevel.EnergyReward
+		levelDiff.NewWinProbability = newLevel.WinProbability()
+		err := reencode(newLevel, &newFields)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		levelDiff.Status = LevelRemoved
+	}
+
+	if levelDiff.Status != LevelChanged {
+		return levelDiff, nil
+	}
+
+	levelDiff.Changes = diffValues(nil, "", oldFields, newFields)
+	if len(levelDiff.Changes) == 0 {
+		return nil, nil
+	}
+	return levelDiff, nil
+}
+
+// decodedFields returns the fields of the json encoding of the game config
+func decodedFields(gc *GameConfig) (map[string]any, error) {
+
+	body, err := gc.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]any{}
+	err = json.Unmarshal(body, &fields)
+	return fields, err
+}
+
+// reencode encodes the given value to json, and decodes it into the given destination
+func reencode(value any, destination any) error {
+
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, destination)
+}
+
+// diffValues appends the changes between the given decoded json values to the given changes, the fields are given
+// by their json path under the given path (the fields of objects in key order, and the elements of arrays by index)
+func diffValues(changes []FieldChange, path string, oldValue any, newValue any) []FieldChange {
+
+	oldObject, oldIsObject := oldValue.(map[string]any)
+	newObject, newIsObject := newValue.(map[string]any)
+	if oldIsObject && newIsObject {
+		keys := maps.Clone(oldObject)
+		maps.Copy(keys, newObject)
+		for _, key := range slices.Sorted(maps.Keys(keys)) {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			changes = diffValues(changes, field, oldObject[key], newObject[key])
+		}
+		return changes
+	}
+
+	oldArray, oldIsArray := oldValue.([]any)
+	newArray, newIsArray := newValue.([]any)
+	if oldIsArray && newIsArray {
+		for i := range max(len(oldArray), len(newArray)) {
+			var oldElement, newElement any
+			if i < len(oldArray) {
+				oldElement = oldArray[i]
+			}
+			if i < len(newArray) {
+				newElement = newArray[i]
+			}
+			changes = diffValues(changes, fmt.Sprintf("%v[%v]", path, i), oldElement, newElement)
+		}
+		return changes
+	}
+
+	if !reflect.DeepEqual(oldValue, newValue) {
+		changes = append(changes, FieldChange{Field: path, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+// StartRollout serves the given candidate config to the given percentage (1 to 100) of the sessions, instead of
+// the active config, replacing the running rollout if there is one (each session keeps its bucket, so raising the
+// percentage only adds sessions). The candidate config has to be valid
+func (cs *Server) StartRollout(candidate *GameConfig, percentage int32) error {
+
+	if cs == nil {
+		return fmt.Errorf("provided config server pointer is nil")
+	}
+
+	if candidate == nil {
+		return fmt.Errorf("no candidate config")
+	}
+
+	if percentage <= int32(FlagOff) || percentage > int32(FlagOn) {
+		return fmt.Errorf("the rollout percentage should be between %v and %v, got: %v", int32(FlagOff)+1, int32(FlagOn), percentage)
+	}
+
+	err := candidate.Validate()
+	if err != nil {
+		return err
+	}
+
+	cs.rolloutMutex.Lock()
+	defer cs.rolloutMutex.Unlock()
+
+	startTime := time.Now().UTC().Unix()
+	if cs.rollout != nil {
+		startTime = cs.rollout.startTime
+	}
+	cs.rollout = &configRollout{candidate: candidate, percentage: FlagValue(percentage), startTime: startTime}
+	return nil
+}
+
+// StopRollout stops the running rollout, every session gets the active config again
+func (cs *Server) StopRollout() error {
+
+	if cs == nil {
+		return fmt.Errorf("provided config server pointer is nil")
+	}
+
+	cs.rolloutMutex.Lock()
+	defer cs.rolloutMutex.Unlock()
+
+	if cs.rollout == nil {
+		return noRolloutError
+	}
+
+	cs.rollout = nil
+	return nil
+}
+
+// RolloutStatus returns the status of the running rollout, with the diff of its candidate config versus the active one
+func (cs *Server) RolloutStatus() (*RolloutStatus, error) {
+
+	if cs == nil {
+		return nil, fmt.Errorf("provided config server pointer is nil")
+	}
+
+	cs.rolloutMutex.RLock()
+	rollout := cs.rollout
+	cs.rolloutMutex.RUnlock()
+
+	if rollout == nil {
+		return nil, noRolloutError
+	}
+
+	diff, err := DiffConfigs(Config, rollout.candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RolloutStatus{Percentage: int32(rollout.percentage), StartTime: rollout.startTime, Diff: diff}, nil
+}
+
+// servedConfig returns the game config served to the session of the given context: the candidate config of the
+// running rollout if the session is in it, otherwise the active config
+func (cs *Server) servedConfig(ctx context.Context) *GameConfig {
+
+	cs.rolloutMutex.RLock()
+	defer cs.rolloutMutex.RUnlock()
+
+	sessionID, ok := playerctx.SessionID(ctx)
+	if cs.rollout != nil && ok && cs.rollout.percentage.Includes(rolloutBucket, sessionID) {
+		return cs.rollout.candidate
+	}
+	return Config
+}
+
+// HandleConfigDiffRequest compares the proposed game config in the request body with the active one (admin only),
+// and responds with the diff, along with the validation results of the proposed config
+func (cs *Server) HandleConfigDiffRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	// decode the request body, which should be a GameConfig struct
+	proposed := &GameConfig{}
+	err = json.NewDecoder(r.Body).Decode(proposed)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	diff, err := DiffConfigs(Config, proposed)
+	if err != nil {
+		errMsg := "error: could not compare the configs: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	cs.logger.Printf("config diff requested, changed levels: %v, other changes: %v, valid: %v", len(diff.Levels), len(diff.Changes), diff.Valid)
+	cs.writeJSON(w, diff)
+}
+
+// HandleStartRolloutRequest starts (or changes) the staged rollout of the candidate config in the request body
+// to the percentage of the sessions in it (admin only), and responds with the status of the rollout, see StartRollout
+func (cs *Server) HandleStartRolloutRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	// decode the request body, which should be a RolloutRequest struct
+	decodedReq := &RolloutRequest{}
+	err = json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	err = cs.StartRollout(decodedReq.Config, decodedReq.Percentage)
+	if err != nil {
+		errMsg := "error: could not start the rollout: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	cs.logger.Printf("the candidate config is rolled out to %v%% of the sessions", decodedReq.Percentage)
+	cs.HandleRolloutStatusRequest(w, r)
+}
+
+// HandleRolloutStatusRequest responds with the status of the running rollout (admin only), see RolloutStatus
+func (cs *Server) HandleRolloutStatusRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	status, err := cs.RolloutStatus()
+	if err != nil {
+		errMsg := "rollout error: " + err.Error()
+		cs.logger.Println(errMsg)
+		cs.writeRolloutError(w, err, errMsg)
+		return
+	}
+
+	cs.writeJSON(w, status)
+}
+
+// HandleStopRolloutRequest stops the running rollout (admin only)
+func (cs *Server) HandleStopRolloutRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		http.Error(w, "provided config server pointer is nil", http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	err = cs.StopRollout()
+	if err != nil {
+		errMsg := "rollout error: " + err.Error()
+		cs.logger.Println(errMsg)
+		cs.writeRolloutError(w, err, errMsg)
+		return
+	}
+
+	cs.logger.Println("the config rollout is stopped, every session gets the active config")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeRolloutError responds with the status matching the given rollout error
+func (cs *Server) writeRolloutError(w http.ResponseWriter, err error, errMsg string) {
+
+	if errors.Is(err, noRolloutError) {
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+	http.Error(w, errMsg, http.StatusInternalServerError)
+}
+
+// writeJSON responds with the json encoding of the given value
+func (cs *Server) writeJSON(w http.ResponseWriter, value any) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		cs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
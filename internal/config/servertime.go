@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"net/http"
+	"time"
+)
+
+// ServerTimeResponse holds the authoritative (UTC) time of the backend, and the energy regeneration parameters,
+// so clients can render energy countdowns against the server clock instead of their own. Level results which send
+// a client time further than MaxDriftSeconds from the server time are rejected
+type ServerTimeResponse struct {
+	ServerTime         int64 `json:"serverTime"`
+	ServerTimeMillis   int64 `json:"serverTimeMillis"`
+	EnergyRegenSeconds int32 `json:"energyRegenSeconds"`
+	MaxEnergy          int32 `json:"maxEnergy"`
+	MaxDriftSeconds    int64 `json:"maxDriftSeconds"`
+}
+
+// HandleServerTimeRequest responds with the server time and the energy regeneration parameters
+func (cs *Server) HandleServerTimeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	// the time should never be cached on the way
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(&ServerTimeResponse{
+		ServerTime:         now.Unix(),
+		ServerTimeMillis:   now.UnixMilli(),
+		EnergyRegenSeconds: Config.EnergyRegenSeconds,
+		MaxEnergy:          Config.MaxEnergy,
+		MaxDriftSeconds:    constants.MaxClientTimeDriftSeconds,
+	})
+	if err != nil {
+		errMsg := "error: could not encode the server time"
+		cs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
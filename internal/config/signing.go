@@ -0,0 +1,71 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ConfigSignatureHeader is the response header holding the (base64 encoded) Ed25519 signature of the config response body
+const ConfigSignatureHeader = "Config-Signature"
+
+// SignatureAlgorithm is the algorithm used to sign the config
+const SignatureAlgorithm = "ed25519"
+
+// PublicKeyResponse is used as the client response for the public get public key api
+type PublicKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"`
+}
+
+// newConfigSigningKey returns the private key derived from the (base64 encoded) seed in the environment if it is set,
+// otherwise a random one (in which case clients have to fetch the public key again whenever the server restarts)
+func newConfigSigningKey() (ed25519.PrivateKey, error) {
+
+	encodedSeed := os.Getenv(constants.ConfigSigningKeyEnvVar)
+	if encodedSeed == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(encodedSeed)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%v should hold a base64 encoded %v byte seed", constants.ConfigSigningKeyEnvVar, ed25519.SeedSize)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// signConfig returns the (base64 encoded) signature of the given encoded config
+func (cs *Server) signConfig(body []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(cs.signingKey, body))
+}
+
+// HandlePublicKeyRequest responds with the public key which clients can use to verify the config signature
+func (cs *Server) HandlePublicKeyRequest(w http.ResponseWriter, r *http.Request) {
+
+	if cs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	cs.logger.Print("config public key requested... \n")
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(&PublicKeyResponse{
+		Algorithm: SignatureAlgorithm,
+		PublicKey: base64.StdEncoding.EncodeToString(cs.signingKey.Public().(ed25519.PublicKey)),
+	})
+	if err != nil {
+		errMsg := "error: could not encode the public key"
+		cs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
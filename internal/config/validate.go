@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// FieldError is a single problem with a field of the game config, the field is given by its json path (like levels[2].target)
+type FieldError struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+}
+
+func (err FieldError) Error() string {
+	return fmt.Sprintf("%v: %v", err.Field, err.Problem)
+}
+
+// InvalidConfigErr lists all the problems found when validating a game config
+type InvalidConfigErr struct {
+	Problems []FieldError
+}
+
+func (err InvalidConfigErr) Error() string {
+
+	problems := make([]string, 0, len(err.Problems))
+	for _, problem := range err.Problems {
+		problems = append(problems, problem.Error())
+	}
+
+	return fmt.Sprintf("invalid game config, %v problem(s): %v", len(err.Problems), strings.Join(problems, "; "))
+}
+
+// Validate checks the whole game config (including its levels), and returns an InvalidConfigErr listing every problem
+// found, so that an invalid config can be fixed in one go. The services refuse to serve an invalid config (see NewServer),
+// and level content which would make the config invalid is not loaded
+func (gc *GameConfig) Validate() error {
+
+	gc.levelsMutex.RLock()
+	defer gc.levelsMutex.RUnlock()
+
+	return gc.validateWith(gc.Levels)
+}
+
+// validateWith checks the game config as it would be with the given levels
+func (gc *GameConfig) validateWith(levels []LevelConfig) error {
+
+	problems := []FieldError{}
+	check := func(ok bool, field string, format string, args ...any) {
+		if !ok {
+			problems = append(problems, FieldError{Field: field, Problem: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check(gc.MaxEnergy > 0, "maxEnergy", "%v should be greater than 0", gc.MaxEnergy)
+	check(gc.EnergyRegenSeconds > 0, "energyRegenSeconds", "%v should be greater than 0", gc.EnergyRegenSeconds)
+	check(gc.EnergyBankCap >= 0, "energyBankCap", "%v cannot be negative", gc.EnergyBankCap)
+	check(gc.DefaultCoins >= 0, "defaultCoins", "%v cannot be negative", gc.DefaultCoins)
+	check(gc.FTUESteps >= 0, "ftueSteps", "%v cannot be negative", gc.FTUESteps)
+
+	// levels
+	check(len(levels) > 0, "levels", "there should be at least one level")
+	check(gc.DefaultLevel > 0 && gc.DefaultLevel <= int32(len(levels)), "defaultLevel", "%v should be one of the levels (1 to %v)", gc.DefaultLevel, len(levels))
+
+	maxTotalRolls := int32(0)
+	for i := range levels {
+		check(levels[i].Level == int32(i+1), fmt.Sprintf("levels[%v].level", i), "%v should be %v, the levels should be sorted and numbered from 1 without gaps or duplicates", levels[i].Level, i+1)
+		problems = append(problems, levels[i].fieldErrors(fmt.Sprintf("levels[%v]", i), gc.MaxEnergy)...)
+		check(levels[i].RequiredFTUEStep <= gc.FTUESteps, fmt.Sprintf("levels[%v].requiredFtueStep", i), "%v should be one of the FTUE steps (up to %v)", levels[i].RequiredFTUEStep, gc.FTUESteps)
+		maxTotalRolls = max(maxTotalRolls, levels[i].TotalRolls)
+	}
+
+	// the default level score is the best score of a level that was never won, so it has to be worse than any win
+	check(gc.DefaultLevelScore > maxTotalRolls, "defaultLevelScore", "%v should be greater than the total rolls of every level (up to %v)", gc.DefaultLevelScore, maxTotalRolls)
+
+	// shop items
+	itemIDs := map[string]bool{}
+	for i, item := range gc.ShopItems {
+		field := fmt.Sprintf("shopItems[%v]", i)
+
+		check(item.ItemID != "" && !itemIDs[item.ItemID], field+".itemID", "%q should be unique and not blank", item.ItemID)
+		itemIDs[item.ItemID] = true
+
+		check(item.Price > 0, field+".price", "%v should be greater than 0", item.Price)
+
+		switch item.Kind {
+		case ShopItemKindEnergyPack:
+			check(item.EnergyAmount > 0, field+".energyAmount", "%v should be greater than 0 for an energy pack", item.EnergyAmount)
+		case ShopItemKindEnergyBoost:
+			check(item.RegenMultiplier > 1, field+".regenMultiplier", "%v should be greater than 1 for an energy boost", item.RegenMultiplier)
+			check(item.BoostSeconds > 0, field+".boostSeconds", "%v should be greater than 0 for an energy boost", item.BoostSeconds)
+		case ShopItemKindDiceSkin, ShopItemKindSkipTicket:
+		default:
+			check(false, field+".kind", "%q is not a known kind of shop item", item.Kind)
+		}
+	}
+
+	// matches and referrals
+	check(gc.Match.TotalRolls > 0, "match.totalRolls", "%v should be greater than 0", gc.Match.TotalRolls)
+	check(gc.Match.TimeoutSeconds > 0, "match.timeoutSeconds", "%v should be greater than 0", gc.Match.TimeoutSeconds)
+	check(gc.Match.WinnerEnergyReward >= 0, "match.winnerEnergyReward", "%v cannot be negative", gc.Match.WinnerEnergyReward)
+	check(gc.Match.WinnerCoinReward >= 0, "match.winnerCoinReward", "%v cannot be negative", gc.Match.WinnerCoinReward)
+	check(gc.Match.DefaultRating > 0, "match.defaultRating", "%v should be greater than 0", gc.Match.DefaultRating)
+	check(gc.Match.RatingKFactor > 0, "match.ratingKFactor", "%v should be greater than 0", gc.Match.RatingKFactor)
+
+	check(gc.Referral.ReferrerEnergyReward >= 0, "referral.referrerEnergyReward", "%v cannot be negative", gc.Referral.ReferrerEnergyReward)
+	check(gc.Referral.ReferrerCoinReward >= 0, "referral.referrerCoinReward", "%v cannot be negative", gc.Referral.ReferrerCoinReward)
+	check(gc.Referral.RefereeEnergyReward >= 0, "referral.refereeEnergyReward", "%v cannot be negative", gc.Referral.RefereeEnergyReward)
+	check(gc.Referral.RefereeCoinReward >= 0, "referral.refereeCoinReward", "%v cannot be negative", gc.Referral.RefereeCoinReward)
+
+	// dynamic difficulty
+	check(gc.Difficulty.RecentAttempts >= 0, "difficulty.recentAttempts", "%v cannot be negative", gc.Difficulty.RecentAttempts)
+	check(gc.Difficulty.MinAttempts >= 0 && gc.Difficulty.MinAttempts <= gc.Difficulty.RecentAttempts, "difficulty.minAttempts", "%v should be between 0 and the recent attempts (%v)", gc.Difficulty.MinAttempts, gc.Difficulty.RecentAttempts)
+	for i, step := range gc.Difficulty.Steps {
+		field := fmt.Sprintf("difficulty.steps[%v]", i)
+		check(step.MinWinRate >= 0 && step.MinWinRate <= step.MaxWinRate && step.MaxWinRate <= 1, field, "the win rates (%v to %v) should be a range within 0 to 1", step.MinWinRate, step.MaxWinRate)
+	}
+
+	// prestige, every rank should reward at least as much as the one below it
+	previousMultiplier := 1.0
+	for i, multiplier := range gc.Prestige.RewardMultipliers {
+		check(multiplier >= previousMultiplier, fmt.Sprintf("prestige.rewardMultipliers[%v]", i), "%v should be at least %v (the multiplier of the rank below)", multiplier, previousMultiplier)
+		previousMultiplier = max(previousMultiplier, multiplier)
+	}
+
+	// player segments, each segment should be tuned at most once
+	check(gc.Segments.NewPlayerDays >= 0, "segments.newPlayerDays", "%v cannot be negative", gc.Segments.NewPlayerDays)
+	check(gc.Segments.LapsedDays >= 0, "segments.lapsedDays", "%v cannot be negative", gc.Segments.LapsedDays)
+	check(gc.Segments.ReturnDays >= 0, "segments.returnDays", "%v cannot be negative", gc.Segments.ReturnDays)
+	tunedSegments := map[string]bool{}
+	for i, tuning := range gc.Segments.Tuning {
+		field := fmt.Sprintf("segments.tuning[%v]", i)
+		check((tuning.Segment == SegmentNew || tuning.Segment == SegmentLapsed) && !tunedSegments[tuning.Segment], field+".segment", "%q should be %q or %q, and tuned only once", tuning.Segment, SegmentNew, SegmentLapsed)
+		tunedSegments[tuning.Segment] = true
+		check(tuning.EnergyCostMultiplier > 0, field+".energyCostMultiplier", "%v should be greater than 0", tuning.EnergyCostMultiplier)
+		check(tuning.EnergyRewardMultiplier >= 0, field+".energyRewardMultiplier", "%v cannot be negative", tuning.EnergyRewardMultiplier)
+	}
+
+	// milestones
+	milestoneIDs := map[string]bool{}
+	for i, milestone := range gc.Milestones {
+		field := fmt.Sprintf("milestones[%v]", i)
+		check(milestone.MilestoneID != "" && !milestoneIDs[milestone.MilestoneID], field+".milestoneID", "%q should be unique and not blank", milestone.MilestoneID)
+		milestoneIDs[milestone.MilestoneID] = true
+		check(milestone.Kind == MilestoneKindLevel || milestone.Kind == MilestoneKindWins || milestone.Kind == MilestoneKindWinStreak, field+".kind", "%q is not a known kind of milestone", milestone.Kind)
+		check(milestone.Target > 0, field+".target", "%v should be greater than 0", milestone.Target)
+		check(milestone.EnergyReward >= 0, field+".energyReward", "%v cannot be negative", milestone.EnergyReward)
+		check(milestone.CoinReward >= 0, field+".coinReward", "%v cannot be negative", milestone.CoinReward)
+	}
+
+	// bonuses
+	check(gc.Bonuses.FirstRollEnergy >= 0, "bonuses.firstRollEnergy", "%v cannot be negative", gc.Bonuses.FirstRollEnergy)
+	check(gc.Bonuses.ComboStep >= 0, "bonuses.comboStep", "%v cannot be negative", gc.Bonuses.ComboStep)
+	check(gc.Bonuses.ComboStep == 0 || gc.Bonuses.MaxComboMultiplier >= 1, "bonuses.maxComboMultiplier", "%v should be at least 1 when combos are on", gc.Bonuses.MaxComboMultiplier)
+
+	// throttles, a throttle with a limit needs a window
+	checkThrottle := func(field string, throttle ActionThrottleConfig) {
+		check(throttle.MaxActions >= 0, field+".maxActions", "%v cannot be negative", throttle.MaxActions)
+		check(throttle.MaxActions == 0 || throttle.WindowSeconds > 0, field+".windowSeconds", "%v should be greater than 0 when there is a limit", throttle.WindowSeconds)
+	}
+	checkThrottle("throttles.entry", gc.Throttles.Entry)
+	checkThrottle("throttles.result", gc.Throttles.Result)
+
+	// client compatibility, each platform should be listed at most once
+	clientPlatforms := map[string]bool{}
+	for i, platform := range gc.Clients.Platforms {
+		field := fmt.Sprintf("clients.platforms[%v]", i)
+		check(platform.Platform != "" && !clientPlatforms[platform.Platform], field+".platform", "%q should be unique and not blank", platform.Platform)
+		clientPlatforms[platform.Platform] = true
+		_, versionErr := parseClientVersion(platform.MinVersion)
+		check(versionErr == nil, field+".minVersion", "%q should be dot separated numbers, like 1.4.2", platform.MinVersion)
+		storeURL, urlErr := url.Parse(platform.StoreURL)
+		check(urlErr == nil && (storeURL.Scheme == "https" || storeURL.Scheme == "http") && storeURL.Host != "", field+".storeURL", "%q should be an http(s) url", platform.StoreURL)
+	}
+
+	if len(problems) > 0 {
+		return InvalidConfigErr{Problems: problems}
+	}
+
+	return nil
+}
+
+// fieldErrors returns the problems with the fields of the level (at the given json path): the energy cost and reward
+// should be positive (and within the max energy), the dice and face weights valid, the entry limits not negative,
+// and the target possible to roll with the dice
+func (lc *LevelConfig) fieldErrors(path string, maxEnergy int32) []FieldError {
+
+	problems := []FieldError{}
+	check := func(ok bool, field string, format string, args ...any) {
+		if !ok {
+			problems = append(problems, FieldError{Field: path + "." + field, Problem: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check(lc.EnergyCost > 0 && lc.EnergyCost <= maxEnergy, "energyCost", "%v should be between 1 and the max energy (%v)", lc.EnergyCost, maxEnergy)
+	check(lc.EnergyReward > 0 && lc.EnergyReward <= maxEnergy, "energyRewards", "%v should be between 1 and the max energy (%v)", lc.EnergyReward, maxEnergy)
+	check(lc.TotalRolls > 0, "totalRolls", "%v should be greater than 0", lc.TotalRolls)
+	check(lc.DiceSides >= 0, "diceSides", "%v cannot be negative", lc.DiceSides)
+	check(lc.DiceCount >= 0, "diceCount", "%v cannot be negative", lc.DiceCount)
+	check(lc.CooldownSeconds >= 0, "cooldownSeconds", "%v cannot be negative", lc.CooldownSeconds)
+	check(lc.MaxAttemptsPerDay >= 0, "maxAttemptsPerDay", "%v cannot be negative", lc.MaxAttemptsPerDay)
+	check(lc.RequiredFTUEStep >= 0, "requiredFtueStep", "%v cannot be negative", lc.RequiredFTUEStep)
+
+	// the dice checks below only make sense for valid dice
+	if lc.DiceSides < 0 || lc.DiceCount < 0 {
+		return problems
+	}
+
+	sides, count := lc.Dice()
+
+	if lc.FaceWeights != nil {
+
+		weightSum := int32(0)
+		negative := false
+		for _, weight := range lc.FaceWeights {
+			negative = negative || weight < 0
+			weightSum += weight
+		}
+
+		check(int32(len(lc.FaceWeights)) == sides, "faceWeights", "%v should have one weight per side (%v)", lc.FaceWeights, sides)
+		check(!negative, "faceWeights", "%v cannot be negative", lc.FaceWeights)
+		check(negative || weightSum > 0, "faceWeights", "%v should have at least one weight greater than 0", lc.FaceWeights)
+
+		// the target can only be checked against valid weights
+		if int32(len(lc.FaceWeights)) != sides || negative || weightSum <= 0 {
+			return problems
+		}
+	}
+
+	check(lc.IsValidRoll(lc.Target), "target", "%v should be possible to roll with the level's dice (%v dice with %v sides)", lc.Target, count, sides)
+
+	return problems
+}
@@ -0,0 +1,326 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// the kinds of annotations customer service can attach to a player
+const (
+	AnnotationKindNote          = "note"
+	AnnotationKindSupportTicket = "support-ticket"
+	AnnotationKindCompensation  = "compensation" // a record of what was granted to the player, and why
+	AnnotationKindSuspicion     = "suspicion"    // a flag for players suspected of cheating or abuse
+)
+
+type AnnotationNotFoundErr struct {
+	PlayerID     string
+	AnnotationID string
+}
+
+func (err AnnotationNotFoundErr) Error() string {
+	return fmt.Sprintf("player id: %v has no annotation with id: %v", err.PlayerID, err.AnnotationID)
+}
+
+// PlayerAnnotation is an admin only note attached to a player (never shown to the player), like a support ticket,
+// a compensation that was granted, or a suspicion flag. The reference can point outside the backend (like a ticket id)
+type PlayerAnnotation struct {
+	AnnotationID string `json:"annotationID"`
+	Kind         string `json:"kind"`
+	Text         string `json:"text"`
+	Reference    string `json:"reference,omitempty"`
+	Author       string `json:"author"`
+	CreatedAt    int64  `json:"createdAt"`
+}
+
+// AnnotationsData holds the annotations of a player, in the order they were added
+type AnnotationsData struct {
+	PlayerID    string             `json:"playerID"`
+	Annotations []PlayerAnnotation `json:"annotations"`
+}
+
+// AnnotationAddition is used as the request body for the internal request to add an annotation to a player
+// (the annotation id is assigned by the data service)
+type AnnotationAddition struct {
+	PlayerID   string           `json:"playerID"`
+	Annotation PlayerAnnotation `json:"annotation"`
+}
+
+// validate checks the kind and the length of the text of the annotation
+func (annotation *PlayerAnnotation) validate() error {
+
+	switch annotation.Kind {
+	case AnnotationKindNote, AnnotationKindSupportTicket, AnnotationKindCompensation, AnnotationKindSuspicion:
+	default:
+		return fmt.Errorf("unknown annotation kind: %q", annotation.Kind)
+	}
+
+	if text := strings.TrimSpace(annotation.Text); text == "" || len(text) > constants.MaxAnnotationLength {
+		return fmt.Errorf("the annotation text should have between 1 and %v characters", constants.MaxAnnotationLength)
+	}
+
+	if len(annotation.Reference) > constants.MaxAnnotationLength {
+		return fmt.Errorf("the annotation reference should have at most %v characters", constants.MaxAnnotationLength)
+	}
+
+	return nil
+}
+
+// newAnnotationID returns a random annotation id
+func newAnnotationID() string {
+
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+
+	return "annotation-" + hex.EncodeToString(randomBytes)
+}
+
+// HandleReadAnnotationsRequest responds with the annotations of the requested player (none, if they have none)
+func (ds *Server) HandleReadAnnotationsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	annotations, err := ds.ReadAnnotations(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not read annotations: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.writeJSON(w, annotations, "annotations")
+}
+
+// HandleAddAnnotationRequest adds the annotation in the request body to its player, responding with the added annotation
+func (ds *Server) HandleAddAnnotationRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be an AnnotationAddition struct
+	decodedReq := &AnnotationAddition{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	annotation, err := ds.AddAnnotation(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not add annotation: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.writeJSON(w, annotation, "annotation")
+}
+
+// HandleRemoveAnnotationRequest removes the requested annotation of the requested player, responding with the removed
+// annotation, or with a not found if the player has no such annotation
+func (ds *Server) HandleRemoveAnnotationRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	annotation, err := ds.RemoveAnnotation(r.Context(), r.PathValue("id"), r.PathValue("annotationID"))
+	if err != nil {
+		errMsg := "error: could not remove annotation: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case AnnotationNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, annotation, "annotation")
+}
+
+// ReadAnnotations returns (a copy of) the annotations of the given player
+func (ds *Server) ReadAnnotations(ctx context.Context, playerID string) (*AnnotationsData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadAnnotations")
+	defer span.End()
+
+	if playerID == "" {
+		return nil, fmt.Errorf("cannot read annotations without a player id")
+	}
+
+	ds.annotationsMutex.Lock()
+	defer ds.annotationsMutex.Unlock()
+
+	annotations := slices.Clone(ds.annotationsDB[keyOf(ctx, playerID)])
+	if annotations == nil {
+		annotations = []PlayerAnnotation{}
+	}
+
+	return &AnnotationsData{PlayerID: playerID, Annotations: annotations}, nil
+}
+
+// AddAnnotation adds the given annotation to its player (up to constants.MaxAnnotationsPerPlayer of them),
+// with a new annotation id, and returns the added annotation
+func (ds *Server) AddAnnotation(ctx context.Context, addition *AnnotationAddition) (*PlayerAnnotation, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.AddAnnotation")
+	defer span.End()
+
+	if addition == nil || addition.PlayerID == "" {
+		return nil, fmt.Errorf("cannot add an annotation without a player id")
+	}
+
+	err := addition.Annotation.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ds.annotationsMutex.Lock()
+	defer ds.annotationsMutex.Unlock()
+
+	key := keyOf(ctx, addition.PlayerID)
+	if len(ds.annotationsDB[key]) >= constants.MaxAnnotationsPerPlayer {
+		return nil, fmt.Errorf("player id: %v already has %v annotations, remove some first", addition.PlayerID, constants.MaxAnnotationsPerPlayer)
+	}
+
+	annotation := addition.Annotation
+	annotation.AnnotationID = newAnnotationID()
+
+	ds.logger.Printf("adding %v annotation: %v to id: %v", annotation.Kind, annotation.AnnotationID, addition.PlayerID)
+	ds.annotationsDB[key] = append(ds.annotationsDB[key], annotation)
+
+	return &annotation, nil
+}
+
+// RemoveAnnotation removes the given annotation of the given player (like a suspicion flag which was cleared),
+// and returns the removed annotation
+func (ds *Server) RemoveAnnotation(ctx context.Context, playerID string, annotationID string) (*PlayerAnnotation, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.RemoveAnnotation")
+	defer span.End()
+
+	if playerID == "" {
+		return nil, fmt.Errorf("cannot remove an annotation without a player id")
+	}
+
+	ds.annotationsMutex.Lock()
+	defer ds.annotationsMutex.Unlock()
+
+	key := keyOf(ctx, playerID)
+	index := slices.IndexFunc(ds.annotationsDB[key], func(annotation PlayerAnnotation) bool {
+		return annotation.AnnotationID == annotationID
+	})
+	if index < 0 {
+		return nil, AnnotationNotFoundErr{PlayerID: playerID, AnnotationID: annotationID}
+	}
+
+	removed := ds.annotationsDB[key][index]
+
+	ds.logger.Printf("removing annotation: %v of id: %v", annotationID, playerID)
+	ds.annotationsDB[key] = slices.Delete(slices.Clone(ds.annotationsDB[key]), index, index+1)
+	if len(ds.annotationsDB[key]) == 0 {
+		delete(ds.annotationsDB, key)
+	}
+
+	return &removed, nil
+}
+
+// ReadAnnotations makes an internal request to the data service to read the annotations of the required player
+func (hc *HTTPClient) ReadAnnotations(ctx context.Context, playerID string) (*AnnotationsData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &AnnotationsData{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/annotations-internal/"+playerID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read annotations request was not successful, status code %v", statusCode)
+	}
+
+	return result, nil
+}
+
+// AddAnnotation makes an internal request to the data service to add an annotation to the required player
+func (hc *HTTPClient) AddAnnotation(ctx context.Context, addition *AnnotationAddition) (*PlayerAnnotation, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if addition == nil {
+		return nil, fmt.Errorf("provided annotation addition pointer is nil")
+	}
+
+	result := &PlayerAnnotation{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/annotations-internal", addition, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal add annotation request was not successful, status code %v", statusCode)
+	}
+
+	return result, nil
+}
+
+// RemoveAnnotation makes an internal request to the data service to remove an annotation of the required player
+func (hc *HTTPClient) RemoveAnnotation(ctx context.Context, playerID string, annotationID string) (*PlayerAnnotation, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &PlayerAnnotation{}
+	statusCode, err := hc.doInternal(ctx, "DELETE", fmt.Sprintf("/data/annotations-internal/%v/%v", playerID, annotationID), nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, AnnotationNotFoundErr{PlayerID: playerID, AnnotationID: annotationID}
+	default:
+		return nil, fmt.Errorf("internal remove annotation request was not successful, status code %v", statusCode)
+	}
+}
@@ -0,0 +1,324 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archival sweep related constants
+const archiveSweepPeriod time.Duration = 24 * time.Hour
+const secondsPerDay int64 = 24 * 60 * 60
+
+// archivalJob is the name of the lease on the archival sweep, so only one data service runs it
+const archivalJob = "data-archival"
+
+var invalidArchiveIDError = fmt.Errorf("player id cannot be used as an archive file name")
+
+// ArchivedPlayer is everything the data service holds in memory for a player, moved to the cold store together
+type ArchivedPlayer struct {
+	Namespace string       `json:"namespace,omitempty"`
+	Player    PlayerData   `json:"player"`
+	Stats     *PlayerStats `json:"stats,omitempty"`
+}
+
+// ColdStore implementor can keep archived players outside of memory (in files, a database etc.),
+// players are identified by their namespace and their player id
+type ColdStore interface {
+	Store(archived *ArchivedPlayer) error
+	Load(namespace string, playerID string) (*ArchivedPlayer, bool, error)
+	Remove(namespace string, playerID string) error
+}
+
+// FileColdStore is the ColdStore implementation which keeps every archived player as a json file in a directory
+// (players of a namespace other than the default one are kept in a sub directory named after the namespace)
+type FileColdStore struct {
+	dir string
+}
+
+// NewFileColdStore returns an initialized pointer to a file cold store, creating its directory if needed
+func NewFileColdStore(dir string) (*FileColdStore, error) {
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileColdStore{dir: dir}, nil
+}
+
+// Store writes the archived player to its file (via a temporary file, so a failed write never leaves a partial file)
+func (fcs *FileColdStore) Store(archived *ArchivedPlayer) error {
+
+	path, err := fcs.path(archived.Namespace, archived.Player.PlayerID)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(archived)
+	if err != nil {
+		return err
+	}
+
+	tempPath := path + ".tmp"
+	err = os.WriteFile(tempPath, encoded, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+// Load reads the archived player from its file, and returns whether it was found
+func (fcs *FileColdStore) Load(namespace string, playerID string) (*ArchivedPlayer, bool, error) {
+
+	path, err := fcs.path(namespace, playerID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	archived := &ArchivedPlayer{}
+	err = json.Unmarshal(encoded, archived)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return archived, true, nil
+}
+
+// Remove deletes the file of the archived player (if there is one)
+func (fcs *FileColdStore) Remove(namespace string, playerID string) error {
+
+	path, err := fcs.path(namespace, playerID)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// path returns the path of the file for the given player id, making sure it stays inside the store's directory
+func (fcs *FileColdStore) path(playerNamespace string, playerID string) (string, error) {
+
+	if playerID == "" || playerID == "." || playerID == ".." || strings.ContainsAny(playerID, `/\`) {
+		return "", invalidArchiveIDError
+	}
+
+	if namespace.Validate(playerNamespace) != nil {
+		return "", invalidArchiveIDError
+	}
+
+	return filepath.Join(fcs.dir, playerNamespace, playerID+".json"), nil
+}
+
+// EnableArchivalFromEnv enables archival to a file cold store in the directory given by
+// the archive dir environment variable (see constants.ArchiveDirEnvVar), archival stays disabled if it is not set
+func (ds *Server) EnableArchivalFromEnv() error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	dir := os.Getenv(constants.ArchiveDirEnvVar)
+	if dir == "" {
+		return nil
+	}
+
+	store, err := NewFileColdStore(dir)
+	if err != nil {
+		return err
+	}
+
+	ds.EnableArchival(store, constants.ArchiveInactiveDays, archiveSweepPeriod)
+	return nil
+}
+
+// EnableArchival sets the cold store, and starts a periodic sweep which moves the players not updated for
+// the given number of days there. Archived players are transparently brought back to memory when accessed
+func (ds *Server) EnableArchival(store ColdStore, inactiveDays int64, sweepPeriod time.Duration) {
+
+	if ds == nil {
+		return
+	}
+
+	ds.archiveMutex.Lock()
+	ds.coldStore = store
+	ds.archiveMutex.Unlock()
+
+	ds.logger.Printf("archival enabled for players inactive for %v days", inactiveDays)
+
+	ticker := time.NewTicker(sweepPeriod)
+
+	go func() {
+		for {
+			timeNow := <-ticker.C
+			if !ds.isJobLeader(archivalJob, sweepPeriod) {
+				continue
+			}
+
+			ds.logger.Println("periodic archival sweep tick...")
+			count, err := ds.archiveInactivePlayers(timeNow, inactiveDays*secondsPerDay)
+			if err != nil {
+				ds.logger.Println("error in the periodic archival sweep: " + err.Error())
+				continue
+			}
+			ds.logger.Printf("archived %v inactive players", count)
+		}
+	}()
+}
+
+// archiveInactivePlayers moves the players whose last update is older than the given number of seconds
+// (and their stats) to the cold store, and returns how many were moved
+func (ds *Server) archiveInactivePlayers(timeNow time.Time, maxInactiveSeconds int64) (int, error) {
+
+	ds.archiveMutex.Lock()
+	defer ds.archiveMutex.Unlock()
+
+	if ds.coldStore == nil {
+		return 0, nil
+	}
+
+	cutoff := timeNow.UTC().Unix() - maxInactiveSeconds
+
+	// find the candidates first, so the players DB is not locked during the whole sweep
+	inactiveKeys := []dbKey{}
+	for key, player := range ds.playersDB.snapshot() {
+		if player.LastUpdateTime < cutoff {
+			inactiveKeys = append(inactiveKeys, key)
+		}
+	}
+
+	count := 0
+	for _, key := range inactiveKeys {
+		archived, err := ds.archivePlayer(key, cutoff)
+		if err != nil {
+			return count, err
+		}
+		if archived {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// archivePlayer moves a single player to the cold store, if they are still inactive (should be called with the archive lock held)
+func (ds *Server) archivePlayer(key dbKey, cutoff int64) (bool, error) {
+
+	playersShard := ds.playersDB.shardOf(key)
+	playersShard.mutex.Lock()
+	defer playersShard.mutex.Unlock()
+
+	statsShard := ds.statsDB.shardOf(key)
+	statsShard.mutex.Lock()
+	defer statsShard.mutex.Unlock()
+
+	player, ok := playersShard.entries[key]
+	if !ok || player.LastUpdateTime >= cutoff {
+		return false, nil
+	}
+
+	archived := &ArchivedPlayer{Namespace: key.Namespace, Player: player}
+	plStats, hasStats := statsShard.entries[key]
+	if hasStats {
+		archived.Stats = copyStats(plStats)
+	}
+
+	err := ds.coldStore.Store(archived)
+	if err != nil {
+		return false, err
+	}
+
+	delete(playersShard.entries, key)
+	delete(statsShard.entries, key)
+	if hasStats {
+		ds.levelIndex.update(key, &plStats, nil)
+	}
+
+	changesShard := ds.statsChangesDB.shardOf(key)
+	changesShard.mutex.Lock()
+	delete(changesShard.entries, key)
+	changesShard.mutex.Unlock()
+
+	return true, nil
+}
+
+// rehydrate brings the given player (and their stats) back from the cold store to memory, if they were archived
+func (ds *Server) rehydrate(key dbKey) error {
+
+	ds.archiveMutex.Lock()
+	defer ds.archiveMutex.Unlock()
+
+	if ds.coldStore == nil {
+		return nil
+	}
+
+	// players are archived together with their stats, so a player in memory was not archived
+	_, inMemory := ds.playersDB.get(key)
+	if inMemory {
+		return nil
+	}
+
+	archived, found, err := ds.coldStore.Load(key.Namespace, key.ID)
+	if err != nil {
+		if errors.Is(err, invalidArchiveIDError) {
+			return nil
+		}
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	ds.logger.Printf("rehydrating archived player with id: %v", key.ID)
+
+	playersShard := ds.playersDB.shardOf(key)
+	playersShard.mutex.Lock()
+	if _, ok := playersShard.entries[key]; !ok {
+		playersShard.entries[key] = archived.Player
+	}
+	playersShard.mutex.Unlock()
+
+	if archived.Stats != nil {
+		statsShard := ds.statsDB.shardOf(key)
+		statsShard.mutex.Lock()
+		if _, ok := statsShard.entries[key]; !ok {
+			statsShard.entries[key] = *copyStats(*archived.Stats)
+			ds.levelIndex.update(key, nil, archived.Stats)
+		}
+		statsShard.mutex.Unlock()
+	}
+
+	// the player is back in memory, so the archived copy is not needed anymore
+	err = ds.coldStore.Remove(key.Namespace, key.ID)
+	if err != nil {
+		ds.logger.Printf("error: could not remove archived player with id: %v: %v", key.ID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// AttemptRecord is a single level attempt (a win or a loss) in a player's attempt history,
+// the history is append-only, and can be replayed to rebuild the player's stats from scratch
+type AttemptRecord struct {
+	PlayerID string `json:"playerID"`
+	Level    int32  `json:"level"`
+	Won      bool   `json:"won"`
+	Score    int32  `json:"score"` // the number of rolls it took to win (not used for losses)
+	Time     int64  `json:"time"`
+}
+
+// AttemptsPage is a page of a player's attempt history, ordered by the time the attempts were written
+// (ascending by default), the next cursor is blank on the last page
+type AttemptsPage struct {
+	Attempts   []AttemptRecord `json:"attempts"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// HandleWriteAttemptRequest appends the given attempt to the attempt history of its player
+func (ds *Server) HandleWriteAttemptRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be an AttemptRecord struct
+	decodedReq := &AttemptRecord{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	if decodedReq.PlayerID == "" {
+		errMsg := "error: cannot write an entry with a blank player id"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// write the entry to the database
+	err = ds.WriteAttempt(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not write attempt: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleReadAttemptsRequest responds with a page of the attempt history of the requested player (which is empty
+// if the player has not attempted any level yet), the optional 'level' query parameter only keeps the attempts at that level
+func (ds *Server) HandleReadAttemptsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the request uri
+	id := r.PathValue("id")
+
+	page, err := pagination.ParseRequest(r.URL.Query())
+	if err != nil {
+		errMsg := "error: could not parse attempts query: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	level := 0
+	if levelParam := r.URL.Query().Get("level"); levelParam != "" {
+		level, err = strconv.Atoi(levelParam)
+		if err != nil || level <= 0 {
+			errMsg := "error: invalid level in request"
+			ds.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	attemptsPage, err := ds.ReadAttemptsPage(r.Context(), id, int32(level), page)
+	if err != nil {
+		errMsg := "error: could not read attempts: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(attemptsPage)
+	if err != nil {
+		errMsg := "error: could not encode attempts: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// HandleReadLevelAttemptsRequest responds with the attempts at the requested level by all players
+func (ds *Server) HandleReadLevelAttemptsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the level from the request uri
+	level, err := strconv.Atoi(r.PathValue("level"))
+	if err != nil || level <= 0 {
+		errMsg := "error: invalid level in request"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	attempts, err := ds.ReadLevelAttempts(r.Context(), int32(level))
+	if err != nil {
+		errMsg := "error: could not read attempts: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(attempts)
+	if err != nil {
+		errMsg := "error: could not encode attempts: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// WriteAttempt appends a copy of the given attempt to the attempt history of its player
+func (ds *Server) WriteAttempt(ctx context.Context, attempt *AttemptRecord) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.WriteAttempt")
+	defer span.End()
+
+	if attempt == nil {
+		return fmt.Errorf("provided attempt pointer is nil")
+	}
+
+	ds.logger.Printf("writing attempts DB entry for id: %v", attempt.PlayerID)
+
+	ds.attemptsMutex.Lock()
+	defer ds.attemptsMutex.Unlock()
+
+	key := keyOf(ctx, attempt.PlayerID)
+	ds.attemptsDB[key] = append(ds.attemptsDB[key], *attempt)
+
+	return nil
+}
+
+// ReadAttempts returns a copy of the attempt history of the given player, in the order the attempts were written
+func (ds *Server) ReadAttempts(ctx context.Context, playerID string) ([]AttemptRecord, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadAttempts")
+	defer span.End()
+
+	ds.logger.Printf("attempts DB entries requested for id: %v", playerID)
+
+	ds.attemptsMutex.Lock()
+	defer ds.attemptsMutex.Unlock()
+
+	key := keyOf(ctx, playerID)
+	attempts := make([]AttemptRecord, len(ds.attemptsDB[key]))
+	copy(attempts, ds.attemptsDB[key])
+
+	return attempts, nil
+}
+
+// ReadAttemptsPage returns the requested page of the attempt history of the given player,
+// only with the attempts at the given level (unless it is 0)
+func (ds *Server) ReadAttemptsPage(ctx context.Context, playerID string, level int32, page pagination.Request) (*AttemptsPage, error) {
+
+	attempts, err := ds.ReadAttempts(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if level != 0 {
+		attempts = slices.DeleteFunc(attempts, func(attempt AttemptRecord) bool { return attempt.Level != level })
+	}
+
+	attempts, nextCursor, err := pagination.PaginateHistory(attempts, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AttemptsPage{Attempts: attempts, NextCursor: nextCursor}, nil
+}
+
+// ReadLevelAttempts returns a copy of the attempts at the given level by all players (of the namespace)
+func (ds *Server) ReadLevelAttempts(ctx context.Context, level int32) ([]AttemptRecord, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadLevelAttempts")
+	defer span.End()
+
+	ds.logger.Printf("attempts DB entries requested for level: %v", level)
+
+	ds.attemptsMutex.Lock()
+	defer ds.attemptsMutex.Unlock()
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	attempts := []AttemptRecord{}
+	for key, playerAttempts := range ds.attemptsDB {
+		if key.Namespace != requestNamespace {
+			continue
+		}
+		for _, attempt := range playerAttempts {
+			if attempt.Level == level {
+				attempts = append(attempts, attempt)
+			}
+		}
+	}
+
+	return attempts, nil
+}
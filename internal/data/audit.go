@@ -0,0 +1,232 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// AuditEntry is a single record in the append-only audit log
+// (the id and time are assigned by the data service when the entry is appended)
+type AuditEntry struct {
+	ID       int64           `json:"id"`
+	Time     int64           `json:"time"`
+	Actor    string          `json:"actor"`
+	Action   string          `json:"action"`
+	PlayerID string          `json:"playerID"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+// AuditQuery holds the filters used to read the audit log, blank / zero fields are not used for filtering
+// (Since and Until are unix times, both inclusive), and the page of the matching entries to read.
+// Entries are ordered by id, which is the order they were appended in (ascending by default)
+type AuditQuery struct {
+	PlayerID string
+	Action   string
+	Since    int64
+	Until    int64
+	Page     pagination.Request
+}
+
+// AuditPage is a page of the audit log entries matching a query, the next cursor is blank on the last page
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// values converts the audit query to url query values (used by the http client)
+func (query *AuditQuery) values() url.Values {
+	values := query.Page.Values()
+	if query.PlayerID != "" {
+		values.Set("playerID", query.PlayerID)
+	}
+	if query.Action != "" {
+		values.Set("action", query.Action)
+	}
+	if query.Since != 0 {
+		values.Set("since", strconv.FormatInt(query.Since, 10))
+	}
+	if query.Until != 0 {
+		values.Set("until", strconv.FormatInt(query.Until, 10))
+	}
+	return values
+}
+
+// ParseAuditQuery reads an audit query from the given url query values
+func ParseAuditQuery(values url.Values) (*AuditQuery, error) {
+
+	page, err := pagination.ParseRequest(values)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &AuditQuery{
+		PlayerID: values.Get("playerID"),
+		Action:   values.Get("action"),
+		Page:     page,
+	}
+
+	if since := values.Get("since"); since != "" {
+		query.Since, err = strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since: %v", err)
+		}
+	}
+
+	if until := values.Get("until"); until != "" {
+		query.Until, err = strconv.ParseInt(until, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid until: %v", err)
+		}
+	}
+
+	return query, nil
+}
+
+// matches returns whether the given entry passes the filters of the query
+func (query *AuditQuery) matches(entry *AuditEntry) bool {
+	return (query.PlayerID == "" || entry.PlayerID == query.PlayerID) &&
+		(query.Action == "" || entry.Action == query.Action) &&
+		(query.Since == 0 || entry.Time >= query.Since) &&
+		(query.Until == 0 || entry.Time <= query.Until)
+}
+
+// HandleAppendAuditEntryRequest appends the given entry to the audit log
+func (ds *Server) HandleAppendAuditEntryRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be an AuditEntry struct
+	decodedReq := &AuditEntry{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	err = ds.AppendAuditEntry(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not append audit entry: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleReadAuditEntriesRequest returns the page of the audit log entries matching the query in the request url
+func (ds *Server) HandleReadAuditEntriesRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, err := ParseAuditQuery(r.URL.Query())
+	if err != nil {
+		errMsg := "error: could not parse audit query: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	auditPage, err := ds.ReadAuditEntries(r.Context(), query)
+	if err != nil {
+		errMsg := "error: could not read audit entries: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(auditPage)
+	if err != nil {
+		errMsg := "error: could not encode audit entries: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// AppendAuditEntry appends a copy of the given entry to the audit log (of the namespace), assigning it the next id and the current time
+func (ds *Server) AppendAuditEntry(ctx context.Context, entry *AuditEntry) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.AppendAuditEntry")
+	defer span.End()
+
+	if entry == nil || entry.Action == "" {
+		return fmt.Errorf("cannot append an audit entry without an action")
+	}
+
+	ds.auditMutex.Lock()
+	defer ds.auditMutex.Unlock()
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	newEntry := *entry
+	newEntry.ID = int64(len(ds.auditLogs[requestNamespace])) + 1
+	newEntry.Time = time.Now().UTC().Unix()
+	ds.auditLogs[requestNamespace] = append(ds.auditLogs[requestNamespace], newEntry)
+
+	return nil
+}
+
+// ReadAuditEntries returns the requested page of the audit log entries (of the namespace) matching the given query
+func (ds *Server) ReadAuditEntries(ctx context.Context, query *AuditQuery) (*AuditPage, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadAuditEntries")
+	defer span.End()
+
+	if query == nil {
+		query = &AuditQuery{}
+	}
+
+	ds.auditMutex.Lock()
+	defer ds.auditMutex.Unlock()
+
+	auditLog := ds.auditLogs[namespace.FromContext(ctx)]
+
+	matching := []AuditEntry{}
+	for i := range auditLog {
+		if query.matches(&auditLog[i]) {
+			matching = append(matching, auditLog[i])
+		}
+	}
+
+	entries, nextCursor, err := pagination.Paginate(matching, query.Page, func(entry AuditEntry) int64 { return entry.ID }, cmp.Compare[int64])
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditPage{Entries: entries, NextCursor: nextCursor}, nil
+}
@@ -0,0 +1,906 @@
+package data
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot layout written by backups, restores only accept this version
+const snapshotVersion = 1
+
+// backup formats (also used as the extension of the backup names)
+const BackupFormatJSON = "json"
+const BackupFormatGob = "gob"
+
+// backup names are the time of the backup (in this layout) with a prefix and the format's extension,
+// so they sort in the order the backups were taken
+const backupNamePrefix = "backup-"
+const backupTimeLayout = "20060102T150405.000000Z"
+
+// backupJob is the name of the lease on the scheduled backups, so only one data service takes them
+const backupJob = "data-backup"
+
+var backupsDisabledError = fmt.Errorf("backups are not enabled")
+var invalidBackupNameError = fmt.Errorf("invalid backup name")
+
+type BackupNotFoundErr struct {
+	Name string
+}
+
+func (err BackupNotFoundErr) Error() string {
+	return fmt.Sprintf("backup: %v was not found in the backup store", err.Name)
+}
+
+// Snapshot is a copy of all the data the data service holds in memory (for every namespace) at one point in time,
+// written by backups and loaded by restores. Archived players are not part of it, they stay in the cold store
+type Snapshot struct {
+	Version    int                 `json:"version"`
+	CreatedAt  int64               `json:"createdAt"`
+	Namespaces []NamespaceSnapshot `json:"namespaces"`
+}
+
+// NamespaceSnapshot holds the data of a single namespace in a snapshot, the records of each player in the order
+// they were written, and the audit log in the order it was appended
+type NamespaceSnapshot struct {
+	Namespace    string              `json:"namespace"`
+	Players      []PlayerData        `json:"players,omitempty"`
+	Stats        []PlayerStatsWithID `json:"stats,omitempty"`
+	Bans         []BanData           `json:"bans,omitempty"`
+	Attempts     []AttemptRecord     `json:"attempts,omitempty"`
+	Entries      []LevelEntryData    `json:"entries,omitempty"`
+	Wallets      []WalletData        `json:"wallets,omitempty"`
+	Inventories  []InventoryData     `json:"inventories,omitempty"`
+	Matches      []MatchRecord       `json:"matches,omitempty"`
+	PromoCodes   []PromoCode         `json:"promoCodes,omitempty"`
+	Redemptions  []PromoRedemption   `json:"redemptions,omitempty"`
+	Referrals    []ReferralData      `json:"referrals,omitempty"`
+	IPClaims     []ReferralIPClaims  `json:"ipClaims,omitempty"`
+	Milestones   []MilestonesData    `json:"milestones,omitempty"`
+	Annotations  []AnnotationsData   `json:"annotations,omitempty"`
+	Guilds       []GuildData         `json:"guilds,omitempty"`
+	Redirects    []PlayerRedirect    `json:"redirects,omitempty"`
+	AuditLog     []AuditEntry        `json:"auditLog,omitempty"`
+	PlayerEvents []PlayerEventStream `json:"playerEvents,omitempty"`
+}
+
+// BackupInfo is used as the response body of the internal request to take a backup
+type BackupInfo struct {
+	Name      string `json:"name"`
+	Format    string `json:"format"`
+	Size      int    `json:"size"` // in bytes
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// BackupRestore is used as the request body for the internal request to restore a backup
+type BackupRestore struct {
+	Name string `json:"name"`
+}
+
+// BackupStore implementor can keep backups (encoded snapshots) outside of the data service, like in files or an
+// S3 compatible object store, backups are identified by their names
+type BackupStore interface {
+	Write(name string, encoded []byte) error
+	Read(name string) ([]byte, error)
+	List() ([]string, error)
+	Remove(name string) error
+}
+
+// FileBackupStore is the BackupStore implementation which keeps every backup as a file in a directory
+type FileBackupStore struct {
+	dir string
+}
+
+// NewFileBackupStore returns an initialized pointer to a file backup store, creating its directory if needed
+func NewFileBackupStore(dir string) (*FileBackupStore, error) {
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileBackupStore{dir: dir}, nil
+}
+
+// Write writes the backup to its file (via a temporary file, so a failed write never leaves a partial backup)
+func (fbs *FileBackupStore) Write(name string, encoded []byte) error {
+
+	path, err := fbs.path(name)
+	if err != nil {
+		return err
+	}
+
+	tempPath := path + ".tmp"
+	err = os.WriteFile(tempPath, encoded, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+// Read returns the contents of the backup's file (a BackupNotFoundErr if there is none)
+func (fbs *FileBackupStore) Read(name string) ([]byte, error) {
+
+	path, err := fbs.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, BackupNotFoundErr{Name: name}
+		}
+		return nil, err
+	}
+
+	return encoded, nil
+}
+
+// List returns the names of all the backups in the directory, oldest first
+func (fbs *FileBackupStore) List() ([]string, error) {
+
+	entries, err := os.ReadDir(fbs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && validateBackupName(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// Remove deletes the file of the backup (if there is one)
+func (fbs *FileBackupStore) Remove(name string) error {
+
+	path, err := fbs.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// path returns the path of the file for the given backup name, making sure it stays inside the store's directory
+func (fbs *FileBackupStore) path(name string) (string, error) {
+
+	err := validateBackupName(name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(fbs.dir, name), nil
+}
+
+// newBackupName returns the name of a backup in the given format, taken at the given time
+func newBackupName(timeNow time.Time, format string) string {
+	return backupNamePrefix + timeNow.UTC().Format(backupTimeLayout) + "." + format
+}
+
+// validateBackupName checks that the given name is a backup name (see newBackupName)
+func validateBackupName(name string) error {
+
+	if !strings.HasPrefix(name, backupNamePrefix) || strings.ContainsAny(name, `/\`) {
+		return invalidBackupNameError
+	}
+
+	_, err := backupFormatOf(name)
+	return err
+}
+
+// backupFormatOf returns the format of the backup with the given name, based on its extension
+func backupFormatOf(name string) (string, error) {
+
+	format := strings.TrimPrefix(filepath.Ext(name), ".")
+	if format != BackupFormatJSON && format != BackupFormatGob {
+		return "", invalidBackupNameError
+	}
+	return format, nil
+}
+
+// encodeSnapshot encodes the snapshot in the given format
+func encodeSnapshot(snapshot *Snapshot, format string) ([]byte, error) {
+
+	switch format {
+	case BackupFormatJSON:
+		return json.Marshal(snapshot)
+	case BackupFormatGob:
+		buffer := &bytes.Buffer{}
+		err := gob.NewEncoder(buffer).Encode(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported backup format: %v", format)
+	}
+}
+
+// decodeSnapshot decodes a snapshot encoded in the given format
+func decodeSnapshot(encoded []byte, format string) (*Snapshot, error) {
+
+	snapshot := &Snapshot{}
+
+	var err error
+	switch format {
+	case BackupFormatJSON:
+		err = json.Unmarshal(encoded, snapshot)
+	case BackupFormatGob:
+		err = gob.NewDecoder(bytes.NewReader(encoded)).Decode(snapshot)
+	default:
+		err = fmt.Errorf("unsupported backup format: %v", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// HandleBackupRequest takes a backup (in the format given by the 'format' query parameter, json by default)
+// and responds with its info
+func (ds *Server) HandleBackupRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = BackupFormatJSON
+	}
+
+	ds.logger.Printf("backup request, format: %v", format)
+
+	info, err := ds.Backup(r.Context(), format)
+	if err != nil {
+		errMsg := "error: could not take backup: " + err.Error()
+		ds.logger.Println(errMsg)
+		ds.writeBackupError(w, err, errMsg)
+		return
+	}
+
+	ds.writeJSON(w, info, "backup info")
+}
+
+// HandleAdminBackupRequest takes a backup like HandleBackupRequest, for an admin (rather than another service)
+func (ds *Server) HandleAdminBackupRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	ds.HandleBackupRequest(w, r)
+}
+
+// HandleRestoreRequest replaces all the data in memory with the contents of the given backup
+func (ds *Server) HandleRestoreRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a BackupRestore struct
+	decodedReq := &BackupRestore{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.logger.Printf("restore request for backup: %v", decodedReq.Name)
+
+	err = ds.Restore(r.Context(), decodedReq.Name)
+	if err != nil {
+		errMsg := "error: could not restore backup: " + err.Error()
+		ds.logger.Println(errMsg)
+		ds.writeBackupError(w, err, errMsg)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleListBackupsRequest responds with the names of all the backups in the backup store, oldest first
+func (ds *Server) HandleListBackupsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	names, err := ds.ListBackups(r.Context())
+	if err != nil {
+		errMsg := "error: could not list backups: " + err.Error()
+		ds.logger.Println(errMsg)
+		ds.writeBackupError(w, err, errMsg)
+		return
+	}
+
+	ds.writeJSON(w, names, "backup names")
+}
+
+// writeBackupError responds with the status matching the given backup / restore error
+func (ds *Server) writeBackupError(w http.ResponseWriter, err error, errMsg string) {
+
+	var notFoundErr BackupNotFoundErr
+	switch {
+	case errors.Is(err, backupsDisabledError):
+		http.Error(w, errMsg, http.StatusServiceUnavailable)
+	case errors.As(err, &notFoundErr):
+		http.Error(w, errMsg, http.StatusNotFound)
+	default:
+		http.Error(w, errMsg, http.StatusBadRequest)
+	}
+}
+
+// EnableBackupsFromEnv enables backups to a file backup store in the directory given by the backup dir environment
+// variable (see constants.BackupDirEnvVar), along with the scheduled backups, backups stay disabled if it is not set
+func (ds *Server) EnableBackupsFromEnv() error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	dir := os.Getenv(constants.BackupDirEnvVar)
+	if dir == "" {
+		return nil
+	}
+
+	store, err := NewFileBackupStore(dir)
+	if err != nil {
+		return err
+	}
+
+	ds.EnableBackups(store, constants.BackupIntervalMinutes*time.Minute, constants.BackupsKept)
+	return nil
+}
+
+// EnableBackups sets the backup store (used by the backup and restore requests), and starts taking a json backup
+// every interval, after which only the latest backups (up to the given number) are kept in the store
+func (ds *Server) EnableBackups(store BackupStore, interval time.Duration, kept int) {
+
+	if ds == nil {
+		return
+	}
+
+	ds.backupMutex.Lock()
+	ds.backupStore = store
+	ds.backupMutex.Unlock()
+
+	ds.logger.Printf("backups enabled, taken every %v", interval)
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			<-ticker.C
+			if !ds.isJobLeader(backupJob, interval) {
+				continue
+			}
+
+			ds.logger.Println("scheduled backup tick...")
+			info, err := ds.Backup(context.Background(), BackupFormatJSON)
+			if err != nil {
+				ds.logger.Println("error in the scheduled backup: " + err.Error())
+				continue
+			}
+			ds.logger.Printf("took backup: %v (%v bytes)", info.Name, info.Size)
+
+			err = ds.pruneBackups(kept)
+			if err != nil {
+				ds.logger.Println("error pruning old backups: " + err.Error())
+			}
+		}
+	}()
+}
+
+// pruneBackups removes the oldest backups from the backup store, so only the given number of them are left
+func (ds *Server) pruneBackups(kept int) error {
+
+	ds.backupMutex.Lock()
+	defer ds.backupMutex.Unlock()
+
+	if ds.backupStore == nil {
+		return backupsDisabledError
+	}
+
+	names, err := ds.backupStore.List()
+	if err != nil {
+		return err
+	}
+
+	for len(names) > kept {
+		err = ds.backupStore.Remove(names[0])
+		if err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+
+	return nil
+}
+
+// Backup takes a snapshot of all the data and writes it to the backup store in the given format
+func (ds *Server) Backup(ctx context.Context, format string) (*BackupInfo, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "data.Backup")
+	defer span.End()
+
+	ds.backupMutex.Lock()
+	defer ds.backupMutex.Unlock()
+
+	if ds.backupStore == nil {
+		return nil, backupsDisabledError
+	}
+
+	snapshot, err := ds.TakeSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := encodeSnapshot(snapshot, format)
+	if err != nil {
+		return nil, err
+	}
+
+	name := newBackupName(time.Now(), format)
+	err = ds.backupStore.Write(name, encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BackupInfo{Name: name, Format: format, Size: len(encoded), CreatedAt: snapshot.CreatedAt}, nil
+}
+
+// Restore reads the backup with the given name from the backup store, and replaces all the data in memory with it
+func (ds *Server) Restore(ctx context.Context, name string) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "data.Restore")
+	defer span.End()
+
+	format, err := backupFormatOf(name)
+	if err != nil {
+		return err
+	}
+
+	ds.backupMutex.Lock()
+	defer ds.backupMutex.Unlock()
+
+	if ds.backupStore == nil {
+		return backupsDisabledError
+	}
+
+	encoded, err := ds.backupStore.Read(name)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := decodeSnapshot(encoded, format)
+	if err != nil {
+		return err
+	}
+
+	return ds.RestoreSnapshot(ctx, snapshot)
+}
+
+// ListBackups returns the names of all the backups in the backup store, oldest first
+func (ds *Server) ListBackups(ctx context.Context) ([]string, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ListBackups")
+	defer span.End()
+
+	ds.backupMutex.Lock()
+	defer ds.backupMutex.Unlock()
+
+	if ds.backupStore == nil {
+		return nil, backupsDisabledError
+	}
+
+	return ds.backupStore.List()
+}
+
+// TakeSnapshot returns a (consistent) copy of all the data in memory, of every namespace
+func (ds *Server) TakeSnapshot(ctx context.Context) (*Snapshot, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.TakeSnapshot")
+	defer span.End()
+
+	ds.lockAll()
+	defer ds.unlockAll()
+
+	namespaces := map[string]*NamespaceSnapshot{}
+	of := func(snapshotNamespace string) *NamespaceSnapshot {
+		if _, ok := namespaces[snapshotNamespace]; !ok {
+			namespaces[snapshotNamespace] = &NamespaceSnapshot{Namespace: snapshotNamespace}
+		}
+		return namespaces[snapshotNamespace]
+	}
+
+	players := ds.playersDB.all()
+	for _, key := range sortedKeys(players) {
+		of(key.Namespace).Players = append(of(key.Namespace).Players, players[key].Clone())
+	}
+	allStats := ds.statsDB.all()
+	for _, key := range sortedKeys(allStats) {
+		of(key.Namespace).Stats = append(of(key.Namespace).Stats, PlayerStatsWithID{PlayerID: key.ID, PlayerStats: *copyStats(allStats[key])})
+	}
+	for _, key := range sortedKeys(ds.bansDB) {
+		of(key.Namespace).Bans = append(of(key.Namespace).Bans, ds.bansDB[key])
+	}
+	for _, key := range sortedKeys(ds.attemptsDB) {
+		of(key.Namespace).Attempts = append(of(key.Namespace).Attempts, ds.attemptsDB[key]...)
+	}
+	for _, key := range sortedKeys(ds.entriesDB) {
+		of(key.Namespace).Entries = append(of(key.Namespace).Entries, ds.entriesDB[key]...)
+	}
+	for _, key := range sortedKeys(ds.walletsDB) {
+		of(key.Namespace).Wallets = append(of(key.Namespace).Wallets, ds.walletsDB[key])
+	}
+	for _, key := range sortedKeys(ds.inventoriesDB) {
+		of(key.Namespace).Inventories = append(of(key.Namespace).Inventories, InventoryData{PlayerID: key.ID, Items: slices.Clone(ds.inventoriesDB[key])})
+	}
+	for _, key := range sortedKeys(ds.matchesDB) {
+		of(key.Namespace).Matches = append(of(key.Namespace).Matches, ds.matchesDB[key]...)
+	}
+	for _, key := range sortedKeys(ds.promoCodesDB) {
+		promoCode := ds.promoCodesDB[key]
+		promoCode.Items = slices.Clone(promoCode.Items)
+		of(key.Namespace).PromoCodes = append(of(key.Namespace).PromoCodes, promoCode)
+	}
+	for _, key := range sortedKeys(ds.redemptionsDB) {
+		of(key.Namespace).Redemptions = append(of(key.Namespace).Redemptions, ds.redemptionsDB[key]...)
+	}
+	for _, key := range sortedKeys(ds.referralsDB) {
+		of(key.Namespace).Referrals = append(of(key.Namespace).Referrals, ds.referralsDB[key])
+	}
+	for _, key := range sortedKeys(ds.referralIPClaimsDB) {
+		of(key.Namespace).IPClaims = append(of(key.Namespace).IPClaims, ReferralIPClaims{IP: key.ID, ClaimTimes: slices.Clone(ds.referralIPClaimsDB[key])})
+	}
+	for _, key := range sortedKeys(ds.milestonesDB) {
+		of(key.Namespace).Milestones = append(of(key.Namespace).Milestones, MilestonesData{PlayerID: key.ID, Milestones: slices.Clone(ds.milestonesDB[key])})
+	}
+	for _, key := range sortedKeys(ds.annotationsDB) {
+		of(key.Namespace).Annotations = append(of(key.Namespace).Annotations, AnnotationsData{PlayerID: key.ID, Annotations: slices.Clone(ds.annotationsDB[key])})
+	}
+	for _, key := range sortedKeys(ds.guildsDB) {
+		of(key.Namespace).Guilds = append(of(key.Namespace).Guilds, ds.guildsDB[key].Clone())
+	}
+	for _, key := range sortedKeys(ds.redirectsDB) {
+		of(key.Namespace).Redirects = append(of(key.Namespace).Redirects, ds.redirectsDB[key])
+	}
+	for auditNamespace, auditLog := range ds.auditLogs {
+		of(auditNamespace).AuditLog = slices.Clone(auditLog)
+	}
+	for _, key := range sortedKeys(ds.eventStreams) {
+		stream := ds.eventStreams[key]
+		eventStream := PlayerEventStream{PlayerID: key.ID, Events: []PlayerEvent{}}
+		for _, event := range stream.events {
+			eventStream.Events = append(eventStream.Events, event.clone())
+		}
+		for _, state := range stream.snapshots {
+			eventStream.Snapshots = append(eventStream.Snapshots, state.clone())
+		}
+		of(key.Namespace).PlayerEvents = append(of(key.Namespace).PlayerEvents, eventStream)
+	}
+
+	snapshot := &Snapshot{Version: snapshotVersion, CreatedAt: time.Now().UTC().Unix(), Namespaces: []NamespaceSnapshot{}}
+	for _, snapshotNamespace := range slices.Sorted(maps.Keys(namespaces)) {
+		snapshot.Namespaces = append(snapshot.Namespaces, *namespaces[snapshotNamespace])
+	}
+
+	return snapshot, nil
+}
+
+// RestoreSnapshot replaces all the data in memory (of every namespace) with the contents of the given snapshot.
+// The snapshot is checked in full before anything is replaced, so an invalid one leaves the data as it was.
+// Archived players not in the snapshot stay in the cold store, and come back when accessed as before
+func (ds *Server) RestoreSnapshot(ctx context.Context, snapshot *Snapshot) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.RestoreSnapshot")
+	defer span.End()
+
+	if snapshot == nil || snapshot.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version, want: %v", snapshotVersion)
+	}
+
+	playersDB := map[dbKey]PlayerData{}
+	statsDB := map[dbKey]PlayerStats{}
+	bansDB := map[dbKey]BanData{}
+	attemptsDB := map[dbKey][]AttemptRecord{}
+	entriesDB := map[dbKey][]LevelEntryData{}
+	walletsDB := map[dbKey]WalletData{}
+	inventoriesDB := map[dbKey][]InventoryItem{}
+	matchesDB := map[dbKey][]MatchRecord{}
+	promoCodesDB := map[dbKey]PromoCode{}
+	redemptionsDB := map[dbKey][]PromoRedemption{}
+	referralsDB := map[dbKey]ReferralData{}
+	referralCodesDB := map[dbKey]string{}
+	referralIPClaimsDB := map[dbKey][]int64{}
+	milestonesDB := map[dbKey][]MilestoneState{}
+	annotationsDB := map[dbKey][]PlayerAnnotation{}
+	guildsDB := map[dbKey]GuildData{}
+	guildNamesDB := map[dbKey]string{}
+	guildMembersDB := map[dbKey]string{}
+	redirectsDB := map[dbKey]PlayerRedirect{}
+	auditLogs := map[string][]AuditEntry{}
+	eventStreams := map[dbKey]*playerEventStream{}
+
+	for _, nsSnapshot := range snapshot.Namespaces {
+
+		nsName := nsSnapshot.Namespace
+		err := namespace.Validate(nsName)
+		if err != nil {
+			return err
+		}
+		if _, ok := auditLogs[nsName]; ok {
+			return fmt.Errorf("namespace %q appears more than once in the snapshot", nsName)
+		}
+
+		// json snapshots are upgraded to the current versions while being decoded, but gob ones are not
+		for _, player := range nsSnapshot.Players {
+			restored := player.Clone()
+			err = upgradePlayer(&restored)
+			if err != nil {
+				return err
+			}
+			playersDB[dbKey{Namespace: nsName, ID: player.PlayerID}] = restored
+		}
+		for _, plStats := range nsSnapshot.Stats {
+			restored := copyStats(plStats.PlayerStats)
+			err = upgradeStats(restored)
+			if err != nil {
+				return err
+			}
+			statsDB[dbKey{Namespace: nsName, ID: plStats.PlayerID}] = *restored
+		}
+		for _, ban := range nsSnapshot.Bans {
+			bansDB[dbKey{Namespace: nsName, ID: ban.PlayerID}] = ban
+		}
+		for _, attempt := range nsSnapshot.Attempts {
+			key := dbKey{Namespace: nsName, ID: attempt.PlayerID}
+			attemptsDB[key] = append(attemptsDB[key], attempt)
+		}
+		for _, levelEntries := range nsSnapshot.Entries {
+			key := dbKey{Namespace: nsName, ID: levelEntries.PlayerID}
+			entriesDB[key] = append(entriesDB[key], levelEntries)
+		}
+		for _, wallet := range nsSnapshot.Wallets {
+			walletsDB[dbKey{Namespace: nsName, ID: wallet.PlayerID}] = wallet
+		}
+		for _, inventory := range nsSnapshot.Inventories {
+			inventoriesDB[dbKey{Namespace: nsName, ID: inventory.PlayerID}] = slices.Clone(inventory.Items)
+		}
+		for _, record := range nsSnapshot.Matches {
+			key := dbKey{Namespace: nsName, ID: record.PlayerID}
+			matchesDB[key] = append(matchesDB[key], record)
+		}
+		for _, promoCode := range nsSnapshot.PromoCodes {
+			promoCode.Items = slices.Clone(promoCode.Items)
+			promoCodesDB[dbKey{Namespace: nsName, ID: promoCode.Code}] = promoCode
+		}
+		for _, redemption := range nsSnapshot.Redemptions {
+			key := dbKey{Namespace: nsName, ID: redemption.PlayerID}
+			redemptionsDB[key] = append(redemptionsDB[key], redemption)
+		}
+		// the owners of the referral codes are not in the snapshot, they come from the referral data
+		for _, referral := range nsSnapshot.Referrals {
+			referralsDB[dbKey{Namespace: nsName, ID: referral.PlayerID}] = referral
+			if referral.Code != "" {
+				referralCodesDB[dbKey{Namespace: nsName, ID: referral.Code}] = referral.PlayerID
+			}
+		}
+		for _, ipClaims := range nsSnapshot.IPClaims {
+			referralIPClaimsDB[dbKey{Namespace: nsName, ID: ipClaims.IP}] = slices.Clone(ipClaims.ClaimTimes)
+		}
+		for _, milestones := range nsSnapshot.Milestones {
+			milestonesDB[dbKey{Namespace: nsName, ID: milestones.PlayerID}] = slices.Clone(milestones.Milestones)
+		}
+		for _, annotations := range nsSnapshot.Annotations {
+			annotationsDB[dbKey{Namespace: nsName, ID: annotations.PlayerID}] = slices.Clone(annotations.Annotations)
+		}
+		// the guild names and memberships are not in the snapshot either, they come from the guilds
+		for _, guild := range nsSnapshot.Guilds {
+			guildsDB[dbKey{Namespace: nsName, ID: guild.GuildID}] = guild.Clone()
+			guildNamesDB[dbKey{Namespace: nsName, ID: normalizeGuildName(guild.Name)}] = guild.GuildID
+			for _, member := range guild.Members {
+				memberKey := dbKey{Namespace: nsName, ID: member.PlayerID}
+				if _, ok := guildMembersDB[memberKey]; ok {
+					return fmt.Errorf("player id: %v of namespace %q is in more than one guild in the snapshot", member.PlayerID, nsName)
+				}
+				guildMembersDB[memberKey] = guild.GuildID
+			}
+		}
+
+		for _, redirect := range nsSnapshot.Redirects {
+			redirectsDB[dbKey{Namespace: nsName, ID: redirect.PlayerID}] = redirect
+		}
+
+		// audit entry ids are positions in the log (see ReadAuditEntries), so they have to be sequential from 1
+		for i, entry := range nsSnapshot.AuditLog {
+			if entry.ID != int64(i+1) {
+				return fmt.Errorf("audit log of namespace %q is out of sequence at id: %v", nsName, entry.ID)
+			}
+		}
+		auditLogs[nsName] = slices.Clone(nsSnapshot.AuditLog)
+
+		// the current state of each event stream is not in the snapshot, it comes from replaying the events
+		for _, eventStream := range nsSnapshot.PlayerEvents {
+			stream := &playerEventStream{state: PlayerState{PlayerID: eventStream.PlayerID}}
+			for i, event := range eventStream.Events {
+				if event.Sequence != int64(i+1) {
+					return fmt.Errorf("events of player id: %v of namespace %q are out of sequence at: %v", eventStream.PlayerID, nsName, event.Sequence)
+				}
+				stream.events = append(stream.events, event.clone())
+				stream.state.apply(event.clone())
+			}
+			for _, state := range eventStream.Snapshots {
+				stream.snapshots = append(stream.snapshots, state.clone())
+			}
+			eventStreams[dbKey{Namespace: nsName, ID: eventStream.PlayerID}] = stream
+		}
+	}
+
+	ds.lockAll()
+	defer ds.unlockAll()
+
+	ds.playersDB.replace(playersDB)
+	ds.statsDB.replace(statsDB)
+	ds.statsChangesDB.replace(map[dbKey]statsChangeTimes{})
+	ds.levelIndex.rebuild(statsDB)
+	ds.bansDB = bansDB
+	ds.attemptsDB = attemptsDB
+	ds.entriesDB = entriesDB
+	ds.walletsDB = walletsDB
+	ds.inventoriesDB = inventoriesDB
+	ds.matchesDB = matchesDB
+	ds.promoCodesDB = promoCodesDB
+	ds.redemptionsDB = redemptionsDB
+	ds.referralsDB = referralsDB
+	ds.referralCodesDB = referralCodesDB
+	ds.referralIPClaimsDB = referralIPClaimsDB
+	ds.milestonesDB = milestonesDB
+	ds.annotationsDB = annotationsDB
+	ds.guildsDB = guildsDB
+	ds.guildNamesDB = guildNamesDB
+	ds.guildMembersDB = guildMembersDB
+	ds.redirectsDB = redirectsDB
+	ds.auditLogs = auditLogs
+
+	// the event streams are only restored if event sourcing is enabled
+	if ds.eventStreams != nil {
+		ds.eventStreams = eventStreams
+	}
+
+	// the followers get a full copy of the restored players and stats
+	ds.resetFollowers()
+
+	ds.logger.Printf("restored snapshot taken at: %v", snapshot.CreatedAt)
+	return nil
+}
+
+// lockAll locks every DB (and the archive lock, so no sweep runs meanwhile), always in the same order
+func (ds *Server) lockAll() {
+	ds.archiveMutex.Lock()
+	ds.playersDB.lockAll()
+	ds.statsDB.lockAll()
+	ds.statsChangesDB.lockAll()
+	ds.bansMutex.Lock()
+	ds.attemptsMutex.Lock()
+	ds.walletsMutex.Lock()
+	ds.inventoriesMutex.Lock()
+	ds.matchesMutex.Lock()
+	ds.promoMutex.Lock()
+	ds.referralMutex.Lock()
+	ds.milestonesMutex.Lock()
+	ds.annotationsMutex.Lock()
+	ds.guildsMutex.Lock()
+	ds.redirectsMutex.Lock()
+	ds.auditMutex.Lock()
+	ds.eventsMutex.Lock()
+}
+
+// unlockAll unlocks everything locked by lockAll
+func (ds *Server) unlockAll() {
+	ds.eventsMutex.Unlock()
+	ds.auditMutex.Unlock()
+	ds.redirectsMutex.Unlock()
+	ds.guildsMutex.Unlock()
+	ds.annotationsMutex.Unlock()
+	ds.milestonesMutex.Unlock()
+	ds.referralMutex.Unlock()
+	ds.promoMutex.Unlock()
+	ds.matchesMutex.Unlock()
+	ds.inventoriesMutex.Unlock()
+	ds.walletsMutex.Unlock()
+	ds.attemptsMutex.Unlock()
+	ds.bansMutex.Unlock()
+	ds.statsChangesDB.unlockAll()
+	ds.statsDB.unlockAll()
+	ds.playersDB.unlockAll()
+	ds.archiveMutex.Unlock()
+}
+
+// sortedKeys returns the keys of the given DB, ordered by namespace and then by id
+func sortedKeys[V any](db map[dbKey]V) []dbKey {
+
+	keys := make([]dbKey, 0, len(db))
+	for key := range db {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b dbKey) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.ID, b.ID))
+	})
+	return keys
+}
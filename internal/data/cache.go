@@ -0,0 +1,223 @@
+package data
+
+import (
+	"container/list"
+	"context"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CachedClient is a data client which keeps the players and player stats it reads in an in-memory LRU cache
+// (in front of another data client), so repeated reads do not make internal requests to the data service.
+// Writes of players and stats through it invalidate the cached entries, all the other requests are passed through
+type CachedClient struct {
+	DataClient
+
+	players *lruCache[PlayerData]
+	stats   *lruCache[PlayerStats]
+}
+
+// NewCachedClient returns a client which caches up to the given number of players (and player stats) read with the
+// given data client, for the given time to live
+func NewCachedClient(dataClient DataClient, size int, ttl time.Duration) *CachedClient {
+	return &CachedClient{
+		DataClient: dataClient,
+		players:    newLRUCache[PlayerData](size, ttl),
+		stats:      newLRUCache[PlayerStats](size, ttl),
+	}
+}
+
+// WithCacheFromEnv wraps the given data client in a cached client with the size and time to live given by the
+// environment variables (see constants.DataCacheSizeEnvVar), the data client is returned as is if the size is not set
+func WithCacheFromEnv(dataClient DataClient) (DataClient, error) {
+
+	sizeEnv := os.Getenv(constants.DataCacheSizeEnvVar)
+	if sizeEnv == "" {
+		return dataClient, nil
+	}
+
+	size, err := strconv.Atoi(sizeEnv)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("%v should be a positive number of entries, got: %v", constants.DataCacheSizeEnvVar, sizeEnv)
+	}
+
+	ttl := constants.DataCacheDefaultTTLSeconds
+	if ttlEnv := os.Getenv(constants.DataCacheTTLSecondsEnvVar); ttlEnv != "" {
+		ttl, err = strconv.Atoi(ttlEnv)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("%v should be a positive number of seconds, got: %v", constants.DataCacheTTLSecondsEnvVar, ttlEnv)
+		}
+	}
+
+	return NewCachedClient(dataClient, size, time.Duration(ttl)*time.Second), nil
+}
+
+// ReadPlayer returns the cached player if there is one, otherwise it reads the player with the wrapped client (and caches it)
+func (cc *CachedClient) ReadPlayer(ctx context.Context, playerID string) (*PlayerData, error) {
+
+	key := keyOf(ctx, playerID)
+	if player, ok := cc.players.get(key); ok {
+		player = player.Clone()
+		return &player, nil
+	}
+
+	generation := cc.players.generation()
+	player, err := cc.DataClient.ReadPlayer(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.players.put(key, player.Clone(), generation)
+	return player, nil
+}
+
+// WritePlayer writes the player with the wrapped client, and invalidates the cached player
+func (cc *CachedClient) WritePlayer(ctx context.Context, player *PlayerData) error {
+
+	if player == nil {
+		return cc.DataClient.WritePlayer(ctx, player)
+	}
+
+	defer cc.players.invalidate(keyOf(ctx, player.PlayerID))
+	return cc.DataClient.WritePlayer(ctx, player)
+}
+
+// SwapPlayer swaps the player with the wrapped client, and invalidates the cached player
+// (whether the swap succeeded or not, a conflict means the cached player is out of date)
+func (cc *CachedClient) SwapPlayer(ctx context.Context, swap *PlayerSwap) error {
+
+	if swap == nil {
+		return cc.DataClient.SwapPlayer(ctx, swap)
+	}
+
+	defer cc.players.invalidate(keyOf(ctx, swap.Updated.PlayerID))
+	return cc.DataClient.SwapPlayer(ctx, swap)
+}
+
+// ReadStats returns the cached player stats if there are any, otherwise it reads the stats with the wrapped client (and caches them)
+func (cc *CachedClient) ReadStats(ctx context.Context, playerID string) (*PlayerStats, error) {
+
+	key := keyOf(ctx, playerID)
+	if plStats, ok := cc.stats.get(key); ok {
+		return copyStats(plStats), nil
+	}
+
+	generation := cc.stats.generation()
+	plStats, err := cc.DataClient.ReadStats(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.stats.put(key, *copyStats(*plStats), generation)
+	return plStats, nil
+}
+
+// WriteStats writes the player stats with the wrapped client, and invalidates the cached stats
+func (cc *CachedClient) WriteStats(ctx context.Context, plStatsWithID *PlayerStatsWithID) error {
+
+	if plStatsWithID == nil {
+		return cc.DataClient.WriteStats(ctx, plStatsWithID)
+	}
+
+	defer cc.stats.invalidate(keyOf(ctx, plStatsWithID.PlayerID))
+	return cc.DataClient.WriteStats(ctx, plStatsWithID)
+}
+
+// lruCache holds up to size entries for the ttl, evicting the least recently used entry when it is full
+type lruCache[V any] struct {
+	size    int
+	ttl     time.Duration
+	entries map[dbKey]*list.Element
+	order   *list.List // most recently used entry at the front
+
+	// invalidations counts the invalidations, a value read before an invalidation is not cached (as it may be out of date)
+	invalidations uint64
+
+	mutex sync.Mutex
+}
+
+type lruEntry[V any] struct {
+	key        dbKey
+	value      V
+	expiryTime time.Time
+}
+
+func newLRUCache[V any](size int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{size: size, ttl: ttl, entries: map[dbKey]*list.Element{}, order: list.New()}
+}
+
+// get returns the value of the key if it is cached and has not expired
+func (c *lruCache[V]) get(key dbKey) (V, bool) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	entry := element.Value.(*lruEntry[V])
+	if time.Now().After(entry.expiryTime) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// generation returns the number of invalidations so far, it is passed to put with the value read after calling it
+func (c *lruCache[V]) generation() uint64 {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.invalidations
+}
+
+// put caches the value of the key, unless there was an invalidation since the given generation
+func (c *lruCache[V]) put(key dbKey, value V, generation uint64) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.invalidations {
+		return
+	}
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = &lruEntry[V]{key: key, value: value, expiryTime: time.Now().Add(c.ttl)}
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value, expiryTime: time.Now().Add(c.ttl)})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+// invalidate removes the key from the cache
+func (c *lruCache[V]) invalidate(key dbKey) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.invalidations++
+
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,1287 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+var clientNilError = fmt.Errorf("provided data client pointer is nil")
+
+// DataClient implementor can read and write player data, player stats, ban entries, wallets, inventories, promo codes, referrals, milestones and guilds,
+// as well as append to (and read from) the attempt history, the match history and the audit log
+// (implemented by the data Server itself for in-process use, and by HTTPClient
+// when the data service runs as its own microservice)
+type DataClient interface {
+	ReadPlayer(ctx context.Context, playerID string) (*PlayerData, error)
+	WritePlayer(ctx context.Context, player *PlayerData) error
+	SwapPlayer(ctx context.Context, swap *PlayerSwap) error
+	ReadStats(ctx context.Context, playerID string) (*PlayerStats, error)
+	ReadStatsDelta(ctx context.Context, playerID string, since int64) (*StatsDelta, error)
+	WriteStats(ctx context.Context, plStatsWithID *PlayerStatsWithID) error
+	WritePlayerAndStats(ctx context.Context, playerWithStats *PlayerWithStats) error
+	MergePlayers(ctx context.Context, merge *PlayerMerge) (*PlayerWithStats, error)
+	ReadRedirect(ctx context.Context, playerID string) (*PlayerRedirect, error)
+	ReadBan(ctx context.Context, playerID string) (*BanData, error)
+	WriteBan(ctx context.Context, ban *BanData) error
+	DeleteBan(ctx context.Context, playerID string) error
+	WriteAttempt(ctx context.Context, attempt *AttemptRecord) error
+	ReadAttempts(ctx context.Context, playerID string) ([]AttemptRecord, error)
+	ReadLevelAttempts(ctx context.Context, level int32) ([]AttemptRecord, error)
+	RecordLevelEntry(ctx context.Context, entry *LevelEntry) (*LevelEntryData, error)
+	RecordAction(ctx context.Context, throttle *ActionThrottle) (*ActionLogData, error)
+	AppendAuditEntry(ctx context.Context, entry *AuditEntry) error
+	ReadAuditEntries(ctx context.Context, query *AuditQuery) (*AuditPage, error)
+	InitWallet(ctx context.Context, wallet *WalletData) (*WalletData, error)
+	ReadWallet(ctx context.Context, playerID string) (*WalletData, error)
+	AdjustWallet(ctx context.Context, playerID string, delta int64) (*WalletData, error)
+	ReadInventory(ctx context.Context, playerID string) (*InventoryData, error)
+	GrantItem(ctx context.Context, grant *ItemGrant) (*InventoryData, error)
+	ConsumeItem(ctx context.Context, grant *ItemGrant) (*InventoryData, error)
+	WriteMatchRecord(ctx context.Context, record *MatchRecord) error
+	ReadMatchRecords(ctx context.Context, playerID string) ([]MatchRecord, error)
+	ReadRatingLeaderboard(ctx context.Context, limit int) ([]RatingEntry, error)
+	ReadLevelLeaderboard(ctx context.Context, level int32, limit int) ([]LevelRankEntry, error)
+	CreatePromoCode(ctx context.Context, promoCode *PromoCode) error
+	RedeemPromoCode(ctx context.Context, redemption *PromoRedemption) (*PromoCode, error)
+	ReadRedemptions(ctx context.Context, playerID string) ([]PromoRedemption, error)
+	InitReferralCode(ctx context.Context, playerID string) (*ReferralData, error)
+	ClaimReferral(ctx context.Context, claim *ReferralClaim) (*ReferralData, error)
+	CompleteReferral(ctx context.Context, completion *ReferralCompletion) (*ReferralData, error)
+	ReadMilestones(ctx context.Context, playerID string) (*MilestonesData, error)
+	ReachMilestones(ctx context.Context, reach *MilestoneReach) (*MilestonesData, error)
+	ClaimMilestone(ctx context.Context, claim *MilestoneClaim) (*MilestonesData, error)
+	ReadAnnotations(ctx context.Context, playerID string) (*AnnotationsData, error)
+	AddAnnotation(ctx context.Context, addition *AnnotationAddition) (*PlayerAnnotation, error)
+	RemoveAnnotation(ctx context.Context, playerID string, annotationID string) (*PlayerAnnotation, error)
+	CreateGuild(ctx context.Context, creation *GuildCreation) (*GuildData, error)
+	JoinGuild(ctx context.Context, join *GuildJoin) (*GuildData, error)
+	LeaveGuild(ctx context.Context, playerID string) (*GuildData, error)
+	ReadGuild(ctx context.Context, guildID string) (*GuildData, error)
+	ReadPlayerGuild(ctx context.Context, playerID string) (*GuildData, error)
+	ListGuilds(ctx context.Context) ([]GuildData, error)
+	ListPlayers(ctx context.Context, page pagination.Request) (*PlayersPage, error)
+	ListStats(ctx context.Context, page pagination.Request) (*StatsPage, error)
+	AcquireLease(ctx context.Context, request *LeaseRequest) (*LeaseData, error)
+	ReleaseLease(ctx context.Context, name string, holder string) error
+}
+
+// HTTPClient is the DataClient implementation which makes internal (server to server) requests to the data service
+type HTTPClient struct {
+	baseURL string
+}
+
+// NewHTTPClient returns an initialized pointer to a data client that talks to the data service at its address in the startup config (see startup.ServiceURL)
+func NewHTTPClient() *HTTPClient {
+	return &HTTPClient{
+		baseURL: startup.ServiceURL("data"),
+	}
+}
+
+// ReadPlayer makes an internal request to the data service to read the required player
+func (hc *HTTPClient) ReadPlayer(ctx context.Context, playerID string) (*PlayerData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/data/player-internal/%v", hc.baseURL, playerID)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, PlayerNotFoundErr{PlayerID: playerID}
+		} else {
+			return nil, fmt.Errorf("internal read player request was not successful, status code %v", resp.StatusCode)
+		}
+	}
+
+	//decode the response for the player data
+	playerData := &PlayerData{}
+	err = json.NewDecoder(resp.Body).Decode(playerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerData, nil
+}
+
+// WritePlayer makes an internal request to the data service to write the required player entry
+func (hc *HTTPClient) WritePlayer(ctx context.Context, player *PlayerData) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	return hc.postInternal(ctx, "/data/player-internal", player, "player")
+}
+
+// SwapPlayer makes an internal request to the data service to write the updated player data,
+// if the stored player data is still the expected one
+func (hc *HTTPClient) SwapPlayer(ctx context.Context, swap *PlayerSwap) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/player-swap-internal", swap, nil)
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return PlayerNotFoundErr{PlayerID: swap.Expected.PlayerID}
+	case http.StatusConflict:
+		return PlayerChangedErr{PlayerID: swap.Expected.PlayerID}
+	default:
+		return fmt.Errorf("internal swap player request was not successful, status code %v", statusCode)
+	}
+}
+
+// ReadStats makes an internal request to the data service to read the stats for the required player
+func (hc *HTTPClient) ReadStats(ctx context.Context, playerID string) (*PlayerStats, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/data/stats-internal/%v", hc.baseURL, playerID)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, PlayerStatsNotFoundErr{PlayerID: playerID}
+		} else {
+			return nil, fmt.Errorf("internal read stats request was not successful, status code %v", resp.StatusCode)
+		}
+	}
+
+	//decode the response for the player stats
+	playerStats := &PlayerStats{}
+	err = json.NewDecoder(resp.Body).Decode(playerStats)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerStats, nil
+}
+
+// WriteStats makes an internal request to the data service to write the required player's stats entries
+func (hc *HTTPClient) WriteStats(ctx context.Context, plStatsWithID *PlayerStatsWithID) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	return hc.postInternal(ctx, "/data/stats-internal", plStatsWithID, "stats")
+}
+
+// ReadBan makes an internal request to the data service to read the ban entry for the required player
+func (hc *HTTPClient) ReadBan(ctx context.Context, playerID string) (*BanData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/data/ban-internal/%v", hc.baseURL, playerID)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, BanNotFoundErr{PlayerID: playerID}
+		} else {
+			return nil, fmt.Errorf("internal read ban request was not successful, status code %v", resp.StatusCode)
+		}
+	}
+
+	//decode the response for the ban data
+	ban := &BanData{}
+	err = json.NewDecoder(resp.Body).Decode(ban)
+	if err != nil {
+		return nil, err
+	}
+
+	return ban, nil
+}
+
+// WriteBan makes an internal request to the data service to write the required ban entry
+func (hc *HTTPClient) WriteBan(ctx context.Context, ban *BanData) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	return hc.postInternal(ctx, "/data/ban-internal", ban, "ban")
+}
+
+// DeleteBan makes an internal request to the data service to delete the ban entry for the required player
+func (hc *HTTPClient) DeleteBan(ctx context.Context, playerID string) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/data/ban-internal/%v", hc.baseURL, playerID)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", reqURL, nil)
+	if err != nil {
+		return err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return BanNotFoundErr{PlayerID: playerID}
+		} else {
+			return fmt.Errorf("internal delete ban request was not successful, status code %v", resp.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// WriteAttempt makes an internal request to the data service to append the attempt to the player's attempt history
+func (hc *HTTPClient) WriteAttempt(ctx context.Context, attempt *AttemptRecord) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	return hc.postInternal(ctx, "/data/attempt-internal", attempt, "attempt")
+}
+
+// ReadAttempts makes internal requests to the data service to read the whole attempt history of the required player
+// (a page at a time)
+func (hc *HTTPClient) ReadAttempts(ctx context.Context, playerID string) ([]AttemptRecord, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	attempts := []AttemptRecord{}
+	page := pagination.Request{Limit: pagination.MaxLimit}
+	for {
+		attemptsPage, err := hc.readAttemptsPage(ctx, playerID, page)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, attemptsPage.Attempts...)
+		if attemptsPage.NextCursor == "" {
+			return attempts, nil
+		}
+		page.Cursor = attemptsPage.NextCursor
+	}
+}
+
+// readAttemptsPage makes an internal request to the data service to read a page of the attempt history of the required player
+func (hc *HTTPClient) readAttemptsPage(ctx context.Context, playerID string, page pagination.Request) (*AttemptsPage, error) {
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/data/attempt-internal/%v?%v", hc.baseURL, playerID, page.Values().Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read attempts request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the attempts page
+	attemptsPage := &AttemptsPage{}
+	err = json.NewDecoder(resp.Body).Decode(attemptsPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return attemptsPage, nil
+}
+
+// RecordLevelEntry makes an internal request to the data service to record a level entry, if the level's limits allow it
+func (hc *HTTPClient) RecordLevelEntry(ctx context.Context, entry *LevelEntry) (*LevelEntryData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if entry == nil {
+		return nil, fmt.Errorf("provided entry pointer is nil")
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/data/level-entry-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		entries := &LevelEntryData{}
+		err = json.NewDecoder(resp.Body).Decode(entries)
+		if err != nil {
+			return nil, err
+		}
+		return entries, nil
+
+	case http.StatusTooManyRequests:
+		// the body holds the limit which rejected the entry
+		limitErr := EntryLimitErr{}
+		err = json.NewDecoder(resp.Body).Decode(&limitErr)
+		if err != nil {
+			return nil, err
+		}
+		return nil, limitErr
+
+	default:
+		return nil, fmt.Errorf("internal record level entry request was not successful, status code %v", resp.StatusCode)
+	}
+}
+
+// ReadLevelAttempts makes an internal request to the data service to read the attempts at a level by all players
+func (hc *HTTPClient) ReadLevelAttempts(ctx context.Context, level int32) ([]AttemptRecord, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	attempts := []AttemptRecord{}
+	statusCode, err := hc.doInternal(ctx, "GET", fmt.Sprintf("/data/level-attempts-internal/%v", level), nil, &attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read level attempts request was not successful, status code %v", statusCode)
+	}
+
+	return attempts, nil
+}
+
+// AppendAuditEntry makes an internal request to the data service to append the given entry to the audit log
+func (hc *HTTPClient) AppendAuditEntry(ctx context.Context, entry *AuditEntry) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	return hc.postInternal(ctx, "/data/audit-internal", entry, "audit")
+}
+
+// ReadAuditEntries makes an internal request to the data service to read a page of the audit log entries matching the query
+func (hc *HTTPClient) ReadAuditEntries(ctx context.Context, query *AuditQuery) (*AuditPage, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if query == nil {
+		query = &AuditQuery{}
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/data/audit-internal?%v", hc.baseURL, query.values().Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read audit request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the audit page
+	auditPage := &AuditPage{}
+	err = json.NewDecoder(resp.Body).Decode(auditPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return auditPage, nil
+}
+
+// InitWallet makes an internal request to the data service to create the given wallet (if the player does not have one yet)
+func (hc *HTTPClient) InitWallet(ctx context.Context, wallet *WalletData) (*WalletData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &WalletData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/wallet-internal", wallet, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal init wallet request was not successful, status code %v", statusCode)
+	}
+
+	return result, nil
+}
+
+// ReadWallet makes an internal request to the data service to read the wallet of the required player
+func (hc *HTTPClient) ReadWallet(ctx context.Context, playerID string) (*WalletData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &WalletData{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/wallet-internal/"+playerID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, WalletNotFoundErr{PlayerID: playerID}
+	default:
+		return nil, fmt.Errorf("internal read wallet request was not successful, status code %v", statusCode)
+	}
+}
+
+// AdjustWallet makes an internal request to the data service to add the delta to the wallet of the required player
+func (hc *HTTPClient) AdjustWallet(ctx context.Context, playerID string, delta int64) (*WalletData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &WalletData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/wallet-adjust-internal", &WalletDelta{PlayerID: playerID, Delta: delta}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, WalletNotFoundErr{PlayerID: playerID}
+	case http.StatusConflict:
+		return nil, InsufficientCoinsErr{PlayerID: playerID}
+	default:
+		return nil, fmt.Errorf("internal adjust wallet request was not successful, status code %v", statusCode)
+	}
+}
+
+// ReadInventory makes an internal request to the data service to read the inventory of the required player
+func (hc *HTTPClient) ReadInventory(ctx context.Context, playerID string) (*InventoryData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &InventoryData{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/inventory-internal/"+playerID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read inventory request was not successful, status code %v", statusCode)
+	}
+
+	return result, nil
+}
+
+// GrantItem makes an internal request to the data service to grant the items to the required player
+func (hc *HTTPClient) GrantItem(ctx context.Context, grant *ItemGrant) (*InventoryData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &InventoryData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/inventory-internal", grant, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal grant item request was not successful, status code %v", statusCode)
+	}
+
+	return result, nil
+}
+
+// ConsumeItem makes an internal request to the data service to take items out of the inventory of a player
+func (hc *HTTPClient) ConsumeItem(ctx context.Context, grant *ItemGrant) (*InventoryData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &InventoryData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/inventory-consume-internal", grant, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusConflict:
+		return nil, NotEnoughItemsErr{PlayerID: grant.PlayerID, ItemID: grant.ItemID}
+	default:
+		return nil, fmt.Errorf("internal consume item request was not successful, status code %v", statusCode)
+	}
+}
+
+// WriteMatchRecord makes an internal request to the data service to append the record to the player's match history
+func (hc *HTTPClient) WriteMatchRecord(ctx context.Context, record *MatchRecord) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	return hc.postInternal(ctx, "/data/match-internal", record, "match")
+}
+
+// ReadMatchRecords makes an internal request to the data service to read the match history of the required player
+func (hc *HTTPClient) ReadMatchRecords(ctx context.Context, playerID string) ([]MatchRecord, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	records := []MatchRecord{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/match-internal/"+playerID, nil, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read match records request was not successful, status code %v", statusCode)
+	}
+
+	return records, nil
+}
+
+// ReadRatingLeaderboard makes an internal request to the data service to read the highest rated players
+func (hc *HTTPClient) ReadRatingLeaderboard(ctx context.Context, limit int) ([]RatingEntry, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	entries := []RatingEntry{}
+	statusCode, err := hc.doInternal(ctx, "GET", fmt.Sprintf("/data/rating-leaderboard-internal?limit=%v", limit), nil, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read rating leaderboard request was not successful, status code %v", statusCode)
+	}
+
+	return entries, nil
+}
+
+// ReadLevelLeaderboard makes an internal request to the data service to read the best players of the given level
+func (hc *HTTPClient) ReadLevelLeaderboard(ctx context.Context, level int32, limit int) ([]LevelRankEntry, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	entries := []LevelRankEntry{}
+	statusCode, err := hc.doInternal(ctx, "GET", fmt.Sprintf("/data/level-leaderboard-internal/%v?limit=%v", level, limit), nil, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read level leaderboard request was not successful, status code %v", statusCode)
+	}
+
+	return entries, nil
+}
+
+// CreatePromoCode makes an internal request to the data service to create the given promo code
+func (hc *HTTPClient) CreatePromoCode(ctx context.Context, promoCode *PromoCode) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/promo-internal", promoCode, nil)
+	if err != nil {
+		return err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return PromoCodeExistsErr{Code: NormalizePromoCode(promoCode.Code)}
+	default:
+		return fmt.Errorf("internal create promo code request was not successful, status code %v", statusCode)
+	}
+}
+
+// RedeemPromoCode makes an internal request to the data service to redeem the promo code for the required player
+func (hc *HTTPClient) RedeemPromoCode(ctx context.Context, redemption *PromoRedemption) (*PromoCode, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if redemption == nil {
+		return nil, fmt.Errorf("provided redemption pointer is nil")
+	}
+
+	result := &PromoCode{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/promo-redeem-internal", redemption, result)
+	if err != nil {
+		return nil, err
+	}
+
+	code := NormalizePromoCode(redemption.Code)
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, PromoCodeNotFoundErr{Code: code}
+	case http.StatusGone:
+		return nil, PromoCodeUnavailableErr{Code: code}
+	case http.StatusConflict:
+		return nil, PromoCodeAlreadyRedeemedErr{Code: code, PlayerID: redemption.PlayerID}
+	default:
+		return nil, fmt.Errorf("internal redeem promo code request was not successful, status code %v", statusCode)
+	}
+}
+
+// ReadRedemptions makes an internal request to the data service to read the promo code redemption history of the required player
+func (hc *HTTPClient) ReadRedemptions(ctx context.Context, playerID string) ([]PromoRedemption, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	redemptions := []PromoRedemption{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/promo-redeem-internal/"+playerID, nil, &redemptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read redemptions request was not successful, status code %v", statusCode)
+	}
+
+	return redemptions, nil
+}
+
+// InitReferralCode makes an internal request to the data service to read the referral data of the required player
+// (giving them a referral code if they have none)
+func (hc *HTTPClient) InitReferralCode(ctx context.Context, playerID string) (*ReferralData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &ReferralData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/referral-code-internal/"+playerID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal init referral code request was not successful, status code %v", statusCode)
+	}
+
+	return result, nil
+}
+
+// ClaimReferral makes an internal request to the data service to claim the referral code for the required player
+func (hc *HTTPClient) ClaimReferral(ctx context.Context, claim *ReferralClaim) (*ReferralData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if claim == nil {
+		return nil, fmt.Errorf("provided referral claim pointer is nil")
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(claim)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/data/referral-claim-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// both a successful claim and a claim rejected by a limit have a json body
+	code := NormalizeReferralCode(claim.Code)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		referral := &ReferralData{}
+		err = json.NewDecoder(resp.Body).Decode(referral)
+		if err != nil {
+			return nil, err
+		}
+		return referral, nil
+	case http.StatusTooManyRequests:
+		limitErr := ReferralLimitErr{}
+		err = json.NewDecoder(resp.Body).Decode(&limitErr)
+		if err != nil {
+			return nil, err
+		}
+		return nil, limitErr
+	case http.StatusNotFound:
+		return nil, ReferralCodeNotFoundErr{Code: code}
+	case http.StatusConflict:
+		return nil, ReferralAlreadyClaimedErr{PlayerID: claim.PlayerID}
+	case http.StatusForbidden:
+		return nil, SelfReferralErr{PlayerID: claim.PlayerID}
+	default:
+		return nil, fmt.Errorf("internal claim referral request was not successful, status code %v", resp.StatusCode)
+	}
+}
+
+// CompleteReferral makes an internal request to the data service to mark the referral of the required player as rewarded
+func (hc *HTTPClient) CompleteReferral(ctx context.Context, completion *ReferralCompletion) (*ReferralData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if completion == nil {
+		return nil, fmt.Errorf("provided referral completion pointer is nil")
+	}
+
+	result := &ReferralData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/referral-complete-internal", completion, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, ReferralNotFoundErr{PlayerID: completion.PlayerID}
+	default:
+		return nil, fmt.Errorf("internal complete referral request was not successful, status code %v", statusCode)
+	}
+}
+
+// ReadMilestones makes an internal request to the data service to read the milestones the required player reached
+func (hc *HTTPClient) ReadMilestones(ctx context.Context, playerID string) (*MilestonesData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &MilestonesData{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/milestones-internal/"+playerID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal read milestones request was not successful, status code %v", statusCode)
+	}
+
+	return result, nil
+}
+
+// ReachMilestones makes an internal request to the data service to record the milestones the required player reached
+func (hc *HTTPClient) ReachMilestones(ctx context.Context, reach *MilestoneReach) (*MilestonesData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if reach == nil {
+		return nil, fmt.Errorf("provided milestone reach pointer is nil")
+	}
+
+	result := &MilestonesData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/milestones-reach-internal", reach, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal reach milestones request was not successful, status code %v", statusCode)
+	}
+
+	return result, nil
+}
+
+// ClaimMilestone makes an internal request to the data service to mark the milestone of the required player as claimed
+func (hc *HTTPClient) ClaimMilestone(ctx context.Context, claim *MilestoneClaim) (*MilestonesData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if claim == nil {
+		return nil, fmt.Errorf("provided milestone claim pointer is nil")
+	}
+
+	result := &MilestonesData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/milestones-claim-internal", claim, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, MilestoneNotReachedErr{PlayerID: claim.PlayerID, MilestoneID: claim.MilestoneID}
+	case http.StatusConflict:
+		return nil, MilestoneAlreadyClaimedErr{PlayerID: claim.PlayerID, MilestoneID: claim.MilestoneID}
+	default:
+		return nil, fmt.Errorf("internal claim milestone request was not successful, status code %v", statusCode)
+	}
+}
+
+// CreateGuild makes an internal request to the data service to create a guild owned by the required player
+func (hc *HTTPClient) CreateGuild(ctx context.Context, creation *GuildCreation) (*GuildData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if creation == nil {
+		return nil, fmt.Errorf("provided guild creation pointer is nil")
+	}
+
+	result := &GuildData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/guild-internal", creation, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusConflict:
+		return nil, GuildNameTakenErr{Name: creation.Name}
+	case http.StatusForbidden:
+		return nil, AlreadyInGuildErr{PlayerID: creation.OwnerID}
+	default:
+		return nil, fmt.Errorf("internal create guild request was not successful, status code %v", statusCode)
+	}
+}
+
+// JoinGuild makes an internal request to the data service to add the required player to the required guild
+func (hc *HTTPClient) JoinGuild(ctx context.Context, join *GuildJoin) (*GuildData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if join == nil {
+		return nil, fmt.Errorf("provided guild join pointer is nil")
+	}
+
+	result := &GuildData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/guild-join-internal", join, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, GuildNotFoundErr{GuildID: join.GuildID}
+	case http.StatusForbidden:
+		return nil, AlreadyInGuildErr{PlayerID: join.PlayerID}
+	case http.StatusConflict:
+		return nil, GuildFullErr{GuildID: join.GuildID}
+	default:
+		return nil, fmt.Errorf("internal join guild request was not successful, status code %v", statusCode)
+	}
+}
+
+// LeaveGuild makes an internal request to the data service to remove the required player from their guild
+func (hc *HTTPClient) LeaveGuild(ctx context.Context, playerID string) (*GuildData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &GuildData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/guild-leave-internal/"+playerID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, NotInGuildErr{PlayerID: playerID}
+	default:
+		return nil, fmt.Errorf("internal leave guild request was not successful, status code %v", statusCode)
+	}
+}
+
+// ReadGuild makes an internal request to the data service to read the required guild
+func (hc *HTTPClient) ReadGuild(ctx context.Context, guildID string) (*GuildData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &GuildData{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/guild-internal/"+guildID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, GuildNotFoundErr{GuildID: guildID}
+	default:
+		return nil, fmt.Errorf("internal read guild request was not successful, status code %v", statusCode)
+	}
+}
+
+// ReadPlayerGuild makes an internal request to the data service to read the guild of the required player
+func (hc *HTTPClient) ReadPlayerGuild(ctx context.Context, playerID string) (*GuildData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &GuildData{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/player-guild-internal/"+playerID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, NotInGuildErr{PlayerID: playerID}
+	default:
+		return nil, fmt.Errorf("internal read player guild request was not successful, status code %v", statusCode)
+	}
+}
+
+// ListGuilds makes an internal request to the data service to read all the guilds
+func (hc *HTTPClient) ListGuilds(ctx context.Context) ([]GuildData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	guilds := []GuildData{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/guilds-internal", nil, &guilds)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal list guilds request was not successful, status code %v", statusCode)
+	}
+
+	return guilds, nil
+}
+
+// doInternal sends a request (with the given body encoded, if not nil) to the given internal data service path,
+// and decodes a successful response into out (if not nil), it returns the response status code
+func (hc *HTTPClient) doInternal(ctx context.Context, method string, path string, body any, out any) (int, error) {
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	if body != nil {
+		err := json.NewEncoder(reqBody).Encode(body)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, method, hc.baseURL+path, reqBody)
+	if err != nil {
+		return 0, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || out == nil {
+		return resp.StatusCode, nil
+	}
+
+	//decode the response
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+// postInternal encodes the given body, and posts it to the given internal data service path
+func (hc *HTTPClient) postInternal(ctx context.Context, path string, body any, entryKind string) error {
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(body)
+	if err != nil {
+		return err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("internal write %v request was not successful, status code %v", entryKind, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// ListPlayers makes an internal request to the data service to read the requested page of all the players
+func (hc *HTTPClient) ListPlayers(ctx context.Context, page pagination.Request) (*PlayersPage, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/data/players-internal?%v", hc.baseURL, page.Values().Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal list players request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the page
+	playersPage := &PlayersPage{}
+	err = json.NewDecoder(resp.Body).Decode(playersPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return playersPage, nil
+}
+
+// ListStats makes an internal request to the data service to read the requested page of all the player stats
+func (hc *HTTPClient) ListStats(ctx context.Context, page pagination.Request) (*StatsPage, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/data/all-stats-internal?%v", hc.baseURL, page.Values().Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal list stats request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the page
+	statsPage := &StatsPage{}
+	err = json.NewDecoder(resp.Body).Decode(statsPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return statsPage, nil
+}
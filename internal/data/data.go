@@ -3,13 +3,20 @@
 package data
 
 import (
+	"context"
 	"encoding/json"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"slices"
 	"sync"
+	"time"
 )
 
 // Data service related errors (used by other services as well):
@@ -31,16 +38,63 @@ func (err PlayerStatsNotFoundErr) Error() string {
 	return fmt.Sprintf("stats entry for id: %v was not found in the stats DB", err.PlayerID)
 }
 
+type BanNotFoundErr struct {
+	PlayerID string
+}
+
+func (err BanNotFoundErr) Error() string {
+	return fmt.Sprintf("ban entry for id: %v was not found in the bans DB", err.PlayerID)
+}
+
 // Data storage related structs (used by other services as well):
 
 // PlayerData stores player related live data like level, energy etc.
 // (used in read/write requests to this service, also used as
 // the response struct for client requests to the profile service)
 type PlayerData struct {
-	PlayerID       string `json:"playerID"`
-	Level          int32  `json:"level"`
-	Energy         int32  `json:"energy"`
-	LastUpdateTime int64  `json:"lastUpdateTime"`
+	PlayerID        string        `json:"playerID"`
+	Level           int32         `json:"level"`
+	Energy          int32         `json:"energy"`
+	LastUpdateTime  int64         `json:"lastUpdateTime"`
+	Boosts          []EnergyBoost `json:"boosts,omitempty"`
+	BankedEnergy    int32         `json:"bankedEnergy,omitempty"`    // energy rewards above the max energy (see config.GameConfig.EnergyBankCap)
+	FTUEStep        int32         `json:"ftueStep,omitempty"`        // the last first time user experience (tutorial) step the player completed
+	PrestigeRank    int32         `json:"prestigeRank,omitempty"`    // the number of times the player reset to the default level from the last one
+	CreatedTime     int64         `json:"createdTime,omitempty"`     // the (unix) time the player was created, 0 for players created before it was tracked
+	ReturnTime      int64         `json:"returnTime,omitempty"`      // the (unix) time the player last came back after a lapse (see config.SegmentConfig)
+	Segment         string        `json:"segment,omitempty"`         // the segment of the player as of their last update (blank for the default segment)
+	ComboMultiplier float64       `json:"comboMultiplier,omitempty"` // the energy reward multiplier built by the current win combo of the player (see config.BonusConfig)
+	Version         int32         `json:"version,omitempty"`         // the layout version of the record, see PlayerDataVersion
+}
+
+// EnergyBoost multiplies the energy regeneration of a player till it expires (unix time)
+type EnergyBoost struct {
+	BoostID         string `json:"boostID"`
+	RegenMultiplier int32  `json:"regenMultiplier"`
+	ExpiryTime      int64  `json:"expiryTime"`
+}
+
+// Equal checks whether the player data is the same as the other player data
+// (no boosts and an empty list of boosts are the same, and the versions are not compared)
+func (pd PlayerData) Equal(other PlayerData) bool {
+	return pd.PlayerID == other.PlayerID &&
+		pd.Level == other.Level &&
+		pd.Energy == other.Energy &&
+		pd.LastUpdateTime == other.LastUpdateTime &&
+		pd.BankedEnergy == other.BankedEnergy &&
+		pd.FTUEStep == other.FTUEStep &&
+		pd.PrestigeRank == other.PrestigeRank &&
+		pd.CreatedTime == other.CreatedTime &&
+		pd.ReturnTime == other.ReturnTime &&
+		pd.Segment == other.Segment &&
+		pd.ComboMultiplier == other.ComboMultiplier &&
+		slices.Equal(pd.Boosts, other.Boosts)
+}
+
+// Clone returns a copy of the player data which does not share its list of boosts
+func (pd PlayerData) Clone() PlayerData {
+	pd.Boosts = slices.Clone(pd.Boosts)
+	return pd
 }
 
 // PlayerLevelStats store historical stats are for a given level for a given player
@@ -51,10 +105,14 @@ type PlayerLevelStats struct {
 	BestScore int32 `json:"bestScore"`
 }
 
-// PlayerStats are for all levels for a given player
-// (used in read requests to this service)
+// PlayerStats are for all levels for a given player, along with their head-to-head match rating
+// (used in read requests to this service, a rating of 0 means the player has not finished a match yet)
 type PlayerStats struct {
-	LevelStats []PlayerLevelStats `json:"levelStats"`
+	LevelStats    []PlayerLevelStats `json:"levelStats"`
+	Rating        int32              `json:"rating,omitempty"`
+	PrestigeCount int32              `json:"prestigeCount,omitempty"` // the number of times the player prestiged
+	WinStreak     int32              `json:"winStreak,omitempty"`     // the levels won in a row (at any level) up to the latest attempt
+	Version       int32              `json:"version,omitempty"`       // the layout version of the record, see PlayerStatsVersion
 }
 
 // PlayerStatsWithID is used as the client response for the public get stats api
@@ -64,13 +122,133 @@ type PlayerStatsWithID struct {
 	PlayerStats PlayerStats `json:"playerStats"`
 }
 
+// statsWriteLimits allow larger bodies than the default, since a stats entry holds the stats for all the levels
+var statsWriteLimits = middleware.RouteLimits{
+	Timeout:      constants.DefaultRequestTimeoutSeconds * time.Second,
+	MaxBodyBytes: 1024 * 1024, // 1 MB
+}
+
+// BanData stores the ban / suspension state of a player
+// (an expiry time of 0 means the ban is permanent, otherwise it is a suspension till that unix time)
+type BanData struct {
+	PlayerID   string `json:"playerID"`
+	Reason     string `json:"reason"`
+	BanTime    int64  `json:"banTime"`
+	ExpiryTime int64  `json:"expiryTime"`
+}
+
+// IsActive returns whether the ban is still in effect at the given unix time
+func (ban *BanData) IsActive(unixNow int64) bool {
+	return ban != nil && (ban.ExpiryTime == 0 || unixNow < ban.ExpiryTime)
+}
+
+// dbKey is the key of the DB entries: a player id (or a promo code) within its namespace, so the services of
+// different environments or game titles sharing the data service never see each other's data
+type dbKey struct {
+	Namespace string
+	ID        string
+}
+
+// keyOf returns the DB key of the given id, in the namespace of the given context (see namespace.FromContext)
+func keyOf(ctx context.Context, id string) dbKey {
+	return dbKey{Namespace: namespace.FromContext(ctx), ID: id}
+}
+
 // Server is the core data service provider
 type Server struct {
-	playersDB    map[string]PlayerData
-	playersMutex sync.Mutex
-
-	statsDB    map[string]PlayerStats
-	statsMutex sync.Mutex
+	// the players and their stats are sharded (see shardedStore), the change times of the stats of a player
+	// are only used (and the level index updated) while holding the lock of the shard of their stats
+	playersDB      *shardedStore[PlayerData]
+	statsDB        *shardedStore[PlayerStats]
+	statsChangesDB *shardedStore[statsChangeTimes]
+	levelIndex     *levelIndex
+
+	bansDB    map[dbKey]BanData
+	bansMutex sync.Mutex
+
+	// the attempt history, and the entries of each player into the levels with entry limits
+	// (both guarded by the attempts mutex)
+	attemptsDB    map[dbKey][]AttemptRecord
+	entriesDB     map[dbKey][]LevelEntryData
+	attemptsMutex sync.Mutex
+
+	// the recent actions of each player, for the throttles of the gameplay actions
+	actionLogsDB   map[dbKey][]ActionLogData
+	throttlesMutex sync.Mutex
+
+	walletsDB    map[dbKey]WalletData
+	walletsMutex sync.Mutex
+
+	inventoriesDB    map[dbKey][]InventoryItem
+	inventoriesMutex sync.Mutex
+
+	matchesDB    map[dbKey][]MatchRecord
+	matchesMutex sync.Mutex
+
+	// promo codes, and the redemption history of each player (both guarded by the promo mutex)
+	promoCodesDB  map[dbKey]PromoCode
+	redemptionsDB map[dbKey][]PromoRedemption
+	promoMutex    sync.Mutex
+
+	// the referral data of each player, the owners of the referral codes, and the referral claims made from each
+	// ip address (all guarded by the referral mutex)
+	referralsDB        map[dbKey]ReferralData
+	referralCodesDB    map[dbKey]string
+	referralIPClaimsDB map[dbKey][]int64
+	referralMutex      sync.Mutex
+
+	// the milestones reached by each player
+	milestonesDB    map[dbKey][]MilestoneState
+	milestonesMutex sync.Mutex
+
+	// the admin only annotations of each player (support tickets, compensations, suspicion flags)
+	annotationsDB    map[dbKey][]PlayerAnnotation
+	annotationsMutex sync.Mutex
+
+	// the guilds, the guild of each guild name (in lowercase), and the guild of each member
+	// (all guarded by the guilds mutex)
+	guildsDB       map[dbKey]GuildData
+	guildNamesDB   map[dbKey]string
+	guildMembersDB map[dbKey]string
+	guildsMutex    sync.Mutex
+
+	// the redirects of the player ids retired by merges, to the players they were merged into
+	redirectsDB    map[dbKey]PlayerRedirect
+	redirectsMutex sync.Mutex
+
+	// audit log per namespace
+	auditLogs  map[string][]AuditEntry
+	auditMutex sync.Mutex
+
+	// leases on singleton background jobs, by name (kept in the player store instead, if it is a lease store),
+	// and the holder name of this data service for the leases on its own jobs
+	leasesDB    map[dbKey]LeaseData
+	leasesMutex sync.Mutex
+	jobHolder   string
+
+	// optional event streams of the players (nil when event sourcing is not enabled)
+	eventStreams map[dbKey]*playerEventStream
+	eventsMutex  sync.Mutex
+
+	// optional cold store for inactive players (nil when archival is not enabled)
+	coldStore    ColdStore
+	archiveMutex sync.Mutex
+
+	// optional backup store (nil when backups are not enabled), the backup mutex also keeps backups and restores apart
+	backupStore BackupStore
+	backupMutex sync.Mutex
+
+	// optional replication: the log of the writes of players and stats shipped to the followers of a primary (guarded by
+	// the replication mutex), or the progress of a read only follower (guarded by the replica mutex, which is held while
+	// a shipped batch is applied), both nil when replication is not enabled
+	replication      *replicationLog
+	replicationMutex sync.Mutex
+	replica          *replicaState
+	replicaMutex     sync.Mutex
+
+	// optional store which holds the players and their stats instead of the players and stats DBs
+	// (nil when they are kept in memory), it is only set before the server runs
+	playerStore PlayerStore
 
 	logger *log.Logger
 }
@@ -79,13 +257,70 @@ type Server struct {
 func NewServer() *Server {
 
 	ds := &Server{
-		playersDB:    map[string]PlayerData{},
-		playersMutex: sync.Mutex{},
+		playersDB:      newShardedStore[PlayerData](),
+		statsDB:        newShardedStore[PlayerStats](),
+		statsChangesDB: newShardedStore[statsChangeTimes](),
+		levelIndex:     newLevelIndex(),
+
+		bansDB:    map[dbKey]BanData{},
+		bansMutex: sync.Mutex{},
+
+		attemptsDB:    map[dbKey][]AttemptRecord{},
+		entriesDB:     map[dbKey][]LevelEntryData{},
+		attemptsMutex: sync.Mutex{},
+
+		actionLogsDB:   map[dbKey][]ActionLogData{},
+		throttlesMutex: sync.Mutex{},
+
+		walletsDB:    map[dbKey]WalletData{},
+		walletsMutex: sync.Mutex{},
+
+		inventoriesDB:    map[dbKey][]InventoryItem{},
+		inventoriesMutex: sync.Mutex{},
+
+		matchesDB:    map[dbKey][]MatchRecord{},
+		matchesMutex: sync.Mutex{},
+
+		promoCodesDB:  map[dbKey]PromoCode{},
+		redemptionsDB: map[dbKey][]PromoRedemption{},
+		promoMutex:    sync.Mutex{},
+
+		referralsDB:        map[dbKey]ReferralData{},
+		referralCodesDB:    map[dbKey]string{},
+		referralIPClaimsDB: map[dbKey][]int64{},
+		referralMutex:      sync.Mutex{},
+
+		milestonesDB:    map[dbKey][]MilestoneState{},
+		milestonesMutex: sync.Mutex{},
+
+		annotationsDB:    map[dbKey][]PlayerAnnotation{},
+		annotationsMutex: sync.Mutex{},
+
+		guildsDB:       map[dbKey]GuildData{},
+		guildNamesDB:   map[dbKey]string{},
+		guildMembersDB: map[dbKey]string{},
+		guildsMutex:    sync.Mutex{},
+
+		redirectsDB:    map[dbKey]PlayerRedirect{},
+		redirectsMutex: sync.Mutex{},
+
+		auditLogs:  map[string][]AuditEntry{},
+		auditMutex: sync.Mutex{},
 
-		statsDB:    map[string]PlayerStats{},
-		statsMutex: sync.Mutex{},
+		leasesDB:    map[dbKey]LeaseData{},
+		leasesMutex: sync.Mutex{},
+		jobHolder:   NewLeaseHolder("data"),
 
-		logger: log.New(os.Stdout, "data: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+		eventsMutex: sync.Mutex{},
+
+		archiveMutex: sync.Mutex{},
+
+		backupMutex: sync.Mutex{},
+
+		replicationMutex: sync.Mutex{},
+		replicaMutex:     sync.Mutex{},
+
+		logger: log.New(redact.Stdout, "data: ", log.Ltime|log.LUTC|log.Lmsgprefix),
 	}
 
 	return ds
@@ -93,24 +328,121 @@ func NewServer() *Server {
 
 // Run runs a given data server on the designated port
 func (ds *Server) Run(port string) {
+	log.Fatal(ds.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the data server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (ds *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ds.serve(ctx, listener)
+}
+
+// RunWithListener runs the data server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (ds *Server) RunWithListener(listener net.Listener) error {
+	return ds.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the data server, and serves them on the given listener till the context is done
+func (ds *Server) serve(ctx context.Context, listener net.Listener) error {
 
 	if ds == nil {
-		fmt.Println(serverNilError)
-		return
+		return serverNilError
 	}
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /data/player-internal", ds.HandleWritePlayerDataRequest)
-	mux.HandleFunc("GET /data/player-internal/{id}", ds.HandleReadPlayerDataRequest)
-
-	mux.HandleFunc("POST /data/stats-internal", ds.HandleWritePlayerStatsRequest)
-	mux.HandleFunc("GET /data/stats-internal/{id}", ds.HandleReadPlayerStatsRequest)
+	mux.Handle("POST /data/player-internal", middleware.WithLimits(ds.HandleWritePlayerDataRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/player-internal/{id}", middleware.WithLimits(ds.HandleReadPlayerDataRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/player-swap-internal", middleware.WithLimits(ds.HandleSwapPlayerRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/players-internal", middleware.WithLimits(ds.HandleListPlayersRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/player-merge-internal", middleware.WithLimits(ds.HandleMergePlayersRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/redirect-internal/{id}", middleware.WithLimits(ds.HandleReadRedirectRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/stats-internal", middleware.WithLimits(ds.HandleWritePlayerStatsRequest, statsWriteLimits))
+	mux.Handle("GET /data/stats-internal/{id}", middleware.WithLimits(ds.HandleReadPlayerStatsRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/stats-delta-internal/{id}", middleware.WithLimits(ds.HandleReadStatsDeltaRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/all-stats-internal", middleware.WithLimits(ds.HandleListStatsRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/player-stats-internal", middleware.WithLimits(ds.HandleWritePlayerAndStatsRequest, statsWriteLimits))
+
+	mux.Handle("POST /data/ban-internal", middleware.WithLimits(ds.HandleWriteBanRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/ban-internal/{id}", middleware.WithLimits(ds.HandleReadBanRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /data/ban-internal/{id}", middleware.WithLimits(ds.HandleDeleteBanRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/attempt-internal", middleware.WithLimits(ds.HandleWriteAttemptRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/attempt-internal/{id}", middleware.WithLimits(ds.HandleReadAttemptsRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/level-attempts-internal/{level}", middleware.WithLimits(ds.HandleReadLevelAttemptsRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/level-entry-internal", middleware.WithLimits(ds.HandleRecordLevelEntryRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/action-internal", middleware.WithLimits(ds.HandleRecordActionRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/wallet-internal", middleware.WithLimits(ds.HandleInitWalletRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/wallet-internal/{id}", middleware.WithLimits(ds.HandleReadWalletRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/wallet-adjust-internal", middleware.WithLimits(ds.HandleAdjustWalletRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/inventory-internal", middleware.WithLimits(ds.HandleGrantItemRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/inventory-consume-internal", middleware.WithLimits(ds.HandleConsumeItemRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/inventory-internal/{id}", middleware.WithLimits(ds.HandleReadInventoryRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/match-internal", middleware.WithLimits(ds.HandleWriteMatchRecordRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/match-internal/{id}", middleware.WithLimits(ds.HandleReadMatchRecordsRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/rating-leaderboard-internal", middleware.WithLimits(ds.HandleReadRatingLeaderboardRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/level-leaderboard-internal/{level}", middleware.WithLimits(ds.HandleReadLevelLeaderboardRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/promo-internal", middleware.WithLimits(ds.HandleCreatePromoCodeRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/promo-redeem-internal", middleware.WithLimits(ds.HandleRedeemPromoCodeRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/promo-redeem-internal/{id}", middleware.WithLimits(ds.HandleReadRedemptionsRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/referral-code-internal/{id}", middleware.WithLimits(ds.HandleInitReferralCodeRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/referral-claim-internal", middleware.WithLimits(ds.HandleClaimReferralRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/referral-complete-internal", middleware.WithLimits(ds.HandleCompleteReferralRequest, middleware.DefaultLimits))
+
+	mux.Handle("GET /data/milestones-internal/{id}", middleware.WithLimits(ds.HandleReadMilestonesRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/milestones-reach-internal", middleware.WithLimits(ds.HandleReachMilestonesRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/milestones-claim-internal", middleware.WithLimits(ds.HandleClaimMilestoneRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/annotations-internal/{id}", middleware.WithLimits(ds.HandleReadAnnotationsRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/annotations-internal", middleware.WithLimits(ds.HandleAddAnnotationRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /data/annotations-internal/{id}/{annotationID}", middleware.WithLimits(ds.HandleRemoveAnnotationRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/guild-internal", middleware.WithLimits(ds.HandleCreateGuildRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/guild-internal/{id}", middleware.WithLimits(ds.HandleReadGuildRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/guilds-internal", middleware.WithLimits(ds.HandleListGuildsRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/guild-join-internal", middleware.WithLimits(ds.HandleJoinGuildRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/guild-leave-internal/{id}", middleware.WithLimits(ds.HandleLeaveGuildRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/player-guild-internal/{id}", middleware.WithLimits(ds.HandleReadPlayerGuildRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/audit-internal", middleware.WithLimits(ds.HandleAppendAuditEntryRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/audit-internal", middleware.WithLimits(ds.HandleReadAuditEntriesRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/lease-internal", middleware.WithLimits(ds.HandleAcquireLeaseRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /data/lease-internal/{name}", middleware.WithLimits(ds.HandleReleaseLeaseRequest, middleware.DefaultLimits))
+
+	mux.Handle("GET /data/player-events-internal/{id}", middleware.WithLimits(ds.HandleReadPlayerEventsRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/player-state-internal/{id}", middleware.WithLimits(ds.HandleReadPlayerStateRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/stats-recompute-internal/{id}", middleware.WithLimits(ds.HandleRecomputeStatsRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/backup-internal", middleware.WithLimits(ds.HandleBackupRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/restore-internal", middleware.WithLimits(ds.HandleRestoreRequest, middleware.DefaultLimits))
+	mux.Handle("GET /data/backups-internal", middleware.WithLimits(ds.HandleListBackupsRequest, middleware.DefaultLimits))
+	mux.Handle("POST /data/admin/backup", middleware.WithLimits(ds.HandleAdminBackupRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /data/replication-internal", middleware.WithLimits(ds.HandleReplicationRequest, replicationLimits))
+	mux.Handle("GET /data/replication-internal", middleware.WithLimits(ds.HandleReplicationStatusRequest, middleware.DefaultLimits))
+
+	// a follower only takes the writes shipped from its primary
+	var handler http.Handler = mux
+	if ds.isFollower() {
+		handler = ds.withReadOnly(mux)
+	}
 
 	ds.logger.Println("the data server is up and running...")
 
-	addr := constants.CommonHost + ":" + port
-	log.Fatal(http.ListenAndServe(addr, mux))
+	return middleware.Serve(ctx, listener, middleware.WithTracing(middleware.WithCompression(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("data", middleware.WithBackpressure("data", middleware.WithAccessLog("data", handler), middleware.DataBackpressureOptions)))), middleware.DefaultCompressionOptions)))
 }
 
 // HandleWritePlayerDataRequest writes the given player data to a player DB entry
@@ -139,13 +471,14 @@ func (ds *Server) HandleWritePlayerDataRequest(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	ds.logger.Printf("writing player DB entry for id: %v", decodedReq.PlayerID)
-
-	ds.playersMutex.Lock()
-	defer ds.playersMutex.Unlock()
-
 	// write the entry to the database
-	ds.playersDB[decodedReq.PlayerID] = *decodedReq
+	err = ds.WritePlayer(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not write player data: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
 
 	// provide the success response, the body is meaningless
 	// (status of 200: operation will be considered a success)
@@ -169,24 +502,17 @@ func (ds *Server) HandleReadPlayerDataRequest(w http.ResponseWriter, r *http.Req
 
 	// get the id from the path value of the request
 	id := r.PathValue("id")
-	ds.logger.Printf("player DB entry requested for id: %v", id)
-
-	ds.playersMutex.Lock()
-	defer ds.playersMutex.Unlock()
 
 	// fetch the entry (if present) from the database
-	player, ok := ds.playersDB[id]
-	if !ok {
-		notFoundErr := PlayerNotFoundErr{id}
-		errMsg := notFoundErr.Error()
-		ds.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusNotFound)
+	player, err := ds.ReadPlayer(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
 	//write the response with the player entry in it and set it back
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(player)
+	err = json.NewEncoder(w).Encode(player)
 	if err != nil {
 		errMsg := "error: could not encode player data: " + err.Error()
 		ds.logger.Println(errMsg)
@@ -220,13 +546,14 @@ func (ds *Server) HandleWritePlayerStatsRequest(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	ds.logger.Printf("writing stats DB entry for id: %v", decodedReq.PlayerID)
-
-	ds.statsMutex.Lock()
-	defer ds.statsMutex.Unlock()
-
 	// write the entry to the database
-	ds.statsDB[decodedReq.PlayerID] = decodedReq.PlayerStats
+	err = ds.WriteStats(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not write player stats: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
 
 	// provide the success response, the body is meaningless
 	// (status of 200: operation will be considered a success)
@@ -250,27 +577,383 @@ func (ds *Server) HandleReadPlayerStatsRequest(w http.ResponseWriter, r *http.Re
 
 	// get the id from the path value of the request
 	id := r.PathValue("id")
-	ds.logger.Printf("stats DB entry requested for id: %v", id)
-
-	ds.statsMutex.Lock()
-	defer ds.statsMutex.Unlock()
 
 	// fetch the entry (if present) from the database
-	plStats, ok := ds.statsDB[id]
-	if !ok {
-		notFoundErr := PlayerStatsNotFoundErr{id}
-		errMsg := notFoundErr.Error()
-		ds.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusNotFound)
+	plStats, err := ds.ReadStats(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
 	//write the response with the player entry in it and set it back
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(plStats)
+	err = json.NewEncoder(w).Encode(plStats)
 	if err != nil {
 		errMsg := "error: could not encode player data: " + err.Error()
 		ds.logger.Println(errMsg)
 		http.Error(w, errMsg, http.StatusInternalServerError)
 	}
 }
+
+// HandleWriteBanRequest writes the given ban data to a bans DB entry
+// (creating a new bans DB entry if not present)
+func (ds *Server) HandleWriteBanRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a BanData struct
+	decodedReq := &BanData{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	if decodedReq.PlayerID == "" {
+		errMsg := "error: cannot write an entry with a blank player id"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// write the entry to the database
+	err = ds.WriteBan(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not write ban data: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleReadBanRequest returns the bans DB entry of the requested player ID (if present)
+func (ds *Server) HandleReadBanRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	// fetch the entry (if present) from the database
+	ban, err := ds.ReadBan(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	//write the response with the ban entry in it and set it back
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(ban)
+	if err != nil {
+		errMsg := "error: could not encode ban data: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// HandleDeleteBanRequest deletes the bans DB entry of the requested player ID (if present)
+func (ds *Server) HandleDeleteBanRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	err := ds.DeleteBan(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// ReadPlayer returns a copy of the player DB entry of the requested player ID (if present)
+func (ds *Server) ReadPlayer(ctx context.Context, playerID string) (*PlayerData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadPlayer")
+	defer span.End()
+
+	ds.logger.Printf("player DB entry requested for id: %v", playerID)
+	key := keyOf(ctx, playerID)
+
+	if ds.playerStore != nil {
+		player, found, err := ds.playerStore.ReadPlayer(ctx, key.Namespace, playerID)
+		if err == nil && !found {
+			err = PlayerNotFoundErr{playerID}
+			ds.logger.Println(err.Error())
+		}
+		return player, err
+	}
+
+	// archived players are brought back to memory on access
+	err := ds.rehydrate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	player, ok := ds.playersDB.get(key)
+	if !ok {
+		notFoundErr := PlayerNotFoundErr{playerID}
+		ds.logger.Println(notFoundErr.Error())
+		return nil, notFoundErr
+	}
+
+	player = player.Clone()
+	return &player, nil
+}
+
+// WritePlayer writes the given player data to a player DB entry
+// (creating a new player DB entry if not present)
+func (ds *Server) WritePlayer(ctx context.Context, player *PlayerData) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.WritePlayer")
+	defer span.End()
+
+	if player == nil {
+		return fmt.Errorf("provided player data pointer is nil")
+	}
+
+	ds.logger.Printf("writing player DB entry for id: %v", player.PlayerID)
+
+	// records are always stored in the current version
+	stored := player.Clone()
+	err := upgradePlayer(&stored)
+	if err != nil {
+		return err
+	}
+
+	key := keyOf(ctx, player.PlayerID)
+	if ds.playerStore != nil {
+		return ds.playerStore.WritePlayer(ctx, key.Namespace, &stored)
+	}
+
+	shard := ds.playersDB.shardOf(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if old, ok := shard.entries[key]; ok {
+		ds.recordPlayerChange(key, &old, stored)
+	} else {
+		ds.recordPlayerChange(key, nil, stored)
+	}
+
+	shard.entries[key] = stored
+	ds.replicate(key, &stored, nil)
+
+	return nil
+}
+
+// ReadStats returns a copy of the stats DB entry of the requested player ID (if present)
+func (ds *Server) ReadStats(ctx context.Context, playerID string) (*PlayerStats, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadStats")
+	defer span.End()
+
+	ds.logger.Printf("stats DB entry requested for id: %v", playerID)
+	key := keyOf(ctx, playerID)
+
+	if ds.playerStore != nil {
+		plStats, found, err := ds.playerStore.ReadStats(ctx, key.Namespace, playerID)
+		if err == nil && !found {
+			err = PlayerStatsNotFoundErr{playerID}
+			ds.logger.Println(err.Error())
+		}
+		return plStats, err
+	}
+
+	// archived players are brought back to memory on access
+	err := ds.rehydrate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plStats, ok := ds.statsDB.get(key)
+	if !ok {
+		notFoundErr := PlayerStatsNotFoundErr{playerID}
+		ds.logger.Println(notFoundErr.Error())
+		return nil, notFoundErr
+	}
+
+	// copy the stats, so that the caller cannot modify the DB entry without going through WriteStats()
+	return copyStats(plStats), nil
+}
+
+// WriteStats writes the given player stats to a stats DB entry
+// (creating a new stats DB entry if not present)
+func (ds *Server) WriteStats(ctx context.Context, plStatsWithID *PlayerStatsWithID) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.WriteStats")
+	defer span.End()
+
+	if plStatsWithID == nil {
+		return fmt.Errorf("provided player stats pointer is nil")
+	}
+
+	ds.logger.Printf("writing stats DB entry for id: %v", plStatsWithID.PlayerID)
+
+	// records are always stored in the current version
+	stored := copyStats(plStatsWithID.PlayerStats)
+	err := upgradeStats(stored)
+	if err != nil {
+		return err
+	}
+
+	key := keyOf(ctx, plStatsWithID.PlayerID)
+	if ds.playerStore != nil {
+		return ds.playerStore.WriteStats(ctx, key.Namespace, plStatsWithID.PlayerID, stored)
+	}
+
+	shard := ds.statsDB.shardOf(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if old, ok := shard.entries[key]; ok {
+		ds.recordStatsChange(key, &old, *stored)
+		ds.stampStatsChanges(key, &old, *stored)
+		ds.levelIndex.update(key, &old, stored)
+	} else {
+		ds.recordStatsChange(key, nil, *stored)
+		ds.stampStatsChanges(key, nil, *stored)
+		ds.levelIndex.update(key, nil, stored)
+	}
+
+	shard.entries[key] = *stored
+	ds.replicate(key, nil, stored)
+
+	return nil
+}
+
+// copyStats returns a copy of the given player stats, including a copy of the level stats slice
+func copyStats(plStats PlayerStats) *PlayerStats {
+	return &PlayerStats{LevelStats: copyLevelStats(plStats.LevelStats), Rating: plStats.Rating, PrestigeCount: plStats.PrestigeCount, WinStreak: plStats.WinStreak, Version: plStats.Version}
+}
+
+// copyLevelStats returns a copy of the given level stats slice (nil stays nil)
+func copyLevelStats(levelStats []PlayerLevelStats) []PlayerLevelStats {
+	if levelStats == nil {
+		return nil
+	}
+	return append(make([]PlayerLevelStats, 0, len(levelStats)), levelStats...)
+}
+
+// ReadBan returns a copy of the bans DB entry of the requested player ID (if present)
+func (ds *Server) ReadBan(ctx context.Context, playerID string) (*BanData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadBan")
+	defer span.End()
+
+	ds.logger.Printf("bans DB entry requested for id: %v", playerID)
+
+	ds.bansMutex.Lock()
+	defer ds.bansMutex.Unlock()
+
+	ban, ok := ds.bansDB[keyOf(ctx, playerID)]
+	if !ok {
+		return nil, BanNotFoundErr{playerID}
+	}
+
+	return &ban, nil
+}
+
+// WriteBan writes the given ban data to a bans DB entry
+// (creating a new bans DB entry if not present)
+func (ds *Server) WriteBan(ctx context.Context, ban *BanData) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.WriteBan")
+	defer span.End()
+
+	if ban == nil {
+		return fmt.Errorf("provided ban data pointer is nil")
+	}
+
+	ds.logger.Printf("writing bans DB entry for id: %v", ban.PlayerID)
+
+	ds.bansMutex.Lock()
+	defer ds.bansMutex.Unlock()
+
+	ds.bansDB[keyOf(ctx, ban.PlayerID)] = *ban
+
+	return nil
+}
+
+// DeleteBan deletes the bans DB entry of the requested player ID (if present)
+func (ds *Server) DeleteBan(ctx context.Context, playerID string) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.DeleteBan")
+	defer span.End()
+
+	ds.logger.Printf("deleting bans DB entry for id: %v", playerID)
+
+	ds.bansMutex.Lock()
+	defer ds.bansMutex.Unlock()
+
+	key := keyOf(ctx, playerID)
+	_, ok := ds.bansDB[key]
+	if !ok {
+		return BanNotFoundErr{playerID}
+	}
+
+	delete(ds.bansDB, key)
+
+	return nil
+}
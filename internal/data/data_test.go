@@ -1,11 +1,29 @@
 package data
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"fmt"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"io"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -29,7 +47,7 @@ func TestNewDataServer(t *testing.T) {
 func TestServer_HandleReadPlayerDataRequest(t *testing.T) {
 
 	ds := NewServer()
-	ds.playersDB["player2"] = PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()}
+	ds.playersDB.put(dbKey{ID: "player2"}, PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
 
 	tests := []struct {
 		name             string
@@ -41,7 +59,7 @@ func TestServer_HandleReadPlayerDataRequest(t *testing.T) {
 	}{
 		{"nil server", nil, "player1", http.StatusInternalServerError, "application/json", nil},
 		{"invalid player", ds, "player1", http.StatusNotFound, "application/json", nil},
-		{"existing player", ds, "player2", http.StatusOK, "application/json", &PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()}},
+		{"existing player", ds, "player2", http.StatusOK, "application/json", &PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix(), Version: PlayerDataVersion}},
 	}
 
 	for _, test := range tests {
@@ -84,7 +102,7 @@ func TestServer_HandleReadPlayerDataRequest(t *testing.T) {
 func TestServer_HandleWritePlayerDataRequest(t *testing.T) {
 
 	ds := NewServer()
-	ds.playersDB["player2"] = PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()}
+	ds.playersDB.put(dbKey{ID: "player2"}, PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
 
 	tests := []struct {
 		name            string
@@ -135,13 +153,13 @@ func TestServer_HandleReadPlayerStatsRequest(t *testing.T) {
 
 	ds := NewServer()
 
-	ds.statsDB["player2"] = PlayerStats{
+	ds.statsDB.put(dbKey{ID: "player2"}, PlayerStats{
 		LevelStats: []PlayerLevelStats{
 			{1, 2, 3, 1},
 			{2, 1, 4, 2},
 			{3, 0, 1, 99},
 		},
-	}
+	})
 
 	tests := []struct {
 		name             string
@@ -157,7 +175,7 @@ func TestServer_HandleReadPlayerStatsRequest(t *testing.T) {
 			{1, 2, 3, 1},
 			{2, 1, 4, 2},
 			{3, 0, 1, 99},
-		}}},
+		}, Version: PlayerStatsVersion}},
 	}
 
 	for _, test := range tests {
@@ -200,13 +218,13 @@ func TestServer_HandleReadPlayerStatsRequest(t *testing.T) {
 func TestServer_HandleWritePlayerStatsRequest(t *testing.T) {
 
 	ds := NewServer()
-	ds.statsDB["player2"] = PlayerStats{
+	ds.statsDB.put(dbKey{ID: "player2"}, PlayerStats{
 		LevelStats: []PlayerLevelStats{
 			{1, 2, 3, 1},
 			{2, 1, 4, 2},
 			{3, 0, 1, 99},
 		},
-	}
+	})
 	tests := []struct {
 		name            string
 		server          *Server
@@ -252,3 +270,3917 @@ func TestServer_HandleWritePlayerStatsRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPClient(t *testing.T) {
+
+	ds := NewServer()
+	ds.playersDB.put(dbKey{ID: "player2"}, PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: 1})
+	ds.statsDB.put(dbKey{ID: "player2"}, PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1}}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /data/player-internal", ds.HandleWritePlayerDataRequest)
+	mux.HandleFunc("GET /data/player-internal/{id}", ds.HandleReadPlayerDataRequest)
+	mux.HandleFunc("POST /data/stats-internal", ds.HandleWritePlayerStatsRequest)
+	mux.HandleFunc("GET /data/stats-internal/{id}", ds.HandleReadPlayerStatsRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	var hc1 *HTTPClient
+	hc2 := &HTTPClient{baseURL: testServer.URL}
+
+	tests := []struct {
+		name       string
+		client     *HTTPClient
+		playerID   string
+		wantPlayer *PlayerData
+		wantStats  *PlayerStats
+		expError   error
+	}{
+		{"nil client", hc1, "player2", nil, nil, clientNilError},
+		{"invalid player", hc2, "player1", nil, nil, PlayerNotFoundErr{"player1"}},
+		{"existing player", hc2, "player2", &PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: 1, Version: PlayerDataVersion}, &PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1}}, Version: PlayerStatsVersion}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotPlayer, gotErr := test.client.ReadPlayer(context.Background(), test.playerID)
+			if gotErr != nil {
+				if !errors.Is(gotErr, test.expError) {
+					t.Fatalf("ReadPlayer() failed with an unexpected error, %v", gotErr)
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(gotPlayer, test.wantPlayer) {
+				t.Errorf("ReadPlayer() gave incorrect results, want: %v, got: %v", test.wantPlayer, gotPlayer)
+			}
+
+			gotPlayer.Energy += 10
+			err := test.client.WritePlayer(context.Background(), gotPlayer)
+			if err != nil {
+				t.Fatalf("WritePlayer() failed with an unexpected error, %v", err)
+			}
+
+			storedPlayer, _ := ds.playersDB.get(dbKey{ID: test.playerID})
+			if !storedPlayer.Equal(*gotPlayer) {
+				t.Errorf("WritePlayer() gave incorrect results, want: %v, got: %v", *gotPlayer, storedPlayer)
+			}
+
+			gotStats, err := test.client.ReadStats(context.Background(), test.playerID)
+			if err != nil {
+				t.Fatalf("ReadStats() failed with an unexpected error, %v", err)
+			}
+
+			if !reflect.DeepEqual(gotStats, test.wantStats) {
+				t.Errorf("ReadStats() gave incorrect results, want: %v, got: %v", test.wantStats, gotStats)
+			}
+
+			gotStats.LevelStats[0].WinCount += 1
+			err = test.client.WriteStats(context.Background(), &PlayerStatsWithID{PlayerID: test.playerID, PlayerStats: *gotStats})
+			if err != nil {
+				t.Fatalf("WriteStats() failed with an unexpected error, %v", err)
+			}
+
+			storedStats, _ := ds.statsDB.get(dbKey{ID: test.playerID})
+			if !reflect.DeepEqual(storedStats, *gotStats) {
+				t.Errorf("WriteStats() gave incorrect results, want: %v, got: %v", *gotStats, storedStats)
+			}
+		})
+	}
+}
+
+func TestServer_ReadStats(t *testing.T) {
+
+	ds := NewServer()
+	ds.statsDB.put(dbKey{ID: "player2"}, PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1}}})
+
+	// modifying the returned stats should not modify the DB entry
+	gotStats, err := ds.ReadStats(context.Background(), "player2")
+	if err != nil {
+		t.Fatalf("ReadStats() failed with an unexpected error, %v", err)
+	}
+	gotStats.LevelStats[0].WinCount = 10
+
+	if storedStats, _ := ds.statsDB.get(dbKey{ID: "player2"}); storedStats.LevelStats[0].WinCount != 2 {
+		t.Error("ReadStats() should return a copy of the DB entry")
+	}
+}
+
+func TestServer_HandleWriteBanRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	tests := []struct {
+		name            string
+		server          *Server
+		requestBan      *BanData
+		wantStatus      int
+		wantContentType string
+	}{
+		{"nil server", nil, nil, http.StatusInternalServerError, "text/plain"},
+		{"nil ban", ds, nil, http.StatusBadRequest, "text/plain"},
+		{"valid ban", ds, &BanData{PlayerID: "player2", Reason: "cheating", BanTime: 1, ExpiryTime: 0}, http.StatusOK, "text/plain"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err := json.NewEncoder(buf).Encode(test.requestBan)
+			if err != nil {
+				t.Fatal("could not encode the request body: " + err.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/ban-internal", buf)
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleWriteBanRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				if ds.bansDB[dbKey{ID: test.requestBan.PlayerID}] != *test.requestBan {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", *test.requestBan, ds.bansDB[dbKey{ID: test.requestBan.PlayerID}])
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleReadBanRequest(t *testing.T) {
+
+	ds := NewServer()
+	ds.bansDB[dbKey{ID: "player2"}] = BanData{PlayerID: "player2", Reason: "cheating", BanTime: 1, ExpiryTime: 100}
+
+	tests := []struct {
+		name             string
+		server           *Server
+		playerID         string
+		wantStatus       int
+		wantResponseBody *BanData
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil},
+		{"player without ban", ds, "player1", http.StatusNotFound, nil},
+		{"banned player", ds, "player2", http.StatusOK, &BanData{PlayerID: "player2", Reason: "cheating", BanTime: 1, ExpiryTime: 100}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/data/ban-internal/", nil)
+			newReq.SetPathValue("id", test.playerID)
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleReadBanRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &BanData{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
+				}
+			}
+		})
+	}
+}
+
+func TestBanData_IsActive(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		ban     *BanData
+		unixNow int64
+		want    bool
+	}{
+		{"nil ban", nil, 10, false},
+		{"permanent ban", &BanData{PlayerID: "player1", ExpiryTime: 0}, 10, true},
+		{"active suspension", &BanData{PlayerID: "player1", ExpiryTime: 20}, 10, true},
+		{"expired suspension", &BanData{PlayerID: "player1", ExpiryTime: 20}, 30, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.ban.IsActive(test.unixNow)
+			if got != test.want {
+				t.Errorf("IsActive() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_HandleAppendAuditEntryRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+		wantLogLen int
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, 0},
+		{"invalid body", ds, "{", http.StatusBadRequest, 0},
+		{"missing action", ds, `{"actor":"admin","playerID":"player1"}`, http.StatusBadRequest, 0},
+		{"valid entry", ds, `{"actor":"admin","action":"ban","playerID":"player1","payload":{"reason":"cheating"}}`, http.StatusOK, 1},
+		{"second entry", ds, `{"actor":"player1","action":"login","playerID":"player1"}`, http.StatusOK, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/audit-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleAppendAuditEntryRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if dataServer != nil && len(dataServer.auditLogs[""]) != test.wantLogLen {
+				t.Errorf("audit log has incorrect length, want: %v, got: %v", test.wantLogLen, len(dataServer.auditLogs[""]))
+			}
+		})
+	}
+
+	if ds.auditLogs[""][1].ID != 2 || ds.auditLogs[""][1].Time == 0 {
+		t.Errorf("audit entry was not assigned an id and time, got: %v", ds.auditLogs[""][1])
+	}
+}
+
+func TestServer_HandleReadAuditEntriesRequest(t *testing.T) {
+
+	ds := NewServer()
+	entries := []AuditEntry{
+		{Actor: "player1", Action: "login", PlayerID: "player1"},
+		{Actor: "admin", Action: "ban", PlayerID: "player2"},
+		{Actor: "player1", Action: "logout", PlayerID: "player1"},
+		{Actor: "admin", Action: "unban", PlayerID: "player2"},
+	}
+	for _, entry := range entries {
+		err := ds.AppendAuditEntry(context.Background(), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ds.auditLogs[""][3].Time = ds.auditLogs[""][2].Time + 100
+
+	cursorOf := func(id int64) string {
+		cursor, _ := pagination.EncodeCursor(id)
+		return cursor
+	}
+
+	tests := []struct {
+		name           string
+		server         *Server
+		query          string
+		wantStatus     int
+		wantIDs        []int64
+		wantNextCursor string
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil, ""},
+		{"invalid cursor", ds, "cursor=x", http.StatusBadRequest, nil, ""},
+		{"invalid limit", ds, "limit=-1", http.StatusBadRequest, nil, ""},
+		{"invalid since", ds, "since=yesterday", http.StatusBadRequest, nil, ""},
+		{"all entries", ds, "", http.StatusOK, []int64{1, 2, 3, 4}, ""},
+		{"by player", ds, "playerID=player2", http.StatusOK, []int64{2, 4}, ""},
+		{"by action", ds, "action=logout", http.StatusOK, []int64{3}, ""},
+		{"by time", ds, fmt.Sprintf("since=%v", ds.auditLogs[""][3].Time), http.StatusOK, []int64{4}, ""},
+		{"until time", ds, fmt.Sprintf("until=%v", ds.auditLogs[""][2].Time), http.StatusOK, []int64{1, 2, 3}, ""},
+		{"after cursor", ds, "cursor=" + cursorOf(2), http.StatusOK, []int64{3, 4}, ""},
+		{"limit", ds, "limit=3", http.StatusOK, []int64{1, 2, 3}, cursorOf(3)},
+		{"filtered pages", ds, "playerID=player2&limit=1", http.StatusOK, []int64{2}, cursorOf(2)},
+		{"descending", ds, "order=desc&limit=3", http.StatusOK, []int64{4, 3, 2}, cursorOf(2)},
+		{"after last id", ds, "cursor=" + cursorOf(10), http.StatusOK, []int64{}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/data/audit-internal?"+test.query, nil)
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleReadAuditEntriesRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotPage := &AuditPage{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotPage)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				gotIDs := []int64{}
+				for _, entry := range gotPage.Entries {
+					gotIDs = append(gotIDs, entry.ID)
+				}
+
+				if !reflect.DeepEqual(gotIDs, test.wantIDs) || gotPage.NextCursor != test.wantNextCursor {
+					t.Errorf("handler gave incorrect results, want: %v (next: %v), got: %v (next: %v)", test.wantIDs, test.wantNextCursor, gotIDs, gotPage.NextCursor)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleWriteAttemptRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError},
+		{"invalid body", ds, "{", http.StatusBadRequest},
+		{"blank player id", ds, `{"level":1,"won":true,"score":2}`, http.StatusBadRequest},
+		{"valid attempt", ds, `{"playerID":"player1","level":1,"won":true,"score":2,"time":10}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/attempt-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleWriteAttemptRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	want := []AttemptRecord{{PlayerID: "player1", Level: 1, Won: true, Score: 2, Time: 10}}
+	if !reflect.DeepEqual(ds.attemptsDB[dbKey{ID: "player1"}], want) {
+		t.Errorf("attempts DB has incorrect entries, want: %v, got: %v", want, ds.attemptsDB[dbKey{ID: "player1"}])
+	}
+}
+
+func TestServer_HandleReadAttemptsRequest(t *testing.T) {
+
+	ds := NewServer()
+	ds.attemptsDB[dbKey{ID: "player2"}] = []AttemptRecord{
+		{PlayerID: "player2", Level: 1, Won: false, Score: 99, Time: 10},
+		{PlayerID: "player2", Level: 1, Won: true, Score: 2, Time: 20},
+		{PlayerID: "player2", Level: 2, Won: false, Score: 99, Time: 30},
+	}
+	attempts := ds.attemptsDB[dbKey{ID: "player2"}]
+
+	cursorOf := func(position int) string {
+		cursor, _ := pagination.EncodeCursor(position)
+		return cursor
+	}
+
+	tests := []struct {
+		name           string
+		server         *Server
+		playerID       string
+		query          string
+		wantStatus     int
+		wantAttempts   []AttemptRecord
+		wantNextCursor string
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError, nil, ""},
+		{"invalid level", ds, "player2", "?level=0", http.StatusBadRequest, nil, ""},
+		{"invalid cursor", ds, "player2", "?cursor=x", http.StatusBadRequest, nil, ""},
+		{"player without attempts", ds, "player1", "", http.StatusOK, []AttemptRecord{}, ""},
+		{"player with attempts", ds, "player2", "", http.StatusOK, attempts, ""},
+		{"first page", ds, "player2", "?limit=2", http.StatusOK, attempts[:2], cursorOf(2)},
+		{"last page", ds, "player2", "?limit=2&cursor=" + cursorOf(2), http.StatusOK, attempts[2:], ""},
+		{"latest first", ds, "player2", "?limit=1&order=desc", http.StatusOK, attempts[2:], cursorOf(3)},
+		{"by level", ds, "player2", "?level=1&order=desc", http.StatusOK, []AttemptRecord{attempts[1], attempts[0]}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/data/attempt-internal/"+test.playerID+test.query, nil)
+			newReq.SetPathValue("id", test.playerID)
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleReadAttemptsRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotPage := &AttemptsPage{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotPage)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotPage.Attempts, test.wantAttempts) || gotPage.NextCursor != test.wantNextCursor {
+					t.Errorf("handler gave incorrect results, want: %v (next: %v), got: %v (next: %v)", test.wantAttempts, test.wantNextCursor, gotPage.Attempts, gotPage.NextCursor)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPClient_ReadAttempts(t *testing.T) {
+
+	ds := NewServer()
+	wantAttempts := []AttemptRecord{}
+	for i := range pagination.MaxLimit + 5 {
+		wantAttempts = append(wantAttempts, AttemptRecord{PlayerID: "player1", Level: 1, Won: i%2 == 0, Score: 2, Time: int64(i)})
+	}
+	ds.attemptsDB[dbKey{ID: "player1"}] = wantAttempts
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/attempt-internal/{id}", ds.HandleReadAttemptsRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	// the whole history is read, across pages
+	gotAttempts, err := hc.ReadAttempts(context.Background(), "player1")
+	if err != nil {
+		t.Fatalf("ReadAttempts() failed with an unexpected error, %v", err)
+	}
+
+	if !reflect.DeepEqual(gotAttempts, wantAttempts) {
+		t.Errorf("ReadAttempts() gave incorrect results, want: %v attempts, got: %v", len(wantAttempts), len(gotAttempts))
+	}
+}
+
+func TestFileColdStore(t *testing.T) {
+
+	store, err := NewFileColdStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archived := &ArchivedPlayer{
+		Player: PlayerData{PlayerID: "player1", Level: 2, Energy: 10, LastUpdateTime: 100, Version: PlayerDataVersion},
+		Stats:  &PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, LossCount: 0, BestScore: 2}}, Version: PlayerStatsVersion},
+	}
+
+	err = store.Store(archived)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, found, err := store.Load("", "player1")
+	if err != nil || !found {
+		t.Fatalf("could not load the archived player, found: %v, error: %v", found, err)
+	}
+	if !reflect.DeepEqual(got, archived) {
+		t.Errorf("cold store gave incorrect results, want: %v, got: %v", archived, got)
+	}
+
+	err = store.Remove("", "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err = store.Load("", "player1")
+	if err != nil || found {
+		t.Errorf("removed player should not be found, found: %v, error: %v", found, err)
+	}
+
+	_, _, err = store.Load("", "../player1")
+	if !errors.Is(err, invalidArchiveIDError) {
+		t.Errorf("expected an invalid archive id error, got: %v", err)
+	}
+
+	// the same player id in another namespace is a different player
+	namespaced := &ArchivedPlayer{Namespace: "staging", Player: PlayerData{PlayerID: "player1", Level: 5, Energy: 1, LastUpdateTime: 200, Version: PlayerDataVersion}}
+	err = store.Store(namespaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err = store.Load("", "player1")
+	if err != nil || found {
+		t.Errorf("player of another namespace should not be found, found: %v, error: %v", found, err)
+	}
+
+	got, found, err = store.Load("staging", "player1")
+	if err != nil || !found || !reflect.DeepEqual(got, namespaced) {
+		t.Errorf("cold store gave incorrect results, want: %v, got: %v (found: %v, error: %v)", namespaced, got, found, err)
+	}
+
+	_, _, err = store.Load("..", "player1")
+	if !errors.Is(err, invalidArchiveIDError) {
+		t.Errorf("expected an invalid archive id error, got: %v", err)
+	}
+}
+
+func TestServer_Namespaces(t *testing.T) {
+
+	ds := NewServer()
+
+	defaultCtx := context.Background()
+	stagingCtx := namespace.NewContext(context.Background(), "staging")
+
+	err := ds.WritePlayer(defaultCtx, &PlayerData{PlayerID: "player1", Level: 1, Energy: 10, LastUpdateTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.WritePlayer(stagingCtx, &PlayerData{PlayerID: "player1", Level: 7, Energy: 40, LastUpdateTime: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.WriteStats(stagingCtx, &PlayerStatsWithID{PlayerID: "player1", PlayerStats: PlayerStats{Rating: 1200}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		wantLevel   int32
+		wantStats   bool
+		wantRatings int
+	}{
+		{"default namespace", defaultCtx, 1, false, 0},
+		{"staging namespace", stagingCtx, 7, true, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			player, readErr := ds.ReadPlayer(test.ctx, "player1")
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if player.Level != test.wantLevel {
+				t.Errorf("ReadPlayer() gave incorrect results, want level: %v, got: %v", test.wantLevel, player.Level)
+			}
+
+			_, readErr = ds.ReadStats(test.ctx, "player1")
+			if (readErr == nil) != test.wantStats {
+				t.Errorf("ReadStats() gave incorrect results, want stats: %v, got error: %v", test.wantStats, readErr)
+			}
+
+			entries, readErr := ds.ReadRatingLeaderboard(test.ctx, 10)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if len(entries) != test.wantRatings {
+				t.Errorf("ReadRatingLeaderboard() gave incorrect results, want entries: %v, got: %v", test.wantRatings, entries)
+			}
+		})
+	}
+
+	_, err = ds.ReadPlayer(namespace.NewContext(context.Background(), "prod"), "player1")
+	if !errors.Is(err, PlayerNotFoundErr{PlayerID: "player1"}) {
+		t.Errorf("expected a player not found error, got: %v", err)
+	}
+}
+
+func TestServer_archiveInactivePlayers(t *testing.T) {
+
+	store, err := NewFileColdStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := NewServer()
+	ds.coldStore = store
+
+	unixNow := time.Now().UTC().Unix()
+	activePlayer := &PlayerData{PlayerID: "player1", Level: 1, Energy: 50, LastUpdateTime: unixNow}
+	inactivePlayer := &PlayerData{PlayerID: "player2", Level: 3, Energy: 20, LastUpdateTime: unixNow - 100, Version: PlayerDataVersion}
+	inactiveStats := &PlayerStatsWithID{PlayerID: "player2", PlayerStats: PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, LossCount: 2, BestScore: 2}}, Version: PlayerStatsVersion}}
+
+	for _, player := range []*PlayerData{activePlayer, inactivePlayer} {
+		err = ds.WritePlayer(context.Background(), player)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ds.WriteStats(context.Background(), inactiveStats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := ds.archiveInactivePlayers(time.Unix(unixNow, 0), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("incorrect number of archived players, want: %v, got: %v", 1, count)
+	}
+
+	if _, ok := ds.playersDB.get(dbKey{ID: "player2"}); ok {
+		t.Error("inactive player should have been removed from the players DB")
+	}
+	if _, ok := ds.statsDB.get(dbKey{ID: "player2"}); ok {
+		t.Error("inactive player should have been removed from the stats DB")
+	}
+	if _, ok := ds.playersDB.get(dbKey{ID: "player1"}); !ok {
+		t.Error("active player should still be in the players DB")
+	}
+
+	// reading the stats brings the whole archived player back
+	gotStats, err := ds.ReadStats(context.Background(), "player2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotStats, &inactiveStats.PlayerStats) {
+		t.Errorf("rehydrated stats are incorrect, want: %v, got: %v", inactiveStats.PlayerStats, gotStats)
+	}
+
+	gotPlayer, err := ds.ReadPlayer(context.Background(), "player2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotPlayer, inactivePlayer) {
+		t.Errorf("rehydrated player is incorrect, want: %v, got: %v", inactivePlayer, gotPlayer)
+	}
+
+	_, found, err := store.Load("", "player2")
+	if err != nil || found {
+		t.Errorf("rehydrated player should be removed from the cold store, found: %v, error: %v", found, err)
+	}
+
+	_, err = ds.ReadPlayer(context.Background(), "player3")
+	if !errors.Is(err, PlayerNotFoundErr{PlayerID: "player3"}) {
+		t.Errorf("expected a player not found error, got: %v", err)
+	}
+}
+
+func TestServer_HandleInitWalletRequest(t *testing.T) {
+
+	ds := NewServer()
+	ds.walletsDB[dbKey{ID: "player2"}] = WalletData{PlayerID: "player2", Coins: 7}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+		wantWallet *WalletData
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil},
+		{"invalid body", ds, "{", http.StatusBadRequest, nil},
+		{"blank player id", ds, `{"coins":10}`, http.StatusBadRequest, nil},
+		{"negative coins", ds, `{"playerID":"player1","coins":-10}`, http.StatusBadRequest, nil},
+		{"new wallet", ds, `{"playerID":"player1","coins":10}`, http.StatusOK, &WalletData{PlayerID: "player1", Coins: 10}},
+		{"existing wallet", ds, `{"playerID":"player2","coins":10}`, http.StatusOK, &WalletData{PlayerID: "player2", Coins: 7}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/wallet-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleInitWalletRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotWallet := &WalletData{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotWallet)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotWallet, test.wantWallet) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantWallet, gotWallet)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleAdjustWalletRequest(t *testing.T) {
+
+	ds := NewServer()
+	ds.walletsDB[dbKey{ID: "player1"}] = WalletData{PlayerID: "player1", Coins: 10}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+		wantCoins  int64
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, 0},
+		{"invalid body", ds, "{", http.StatusBadRequest, 0},
+		{"missing wallet", ds, `{"playerID":"player2","delta":5}`, http.StatusNotFound, 0},
+		{"add coins", ds, `{"playerID":"player1","delta":5}`, http.StatusOK, 15},
+		{"take coins", ds, `{"playerID":"player1","delta":-12}`, http.StatusOK, 3},
+		{"insufficient coins", ds, `{"playerID":"player1","delta":-4}`, http.StatusConflict, 0},
+		{"take all coins", ds, `{"playerID":"player1","delta":-3}`, http.StatusOK, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/wallet-adjust-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleAdjustWalletRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotWallet := &WalletData{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotWallet)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotWallet.Coins != test.wantCoins {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantCoins, gotWallet.Coins)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleGrantItemRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	tests := []struct {
+		name          string
+		server        *Server
+		body          string
+		wantStatus    int
+		wantInventory *InventoryData
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil},
+		{"invalid body", ds, "{", http.StatusBadRequest, nil},
+		{"blank item id", ds, `{"playerID":"player1","count":1}`, http.StatusBadRequest, nil},
+		{"zero count", ds, `{"playerID":"player1","itemID":"item1"}`, http.StatusBadRequest, nil},
+		{"new item", ds, `{"playerID":"player1","itemID":"item1","count":1}`, http.StatusOK, &InventoryData{PlayerID: "player1", Items: []InventoryItem{{ItemID: "item1", Count: 1}}}},
+		{"second item", ds, `{"playerID":"player1","itemID":"item2","count":3}`, http.StatusOK, &InventoryData{PlayerID: "player1", Items: []InventoryItem{{ItemID: "item1", Count: 1}, {ItemID: "item2", Count: 3}}}},
+		{"more of an item", ds, `{"playerID":"player1","itemID":"item1","count":2}`, http.StatusOK, &InventoryData{PlayerID: "player1", Items: []InventoryItem{{ItemID: "item1", Count: 3}, {ItemID: "item2", Count: 3}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/inventory-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleGrantItemRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotInventory := &InventoryData{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotInventory)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotInventory, test.wantInventory) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantInventory, gotInventory)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleSwapPlayerRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	err := ds.WritePlayer(context.Background(), &PlayerData{PlayerID: "player1", Level: 1, Energy: 20, LastUpdateTime: 100})
+	if err != nil {
+		t.Fatal("write player error: " + err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+		wantPlayer *PlayerData
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil},
+		{"invalid body", ds, "{", http.StatusBadRequest, nil},
+		{"mismatched ids", ds, `{"expected":{"playerID":"player1"},"updated":{"playerID":"player2"}}`, http.StatusBadRequest, nil},
+		{"missing player", ds, `{"expected":{"playerID":"player2"},"updated":{"playerID":"player2"}}`, http.StatusNotFound, nil},
+		{"changed player", ds, `{"expected":{"playerID":"player1","level":1,"energy":25,"lastUpdateTime":100},"updated":{"playerID":"player1","level":1,"energy":15,"lastUpdateTime":110}}`, http.StatusConflict, &PlayerData{PlayerID: "player1", Level: 1, Energy: 20, LastUpdateTime: 100, Version: PlayerDataVersion}},
+		{"unchanged player", ds, `{"expected":{"playerID":"player1","level":1,"energy":20,"lastUpdateTime":100},"updated":{"playerID":"player1","level":1,"energy":15,"lastUpdateTime":110}}`, http.StatusOK, &PlayerData{PlayerID: "player1", Level: 1, Energy: 15, LastUpdateTime: 110, Version: PlayerDataVersion}},
+		{"stale swap", ds, `{"expected":{"playerID":"player1","level":1,"energy":20,"lastUpdateTime":100},"updated":{"playerID":"player1","level":1,"energy":15,"lastUpdateTime":110}}`, http.StatusConflict, &PlayerData{PlayerID: "player1", Level: 1, Energy: 15, LastUpdateTime: 110, Version: PlayerDataVersion}},
+		{"boost added", ds, `{"expected":{"playerID":"player1","level":1,"energy":15,"lastUpdateTime":110,"boosts":[]},"updated":{"playerID":"player1","level":1,"energy":15,"lastUpdateTime":110,"boosts":[{"boostID":"boost1","regenMultiplier":2,"expiryTime":3710}]}}`, http.StatusOK, &PlayerData{PlayerID: "player1", Level: 1, Energy: 15, LastUpdateTime: 110, Boosts: []EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: 3710}}, Version: PlayerDataVersion}},
+		{"changed boosts", ds, `{"expected":{"playerID":"player1","level":1,"energy":15,"lastUpdateTime":110,"boosts":[{"boostID":"boost1","regenMultiplier":2,"expiryTime":9999}]},"updated":{"playerID":"player1","level":1,"energy":15,"lastUpdateTime":110}}`, http.StatusConflict, &PlayerData{PlayerID: "player1", Level: 1, Energy: 15, LastUpdateTime: 110, Boosts: []EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: 3710}}, Version: PlayerDataVersion}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/player-swap-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleSwapPlayerRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if test.wantPlayer != nil {
+				gotPlayer, readErr := ds.ReadPlayer(context.Background(), "player1")
+				if readErr != nil {
+					t.Fatal("read player error: " + readErr.Error())
+				}
+
+				if !reflect.DeepEqual(gotPlayer, test.wantPlayer) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantPlayer, gotPlayer)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleConsumeItemRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	_, err := ds.GrantItem(context.Background(), &ItemGrant{PlayerID: "player1", ItemID: "item1", Count: 2})
+	if err != nil {
+		t.Fatal("grant item error: " + err.Error())
+	}
+
+	tests := []struct {
+		name          string
+		server        *Server
+		body          string
+		wantStatus    int
+		wantInventory *InventoryData
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil},
+		{"invalid body", ds, "{", http.StatusBadRequest, nil},
+		{"zero count", ds, `{"playerID":"player1","itemID":"item1"}`, http.StatusBadRequest, nil},
+		{"unowned item", ds, `{"playerID":"player1","itemID":"item2","count":1}`, http.StatusConflict, nil},
+		{"too many items", ds, `{"playerID":"player1","itemID":"item1","count":3}`, http.StatusConflict, nil},
+		{"some of an item", ds, `{"playerID":"player1","itemID":"item1","count":1}`, http.StatusOK, &InventoryData{PlayerID: "player1", Items: []InventoryItem{{ItemID: "item1", Count: 1}}}},
+		{"last of an item", ds, `{"playerID":"player1","itemID":"item1","count":1}`, http.StatusOK, &InventoryData{PlayerID: "player1", Items: []InventoryItem{}}},
+		{"used up item", ds, `{"playerID":"player1","itemID":"item1","count":1}`, http.StatusConflict, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/inventory-consume-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleConsumeItemRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotInventory := &InventoryData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotInventory)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotInventory, test.wantInventory) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantInventory, gotInventory)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleCreatePromoCodeRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError},
+		{"invalid body", ds, "{", http.StatusBadRequest},
+		{"blank code", ds, `{"code":" ","coins":10}`, http.StatusBadRequest},
+		{"grants nothing", ds, `{"code":"promo1"}`, http.StatusBadRequest},
+		{"negative coins", ds, `{"code":"promo1","coins":-10}`, http.StatusBadRequest},
+		{"invalid item", ds, `{"code":"promo1","items":[{"itemID":"item1"}]}`, http.StatusBadRequest},
+		{"valid code", ds, `{"code":"promo1","coins":10,"uses":5}`, http.StatusOK},
+		{"existing code, different case", ds, `{"code":"PROMO1","energy":10}`, http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/promo-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleCreatePromoCodeRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	want := PromoCode{Code: "PROMO1", Coins: 10, Items: []InventoryItem{}}
+	if !reflect.DeepEqual(ds.promoCodesDB[dbKey{ID: "PROMO1"}], want) {
+		t.Errorf("promo codes DB has incorrect entries, want: %v, got: %v", want, ds.promoCodesDB[dbKey{ID: "PROMO1"}])
+	}
+}
+
+func TestServer_HandleRedeemPromoCodeRequest(t *testing.T) {
+
+	ds := NewServer()
+	ds.promoCodesDB[dbKey{ID: "PROMO1"}] = PromoCode{Code: "PROMO1", Coins: 10, MaxUses: 2}
+	ds.promoCodesDB[dbKey{ID: "PROMO2"}] = PromoCode{Code: "PROMO2", Energy: 10, ExpiryTime: 100}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError},
+		{"invalid body", ds, "{", http.StatusBadRequest},
+		{"blank player id", ds, `{"code":"promo1","time":10}`, http.StatusBadRequest},
+		{"missing code", ds, `{"code":"promo3","playerID":"player1","time":10}`, http.StatusNotFound},
+		{"valid redemption", ds, `{"code":"promo1","playerID":"player1","time":10}`, http.StatusOK},
+		{"repeat redemption", ds, `{"code":"Promo1","playerID":"player1","time":20}`, http.StatusConflict},
+		{"last use", ds, `{"code":"promo1","playerID":"player2","time":20}`, http.StatusOK},
+		{"used up", ds, `{"code":"promo1","playerID":"player3","time":30}`, http.StatusGone},
+		{"before expiry", ds, `{"code":"promo2","playerID":"player1","time":99}`, http.StatusOK},
+		{"after expiry", ds, `{"code":"promo2","playerID":"player2","time":100}`, http.StatusGone},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/promo-redeem-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleRedeemPromoCodeRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	want := []PromoRedemption{{Code: "PROMO1", PlayerID: "player1", Time: 10}, {Code: "PROMO2", PlayerID: "player1", Time: 99}}
+	if !reflect.DeepEqual(ds.redemptionsDB[dbKey{ID: "player1"}], want) {
+		t.Errorf("redemptions DB has incorrect entries, want: %v, got: %v", want, ds.redemptionsDB[dbKey{ID: "player1"}])
+	}
+}
+
+func TestServer_HandleListPlayersRequest(t *testing.T) {
+
+	ds := NewServer()
+	for _, playerID := range []string{"player3", "player1", "player2"} {
+		ds.playersDB.put(dbKey{ID: playerID}, PlayerData{PlayerID: playerID, Level: 1, Energy: 20, LastUpdateTime: 1})
+	}
+	ds.playersDB.put(dbKey{Namespace: "staging", ID: "player0"}, PlayerData{PlayerID: "player0", Level: 1, Energy: 20, LastUpdateTime: 1})
+
+	cursorOf := func(playerID string) string {
+		cursor, _ := pagination.EncodeCursor(playerID)
+		return cursor
+	}
+
+	tests := []struct {
+		name           string
+		server         *Server
+		query          string
+		wantStatus     int
+		wantPlayerIDs  []string
+		wantNextCursor string
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, nil, ""},
+		{"invalid limit", ds, "?limit=ten", http.StatusBadRequest, nil, ""},
+		{"invalid cursor", ds, "?cursor=%25%25", http.StatusBadRequest, nil, ""},
+		{"all players", ds, "", http.StatusOK, []string{"player1", "player2", "player3"}, ""},
+		{"invalid order", ds, "?order=random", http.StatusBadRequest, nil, ""},
+		{"first page", ds, "?limit=2", http.StatusOK, []string{"player1", "player2"}, cursorOf("player2")},
+		{"last page", ds, "?limit=2&cursor=" + cursorOf("player2"), http.StatusOK, []string{"player3"}, ""},
+		{"past the end", ds, "?cursor=" + cursorOf("player3"), http.StatusOK, []string{}, ""},
+		{"descending", ds, "?limit=2&order=desc", http.StatusOK, []string{"player3", "player2"}, cursorOf("player2")},
+		{"descending last page", ds, "?limit=2&order=desc&cursor=" + cursorOf("player2"), http.StatusOK, []string{"player1"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/data/players-internal"+test.query, nil)
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleListPlayersRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				page := &PlayersPage{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(page)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				gotPlayerIDs := []string{}
+				for _, player := range page.Players {
+					gotPlayerIDs = append(gotPlayerIDs, player.PlayerID)
+				}
+
+				if !reflect.DeepEqual(gotPlayerIDs, test.wantPlayerIDs) || page.NextCursor != test.wantNextCursor {
+					t.Errorf("handler gave incorrect results, want: %v (next: %v), got: %v (next: %v)", test.wantPlayerIDs, test.wantNextCursor, gotPlayerIDs, page.NextCursor)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPClient_ListStats(t *testing.T) {
+
+	ds := NewServer()
+	wantStats := []PlayerStatsWithID{}
+	for i := 1; i <= 5; i++ {
+		plStats := PlayerStatsWithID{PlayerID: fmt.Sprintf("player%v", i), PlayerStats: PlayerStats{Rating: int32(1000 + i), Version: PlayerStatsVersion}}
+		ds.statsDB.put(dbKey{ID: plStats.PlayerID}, plStats.PlayerStats)
+		wantStats = append(wantStats, plStats)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/all-stats-internal", ds.HandleListStatsRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	// iterate all the pages
+	gotStats := []PlayerStatsWithID{}
+	pages := 0
+	for cursor := ""; pages == 0 || cursor != ""; pages++ {
+		page, err := hc.ListStats(context.Background(), pagination.Request{Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatalf("ListStats() failed with an unexpected error, %v", err)
+		}
+		gotStats = append(gotStats, page.Stats...)
+		cursor = page.NextCursor
+	}
+
+	if pages != 3 {
+		t.Errorf("ListStats() gave incorrect number of pages, want: %v, got: %v", 3, pages)
+	}
+
+	if !reflect.DeepEqual(gotStats, wantStats) {
+		t.Errorf("ListStats() gave incorrect results, want: %v, got: %v", wantStats, gotStats)
+	}
+}
+
+func TestHTTPClient_RecordLevelEntry(t *testing.T) {
+
+	ds := NewServer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /data/level-entry-internal", ds.HandleRecordLevelEntryRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	day := secondsPerDay
+	tests := []struct {
+		name             string
+		entry            *LevelEntry
+		wantEntriesToday int32
+		wantErr          error
+	}{
+		{"no limits", &LevelEntry{PlayerID: "player1", Level: 2, Time: day}, 1, nil},
+		{"first entry", &LevelEntry{PlayerID: "player1", Level: 1, Time: day, CooldownSeconds: 60, MaxAttemptsPerDay: 2}, 1, nil},
+		{"within the cooldown", &LevelEntry{PlayerID: "player1", Level: 1, Time: day + 59, CooldownSeconds: 60, MaxAttemptsPerDay: 2}, 0, EntryLimitErr{PlayerID: "player1", Level: 1, Limit: EntryLimitCooldown, ResetTime: day + 60}},
+		{"after the cooldown", &LevelEntry{PlayerID: "player1", Level: 1, Time: day + 60, CooldownSeconds: 60, MaxAttemptsPerDay: 2}, 2, nil},
+		{"over the daily limit", &LevelEntry{PlayerID: "player1", Level: 1, Time: day + 200, CooldownSeconds: 60, MaxAttemptsPerDay: 2}, 0, EntryLimitErr{PlayerID: "player1", Level: 1, Limit: EntryLimitDaily, ResetTime: 2 * day}},
+		{"another player", &LevelEntry{PlayerID: "player2", Level: 1, Time: day + 200, CooldownSeconds: 60, MaxAttemptsPerDay: 2}, 1, nil},
+		{"next day", &LevelEntry{PlayerID: "player1", Level: 1, Time: 2 * day, CooldownSeconds: 60, MaxAttemptsPerDay: 2}, 1, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			entries, err := hc.RecordLevelEntry(context.Background(), test.entry)
+			if err != test.wantErr {
+				t.Fatalf("RecordLevelEntry() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+
+			if err == nil && entries.EntriesToday != test.wantEntriesToday {
+				t.Errorf("RecordLevelEntry() gave incorrect results, want entries today: %v, got: %v", test.wantEntriesToday, entries.EntriesToday)
+			}
+		})
+	}
+}
+
+func TestHTTPClient_RecordAction(t *testing.T) {
+
+	ds := NewServer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /data/action-internal", ds.HandleRecordActionRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	tests := []struct {
+		name      string
+		throttle  *ActionThrottle
+		wantTimes []int64
+		wantErr   error
+	}{
+		{"first action", &ActionThrottle{PlayerID: "player1", Action: "level-result", Time: 100, MaxActions: 2, WindowSeconds: 60}, []int64{100}, nil},
+		{"second action", &ActionThrottle{PlayerID: "player1", Action: "level-result", Time: 130, MaxActions: 2, WindowSeconds: 60}, []int64{100, 130}, nil},
+		{"over the limit", &ActionThrottle{PlayerID: "player1", Action: "level-result", Time: 150, MaxActions: 2, WindowSeconds: 60}, nil, ActionThrottledErr{PlayerID: "player1", Action: "level-result", RetryTime: 160}},
+		{"another action", &ActionThrottle{PlayerID: "player1", Action: "level-entry", Time: 150, MaxActions: 1, WindowSeconds: 2}, []int64{150}, nil},
+		{"another player", &ActionThrottle{PlayerID: "player2", Action: "level-result", Time: 150, MaxActions: 2, WindowSeconds: 60}, []int64{150}, nil},
+		{"oldest action left the window", &ActionThrottle{PlayerID: "player1", Action: "level-result", Time: 160, MaxActions: 2, WindowSeconds: 60}, []int64{130, 160}, nil},
+		{"invalid throttle", &ActionThrottle{PlayerID: "player1", Action: "level-result", Time: 300}, nil, fmt.Errorf("internal record action request was not successful, status code 400")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			actions, err := hc.RecordAction(context.Background(), test.throttle)
+			if fmt.Sprint(err) != fmt.Sprint(test.wantErr) {
+				t.Fatalf("RecordAction() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+
+			if err == nil && !reflect.DeepEqual(actions.Times, test.wantTimes) {
+				t.Errorf("RecordAction() gave incorrect results, want times: %v, got: %v", test.wantTimes, actions.Times)
+			}
+		})
+	}
+}
+
+func TestHTTPClient_Referrals(t *testing.T) {
+
+	ds := NewServer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /data/referral-code-internal/{id}", ds.HandleInitReferralCodeRequest)
+	mux.HandleFunc("POST /data/referral-claim-internal", ds.HandleClaimReferralRequest)
+	mux.HandleFunc("POST /data/referral-complete-internal", ds.HandleCompleteReferralRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	referrer, err := hc.InitReferralCode(context.Background(), "referrer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrer.Code) != referralCodeLength {
+		t.Fatalf("InitReferralCode() gave incorrect results, got code: %q", referrer.Code)
+	}
+
+	// the code of a player never changes
+	again, err := hc.InitReferralCode(context.Background(), "referrer")
+	if err != nil || again.Code != referrer.Code {
+		t.Errorf("InitReferralCode() gave incorrect results, want code: %v, got: %v (error: %v)", referrer.Code, again.Code, err)
+	}
+
+	code := strings.ToLower(referrer.Code) // codes are not case sensitive
+	day := secondsPerDay
+	claimTests := []struct {
+		name    string
+		claim   *ReferralClaim
+		wantErr error
+	}{
+		{"unknown code", &ReferralClaim{PlayerID: "player1", Code: "NOPE", IP: "ip1", Time: day}, ReferralCodeNotFoundErr{Code: "NOPE"}},
+		{"own code", &ReferralClaim{PlayerID: "referrer", Code: code, IP: "ip1", Time: day}, SelfReferralErr{PlayerID: "referrer"}},
+		{"claim", &ReferralClaim{PlayerID: "player1", Code: code, IP: "ip1", Time: day, MaxReferrals: 3, MaxClaimsPerIP: 2}, nil},
+		{"claim again", &ReferralClaim{PlayerID: "player1", Code: code, IP: "ip2", Time: day, MaxReferrals: 3, MaxClaimsPerIP: 2}, ReferralAlreadyClaimedErr{PlayerID: "player1"}},
+		{"second claim from the ip", &ReferralClaim{PlayerID: "player2", Code: code, IP: "ip1", Time: day + 10, MaxReferrals: 3, MaxClaimsPerIP: 2}, nil},
+		{"over the ip limit", &ReferralClaim{PlayerID: "player3", Code: code, IP: "ip1", Time: day + 20, MaxReferrals: 3, MaxClaimsPerIP: 2}, ReferralLimitErr{PlayerID: "player3", Limit: ReferralLimitIP}},
+		{"same ip next day", &ReferralClaim{PlayerID: "player3", Code: code, IP: "ip1", Time: 2 * day, MaxReferrals: 3, MaxClaimsPerIP: 2}, nil},
+		{"over the referrer limit", &ReferralClaim{PlayerID: "player4", Code: code, IP: "ip3", Time: 2 * day, MaxReferrals: 3, MaxClaimsPerIP: 2}, ReferralLimitErr{PlayerID: "player4", Limit: ReferralLimitReferrer}},
+	}
+
+	for _, test := range claimTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			referral, err := hc.ClaimReferral(context.Background(), test.claim)
+			if err != test.wantErr {
+				t.Fatalf("ClaimReferral() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+			if err == nil && referral.ReferrerID != "referrer" {
+				t.Errorf("ClaimReferral() gave incorrect results, want referrer: referrer, got: %v", referral.ReferrerID)
+			}
+		})
+	}
+
+	completeTests := []struct {
+		name     string
+		playerID string
+		wantErr  error
+	}{
+		{"not referred", "player4", ReferralNotFoundErr{PlayerID: "player4"}},
+		{"complete", "player1", nil},
+		{"complete again", "player1", ReferralNotFoundErr{PlayerID: "player1"}},
+	}
+
+	for _, test := range completeTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			referral, err := hc.CompleteReferral(context.Background(), &ReferralCompletion{PlayerID: test.playerID, Time: 3 * day})
+			if err != test.wantErr {
+				t.Fatalf("CompleteReferral() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+			if err == nil && (referral.ReferrerID != "referrer" || referral.RewardTime != 3*day) {
+				t.Errorf("CompleteReferral() gave incorrect results, got: %+v", referral)
+			}
+		})
+	}
+
+	referrer, err = hc.InitReferralCode(context.Background(), "referrer")
+	if err != nil || referrer.Referred != 3 || referrer.Rewarded != 1 {
+		t.Errorf("incorrect referrer counts, want referred: 3, rewarded: 1, got: %+v (error: %v)", referrer, err)
+	}
+}
+
+func TestHTTPClient_Milestones(t *testing.T) {
+
+	ds := NewServer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/milestones-internal/{id}", ds.HandleReadMilestonesRequest)
+	mux.HandleFunc("POST /data/milestones-reach-internal", ds.HandleReachMilestonesRequest)
+	mux.HandleFunc("POST /data/milestones-claim-internal", ds.HandleClaimMilestoneRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	milestones, err := hc.ReadMilestones(context.Background(), "player1")
+	if err != nil || len(milestones.Milestones) != 0 {
+		t.Fatalf("ReadMilestones() gave incorrect results, want no milestones, got: %+v (error: %v)", milestones, err)
+	}
+
+	_, err = hc.ReachMilestones(context.Background(), &MilestoneReach{PlayerID: "player1", MilestoneIDs: []string{"win-100"}, Time: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a milestone reached again keeps its first reach time
+	milestones, err = hc.ReachMilestones(context.Background(), &MilestoneReach{PlayerID: "player1", MilestoneIDs: []string{"win-100", "reach-level-5"}, Time: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MilestoneState{{MilestoneID: "win-100", ReachTime: 100}, {MilestoneID: "reach-level-5", ReachTime: 200}}
+	if !reflect.DeepEqual(milestones.Milestones, want) {
+		t.Errorf("ReachMilestones() gave incorrect results, want: %v, got: %v", want, milestones.Milestones)
+	}
+
+	claimTests := []struct {
+		name        string
+		playerID    string
+		milestoneID string
+		wantErr     error
+	}{
+		{"not reached", "player1", "win-streak-10", MilestoneNotReachedErr{PlayerID: "player1", MilestoneID: "win-streak-10"}},
+		{"other player", "player2", "win-100", MilestoneNotReachedErr{PlayerID: "player2", MilestoneID: "win-100"}},
+		{"claim", "player1", "win-100", nil},
+		{"claim again", "player1", "win-100", MilestoneAlreadyClaimedErr{PlayerID: "player1", MilestoneID: "win-100"}},
+	}
+
+	for _, test := range claimTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			milestones, err := hc.ClaimMilestone(context.Background(), &MilestoneClaim{PlayerID: test.playerID, MilestoneID: test.milestoneID, Time: 300})
+			if err != test.wantErr {
+				t.Fatalf("ClaimMilestone() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+			if err == nil {
+				if state, ok := milestones.State(test.milestoneID); !ok || state.ClaimTime != 300 {
+					t.Errorf("ClaimMilestone() gave incorrect results, got: %+v", milestones)
+				}
+			}
+		})
+	}
+
+	milestones, err = hc.ReadMilestones(context.Background(), "player1")
+	want = []MilestoneState{{MilestoneID: "win-100", ReachTime: 100, ClaimTime: 300}, {MilestoneID: "reach-level-5", ReachTime: 200}}
+	if err != nil || !reflect.DeepEqual(milestones.Milestones, want) {
+		t.Errorf("ReadMilestones() gave incorrect results, want: %v, got: %+v (error: %v)", want, milestones, err)
+	}
+}
+
+func TestHTTPClient_Annotations(t *testing.T) {
+
+	ds := NewServer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/annotations-internal/{id}", ds.HandleReadAnnotationsRequest)
+	mux.HandleFunc("POST /data/annotations-internal", ds.HandleAddAnnotationRequest)
+	mux.HandleFunc("DELETE /data/annotations-internal/{id}/{annotationID}", ds.HandleRemoveAnnotationRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	annotations, err := hc.ReadAnnotations(context.Background(), "player1")
+	if err != nil || annotations.Annotations == nil || len(annotations.Annotations) != 0 {
+		t.Fatalf("ReadAnnotations() gave incorrect results, want no annotations, got: %+v (error: %v)", annotations, err)
+	}
+
+	addTests := []struct {
+		name       string
+		annotation PlayerAnnotation
+		wantErr    bool
+	}{
+		{"unknown kind", PlayerAnnotation{Kind: "rumour", Text: "might be a bot"}, true},
+		{"blank text", PlayerAnnotation{Kind: AnnotationKindNote, Text: "   "}, true},
+		{"text too long", PlayerAnnotation{Kind: AnnotationKindNote, Text: strings.Repeat("a", constants.MaxAnnotationLength+1)}, true},
+		{"support ticket", PlayerAnnotation{Kind: AnnotationKindSupportTicket, Text: "lost energy after a crash", Reference: "TICKET-42", Author: "sam", CreatedAt: 100}, false},
+		{"suspicion", PlayerAnnotation{Kind: AnnotationKindSuspicion, Text: "wins every level in one roll", Author: "sam", CreatedAt: 200}, false},
+	}
+
+	added := []PlayerAnnotation{}
+	for _, test := range addTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			annotation, err := hc.AddAnnotation(context.Background(), &AnnotationAddition{PlayerID: "player1", Annotation: test.annotation})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("AddAnnotation() gave incorrect error, want error: %v, got: %v", test.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if !strings.HasPrefix(annotation.AnnotationID, "annotation-") {
+				t.Errorf("AddAnnotation() gave an incorrect annotation id: %v", annotation.AnnotationID)
+			}
+			want := test.annotation
+			want.AnnotationID = annotation.AnnotationID
+			if *annotation != want {
+				t.Errorf("AddAnnotation() gave incorrect results, want: %+v, got: %+v", want, *annotation)
+			}
+			added = append(added, *annotation)
+		})
+	}
+
+	annotations, err = hc.ReadAnnotations(context.Background(), "player1")
+	if err != nil || !reflect.DeepEqual(annotations.Annotations, added) {
+		t.Fatalf("ReadAnnotations() gave incorrect results, want: %+v, got: %+v (error: %v)", added, annotations, err)
+	}
+
+	removeTests := []struct {
+		name         string
+		playerID     string
+		annotationID string
+		wantErr      error
+	}{
+		{"other player", "player2", added[1].AnnotationID, AnnotationNotFoundErr{PlayerID: "player2", AnnotationID: added[1].AnnotationID}},
+		{"unknown annotation", "player1", "annotation-0", AnnotationNotFoundErr{PlayerID: "player1", AnnotationID: "annotation-0"}},
+		{"remove", "player1", added[1].AnnotationID, nil},
+		{"remove again", "player1", added[1].AnnotationID, AnnotationNotFoundErr{PlayerID: "player1", AnnotationID: added[1].AnnotationID}},
+	}
+
+	for _, test := range removeTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			annotation, err := hc.RemoveAnnotation(context.Background(), test.playerID, test.annotationID)
+			if err != test.wantErr {
+				t.Fatalf("RemoveAnnotation() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+			if err == nil && *annotation != added[1] {
+				t.Errorf("RemoveAnnotation() gave incorrect results, want: %+v, got: %+v", added[1], *annotation)
+			}
+		})
+	}
+
+	annotations, err = hc.ReadAnnotations(context.Background(), "player1")
+	if err != nil || !reflect.DeepEqual(annotations.Annotations, added[:1]) {
+		t.Errorf("ReadAnnotations() gave incorrect results, want: %+v, got: %+v (error: %v)", added[:1], annotations, err)
+	}
+}
+
+func TestHTTPClient_Guilds(t *testing.T) {
+
+	ds := NewServer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /data/guild-internal", ds.HandleCreateGuildRequest)
+	mux.HandleFunc("GET /data/guild-internal/{id}", ds.HandleReadGuildRequest)
+	mux.HandleFunc("GET /data/guilds-internal", ds.HandleListGuildsRequest)
+	mux.HandleFunc("POST /data/guild-join-internal", ds.HandleJoinGuildRequest)
+	mux.HandleFunc("POST /data/guild-leave-internal/{id}", ds.HandleLeaveGuildRequest)
+	mux.HandleFunc("GET /data/player-guild-internal/{id}", ds.HandleReadPlayerGuildRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	guild, err := hc.CreateGuild(context.Background(), &GuildCreation{Name: " Dice Rollers ", OwnerID: "player1", Time: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guild.Name != "Dice Rollers" || guild.OwnerID != "player1" || len(guild.Members) != 1 {
+		t.Fatalf("CreateGuild() gave incorrect results, got: %+v", guild)
+	}
+
+	createTests := []struct {
+		name     string
+		creation *GuildCreation
+		wantErr  error
+	}{
+		{"name taken", &GuildCreation{Name: "dice rollers", OwnerID: "player2", Time: 110}, GuildNameTakenErr{Name: "dice rollers"}},
+		{"owner in a guild", &GuildCreation{Name: "Other Guild", OwnerID: "player1", Time: 110}, AlreadyInGuildErr{PlayerID: "player1"}},
+	}
+
+	for _, test := range createTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := hc.CreateGuild(context.Background(), test.creation)
+			if err != test.wantErr {
+				t.Errorf("CreateGuild() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+		})
+	}
+
+	joinTests := []struct {
+		name        string
+		join        *GuildJoin
+		wantErr     error
+		wantMembers int
+	}{
+		{"unknown guild", &GuildJoin{GuildID: "guild-0", PlayerID: "player2", Time: 120, MaxMembers: 2}, GuildNotFoundErr{GuildID: "guild-0"}, 0},
+		{"join", &GuildJoin{GuildID: guild.GuildID, PlayerID: "player2", Time: 120, MaxMembers: 2}, nil, 2},
+		{"join again", &GuildJoin{GuildID: guild.GuildID, PlayerID: "player2", Time: 130, MaxMembers: 3}, AlreadyInGuildErr{PlayerID: "player2"}, 0},
+		{"guild full", &GuildJoin{GuildID: guild.GuildID, PlayerID: "player3", Time: 130, MaxMembers: 2}, GuildFullErr{GuildID: guild.GuildID}, 0},
+		{"no size limit", &GuildJoin{GuildID: guild.GuildID, PlayerID: "player3", Time: 130}, nil, 3},
+	}
+
+	for _, test := range joinTests {
+		t.Run(test.name, func(t *testing.T) {
+			joined, err := hc.JoinGuild(context.Background(), test.join)
+			if err != test.wantErr {
+				t.Fatalf("JoinGuild() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+			if err == nil && len(joined.Members) != test.wantMembers {
+				t.Errorf("JoinGuild() gave incorrect results, want: %v members, got: %+v", test.wantMembers, joined.Members)
+			}
+		})
+	}
+
+	// the owner leaving passes the guild on to the longest standing member
+	left, err := hc.LeaveGuild(context.Background(), "player1")
+	if err != nil || left.OwnerID != "player2" || len(left.Members) != 2 {
+		t.Errorf("LeaveGuild() gave incorrect results, want owner: player2 and 2 members, got: %+v (error: %v)", left, err)
+	}
+
+	_, err = hc.LeaveGuild(context.Background(), "player1")
+	if err != (NotInGuildErr{PlayerID: "player1"}) {
+		t.Errorf("LeaveGuild() gave incorrect error, want: %v, got: %v", NotInGuildErr{PlayerID: "player1"}, err)
+	}
+
+	playerGuild, err := hc.ReadPlayerGuild(context.Background(), "player3")
+	if err != nil || playerGuild.GuildID != guild.GuildID {
+		t.Errorf("ReadPlayerGuild() gave incorrect results, want guild: %v, got: %+v (error: %v)", guild.GuildID, playerGuild, err)
+	}
+
+	// the last member leaving deletes the guild, which frees its name
+	for _, playerID := range []string{"player2", "player3"} {
+		_, err = hc.LeaveGuild(context.Background(), playerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = hc.ReadGuild(context.Background(), guild.GuildID)
+	if err != (GuildNotFoundErr{GuildID: guild.GuildID}) {
+		t.Errorf("ReadGuild() gave incorrect error, want: %v, got: %v", GuildNotFoundErr{GuildID: guild.GuildID}, err)
+	}
+
+	_, err = hc.CreateGuild(context.Background(), &GuildCreation{Name: "Dice Rollers", OwnerID: "player1", Time: 200})
+	if err != nil {
+		t.Errorf("CreateGuild() gave incorrect error, want: <nil>, got: %v", err)
+	}
+
+	guilds, err := hc.ListGuilds(context.Background())
+	if err != nil || len(guilds) != 1 || guilds[0].OwnerID != "player1" {
+		t.Errorf("ListGuilds() gave incorrect results, want the new guild, got: %+v (error: %v)", guilds, err)
+	}
+}
+
+// countingClient counts the player and stats reads which reach the data server
+type countingClient struct {
+	*Server
+	playerReads int
+	statsReads  int
+}
+
+func (cc *countingClient) ReadPlayer(ctx context.Context, playerID string) (*PlayerData, error) {
+	cc.playerReads++
+	return cc.Server.ReadPlayer(ctx, playerID)
+}
+
+func (cc *countingClient) ReadStats(ctx context.Context, playerID string) (*PlayerStats, error) {
+	cc.statsReads++
+	return cc.Server.ReadStats(ctx, playerID)
+}
+
+func TestShardedStore(t *testing.T) {
+
+	store := newShardedStore[int]()
+
+	// concurrent writes to different keys only wait on their own shards
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.put(dbKey{ID: fmt.Sprintf("player%v", i)}, i)
+		}()
+	}
+	wg.Wait()
+
+	if got := len(store.snapshot()); got != 100 {
+		t.Fatalf("snapshot() gave incorrect results, want: 100 entries, got: %v", got)
+	}
+
+	store.lockAll()
+	store.replace(map[dbKey]int{{ID: "player1"}: 10, {Namespace: "staging", ID: "player1"}: 20})
+	store.unlockAll()
+
+	tests := []struct {
+		name      string
+		key       dbKey
+		wantValue int
+		wantOK    bool
+	}{
+		{"replaced entry", dbKey{ID: "player1"}, 10, true},
+		{"other namespace", dbKey{Namespace: "staging", ID: "player1"}, 20, true},
+		{"removed entry", dbKey{ID: "player2"}, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotValue, gotOK := store.get(test.key)
+			if gotValue != test.wantValue || gotOK != test.wantOK {
+				t.Errorf("get() gave incorrect results, want: %v %v, got: %v %v", test.wantValue, test.wantOK, gotValue, gotOK)
+			}
+		})
+	}
+}
+
+func TestCachedClient(t *testing.T) {
+
+	inner := &countingClient{Server: NewServer()}
+	cc := NewCachedClient(inner, 2, time.Minute)
+
+	defaultCtx := context.Background()
+	stagingCtx := namespace.NewContext(context.Background(), "staging")
+
+	for _, playerID := range []string{"player1", "player2", "player3"} {
+		err := cc.WritePlayer(defaultCtx, &PlayerData{PlayerID: playerID, Level: 1, Energy: 10, LastUpdateTime: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := cc.WritePlayer(stagingCtx, &PlayerData{PlayerID: "player1", Level: 5, Energy: 10, LastUpdateTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cc.WriteStats(defaultCtx, &PlayerStatsWithID{PlayerID: "player1", PlayerStats: PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		ctx             context.Context
+		playerID        string
+		write           *PlayerData
+		wantLevel       int32
+		wantPlayerReads int
+	}{
+		{"first read", defaultCtx, "player1", nil, 1, 1},
+		{"cached read", defaultCtx, "player1", nil, 1, 1},
+		{"other namespace", stagingCtx, "player1", nil, 5, 2},
+		{"read after write", defaultCtx, "player1", &PlayerData{PlayerID: "player1", Level: 2, Energy: 10, LastUpdateTime: 100}, 2, 3},
+		{"cached read after write", defaultCtx, "player1", nil, 2, 3},
+		{"other player", defaultCtx, "player2", nil, 1, 4},
+		{"evicted player", stagingCtx, "player1", nil, 5, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if test.write != nil {
+				writeErr := cc.WritePlayer(test.ctx, test.write)
+				if writeErr != nil {
+					t.Fatal(writeErr)
+				}
+			}
+
+			player, readErr := cc.ReadPlayer(test.ctx, test.playerID)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if player.Level != test.wantLevel {
+				t.Errorf("ReadPlayer() gave incorrect results, want level: %v, got: %v", test.wantLevel, player.Level)
+			}
+			if inner.playerReads != test.wantPlayerReads {
+				t.Errorf("ReadPlayer() gave incorrect reads of the data server, want: %v, got: %v", test.wantPlayerReads, inner.playerReads)
+			}
+		})
+	}
+
+	// a failed swap leaves the cache empty, so the next read reaches the data server
+	player, err := cc.ReadPlayer(defaultCtx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readsBefore := inner.playerReads
+	err = cc.SwapPlayer(defaultCtx, &PlayerSwap{Expected: PlayerData{PlayerID: "player1", Level: 9}, Updated: *player})
+	if !errors.As(err, &PlayerChangedErr{}) {
+		t.Fatalf("SwapPlayer() gave incorrect error, want a player changed error, got: %v", err)
+	}
+	_, err = cc.ReadPlayer(defaultCtx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.playerReads != readsBefore+1 {
+		t.Errorf("SwapPlayer() should invalidate the cached player, want reads: %v, got: %v", readsBefore+1, inner.playerReads)
+	}
+
+	// changes to the returned stats do not change the cached stats
+	plStats, err := cc.ReadStats(defaultCtx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plStats.LevelStats[0].WinCount = 100
+
+	plStats, err = cc.ReadStats(defaultCtx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plStats.LevelStats[0].WinCount != 1 || inner.statsReads != 1 {
+		t.Errorf("ReadStats() gave incorrect results, want 1 win from 1 read, got: %v from %v reads", plStats.LevelStats[0].WinCount, inner.statsReads)
+	}
+
+	// expired entries are read again
+	shortLived := NewCachedClient(inner, 2, time.Millisecond)
+	readsBefore = inner.statsReads
+	for range 2 {
+		time.Sleep(2 * time.Millisecond)
+		_, err = shortLived.ReadStats(defaultCtx, "player1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inner.statsReads != readsBefore+2 {
+		t.Errorf("ReadStats() should not return expired stats, want reads: %v, got: %v", readsBefore+2, inner.statsReads)
+	}
+}
+
+func TestWithCacheFromEnv(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		size       string
+		ttl        string
+		wantErr    bool
+		wantCached bool
+	}{
+		{"not set", "", "", false, false},
+		{"size set", "100", "", false, true},
+		{"size and ttl set", "100", "30", false, true},
+		{"invalid size", "zero", "", true, false},
+		{"negative size", "-1", "", true, false},
+		{"invalid ttl", "100", "0", true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			t.Setenv(constants.DataCacheSizeEnvVar, test.size)
+			t.Setenv(constants.DataCacheTTLSecondsEnvVar, test.ttl)
+
+			dataClient, err := WithCacheFromEnv(NewServer())
+			if (err != nil) != test.wantErr {
+				t.Fatalf("WithCacheFromEnv() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			_, gotCached := dataClient.(*CachedClient)
+			if gotCached != test.wantCached {
+				t.Errorf("WithCacheFromEnv() gave incorrect results, want cached: %v, got: %v", test.wantCached, gotCached)
+			}
+		})
+	}
+}
+
+func TestServer_EventSourcing(t *testing.T) {
+
+	ds := NewServer()
+	ctx := context.Background()
+
+	// changes made before event sourcing is enabled are not recorded, but the players are imported on their next change
+	err := ds.WritePlayer(ctx, &PlayerData{PlayerID: "player1", Level: 1, Energy: 50, LastUpdateTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.WriteStats(ctx, &PlayerStatsWithID{PlayerID: "player1", PlayerStats: PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 4}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.ReadPlayerEvents(ctx, "player1", 0)
+	if !errors.Is(err, eventSourcingDisabledError) {
+		t.Fatalf("ReadPlayerEvents() gave incorrect error, want: %v, got: %v", eventSourcingDisabledError, err)
+	}
+
+	ds.EnableEventSourcing()
+
+	err = ds.SwapPlayer(ctx, &PlayerSwap{
+		Expected: PlayerData{PlayerID: "player1", Level: 1, Energy: 50, LastUpdateTime: 100},
+		Updated:  PlayerData{PlayerID: "player1", Level: 1, Energy: 40, LastUpdateTime: 110},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.WritePlayer(ctx, &PlayerData{PlayerID: "player1", Level: 2, Energy: 45, LastUpdateTime: 160, Boosts: []EnergyBoost{{BoostID: "boost-regen-2x", RegenMultiplier: 2, ExpiryTime: 500}}, BankedEnergy: 5, FTUEStep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.WriteStats(ctx, &PlayerStatsWithID{PlayerID: "player1", PlayerStats: PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 2, BestScore: 4}, {Level: 2, LossCount: 1}}, Rating: 1010}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.WritePlayer(ctx, &PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventTests := []struct {
+		name          string
+		playerID      string
+		afterSequence int64
+		wantTypes     []string
+		wantErr       error
+	}{
+		{"imported player", "player1", 0, []string{EventPlayerImported, EventEnergySpent, EventLevelUnlocked, EventEnergyGained, EventBoostsChanged, EventEnergyBanked, EventFTUEProgressed, EventStatsImported, EventStatsUpdated}, nil},
+		{"after a sequence", "player1", 5, []string{EventEnergyBanked, EventFTUEProgressed, EventStatsImported, EventStatsUpdated}, nil},
+		{"after the last sequence", "player1", 11, []string{}, nil},
+		{"new player", "player2", 0, []string{EventPlayerCreated}, nil},
+		{"unknown player", "player3", 0, nil, PlayerEventsNotFoundErr{PlayerID: "player3"}},
+	}
+
+	for _, test := range eventTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			events, readErr := ds.ReadPlayerEvents(ctx, test.playerID, test.afterSequence)
+			if readErr != test.wantErr {
+				t.Fatalf("ReadPlayerEvents() gave incorrect error, want: %v, got: %v", test.wantErr, readErr)
+			}
+			if readErr != nil {
+				return
+			}
+
+			gotTypes := []string{}
+			for i, event := range events {
+				gotTypes = append(gotTypes, event.Type)
+				if event.Sequence != test.afterSequence+int64(i+1) {
+					t.Errorf("ReadPlayerEvents() gave incorrect sequence, want: %v, got: %v", test.afterSequence+int64(i+1), event.Sequence)
+				}
+			}
+			if !reflect.DeepEqual(gotTypes, test.wantTypes) {
+				t.Errorf("ReadPlayerEvents() gave incorrect events, want: %v, got: %v", test.wantTypes, gotTypes)
+			}
+		})
+	}
+
+	// the latest state matches the players and stats DBs
+	state, err := ds.ReadPlayerState(ctx, "player1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	player, err := ds.ReadPlayer(ctx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plStats, err := ds.ReadStats(ctx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state.Player, player) || !reflect.DeepEqual(state.Stats, plStats) {
+		t.Errorf("ReadPlayerState() gave incorrect results, want: %+v and %+v, got: %+v and %+v", player, plStats, state.Player, state.Stats)
+	}
+
+	// stats lost from the stats DB are recomputed from the events
+	ds.statsDB.put(keyOf(ctx, "player1"), PlayerStats{Version: PlayerStatsVersion})
+
+	recomputed, err := ds.RecomputeStats(ctx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plStats2, err := ds.ReadStats(ctx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recomputed, plStats) || !reflect.DeepEqual(plStats2, plStats) {
+		t.Errorf("RecomputeStats() gave incorrect results, want: %+v, got: %+v (stored: %+v)", plStats, recomputed, plStats2)
+	}
+
+	_, err = ds.RecomputeStats(ctx, "player2")
+	if !errors.Is(err, PlayerStatsNotFoundErr{PlayerID: "player2"}) {
+		t.Errorf("expected a stats not found error, got: %v", err)
+	}
+
+	// the stream of a player merged into another one ends with the merge
+	_, err = ds.MergePlayers(ctx, &PlayerMerge{Expected: *player, ExpectedSecondary: PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: 200}, Merged: *player})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := ds.ReadPlayerEvents(ctx, "player2", 1)
+	if err != nil || len(events) != 1 || events[0].Type != EventPlayerMerged || events[0].MergedInto != "player1" {
+		t.Errorf("ReadPlayerEvents() gave incorrect results, want a %v event, got: %+v (error: %v)", EventPlayerMerged, events, err)
+	}
+
+	state, err = ds.ReadPlayerState(ctx, "player2", 0)
+	if err != nil || state.Player != nil || state.Stats != nil || state.MergedInto != "player1" {
+		t.Errorf("ReadPlayerState() gave incorrect results, want the player merged into player1, got: %+v (error: %v)", state, err)
+	}
+}
+
+func TestServer_ReadPlayerState(t *testing.T) {
+
+	ds := NewServer()
+	ds.EnableEventSourcing()
+
+	// a stream with events at different times, and a snapshot after the second event
+	created := PlayerData{PlayerID: "player1", Level: 1, Energy: 50, LastUpdateTime: 100, Version: PlayerDataVersion}
+	spent := created
+	spent.Energy, spent.LastUpdateTime = 40, 200
+
+	err := ds.RestoreSnapshot(context.Background(), &Snapshot{Version: snapshotVersion, Namespaces: []NamespaceSnapshot{{
+		PlayerEvents: []PlayerEventStream{{
+			PlayerID: "player1",
+			Events: []PlayerEvent{
+				{Sequence: 1, Type: EventPlayerCreated, Time: 100, Player: &created},
+				{Sequence: 2, Type: EventEnergySpent, Time: 200, Energy: 40, EnergyDelta: -10, LastUpdateTime: 200},
+				{Sequence: 3, Type: EventLevelUnlocked, Time: 300, Level: 2},
+				{Sequence: 4, Type: EventStatsUpdated, Time: 300, LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1}}, Rating: 1000},
+			},
+			Snapshots: []PlayerState{{PlayerID: "player1", Sequence: 2, Time: 200, Player: &spent}},
+		}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		unixTime     int64
+		wantSequence int64
+		wantLevel    int32
+		wantEnergy   int32
+		wantStats    bool
+	}{
+		{"before the player", 50, 0, 0, 0, false},
+		{"after creation", 150, 1, 1, 50, false},
+		{"at the snapshot", 200, 2, 1, 40, false},
+		{"after the snapshot", 300, 4, 2, 40, true},
+		{"latest", 0, 4, 2, 40, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			state, readErr := ds.ReadPlayerState(context.Background(), "player1", test.unixTime)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+
+			gotLevel, gotEnergy := int32(0), int32(0)
+			if state.Player != nil {
+				gotLevel, gotEnergy = state.Player.Level, state.Player.Energy
+			}
+
+			if state.Sequence != test.wantSequence || gotLevel != test.wantLevel || gotEnergy != test.wantEnergy || (state.Stats != nil) != test.wantStats {
+				t.Errorf("ReadPlayerState() gave incorrect results, want sequence: %v, level: %v, energy: %v, stats: %v, got: %+v",
+					test.wantSequence, test.wantLevel, test.wantEnergy, test.wantStats, state)
+			}
+		})
+	}
+}
+
+func TestServer_HandleReadPlayerEventsRequest(t *testing.T) {
+
+	disabled := NewServer()
+	enabled := NewServer()
+	enabled.EnableEventSourcing()
+
+	err := enabled.WritePlayer(context.Background(), &PlayerData{PlayerID: "player1", Level: 1, Energy: 50, LastUpdateTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		playerID   string
+		query      string
+		wantStatus int
+	}{
+		{"nil server", nil, "player1", "", http.StatusInternalServerError},
+		{"disabled", disabled, "player1", "", http.StatusServiceUnavailable},
+		{"unknown player", enabled, "player2", "", http.StatusNotFound},
+		{"invalid after", enabled, "player1", "?after=-1", http.StatusBadRequest},
+		{"valid request", enabled, "player1", "?after=0", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/data/player-events-internal/"+test.playerID+test.query, nil)
+			newReq.SetPathValue("id", test.playerID)
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleReadPlayerEventsRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
+
+func TestServer_HandleReadStatsDeltaRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	writeStats := func(playerID string, rating int32, levelStats ...PlayerLevelStats) {
+		err := ds.WriteStats(context.Background(), &PlayerStatsWithID{PlayerID: playerID, PlayerStats: PlayerStats{LevelStats: levelStats, Rating: rating}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	level1 := PlayerLevelStats{Level: 1, WinCount: 1, BestScore: 3}
+	level2 := PlayerLevelStats{Level: 2, LossCount: 1, BestScore: 99}
+	writeStats("player1", 1000, level1, level2)
+
+	// back date the changes, then only update level 2
+	key := dbKey{ID: "player1"}
+	ds.statsChangesDB.put(key, statsChangeTimes{levels: map[int32]int64{1: 100, 2: 100}, rating: 150})
+
+	level2.WinCount, level2.BestScore = 1, 4
+	writeStats("player1", 1000, level1, level2)
+
+	changes, _ := ds.statsChangesDB.get(key)
+	changes.levels[2] = 200
+
+	// stats without change times (like restored ones) count as changed
+	ds.statsDB.put(dbKey{ID: "player2"}, PlayerStats{LevelStats: []PlayerLevelStats{level1}, Rating: 900})
+
+	tests := []struct {
+		name       string
+		server     *Server
+		playerID   string
+		query      string
+		wantStatus int
+		wantDelta  *StatsDelta
+	}{
+		{"nil server", nil, "player1", "", http.StatusInternalServerError, nil},
+		{"unknown player", ds, "player3", "", http.StatusNotFound, nil},
+		{"invalid since", ds, "player1", "?since=-1", http.StatusBadRequest, nil},
+		{"everything", ds, "player1", "", http.StatusOK, &StatsDelta{LevelStats: []PlayerLevelStats{level1, level2}, Rating: 1000}},
+		{"changed level and rating", ds, "player1", "?since=150", http.StatusOK, &StatsDelta{LevelStats: []PlayerLevelStats{level2}, Rating: 1000}},
+		{"changed level", ds, "player1", "?since=151", http.StatusOK, &StatsDelta{LevelStats: []PlayerLevelStats{level2}}},
+		{"nothing changed", ds, "player1", "?since=201", http.StatusOK, &StatsDelta{LevelStats: []PlayerLevelStats{}}},
+		{"unknown change times", ds, "player2", "?since=201", http.StatusOK, &StatsDelta{LevelStats: []PlayerLevelStats{level1}, Rating: 900}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/data/stats-delta-internal/"+test.playerID+test.query, nil)
+			newReq.SetPathValue("id", test.playerID)
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleReadStatsDeltaRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotDelta := &StatsDelta{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotDelta)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotDelta, test.wantDelta) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantDelta, gotDelta)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_BackupAndRestore(t *testing.T) {
+
+	for _, format := range []string{BackupFormatJSON, BackupFormatGob} {
+		t.Run(format, func(t *testing.T) {
+
+			store, err := NewFileBackupStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ds := NewServer()
+			ds.backupStore = store
+			ds.EnableEventSourcing()
+
+			defaultCtx := context.Background()
+			stagingCtx := namespace.NewContext(context.Background(), "staging")
+
+			err = ds.WritePlayer(defaultCtx, &PlayerData{PlayerID: "player1", Level: 2, Energy: 10, LastUpdateTime: 100, Boosts: []EnergyBoost{{BoostID: "boost-regen-2x", RegenMultiplier: 2, ExpiryTime: 500}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ds.WritePlayer(stagingCtx, &PlayerData{PlayerID: "player1", Level: 7, Energy: 40, LastUpdateTime: 200})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ds.WriteStats(stagingCtx, &PlayerStatsWithID{PlayerID: "player1", PlayerStats: PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 3}}, Rating: 1200}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ds.WriteAttempt(defaultCtx, &AttemptRecord{PlayerID: "player1", Level: 1, Won: true, Score: 3, Time: 150})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ds.AppendAuditEntry(stagingCtx, &AuditEntry{Actor: "admin", Action: "ban", PlayerID: "player1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			referral, err := ds.InitReferralCode(defaultCtx, "player1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ds.ClaimReferral(defaultCtx, &ReferralClaim{PlayerID: "player3", Code: referral.Code, IP: "10.0.0.1", Time: 150})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ds.CreateGuild(defaultCtx, &GuildCreation{Name: "Dice Rollers", OwnerID: "player1", Time: 160})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ds.AddAnnotation(stagingCtx, &AnnotationAddition{PlayerID: "player1", Annotation: PlayerAnnotation{Kind: AnnotationKindSupportTicket, Text: "lost energy after a crash", Reference: "TICKET-42", Author: "admin", CreatedAt: 170}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := ds.TakeSnapshot(defaultCtx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := ds.Backup(defaultCtx, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Format != format || info.Size == 0 {
+				t.Errorf("Backup() gave incorrect results, got: %v", info)
+			}
+
+			// changes made after the backup are undone by the restore
+			err = ds.WritePlayer(defaultCtx, &PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: 300})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ds.AppendAuditEntry(stagingCtx, &AuditEntry{Actor: "admin", Action: "unban", PlayerID: "player1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ds.Restore(defaultCtx, info.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ds.TakeSnapshot(defaultCtx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.CreatedAt = want.CreatedAt
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("restore gave incorrect results, want: %v, got: %v", want, got)
+			}
+
+			_, err = ds.ReadPlayer(defaultCtx, "player2")
+			if !errors.Is(err, PlayerNotFoundErr{PlayerID: "player2"}) {
+				t.Errorf("expected a player not found error, got: %v", err)
+			}
+
+			// the state of the event streams comes back from replaying their events
+			state, err := ds.ReadPlayerState(stagingCtx, "player1", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Sequence != 2 || state.Player == nil || state.Player.Level != 7 || state.Stats == nil || state.Stats.Rating != 1200 {
+				t.Errorf("expected the restored player state, got: %+v", state)
+			}
+			_, err = ds.ReadPlayerEvents(defaultCtx, "player2", 0)
+			if !errors.Is(err, PlayerEventsNotFoundErr{PlayerID: "player2"}) {
+				t.Errorf("expected a player events not found error, got: %v", err)
+			}
+
+			// the owners of the referral codes come back with the referral data
+			_, err = ds.ClaimReferral(defaultCtx, &ReferralClaim{PlayerID: "player4", Code: referral.Code, IP: "10.0.0.2", Time: 150})
+			if err != nil {
+				t.Errorf("expected the restored referral code to be claimable, got: %v", err)
+			}
+
+			// so do the guild names and memberships
+			_, err = ds.CreateGuild(defaultCtx, &GuildCreation{Name: "dice rollers", OwnerID: "player4", Time: 170})
+			if err != (GuildNameTakenErr{Name: "dice rollers"}) {
+				t.Errorf("expected the restored guild name to be taken, got: %v", err)
+			}
+			_, err = ds.ReadPlayerGuild(defaultCtx, "player1")
+			if err != nil {
+				t.Errorf("expected the restored guild member to be in the guild, got: %v", err)
+			}
+
+			err = ds.pruneBackups(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names, err := ds.ListBackups(defaultCtx)
+			if err != nil || len(names) != 0 {
+				t.Errorf("expected no backups after pruning, got: %v (error: %v)", names, err)
+			}
+		})
+	}
+}
+
+func TestServer_RestoreSnapshot(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		snapshot *Snapshot
+		wantErr  bool
+	}{
+		{"nil snapshot", nil, true},
+		{"unsupported version", &Snapshot{Version: snapshotVersion + 1}, true},
+		{"invalid namespace", &Snapshot{Version: snapshotVersion, Namespaces: []NamespaceSnapshot{{Namespace: "../prod"}}}, true},
+		{"repeated namespace", &Snapshot{Version: snapshotVersion, Namespaces: []NamespaceSnapshot{{Namespace: "prod"}, {Namespace: "prod"}}}, true},
+		{"audit log out of sequence", &Snapshot{Version: snapshotVersion, Namespaces: []NamespaceSnapshot{{AuditLog: []AuditEntry{{ID: 2, Action: "ban"}}}}}, true},
+		{"valid snapshot", &Snapshot{Version: snapshotVersion, Namespaces: []NamespaceSnapshot{{Players: []PlayerData{{PlayerID: "player2", Level: 1, Energy: 50}}}}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			ds := NewServer()
+			ds.playersDB.put(dbKey{ID: "player1"}, PlayerData{PlayerID: "player1", Level: 1, Energy: 20, LastUpdateTime: 1})
+
+			err := ds.RestoreSnapshot(context.Background(), test.snapshot)
+			if (err != nil) != test.wantErr {
+				t.Errorf("RestoreSnapshot() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			// a failed restore leaves the data as it was
+			_, kept := ds.playersDB.get(dbKey{ID: "player1"})
+			if kept != test.wantErr {
+				t.Errorf("RestoreSnapshot() gave incorrect results, want player kept: %v, got: %v", test.wantErr, kept)
+			}
+		})
+	}
+}
+
+func TestServer_LevelIndex(t *testing.T) {
+
+	ds := NewServer()
+	ctx := context.Background()
+	stagingCtx := namespace.NewContext(context.Background(), "staging")
+
+	writeStats := func(ctx context.Context, playerID string, levelStats ...PlayerLevelStats) {
+		err := ds.WriteStats(ctx, &PlayerStatsWithID{PlayerID: playerID, PlayerStats: PlayerStats{LevelStats: levelStats}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkTop := func(ctx context.Context, level int32, limit int, want []LevelRankEntry) {
+		t.Helper()
+		got, err := ds.ReadLevelLeaderboard(ctx, level, limit)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadLevelLeaderboard() gave incorrect results, want: %v, got: %v (error: %v)", want, got, err)
+		}
+	}
+
+	writeStats(ctx, "player1", PlayerLevelStats{Level: 1, WinCount: 1, LossCount: 1, BestScore: 4})
+	writeStats(ctx, "player2", PlayerLevelStats{Level: 1, WinCount: 2, BestScore: 4}, PlayerLevelStats{Level: 2, WinCount: 1, BestScore: 6})
+	writeStats(ctx, "player3", PlayerLevelStats{Level: 1, LossCount: 3, BestScore: 100})
+	writeStats(stagingCtx, "player4", PlayerLevelStats{Level: 1, WinCount: 1, BestScore: 2})
+
+	// same best score, the player with more wins ranks first, players without a win are not ranked
+	checkTop(ctx, 1, 10, []LevelRankEntry{{PlayerID: "player2", BestScore: 4, WinCount: 2}, {PlayerID: "player1", BestScore: 4, WinCount: 1}})
+	checkTop(ctx, 1, 1, []LevelRankEntry{{PlayerID: "player2", BestScore: 4, WinCount: 2}})
+	checkTop(ctx, 3, 10, []LevelRankEntry{})
+	checkTop(stagingCtx, 1, 10, []LevelRankEntry{{PlayerID: "player4", BestScore: 2, WinCount: 1}})
+
+	// a better score moves the player up
+	writeStats(ctx, "player1", PlayerLevelStats{Level: 1, WinCount: 2, LossCount: 1, BestScore: 3})
+	writeStats(ctx, "player3", PlayerLevelStats{Level: 1, WinCount: 1, LossCount: 3, BestScore: 5})
+	checkTop(ctx, 1, 10, []LevelRankEntry{{PlayerID: "player1", BestScore: 3, WinCount: 2}, {PlayerID: "player2", BestScore: 4, WinCount: 2}, {PlayerID: "player3", BestScore: 5, WinCount: 1}})
+
+	// an archived player leaves the index, and comes back with their stats
+	store, err := NewFileColdStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.coldStore = store
+	err = ds.WritePlayer(ctx, &PlayerData{PlayerID: "player1", Level: 2, Energy: 50, LastUpdateTime: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ds.archiveInactivePlayers(time.Now().UTC(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTop(ctx, 1, 10, []LevelRankEntry{{PlayerID: "player2", BestScore: 4, WinCount: 2}, {PlayerID: "player3", BestScore: 5, WinCount: 1}})
+
+	_, err = ds.ReadStats(ctx, "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTop(ctx, 1, 10, []LevelRankEntry{{PlayerID: "player1", BestScore: 3, WinCount: 2}, {PlayerID: "player2", BestScore: 4, WinCount: 2}, {PlayerID: "player3", BestScore: 5, WinCount: 1}})
+
+	// a restored snapshot rebuilds the index
+	err = ds.RestoreSnapshot(ctx, &Snapshot{Version: snapshotVersion, Namespaces: []NamespaceSnapshot{{Stats: []PlayerStatsWithID{
+		{PlayerID: "player5", PlayerStats: PlayerStats{LevelStats: []PlayerLevelStats{{Level: 2, WinCount: 1, BestScore: 3}}}},
+	}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTop(ctx, 1, 10, []LevelRankEntry{})
+	checkTop(ctx, 2, 10, []LevelRankEntry{{PlayerID: "player5", BestScore: 3, WinCount: 1}})
+	checkTop(stagingCtx, 1, 10, []LevelRankEntry{})
+
+	_, err = ds.ReadLevelLeaderboard(ctx, 0, 10)
+	if err == nil {
+		t.Error("ReadLevelLeaderboard() should fail for an invalid level")
+	}
+}
+
+func TestHTTPClient_ReadLevelLeaderboard(t *testing.T) {
+
+	ds := NewServer()
+	err := ds.WriteStats(context.Background(), &PlayerStatsWithID{PlayerID: "player1", PlayerStats: PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 2}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/level-leaderboard-internal/{level}", ds.HandleReadLevelLeaderboardRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	want := []LevelRankEntry{{PlayerID: "player1", BestScore: 2, WinCount: 1}}
+	got, err := hc.ReadLevelLeaderboard(context.Background(), 1, 10)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadLevelLeaderboard() gave incorrect results, want: %v, got: %v (error: %v)", want, got, err)
+	}
+
+	_, err = hc.ReadLevelLeaderboard(context.Background(), 1, 0)
+	if err == nil {
+		t.Error("ReadLevelLeaderboard() should fail for an invalid limit")
+	}
+}
+
+func TestServer_HandleRestoreRequest(t *testing.T) {
+
+	store, err := NewFileBackupStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := NewServer()
+	ds.backupStore = store
+
+	info, err := ds.Backup(context.Background(), BackupFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, `{"name": "` + info.Name + `"}`, http.StatusInternalServerError},
+		{"backups not enabled", NewServer(), `{"name": "` + info.Name + `"}`, http.StatusServiceUnavailable},
+		{"invalid body", ds, `{"name": `, http.StatusBadRequest},
+		{"invalid name", ds, `{"name": "../backup-1.json"}`, http.StatusBadRequest},
+		{"missing backup", ds, `{"name": "backup-1.json"}`, http.StatusNotFound},
+		{"valid restore", ds, `{"name": "` + info.Name + `"}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/restore-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleRestoreRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
+
+func TestMigrationRegistries(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		migrations []Migration
+		latest     int32
+	}{
+		{"player data", playerDataMigrations, PlayerDataVersion},
+		{"player stats", playerStatsMigrations, PlayerStatsVersion},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// there should be exactly one migration to every version, in order
+			for i, migration := range test.migrations {
+				if migration.Version != int32(i+1) || migration.Migrate == nil {
+					t.Errorf("migration registry is out of order, want version: %v, got: %v", i+1, migration.Version)
+				}
+			}
+			if len(test.migrations) != int(test.latest) {
+				t.Errorf("migration registry does not reach the latest version, want: %v, got: %v", test.latest, len(test.migrations))
+			}
+		})
+	}
+}
+
+func TestDecodeVersioned(t *testing.T) {
+
+	type testRecord struct {
+		Name    string `json:"name"`
+		Count   int32  `json:"count"`
+		Version int32  `json:"version"`
+	}
+
+	// version 2 renamed 'title' to 'name', version 3 added the count, defaulting to 1
+	migrations := []Migration{
+		{Version: 1, Migrate: func(record map[string]any) error { return nil }},
+		{Version: 2, Migrate: func(record map[string]any) error {
+			if title, ok := record["title"]; ok {
+				record["name"] = title
+				delete(record, "title")
+			}
+			return nil
+		}},
+		{Version: 3, Migrate: func(record map[string]any) error {
+			if _, ok := record["count"]; !ok {
+				record["count"] = 1
+			}
+			return nil
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		encoded    string
+		wantRecord *testRecord
+		wantErr    bool
+	}{
+		{"unversioned record", `{"title":"a"}`, &testRecord{Name: "a", Count: 1, Version: 3}, false},
+		{"version 2 record", `{"name":"b","version":2}`, &testRecord{Name: "b", Count: 1, Version: 3}, false},
+		{"current record", `{"name":"c","count":5,"version":3}`, &testRecord{Name: "c", Count: 5, Version: 3}, false},
+		{"newer record", `{"name":"d","version":4}`, nil, true},
+		{"invalid json", `{"name":`, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotRecord, err := decodeVersioned([]byte(test.encoded), migrations, 3, func(record *testRecord) int32 {
+				return record.Version
+			})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("decodeVersioned() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if !reflect.DeepEqual(gotRecord, test.wantRecord) {
+				t.Errorf("decodeVersioned() gave incorrect results, want: %v, got: %v", test.wantRecord, gotRecord)
+			}
+		})
+	}
+}
+
+func TestPlayerData_UnmarshalJSON(t *testing.T) {
+
+	// records saved before versioning are upgraded while being decoded
+	player := &PlayerData{}
+	err := json.Unmarshal([]byte(`{"playerID":"player1","level":2,"energy":30,"lastUpdateTime":100}`), player)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &PlayerData{PlayerID: "player1", Level: 2, Energy: 30, LastUpdateTime: 100, Version: PlayerDataVersion}
+	if !reflect.DeepEqual(player, want) {
+		t.Errorf("UnmarshalJSON() gave incorrect results, want: %v, got: %v", want, player)
+	}
+
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{"playerID":"player1","version":%v}`, PlayerDataVersion+1)), player)
+	if err == nil {
+		t.Error("expected an error for a record newer than the supported version")
+	}
+}
+
+// fakeRedis is a minimal in memory redis server, with the commands used by the redis store
+type fakeRedis struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	hashes   map[string]map[string]string
+	versions map[string]int
+
+	// called before a transaction is executed, with the fake redis mutex held (to change a watched hash in between)
+	beforeExec func(fr *fakeRedis)
+}
+
+// newFakeRedis starts a fake redis server on a free port, it is closed when the test ends
+func newFakeRedis(t *testing.T) *fakeRedis {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("could not listen: " + err.Error())
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	fr := &fakeRedis{listener: listener, hashes: map[string]map[string]string{}, versions: map[string]int{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+
+	return fr
+}
+
+func (fr *fakeRedis) setBeforeExec(beforeExec func(fr *fakeRedis)) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	fr.beforeExec = beforeExec
+}
+
+// hset sets a field of a hash, changing the version of the hash (the fake redis mutex should be held)
+func (fr *fakeRedis) hset(key string, field string, value string) {
+	if fr.hashes[key] == nil {
+		fr.hashes[key] = map[string]string{}
+	}
+	fr.hashes[key][field] = value
+	fr.versions[key]++
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	watched := map[string]int{}
+	var queued [][]string
+	inMulti := false
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, count)
+		for i := range args {
+			sizeLine, _ := reader.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(sizeLine[1:]))
+			value := make([]byte, size+2)
+			_, err = io.ReadFull(reader, value)
+			if err != nil {
+				return
+			}
+			args[i] = string(value[:size])
+		}
+
+		var reply string
+		switch {
+		case inMulti && args[0] != "EXEC":
+			queued = append(queued, args)
+			reply = "+QUEUED\r\n"
+		case args[0] == "MULTI":
+			inMulti = true
+			reply = "+OK\r\n"
+		case args[0] == "EXEC":
+			fr.mutex.Lock()
+			if fr.beforeExec != nil {
+				fr.beforeExec(fr)
+			}
+			aborted := false
+			for key, version := range watched {
+				aborted = aborted || fr.versions[key] != version
+			}
+			if aborted {
+				reply = "*-1\r\n"
+			} else {
+				reply = fmt.Sprintf("*%d\r\n", len(queued))
+				for _, command := range queued {
+					reply += fr.run(command, watched)
+				}
+			}
+			fr.mutex.Unlock()
+			inMulti, queued, watched = false, nil, map[string]int{}
+		default:
+			fr.mutex.Lock()
+			reply = fr.run(args, watched)
+			fr.mutex.Unlock()
+		}
+
+		_, err = conn.Write([]byte(reply))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// run runs a single command outside of a transaction (the fake redis mutex should be held)
+func (fr *fakeRedis) run(args []string, watched map[string]int) string {
+
+	bulk := func(value string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value) }
+
+	switch args[0] {
+	case "PING":
+		return "+PONG\r\n"
+	case "HGET":
+		value, ok := fr.hashes[args[1]][args[2]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "HMGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-2)
+		for _, field := range args[2:] {
+			value, ok := fr.hashes[args[1]][field]
+			if !ok {
+				reply += "$-1\r\n"
+			} else {
+				reply += bulk(value)
+			}
+		}
+		return reply
+	case "HSET":
+		for i := 2; i+1 < len(args); i += 2 {
+			fr.hset(args[1], args[i], args[i+1])
+		}
+		return ":1\r\n"
+	case "DEL":
+		delete(fr.hashes, args[1])
+		fr.versions[args[1]]++
+		return ":1\r\n"
+	case "WATCH":
+		for _, key := range args[1:] {
+			watched[key] = fr.versions[key]
+		}
+		return "+OK\r\n"
+	case "UNWATCH":
+		clear(watched)
+		return "+OK\r\n"
+	case "SCAN":
+		// args: SCAN cursor MATCH pattern COUNT count, the cursor is an index into the sorted matching keys
+		cursor, _ := strconv.Atoi(args[1])
+		batch, _ := strconv.Atoi(args[5])
+		keys := []string{}
+		for key := range fr.hashes {
+			if strings.HasPrefix(key, strings.TrimSuffix(args[3], "*")) {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		end := min(cursor+batch, len(keys))
+		next := strconv.Itoa(end)
+		if end == len(keys) {
+			next = "0"
+		}
+		reply := "*2\r\n" + bulk(next) + fmt.Sprintf("*%d\r\n", end-cursor)
+		for _, key := range keys[cursor:end] {
+			reply += bulk(key)
+		}
+		return reply
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+func TestServer_Redis(t *testing.T) {
+
+	fr := newFakeRedis(t)
+
+	store, err := NewRedisStore(fr.listener.Addr().String())
+	if err != nil {
+		t.Fatal("NewRedisStore() gave an unexpected error: " + err.Error())
+	}
+
+	// replicas sharing the redis store see each other's writes
+	ds, replica := NewServer(), NewServer()
+	for _, server := range []*Server{ds, replica} {
+		err = server.EnablePlayerStore(store)
+		if err != nil {
+			t.Fatal("EnablePlayerStore() gave an unexpected error: " + err.Error())
+		}
+	}
+
+	ctx := context.Background()
+	stagingCtx := namespace.NewContext(ctx, "staging")
+
+	for i := range redisScanBatch + 5 {
+		err = ds.WritePlayer(ctx, &PlayerData{PlayerID: fmt.Sprintf("player%03d", i), Level: 1, Energy: 50, LastUpdateTime: 100})
+		if err != nil {
+			t.Fatal("write player error: " + err.Error())
+		}
+	}
+
+	err = ds.WritePlayer(stagingCtx, &PlayerData{PlayerID: "player000", Level: 2, Energy: 10, LastUpdateTime: 100})
+	if err != nil {
+		t.Fatal("write player error: " + err.Error())
+	}
+
+	err = ds.WriteStats(ctx, &PlayerStatsWithID{PlayerID: "player001", PlayerStats: PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 3}}, Rating: 1210}})
+	if err != nil {
+		t.Fatal("write stats error: " + err.Error())
+	}
+
+	t.Run("read", func(t *testing.T) {
+
+		gotPlayer, err := replica.ReadPlayer(stagingCtx, "player000")
+		wantPlayer := &PlayerData{PlayerID: "player000", Level: 2, Energy: 10, LastUpdateTime: 100, Version: PlayerDataVersion}
+		if err != nil || !reflect.DeepEqual(gotPlayer, wantPlayer) {
+			t.Errorf("ReadPlayer() gave incorrect results, want: %v, got: %v (error: %v)", wantPlayer, gotPlayer, err)
+		}
+
+		_, err = replica.ReadPlayer(ctx, "missing")
+		if err != (PlayerNotFoundErr{PlayerID: "missing"}) {
+			t.Errorf("ReadPlayer() gave incorrect error, want: %v, got: %v", PlayerNotFoundErr{PlayerID: "missing"}, err)
+		}
+
+		gotStats, err := replica.ReadStats(ctx, "player001")
+		if err != nil || gotStats.Rating != 1210 || len(gotStats.LevelStats) != 1 || gotStats.Version != PlayerStatsVersion {
+			t.Errorf("ReadStats() gave incorrect results, got: %v (error: %v)", gotStats, err)
+		}
+
+		_, err = replica.ReadStats(ctx, "player000")
+		if err != (PlayerStatsNotFoundErr{PlayerID: "player000"}) {
+			t.Errorf("ReadStats() gave incorrect error, want: %v, got: %v", PlayerStatsNotFoundErr{PlayerID: "player000"}, err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+
+		// the players are scanned over more than one batch
+		gotPage, err := replica.ListPlayers(ctx, pagination.Request{Limit: pagination.MaxLimit})
+		if err != nil || len(gotPage.Players) != redisScanBatch+5 {
+			t.Fatalf("ListPlayers() gave incorrect results, want: %v players, got: %v (error: %v)", redisScanBatch+5, gotPage, err)
+		}
+
+		gotLeaderboard, err := replica.ReadRatingLeaderboard(ctx, 10)
+		wantLeaderboard := []RatingEntry{{PlayerID: "player001", Rating: 1210}}
+		if err != nil || !reflect.DeepEqual(gotLeaderboard, wantLeaderboard) {
+			t.Errorf("ReadRatingLeaderboard() gave incorrect results, want: %v, got: %v (error: %v)", wantLeaderboard, gotLeaderboard, err)
+		}
+	})
+
+	swapTests := []struct {
+		name       string
+		swap       *PlayerSwap
+		beforeExec func(fr *fakeRedis)
+		wantErr    error
+		wantEnergy int32
+	}{
+		{"missing player", &PlayerSwap{Expected: PlayerData{PlayerID: "missing"}, Updated: PlayerData{PlayerID: "missing"}}, nil, PlayerNotFoundErr{PlayerID: "missing"}, 50},
+		{"changed player", &PlayerSwap{Expected: PlayerData{PlayerID: "player002", Level: 1, Energy: 40, LastUpdateTime: 100}, Updated: PlayerData{PlayerID: "player002", Level: 1, Energy: 30, LastUpdateTime: 110}}, nil, PlayerChangedErr{PlayerID: "player002"}, 50},
+		{"changed during the swap", &PlayerSwap{Expected: PlayerData{PlayerID: "player002", Level: 1, Energy: 50, LastUpdateTime: 100}, Updated: PlayerData{PlayerID: "player002", Level: 1, Energy: 30, LastUpdateTime: 110}}, func(fr *fakeRedis) {
+			fr.versions[redisKeyPrefix+":player002"]++
+		}, PlayerChangedErr{PlayerID: "player002"}, 50},
+		{"unchanged player", &PlayerSwap{Expected: PlayerData{PlayerID: "player002", Level: 1, Energy: 50, LastUpdateTime: 100}, Updated: PlayerData{PlayerID: "player002", Level: 1, Energy: 30, LastUpdateTime: 110}}, nil, nil, 30},
+	}
+
+	for _, test := range swapTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			fr.setBeforeExec(test.beforeExec)
+			defer fr.setBeforeExec(nil)
+
+			err := replica.SwapPlayer(ctx, test.swap)
+			if err != test.wantErr {
+				t.Errorf("SwapPlayer() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+
+			gotPlayer, err := ds.ReadPlayer(ctx, "player002")
+			if err != nil || gotPlayer.Energy != test.wantEnergy {
+				t.Errorf("SwapPlayer() gave incorrect results, want energy: %v, got: %v (error: %v)", test.wantEnergy, gotPlayer, err)
+			}
+		})
+	}
+
+	// player001 (who has stats) is merged into player003 in one transaction
+	expected, secondary := PlayerData{PlayerID: "player003", Level: 1, Energy: 50, LastUpdateTime: 100}, PlayerData{PlayerID: "player001", Level: 1, Energy: 50, LastUpdateTime: 100}
+	merged := PlayerData{PlayerID: "player003", Level: 1, Energy: 100, LastUpdateTime: 120}
+
+	mergeTests := []struct {
+		name       string
+		merge      *PlayerMerge
+		beforeExec func(fr *fakeRedis)
+		wantErr    error
+	}{
+		{"changed player", &PlayerMerge{Expected: merged, ExpectedSecondary: secondary, Merged: merged}, nil, PlayerChangedErr{PlayerID: "player003"}},
+		{"changed during the merge", &PlayerMerge{Expected: expected, ExpectedSecondary: secondary, Merged: merged}, func(fr *fakeRedis) {
+			fr.versions[redisKeyPrefix+":player001"]++
+		}, PlayerChangedErr{PlayerID: "player003"}},
+		{"merge", &PlayerMerge{Expected: expected, ExpectedSecondary: secondary, Merged: merged, MergeTime: 120}, nil, nil},
+		{"merge again", &PlayerMerge{Expected: merged, ExpectedSecondary: secondary, Merged: merged}, nil, PlayerRetiredErr{PlayerID: "player001"}},
+	}
+
+	for _, test := range mergeTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			fr.setBeforeExec(test.beforeExec)
+			defer fr.setBeforeExec(nil)
+
+			result, err := replica.MergePlayers(ctx, test.merge)
+			if err != test.wantErr {
+				t.Fatalf("MergePlayers() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+			if err == nil && (!result.Player.Equal(merged) || result.Stats.Rating != 1210) {
+				t.Errorf("MergePlayers() gave incorrect results, want: %v with the stats of player001, got: %+v", merged, result)
+			}
+		})
+	}
+
+	t.Run("merged players", func(t *testing.T) {
+
+		gotStats, err := ds.ReadStats(ctx, "player003")
+		if err != nil || gotStats.Rating != 1210 || len(gotStats.LevelStats) != 1 {
+			t.Errorf("ReadStats() gave incorrect results, want the merged stats, got: %v (error: %v)", gotStats, err)
+		}
+
+		_, err = ds.ReadPlayer(ctx, "player001")
+		if err != (PlayerNotFoundErr{PlayerID: "player001"}) {
+			t.Errorf("the secondary player should have been removed, got: %v", err)
+		}
+		_, err = ds.ReadStats(ctx, "player001")
+		if err != (PlayerStatsNotFoundErr{PlayerID: "player001"}) {
+			t.Errorf("the stats of the secondary player should have been removed, got: %v", err)
+		}
+
+		redirect, err := ds.ReadRedirect(ctx, "player001")
+		if err != nil || *redirect != (PlayerRedirect{PlayerID: "player001", MergedInto: "player003", MergeTime: 120}) {
+			t.Errorf("ReadRedirect() gave incorrect results, got: %+v (error: %v)", redirect, err)
+		}
+
+		gotPage, err := ds.ListPlayers(ctx, pagination.Request{Limit: pagination.MaxLimit})
+		if err != nil || len(gotPage.Players) != redisScanBatch+4 {
+			t.Errorf("ListPlayers() gave incorrect results, want: %v players, got: %v (error: %v)", redisScanBatch+4, len(gotPage.Players), err)
+		}
+	})
+
+	// the leases are kept in redis too, so all the replicas see them
+	t.Run("leases", func(t *testing.T) {
+		testLeases(t, ds)
+
+		_, err := replica.AcquireLease(ctx, &LeaseRequest{Name: "job1", Holder: "holderC", TTLSeconds: 60})
+		if !errors.As(err, &LeaseHeldErr{}) {
+			t.Errorf("AcquireLease() gave incorrect error, want: a LeaseHeldErr on the replica, got: %v", err)
+		}
+	})
+
+	t.Run("not with archival", func(t *testing.T) {
+
+		coldStore, err := NewFileColdStore(t.TempDir())
+		if err != nil {
+			t.Fatal("NewFileColdStore() gave an unexpected error: " + err.Error())
+		}
+
+		archiving := NewServer()
+		archiving.EnableArchival(coldStore, constants.ArchiveInactiveDays, time.Hour)
+
+		if archiving.EnablePlayerStore(store) == nil {
+			t.Error("EnablePlayerStore() gave incorrect results, want an error with archival enabled")
+		}
+	})
+}
+
+// fakePostgres is an in memory database behind a fake database/sql driver, which only understands the statements
+// of the postgres store. A transaction restores the tables it started with when it is rolled back
+type fakePostgres struct {
+	mutex      sync.Mutex
+	migrations int
+	tables     map[string]map[[2]string][]byte
+	leases     map[[2]string]LeaseData
+
+	// writes to this table fail (to check that transactions are rolled back)
+	failTable string
+}
+
+// fakePostgresDBs holds the fake databases by their connection strings
+var fakePostgresDBs sync.Map
+
+func init() {
+	sql.Register("fakepostgres", fakePostgresDriver{})
+}
+
+// newFakePostgres returns a new fake database, and its connection string
+func newFakePostgres(t *testing.T) (*fakePostgres, string) {
+	fp := &fakePostgres{tables: map[string]map[[2]string][]byte{}, leases: map[[2]string]LeaseData{}}
+	fakePostgresDBs.Store(t.Name(), fp)
+	return fp, t.Name()
+}
+
+func (fp *fakePostgres) setFailTable(table string) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	fp.failTable = table
+}
+
+type fakePostgresDriver struct{}
+
+func (fakePostgresDriver) Open(dsn string) (driver.Conn, error) {
+	fp, ok := fakePostgresDBs.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("no fake database: %v", dsn)
+	}
+	return &fakePostgresConn{db: fp.(*fakePostgres)}, nil
+}
+
+type fakePostgresConn struct {
+	db *fakePostgres
+
+	// the tables when the current transaction began (nil outside of a transaction)
+	saved map[string]map[[2]string][]byte
+}
+
+func (fc *fakePostgresConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakePostgresStmt{conn: fc, query: query}, nil
+}
+
+func (fc *fakePostgresConn) Close() error { return nil }
+
+func (fc *fakePostgresConn) Begin() (driver.Tx, error) {
+	fc.db.mutex.Lock()
+	defer fc.db.mutex.Unlock()
+
+	fc.saved = map[string]map[[2]string][]byte{}
+	for table, rows := range fc.db.tables {
+		fc.saved[table] = maps.Clone(rows)
+	}
+	return fc, nil
+}
+
+func (fc *fakePostgresConn) Commit() error {
+	fc.saved = nil
+	return nil
+}
+
+func (fc *fakePostgresConn) Rollback() error {
+	fc.db.mutex.Lock()
+	defer fc.db.mutex.Unlock()
+
+	fc.db.tables, fc.saved = fc.saved, nil
+	return nil
+}
+
+type fakePostgresStmt struct {
+	conn  *fakePostgresConn
+	query string
+}
+
+func (fs *fakePostgresStmt) Close() error  { return nil }
+func (fs *fakePostgresStmt) NumInput() int { return -1 }
+
+func (fs *fakePostgresStmt) Exec(args []driver.Value) (driver.Result, error) {
+
+	db := fs.conn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	table := ""
+	switch fs.query {
+	case pgWritePlayer:
+		table = "players"
+	case pgWriteStats:
+		table = "player_stats"
+	case pgWriteRedirect:
+		table = "player_redirects"
+	case pgDeletePlayer, pgDeleteStats:
+		table = map[string]string{pgDeletePlayer: "players", pgDeleteStats: "player_stats"}[fs.query]
+		delete(db.tables[table], [2]string{args[0].(string), args[1].(string)})
+		return driver.RowsAffected(1), nil
+	case postgresMigrations[0]:
+		db.tables["players"] = map[[2]string][]byte{}
+		return driver.RowsAffected(0), nil
+	case postgresMigrations[1]:
+		db.tables["player_stats"] = map[[2]string][]byte{}
+		return driver.RowsAffected(0), nil
+	case postgresMigrations[2]:
+		return driver.RowsAffected(0), nil
+	case postgresMigrations[3]:
+		db.tables["player_redirects"] = map[[2]string][]byte{}
+		return driver.RowsAffected(0), nil
+	case pgReleaseLease:
+		key := [2]string{args[0].(string), args[1].(string)}
+		if db.leases[key].Holder == args[2].(string) {
+			delete(db.leases, key)
+		}
+		return driver.RowsAffected(1), nil
+	case `INSERT INTO schema_migrations (version) VALUES ($1)`:
+		db.migrations = int(args[0].(int64))
+		return driver.RowsAffected(1), nil
+	case `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`:
+		return driver.RowsAffected(0), nil
+	default:
+		return nil, fmt.Errorf("unexpected statement: %v", fs.query)
+	}
+
+	if table == db.failTable {
+		return nil, fmt.Errorf("write to %v failed", table)
+	}
+	db.tables[table][[2]string{args[0].(string), args[1].(string)}] = args[2].([]byte)
+	return driver.RowsAffected(1), nil
+}
+
+func (fs *fakePostgresStmt) Query(args []driver.Value) (driver.Rows, error) {
+
+	db := fs.conn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	rows := &fakePostgresRows{}
+	switch fs.query {
+	case `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`:
+		rows.columns = []string{"max"}
+		rows.values = [][]driver.Value{{int64(db.migrations)}}
+	case pgReadPlayer, pgLockPlayer, pgReadStats, pgLockStats, pgReadRedirect:
+		table := map[string]string{pgReadPlayer: "players", pgLockPlayer: "players", pgReadStats: "player_stats", pgLockStats: "player_stats", pgReadRedirect: "player_redirects"}[fs.query]
+		rows.columns = []string{"data"}
+		if data, ok := db.tables[table][[2]string{args[0].(string), args[1].(string)}]; ok {
+			rows.values = [][]driver.Value{{data}}
+		}
+	case pgAcquireLease:
+		rows.columns = []string{"holder"}
+		key := [2]string{args[0].(string), args[1].(string)}
+		current, held := db.leases[key]
+		if !held || current.Holder == args[2].(string) || current.ExpiryTime <= args[4].(int64) {
+			db.leases[key] = LeaseData{Name: args[1].(string), Holder: args[2].(string), ExpiryTime: args[3].(int64)}
+			rows.values = [][]driver.Value{{args[2]}}
+		}
+	case pgReadLease:
+		rows.columns = []string{"holder"}
+		if current, held := db.leases[[2]string{args[0].(string), args[1].(string)}]; held {
+			rows.values = [][]driver.Value{{current.Holder}}
+		}
+	case pgReadAllPlayers, pgReadAllStats:
+		table := map[string]string{pgReadAllPlayers: "players", pgReadAllStats: "player_stats"}[fs.query]
+		rows.columns = []string{"player_id", "data"}
+		for key, data := range db.tables[table] {
+			if key[0] == args[0].(string) {
+				rows.values = append(rows.values, []driver.Value{key[1], data})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query: %v", fs.query)
+	}
+	return rows, nil
+}
+
+type fakePostgresRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (fr *fakePostgresRows) Columns() []string { return fr.columns }
+func (fr *fakePostgresRows) Close() error      { return nil }
+
+func (fr *fakePostgresRows) Next(dest []driver.Value) error {
+	if len(fr.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, fr.values[0])
+	fr.values = fr.values[1:]
+	return nil
+}
+
+func TestServer_Postgres(t *testing.T) {
+
+	_, err := NewPostgresStore(context.Background(), "missing", "")
+	if err == nil {
+		t.Error("NewPostgresStore() gave incorrect results, want an error without a registered driver")
+	}
+
+	fp, dsn := newFakePostgres(t)
+
+	store, err := NewPostgresStore(context.Background(), "fakepostgres", dsn)
+	if err != nil {
+		t.Fatal("NewPostgresStore() gave an unexpected error: " + err.Error())
+	}
+	defer store.Close()
+
+	if fp.migrations != len(postgresMigrations) {
+		t.Fatalf("NewPostgresStore() gave incorrect results, want: %v migrations applied, got: %v", len(postgresMigrations), fp.migrations)
+	}
+
+	// reopening the migrated database applies no migrations again
+	reopened, err := NewPostgresStore(context.Background(), "fakepostgres", dsn)
+	if err != nil {
+		t.Fatal("NewPostgresStore() gave an unexpected error on a migrated database: " + err.Error())
+	}
+	reopened.Close()
+
+	ds := NewServer()
+	err = ds.EnablePlayerStore(store)
+	if err != nil {
+		t.Fatal("EnablePlayerStore() gave an unexpected error: " + err.Error())
+	}
+
+	ctx := context.Background()
+	plStats := PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 3}}, Rating: 1210}
+
+	err = ds.WritePlayerAndStats(ctx, &PlayerWithStats{Player: PlayerData{PlayerID: "player1", Level: 2, Energy: 40, LastUpdateTime: 100}, Stats: plStats})
+	if err != nil {
+		t.Fatal("WritePlayerAndStats() gave an unexpected error: " + err.Error())
+	}
+
+	// a failed stats write rolls back the player write of the same transaction
+	fp.setFailTable("player_stats")
+	err = ds.WritePlayerAndStats(ctx, &PlayerWithStats{Player: PlayerData{PlayerID: "player1", Level: 3, Energy: 30, LastUpdateTime: 200}, Stats: plStats})
+	if err == nil {
+		t.Error("WritePlayerAndStats() gave incorrect results, want an error when the stats write fails")
+	}
+	fp.setFailTable("")
+
+	gotPlayer, err := ds.ReadPlayer(ctx, "player1")
+	wantPlayer := &PlayerData{PlayerID: "player1", Level: 2, Energy: 40, LastUpdateTime: 100, Version: PlayerDataVersion}
+	if err != nil || !reflect.DeepEqual(gotPlayer, wantPlayer) {
+		t.Errorf("ReadPlayer() gave incorrect results, want: %v, got: %v (error: %v)", wantPlayer, gotPlayer, err)
+	}
+
+	gotStats, err := ds.ReadStats(ctx, "player1")
+	if err != nil || gotStats.Rating != 1210 || gotStats.Version != PlayerStatsVersion {
+		t.Errorf("ReadStats() gave incorrect results, got: %v (error: %v)", gotStats, err)
+	}
+
+	_, err = ds.ReadStats(ctx, "player2")
+	if err != (PlayerStatsNotFoundErr{PlayerID: "player2"}) {
+		t.Errorf("ReadStats() gave incorrect error, want: %v, got: %v", PlayerStatsNotFoundErr{PlayerID: "player2"}, err)
+	}
+
+	swapTests := []struct {
+		name    string
+		swap    *PlayerSwap
+		wantErr error
+	}{
+		{"missing player", &PlayerSwap{Expected: PlayerData{PlayerID: "player2"}, Updated: PlayerData{PlayerID: "player2"}}, PlayerNotFoundErr{PlayerID: "player2"}},
+		{"changed player", &PlayerSwap{Expected: PlayerData{PlayerID: "player1", Level: 2, Energy: 50, LastUpdateTime: 100}, Updated: PlayerData{PlayerID: "player1", Level: 2, Energy: 30, LastUpdateTime: 110}}, PlayerChangedErr{PlayerID: "player1"}},
+		{"unchanged player", &PlayerSwap{Expected: PlayerData{PlayerID: "player1", Level: 2, Energy: 40, LastUpdateTime: 100}, Updated: PlayerData{PlayerID: "player1", Level: 2, Energy: 30, LastUpdateTime: 110}}, nil},
+	}
+
+	for _, test := range swapTests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ds.SwapPlayer(ctx, test.swap)
+			if err != test.wantErr {
+				t.Errorf("SwapPlayer() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+		})
+	}
+
+	gotPage, err := ds.ListPlayers(ctx, pagination.Request{})
+	if err != nil || len(gotPage.Players) != 1 || gotPage.Players[0].Energy != 30 {
+		t.Errorf("ListPlayers() gave incorrect results, want the swapped player, got: %v (error: %v)", gotPage, err)
+	}
+
+	// player2 (who has no stats) is merged into player1 in one transaction
+	err = ds.WritePlayer(ctx, &PlayerData{PlayerID: "player2", Level: 3, Energy: 20, LastUpdateTime: 100})
+	if err != nil {
+		t.Fatal("WritePlayer() gave an unexpected error: " + err.Error())
+	}
+
+	expected, secondary := PlayerData{PlayerID: "player1", Level: 2, Energy: 30, LastUpdateTime: 110}, PlayerData{PlayerID: "player2", Level: 3, Energy: 20, LastUpdateTime: 100}
+	merged := PlayerData{PlayerID: "player1", Level: 3, Energy: 50, LastUpdateTime: 120}
+
+	// a failed redirect write rolls back the whole merge
+	fp.setFailTable("player_redirects")
+	_, err = ds.MergePlayers(ctx, &PlayerMerge{Expected: expected, ExpectedSecondary: secondary, Merged: merged})
+	if err == nil {
+		t.Error("MergePlayers() gave incorrect results, want an error when the redirect write fails")
+	}
+	fp.setFailTable("")
+
+	mergeTests := []struct {
+		name    string
+		merge   *PlayerMerge
+		wantErr error
+	}{
+		{"missing secondary", &PlayerMerge{Expected: expected, ExpectedSecondary: PlayerData{PlayerID: "player3"}, Merged: merged}, PlayerNotFoundErr{PlayerID: "player3"}},
+		{"changed secondary", &PlayerMerge{Expected: expected, ExpectedSecondary: PlayerData{PlayerID: "player2"}, Merged: merged}, PlayerChangedErr{PlayerID: "player2"}},
+		{"merge", &PlayerMerge{Expected: expected, ExpectedSecondary: secondary, Merged: merged, MergeTime: 120}, nil},
+		{"merge again", &PlayerMerge{Expected: merged, ExpectedSecondary: secondary, Merged: merged}, PlayerRetiredErr{PlayerID: "player2"}},
+	}
+
+	for _, test := range mergeTests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := ds.MergePlayers(ctx, test.merge)
+			if err != test.wantErr {
+				t.Fatalf("MergePlayers() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+			if err == nil && (!result.Player.Equal(merged) || result.Stats.Rating != 1210) {
+				t.Errorf("MergePlayers() gave incorrect results, want: %v with the stats of player1, got: %+v", merged, result)
+			}
+		})
+	}
+
+	_, err = ds.ReadPlayer(ctx, "player2")
+	if err != (PlayerNotFoundErr{PlayerID: "player2"}) {
+		t.Errorf("the secondary player should have been removed, got: %v", err)
+	}
+
+	redirect, err := ds.ReadRedirect(ctx, "player2")
+	if err != nil || *redirect != (PlayerRedirect{PlayerID: "player2", MergedInto: "player1", MergeTime: 120}) {
+		t.Errorf("ReadRedirect() gave incorrect results, got: %+v (error: %v)", redirect, err)
+	}
+
+	// the leases are kept in postgres too
+	t.Run("leases", func(t *testing.T) {
+		testLeases(t, ds)
+	})
+}
+
+// TestServer_PostgresIntegration runs the postgres store against a real database, through the driver the runners
+// import, it only runs when the postgres dsn environment variable is set
+func TestServer_PostgresIntegration(t *testing.T) {
+
+	if os.Getenv(constants.PostgresDSNEnvVar) == "" {
+		t.Skipf("%v is not set", constants.PostgresDSNEnvVar)
+	}
+
+	ds := NewServer()
+	err := ds.EnablePostgresFromEnv()
+	if err != nil {
+		t.Fatal("EnablePostgresFromEnv() gave an unexpected error: " + err.Error())
+	}
+
+	// the test players are written in a namespace of their own, so the test never touches other data of the database
+	ctx := namespace.NewContext(context.Background(), "test-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	plStats := PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 3}}, Rating: 1210}
+
+	err = ds.WritePlayerAndStats(ctx, &PlayerWithStats{Player: PlayerData{PlayerID: "player1", Level: 2, Energy: 40, LastUpdateTime: 100}, Stats: plStats})
+	if err != nil {
+		t.Fatal("WritePlayerAndStats() gave an unexpected error: " + err.Error())
+	}
+
+	gotPlayer, err := ds.ReadPlayer(ctx, "player1")
+	wantPlayer := &PlayerData{PlayerID: "player1", Level: 2, Energy: 40, LastUpdateTime: 100, Version: PlayerDataVersion}
+	if err != nil || !reflect.DeepEqual(gotPlayer, wantPlayer) {
+		t.Errorf("ReadPlayer() gave incorrect results, want: %v, got: %v (error: %v)", wantPlayer, gotPlayer, err)
+	}
+
+	gotStats, err := ds.ReadStats(ctx, "player1")
+	if err != nil || gotStats.Rating != 1210 || !reflect.DeepEqual(gotStats.LevelStats, plStats.LevelStats) {
+		t.Errorf("ReadStats() gave incorrect results, want: %v, got: %v (error: %v)", plStats, gotStats, err)
+	}
+
+	err = ds.SwapPlayer(ctx, &PlayerSwap{Expected: PlayerData{PlayerID: "player1", Level: 2, Energy: 50, LastUpdateTime: 100}, Updated: PlayerData{PlayerID: "player1", Level: 2, Energy: 30, LastUpdateTime: 110}})
+	if err != (PlayerChangedErr{PlayerID: "player1"}) {
+		t.Errorf("SwapPlayer() gave incorrect error, want: %v, got: %v", PlayerChangedErr{PlayerID: "player1"}, err)
+	}
+
+	err = ds.SwapPlayer(ctx, &PlayerSwap{Expected: PlayerData{PlayerID: "player1", Level: 2, Energy: 40, LastUpdateTime: 100}, Updated: PlayerData{PlayerID: "player1", Level: 2, Energy: 30, LastUpdateTime: 110}})
+	if err != nil {
+		t.Errorf("SwapPlayer() gave an unexpected error: %v", err)
+	}
+
+	_, err = ds.ReadPlayer(ctx, "player2")
+	if err != (PlayerNotFoundErr{PlayerID: "player2"}) {
+		t.Errorf("ReadPlayer() gave incorrect error, want: %v, got: %v", PlayerNotFoundErr{PlayerID: "player2"}, err)
+	}
+}
+
+func TestServer_HandleWritePlayerAndStatsRequest(t *testing.T) {
+
+	ds := NewServer()
+
+	tests := []struct {
+		name       string
+		server     *Server
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError},
+		{"invalid body", ds, "{", http.StatusBadRequest},
+		{"blank player id", ds, `{"player":{"level":1},"stats":{"rating":1000}}`, http.StatusBadRequest},
+		{"valid request", ds, `{"player":{"playerID":"player1","level":2,"energy":40,"lastUpdateTime":100},"stats":{"levelStats":[{"level":1,"winCount":1,"bestScore":3}],"rating":1210}}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/player-stats-internal", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleWritePlayerAndStatsRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	gotPlayer, err := ds.ReadPlayer(context.Background(), "player1")
+	if err != nil || gotPlayer.Level != 2 {
+		t.Errorf("handler gave incorrect results, want the written player, got: %v (error: %v)", gotPlayer, err)
+	}
+
+	gotStats, err := ds.ReadStats(context.Background(), "player1")
+	if err != nil || gotStats.Rating != 1210 {
+		t.Errorf("handler gave incorrect results, want the written stats, got: %v (error: %v)", gotStats, err)
+	}
+}
+
+// leaseClient is implemented by the data server and the data clients
+type leaseClient interface {
+	AcquireLease(ctx context.Context, request *LeaseRequest) (*LeaseData, error)
+	ReleaseLease(ctx context.Context, name string, holder string) error
+}
+
+// testLeases checks taking, renewing and releasing a lease through the given client
+func testLeases(t *testing.T, client leaseClient) {
+
+	tests := []struct {
+		name        string
+		release     bool
+		holder      string
+		wantAcquire bool
+	}{
+		{"free lease", false, "holderA", true},
+		{"held by another holder", false, "holderB", false},
+		{"renewed by its holder", false, "holderA", true},
+		{"released by another holder", true, "holderB", false},
+		{"still held", false, "holderB", false},
+		{"released by its holder", true, "holderA", false},
+		{"taken after the release", false, "holderB", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if test.release {
+				err := client.ReleaseLease(context.Background(), "job1", test.holder)
+				if err != nil {
+					t.Fatal("ReleaseLease() gave an unexpected error: " + err.Error())
+				}
+				return
+			}
+
+			lease, err := client.AcquireLease(context.Background(), &LeaseRequest{Name: "job1", Holder: test.holder, TTLSeconds: 60})
+			if test.wantAcquire {
+				if err != nil || lease.Holder != test.holder || lease.ExpiryTime <= time.Now().UTC().Unix() {
+					t.Errorf("AcquireLease() gave incorrect results, want a lease of: %v, got: %v (error: %v)", test.holder, lease, err)
+				}
+				return
+			}
+
+			if !errors.As(err, &LeaseHeldErr{}) {
+				t.Errorf("AcquireLease() gave incorrect error, want: a LeaseHeldErr, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPClient_Leases(t *testing.T) {
+
+	ds := NewServer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /data/lease-internal", ds.HandleAcquireLeaseRequest)
+	mux.HandleFunc("DELETE /data/lease-internal/{name}", ds.HandleReleaseLeaseRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+	testLeases(t, hc)
+
+	_, err := hc.AcquireLease(context.Background(), &LeaseRequest{Name: "job2", Holder: "holderA"})
+	if err == nil || errors.As(err, &LeaseHeldErr{}) {
+		t.Errorf("AcquireLease() gave incorrect error, want: a bad request error without a ttl, got: %v", err)
+	}
+
+	// an expired lease can be taken by another holder
+	ds.leasesMutex.Lock()
+	ds.leasesDB[dbKey{ID: "job3"}] = LeaseData{Name: "job3", Holder: "holderA", ExpiryTime: time.Now().UTC().Unix() - 1}
+	ds.leasesMutex.Unlock()
+
+	lease, err := hc.AcquireLease(context.Background(), &LeaseRequest{Name: "job3", Holder: "holderB", TTLSeconds: 60})
+	if err != nil || lease.Holder != "holderB" {
+		t.Errorf("AcquireLease() gave incorrect results, want the expired lease for holderB, got: %v (error: %v)", lease, err)
+	}
+
+	// the data service takes the leases on its own jobs directly
+	if !ds.isJobLeader(backupJob, time.Hour) {
+		t.Error("isJobLeader() gave incorrect results, want the free job lease")
+	}
+
+	_, err = ds.AcquireLease(context.Background(), JobLeaseRequest(backupJob, NewLeaseHolder("data"), time.Hour))
+	if !errors.As(err, &LeaseHeldErr{}) {
+		t.Errorf("AcquireLease() gave incorrect error, want: a LeaseHeldErr for another data service, got: %v", err)
+	}
+}
+
+func TestServer_HandleAdminBackupRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	store, err := NewFileBackupStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := NewServer()
+	ds.backupStore = store
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		wantStatus int
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError},
+		{"invalid admin token", ds, "testToken", http.StatusUnauthorized},
+		{"backup", ds, "adminToken", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/data/admin/backup", nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			dataServer := test.server
+			dataServer.HandleAdminBackupRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	backups, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("HandleAdminBackupRequest() gave incorrect results, want: 1 backup, got: %v", len(backups))
+	}
+}
+
+func TestServer_ApplyReplicationBatch(t *testing.T) {
+
+	player := &PlayerData{PlayerID: "player1", Level: 2, Energy: 40, LastUpdateTime: 1}
+	stats := &PlayerStats{LevelStats: []PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 12}}}
+
+	tests := []struct {
+		name         string
+		follower     bool
+		synced       bool
+		batch        *ReplicationBatch
+		wantErr      bool
+		wantAck      ReplicationAck
+		wantPlayer   bool
+		wantOldEntry bool
+	}{
+		{"not a follower", false, false, &ReplicationBatch{}, true, ReplicationAck{}, false, true},
+		{"nil batch", true, true, nil, true, ReplicationAck{}, false, true},
+		{"writes before a full copy", true, false, &ReplicationBatch{Sequence: 3, Entries: []ReplicationEntry{{Sequence: 4, PlayerID: "player1", Player: player}}}, false, ReplicationAck{NeedsReset: true}, false, true},
+		{"full copy", true, false, &ReplicationBatch{Reset: true, Sequence: 9, Entries: []ReplicationEntry{{Sequence: 9, PlayerID: "player1", Player: player, Stats: stats}}}, false, ReplicationAck{Sequence: 9}, true, false},
+		{"writes in order", true, true, &ReplicationBatch{Sequence: 3, Entries: []ReplicationEntry{{Sequence: 4, PlayerID: "player1", Player: player}, {Sequence: 5, PlayerID: "player1", Stats: stats}}}, false, ReplicationAck{Sequence: 5}, true, true},
+		{"writes already applied", true, true, &ReplicationBatch{Sequence: 1, Entries: []ReplicationEntry{{Sequence: 2, PlayerID: "player1", Player: player}, {Sequence: 3, PlayerID: "player1", Player: player}}}, false, ReplicationAck{Sequence: 3}, false, true},
+		{"missing write", true, true, &ReplicationBatch{Sequence: 4, Entries: []ReplicationEntry{{Sequence: 5, PlayerID: "player1", Player: player}}}, false, ReplicationAck{Sequence: 3}, false, true},
+		{"retired player", true, true, &ReplicationBatch{Sequence: 3, Entries: []ReplicationEntry{{Sequence: 4, PlayerID: "player1", Player: player, Stats: stats}, {Sequence: 5, PlayerID: "player1", Redirect: &PlayerRedirect{PlayerID: "player1", MergedInto: "player0"}}}}, false, ReplicationAck{Sequence: 5}, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			ds := NewServer()
+			ds.playersDB.put(dbKey{ID: "player0"}, PlayerData{PlayerID: "player0", Level: 1, Energy: 50})
+			if test.follower {
+				err := ds.EnableFollower()
+				if err != nil {
+					t.Fatal(err)
+				}
+				ds.replica.synced = test.synced
+				ds.replica.sequence = 3
+			}
+
+			ack, err := ds.ApplyReplicationBatch(context.Background(), test.batch)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ApplyReplicationBatch() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if !test.wantErr && *ack != test.wantAck {
+				t.Errorf("ApplyReplicationBatch() gave incorrect results, want: %+v, got: %+v", test.wantAck, *ack)
+			}
+
+			gotPlayer, gotErr := ds.ReadPlayer(context.Background(), "player1")
+			if (gotErr == nil) != test.wantPlayer || (test.wantPlayer && !gotPlayer.Equal(*player)) {
+				t.Errorf("ApplyReplicationBatch() gave incorrect results, want player written: %v, got: %v (error: %v)", test.wantPlayer, gotPlayer, gotErr)
+			}
+
+			// a full copy replaces everything the follower held
+			_, gotOldEntry := ds.playersDB.get(dbKey{ID: "player0"})
+			if gotOldEntry != test.wantOldEntry {
+				t.Errorf("ApplyReplicationBatch() gave incorrect results, want the old entry kept: %v, got: %v", test.wantOldEntry, gotOldEntry)
+			}
+		})
+	}
+}
+
+func TestServer_Replication(t *testing.T) {
+
+	follower := NewServer()
+	err := follower.EnableFollower()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /data/replication-internal", follower.HandleReplicationRequest)
+	mux.HandleFunc("POST /data/player-internal", follower.HandleWritePlayerDataRequest)
+	mux.HandleFunc("GET /data/rating-leaderboard-internal", follower.HandleReadRatingLeaderboardRequest)
+
+	testServer := httptest.NewServer(follower.withReadOnly(mux))
+	defer testServer.Close()
+
+	// the players written before replication is enabled reach the follower in its first (full) copy
+	primary := NewServer()
+	err = primary.WritePlayer(context.Background(), &PlayerData{PlayerID: "player1", Level: 1, Energy: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = primary.EnableReplication([]string{testServer.URL + "/"}, 4, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 20 {
+		err = primary.WriteStats(context.Background(), &PlayerStatsWithID{PlayerID: fmt.Sprintf("player%v", i), PlayerStats: PlayerStats{Rating: int32(1000 + i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the follower catches up with the primary
+	deadline := time.Now().Add(5 * time.Second)
+	for primary.ReplicationLag() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status, err := follower.ReadReplicationStatus(context.Background())
+	if err != nil || status.Role != ReplicationRoleFollower || status.Sequence != 20 {
+		t.Fatalf("the follower did not catch up, want sequence: 20, got: %+v (error: %v)", status, err)
+	}
+
+	player, err := follower.ReadPlayer(context.Background(), "player1")
+	if err != nil || player.Energy != 50 {
+		t.Errorf("the follower gave incorrect results, want the player written before replication, got: %v (error: %v)", player, err)
+	}
+
+	replicaClient := &HTTPClient{baseURL: testServer.URL}
+	entries, err := replicaClient.ReadRatingLeaderboard(context.Background(), 2)
+	want := []RatingEntry{{PlayerID: "player19", Rating: 1019}, {PlayerID: "player18", Rating: 1018}}
+	if err != nil || !slices.Equal(entries, want) {
+		t.Errorf("ReadRatingLeaderboard() gave incorrect results from the follower, want: %v, got: %v (error: %v)", want, entries, err)
+	}
+
+	// a merge reaches the follower as the write of the primary player, and the retirement of the secondary one
+	err = primary.WritePlayer(context.Background(), &PlayerData{PlayerID: "player2", Level: 3, Energy: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = primary.MergePlayers(context.Background(), &PlayerMerge{
+		Expected:          PlayerData{PlayerID: "player1", Level: 1, Energy: 50},
+		ExpectedSecondary: PlayerData{PlayerID: "player2", Level: 3, Energy: 20},
+		Merged:            PlayerData{PlayerID: "player1", Level: 3, Energy: 70},
+		MergeTime:         100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for primary.ReplicationLag() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	player, err = follower.ReadPlayer(context.Background(), "player1")
+	if err != nil || player.Level != 3 || player.Energy != 70 {
+		t.Errorf("the follower gave incorrect results, want the merged player, got: %v (error: %v)", player, err)
+	}
+	_, err = follower.ReadPlayer(context.Background(), "player2")
+	if err != (PlayerNotFoundErr{PlayerID: "player2"}) {
+		t.Errorf("the follower should have removed the secondary player, got: %v", err)
+	}
+	_, err = follower.ReadStats(context.Background(), "player2")
+	if err != (PlayerStatsNotFoundErr{PlayerID: "player2"}) {
+		t.Errorf("the follower should have removed the stats of the secondary player, got: %v", err)
+	}
+	redirect, err := follower.ReadRedirect(context.Background(), "player2")
+	if err != nil || redirect.MergedInto != "player1" {
+		t.Errorf("the follower gave incorrect results, want the redirect of the secondary player, got: %+v (error: %v)", redirect, err)
+	}
+
+	// writes only reach the follower from its primary
+	err = replicaClient.WritePlayer(context.Background(), &PlayerData{PlayerID: "player2", Level: 1})
+	if err == nil {
+		t.Error("WritePlayer() gave incorrect results, want an error from the read only follower")
+	}
+
+	status, err = primary.ReadReplicationStatus(context.Background())
+	if err != nil || status.Role != ReplicationRolePrimary || len(status.Followers) != 1 || status.Followers[0].URL != testServer.URL {
+		t.Errorf("ReadReplicationStatus() gave incorrect results for the primary, got: %+v (error: %v)", status, err)
+	}
+}
+
+func TestServer_nextBatch(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		sequence      int64
+		reset         bool
+		wantNil       bool
+		wantReset     bool
+		wantSequences []int64
+	}{
+		{"caught up", 12, false, true, false, nil},
+		{"behind", 9, false, false, false, []int64{10, 11, 12}},
+		{"further behind than the log", 2, false, false, true, []int64{12, 12}},
+		{"reset", 12, true, false, true, []int64{12, 12}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			ds := NewServer()
+			ds.playersDB.put(dbKey{ID: "player1"}, PlayerData{PlayerID: "player1", Level: 1})
+			ds.statsDB.put(dbKey{ID: "player2"}, PlayerStats{Rating: 1000})
+
+			// the log holds the writes after the 4th one
+			ds.replication = &replicationLog{size: 8}
+			for range 12 {
+				ds.replicate(dbKey{ID: "player1"}, &PlayerData{PlayerID: "player1", Level: 1}, nil)
+			}
+			ds.replication.entries = ds.replication.entries[4:]
+
+			follower := &followerProgress{sequence: test.sequence, reset: test.reset}
+			batch := ds.nextBatch(follower)
+			if (batch == nil) != test.wantNil {
+				t.Fatalf("nextBatch() gave incorrect results, want nil: %v, got: %+v", test.wantNil, batch)
+			}
+			if test.wantNil {
+				return
+			}
+
+			var gotSequences []int64
+			for _, entry := range batch.Entries {
+				gotSequences = append(gotSequences, entry.Sequence)
+			}
+			if batch.Reset != test.wantReset || !slices.Equal(gotSequences, test.wantSequences) || follower.reset {
+				t.Errorf("nextBatch() gave incorrect results, want reset: %v, sequences: %v, got: %+v", test.wantReset, test.wantSequences, batch)
+			}
+		})
+	}
+}
+
+func TestMergeStats(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		primary   *PlayerStats
+		secondary *PlayerStats
+		want      PlayerStats
+	}{
+		{"no stats", nil, nil, PlayerStats{}},
+		{"only the secondary has stats",
+			nil,
+			&PlayerStats{LevelStats: []PlayerLevelStats{{1, 2, 1, 3}}, Rating: 1100, PrestigeCount: 1, WinStreak: 2},
+			PlayerStats{LevelStats: []PlayerLevelStats{{1, 2, 1, 3}}, Rating: 1100, PrestigeCount: 1},
+		},
+		{"both have stats",
+			&PlayerStats{LevelStats: []PlayerLevelStats{{1, 3, 0, 4}, {2, 0, 2, 0}}, Rating: 1000, WinStreak: 3},
+			&PlayerStats{LevelStats: []PlayerLevelStats{{1, 1, 1, 2}, {2, 1, 0, 5}, {3, 0, 1, 0}}, Rating: 1200, PrestigeCount: 2},
+			PlayerStats{LevelStats: []PlayerLevelStats{{1, 4, 1, 2}, {2, 1, 2, 5}, {3, 0, 1, 0}}, Rating: 1000, PrestigeCount: 2, WinStreak: 3},
+		},
+		{"a level only lost by the secondary keeps the best score of the primary",
+			&PlayerStats{LevelStats: []PlayerLevelStats{{1, 1, 0, 3}}},
+			&PlayerStats{LevelStats: []PlayerLevelStats{{1, 0, 4, 0}}},
+			PlayerStats{LevelStats: []PlayerLevelStats{{1, 1, 4, 3}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var before []PlayerLevelStats
+			if test.primary != nil {
+				before = slices.Clone(test.primary.LevelStats)
+			}
+
+			got := MergeStats(test.primary, test.secondary)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("MergeStats() gave incorrect results, want: %+v, got: %+v", test.want, got)
+			}
+			if test.primary != nil && !slices.Equal(test.primary.LevelStats, before) {
+				t.Errorf("MergeStats() changed the stats of the primary player")
+			}
+		})
+	}
+}
+
+func TestHTTPClient_MergePlayers(t *testing.T) {
+
+	ds := NewServer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/player-internal/{id}", ds.HandleReadPlayerDataRequest)
+	mux.HandleFunc("POST /data/player-merge-internal", ds.HandleMergePlayersRequest)
+	mux.HandleFunc("GET /data/redirect-internal/{id}", ds.HandleReadRedirectRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+	ctx := context.Background()
+
+	primary := PlayerData{PlayerID: "player1", Level: 2, Energy: 10, LastUpdateTime: 100}
+	secondary := PlayerData{PlayerID: "player2", Level: 4, Energy: 20, LastUpdateTime: 100}
+	merged := PlayerData{PlayerID: "player1", Level: 4, Energy: 30, LastUpdateTime: 200}
+	for _, player := range []PlayerData{primary, secondary, {PlayerID: "player3"}} {
+		err := ds.WritePlayer(ctx, &player)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for playerID, levelStats := range map[string][]PlayerLevelStats{"player1": {{1, 1, 0, 3}}, "player2": {{1, 2, 1, 2}}} {
+		err := ds.WriteStats(ctx, &PlayerStatsWithID{PlayerID: playerID, PlayerStats: PlayerStats{LevelStats: levelStats}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		merge   *PlayerMerge
+		wantErr error
+	}{
+		{"unknown secondary", &PlayerMerge{Expected: primary, ExpectedSecondary: PlayerData{PlayerID: "player4"}, Merged: merged}, PlayerNotFoundErr{PlayerID: "player4"}},
+		{"changed secondary", &PlayerMerge{Expected: primary, ExpectedSecondary: PlayerData{PlayerID: "player2"}, Merged: merged}, PlayerChangedErr{PlayerID: "player1"}},
+		{"merge", &PlayerMerge{Expected: primary, ExpectedSecondary: secondary, Merged: merged, MergeTime: 200}, nil},
+		{"merge again", &PlayerMerge{Expected: merged, ExpectedSecondary: secondary, Merged: merged}, PlayerRetiredErr{PlayerID: "player2"}},
+		{"merge into the retired player", &PlayerMerge{Expected: PlayerData{PlayerID: "player2"}, ExpectedSecondary: PlayerData{PlayerID: "player3"}, Merged: PlayerData{PlayerID: "player2"}}, PlayerRetiredErr{PlayerID: "player2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			result, err := hc.MergePlayers(ctx, test.merge)
+			if err != test.wantErr {
+				t.Fatalf("MergePlayers() gave incorrect error, want: %v, got: %v", test.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			want := &PlayerWithStats{Player: merged, Stats: PlayerStats{LevelStats: []PlayerLevelStats{{1, 3, 1, 2}}}}
+			if !result.Player.Equal(want.Player) || !reflect.DeepEqual(result.Stats.LevelStats, want.Stats.LevelStats) {
+				t.Errorf("MergePlayers() gave incorrect results, want: %+v, got: %+v", want, result)
+			}
+		})
+	}
+
+	// the secondary player and their stats are gone, and their id redirects to the primary player
+	_, err := ds.ReadPlayer(ctx, "player2")
+	if err != (PlayerNotFoundErr{PlayerID: "player2"}) {
+		t.Errorf("the secondary player should have been removed, got: %v", err)
+	}
+	_, err = ds.ReadStats(ctx, "player2")
+	if err != (PlayerStatsNotFoundErr{PlayerID: "player2"}) {
+		t.Errorf("the stats of the secondary player should have been removed, got: %v", err)
+	}
+
+	redirect, err := hc.ReadRedirect(ctx, "player2")
+	if err != nil || *redirect != (PlayerRedirect{PlayerID: "player2", MergedInto: "player1", MergeTime: 200}) {
+		t.Errorf("ReadRedirect() gave incorrect results, got: %+v (error: %v)", redirect, err)
+	}
+	_, err = hc.ReadRedirect(ctx, "player1")
+	if err != (RedirectNotFoundErr{PlayerID: "player1"}) {
+		t.Errorf("ReadRedirect() gave incorrect error, want: %v, got: %v", RedirectNotFoundErr{PlayerID: "player1"}, err)
+	}
+
+	// the redirects are part of the backups
+	snapshot, err := ds.TakeSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewServer()
+	err = restored.RestoreSnapshot(ctx, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = restored.ReadRedirect(ctx, "player2")
+	if err != nil {
+		t.Errorf("the redirect should have been restored, got: %v", err)
+	}
+}
+
+func TestServer_MergePlayers_Guilds(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name             string
+		primaryGuild     bool
+		secondaryOwns    bool
+		wantPrimaryGuild string
+		wantOwner        string
+		wantMembers      []string
+	}{
+		{"owner of the guild", false, true, "guild of player2", "player1", []string{"player1", "player3"}},
+		{"member of the guild", false, false, "guild of player3", "player3", []string{"player3", "player1"}},
+		{"owner, primary in another guild", true, true, "guild of player1", "player3", []string{"player3"}},
+		{"member, primary in another guild", true, false, "guild of player1", "player3", []string{"player3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			ds := NewServer()
+
+			primary := PlayerData{PlayerID: "player1", Level: 1}
+			secondary := PlayerData{PlayerID: "player2", Level: 1}
+			for _, player := range []PlayerData{primary, secondary} {
+				err := ds.WritePlayer(ctx, &player)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the guild of the secondary player, which player3 is also a member of
+			owner, member := "player3", "player2"
+			if test.secondaryOwns {
+				owner, member = "player2", "player3"
+			}
+			guild, err := ds.CreateGuild(ctx, &GuildCreation{Name: "guild of " + owner, OwnerID: owner, Time: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ds.JoinGuild(ctx, &GuildJoin{GuildID: guild.GuildID, PlayerID: member, Time: 200})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.primaryGuild {
+				_, err = ds.CreateGuild(ctx, &GuildCreation{Name: "guild of player1", OwnerID: "player1", Time: 300})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err = ds.MergePlayers(ctx, &PlayerMerge{Expected: primary, ExpectedSecondary: secondary, Merged: primary})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the retired player is in no guild, and the primary player is only in one
+			_, err = ds.ReadPlayerGuild(ctx, "player2")
+			if err != (NotInGuildErr{PlayerID: "player2"}) {
+				t.Errorf("the secondary player should not be in a guild after the merge, got: %v", err)
+			}
+			primaryGuild, err := ds.ReadPlayerGuild(ctx, "player1")
+			if err != nil || primaryGuild.Name != test.wantPrimaryGuild {
+				t.Errorf("incorrect guild of the primary player, want: %v, got: %+v (error: %v)", test.wantPrimaryGuild, primaryGuild, err)
+			}
+
+			// the guild of the secondary player keeps its other members
+			gotGuild, err := ds.ReadGuild(ctx, guild.GuildID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotMembers := []string{}
+			for _, guildMember := range gotGuild.Members {
+				gotMembers = append(gotMembers, guildMember.PlayerID)
+			}
+			if gotGuild.OwnerID != test.wantOwner || !reflect.DeepEqual(gotMembers, test.wantMembers) {
+				t.Errorf("incorrect guild after the merge, want owner: %v, members: %v, got owner: %v, members: %v", test.wantOwner, test.wantMembers, gotGuild.OwnerID, gotMembers)
+			}
+
+			// the primary player cannot join another guild while in one, but can leave it
+			_, err = ds.JoinGuild(ctx, &GuildJoin{GuildID: guild.GuildID, PlayerID: "player1", Time: 400})
+			if err != (AlreadyInGuildErr{PlayerID: "player1"}) {
+				t.Errorf("JoinGuild() gave incorrect error, want: %v, got: %v", AlreadyInGuildErr{PlayerID: "player1"}, err)
+			}
+			_, err = ds.LeaveGuild(ctx, "player1")
+			if err != nil {
+				t.Errorf("LeaveGuild() gave an unexpected error: %v", err)
+			}
+		})
+	}
+}
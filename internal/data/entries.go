@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+// the limits a level entry can be rejected by
+const (
+	EntryLimitCooldown = "cooldown"
+	EntryLimitDaily    = "daily"
+)
+
+// EntryLimitErr is returned when a level entry is rejected by one of the level's limits,
+// along with the (unix) time the player can enter the level again
+type EntryLimitErr struct {
+	PlayerID  string `json:"playerID"`
+	Level     int32  `json:"level"`
+	Limit     string `json:"limit"`
+	ResetTime int64  `json:"resetTime"`
+}
+
+func (err EntryLimitErr) Error() string {
+	return fmt.Sprintf("player id: %v cannot enter level %v before %v (%v limit)", err.PlayerID, err.Level, err.ResetTime, err.Limit)
+}
+
+// LevelEntryData keeps track of a player's entries into a level, for the level's entry limits:
+// the time of the last entry, and the number of entries on the (UTC) day it was made on
+type LevelEntryData struct {
+	PlayerID      string `json:"playerID"`
+	Level         int32  `json:"level"`
+	LastEntryTime int64  `json:"lastEntryTime"`
+	EntriesToday  int32  `json:"entriesToday"`
+}
+
+// LevelEntry is used as the request body for the internal request to record a level entry,
+// which is only recorded if the given limits of the level allow it (a limit of 0 is no limit)
+type LevelEntry struct {
+	PlayerID          string `json:"playerID"`
+	Level             int32  `json:"level"`
+	Time              int64  `json:"time"`
+	CooldownSeconds   int64  `json:"cooldownSeconds"`
+	MaxAttemptsPerDay int32  `json:"maxAttemptsPerDay"`
+}
+
+// HandleRecordLevelEntryRequest records the given level entry if the level's limits allow it, responding with
+// the player's updated entries into the level, or with the limit which rejected the entry (as too many requests)
+func (ds *Server) HandleRecordLevelEntryRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a LevelEntry struct
+	decodedReq := &LevelEntry{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := ds.RecordLevelEntry(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not record level entry: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case EntryLimitErr:
+			// the limit goes in the body, so the client can tell the player when they can enter again
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(err)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, entries, "level entries")
+}
+
+// RecordLevelEntry records the given level entry, unless the cooldown since the player's last entry into the level
+// has not passed yet, or the player already entered the level as many times as allowed today (UTC)
+func (ds *Server) RecordLevelEntry(ctx context.Context, entry *LevelEntry) (*LevelEntryData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.RecordLevelEntry")
+	defer span.End()
+
+	if entry == nil || entry.PlayerID == "" {
+		return nil, fmt.Errorf("cannot record a level entry without a player id")
+	}
+
+	ds.attemptsMutex.Lock()
+	defer ds.attemptsMutex.Unlock()
+
+	key := keyOf(ctx, entry.PlayerID)
+
+	index := -1
+	current := LevelEntryData{PlayerID: entry.PlayerID, Level: entry.Level}
+	for i, levelEntries := range ds.entriesDB[key] {
+		if levelEntries.Level == entry.Level {
+			index, current = i, levelEntries
+			break
+		}
+	}
+
+	// entries of an earlier day are not counted
+	today := entry.Time / secondsPerDay
+	if current.LastEntryTime/secondsPerDay != today {
+		current.EntriesToday = 0
+	}
+
+	if index >= 0 && entry.CooldownSeconds > 0 && entry.Time < current.LastEntryTime+entry.CooldownSeconds {
+		return nil, EntryLimitErr{PlayerID: entry.PlayerID, Level: entry.Level, Limit: EntryLimitCooldown, ResetTime: current.LastEntryTime + entry.CooldownSeconds}
+	}
+
+	if entry.MaxAttemptsPerDay > 0 && current.EntriesToday >= entry.MaxAttemptsPerDay {
+		return nil, EntryLimitErr{PlayerID: entry.PlayerID, Level: entry.Level, Limit: EntryLimitDaily, ResetTime: (today + 1) * secondsPerDay}
+	}
+
+	ds.logger.Printf("recording entry into level %v for id: %v", entry.Level, entry.PlayerID)
+
+	current.LastEntryTime = entry.Time
+	current.EntriesToday++
+
+	if index >= 0 {
+		ds.entriesDB[key][index] = current
+	} else {
+		ds.entriesDB[key] = append(ds.entriesDB[key], current)
+	}
+
+	return &current, nil
+}
@@ -0,0 +1,582 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Event sourcing related errors:
+var eventSourcingDisabledError = fmt.Errorf("event sourcing is not enabled")
+
+type PlayerEventsNotFoundErr struct {
+	PlayerID string
+}
+
+func (err PlayerEventsNotFoundErr) Error() string {
+	return fmt.Sprintf("no events for id: %v were found in the event streams", err.PlayerID)
+}
+
+// the types of the player events
+const (
+	EventPlayerCreated  = "PlayerCreated"  // a new player was written, the event has the whole player
+	EventPlayerImported = "PlayerImported" // the first change of a player written before event sourcing was enabled, the event has the player as it was
+	EventLevelUnlocked  = "LevelUnlocked"  // the event has the new level
+	EventEnergySpent    = "EnergySpent"    // the event has the new energy and last update time, and the (negative) energy delta
+	EventEnergyGained   = "EnergyGained"   // regenerated or granted energy, the event has the same fields as EnergySpent
+	EventBoostsChanged  = "BoostsChanged"  // the event has the new boosts
+	EventEnergyBanked   = "EnergyBanked"   // energy was put in or claimed from the energy bank, the event has the new banked energy
+	EventFTUEProgressed = "FTUEProgressed" // the first time user experience was advanced or reset, the event has the new step
+	EventPrestiged      = "Prestiged"      // the player reset to the default level from the last one, the event has the new prestige rank
+	EventStatsImported  = "StatsImported"  // the first change of stats written before event sourcing was enabled, the event has the stats as they were
+	EventStatsUpdated   = "StatsUpdated"   // the event has the changed (or new) level stats, the new rating and the new prestige count
+	EventPlayerMerged   = "PlayerMerged"   // the player (and their stats) were merged into another player, and their id retired, the event has the id of the other player
+)
+
+// PlayerEvent is a single change to a player's data or stats in the player's event stream, the sequence numbers
+// of the events of a player start at 1. Only the fields used by the type of the event are set
+type PlayerEvent struct {
+	Sequence int64  `json:"sequence"`
+	Type     string `json:"type"`
+	Time     int64  `json:"time"` // unix time the event was recorded at
+
+	Player         *PlayerData        `json:"player,omitempty"`
+	Level          int32              `json:"level,omitempty"`
+	Energy         int32              `json:"energy,omitempty"`
+	EnergyDelta    int32              `json:"energyDelta,omitempty"`
+	LastUpdateTime int64              `json:"lastUpdateTime,omitempty"`
+	Boosts         []EnergyBoost      `json:"boosts,omitempty"`
+	BankedEnergy   int32              `json:"bankedEnergy,omitempty"`
+	FTUEStep       int32              `json:"ftueStep,omitempty"`
+	PrestigeRank   int32              `json:"prestigeRank,omitempty"`
+	Stats          *PlayerStats       `json:"stats,omitempty"`
+	LevelStats     []PlayerLevelStats `json:"levelStats,omitempty"`
+	Rating         int32              `json:"rating,omitempty"`
+	PrestigeCount  int32              `json:"prestigeCount,omitempty"`
+	WinStreak      int32              `json:"winStreak,omitempty"`
+	MergedInto     string             `json:"mergedInto,omitempty"`
+}
+
+// PlayerState is the state of a player (data and stats) after the event with the given sequence number,
+// the player and stats are nil if they had not been written yet (or the player was merged into another one)
+type PlayerState struct {
+	PlayerID   string       `json:"playerID"`
+	Sequence   int64        `json:"sequence"`
+	Time       int64        `json:"time"`
+	Player     *PlayerData  `json:"player,omitempty"`
+	Stats      *PlayerStats `json:"stats,omitempty"`
+	MergedInto string       `json:"mergedInto,omitempty"`
+}
+
+// PlayerEventStream is the event stream of a player, along with the snapshots of the player's state
+// (used in backups)
+type PlayerEventStream struct {
+	PlayerID  string        `json:"playerID"`
+	Events    []PlayerEvent `json:"events"`
+	Snapshots []PlayerState `json:"snapshots,omitempty"`
+}
+
+// playerEventStream is the event stream of a player in memory, with the current state (after the last event)
+type playerEventStream struct {
+	events    []PlayerEvent
+	snapshots []PlayerState
+	state     PlayerState
+}
+
+// clone returns a copy of the event which does not share anything with the original
+func (event PlayerEvent) clone() PlayerEvent {
+	if event.Player != nil {
+		player := event.Player.Clone()
+		event.Player = &player
+	}
+	if event.Stats != nil {
+		event.Stats = copyStats(*event.Stats)
+	}
+	event.Boosts = slices.Clone(event.Boosts)
+	event.LevelStats = copyLevelStats(event.LevelStats)
+	return event
+}
+
+// clone returns a copy of the state which does not share anything with the original
+func (state PlayerState) clone() PlayerState {
+	if state.Player != nil {
+		player := state.Player.Clone()
+		state.Player = &player
+	}
+	if state.Stats != nil {
+		state.Stats = copyStats(*state.Stats)
+	}
+	return state
+}
+
+// apply changes the state with the given event
+func (state *PlayerState) apply(event PlayerEvent) {
+
+	state.Sequence = event.Sequence
+	state.Time = event.Time
+
+	// changes are only recorded after the player / stats were created (or imported), but streams from
+	// backups are not checked for that, so changes of a missing player / stats start from blank ones
+	switch event.Type {
+	case EventLevelUnlocked, EventEnergySpent, EventEnergyGained, EventBoostsChanged, EventEnergyBanked, EventFTUEProgressed, EventPrestiged:
+		if state.Player == nil {
+			state.Player = &PlayerData{PlayerID: state.PlayerID, Version: PlayerDataVersion}
+		}
+	case EventStatsUpdated:
+		if state.Stats == nil {
+			state.Stats = &PlayerStats{Version: PlayerStatsVersion}
+		}
+	}
+
+	switch event.Type {
+	case EventPlayerCreated, EventPlayerImported:
+		player := event.Player.Clone()
+		state.Player = &player
+
+	case EventLevelUnlocked:
+		state.Player.Level = event.Level
+
+	case EventEnergySpent, EventEnergyGained:
+		state.Player.Energy = event.Energy
+		state.Player.LastUpdateTime = event.LastUpdateTime
+
+	case EventBoostsChanged:
+		state.Player.Boosts = slices.Clone(event.Boosts)
+
+	case EventEnergyBanked:
+		state.Player.BankedEnergy = event.BankedEnergy
+
+	case EventFTUEProgressed:
+		state.Player.FTUEStep = event.FTUEStep
+
+	case EventPrestiged:
+		state.Player.PrestigeRank = event.PrestigeRank
+
+	case EventStatsImported:
+		state.Stats = copyStats(*event.Stats)
+
+	case EventStatsUpdated:
+		for _, levelStats := range event.LevelStats {
+			i := slices.IndexFunc(state.Stats.LevelStats, func(existing PlayerLevelStats) bool { return existing.Level == levelStats.Level })
+			if i < 0 {
+				state.Stats.LevelStats = append(state.Stats.LevelStats, levelStats)
+			} else {
+				state.Stats.LevelStats[i] = levelStats
+			}
+		}
+		state.Stats.Rating = event.Rating
+		state.Stats.PrestigeCount = event.PrestigeCount
+		state.Stats.WinStreak = event.WinStreak
+
+	case EventPlayerMerged:
+		state.Player, state.Stats = nil, nil
+		state.MergedInto = event.MergedInto
+	}
+}
+
+// append adds the given event to the stream (setting its sequence number and time), and takes a snapshot
+// of the state after every PlayerEventSnapshotInterval events
+func (stream *playerEventStream) append(event PlayerEvent, unixTime int64) {
+
+	event.Sequence = stream.state.Sequence + 1
+	event.Time = unixTime
+
+	stream.events = append(stream.events, event)
+	stream.state.apply(event)
+
+	if event.Sequence%constants.PlayerEventSnapshotInterval == 0 {
+		stream.snapshots = append(stream.snapshots, stream.state.clone())
+	}
+}
+
+// EnableEventSourcingFromEnv enables event sourcing if the event sourcing environment variable is set to true
+// (see constants.EventSourcingEnvVar), it stays disabled otherwise
+func (ds *Server) EnableEventSourcingFromEnv() error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	enabledEnv := os.Getenv(constants.EventSourcingEnvVar)
+	if enabledEnv == "" {
+		return nil
+	}
+
+	enabled, err := strconv.ParseBool(enabledEnv)
+	if err != nil {
+		return fmt.Errorf("%v should be true or false, got: %v", constants.EventSourcingEnvVar, enabledEnv)
+	}
+
+	if enabled {
+		ds.EnableEventSourcing()
+	}
+	return nil
+}
+
+// EnableEventSourcing starts appending every change to the data or stats of a player to the event stream of the player.
+// The players and stats DBs are still kept up to date with every change, and serve all the reads as before
+func (ds *Server) EnableEventSourcing() {
+
+	if ds == nil {
+		return
+	}
+
+	ds.eventsMutex.Lock()
+	defer ds.eventsMutex.Unlock()
+
+	if ds.eventStreams == nil {
+		ds.eventStreams = map[dbKey]*playerEventStream{}
+	}
+
+	ds.logger.Println("event sourcing enabled")
+}
+
+// streamOf returns the event stream of the given key, creating it if needed (the events mutex should be held by the caller)
+func (ds *Server) streamOf(key dbKey) *playerEventStream {
+
+	stream, ok := ds.eventStreams[key]
+	if !ok {
+		stream = &playerEventStream{state: PlayerState{PlayerID: key.ID}}
+		ds.eventStreams[key] = stream
+	}
+	return stream
+}
+
+// recordPlayerChange appends the events of the change from the old player data (nil for a new player) to the updated one,
+// if event sourcing is enabled (the players mutex should be held by the caller, so the events are in the order of the writes)
+func (ds *Server) recordPlayerChange(key dbKey, old *PlayerData, updated PlayerData) {
+
+	ds.eventsMutex.Lock()
+	defer ds.eventsMutex.Unlock()
+
+	if ds.eventStreams == nil {
+		return
+	}
+
+	unixNow := time.Now().UTC().Unix()
+	stream := ds.streamOf(key)
+
+	if old == nil {
+		player := updated.Clone()
+		stream.append(PlayerEvent{Type: EventPlayerCreated, Player: &player}, unixNow)
+		return
+	}
+
+	if stream.state.Player == nil {
+		player := old.Clone()
+		stream.append(PlayerEvent{Type: EventPlayerImported, Player: &player}, unixNow)
+	}
+
+	if updated.Level != old.Level {
+		stream.append(PlayerEvent{Type: EventLevelUnlocked, Level: updated.Level}, unixNow)
+	}
+
+	if updated.Energy != old.Energy || updated.LastUpdateTime != old.LastUpdateTime {
+		eventType := EventEnergyGained
+		if updated.Energy < old.Energy {
+			eventType = EventEnergySpent
+		}
+		stream.append(PlayerEvent{Type: eventType, Energy: updated.Energy, EnergyDelta: updated.Energy - old.Energy, LastUpdateTime: updated.LastUpdateTime}, unixNow)
+	}
+
+	if (len(updated.Boosts) > 0 || len(old.Boosts) > 0) && !slices.Equal(updated.Boosts, old.Boosts) {
+		stream.append(PlayerEvent{Type: EventBoostsChanged, Boosts: slices.Clone(updated.Boosts)}, unixNow)
+	}
+
+	if updated.BankedEnergy != old.BankedEnergy {
+		stream.append(PlayerEvent{Type: EventEnergyBanked, BankedEnergy: updated.BankedEnergy}, unixNow)
+	}
+
+	if updated.FTUEStep != old.FTUEStep {
+		stream.append(PlayerEvent{Type: EventFTUEProgressed, FTUEStep: updated.FTUEStep}, unixNow)
+	}
+
+	if updated.PrestigeRank != old.PrestigeRank {
+		stream.append(PlayerEvent{Type: EventPrestiged, PrestigeRank: updated.PrestigeRank}, unixNow)
+	}
+}
+
+// recordStatsChange appends the events of the change from the old player stats (nil for new stats) to the updated ones,
+// if event sourcing is enabled (the stats mutex should be held by the caller, so the events are in the order of the writes)
+func (ds *Server) recordStatsChange(key dbKey, old *PlayerStats, updated PlayerStats) {
+
+	ds.eventsMutex.Lock()
+	defer ds.eventsMutex.Unlock()
+
+	if ds.eventStreams == nil {
+		return
+	}
+
+	unixNow := time.Now().UTC().Unix()
+	stream := ds.streamOf(key)
+
+	if old != nil && stream.state.Stats == nil {
+		stream.append(PlayerEvent{Type: EventStatsImported, Stats: copyStats(*old)}, unixNow)
+	}
+
+	// level stats are only ever added or updated, so only the ones which changed go in the event
+	var changed []PlayerLevelStats
+	for _, levelStats := range updated.LevelStats {
+		if old == nil || !slices.Contains(old.LevelStats, levelStats) {
+			changed = append(changed, levelStats)
+		}
+	}
+
+	if old == nil || len(changed) > 0 || updated.Rating != old.Rating || updated.PrestigeCount != old.PrestigeCount || updated.WinStreak != old.WinStreak {
+		stream.append(PlayerEvent{Type: EventStatsUpdated, LevelStats: changed, Rating: updated.Rating, PrestigeCount: updated.PrestigeCount, WinStreak: updated.WinStreak}, unixNow)
+	}
+}
+
+// recordPlayerMerge appends the event of the merge of the player of the given key into the given player, which ends their
+// stream, if event sourcing is enabled (the players mutex should be held by the caller, so the events are in the order of the writes)
+func (ds *Server) recordPlayerMerge(key dbKey, mergedInto string) {
+
+	ds.eventsMutex.Lock()
+	defer ds.eventsMutex.Unlock()
+
+	if ds.eventStreams == nil {
+		return
+	}
+
+	ds.streamOf(key).append(PlayerEvent{Type: EventPlayerMerged, MergedInto: mergedInto}, time.Now().UTC().Unix())
+}
+
+// ReadPlayerEvents returns a copy of the events of the requested player ID after the given sequence number
+func (ds *Server) ReadPlayerEvents(ctx context.Context, playerID string, afterSequence int64) ([]PlayerEvent, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadPlayerEvents")
+	defer span.End()
+
+	ds.eventsMutex.Lock()
+	defer ds.eventsMutex.Unlock()
+
+	if ds.eventStreams == nil {
+		return nil, eventSourcingDisabledError
+	}
+
+	stream, ok := ds.eventStreams[keyOf(ctx, playerID)]
+	if !ok {
+		return nil, PlayerEventsNotFoundErr{playerID}
+	}
+
+	events := []PlayerEvent{}
+	for _, event := range stream.events[min(max(afterSequence, 0), int64(len(stream.events))):] {
+		events = append(events, event.clone())
+	}
+
+	return events, nil
+}
+
+// ReadPlayerState returns the state of the requested player ID as of the given unix time (the latest state for a time of 0),
+// it is rebuilt from the latest snapshot before that time, and the events after it
+func (ds *Server) ReadPlayerState(ctx context.Context, playerID string, unixTime int64) (*PlayerState, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadPlayerState")
+	defer span.End()
+
+	ds.eventsMutex.Lock()
+	defer ds.eventsMutex.Unlock()
+
+	if ds.eventStreams == nil {
+		return nil, eventSourcingDisabledError
+	}
+
+	stream, ok := ds.eventStreams[keyOf(ctx, playerID)]
+	if !ok {
+		return nil, PlayerEventsNotFoundErr{playerID}
+	}
+
+	if unixTime <= 0 {
+		state := stream.state.clone()
+		return &state, nil
+	}
+
+	state := PlayerState{PlayerID: playerID}
+	for i := len(stream.snapshots) - 1; i >= 0; i-- {
+		if stream.snapshots[i].Time <= unixTime {
+			state = stream.snapshots[i].clone()
+			break
+		}
+	}
+
+	for _, event := range stream.events[state.Sequence:] {
+		if event.Time > unixTime {
+			break
+		}
+		state.apply(event.clone())
+	}
+
+	return &state, nil
+}
+
+// RecomputeStats rebuilds the stats of the requested player ID from all the events of the player,
+// and writes them to the stats DB entry of the player (without adding an event)
+func (ds *Server) RecomputeStats(ctx context.Context, playerID string) (*PlayerStats, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.RecomputeStats")
+	defer span.End()
+
+	key := keyOf(ctx, playerID)
+
+	// archived players are brought back to memory on access
+	err := ds.rehydrate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	shard := ds.statsDB.shardOf(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	ds.eventsMutex.Lock()
+	defer ds.eventsMutex.Unlock()
+
+	if ds.eventStreams == nil {
+		return nil, eventSourcingDisabledError
+	}
+
+	stream, ok := ds.eventStreams[key]
+	if !ok {
+		return nil, PlayerEventsNotFoundErr{playerID}
+	}
+
+	state := PlayerState{PlayerID: playerID}
+	for _, event := range stream.events {
+		state.apply(event.clone())
+	}
+
+	if state.Stats == nil {
+		return nil, PlayerStatsNotFoundErr{playerID}
+	}
+
+	ds.logger.Printf("recomputed the stats of id: %v from %v events", playerID, len(stream.events))
+	if old, ok := shard.entries[key]; ok {
+		ds.stampStatsChanges(key, &old, *state.Stats)
+		ds.levelIndex.update(key, &old, state.Stats)
+	} else {
+		ds.stampStatsChanges(key, nil, *state.Stats)
+		ds.levelIndex.update(key, nil, state.Stats)
+	}
+	shard.entries[key] = *copyStats(*state.Stats)
+	ds.replicate(key, nil, state.Stats)
+
+	return state.Stats, nil
+}
+
+// HandleReadPlayerEventsRequest responds with the events of the requested player, the after query parameter
+// (a sequence number) leaves out the earlier events
+func (ds *Server) HandleReadPlayerEventsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	afterSequence := int64(0)
+	if afterParam := r.URL.Query().Get("after"); afterParam != "" {
+		var err error
+		afterSequence, err = strconv.ParseInt(afterParam, 10, 64)
+		if err != nil || afterSequence < 0 {
+			errMsg := "error: invalid after sequence in request"
+			ds.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := ds.ReadPlayerEvents(r.Context(), r.PathValue("id"), afterSequence)
+	if err != nil {
+		errMsg := "error: could not read player events: " + err.Error()
+		ds.logger.Println(errMsg)
+		writeEventsError(w, err, errMsg)
+		return
+	}
+
+	ds.writeJSON(w, events, "player events")
+}
+
+// HandleReadPlayerStateRequest responds with the state of the requested player as of the unix time in the at query
+// parameter (or the latest state without it)
+func (ds *Server) HandleReadPlayerStateRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	unixTime := int64(0)
+	if atParam := r.URL.Query().Get("at"); atParam != "" {
+		var err error
+		unixTime, err = strconv.ParseInt(atParam, 10, 64)
+		if err != nil || unixTime <= 0 {
+			errMsg := "error: invalid at time in request"
+			ds.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	state, err := ds.ReadPlayerState(r.Context(), r.PathValue("id"), unixTime)
+	if err != nil {
+		errMsg := "error: could not read player state: " + err.Error()
+		ds.logger.Println(errMsg)
+		writeEventsError(w, err, errMsg)
+		return
+	}
+
+	ds.writeJSON(w, state, "player state")
+}
+
+// HandleRecomputeStatsRequest rebuilds the stats of the requested player from the player's events,
+// and responds with the recomputed stats
+func (ds *Server) HandleRecomputeStatsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plStats, err := ds.RecomputeStats(r.Context(), r.PathValue("id"))
+	if err != nil {
+		errMsg := "error: could not recompute stats: " + err.Error()
+		ds.logger.Println(errMsg)
+		writeEventsError(w, err, errMsg)
+		return
+	}
+
+	ds.writeJSON(w, plStats, "player stats")
+}
+
+// writeEventsError responds with the status matching the given event sourcing error
+func writeEventsError(w http.ResponseWriter, err error, errMsg string) {
+
+	var eventsNotFoundErr PlayerEventsNotFoundErr
+	var statsNotFoundErr PlayerStatsNotFoundErr
+	switch {
+	case errors.Is(err, eventSourcingDisabledError):
+		http.Error(w, errMsg, http.StatusServiceUnavailable)
+	case errors.As(err, &eventsNotFoundErr), errors.As(err, &statsNotFoundErr):
+		http.Error(w, errMsg, http.StatusNotFound)
+	default:
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
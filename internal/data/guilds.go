@@ -0,0 +1,532 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type GuildNotFoundErr struct {
+	GuildID string
+}
+
+func (err GuildNotFoundErr) Error() string {
+	return fmt.Sprintf("guild id: %v was not found in the guilds DB", err.GuildID)
+}
+
+type GuildNameTakenErr struct {
+	Name string
+}
+
+func (err GuildNameTakenErr) Error() string {
+	return fmt.Sprintf("guild name: %v is already taken", err.Name)
+}
+
+type AlreadyInGuildErr struct {
+	PlayerID string
+}
+
+func (err AlreadyInGuildErr) Error() string {
+	return fmt.Sprintf("player id: %v is already in a guild", err.PlayerID)
+}
+
+type NotInGuildErr struct {
+	PlayerID string
+}
+
+func (err NotInGuildErr) Error() string {
+	return fmt.Sprintf("player id: %v is not in a guild", err.PlayerID)
+}
+
+type GuildFullErr struct {
+	GuildID string
+}
+
+func (err GuildFullErr) Error() string {
+	return fmt.Sprintf("guild id: %v has no room for new members", err.GuildID)
+}
+
+// GuildMember is a member of a guild, and the (unix) time they joined it
+type GuildMember struct {
+	PlayerID string `json:"playerID"`
+	JoinTime int64  `json:"joinTime"`
+}
+
+// GuildData is a guild and its member roster (in the order the members joined),
+// the owner is always one of the members
+type GuildData struct {
+	GuildID     string        `json:"guildID"`
+	Name        string        `json:"name"`
+	OwnerID     string        `json:"ownerID"`
+	CreatedTime int64         `json:"createdTime"`
+	Members     []GuildMember `json:"members"`
+}
+
+// GuildCreation is used as the request body for the internal request to create a guild (owned by the given player)
+type GuildCreation struct {
+	Name    string `json:"name"`
+	OwnerID string `json:"ownerID"`
+	Time    int64  `json:"time"`
+}
+
+// GuildJoin is used as the request body for the internal request for a player to join a guild,
+// a max members of 0 means the guild has no size limit
+type GuildJoin struct {
+	GuildID    string `json:"guildID"`
+	PlayerID   string `json:"playerID"`
+	Time       int64  `json:"time"`
+	MaxMembers int32  `json:"maxMembers"`
+}
+
+// Clone returns a copy of the guild data which does not share its member roster
+func (gd GuildData) Clone() GuildData {
+	gd.Members = slices.Clone(gd.Members)
+	return gd
+}
+
+// normalizeGuildName returns the form guild names are compared in (names are unique regardless of case)
+func normalizeGuildName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// newGuildID returns a random guild id
+func newGuildID() string {
+
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+
+	return "guild-" + hex.EncodeToString(randomBytes)
+}
+
+// HandleCreateGuildRequest creates the guild in the request body, responding with the new guild
+func (ds *Server) HandleCreateGuildRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a GuildCreation struct
+	decodedReq := &GuildCreation{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	guild, err := ds.CreateGuild(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not create guild: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case GuildNameTakenErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		case AlreadyInGuildErr:
+			http.Error(w, errMsg, http.StatusForbidden)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, guild, "guild")
+}
+
+// HandleJoinGuildRequest adds the player in the request body to the guild, responding with the updated guild
+func (ds *Server) HandleJoinGuildRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a GuildJoin struct
+	decodedReq := &GuildJoin{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	guild, err := ds.JoinGuild(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not join guild: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case GuildNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case AlreadyInGuildErr:
+			http.Error(w, errMsg, http.StatusForbidden)
+		case GuildFullErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, guild, "guild")
+}
+
+// HandleLeaveGuildRequest removes the requested player from their guild, responding with the guild they left
+func (ds *Server) HandleLeaveGuildRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	guild, err := ds.LeaveGuild(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not leave guild: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case NotInGuildErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, guild, "guild")
+}
+
+// HandleReadGuildRequest responds with the requested guild
+func (ds *Server) HandleReadGuildRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	guild, err := ds.ReadGuild(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not read guild: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+
+	ds.writeJSON(w, guild, "guild")
+}
+
+// HandleReadPlayerGuildRequest responds with the guild of the requested player
+func (ds *Server) HandleReadPlayerGuildRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	guild, err := ds.ReadPlayerGuild(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not read player guild: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+
+	ds.writeJSON(w, guild, "guild")
+}
+
+// HandleListGuildsRequest responds with all the guilds (of the namespace)
+func (ds *Server) HandleListGuildsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	guilds, err := ds.ListGuilds(r.Context())
+	if err != nil {
+		errMsg := "error: could not list guilds: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	ds.writeJSON(w, guilds, "guilds")
+}
+
+// CreateGuild creates a new guild (with a new, unique id) with the given name, and its owner as its first member.
+// Guild names are unique regardless of case, and players can only be in one guild at a time
+func (ds *Server) CreateGuild(ctx context.Context, creation *GuildCreation) (*GuildData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.CreateGuild")
+	defer span.End()
+
+	if creation == nil || creation.OwnerID == "" || normalizeGuildName(creation.Name) == "" {
+		return nil, fmt.Errorf("cannot create a guild without a name and an owner")
+	}
+
+	ds.guildsMutex.Lock()
+	defer ds.guildsMutex.Unlock()
+
+	nameKey := keyOf(ctx, normalizeGuildName(creation.Name))
+	if _, taken := ds.guildNamesDB[nameKey]; taken {
+		return nil, GuildNameTakenErr{creation.Name}
+	}
+
+	ownerKey := keyOf(ctx, creation.OwnerID)
+	if _, inGuild := ds.guildMembersDB[ownerKey]; inGuild {
+		return nil, AlreadyInGuildErr{creation.OwnerID}
+	}
+
+	guildID := newGuildID()
+	for {
+		if _, taken := ds.guildsDB[keyOf(ctx, guildID)]; !taken {
+			break
+		}
+		guildID = newGuildID()
+	}
+
+	ds.logger.Printf("creating guild id: %v owned by id: %v", guildID, creation.OwnerID)
+
+	guild := GuildData{
+		GuildID:     guildID,
+		Name:        strings.TrimSpace(creation.Name),
+		OwnerID:     creation.OwnerID,
+		CreatedTime: creation.Time,
+		Members:     []GuildMember{{PlayerID: creation.OwnerID, JoinTime: creation.Time}},
+	}
+
+	ds.guildsDB[keyOf(ctx, guildID)] = guild
+	ds.guildNamesDB[nameKey] = guildID
+	ds.guildMembersDB[ownerKey] = guildID
+
+	guild = guild.Clone()
+	return &guild, nil
+}
+
+// JoinGuild adds the given player to the given guild, unless they are in a guild already, or the guild is full
+func (ds *Server) JoinGuild(ctx context.Context, join *GuildJoin) (*GuildData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.JoinGuild")
+	defer span.End()
+
+	if join == nil || join.PlayerID == "" {
+		return nil, fmt.Errorf("cannot join a guild without a player id")
+	}
+
+	ds.guildsMutex.Lock()
+	defer ds.guildsMutex.Unlock()
+
+	guildKey := keyOf(ctx, join.GuildID)
+	guild, ok := ds.guildsDB[guildKey]
+	if !ok {
+		return nil, GuildNotFoundErr{join.GuildID}
+	}
+
+	playerKey := keyOf(ctx, join.PlayerID)
+	if _, inGuild := ds.guildMembersDB[playerKey]; inGuild {
+		return nil, AlreadyInGuildErr{join.PlayerID}
+	}
+
+	if join.MaxMembers > 0 && int32(len(guild.Members)) >= join.MaxMembers {
+		return nil, GuildFullErr{join.GuildID}
+	}
+
+	ds.logger.Printf("adding id: %v to guild id: %v", join.PlayerID, join.GuildID)
+
+	guild.Members = append(slices.Clone(guild.Members), GuildMember{PlayerID: join.PlayerID, JoinTime: join.Time})
+	ds.guildsDB[guildKey] = guild
+	ds.guildMembersDB[playerKey] = join.GuildID
+
+	guild = guild.Clone()
+	return &guild, nil
+}
+
+// LeaveGuild removes the given player from their guild, and returns the guild as it is after they left.
+// If the owner leaves, the longest standing member becomes the owner, and a guild with no members left is deleted
+func (ds *Server) LeaveGuild(ctx context.Context, playerID string) (*GuildData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.LeaveGuild")
+	defer span.End()
+
+	ds.guildsMutex.Lock()
+	defer ds.guildsMutex.Unlock()
+
+	guild, ok := ds.removeGuildMember(ctx, playerID)
+	if !ok {
+		return nil, NotInGuildErr{playerID}
+	}
+
+	return guild, nil
+}
+
+// removeGuildMember removes the given player from their guild (if they are in one), handing the ownership over or
+// deleting the guild like LeaveGuild, and returns the guild as it is after they left (it should be called with the
+// guilds mutex held)
+func (ds *Server) removeGuildMember(ctx context.Context, playerID string) (*GuildData, bool) {
+
+	playerKey := keyOf(ctx, playerID)
+	guildID, ok := ds.guildMembersDB[playerKey]
+	if !ok {
+		return nil, false
+	}
+
+	ds.logger.Printf("removing id: %v from guild id: %v", playerID, guildID)
+
+	guildKey := keyOf(ctx, guildID)
+	guild := ds.guildsDB[guildKey]
+	guild.Members = slices.DeleteFunc(slices.Clone(guild.Members), func(member GuildMember) bool { return member.PlayerID == playerID })
+	delete(ds.guildMembersDB, playerKey)
+
+	if len(guild.Members) == 0 {
+		ds.logger.Printf("deleting guild id: %v, which has no members left", guildID)
+		delete(ds.guildsDB, guildKey)
+		delete(ds.guildNamesDB, keyOf(ctx, normalizeGuildName(guild.Name)))
+		return &guild, true
+	}
+
+	if guild.OwnerID == playerID {
+		guild.OwnerID = guild.Members[0].PlayerID
+	}
+	ds.guildsDB[guildKey] = guild
+
+	guild = guild.Clone()
+	return &guild, true
+}
+
+// mergeGuildMember hands the guild membership of the secondary player of a merge over to the primary player, who takes
+// their place in the roster (and the ownership of the guild, if the secondary player owned it). When the primary
+// player is in a guild already, the secondary player just leaves their guild (it should be called with the guilds
+// mutex held)
+func (ds *Server) mergeGuildMember(ctx context.Context, primaryID string, secondaryID string) {
+
+	secondaryKey := keyOf(ctx, secondaryID)
+	guildID, ok := ds.guildMembersDB[secondaryKey]
+	if !ok {
+		return
+	}
+
+	primaryKey := keyOf(ctx, primaryID)
+	if _, inGuild := ds.guildMembersDB[primaryKey]; inGuild {
+		ds.removeGuildMember(ctx, secondaryID)
+		return
+	}
+
+	ds.logger.Printf("handing the membership of guild id: %v from id: %v to id: %v", guildID, secondaryID, primaryID)
+
+	guildKey := keyOf(ctx, guildID)
+	guild := ds.guildsDB[guildKey].Clone()
+	for i := range guild.Members {
+		if guild.Members[i].PlayerID == secondaryID {
+			guild.Members[i].PlayerID = primaryID
+		}
+	}
+	if guild.OwnerID == secondaryID {
+		guild.OwnerID = primaryID
+	}
+
+	ds.guildsDB[guildKey] = guild
+	delete(ds.guildMembersDB, secondaryKey)
+	ds.guildMembersDB[primaryKey] = guildID
+}
+
+// ReadGuild returns a copy of the given guild
+func (ds *Server) ReadGuild(ctx context.Context, guildID string) (*GuildData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadGuild")
+	defer span.End()
+
+	ds.guildsMutex.Lock()
+	defer ds.guildsMutex.Unlock()
+
+	guild, ok := ds.guildsDB[keyOf(ctx, guildID)]
+	if !ok {
+		return nil, GuildNotFoundErr{guildID}
+	}
+
+	guild = guild.Clone()
+	return &guild, nil
+}
+
+// ReadPlayerGuild returns a copy of the guild the given player is in
+func (ds *Server) ReadPlayerGuild(ctx context.Context, playerID string) (*GuildData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadPlayerGuild")
+	defer span.End()
+
+	ds.guildsMutex.Lock()
+	defer ds.guildsMutex.Unlock()
+
+	guildID, ok := ds.guildMembersDB[keyOf(ctx, playerID)]
+	if !ok {
+		return nil, NotInGuildErr{playerID}
+	}
+
+	guild := ds.guildsDB[keyOf(ctx, guildID)].Clone()
+	return &guild, nil
+}
+
+// ListGuilds returns a copy of all the guilds (of the namespace), in the order they were created
+func (ds *Server) ListGuilds(ctx context.Context) ([]GuildData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ListGuilds")
+	defer span.End()
+
+	ds.guildsMutex.Lock()
+	defer ds.guildsMutex.Unlock()
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	guilds := []GuildData{}
+	for key, guild := range ds.guildsDB {
+		if key.Namespace == requestNamespace {
+			guilds = append(guilds, guild.Clone())
+		}
+	}
+
+	slices.SortFunc(guilds, func(a, b GuildData) int {
+		return cmp.Or(cmp.Compare(a.CreatedTime, b.CreatedTime), cmp.Compare(a.GuildID, b.GuildID))
+	})
+
+	return guilds, nil
+}
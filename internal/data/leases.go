@@ -0,0 +1,254 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+type LeaseHeldErr struct {
+	Name   string
+	Holder string
+}
+
+func (err LeaseHeldErr) Error() string {
+	return fmt.Sprintf("lease: %v is held by: %v", err.Name, err.Holder)
+}
+
+// LeaseRequest is used as the request body for the internal request to acquire (or renew) a lease
+type LeaseRequest struct {
+	Name       string `json:"name"`
+	Holder     string `json:"holder"`
+	TTLSeconds int64  `json:"ttlSeconds"`
+}
+
+// LeaseData is a lease on a name (like a singleton background job), held until its expiry time (unix seconds)
+type LeaseData struct {
+	Name       string `json:"name"`
+	Holder     string `json:"holder"`
+	ExpiryTime int64  `json:"expiryTime"`
+}
+
+// LeaseStore implementor keeps the leases in a store shared by several replicas of the data service, a player store
+// which implements it (like the redis and postgres stores) keeps the leases too. AcquireLease returns LeaseHeldErr if
+// the lease is held by another holder, and ReleaseLease does nothing if it is not held by the given holder
+type LeaseStore interface {
+	AcquireLease(ctx context.Context, namespace string, lease *LeaseData, unixNow int64) error
+	ReleaseLease(ctx context.Context, namespace string, name string, holder string) error
+}
+
+// NewLeaseHolder returns a lease holder name unique to this instance of the given service
+func NewLeaseHolder(service string) string {
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix) // never returns an error
+
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%v@%v-%v-%v", service, hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// JobLeaseRequest returns the request for the lease of the given holder on a job which runs every period,
+// the lease lasts constants.JobLeasePeriods periods (so it is renewed by every run of the job)
+func JobLeaseRequest(job string, holder string, period time.Duration) *LeaseRequest {
+	ttlSeconds := max(int64(constants.JobLeasePeriods*period/time.Second), 1)
+	return &LeaseRequest{Name: job, Holder: holder, TTLSeconds: ttlSeconds}
+}
+
+// isJobLeader acquires (or renews) the lease of this data service on the given job, which runs every period,
+// and returns whether it holds it (the other services do the same through a leader.Elector)
+func (ds *Server) isJobLeader(job string, period time.Duration) bool {
+
+	_, err := ds.AcquireLease(context.Background(), JobLeaseRequest(job, ds.jobHolder, period))
+	if err != nil {
+		if _, held := err.(LeaseHeldErr); !held {
+			ds.logger.Printf("could not acquire the lease on job: %v, skipping it: %v", job, err)
+		}
+		return false
+	}
+	return true
+}
+
+// canTake returns whether the given holder can take the lease (it is not held, has expired, or is held by the same holder)
+func (lease *LeaseData) canTake(holder string, unixNow int64) bool {
+	return lease == nil || lease.Holder == holder || lease.ExpiryTime <= unixNow
+}
+
+// HandleAcquireLeaseRequest acquires (or renews) the lease in the request body for its holder,
+// responding with a conflict status if another holder has it
+func (ds *Server) HandleAcquireLeaseRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a LeaseRequest struct
+	decodedReq := &LeaseRequest{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	lease, err := ds.AcquireLease(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not acquire lease: " + err.Error()
+		switch err.(type) {
+		case LeaseHeldErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			ds.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, lease, "lease")
+}
+
+// HandleReleaseLeaseRequest releases the lease with the requested name, if it is held by the holder in the holder query parameter
+func (ds *Server) HandleReleaseLeaseRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := ds.ReleaseLease(r.Context(), r.PathValue("name"), r.URL.Query().Get("holder"))
+	if err != nil {
+		errMsg := "error: could not release lease: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// AcquireLease gives the requested lease to its holder for the requested time to live, if it is not held by another
+// holder (or has expired). A holder renews its lease by acquiring it again
+func (ds *Server) AcquireLease(ctx context.Context, request *LeaseRequest) (*LeaseData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.AcquireLease")
+	defer span.End()
+
+	if request == nil || request.Name == "" || request.Holder == "" || request.TTLSeconds <= 0 {
+		return nil, fmt.Errorf("invalid lease request")
+	}
+
+	unixNow := time.Now().UTC().Unix()
+	lease := &LeaseData{Name: request.Name, Holder: request.Holder, ExpiryTime: unixNow + request.TTLSeconds}
+	key := keyOf(ctx, request.Name)
+
+	if leaseStore, ok := ds.playerStore.(LeaseStore); ok {
+		err := leaseStore.AcquireLease(ctx, key.Namespace, lease, unixNow)
+		if err != nil {
+			return nil, err
+		}
+		return lease, nil
+	}
+
+	ds.leasesMutex.Lock()
+	defer ds.leasesMutex.Unlock()
+
+	if current, ok := ds.leasesDB[key]; ok && !current.canTake(request.Holder, unixNow) {
+		return nil, LeaseHeldErr{Name: request.Name, Holder: current.Holder}
+	}
+
+	ds.leasesDB[key] = *lease
+	return lease, nil
+}
+
+// ReleaseLease releases the lease with the given name, if it is held by the given holder
+// (so another holder can acquire it before it expires)
+func (ds *Server) ReleaseLease(ctx context.Context, name string, holder string) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReleaseLease")
+	defer span.End()
+
+	if name == "" || holder == "" {
+		return fmt.Errorf("invalid lease release")
+	}
+
+	key := keyOf(ctx, name)
+
+	if leaseStore, ok := ds.playerStore.(LeaseStore); ok {
+		return leaseStore.ReleaseLease(ctx, key.Namespace, name, holder)
+	}
+
+	ds.leasesMutex.Lock()
+	defer ds.leasesMutex.Unlock()
+
+	if current, ok := ds.leasesDB[key]; ok && current.Holder == holder {
+		delete(ds.leasesDB, key)
+	}
+
+	return nil
+}
+
+// AcquireLease makes an internal request to the data service to acquire (or renew) a lease
+func (hc *HTTPClient) AcquireLease(ctx context.Context, request *LeaseRequest) (*LeaseData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &LeaseData{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/lease-internal", request, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusConflict:
+		return nil, LeaseHeldErr{Name: request.Name}
+	default:
+		return nil, fmt.Errorf("internal acquire lease request was not successful, status code %v", statusCode)
+	}
+}
+
+// ReleaseLease makes an internal request to the data service to release a lease held by the given holder
+func (hc *HTTPClient) ReleaseLease(ctx context.Context, name string, holder string) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	statusCode, err := hc.doInternal(ctx, "DELETE", fmt.Sprintf("/data/lease-internal/%v?holder=%v", name, url.QueryEscape(holder)), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("internal release lease request was not successful, status code %v", statusCode)
+	}
+	return nil
+}
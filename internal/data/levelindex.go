@@ -0,0 +1,224 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LevelRankEntry is the entry of a player in the ranking of a level, players who won the level are ranked by their
+// best score at it (the fewest rolls first), then by their win count (the most first), then by their player id
+type LevelRankEntry struct {
+	PlayerID  string `json:"playerID"`
+	BestScore int32  `json:"bestScore"`
+	WinCount  int32  `json:"winCount"`
+}
+
+// levelIndexKey identifies the ranking of a level in a namespace
+type levelIndexKey struct {
+	Namespace string
+	Level     int32
+}
+
+// levelIndex is a secondary index of the stats, keyed by level, which keeps the players who won each level ranked
+// (see compareLevelRanks), so the top of a level is read without going through the stats of every player. It is kept
+// in memory only, and updated along with the stats (while the lock of the shard of the player's stats is held)
+type levelIndex struct {
+	mutex  sync.Mutex
+	levels map[levelIndexKey][]LevelRankEntry
+}
+
+// newLevelIndex returns an empty level index
+func newLevelIndex() *levelIndex {
+	return &levelIndex{levels: map[levelIndexKey][]LevelRankEntry{}}
+}
+
+// compareLevelRanks orders the entries of a level ranking, the best ranked first
+func compareLevelRanks(a, b LevelRankEntry) int {
+
+	if a.BestScore != b.BestScore {
+		return cmp.Compare(a.BestScore, b.BestScore)
+	}
+	if a.WinCount != b.WinCount {
+		return cmp.Compare(b.WinCount, a.WinCount)
+	}
+	return strings.Compare(a.PlayerID, b.PlayerID)
+}
+
+// rankEntriesOf returns the ranking entries of the given player stats (nil for none) keyed by level,
+// the levels the player never won are not ranked
+func rankEntriesOf(playerID string, plStats *PlayerStats) map[int32]LevelRankEntry {
+
+	entries := map[int32]LevelRankEntry{}
+	if plStats == nil {
+		return entries
+	}
+
+	for _, levelStats := range plStats.LevelStats {
+		if levelStats.WinCount > 0 {
+			entries[levelStats.Level] = LevelRankEntry{PlayerID: playerID, BestScore: levelStats.BestScore, WinCount: levelStats.WinCount}
+		}
+	}
+	return entries
+}
+
+// update moves the entries of the player with the given key from their old stats (nil for new stats)
+// to their updated stats (nil for removed stats), only the levels whose entries changed are touched
+func (li *levelIndex) update(key dbKey, old *PlayerStats, updated *PlayerStats) {
+
+	oldEntries := rankEntriesOf(key.ID, old)
+	updatedEntries := rankEntriesOf(key.ID, updated)
+
+	li.mutex.Lock()
+	defer li.mutex.Unlock()
+
+	for level, entry := range oldEntries {
+		if updatedEntry, ok := updatedEntries[level]; !ok || updatedEntry != entry {
+			li.remove(levelIndexKey{Namespace: key.Namespace, Level: level}, entry)
+		}
+	}
+
+	for level, entry := range updatedEntries {
+		if oldEntry, ok := oldEntries[level]; !ok || oldEntry != entry {
+			li.insert(levelIndexKey{Namespace: key.Namespace, Level: level}, entry)
+		}
+	}
+}
+
+// insert adds the given entry to the given ranking, at its rank (the index mutex should be held)
+func (li *levelIndex) insert(indexKey levelIndexKey, entry LevelRankEntry) {
+
+	ranking := li.levels[indexKey]
+	i, found := slices.BinarySearchFunc(ranking, entry, compareLevelRanks)
+	if !found {
+		li.levels[indexKey] = slices.Insert(ranking, i, entry)
+	}
+}
+
+// remove takes the given entry out of the given ranking, if it is there (the index mutex should be held)
+func (li *levelIndex) remove(indexKey levelIndexKey, entry LevelRankEntry) {
+
+	ranking := li.levels[indexKey]
+	i, found := slices.BinarySearchFunc(ranking, entry, compareLevelRanks)
+	if !found {
+		return
+	}
+
+	if len(ranking) == 1 {
+		delete(li.levels, indexKey)
+		return
+	}
+	li.levels[indexKey] = slices.Delete(ranking, i, i+1)
+}
+
+// rebuild replaces the whole index with one built from the given stats (all the stats shards should be locked)
+func (li *levelIndex) rebuild(allStats map[dbKey]PlayerStats) {
+
+	levels := map[levelIndexKey][]LevelRankEntry{}
+	for key, plStats := range allStats {
+		for level, entry := range rankEntriesOf(key.ID, &plStats) {
+			indexKey := levelIndexKey{Namespace: key.Namespace, Level: level}
+			levels[indexKey] = append(levels[indexKey], entry)
+		}
+	}
+
+	for _, ranking := range levels {
+		slices.SortFunc(ranking, compareLevelRanks)
+	}
+
+	li.mutex.Lock()
+	defer li.mutex.Unlock()
+
+	li.levels = levels
+}
+
+// top returns (up to limit) entries from the top of the ranking of the given level
+func (li *levelIndex) top(indexKey levelIndexKey, limit int) []LevelRankEntry {
+
+	li.mutex.Lock()
+	defer li.mutex.Unlock()
+
+	ranking := li.levels[indexKey]
+	return append([]LevelRankEntry{}, ranking[:min(limit, len(ranking))]...)
+}
+
+// HandleReadLevelLeaderboardRequest responds with the top ranked players of the requested level,
+// the 'limit' query parameter sets the number of entries
+func (ds *Server) HandleReadLevelLeaderboardRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	level, err := strconv.ParseInt(r.PathValue("level"), 10, 32)
+	if err != nil || level <= 0 {
+		errMsg := "error: invalid level in the path"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		errMsg := "error: invalid limit parameter"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := ds.ReadLevelLeaderboard(r.Context(), int32(level), limit)
+	if err != nil {
+		errMsg := "error: could not read level leaderboard: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	ds.writeJSON(w, entries, "level leaderboard")
+}
+
+// ReadLevelLeaderboard returns (up to limit) top ranked players of the given level in the namespace (see LevelRankEntry),
+// from the level index. Only players in memory are included, archived players come back once they are accessed again,
+// and the stats held in a player store are not indexed, so they are ranked by going through all of them instead
+func (ds *Server) ReadLevelLeaderboard(ctx context.Context, level int32, limit int) ([]LevelRankEntry, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadLevelLeaderboard")
+	defer span.End()
+
+	if level <= 0 || limit <= 0 {
+		return nil, fmt.Errorf("the level and the limit should be positive, got: %v, %v", level, limit)
+	}
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	if ds.playerStore == nil {
+		return ds.levelIndex.top(levelIndexKey{Namespace: requestNamespace, Level: level}, limit), nil
+	}
+
+	allStats, err := ds.statsOf(ctx, requestNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []LevelRankEntry{}
+	for key, plStats := range allStats {
+		if entry, ranked := rankEntriesOf(key.ID, &plStats)[level]; ranked {
+			entries = append(entries, entry)
+		}
+	}
+
+	slices.SortFunc(entries, compareLevelRanks)
+	return entries[:min(limit, len(entries))], nil
+}
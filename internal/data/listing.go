@@ -0,0 +1,158 @@
+package data
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// PlayersPage is a page of the listing of all the players (of the namespace), ordered by player id,
+// the next cursor is blank on the last page
+type PlayersPage struct {
+	Players    []PlayerData `json:"players"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// StatsPage is a page of the listing of all the player stats (of the namespace), ordered by player id,
+// the next cursor is blank on the last page
+type StatsPage struct {
+	Stats      []PlayerStatsWithID `json:"stats"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+// pageIDs returns the ids of the DB entries of the given namespace on the requested page (ordered by id),
+// along with the cursor of the next page (blank if this is the last one). It should be called with the DB's lock held
+func pageIDs[V any](db map[dbKey]V, requestNamespace string, page pagination.Request) ([]string, string, error) {
+
+	ids := []string{}
+	for key := range db {
+		if key.Namespace == requestNamespace {
+			ids = append(ids, key.ID)
+		}
+	}
+	slices.Sort(ids)
+
+	return pagination.Paginate(ids, page, func(id string) string { return id }, strings.Compare)
+}
+
+// HandleListPlayersRequest responds with a page of all the players, see ListPlayers
+// (the pagination query parameters pick the page)
+func (ds *Server) HandleListPlayersRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, err := pagination.ParseRequest(r.URL.Query())
+	if err != nil {
+		errMsg := "error: could not parse players query: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	playersPage, err := ds.ListPlayers(r.Context(), page)
+	if err != nil {
+		errMsg := "error: could not list players: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.writeJSON(w, playersPage, "players page")
+}
+
+// HandleListStatsRequest responds with a page of all the player stats, see ListStats
+// (the pagination query parameters pick the page)
+func (ds *Server) HandleListStatsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, err := pagination.ParseRequest(r.URL.Query())
+	if err != nil {
+		errMsg := "error: could not parse stats query: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	statsPage, err := ds.ListStats(r.Context(), page)
+	if err != nil {
+		errMsg := "error: could not list stats: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.writeJSON(w, statsPage, "stats page")
+}
+
+// ListPlayers returns the requested page of the players, ordered by player id (ascending by default). Players added while iterating show up on a later page if their id comes after the cursor.
+// Only players in memory (or in the player store, when it is enabled) are included, archived players come back once they are accessed again
+func (ds *Server) ListPlayers(ctx context.Context, page pagination.Request) (*PlayersPage, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ListPlayers")
+	defer span.End()
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	players, err := ds.playersOf(ctx, requestNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, nextCursor, err := pageIDs(players, requestNamespace, page)
+	if err != nil {
+		return nil, err
+	}
+
+	playersPage := &PlayersPage{Players: make([]PlayerData, 0, len(ids)), NextCursor: nextCursor}
+	for _, id := range ids {
+		playersPage.Players = append(playersPage.Players, players[dbKey{Namespace: requestNamespace, ID: id}].Clone())
+	}
+
+	return playersPage, nil
+}
+
+// ListStats returns the requested page of the player stats, ordered by player id (ascending by default). Like ListPlayers, only the stats of players in memory are included
+func (ds *Server) ListStats(ctx context.Context, page pagination.Request) (*StatsPage, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ListStats")
+	defer span.End()
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	allStats, err := ds.statsOf(ctx, requestNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, nextCursor, err := pageIDs(allStats, requestNamespace, page)
+	if err != nil {
+		return nil, err
+	}
+
+	statsPage := &StatsPage{Stats: make([]PlayerStatsWithID, 0, len(ids)), NextCursor: nextCursor}
+	for _, id := range ids {
+		plStats := allStats[dbKey{Namespace: requestNamespace, ID: id}]
+		statsPage.Stats = append(statsPage.Stats, PlayerStatsWithID{PlayerID: id, PlayerStats: *copyStats(plStats)})
+	}
+
+	return statsPage, nil
+}
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// outcomes of a head-to-head match, from the point of view of a player
+const (
+	MatchOutcomeWin  = "win"
+	MatchOutcomeLoss = "loss"
+	MatchOutcomeDraw = "draw"
+)
+
+// MatchRecord is a finished head-to-head match in a player's match history.
+// A score is the number of rolls it took to hit the target (0 if it was missed, or the rolls were never submitted)
+type MatchRecord struct {
+	MatchID       string `json:"matchID"`
+	PlayerID      string `json:"playerID"`
+	OpponentID    string `json:"opponentID"`
+	Outcome       string `json:"outcome"`
+	Score         int32  `json:"score"`
+	OpponentScore int32  `json:"opponentScore"`
+	Forfeit       bool   `json:"forfeit"` // whether the match was decided because a player did not submit in time
+	Rating        int32  `json:"rating"`  // the player's rating after the match
+	RatingChange  int32  `json:"ratingChange"`
+	Time          int64  `json:"time"`
+}
+
+// RatingEntry is a single entry of the rating leaderboard
+type RatingEntry struct {
+	PlayerID string `json:"playerID"`
+	Rating   int32  `json:"rating"`
+}
+
+// HandleWriteMatchRecordRequest appends the given match record to the match history of its player
+func (ds *Server) HandleWriteMatchRecordRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a MatchRecord struct
+	decodedReq := &MatchRecord{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	if decodedReq.PlayerID == "" || decodedReq.MatchID == "" {
+		errMsg := "error: cannot write an entry with a blank player id or match id"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// write the entry to the database
+	err = ds.WriteMatchRecord(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not write match record: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleReadMatchRecordsRequest responds with the match history of the requested player
+// (which is empty if the player has not finished any match yet)
+func (ds *Server) HandleReadMatchRecordsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the request uri
+	id := r.PathValue("id")
+
+	records, err := ds.ReadMatchRecords(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not read match records: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	ds.writeJSON(w, records, "match records")
+}
+
+// WriteMatchRecord appends the match record to the match history of its player
+func (ds *Server) WriteMatchRecord(ctx context.Context, record *MatchRecord) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.WriteMatchRecord")
+	defer span.End()
+
+	if record == nil {
+		return fmt.Errorf("provided match record pointer is nil")
+	}
+
+	ds.logger.Printf("appending match %v to the match history of id: %v", record.MatchID, record.PlayerID)
+
+	ds.matchesMutex.Lock()
+	defer ds.matchesMutex.Unlock()
+
+	key := keyOf(ctx, record.PlayerID)
+	ds.matchesDB[key] = append(ds.matchesDB[key], *record)
+
+	return nil
+}
+
+// ReadMatchRecords returns a copy of the match history of the given player (empty if there is none)
+func (ds *Server) ReadMatchRecords(ctx context.Context, playerID string) ([]MatchRecord, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadMatchRecords")
+	defer span.End()
+
+	ds.matchesMutex.Lock()
+	defer ds.matchesMutex.Unlock()
+
+	return append([]MatchRecord{}, ds.matchesDB[keyOf(ctx, playerID)]...), nil
+}
+
+// HandleReadRatingLeaderboardRequest responds with the highest rated players,
+// the 'limit' query parameter sets the number of entries
+func (ds *Server) HandleReadRatingLeaderboardRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		errMsg := "error: invalid limit parameter"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := ds.ReadRatingLeaderboard(r.Context(), limit)
+	if err != nil {
+		errMsg := "error: could not read rating leaderboard: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	ds.writeJSON(w, entries, "rating leaderboard")
+}
+
+// ReadRatingLeaderboard returns (up to limit) rated players of the namespace, ordered by rating (highest first, ties by player id).
+// Only players in memory are included, archived players come back once they are accessed again
+func (ds *Server) ReadRatingLeaderboard(ctx context.Context, limit int) ([]RatingEntry, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadRatingLeaderboard")
+	defer span.End()
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	allStats, err := ds.statsOf(ctx, requestNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []RatingEntry{}
+	for key, plStats := range allStats {
+		if key.Namespace == requestNamespace && plStats.Rating > 0 {
+			entries = append(entries, RatingEntry{PlayerID: key.ID, Rating: plStats.Rating})
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b RatingEntry) int {
+		if a.Rating != b.Rating {
+			return int(b.Rating - a.Rating)
+		}
+		return strings.Compare(a.PlayerID, b.PlayerID)
+	})
+
+	return entries[:min(limit, len(entries))], nil
+}
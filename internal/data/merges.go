@@ -0,0 +1,416 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+type RedirectNotFoundErr struct {
+	PlayerID string
+}
+
+func (err RedirectNotFoundErr) Error() string {
+	return fmt.Sprintf("redirect entry for id: %v was not found in the redirects DB", err.PlayerID)
+}
+
+type PlayerRetiredErr struct {
+	PlayerID string
+}
+
+func (err PlayerRetiredErr) Error() string {
+	return fmt.Sprintf("player with id: %v was already merged into another player", err.PlayerID)
+}
+
+// PlayerRedirect is the record left behind by a retired player id, which was merged into another player
+type PlayerRedirect struct {
+	PlayerID   string `json:"playerID"`
+	MergedInto string `json:"mergedInto"`
+	MergeTime  int64  `json:"mergeTime"`
+}
+
+// PlayerMerge is used as the request body for the internal request to merge a secondary player into a primary one:
+// the merged player data is only written if both players still hold the expected player data (like PlayerSwap),
+// the stats of both are merged by the data service (see MergeStats)
+type PlayerMerge struct {
+	Expected          PlayerData `json:"expected"`
+	ExpectedSecondary PlayerData `json:"expectedSecondary"`
+	Merged            PlayerData `json:"merged"`
+	MergeTime         int64      `json:"mergeTime"`
+}
+
+// MergeStats returns the stats of a player merged from the stats of two players (either can be nil): the win and
+// loss counts of each level are added, and the best score of a level is the lower best score of the players who won
+// it. The rating and win streak of the primary player are kept (the secondary's rating if the primary has none),
+// along with the higher prestige count
+func MergeStats(primary *PlayerStats, secondary *PlayerStats) PlayerStats {
+
+	if primary == nil {
+		primary = &PlayerStats{}
+	}
+	if secondary == nil {
+		secondary = &PlayerStats{}
+	}
+
+	merged := *copyStats(*primary)
+	merged.PrestigeCount = max(primary.PrestigeCount, secondary.PrestigeCount)
+	if merged.Rating == 0 {
+		merged.Rating = secondary.Rating
+	}
+
+	// level stats are kept in level order, one entry per level from the first one
+	for i, levelStats := range secondary.LevelStats {
+
+		if i >= len(merged.LevelStats) {
+			merged.LevelStats = append(merged.LevelStats, levelStats)
+			continue
+		}
+
+		mergedLevel := &merged.LevelStats[i]
+		switch {
+		case levelStats.WinCount == 0:
+		case mergedLevel.WinCount == 0:
+			mergedLevel.BestScore = levelStats.BestScore
+		default:
+			mergedLevel.BestScore = min(mergedLevel.BestScore, levelStats.BestScore)
+		}
+		mergedLevel.WinCount += levelStats.WinCount
+		mergedLevel.LossCount += levelStats.LossCount
+	}
+
+	return merged
+}
+
+// mergedStatsOf returns the stats of the merged player in the current version (see MergeStats), and whether they are
+// kept: players without stats (who never finished a level) still have none after the merge
+func mergedStatsOf(primary *PlayerStats, secondary *PlayerStats) (PlayerStats, bool, error) {
+	merged := MergeStats(primary, secondary)
+	err := upgradeStats(&merged)
+	return merged, primary != nil || secondary != nil, err
+}
+
+// HandleMergePlayersRequest merges the secondary player in the request body into the primary one,
+// responding with the merged player and their stats
+func (ds *Server) HandleMergePlayersRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a PlayerMerge struct
+	decodedReq := &PlayerMerge{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	merged, err := ds.MergePlayers(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not merge players: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case PlayerNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case PlayerChangedErr, PlayerRetiredErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, merged, "merged player")
+}
+
+// HandleReadRedirectRequest responds with the redirect entry of the requested (retired) player id, if present
+func (ds *Server) HandleReadRedirectRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	redirect, err := ds.ReadRedirect(r.Context(), r.PathValue("id"))
+	if err != nil {
+		errMsg := "error: could not read redirect: " + err.Error()
+		ds.logger.Println(errMsg)
+		if _, ok := err.(RedirectNotFoundErr); ok {
+			http.Error(w, errMsg, http.StatusNotFound)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ds.writeJSON(w, redirect, "redirect")
+}
+
+// MergePlayers atomically writes the merged player data over the primary player, and their stats merged with the
+// stats of the secondary player (see MergeStats), removes the secondary player and their stats, and retires the
+// secondary player id with a redirect entry to the primary one. The guild membership of the secondary player goes to
+// the primary one, unless they are in a guild already (see mergeGuildMember). Neither player can have been merged already.
+// The merge is replicated as a write of the primary player, and the retirement of the secondary one (see replicateRedirect),
+// and ends the event stream of the secondary player (see recordPlayerMerge)
+func (ds *Server) MergePlayers(ctx context.Context, merge *PlayerMerge) (*PlayerWithStats, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.MergePlayers")
+	defer span.End()
+
+	if merge == nil || merge.Expected.PlayerID == "" || merge.ExpectedSecondary.PlayerID == "" ||
+		merge.Merged.PlayerID != merge.Expected.PlayerID || merge.ExpectedSecondary.PlayerID == merge.Expected.PlayerID {
+		return nil, fmt.Errorf("invalid player merge")
+	}
+
+	primaryID := merge.Expected.PlayerID
+	secondaryID := merge.ExpectedSecondary.PlayerID
+	primaryKey := keyOf(ctx, primaryID)
+	secondaryKey := keyOf(ctx, secondaryID)
+
+	// records are always stored in the current version
+	merged := merge.Merged.Clone()
+	err := upgradePlayer(&merged)
+	if err != nil {
+		return nil, err
+	}
+
+	if ds.playerStore != nil {
+		return ds.mergeStoredPlayers(ctx, merge, merged)
+	}
+
+	// archived players are brought back to memory first, so nothing of theirs is left behind in the cold store
+	for _, key := range []dbKey{primaryKey, secondaryKey} {
+		err = ds.rehydrate(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the players shards are locked before the stats shards, and both before the guilds and the redirects,
+	// like lockAll does
+	unlockPlayers := ds.playersDB.lockPair(primaryKey, secondaryKey)
+	defer unlockPlayers()
+
+	unlockStats := ds.statsDB.lockPair(primaryKey, secondaryKey)
+	defer unlockStats()
+
+	ds.guildsMutex.Lock()
+	defer ds.guildsMutex.Unlock()
+
+	ds.redirectsMutex.Lock()
+	defer ds.redirectsMutex.Unlock()
+
+	for _, key := range []dbKey{primaryKey, secondaryKey} {
+		if _, retired := ds.redirectsDB[key]; retired {
+			return nil, PlayerRetiredErr{key.ID}
+		}
+	}
+
+	playersEntries := ds.playersDB.shardOf(primaryKey).entries
+	secondaryPlayersEntries := ds.playersDB.shardOf(secondaryKey).entries
+
+	primary, ok := playersEntries[primaryKey]
+	if !ok {
+		return nil, PlayerNotFoundErr{primaryID}
+	}
+	secondary, ok := secondaryPlayersEntries[secondaryKey]
+	if !ok {
+		return nil, PlayerNotFoundErr{secondaryID}
+	}
+
+	if !primary.Equal(merge.Expected) {
+		return nil, PlayerChangedErr{primaryID}
+	}
+	if !secondary.Equal(merge.ExpectedSecondary) {
+		return nil, PlayerChangedErr{secondaryID}
+	}
+
+	statsEntries := ds.statsDB.shardOf(primaryKey).entries
+	secondaryStatsEntries := ds.statsDB.shardOf(secondaryKey).entries
+
+	var primaryStats, secondaryStats *PlayerStats
+	if plStats, found := statsEntries[primaryKey]; found {
+		primaryStats = &plStats
+	}
+	if plStats, found := secondaryStatsEntries[secondaryKey]; found {
+		secondaryStats = &plStats
+	}
+
+	mergedStats, keepStats, err := mergedStatsOf(primaryStats, secondaryStats)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.logger.Printf("merging player id: %v into player id: %v", secondaryID, primaryID)
+
+	ds.recordPlayerChange(primaryKey, &primary, merged)
+	playersEntries[primaryKey] = merged
+
+	if keepStats {
+		ds.recordStatsChange(primaryKey, primaryStats, mergedStats)
+		ds.stampStatsChanges(primaryKey, primaryStats, mergedStats)
+		ds.levelIndex.update(primaryKey, primaryStats, &mergedStats)
+		statsEntries[primaryKey] = mergedStats
+		ds.replicate(primaryKey, &merged, &mergedStats)
+	} else {
+		ds.replicate(primaryKey, &merged, nil)
+	}
+
+	// the secondary player is removed, so they are not ranked (or listed) twice
+	delete(secondaryPlayersEntries, secondaryKey)
+	if secondaryStats != nil {
+		ds.levelIndex.update(secondaryKey, secondaryStats, nil)
+		delete(secondaryStatsEntries, secondaryKey)
+	}
+
+	changesShard := ds.statsChangesDB.shardOf(secondaryKey)
+	changesShard.mutex.Lock()
+	delete(changesShard.entries, secondaryKey)
+	changesShard.mutex.Unlock()
+
+	// the retired player does not stay in a guild (see mergeGuildMember)
+	ds.mergeGuildMember(ctx, primaryID, secondaryID)
+
+	redirect := PlayerRedirect{PlayerID: secondaryID, MergedInto: primaryID, MergeTime: merge.MergeTime}
+	ds.redirectsDB[secondaryKey] = redirect
+	ds.recordPlayerMerge(secondaryKey, primaryID)
+	ds.replicateRedirect(secondaryKey, redirect)
+
+	return &PlayerWithStats{Player: merged.Clone(), Stats: *copyStats(mergedStats)}, nil
+}
+
+// mergeStoredPlayers merges the players in the player store, which writes the merged player and stats, removes the
+// secondary player and stores the redirect all at once. The guild membership is handed over once the merge is stored
+func (ds *Server) mergeStoredPlayers(ctx context.Context, merge *PlayerMerge, merged PlayerData) (*PlayerWithStats, error) {
+
+	stored := *merge
+	stored.Merged = merged
+
+	ds.logger.Printf("merging player id: %v into player id: %v", merge.ExpectedSecondary.PlayerID, merge.Expected.PlayerID)
+
+	mergedStats, err := ds.playerStore.MergePlayers(ctx, keyOf(ctx, merged.PlayerID).Namespace, &stored)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.guildsMutex.Lock()
+	ds.mergeGuildMember(ctx, merge.Expected.PlayerID, merge.ExpectedSecondary.PlayerID)
+	ds.guildsMutex.Unlock()
+
+	return &PlayerWithStats{Player: merged.Clone(), Stats: *mergedStats}, nil
+}
+
+// ReadRedirect returns the redirect entry of the given player id, if it was retired by a merge
+func (ds *Server) ReadRedirect(ctx context.Context, playerID string) (*PlayerRedirect, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadRedirect")
+	defer span.End()
+
+	key := keyOf(ctx, playerID)
+	if ds.playerStore != nil {
+		redirect, found, err := ds.playerStore.ReadRedirect(ctx, key.Namespace, playerID)
+		if err == nil && !found {
+			err = RedirectNotFoundErr{playerID}
+		}
+		return redirect, err
+	}
+
+	ds.redirectsMutex.Lock()
+	defer ds.redirectsMutex.Unlock()
+
+	redirect, ok := ds.redirectsDB[key]
+	if !ok {
+		return nil, RedirectNotFoundErr{playerID}
+	}
+
+	return &redirect, nil
+}
+
+// MergePlayers makes an internal request to the data service to merge a secondary player into a primary one
+func (hc *HTTPClient) MergePlayers(ctx context.Context, merge *PlayerMerge) (*PlayerWithStats, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if merge == nil {
+		return nil, fmt.Errorf("provided player merge pointer is nil")
+	}
+
+	result := &PlayerWithStats{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/player-merge-internal", merge, result)
+	if err != nil {
+		return nil, err
+	}
+
+	// the players which were not found, or were merged already, are told apart by reading them
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		if _, err = hc.ReadPlayer(ctx, merge.Expected.PlayerID); err != nil {
+			return nil, PlayerNotFoundErr{PlayerID: merge.Expected.PlayerID}
+		}
+		return nil, PlayerNotFoundErr{PlayerID: merge.ExpectedSecondary.PlayerID}
+	case http.StatusConflict:
+		for _, playerID := range []string{merge.Expected.PlayerID, merge.ExpectedSecondary.PlayerID} {
+			if _, err = hc.ReadRedirect(ctx, playerID); err == nil {
+				return nil, PlayerRetiredErr{PlayerID: playerID}
+			}
+		}
+		return nil, PlayerChangedErr{PlayerID: merge.Expected.PlayerID}
+	default:
+		return nil, fmt.Errorf("internal merge players request was not successful, status code %v", statusCode)
+	}
+}
+
+// ReadRedirect makes an internal request to the data service to read the redirect entry of the required player id
+func (hc *HTTPClient) ReadRedirect(ctx context.Context, playerID string) (*PlayerRedirect, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &PlayerRedirect{}
+	statusCode, err := hc.doInternal(ctx, "GET", "/data/redirect-internal/"+playerID, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, RedirectNotFoundErr{PlayerID: playerID}
+	default:
+		return nil, fmt.Errorf("internal read redirect request was not successful, status code %v", statusCode)
+	}
+}
+
+// MergePlayers merges the players with the wrapped client, and invalidates the cached entries of both
+func (cc *CachedClient) MergePlayers(ctx context.Context, merge *PlayerMerge) (*PlayerWithStats, error) {
+
+	if merge == nil {
+		return cc.DataClient.MergePlayers(ctx, merge)
+	}
+
+	for _, playerID := range []string{merge.Expected.PlayerID, merge.ExpectedSecondary.PlayerID} {
+		key := keyOf(ctx, playerID)
+		defer cc.players.invalidate(key)
+		defer cc.stats.invalidate(key)
+	}
+	return cc.DataClient.MergePlayers(ctx, merge)
+}
@@ -0,0 +1,145 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PlayerDataVersion and PlayerStatsVersion are the current versions of the player data and player stats records.
+// Whenever the layout of one of them changes, its version is bumped and a migration to it is registered
+// (see playerDataMigrations and playerStatsMigrations), so records saved with older versions still load
+const PlayerDataVersion int32 = 1
+const PlayerStatsVersion int32 = 1
+
+// Migration upgrades a record (in its json object form) from the previous version to the given version,
+// so fields can be added with defaults, renamed, split etc. before the record is decoded. Records which do not
+// come from json are encoded from the current struct before being migrated, so a migration should leave
+// a record which already has the new layout as it is
+type Migration struct {
+	Version int32
+	Migrate func(record map[string]any) error
+}
+
+// playerDataMigrations is the registry of player data migrations, in order of version
+var playerDataMigrations = []Migration{
+	// records saved before versioning was added have the version 1 layout
+	{Version: 1, Migrate: func(record map[string]any) error { return nil }},
+}
+
+// playerStatsMigrations is the registry of player stats migrations, in order of version
+var playerStatsMigrations = []Migration{
+	// records saved before versioning was added have the version 1 layout
+	{Version: 1, Migrate: func(record map[string]any) error { return nil }},
+}
+
+// UnmarshalJSON decodes a player data record of any version, upgrading older records to the current version
+func (pd *PlayerData) UnmarshalJSON(encoded []byte) error {
+
+	// the plain type has no methods, so decoding it does not come back here
+	type plainPlayerData PlayerData
+
+	decoded, err := decodeVersioned(encoded, playerDataMigrations, PlayerDataVersion, func(player *plainPlayerData) int32 {
+		return player.Version
+	})
+	if err != nil {
+		return err
+	}
+
+	*pd = PlayerData(*decoded)
+	return nil
+}
+
+// UnmarshalJSON decodes a player stats record of any version, upgrading older records to the current version
+func (ps *PlayerStats) UnmarshalJSON(encoded []byte) error {
+
+	// the plain type has no methods, so decoding it does not come back here
+	type plainPlayerStats PlayerStats
+
+	decoded, err := decodeVersioned(encoded, playerStatsMigrations, PlayerStatsVersion, func(plStats *plainPlayerStats) int32 {
+		return plStats.Version
+	})
+	if err != nil {
+		return err
+	}
+
+	*ps = PlayerStats(*decoded)
+	return nil
+}
+
+// upgradePlayer upgrades a player data record which was not decoded from json (like one passed in directly,
+// or restored from a gob backup) to the current version
+func upgradePlayer(player *PlayerData) error {
+
+	if player.Version == PlayerDataVersion {
+		return nil
+	}
+
+	encoded, err := json.Marshal(player)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, player)
+}
+
+// upgradeStats upgrades a player stats record which was not decoded from json to the current version
+func upgradeStats(plStats *PlayerStats) error {
+
+	if plStats.Version == PlayerStatsVersion {
+		return nil
+	}
+
+	encoded, err := json.Marshal(plStats)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, plStats)
+}
+
+// decodeVersioned decodes the given json record, and if it has an older version than the latest one,
+// runs the migrations after its version on it and decodes the migrated record instead
+func decodeVersioned[T any](encoded []byte, migrations []Migration, latest int32, versionOf func(*T) int32) (*T, error) {
+
+	decoded := new(T)
+	err := json.Unmarshal(encoded, decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	version := versionOf(decoded)
+	if version == latest || string(encoded) == "null" {
+		return decoded, nil
+	}
+	if version > latest {
+		return nil, fmt.Errorf("record version %v is newer than the supported version %v", version, latest)
+	}
+
+	record := map[string]any{}
+	err = json.Unmarshal(encoded, &record)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+		err = migration.Migrate(record)
+		if err != nil {
+			return nil, fmt.Errorf("could not migrate record to version %v: %v", migration.Version, err)
+		}
+	}
+	record["version"] = latest
+
+	migrated, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded = new(T)
+	err = json.Unmarshal(migrated, decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,249 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+type MilestoneNotReachedErr struct {
+	PlayerID    string
+	MilestoneID string
+}
+
+func (err MilestoneNotReachedErr) Error() string {
+	return fmt.Sprintf("player id: %v has not reached milestone: %v", err.PlayerID, err.MilestoneID)
+}
+
+type MilestoneAlreadyClaimedErr struct {
+	PlayerID    string
+	MilestoneID string
+}
+
+func (err MilestoneAlreadyClaimedErr) Error() string {
+	return fmt.Sprintf("player id: %v has already claimed milestone: %v", err.PlayerID, err.MilestoneID)
+}
+
+// MilestoneState holds when (unix) a player reached a milestone, and when they claimed its rewards (0 till they do)
+type MilestoneState struct {
+	MilestoneID string `json:"milestoneID"`
+	ReachTime   int64  `json:"reachTime"`
+	ClaimTime   int64  `json:"claimTime,omitempty"`
+}
+
+// MilestonesData holds the milestones a player reached, in the order they reached them
+type MilestonesData struct {
+	PlayerID   string           `json:"playerID"`
+	Milestones []MilestoneState `json:"milestones"`
+}
+
+// State returns the state of the milestone with the given id, if the player reached it
+func (md *MilestonesData) State(milestoneID string) (*MilestoneState, bool) {
+
+	for i := range md.Milestones {
+		if md.Milestones[i].MilestoneID == milestoneID {
+			return &md.Milestones[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// MilestoneReach is used as the request body for the internal request to record the milestones a player reached
+// (at the given unix time), the ones they had already reached keep their reach time
+type MilestoneReach struct {
+	PlayerID     string   `json:"playerID"`
+	MilestoneIDs []string `json:"milestoneIDs"`
+	Time         int64    `json:"time"`
+}
+
+// MilestoneClaim is used as the request body for the internal request to mark a milestone of a player as claimed
+type MilestoneClaim struct {
+	PlayerID    string `json:"playerID"`
+	MilestoneID string `json:"milestoneID"`
+	Time        int64  `json:"time"`
+}
+
+// HandleReadMilestonesRequest responds with the milestones the requested player reached (none, if they reached none yet)
+func (ds *Server) HandleReadMilestonesRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	milestones, err := ds.ReadMilestones(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not read milestones: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.writeJSON(w, milestones, "milestones")
+}
+
+// HandleReachMilestonesRequest records the given milestones as reached, responding with all the milestones of the player
+func (ds *Server) HandleReachMilestonesRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a MilestoneReach struct
+	decodedReq := &MilestoneReach{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	milestones, err := ds.ReachMilestones(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not reach milestones: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.writeJSON(w, milestones, "milestones")
+}
+
+// HandleClaimMilestoneRequest marks the given milestone as claimed, responding with all the milestones of the player,
+// or with a not found if the player has not reached it, or a conflict if they already claimed it
+func (ds *Server) HandleClaimMilestoneRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a MilestoneClaim struct
+	decodedReq := &MilestoneClaim{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	milestones, err := ds.ClaimMilestone(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not claim milestone: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case MilestoneNotReachedErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case MilestoneAlreadyClaimedErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, milestones, "milestones")
+}
+
+// ReadMilestones returns (a copy of) the milestones the given player reached
+func (ds *Server) ReadMilestones(ctx context.Context, playerID string) (*MilestonesData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadMilestones")
+	defer span.End()
+
+	if playerID == "" {
+		return nil, fmt.Errorf("cannot read milestones without a player id")
+	}
+
+	ds.milestonesMutex.Lock()
+	defer ds.milestonesMutex.Unlock()
+
+	return &MilestonesData{PlayerID: playerID, Milestones: slices.Clone(ds.milestonesDB[keyOf(ctx, playerID)])}, nil
+}
+
+// ReachMilestones records the given milestones as reached by the given player (the ones they had already reached
+// are left as they are), and returns all the milestones of the player
+func (ds *Server) ReachMilestones(ctx context.Context, reach *MilestoneReach) (*MilestonesData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReachMilestones")
+	defer span.End()
+
+	if reach == nil || reach.PlayerID == "" {
+		return nil, fmt.Errorf("cannot reach milestones without a player id")
+	}
+
+	ds.milestonesMutex.Lock()
+	defer ds.milestonesMutex.Unlock()
+
+	key := keyOf(ctx, reach.PlayerID)
+	milestones := &MilestonesData{PlayerID: reach.PlayerID, Milestones: ds.milestonesDB[key]}
+
+	for _, milestoneID := range reach.MilestoneIDs {
+		if _, reached := milestones.State(milestoneID); reached || milestoneID == "" {
+			continue
+		}
+
+		ds.logger.Printf("recording milestone: %v as reached by id: %v", milestoneID, reach.PlayerID)
+		milestones.Milestones = append(milestones.Milestones, MilestoneState{MilestoneID: milestoneID, ReachTime: reach.Time})
+	}
+
+	ds.milestonesDB[key] = milestones.Milestones
+
+	milestones.Milestones = slices.Clone(milestones.Milestones)
+	return milestones, nil
+}
+
+// ClaimMilestone marks the given milestone of the given player as claimed, it can only be done once
+// (and only for a milestone the player reached), so the rewards of a milestone are never given twice
+func (ds *Server) ClaimMilestone(ctx context.Context, claim *MilestoneClaim) (*MilestonesData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ClaimMilestone")
+	defer span.End()
+
+	if claim == nil || claim.PlayerID == "" {
+		return nil, fmt.Errorf("cannot claim a milestone without a player id")
+	}
+
+	ds.milestonesMutex.Lock()
+	defer ds.milestonesMutex.Unlock()
+
+	key := keyOf(ctx, claim.PlayerID)
+	milestones := &MilestonesData{PlayerID: claim.PlayerID, Milestones: slices.Clone(ds.milestonesDB[key])}
+
+	state, reached := milestones.State(claim.MilestoneID)
+	if !reached {
+		return nil, MilestoneNotReachedErr{PlayerID: claim.PlayerID, MilestoneID: claim.MilestoneID}
+	}
+	if state.ClaimTime != 0 {
+		return nil, MilestoneAlreadyClaimedErr{PlayerID: claim.PlayerID, MilestoneID: claim.MilestoneID}
+	}
+
+	ds.logger.Printf("claiming milestone: %v for id: %v", claim.MilestoneID, claim.PlayerID)
+
+	state.ClaimTime = claim.Time
+	ds.milestonesDB[key] = slices.Clone(milestones.Milestones)
+
+	return milestones, nil
+}
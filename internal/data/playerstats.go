@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+// PlayerWithStats is used as the request body for the internal request to write a player and their stats together
+// (like the results of a level), so either both are written or neither is
+type PlayerWithStats struct {
+	Player PlayerData  `json:"player"`
+	Stats  PlayerStats `json:"stats"`
+}
+
+// HandleWritePlayerAndStatsRequest writes the player and their stats in the request body together
+func (ds *Server) HandleWritePlayerAndStatsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a PlayerWithStats struct
+	decodedReq := &PlayerWithStats{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	if decodedReq.Player.PlayerID == "" {
+		errMsg := "error: cannot write an entry with a blank player id"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	err = ds.WritePlayerAndStats(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not write player and stats: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// WritePlayerAndStats writes the given player data and stats to their DB entries (creating them if not present),
+// holding the locks of both entries, so no reader sees one written without the other
+func (ds *Server) WritePlayerAndStats(ctx context.Context, playerWithStats *PlayerWithStats) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.WritePlayerAndStats")
+	defer span.End()
+
+	if playerWithStats == nil {
+		return fmt.Errorf("provided player with stats pointer is nil")
+	}
+
+	playerID := playerWithStats.Player.PlayerID
+	ds.logger.Printf("writing player and stats DB entries for id: %v", playerID)
+
+	// records are always stored in the current version
+	storedPlayer := playerWithStats.Player.Clone()
+	err := upgradePlayer(&storedPlayer)
+	if err != nil {
+		return err
+	}
+
+	storedStats := copyStats(playerWithStats.Stats)
+	err = upgradeStats(storedStats)
+	if err != nil {
+		return err
+	}
+
+	key := keyOf(ctx, playerID)
+	if ds.playerStore != nil {
+		return ds.playerStore.WritePlayerAndStats(ctx, key.Namespace, &storedPlayer, storedStats)
+	}
+
+	// archived players are brought back to memory first, so their stats are not left behind in the cold store
+	err = ds.rehydrate(key)
+	if err != nil {
+		return err
+	}
+
+	// the players shard is locked before the stats shard, like everywhere both are held
+	playersShard := ds.playersDB.shardOf(key)
+	playersShard.mutex.Lock()
+	defer playersShard.mutex.Unlock()
+
+	statsShard := ds.statsDB.shardOf(key)
+	statsShard.mutex.Lock()
+	defer statsShard.mutex.Unlock()
+
+	if old, ok := playersShard.entries[key]; ok {
+		ds.recordPlayerChange(key, &old, storedPlayer)
+	} else {
+		ds.recordPlayerChange(key, nil, storedPlayer)
+	}
+
+	if old, ok := statsShard.entries[key]; ok {
+		ds.recordStatsChange(key, &old, *storedStats)
+		ds.stampStatsChanges(key, &old, *storedStats)
+		ds.levelIndex.update(key, &old, storedStats)
+	} else {
+		ds.recordStatsChange(key, nil, *storedStats)
+		ds.stampStatsChanges(key, nil, *storedStats)
+		ds.levelIndex.update(key, nil, storedStats)
+	}
+
+	playersShard.entries[key] = storedPlayer
+	statsShard.entries[key] = *storedStats
+	ds.replicate(key, &storedPlayer, storedStats)
+
+	return nil
+}
+
+// WritePlayerAndStats makes an internal request to the data service to write a player and their stats together
+func (hc *HTTPClient) WritePlayerAndStats(ctx context.Context, playerWithStats *PlayerWithStats) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	return hc.postInternal(ctx, "/data/player-stats-internal", playerWithStats, "player and stats")
+}
+
+// WritePlayerAndStats writes the player and their stats with the wrapped client, and invalidates both cached entries
+func (cc *CachedClient) WritePlayerAndStats(ctx context.Context, playerWithStats *PlayerWithStats) error {
+
+	if playerWithStats == nil {
+		return cc.DataClient.WritePlayerAndStats(ctx, playerWithStats)
+	}
+
+	key := keyOf(ctx, playerWithStats.Player.PlayerID)
+	defer cc.players.invalidate(key)
+	defer cc.stats.invalidate(key)
+	return cc.DataClient.WritePlayerAndStats(ctx, playerWithStats)
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+// PlayerStore implementor keeps the players and their stats outside of the data service (in redis, postgres etc.),
+// so several replicas of the data service can share them. Players are identified by their namespace and their player id,
+// SwapPlayer returns PlayerNotFoundErr / PlayerChangedErr like the in memory swap does. MergePlayers writes the merged
+// player over the primary player with their merged stats (see mergedStatsOf), removes the secondary player and their stats,
+// and stores the redirect of the secondary player id, all at once. It returns the merged stats, or PlayerRetiredErr /
+// PlayerNotFoundErr / PlayerChangedErr like the in memory merge does
+type PlayerStore interface {
+	ReadPlayer(ctx context.Context, namespace string, playerID string) (*PlayerData, bool, error)
+	WritePlayer(ctx context.Context, namespace string, player *PlayerData) error
+	SwapPlayer(ctx context.Context, namespace string, expected *PlayerData, updated *PlayerData) error
+	ReadStats(ctx context.Context, namespace string, playerID string) (*PlayerStats, bool, error)
+	WriteStats(ctx context.Context, namespace string, playerID string, plStats *PlayerStats) error
+	WritePlayerAndStats(ctx context.Context, namespace string, player *PlayerData, plStats *PlayerStats) error
+	ReadAllPlayers(ctx context.Context, namespace string) (map[string]PlayerData, error)
+	ReadAllStats(ctx context.Context, namespace string) (map[string]PlayerStats, error)
+	MergePlayers(ctx context.Context, namespace string, merge *PlayerMerge) (*PlayerStats, error)
+	ReadRedirect(ctx context.Context, namespace string, playerID string) (*PlayerRedirect, bool, error)
+}
+
+// EnablePlayerStore keeps the players and their stats in the given store (instead of in memory) from now on.
+// Archival, backups, event sourcing and replication work on the state held by a single data service, so they cannot be used with it
+func (ds *Server) EnablePlayerStore(store PlayerStore) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	ds.archiveMutex.Lock()
+	archival := ds.coldStore != nil
+	ds.archiveMutex.Unlock()
+
+	ds.backupMutex.Lock()
+	backups := ds.backupStore != nil
+	ds.backupMutex.Unlock()
+
+	ds.eventsMutex.Lock()
+	eventSourcing := ds.eventStreams != nil
+	ds.eventsMutex.Unlock()
+
+	ds.replicationMutex.Lock()
+	replication := ds.replication != nil
+	ds.replicationMutex.Unlock()
+
+	ds.replicaMutex.Lock()
+	replication = replication || ds.replica != nil
+	ds.replicaMutex.Unlock()
+
+	if archival || backups || eventSourcing || replication {
+		return fmt.Errorf("a player store cannot be used together with archival, backups, event sourcing or replication")
+	}
+
+	ds.playerStore = store
+	return nil
+}
+
+// playersOf returns all the players of the given namespace (from the player store, or a snapshot of the players DB)
+func (ds *Server) playersOf(ctx context.Context, playerNamespace string) (map[dbKey]PlayerData, error) {
+
+	if ds.playerStore == nil {
+		return ds.playersDB.snapshot(), nil
+	}
+
+	players, err := ds.playerStore.ReadAllPlayers(ctx, playerNamespace)
+	return keyedBy(playerNamespace, players), err
+}
+
+// statsOf returns the stats of all the players of the given namespace (from the player store, or a snapshot of the stats DB)
+func (ds *Server) statsOf(ctx context.Context, playerNamespace string) (map[dbKey]PlayerStats, error) {
+
+	if ds.playerStore == nil {
+		return ds.statsDB.snapshot(), nil
+	}
+
+	allStats, err := ds.playerStore.ReadAllStats(ctx, playerNamespace)
+	return keyedBy(playerNamespace, allStats), err
+}
+
+// keyedBy returns the given entries (by player id) keyed by their DB keys in the given namespace
+func keyedBy[V any](playerNamespace string, entries map[string]V) map[dbKey]V {
+
+	keyed := make(map[dbKey]V, len(entries))
+	for id, entry := range entries {
+		keyed[dbKey{Namespace: playerNamespace, ID: id}] = entry
+	}
+	return keyed
+}
@@ -0,0 +1,444 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// postgresMigrations are the schema migrations of the postgres store, in order. Each one is applied once,
+// in its own transaction, and recorded in the schema_migrations table (new migrations are only ever appended)
+var postgresMigrations = []string{
+	`CREATE TABLE players (
+		namespace TEXT NOT NULL,
+		player_id TEXT NOT NULL,
+		data JSONB NOT NULL,
+		PRIMARY KEY (namespace, player_id)
+	)`,
+	`CREATE TABLE player_stats (
+		namespace TEXT NOT NULL,
+		player_id TEXT NOT NULL,
+		data JSONB NOT NULL,
+		PRIMARY KEY (namespace, player_id)
+	)`,
+	`CREATE TABLE leases (
+		namespace TEXT NOT NULL,
+		name TEXT NOT NULL,
+		holder TEXT NOT NULL,
+		expiry_time BIGINT NOT NULL,
+		PRIMARY KEY (namespace, name)
+	)`,
+	`CREATE TABLE player_redirects (
+		namespace TEXT NOT NULL,
+		player_id TEXT NOT NULL,
+		data JSONB NOT NULL,
+		PRIMARY KEY (namespace, player_id)
+	)`,
+}
+
+// the statements of the postgres store, prepared once when it is created
+const (
+	pgReadPlayer     = `SELECT data FROM players WHERE namespace = $1 AND player_id = $2`
+	pgLockPlayer     = `SELECT data FROM players WHERE namespace = $1 AND player_id = $2 FOR UPDATE`
+	pgWritePlayer    = `INSERT INTO players (namespace, player_id, data) VALUES ($1, $2, $3) ON CONFLICT (namespace, player_id) DO UPDATE SET data = EXCLUDED.data`
+	pgReadAllPlayers = `SELECT player_id, data FROM players WHERE namespace = $1`
+	pgReadStats      = `SELECT data FROM player_stats WHERE namespace = $1 AND player_id = $2`
+	pgWriteStats     = `INSERT INTO player_stats (namespace, player_id, data) VALUES ($1, $2, $3) ON CONFLICT (namespace, player_id) DO UPDATE SET data = EXCLUDED.data`
+	pgReadAllStats   = `SELECT player_id, data FROM player_stats WHERE namespace = $1`
+	pgLockStats      = `SELECT data FROM player_stats WHERE namespace = $1 AND player_id = $2 FOR UPDATE`
+	pgDeletePlayer   = `DELETE FROM players WHERE namespace = $1 AND player_id = $2`
+	pgDeleteStats    = `DELETE FROM player_stats WHERE namespace = $1 AND player_id = $2`
+	pgReadRedirect   = `SELECT data FROM player_redirects WHERE namespace = $1 AND player_id = $2`
+	pgWriteRedirect  = `INSERT INTO player_redirects (namespace, player_id, data) VALUES ($1, $2, $3)`
+
+	// the lease is only taken over if it is held by the same holder, or has expired (no row is returned otherwise)
+	pgAcquireLease = `INSERT INTO leases (namespace, name, holder, expiry_time) VALUES ($1, $2, $3, $4) ON CONFLICT (namespace, name) DO UPDATE SET holder = EXCLUDED.holder, expiry_time = EXCLUDED.expiry_time WHERE leases.holder = EXCLUDED.holder OR leases.expiry_time <= $5 RETURNING holder`
+	pgReadLease    = `SELECT holder FROM leases WHERE namespace = $1 AND name = $2`
+	pgReleaseLease = `DELETE FROM leases WHERE namespace = $1 AND name = $2 AND holder = $3`
+)
+
+// PostgresStore keeps the players and their stats in postgres tables (as jsonb), so several replicas of the data service
+// can share them. It uses database/sql, with the driver registered under the name it is given (the runners import the
+// pgx driver, registered as constants.PostgresDriverName)
+type PostgresStore struct {
+	db         *sql.DB
+	statements map[string]*sql.Stmt
+}
+
+// NewPostgresStore opens the postgres database with the given connection string, migrates its schema to the latest
+// version, and prepares the statements of the store
+func NewPostgresStore(ctx context.Context, driverName string, dsn string) (*PostgresStore, error) {
+
+	if !slices.Contains(sql.Drivers(), driverName) {
+		return nil, fmt.Errorf("no database driver registered as %q, a postgres driver has to be imported", driverName)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &PostgresStore{db: db, statements: map[string]*sql.Stmt{}}
+
+	err = store.migrate(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not migrate the postgres schema: %w", err)
+	}
+
+	queries := []string{pgReadPlayer, pgLockPlayer, pgWritePlayer, pgReadAllPlayers, pgReadStats, pgWriteStats, pgReadAllStats, pgLockStats, pgDeletePlayer, pgDeleteStats, pgReadRedirect, pgWriteRedirect, pgAcquireLease, pgReadLease, pgReleaseLease}
+	for _, query := range queries {
+		store.statements[query], err = db.PrepareContext(ctx, query)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("could not prepare a postgres statement: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+// migrate applies the schema migrations which were not applied yet. The migrations table is locked while each one
+// is applied, so replicas starting at the same time never apply the same migration twice
+func (ps *PostgresStore) migrate(ctx context.Context) error {
+
+	_, err := ps.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	for {
+		applied := 0
+		err = ps.inTx(ctx, func(tx *sql.Tx) error {
+
+			_, err := tx.ExecContext(ctx, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`)
+			if err != nil {
+				return err
+			}
+
+			err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&applied)
+			if err != nil || applied >= len(postgresMigrations) {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, postgresMigrations[applied])
+			if err != nil {
+				return fmt.Errorf("migration %v: %w", applied+1, err)
+			}
+
+			_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, applied+1)
+			return err
+		})
+
+		if err != nil || applied >= len(postgresMigrations) {
+			return err
+		}
+	}
+}
+
+// inTx runs the given function in a transaction, which is committed if it succeeds (and rolled back otherwise)
+func (ps *PostgresStore) inTx(ctx context.Context, run func(tx *sql.Tx) error) error {
+
+	tx, err := ps.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = run(tx)
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Close closes the prepared statements, and the database
+func (ps *PostgresStore) Close() error {
+
+	for _, statement := range ps.statements {
+		statement.Close()
+	}
+	return ps.db.Close()
+}
+
+// ReadPlayer returns the player with the given id in the given namespace, and whether they were found
+func (ps *PostgresStore) ReadPlayer(ctx context.Context, playerNamespace string, playerID string) (*PlayerData, bool, error) {
+	return readPostgres[PlayerData](ctx, ps.statements[pgReadPlayer], playerNamespace, playerID)
+}
+
+// WritePlayer writes the given player, in the given namespace
+func (ps *PostgresStore) WritePlayer(ctx context.Context, playerNamespace string, player *PlayerData) error {
+	return writePostgres(ctx, ps.statements[pgWritePlayer], playerNamespace, player.PlayerID, player)
+}
+
+// SwapPlayer writes the updated player, if the player still holds the expected player data
+// (the row of the player is locked from the read to the commit)
+func (ps *PostgresStore) SwapPlayer(ctx context.Context, playerNamespace string, expected *PlayerData, updated *PlayerData) error {
+
+	return ps.inTx(ctx, func(tx *sql.Tx) error {
+
+		player, found, err := readPostgres[PlayerData](ctx, tx.StmtContext(ctx, ps.statements[pgLockPlayer]), playerNamespace, expected.PlayerID)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return PlayerNotFoundErr{expected.PlayerID}
+		}
+
+		if !player.Equal(*expected) {
+			return PlayerChangedErr{expected.PlayerID}
+		}
+
+		return writePostgres(ctx, tx.StmtContext(ctx, ps.statements[pgWritePlayer]), playerNamespace, updated.PlayerID, updated)
+	})
+}
+
+// ReadStats returns the stats of the player with the given id in the given namespace, and whether they were found
+func (ps *PostgresStore) ReadStats(ctx context.Context, playerNamespace string, playerID string) (*PlayerStats, bool, error) {
+	return readPostgres[PlayerStats](ctx, ps.statements[pgReadStats], playerNamespace, playerID)
+}
+
+// WriteStats writes the given stats of the player with the given id, in the given namespace
+func (ps *PostgresStore) WriteStats(ctx context.Context, playerNamespace string, playerID string, plStats *PlayerStats) error {
+	return writePostgres(ctx, ps.statements[pgWriteStats], playerNamespace, playerID, plStats)
+}
+
+// WritePlayerAndStats writes the given player and their stats in one transaction
+func (ps *PostgresStore) WritePlayerAndStats(ctx context.Context, playerNamespace string, player *PlayerData, plStats *PlayerStats) error {
+
+	return ps.inTx(ctx, func(tx *sql.Tx) error {
+
+		err := writePostgres(ctx, tx.StmtContext(ctx, ps.statements[pgWritePlayer]), playerNamespace, player.PlayerID, player)
+		if err != nil {
+			return err
+		}
+
+		return writePostgres(ctx, tx.StmtContext(ctx, ps.statements[pgWriteStats]), playerNamespace, player.PlayerID, plStats)
+	})
+}
+
+// ReadAllPlayers returns all the players of the given namespace, by player id
+func (ps *PostgresStore) ReadAllPlayers(ctx context.Context, playerNamespace string) (map[string]PlayerData, error) {
+	return readAllPostgres[PlayerData](ctx, ps.statements[pgReadAllPlayers], playerNamespace)
+}
+
+// ReadAllStats returns the stats of all the players of the given namespace, by player id
+func (ps *PostgresStore) ReadAllStats(ctx context.Context, playerNamespace string) (map[string]PlayerStats, error) {
+	return readAllPostgres[PlayerStats](ctx, ps.statements[pgReadAllStats], playerNamespace)
+}
+
+// MergePlayers merges the secondary player into the primary one in one transaction: the rows of both players
+// (and their stats) are locked from the reads to the commit
+func (ps *PostgresStore) MergePlayers(ctx context.Context, playerNamespace string, merge *PlayerMerge) (*PlayerStats, error) {
+
+	primaryID, secondaryID := merge.Expected.PlayerID, merge.ExpectedSecondary.PlayerID
+	mergedStats := PlayerStats{}
+
+	err := ps.inTx(ctx, func(tx *sql.Tx) error {
+
+		// the rows are locked in player id order, so merges of the same two players the other way round cannot deadlock
+		lockOrder := []string{primaryID, secondaryID}
+		slices.Sort(lockOrder)
+
+		players := map[string]*PlayerData{}
+		stats := map[string]*PlayerStats{}
+		for _, playerID := range lockOrder {
+			player, _, err := readPostgres[PlayerData](ctx, tx.StmtContext(ctx, ps.statements[pgLockPlayer]), playerNamespace, playerID)
+			if err != nil {
+				return err
+			}
+			players[playerID] = player
+
+			plStats, _, err := readPostgres[PlayerStats](ctx, tx.StmtContext(ctx, ps.statements[pgLockStats]), playerNamespace, playerID)
+			if err != nil {
+				return err
+			}
+			stats[playerID] = plStats
+		}
+
+		// the redirects are read once the rows are locked, so a merge which retired either player in between is seen
+		for _, playerID := range []string{primaryID, secondaryID} {
+			_, retired, err := readPostgres[PlayerRedirect](ctx, tx.StmtContext(ctx, ps.statements[pgReadRedirect]), playerNamespace, playerID)
+			if err != nil {
+				return err
+			}
+			if retired {
+				return PlayerRetiredErr{playerID}
+			}
+		}
+
+		for _, expected := range []*PlayerData{&merge.Expected, &merge.ExpectedSecondary} {
+			player := players[expected.PlayerID]
+			if player == nil {
+				return PlayerNotFoundErr{expected.PlayerID}
+			}
+			if !player.Equal(*expected) {
+				return PlayerChangedErr{expected.PlayerID}
+			}
+		}
+
+		var keepStats bool
+		var err error
+		mergedStats, keepStats, err = mergedStatsOf(stats[primaryID], stats[secondaryID])
+		if err != nil {
+			return err
+		}
+
+		err = writePostgres(ctx, tx.StmtContext(ctx, ps.statements[pgWritePlayer]), playerNamespace, primaryID, &merge.Merged)
+		if err != nil {
+			return err
+		}
+
+		if keepStats {
+			err = writePostgres(ctx, tx.StmtContext(ctx, ps.statements[pgWriteStats]), playerNamespace, primaryID, &mergedStats)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, statement := range []string{pgDeletePlayer, pgDeleteStats} {
+			_, err = tx.StmtContext(ctx, ps.statements[statement]).ExecContext(ctx, playerNamespace, secondaryID)
+			if err != nil {
+				return err
+			}
+		}
+
+		redirect := &PlayerRedirect{PlayerID: secondaryID, MergedInto: primaryID, MergeTime: merge.MergeTime}
+		return writePostgres(ctx, tx.StmtContext(ctx, ps.statements[pgWriteRedirect]), playerNamespace, secondaryID, redirect)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return &mergedStats, nil
+}
+
+// ReadRedirect returns the redirect of the given (retired) player id in the given namespace, and whether it was found
+func (ps *PostgresStore) ReadRedirect(ctx context.Context, playerNamespace string, playerID string) (*PlayerRedirect, bool, error) {
+	return readPostgres[PlayerRedirect](ctx, ps.statements[pgReadRedirect], playerNamespace, playerID)
+}
+
+// AcquireLease gives the lease to its holder, if it is not held by another holder (or has expired)
+func (ps *PostgresStore) AcquireLease(ctx context.Context, leaseNamespace string, lease *LeaseData, unixNow int64) error {
+
+	holder := ""
+	err := ps.statements[pgAcquireLease].QueryRowContext(ctx, leaseNamespace, lease.Name, lease.Holder, lease.ExpiryTime, unixNow).Scan(&holder)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// the lease was not taken over, so it is held by another holder
+	err = ps.statements[pgReadLease].QueryRowContext(ctx, leaseNamespace, lease.Name).Scan(&holder)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return LeaseHeldErr{Name: lease.Name, Holder: holder}
+}
+
+// ReleaseLease releases the lease, if it is held by the given holder
+func (ps *PostgresStore) ReleaseLease(ctx context.Context, leaseNamespace string, name string, holder string) error {
+	_, err := ps.statements[pgReleaseLease].ExecContext(ctx, leaseNamespace, name, holder)
+	return err
+}
+
+// readPostgres decodes the json data of the row read with the given statement, and returns whether it was found
+// (the records are upgraded to the current version as they are decoded)
+func readPostgres[V any](ctx context.Context, statement *sql.Stmt, playerNamespace string, playerID string) (*V, bool, error) {
+
+	var encoded []byte
+	err := statement.QueryRowContext(ctx, playerNamespace, playerID).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	value := new(V)
+	err = json.Unmarshal(encoded, value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+// writePostgres encodes the given value as json, and upserts it with the given statement
+func writePostgres[V any](ctx context.Context, statement *sql.Stmt, playerNamespace string, playerID string, value V) error {
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = statement.ExecContext(ctx, playerNamespace, playerID, encoded)
+	return err
+}
+
+// readAllPostgres decodes the json data of all the rows of the given namespace read with the given statement, by player id
+func readAllPostgres[V any](ctx context.Context, statement *sql.Stmt, playerNamespace string) (map[string]V, error) {
+
+	rows, err := statement.QueryContext(ctx, playerNamespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]V{}
+	for rows.Next() {
+		var id string
+		var encoded []byte
+		err = rows.Scan(&id, &encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		var decoded V
+		err = json.Unmarshal(encoded, &decoded)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode the data of id: %v: %w", id, err)
+		}
+		values[id] = decoded
+	}
+
+	return values, rows.Err()
+}
+
+// EnablePostgresFromEnv keeps the players and their stats in the postgres database with the connection string given by
+// the postgres dsn environment variable (see constants.PostgresDSNEnvVar), they stay in memory if it is not set
+func (ds *Server) EnablePostgresFromEnv() error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	dsn := os.Getenv(constants.PostgresDSNEnvVar)
+	if dsn == "" {
+		return nil
+	}
+
+	if ds.playerStore != nil {
+		return fmt.Errorf("%v and %v cannot both be set", constants.PostgresDSNEnvVar, constants.RedisAddrEnvVar)
+	}
+
+	store, err := NewPostgresStore(context.Background(), constants.PostgresDriverName, dsn)
+	if err != nil {
+		return err
+	}
+
+	ds.logger.Println("players and stats are kept in postgres")
+	return ds.EnablePlayerStore(store)
+}
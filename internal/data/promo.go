@@ -0,0 +1,298 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type PromoCodeNotFoundErr struct {
+	Code string
+}
+
+func (err PromoCodeNotFoundErr) Error() string {
+	return fmt.Sprintf("promo code: %v was not found in the promo codes DB", err.Code)
+}
+
+type PromoCodeExistsErr struct {
+	Code string
+}
+
+func (err PromoCodeExistsErr) Error() string {
+	return fmt.Sprintf("promo code: %v already exists", err.Code)
+}
+
+type PromoCodeUnavailableErr struct {
+	Code string
+}
+
+func (err PromoCodeUnavailableErr) Error() string {
+	return fmt.Sprintf("promo code: %v has expired, or has no uses left", err.Code)
+}
+
+type PromoCodeAlreadyRedeemedErr struct {
+	Code     string
+	PlayerID string
+}
+
+func (err PromoCodeAlreadyRedeemedErr) Error() string {
+	return fmt.Sprintf("promo code: %v has already been redeemed by id: %v", err.Code, err.PlayerID)
+}
+
+// PromoCode stores a code created by an admin, and what it grants when redeemed.
+// A max uses of 0 means the code can be used any number of times (but still only once per player),
+// and an expiry time of 0 means the code never expires, otherwise it can be redeemed till that unix time
+type PromoCode struct {
+	Code       string          `json:"code"`
+	Energy     int32           `json:"energy"`
+	Coins      int64           `json:"coins"`
+	Items      []InventoryItem `json:"items"`
+	MaxUses    int32           `json:"maxUses"`
+	Uses       int32           `json:"uses"`
+	ExpiryTime int64           `json:"expiryTime"`
+}
+
+// PromoRedemption is a single redemption of a promo code by a player, at the given unix time
+type PromoRedemption struct {
+	Code     string `json:"code"`
+	PlayerID string `json:"playerID"`
+	Time     int64  `json:"time"`
+}
+
+// NormalizePromoCode returns the form promo codes are stored in (codes are not case sensitive)
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks that the promo code has a code, and grants something (and nothing negative)
+func (pc *PromoCode) Validate() error {
+
+	if pc == nil {
+		return fmt.Errorf("provided promo code pointer is nil")
+	}
+
+	if NormalizePromoCode(pc.Code) == "" {
+		return fmt.Errorf("promo code cannot be blank")
+	}
+
+	if pc.Energy < 0 || pc.Coins < 0 || pc.MaxUses < 0 || pc.ExpiryTime < 0 {
+		return fmt.Errorf("promo code values cannot be negative")
+	}
+
+	for _, item := range pc.Items {
+		if item.ItemID == "" || item.Count <= 0 {
+			return fmt.Errorf("promo code items need an item id, and a count greater than 0")
+		}
+	}
+
+	if pc.Energy == 0 && pc.Coins == 0 && len(pc.Items) == 0 {
+		return fmt.Errorf("promo code does not grant anything")
+	}
+
+	return nil
+}
+
+// HandleCreatePromoCodeRequest adds a new entry to the promo codes DB
+func (ds *Server) HandleCreatePromoCodeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a PromoCode struct
+	decodedReq := &PromoCode{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	err = ds.CreatePromoCode(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not create promo code: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case PromoCodeExistsErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleRedeemPromoCodeRequest records the redemption of a promo code, and responds with the redeemed code
+func (ds *Server) HandleRedeemPromoCodeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a PromoRedemption struct
+	decodedReq := &PromoRedemption{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	promoCode, err := ds.RedeemPromoCode(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not redeem promo code: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case PromoCodeNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case PromoCodeUnavailableErr:
+			http.Error(w, errMsg, http.StatusGone)
+		case PromoCodeAlreadyRedeemedErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, promoCode, "promo code")
+}
+
+// HandleReadRedemptionsRequest responds with the promo code redemption history of the requested player
+func (ds *Server) HandleReadRedemptionsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	redemptions, err := ds.ReadRedemptions(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not read redemptions: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	ds.writeJSON(w, redemptions, "redemptions")
+}
+
+// CreatePromoCode adds a new (valid) promo code, codes which already exist are never overwritten
+func (ds *Server) CreatePromoCode(ctx context.Context, promoCode *PromoCode) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.CreatePromoCode")
+	defer span.End()
+
+	err := promoCode.Validate()
+	if err != nil {
+		return err
+	}
+
+	newCode := *promoCode
+	newCode.Code = NormalizePromoCode(promoCode.Code)
+	newCode.Items = append([]InventoryItem{}, promoCode.Items...)
+	newCode.Uses = 0
+
+	ds.promoMutex.Lock()
+	defer ds.promoMutex.Unlock()
+
+	key := keyOf(ctx, newCode.Code)
+	_, ok := ds.promoCodesDB[key]
+	if ok {
+		return PromoCodeExistsErr{newCode.Code}
+	}
+
+	ds.logger.Printf("creating promo codes DB entry for code: %v", newCode.Code)
+	ds.promoCodesDB[key] = newCode
+
+	return nil
+}
+
+// RedeemPromoCode checks that the promo code can still be redeemed by the player at the redemption time,
+// then counts the use and adds the redemption to the player's history, all under the same lock,
+// so a code can never be redeemed twice by a player, or more times than its max uses
+func (ds *Server) RedeemPromoCode(ctx context.Context, redemption *PromoRedemption) (*PromoCode, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.RedeemPromoCode")
+	defer span.End()
+
+	if redemption == nil || redemption.PlayerID == "" {
+		return nil, fmt.Errorf("cannot redeem a promo code without a player id")
+	}
+
+	code := NormalizePromoCode(redemption.Code)
+	codeKey := keyOf(ctx, code)
+	playerKey := keyOf(ctx, redemption.PlayerID)
+
+	ds.promoMutex.Lock()
+	defer ds.promoMutex.Unlock()
+
+	promoCode, ok := ds.promoCodesDB[codeKey]
+	if !ok {
+		return nil, PromoCodeNotFoundErr{code}
+	}
+
+	for _, previous := range ds.redemptionsDB[playerKey] {
+		if previous.Code == code {
+			return nil, PromoCodeAlreadyRedeemedErr{code, redemption.PlayerID}
+		}
+	}
+
+	if (promoCode.ExpiryTime != 0 && redemption.Time >= promoCode.ExpiryTime) || (promoCode.MaxUses != 0 && promoCode.Uses >= promoCode.MaxUses) {
+		return nil, PromoCodeUnavailableErr{code}
+	}
+
+	ds.logger.Printf("redeeming promo code: %v for id: %v", code, redemption.PlayerID)
+
+	promoCode.Uses++
+	ds.promoCodesDB[codeKey] = promoCode
+	ds.redemptionsDB[playerKey] = append(ds.redemptionsDB[playerKey], PromoRedemption{Code: code, PlayerID: redemption.PlayerID, Time: redemption.Time})
+
+	promoCode.Items = append([]InventoryItem{}, promoCode.Items...)
+	return &promoCode, nil
+}
+
+// ReadRedemptions returns the promo code redemption history of the given player (empty if there is none)
+func (ds *Server) ReadRedemptions(ctx context.Context, playerID string) ([]PromoRedemption, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadRedemptions")
+	defer span.End()
+
+	ds.promoMutex.Lock()
+	defer ds.promoMutex.Unlock()
+
+	return append([]PromoRedemption{}, ds.redemptionsDB[keyOf(ctx, playerID)]...), nil
+}
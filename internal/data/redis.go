@@ -0,0 +1,739 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redis store related constants
+const redisKeyPrefix = "dice:player:"
+const redisLeaseKeyPrefix = "dice:lease:"
+const redisLeaseField = "lease"
+const redisPlayerField = "player"
+const redisStatsField = "stats"
+const redisRedirectField = "redirect"
+const redisScanBatch = 100
+const redisIdleConns = 16
+const redisDialTimeout time.Duration = 5 * time.Second
+
+var redisConflictError = fmt.Errorf("redis entry changed while it was being swapped")
+
+// RedisErr is an error reply from the redis server
+type RedisErr struct {
+	Message string
+}
+
+func (err RedisErr) Error() string {
+	return "redis error: " + err.Message
+}
+
+// RedisStore keeps the players and their stats in redis, so several replicas of the data service can share them.
+// Every player is a hash (keyed by their namespace and player id) with a player field and a stats field, both json
+// (the hash of a player id retired by a merge only holds a redirect field).
+// It talks to redis over its own minimal RESP client, with a small pool of idle connections
+type RedisStore struct {
+	addr string
+	idle chan *redisConn
+}
+
+// redisConn is a connection to the redis server, with buffered reads and writes. It is broken after a failed
+// read or write, as its replies can no longer be matched to its commands
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	broken bool
+}
+
+// NewRedisStore returns an initialized pointer to a redis store for the server at the given address (host:port),
+// after checking that the server can be reached
+func NewRedisStore(addr string) (*RedisStore, error) {
+
+	store := &RedisStore{addr: addr, idle: make(chan *redisConn, redisIdleConns)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+
+	_, err := store.do(ctx, "PING")
+	if err != nil {
+		return nil, fmt.Errorf("could not reach redis at %v: %w", addr, err)
+	}
+
+	return store, nil
+}
+
+// redisKey returns the key of the hash of the player with the given key (namespaces cannot hold a colon,
+// so everything after the colon following the namespace is the player id)
+func redisKey(key dbKey) string {
+	return redisKeyPrefix + key.Namespace + ":" + key.ID
+}
+
+// read returns the json held in the given field of the hash of the given key, and whether it was found
+func (rs *RedisStore) read(ctx context.Context, key dbKey, field string) ([]byte, bool, error) {
+
+	reply, err := rs.do(ctx, "HGET", redisKey(key), field)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if reply == nil {
+		return nil, false, nil
+	}
+
+	return reply.([]byte), true, nil
+}
+
+// write sets the given field of the hash of the given key to the given json
+func (rs *RedisStore) write(ctx context.Context, key dbKey, field string, value []byte) error {
+	_, err := rs.do(ctx, "HSET", redisKey(key), field, string(value))
+	return err
+}
+
+// swap sets the given field of the hash with the given key to the updated json, if check accepts the json it holds
+// (check is called with nil if the field is not set). It returns redisConflictError if the field changed in between
+func (rs *RedisStore) swap(ctx context.Context, hashKey string, field string, updated []byte, check func(current []byte) error) error {
+
+	conn, err := rs.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = rs.swapOn(conn, hashKey, field, updated, check)
+	rs.put(conn)
+	return err
+}
+
+// swapOn runs the swap on the given connection: the hash is watched, so the transaction is aborted if it changes
+// after the current json is read
+func (rs *RedisStore) swapOn(conn *redisConn, hashKey string, field string, updated []byte, check func(current []byte) error) error {
+
+	_, err := conn.call("WATCH", hashKey)
+	if err != nil {
+		return err
+	}
+
+	reply, err := conn.call("HGET", hashKey, field)
+	if err != nil {
+		_, unwatchErr := conn.call("UNWATCH")
+		return errors.Join(err, unwatchErr)
+	}
+
+	var current []byte
+	if reply != nil {
+		current = reply.([]byte)
+	}
+
+	err = check(current)
+	if err != nil {
+		_, unwatchErr := conn.call("UNWATCH")
+		return errors.Join(err, unwatchErr)
+	}
+
+	replies, err := conn.pipeline([][]string{{"MULTI"}, {"HSET", hashKey, field, string(updated)}, {"EXEC"}})
+	if err != nil {
+		return err
+	}
+
+	// a nil reply to EXEC means the transaction was aborted, as the watched hash changed
+	if replies[2] == nil {
+		return redisConflictError
+	}
+
+	return nil
+}
+
+// readAll returns the json held in the given field of the hashes of all the players of the given namespace, by player id.
+// The keys are scanned in batches, and the fields of each batch are read in one pipeline
+func (rs *RedisStore) readAll(ctx context.Context, playerNamespace string, field string) (map[string][]byte, error) {
+
+	conn, err := rs.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := rs.readAllOn(conn, playerNamespace, field)
+	rs.put(conn)
+	return values, err
+}
+
+// readAllOn runs readAll on the given connection
+func (rs *RedisStore) readAllOn(conn *redisConn, playerNamespace string, field string) (map[string][]byte, error) {
+
+	namespacePrefix := redisKeyPrefix + playerNamespace + ":"
+	values := map[string][]byte{}
+
+	cursor := "0"
+	for {
+		reply, err := conn.call("SCAN", cursor, "MATCH", namespacePrefix+"*", "COUNT", strconv.Itoa(redisScanBatch))
+		if err != nil {
+			return nil, err
+		}
+
+		scan, ok := reply.([]any)
+		if !ok || len(scan) != 2 {
+			return nil, fmt.Errorf("unexpected reply to SCAN")
+		}
+
+		keys, _ := scan[1].([]any)
+		commands := make([][]string, 0, len(keys))
+		for _, hashKey := range keys {
+			commands = append(commands, []string{"HGET", string(hashKey.([]byte)), field})
+		}
+
+		replies, err := conn.pipeline(commands)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, hashKey := range keys {
+			if replies[i] != nil {
+				values[strings.TrimPrefix(string(hashKey.([]byte)), namespacePrefix)] = replies[i].([]byte)
+			}
+		}
+
+		cursor = string(scan[0].([]byte))
+		if cursor == "0" {
+			return values, nil
+		}
+	}
+}
+
+// do runs a single command on a pooled connection, and returns its reply
+func (rs *RedisStore) do(ctx context.Context, args ...string) (any, error) {
+
+	conn, err := rs.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.call(args...)
+	rs.put(conn)
+	return reply, err
+}
+
+// get returns an idle connection (or a new one), with the deadline of the given context (if it has one)
+func (rs *RedisStore) get(ctx context.Context) (*redisConn, error) {
+
+	var conn *redisConn
+	select {
+	case conn = <-rs.idle:
+	default:
+		dialer := net.Dialer{Timeout: redisDialTimeout}
+		netConn, err := dialer.DialContext(ctx, "tcp", rs.addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = &redisConn{conn: netConn, reader: bufio.NewReader(netConn), writer: bufio.NewWriter(netConn)}
+	}
+
+	// without a deadline in the context, the zero deadline clears the one left by an earlier use
+	deadline, _ := ctx.Deadline()
+	err := conn.conn.SetDeadline(deadline)
+	if err != nil {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// put returns the connection to the idle pool, unless it is broken or the pool is full, in which case it is closed
+func (rs *RedisStore) put(conn *redisConn) {
+
+	if conn.broken {
+		conn.conn.Close()
+		return
+	}
+
+	select {
+	case rs.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// call sends a single command, and returns its reply
+func (rc *redisConn) call(args ...string) (any, error) {
+
+	replies, err := rc.pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline sends all the given commands at once, then reads all their replies. Replies are nil (for a missing value),
+// []byte (for strings), int64 (for integers) or []any (for arrays), the first error reply is returned as a RedisErr
+// once all the replies are read
+func (rc *redisConn) pipeline(commands [][]string) ([]any, error) {
+
+	if rc.broken {
+		return nil, fmt.Errorf("redis connection is broken")
+	}
+
+	for _, args := range commands {
+		fmt.Fprintf(rc.writer, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(rc.writer, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+
+	err := rc.writer.Flush()
+	if err != nil {
+		rc.broken = true
+		return nil, err
+	}
+
+	replies := make([]any, len(commands))
+	var replyErr error
+	for i := range commands {
+		replies[i], err = rc.receive()
+		if err != nil {
+			var redisErr RedisErr
+			if !errors.As(err, &redisErr) {
+				rc.broken = true
+				return nil, err
+			}
+			if replyErr == nil {
+				replyErr = err
+			}
+		}
+	}
+
+	return replies, replyErr
+}
+
+// receive reads and parses a single reply
+func (rc *redisConn) receive() (any, error) {
+
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed redis reply: %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return []byte(body), nil
+	case '-':
+		return nil, RedisErr{Message: body}
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		value := make([]byte, size+2)
+		_, err = io.ReadFull(rc.reader, value)
+		if err != nil {
+			return nil, err
+		}
+		return value[:size], nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		elements := make([]any, count)
+		for i := range elements {
+			elements[i], err = rc.receive()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type: %q", kind)
+	}
+}
+
+// EnableRedisFromEnv keeps the players and their stats in redis at the address given by the redis address environment
+// variable (see constants.RedisAddrEnvVar), they stay in memory if it is not set
+func (ds *Server) EnableRedisFromEnv() error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	addr := os.Getenv(constants.RedisAddrEnvVar)
+	if addr == "" {
+		return nil
+	}
+
+	store, err := NewRedisStore(addr)
+	if err != nil {
+		return err
+	}
+
+	ds.logger.Printf("players and stats are kept in redis at %v", addr)
+	return ds.EnablePlayerStore(store)
+}
+
+// ReadPlayer returns the player with the given id in the given namespace, and whether they were found
+func (rs *RedisStore) ReadPlayer(ctx context.Context, playerNamespace string, playerID string) (*PlayerData, bool, error) {
+	return readRedis[PlayerData](ctx, rs, dbKey{Namespace: playerNamespace, ID: playerID}, redisPlayerField)
+}
+
+// WritePlayer writes the given player, in the given namespace
+func (rs *RedisStore) WritePlayer(ctx context.Context, playerNamespace string, player *PlayerData) error {
+	return writeRedis(ctx, rs, dbKey{Namespace: playerNamespace, ID: player.PlayerID}, redisPlayerField, player)
+}
+
+// SwapPlayer writes the updated player, if the player still holds the expected player data
+func (rs *RedisStore) SwapPlayer(ctx context.Context, playerNamespace string, expected *PlayerData, updated *PlayerData) error {
+
+	encoded, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+
+	key := dbKey{Namespace: playerNamespace, ID: expected.PlayerID}
+	err = rs.swap(ctx, redisKey(key), redisPlayerField, encoded, func(current []byte) error {
+		if current == nil {
+			return PlayerNotFoundErr{key.ID}
+		}
+
+		player := PlayerData{}
+		err := json.Unmarshal(current, &player)
+		if err != nil {
+			return err
+		}
+
+		if !player.Equal(*expected) {
+			return PlayerChangedErr{key.ID}
+		}
+		return nil
+	})
+
+	// check errors are joined with the (usually nil) error of unwatching the hash
+	var notFoundErr PlayerNotFoundErr
+	var changedErr PlayerChangedErr
+	switch {
+	case errors.As(err, &notFoundErr):
+		return notFoundErr
+	case errors.As(err, &changedErr):
+		return changedErr
+	case errors.Is(err, redisConflictError):
+		return PlayerChangedErr{key.ID}
+	}
+	return err
+}
+
+// ReadStats returns the stats of the player with the given id in the given namespace, and whether they were found
+func (rs *RedisStore) ReadStats(ctx context.Context, playerNamespace string, playerID string) (*PlayerStats, bool, error) {
+	return readRedis[PlayerStats](ctx, rs, dbKey{Namespace: playerNamespace, ID: playerID}, redisStatsField)
+}
+
+// WriteStats writes the given stats of the player with the given id, in the given namespace
+func (rs *RedisStore) WriteStats(ctx context.Context, playerNamespace string, playerID string, plStats *PlayerStats) error {
+	return writeRedis(ctx, rs, dbKey{Namespace: playerNamespace, ID: playerID}, redisStatsField, plStats)
+}
+
+// WritePlayerAndStats writes the given player and their stats together (a single HSET of both fields is atomic)
+func (rs *RedisStore) WritePlayerAndStats(ctx context.Context, playerNamespace string, player *PlayerData, plStats *PlayerStats) error {
+
+	encodedPlayer, err := json.Marshal(player)
+	if err != nil {
+		return err
+	}
+
+	encodedStats, err := json.Marshal(plStats)
+	if err != nil {
+		return err
+	}
+
+	key := dbKey{Namespace: playerNamespace, ID: player.PlayerID}
+	_, err = rs.do(ctx, "HSET", redisKey(key), redisPlayerField, string(encodedPlayer), redisStatsField, string(encodedStats))
+	return err
+}
+
+// ReadAllPlayers returns all the players of the given namespace, by player id
+func (rs *RedisStore) ReadAllPlayers(ctx context.Context, playerNamespace string) (map[string]PlayerData, error) {
+	return readAllRedis[PlayerData](ctx, rs, playerNamespace, redisPlayerField)
+}
+
+// ReadAllStats returns the stats of all the players of the given namespace, by player id
+func (rs *RedisStore) ReadAllStats(ctx context.Context, playerNamespace string) (map[string]PlayerStats, error) {
+	return readAllRedis[PlayerStats](ctx, rs, playerNamespace, redisStatsField)
+}
+
+// MergePlayers merges the secondary player into the primary one in one transaction: the hashes of both players are
+// watched, so it is aborted if either changes after they are read
+func (rs *RedisStore) MergePlayers(ctx context.Context, playerNamespace string, merge *PlayerMerge) (*PlayerStats, error) {
+
+	conn, err := rs.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mergedStats, err := rs.mergeOn(conn, playerNamespace, merge)
+	rs.put(conn)
+
+	// check errors are joined with the (usually nil) error of unwatching the hashes
+	var retiredErr PlayerRetiredErr
+	var notFoundErr PlayerNotFoundErr
+	var changedErr PlayerChangedErr
+	switch {
+	case errors.As(err, &retiredErr):
+		return nil, retiredErr
+	case errors.As(err, &notFoundErr):
+		return nil, notFoundErr
+	case errors.As(err, &changedErr):
+		return nil, changedErr
+	case errors.Is(err, redisConflictError):
+		return nil, PlayerChangedErr{merge.Expected.PlayerID}
+	}
+	return mergedStats, err
+}
+
+// mergeOn runs the merge on the given connection: the player, stats and redirect fields of both hashes are read,
+// then the merged player and stats are set, the hash of the secondary player is replaced by their redirect
+func (rs *RedisStore) mergeOn(conn *redisConn, playerNamespace string, merge *PlayerMerge) (*PlayerStats, error) {
+
+	primaryKey := redisKey(dbKey{Namespace: playerNamespace, ID: merge.Expected.PlayerID})
+	secondaryKey := redisKey(dbKey{Namespace: playerNamespace, ID: merge.ExpectedSecondary.PlayerID})
+
+	_, err := conn.call("WATCH", primaryKey, secondaryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := conn.pipeline([][]string{
+		{"HMGET", primaryKey, redisPlayerField, redisStatsField, redisRedirectField},
+		{"HMGET", secondaryKey, redisPlayerField, redisStatsField, redisRedirectField},
+	})
+	if err != nil {
+		_, unwatchErr := conn.call("UNWATCH")
+		return nil, errors.Join(err, unwatchErr)
+	}
+
+	mergedStats, commands, err := mergeCommands(primaryKey, secondaryKey, merge, replies)
+	if err != nil {
+		_, unwatchErr := conn.call("UNWATCH")
+		return nil, errors.Join(err, unwatchErr)
+	}
+
+	replies, err = conn.pipeline(append(append([][]string{{"MULTI"}}, commands...), []string{"EXEC"}))
+	if err != nil {
+		return nil, err
+	}
+
+	// a nil reply to EXEC means the transaction was aborted, as a watched hash changed
+	if replies[len(replies)-1] == nil {
+		return nil, redisConflictError
+	}
+
+	return mergedStats, nil
+}
+
+// mergeCommands checks the fields of both players read by the merge (the replies to HMGET of the player, stats
+// and redirect fields), and returns the merged stats and the commands which write the merge
+func mergeCommands(primaryKey string, secondaryKey string, merge *PlayerMerge, replies []any) (*PlayerStats, [][]string, error) {
+
+	expected := []*PlayerData{&merge.Expected, &merge.ExpectedSecondary}
+	fields := make([][]any, len(expected))
+	for i := range expected {
+		fields[i], _ = replies[i].([]any)
+		if len(fields[i]) != 3 {
+			return nil, nil, fmt.Errorf("unexpected reply to HMGET")
+		}
+		if fields[i][2] != nil {
+			return nil, nil, PlayerRetiredErr{expected[i].PlayerID}
+		}
+	}
+
+	stats := make([]*PlayerStats, len(expected))
+	for i := range expected {
+		if fields[i][0] == nil {
+			return nil, nil, PlayerNotFoundErr{expected[i].PlayerID}
+		}
+
+		player := PlayerData{}
+		err := json.Unmarshal(fields[i][0].([]byte), &player)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !player.Equal(*expected[i]) {
+			return nil, nil, PlayerChangedErr{expected[i].PlayerID}
+		}
+
+		if fields[i][1] != nil {
+			stats[i] = &PlayerStats{}
+			err = json.Unmarshal(fields[i][1].([]byte), stats[i])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	mergedStats, keepStats, err := mergedStatsOf(stats[0], stats[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedPlayer, err := json.Marshal(&merge.Merged)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedRedirect, err := json.Marshal(&PlayerRedirect{PlayerID: merge.ExpectedSecondary.PlayerID, MergedInto: merge.Expected.PlayerID, MergeTime: merge.MergeTime})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	write := []string{"HSET", primaryKey, redisPlayerField, string(encodedPlayer)}
+	if keepStats {
+		encodedStats, err := json.Marshal(&mergedStats)
+		if err != nil {
+			return nil, nil, err
+		}
+		write = append(write, redisStatsField, string(encodedStats))
+	}
+
+	return &mergedStats, [][]string{write, {"DEL", secondaryKey}, {"HSET", secondaryKey, redisRedirectField, string(encodedRedirect)}}, nil
+}
+
+// ReadRedirect returns the redirect of the given (retired) player id in the given namespace, and whether it was found
+func (rs *RedisStore) ReadRedirect(ctx context.Context, playerNamespace string, playerID string) (*PlayerRedirect, bool, error) {
+	return readRedis[PlayerRedirect](ctx, rs, dbKey{Namespace: playerNamespace, ID: playerID}, redisRedirectField)
+}
+
+// AcquireLease gives the lease to its holder, if it is not held by another holder (or has expired)
+func (rs *RedisStore) AcquireLease(ctx context.Context, leaseNamespace string, lease *LeaseData, unixNow int64) error {
+
+	encoded, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	err = rs.swapLease(ctx, leaseNamespace, lease.Name, encoded, func(current *LeaseData) error {
+		if !current.canTake(lease.Holder, unixNow) {
+			return LeaseHeldErr{Name: lease.Name, Holder: current.Holder}
+		}
+		return nil
+	})
+
+	// another holder took the lease in between
+	if errors.Is(err, redisConflictError) {
+		return LeaseHeldErr{Name: lease.Name}
+	}
+	return err
+}
+
+// ReleaseLease releases the lease (by expiring it), if it is held by the given holder
+func (rs *RedisStore) ReleaseLease(ctx context.Context, leaseNamespace string, name string, holder string) error {
+
+	encoded, err := json.Marshal(&LeaseData{Name: name})
+	if err != nil {
+		return err
+	}
+
+	errNotHeld := fmt.Errorf("lease not held")
+	err = rs.swapLease(ctx, leaseNamespace, name, encoded, func(current *LeaseData) error {
+		if current == nil || current.Holder != holder {
+			return errNotHeld
+		}
+		return nil
+	})
+
+	if errors.Is(err, errNotHeld) || errors.Is(err, redisConflictError) {
+		return nil
+	}
+	return err
+}
+
+// swapLease sets the lease with the given name to the updated json, if check accepts the current lease (nil if there is none)
+func (rs *RedisStore) swapLease(ctx context.Context, leaseNamespace string, name string, updated []byte, check func(current *LeaseData) error) error {
+
+	err := rs.swap(ctx, redisLeaseKeyPrefix+leaseNamespace+":"+name, redisLeaseField, updated, func(encoded []byte) error {
+
+		var current *LeaseData
+		if encoded != nil {
+			current = &LeaseData{}
+			err := json.Unmarshal(encoded, current)
+			if err != nil {
+				return err
+			}
+		}
+		return check(current)
+	})
+
+	// check errors are joined with the (usually nil) error of unwatching the hash
+	var heldErr LeaseHeldErr
+	if errors.As(err, &heldErr) {
+		return heldErr
+	}
+	return err
+}
+
+// readRedis decodes the json held in the given field of the hash of the given key, and returns whether it was found
+// (the records are upgraded to the current version as they are decoded)
+func readRedis[V any](ctx context.Context, store *RedisStore, key dbKey, field string) (*V, bool, error) {
+
+	encoded, found, err := store.read(ctx, key, field)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	value := new(V)
+	err = json.Unmarshal(encoded, value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+// writeRedis encodes the given value as json, into the given field of the hash of the given key
+func writeRedis[V any](ctx context.Context, store *RedisStore, key dbKey, field string, value V) error {
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return store.write(ctx, key, field, encoded)
+}
+
+// readAllRedis decodes the json held in the given field of the hashes of all the players of the given namespace
+func readAllRedis[V any](ctx context.Context, store *RedisStore, playerNamespace string, field string) (map[string]V, error) {
+
+	encoded, err := store.readAll(ctx, playerNamespace, field)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]V, len(encoded))
+	for id, value := range encoded {
+		var decoded V
+		err = json.Unmarshal(value, &decoded)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode the %v of id: %v: %w", field, id, err)
+		}
+		values[id] = decoded
+	}
+
+	return values, nil
+}
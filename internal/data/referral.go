@@ -0,0 +1,368 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// referral codes are made of characters which cannot be confused with each other when typed in
+const referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const referralCodeLength = 8
+
+// the limits a referral claim can be rejected by
+const (
+	ReferralLimitReferrer = "referrer" // the owner of the code has referred as many players as allowed
+	ReferralLimitIP       = "ip"       // too many codes were claimed from the same ip address today
+)
+
+type ReferralCodeNotFoundErr struct {
+	Code string
+}
+
+func (err ReferralCodeNotFoundErr) Error() string {
+	return fmt.Sprintf("referral code: %v was not found in the referrals DB", err.Code)
+}
+
+type ReferralAlreadyClaimedErr struct {
+	PlayerID string
+}
+
+func (err ReferralAlreadyClaimedErr) Error() string {
+	return fmt.Sprintf("player id: %v has already claimed a referral code", err.PlayerID)
+}
+
+type SelfReferralErr struct {
+	PlayerID string
+}
+
+func (err SelfReferralErr) Error() string {
+	return fmt.Sprintf("player id: %v cannot claim their own referral code", err.PlayerID)
+}
+
+type ReferralNotFoundErr struct {
+	PlayerID string
+}
+
+func (err ReferralNotFoundErr) Error() string {
+	return fmt.Sprintf("player id: %v has no referral waiting for its rewards", err.PlayerID)
+}
+
+// ReferralLimitErr is returned when a referral claim is rejected by one of the anti abuse limits
+type ReferralLimitErr struct {
+	PlayerID string `json:"playerID"`
+	Limit    string `json:"limit"`
+}
+
+func (err ReferralLimitErr) Error() string {
+	return fmt.Sprintf("player id: %v cannot claim the referral code (%v limit)", err.PlayerID, err.Limit)
+}
+
+// ReferralData holds the referral code of a player (blank till they first ask for it), who referred them (if anyone),
+// and how many players they referred. A referral is rewarded (for both players) once the referred player wins their
+// first level, the reward time stays 0 till then
+type ReferralData struct {
+	PlayerID   string `json:"playerID"`
+	Code       string `json:"code,omitempty"`
+	ReferrerID string `json:"referrerID,omitempty"`
+	ClaimTime  int64  `json:"claimTime,omitempty"`
+	RewardTime int64  `json:"rewardTime,omitempty"`
+	Referred   int32  `json:"referred"`
+	Rewarded   int32  `json:"rewarded"`
+}
+
+// ReferralClaim is used as the request body for the internal request to claim a referral code for a player
+// (at the given unix time, from the given ip address), which is only recorded if the given limits allow it:
+// the max number of players a referrer can refer, and the max number of claims per ip address per (UTC) day
+type ReferralClaim struct {
+	PlayerID       string `json:"playerID"`
+	Code           string `json:"code"`
+	IP             string `json:"ip"`
+	Time           int64  `json:"time"`
+	MaxReferrals   int32  `json:"maxReferrals"`
+	MaxClaimsPerIP int32  `json:"maxClaimsPerIP"`
+}
+
+// ReferralCompletion is used as the request body for the internal request to mark the referral of a player as rewarded
+type ReferralCompletion struct {
+	PlayerID string `json:"playerID"`
+	Time     int64  `json:"time"`
+}
+
+// ReferralIPClaims holds the times (unix) of the referral claims made from an ip address on the day of the last one
+type ReferralIPClaims struct {
+	IP         string  `json:"ip"`
+	ClaimTimes []int64 `json:"claimTimes"`
+}
+
+// NormalizeReferralCode returns the form referral codes are stored in (codes are not case sensitive)
+func NormalizeReferralCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// newReferralCode returns a random referral code
+func newReferralCode() string {
+
+	randomBytes := make([]byte, referralCodeLength)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+
+	code := make([]byte, referralCodeLength)
+	for i, randomByte := range randomBytes {
+		code[i] = referralCodeAlphabet[int(randomByte)%len(referralCodeAlphabet)]
+	}
+	return string(code)
+}
+
+// HandleInitReferralCodeRequest responds with the referral data of the requested player, giving them a code if they have none
+func (ds *Server) HandleInitReferralCodeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	referral, err := ds.InitReferralCode(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not init referral code: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.writeJSON(w, referral, "referral")
+}
+
+// HandleClaimReferralRequest records the given referral claim if the limits allow it, responding with the
+// referral data of the player, or with the limit which rejected the claim (as too many requests)
+func (ds *Server) HandleClaimReferralRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a ReferralClaim struct
+	decodedReq := &ReferralClaim{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	referral, err := ds.ClaimReferral(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not claim referral code: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case ReferralCodeNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case ReferralAlreadyClaimedErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		case SelfReferralErr:
+			http.Error(w, errMsg, http.StatusForbidden)
+		case ReferralLimitErr:
+			// the limit goes in the body, so the caller can tell which one it was
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(err)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, referral, "referral")
+}
+
+// HandleCompleteReferralRequest marks the referral of the given player as rewarded, responding with the referral data
+// of the player (which holds the referrer), or with a not found if the player has no referral waiting for its rewards
+func (ds *Server) HandleCompleteReferralRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a ReferralCompletion struct
+	decodedReq := &ReferralCompletion{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	referral, err := ds.CompleteReferral(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not complete referral: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case ReferralNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, referral, "referral")
+}
+
+// InitReferralCode returns the referral data of the given player, after giving them a (new, unique) code if they have none
+func (ds *Server) InitReferralCode(ctx context.Context, playerID string) (*ReferralData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.InitReferralCode")
+	defer span.End()
+
+	if playerID == "" {
+		return nil, fmt.Errorf("cannot init a referral code without a player id")
+	}
+
+	ds.referralMutex.Lock()
+	defer ds.referralMutex.Unlock()
+
+	key := keyOf(ctx, playerID)
+	referral, ok := ds.referralsDB[key]
+	if !ok {
+		referral = ReferralData{PlayerID: playerID}
+	}
+
+	if referral.Code == "" {
+		code := newReferralCode()
+		for {
+			if _, taken := ds.referralCodesDB[keyOf(ctx, code)]; !taken {
+				break
+			}
+			code = newReferralCode()
+		}
+
+		ds.logger.Printf("creating referral code: %v for id: %v", code, playerID)
+		referral.Code = code
+		ds.referralCodesDB[keyOf(ctx, code)] = playerID
+		ds.referralsDB[key] = referral
+	}
+
+	return &referral, nil
+}
+
+// ClaimReferral records the given player as referred by the owner of the given code, unless the player already claimed
+// a code, the code is their own, the owner of the code referred as many players as allowed, or as many codes were
+// claimed from the same ip address today (UTC) as allowed, all under the same lock
+func (ds *Server) ClaimReferral(ctx context.Context, claim *ReferralClaim) (*ReferralData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ClaimReferral")
+	defer span.End()
+
+	if claim == nil || claim.PlayerID == "" {
+		return nil, fmt.Errorf("cannot claim a referral code without a player id")
+	}
+
+	code := NormalizeReferralCode(claim.Code)
+	playerKey := keyOf(ctx, claim.PlayerID)
+	ipKey := keyOf(ctx, claim.IP)
+
+	ds.referralMutex.Lock()
+	defer ds.referralMutex.Unlock()
+
+	referrerID, ok := ds.referralCodesDB[keyOf(ctx, code)]
+	if !ok {
+		return nil, ReferralCodeNotFoundErr{code}
+	}
+
+	if referrerID == claim.PlayerID {
+		return nil, SelfReferralErr{claim.PlayerID}
+	}
+
+	referral, ok := ds.referralsDB[playerKey]
+	if !ok {
+		referral = ReferralData{PlayerID: claim.PlayerID}
+	}
+	if referral.ReferrerID != "" {
+		return nil, ReferralAlreadyClaimedErr{claim.PlayerID}
+	}
+
+	referrerKey := keyOf(ctx, referrerID)
+	referrer := ds.referralsDB[referrerKey]
+	if claim.MaxReferrals > 0 && referrer.Referred >= claim.MaxReferrals {
+		return nil, ReferralLimitErr{PlayerID: claim.PlayerID, Limit: ReferralLimitReferrer}
+	}
+
+	// claims of an earlier day are not counted
+	ipClaims := []int64{}
+	for _, claimTime := range ds.referralIPClaimsDB[ipKey] {
+		if claimTime/secondsPerDay == claim.Time/secondsPerDay {
+			ipClaims = append(ipClaims, claimTime)
+		}
+	}
+	if claim.MaxClaimsPerIP > 0 && int32(len(ipClaims)) >= claim.MaxClaimsPerIP {
+		return nil, ReferralLimitErr{PlayerID: claim.PlayerID, Limit: ReferralLimitIP}
+	}
+
+	ds.logger.Printf("recording referral of id: %v by id: %v", claim.PlayerID, referrerID)
+
+	referral.ReferrerID = referrerID
+	referral.ClaimTime = claim.Time
+	ds.referralsDB[playerKey] = referral
+
+	referrer.Referred++
+	ds.referralsDB[referrerKey] = referrer
+
+	ds.referralIPClaimsDB[ipKey] = append(ipClaims, claim.Time)
+
+	return &referral, nil
+}
+
+// CompleteReferral marks the referral of the given player as rewarded (counting it for the referrer too),
+// it can only be done once, so the rewards of a referral are never given twice
+func (ds *Server) CompleteReferral(ctx context.Context, completion *ReferralCompletion) (*ReferralData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.CompleteReferral")
+	defer span.End()
+
+	if completion == nil || completion.PlayerID == "" {
+		return nil, fmt.Errorf("cannot complete a referral without a player id")
+	}
+
+	ds.referralMutex.Lock()
+	defer ds.referralMutex.Unlock()
+
+	key := keyOf(ctx, completion.PlayerID)
+	referral, ok := ds.referralsDB[key]
+	if !ok || referral.ReferrerID == "" || referral.RewardTime != 0 {
+		return nil, ReferralNotFoundErr{completion.PlayerID}
+	}
+
+	ds.logger.Printf("completing referral of id: %v by id: %v", completion.PlayerID, referral.ReferrerID)
+
+	referral.RewardTime = completion.Time
+	ds.referralsDB[key] = referral
+
+	referrerKey := keyOf(ctx, referral.ReferrerID)
+	referrer := ds.referralsDB[referrerKey]
+	referrer.Rewarded++
+	ds.referralsDB[referrerKey] = referrer
+
+	return &referral, nil
+}
@@ -0,0 +1,707 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the roles of the data services taking part in replication
+const (
+	ReplicationRolePrimary  = "primary"
+	ReplicationRoleFollower = "follower"
+)
+
+var notFollowerError = fmt.Errorf("this data service is not a follower")
+var readOnlyFollowerError = fmt.Errorf("this data service is a read only follower, writes go to its primary")
+
+// replicationLimits allow much larger bodies than the default, since a reset batch holds all the players and their stats
+var replicationLimits = middleware.RouteLimits{
+	Timeout:      constants.DefaultRequestTimeoutSeconds * time.Second,
+	MaxBodyBytes: 256 * 1024 * 1024, // 256 MB
+}
+
+// ReplicationEntry is a write of the data and / or the stats of a player, shipped from a primary data service to its followers.
+// An entry with a redirect retires the player id (merged into another player): the player and their stats are removed
+type ReplicationEntry struct {
+	Sequence  int64           `json:"sequence"`
+	Namespace string          `json:"namespace,omitempty"`
+	PlayerID  string          `json:"playerID"`
+	Player    *PlayerData     `json:"player,omitempty"`
+	Stats     *PlayerStats    `json:"stats,omitempty"`
+	Redirect  *PlayerRedirect `json:"redirect,omitempty"`
+}
+
+// ReplicationBatch is the request body of the internal replication request, made by a primary to each of its followers.
+// A reset batch holds a full copy of the players, stats and redirects of the primary as of its sequence, which replaces everything
+// the follower held, other batches hold the writes after the sequence the follower last acknowledged
+type ReplicationBatch struct {
+	Reset    bool               `json:"reset,omitempty"`
+	Sequence int64              `json:"sequence"`
+	Entries  []ReplicationEntry `json:"entries"`
+}
+
+// ReplicationAck is the response to the internal replication request: the sequence of the latest write the follower
+// applied, or that it needs a full copy (a follower which has not had one since it started cannot apply single writes)
+type ReplicationAck struct {
+	Sequence   int64 `json:"sequence"`
+	NeedsReset bool  `json:"needsReset,omitempty"`
+}
+
+// ReplicationStatus is the response to the internal replication status request, the sequence is the one of the latest
+// write logged (by a primary) or applied (by a follower), the role is blank when replication is not enabled
+type ReplicationStatus struct {
+	Role      string           `json:"role"`
+	Sequence  int64            `json:"sequence"`
+	AppliedAt int64            `json:"appliedAt,omitempty"` // when a follower last applied a batch (unix time)
+	Followers []FollowerStatus `json:"followers,omitempty"`
+}
+
+// FollowerStatus is the progress of a follower, as seen by its primary
+type FollowerStatus struct {
+	URL      string `json:"url"`
+	Sequence int64  `json:"sequence"` // the latest write the follower acknowledged
+	Lag      int64  `json:"lag"`      // the number of writes the follower is behind
+}
+
+// replicationLog holds the latest writes of players and stats of a primary (oldest first, up to the log size),
+// and the progress of each of its followers
+type replicationLog struct {
+	entries   []ReplicationEntry
+	sequence  int64
+	size      int
+	followers []*followerProgress
+}
+
+// followerProgress is the progress of a follower, the wake channel is signalled when there are new writes to ship
+type followerProgress struct {
+	client   *HTTPClient
+	sequence int64
+	reset    bool
+	wake     chan struct{}
+}
+
+// replicaState is the progress of a follower: whether it had a full copy since it started,
+// the sequence of the latest write it applied, and when it last applied a batch (unix time)
+type replicaState struct {
+	synced    bool
+	sequence  int64
+	appliedAt int64
+}
+
+// EnableReplicationFromEnv makes the data service either a primary shipping its writes to the followers given by
+// the followers environment variable (see constants.DataFollowersEnvVar), or a read only follower if the follower
+// environment variable is set to true (see constants.DataFollowerEnvVar), replication stays disabled otherwise
+func (ds *Server) EnableReplicationFromEnv() error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	followerEnv := os.Getenv(constants.DataFollowerEnvVar)
+	followersEnv := os.Getenv(constants.DataFollowersEnvVar)
+
+	isFollower := false
+	if followerEnv != "" {
+		var err error
+		isFollower, err = strconv.ParseBool(followerEnv)
+		if err != nil {
+			return fmt.Errorf("%v should be true or false, got: %v", constants.DataFollowerEnvVar, followerEnv)
+		}
+	}
+
+	if isFollower && followersEnv != "" {
+		return fmt.Errorf("a follower cannot have followers of its own, %v and %v cannot be used together", constants.DataFollowerEnvVar, constants.DataFollowersEnvVar)
+	}
+
+	if isFollower {
+		return ds.EnableFollower()
+	}
+
+	if followersEnv == "" {
+		return nil
+	}
+
+	return ds.EnableReplication(strings.Split(followersEnv, ","), constants.ReplicationLogSize, constants.ReplicationRetrySeconds*time.Second)
+}
+
+// EnableReplication starts logging every write of the players and their stats (the latest writes, up to the given log size),
+// and shipping them to the followers at the given addresses. A follower which is further behind than the log (or has just
+// started) gets a full copy instead, failed requests are retried every retry period
+func (ds *Server) EnableReplication(followerURLs []string, logSize int, retryPeriod time.Duration) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	if ds.playerStore != nil {
+		return fmt.Errorf("replication cannot be used together with a player store")
+	}
+
+	log := &replicationLog{size: logSize}
+	for _, followerURL := range followerURLs {
+		followerURL = strings.TrimSuffix(strings.TrimSpace(followerURL), "/")
+		parsed, err := url.Parse(followerURL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("invalid follower address: %q", followerURL)
+		}
+		log.followers = append(log.followers, &followerProgress{client: &HTTPClient{baseURL: followerURL}, reset: true, wake: make(chan struct{}, 1)})
+	}
+
+	ds.replicaMutex.Lock()
+	isFollower := ds.replica != nil
+	ds.replicaMutex.Unlock()
+	if isFollower {
+		return fmt.Errorf("a follower cannot have followers of its own")
+	}
+
+	ds.replicationMutex.Lock()
+	ds.replication = log
+	ds.replicationMutex.Unlock()
+
+	for _, follower := range log.followers {
+		go ds.shipTo(follower, retryPeriod)
+	}
+
+	middleware.RegisterLiveGauge("data", "replicationLag", ds.ReplicationLag)
+
+	ds.logger.Printf("replication enabled, shipping writes to %v followers", len(log.followers))
+	return nil
+}
+
+// EnableFollower makes the data service a read only follower: it only takes the writes shipped from its primary
+// (see EnableReplication), and rejects every other write request, its reads may be behind the primary by a few writes
+func (ds *Server) EnableFollower() error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	if ds.playerStore != nil {
+		return fmt.Errorf("replication cannot be used together with a player store")
+	}
+
+	ds.replicationMutex.Lock()
+	isPrimary := ds.replication != nil
+	ds.replicationMutex.Unlock()
+	if isPrimary {
+		return fmt.Errorf("a primary cannot be a follower")
+	}
+
+	// the players and stats of a follower come from its primary, so it cannot move them to a cold store of its own
+	ds.archiveMutex.Lock()
+	archival := ds.coldStore != nil
+	ds.archiveMutex.Unlock()
+	if archival {
+		return fmt.Errorf("a follower cannot be used together with archival")
+	}
+
+	ds.replicaMutex.Lock()
+	ds.replica = &replicaState{}
+	ds.replicaMutex.Unlock()
+
+	ds.logger.Println("running as a read only follower")
+	return nil
+}
+
+// isFollower returns whether the data service is a read only follower
+func (ds *Server) isFollower() bool {
+
+	ds.replicaMutex.Lock()
+	defer ds.replicaMutex.Unlock()
+
+	return ds.replica != nil
+}
+
+// replicate logs the write of the given player data and / or stats (nil if not written) of the given key, if this is a
+// primary (the locks of the shards of the written entries should be held, so the writes are logged in the order they were made)
+func (ds *Server) replicate(key dbKey, player *PlayerData, stats *PlayerStats) {
+
+	ds.replicationMutex.Lock()
+	defer ds.replicationMutex.Unlock()
+
+	if ds.replication == nil {
+		return
+	}
+
+	entry := ReplicationEntry{Namespace: key.Namespace, PlayerID: key.ID}
+	if player != nil {
+		cloned := player.Clone()
+		entry.Player = &cloned
+	}
+	if stats != nil {
+		entry.Stats = copyStats(*stats)
+	}
+	ds.logEntry(entry)
+}
+
+// replicateRedirect logs the retirement of the player id of the given key by a merge, with its redirect, if this is a
+// primary (the locks of the shards of the removed entries, and the redirects mutex, should be held)
+func (ds *Server) replicateRedirect(key dbKey, redirect PlayerRedirect) {
+
+	ds.replicationMutex.Lock()
+	defer ds.replicationMutex.Unlock()
+
+	if ds.replication == nil {
+		return
+	}
+
+	ds.logEntry(ReplicationEntry{Namespace: key.Namespace, PlayerID: key.ID, Redirect: &redirect})
+}
+
+// logEntry appends the given entry to the replication log with the next sequence, and wakes the followers
+// (the replication mutex should be held, and replication enabled)
+func (ds *Server) logEntry(entry ReplicationEntry) {
+
+	log := ds.replication
+	log.sequence++
+	entry.Sequence = log.sequence
+
+	// the log is trimmed to its size once it is twice as long, so most writes do not move it
+	log.entries = append(log.entries, entry)
+	if len(log.entries) >= 2*log.size {
+		log.entries = append([]ReplicationEntry(nil), log.entries[len(log.entries)-log.size:]...)
+	}
+
+	for _, follower := range log.followers {
+		select {
+		case follower.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// resetFollowers makes every follower get a full copy next, after the players and stats were replaced (by a restore)
+func (ds *Server) resetFollowers() {
+
+	ds.replicationMutex.Lock()
+	defer ds.replicationMutex.Unlock()
+
+	if ds.replication == nil {
+		return
+	}
+
+	for _, follower := range ds.replication.followers {
+		follower.reset = true
+		select {
+		case follower.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// shipTo ships the new writes to the given follower whenever there are some, till it caught up,
+// and retries every retry period after a failed request
+func (ds *Server) shipTo(follower *followerProgress, retryPeriod time.Duration) {
+
+	ticker := time.NewTicker(retryPeriod)
+
+	for {
+		select {
+		case <-follower.wake:
+		case <-ticker.C:
+		}
+
+		for {
+			batch := ds.nextBatch(follower)
+			if batch == nil {
+				break
+			}
+
+			ack, err := follower.client.ShipReplicationBatch(context.Background(), batch)
+			ds.acknowledge(follower, batch, ack, err)
+			if err != nil {
+				ds.logger.Printf("error shipping writes to follower: %v: %v", follower.client.baseURL, err.Error())
+				break
+			}
+		}
+	}
+}
+
+// nextBatch returns the next batch for the given follower, a full copy if it needs one (or is further behind than the log),
+// the writes after the last one it acknowledged otherwise, or nil if it caught up
+func (ds *Server) nextBatch(follower *followerProgress) *ReplicationBatch {
+
+	ds.replicationMutex.Lock()
+
+	log := ds.replication
+	if !follower.reset && follower.sequence < log.sequence-int64(len(log.entries)) {
+		follower.reset = true
+	}
+
+	if !follower.reset {
+		defer ds.replicationMutex.Unlock()
+
+		if follower.sequence >= log.sequence {
+			return nil
+		}
+
+		first := len(log.entries) - int(log.sequence-follower.sequence)
+		last := min(first+constants.ReplicationBatchSize, len(log.entries))
+		return &ReplicationBatch{Sequence: follower.sequence, Entries: append([]ReplicationEntry(nil), log.entries[first:last]...)}
+	}
+
+	ds.replicationMutex.Unlock()
+
+	// no write is made while the copy is taken (the shard locks are taken before the replication mutex, like in every write)
+	ds.playersDB.lockAll()
+	defer ds.playersDB.unlockAll()
+	ds.statsDB.lockAll()
+	defer ds.statsDB.unlockAll()
+	ds.redirectsMutex.Lock()
+	defer ds.redirectsMutex.Unlock()
+
+	ds.replicationMutex.Lock()
+	defer ds.replicationMutex.Unlock()
+
+	// the reset is cleared as the copy is taken, so a restore made while it is shipped resets the follower again
+	follower.reset = false
+	batch := &ReplicationBatch{Reset: true, Sequence: log.sequence, Entries: []ReplicationEntry{}}
+
+	entries := map[dbKey]*ReplicationEntry{}
+	for key, player := range ds.playersDB.all() {
+		cloned := player.Clone()
+		entries[key] = &ReplicationEntry{Sequence: log.sequence, Namespace: key.Namespace, PlayerID: key.ID, Player: &cloned}
+	}
+	for key, stats := range ds.statsDB.all() {
+		entry, ok := entries[key]
+		if !ok {
+			entry = &ReplicationEntry{Sequence: log.sequence, Namespace: key.Namespace, PlayerID: key.ID}
+			entries[key] = entry
+		}
+		entry.Stats = copyStats(stats)
+	}
+	for key, redirect := range ds.redirectsDB {
+		entry, ok := entries[key]
+		if !ok {
+			entry = &ReplicationEntry{Sequence: log.sequence, Namespace: key.Namespace, PlayerID: key.ID}
+			entries[key] = entry
+		}
+		entry.Redirect = &redirect
+	}
+	for _, key := range sortedKeys(entries) {
+		batch.Entries = append(batch.Entries, *entries[key])
+	}
+
+	return batch
+}
+
+// acknowledge records the response of the given follower to the given batch
+func (ds *Server) acknowledge(follower *followerProgress, batch *ReplicationBatch, ack *ReplicationAck, err error) {
+
+	ds.replicationMutex.Lock()
+	defer ds.replicationMutex.Unlock()
+
+	switch {
+	case err != nil:
+		if batch.Reset {
+			follower.reset = true
+		}
+	case ack.NeedsReset:
+		follower.reset = true
+	default:
+		follower.sequence = ack.Sequence
+	}
+}
+
+// ReplicationLag returns the number of writes the furthest behind follower is missing (0 without followers)
+func (ds *Server) ReplicationLag() int64 {
+
+	if ds == nil {
+		return 0
+	}
+
+	ds.replicationMutex.Lock()
+	defer ds.replicationMutex.Unlock()
+
+	var lag int64
+	if ds.replication != nil {
+		for _, follower := range ds.replication.followers {
+			lag = max(lag, ds.replication.sequence-follower.sequence)
+		}
+	}
+	return lag
+}
+
+// ApplyReplicationBatch applies the given batch shipped from the primary, a full copy replaces all the players, stats and redirects,
+// the writes of other batches are applied in order (the ones already applied are skipped, and the ones after a missing
+// write are left for the next batch), it returns the sequence of the latest write applied
+func (ds *Server) ApplyReplicationBatch(ctx context.Context, batch *ReplicationBatch) (*ReplicationAck, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	if batch == nil {
+		return nil, fmt.Errorf("provided replication batch pointer is nil")
+	}
+
+	// the replica mutex is held while the batch is applied, so batches are applied one at a time
+	ds.replicaMutex.Lock()
+	defer ds.replicaMutex.Unlock()
+
+	replica := ds.replica
+	if replica == nil {
+		return nil, notFollowerError
+	}
+
+	if batch.Reset {
+		playersDB := map[dbKey]PlayerData{}
+		statsDB := map[dbKey]PlayerStats{}
+		redirectsDB := map[dbKey]PlayerRedirect{}
+		for _, entry := range batch.Entries {
+			key := dbKey{Namespace: entry.Namespace, ID: entry.PlayerID}
+			if entry.Player != nil {
+				playersDB[key] = entry.Player.Clone()
+			}
+			if entry.Stats != nil {
+				statsDB[key] = *copyStats(*entry.Stats)
+			}
+			if entry.Redirect != nil {
+				redirectsDB[key] = *entry.Redirect
+			}
+		}
+
+		ds.playersDB.lockAll()
+		ds.statsDB.lockAll()
+		ds.statsChangesDB.lockAll()
+		ds.playersDB.replace(playersDB)
+		ds.statsDB.replace(statsDB)
+		ds.statsChangesDB.replace(map[dbKey]statsChangeTimes{})
+		ds.levelIndex.rebuild(statsDB)
+		ds.redirectsMutex.Lock()
+		ds.redirectsDB = redirectsDB
+		ds.redirectsMutex.Unlock()
+		ds.statsChangesDB.unlockAll()
+		ds.statsDB.unlockAll()
+		ds.playersDB.unlockAll()
+
+		replica.synced = true
+		replica.sequence = batch.Sequence
+		replica.appliedAt = time.Now().UTC().Unix()
+
+		ds.logger.Printf("applied a full copy from the primary, at sequence: %v", batch.Sequence)
+		return &ReplicationAck{Sequence: replica.sequence}, nil
+	}
+
+	if !replica.synced {
+		return &ReplicationAck{NeedsReset: true}, nil
+	}
+
+	for _, entry := range batch.Entries {
+		if entry.Sequence <= replica.sequence {
+			continue
+		}
+		if entry.Sequence != replica.sequence+1 {
+			break
+		}
+
+		ds.applyEntry(entry)
+		replica.sequence = entry.Sequence
+	}
+
+	replica.appliedAt = time.Now().UTC().Unix()
+	return &ReplicationAck{Sequence: replica.sequence}, nil
+}
+
+// applyEntry writes the player data and / or stats of the given entry, holding the locks of both entries
+// (the players shard is locked before the stats shard, like everywhere both are held), or retires the player id
+// of an entry with a redirect
+func (ds *Server) applyEntry(entry ReplicationEntry) {
+
+	key := dbKey{Namespace: entry.Namespace, ID: entry.PlayerID}
+
+	playersShard := ds.playersDB.shardOf(key)
+	playersShard.mutex.Lock()
+	defer playersShard.mutex.Unlock()
+
+	statsShard := ds.statsDB.shardOf(key)
+	statsShard.mutex.Lock()
+	defer statsShard.mutex.Unlock()
+
+	if entry.Redirect != nil {
+		delete(playersShard.entries, key)
+		if old, ok := statsShard.entries[key]; ok {
+			ds.levelIndex.update(key, &old, nil)
+			delete(statsShard.entries, key)
+		}
+
+		changesShard := ds.statsChangesDB.shardOf(key)
+		changesShard.mutex.Lock()
+		delete(changesShard.entries, key)
+		changesShard.mutex.Unlock()
+
+		ds.redirectsMutex.Lock()
+		ds.redirectsDB[key] = *entry.Redirect
+		ds.redirectsMutex.Unlock()
+		return
+	}
+
+	if entry.Player != nil {
+		playersShard.entries[key] = entry.Player.Clone()
+	}
+
+	if entry.Stats != nil {
+		if old, ok := statsShard.entries[key]; ok {
+			ds.stampStatsChanges(key, &old, *entry.Stats)
+			ds.levelIndex.update(key, &old, entry.Stats)
+		} else {
+			ds.stampStatsChanges(key, nil, *entry.Stats)
+			ds.levelIndex.update(key, nil, entry.Stats)
+		}
+		statsShard.entries[key] = *copyStats(*entry.Stats)
+	}
+}
+
+// ReadReplicationStatus returns the role of the data service in replication, and its progress
+func (ds *Server) ReadReplicationStatus(ctx context.Context) (*ReplicationStatus, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	ds.replicaMutex.Lock()
+	replica := ds.replica
+	var status *ReplicationStatus
+	if replica != nil {
+		status = &ReplicationStatus{Role: ReplicationRoleFollower, Sequence: replica.sequence, AppliedAt: replica.appliedAt}
+	}
+	ds.replicaMutex.Unlock()
+
+	if status != nil {
+		return status, nil
+	}
+
+	ds.replicationMutex.Lock()
+	defer ds.replicationMutex.Unlock()
+
+	if ds.replication == nil {
+		return &ReplicationStatus{}, nil
+	}
+
+	status = &ReplicationStatus{Role: ReplicationRolePrimary, Sequence: ds.replication.sequence}
+	for _, follower := range ds.replication.followers {
+		status.Followers = append(status.Followers, FollowerStatus{
+			URL:      follower.client.baseURL,
+			Sequence: follower.sequence,
+			Lag:      ds.replication.sequence - follower.sequence,
+		})
+	}
+	return status, nil
+}
+
+// withReadOnly wraps the given handler (the mux of a follower) so that every request other than a read,
+// or a batch shipped from the primary, is rejected
+func (ds *Server) withReadOnly(handler http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodGet && r.URL.Path != "/data/replication-internal" {
+			errMsg := "error: " + readOnlyFollowerError.Error()
+			ds.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// HandleReplicationRequest applies the batch of writes shipped from the primary, and responds with the sequence of the
+// latest write applied (only followers take these requests)
+func (ds *Server) HandleReplicationRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a ReplicationBatch struct
+	decodedReq := &ReplicationBatch{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ack, err := ds.ApplyReplicationBatch(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not apply replication batch: " + err.Error()
+		ds.logger.Println(errMsg)
+		if err == notFollowerError {
+			http.Error(w, errMsg, http.StatusConflict)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ds.writeJSON(w, ack, "replication ack")
+}
+
+// HandleReplicationStatusRequest responds with the role of the data service in replication, and its progress
+func (ds *Server) HandleReplicationStatusRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status, err := ds.ReadReplicationStatus(r.Context())
+	if err != nil {
+		errMsg := "error: could not read replication status: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	ds.writeJSON(w, status, "replication status")
+}
+
+// ShipReplicationBatch makes an internal request to a data follower to apply the given batch of writes
+func (hc *HTTPClient) ShipReplicationBatch(ctx context.Context, batch *ReplicationBatch) (*ReplicationAck, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	ack := &ReplicationAck{}
+	statusCode, err := hc.doInternal(ctx, "POST", "/data/replication-internal", batch, ack)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal replication request was not successful, status code %v", statusCode)
+	}
+
+	return ack, nil
+}
+
+// NewReplicaHTTPClientFromEnv returns a data client which talks to the data follower at the address given by the
+// replica address environment variable (see constants.DataReplicaURLEnvVar), or nil if it is not set.
+// Its reads may be behind the primary by a few writes, so it is only meant for read heavy requests which can be a little behind
+func NewReplicaHTTPClientFromEnv() (*HTTPClient, error) {
+
+	replicaURL := strings.TrimSuffix(os.Getenv(constants.DataReplicaURLEnvVar), "/")
+	if replicaURL == "" {
+		return nil, nil
+	}
+
+	parsed, err := url.Parse(replicaURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("%v should be an absolute url, got: %v", constants.DataReplicaURLEnvVar, replicaURL)
+	}
+
+	return &HTTPClient{baseURL: replicaURL}, nil
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"example.com/dice-game-backend/internal/shared/constants"
+	"hash/maphash"
+	"maps"
+	"sync"
+)
+
+// shardSeed is used to hash the keys to their shards
+var shardSeed = maphash.MakeSeed()
+
+// shardedStore holds entries (keyed by namespace and id) split into shards, each with its own mutex,
+// so requests for different players do not wait on each other
+type shardedStore[V any] struct {
+	shards []*storeShard[V]
+}
+
+// storeShard holds the entries of the keys which hash to it, guarded by its mutex
+type storeShard[V any] struct {
+	mutex   sync.Mutex
+	entries map[dbKey]V
+}
+
+// newShardedStore returns an empty store with constants.DataStoreShards shards
+func newShardedStore[V any]() *shardedStore[V] {
+
+	store := &shardedStore[V]{shards: make([]*storeShard[V], constants.DataStoreShards)}
+	for i := range store.shards {
+		store.shards[i] = &storeShard[V]{entries: map[dbKey]V{}}
+	}
+	return store
+}
+
+// shardOf returns the shard holding the entry of the given key (its mutex should be held while using its entries)
+func (s *shardedStore[V]) shardOf(key dbKey) *storeShard[V] {
+	return s.shards[maphash.Comparable(shardSeed, key)%uint64(len(s.shards))]
+}
+
+// get returns the entry of the given key, if there is one
+func (s *shardedStore[V]) get(key dbKey) (V, bool) {
+
+	shard := s.shardOf(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	value, ok := shard.entries[key]
+	return value, ok
+}
+
+// put sets the entry of the given key
+func (s *shardedStore[V]) put(key dbKey, value V) {
+
+	shard := s.shardOf(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	shard.entries[key] = value
+}
+
+// lockPair locks the shards holding the entries of the two given keys, in shard order (once, if both keys are in the
+// same shard), like lockAll does, and returns the function unlocking them
+func (s *shardedStore[V]) lockPair(a dbKey, b dbKey) func() {
+
+	first := maphash.Comparable(shardSeed, a) % uint64(len(s.shards))
+	second := maphash.Comparable(shardSeed, b) % uint64(len(s.shards))
+	if first > second {
+		first, second = second, first
+	}
+
+	s.shards[first].mutex.Lock()
+	if first == second {
+		return s.shards[first].mutex.Unlock
+	}
+	s.shards[second].mutex.Lock()
+
+	return func() {
+		s.shards[second].mutex.Unlock()
+		s.shards[first].mutex.Unlock()
+	}
+}
+
+// snapshot returns a copy of all the entries, locking one shard at a time
+// (so it is not a point in time copy of the whole store, unless all the shards are locked with lockAll)
+func (s *shardedStore[V]) snapshot() map[dbKey]V {
+
+	entries := map[dbKey]V{}
+	for _, shard := range s.shards {
+		shard.mutex.Lock()
+		maps.Copy(entries, shard.entries)
+		shard.mutex.Unlock()
+	}
+	return entries
+}
+
+// lockAll locks all the shards, in order
+func (s *shardedStore[V]) lockAll() {
+	for _, shard := range s.shards {
+		shard.mutex.Lock()
+	}
+}
+
+// unlockAll unlocks all the shards, in reverse order
+func (s *shardedStore[V]) unlockAll() {
+	for i := len(s.shards) - 1; i >= 0; i-- {
+		s.shards[i].mutex.Unlock()
+	}
+}
+
+// all returns a copy of all the entries (all the shards should be locked with lockAll)
+func (s *shardedStore[V]) all() map[dbKey]V {
+
+	entries := map[dbKey]V{}
+	for _, shard := range s.shards {
+		maps.Copy(entries, shard.entries)
+	}
+	return entries
+}
+
+// replace replaces all the entries with the given ones (all the shards should be locked with lockAll)
+func (s *shardedStore[V]) replace(entries map[dbKey]V) {
+
+	for _, shard := range s.shards {
+		shard.entries = map[dbKey]V{}
+	}
+	for key, value := range entries {
+		s.shardOf(key).entries[key] = value
+	}
+}
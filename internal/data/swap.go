@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+type PlayerChangedErr struct {
+	PlayerID string
+}
+
+func (err PlayerChangedErr) Error() string {
+	return fmt.Sprintf("player with id: %v has changed since it was read", err.PlayerID)
+}
+
+// PlayerSwap is used as the request body for the internal request to conditionally write a player,
+// the updated player data is only written if the player DB entry still holds the expected player data
+type PlayerSwap struct {
+	Expected PlayerData `json:"expected"`
+	Updated  PlayerData `json:"updated"`
+}
+
+// HandleSwapPlayerRequest writes the updated player data if the player DB entry still holds the expected player data,
+// responding with a conflict status if it does not (so the caller can read the player again, and retry)
+func (ds *Server) HandleSwapPlayerRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a PlayerSwap struct
+	decodedReq := &PlayerSwap{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	err = ds.SwapPlayer(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not swap player: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case PlayerNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case PlayerChangedErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// SwapPlayer atomically writes the updated player data, if the player DB entry still holds the expected player data
+func (ds *Server) SwapPlayer(ctx context.Context, swap *PlayerSwap) error {
+
+	if ds == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.SwapPlayer")
+	defer span.End()
+
+	if swap == nil || swap.Expected.PlayerID == "" || swap.Updated.PlayerID != swap.Expected.PlayerID {
+		return fmt.Errorf("invalid player swap")
+	}
+
+	playerID := swap.Expected.PlayerID
+	key := keyOf(ctx, playerID)
+
+	// records are always stored in the current version
+	updated := swap.Updated.Clone()
+	err := upgradePlayer(&updated)
+	if err != nil {
+		return err
+	}
+
+	if ds.playerStore != nil {
+		ds.logger.Printf("swapping player DB entry for id: %v", playerID)
+		return ds.playerStore.SwapPlayer(ctx, key.Namespace, &swap.Expected, &updated)
+	}
+
+	// archived players are brought back to memory on access
+	err = ds.rehydrate(key)
+	if err != nil {
+		return err
+	}
+
+	shard := ds.playersDB.shardOf(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	player, ok := shard.entries[key]
+	if !ok {
+		return PlayerNotFoundErr{playerID}
+	}
+
+	if !player.Equal(swap.Expected) {
+		return PlayerChangedErr{playerID}
+	}
+
+	ds.logger.Printf("swapping player DB entry for id: %v", playerID)
+	ds.recordPlayerChange(key, &player, updated)
+	shard.entries[key] = updated
+	ds.replicate(key, &updated, nil)
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// StatsDelta holds the level stats of a player which changed at or after a given time (unix seconds), their rating
+// (left out if it did not change), their prestige count and their win streak (both always sent), it is used as the response for the internal stats delta request
+type StatsDelta struct {
+	LevelStats    []PlayerLevelStats `json:"levelStats"`
+	Rating        int32              `json:"rating,omitempty"`
+	PrestigeCount int32              `json:"prestigeCount,omitempty"`
+	WinStreak     int32              `json:"winStreak,omitempty"`
+}
+
+// statsChangeTimes are the times (unix seconds) the stats of each level, and the rating, of a player last changed.
+// They are only kept in memory: stats without change times (restored from a backup, or brought back from the cold
+// store) count as changed, so a delta never leaves out a change
+type statsChangeTimes struct {
+	levels map[int32]int64
+	rating int64
+}
+
+// stampStatsChanges records the current time as the change time of the level stats (and the rating) which differ
+// between the old and the updated stats of the player with the given key (the lock of the shard of their stats should be held)
+func (ds *Server) stampStatsChanges(key dbKey, old *PlayerStats, updated PlayerStats) {
+
+	unixNow := time.Now().UTC().Unix()
+
+	changes, ok := ds.statsChangesDB.get(key)
+	if !ok {
+		// without change times for the old stats, all of them count as changed now
+		changes = statsChangeTimes{levels: map[int32]int64{}}
+		old = nil
+	}
+
+	for _, levelStats := range updated.LevelStats {
+		if old == nil || !slices.Contains(old.LevelStats, levelStats) {
+			changes.levels[levelStats.Level] = unixNow
+		}
+	}
+
+	if old == nil || old.Rating != updated.Rating {
+		changes.rating = unixNow
+	}
+
+	ds.statsChangesDB.put(key, changes)
+}
+
+// ReadStatsDelta returns the level stats of the requested player ID which changed at or after the given time
+// (unix seconds), along with their rating if it changed then
+func (ds *Server) ReadStatsDelta(ctx context.Context, playerID string, since int64) (*StatsDelta, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadStatsDelta")
+	defer span.End()
+
+	key := keyOf(ctx, playerID)
+
+	// change times are not kept for the stats held in a player store, so all of them count as changed
+	if ds.playerStore != nil {
+		plStats, found, err := ds.playerStore.ReadStats(ctx, key.Namespace, playerID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, PlayerStatsNotFoundErr{playerID}
+		}
+		return &StatsDelta{LevelStats: append([]PlayerLevelStats{}, plStats.LevelStats...), Rating: plStats.Rating, PrestigeCount: plStats.PrestigeCount, WinStreak: plStats.WinStreak}, nil
+	}
+
+	// archived players are brought back to memory on access
+	err := ds.rehydrate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	shard := ds.statsDB.shardOf(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	plStats, ok := shard.entries[key]
+	if !ok {
+		return nil, PlayerStatsNotFoundErr{playerID}
+	}
+
+	changes, known := ds.statsChangesDB.get(key)
+
+	delta := &StatsDelta{LevelStats: []PlayerLevelStats{}, PrestigeCount: plStats.PrestigeCount, WinStreak: plStats.WinStreak}
+	for _, levelStats := range plStats.LevelStats {
+		changeTime, ok := changes.levels[levelStats.Level]
+		if !known || !ok || changeTime >= since {
+			delta.LevelStats = append(delta.LevelStats, levelStats)
+		}
+	}
+
+	if !known || changes.rating >= since {
+		delta.Rating = plStats.Rating
+	}
+
+	return delta, nil
+}
+
+// HandleReadStatsDeltaRequest responds with the level stats of the requested player which changed at or after
+// the unix time in the since query parameter (all of them without it)
+func (ds *Server) HandleReadStatsDeltaRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	since := int64(0)
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		var err error
+		since, err = strconv.ParseInt(sinceParam, 10, 64)
+		if err != nil || since < 0 {
+			errMsg := "error: invalid since time in request"
+			ds.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := r.PathValue("id")
+
+	delta, err := ds.ReadStatsDelta(r.Context(), id, since)
+	if err != nil {
+		errMsg := "error: could not read stats delta: " + err.Error()
+		ds.logger.Println(errMsg)
+		if errors.Is(err, PlayerStatsNotFoundErr{PlayerID: id}) {
+			http.Error(w, errMsg, http.StatusNotFound)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ds.writeJSON(w, delta, "stats delta")
+}
+
+// ReadStatsDelta makes an internal request to the data service to read the level stats of the required player
+// which changed at or after the given time (unix seconds)
+func (hc *HTTPClient) ReadStatsDelta(ctx context.Context, playerID string, since int64) (*StatsDelta, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	result := &StatsDelta{}
+	statusCode, err := hc.doInternal(ctx, "GET", fmt.Sprintf("/data/stats-delta-internal/%v?since=%v", playerID, since), nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		return nil, PlayerStatsNotFoundErr{PlayerID: playerID}
+	default:
+		return nil, fmt.Errorf("internal read stats delta request was not successful, status code %v", statusCode)
+	}
+}
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+// ActionThrottledErr is returned when an action of a player is rejected by its throttle,
+// along with the (unix) time the player can take the action again
+type ActionThrottledErr struct {
+	PlayerID  string `json:"playerID"`
+	Action    string `json:"action"`
+	RetryTime int64  `json:"retryTime"`
+}
+
+func (err ActionThrottledErr) Error() string {
+	return fmt.Sprintf("player id: %v cannot take action: %v again before %v", err.PlayerID, err.Action, err.RetryTime)
+}
+
+// ActionThrottle is used as the request body for the internal request to record an action of a player,
+// which is only recorded if the player took fewer than MaxActions of it in the last WindowSeconds (before Time)
+type ActionThrottle struct {
+	PlayerID      string `json:"playerID"`
+	Action        string `json:"action"`
+	Time          int64  `json:"time"`
+	MaxActions    int32  `json:"maxActions"`
+	WindowSeconds int64  `json:"windowSeconds"`
+}
+
+// ActionLogData keeps the (unix) times of the recent actions of a kind by a player, only the actions
+// within the window of the latest throttle are kept
+type ActionLogData struct {
+	PlayerID string  `json:"playerID"`
+	Action   string  `json:"action"`
+	Times    []int64 `json:"times"`
+}
+
+// HandleRecordActionRequest records the given action if its throttle allows it, responding with the player's recent
+// actions of that kind, or with the time the player can take it again (as too many requests)
+func (ds *Server) HandleRecordActionRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be an ActionThrottle struct
+	decodedReq := &ActionThrottle{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	actions, err := ds.RecordAction(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not record action: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case ActionThrottledErr:
+			// the retry time goes in the body, so the client can tell the player when to try again
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(err)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, actions, "action log")
+}
+
+// RecordAction records the given action of a player, unless they already took MaxActions of it in the sliding window
+// of the last WindowSeconds, in which case the time the oldest of those actions leaves the window is returned.
+// The action logs are only kept in memory (not in backups or the player store), since they only matter for a few seconds
+func (ds *Server) RecordAction(ctx context.Context, throttle *ActionThrottle) (*ActionLogData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.RecordAction")
+	defer span.End()
+
+	if throttle == nil || throttle.PlayerID == "" || throttle.Action == "" {
+		return nil, fmt.Errorf("cannot record an action without a player id and an action")
+	}
+
+	if throttle.MaxActions <= 0 || throttle.WindowSeconds <= 0 {
+		return nil, fmt.Errorf("the max actions and the window should be positive, got: %v, %v", throttle.MaxActions, throttle.WindowSeconds)
+	}
+
+	ds.throttlesMutex.Lock()
+	defer ds.throttlesMutex.Unlock()
+
+	key := keyOf(ctx, throttle.PlayerID)
+
+	index := -1
+	current := ActionLogData{PlayerID: throttle.PlayerID, Action: throttle.Action}
+	for i, actionLog := range ds.actionLogsDB[key] {
+		if actionLog.Action == throttle.Action {
+			index, current = i, actionLog
+			break
+		}
+	}
+
+	// the actions which left the window are not counted (the times are in order, oldest first)
+	windowStart := throttle.Time - throttle.WindowSeconds
+	recent := []int64{}
+	for _, actionTime := range current.Times {
+		if actionTime > windowStart {
+			recent = append(recent, actionTime)
+		}
+	}
+
+	if int32(len(recent)) >= throttle.MaxActions {
+		return nil, ActionThrottledErr{PlayerID: throttle.PlayerID, Action: throttle.Action, RetryTime: recent[len(recent)-int(throttle.MaxActions)] + throttle.WindowSeconds}
+	}
+
+	current.Times = append(recent, throttle.Time)
+
+	if index >= 0 {
+		ds.actionLogsDB[key][index] = current
+	} else {
+		ds.actionLogsDB[key] = append(ds.actionLogsDB[key], current)
+	}
+
+	return &current, nil
+}
+
+// RecordAction makes an internal request to the data service to record an action of a player, if its throttle allows it
+func (hc *HTTPClient) RecordAction(ctx context.Context, throttle *ActionThrottle) (*ActionLogData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if throttle == nil {
+		return nil, fmt.Errorf("provided throttle pointer is nil")
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(throttle)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/data/action-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		actions := &ActionLogData{}
+		err = json.NewDecoder(resp.Body).Decode(actions)
+		if err != nil {
+			return nil, err
+		}
+		return actions, nil
+
+	case http.StatusTooManyRequests:
+		// the body holds the time the player can take the action again
+		throttledErr := ActionThrottledErr{}
+		err = json.NewDecoder(resp.Body).Decode(&throttledErr)
+		if err != nil {
+			return nil, err
+		}
+		return nil, throttledErr
+
+	default:
+		return nil, fmt.Errorf("internal record action request was not successful, status code %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,425 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+type WalletNotFoundErr struct {
+	PlayerID string
+}
+
+func (err WalletNotFoundErr) Error() string {
+	return fmt.Sprintf("wallet for id: %v was not found in the wallets DB", err.PlayerID)
+}
+
+type InsufficientCoinsErr struct {
+	PlayerID string
+}
+
+func (err InsufficientCoinsErr) Error() string {
+	return fmt.Sprintf("wallet for id: %v does not have enough coins", err.PlayerID)
+}
+
+type NotEnoughItemsErr struct {
+	PlayerID string
+	ItemID   string
+}
+
+func (err NotEnoughItemsErr) Error() string {
+	return fmt.Sprintf("inventory for id: %v does not have enough of item %v", err.PlayerID, err.ItemID)
+}
+
+// WalletData stores the coin balance of a player
+type WalletData struct {
+	PlayerID string `json:"playerID"`
+	Coins    int64  `json:"coins"`
+}
+
+// WalletDelta is used as the request body for the internal request to add coins to (or take them from) a wallet
+type WalletDelta struct {
+	PlayerID string `json:"playerID"`
+	Delta    int64  `json:"delta"`
+}
+
+// InventoryItem is a single kind of item owned by a player, and how many of it they own
+type InventoryItem struct {
+	ItemID string `json:"itemID"`
+	Count  int32  `json:"count"`
+}
+
+// InventoryData stores all the items owned by a player
+type InventoryData struct {
+	PlayerID string          `json:"playerID"`
+	Items    []InventoryItem `json:"items"`
+}
+
+// ItemGrant is used as the request body for the internal requests to grant items to (or consume items of) a player
+type ItemGrant struct {
+	PlayerID string `json:"playerID"`
+	ItemID   string `json:"itemID"`
+	Count    int32  `json:"count"`
+}
+
+// HandleInitWalletRequest creates the given wallet if the player does not have one yet,
+// and responds with the player's wallet either way
+func (ds *Server) HandleInitWalletRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a WalletData struct
+	decodedReq := &WalletData{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	if decodedReq.PlayerID == "" || decodedReq.Coins < 0 {
+		errMsg := "error: cannot create a wallet with a blank player id, or negative coins"
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	wallet, err := ds.InitWallet(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not create wallet: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	ds.writeJSON(w, wallet, "wallet")
+}
+
+// HandleReadWalletRequest responds with the wallet of the requested player
+func (ds *Server) HandleReadWalletRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	wallet, err := ds.ReadWallet(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ds.writeJSON(w, wallet, "wallet")
+}
+
+// HandleAdjustWalletRequest adds the delta to the requested wallet (a negative delta takes coins away),
+// responding with a conflict status if the wallet does not have enough coins for it
+func (ds *Server) HandleAdjustWalletRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a WalletDelta struct
+	decodedReq := &WalletDelta{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	wallet, err := ds.AdjustWallet(r.Context(), decodedReq.PlayerID, decodedReq.Delta)
+	if err != nil {
+		errMsg := "error: could not adjust wallet: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case WalletNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case InsufficientCoinsErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ds.writeJSON(w, wallet, "wallet")
+}
+
+// HandleReadInventoryRequest responds with the inventory of the requested player (empty if they own nothing)
+func (ds *Server) HandleReadInventoryRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the path value of the request
+	id := r.PathValue("id")
+
+	inventory, err := ds.ReadInventory(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not read inventory: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	ds.writeJSON(w, inventory, "inventory")
+}
+
+// HandleGrantItemRequest adds the granted items to the inventory of the player, and responds with the updated inventory
+func (ds *Server) HandleGrantItemRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be an ItemGrant struct
+	decodedReq := &ItemGrant{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	inventory, err := ds.GrantItem(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not grant item: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ds.writeJSON(w, inventory, "inventory")
+}
+
+// HandleConsumeItemRequest takes the given items out of the inventory of the player, and responds with the updated inventory,
+// responding with a conflict status if the player does not own enough of them
+func (ds *Server) HandleConsumeItemRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ds == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be an ItemGrant struct
+	decodedReq := &ItemGrant{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	inventory, err := ds.ConsumeItem(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not consume item: " + err.Error()
+		ds.logger.Println(errMsg)
+		switch err.(type) {
+		case NotEnoughItemsErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ds.writeJSON(w, inventory, "inventory")
+}
+
+// InitWallet creates the given wallet if the player does not have one yet, and returns the player's wallet
+func (ds *Server) InitWallet(ctx context.Context, wallet *WalletData) (*WalletData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.InitWallet")
+	defer span.End()
+
+	if wallet == nil {
+		return nil, fmt.Errorf("provided wallet pointer is nil")
+	}
+
+	ds.walletsMutex.Lock()
+	defer ds.walletsMutex.Unlock()
+
+	key := keyOf(ctx, wallet.PlayerID)
+	existing, ok := ds.walletsDB[key]
+	if ok {
+		return &existing, nil
+	}
+
+	ds.logger.Printf("creating wallets DB entry for id: %v", wallet.PlayerID)
+	ds.walletsDB[key] = *wallet
+
+	newWallet := *wallet
+	return &newWallet, nil
+}
+
+// ReadWallet returns the wallet of the given player
+func (ds *Server) ReadWallet(ctx context.Context, playerID string) (*WalletData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadWallet")
+	defer span.End()
+
+	ds.walletsMutex.Lock()
+	defer ds.walletsMutex.Unlock()
+
+	wallet, ok := ds.walletsDB[keyOf(ctx, playerID)]
+	if !ok {
+		return nil, WalletNotFoundErr{playerID}
+	}
+
+	return &wallet, nil
+}
+
+// AdjustWallet adds the delta to the wallet of the given player, the check for enough coins (for a negative delta)
+// and the update happen under the same lock, so concurrent purchases can never overdraw a wallet
+func (ds *Server) AdjustWallet(ctx context.Context, playerID string, delta int64) (*WalletData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.AdjustWallet")
+	defer span.End()
+
+	ds.walletsMutex.Lock()
+	defer ds.walletsMutex.Unlock()
+
+	key := keyOf(ctx, playerID)
+	wallet, ok := ds.walletsDB[key]
+	if !ok {
+		return nil, WalletNotFoundErr{playerID}
+	}
+
+	if wallet.Coins+delta < 0 {
+		return nil, InsufficientCoinsErr{playerID}
+	}
+
+	ds.logger.Printf("adjusting wallets DB entry for id: %v by %v coins", playerID, delta)
+
+	wallet.Coins += delta
+	ds.walletsDB[key] = wallet
+
+	return &wallet, nil
+}
+
+// ReadInventory returns a copy of the inventory of the given player (empty if they own nothing)
+func (ds *Server) ReadInventory(ctx context.Context, playerID string) (*InventoryData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ReadInventory")
+	defer span.End()
+
+	ds.inventoriesMutex.Lock()
+	defer ds.inventoriesMutex.Unlock()
+
+	return &InventoryData{PlayerID: playerID, Items: append([]InventoryItem{}, ds.inventoriesDB[keyOf(ctx, playerID)]...)}, nil
+}
+
+// GrantItem adds the granted items to the inventory of the player, and returns a copy of the updated inventory
+func (ds *Server) GrantItem(ctx context.Context, grant *ItemGrant) (*InventoryData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.GrantItem")
+	defer span.End()
+
+	if grant == nil || grant.PlayerID == "" || grant.ItemID == "" || grant.Count <= 0 {
+		return nil, fmt.Errorf("invalid item grant")
+	}
+
+	ds.logger.Printf("granting %v of item %v to id: %v", grant.Count, grant.ItemID, grant.PlayerID)
+
+	ds.inventoriesMutex.Lock()
+	defer ds.inventoriesMutex.Unlock()
+
+	key := keyOf(ctx, grant.PlayerID)
+	items := ds.inventoriesDB[key]
+	index := slices.IndexFunc(items, func(item InventoryItem) bool { return item.ItemID == grant.ItemID })
+	if index >= 0 {
+		items[index].Count += grant.Count
+	} else {
+		items = append(items, InventoryItem{ItemID: grant.ItemID, Count: grant.Count})
+	}
+	ds.inventoriesDB[key] = items
+
+	return &InventoryData{PlayerID: grant.PlayerID, Items: append([]InventoryItem{}, items...)}, nil
+}
+
+// ConsumeItem takes the given items out of the inventory of the player, and returns the updated inventory
+// (items whose count drops to zero are removed), it fails without changes if the player does not own enough of them
+func (ds *Server) ConsumeItem(ctx context.Context, grant *ItemGrant) (*InventoryData, error) {
+
+	if ds == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "data.ConsumeItem")
+	defer span.End()
+
+	if grant == nil || grant.PlayerID == "" || grant.ItemID == "" || grant.Count <= 0 {
+		return nil, fmt.Errorf("invalid item grant")
+	}
+
+	ds.inventoriesMutex.Lock()
+	defer ds.inventoriesMutex.Unlock()
+
+	key := keyOf(ctx, grant.PlayerID)
+	items := ds.inventoriesDB[key]
+	index := slices.IndexFunc(items, func(item InventoryItem) bool { return item.ItemID == grant.ItemID })
+	if index < 0 || items[index].Count < grant.Count {
+		return nil, NotEnoughItemsErr{PlayerID: grant.PlayerID, ItemID: grant.ItemID}
+	}
+
+	ds.logger.Printf("consuming %v of item %v of id: %v", grant.Count, grant.ItemID, grant.PlayerID)
+
+	items[index].Count -= grant.Count
+	if items[index].Count == 0 {
+		items = slices.Delete(items, index, index+1)
+	}
+	ds.inventoriesDB[key] = items
+
+	return &InventoryData{PlayerID: grant.PlayerID, Items: append([]InventoryItem{}, items...)}, nil
+}
+
+// writeJSON writes the given value as the json response
+func (ds *Server) writeJSON(w http.ResponseWriter, value any, entryKind string) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		errMsg := fmt.Sprintf("error: could not encode %v: %v", entryKind, err.Error())
+		ds.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
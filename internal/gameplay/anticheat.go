@@ -0,0 +1,281 @@
+package gameplay
+
+import (
+	"cmp"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maxReviewFlags is the number of (most recent) flags kept per player in the review list
+const maxReviewFlags = 20
+
+// reasons a player is flagged for review
+const (
+	ReviewReasonImpossibleRoll   = "impossible-roll"
+	ReviewReasonImprobableStreak = "improbable-streak"
+	ReviewReasonSubmissionRate   = "submission-rate"
+)
+
+// ReviewEntryNotFoundErr is returned when the player is not in the review list
+type ReviewEntryNotFoundErr struct {
+	PlayerID string
+}
+
+func (err ReviewEntryNotFoundErr) Error() string {
+	return fmt.Sprintf("player id %v is not in the review list", err.PlayerID)
+}
+
+// ReviewFlag is a single suspicious submission by a player, with the attempt it was submitted for
+// (left out when the submission did not reference one of the player's attempts)
+type ReviewFlag struct {
+	Reason    string `json:"reason"`
+	Details   string `json:"details"`
+	AttemptID string `json:"attemptID,omitempty"`
+	FlaggedAt int64  `json:"flaggedAt"`
+}
+
+// ReviewEntry holds the flags raised for a player, who needs to be reviewed by an admin
+type ReviewEntry struct {
+	PlayerID      string       `json:"playerID"`
+	Flags         []ReviewFlag `json:"flags"`
+	LastFlaggedAt int64        `json:"lastFlaggedAt"`
+}
+
+// ReviewPage is a page of the review list, with the cursor of the next page (left out on the last page)
+type ReviewPage struct {
+	Entries    []ReviewEntry `json:"entries"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// reviewKey is the key a review entry is paginated by
+type reviewKey struct {
+	LastFlaggedAt int64  `json:"lastFlaggedAt"`
+	PlayerID      string `json:"playerID"`
+}
+
+func reviewKeyOf(entry ReviewEntry) reviewKey {
+	return reviewKey{LastFlaggedAt: entry.LastFlaggedAt, PlayerID: entry.PlayerID}
+}
+
+// compareReviewKeys orders the review keys by the time the players were last flagged, and then by reverse player id,
+// so the (default) descending order is the order of the ReviewList
+func compareReviewKeys(a, b reviewKey) int {
+	if a.LastFlaggedAt != b.LastFlaggedAt {
+		return cmp.Compare(a.LastFlaggedAt, b.LastFlaggedAt)
+	}
+	return strings.Compare(b.PlayerID, a.PlayerID)
+}
+
+// playerActivity is what the cheat detection remembers about the recent level results of a player
+type playerActivity struct {
+
+	// the number of wins in a row, and the probability of winning all of them
+	streakLength      int
+	streakProbability float64
+
+	// unix times of the results submitted within the last minute
+	recentResults []int64
+}
+
+// flagForReview adds a flag with the given reason and details to the review list entry of the player,
+// and to the record of the given attempt (if it is one of the player's)
+func (gs *Server) flagForReview(playerID string, attemptID string, reason string, details string, unixNow int64) {
+
+	gs.reviewMutex.Lock()
+	defer gs.reviewMutex.Unlock()
+
+	gs.flagForReviewLocked(playerID, attemptID, reason, details, unixNow)
+}
+
+// flagForReviewLocked is flagForReview for callers already holding the review mutex
+func (gs *Server) flagForReviewLocked(playerID string, attemptID string, reason string, details string, unixNow int64) {
+
+	gs.logger.Printf("flagging player id %v for review (attempt %q), reason: %v, details: %v", playerID, attemptID, reason, details)
+
+	flag := ReviewFlag{Reason: reason, Details: details, AttemptID: attemptID, FlaggedAt: unixNow}
+	if !gs.flagAttempt(playerID, attemptID, flag) {
+		flag.AttemptID = ""
+	}
+
+	entry, ok := gs.reviewList[playerID]
+	if !ok {
+		entry = &ReviewEntry{PlayerID: playerID}
+		gs.reviewList[playerID] = entry
+	}
+
+	entry.Flags = append(entry.Flags, flag)
+	if len(entry.Flags) > maxReviewFlags {
+		entry.Flags = entry.Flags[len(entry.Flags)-maxReviewFlags:]
+	}
+	entry.LastFlaggedAt = unixNow
+}
+
+// checkLevelResult runs the cheat detection heuristics on a (verified) level result of the player's attempt:
+// wins in a row which are too unlikely (see constants.ImprobableStreakProbability), and results submitted
+// faster than humanly possible (see constants.MaxResultsPerMinute) get the player flagged for review
+func (gs *Server) checkLevelResult(playerID string, attemptID string, level int32, winProbability float64, won bool, unixNow int64) {
+
+	gs.reviewMutex.Lock()
+	defer gs.reviewMutex.Unlock()
+
+	activity, ok := gs.activity[playerID]
+	if !ok {
+		activity = &playerActivity{streakProbability: 1}
+		gs.activity[playerID] = activity
+	}
+
+	// submission rate
+	firstRecent := 0
+	for firstRecent < len(activity.recentResults) && unixNow-activity.recentResults[firstRecent] >= 60 {
+		firstRecent++
+	}
+	activity.recentResults = append(activity.recentResults[firstRecent:], unixNow)
+
+	if len(activity.recentResults) > constants.MaxResultsPerMinute {
+		details := fmt.Sprintf("%v level results submitted within a minute", len(activity.recentResults))
+		gs.flagForReviewLocked(playerID, attemptID, ReviewReasonSubmissionRate, details, unixNow)
+
+		// start counting again, so the same burst is only flagged once
+		activity.recentResults = nil
+	}
+
+	// win streak
+	if !won {
+		activity.streakLength = 0
+		activity.streakProbability = 1
+		return
+	}
+
+	activity.streakLength++
+	activity.streakProbability *= winProbability
+
+	if activity.streakProbability < constants.ImprobableStreakProbability {
+		details := fmt.Sprintf("%v wins in a row (ending at level %v), with a probability of %.3g", activity.streakLength, level, activity.streakProbability)
+		gs.flagForReviewLocked(playerID, attemptID, ReviewReasonImprobableStreak, details, unixNow)
+
+		// start counting again, so the same streak is only flagged once
+		activity.streakLength = 0
+		activity.streakProbability = 1
+	}
+}
+
+// HandleReviewListRequest responds with a page of the players flagged for review, most recently flagged first by default (admin only)
+func (gs *Server) HandleReviewListRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	page, err := pagination.ParseRequest(r.URL.Query())
+	if err != nil {
+		errMsg := "error: invalid page request: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// the review list is paginated in ascending order of the review keys (least recently flagged first)
+	entries := gs.ReviewList()
+	slices.Reverse(entries)
+
+	reviewPage := &ReviewPage{}
+	reviewPage.Entries, reviewPage.NextCursor, err = pagination.Paginate(entries, page.WithDefaultOrder(pagination.OrderDesc), reviewKeyOf, compareReviewKeys)
+	if err != nil {
+		errMsg := "error: invalid page request: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(reviewPage)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// HandleClearReviewRequest removes the requested player from the review list, once they have been reviewed (admin only)
+func (gs *Server) HandleClearReviewRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	id := r.PathValue("id")
+
+	err = gs.ClearReview(id)
+	if err != nil {
+		errMsg := "clear review error: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, "success")
+}
+
+// ReviewList returns a copy of the review list, most recently flagged players first
+func (gs *Server) ReviewList() []ReviewEntry {
+
+	gs.reviewMutex.Lock()
+	defer gs.reviewMutex.Unlock()
+
+	list := make([]ReviewEntry, 0, len(gs.reviewList))
+	for _, entry := range gs.reviewList {
+		list = append(list, ReviewEntry{
+			PlayerID:      entry.PlayerID,
+			Flags:         slices.Clone(entry.Flags),
+			LastFlaggedAt: entry.LastFlaggedAt,
+		})
+	}
+
+	slices.SortFunc(list, func(a, b ReviewEntry) int {
+		if a.LastFlaggedAt != b.LastFlaggedAt {
+			return int(b.LastFlaggedAt - a.LastFlaggedAt)
+		}
+		return strings.Compare(a.PlayerID, b.PlayerID)
+	})
+
+	return list
+}
+
+// ClearReview removes the player from the review list
+func (gs *Server) ClearReview(playerID string) error {
+
+	gs.reviewMutex.Lock()
+	defer gs.reviewMutex.Unlock()
+
+	if _, ok := gs.reviewList[playerID]; !ok {
+		return ReviewEntryNotFoundErr{PlayerID: playerID}
+	}
+
+	delete(gs.reviewList, playerID)
+	return nil
+}
@@ -0,0 +1,153 @@
+package gameplay
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// asyncStatsQueueSize is how many stats updates can wait in the queue, when it is full,
+// level results fall back to updating the stats synchronously
+const asyncStatsQueueSize = 1000
+
+// statsUpdate is a stats delta of a player waiting in the async stats queue
+type statsUpdate struct {
+	playerID   string
+	statsDelta data.PlayerLevelStats
+}
+
+// StatsStatusResponse is used as the client response for the stats status api,
+// once no updates are pending, the player's stats (from the stats service) are up to date
+type StatsStatusResponse struct {
+	PlayerID string `json:"playerID"`
+	Pending  int    `json:"pending"`
+}
+
+// EnableAsyncStatsFromEnv enables async stats updates with the number of workers given by
+// the async stats workers environment variable (see constants.AsyncStatsWorkersEnvVar), stats updates stay synchronous if it is not set
+func (gs *Server) EnableAsyncStatsFromEnv() error {
+
+	if gs == nil {
+		return serverNilError
+	}
+
+	workersEnv := os.Getenv(constants.AsyncStatsWorkersEnvVar)
+	if workersEnv == "" {
+		return nil
+	}
+
+	workers, err := strconv.Atoi(workersEnv)
+	if err != nil || workers <= 0 {
+		return fmt.Errorf("%v should be a positive number of workers, got: %v", constants.AsyncStatsWorkersEnvVar, workersEnv)
+	}
+
+	gs.EnableAsyncStats(workers)
+	return nil
+}
+
+// EnableAsyncStats makes level results enqueue their stats updates instead of waiting for the stats service,
+// and starts the given number of workers which send the queued updates to the stats service
+func (gs *Server) EnableAsyncStats(workers int) {
+
+	if gs == nil {
+		return
+	}
+
+	queue := make(chan *statsUpdate, asyncStatsQueueSize)
+
+	gs.pendingStatsMutex.Lock()
+	gs.statsQueue = queue
+	gs.pendingStatsMutex.Unlock()
+
+	gs.logger.Printf("async stats updates enabled with %v workers", workers)
+
+	for range workers {
+		go func() {
+			for update := range queue {
+				gs.processStatsUpdate(update)
+			}
+		}()
+	}
+}
+
+// enqueueStatsUpdate puts the stats delta in the async stats queue, and returns whether it was queued
+// (it is not when async stats are disabled, or the queue is full)
+func (gs *Server) enqueueStatsUpdate(playerID string, statsDelta *data.PlayerLevelStats) bool {
+
+	gs.pendingStatsMutex.Lock()
+	defer gs.pendingStatsMutex.Unlock()
+
+	if gs.statsQueue == nil {
+		return false
+	}
+
+	select {
+	case gs.statsQueue <- &statsUpdate{playerID: playerID, statsDelta: *statsDelta}:
+		gs.pendingStats[playerID]++
+		return true
+	default:
+		gs.logger.Printf("the async stats queue is full, updating the stats of player id %v synchronously", playerID)
+		return false
+	}
+}
+
+// processStatsUpdate sends a queued stats update to the stats service, and marks it as no longer pending
+// (a failed update goes to the outbox if it is enabled, otherwise it is logged and dropped, since the level result has already been sent)
+func (gs *Server) processStatsUpdate(update *statsUpdate) {
+
+	ctx := context.Background()
+	_, err := gs.statsClient.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(ctx, update.playerID), &update.statsDelta)
+	if err != nil && !gs.addToOutbox(ctx, update.playerID, &update.statsDelta, err) {
+		gs.logger.Printf("error: async stats update for player id %v (level %v) failed: %v", update.playerID, update.statsDelta.Level, err)
+	}
+
+	gs.markStatsUpdateDone(update.playerID)
+}
+
+// markStatsUpdateDone marks one of the stats updates of the given player as no longer pending
+func (gs *Server) markStatsUpdateDone(playerID string) {
+
+	gs.pendingStatsMutex.Lock()
+	defer gs.pendingStatsMutex.Unlock()
+
+	gs.pendingStats[playerID]--
+	if gs.pendingStats[playerID] <= 0 {
+		delete(gs.pendingStats, playerID)
+	}
+}
+
+// HandleStatsStatusRequest responds with the number of stats updates still pending for the given player,
+// clients using async stats can fetch the player's stats from the stats service once none are pending
+func (gs *Server) HandleStatsStatusRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// get the player id from the request path
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+
+	gs.pendingStatsMutex.Lock()
+	pending := gs.pendingStats[id]
+	gs.pendingStatsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&StatsStatusResponse{PlayerID: id, Pending: pending})
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
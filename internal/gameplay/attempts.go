@@ -0,0 +1,428 @@
+package gameplay
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Attempt Errors:
+var usedAttemptError = fmt.Errorf("the attempt already has a different result")
+var pendingAttemptError = fmt.Errorf("the result of the attempt is still being applied, or could not be applied")
+var refundedAttemptError = fmt.Errorf("the energy of the attempt has been refunded")
+var attemptHasResultError = fmt.Errorf("the attempt already has a result")
+var attemptCostNothingError = fmt.Errorf("the attempt did not cost any energy")
+
+// attemptRefundPeriod is how often the expired attempts are checked for energy to refund
+const attemptRefundPeriod = time.Minute
+
+// LevelAttemptNotFoundErr is returned when there is no record of the attempt (or it has expired)
+type LevelAttemptNotFoundErr struct {
+	AttemptID string
+}
+
+func (err LevelAttemptNotFoundErr) Error() string {
+	return fmt.Sprintf("no record of attempt id %v", err.AttemptID)
+}
+
+// LevelAttempt is the record of a single attempt at a level, from the entry which opened it till it expires.
+// Once the result is in, it has the rolls and the win / loss of the attempt, along with the cheat detection flags raised for it.
+// An attempt which never gets a result has the energy spent on its entry refunded (see refundExpiredAttempts)
+type LevelAttempt struct {
+	AttemptID  string       `json:"attemptID"`
+	PlayerID   string       `json:"playerID"`
+	Level      int32        `json:"level"`
+	Mode       string       `json:"mode"`
+	IssuedAt   int64        `json:"issuedAt"`
+	EnergyCost int32        `json:"energyCost,omitempty"`
+	ResultAt   int64        `json:"resultAt,omitempty"`
+	Rolls      []int32      `json:"rolls,omitempty"`
+	Won        bool         `json:"won,omitempty"`
+	Flags      []ReviewFlag `json:"flags,omitempty"`
+	RefundedAt int64        `json:"refundedAt,omitempty"`
+
+	// the response the result got, which is sent again when the same result is retried
+	response *LevelResultResponse
+
+	// the namespace the attempt was entered in, which its refund is made in
+	namespace string
+
+	// whether the refund of the attempt is being made, its record is kept till the refund is made (or undone)
+	refunding bool
+}
+
+// owesRefund returns whether the energy spent on the attempt should be given back, once it has expired
+func (attempt *LevelAttempt) owesRefund() bool {
+	return attempt.EnergyCost > 0 && attempt.ResultAt == 0 && attempt.RefundedAt == 0
+}
+
+// openAttempt records a new attempt, for the entry token with the given claims
+func (gs *Server) openAttempt(claims *EntryTokenClaims) {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	gs.attempts[claims.AttemptID] = &LevelAttempt{
+		AttemptID: claims.AttemptID,
+		PlayerID:  claims.PlayerID,
+		Level:     claims.Level,
+		Mode:      claims.Mode,
+		IssuedAt:  claims.IssuedAt,
+	}
+}
+
+// chargeAttempt records the energy spent on the entry of the given attempt (in the namespace of the context),
+// so it can be refunded if the attempt never gets a result
+func (gs *Server) chargeAttempt(ctx context.Context, attemptID string, energyCost int32) {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	attempt, ok := gs.attempts[attemptID]
+	if !ok {
+		return
+	}
+
+	attempt.EnergyCost = energyCost
+	attempt.namespace = namespace.FromContext(ctx)
+}
+
+// claimAttempt records the given rolls as the result of the (verified) attempt, so each attempt only has one result.
+// When the attempt already has the same result (the request is retried), it returns the response that result got,
+// so it is not applied twice, a different result gets usedAttemptError
+func (gs *Server) claimAttempt(claims *EntryTokenClaims, rolls []int32, unixNow int64) (*LevelResultResponse, error) {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	gs.forgetExpiredAttempts(unixNow)
+
+	attempt, ok := gs.attempts[claims.AttemptID]
+	if !ok {
+		// the attempt was opened before a restart (or by another instance), the token vouches for it
+		attempt = &LevelAttempt{AttemptID: claims.AttemptID, PlayerID: claims.PlayerID, Level: claims.Level, Mode: claims.Mode, IssuedAt: claims.IssuedAt}
+		gs.attempts[claims.AttemptID] = attempt
+	}
+
+	if attempt.RefundedAt != 0 {
+		return nil, refundedAttemptError
+	}
+
+	if attempt.ResultAt != 0 {
+		if !slices.Equal(attempt.Rolls, rolls) {
+			return nil, usedAttemptError
+		}
+		if attempt.response == nil {
+			return nil, pendingAttemptError
+		}
+		return attempt.response, nil
+	}
+
+	attempt.ResultAt = unixNow
+	attempt.Rolls = slices.Clone(rolls)
+	return nil, nil
+}
+
+// completeAttempt keeps the response the result of the attempt got, for the retries of the same result
+func (gs *Server) completeAttempt(attemptID string, response *LevelResultResponse) {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	attempt, ok := gs.attempts[attemptID]
+	if !ok {
+		return
+	}
+
+	attempt.Won = response.LevelResult.Won
+	attempt.response = response
+}
+
+// releaseAttempt takes back the result of an attempt which could not be applied at all, so the result can be sent again
+func (gs *Server) releaseAttempt(attemptID string) {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	attempt, ok := gs.attempts[attemptID]
+	if !ok {
+		return
+	}
+
+	attempt.ResultAt = 0
+	attempt.Rolls = nil
+}
+
+// flagAttempt adds the given flag to the record of the given attempt of the player, and reports whether there is one
+func (gs *Server) flagAttempt(playerID string, attemptID string, flag ReviewFlag) bool {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	attempt, ok := gs.attempts[attemptID]
+	if !ok || attempt.PlayerID != playerID {
+		return false
+	}
+
+	attempt.Flags = append(attempt.Flags, flag)
+	return true
+}
+
+// forgetExpiredAttempts deletes the records of the attempts whose entry tokens have expired (except the ones whose
+// energy is still to be refunded, see refundExpiredAttempts), the caller has to hold the attempts mutex
+func (gs *Server) forgetExpiredAttempts(unixNow int64) {
+	for attemptID, attempt := range gs.attempts {
+		if attemptExpired(attempt, unixNow) && !attempt.owesRefund() && !attempt.refunding {
+			delete(gs.attempts, attemptID)
+		}
+	}
+}
+
+// attemptExpired returns whether the entry token of the given attempt has expired at the given time,
+// after which the attempt cannot take a result any more
+func attemptExpired(attempt *LevelAttempt, unixNow int64) bool {
+	return unixNow-attempt.IssuedAt > constants.EntryTokenExpirySeconds
+}
+
+// StartPeriodicAttemptRefunds starts refunding the energy of the expired attempts which never got a result
+// with the given period, till the given context is done
+func (gs *Server) StartPeriodicAttemptRefunds(ctx context.Context, checkPeriod time.Duration) {
+
+	if gs == nil {
+		return
+	}
+
+	ticker := time.NewTicker(checkPeriod)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				gs.refundExpiredAttempts(gs.clock.Now().UTC().Unix())
+			}
+		}
+	}()
+}
+
+// refundExpiredAttempts gives back the energy spent on the attempts which expired at the given time without a result
+// (like when the entry response never reached the client, or the client gave up on the level), and returns the number
+// of attempts refunded. A refund which fails is tried again on the next check, and the record of an attempt is kept
+// till its energy is refunded
+func (gs *Server) refundExpiredAttempts(unixNow int64) int {
+
+	// the attempts are marked as refunded first, so an admin cannot refund them again in the meantime
+	gs.attemptsMutex.Lock()
+	due := []LevelAttempt{}
+	for _, attempt := range gs.attempts {
+		if attemptExpired(attempt, unixNow) && attempt.owesRefund() {
+			attempt.RefundedAt = unixNow
+			attempt.refunding = true
+			due = append(due, *attempt)
+		}
+	}
+	gs.attemptsMutex.Unlock()
+
+	refunded := 0
+	for _, attempt := range due {
+
+		ctx := namespace.NewContext(context.Background(), attempt.namespace)
+		_, err := gs.profileClient.RefundEnergy(ctx, &profile.EnergyRefund{
+			PlayerID:  attempt.PlayerID,
+			AttemptID: attempt.AttemptID,
+			Energy:    attempt.EnergyCost,
+			Actor:     audit.ActorSystem,
+		})
+		if err != nil {
+			gs.logger.Printf("error: could not refund the energy of expired attempt %v of player id %v: %v", attempt.AttemptID, attempt.PlayerID, err)
+			gs.finishRefund(attempt.AttemptID, false)
+			continue
+		}
+
+		gs.logger.Printf("refunded %v energy to player id %v for attempt %v, which expired without a result", attempt.EnergyCost, attempt.PlayerID, attempt.AttemptID)
+
+		gs.attemptsMutex.Lock()
+		delete(gs.attempts, attempt.AttemptID)
+		gs.attemptsMutex.Unlock()
+
+		refunded += 1
+	}
+
+	return refunded
+}
+
+// RefundAttempt gives back the energy spent on the given attempt (like when a player reports a level that never
+// started), which has to have no result yet, and closes the attempt, so it cannot take a result any more.
+// The refund is recorded in the audit log, with the admin as the actor
+func (gs *Server) RefundAttempt(ctx context.Context, attemptID string) (*data.PlayerData, error) {
+
+	if gs == nil {
+		return nil, serverNilError
+	}
+
+	// mark the attempt as refunded first, so a result sent in the meantime is turned away
+	refund, err := gs.markRefunded(attemptID, gs.clock.Now().UTC().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	player, err := gs.profileClient.RefundEnergy(ctx, refund)
+	gs.finishRefund(attemptID, err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return player, nil
+}
+
+// finishRefund records the end of the refund of the given attempt (see markRefunded), an attempt which was not
+// refunded is open again
+func (gs *Server) finishRefund(attemptID string, refunded bool) {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	attempt, ok := gs.attempts[attemptID]
+	if !ok {
+		return
+	}
+
+	attempt.refunding = false
+	if !refunded {
+		attempt.RefundedAt = 0
+	}
+}
+
+// markRefunded marks the given attempt as refunded by an admin at the given time, and returns the refund of its energy,
+// the attempt has to have cost energy, and have no result (or refund) yet
+func (gs *Server) markRefunded(attemptID string, unixNow int64) (*profile.EnergyRefund, error) {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	attempt, ok := gs.attempts[attemptID]
+	switch {
+	case !ok:
+		return nil, LevelAttemptNotFoundErr{AttemptID: attemptID}
+	case attempt.RefundedAt != 0:
+		return nil, refundedAttemptError
+	case attempt.ResultAt != 0:
+		return nil, attemptHasResultError
+	case attempt.EnergyCost <= 0:
+		return nil, attemptCostNothingError
+	}
+
+	attempt.RefundedAt = unixNow
+	attempt.refunding = true
+	return &profile.EnergyRefund{PlayerID: attempt.PlayerID, AttemptID: attemptID, Energy: attempt.EnergyCost, Actor: audit.ActorAdmin}, nil
+}
+
+// Attempt returns a copy of the record of the given attempt
+func (gs *Server) Attempt(attemptID string) (*LevelAttempt, error) {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	attempt, ok := gs.attempts[attemptID]
+	if !ok {
+		return nil, LevelAttemptNotFoundErr{AttemptID: attemptID}
+	}
+
+	attemptCopy := *attempt
+	attemptCopy.Rolls = slices.Clone(attempt.Rolls)
+	attemptCopy.Flags = slices.Clone(attempt.Flags)
+	return &attemptCopy, nil
+}
+
+// HandleRefundAttemptRequest gives back the energy spent on the requested attempt (admin only), and responds with the
+// updated player data, with a 404 if there is no record of the attempt, and a 409 if it already has a result (or a refund)
+func (gs *Server) HandleRefundAttemptRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	attemptID := r.PathValue("id")
+	gs.logger.Printf("admin refund request for attempt id: %v", attemptID)
+
+	player, err := gs.RefundAttempt(r.Context(), attemptID)
+	if err != nil {
+		errMsg := "error: could not refund attempt: " + err.Error()
+		gs.logger.Println(errMsg)
+		switch err {
+		case refundedAttemptError, attemptHasResultError:
+			http.Error(w, errMsg, http.StatusConflict)
+		case attemptCostNothingError:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		default:
+			switch err.(type) {
+			case LevelAttemptNotFoundErr, data.PlayerNotFoundErr:
+				http.Error(w, errMsg, http.StatusNotFound)
+			default:
+				http.Error(w, errMsg, http.StatusInternalServerError)
+			}
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(player)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// HandleAttemptRequest responds with the record of the requested attempt, with its rolls and flags (admin only)
+func (gs *Server) HandleAttemptRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	attempt, err := gs.Attempt(r.PathValue("id"))
+	if err != nil {
+		errMsg := "attempt error: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(attempt)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
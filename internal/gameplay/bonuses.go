@@ -0,0 +1,15 @@
+package gameplay
+
+import (
+	"example.com/dice-game-backend/pkg/rules"
+)
+
+// kinds of bonuses a level result can apply (see config.BonusConfig, the bonuses are applied by the rules package)
+const (
+	BonusFirstRoll = rules.BonusFirstRoll
+	BonusCombo     = rules.BonusCombo
+)
+
+// AppliedBonus is a bonus applied to the energy reward of a level result, with the extra energy it gave
+// (and the multiplier, for combo bonuses), so the client can animate it
+type AppliedBonus = rules.AppliedBonus
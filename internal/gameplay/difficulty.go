@@ -0,0 +1,143 @@
+package gameplay
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/stats"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DifficultyAdjustment is the change the dynamic difficulty made to a level for a player (based on their recent win rate),
+// it is sent in the entry response (so the client can show the adjusted level), and kept in the entry token for the result
+type DifficultyAdjustment struct {
+	WinRate     float64 `json:"winRate"`
+	TargetDelta int32   `json:"targetDelta"`
+	RollsDelta  int32   `json:"rollsDelta"`
+	Target      int32   `json:"target"`
+	TotalRolls  int32   `json:"totalRolls"`
+}
+
+// EnableDynamicDifficultyFromEnv enables the dynamic difficulty if the dynamic difficulty environment variable
+// (see constants.DynamicDifficultyEnvVar) is set to true, the levels stay as they are configured if it is not set
+func (gs *Server) EnableDynamicDifficultyFromEnv() error {
+
+	if gs == nil {
+		return serverNilError
+	}
+
+	difficultyEnv := os.Getenv(constants.DynamicDifficultyEnvVar)
+	if difficultyEnv == "" {
+		return nil
+	}
+
+	enabled, err := strconv.ParseBool(difficultyEnv)
+	if err != nil {
+		return fmt.Errorf("%v should be true or false, got: %v", constants.DynamicDifficultyEnvVar, difficultyEnv)
+	}
+
+	if enabled {
+		gs.EnableDynamicDifficulty()
+	}
+	return nil
+}
+
+// EnableDynamicDifficulty makes normal level entries adjust the level for the player, based on their recent win rate
+// (from the stats service), with the steps in the difficulty config
+func (gs *Server) EnableDynamicDifficulty() {
+
+	if gs == nil {
+		return
+	}
+
+	gs.dynamicDifficulty = true
+	gs.logger.Println("dynamic difficulty enabled")
+}
+
+// difficultyAdjustment returns the adjustment to make to the given level for the player, or nil if the level stays as it is
+// (when the dynamic difficulty is disabled or flagged off for the player, or no step applies). Errors are logged rather than returned,
+// so the player can still enter the level (unadjusted) when their recent form cannot be read
+func (gs *Server) difficultyAdjustment(ctx context.Context, playerID string, levelConfig *config.LevelConfig) *DifficultyAdjustment {
+
+	difficultyConfig := config.Config.Difficulty
+	if !gs.dynamicDifficulty || difficultyConfig.RecentAttempts <= 0 || len(difficultyConfig.Steps) == 0 {
+		return nil
+	}
+
+	if !gs.flags.Enabled(ctx, config.FlagDynamicDifficulty, playerID) {
+		return nil
+	}
+
+	form, err := gs.statsClient.ReturnRecentForm(ctx, playerID, difficultyConfig.RecentAttempts)
+	if err != nil {
+		gs.logger.Printf("error: could not read the recent form of player id %v: %v", playerID, err)
+		return nil
+	}
+
+	return adjustLevel(levelConfig, form, &difficultyConfig)
+}
+
+// adjustLevel returns the adjustment of the first difficulty step the recent win rate is within,
+// or nil if the player does not have enough recent attempts, no step applies, or the step changes nothing.
+// A target which cannot be rolled with the dice of the level is not adjusted, and there is always at least one roll
+func adjustLevel(levelConfig *config.LevelConfig, form *stats.RecentForm, difficultyConfig *config.DifficultyConfig) *DifficultyAdjustment {
+
+	if form.Attempts() == 0 || form.Attempts() < difficultyConfig.MinAttempts {
+		return nil
+	}
+
+	winRate := form.WinRate()
+	for _, step := range difficultyConfig.Steps {
+		if winRate < step.MinWinRate || winRate > step.MaxWinRate {
+			continue
+		}
+
+		adjustment := &DifficultyAdjustment{WinRate: winRate, Target: levelConfig.Target, TotalRolls: levelConfig.TotalRolls}
+
+		if levelConfig.IsValidRoll(levelConfig.Target + step.TargetDelta) {
+			adjustment.Target += step.TargetDelta
+		}
+		adjustment.TotalRolls = max(1, levelConfig.TotalRolls+step.RollsDelta)
+
+		adjustment.TargetDelta = adjustment.Target - levelConfig.Target
+		adjustment.RollsDelta = adjustment.TotalRolls - levelConfig.TotalRolls
+		if adjustment.TargetDelta == 0 && adjustment.RollsDelta == 0 {
+			return nil
+		}
+
+		return adjustment
+	}
+
+	return nil
+}
+
+// maxExtraRolls returns the most rolls the dynamic difficulty can add to a level (0 when it is disabled)
+func (gs *Server) maxExtraRolls() int32 {
+
+	if !gs.dynamicDifficulty {
+		return 0
+	}
+
+	extraRolls := int32(0)
+	for _, step := range config.Config.Difficulty.Steps {
+		extraRolls = max(extraRolls, step.RollsDelta)
+	}
+
+	return extraRolls
+}
+
+// apply returns a copy of the given level config with the adjusted target and total rolls
+// (a nil adjustment returns the level config as it is)
+func (adjustment *DifficultyAdjustment) apply(levelConfig *config.LevelConfig) *config.LevelConfig {
+
+	if adjustment == nil {
+		return levelConfig
+	}
+
+	adjusted := *levelConfig
+	adjusted.Target = adjustment.Target
+	adjusted.TotalRolls = adjustment.TotalRolls
+	return &adjusted
+}
@@ -0,0 +1,145 @@
+package gameplay
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Entry Token Errors:
+var malformedEntryTokenError = fmt.Errorf("malformed entry token")
+var invalidEntryTokenSignatureError = fmt.Errorf("invalid entry token signature")
+var expiredEntryTokenError = fmt.Errorf("entry token has expired")
+
+// EntryTokenClaims are the details encoded (and signed) in the entry token
+// which is handed out when a player is granted access to a level
+// (with the difficulty adjustment of the attempt, if the dynamic difficulty adjusted the level)
+type EntryTokenClaims struct {
+	PlayerID   string                `json:"playerID"`
+	Level      int32                 `json:"level"`
+	Mode       string                `json:"mode"`
+	AttemptID  string                `json:"attemptID"`
+	IssuedAt   int64                 `json:"issuedAt"`
+	Difficulty *DifficultyAdjustment `json:"difficulty,omitempty"`
+}
+
+// newEntryTokenKey returns the secret from the environment if it is set, otherwise a random one
+func newEntryTokenKey() []byte {
+
+	secret := os.Getenv(constants.EntryTokenSecretEnvVar)
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	key := make([]byte, 32)
+	_, _ = rand.Read(key) // never returns an error
+	return key
+}
+
+// issueEntryToken returns a signed entry token for a new attempt at the given level (in the given mode, with the given
+// difficulty adjustment, if any) by the given player, along with the id of the attempt it opens,
+// the token is of the form base64(claims json).base64(hmac sha256 of the claims json)
+func (gs *Server) issueEntryToken(playerID string, level int32, mode string, difficulty *DifficultyAdjustment) (string, string, error) {
+
+	attemptID := make([]byte, 8)
+	_, _ = rand.Read(attemptID) // never returns an error
+
+	claims := &EntryTokenClaims{
+		PlayerID:   playerID,
+		Level:      level,
+		Mode:       mode,
+		AttemptID:  hex.EncodeToString(attemptID),
+		IssuedAt:   gs.clock.Now().UTC().Unix(),
+		Difficulty: difficulty,
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", "", err
+	}
+
+	gs.openAttempt(claims)
+
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(gs.signEntryToken(payload)), claims.AttemptID, nil
+}
+
+// verifyEntryToken checks that the given token was issued by this server for the given player, level and attempt,
+// and that it has not expired (see claimAttempt for making sure each attempt has only one result).
+// It returns the claims of the token (like the mode the level was entered in)
+func (gs *Server) verifyEntryToken(token string, playerID string, level int32, attemptID string) (*EntryTokenClaims, error) {
+
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, malformedEntryTokenError
+	}
+
+	encoding := base64.RawURLEncoding
+	payload, err := encoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, malformedEntryTokenError
+	}
+
+	signature, err := encoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, malformedEntryTokenError
+	}
+
+	if !hmac.Equal(signature, gs.signEntryToken(payload)) {
+		return nil, invalidEntryTokenSignatureError
+	}
+
+	claims := &EntryTokenClaims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return nil, malformedEntryTokenError
+	}
+
+	if claims.PlayerID != playerID || claims.Level != level {
+		return nil, fmt.Errorf("entry token was issued for player id %v, level %v", claims.PlayerID, claims.Level)
+	}
+
+	if claims.AttemptID != attemptID {
+		return nil, fmt.Errorf("entry token was issued for attempt id %v", claims.AttemptID)
+	}
+
+	if gs.clock.Now().UTC().Unix()-claims.IssuedAt > constants.EntryTokenExpirySeconds {
+		return nil, expiredEntryTokenError
+	}
+
+	return claims, nil
+}
+
+// LevelsBeingPlayed returns the number of open attempts, i.e. the levels which have been entered
+// (with an entry token) and have not had a result yet, and whose entry tokens have not expired
+func (gs *Server) LevelsBeingPlayed() int64 {
+
+	gs.attemptsMutex.Lock()
+	defer gs.attemptsMutex.Unlock()
+
+	unixNow := gs.clock.Now().UTC().Unix()
+	gs.forgetExpiredAttempts(unixNow)
+
+	open := int64(0)
+	for _, attempt := range gs.attempts {
+		if attempt.ResultAt == 0 && attempt.RefundedAt == 0 && !attemptExpired(attempt, unixNow) {
+			open += 1
+		}
+	}
+
+	return open
+}
+
+// signEntryToken returns the hmac sha256 of the given payload, keyed with the server's entry token key
+func (gs *Server) signEntryToken(payload []byte) []byte {
+	mac := hmac.New(sha256.New, gs.entryTokenKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
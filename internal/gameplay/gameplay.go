@@ -4,80 +4,397 @@
 package gameplay
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"example.com/dice-game-backend/internal/config"
 	"example.com/dice-game-backend/internal/data"
 	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/referral"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/clock"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/redact"
 	"example.com/dice-game-backend/internal/shared/validation"
 	"example.com/dice-game-backend/internal/stats"
+	"example.com/dice-game-backend/internal/webhooks"
+	"example.com/dice-game-backend/pkg/rules"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
-	"time"
+	"strconv"
+	"sync"
 )
 
 // Stats Specific Errors:
 var serverNilError = fmt.Errorf("provided gameplay server pointer is nil")
 
+// the modes a level can be entered in (a blank mode is the normal mode):
+// practice attempts cost no energy, and their results give no rewards, unlock nothing, and are not recorded in the stats,
+// while skipping uses up a skip ticket to unlock the next level right away, without playing the level
+const (
+	EntryModeNormal   = "normal"
+	EntryModePractice = "practice"
+	EntryModeSkip     = "skip"
+)
+
 type EnterLevelRequestBody struct {
 	PlayerID string `json:"playerID"`
 	Level    int32  `json:"level"`
+	Mode     string `json:"mode,omitempty"`
 }
 
+// EnterLevelResponse contains an entry token and the id of the attempt it opened when access is granted,
+// which both have to be sent back with the level result request for that level
+// (a skipped level has no result, so it gets neither), and the difficulty adjustment
+// the attempt is played with, when the dynamic difficulty adjusted the level for the player
 type EnterLevelResponse struct {
-	AccessGranted bool            `json:"accessGranted"`
-	Player        data.PlayerData `json:"playerData"`
+	AccessGranted bool                  `json:"accessGranted"`
+	Player        data.PlayerData       `json:"playerData"`
+	EntryToken    string                `json:"entryToken,omitempty"`
+	AttemptID     string                `json:"attemptID,omitempty"`
+	LevelSkipped  bool                  `json:"levelSkipped,omitempty"`
+	Difficulty    *DifficultyAdjustment `json:"difficulty,omitempty"`
 }
 
+// EntryLimitResponse is the response to an entry request rejected by one of the level's entry limits (the cooldown
+// or the daily attempts), with the (unix) time the player can enter the level again
+type EntryLimitResponse struct {
+	Code      apierror.Code `json:"code"`
+	Error     string        `json:"error"`
+	Limit     string        `json:"limit"`
+	ResetTime int64         `json:"resetTime"`
+}
+
+// LevelResultRequestBody is the level result request, a dry run only evaluates the result without applying it
+// (it needs no entry token or attempt id, and the response has the player data and stats the result would lead to).
+// The client can send its (unix) time, which should be within constants.MaxClientTimeDriftSeconds of the server time
 type LevelResultRequestBody struct {
-	PlayerID string  `json:"playerID"`
-	Level    int32   `json:"level"`
-	Rolls    []int32 `json:"rolls"`
+	PlayerID   string  `json:"playerID"`
+	Level      int32   `json:"level"`
+	Rolls      []int32 `json:"rolls"`
+	EntryToken string  `json:"entryToken"`
+	AttemptID  string  `json:"attemptID"`
+	DryRun     bool    `json:"dryRun,omitempty"`
+	ClientTime int64   `json:"clientTime,omitempty"`
+}
+
+// ClockDriftResponse is the response to a level result rejected because the client time drifted too far from the
+// server time, with the server time, so the client can correct its clock and send the result again
+type ClockDriftResponse struct {
+	Code       apierror.Code `json:"code"`
+	Error      string        `json:"error"`
+	ServerTime int64         `json:"serverTime"`
 }
 
 // LevelResult only contains level result details, and is sent as part of the level result response
 type LevelResult struct {
-	Won              bool  `json:"won"`
-	EnergyReward     int32 `json:"energyReward"`
-	UnlockedNewLevel bool  `json:"unlockedNewLevel"`
+	Won              bool           `json:"won"`
+	EnergyReward     int32          `json:"energyReward"`
+	UnlockedNewLevel bool           `json:"unlockedNewLevel"`
+	Practice         bool           `json:"practice,omitempty"`
+	DryRun           bool           `json:"dryRun,omitempty"`
+	Bonuses          []AppliedBonus `json:"bonuses,omitempty"`
 }
 
+// LevelResultResponse is the level result response of api version 1, which contains the stats of all levels, and how
+// the result changed the stats of the level (when the stats update is done asynchronously, or waits in the outbox,
+// the stats are left out, and marked as pending instead, and practice results leave them out altogether)
 type LevelResultResponse struct {
-	LevelResult LevelResult      `json:"levelResult"`
-	Player      data.PlayerData  `json:"playerData"`
-	Stats       data.PlayerStats `json:"statsData"`
+	LevelResult  LevelResult             `json:"levelResult"`
+	Player       data.PlayerData         `json:"playerData"`
+	Stats        data.PlayerStats        `json:"statsData,omitzero"`
+	StatsChange  *stats.LevelStatsChange `json:"statsChange,omitempty"`
+	StatsPending bool                    `json:"statsPending,omitempty"`
+
+	level int32 // the level that was played (used to shape the response for later api versions)
+}
+
+// LevelResultResponseV2 is the level result response from api version 2 onwards,
+// which only contains the stats of the level that was played
+type LevelResultResponseV2 struct {
+	LevelResult  LevelResult             `json:"levelResult"`
+	Player       data.PlayerData         `json:"playerData"`
+	LevelStats   data.PlayerLevelStats   `json:"levelStats,omitzero"`
+	StatsChange  *stats.LevelStatsChange `json:"statsChange,omitempty"`
+	StatsPending bool                    `json:"statsPending,omitempty"`
+}
+
+// ForAPIVersion returns the level result response in the shape of the given api version
+func (response *LevelResultResponse) ForAPIVersion(version int) any {
+
+	if version < middleware.APIVersion2 {
+		return response
+	}
+
+	responseV2 := &LevelResultResponseV2{
+		LevelResult:  response.LevelResult,
+		Player:       response.Player,
+		StatsChange:  response.StatsChange,
+		StatsPending: response.StatsPending,
+	}
+
+	if response.StatsPending || response.LevelResult.Practice {
+		return responseV2
+	}
+
+	responseV2.LevelStats = data.PlayerLevelStats{Level: response.level}
+	for _, levelStats := range response.Stats.LevelStats {
+		if levelStats.Level == response.level {
+			responseV2.LevelStats = levelStats
+		}
+	}
+
+	return responseV2
 }
 
 // Server is the core gameplay service provider
 type Server struct {
 	requestValidator validation.RequestValidator
-	logger           *log.Logger
+	profileClient    profile.ProfileClient
+	statsClient      stats.StatsClient
+	dataClient       data.DataClient
+
+	// used to sign entry tokens, and the records of the attempts they opened (till they expire),
+	// which make sure each attempt only has one result
+	entryTokenKey []byte
+	attempts      map[string]*LevelAttempt
+	attemptsMutex sync.Mutex
+
+	// when async stats are enabled, level results queue their stats updates here,
+	// and the number of pending updates per player is kept till the workers are done with them
+	// (or till they are replayed, for the failed updates waiting in the outbox, see EnableOutbox)
+	statsQueue        chan *statsUpdate
+	outbox            *statsOutbox
+	pendingStats      map[string]int
+	pendingStatsMutex sync.Mutex
+
+	// the cheat detection keeps the recent activity of each player, and the players flagged for review
+	activity    map[string]*playerActivity
+	reviewList  map[string]*ReviewEntry
+	reviewMutex sync.Mutex
+
+	// when referrals are enabled, the first level win of a player completes their referral (see EnableReferrals)
+	referralClient referral.ReferralClient
+
+	// when webhooks are enabled, level wins are published to them (see EnableWebhooks)
+	webhookClient webhooks.WebhookClient
+
+	// when the dynamic difficulty is enabled, normal level entries are adjusted based on the player's recent win rate
+	dynamicDifficulty bool
+
+	// when throttles are enabled, the level entries and results of each player are rate limited (see EnableThrottles)
+	throttles bool
+
+	// the feature flags are checked before using the newer features (see EnableFeatureFlags)
+	flags *config.FlagChecker
+
+	// the time is read from the clock, which is an offset clock admins can move in the time offset mode
+	// (see EnableTimeOffset)
+	clock      clock.Clock
+	timeOffset *clock.Offset
+
+	logger *log.Logger
 }
 
 // NewServer returns an initialized pointer to the gameplay server
-func NewServer(rv validation.RequestValidator) *Server {
+func NewServer(rv validation.RequestValidator, pc profile.ProfileClient, sc stats.StatsClient, dc data.DataClient) *Server {
 	return &Server{
 		requestValidator: rv,
-		logger:           log.New(os.Stdout, "gameplay: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+		profileClient:    pc,
+		statsClient:      sc,
+		dataClient:       dc,
+
+		entryTokenKey: newEntryTokenKey(),
+		attempts:      map[string]*LevelAttempt{},
+		attemptsMutex: sync.Mutex{},
+
+		pendingStats:      map[string]int{},
+		pendingStatsMutex: sync.Mutex{},
+
+		activity:    map[string]*playerActivity{},
+		reviewList:  map[string]*ReviewEntry{},
+		reviewMutex: sync.Mutex{},
+
+		clock: clock.System,
+
+		logger: log.New(redact.Stdout, "gameplay: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+	}
+}
+
+// EnableReferrals makes the first level win of each player complete their referral (if they were referred),
+// which rewards them and their referrer
+func (gs *Server) EnableReferrals(rc referral.ReferralClient) {
+
+	if gs == nil {
+		return
+	}
+
+	gs.referralClient = rc
+}
+
+// EnableWebhooks makes the server publish every level win (not practice or dry runs) to the webhooks subscribed to it
+func (gs *Server) EnableWebhooks(wc webhooks.WebhookClient) {
+
+	if gs == nil {
+		return
+	}
+
+	gs.webhookClient = wc
+}
+
+// EnableFeatureFlags makes the server check the feature flags of its newer features with the given flag checker
+// (without it, the default flags are used)
+func (gs *Server) EnableFeatureFlags(fc *config.FlagChecker) {
+
+	if gs == nil {
+		return
+	}
+
+	gs.flags = fc
+}
+
+// SetClock changes the clock the server reads the time from (the system clock by default),
+// tests use a frozen clock (see clock.Frozen), it should be called before Run
+func (gs *Server) SetClock(c clock.Clock) {
+
+	if gs == nil || c == nil {
+		return
+	}
+
+	gs.clock = c
+}
+
+// EnableTimeOffsetFromEnv enables the time offset mode if the time offset environment variable
+// (see constants.TimeOffsetEnvVar) is set to true, the server reads the time of its clock as it is if it is not set
+func (gs *Server) EnableTimeOffsetFromEnv() error {
+
+	if gs == nil {
+		return serverNilError
+	}
+
+	enabled, err := clock.OffsetModeFromEnv()
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		gs.EnableTimeOffset(clock.NewOffset(gs.clock, gs.logger))
+	}
+	return nil
+}
+
+// EnableTimeOffset makes the server read the time from the given offset clock, which admins can move ahead or back
+// with the time offset request (servers given the same offset clock move together), it should be called before Run
+func (gs *Server) EnableTimeOffset(oc *clock.Offset) {
+
+	if gs == nil || oc == nil {
+		return
+	}
+
+	gs.logger.Println("the time offset mode is enabled, admins can move the clock of the server")
+	gs.clock = oc
+	gs.timeOffset = oc
+}
+
+// HandleTimeOffsetRequest responds with the time offset of the server (admin only), after changing it for PUT requests,
+// see clock.Offset.HandleOffsetRequest, it responds with 503 if the time offset mode is not enabled
+func (gs *Server) HandleTimeOffsetRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	gs.timeOffset.HandleOffsetRequest(w, r)
+}
+
+// completeReferral completes the referral of the given player, if referrals are enabled,
+// errors are logged rather than returned, so they never fail the level result
+func (gs *Server) completeReferral(ctx context.Context, playerID string) {
+
+	if gs.referralClient == nil {
+		return
+	}
+
+	err := gs.referralClient.CompleteReferral(ctx, playerID)
+	if err != nil {
+		gs.logger.Printf("error: could not complete the referral of player id %v: %v", playerID, err)
+	}
+}
+
+// publishEvent publishes an event with the given data to the webhooks, if webhooks are enabled,
+// errors are logged rather than returned, so they never fail the level result
+func (gs *Server) publishEvent(ctx context.Context, eventType string, playerID string, eventData any) {
+
+	if gs.webhookClient == nil {
+		return
+	}
+
+	event, err := webhooks.NewEvent(eventType, playerID, eventData)
+	if err == nil {
+		err = gs.webhookClient.Publish(ctx, event)
+	}
+	if err != nil {
+		gs.logger.Printf("error: could not publish the %v event of player id %v: %v", eventType, playerID, err)
 	}
 }
 
 // Run runs a given gameplay server on the given port
 func (gs *Server) Run(port string) {
+	log.Fatal(gs.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the gameplay server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (gs *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return gs.serve(ctx, listener)
+}
+
+// RunWithListener runs the gameplay server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (gs *Server) RunWithListener(listener net.Listener) error {
+	return gs.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the gameplay server, and serves them on the given listener till the context is done
+func (gs *Server) serve(ctx context.Context, listener net.Listener) error {
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /gameplay/entry", gs.HandleEnterLevelRequest)
-	mux.HandleFunc("POST /gameplay/result", gs.HandleLevelResultRequest)
+	mux.Handle("POST /gameplay/entry", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleEnterLevelRequest), middleware.DefaultLimits))
+	mux.Handle("POST /gameplay/result", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleLevelResultRequest), middleware.DefaultLimits))
+	mux.Handle("GET /gameplay/stats-status/{id}", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleStatsStatusRequest), middleware.DefaultLimits))
+	mux.Handle("POST /gameplay/prestige", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandlePrestigeRequest), middleware.DefaultLimits))
+
+	mux.Handle("GET /gameplay/admin/review", middleware.WithLimits(gs.HandleReviewListRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /gameplay/admin/review/{id}", middleware.WithLimits(gs.HandleClearReviewRequest, middleware.DefaultLimits))
+	mux.Handle("GET /gameplay/admin/attempt/{id}", middleware.WithLimits(gs.HandleAttemptRequest, middleware.DefaultLimits))
+	mux.Handle("POST /gameplay/admin/attempt/{id}/refund", middleware.WithLimits(gs.HandleRefundAttemptRequest, middleware.DefaultLimits))
+	mux.Handle("POST /gameplay/admin/simulate", middleware.WithLimits(gs.HandleSimulationRequest, simulationLimits))
+	mux.Handle("GET /gameplay/admin/time-offset", middleware.WithLimits(gs.HandleTimeOffsetRequest, middleware.DefaultLimits))
+	mux.Handle("PUT /gameplay/admin/time-offset", middleware.WithLimits(gs.HandleTimeOffsetRequest, middleware.DefaultLimits))
+
+	middleware.RegisterLiveGauge("gameplay", "levelsBeingPlayed", gs.LevelsBeingPlayed)
+	middleware.RegisterLiveGauge("gameplay", "statsOutbox", gs.OutboxSize)
+
+	gs.StartPeriodicOutboxReplay(ctx, outboxReplayPeriod)
+	gs.StartPeriodicAttemptRefunds(ctx, attemptRefundPeriod)
 
 	gs.logger.Println("the gameplay server is up and running...")
 
-	addr := constants.CommonHost + ":" + port
-	log.Fatal(http.ListenAndServe(addr, mux))
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("gameplay", middleware.WithAccessLog("gameplay", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
 }
 
 // HandleEnterLevelRequest accepts / rejects a request to enter a level based on current player data
@@ -85,45 +402,65 @@ func (gs *Server) Run(port string) {
 func (gs *Server) HandleEnterLevelRequest(w http.ResponseWriter, r *http.Request) {
 
 	if gs == nil {
-		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
-	err := gs.requestValidator.ValidateRequest(r)
+	// decode the request
+	entryRequest := &EnterLevelRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(entryRequest)
 	if err != nil {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
-		errMsg := "error: session validation error: " + err.Error()
+		errMsg := "error: could not decode the entry request: " + err.Error()
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusUnauthorized)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
 		return
 	}
 
-	// decode the request
-	entryRequest := &EnterLevelRequestBody{}
-	err = json.NewDecoder(r.Body).Decode(entryRequest)
-	if err != nil {
-		errMsg := "error: could not decode the entry request: " + err.Error()
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &entryRequest.PlayerID) {
+		return
+	}
+
+	if entryRequest.Mode == "" {
+		entryRequest.Mode = EntryModeNormal
+	}
+
+	if entryRequest.Mode != EntryModeNormal && entryRequest.Mode != EntryModePractice && entryRequest.Mode != EntryModeSkip {
+		errMsg := "error: invalid entry mode in request: " + entryRequest.Mode
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+	gs.logger.Printf("request to enter level %v by player id %v (%v mode)", entryRequest.Level, entryRequest.PlayerID, entryRequest.Mode)
+
+	// entries coming too fast (like from a script, or a client stuck in a loop) are turned away before any other work
+	if throttledErr := gs.throttleAction(r.Context(), entryRequest.PlayerID, ThrottleActionEntry, config.Config.Throttles.Entry); throttledErr != nil {
+		gs.writeThrottledResponse(w, r, throttledErr)
 		return
 	}
-	gs.logger.Printf("request to enter level %v by player id %v", entryRequest.Level, entryRequest.PlayerID)
 
-	// get the config and the player data
-	cfg := config.Config
-	if entryRequest.Level <= 0 || entryRequest.Level > int32(len(cfg.Levels)) {
+	// get the level config and the player data
+	levelConfig, ok := config.Config.Level(entryRequest.Level)
+	if !ok {
 		errMsg := "error: invalid level in request"
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
 		return
 	}
 
 	// make a request to the profile service for the player data
-	player, err := gs.getPlayerFromProfile(entryRequest.PlayerID, r.Header.Get("Session-Id"))
+	player, err := gs.profileClient.GetPlayer(r.Context(), entryRequest.PlayerID)
 	if err != nil {
 		errMsg := "get player error: " + err.Error()
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// levels which require a step of the first time user experience stay locked (in every mode) till the player completes it
+	if player.FTUEStep < levelConfig.RequiredFTUEStep {
+		gs.logger.Printf("error: level %v requires FTUE step %v, player id %v completed step %v", entryRequest.Level, levelConfig.RequiredFTUEStep, entryRequest.PlayerID, player.FTUEStep)
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeFTUEIncomplete)
 		return
 	}
 
@@ -133,25 +470,114 @@ func (gs *Server) HandleEnterLevelRequest(w http.ResponseWriter, r *http.Request
 		Player:        *player,
 	}
 
-	energyCost := cfg.Levels[entryRequest.Level-1].EnergyCost
+	// the energy taken from the player for the entry, which is given back if the attempt never gets a result
+	spentEnergy := int32(0)
 
-	// has the player unlocked the level?
-	// does the player have enough energy to enter the level?
-	if player.Level >= entryRequest.Level && player.Energy >= energyCost {
+	switch entryRequest.Mode {
+	case EntryModeSkip:
+
+		// only the highest unlocked level can be skipped (and the last level has nothing after it)
+		if entryRequest.Level != player.Level || entryRequest.Level >= config.Config.LevelCount() {
+			break
+		}
+
+		updatedPlayer, skipErr := gs.skipLevel(r.Context(), player)
+		switch skipErr.(type) {
+		case nil:
+			entryResponse.AccessGranted = true
+			entryResponse.LevelSkipped = true
+			entryResponse.Player = *updatedPlayer
+		case data.NotEnoughItemsErr:
+			// the player has no skip ticket, so access is not granted
+		default:
+			errMsg := "skip level error: " + skipErr.Error()
+			gs.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			return
+		}
+
+	case EntryModePractice:
+
+		// practice is free, but only on the levels the player has unlocked
+		if player.Level < entryRequest.Level {
+			break
+		}
 
 		entryResponse.AccessGranted = true
 
+	default:
+
+		// the energy cost of the level can be tuned for the segment of the player
+		energyCost := config.Config.Segments.EnergyCost(gs.tunedSegment(r.Context(), player), levelConfig.EnergyCost)
+
+		// has the player unlocked the level?
+		// does the player have enough energy to enter the level?
+		if player.Level < entryRequest.Level || player.Energy < energyCost {
+			break
+		}
+
+		// levels with entry limits only let the player in once the cooldown since their last entry has passed,
+		// and while they have attempts left for the day (the entry is recorded right away if it is allowed)
+		if levelConfig.CooldownSeconds > 0 || levelConfig.MaxAttemptsPerDay > 0 {
+			_, limitErr := gs.dataClient.RecordLevelEntry(r.Context(), &data.LevelEntry{
+				PlayerID:          entryRequest.PlayerID,
+				Level:             entryRequest.Level,
+				Time:              gs.clock.Now().UTC().Unix(),
+				CooldownSeconds:   levelConfig.CooldownSeconds,
+				MaxAttemptsPerDay: levelConfig.MaxAttemptsPerDay,
+			})
+			if limitErr != nil {
+				errMsg := "record level entry error: " + limitErr.Error()
+				gs.logger.Println(errMsg)
+				switch limitErr := limitErr.(type) {
+				case data.EntryLimitErr:
+					gs.writeEntryLimitResponse(w, r, limitErr)
+				default:
+					apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+				}
+				return
+			}
+		}
+
 		// if player can enter, reduce the amount of energy
-		// make a request to the profile service to update the player data
-		updatedPlayer, updateErr := gs.updatePlayerData(entryRequest.PlayerID, -energyCost, player.Level)
-		if updateErr != nil {
-			errMsg := "update player error: " + updateErr.Error()
+		// make a request to the profile service to spend the energy, which checks it again at the time of the
+		// write, so simultaneous entries cannot spend the same energy twice (the one which comes too late fails)
+		updatedPlayer, spendErr := gs.profileClient.SpendEnergy(r.Context(), entryRequest.PlayerID, energyCost)
+		if spendErr != nil {
+			errMsg := "spend energy error: " + spendErr.Error()
 			gs.logger.Println(errMsg)
-			http.Error(w, errMsg, http.StatusInternalServerError)
+			switch spendErr.(type) {
+			case profile.InsufficientEnergyErr:
+				apierror.Write(w, r, http.StatusConflict, apierror.CodeInsufficientEnergy)
+			default:
+				apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			}
 			return
 		}
 
+		spentEnergy = energyCost
+		entryResponse.AccessGranted = true
 		entryResponse.Player = *updatedPlayer
+		entryResponse.Difficulty = gs.difficultyAdjustment(r.Context(), entryRequest.PlayerID, levelConfig)
+	}
+
+	// open an attempt (in the mode it was entered in), with a token which the level result request has to present
+	if entryResponse.AccessGranted && !entryResponse.LevelSkipped {
+		entryToken, attemptID, tokenErr := gs.issueEntryToken(entryRequest.PlayerID, entryRequest.Level, entryRequest.Mode, entryResponse.Difficulty)
+		if tokenErr != nil {
+			errMsg := "error: could not issue entry token: " + tokenErr.Error()
+			gs.logger.Println(errMsg)
+			gs.refundEntry(r.Context(), entryRequest.PlayerID, spentEnergy)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			return
+		}
+
+		if spentEnergy > 0 {
+			gs.chargeAttempt(r.Context(), attemptID, spentEnergy)
+		}
+
+		entryResponse.EntryToken = entryToken
+		entryResponse.AttemptID = attemptID
 	}
 
 	// send level entry acceptance / rejection in response
@@ -160,82 +586,217 @@ func (gs *Server) HandleEnterLevelRequest(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		errMsg := "error: could not encode the response: " + err.Error()
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 	}
 }
 
-// HandleLevelResultRequest checks the rolls that the player made in a given level,
-// decides if the level was won or lost, and sends back updated player data
-func (gs *Server) HandleLevelResultRequest(w http.ResponseWriter, r *http.Request) {
+// refundEntry gives back the energy spent on an entry which failed before its attempt was opened (if any was spent),
+// errors are logged rather than returned, since the entry has already failed
+func (gs *Server) refundEntry(ctx context.Context, playerID string, spentEnergy int32) {
 
-	if gs == nil {
-		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+	if spentEnergy <= 0 {
 		return
 	}
 
-	err := gs.requestValidator.ValidateRequest(r)
+	_, err := gs.profileClient.RefundEnergy(ctx, &profile.EnergyRefund{PlayerID: playerID, Energy: spentEnergy, Actor: audit.ActorSystem})
 	if err != nil {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
-		errMsg := "error: session validation error: " + err.Error()
-		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusUnauthorized)
+		gs.logger.Printf("error: could not refund the energy of the failed entry of player id %v: %v", playerID, err)
+	}
+}
+
+// writeEntryLimitResponse responds to an entry request rejected by one of the level's entry limits
+func (gs *Server) writeEntryLimitResponse(w http.ResponseWriter, r *http.Request, limitErr data.EntryLimitErr) {
+
+	code := apierror.CodeLevelCooldown
+	if limitErr.Limit == data.EntryLimitDaily {
+		code = apierror.CodeDailyAttemptLimit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	err := json.NewEncoder(w).Encode(&EntryLimitResponse{
+		Code:      code,
+		Error:     apierror.Message(r, code, "{resetTime}", strconv.FormatInt(limitErr.ResetTime, 10)),
+		Limit:     limitErr.Limit,
+		ResetTime: limitErr.ResetTime,
+	})
+	if err != nil {
+		gs.logger.Println("error: could not encode the entry limit response: " + err.Error())
+	}
+}
+
+// writeClockDriftResponse responds to a level result whose client time drifted too far from the server time
+func (gs *Server) writeClockDriftResponse(w http.ResponseWriter, r *http.Request, unixNow int64) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	err := json.NewEncoder(w).Encode(&ClockDriftResponse{
+		Code:       apierror.CodeClientTimeDrift,
+		Error:      apierror.Message(r, apierror.CodeClientTimeDrift, "{maxDrift}", strconv.Itoa(constants.MaxClientTimeDriftSeconds)),
+		ServerTime: unixNow,
+	})
+	if err != nil {
+		gs.logger.Println("error: could not encode the clock drift response: " + err.Error())
+	}
+}
+
+// abs returns the absolute value of the given number
+func abs(value int64) int64 {
+	return max(value, -value)
+}
+
+// HandleLevelResultRequest checks the rolls that the player made in a given level,
+// decides if the level was won or lost, and sends back updated player data
+func (gs *Server) HandleLevelResultRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
 	// decode the request
 	request := &LevelResultRequestBody{}
-	err = json.NewDecoder(r.Body).Decode(request)
+	err := json.NewDecoder(r.Body).Decode(request)
 	if err != nil {
 		errMsg := "error: could not decode the level result request: " + err.Error()
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &request.PlayerID) {
 		return
 	}
 	gs.logger.Printf("request for level results for level %v by player id %v", request.Level, request.PlayerID)
 
-	// get the config and player, do basic validation there
-	cfg := config.Config
+	// results coming too fast are turned away before any other work (dry runs count too)
+	if throttledErr := gs.throttleAction(r.Context(), request.PlayerID, ThrottleActionResult, config.Config.Throttles.Result); throttledErr != nil {
+		gs.writeThrottledResponse(w, r, throttledErr)
+		return
+	}
+
+	// a client whose clock drifted too far would show the wrong energy timers (and could be tampering with it)
+	if unixNow := gs.clock.Now().UTC().Unix(); request.ClientTime != 0 && abs(request.ClientTime-unixNow) > constants.MaxClientTimeDriftSeconds {
+		gs.logger.Printf("error: client time %v of player id %v drifted too far from the server time %v", request.ClientTime, request.PlayerID, unixNow)
+		gs.writeClockDriftResponse(w, r, unixNow)
+		return
+	}
+
+	// get the player and the level config, do basic validation there
 
 	// make a request to the profile service for the player data
-	player, err := gs.getPlayerFromProfile(request.PlayerID, r.Header.Get("Session-Id"))
+	player, err := gs.profileClient.GetPlayer(r.Context(), request.PlayerID)
 	if err != nil {
 		errMsg := "get player error: " + err.Error()
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
-	if request.Level <= 0 || request.Level > int32(len(cfg.Levels)) || request.Level > player.Level {
+	levelConfig, ok := config.Config.Level(request.Level)
+	if !ok || request.Level > player.Level {
 		errMsg := "error: invalid level in request"
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
 		return
 	}
 
-	// check rolls against level requirement, decide win/loss and if new level was unlocked
-	levelConfig := cfg.Levels[request.Level-1]
+	// check rolls against level requirement (allowing for the extra rolls the dynamic difficulty can give),
+	// and every roll has to be possible with the dice of the level
 	rollCount := int32(len(request.Rolls))
-	levelCount := int32(len(cfg.Levels))
 
-	if request.Rolls == nil || rollCount > levelConfig.TotalRolls {
+	if request.Rolls == nil || rollCount == 0 || rollCount > levelConfig.TotalRolls+gs.maxExtraRolls() {
 		errMsg := "error: invalid rolls data in request"
 		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
 		return
 	}
 
-	won := request.Rolls[rollCount-1] == levelConfig.Target
-	newLevelUnlocked := won && request.Level == player.Level && request.Level < levelCount
+	for _, roll := range request.Rolls {
+		if !levelConfig.IsValidRoll(roll) {
+			if !request.DryRun {
+				gs.flagForReview(request.PlayerID, request.AttemptID, ReviewReasonImpossibleRoll, fmt.Sprintf("roll %v at level %v", roll, request.Level), gs.clock.Now().UTC().Unix())
+			}
 
-	// update player data based on win / loss, and if new level was unlocked
-	energyDelta := int32(0)
-	if won {
-		energyDelta = levelConfig.EnergyReward
+			errMsg := fmt.Sprintf("error: invalid roll value in request: %v", roll)
+			gs.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+			return
+		}
+	}
+
+	// the result can only be submitted for an attempt the player actually entered (once per attempt, a retry of
+	// the same result gets the response the result already got), dry runs are evaluated as normal attempts
+	// (of the level as configured), and leave the attempt open
+	mode := EntryModeNormal
+	if !request.DryRun {
+		claims, tokenErr := gs.verifyEntryToken(request.EntryToken, request.PlayerID, request.Level, request.AttemptID)
+		if tokenErr != nil {
+			errMsg := "error: entry token verification failed: " + tokenErr.Error()
+			gs.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden)
+			return
+		}
+
+		previousResponse, claimErr := gs.claimAttempt(claims, request.Rolls, gs.clock.Now().UTC().Unix())
+		if claimErr != nil {
+			errMsg := "error: attempt " + request.AttemptID + " cannot take the result: " + claimErr.Error()
+			gs.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict)
+			return
+		}
+
+		if previousResponse != nil {
+			gs.logger.Printf("result of attempt %v by player id %v was retried, sending the same response", request.AttemptID, request.PlayerID)
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(middleware.ShapeResponse(r, previousResponse))
+			if err != nil {
+				errMsg := "error: could not encode the response: " + err.Error()
+				gs.logger.Println(errMsg)
+				apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			}
+			return
+		}
+
+		// the attempt is played with the difficulty adjustment it was entered with (if any)
+		mode = claims.Mode
+		levelConfig = claims.Difficulty.apply(levelConfig)
+	}
+
+	// practice attempts are only played for the win / loss, without rewards, unlocks or stats
+	practice := mode == EntryModePractice
+
+	// check rolls against the (adjusted) level requirement, decide win/loss, if new level was unlocked, and the energy
+	// reward (with the reward multiplier of the player's prestige rank, the energy tuning of their segment,
+	// and the bonuses of the win), a win builds on the combo of the player, and a loss resets it
+	outcome, err := rules.Evaluate(&rules.Attempt{
+		Level:      levelConfig.Rules(),
+		LevelCount: config.Config.LevelCount(),
+		Player:     rules.Player{Level: player.Level, ComboMultiplier: player.ComboMultiplier},
+		Rolls:      request.Rolls,
+		Practice:   practice,
+		RewardMultipliers: []float64{
+			config.Config.Prestige.RewardMultiplier(player.PrestigeRank),
+			config.Config.Segments.EnergyRewardMultiplier(gs.tunedSegment(r.Context(), player)),
+		},
+		Bonuses: config.Config.Bonuses.Rules(),
+	})
+	if err != nil {
+		errMsg := "error: invalid rolls data in request: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
 	}
 
-	newPlayerLevel := player.Level
-	if newLevelUnlocked {
-		newPlayerLevel += 1
+	won, newLevelUnlocked, energyDelta := outcome.Won, outcome.UnlockedNewLevel, outcome.EnergyReward
+	if !request.DryRun {
+		gs.checkLevelResult(request.PlayerID, request.AttemptID, request.Level, levelConfig.WinProbability(), won, gs.clock.Now().UTC().Unix())
+	}
+
+	// the first win of a referred player rewards them and their referrer
+	if won && !practice && !request.DryRun && request.Level == config.Config.DefaultLevel {
+		gs.completeReferral(r.Context(), request.PlayerID)
 	}
 
 	// create a new level result to send in the response
@@ -243,16 +804,35 @@ func (gs *Server) HandleLevelResultRequest(w http.ResponseWriter, r *http.Reques
 		Won:              won,
 		EnergyReward:     energyDelta,
 		UnlockedNewLevel: newLevelUnlocked,
-	}
-
-	// update the player data to send back in the response
-	// make a request to the profile service to update the player data
-	updatedPlayer, err := gs.updatePlayerData(request.PlayerID, energyDelta, newPlayerLevel)
-	if err != nil {
-		errMsg := "update player error: " + err.Error()
-		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
-		return
+		Practice:         practice,
+		DryRun:           request.DryRun,
+		Bonuses:          outcome.Bonuses,
+	}
+
+	// update the player data to send back in the response (practice leaves it as it is, and a dry run
+	// only previews the update), otherwise make a request to the profile service to update the player data
+	updatedPlayer := player
+	switch {
+	case practice:
+	case request.DryRun:
+		updatedPlayer = previewPlayer(player, energyDelta, outcome.NewLevel)
+		updatedPlayer.ComboMultiplier = outcome.ComboMultiplier
+	default:
+		updatedPlayer, err = gs.profileClient.ApplyLevelResult(r.Context(), &profile.LevelResultUpdate{
+			PlayerID:        request.PlayerID,
+			Level:           outcome.NewLevel,
+			EnergyDelta:     energyDelta,
+			ComboMultiplier: outcome.ComboMultiplier,
+		})
+		if err != nil {
+			// nothing has been applied, so the result can be sent again for the attempt
+			gs.releaseAttempt(request.AttemptID)
+
+			errMsg := "update player error: " + err.Error()
+			gs.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			return
+		}
 	}
 
 	// update stats entry for this level (update win count, loss count, best score if better)
@@ -265,166 +845,127 @@ func (gs *Server) HandleLevelResultRequest(w http.ResponseWriter, r *http.Reques
 
 	if won {
 		newStatsDelta.WinCount = 1
-		newStatsDelta.BestScore = rollCount
+		newStatsDelta.BestScore = outcome.Score
 	} else {
 		newStatsDelta.LossCount = 1
 	}
 
-	// make a request to the stats server to update the player stats
-	updatedStats, err := gs.returnUpdatedPlayerStats(request.PlayerID, newStatsDelta)
-	if err != nil {
-		errMsg := "update stats error: " + err.Error()
-		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
-		return
-	}
-
 	// create the response
 	response := &LevelResultResponse{
 		LevelResult: *levelResult,
 		Player:      *updatedPlayer,
-		Stats:       *updatedStats,
-	}
 
-	// send the response back
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(response)
-	if err != nil {
-		errMsg := "error: could not encode the response: " + err.Error()
-		gs.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		level: request.Level,
 	}
-}
 
-// getPlayerFromProfile makes an internal (server to server) request to the profile service to get the required player data
-func (gs *Server) getPlayerFromProfile(playerID string, sessionID string) (*data.PlayerData, error) {
-
-	// create a new context
-	ctx, cancel := context.WithTimeout(context.TODO(), constants.InternalRequestDeadlineSeconds*time.Second)
-	defer cancel()
-
-	// create the request
-	reqURL := fmt.Sprintf("%v://%v:%v/profile/player-data/%v", constants.CommonProtocol, constants.CommonHost, constants.ProfileServerPort, playerID)
-	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
-	if err != nil {
-		return nil, err
+	// practice results are not recorded in the stats, dry runs only preview the update, for the others,
+	// queue the stats update if async stats are enabled, otherwise
+	// make a request to the stats server to update the player stats
+	// (if that fails, the update waits in the outbox if it is enabled, and the stats are marked as pending)
+	switch {
+	case practice:
+	case request.DryRun:
+		previewedUpdate, statsErr := gs.previewStats(r.Context(), request.PlayerID, newStatsDelta)
+		if statsErr != nil {
+			errMsg := "read stats error: " + statsErr.Error()
+			gs.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			return
+		}
+		response.Stats = previewedUpdate.Stats
+		response.StatsChange = &previewedUpdate.Change
+	case gs.enqueueStatsUpdate(request.PlayerID, newStatsDelta):
+		response.StatsPending = true
+	default:
+		statsUpdate, statsErr := gs.statsClient.UpdatePlayerStats(r.Context(), request.PlayerID, newStatsDelta)
+		switch {
+		case statsErr == nil:
+			response.Stats = statsUpdate.Stats
+			response.StatsChange = &statsUpdate.Change
+		case gs.addToOutbox(r.Context(), request.PlayerID, newStatsDelta, statsErr):
+			response.StatsPending = true
+		default:
+			errMsg := "update stats error: " + statsErr.Error()
+			gs.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			return
+		}
 	}
-	req.Header.Set("Session-Id", sessionID)
 
-	// send the request
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	// keep the response for the retries of the same result
+	if !request.DryRun {
+		gs.completeAttempt(request.AttemptID, response)
 	}
-	defer resp.Body.Close()
 
-	// check response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("internal get player data request was not successful, status code %v", resp.StatusCode)
+	// let the webhooks know about the win, once the result has been applied
+	if won && !practice && !request.DryRun {
+		gs.publishEvent(r.Context(), webhooks.EventLevelWon, request.PlayerID, &webhooks.LevelWonData{Level: request.Level, Rolls: rollCount, UnlockedNewLevel: newLevelUnlocked})
 	}
 
-	//decode the response for the player data
-	playerData := &data.PlayerData{}
-	err = json.NewDecoder(resp.Body).Decode(playerData)
+	// send the response back (in the shape of the requested api version)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(middleware.ShapeResponse(r, response))
 	if err != nil {
-		return nil, err
+		errMsg := "error: could not encode the response: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 	}
-
-	return playerData, nil
 }
 
-// updatePlayerData makes an internal (server to server) request to the profile service to update the required player data
-func (gs *Server) updatePlayerData(playerID string, energyDelta int32, newLevel int32) (*data.PlayerData, error) {
+// previewPlayer returns a copy of the given player with the given energy delta and new level applied (like the profile
+// service would, the energy is already regenerated when the player is read), without updating the player
+func previewPlayer(player *data.PlayerData, energyDelta int32, newLevel int32) *data.PlayerData {
 
-	// create a new context
-	ctx, cancel := context.WithTimeout(context.TODO(), constants.InternalRequestDeadlineSeconds*time.Second)
-	defer cancel()
+	preview := player.Clone()
 
-	// create the request body
-	reqBody := &bytes.Buffer{}
-	err := json.NewEncoder(reqBody).Encode(&profile.PlayerIDLevelEnergy{
-		PlayerID:    playerID,
-		Level:       newLevel,
-		EnergyDelta: energyDelta,
-	})
-	if err != nil {
-		return nil, err
+	if energyDelta != 0 {
+		preview.BankedEnergy = profile.BankedEnergy(player, energyDelta, config.Config.MaxEnergy, config.Config.EnergyBankCap)
+		preview.Energy = min(preview.Energy+energyDelta, config.Config.MaxEnergy)
 	}
 
-	// create the request
-	reqURL := fmt.Sprintf("%v://%v:%v/profile/player-data-internal", constants.CommonProtocol, constants.CommonHost, constants.ProfileServerPort)
-	req, err := http.NewRequestWithContext(ctx, "PUT", reqURL, reqBody)
-	if err != nil {
-		return nil, err
+	if preview.Level < newLevel {
+		preview.Level = min(newLevel, config.Config.LevelCount())
 	}
 
-	// send the request
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	return &preview
+}
 
-	// check response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("internal update player request was not successful, status code %v", resp.StatusCode)
-	}
+// previewStats returns the stats the player would have with the given level stats delta applied (and how it changes them),
+// without recording the attempt or updating the stats (a player with no stats yet starts with empty ones)
+func (gs *Server) previewStats(ctx context.Context, playerID string, newStatsDelta *data.PlayerLevelStats) (*stats.StatsUpdate, error) {
 
-	//decode the response for the player data
-	playerData := &data.PlayerData{}
-	err = json.NewDecoder(resp.Body).Decode(playerData)
+	playerStats, err := gs.dataClient.ReadStats(ctx, playerID)
 	if err != nil {
-		return nil, err
+		if !errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: playerID}) {
+			return nil, err
+		}
+		playerStats = &data.PlayerStats{LevelStats: []data.PlayerLevelStats{}, Version: data.PlayerStatsVersion}
 	}
 
-	return playerData, nil
+	change := stats.ApplyLevelStatsUpdate(playerStats, newStatsDelta)
+	return &stats.StatsUpdate{Stats: *playerStats, Change: change}, nil
 }
 
-// returnUpdatedPlayerStats makes an internal (server to server) request to the stats service to update the required player stats
-func (gs *Server) returnUpdatedPlayerStats(playerID string, newStatsDelta *data.PlayerLevelStats) (*data.PlayerStats, error) {
+// skipLevel uses up one of the player's skip tickets to unlock the level after their current highest level,
+// and returns the updated player data (the ticket is given back if the level could not be unlocked)
+func (gs *Server) skipLevel(ctx context.Context, player *data.PlayerData) (*data.PlayerData, error) {
 
-	// create a new context
-	ctx, cancel := context.WithTimeout(context.TODO(), constants.InternalRequestDeadlineSeconds*time.Second)
-	defer cancel()
+	ticket := &data.ItemGrant{PlayerID: player.PlayerID, ItemID: config.SkipTicketItemID, Count: 1}
 
-	// create the request body
-	reqBody := &bytes.Buffer{}
-	err := json.NewEncoder(reqBody).Encode(&stats.PlayerIDLevelStats{
-		PlayerID:        playerID,
-		LevelStatsDelta: *newStatsDelta,
-	})
+	_, err := gs.dataClient.ConsumeItem(ctx, ticket)
 	if err != nil {
 		return nil, err
 	}
 
-	// create the request
-	reqURL := fmt.Sprintf("%v://%v:%v/stats/player-stats-internal", constants.CommonProtocol, constants.CommonHost, constants.StatsServerPort)
-	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, reqBody)
-	if err != nil {
-		return nil, err
-	}
-
-	// send the request
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// check response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("internal update player request was not successful, status code %v", resp.StatusCode)
-	}
-
-	//decode the response for the player stats
-	playerStats := &data.PlayerStats{}
-	err = json.NewDecoder(resp.Body).Decode(playerStats)
+	updatedPlayer, err := gs.profileClient.UpdatePlayerData(playerctx.WithPlayerID(ctx, player.PlayerID), 0, player.Level+1)
 	if err != nil {
+		_, refundErr := gs.dataClient.GrantItem(ctx, ticket)
+		if refundErr != nil {
+			gs.logger.Printf("error: could not give the skip ticket back to player id %v: %v", player.PlayerID, refundErr)
+		}
 		return nil, err
 	}
 
-	return playerStats, nil
+	gs.logger.Printf("player id %v used a skip ticket on level %v", player.PlayerID, player.Level)
+	return updatedPlayer, nil
 }
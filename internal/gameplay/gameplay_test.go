@@ -2,37 +2,41 @@ package gameplay
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"example.com/dice-game-backend/internal/auth"
 	"example.com/dice-game-backend/internal/config"
 	"example.com/dice-game-backend/internal/data"
 	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/clock"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
 	"example.com/dice-game-backend/internal/shared/testsetup"
 	"example.com/dice-game-backend/internal/stats"
+	"example.com/dice-game-backend/pkg/rules"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var authServer *auth.Server
 var profileServer *profile.Server
+var statsServer *stats.Server
+var dataServer *data.Server
 
 func TestMain(m *testing.M) {
 
-	authServer = auth.NewServer()
-	go authServer.Run(constants.AuthServerPort)
-
-	dataServer := data.NewServer()
-	go dataServer.Run(constants.DataServerPort)
-
-	profileServer = profile.NewServer(authServer)
-	go profileServer.Run(constants.ProfileServerPort)
-
-	statsServer := stats.NewServer(authServer)
-	go statsServer.Run(constants.StatsServerPort)
+	// all the servers used by the gameplay server are wired in-process
+	authServer = auth.NewServer(data.NewServer())
+	dataServer = data.NewServer()
+	profileServer = profile.NewServer(authServer, dataServer)
+	statsServer = stats.NewServer(authServer, dataServer)
 
 	code := m.Run()
 
@@ -41,9 +45,9 @@ func TestMain(m *testing.M) {
 
 func TestNewGameplayServer(t *testing.T) {
 
-	as := auth.NewServer()
+	as := auth.NewServer(data.NewServer())
 
-	gs := NewServer(as)
+	gs := NewServer(as, profileServer, statsServer, dataServer)
 
 	if gs == nil {
 		t.Fatal("new profile server should not return a nil server pointer")
@@ -57,13 +61,16 @@ func TestServer_HandleEnterLevelRequest(t *testing.T) {
 		t.Fatal("auth setup error: " + err.Error())
 	}
 
-	newPlayerData, err := setupTestProfile("player2", sID, profileServer)
+	newPlayerData, err := testsetup.SetupTestProfile("player2", sID, profileServer.HandleNewPlayerRequest)
 	if err != nil {
 		t.Fatal("profile setup error: " + err.Error())
 	}
 	energyCost := config.Config.Levels[0].EnergyCost
 
-	gs := NewServer(authServer)
+	gs := NewServer(authServer, profileServer, statsServer, dataServer)
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player2", "session1": "player1"}
 
 	tests := []struct {
 		name             string
@@ -77,17 +84,21 @@ func TestServer_HandleEnterLevelRequest(t *testing.T) {
 		{"nil server", nil, "", nil, http.StatusInternalServerError, "", nil},
 		{"blank session id", gs, "", nil, http.StatusUnauthorized, "application/json", nil},
 		{"invalid session id", gs, "testSessionID", nil, http.StatusUnauthorized, "application/json", nil},
-		{"invalid player", gs, sID, &EnterLevelRequestBody{"player1", 1}, http.StatusInternalServerError, "application/json", nil},
-		{"invalid level 0", gs, sID, &EnterLevelRequestBody{"player2", 0}, http.StatusBadRequest, "application/json", nil},
-		{"invalid level 50", gs, sID, &EnterLevelRequestBody{"player2", 50}, http.StatusBadRequest, "application/json", nil},
-		{"locked level", gs, sID, &EnterLevelRequestBody{"player2", 5}, http.StatusOK, "application/json", &EnterLevelResponse{false, *newPlayerData}},
-		{name: "valid level", server: gs, sessionID: sID, requestBody: &EnterLevelRequestBody{"player2", 1}, wantStatus: http.StatusOK, wantContentType: "application/json", wantResponseBody: &EnterLevelResponse{
+		{"another player", gs, sID, &EnterLevelRequestBody{PlayerID: "player1", Level: 1}, http.StatusForbidden, "application/json", nil},
+		{"invalid player", gs, "session1", &EnterLevelRequestBody{PlayerID: "player1", Level: 1}, http.StatusInternalServerError, "application/json", nil},
+		{"invalid level 0", gs, sID, &EnterLevelRequestBody{PlayerID: "player2", Level: 0}, http.StatusBadRequest, "application/json", nil},
+		{"invalid level 50", gs, sID, &EnterLevelRequestBody{PlayerID: "player2", Level: 50}, http.StatusBadRequest, "application/json", nil},
+		{"locked level", gs, sID, &EnterLevelRequestBody{PlayerID: "player2", Level: 5}, http.StatusOK, "application/json", &EnterLevelResponse{AccessGranted: false, Player: *newPlayerData}},
+		{name: "valid level", server: gs, sessionID: sID, requestBody: &EnterLevelRequestBody{PlayerID: "player2", Level: 1}, wantStatus: http.StatusOK, wantContentType: "application/json", wantResponseBody: &EnterLevelResponse{
 			AccessGranted: true,
 			Player: data.PlayerData{
 				PlayerID:       newPlayerData.PlayerID,
 				Level:          newPlayerData.Level,
 				Energy:         newPlayerData.Energy - energyCost,
 				LastUpdateTime: newPlayerData.LastUpdateTime,
+				CreatedTime:    newPlayerData.CreatedTime,
+				Segment:        newPlayerData.Segment,
+				Version:        newPlayerData.Version,
 			}}},
 	}
 
@@ -105,7 +116,7 @@ func TestServer_HandleEnterLevelRequest(t *testing.T) {
 			respRec := httptest.NewRecorder()
 
 			gameplayServer := test.server
-			gameplayServer.HandleEnterLevelRequest(respRec, newReq)
+			testsetup.WithSession(gameplayServer, sessions, gameplayServer.HandleEnterLevelRequest)(respRec, newReq)
 
 			gotStatus := respRec.Result().StatusCode
 
@@ -126,6 +137,16 @@ func TestServer_HandleEnterLevelRequest(t *testing.T) {
 					t.Fatal("could not decode the response body")
 				}
 
+				// the entry token is random, so just check that it is valid for the level
+				if gotResponseBody.AccessGranted {
+					_, err = gameplayServer.verifyEntryToken(gotResponseBody.EntryToken, test.requestBody.PlayerID, test.requestBody.Level, gotResponseBody.AttemptID)
+					if err != nil {
+						t.Errorf("handler gave an invalid entry token: %v", err)
+					}
+					gotResponseBody.EntryToken = ""
+					gotResponseBody.AttemptID = ""
+				}
+
 				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
 					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
 				}
@@ -134,6 +155,63 @@ func TestServer_HandleEnterLevelRequest(t *testing.T) {
 	}
 }
 
+// staleProfileClient reads the player as having full energy (as if another entry spent it right after the read)
+type staleProfileClient struct {
+	*profile.Server
+}
+
+func (spc *staleProfileClient) GetPlayer(ctx context.Context, playerID string) (*data.PlayerData, error) {
+	player, err := spc.Server.GetPlayer(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+	player.Energy = config.Config.MaxEnergy
+	return player, nil
+}
+
+func TestServer_HandleEnterLevelRequest_EnergySpentConcurrently(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	// the energy is actually gone by the time the entry spends it
+	_, err = ps.SpendEnergy(context.Background(), "player1", config.Config.MaxEnergy)
+	if err != nil {
+		t.Fatal("spend energy error: " + err.Error())
+	}
+
+	gs := NewServer(as, &staleProfileClient{ps}, stats.NewServer(as, ds), ds)
+
+	buf := &bytes.Buffer{}
+	err = json.NewEncoder(buf).Encode(&EnterLevelRequestBody{PlayerID: "player1", Level: 1})
+	if err != nil {
+		t.Fatal("could not encode the request body: " + err.Error())
+	}
+
+	newReq := httptest.NewRequest(http.MethodPost, "/gameplay/entry/", buf)
+	newReq.Header.Set("Session-Id", sID)
+	respRec := httptest.NewRecorder()
+	testsetup.WithSession(gs, sessions, gs.HandleEnterLevelRequest)(respRec, newReq)
+
+	gotStatus := respRec.Result().StatusCode
+	if gotStatus != http.StatusConflict {
+		t.Errorf("handler gave incorrect results, want: %v, got: %v", http.StatusConflict, gotStatus)
+	}
+}
+
 func TestServer_HandleLevelResultRequest(t *testing.T) {
 
 	sID, err := testsetup.SetupTestAuthWithInput(authServer, "user2", "pass2")
@@ -141,14 +219,37 @@ func TestServer_HandleLevelResultRequest(t *testing.T) {
 		t.Fatal("auth setup error: " + err.Error())
 	}
 
-	newPlayer3, err := setupTestProfile("player3", sID, profileServer)
+	newPlayer3, err := testsetup.SetupTestProfile("player3", sID, profileServer.HandleNewPlayerRequest)
 	if err != nil {
 		t.Fatal("profile setup error: " + err.Error())
 	}
 
 	energyReward := config.Config.Levels[0].EnergyReward
 
-	gs := NewServer(authServer)
+	gs := NewServer(authServer, profileServer, statsServer, dataServer)
+
+	lossToken, lossAttempt, err := gs.issueEntryToken("player3", 1, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	winToken, winAttempt, err := gs.issueEntryToken("player3", 1, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	otherLevelToken, otherLevelAttempt, err := gs.issueEntryToken("player3", 2, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	otherServerToken, otherServerAttempt, err := NewServer(authServer, profileServer, statsServer, dataServer).issueEntryToken("player3", 1, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player3", "session1": "player1"}
 
 	tests := []struct {
 		name             string
@@ -162,22 +263,42 @@ func TestServer_HandleLevelResultRequest(t *testing.T) {
 		{"nil server", nil, "", nil, http.StatusInternalServerError, "", nil},
 		{"blank session id", gs, "", nil, http.StatusUnauthorized, "application/json", nil},
 		{"invalid session id", gs, "testSessionID", nil, http.StatusUnauthorized, "application/json", nil},
-		{"invalid player", gs, sID, &LevelResultRequestBody{"player1", 1, nil}, http.StatusInternalServerError, "application/json", nil},
-		{"invalid level 0", gs, sID, &LevelResultRequestBody{"player3", 0, nil}, http.StatusBadRequest, "application/json", nil},
-		{"invalid level 50", gs, sID, &LevelResultRequestBody{"player3", 50, nil}, http.StatusBadRequest, "application/json", nil},
-		{"locked level", gs, sID, &LevelResultRequestBody{"player3", 5, nil}, http.StatusBadRequest, "application/json", &LevelResultResponse{}},
-		{"nil rolls", gs, sID, &LevelResultRequestBody{"player3", 5, nil}, http.StatusBadRequest, "application/json", &LevelResultResponse{}},
-		{"invalid rolls", gs, sID, &LevelResultRequestBody{"player3", 1, []int32{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}}, http.StatusBadRequest, "application/json", &LevelResultResponse{}},
-
-		{name: "level loss", server: gs, sessionID: sID, requestBody: &LevelResultRequestBody{"player3", 1, []int32{1, 1}}, wantStatus: http.StatusOK, wantContentType: "application/json", wantResponseBody: &LevelResultResponse{
-			LevelResult: LevelResult{false, 0, false},
+		{"another player", gs, sID, &LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: nil}, http.StatusForbidden, "application/json", nil},
+		{"invalid player", gs, "session1", &LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: nil}, http.StatusInternalServerError, "application/json", nil},
+		{"invalid level 0", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 0, Rolls: nil}, http.StatusBadRequest, "application/json", nil},
+		{"invalid level 50", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 50, Rolls: nil}, http.StatusBadRequest, "application/json", nil},
+		{"locked level", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 5, Rolls: nil}, http.StatusBadRequest, "application/json", &LevelResultResponse{}},
+		{"nil rolls", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 5, Rolls: nil}, http.StatusBadRequest, "application/json", &LevelResultResponse{}},
+		{"invalid rolls", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}}, http.StatusBadRequest, "application/json", &LevelResultResponse{}},
+		{"empty rolls", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{}}, http.StatusBadRequest, "application/json", &LevelResultResponse{}},
+		{"invalid roll value", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{7, 6}}, http.StatusBadRequest, "application/json", &LevelResultResponse{}},
+
+		{"missing entry token", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1}}, http.StatusForbidden, "application/json", &LevelResultResponse{}},
+		{"malformed entry token", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1}, EntryToken: "testToken"}, http.StatusForbidden, "application/json", &LevelResultResponse{}},
+		{"entry token for other level", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1}, EntryToken: otherLevelToken, AttemptID: otherLevelAttempt}, http.StatusForbidden, "application/json", &LevelResultResponse{}},
+		{"entry token from other server", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1}, EntryToken: otherServerToken, AttemptID: otherServerAttempt}, http.StatusForbidden, "application/json", &LevelResultResponse{}},
+
+		{"missing attempt id", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1}, EntryToken: lossToken}, http.StatusForbidden, "application/json", &LevelResultResponse{}},
+		{"attempt id of other entry token", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1}, EntryToken: lossToken, AttemptID: winAttempt}, http.StatusForbidden, "application/json", &LevelResultResponse{}},
+
+		{name: "level loss", server: gs, sessionID: sID, requestBody: &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1}, EntryToken: lossToken, AttemptID: lossAttempt}, wantStatus: http.StatusOK, wantContentType: "application/json", wantResponseBody: &LevelResultResponse{
+			LevelResult: LevelResult{Won: false, EnergyReward: 0, UnlockedNewLevel: false},
+			Player:      *newPlayer3,
+			Stats:       data.PlayerStats{LevelStats: []data.PlayerLevelStats{{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99}}, Version: data.PlayerStatsVersion},
+			StatsChange: &stats.LevelStatsChange{Level: 1, LossCountDelta: 1, PreviousBestScore: 99, BestScore: 99},
+		}},
+		{name: "retried level loss", server: gs, sessionID: sID, requestBody: &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 1}, EntryToken: lossToken, AttemptID: lossAttempt}, wantStatus: http.StatusOK, wantContentType: "application/json", wantResponseBody: &LevelResultResponse{
+			LevelResult: LevelResult{Won: false, EnergyReward: 0, UnlockedNewLevel: false},
 			Player:      *newPlayer3,
-			Stats:       data.PlayerStats{LevelStats: []data.PlayerLevelStats{{1, 0, 1, 99}}},
+			Stats:       data.PlayerStats{LevelStats: []data.PlayerLevelStats{{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99}}, Version: data.PlayerStatsVersion},
+			StatsChange: &stats.LevelStatsChange{Level: 1, LossCountDelta: 1, PreviousBestScore: 99, BestScore: 99},
 		}},
-		{name: "level win", server: gs, sessionID: sID, requestBody: &LevelResultRequestBody{"player3", 1, []int32{1, 6}}, wantStatus: http.StatusOK, wantContentType: "application/json", wantResponseBody: &LevelResultResponse{
-			LevelResult: LevelResult{true, energyReward, true},
-			Player:      data.PlayerData{PlayerID: newPlayer3.PlayerID, Level: newPlayer3.Level + 1, Energy: 50, LastUpdateTime: newPlayer3.LastUpdateTime},
-			Stats:       data.PlayerStats{LevelStats: []data.PlayerLevelStats{{1, 1, 1, 2}}},
+		{"other result for the same attempt", gs, sID, &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 6}, EntryToken: lossToken, AttemptID: lossAttempt}, http.StatusConflict, "application/json", &LevelResultResponse{}},
+		{name: "level win", server: gs, sessionID: sID, requestBody: &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{1, 6}, EntryToken: winToken, AttemptID: winAttempt}, wantStatus: http.StatusOK, wantContentType: "application/json", wantResponseBody: &LevelResultResponse{
+			LevelResult: LevelResult{Won: true, EnergyReward: energyReward, UnlockedNewLevel: true},
+			Player:      data.PlayerData{PlayerID: newPlayer3.PlayerID, Level: newPlayer3.Level + 1, Energy: 50, LastUpdateTime: newPlayer3.LastUpdateTime, CreatedTime: newPlayer3.CreatedTime, Segment: newPlayer3.Segment, ComboMultiplier: config.Config.Bonuses.NextComboMultiplier(0), Version: newPlayer3.Version},
+			Stats:       data.PlayerStats{LevelStats: []data.PlayerLevelStats{{Level: 1, WinCount: 1, LossCount: 1, BestScore: 2}}, WinStreak: 1, Version: data.PlayerStatsVersion},
+			StatsChange: &stats.LevelStatsChange{Level: 1, WinCountDelta: 1, PreviousBestScore: 99, BestScore: 2, BestScoreImproved: true, WinStreak: 1},
 		}},
 	}
 
@@ -195,7 +316,7 @@ func TestServer_HandleLevelResultRequest(t *testing.T) {
 			respRec := httptest.NewRecorder()
 
 			gameplayServer := test.server
-			gameplayServer.HandleLevelResultRequest(respRec, newReq)
+			testsetup.WithSession(gameplayServer, sessions, gameplayServer.HandleLevelResultRequest)(respRec, newReq)
 
 			gotStatus := respRec.Result().StatusCode
 
@@ -224,25 +345,1978 @@ func TestServer_HandleLevelResultRequest(t *testing.T) {
 	}
 }
 
-func setupTestProfile(playerID string, sessionID string, profileServer *profile.Server) (*data.PlayerData, error) {
+func TestServer_AsyncStats(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	ps := profile.NewServer(as, dataServer)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	gs := NewServer(as, ps, stats.NewServer(as, dataServer), dataServer)
+	gs.EnableAsyncStats(2)
+
+	entryToken, attemptID, err := gs.issueEntryToken("player1", 1, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
 	buf := &bytes.Buffer{}
-	reqBody := &profile.NewPlayerRequestBody{PlayerID: playerID}
-	err := json.NewEncoder(buf).Encode(reqBody)
+	err = json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{1, 6}, EntryToken: entryToken, AttemptID: attemptID})
 	if err != nil {
-		return nil, err
+		t.Fatal("could not encode the request body: " + err.Error())
 	}
 
-	newReq := httptest.NewRequest(http.MethodPost, "/profile/new-player", buf)
-	newReq.Header.Set("Session-Id", sessionID)
+	newReq := httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+	newReq.Header.Set("Session-Id", sID)
 	respRec := httptest.NewRecorder()
+	testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(respRec, newReq)
 
-	profileServer.HandleNewPlayerRequest(respRec, newReq)
+	if respRec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusOK, respRec.Result().StatusCode)
+	}
 
-	newPlayerData := &data.PlayerData{}
-	err = json.NewDecoder(respRec.Result().Body).Decode(newPlayerData)
+	// the stats are left out of the response, and marked as pending
+	gotResponseBody := &LevelResultResponse{}
+	err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
 	if err != nil {
-		return nil, err
+		t.Fatal("could not decode the response body")
+	}
+
+	if !gotResponseBody.StatsPending || gotResponseBody.Stats.LevelStats != nil || !gotResponseBody.LevelResult.Won {
+		t.Errorf("handler gave incorrect results, want won level with pending stats, got: %v", gotResponseBody)
+	}
+
+	// the stats status of another player cannot be read
+	newReq = httptest.NewRequest(http.MethodGet, "/gameplay/stats-status/", nil)
+	newReq.SetPathValue("id", "player2")
+	newReq.Header.Set("Session-Id", sID)
+	respRec = httptest.NewRecorder()
+	testsetup.WithSession(gs, sessions, gs.HandleStatsStatusRequest)(respRec, newReq)
+
+	if respRec.Result().StatusCode != http.StatusForbidden {
+		t.Errorf("handler gave incorrect results, want: %v, got: %v", http.StatusForbidden, respRec.Result().StatusCode)
+	}
+
+	// wait for the workers to send the update to the stats service
+	pending := -1
+	for range 100 {
+		newReq = httptest.NewRequest(http.MethodGet, "/gameplay/stats-status/", nil)
+		newReq.SetPathValue("id", "player1")
+		newReq.Header.Set("Session-Id", sID)
+		respRec = httptest.NewRecorder()
+		testsetup.WithSession(gs, sessions, gs.HandleStatsStatusRequest)(respRec, newReq)
+
+		status := &StatsStatusResponse{}
+		err = json.NewDecoder(respRec.Result().Body).Decode(status)
+		if err != nil {
+			t.Fatal("could not decode the stats status response")
+		}
+
+		pending = status.Pending
+		if pending == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if pending != 0 {
+		t.Fatalf("the stats update should not be pending anymore, pending: %v", pending)
+	}
+
+	gotStats, err := dataServer.ReadStats(context.Background(), "player1")
+	if err != nil {
+		t.Fatal("read stats error: " + err.Error())
+	}
+
+	wantStats := []data.PlayerLevelStats{{Level: 1, WinCount: 1, LossCount: 0, BestScore: 2}}
+	if !reflect.DeepEqual(gotStats.LevelStats, wantStats) {
+		t.Errorf("async stats update gave incorrect results, want: %v, got: %v", wantStats, gotStats.LevelStats)
+	}
+}
+
+// testFlakyStatsClient is a stats client which fails its stats updates while the stats service is down,
+// and passes them on to the stats server otherwise
+type testFlakyStatsClient struct {
+	*stats.Server
+	down atomic.Bool
+}
+
+func (sc *testFlakyStatsClient) ReturnUpdatedPlayerStats(ctx context.Context, newStatsDelta *data.PlayerLevelStats) (*data.PlayerStats, error) {
+	if sc.down.Load() {
+		return nil, fmt.Errorf("stats service unavailable")
+	}
+	return sc.Server.ReturnUpdatedPlayerStats(ctx, newStatsDelta)
+}
+
+func (sc *testFlakyStatsClient) UpdatePlayerStats(ctx context.Context, playerID string, newStatsDelta *data.PlayerLevelStats) (*stats.StatsUpdate, error) {
+	if sc.down.Load() {
+		return nil, fmt.Errorf("stats service unavailable")
+	}
+	return sc.Server.UpdatePlayerStats(ctx, playerID, newStatsDelta)
+}
+
+func TestServer_StatsOutbox(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	sc := &testFlakyStatsClient{Server: stats.NewServer(as, ds)}
+	sc.down.Store(true)
+	outboxDir := t.TempDir()
+
+	sendResult := func(gs *Server) int {
+
+		entryToken, attemptID, tokenErr := gs.issueEntryToken("player1", 1, EntryModeNormal, nil)
+		if tokenErr != nil {
+			t.Fatal("entry token setup error: " + tokenErr.Error())
+		}
+
+		buf := &bytes.Buffer{}
+		encodeErr := json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{1, 6}, EntryToken: entryToken, AttemptID: attemptID})
+		if encodeErr != nil {
+			t.Fatal("could not encode the request body: " + encodeErr.Error())
+		}
+
+		newReq := httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+		newReq.Header.Set("Session-Id", sID)
+		respRec := httptest.NewRecorder()
+		testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(respRec, newReq)
+
+		if respRec.Result().StatusCode == http.StatusOK {
+			gotResponseBody := &LevelResultResponse{}
+			decodeErr := json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+			if decodeErr != nil {
+				t.Fatal("could not decode the response body")
+			}
+			if !gotResponseBody.StatsPending || gotResponseBody.Stats.LevelStats != nil {
+				t.Errorf("handler gave incorrect results, want pending stats, got: %v", gotResponseBody)
+			}
+		}
+
+		return respRec.Result().StatusCode
+	}
+
+	// without the outbox, the level result fails along with the stats update
+	gs := NewServer(as, ps, sc, ds)
+	if gotStatus := sendResult(gs); gotStatus != http.StatusInternalServerError {
+		t.Fatalf("handler gave incorrect results without the outbox, want: %v, got: %v", http.StatusInternalServerError, gotStatus)
+	}
+
+	// with the outbox, the result goes through, and its stats update is kept till it can be replayed
+	err = gs.EnableOutbox(outboxDir)
+	if err != nil {
+		t.Fatal("outbox setup error: " + err.Error())
+	}
+	if gotStatus := sendResult(gs); gotStatus != http.StatusOK {
+		t.Fatalf("handler gave incorrect results with the outbox, want: %v, got: %v", http.StatusOK, gotStatus)
+	}
+
+	now := time.Now().UTC()
+	if replayed := gs.replayOutbox(now.Add(time.Minute)); replayed != 0 {
+		t.Errorf("replayOutbox() should not replay anything while the stats service is down, replayed: %v", replayed)
+	}
+
+	// the update survives a restart (the new server loads it from the outbox directory), and is pending again
+	gs = NewServer(as, ps, sc, ds)
+	err = gs.EnableOutbox(outboxDir)
+	if err != nil {
+		t.Fatal("outbox setup error: " + err.Error())
+	}
+	if gs.OutboxSize() != 1 || gs.pendingStats["player1"] != 1 {
+		t.Fatalf("the outbox was not loaded, size: %v, pending: %v", gs.OutboxSize(), gs.pendingStats["player1"])
+	}
+
+	// once the stats service is back, the update is replayed after its backoff
+	sc.down.Store(false)
+	tests := []struct {
+		name         string
+		at           time.Time
+		wantReplayed int
+		wantPending  int
+	}{
+		{"before the backoff", now.Add(time.Minute), 0, 1},
+		{"after the backoff", now.Add(time.Minute + 2*time.Second), 1, 0},
+		{"nothing left", now.Add(time.Hour), 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotReplayed := gs.replayOutbox(test.at)
+			if gotReplayed != test.wantReplayed {
+				t.Errorf("replayOutbox() gave incorrect results, want: %v, got: %v", test.wantReplayed, gotReplayed)
+			}
+
+			gs.pendingStatsMutex.Lock()
+			gotPending := gs.pendingStats["player1"]
+			gs.pendingStatsMutex.Unlock()
+
+			if gotPending != test.wantPending || gs.OutboxSize() != int64(test.wantPending) {
+				t.Errorf("replayOutbox() left incorrect pending updates, want: %v, got: %v (outbox size: %v)", test.wantPending, gotPending, gs.OutboxSize())
+			}
+		})
+	}
+
+	gotStats, err := ds.ReadStats(context.Background(), "player1")
+	if err != nil {
+		t.Fatal("read stats error: " + err.Error())
+	}
+
+	wantStats := []data.PlayerLevelStats{{Level: 1, WinCount: 1, LossCount: 0, BestScore: 2}}
+	if !reflect.DeepEqual(gotStats.LevelStats, wantStats) {
+		t.Errorf("outbox replay gave incorrect results, want: %v, got: %v", wantStats, gotStats.LevelStats)
+	}
+}
+
+func TestServer_EntryModes(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	newPlayer, err := testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	tests := []struct {
+		name           string
+		requestBody    *EnterLevelRequestBody
+		grantTickets   int32
+		wantStatus     int
+		wantGranted    bool
+		wantSkipped    bool
+		wantEntryToken bool
+		wantLevel      int32
+		wantEnergy     int32
+	}{
+		{"invalid mode", &EnterLevelRequestBody{PlayerID: "player1", Level: 1, Mode: "testMode"}, 0, http.StatusBadRequest, false, false, false, 0, 0},
+		{"practice locked level", &EnterLevelRequestBody{PlayerID: "player1", Level: 2, Mode: EntryModePractice}, 0, http.StatusOK, false, false, false, 1, newPlayer.Energy},
+		{"practice unlocked level", &EnterLevelRequestBody{PlayerID: "player1", Level: 1, Mode: EntryModePractice}, 0, http.StatusOK, true, false, true, 1, newPlayer.Energy},
+		{"skip without ticket", &EnterLevelRequestBody{PlayerID: "player1", Level: 1, Mode: EntryModeSkip}, 0, http.StatusOK, false, false, false, 1, newPlayer.Energy},
+		{"skip locked level", &EnterLevelRequestBody{PlayerID: "player1", Level: 2, Mode: EntryModeSkip}, 1, http.StatusOK, false, false, false, 1, newPlayer.Energy},
+		{"skip highest level", &EnterLevelRequestBody{PlayerID: "player1", Level: 1, Mode: EntryModeSkip}, 0, http.StatusOK, true, true, false, 2, newPlayer.Energy},
+		{"skip with used ticket", &EnterLevelRequestBody{PlayerID: "player1", Level: 2, Mode: EntryModeSkip}, 0, http.StatusOK, false, false, false, 2, newPlayer.Energy},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if test.grantTickets > 0 {
+				_, err = ds.GrantItem(context.Background(), &data.ItemGrant{PlayerID: "player1", ItemID: config.SkipTicketItemID, Count: test.grantTickets})
+				if err != nil {
+					t.Fatal("grant item error: " + err.Error())
+				}
+			}
+
+			buf := &bytes.Buffer{}
+			err = json.NewEncoder(buf).Encode(test.requestBody)
+			if err != nil {
+				t.Fatal("could not encode the request body: " + err.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/entry/", buf)
+			newReq.Header.Set("Session-Id", sID)
+			respRec := httptest.NewRecorder()
+			testsetup.WithSession(gs, sessions, gs.HandleEnterLevelRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus != http.StatusOK {
+				return
+			}
+
+			gotResponseBody := &EnterLevelResponse{}
+			err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			if gotResponseBody.AccessGranted != test.wantGranted || gotResponseBody.LevelSkipped != test.wantSkipped || (gotResponseBody.EntryToken != "") != test.wantEntryToken {
+				t.Errorf("handler gave incorrect results, want: granted %v, skipped %v, entry token %v, got: %v", test.wantGranted, test.wantSkipped, test.wantEntryToken, gotResponseBody)
+			}
+
+			if gotResponseBody.Player.Level != test.wantLevel || gotResponseBody.Player.Energy != test.wantEnergy {
+				t.Errorf("handler gave incorrect results, want: level %v, energy %v, got: level %v, energy %v", test.wantLevel, test.wantEnergy, gotResponseBody.Player.Level, gotResponseBody.Player.Energy)
+			}
+		})
+	}
+
+	inventory, err := ds.ReadInventory(context.Background(), "player1")
+	if err != nil {
+		t.Fatal("read inventory error: " + err.Error())
+	}
+
+	if len(inventory.Items) != 0 {
+		t.Errorf("the skip ticket should have been used up, inventory: %v", inventory.Items)
+	}
+}
+
+func TestServer_EntryLimits(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	// the first level gets a cooldown and a daily limit for this test
+	levelConfig := config.Config.Levels[0]
+	t.Cleanup(func() { config.Config.Levels[0] = levelConfig })
+
+	tests := []struct {
+		name            string
+		playerID        string
+		cooldownSeconds int64
+		maxPerDay       int32
+		entries         int
+		wantLimit       string
+	}{
+		{"within the daily limit", "player1", 0, 2, 2, ""},
+		{"over the daily limit", "player2", 0, 2, 3, data.EntryLimitDaily},
+		{"within the cooldown", "player3", 3600, 0, 2, data.EntryLimitCooldown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			_, err = testsetup.SetupTestProfile(test.playerID, sID, ps.HandleNewPlayerRequest)
+			if err != nil {
+				t.Fatal("profile setup error: " + err.Error())
+			}
+
+			// the session is a session of the player of the test
+			sessions := testsetup.Sessions{sID: test.playerID}
+
+			config.Config.Levels[0].CooldownSeconds = test.cooldownSeconds
+			config.Config.Levels[0].MaxAttemptsPerDay = test.maxPerDay
+
+			var respRec *httptest.ResponseRecorder
+			for range test.entries {
+				buf := &bytes.Buffer{}
+				err = json.NewEncoder(buf).Encode(&EnterLevelRequestBody{PlayerID: test.playerID, Level: 1})
+				if err != nil {
+					t.Fatal("could not encode the request body: " + err.Error())
+				}
+
+				newReq := httptest.NewRequest(http.MethodPost, "/gameplay/entry/", buf)
+				newReq.Header.Set("Session-Id", sID)
+				respRec = httptest.NewRecorder()
+				testsetup.WithSession(gs, sessions, gs.HandleEnterLevelRequest)(respRec, newReq)
+			}
+
+			// only the last entry can be rejected
+			if test.wantLimit == "" {
+				if respRec.Result().StatusCode != http.StatusOK {
+					t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusOK, respRec.Result().StatusCode)
+				}
+				return
+			}
+
+			if respRec.Result().StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusTooManyRequests, respRec.Result().StatusCode)
+			}
+
+			gotResponseBody := &EntryLimitResponse{}
+			err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			if gotResponseBody.Limit != test.wantLimit || gotResponseBody.ResetTime <= time.Now().UTC().Unix() || gotResponseBody.Error == "" {
+				t.Errorf("handler gave incorrect results, want limit: %v (reset in the future), got: %+v", test.wantLimit, gotResponseBody)
+			}
+		})
+	}
+}
+
+func TestServer_Throttles(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	// the windows are long enough for the test to never outlast them
+	throttles := config.Config.Throttles
+	t.Cleanup(func() { config.Config.Throttles = throttles })
+	config.Config.Throttles = config.ThrottleConfig{
+		Entry:  config.ActionThrottleConfig{MaxActions: 1, WindowSeconds: 60},
+		Result: config.ActionThrottleConfig{MaxActions: 2, WindowSeconds: 60},
+	}
+
+	throttled := NewServer(as, ps, stats.NewServer(as, ds), ds)
+	throttled.EnableThrottles()
+	unthrottled := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	tests := []struct {
+		name       string
+		server     *Server
+		playerID   string
+		path       string
+		body       any
+		requests   int
+		wantAction string
+	}{
+		{"entries within the limit", throttled, "player1", "/gameplay/entry", &EnterLevelRequestBody{PlayerID: "player1", Level: 1}, 1, ""},
+		{"entries over the limit", throttled, "player2", "/gameplay/entry", &EnterLevelRequestBody{PlayerID: "player2", Level: 1}, 2, ThrottleActionEntry},
+		{"results within the limit", throttled, "player3", "/gameplay/result", &LevelResultRequestBody{PlayerID: "player3", Level: 1, Rolls: []int32{6}, DryRun: true}, 2, ""},
+		{"results over the limit", throttled, "player4", "/gameplay/result", &LevelResultRequestBody{PlayerID: "player4", Level: 1, Rolls: []int32{6}, DryRun: true}, 3, ThrottleActionResult},
+		{"throttles not enabled", unthrottled, "player5", "/gameplay/entry", &EnterLevelRequestBody{PlayerID: "player5", Level: 1}, 2, ""},
 	}
 
-	return newPlayerData, nil
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			_, err = testsetup.SetupTestProfile(test.playerID, sID, ps.HandleNewPlayerRequest)
+			if err != nil {
+				t.Fatal("profile setup error: " + err.Error())
+			}
+
+			// the session is a session of the player of the test
+			sessions := testsetup.Sessions{sID: test.playerID}
+
+			var respRec *httptest.ResponseRecorder
+			for range test.requests {
+				buf := &bytes.Buffer{}
+				err = json.NewEncoder(buf).Encode(test.body)
+				if err != nil {
+					t.Fatal("could not encode the request body: " + err.Error())
+				}
+
+				newReq := httptest.NewRequest(http.MethodPost, test.path, buf)
+				newReq.Header.Set("Session-Id", sID)
+				respRec = httptest.NewRecorder()
+				if test.path == "/gameplay/entry" {
+					testsetup.WithSession(test.server, sessions, test.server.HandleEnterLevelRequest)(respRec, newReq)
+				} else {
+					testsetup.WithSession(test.server, sessions, test.server.HandleLevelResultRequest)(respRec, newReq)
+				}
+			}
+
+			// only the last request can be rejected
+			if test.wantAction == "" {
+				if respRec.Result().StatusCode != http.StatusOK {
+					t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusOK, respRec.Result().StatusCode)
+				}
+				return
+			}
+
+			if respRec.Result().StatusCode != http.StatusTooManyRequests || respRec.Result().Header.Get("Retry-After") == "" {
+				t.Fatalf("handler gave incorrect results, want: %v (with a retry after), got: %v", http.StatusTooManyRequests, respRec.Result().StatusCode)
+			}
+
+			gotResponseBody := &ThrottledResponse{}
+			err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			if gotResponseBody.Action != test.wantAction || gotResponseBody.RetryTime <= time.Now().UTC().Unix() || gotResponseBody.Error == "" {
+				t.Errorf("handler gave incorrect results, want action: %v (retry in the future), got: %+v", test.wantAction, gotResponseBody)
+			}
+		})
+	}
+}
+
+func TestServer_FTUEGating(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	// the first level requires the second FTUE step for this test
+	levelConfig := config.Config.Levels[0]
+	t.Cleanup(func() { config.Config.Levels[0] = levelConfig })
+	config.Config.Levels[0].RequiredFTUEStep = 2
+
+	tests := []struct {
+		name       string
+		playerID   string
+		ftueSteps  int32
+		mode       string
+		wantStatus int
+	}{
+		{"FTUE not started", "player1", 0, EntryModeNormal, http.StatusForbidden},
+		{"FTUE step missing", "player2", 1, EntryModeNormal, http.StatusForbidden},
+		{"FTUE step missing, practice", "player3", 1, EntryModePractice, http.StatusForbidden},
+		{"FTUE step completed", "player4", 2, EntryModeNormal, http.StatusOK},
+		{"FTUE step completed, practice", "player5", 2, EntryModePractice, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			_, err = testsetup.SetupTestProfile(test.playerID, sID, ps.HandleNewPlayerRequest)
+			if err != nil {
+				t.Fatal("profile setup error: " + err.Error())
+			}
+
+			// the session is a session of the player of the test
+			sessions := testsetup.Sessions{sID: test.playerID}
+
+			for step := int32(1); step <= test.ftueSteps; step++ {
+				_, err = ps.AdvanceFTUE(context.Background(), test.playerID, step)
+				if err != nil {
+					t.Fatal("FTUE setup error: " + err.Error())
+				}
+			}
+
+			buf := &bytes.Buffer{}
+			err = json.NewEncoder(buf).Encode(&EnterLevelRequestBody{PlayerID: test.playerID, Level: 1, Mode: test.mode})
+			if err != nil {
+				t.Fatal("could not encode the request body: " + err.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/entry/", buf)
+			newReq.Header.Set("Session-Id", sID)
+			respRec := httptest.NewRecorder()
+			testsetup.WithSession(gs, sessions, gs.HandleEnterLevelRequest)(respRec, newReq)
+
+			if respRec.Result().StatusCode != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, respRec.Result().StatusCode)
+			}
+		})
+	}
+}
+
+func TestServer_ClientTimeDrift(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+	frozenClock := clock.NewFrozen(time.Now())
+	gs.SetClock(frozenClock)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	unixNow := frozenClock.Now().UTC().Unix()
+	tests := []struct {
+		name       string
+		clientTime int64
+		wantStatus int
+	}{
+		{"no client time", 0, http.StatusOK},
+		{"client time in sync", unixNow + 5, http.StatusOK},
+		{"client time too far ahead", unixNow + 10*constants.MaxClientTimeDriftSeconds, http.StatusBadRequest},
+		{"client time too far behind", unixNow - 10*constants.MaxClientTimeDriftSeconds, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err = json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{6}, DryRun: true, ClientTime: test.clientTime})
+			if err != nil {
+				t.Fatal("could not encode the request body: " + err.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/result", buf)
+			newReq.Header.Set("Session-Id", sID)
+			respRec := httptest.NewRecorder()
+			testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(respRec, newReq)
+
+			if respRec.Result().StatusCode != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, respRec.Result().StatusCode)
+			}
+
+			if test.wantStatus == http.StatusBadRequest {
+				gotResponseBody := &ClockDriftResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotResponseBody.ServerTime != unixNow || gotResponseBody.Error == "" {
+					t.Errorf("handler gave incorrect results, want the server time (%v), got: %+v", unixNow, gotResponseBody)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_PracticeResult(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	newPlayer, err := testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	entryToken, attemptID, err := gs.issueEntryToken("player1", 1, EntryModePractice, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	buf := &bytes.Buffer{}
+	err = json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{1, 6}, EntryToken: entryToken, AttemptID: attemptID})
+	if err != nil {
+		t.Fatal("could not encode the request body: " + err.Error())
+	}
+
+	newReq := httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+	newReq.Header.Set("Session-Id", sID)
+	respRec := httptest.NewRecorder()
+	testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(respRec, newReq)
+
+	if respRec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusOK, respRec.Result().StatusCode)
+	}
+
+	// a won practice attempt gives no reward, unlocks nothing, and leaves the player and the stats as they were
+	gotResponseBody := &LevelResultResponse{}
+	err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+	if err != nil {
+		t.Fatal("could not decode the response body")
+	}
+
+	wantResponseBody := &LevelResultResponse{
+		LevelResult: LevelResult{Won: true, EnergyReward: 0, UnlockedNewLevel: false, Practice: true},
+		Player:      *newPlayer,
+	}
+
+	if !reflect.DeepEqual(gotResponseBody, wantResponseBody) {
+		t.Errorf("handler gave incorrect results, want: %v, got: %v", wantResponseBody, gotResponseBody)
+	}
+
+	_, err = ds.ReadStats(context.Background(), "player1")
+	if err == nil {
+		t.Errorf("practice results should not be recorded in the stats")
+	}
+}
+
+func TestServer_DryRunResult(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	newPlayer, err := testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	entryToken, attemptID, err := gs.issueEntryToken("player1", 1, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	energyReward := config.Config.Levels[0].EnergyReward
+
+	tests := []struct {
+		name             string
+		requestBody      *LevelResultRequestBody
+		wantStatus       int
+		wantResponseBody *LevelResultResponse
+	}{
+		{"invalid roll value", &LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{7, 6}, DryRun: true}, http.StatusBadRequest, nil},
+		{"locked level", &LevelResultRequestBody{PlayerID: "player1", Level: 2, Rolls: []int32{1, 6}, DryRun: true}, http.StatusBadRequest, nil},
+		{"level loss", &LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{1, 1}, DryRun: true}, http.StatusOK, &LevelResultResponse{
+			LevelResult: LevelResult{Won: false, EnergyReward: 0, UnlockedNewLevel: false, DryRun: true},
+			Player:      *newPlayer,
+			Stats:       data.PlayerStats{LevelStats: []data.PlayerLevelStats{{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99}}, Version: data.PlayerStatsVersion},
+			StatsChange: &stats.LevelStatsChange{Level: 1, LossCountDelta: 1, PreviousBestScore: 99, BestScore: 99},
+		}},
+		{"level win with an entry token", &LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{1, 6}, EntryToken: entryToken, DryRun: true}, http.StatusOK, &LevelResultResponse{
+			LevelResult: LevelResult{Won: true, EnergyReward: energyReward, UnlockedNewLevel: true, DryRun: true},
+			Player:      data.PlayerData{PlayerID: newPlayer.PlayerID, Level: newPlayer.Level + 1, Energy: min(newPlayer.Energy+energyReward, config.Config.MaxEnergy), LastUpdateTime: newPlayer.LastUpdateTime, CreatedTime: newPlayer.CreatedTime, Segment: newPlayer.Segment, ComboMultiplier: config.Config.Bonuses.NextComboMultiplier(0), Version: newPlayer.Version},
+			Stats:       data.PlayerStats{LevelStats: []data.PlayerLevelStats{{Level: 1, WinCount: 1, LossCount: 0, BestScore: 2}}, WinStreak: 1, Version: data.PlayerStatsVersion},
+			StatsChange: &stats.LevelStatsChange{Level: 1, WinCountDelta: 1, PreviousBestScore: 99, BestScore: 2, BestScoreImproved: true, WinStreak: 1},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err2 := json.NewEncoder(buf).Encode(test.requestBody)
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+			newReq.Header.Set("Session-Id", sID)
+			respRec := httptest.NewRecorder()
+			testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &LevelResultResponse{}
+				err2 = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err2 != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
+				}
+			}
+		})
+	}
+
+	// dry runs leave the player, the stats, the review list and the entry token as they were
+	player, err := ds.ReadPlayer(context.Background(), "player1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.Level != newPlayer.Level {
+		t.Errorf("dry runs should not update the player, want level: %v, got: %v", newPlayer.Level, player.Level)
+	}
+
+	_, err = ds.ReadStats(context.Background(), "player1")
+	if err == nil {
+		t.Errorf("dry runs should not be recorded in the stats")
+	}
+
+	if _, flagged := gs.reviewList["player1"]; flagged {
+		t.Errorf("dry runs should not flag the player for review")
+	}
+
+	attempt, err := gs.Attempt(attemptID)
+	if err != nil || attempt.ResultAt != 0 {
+		t.Errorf("dry runs should leave the attempt open, got: %v, %v", attempt, err)
+	}
+}
+
+// testReferralClient records the players whose referrals were completed
+type testReferralClient struct {
+	completed []string
+}
+
+func (rc *testReferralClient) CompleteReferral(ctx context.Context, playerID string) error {
+	rc.completed = append(rc.completed, playerID)
+	return nil
+}
+
+func TestServer_ReferralCompletion(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+	rc := &testReferralClient{}
+	gs.EnableReferrals(rc)
+
+	tests := []struct {
+		name          string
+		level         int32
+		mode          string
+		rolls         []int32
+		wantCompleted []string
+	}{
+		{"practice win", 1, EntryModePractice, []int32{6}, nil},
+		{"loss", 1, EntryModeNormal, []int32{1, 2}, nil},
+		{"first win", 1, EntryModeNormal, []int32{6}, []string{"player1"}},
+		{"win at the next level", 2, EntryModeNormal, []int32{4}, []string{"player1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			entryToken, attemptID, err2 := gs.issueEntryToken("player1", test.level, test.mode, nil)
+			if err2 != nil {
+				t.Fatal("entry token setup error: " + err2.Error())
+			}
+
+			buf := &bytes.Buffer{}
+			err2 = json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: test.level, Rolls: test.rolls, EntryToken: entryToken, AttemptID: attemptID})
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+			newReq.Header.Set("Session-Id", sID)
+			respRec := httptest.NewRecorder()
+			testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(respRec, newReq)
+
+			if respRec.Result().StatusCode != http.StatusOK {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusOK, respRec.Result().StatusCode)
+			}
+
+			if !reflect.DeepEqual(rc.completed, test.wantCompleted) {
+				t.Errorf("handler gave incorrect referral completions, want: %v, got: %v", test.wantCompleted, rc.completed)
+			}
+		})
+	}
+}
+
+func TestServer_HandlePrestigeRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
+	var gs1 *Server
+	gs2 := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	lastLevel := config.Config.LevelCount()
+	maxRank := config.Config.Prestige.MaxRank()
+
+	tests := []struct {
+		name           string
+		server         *Server
+		sessionID      string
+		level          int32
+		rank           int32
+		wantStatus     int
+		wantRank       int32
+		wantMultiplier float64
+	}{
+		{"nil server", gs1, sID, lastLevel, 0, http.StatusInternalServerError, 0, 0},
+		{"invalid session", gs2, "", lastLevel, 0, http.StatusUnauthorized, 0, 0},
+		{"another player", gs2, "session2", lastLevel, 0, http.StatusForbidden, 0, 0},
+		{"below the last level", gs2, sID, lastLevel - 1, 0, http.StatusConflict, 0, 0},
+		{"first prestige", gs2, sID, lastLevel, 0, http.StatusOK, 1, config.Config.Prestige.RewardMultipliers[0]},
+		{"next prestige", gs2, sID, lastLevel, 1, http.StatusOK, 2, config.Config.Prestige.RewardMultipliers[1]},
+		{"highest rank", gs2, sID, lastLevel, maxRank, http.StatusConflict, 0, 0},
+	}
+
+	wantPrestigeCount := int32(0)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			player, err2 := ds.ReadPlayer(context.Background(), "player1")
+			if err2 != nil {
+				t.Fatal("read player error: " + err2.Error())
+			}
+			player.Level, player.PrestigeRank = test.level, test.rank
+			err2 = ds.WritePlayer(context.Background(), player)
+			if err2 != nil {
+				t.Fatal("write player error: " + err2.Error())
+			}
+
+			buf := &bytes.Buffer{}
+			err2 = json.NewEncoder(buf).Encode(&PrestigeRequestBody{PlayerID: "player1"})
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/prestige", buf)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+			testsetup.WithSession(test.server, sessions, test.server.HandlePrestigeRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus != http.StatusOK {
+				return
+			}
+			wantPrestigeCount += 1
+
+			gotResponseBody := &PrestigeResponse{}
+			err2 = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+			if err2 != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			if gotResponseBody.Player.Level != config.Config.DefaultLevel || gotResponseBody.Player.PrestigeRank != test.wantRank || gotResponseBody.RewardMultiplier != test.wantMultiplier {
+				t.Errorf("handler gave incorrect results, want: level %v, rank %v, multiplier %v, got: %+v", config.Config.DefaultLevel, test.wantRank, test.wantMultiplier, gotResponseBody)
+			}
+
+			gotStats, err2 := ds.ReadStats(context.Background(), "player1")
+			if err2 != nil {
+				t.Fatal("read stats error: " + err2.Error())
+			}
+			if gotStats.PrestigeCount != wantPrestigeCount {
+				t.Errorf("handler gave incorrect prestige count, want: %v, got: %v", wantPrestigeCount, gotStats.PrestigeCount)
+			}
+		})
+	}
+}
+
+func TestPrestigeReward(t *testing.T) {
+
+	// the reward of a level win is scaled by the reward multiplier of the prestige rank of the player
+	tests := []struct {
+		name         string
+		energyReward int32
+		rank         int32
+		want         int32
+	}{
+		{"never prestiged", 5, 0, 5},
+		{"first rank", 5, 1, 6},
+		{"rounded", 7, 2, 11},
+		{"highest rank", 8, 3, 16},
+		{"above the highest rank", 8, 7, 16},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := rules.ScaleReward(test.energyReward, config.Config.Prestige.RewardMultiplier(test.rank))
+			if got != test.want {
+				t.Errorf("ScaleReward() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_EnergySegments(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	// player1 is a new player, player2 is coming back after a lapse, and player3 is in the default segment
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	lapsedTime := time.Now().UTC().Unix() - int64(config.Config.Segments.LapsedDays)*24*60*60 - 1
+	for _, player := range []*data.PlayerData{
+		{PlayerID: "player2", Level: 1, Energy: 10, LastUpdateTime: lapsedTime, CreatedTime: lapsedTime},
+		{PlayerID: "player3", Level: 1, Energy: config.Config.MaxEnergy, LastUpdateTime: time.Now().UTC().Unix(), CreatedTime: lapsedTime},
+	} {
+		err = ds.WritePlayer(context.Background(), player)
+		if err != nil {
+			t.Fatal("player setup error: " + err.Error())
+		}
+	}
+
+	// the experiment is off by default, so only the server with the flag turned on tunes the energy
+	cs := config.NewServer(as)
+	err = cs.SetFlag(config.FlagEnergySegments, config.FlagOn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	experimentServer := NewServer(as, ps, stats.NewServer(as, ds), ds)
+	experimentServer.EnableFeatureFlags(config.NewFlagChecker(cs))
+
+	defaultServer := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	levelConfig, _ := config.Config.Level(1)
+	segments := &config.Config.Segments
+
+	tests := []struct {
+		name        string
+		server      *Server
+		playerID    string
+		wantSegment string
+		wantCost    int32
+		wantReward  int32
+	}{
+		{"new player, experiment off", defaultServer, "player1", config.SegmentNew, levelConfig.EnergyCost, levelConfig.EnergyReward},
+		{"new player", experimentServer, "player1", config.SegmentNew, segments.EnergyCost(config.SegmentNew, levelConfig.EnergyCost), segments.EnergyReward(config.SegmentNew, levelConfig.EnergyReward)},
+		{"lapsed player", experimentServer, "player2", config.SegmentLapsed, segments.EnergyCost(config.SegmentLapsed, levelConfig.EnergyCost), segments.EnergyReward(config.SegmentLapsed, levelConfig.EnergyReward)},
+		{"default segment", experimentServer, "player3", "", levelConfig.EnergyCost, levelConfig.EnergyReward},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// the session is a session of the player of the test
+			sessions := testsetup.Sessions{sID: test.playerID}
+
+			// the energy is read right before the entry, since the players regenerate energy
+			player, err2 := ps.GetPlayer(context.Background(), test.playerID)
+			if err2 != nil {
+				t.Fatal(err2)
+			}
+			if player.Segment != test.wantSegment {
+				t.Errorf("profile gave incorrect segment, want: %q, got: %q", test.wantSegment, player.Segment)
+			}
+
+			buf := &bytes.Buffer{}
+			err2 = json.NewEncoder(buf).Encode(&EnterLevelRequestBody{PlayerID: test.playerID, Level: 1})
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/entry/", buf)
+			newReq.Header.Set("Session-Id", sID)
+			respRec := httptest.NewRecorder()
+			testsetup.WithSession(test.server, sessions, test.server.HandleEnterLevelRequest)(respRec, newReq)
+
+			entryResponse := &EnterLevelResponse{}
+			err2 = json.NewDecoder(respRec.Result().Body).Decode(entryResponse)
+			if err2 != nil {
+				t.Fatal("could not decode the entry response body")
+			}
+
+			if gotCost := player.Energy - entryResponse.Player.Energy; gotCost != test.wantCost {
+				t.Errorf("entry handler gave incorrect energy cost, want: %v, got: %v", test.wantCost, gotCost)
+			}
+
+			buf = &bytes.Buffer{}
+			err2 = json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: test.playerID, Level: 1, Rolls: []int32{levelConfig.Target}, EntryToken: entryResponse.EntryToken, AttemptID: entryResponse.AttemptID})
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq = httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+			newReq.Header.Set("Session-Id", sID)
+			respRec = httptest.NewRecorder()
+			testsetup.WithSession(test.server, sessions, test.server.HandleLevelResultRequest)(respRec, newReq)
+
+			resultResponse := &LevelResultResponse{}
+			err2 = json.NewDecoder(respRec.Result().Body).Decode(resultResponse)
+			if err2 != nil {
+				t.Fatal("could not decode the result response body")
+			}
+
+			// the bonuses of the win (which do not depend on the segment) are reported on their own
+			gotReward := resultResponse.LevelResult.EnergyReward
+			for _, bonus := range resultResponse.LevelResult.Bonuses {
+				gotReward -= bonus.Energy
+			}
+
+			if gotReward != test.wantReward {
+				t.Errorf("result handler gave incorrect energy reward, want: %v, got: %v", test.wantReward, gotReward)
+			}
+		})
+	}
+}
+
+func TestServer_DynamicDifficulty(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+	gs.EnableDynamicDifficulty()
+
+	// player1 lost all their recent attempts, so they get the extra roll of the lowest difficulty step
+	for range config.Config.Difficulty.RecentAttempts {
+		err = ds.WriteAttempt(context.Background(), &data.AttemptRecord{PlayerID: "player1", Level: 1, Won: false})
+		if err != nil {
+			t.Fatal("attempt setup error: " + err.Error())
+		}
+	}
+
+	levelConfig, _ := config.Config.Level(1)
+	wantDifficulty := &DifficultyAdjustment{WinRate: 0, TargetDelta: 0, RollsDelta: 1, Target: levelConfig.Target, TotalRolls: levelConfig.TotalRolls + 1}
+
+	buf := &bytes.Buffer{}
+	err = json.NewEncoder(buf).Encode(&EnterLevelRequestBody{PlayerID: "player1", Level: 1})
+	if err != nil {
+		t.Fatal("could not encode the request body: " + err.Error())
+	}
+
+	newReq := httptest.NewRequest(http.MethodPost, "/gameplay/entry/", buf)
+	newReq.Header.Set("Session-Id", sID)
+	respRec := httptest.NewRecorder()
+	testsetup.WithSession(gs, sessions, gs.HandleEnterLevelRequest)(respRec, newReq)
+
+	entryResponse := &EnterLevelResponse{}
+	err = json.NewDecoder(respRec.Result().Body).Decode(entryResponse)
+	if err != nil {
+		t.Fatal("could not decode the entry response body")
+	}
+
+	if !reflect.DeepEqual(entryResponse.Difficulty, wantDifficulty) {
+		t.Fatalf("entry handler gave incorrect difficulty, want: %v, got: %v", wantDifficulty, entryResponse.Difficulty)
+	}
+
+	// the extra roll can only be used with the entry token of the adjusted attempt, and the win comes on it
+	rolls := make([]int32, wantDifficulty.TotalRolls)
+	for i := range rolls {
+		rolls[i] = 1
+	}
+	rolls[len(rolls)-1] = levelConfig.Target
+
+	unadjustedToken, unadjustedAttempt, err := gs.issueEntryToken("player1", 1, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		entryToken string
+		attemptID  string
+		wantStatus int
+	}{
+		{"unadjusted attempt", unadjustedToken, unadjustedAttempt, http.StatusBadRequest},
+		{"adjusted attempt", entryResponse.EntryToken, entryResponse.AttemptID, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err2 := json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: rolls, EntryToken: test.entryToken, AttemptID: test.attemptID})
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+			newReq.Header.Set("Session-Id", sID)
+			respRec := httptest.NewRecorder()
+			testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(respRec, newReq)
+
+			if respRec.Result().StatusCode != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, respRec.Result().StatusCode)
+			}
+
+			if test.wantStatus == http.StatusOK {
+				gotResponseBody := &LevelResultResponse{}
+				err2 = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err2 != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !gotResponseBody.LevelResult.Won {
+					t.Errorf("handler gave incorrect results, the win on the extra roll should count")
+				}
+			}
+		})
+	}
+}
+
+func TestAdjustLevel(t *testing.T) {
+
+	// a level with two dice, so targets 2 to 12 can be rolled
+	levelConfig := &config.LevelConfig{Level: 1, TotalRolls: 3, Target: 11, DiceSides: 6, DiceCount: 2}
+	difficultyConfig := &config.DifficultyConfig{RecentAttempts: 10, MinAttempts: 4, Steps: []config.DifficultyStep{
+		{MinWinRate: 0, MaxWinRate: 0.25, TargetDelta: -1, RollsDelta: 1},
+		{MinWinRate: 0.75, MaxWinRate: 1, TargetDelta: 2, RollsDelta: -3},
+	}}
+
+	tests := []struct {
+		name           string
+		form           *stats.RecentForm
+		wantAdjustment *DifficultyAdjustment
+	}{
+		{"no attempts", &stats.RecentForm{}, nil},
+		{"too few attempts", &stats.RecentForm{Wins: 0, Losses: 3}, nil},
+		{"low win rate", &stats.RecentForm{Wins: 1, Losses: 3}, &DifficultyAdjustment{WinRate: 0.25, TargetDelta: -1, RollsDelta: 1, Target: 10, TotalRolls: 4}},
+		{"win rate between steps", &stats.RecentForm{Wins: 2, Losses: 2}, nil},
+		{"high win rate, target out of range and at least one roll", &stats.RecentForm{Wins: 8, Losses: 0}, &DifficultyAdjustment{WinRate: 1, TargetDelta: 0, RollsDelta: -2, Target: 11, TotalRolls: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotAdjustment := adjustLevel(levelConfig, test.form, difficultyConfig)
+			if !reflect.DeepEqual(gotAdjustment, test.wantAdjustment) {
+				t.Errorf("adjustLevel() gave incorrect results, want: %v, got: %v", test.wantAdjustment, gotAdjustment)
+			}
+		})
+	}
+}
+
+func TestServer_LevelsBeingPlayed(t *testing.T) {
+
+	gs := NewServer(nil, nil, nil, nil)
+	frozenClock := clock.NewFrozen(time.Now())
+	gs.SetClock(frozenClock)
+
+	firstToken, firstAttempt, err := gs.issueEntryToken("player1", 1, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	_, _, err = gs.issueEntryToken("player2", 2, EntryModePractice, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	// an attempt which was entered long ago, and never finished
+	gs.attempts["expiredAttempt"] = &LevelAttempt{AttemptID: "expiredAttempt", IssuedAt: frozenClock.Now().UTC().Unix() - constants.EntryTokenExpirySeconds - 1}
+
+	tests := []struct {
+		name   string
+		action func()
+		want   int64
+	}{
+		{"expired attempt not counted", func() {}, 2},
+		{"finished attempt not counted", func() {
+			claims, _ := gs.verifyEntryToken(firstToken, "player1", 1, firstAttempt)
+			_, _ = gs.claimAttempt(claims, []int32{1, 6}, frozenClock.Now().UTC().Unix())
+		}, 1},
+		{"unfinished attempts expire with time", func() {
+			frozenClock.Advance((constants.EntryTokenExpirySeconds + 1) * time.Second)
+		}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.action()
+			got := gs.LevelsBeingPlayed()
+			if got != test.want {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_checkLevelResult(t *testing.T) {
+
+	type result struct {
+		won            bool
+		winProbability float64
+		secondsLater   int64
+	}
+
+	// the given number of results, each submitted the given seconds after the previous one
+	results := func(count int, won bool, winProbability float64, secondsLater int64) []result {
+		list := make([]result, count)
+		for i := range list {
+			list[i] = result{won, winProbability, secondsLater}
+		}
+		return list
+	}
+
+	tests := []struct {
+		name        string
+		results     []result
+		wantReasons []string
+	}{
+		{"likely wins", results(10, true, 0.5, 10), nil},
+		{"improbable streak", results(7, true, 0.1, 10), []string{ReviewReasonImprobableStreak}},
+		{"streak broken by a loss", append(results(5, true, 0.1, 10), append(results(1, false, 0.1, 10), results(5, true, 0.1, 10)...)...), nil},
+		{"improbable streak flagged once", results(13, true, 0.1, 10), []string{ReviewReasonImprobableStreak}},
+		{"human submission rate", results(constants.MaxResultsPerMinute+1, false, 0.5, 2), nil},
+		{"inhuman submission rate", results(constants.MaxResultsPerMinute+1, false, 0.5, 0), []string{ReviewReasonSubmissionRate}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gs := NewServer(nil, nil, nil, nil)
+
+			unixTime := int64(1000)
+			for _, res := range test.results {
+				unixTime += res.secondsLater
+				gs.checkLevelResult("player1", "", 1, res.winProbability, res.won, unixTime)
+			}
+
+			var gotReasons []string
+			for _, entry := range gs.ReviewList() {
+				for _, flag := range entry.Flags {
+					gotReasons = append(gotReasons, flag.Reason)
+				}
+			}
+
+			if !reflect.DeepEqual(gotReasons, test.wantReasons) {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantReasons, gotReasons)
+			}
+		})
+	}
+}
+
+func TestServer_HandleReviewRequests(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	// submitting an impossible roll gets the player flagged
+	buf := &bytes.Buffer{}
+	err = json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{9}})
+	if err != nil {
+		t.Fatal("could not encode the request body: " + err.Error())
+	}
+
+	resultReq := httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+	resultReq.Header.Set("Session-Id", sID)
+	testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(httptest.NewRecorder(), resultReq)
+
+	gs.flagForReview("player2", "", ReviewReasonSubmissionRate, "test", 1)
+
+	tests := []struct {
+		name           string
+		method         string
+		id             string
+		query          string
+		adminToken     string
+		wantStatus     int
+		wantPlayers    []string
+		wantNextCursor bool
+	}{
+		{"list, invalid admin token", http.MethodGet, "", "", "testToken", http.StatusUnauthorized, nil, false},
+		{"list, most recent first", http.MethodGet, "", "", "adminToken", http.StatusOK, []string{"player1", "player2"}, false},
+		{"list, first page", http.MethodGet, "", "?limit=1", "adminToken", http.StatusOK, []string{"player1"}, true},
+		{"list, least recent first", http.MethodGet, "", "?order=asc", "adminToken", http.StatusOK, []string{"player2", "player1"}, false},
+		{"list, invalid cursor", http.MethodGet, "", "?cursor=bad", "adminToken", http.StatusBadRequest, nil, false},
+		{"clear, invalid admin token", http.MethodDelete, "player2", "", "testToken", http.StatusUnauthorized, nil, false},
+		{"clear, player not in the list", http.MethodDelete, "player3", "", "adminToken", http.StatusNotFound, nil, false},
+		{"clear, success", http.MethodDelete, "player2", "", "adminToken", http.StatusOK, nil, false},
+		{"list, after clearing", http.MethodGet, "", "", "adminToken", http.StatusOK, []string{"player1"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(test.method, "/gameplay/admin/review/"+test.id+test.query, nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			newReq.SetPathValue("id", test.id)
+			respRec := httptest.NewRecorder()
+
+			if test.method == http.MethodGet {
+				gs.HandleReviewListRequest(respRec, newReq)
+			} else {
+				gs.HandleClearReviewRequest(respRec, newReq)
+			}
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if test.wantPlayers != nil {
+				gotPage := &ReviewPage{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotPage)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				gotPlayers := []string{}
+				for _, entry := range gotPage.Entries {
+					gotPlayers = append(gotPlayers, entry.PlayerID)
+				}
+
+				if !reflect.DeepEqual(gotPlayers, test.wantPlayers) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantPlayers, gotPlayers)
+				}
+
+				if (gotPage.NextCursor != "") != test.wantNextCursor {
+					t.Errorf("handler gave incorrect results, want next cursor: %v, got: %q", test.wantNextCursor, gotPage.NextCursor)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleAttemptRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	entryToken, attemptID, err := gs.issueEntryToken("player1", 1, EntryModeNormal, nil)
+	if err != nil {
+		t.Fatal("entry token setup error: " + err.Error())
+	}
+
+	// an impossible roll is flagged on the attempt, and then the result of the attempt comes in
+	for _, rolls := range [][]int32{{9}, {1, 6}} {
+		buf := &bytes.Buffer{}
+		err = json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: rolls, EntryToken: entryToken, AttemptID: attemptID})
+		if err != nil {
+			t.Fatal("could not encode the request body: " + err.Error())
+		}
+
+		resultReq := httptest.NewRequest(http.MethodPost, "/gameplay/result/", buf)
+		resultReq.Header.Set("Session-Id", sID)
+		testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(httptest.NewRecorder(), resultReq)
+	}
+
+	tests := []struct {
+		name        string
+		id          string
+		adminToken  string
+		wantStatus  int
+		wantRolls   []int32
+		wantWon     bool
+		wantReasons []string
+	}{
+		{"invalid admin token", attemptID, "testToken", http.StatusUnauthorized, nil, false, nil},
+		{"unknown attempt", "testAttempt", "adminToken", http.StatusNotFound, nil, false, nil},
+		{"success", attemptID, "adminToken", http.StatusOK, []int32{1, 6}, true, []string{ReviewReasonImpossibleRoll}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/gameplay/admin/attempt/"+test.id, nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			newReq.SetPathValue("id", test.id)
+			respRec := httptest.NewRecorder()
+
+			gs.HandleAttemptRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotAttempt := &LevelAttempt{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotAttempt)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				gotReasons := []string{}
+				for _, flag := range gotAttempt.Flags {
+					gotReasons = append(gotReasons, flag.Reason)
+				}
+
+				if gotAttempt.PlayerID != "player1" || !reflect.DeepEqual(gotAttempt.Rolls, test.wantRolls) || gotAttempt.Won != test.wantWon || !reflect.DeepEqual(gotReasons, test.wantReasons) {
+					t.Errorf("handler gave incorrect results, want: rolls %v, won %v, flags %v, got: %v", test.wantRolls, test.wantWon, test.wantReasons, gotAttempt)
+				}
+			}
+		})
+	}
+
+	// the flag in the review list points at the attempt too
+	reviewList := gs.ReviewList()
+	if len(reviewList) != 1 || reviewList[0].Flags[0].AttemptID != attemptID {
+		t.Errorf("review list flag should reference attempt %v, got: %v", attemptID, reviewList)
+	}
+}
+
+func TestServer_AttemptRefunds(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+	frozenClock := clock.NewFrozen(time.Now())
+	ps.SetClock(frozenClock)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session is a session of the test player
+	sessions := testsetup.Sessions{sID: "player1"}
+	energyCost := config.Config.Levels[0].EnergyCost
+
+	// the player starts well below the max energy, so the refunds are not capped
+	_, err = ps.SetPlayer(context.Background(), &profile.PlayerOverride{PlayerID: "player1", Level: 1, Energy: 20})
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+	gs.SetClock(frozenClock)
+
+	// energy returns the current energy of the player
+	energy := func() int32 {
+		player, err := ps.GetPlayer(context.Background(), "player1")
+		if err != nil {
+			t.Fatal("could not get the player: " + err.Error())
+		}
+		return player.Energy
+	}
+
+	// enter enters level 1, and returns the response
+	enter := func() *EnterLevelResponse {
+		buf := &bytes.Buffer{}
+		err := json.NewEncoder(buf).Encode(&EnterLevelRequestBody{PlayerID: "player1", Level: 1})
+		if err != nil {
+			t.Fatal("could not encode the request body: " + err.Error())
+		}
+
+		entryReq := httptest.NewRequest(http.MethodPost, "/gameplay/entry", buf)
+		entryReq.Header.Set("Session-Id", sID)
+		respRec := httptest.NewRecorder()
+		testsetup.WithSession(gs, sessions, gs.HandleEnterLevelRequest)(respRec, entryReq)
+
+		entryResponse := &EnterLevelResponse{}
+		err = json.NewDecoder(respRec.Result().Body).Decode(entryResponse)
+		if err != nil || !entryResponse.AccessGranted {
+			t.Fatalf("could not enter the level, got: %v, %v", respRec.Code, err)
+		}
+		return entryResponse
+	}
+
+	// submitResult sends a result for the given entry, and returns the status it got
+	submitResult := func(entry *EnterLevelResponse) int {
+		buf := &bytes.Buffer{}
+		err := json.NewEncoder(buf).Encode(&LevelResultRequestBody{PlayerID: "player1", Level: 1, Rolls: []int32{1, 6}, EntryToken: entry.EntryToken, AttemptID: entry.AttemptID})
+		if err != nil {
+			t.Fatal("could not encode the request body: " + err.Error())
+		}
+
+		resultReq := httptest.NewRequest(http.MethodPost, "/gameplay/result", buf)
+		resultReq.Header.Set("Session-Id", sID)
+		respRec := httptest.NewRecorder()
+		testsetup.WithSession(gs, sessions, gs.HandleLevelResultRequest)(respRec, resultReq)
+		return respRec.Code
+	}
+
+	abandonedEntry := enter()
+	refundedEntry := enter()
+	finishedEntry := enter()
+	if status := submitResult(finishedEntry); status != http.StatusOK {
+		t.Fatalf("could not submit the result, got: %v", status)
+	}
+	energyBefore := energy()
+
+	tests := []struct {
+		name       string
+		id         string
+		adminToken string
+		wantStatus int
+		wantEnergy int32
+	}{
+		{"invalid admin token", refundedEntry.AttemptID, "testToken", http.StatusUnauthorized, 0},
+		{"unknown attempt", "testAttempt", "adminToken", http.StatusNotFound, 0},
+		{"attempt with a result", finishedEntry.AttemptID, "adminToken", http.StatusConflict, 0},
+		{"success", refundedEntry.AttemptID, "adminToken", http.StatusOK, energyBefore + energyCost},
+		{"already refunded", refundedEntry.AttemptID, "adminToken", http.StatusConflict, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/admin/attempt/"+test.id+"/refund", nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			newReq.SetPathValue("id", test.id)
+			respRec := httptest.NewRecorder()
+
+			gs.HandleRefundAttemptRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotPlayer := &data.PlayerData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotPlayer)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+				if gotPlayer.Energy != test.wantEnergy {
+					t.Errorf("handler gave incorrect results, want energy: %v, got: %v", test.wantEnergy, gotPlayer.Energy)
+				}
+			}
+		})
+	}
+
+	// the refunded attempt cannot take a result any more
+	if status := submitResult(refundedEntry); status != http.StatusConflict {
+		t.Errorf("the result of a refunded attempt should be turned away, want: %v, got: %v", http.StatusConflict, status)
+	}
+
+	// before it expires, the abandoned attempt could still get a result
+	unixNow := frozenClock.Now().UTC().Unix()
+	if refunded := gs.refundExpiredAttempts(unixNow); refunded != 0 {
+		t.Errorf("no attempt should have been refunded before expiring, got: %v", refunded)
+	}
+
+	// once expired, only the abandoned attempt is refunded, and only once
+	expired := unixNow + constants.EntryTokenExpirySeconds + 1
+	for _, want := range []int{1, 0} {
+		if refunded := gs.refundExpiredAttempts(expired); refunded != want {
+			t.Errorf("refundExpiredAttempts() gave incorrect results, want: %v, got: %v", want, refunded)
+		}
+	}
+
+	if want, got := energyBefore+2*energyCost, energy(); got != want {
+		t.Errorf("the energy of the abandoned attempt should have been refunded, want: %v, got: %v", want, got)
+	}
+
+	_, err = gs.Attempt(abandonedEntry.AttemptID)
+	if _, ok := err.(LevelAttemptNotFoundErr); !ok {
+		t.Errorf("the record of the refunded attempt should have been forgotten, got: %v", err)
+	}
+
+	// both refunds are in the audit log
+	page, err := ds.ReadAuditEntries(context.Background(), &data.AuditQuery{Action: audit.ActionEnergyRefund})
+	if err != nil || len(page.Entries) != 2 {
+		t.Fatalf("the refunds should have been recorded in the audit log, got: %+v, %v", page, err)
+	}
+	if page.Entries[0].Actor != audit.ActorAdmin || page.Entries[1].Actor != audit.ActorSystem {
+		t.Errorf("the refunds have incorrect actors, want: %v then %v, got: %v then %v", audit.ActorAdmin, audit.ActorSystem, page.Entries[0].Actor, page.Entries[1].Actor)
+	}
+}
+
+// racingProfileClient runs the given function at the start of the next energy refund (like a refund of the same
+// attempt coming in meanwhile)
+type racingProfileClient struct {
+	*profile.Server
+	duringRefund func()
+}
+
+func (rpc *racingProfileClient) RefundEnergy(ctx context.Context, refund *profile.EnergyRefund) (*data.PlayerData, error) {
+	if rpc.duringRefund != nil {
+		duringRefund := rpc.duringRefund
+		rpc.duringRefund = nil
+		duringRefund()
+	}
+	return rpc.Server.RefundEnergy(ctx, refund)
+}
+
+func TestServer_AttemptRefunds_ExpiryAndAdmin(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	ps := profile.NewServer(as, ds)
+	frozenClock := clock.NewFrozen(time.Now())
+	ps.SetClock(frozenClock)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, ps.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the player starts well below the max energy, so the refund is not capped
+	_, err = ps.SetPlayer(context.Background(), &profile.PlayerOverride{PlayerID: "player1", Level: 1, Energy: 20})
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	profileClient := &racingProfileClient{Server: ps}
+	gs := NewServer(as, profileClient, stats.NewServer(as, ds), ds)
+	gs.SetClock(frozenClock)
+
+	buf := &bytes.Buffer{}
+	err = json.NewEncoder(buf).Encode(&EnterLevelRequestBody{PlayerID: "player1", Level: 1})
+	if err != nil {
+		t.Fatal("could not encode the request body: " + err.Error())
+	}
+
+	entryReq := httptest.NewRequest(http.MethodPost, "/gameplay/entry", buf)
+	entryReq.Header.Set("Session-Id", sID)
+	respRec := httptest.NewRecorder()
+	testsetup.WithSession(gs, testsetup.Sessions{sID: "player1"}, gs.HandleEnterLevelRequest)(respRec, entryReq)
+
+	entry := &EnterLevelResponse{}
+	err = json.NewDecoder(respRec.Result().Body).Decode(entry)
+	if err != nil || !entry.AccessGranted {
+		t.Fatalf("could not enter the level, got: %v, %v", respRec.Code, err)
+	}
+
+	player, err := ps.GetPlayer(context.Background(), "player1")
+	if err != nil {
+		t.Fatal("could not get the player: " + err.Error())
+	}
+	energyBefore := player.Energy
+
+	// an admin refunds the attempt while the expiry check is refunding it
+	var adminErr error
+	profileClient.duringRefund = func() {
+		_, adminErr = gs.RefundAttempt(context.Background(), entry.AttemptID)
+	}
+
+	expired := frozenClock.Now().UTC().Unix() + constants.EntryTokenExpirySeconds + 1
+	if refunded := gs.refundExpiredAttempts(expired); refunded != 1 {
+		t.Errorf("refundExpiredAttempts() gave incorrect results, want: %v, got: %v", 1, refunded)
+	}
+
+	if adminErr != refundedAttemptError {
+		t.Errorf("the admin refund gave incorrect results, want: %v, got: %v", refundedAttemptError, adminErr)
+	}
+
+	player, err = ps.GetPlayer(context.Background(), "player1")
+	if err != nil {
+		t.Fatal("could not get the player: " + err.Error())
+	}
+	if want := energyBefore + config.Config.Levels[0].EnergyCost; player.Energy != want {
+		t.Errorf("the energy of the attempt should have been refunded once, want: %v, got: %v", want, player.Energy)
+	}
+}
+
+func TestLevelResultResponse_ForAPIVersion(t *testing.T) {
+
+	response := &LevelResultResponse{
+		LevelResult: LevelResult{Won: true, EnergyReward: 5, UnlockedNewLevel: true},
+		Player:      data.PlayerData{PlayerID: "player1", Level: 3, Energy: 40},
+		Stats: data.PlayerStats{LevelStats: []data.PlayerLevelStats{
+			{Level: 1, WinCount: 2, LossCount: 1, BestScore: 2},
+			{Level: 2, WinCount: 1, LossCount: 0, BestScore: 3},
+		}},
+		level: 2,
+	}
+
+	tests := []struct {
+		name         string
+		version      int
+		wantResponse any
+	}{
+		{"version 1", middleware.APIVersion1, response},
+		{"version 2", middleware.APIVersion2, &LevelResultResponseV2{
+			LevelResult: response.LevelResult,
+			Player:      response.Player,
+			LevelStats:  data.PlayerLevelStats{Level: 2, WinCount: 1, LossCount: 0, BestScore: 3},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotResponse := response.ForAPIVersion(test.version)
+			if !reflect.DeepEqual(gotResponse, test.wantResponse) {
+				t.Errorf("ForAPIVersion() gave incorrect results, want: %v, got: %v", test.wantResponse, gotResponse)
+			}
+		})
+	}
+}
+
+func TestSimulate(t *testing.T) {
+
+	// a candidate config where every level is won on the first roll (a one sided die, with a target of 1)
+	encoded, err := json.Marshal(config.Config)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+	certainWins := &config.GameConfig{}
+	err = json.Unmarshal(encoded, certainWins)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+	for i := range certainWins.Levels {
+		certainWins.Levels[i].DiceSides, certainWins.Levels[i].DiceCount, certainWins.Levels[i].Target, certainWins.Levels[i].FaceWeights = 1, 1, 1, nil
+	}
+
+	invalid := &config.GameConfig{}
+	_ = json.Unmarshal(encoded, invalid)
+	invalid.DefaultLevel = 0
+
+	levelCount := config.Config.LevelCount()
+	grinders := []SimulatedProfile{{Name: "grinder", Players: 10, Attempts: levelCount + 2, LevelChoice: LevelChoiceHighest}}
+
+	tests := []struct {
+		name     string
+		config   *config.GameConfig
+		profiles []SimulatedProfile
+		wantErr  bool
+	}{
+		{"invalid config", invalid, grinders, true},
+		{"no profiles", config.Config, nil, true},
+		{"unknown level choice", config.Config, []SimulatedProfile{{Name: "p", Players: 1, Attempts: 1, LevelChoice: "lowest"}}, true},
+		{"too many attempts", config.Config, []SimulatedProfile{{Name: "p", Players: 1000, Attempts: 10000, LevelChoice: LevelChoiceHighest}}, true},
+		{"current config", config.Config, append(grinders, SimulatedProfile{Name: "casual", Players: 10, Attempts: 5, LevelChoice: LevelChoiceRandom, PracticeChance: 0.5}), false},
+		{"certain wins", certainWins, grinders, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			report, err := Simulate(context.Background(), test.config, &SimulationRequest{Profiles: test.profiles, Seed: 7})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Simulate() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+
+			// the same seed reproduces the same simulation
+			again, err := Simulate(context.Background(), test.config, &SimulationRequest{Profiles: test.profiles, Seed: 7})
+			if err != nil || !reflect.DeepEqual(report, again) {
+				t.Errorf("Simulate() gave incorrect results, want the same report for the same seed, got: %+v and %+v (error: %v)", report, again, err)
+			}
+
+			wantAttempts := int64(0)
+			for _, profile := range test.profiles {
+				wantAttempts += int64(profile.Players) * int64(profile.Attempts)
+			}
+			if report.Attempts != wantAttempts || int32(len(report.Levels)) != levelCount || report.NetEnergy != report.EnergyEarned-report.EnergySpent {
+				t.Errorf("Simulate() gave incorrect results, want %v attempts over %v levels, got: %+v", wantAttempts, levelCount, report)
+			}
+		})
+	}
+
+	// with certain wins, the grinders win every attempt in a single roll, and reach the last level
+	report, err := Simulate(context.Background(), certainWins, &SimulationRequest{Profiles: grinders, Seed: 7})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+	if report.WinRate != 1 || report.AverageLevelReached != float64(levelCount) || report.Levels[0].AverageWinRolls != 1 || report.EnergyEarned == 0 {
+		t.Errorf("Simulate() gave incorrect results, want only wins in 1 roll, reaching level %v, got: %+v", levelCount, report)
+	}
+}
+
+func TestServer_HandleSimulationRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ds := data.NewServer()
+	as := auth.NewServer(ds)
+	ps := profile.NewServer(as, ds)
+	gs := NewServer(as, ps, stats.NewServer(as, ds), ds)
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", gs, "testToken", "", http.StatusUnauthorized},
+		{"malformed body", gs, "adminToken", "{", http.StatusBadRequest},
+		{"no profiles", gs, "adminToken", `{"profiles": []}`, http.StatusBadRequest},
+		{"current config", gs, "adminToken", `{"profiles": [{"name": "casual", "players": 5, "attempts": 5, "levelChoice": "random"}]}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/gameplay/admin/simulate", bytes.NewBufferString(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			gameplayServer := test.server
+			gameplayServer.HandleSimulationRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
 }
@@ -0,0 +1,277 @@
+package gameplay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// outbox replay related constants: the outbox is checked every outboxReplayPeriod, and at most outboxReplayBatch
+// updates are replayed per check (so a stats service which just came back is not flooded with them),
+// a failed replay is retried after outboxRetryBaseDelay, twice as long after each attempt, up to outboxRetryMaxDelay
+const (
+	outboxReplayPeriod   = time.Second
+	outboxReplayBatch    = 10
+	outboxRetryBaseDelay = time.Second
+	outboxRetryMaxDelay  = 5 * time.Minute
+)
+
+// outboxFileName is the name of the file the outbox entries are kept in, in the outbox directory
+const outboxFileName = "outbox.json"
+
+// OutboxEntry is a stats update which could not be sent to the stats service, waiting in the outbox to be replayed
+type OutboxEntry struct {
+	Namespace       string                `json:"namespace,omitempty"`
+	PlayerID        string                `json:"playerID"`
+	StatsDelta      data.PlayerLevelStats `json:"statsDelta"`
+	QueuedTime      int64                 `json:"queuedTime"`
+	Attempts        int32                 `json:"attempts"`
+	NextAttemptTime int64                 `json:"nextAttemptTime"`
+	LastError       string                `json:"lastError,omitempty"`
+}
+
+// statsOutbox holds the stats updates waiting to be replayed, every change to them is written to its file
+// (replays are done one check at a time, so an update is never replayed twice)
+type statsOutbox struct {
+	path    string
+	entries []*OutboxEntry
+	mutex   sync.Mutex
+
+	replayMutex sync.Mutex
+}
+
+// EnableOutboxFromEnv enables the stats outbox in the directory given by the outbox dir environment variable
+// (see constants.OutboxDirEnvVar), failed stats updates fail the level result as before if it is not set
+func (gs *Server) EnableOutboxFromEnv() error {
+
+	if gs == nil {
+		return serverNilError
+	}
+
+	dir := os.Getenv(constants.OutboxDirEnvVar)
+	if dir == "" {
+		return nil
+	}
+
+	return gs.EnableOutbox(dir)
+}
+
+// EnableOutbox makes stats updates which fail (synchronous or async ones) wait in an outbox kept in the given directory,
+// instead of failing the level result (which is then marked as stats pending). The updates already in the outbox
+// (from before a restart) are loaded, and are pending again till they are replayed (see StartPeriodicOutboxReplay).
+// Stats updates are not idempotent, so an update which timed out after the stats service applied it is counted twice
+func (gs *Server) EnableOutbox(dir string) error {
+
+	if gs == nil {
+		return serverNilError
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	outbox := &statsOutbox{path: filepath.Join(dir, outboxFileName), entries: []*OutboxEntry{}}
+
+	encoded, err := os.ReadFile(outbox.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		err = json.Unmarshal(encoded, &outbox.entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	gs.pendingStatsMutex.Lock()
+	gs.outbox = outbox
+	for _, entry := range outbox.entries {
+		gs.pendingStats[entry.PlayerID]++
+	}
+	gs.pendingStatsMutex.Unlock()
+
+	gs.logger.Printf("stats outbox enabled in %v, with %v updates to replay", dir, len(outbox.entries))
+	return nil
+}
+
+// StartPeriodicOutboxReplay starts replaying the stats updates in the outbox with the given period,
+// till the given context is done
+func (gs *Server) StartPeriodicOutboxReplay(ctx context.Context, checkPeriod time.Duration) {
+
+	if gs == nil {
+		return
+	}
+
+	ticker := time.NewTicker(checkPeriod)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				gs.replayOutbox(gs.clock.Now().UTC())
+			}
+		}
+	}()
+}
+
+// OutboxSize returns the number of stats updates waiting in the outbox
+func (gs *Server) OutboxSize() int64 {
+
+	outbox := gs.statsOutbox()
+	if outbox == nil {
+		return 0
+	}
+
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	return int64(len(outbox.entries))
+}
+
+// statsOutbox returns the stats outbox, or nil if it is not enabled
+func (gs *Server) statsOutbox() *statsOutbox {
+
+	gs.pendingStatsMutex.Lock()
+	defer gs.pendingStatsMutex.Unlock()
+
+	return gs.outbox
+}
+
+// addToOutbox puts the failed stats update in the outbox, marks it as pending, and returns whether it was added
+// (it is not when the outbox is disabled, or could not be written)
+func (gs *Server) addToOutbox(ctx context.Context, playerID string, statsDelta *data.PlayerLevelStats, statsErr error) bool {
+
+	outbox := gs.statsOutbox()
+	if outbox == nil {
+		return false
+	}
+
+	unixNow := gs.clock.Now().UTC().Unix()
+	entry := &OutboxEntry{
+		Namespace:       namespace.FromContext(ctx),
+		PlayerID:        playerID,
+		StatsDelta:      *statsDelta,
+		QueuedTime:      unixNow,
+		Attempts:        1,
+		NextAttemptTime: unixNow + int64(outboxRetryBaseDelay/time.Second),
+		LastError:       statsErr.Error(),
+	}
+
+	outbox.mutex.Lock()
+	outbox.entries = append(outbox.entries, entry)
+	err := outbox.write()
+	if err != nil {
+		outbox.entries = outbox.entries[:len(outbox.entries)-1]
+	}
+	outbox.mutex.Unlock()
+
+	if err != nil {
+		gs.logger.Printf("error: could not add the stats update of player id %v to the outbox: %v", playerID, err)
+		return false
+	}
+
+	gs.pendingStatsMutex.Lock()
+	gs.pendingStats[playerID]++
+	gs.pendingStatsMutex.Unlock()
+
+	gs.logger.Printf("the stats update of player id %v (level %v) failed, it will be replayed from the outbox: %v", playerID, statsDelta.Level, statsErr)
+	return true
+}
+
+// replayOutbox sends the stats updates in the outbox whose next attempt is due at the given time (up to outboxReplayBatch
+// of them) to the stats service, and returns the number which went through. The replays go through the stats client
+// like any other stats update, so each one is signed with a current service token. After a failed replay, the stats
+// service is most likely still unavailable, so the rest of the due updates wait for the next check
+func (gs *Server) replayOutbox(now time.Time) int {
+
+	outbox := gs.statsOutbox()
+	if outbox == nil {
+		return 0
+	}
+
+	outbox.replayMutex.Lock()
+	defer outbox.replayMutex.Unlock()
+
+	outbox.mutex.Lock()
+	due := []*OutboxEntry{}
+	for _, entry := range outbox.entries {
+		if len(due) < outboxReplayBatch && entry.NextAttemptTime <= now.Unix() {
+			due = append(due, entry)
+		}
+	}
+	outbox.mutex.Unlock()
+
+	replayed := 0
+	for _, entry := range due {
+
+		ctx := namespace.NewContext(context.Background(), entry.Namespace)
+		_, statsErr := gs.statsClient.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(ctx, entry.PlayerID), &entry.StatsDelta)
+
+		outbox.mutex.Lock()
+		if statsErr != nil {
+			entry.Attempts += 1
+			entry.NextAttemptTime = now.Add(outboxRetryDelay(entry.Attempts)).Unix()
+			entry.LastError = statsErr.Error()
+		} else {
+			outbox.entries = slices.DeleteFunc(outbox.entries, func(e *OutboxEntry) bool { return e == entry })
+		}
+		err := outbox.write()
+		outbox.mutex.Unlock()
+
+		if err != nil {
+			gs.logger.Printf("error: could not write the outbox: %v", err)
+		}
+
+		if statsErr != nil {
+			gs.logger.Printf("error: replaying the stats update of player id %v (level %v) failed (attempt %v): %v", entry.PlayerID, entry.StatsDelta.Level, entry.Attempts, statsErr)
+			break
+		}
+
+		gs.markStatsUpdateDone(entry.PlayerID)
+		replayed += 1
+	}
+
+	return replayed
+}
+
+// write writes the entries of the outbox to its file (via a temporary file, so a failed write never leaves a partial file),
+// it should be called with the outbox mutex held
+func (outbox *statsOutbox) write() error {
+
+	encoded, err := json.Marshal(outbox.entries)
+	if err != nil {
+		return err
+	}
+
+	tempPath := outbox.path + ".tmp"
+	err = os.WriteFile(tempPath, encoded, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, outbox.path)
+}
+
+// outboxRetryDelay returns how long to wait before replaying a stats update again after the given number of attempts
+func outboxRetryDelay(attempts int32) time.Duration {
+
+	if attempts > 20 {
+		return outboxRetryMaxDelay
+	}
+
+	return min(outboxRetryBaseDelay<<(attempts-1), outboxRetryMaxDelay)
+}
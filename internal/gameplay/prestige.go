@@ -0,0 +1,84 @@
+package gameplay
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"net/http"
+)
+
+// PrestigeRequestBody is used as the request body for the public prestige request
+type PrestigeRequestBody struct {
+	PlayerID string `json:"playerID"`
+}
+
+// PrestigeResponse is used as the client response for the prestige api, it holds the updated player data
+// (back at the default level, at the new prestige rank) and the reward multiplier of the new rank
+type PrestigeResponse struct {
+	Player           data.PlayerData `json:"playerData"`
+	RewardMultiplier float64         `json:"rewardMultiplier"`
+}
+
+// HandlePrestigeRequest takes a player at the last level back to the default level at the next prestige rank,
+// which multiplies the energy rewards of their level wins from then on, and counts the prestige in their stats
+func (gs *Server) HandlePrestigeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request body, which should be a PrestigeRequestBody struct
+	decodedReq := &PrestigeRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &decodedReq.PlayerID) {
+		return
+	}
+
+	gs.logger.Printf("prestige request for id: %v", decodedReq.PlayerID)
+
+	updatedPlayer, err := gs.profileClient.Prestige(r.Context(), decodedReq.PlayerID)
+	if err != nil {
+		errMsg := "prestige error: " + err.Error()
+		gs.logger.Println(errMsg)
+		switch err.(type) {
+		case profile.PrestigeNotAllowedErr:
+			apierror.Write(w, r, http.StatusConflict, apierror.CodePrestigeNotAllowed)
+		case data.PlayerNotFoundErr:
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		default:
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	// the player has prestiged at this point, so a failure to count it in the stats is logged rather than returned
+	err = gs.statsClient.RecordPrestige(r.Context(), decodedReq.PlayerID)
+	if err != nil {
+		gs.logger.Printf("error: could not record the prestige of player id %v in the stats: %v", decodedReq.PlayerID, err)
+	}
+
+	response := &PrestigeResponse{
+		Player:           *updatedPlayer,
+		RewardMultiplier: config.Config.Prestige.RewardMultiplier(updatedPlayer.PrestigeRank),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
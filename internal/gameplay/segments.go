@@ -0,0 +1,18 @@
+package gameplay
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+)
+
+// tunedSegment returns the segment whose energy tuning the given player plays with: the segment the profile service
+// put the player in, while the energy-segments experiment is on for them (and the default segment otherwise)
+func (gs *Server) tunedSegment(ctx context.Context, player *data.PlayerData) string {
+
+	if player.Segment == "" || !gs.flags.Enabled(ctx, config.FlagEnergySegments, player.PlayerID) {
+		return ""
+	}
+
+	return player.Segment
+}
@@ -0,0 +1,317 @@
+package gameplay
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/rng"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/pkg/rules"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// the ways a simulated player can pick the next level to play (like the bot profiles)
+const (
+	LevelChoiceHighest = "highest" // always the highest unlocked level
+	LevelChoiceRandom  = "random"  // any of the unlocked levels
+)
+
+// simulationLimits allow larger bodies than the default, since a simulation request can hold a whole game config
+var simulationLimits = middleware.RouteLimits{
+	Timeout:      constants.DefaultRequestTimeoutSeconds * time.Second,
+	MaxBodyBytes: 1024 * 1024, // 1 MB
+}
+
+// SimulatedProfile describes a group of simulated players who play the same way, each of them starts at the default
+// level (with no combo) and makes the given number of attempts, with unlimited energy (so the balance can be projected)
+type SimulatedProfile struct {
+	Name           string  `json:"name"`
+	Players        int32   `json:"players"`                // number of players following the profile
+	Attempts       int32   `json:"attempts"`               // attempts each player makes
+	LevelChoice    string  `json:"levelChoice"`            // how the next level is picked, see LevelChoiceHighest / LevelChoiceRandom
+	PracticeChance float64 `json:"practiceChance"`         // chance of an attempt being a practice one (between 0 and 1)
+	PrestigeRank   int32   `json:"prestigeRank,omitempty"` // the prestige rank of the players, for its reward multiplier
+	Segment        string  `json:"segment,omitempty"`      // the segment of the players, for its energy tuning
+}
+
+// SimulationRequest is used as the request body for the admin request to simulate a game config: the candidate config
+// (the current one, if it is left out), the simulated player population, and the seed of the dice (random, if 0)
+type SimulationRequest struct {
+	Config   *config.GameConfig `json:"config,omitempty"`
+	Profiles []SimulatedProfile `json:"profiles"`
+	Seed     uint64             `json:"seed,omitempty"`
+}
+
+// LevelProjection holds the projected results of a level: how often it is won, how many rolls a win takes on average,
+// and the energy spent entering it and earned winning it (the net energy is the earned energy minus the spent energy)
+type LevelProjection struct {
+	Level           int32   `json:"level"`
+	Attempts        int64   `json:"attempts"`
+	Wins            int64   `json:"wins"`
+	WinRate         float64 `json:"winRate"`
+	AverageWinRolls float64 `json:"averageWinRolls"`
+	EnergySpent     int64   `json:"energySpent"`
+	EnergyEarned    int64   `json:"energyEarned"`
+	NetEnergy       int64   `json:"netEnergy"`
+}
+
+// SimulationReport is the response to the admin request to simulate a game config, with the projection of every level,
+// the totals, and the average of the highest level the players reached. The seed reproduces the simulation
+type SimulationReport struct {
+	Seed                uint64            `json:"seed"`
+	Players             int64             `json:"players"`
+	Attempts            int64             `json:"attempts"`
+	WinRate             float64           `json:"winRate"`
+	EnergySpent         int64             `json:"energySpent"`
+	EnergyEarned        int64             `json:"energyEarned"`
+	NetEnergy           int64             `json:"netEnergy"`
+	NetEnergyPerAttempt float64           `json:"netEnergyPerAttempt"`
+	AverageLevelReached float64           `json:"averageLevelReached"`
+	Levels              []LevelProjection `json:"levels"`
+}
+
+// validate checks that the profile can be simulated
+func (sp *SimulatedProfile) validate() error {
+
+	if sp.Players <= 0 || sp.Attempts <= 0 {
+		return fmt.Errorf("profile %q: players and attempts should be positive", sp.Name)
+	}
+	if sp.LevelChoice != LevelChoiceHighest && sp.LevelChoice != LevelChoiceRandom {
+		return fmt.Errorf("profile %q: unknown level choice: %v", sp.Name, sp.LevelChoice)
+	}
+	if sp.PracticeChance < 0 || sp.PracticeChance > 1 {
+		return fmt.Errorf("profile %q: practice chance should be between 0 and 1", sp.Name)
+	}
+	if sp.PrestigeRank < 0 {
+		return fmt.Errorf("profile %q: prestige rank cannot be negative", sp.Name)
+	}
+
+	return nil
+}
+
+// Simulate runs a Monte Carlo simulation of the given player population playing with the given game config, through the
+// same rules as the level results (see rules.Evaluate), and returns the projected win rates and energy balance of every
+// level. Nothing is read or written: the players roll the dice of each level (with its face weights) till they hit the
+// target or run out of rolls, and pay the energy cost of each attempt (practice ones are free). The entry limits and the
+// dynamic difficulty are not simulated. It fails if the config is invalid, or the population makes too many attempts
+func Simulate(ctx context.Context, gameConfig *config.GameConfig, request *SimulationRequest) (*SimulationReport, error) {
+
+	_, span := tracing.Start(ctx, "gameplay.Simulate")
+	defer span.End()
+
+	err := gameConfig.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	if len(request.Profiles) == 0 {
+		return nil, fmt.Errorf("no profiles to simulate")
+	}
+
+	totalAttempts := int64(0)
+	for i := range request.Profiles {
+		err = request.Profiles[i].validate()
+		if err != nil {
+			return nil, err
+		}
+		totalAttempts += int64(request.Profiles[i].Players) * int64(request.Profiles[i].Attempts)
+	}
+	if totalAttempts > constants.MaxSimulatedAttempts {
+		return nil, fmt.Errorf("the profiles make %v attempts, at most %v can be simulated", totalAttempts, constants.MaxSimulatedAttempts)
+	}
+
+	seed := request.Seed
+	if seed == 0 {
+		seed = mathrand.Uint64()
+	}
+	random := rng.NewSeededRNG(seed)
+
+	levelCount := gameConfig.LevelCount()
+	levels := make([]LevelProjection, levelCount)
+	winRolls := make([]int64, levelCount)
+	for i := range levels {
+		levels[i].Level = int32(i) + 1
+	}
+
+	report := &SimulationReport{Seed: seed, Levels: levels}
+	levelsReached := int64(0)
+
+	for _, profile := range request.Profiles {
+		rewardMultipliers := []float64{gameConfig.Prestige.RewardMultiplier(profile.PrestigeRank), gameConfig.Segments.EnergyRewardMultiplier(profile.Segment)}
+
+		for range profile.Players {
+			player := rules.Player{Level: gameConfig.DefaultLevel}
+
+			for range profile.Attempts {
+				level := player.Level
+				if profile.LevelChoice == LevelChoiceRandom {
+					level = 1 + random.Int32N(player.Level)
+				}
+
+				levelConfig, ok := gameConfig.Level(level)
+				if !ok {
+					return nil, fmt.Errorf("level %v is missing from the config", level)
+				}
+
+				practice := random.Int32N(1_000_000) < int32(profile.PracticeChance*1_000_000)
+
+				outcome, err := rules.Evaluate(&rules.Attempt{
+					Level:             levelConfig.Rules(),
+					LevelCount:        levelCount,
+					Player:            player,
+					Rolls:             simulateRolls(random, levelConfig),
+					Practice:          practice,
+					RewardMultipliers: rewardMultipliers,
+					Bonuses:           gameConfig.Bonuses.Rules(),
+				})
+				if err != nil {
+					return nil, err
+				}
+
+				projection := &levels[level-1]
+				projection.Attempts++
+				if outcome.Won {
+					projection.Wins++
+					winRolls[level-1] += int64(outcome.Score)
+				}
+				if !practice {
+					projection.EnergySpent += int64(gameConfig.Segments.EnergyCost(profile.Segment, levelConfig.EnergyCost))
+					projection.EnergyEarned += int64(outcome.EnergyReward)
+				}
+
+				player.Level = outcome.NewLevel
+				player.ComboMultiplier = outcome.ComboMultiplier
+			}
+
+			report.Players++
+			levelsReached += int64(player.Level)
+		}
+	}
+
+	wins := int64(0)
+	for i := range levels {
+		projection := &levels[i]
+		projection.NetEnergy = projection.EnergyEarned - projection.EnergySpent
+		if projection.Attempts > 0 {
+			projection.WinRate = float64(projection.Wins) / float64(projection.Attempts)
+		}
+		if projection.Wins > 0 {
+			projection.AverageWinRolls = float64(winRolls[i]) / float64(projection.Wins)
+		}
+
+		report.Attempts += projection.Attempts
+		report.EnergySpent += projection.EnergySpent
+		report.EnergyEarned += projection.EnergyEarned
+		wins += projection.Wins
+	}
+
+	report.NetEnergy = report.EnergyEarned - report.EnergySpent
+	if report.Attempts > 0 {
+		report.WinRate = float64(wins) / float64(report.Attempts)
+		report.NetEnergyPerAttempt = float64(report.NetEnergy) / float64(report.Attempts)
+	}
+	if report.Players > 0 {
+		report.AverageLevelReached = float64(levelsReached) / float64(report.Players)
+	}
+
+	return report, nil
+}
+
+// simulateRolls rolls the dice of the given level (taking the face weights into account) till the target is hit,
+// or the player runs out of rolls, and returns the rolls
+func simulateRolls(random rng.RNG, levelConfig *config.LevelConfig) []int32 {
+
+	sides, count := levelConfig.Dice()
+
+	weights := make([]int32, sides)
+	totalWeight := int32(0)
+	for face := range sides {
+		weights[face] = 1
+		if levelConfig.FaceWeights != nil {
+			weights[face] = 0
+			if int(face) < len(levelConfig.FaceWeights) {
+				weights[face] = max(levelConfig.FaceWeights[face], 0)
+			}
+		}
+		totalWeight += weights[face]
+	}
+
+	rolls := []int32{}
+	for range levelConfig.TotalRolls {
+		sum := int32(0)
+		for range count {
+			pick := random.Int32N(totalWeight)
+			for face, weight := range weights {
+				if pick < weight {
+					sum += int32(face) + 1
+					break
+				}
+				pick -= weight
+			}
+		}
+
+		rolls = append(rolls, sum)
+		if sum == levelConfig.Target {
+			break
+		}
+	}
+
+	return rolls
+}
+
+// HandleSimulationRequest simulates the candidate game config in the request body (or the current config) with the
+// simulated player population in it (admin only), and responds with the projected results, see Simulate
+func (gs *Server) HandleSimulationRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	// decode the request body, which should be a SimulationRequest struct
+	decodedReq := &SimulationRequest{}
+	err = json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	gameConfig := decodedReq.Config
+	if gameConfig == nil {
+		gameConfig = config.Config
+	}
+
+	gs.logger.Printf("received simulation request, candidate config: %v, profiles: %v", decodedReq.Config != nil, len(decodedReq.Profiles))
+
+	report, err := Simulate(r.Context(), gameConfig, decodedReq)
+	if err != nil {
+		errMsg := "simulation error: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		errMsg := "error: could not encode simulation report: " + err.Error()
+		gs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,113 @@
+package gameplay
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// the gameplay actions which are throttled (the action names kept in the data service)
+const (
+	ThrottleActionEntry  = "level-entry"
+	ThrottleActionResult = "level-result"
+)
+
+// ThrottledResponse is the response to a gameplay request rejected by the throttle of its action,
+// with the (unix) time the player can try again
+type ThrottledResponse struct {
+	Code      apierror.Code `json:"code"`
+	Error     string        `json:"error"`
+	Action    string        `json:"action"`
+	RetryTime int64         `json:"retryTime"`
+}
+
+// EnableThrottlesFromEnv enables the throttles if the action throttles environment variable
+// (see constants.ActionThrottlesEnvVar) is set to true, the gameplay actions are not rate limited if it is not set
+func (gs *Server) EnableThrottlesFromEnv() error {
+
+	if gs == nil {
+		return serverNilError
+	}
+
+	throttlesEnv := os.Getenv(constants.ActionThrottlesEnvVar)
+	if throttlesEnv == "" {
+		return nil
+	}
+
+	enabled, err := strconv.ParseBool(throttlesEnv)
+	if err != nil {
+		return fmt.Errorf("%v should be true or false, got: %v", constants.ActionThrottlesEnvVar, throttlesEnv)
+	}
+
+	if enabled {
+		gs.EnableThrottles()
+	}
+	return nil
+}
+
+// EnableThrottles rate limits the level entries and level results of each player, with the limits in the throttles config.
+// The recent actions are kept in the data service, so the limits hold across gameplay instances
+func (gs *Server) EnableThrottles() {
+
+	if gs == nil {
+		return
+	}
+
+	gs.throttles = true
+	gs.logger.Println("action throttles enabled")
+}
+
+// throttleAction records the given action of the player with the data service, and returns the throttled error
+// if the throttle rejected it (or nil if it is allowed). The throttles are soft limits: they are skipped when they are
+// not enabled or have no limit, and other errors are logged rather than returned, so the action goes ahead
+func (gs *Server) throttleAction(ctx context.Context, playerID string, action string, throttle config.ActionThrottleConfig) *data.ActionThrottledErr {
+
+	if !gs.throttles || throttle.MaxActions <= 0 {
+		return nil
+	}
+
+	_, err := gs.dataClient.RecordAction(ctx, &data.ActionThrottle{
+		PlayerID:      playerID,
+		Action:        action,
+		Time:          gs.clock.Now().UTC().Unix(),
+		MaxActions:    throttle.MaxActions,
+		WindowSeconds: throttle.WindowSeconds,
+	})
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case data.ActionThrottledErr:
+		return &err
+	default:
+		gs.logger.Printf("error: could not check the %v throttle of player id %v, letting it through: %v", action, playerID, err)
+		return nil
+	}
+}
+
+// writeThrottledResponse responds to a gameplay request rejected by the throttle of its action
+func (gs *Server) writeThrottledResponse(w http.ResponseWriter, r *http.Request, throttledErr *data.ActionThrottledErr) {
+
+	gs.logger.Println("error: " + throttledErr.Error())
+
+	retryAfter := max(throttledErr.RetryTime-gs.clock.Now().UTC().Unix(), 1)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+	err := json.NewEncoder(w).Encode(&ThrottledResponse{
+		Code:      apierror.CodeActionThrottled,
+		Error:     apierror.Message(r, apierror.CodeActionThrottled, "{retryTime}", strconv.FormatInt(throttledErr.RetryTime, 10)),
+		Action:    throttledErr.Action,
+		RetryTime: throttledErr.RetryTime,
+	})
+	if err != nil {
+		gs.logger.Println("error: could not encode the throttled response: " + err.Error())
+	}
+}
@@ -0,0 +1,402 @@
+// Package guilds: service which lets players create, join and leave guilds, and sums up the wins
+// of the members of each guild this week, for the guild stats and the guild leaderboard
+
+package guilds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// the number of guilds on the leaderboard when the request does not set a limit, and the most it can ask for
+const defaultLeaderboardLimit = 10
+const maxLeaderboardLimit = 100
+
+// Guilds Specific Errors:
+var serverNilError = fmt.Errorf("provided guilds server pointer is nil")
+
+type InvalidGuildNameErr struct {
+	Name string
+}
+
+func (err InvalidGuildNameErr) Error() string {
+	return fmt.Sprintf("guild name: %q should have between 1 and %v characters", err.Name, constants.MaxGuildNameLength)
+}
+
+type CreateGuildRequestBody struct {
+	PlayerID string `json:"playerID"`
+	Name     string `json:"name"`
+}
+
+type JoinGuildRequestBody struct {
+	PlayerID string `json:"playerID"`
+	GuildID  string `json:"guildID"`
+}
+
+type LeaveGuildRequestBody struct {
+	PlayerID string `json:"playerID"`
+}
+
+// Server is the core guilds service provider
+type Server struct {
+	requestValidator validation.RequestValidator
+	dataClient       data.DataClient
+	profileClient    profile.ProfileClient
+
+	// the leaderboards computed recently, per namespace (see Leaderboard)
+	leaderboards      map[string]*Leaderboard
+	leaderboardsMutex sync.Mutex
+
+	logger *log.Logger
+}
+
+// NewServer returns an initialized pointer to the guilds server
+func NewServer(rv validation.RequestValidator, dc data.DataClient, pc profile.ProfileClient) *Server {
+	return &Server{
+		requestValidator: rv,
+		dataClient:       dc,
+		profileClient:    pc,
+
+		leaderboards:      map[string]*Leaderboard{},
+		leaderboardsMutex: sync.Mutex{},
+
+		logger: log.New(redact.Stdout, "guilds: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+	}
+}
+
+// Run runs a given guilds server on the given port
+func (gs *Server) Run(port string) {
+	log.Fatal(gs.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the guilds server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (gs *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return gs.serve(ctx, listener)
+}
+
+// RunWithListener runs the guilds server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (gs *Server) RunWithListener(listener net.Listener) error {
+	return gs.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the guilds server, and serves them on the given listener till the context is done
+func (gs *Server) serve(ctx context.Context, listener net.Listener) error {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("POST /guilds/create", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleCreateRequest), middleware.DefaultLimits))
+	mux.Handle("POST /guilds/join", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleJoinRequest), middleware.DefaultLimits))
+	mux.Handle("POST /guilds/leave", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleLeaveRequest), middleware.DefaultLimits))
+	mux.Handle("GET /guilds/roster/{id}", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleRosterRequest), middleware.DefaultLimits))
+	mux.Handle("GET /guilds/player-guild/{id}", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandlePlayerGuildRequest), middleware.DefaultLimits))
+	mux.Handle("GET /guilds/stats/{id}", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleGuildStatsRequest), middleware.DefaultLimits))
+	mux.Handle("GET /guilds/leaderboard", middleware.WithLimits(validation.WithSession(gs.requestValidator, gs.HandleLeaderboardRequest), middleware.DefaultLimits))
+
+	gs.logger.Println("the guilds server is up and running...")
+
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("guilds", middleware.WithAccessLog("guilds", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
+}
+
+// HandleCreateRequest creates a new guild, owned by the requesting player (who cannot be in a guild already),
+// and sends back the new guild
+func (gs *Server) HandleCreateRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	createRequest := &CreateGuildRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(createRequest)
+	if err != nil {
+		errMsg := "error: could not decode the create guild request: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &createRequest.PlayerID) {
+		return
+	}
+	gs.logger.Printf("request to create guild %q by player id %v", createRequest.Name, createRequest.PlayerID)
+
+	name := strings.TrimSpace(createRequest.Name)
+	if name == "" || utf8.RuneCountInString(name) > constants.MaxGuildNameLength {
+		errMsg := "error: could not create the guild: " + InvalidGuildNameErr{Name: createRequest.Name}.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidGuildName, "{maxLength}", strconv.Itoa(constants.MaxGuildNameLength))
+		return
+	}
+
+	// make sure the player exists before giving them a guild
+	_, err = gs.profileClient.GetPlayer(r.Context(), createRequest.PlayerID)
+	if err == nil {
+		var guild *data.GuildData
+		guild, err = gs.dataClient.CreateGuild(r.Context(), &data.GuildCreation{Name: name, OwnerID: createRequest.PlayerID, Time: time.Now().UTC().Unix()})
+		if err == nil {
+			gs.writeJSON(w, r, guild, "guild")
+			return
+		}
+	}
+
+	errMsg := "error: could not create the guild: " + err.Error()
+	gs.logger.Println(errMsg)
+	switch {
+	case errors.As(err, &data.PlayerNotFoundErr{}):
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+	case errors.As(err, &data.GuildNameTakenErr{}):
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeGuildNameTaken)
+	case errors.As(err, &data.AlreadyInGuildErr{}):
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeAlreadyInGuild)
+	default:
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandleJoinRequest adds the requesting player (who cannot be in a guild already) to the requested guild,
+// as long as it has room for them, and sends back the guild
+func (gs *Server) HandleJoinRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	joinRequest := &JoinGuildRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(joinRequest)
+	if err != nil {
+		errMsg := "error: could not decode the join guild request: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &joinRequest.PlayerID) {
+		return
+	}
+	gs.logger.Printf("request to join guild id %v by player id %v", joinRequest.GuildID, joinRequest.PlayerID)
+
+	// make sure the player exists before adding them to a guild
+	_, err = gs.profileClient.GetPlayer(r.Context(), joinRequest.PlayerID)
+	if err == nil {
+		var guild *data.GuildData
+		guild, err = gs.dataClient.JoinGuild(r.Context(), &data.GuildJoin{GuildID: joinRequest.GuildID, PlayerID: joinRequest.PlayerID, Time: time.Now().UTC().Unix(), MaxMembers: constants.MaxGuildMembers})
+		if err == nil {
+			gs.writeJSON(w, r, guild, "guild")
+			return
+		}
+	}
+
+	errMsg := "error: could not join the guild: " + err.Error()
+	gs.logger.Println(errMsg)
+	switch {
+	case errors.As(err, &data.PlayerNotFoundErr{}):
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+	case errors.As(err, &data.GuildNotFoundErr{}):
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeGuildNotFound)
+	case errors.As(err, &data.AlreadyInGuildErr{}):
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeAlreadyInGuild)
+	case errors.As(err, &data.GuildFullErr{}):
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeGuildFull)
+	default:
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandleLeaveRequest removes the requesting player from their guild, and sends back the guild they left
+// (a guild is deleted once its last member leaves, and the owner leaving passes it on to the longest standing member)
+func (gs *Server) HandleLeaveRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	leaveRequest := &LeaveGuildRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(leaveRequest)
+	if err != nil {
+		errMsg := "error: could not decode the leave guild request: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &leaveRequest.PlayerID) {
+		return
+	}
+	gs.logger.Printf("request to leave their guild by player id %v", leaveRequest.PlayerID)
+
+	guild, err := gs.dataClient.LeaveGuild(r.Context(), leaveRequest.PlayerID)
+	if err != nil {
+		errMsg := "error: could not leave the guild: " + err.Error()
+		gs.logger.Println(errMsg)
+		if errors.As(err, &data.NotInGuildErr{}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotInGuild)
+		} else {
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	gs.writeJSON(w, r, guild, "guild")
+}
+
+// HandleRosterRequest sends back the requested guild, with its member roster
+func (gs *Server) HandleRosterRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	guild, err := gs.dataClient.ReadGuild(r.Context(), r.PathValue("id"))
+	if err != nil {
+		errMsg := "error: could not read the guild: " + err.Error()
+		gs.logger.Println(errMsg)
+		if errors.As(err, &data.GuildNotFoundErr{}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeGuildNotFound)
+		} else {
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	gs.writeJSON(w, r, guild, "guild")
+}
+
+// HandlePlayerGuildRequest sends back the guild of the requested player (with its member roster)
+func (gs *Server) HandlePlayerGuildRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+
+	guild, err := gs.dataClient.ReadPlayerGuild(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not read the player's guild: " + err.Error()
+		gs.logger.Println(errMsg)
+		if errors.As(err, &data.NotInGuildErr{}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotInGuild)
+		} else {
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	gs.writeJSON(w, r, guild, "guild")
+}
+
+// HandleGuildStatsRequest sends back the wins of the members of the requested guild this week
+func (gs *Server) HandleGuildStatsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	guild, err := gs.dataClient.ReadGuild(r.Context(), r.PathValue("id"))
+	if err != nil {
+		errMsg := "error: could not read the guild: " + err.Error()
+		gs.logger.Println(errMsg)
+		if errors.As(err, &data.GuildNotFoundErr{}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeGuildNotFound)
+		} else {
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	guildStats, err := gs.WeeklyStats(r.Context(), guild, time.Now().UTC().Unix())
+	if err != nil {
+		errMsg := "DB read error: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	gs.writeJSON(w, r, guildStats, "guild stats")
+}
+
+// HandleLeaderboardRequest sends back the guilds with the most wins this week,
+// the 'limit' query parameter sets the number of entries (10 by default, up to 100)
+func (gs *Server) HandleLeaderboardRequest(w http.ResponseWriter, r *http.Request) {
+
+	if gs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	limit := defaultLeaderboardLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 || limit > maxLeaderboardLimit {
+			errMsg := fmt.Sprintf("error: invalid limit parameter, it should be between 1 and %v", maxLeaderboardLimit)
+			gs.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+			return
+		}
+	}
+
+	leaderboard, err := gs.Leaderboard(r.Context())
+	if err != nil {
+		errMsg := "DB read error: " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	response := *leaderboard
+	response.Entries = response.Entries[:min(limit, len(response.Entries))]
+	gs.writeJSON(w, r, &response, "guild leaderboard")
+}
+
+// writeJSON encodes the given value as the json response
+func (gs *Server) writeJSON(w http.ResponseWriter, r *http.Request, v any, kind string) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		errMsg := "error: could not encode " + kind + ": " + err.Error()
+		gs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
@@ -0,0 +1,407 @@
+package guilds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/testsetup"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewGuildsServer(t *testing.T) {
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+
+	gs := NewServer(authServer, dataServer, profile.NewServer(authServer, dataServer))
+
+	if gs == nil {
+		t.Fatal("new guilds server should not return a nil server pointer")
+	}
+}
+
+func TestServer_HandleMembershipRequests(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	gs := NewServer(as, dataServer, profileServer)
+
+	for _, playerID := range []string{"player1", "player2", "player3"} {
+		_, err = testsetup.SetupTestProfile(playerID, sID, profileServer.HandleNewPlayerRequest)
+		if err != nil {
+			t.Fatal("profile setup error: " + err.Error())
+		}
+	}
+
+	// the session of each test player is their own (session1 of player1 and so on)
+	sessions := testsetup.Sessions{"session1": "player1", "session2": "player2", "session3": "player3", "session9": "player9"}
+
+	guildID := ""
+
+	tests := []struct {
+		name        string
+		server      *Server
+		sessionID   string
+		path        string
+		body        func() any
+		wantStatus  int
+		wantOwner   string
+		wantMembers int
+	}{
+		{"nil server", nil, "", "/guilds/create", nil, http.StatusInternalServerError, "", 0},
+		{"blank session id", gs, "", "/guilds/create", nil, http.StatusUnauthorized, "", 0},
+		{"blank name", gs, "session1", "/guilds/create", func() any { return &CreateGuildRequestBody{PlayerID: "player1", Name: "  "} }, http.StatusBadRequest, "", 0},
+		{"long name", gs, "session1", "/guilds/create", func() any { return &CreateGuildRequestBody{PlayerID: "player1", Name: strings.Repeat("a", 25)} }, http.StatusBadRequest, "", 0},
+		{"unknown player", gs, "session9", "/guilds/create", func() any { return &CreateGuildRequestBody{PlayerID: "player9", Name: "Rollers"} }, http.StatusNotFound, "", 0},
+		{"create for another player", gs, "session2", "/guilds/create", func() any { return &CreateGuildRequestBody{PlayerID: "player1", Name: "Rollers"} }, http.StatusForbidden, "", 0},
+		{"create", gs, "session1", "/guilds/create", func() any { return &CreateGuildRequestBody{PlayerID: "player1", Name: "Rollers"} }, http.StatusOK, "player1", 1},
+		{"name taken", gs, "session2", "/guilds/create", func() any { return &CreateGuildRequestBody{PlayerID: "player2", Name: "ROLLERS"} }, http.StatusConflict, "", 0},
+		{"unknown guild", gs, "session2", "/guilds/join", func() any { return &JoinGuildRequestBody{PlayerID: "player2", GuildID: "guild-0"} }, http.StatusNotFound, "", 0},
+		{"join for another player", gs, "session1", "/guilds/join", func() any { return &JoinGuildRequestBody{PlayerID: "player2", GuildID: guildID} }, http.StatusForbidden, "", 0},
+		{"join", gs, "session2", "/guilds/join", func() any { return &JoinGuildRequestBody{PlayerID: "player2", GuildID: guildID} }, http.StatusOK, "player1", 2},
+		{"join again", gs, "session2", "/guilds/join", func() any { return &JoinGuildRequestBody{PlayerID: "player2", GuildID: guildID} }, http.StatusConflict, "", 0},
+		{"leave for another player", gs, "session2", "/guilds/leave", func() any { return &LeaveGuildRequestBody{PlayerID: "player1"} }, http.StatusForbidden, "", 0},
+		{"owner leaves", gs, "session1", "/guilds/leave", func() any { return &LeaveGuildRequestBody{PlayerID: "player1"} }, http.StatusOK, "player2", 1},
+		{"leave again", gs, "session1", "/guilds/leave", func() any { return &LeaveGuildRequestBody{PlayerID: "player1"} }, http.StatusNotFound, "", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			if test.body != nil {
+				err2 := json.NewEncoder(buf).Encode(test.body())
+				if err2 != nil {
+					t.Fatal("could not encode the request body: " + err2.Error())
+				}
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, test.path, buf)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			guildsServer := test.server
+			switch test.path {
+			case "/guilds/create":
+				testsetup.WithSession(guildsServer, sessions, guildsServer.HandleCreateRequest)(respRec, newReq)
+			case "/guilds/join":
+				testsetup.WithSession(guildsServer, sessions, guildsServer.HandleJoinRequest)(respRec, newReq)
+			case "/guilds/leave":
+				testsetup.WithSession(guildsServer, sessions, guildsServer.HandleLeaveRequest)(respRec, newReq)
+			}
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotGuild := &data.GuildData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotGuild)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotGuild.OwnerID != test.wantOwner || len(gotGuild.Members) != test.wantMembers {
+					t.Errorf("handler gave incorrect results, want owner: %v and %v members, got: %+v", test.wantOwner, test.wantMembers, gotGuild)
+				}
+				guildID = gotGuild.GuildID
+			}
+		})
+	}
+}
+
+func TestServer_HandleRosterRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	gs := NewServer(as, dataServer, profile.NewServer(as, dataServer))
+
+	guild, err := dataServer.CreateGuild(context.Background(), &data.GuildCreation{Name: "Rollers", OwnerID: "player1", Time: 100})
+	if err != nil {
+		t.Fatal("guild setup error: " + err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		sessionID  string
+		guildID    string
+		wantStatus int
+	}{
+		{"blank session id", "", guild.GuildID, http.StatusUnauthorized},
+		{"unknown guild", sID, "guild-0", http.StatusNotFound},
+		{"valid guild", sID, guild.GuildID, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/guilds/roster/"+test.guildID, nil)
+			newReq.SetPathValue("id", test.guildID)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			testsetup.WithSession(gs, as, gs.HandleRosterRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotGuild := &data.GuildData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotGuild)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotGuild, guild) {
+					t.Errorf("handler gave incorrect results, want: %+v, got: %+v", guild, gotGuild)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandlePlayerGuildRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	gs := NewServer(as, dataServer, profile.NewServer(as, dataServer))
+
+	guild, err := dataServer.CreateGuild(context.Background(), &data.GuildCreation{Name: "Rollers", OwnerID: "player1", Time: 100})
+	if err != nil {
+		t.Fatal("guild setup error: " + err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
+	tests := []struct {
+		name       string
+		sessionID  string
+		playerID   string
+		wantStatus int
+	}{
+		{"blank session id", "", "player1", http.StatusUnauthorized},
+		{"another player", sID, "player2", http.StatusForbidden},
+		{"not in a guild", "session2", "player2", http.StatusNotFound},
+		{"guild member", sID, "player1", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/guilds/player-guild/"+test.playerID, nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			testsetup.WithSession(gs, sessions, gs.HandlePlayerGuildRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotGuild := &data.GuildData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotGuild)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotGuild, guild) {
+					t.Errorf("handler gave incorrect results, want: %+v, got: %+v", guild, gotGuild)
+				}
+			}
+		})
+	}
+}
+
+func TestWeekStart(t *testing.T) {
+
+	monday := time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC).Unix()
+
+	tests := []struct {
+		name     string
+		unixTime int64
+		want     int64
+	}{
+		{"monday midnight", monday, monday},
+		{"thursday", time.Date(2026, time.October, 15, 13, 30, 0, 0, time.UTC).Unix(), monday},
+		{"sunday night", time.Date(2026, time.October, 18, 23, 59, 59, 0, time.UTC).Unix(), monday},
+		{"next monday", time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC).Unix(), monday + 7*24*60*60},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := WeekStart(test.unixTime); got != test.want {
+				t.Errorf("WeekStart() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_WeeklyStatsAndLeaderboard(t *testing.T) {
+
+	dataServer := data.NewServer()
+	gs := NewServer(nil, dataServer, nil)
+
+	ctx := context.Background()
+	now := time.Now().UTC().Unix()
+	weekStart := WeekStart(now)
+
+	// player1 founded the first guild before this week, player2 joined it just now,
+	// and player3 has a guild of their own
+	rollers, err := dataServer.CreateGuild(ctx, &data.GuildCreation{Name: "Rollers", OwnerID: "player1", Time: weekStart - 100})
+	if err != nil {
+		t.Fatal("guild setup error: " + err.Error())
+	}
+	rollers, err = dataServer.JoinGuild(ctx, &data.GuildJoin{GuildID: rollers.GuildID, PlayerID: "player2", Time: now})
+	if err != nil {
+		t.Fatal("guild setup error: " + err.Error())
+	}
+	dicers, err := dataServer.CreateGuild(ctx, &data.GuildCreation{Name: "Dicers", OwnerID: "player3", Time: weekStart - 100})
+	if err != nil {
+		t.Fatal("guild setup error: " + err.Error())
+	}
+
+	attempts := []data.AttemptRecord{
+		{PlayerID: "player1", Level: 1, Won: true, Time: weekStart - 10}, // last week
+		{PlayerID: "player1", Level: 1, Won: true, Time: weekStart + 10},
+		{PlayerID: "player1", Level: 2, Won: false, Time: weekStart + 20},
+		{PlayerID: "player2", Level: 1, Won: true, Time: now - 10}, // before joining
+		{PlayerID: "player2", Level: 2, Won: true, Time: now},
+		{PlayerID: "player3", Level: 1, Won: true, Time: weekStart + 10},
+		{PlayerID: "player3", Level: 2, Won: true, Time: weekStart + 20},
+		{PlayerID: "player3", Level: 3, Won: true, Time: weekStart + 30},
+	}
+	for i := range attempts {
+		err = dataServer.WriteAttempt(ctx, &attempts[i])
+		if err != nil {
+			t.Fatal("attempt setup error: " + err.Error())
+		}
+	}
+
+	guildStats, err := gs.WeeklyStats(ctx, rollers, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStats := &GuildStats{
+		GuildID:   rollers.GuildID,
+		Name:      "Rollers",
+		WeekStart: weekStart,
+		TotalWins: 2,
+		Members:   []MemberWins{{PlayerID: "player1", Wins: 1}, {PlayerID: "player2", Wins: 1}},
+	}
+	if !reflect.DeepEqual(guildStats, wantStats) {
+		t.Errorf("WeeklyStats() gave incorrect results, want: %+v, got: %+v", wantStats, guildStats)
+	}
+
+	leaderboard, err := gs.Leaderboard(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantEntries := []LeaderboardEntry{
+		{Rank: 1, GuildID: dicers.GuildID, Name: "Dicers", MemberCount: 1, TotalWins: 3},
+		{Rank: 2, GuildID: rollers.GuildID, Name: "Rollers", MemberCount: 2, TotalWins: 2},
+	}
+	if !reflect.DeepEqual(leaderboard.Entries, wantEntries) {
+		t.Errorf("Leaderboard() gave incorrect results, want: %+v, got: %+v", wantEntries, leaderboard.Entries)
+	}
+
+	// the leaderboard is cached for a while
+	err = dataServer.WriteAttempt(ctx, &data.AttemptRecord{PlayerID: "player1", Level: 2, Won: true, Time: now})
+	if err != nil {
+		t.Fatal("attempt setup error: " + err.Error())
+	}
+	cached, err := gs.Leaderboard(ctx)
+	if err != nil || cached != leaderboard {
+		t.Errorf("Leaderboard() gave incorrect results, want the cached leaderboard, got: %+v (error: %v)", cached, err)
+	}
+}
+
+func TestServer_HandleLeaderboardRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	gs := NewServer(as, dataServer, profile.NewServer(as, dataServer))
+
+	for i := range 3 {
+		_, err = dataServer.CreateGuild(context.Background(), &data.GuildCreation{Name: fmt.Sprintf("Guild %v", i), OwnerID: fmt.Sprintf("player%v", i), Time: 100})
+		if err != nil {
+			t.Fatal("guild setup error: " + err.Error())
+		}
+	}
+
+	tests := []struct {
+		name        string
+		sessionID   string
+		query       string
+		wantStatus  int
+		wantEntries int
+	}{
+		{"blank session id", "", "", http.StatusUnauthorized, 0},
+		{"invalid limit", sID, "?limit=0", http.StatusBadRequest, 0},
+		{"limit too high", sID, "?limit=101", http.StatusBadRequest, 0},
+		{"default limit", sID, "", http.StatusOK, 3},
+		{"limit", sID, "?limit=2", http.StatusOK, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/guilds/leaderboard"+test.query, nil)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			testsetup.WithSession(gs, as, gs.HandleLeaderboardRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotLeaderboard := &Leaderboard{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotLeaderboard)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if len(gotLeaderboard.Entries) != test.wantEntries {
+					t.Errorf("handler gave incorrect results, want: %v entries, got: %v", test.wantEntries, len(gotLeaderboard.Entries))
+				}
+			}
+		})
+	}
+}
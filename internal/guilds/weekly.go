@@ -0,0 +1,158 @@
+package guilds
+
+import (
+	"cmp"
+	"context"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"slices"
+	"time"
+)
+
+// MemberWins is the number of levels a guild member won this week (while in the guild)
+type MemberWins struct {
+	PlayerID string `json:"playerID"`
+	Wins     int32  `json:"wins"`
+}
+
+// GuildStats sums up the wins of the members of a guild this week, the week starts on monday (UTC)
+type GuildStats struct {
+	GuildID   string       `json:"guildID"`
+	Name      string       `json:"name"`
+	WeekStart int64        `json:"weekStart"`
+	TotalWins int32        `json:"totalWins"`
+	Members   []MemberWins `json:"members"`
+}
+
+// LeaderboardEntry is a single guild on the guild leaderboard
+type LeaderboardEntry struct {
+	Rank        int32  `json:"rank"`
+	GuildID     string `json:"guildID"`
+	Name        string `json:"name"`
+	MemberCount int32  `json:"memberCount"`
+	TotalWins   int32  `json:"totalWins"`
+}
+
+// Leaderboard ranks the guilds by the total wins of their members this week (most wins first)
+type Leaderboard struct {
+	WeekStart  int64              `json:"weekStart"`
+	Entries    []LeaderboardEntry `json:"entries"`
+	ComputedAt int64              `json:"computedAt"`
+}
+
+// WeekStart returns the start of the week (monday 00:00 UTC) the given unix time is in
+func WeekStart(unixTime int64) int64 {
+
+	t := time.Unix(unixTime, 0).UTC()
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+
+	return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC).Unix()
+}
+
+// WeeklyStats returns the wins of the members of the given guild in the week of the given unix time, based on their
+// attempt histories. Only the wins since a member joined the guild count, so players cannot carry their wins
+// from guild to guild
+func (gs *Server) WeeklyStats(ctx context.Context, guild *data.GuildData, unixNow int64) (*GuildStats, error) {
+
+	if gs == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "guilds.WeeklyStats")
+	defer span.End()
+
+	weekStart := WeekStart(unixNow)
+	guildStats := &GuildStats{GuildID: guild.GuildID, Name: guild.Name, WeekStart: weekStart, Members: []MemberWins{}}
+
+	for _, member := range guild.Members {
+		attempts, err := gs.dataClient.ReadAttempts(ctx, member.PlayerID)
+		if err != nil {
+			return nil, err
+		}
+
+		since := max(weekStart, member.JoinTime)
+		wins := int32(0)
+		for _, attempt := range attempts {
+			if attempt.Won && attempt.Time >= since {
+				wins++
+			}
+		}
+
+		guildStats.Members = append(guildStats.Members, MemberWins{PlayerID: member.PlayerID, Wins: wins})
+		guildStats.TotalWins += wins
+	}
+
+	return guildStats, nil
+}
+
+// Leaderboard returns all the guilds ranked by the wins of their members this week, a cached leaderboard
+// is returned if it was computed less than GuildLeaderboardCacheSeconds ago (in the same week)
+func (gs *Server) Leaderboard(ctx context.Context) (*Leaderboard, error) {
+
+	if gs == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "guilds.Leaderboard")
+	defer span.End()
+
+	unixNow := time.Now().UTC().Unix()
+	requestNamespace := namespace.FromContext(ctx)
+
+	gs.leaderboardsMutex.Lock()
+	cached, ok := gs.leaderboards[requestNamespace]
+	gs.leaderboardsMutex.Unlock()
+
+	if ok && unixNow-cached.ComputedAt < constants.GuildLeaderboardCacheSeconds && cached.WeekStart == WeekStart(unixNow) {
+		return cached, nil
+	}
+
+	leaderboard, err := gs.computeLeaderboard(ctx, unixNow)
+	if err != nil {
+		return nil, err
+	}
+
+	gs.leaderboardsMutex.Lock()
+	gs.leaderboards[requestNamespace] = leaderboard
+	gs.leaderboardsMutex.Unlock()
+
+	return leaderboard, nil
+}
+
+// computeLeaderboard ranks all the guilds by the wins of their members in the week of the given unix time,
+// guilds with the same wins are ranked by their ids, so the order is stable
+func (gs *Server) computeLeaderboard(ctx context.Context, unixNow int64) (*Leaderboard, error) {
+
+	guilds, err := gs.dataClient.ListGuilds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leaderboard := &Leaderboard{WeekStart: WeekStart(unixNow), Entries: []LeaderboardEntry{}, ComputedAt: unixNow}
+
+	for i := range guilds {
+		guildStats, err := gs.WeeklyStats(ctx, &guilds[i], unixNow)
+		if err != nil {
+			return nil, err
+		}
+
+		leaderboard.Entries = append(leaderboard.Entries, LeaderboardEntry{
+			GuildID:     guilds[i].GuildID,
+			Name:        guilds[i].Name,
+			MemberCount: int32(len(guilds[i].Members)),
+			TotalWins:   guildStats.TotalWins,
+		})
+	}
+
+	slices.SortFunc(leaderboard.Entries, func(a, b LeaderboardEntry) int {
+		return cmp.Or(cmp.Compare(b.TotalWins, a.TotalWins), cmp.Compare(a.GuildID, b.GuildID))
+	})
+
+	for i := range leaderboard.Entries {
+		leaderboard.Entries[i].Rank = int32(i + 1)
+	}
+
+	return leaderboard, nil
+}
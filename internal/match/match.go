@@ -0,0 +1,746 @@
+// Package match: service for head-to-head dice matches, players queue up and get paired,
+// both roll for the same target (asynchronously), and the winner gets bonus rewards
+
+package match
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/rng"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"example.com/dice-game-backend/internal/stats"
+	"example.com/dice-game-backend/internal/webhooks"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how often the match sweeper looks for timed out matches and queue entries
+const matchSweepPeriod time.Duration = 10 * time.Second
+
+// Match Specific Errors:
+var serverNilError = fmt.Errorf("provided match server pointer is nil")
+
+type MatchNotFoundErr struct {
+	MatchID  string
+	PlayerID string
+}
+
+func (err MatchNotFoundErr) Error() string {
+	return fmt.Sprintf("no active match: %v found for player id: %v", err.MatchID, err.PlayerID)
+}
+
+type NotQueuedErr struct {
+	PlayerID string
+}
+
+func (err NotQueuedErr) Error() string {
+	return fmt.Sprintf("player id: %v is not in the match queue", err.PlayerID)
+}
+
+var alreadySubmittedError = fmt.Errorf("rolls have already been submitted for this match")
+
+// the states a match goes through: it starts as playing (when the players are paired),
+// and becomes complete once both players submit their rolls, or once the timeout passes
+// (in which case a player who submitted wins by forfeit, and if neither did, the match has expired)
+const (
+	MatchStatePlaying  = "playing"
+	MatchStateComplete = "complete"
+	MatchStateExpired  = "expired"
+)
+
+// the statuses of a player as far as the match service is concerned
+const (
+	QueueStatusIdle    = "idle"
+	QueueStatusQueued  = "queued"
+	QueueStatusMatched = "matched"
+)
+
+type QueueRequestBody struct {
+	PlayerID string `json:"playerID"`
+}
+
+// QueueResponse holds the status of the player, and their current (or just finished) match when matched
+type QueueResponse struct {
+	Status string `json:"status"`
+	Match  *Match `json:"match,omitempty"`
+}
+
+type MatchResultRequestBody struct {
+	PlayerID string  `json:"playerID"`
+	MatchID  string  `json:"matchID"`
+	Rolls    []int32 `json:"rolls"`
+}
+
+// MatchPlayer holds the rolls of one of the players in a match,
+// the score is the number of rolls it took to hit the target (0 if it was missed)
+type MatchPlayer struct {
+	PlayerID  string  `json:"playerID"`
+	Submitted bool    `json:"submitted"`
+	Rolls     []int32 `json:"rolls"`
+	Score     int32   `json:"score"`
+}
+
+type Match struct {
+	MatchID    string         `json:"matchID"`
+	State      string         `json:"state"`
+	Target     int32          `json:"target"`
+	TotalRolls int32          `json:"totalRolls"`
+	Players    [2]MatchPlayer `json:"players"`
+	WinnerID   string         `json:"winnerID"`
+	Forfeit    bool           `json:"forfeit"`
+	StartTime  int64          `json:"startTime"`
+	Deadline   int64          `json:"deadline"`
+}
+
+// queueEntry is a player waiting in the match queue since the given unix time
+type queueEntry struct {
+	playerID  string
+	queueTime int64
+}
+
+// Server is the core match service provider
+type Server struct {
+	requestValidator validation.RequestValidator
+	dataClient       data.DataClient
+	profileClient    profile.ProfileClient
+	statsClient      stats.StatsClient
+
+	// the queue, the matches, and the match each player is currently in (or last finished) are guarded by the same mutex
+	queue         []queueEntry
+	matches       map[string]*Match
+	playerMatches map[string]string
+	matchesMutex  sync.Mutex
+
+	// generates the match targets
+	random rng.RNG
+
+	// when webhooks are enabled, finished matches are published to them (see EnableWebhooks)
+	webhookClient webhooks.WebhookClient
+
+	// how often timed out matches and stale queue entries are swept, see SetSweepPeriod
+	sweepPeriod time.Duration
+
+	logger *log.Logger
+}
+
+// NewServer returns an initialized pointer to the match server
+func NewServer(rv validation.RequestValidator, dc data.DataClient, pc profile.ProfileClient, sc stats.StatsClient) *Server {
+	return &Server{
+		requestValidator: rv,
+		dataClient:       dc,
+		profileClient:    pc,
+		statsClient:      sc,
+
+		queue:         []queueEntry{},
+		matches:       map[string]*Match{},
+		playerMatches: map[string]string{},
+		matchesMutex:  sync.Mutex{},
+
+		random: rng.NewCryptoRNG(),
+
+		sweepPeriod: matchSweepPeriod,
+
+		logger: log.New(redact.Stdout, "match: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+	}
+}
+
+// SetSweepPeriod changes how often timed out matches and stale queue entries are swept (used by the startup config), it should be called before Run
+func (ms *Server) SetSweepPeriod(period time.Duration) {
+
+	if ms == nil || period <= 0 {
+		return
+	}
+
+	ms.sweepPeriod = period
+}
+
+// EnableSeededRNGFromEnv switches the match targets to a seeded (reproducible) random number generator
+// if the seed environment variable is set (see constants.RNGSeedEnvVar)
+func (ms *Server) EnableSeededRNGFromEnv() error {
+
+	if ms == nil {
+		return serverNilError
+	}
+
+	seedEnv := os.Getenv(constants.RNGSeedEnvVar)
+	if seedEnv == "" {
+		return nil
+	}
+
+	seed, err := strconv.ParseUint(seedEnv, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%v should be a non negative number, got: %v", constants.RNGSeedEnvVar, seedEnv)
+	}
+
+	ms.logger.Printf("using a seeded random number generator, seed: %v", seed)
+	ms.random = rng.NewSeededRNG(seed)
+	return nil
+}
+
+// EnableWebhooks makes the server publish every finished match (complete, not expired) to the webhooks subscribed to it
+func (ms *Server) EnableWebhooks(wc webhooks.WebhookClient) {
+
+	if ms == nil {
+		return
+	}
+
+	ms.webhookClient = wc
+}
+
+// Run runs a given match server on the given port
+func (ms *Server) Run(port string) {
+	log.Fatal(ms.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the match server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (ms *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ms.serve(ctx, listener)
+}
+
+// RunWithListener runs the match server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (ms *Server) RunWithListener(listener net.Listener) error {
+	return ms.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the match server, and serves them on the given listener till the context is done
+func (ms *Server) serve(ctx context.Context, listener net.Listener) error {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("POST /match/queue", middleware.WithLimits(validation.WithSession(ms.requestValidator, ms.HandleQueueRequest), middleware.DefaultLimits))
+	mux.Handle("DELETE /match/queue/{id}", middleware.WithLimits(validation.WithSession(ms.requestValidator, ms.HandleLeaveQueueRequest), middleware.DefaultLimits))
+	mux.Handle("GET /match/status/{id}", middleware.WithLimits(validation.WithSession(ms.requestValidator, ms.HandleStatusRequest), middleware.DefaultLimits))
+	mux.Handle("POST /match/result", middleware.WithLimits(validation.WithSession(ms.requestValidator, ms.HandleMatchResultRequest), middleware.DefaultLimits))
+
+	ms.StartPeriodicMatchSweep(ctx, ms.sweepPeriod)
+
+	ms.logger.Println("the match server is up and running...")
+
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("match", middleware.WithAccessLog("match", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
+}
+
+// HandleQueueRequest puts the player in the match queue, or pairs them with a player who is already waiting,
+// if the player is already queued or in a match, that status is sent back instead
+func (ms *Server) HandleQueueRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ms == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	queueRequest := &QueueRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(queueRequest)
+	if err != nil {
+		errMsg := "error: could not decode the queue request: " + err.Error()
+		ms.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &queueRequest.PlayerID) {
+		return
+	}
+	ms.logger.Printf("request to queue for a match by player id %v", queueRequest.PlayerID)
+
+	// make a request to the profile service to make sure the player exists
+	_, err = ms.profileClient.GetPlayer(r.Context(), queueRequest.PlayerID)
+	if err != nil {
+		errMsg := "get player error: " + err.Error()
+		ms.logger.Println(errMsg)
+		if errors.Is(err, data.PlayerNotFoundErr{PlayerID: queueRequest.PlayerID}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		} else {
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	response, err := ms.queuePlayer(queueRequest.PlayerID, time.Now().UTC().Unix())
+	if err != nil {
+		errMsg := "error: could not queue the player: " + err.Error()
+		ms.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	ms.writeResponse(w, r, response)
+}
+
+// HandleLeaveQueueRequest takes the player out of the match queue
+func (ms *Server) HandleLeaveQueueRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ms == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+	ms.logger.Printf("request to leave the match queue by player id %v", id)
+
+	ms.matchesMutex.Lock()
+	defer ms.matchesMutex.Unlock()
+
+	index := slices.IndexFunc(ms.queue, func(entry queueEntry) bool { return entry.playerID == id })
+	if index < 0 {
+		errMsg := "error: " + NotQueuedErr{id}.Error()
+		ms.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		return
+	}
+	ms.queue = slices.Delete(ms.queue, index, index+1)
+
+	ms.writeResponse(w, r, &QueueResponse{Status: QueueStatusIdle})
+}
+
+// HandleStatusRequest sends back whether the player is queued or matched (with the match, redacted for them)
+func (ms *Server) HandleStatusRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ms == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+
+	ms.matchesMutex.Lock()
+	defer ms.matchesMutex.Unlock()
+
+	ms.writeResponse(w, r, ms.status(id))
+}
+
+// HandleMatchResultRequest checks the rolls the player made in their match, and sends back the match,
+// if the opponent has already submitted their rolls, the match is decided and the winner rewarded
+func (ms *Server) HandleMatchResultRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ms == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	resultRequest := &MatchResultRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(resultRequest)
+	if err != nil {
+		errMsg := "error: could not decode the match result request: " + err.Error()
+		ms.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &resultRequest.PlayerID) {
+		return
+	}
+	ms.logger.Printf("match result for match %v by player id %v", resultRequest.MatchID, resultRequest.PlayerID)
+
+	match, finished, err := ms.submitRolls(resultRequest, time.Now().UTC().Unix())
+	if err != nil {
+		errMsg := "error: could not submit the rolls: " + err.Error()
+		ms.logger.Println(errMsg)
+		switch {
+		case errors.As(err, &MatchNotFoundErr{}):
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		case errors.Is(err, alreadySubmittedError):
+			apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict)
+		default:
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		}
+		return
+	}
+
+	if finished {
+		ms.finishMatch(r.Context(), match)
+	}
+
+	ms.writeResponse(w, r, &QueueResponse{Status: QueueStatusMatched, Match: match.viewFor(resultRequest.PlayerID)})
+}
+
+// queuePlayer pairs the player with the player waiting the longest in the queue (if any), or adds them to the queue
+func (ms *Server) queuePlayer(playerID string, unixNow int64) (*QueueResponse, error) {
+
+	ms.matchesMutex.Lock()
+	defer ms.matchesMutex.Unlock()
+
+	// already queued, or in a match which is still being played
+	status := ms.status(playerID)
+	if status.Status == QueueStatusQueued || (status.Match != nil && status.Match.State == MatchStatePlaying) {
+		return status, nil
+	}
+
+	if len(ms.queue) == 0 {
+		ms.queue = append(ms.queue, queueEntry{playerID: playerID, queueTime: unixNow})
+		return &QueueResponse{Status: QueueStatusQueued}, nil
+	}
+
+	opponent := ms.queue[0]
+	ms.queue = ms.queue[1:]
+
+	match, err := newMatch(ms.random, opponent.playerID, playerID, unixNow)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.logger.Printf("starting match %v between player ids %v and %v", match.MatchID, opponent.playerID, playerID)
+
+	ms.matches[match.MatchID] = match
+	ms.playerMatches[opponent.playerID] = match.MatchID
+	ms.playerMatches[playerID] = match.MatchID
+
+	return &QueueResponse{Status: QueueStatusMatched, Match: match.viewFor(playerID)}, nil
+}
+
+// status returns whether the player is queued, or matched (with their current, or last finished match),
+// the matches mutex needs to be held by the caller
+func (ms *Server) status(playerID string) *QueueResponse {
+
+	if slices.ContainsFunc(ms.queue, func(entry queueEntry) bool { return entry.playerID == playerID }) {
+		return &QueueResponse{Status: QueueStatusQueued}
+	}
+
+	match, ok := ms.matches[ms.playerMatches[playerID]]
+	if !ok {
+		return &QueueResponse{Status: QueueStatusIdle}
+	}
+
+	return &QueueResponse{Status: QueueStatusMatched, Match: match.viewFor(playerID)}
+}
+
+// submitRolls validates and stores the rolls of the player in their current match,
+// it returns a copy of the match, and whether this submission finished it
+func (ms *Server) submitRolls(request *MatchResultRequestBody, unixNow int64) (*Match, bool, error) {
+
+	ms.matchesMutex.Lock()
+	defer ms.matchesMutex.Unlock()
+
+	match, ok := ms.matches[request.MatchID]
+	if !ok || ms.playerMatches[request.PlayerID] != request.MatchID || match.State != MatchStatePlaying || unixNow >= match.Deadline {
+		return nil, false, MatchNotFoundErr{request.MatchID, request.PlayerID}
+	}
+
+	player := match.player(request.PlayerID)
+	if player.Submitted {
+		return nil, false, alreadySubmittedError
+	}
+
+	score, err := match.score(request.Rolls)
+	if err != nil {
+		return nil, false, err
+	}
+
+	player.Submitted = true
+	player.Rolls = slices.Clone(request.Rolls)
+	player.Score = score
+
+	if !match.Players[0].Submitted || !match.Players[1].Submitted {
+		return match.clone(), false, nil
+	}
+
+	match.decide(false)
+	return match.clone(), true, nil
+}
+
+// sweepMatches decides the matches which have passed their deadline, and removes stale queue entries,
+// it returns copies of the matches which were finished by the sweep
+func (ms *Server) sweepMatches(timeNow time.Time) []*Match {
+
+	unixNow := timeNow.UTC().Unix()
+	timeoutSeconds := int64(config.Config.Match.TimeoutSeconds)
+
+	ms.matchesMutex.Lock()
+	defer ms.matchesMutex.Unlock()
+
+	ms.queue = slices.DeleteFunc(ms.queue, func(entry queueEntry) bool { return unixNow-entry.queueTime > timeoutSeconds })
+
+	finished := []*Match{}
+	for matchID, match := range ms.matches {
+
+		if match.State == MatchStatePlaying && unixNow >= match.Deadline {
+			ms.logger.Printf("match %v has timed out", matchID)
+			match.decide(true)
+			if match.State == MatchStateComplete {
+				finished = append(finished, match.clone())
+			}
+			continue
+		}
+
+		// finished matches are kept around for a while, so both players can see how they ended
+		if match.State != MatchStatePlaying && unixNow-match.Deadline > timeoutSeconds {
+			for _, player := range match.Players {
+				if ms.playerMatches[player.PlayerID] == matchID {
+					delete(ms.playerMatches, player.PlayerID)
+				}
+			}
+			delete(ms.matches, matchID)
+		}
+	}
+
+	return finished
+}
+
+// StartPeriodicMatchSweep creates a ticker that will periodically decide timed out matches,
+// till the given context is done
+func (ms *Server) StartPeriodicMatchSweep(ctx context.Context, sweepPeriod time.Duration) {
+
+	if ms == nil {
+		return
+	}
+
+	ticker := time.NewTicker(sweepPeriod)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case timeNow := <-ticker.C:
+				for _, match := range ms.sweepMatches(timeNow) {
+					ms.finishMatch(context.Background(), match)
+				}
+			}
+		}
+	}()
+}
+
+// finishMatch records the result of a complete match in the stats of both players (updating their ratings),
+// and rewards the winner.
+// Failures are logged, since the match itself has already been decided
+func (ms *Server) finishMatch(ctx context.Context, match *Match) {
+
+	ctx, span := tracing.Start(ctx, "match.finishMatch")
+	defer span.End()
+
+	result := &stats.MatchResult{}
+	for i, player := range match.Players {
+		opponent := match.Players[1-i]
+
+		outcome := data.MatchOutcomeDraw
+		if match.WinnerID == player.PlayerID {
+			outcome = data.MatchOutcomeWin
+		} else if match.WinnerID == opponent.PlayerID {
+			outcome = data.MatchOutcomeLoss
+		}
+
+		result.Records[i] = data.MatchRecord{
+			MatchID:       match.MatchID,
+			PlayerID:      player.PlayerID,
+			OpponentID:    opponent.PlayerID,
+			Outcome:       outcome,
+			Score:         player.Score,
+			OpponentScore: opponent.Score,
+			Forfeit:       match.Forfeit,
+			Time:          time.Now().UTC().Unix(),
+		}
+	}
+
+	err := ms.statsClient.RecordMatchResult(ctx, result)
+	if err != nil {
+		ms.logger.Printf("error: could not record the result of match %v: %v", match.MatchID, err)
+	}
+
+	ms.publishMatchFinished(ctx, match)
+
+	if match.WinnerID == "" {
+		return
+	}
+
+	rewards := config.Config.Match
+
+	if rewards.WinnerEnergyReward > 0 {
+		_, err := ms.profileClient.UpdatePlayerData(playerctx.WithPlayerID(ctx, match.WinnerID), rewards.WinnerEnergyReward, 0)
+		if err != nil {
+			ms.logger.Printf("error: could not grant the energy reward of match %v to player id %v: %v", match.MatchID, match.WinnerID, err)
+		}
+	}
+
+	if rewards.WinnerCoinReward > 0 {
+		_, err := ms.dataClient.InitWallet(ctx, &data.WalletData{PlayerID: match.WinnerID, Coins: config.Config.DefaultCoins})
+		if err == nil {
+			_, err = ms.dataClient.AdjustWallet(ctx, match.WinnerID, rewards.WinnerCoinReward)
+		}
+		if err != nil {
+			ms.logger.Printf("error: could not grant the coin reward of match %v to player id %v: %v", match.MatchID, match.WinnerID, err)
+		}
+	}
+}
+
+// publishMatchFinished publishes the finished match to the webhooks, if webhooks are enabled (errors are logged)
+func (ms *Server) publishMatchFinished(ctx context.Context, match *Match) {
+
+	if ms.webhookClient == nil {
+		return
+	}
+
+	event, err := webhooks.NewEvent(webhooks.EventMatchFinished, "", &webhooks.MatchFinishedData{
+		MatchID:   match.MatchID,
+		PlayerIDs: [2]string{match.Players[0].PlayerID, match.Players[1].PlayerID},
+		WinnerID:  match.WinnerID,
+		Forfeit:   match.Forfeit,
+	})
+	if err == nil {
+		err = ms.webhookClient.Publish(ctx, event)
+	}
+	if err != nil {
+		ms.logger.Printf("error: could not publish the end of match %v: %v", match.MatchID, err)
+	}
+}
+
+// writeResponse encodes the queue response as json
+func (ms *Server) writeResponse(w http.ResponseWriter, r *http.Request, response *QueueResponse) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		ms.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// newMatch creates a match between the two players, with a target for the default dice from the given random number generator
+func newMatch(random rng.RNG, playerID1 string, playerID2 string, unixNow int64) (*Match, error) {
+
+	matchID := make([]byte, 8)
+	_, err := rand.Read(matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	sides, count := (&config.LevelConfig{}).Dice()
+	matchConfig := config.Config.Match
+
+	return &Match{
+		MatchID:    hex.EncodeToString(matchID),
+		State:      MatchStatePlaying,
+		Target:     count + random.Int32N(sides*count-count+1),
+		TotalRolls: matchConfig.TotalRolls,
+		Players:    [2]MatchPlayer{{PlayerID: playerID1}, {PlayerID: playerID2}},
+		StartTime:  unixNow,
+		Deadline:   unixNow + int64(matchConfig.TimeoutSeconds),
+	}, nil
+}
+
+// player returns the match player with the given id (the caller makes sure they are in the match)
+func (m *Match) player(playerID string) *MatchPlayer {
+	if m.Players[0].PlayerID == playerID {
+		return &m.Players[0]
+	}
+	return &m.Players[1]
+}
+
+// score validates the rolls, and returns the number of rolls it took to hit the target (0 if it was missed).
+// Like in the levels, rolling stops once the target is hit
+func (m *Match) score(rolls []int32) (int32, error) {
+
+	rollCount := int32(len(rolls))
+	if rollCount == 0 || rollCount > m.TotalRolls {
+		return 0, fmt.Errorf("invalid number of rolls: %v", rollCount)
+	}
+
+	levelConfig := &config.LevelConfig{}
+	for i, roll := range rolls {
+		if !levelConfig.IsValidRoll(roll) {
+			return 0, fmt.Errorf("invalid roll value: %v", roll)
+		}
+
+		if roll == m.Target && int32(i) != rollCount-1 {
+			return 0, fmt.Errorf("rolls continue after the target was hit")
+		}
+	}
+
+	if rolls[rollCount-1] != m.Target {
+		return 0, nil
+	}
+
+	return rollCount, nil
+}
+
+// decide completes the match: the player who hit the target in fewer rolls wins (a draw if neither did, or on a tie).
+// On a timeout, a player who submitted wins by forfeit, and if neither did, the match expires
+func (m *Match) decide(timedOut bool) {
+
+	first, second := m.Players[0], m.Players[1]
+	m.State = MatchStateComplete
+
+	switch {
+	case timedOut && !first.Submitted && !second.Submitted:
+		m.State = MatchStateExpired
+	case timedOut && first.Submitted != second.Submitted:
+		m.Forfeit = true
+		if first.Submitted {
+			m.WinnerID = first.PlayerID
+		} else {
+			m.WinnerID = second.PlayerID
+		}
+	case first.Score == second.Score:
+		// a draw (including when both missed)
+	case second.Score == 0 || (first.Score != 0 && first.Score < second.Score):
+		m.WinnerID = first.PlayerID
+	default:
+		m.WinnerID = second.PlayerID
+	}
+}
+
+// clone returns a deep copy of the match
+func (m *Match) clone() *Match {
+
+	clone := *m
+	for i := range clone.Players {
+		clone.Players[i].Rolls = slices.Clone(m.Players[i].Rolls)
+	}
+
+	return &clone
+}
+
+// viewFor returns a copy of the match as the given player is allowed to see it,
+// the opponent's rolls are hidden while the match is still being played
+func (m *Match) viewFor(playerID string) *Match {
+
+	view := m.clone()
+	if view.State == MatchStatePlaying {
+		for i := range view.Players {
+			if view.Players[i].PlayerID != playerID {
+				view.Players[i].Rolls = nil
+				view.Players[i].Score = 0
+			}
+		}
+	}
+
+	return view
+}
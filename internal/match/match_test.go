@@ -0,0 +1,339 @@
+package match
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/testsetup"
+	"example.com/dice-game-backend/internal/stats"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewMatchServer(t *testing.T) {
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+
+	ms := NewServer(authServer, dataServer, profile.NewServer(authServer, dataServer), stats.NewServer(authServer, dataServer))
+
+	if ms == nil {
+		t.Fatal("new match server should not return a nil server pointer")
+	}
+}
+
+func TestMatch_score(t *testing.T) {
+
+	match := &Match{Target: 4, TotalRolls: 3}
+
+	tests := []struct {
+		name      string
+		rolls     []int32
+		wantScore int32
+		wantErr   bool
+	}{
+		{"no rolls", []int32{}, 0, true},
+		{"too many rolls", []int32{1, 2, 3, 4}, 0, true},
+		{"invalid roll", []int32{1, 7}, 0, true},
+		{"rolls after the target", []int32{4, 2}, 0, true},
+		{"hit on the first roll", []int32{4}, 1, false},
+		{"hit on the last roll", []int32{1, 2, 4}, 3, false},
+		{"missed", []int32{1, 2, 3}, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotScore, err := match.score(test.rolls)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("score gave incorrect error, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			if gotScore != test.wantScore {
+				t.Errorf("score gave incorrect results, want: %v, got: %v", test.wantScore, gotScore)
+			}
+		})
+	}
+}
+
+func TestMatch_decide(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		first        MatchPlayer
+		second       MatchPlayer
+		timedOut     bool
+		wantState    string
+		wantWinnerID string
+		wantForfeit  bool
+	}{
+		{"fewer rolls wins", MatchPlayer{"p1", true, nil, 2}, MatchPlayer{"p2", true, nil, 3}, false, MatchStateComplete, "p1", false},
+		{"hit beats miss", MatchPlayer{"p1", true, nil, 0}, MatchPlayer{"p2", true, nil, 3}, false, MatchStateComplete, "p2", false},
+		{"tie is a draw", MatchPlayer{"p1", true, nil, 2}, MatchPlayer{"p2", true, nil, 2}, false, MatchStateComplete, "", false},
+		{"both missed is a draw", MatchPlayer{"p1", true, nil, 0}, MatchPlayer{"p2", true, nil, 0}, false, MatchStateComplete, "", false},
+		{"forfeit", MatchPlayer{"p1", false, nil, 0}, MatchPlayer{"p2", true, nil, 0}, true, MatchStateComplete, "p2", true},
+		{"expired", MatchPlayer{"p1", false, nil, 0}, MatchPlayer{"p2", false, nil, 0}, true, MatchStateExpired, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match := &Match{State: MatchStatePlaying, Players: [2]MatchPlayer{test.first, test.second}}
+			match.decide(test.timedOut)
+
+			if match.State != test.wantState || match.WinnerID != test.wantWinnerID || match.Forfeit != test.wantForfeit {
+				t.Errorf("decide gave incorrect results, want: %v %v %v, got: %v %v %v", test.wantState, test.wantWinnerID, test.wantForfeit, match.State, match.WinnerID, match.Forfeit)
+			}
+		})
+	}
+}
+
+func TestServer_MatchFlow(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	statsServer := stats.NewServer(as, dataServer)
+	ms := NewServer(as, dataServer, profileServer, statsServer)
+
+	for _, playerID := range []string{"player1", "player2"} {
+		_, err = testsetup.SetupTestProfile(playerID, sID, profileServer.HandleNewPlayerRequest)
+		if err != nil {
+			t.Fatal("profile setup error: " + err.Error())
+		}
+	}
+
+	// the session of each test player is their own (session1 of player1 and so on)
+	sessions := testsetup.Sessions{"session1": "player1", "session2": "player2", "session3": "player3"}
+
+	// the first player waits in the queue, the second one gets paired with them
+	status, response := sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleQueueRequest), http.MethodPost, "/match/queue", "session3", &QueueRequestBody{PlayerID: "player3"})
+	if status != http.StatusNotFound {
+		t.Fatalf("queue request for a missing player gave incorrect status, want: %v, got: %v", http.StatusNotFound, status)
+	}
+
+	status, _ = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleQueueRequest), http.MethodPost, "/match/queue", "session2", &QueueRequestBody{PlayerID: "player1"})
+	if status != http.StatusForbidden {
+		t.Fatalf("queue request for another player gave incorrect status, want: %v, got: %v", http.StatusForbidden, status)
+	}
+
+	_, response = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleQueueRequest), http.MethodPost, "/match/queue", "session1", &QueueRequestBody{PlayerID: "player1"})
+	if response.Status != QueueStatusQueued {
+		t.Fatalf("queue request gave incorrect status, want: %v, got: %v", QueueStatusQueued, response.Status)
+	}
+
+	// only the player themselves can take them out of the queue
+	status, _ = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleLeaveQueueRequest), http.MethodDelete, "/match/queue/player1", "session2", nil)
+	if status != http.StatusForbidden {
+		t.Fatalf("leave queue request for another player gave incorrect status, want: %v, got: %v", http.StatusForbidden, status)
+	}
+
+	_, response = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleLeaveQueueRequest), http.MethodDelete, "/match/queue/player1", "session1", nil)
+	if response.Status != QueueStatusIdle {
+		t.Fatalf("leave queue request gave incorrect status, want: %v, got: %v", QueueStatusIdle, response.Status)
+	}
+
+	_, response = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleQueueRequest), http.MethodPost, "/match/queue", "session1", &QueueRequestBody{PlayerID: "player1"})
+	if response.Status != QueueStatusQueued {
+		t.Fatalf("queue request gave incorrect status, want: %v, got: %v", QueueStatusQueued, response.Status)
+	}
+
+	_, response = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleQueueRequest), http.MethodPost, "/match/queue", "session2", &QueueRequestBody{PlayerID: "player2"})
+	if response.Status != QueueStatusMatched || response.Match == nil {
+		t.Fatalf("queue request gave incorrect status, want: %v, got: %v", QueueStatusMatched, response.Status)
+	}
+	match := response.Match
+
+	status, _ = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleStatusRequest), http.MethodGet, "/match/status/player1", "session2", nil)
+	if status != http.StatusForbidden {
+		t.Fatalf("status request for another player gave incorrect status, want: %v, got: %v", http.StatusForbidden, status)
+	}
+
+	// both players see the same match
+	status, response = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleStatusRequest), http.MethodGet, "/match/status/player1", "session1", nil)
+	if status != http.StatusOK || response.Match == nil || response.Match.MatchID != match.MatchID {
+		t.Fatalf("status request gave incorrect results, want match: %v, got: %v", match.MatchID, response.Match)
+	}
+
+	// player1 hits the target on the first roll, player2 misses
+	miss := match.Target%6 + 1
+	status, _ = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleMatchResultRequest), http.MethodPost, "/match/result", "session1", &MatchResultRequestBody{PlayerID: "player1", MatchID: "match1", Rolls: []int32{match.Target}})
+	if status != http.StatusNotFound {
+		t.Errorf("result request for a missing match gave incorrect status, want: %v, got: %v", http.StatusNotFound, status)
+	}
+
+	status, _ = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleMatchResultRequest), http.MethodPost, "/match/result", "session2", &MatchResultRequestBody{PlayerID: "player1", MatchID: match.MatchID, Rolls: []int32{miss}})
+	if status != http.StatusForbidden {
+		t.Errorf("result request for another player gave incorrect status, want: %v, got: %v", http.StatusForbidden, status)
+	}
+
+	_, response = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleMatchResultRequest), http.MethodPost, "/match/result", "session1", &MatchResultRequestBody{PlayerID: "player1", MatchID: match.MatchID, Rolls: []int32{match.Target}})
+	if response.Match.State != MatchStatePlaying {
+		t.Errorf("result request gave incorrect state, want: %v, got: %v", MatchStatePlaying, response.Match.State)
+	}
+
+	status, _ = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleMatchResultRequest), http.MethodPost, "/match/result", "session1", &MatchResultRequestBody{PlayerID: "player1", MatchID: match.MatchID, Rolls: []int32{match.Target}})
+	if status != http.StatusConflict {
+		t.Errorf("repeated result request gave incorrect status, want: %v, got: %v", http.StatusConflict, status)
+	}
+
+	_, response = sendRequest(t, testsetup.WithSession(ms, sessions, ms.HandleMatchResultRequest), http.MethodPost, "/match/result", "session2", &MatchResultRequestBody{PlayerID: "player2", MatchID: match.MatchID, Rolls: []int32{miss}})
+	if response.Match.State != MatchStateComplete || response.Match.WinnerID != "player1" {
+		t.Fatalf("result request gave incorrect results, want winner: player1, got: %v (%v)", response.Match.WinnerID, response.Match.State)
+	}
+
+	// the result is in the match history of both players, and the winner got the coin reward
+	records, err := dataServer.ReadMatchRecords(context.Background(), "player2")
+	if err != nil || len(records) != 1 || records[0].Outcome != data.MatchOutcomeLoss || records[0].OpponentID != "player1" {
+		t.Errorf("match history has incorrect entries: %v (%v)", records, err)
+	}
+
+	wallet, err := dataServer.ReadWallet(context.Background(), "player1")
+	wantCoins := config.Config.DefaultCoins + config.Config.Match.WinnerCoinReward
+	if err != nil || wallet.Coins != wantCoins {
+		t.Errorf("winner has incorrect coins, want: %v, got: %v (%v)", wantCoins, wallet, err)
+	}
+}
+
+func TestServer_sweepMatches(t *testing.T) {
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+	ms := NewServer(authServer, dataServer, profile.NewServer(authServer, dataServer), stats.NewServer(authServer, dataServer))
+
+	timeNow := time.Now()
+	unixNow := timeNow.UTC().Unix()
+	timeout := int64(config.Config.Match.TimeoutSeconds)
+
+	ms.queue = []queueEntry{{"player1", unixNow - timeout - 1}, {"player2", unixNow}}
+
+	forfeited := &Match{MatchID: "match1", State: MatchStatePlaying, Players: [2]MatchPlayer{{PlayerID: "player3", Submitted: true, Score: 0}, {PlayerID: "player4"}}, Deadline: unixNow}
+	expired := &Match{MatchID: "match2", State: MatchStatePlaying, Players: [2]MatchPlayer{{PlayerID: "player5"}, {PlayerID: "player6"}}, Deadline: unixNow}
+	playing := &Match{MatchID: "match3", State: MatchStatePlaying, Players: [2]MatchPlayer{{PlayerID: "player7"}, {PlayerID: "player8"}}, Deadline: unixNow + 1}
+	old := &Match{MatchID: "match4", State: MatchStateComplete, Players: [2]MatchPlayer{{PlayerID: "player9"}, {PlayerID: "player10"}}, Deadline: unixNow - timeout - 1}
+	for _, match := range []*Match{forfeited, expired, playing, old} {
+		ms.matches[match.MatchID] = match
+		ms.playerMatches[match.Players[0].PlayerID] = match.MatchID
+		ms.playerMatches[match.Players[1].PlayerID] = match.MatchID
+	}
+
+	finished := ms.sweepMatches(timeNow)
+
+	if len(finished) != 1 || finished[0].MatchID != "match1" || finished[0].WinnerID != "player3" || !finished[0].Forfeit {
+		t.Errorf("sweep gave incorrect finished matches: %v", finished)
+	}
+
+	if expired.State != MatchStateExpired || playing.State != MatchStatePlaying {
+		t.Errorf("sweep gave incorrect match states, want: %v %v, got: %v %v", MatchStateExpired, MatchStatePlaying, expired.State, playing.State)
+	}
+
+	if _, ok := ms.matches["match4"]; ok {
+		t.Errorf("sweep should have removed the old match")
+	}
+
+	if len(ms.queue) != 1 || ms.queue[0].playerID != "player2" {
+		t.Errorf("sweep gave incorrect queue: %v", ms.queue)
+	}
+}
+
+// sendRequest sends the request body to the handler, and decodes the queue response (if successful)
+func sendRequest(t *testing.T, handler http.HandlerFunc, method string, target string, sessionID string, requestBody any) (int, *QueueResponse) {
+
+	buf := &bytes.Buffer{}
+	if requestBody != nil {
+		err := json.NewEncoder(buf).Encode(requestBody)
+		if err != nil {
+			t.Fatal("could not encode the request body: " + err.Error())
+		}
+	}
+
+	newReq := httptest.NewRequest(method, target, buf)
+	newReq.Header.Set("Session-Id", sessionID)
+	if method != http.MethodPost {
+		newReq.SetPathValue("id", target[strings.LastIndex(target, "/")+1:])
+	}
+	respRec := httptest.NewRecorder()
+
+	handler(respRec, newReq)
+
+	response := &QueueResponse{}
+	if respRec.Result().StatusCode == http.StatusOK {
+		err := json.NewDecoder(respRec.Result().Body).Decode(response)
+		if err != nil {
+			t.Fatal("could not decode the response body")
+		}
+	}
+
+	return respRec.Result().StatusCode, response
+}
+
+func TestServer_EnableSeededRNGFromEnv(t *testing.T) {
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+
+	// the targets of a few matches made by a server with the given seed set in the environment
+	targets := func(seed string) ([]int32, error) {
+		t.Setenv(constants.RNGSeedEnvVar, seed)
+
+		ms := NewServer(authServer, dataServer, profile.NewServer(authServer, dataServer), stats.NewServer(authServer, dataServer))
+		err := ms.EnableSeededRNGFromEnv()
+		if err != nil {
+			return nil, err
+		}
+
+		matchTargets := make([]int32, 10)
+		for i := range matchTargets {
+			match, matchErr := newMatch(ms.random, "player1", "player2", 0)
+			if matchErr != nil {
+				return nil, matchErr
+			}
+			matchTargets[i] = match.Target
+		}
+		return matchTargets, nil
+	}
+
+	tests := []struct {
+		name      string
+		seed      string
+		wantErr   bool
+		wantEqual bool
+	}{
+		{"invalid seed", "seed", true, false},
+		{"negative seed", "-1", true, false},
+		{"same seed, same targets", "42", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			got1, err := targets(test.seed)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("handler gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+
+			got2, err := targets(test.seed)
+			if err != nil {
+				t.Fatal("unexpected error: " + err.Error())
+			}
+
+			if reflect.DeepEqual(got1, got2) != test.wantEqual {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", got1, got2)
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+// Package notifications: service which keeps the push tokens of the players' devices,
+// and sends them push notifications (like 'energy full') via the provider of each platform
+
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// how often the players with registered devices are checked for full energy
+const energyCheckPeriod time.Duration = 1 * time.Minute
+
+// the kinds of notifications sent by this service
+const (
+	KindEnergyFull       = "energy-full"
+	KindTournamentEnding = "tournament-ending"
+)
+
+// Notifications Specific Errors:
+var serverNilError = fmt.Errorf("provided notifications server pointer is nil")
+
+type UnsupportedPlatformErr struct {
+	Platform string
+}
+
+func (err UnsupportedPlatformErr) Error() string {
+	return fmt.Sprintf("push notifications are not supported for platform: %v", err.Platform)
+}
+
+type TournamentEndingRequestBody struct {
+	Name    string `json:"name"`
+	EndTime int64  `json:"endTime"`
+}
+
+// NotificationsSentResponse holds the number of devices a notification was sent to
+type NotificationsSentResponse struct {
+	Sent int `json:"sent"`
+}
+
+// Server is the core notifications service provider
+type Server struct {
+	requestValidator validation.RequestValidator
+	profileClient    profile.ProfileClient
+
+	// the provider used to deliver the notifications for each platform
+	providers map[string]Provider
+
+	// the registered devices (by push token), and whether each player's energy was full
+	// at the last check (so that 'energy full' is only sent when the energy fills back up)
+	devices      map[string]*Device
+	energyFull   map[string]bool
+	devicesMutex sync.Mutex
+
+	// how often the players are checked for full energy, see SetSweepPeriod
+	sweepPeriod time.Duration
+
+	logger *log.Logger
+}
+
+// NewServer returns an initialized pointer to the notifications server,
+// which uses stub providers for both platforms (till they are replaced with SetProvider)
+func NewServer(rv validation.RequestValidator, pc profile.ProfileClient) *Server {
+
+	logger := log.New(redact.Stdout, "notifications: ", log.Ltime|log.LUTC|log.Lmsgprefix)
+
+	return &Server{
+		requestValidator: rv,
+		profileClient:    pc,
+
+		providers: map[string]Provider{
+			PlatformAndroid: NewStubProvider("fcm", logger),
+			PlatformIOS:     NewStubProvider("apns", logger),
+		},
+
+		devices:      map[string]*Device{},
+		energyFull:   map[string]bool{},
+		devicesMutex: sync.Mutex{},
+
+		sweepPeriod: energyCheckPeriod,
+
+		logger: logger,
+	}
+}
+
+// SetSweepPeriod changes how often the players are checked for full energy (used by the startup config), it should be called before Run
+func (ns *Server) SetSweepPeriod(period time.Duration) {
+
+	if ns == nil || period <= 0 {
+		return
+	}
+
+	ns.sweepPeriod = period
+}
+
+// SetProvider plugs in the provider used to deliver the notifications for the given platform
+func (ns *Server) SetProvider(platform string, provider Provider) {
+
+	if ns == nil {
+		return
+	}
+
+	ns.devicesMutex.Lock()
+	defer ns.devicesMutex.Unlock()
+
+	ns.providers[platform] = provider
+}
+
+// Run runs a given notifications server on the given port
+func (ns *Server) Run(port string) {
+	log.Fatal(ns.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the notifications server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (ns *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ns.serve(ctx, listener)
+}
+
+// RunWithListener runs the notifications server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (ns *Server) RunWithListener(listener net.Listener) error {
+	return ns.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the notifications server, and serves them on the given listener till the context is done
+func (ns *Server) serve(ctx context.Context, listener net.Listener) error {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("POST /notifications/register", middleware.WithLimits(validation.WithSession(ns.requestValidator, ns.HandleRegisterRequest), middleware.DefaultLimits))
+
+	mux.Handle("POST /notifications/admin/tournament-ending", middleware.WithLimits(ns.HandleTournamentEndingRequest, middleware.DefaultLimits))
+
+	ns.StartPeriodicEnergyCheck(ctx, ns.sweepPeriod)
+
+	ns.logger.Println("the notifications server is up and running...")
+
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("notifications", middleware.WithAccessLog("notifications", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
+}
+
+// Register registers the push token of a player's device (a token registered earlier,
+// even by another player, now belongs to this device), the player has to exist
+func (ns *Server) Register(ctx context.Context, device *Device) error {
+
+	if ns == nil {
+		return serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "notifications.Register")
+	defer span.End()
+
+	if device == nil {
+		return fmt.Errorf("provided device pointer is nil")
+	}
+
+	if device.Token == "" {
+		return fmt.Errorf("the push token should not be empty")
+	}
+
+	ns.devicesMutex.Lock()
+	_, supported := ns.providers[device.Platform]
+	ns.devicesMutex.Unlock()
+	if !supported {
+		return UnsupportedPlatformErr{Platform: device.Platform}
+	}
+
+	// make a request to the profile service to make sure the player exists (and to see where their energy is at)
+	player, err := ns.profileClient.GetPlayer(ctx, device.PlayerID)
+	if err != nil {
+		return err
+	}
+
+	ns.devicesMutex.Lock()
+	defer ns.devicesMutex.Unlock()
+
+	ns.devices[device.Token] = &Device{PlayerID: device.PlayerID, Token: device.Token, Platform: device.Platform}
+	if _, ok := ns.energyFull[device.PlayerID]; !ok {
+		ns.energyFull[device.PlayerID] = player.Energy >= config.Config.MaxEnergy
+	}
+
+	return nil
+}
+
+// HandleRegisterRequest is a wrapper around the Register() method,
+// clients are expected to register their push token at the start of each session
+func (ns *Server) HandleRegisterRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ns == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	device := &Device{}
+	err := json.NewDecoder(r.Body).Decode(device)
+	if err != nil {
+		errMsg := "error: could not decode the register request: " + err.Error()
+		ns.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &device.PlayerID) {
+		return
+	}
+	ns.logger.Printf("request to register a %v device for player id %v", device.Platform, device.PlayerID)
+
+	err = ns.Register(r.Context(), device)
+	if err != nil {
+		errMsg := "error: could not register the device: " + err.Error()
+		ns.logger.Println(errMsg)
+		if errors.Is(err, data.PlayerNotFoundErr{PlayerID: device.PlayerID}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		} else {
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		}
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ns.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+}
+
+// HandleTournamentEndingRequest lets an admin send a 'tournament ending' notification to every registered device
+func (ns *Server) HandleTournamentEndingRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ns == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ns.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	// decode the request
+	tournament := &TournamentEndingRequestBody{}
+	err = json.NewDecoder(r.Body).Decode(tournament)
+	if err != nil {
+		errMsg := "error: could not decode the tournament ending request: " + err.Error()
+		ns.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	minutesLeft := (tournament.EndTime - time.Now().UTC().Unix()) / 60
+	if tournament.Name == "" || minutesLeft < 1 {
+		errMsg := "error: the tournament should have a name, and end at least a minute from now"
+		ns.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+	ns.logger.Printf("request to notify all players about tournament %v ending", tournament.Name)
+
+	sent := ns.notifyAll(r.Context(), &Notification{
+		Kind:  KindTournamentEnding,
+		Title: "Tournament ending soon",
+		Body:  fmt.Sprintf("%v ends in %v minutes, get your last rolls in!", tournament.Name, minutesLeft),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&NotificationsSentResponse{Sent: sent})
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		ns.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// StartPeriodicEnergyCheck creates a ticker that will periodically send 'energy full' notifications,
+// till the given context is done
+func (ns *Server) StartPeriodicEnergyCheck(ctx context.Context, checkPeriod time.Duration) {
+
+	if ns == nil {
+		return
+	}
+
+	ticker := time.NewTicker(checkPeriod)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ns.checkEnergy(context.Background())
+			}
+		}
+	}()
+}
+
+// checkEnergy reads every player with a registered device via the profile service (which applies energy regeneration),
+// and notifies the ones whose energy has become full since the last check. It returns the number of notifications sent
+func (ns *Server) checkEnergy(ctx context.Context) int {
+
+	ctx, span := tracing.Start(ctx, "notifications.checkEnergy")
+	defer span.End()
+
+	ns.devicesMutex.Lock()
+	playerIDs := make([]string, 0, len(ns.energyFull))
+	for playerID := range ns.energyFull {
+		playerIDs = append(playerIDs, playerID)
+	}
+	ns.devicesMutex.Unlock()
+
+	sent := 0
+	for _, playerID := range playerIDs {
+		player, err := ns.profileClient.GetPlayer(ctx, playerID)
+		if err != nil {
+			ns.logger.Printf("error: could not check the energy of player id %v: %v", playerID, err)
+			continue
+		}
+
+		full := player.Energy >= config.Config.MaxEnergy
+
+		ns.devicesMutex.Lock()
+		wasFull := ns.energyFull[playerID]
+		ns.energyFull[playerID] = full
+		ns.devicesMutex.Unlock()
+
+		if full && !wasFull {
+			sent += ns.notifyPlayer(ctx, playerID, &Notification{
+				Kind:  KindEnergyFull,
+				Title: "Energy full",
+				Body:  "Your energy is full, time to roll some dice!",
+			})
+		}
+	}
+
+	return sent
+}
+
+// notifyAll sends the notification to every registered device, and returns the number of devices it was sent to
+func (ns *Server) notifyAll(ctx context.Context, notification *Notification) int {
+
+	ns.devicesMutex.Lock()
+	devices := make([]*Device, 0, len(ns.devices))
+	for _, device := range ns.devices {
+		devices = append(devices, device)
+	}
+	ns.devicesMutex.Unlock()
+
+	return ns.send(ctx, devices, notification)
+}
+
+// notifyPlayer sends the notification to every registered device of the given player,
+// and returns the number of devices it was sent to
+func (ns *Server) notifyPlayer(ctx context.Context, playerID string, notification *Notification) int {
+
+	ns.devicesMutex.Lock()
+	devices := []*Device{}
+	for _, device := range ns.devices {
+		if device.PlayerID == playerID {
+			devices = append(devices, device)
+		}
+	}
+	ns.devicesMutex.Unlock()
+
+	return ns.send(ctx, devices, notification)
+}
+
+// send delivers the notification to the given devices via the provider of each device's platform,
+// devices with tokens rejected by the provider are unregistered. It returns the number of successful sends
+func (ns *Server) send(ctx context.Context, devices []*Device, notification *Notification) int {
+
+	ctx, span := tracing.Start(ctx, "notifications.send")
+	defer span.End()
+
+	sent := 0
+	for _, device := range devices {
+
+		ns.devicesMutex.Lock()
+		provider := ns.providers[device.Platform]
+		ns.devicesMutex.Unlock()
+
+		err := provider.Send(ctx, device, notification)
+		if err != nil {
+			ns.logger.Printf("error: could not send '%v' notification to player id %v: %v", notification.Kind, device.PlayerID, err)
+			if errors.As(err, &InvalidTokenErr{}) {
+				ns.unregister(device)
+			}
+			continue
+		}
+		sent++
+	}
+
+	return sent
+}
+
+// unregister removes the given device, and stops checking the energy of its player if it was their last device
+func (ns *Server) unregister(device *Device) {
+
+	ns.devicesMutex.Lock()
+	defer ns.devicesMutex.Unlock()
+
+	if ns.devices[device.Token] != device {
+		return // the token has been registered again since
+	}
+	delete(ns.devices, device.Token)
+
+	for _, other := range ns.devices {
+		if other.PlayerID == device.PlayerID {
+			return
+		}
+	}
+	delete(ns.energyFull, device.PlayerID)
+}
@@ -0,0 +1,270 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/testsetup"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordingProvider records the tokens it sends notifications to, and rejects the given invalid tokens
+type recordingProvider struct {
+	sent          []string
+	invalidTokens map[string]bool
+}
+
+func (rp *recordingProvider) Send(ctx context.Context, device *Device, notification *Notification) error {
+	if rp.invalidTokens[device.Token] {
+		return InvalidTokenErr{Token: device.Token}
+	}
+	rp.sent = append(rp.sent, device.Token)
+	return nil
+}
+
+func TestNewNotificationsServer(t *testing.T) {
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+
+	ns := NewServer(authServer, profile.NewServer(authServer, dataServer))
+
+	if ns == nil {
+		t.Fatal("new notifications server should not return a nil server pointer")
+	}
+}
+
+func TestServer_HandleRegisterRequest(t *testing.T) {
+
+	var ns1, ns2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	ns2 = NewServer(as, profileServer)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, profileServer.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		sessionID  string
+		device     *Device
+		wantStatus int
+	}{
+		{"nil server", ns1, "", nil, http.StatusInternalServerError},
+		{"valid server, blank session id", ns2, "", nil, http.StatusUnauthorized},
+		{"valid server, empty token", ns2, sID, &Device{PlayerID: "player1", Token: "", Platform: PlatformAndroid}, http.StatusBadRequest},
+		{"valid server, unsupported platform", ns2, sID, &Device{PlayerID: "player1", Token: "token1", Platform: "windows"}, http.StatusBadRequest},
+		{"valid server, another player", ns2, sID, &Device{PlayerID: "player2", Token: "token1", Platform: PlatformAndroid}, http.StatusForbidden},
+		{"valid server, missing player", ns2, "session2", &Device{PlayerID: "player2", Token: "token1", Platform: PlatformAndroid}, http.StatusNotFound},
+		{"valid server, android device", ns2, sID, &Device{PlayerID: "player1", Token: "token1", Platform: PlatformAndroid}, http.StatusOK},
+		{"valid server, ios device", ns2, sID, &Device{PlayerID: "player1", Token: "token2", Platform: PlatformIOS}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err = json.NewEncoder(buf).Encode(test.device)
+			if err != nil {
+				t.Fatal("could not encode the request body")
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/notifications/register", buf)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			notificationsServer := test.server
+			testsetup.WithSession(notificationsServer, sessions, notificationsServer.HandleRegisterRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	if len(ns2.devices) != 2 {
+		t.Errorf("incorrect number of registered devices, want: %v, got: %v", 2, len(ns2.devices))
+	}
+}
+
+func TestServer_checkEnergy(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	ns := NewServer(as, profileServer)
+
+	provider := &recordingProvider{invalidTokens: map[string]bool{"token3": true}}
+	ns.SetProvider(PlatformAndroid, provider)
+
+	for _, playerID := range []string{"player1", "player2"} {
+		_, err = testsetup.SetupTestProfile(playerID, sID, profileServer.HandleNewPlayerRequest)
+		if err != nil {
+			t.Fatal("profile setup error: " + err.Error())
+		}
+	}
+
+	devices := []*Device{
+		{PlayerID: "player1", Token: "token1", Platform: PlatformAndroid},
+		{PlayerID: "player2", Token: "token2", Platform: PlatformAndroid},
+		{PlayerID: "player2", Token: "token3", Platform: PlatformAndroid},
+	}
+	for _, device := range devices {
+		err = ns.Register(context.Background(), device)
+		if err != nil {
+			t.Fatal("register error: " + err.Error())
+		}
+	}
+
+	// new players start with full energy, which is not news
+	sent := ns.checkEnergy(context.Background())
+	if sent != 0 {
+		t.Errorf("check energy gave incorrect results, want: %v, got: %v", 0, sent)
+	}
+
+	// spend some energy, and then get it back (as if it regenerated)
+	_, err = profileServer.UpdatePlayerData(playerctx.WithPlayerID(context.Background(), "player2"), -5, 0)
+	if err != nil {
+		t.Fatal("update player error: " + err.Error())
+	}
+
+	sent = ns.checkEnergy(context.Background())
+	if sent != 0 {
+		t.Errorf("check energy gave incorrect results, want: %v, got: %v", 0, sent)
+	}
+
+	_, err = profileServer.UpdatePlayerData(playerctx.WithPlayerID(context.Background(), "player2"), 5, 0)
+	if err != nil {
+		t.Fatal("update player error: " + err.Error())
+	}
+
+	// only the valid device of player 2 gets the notification, and the invalid one is unregistered
+	sent = ns.checkEnergy(context.Background())
+	if sent != 1 || len(provider.sent) != 1 || provider.sent[0] != "token2" {
+		t.Errorf("check energy gave incorrect results, want: %v, got: %v (sent to %v)", 1, sent, provider.sent)
+	}
+
+	if _, ok := ns.devices["token3"]; ok {
+		t.Errorf("the device with the invalid token should have been unregistered")
+	}
+
+	// the energy is still full, so nothing new is sent
+	sent = ns.checkEnergy(context.Background())
+	if sent != 0 {
+		t.Errorf("check energy gave incorrect results, want: %v, got: %v", 0, sent)
+	}
+}
+
+func TestServer_HandleTournamentEndingRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	var ns1, ns2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	ns2 = NewServer(as, profileServer)
+
+	provider := &recordingProvider{}
+	ns2.SetProvider(PlatformAndroid, provider)
+	ns2.SetProvider(PlatformIOS, provider)
+
+	for _, playerID := range []string{"player1", "player2"} {
+		_, err = testsetup.SetupTestProfile(playerID, sID, profileServer.HandleNewPlayerRequest)
+		if err != nil {
+			t.Fatal("profile setup error: " + err.Error())
+		}
+	}
+
+	err = ns2.Register(context.Background(), &Device{PlayerID: "player1", Token: "token1", Platform: PlatformAndroid})
+	if err != nil {
+		t.Fatal("register error: " + err.Error())
+	}
+
+	err = ns2.Register(context.Background(), &Device{PlayerID: "player2", Token: "token2", Platform: PlatformIOS})
+	if err != nil {
+		t.Fatal("register error: " + err.Error())
+	}
+
+	endTime := time.Now().UTC().Unix() + 30*60
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		tournament *TournamentEndingRequestBody
+		wantStatus int
+		wantSent   int
+	}{
+		{"nil server", ns1, "", nil, http.StatusInternalServerError, 0},
+		{"invalid admin token", ns2, "testToken", nil, http.StatusUnauthorized, 0},
+		{"blank name", ns2, "adminToken", &TournamentEndingRequestBody{Name: "", EndTime: endTime}, http.StatusBadRequest, 0},
+		{"already ended", ns2, "adminToken", &TournamentEndingRequestBody{Name: "Weekly Cup", EndTime: 10}, http.StatusBadRequest, 0},
+		{"valid tournament", ns2, "adminToken", &TournamentEndingRequestBody{Name: "Weekly Cup", EndTime: endTime}, http.StatusOK, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err = json.NewEncoder(buf).Encode(test.tournament)
+			if err != nil {
+				t.Fatal("could not encode the request body")
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/notifications/admin/tournament-ending", buf)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			notificationsServer := test.server
+			notificationsServer.HandleTournamentEndingRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &NotificationsSentResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotResponseBody.Sent != test.wantSent {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantSent, gotResponseBody.Sent)
+				}
+			}
+		})
+	}
+}
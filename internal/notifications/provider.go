@@ -0,0 +1,70 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// the device platforms that push tokens can be registered for
+// (android devices are reached via FCM, and ios devices via APNS)
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+)
+
+// InvalidTokenErr is returned by a provider when the push service rejects a device token
+// (e.g. the app was uninstalled), the device is then unregistered
+type InvalidTokenErr struct {
+	Token string
+}
+
+func (err InvalidTokenErr) Error() string {
+	return fmt.Sprintf("push token: %v is no longer valid", err.Token)
+}
+
+// Notification is the content of a push notification
+type Notification struct {
+	Kind  string `json:"kind"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// Device is a registered push token of a player's device
+type Device struct {
+	PlayerID string `json:"playerID"`
+	Token    string `json:"token"`
+	Platform string `json:"platform"`
+}
+
+// Provider implementor can deliver a push notification to a device
+// (one provider is plugged in per platform)
+type Provider interface {
+	Send(ctx context.Context, device *Device, notification *Notification) error
+}
+
+// StubProvider is a Provider which only logs the notifications it is asked to send,
+// it stands in for the real push services (FCM / APNS) till those are wired up
+type StubProvider struct {
+	name   string
+	logger *log.Logger
+}
+
+// NewStubProvider returns an initialized pointer to a stub provider with the given name, which logs to the given logger
+func NewStubProvider(name string, logger *log.Logger) *StubProvider {
+	return &StubProvider{
+		name:   name,
+		logger: logger,
+	}
+}
+
+// Send logs the notification that would have been sent to the given device
+func (sp *StubProvider) Send(ctx context.Context, device *Device, notification *Notification) error {
+
+	if sp == nil {
+		return fmt.Errorf("provided stub provider pointer is nil")
+	}
+
+	sp.logger.Printf("%v stub: sending '%v' notification to player id: %v (token: %v)", sp.name, notification.Kind, device.PlayerID, device.Token)
+	return nil
+}
@@ -0,0 +1,255 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+// PlayerOverride is used as the request body for the admin request to set the level and energy of a player
+type PlayerOverride struct {
+	PlayerID string `json:"playerID"`
+	Level    int32  `json:"level"`
+	Energy   int32  `json:"energy"`
+}
+
+// EnergyGrant is used as the request body for the admin request to give energy to a player
+type EnergyGrant struct {
+	PlayerID string `json:"playerID"`
+	Energy   int32  `json:"energy"`
+}
+
+// SetPlayer overwrites the level and energy of the player (like when fixing a support case),
+// the energy regeneration starts again from now, and the energy boosts of the player are kept
+func (ps *Server) SetPlayer(ctx context.Context, override *PlayerOverride) (*data.PlayerData, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.SetPlayer")
+	defer span.End()
+
+	if override == nil {
+		return nil, fmt.Errorf("provided player override pointer is nil")
+	}
+
+	if override.Level <= 0 || override.Level > config.Config.LevelCount() {
+		return nil, fmt.Errorf("the level should be between 1 and %v, got: %v", config.Config.LevelCount(), override.Level)
+	}
+
+	if override.Energy < 0 || override.Energy > ps.maxEnergy {
+		return nil, fmt.Errorf("the energy should be between 0 and %v, got: %v", ps.maxEnergy, override.Energy)
+	}
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	var before data.PlayerData
+	player, err := ps.modifyPlayer(ctx, override.PlayerID, func(player *data.PlayerData) error {
+
+		// drop the expired boosts and make the timestamp current, then overwrite the values
+		updateErr := ps.updateEnergy(player, 0)
+		if updateErr != nil {
+			return updateErr
+		}
+
+		before = player.Clone()
+		player.Level = override.Level
+		player.Energy = override.Energy
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ps.auditRecorder.Record(ctx, audit.ActorAdmin, audit.ActionPlayerSet, override.PlayerID, map[string]int32{
+		"levelBefore": before.Level, "level": player.Level, "energyBefore": before.Energy, "energy": player.Energy,
+	})
+
+	return player, nil
+}
+
+// GrantEnergy gives the given amount of energy to the player (after passive energy regeneration, up to the max energy)
+func (ps *Server) GrantEnergy(ctx context.Context, playerID string, energy int32) (*data.PlayerData, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.GrantEnergy")
+	defer span.End()
+
+	if energy <= 0 {
+		return nil, fmt.Errorf("the energy to grant should be greater than 0, got: %v", energy)
+	}
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	player, err := ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+		return ps.updateEnergy(player, energy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ps.auditRecorder.Record(ctx, audit.ActorAdmin, audit.ActionEnergyGrant, playerID, map[string]int32{"energyDelta": energy, "energy": player.Energy})
+
+	return player, nil
+}
+
+// HandleAdminGetPlayerRequest responds with the data of the requested player, along with their annotations (admin only)
+func (ps *Server) HandleAdminGetPlayerRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ps.validateAdmin(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	ps.logger.Printf("admin player request for id: %v", id)
+
+	view, err := ps.GetAdminPlayerView(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not get player: " + err.Error()
+		ps.logger.Println(errMsg)
+		ps.writeAdminError(w, err, errMsg)
+		return
+	}
+
+	ps.writeAdminJSON(w, view, "player view")
+}
+
+// HandleSetPlayerRequest overwrites the level and energy of the player in the request body (admin only)
+func (ps *Server) HandleSetPlayerRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ps.validateAdmin(w, r) {
+		return
+	}
+
+	// decode the request body, which should be a PlayerOverride struct
+	decodedReq := &PlayerOverride{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ps.logger.Printf("admin set player request for id: %v", decodedReq.PlayerID)
+
+	updatedPlayer, err := ps.SetPlayer(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not set player data: " + err.Error()
+		ps.logger.Println(errMsg)
+		ps.writeAdminError(w, err, errMsg)
+		return
+	}
+
+	ps.writePlayer(w, r, updatedPlayer)
+}
+
+// HandleGrantEnergyRequest gives the energy in the request body to its player (admin only)
+func (ps *Server) HandleGrantEnergyRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ps.validateAdmin(w, r) {
+		return
+	}
+
+	// decode the request body, which should be an EnergyGrant struct
+	decodedReq := &EnergyGrant{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ps.logger.Printf("admin grant energy request for id: %v, energy: %v", decodedReq.PlayerID, decodedReq.Energy)
+
+	updatedPlayer, err := ps.GrantEnergy(r.Context(), decodedReq.PlayerID, decodedReq.Energy)
+	if err != nil {
+		errMsg := "error: could not grant energy: " + err.Error()
+		ps.logger.Println(errMsg)
+		ps.writeAdminError(w, err, errMsg)
+		return
+	}
+
+	ps.writePlayer(w, r, updatedPlayer)
+}
+
+// validateAdmin responds with a 401 (and returns false) if the request does not have a valid admin token
+func (ps *Server) validateAdmin(w http.ResponseWriter, r *http.Request) bool {
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// writeAdminError responds with a 404 if the player (or their annotation) was not found, a 409 if the player was
+// merged into another one (or kept changing), and a 400 for any other error
+func (ps *Server) writeAdminError(w http.ResponseWriter, err error, errMsg string) {
+
+	switch err.(type) {
+	case data.PlayerNotFoundErr, data.AnnotationNotFoundErr:
+		http.Error(w, errMsg, http.StatusNotFound)
+	case data.PlayerRetiredErr, data.PlayerChangedErr:
+		http.Error(w, errMsg, http.StatusConflict)
+	default:
+		http.Error(w, errMsg, http.StatusBadRequest)
+	}
+}
+
+// writePlayer responds with the given player data
+func (ps *Server) writePlayer(w http.ResponseWriter, r *http.Request, player *data.PlayerData) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(player)
+	if err != nil {
+		errMsg := "error: could not encode updated player data: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// writeAdminJSON responds to an admin request with the given value, the kind is only used in the error message
+func (ps *Server) writeAdminJSON(w http.ResponseWriter, v any, kind string) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		errMsg := "error: could not encode " + kind + ": " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,171 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"net/http"
+)
+
+// AdminPlayerView is the response to the admin request to look up a player: their data,
+// along with the annotations customer service attached to them
+type AdminPlayerView struct {
+	Player      *data.PlayerData        `json:"playerData"`
+	Annotations []data.PlayerAnnotation `json:"annotations"`
+}
+
+// AnnotationRequestBody is used as the request body for the admin request to annotate a player,
+// the author is the support agent who added it (the admin actor, if not given)
+type AnnotationRequestBody struct {
+	PlayerID  string `json:"playerID"`
+	Kind      string `json:"kind"`
+	Text      string `json:"text"`
+	Reference string `json:"reference,omitempty"`
+	Author    string `json:"author,omitempty"`
+}
+
+// GetAdminPlayerView returns the data of the player along with their annotations
+func (ps *Server) GetAdminPlayerView(ctx context.Context, playerID string) (*AdminPlayerView, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.GetAdminPlayerView")
+	defer span.End()
+
+	player, err := ps.GetPlayer(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := ps.dataClient.ReadAnnotations(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AdminPlayerView{Player: player, Annotations: annotations.Annotations}, nil
+}
+
+// AddAnnotation attaches the annotation in the request to its (existing) player, and records it in the audit log
+func (ps *Server) AddAnnotation(ctx context.Context, request *AnnotationRequestBody) (*data.PlayerAnnotation, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.AddAnnotation")
+	defer span.End()
+
+	// annotations can only be attached to players who exist
+	_, err := ps.GetPlayer(ctx, request.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+
+	author := request.Author
+	if author == "" {
+		author = audit.ActorAdmin
+	}
+
+	annotation, err := ps.dataClient.AddAnnotation(ctx, &data.AnnotationAddition{
+		PlayerID: request.PlayerID,
+		Annotation: data.PlayerAnnotation{
+			Kind:      request.Kind,
+			Text:      request.Text,
+			Reference: request.Reference,
+			Author:    author,
+			CreatedAt: ps.clock.Now().UTC().Unix(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ps.auditRecorder.Record(ctx, audit.ActorAdmin, audit.ActionAnnotationAdd, request.PlayerID, annotation)
+
+	return annotation, nil
+}
+
+// RemoveAnnotation removes the given annotation of the player, and records the removed annotation in the audit log
+func (ps *Server) RemoveAnnotation(ctx context.Context, playerID string, annotationID string) (*data.PlayerAnnotation, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.RemoveAnnotation")
+	defer span.End()
+
+	annotation, err := ps.dataClient.RemoveAnnotation(ctx, playerID, annotationID)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.auditRecorder.Record(ctx, audit.ActorAdmin, audit.ActionAnnotationRemove, playerID, annotation)
+
+	return annotation, nil
+}
+
+// HandleAddAnnotationRequest attaches the annotation in the request body to its player (admin only)
+func (ps *Server) HandleAddAnnotationRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ps.validateAdmin(w, r) {
+		return
+	}
+
+	// decode the request body, which should be an AnnotationRequestBody struct
+	decodedReq := &AnnotationRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ps.logger.Printf("admin add annotation request for id: %v, kind: %v", decodedReq.PlayerID, decodedReq.Kind)
+
+	annotation, err := ps.AddAnnotation(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not add annotation: " + err.Error()
+		ps.logger.Println(errMsg)
+		ps.writeAdminError(w, err, errMsg)
+		return
+	}
+
+	ps.writeAdminJSON(w, annotation, "annotation")
+}
+
+// HandleRemoveAnnotationRequest removes the requested annotation of the requested player (admin only)
+func (ps *Server) HandleRemoveAnnotationRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ps.validateAdmin(w, r) {
+		return
+	}
+
+	playerID, annotationID := r.PathValue("id"), r.PathValue("annotationID")
+	ps.logger.Printf("admin remove annotation request for id: %v, annotation: %v", playerID, annotationID)
+
+	annotation, err := ps.RemoveAnnotation(r.Context(), playerID, annotationID)
+	if err != nil {
+		errMsg := "error: could not remove annotation: " + err.Error()
+		ps.logger.Println(errMsg)
+		ps.writeAdminError(w, err, errMsg)
+		return
+	}
+
+	ps.writeAdminJSON(w, annotation, "annotation")
+}
@@ -0,0 +1,127 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"net/http"
+)
+
+// NothingToClaimErr is returned when a player claims their banked energy,
+// but their energy bank is empty, or their energy is already at the max
+type NothingToClaimErr struct {
+	PlayerID string
+}
+
+func (err NothingToClaimErr) Error() string {
+	return fmt.Sprintf("player with id: %v has no banked energy to claim, or their energy is full", err.PlayerID)
+}
+
+// BankClaimRequestBody is used as the request body for the public request to claim banked energy
+type BankClaimRequestBody struct {
+	PlayerID string `json:"playerID"`
+}
+
+// BankedEnergy returns the banked energy the player has after the given energy delta is applied to them:
+// the part of a reward which goes above the max energy is banked, up to the bank cap
+// (a bank already above the cap, like after the cap was lowered, is kept as it is)
+func BankedEnergy(player *data.PlayerData, energyDelta int32, maxEnergy int32, bankCap int32) int32 {
+
+	overflow := min(player.Energy+energyDelta-maxEnergy, energyDelta)
+	if overflow <= 0 || player.BankedEnergy >= bankCap {
+		return player.BankedEnergy
+	}
+
+	return min(player.BankedEnergy+overflow, bankCap)
+}
+
+// ClaimBankedEnergy moves the player's banked energy into their energy (after passive energy regeneration),
+// as much of it as fits below the max energy, the rest stays in the bank.
+// It fails with a NothingToClaimErr if the bank is empty or the energy is already at the max
+func (ps *Server) ClaimBankedEnergy(ctx context.Context, playerID string) (*data.PlayerData, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.ClaimBankedEnergy")
+	defer span.End()
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	return ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+
+		// make the energy current first, then check how much of the bank fits
+		updateErr := ps.updateEnergy(player, 0)
+		if updateErr != nil {
+			return updateErr
+		}
+
+		claimed := min(player.BankedEnergy, ps.maxEnergy-player.Energy)
+		if claimed <= 0 {
+			return NothingToClaimErr{PlayerID: playerID}
+		}
+
+		player.BankedEnergy -= claimed
+		player.Energy += claimed
+		return nil
+	})
+}
+
+// HandleClaimBankedEnergyRequest moves the banked energy of the player in the request body into their energy,
+// and sends back the updated player data (a conflict status if there is nothing to claim)
+func (ps *Server) HandleClaimBankedEnergyRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request body, which should be a BankClaimRequestBody struct
+	decodedReq := &BankClaimRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &decodedReq.PlayerID) {
+		return
+	}
+
+	ps.logger.Printf("claim banked energy request for id: %v", decodedReq.PlayerID)
+
+	// while the energy bank is turned off, energy is still banked, it just cannot be claimed
+	if !ps.flags.Enabled(r.Context(), config.FlagEnergyBank, decodedReq.PlayerID) {
+		errMsg := "error: the energy bank is turned off"
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusServiceUnavailable, apierror.CodeUnavailable)
+		return
+	}
+
+	updatedPlayer, err := ps.ClaimBankedEnergy(r.Context(), decodedReq.PlayerID)
+	if err != nil {
+		errMsg := "error: could not claim banked energy: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		case NothingToClaimErr:
+			apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict)
+		default:
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		}
+		return
+	}
+
+	ps.writePlayer(w, r, updatedPlayer)
+}
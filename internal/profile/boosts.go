@@ -0,0 +1,165 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// BoostActivation is used as a request body for the internal request to give a player an energy boost
+type BoostActivation struct {
+	PlayerID        string `json:"playerID"`
+	BoostID         string `json:"boostID"`
+	RegenMultiplier int32  `json:"regenMultiplier"`
+	DurationSeconds int64  `json:"durationSeconds"`
+}
+
+// ActivateBoost gives the player an energy boost which multiplies their energy regeneration for the given duration,
+// the energy regenerated so far is applied first (at the old rate). Activating a boost which the player already has
+// active extends it by the given duration instead
+func (ps *Server) ActivateBoost(ctx context.Context, activation *BoostActivation) (*data.PlayerData, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.ActivateBoost")
+	defer span.End()
+
+	if activation == nil || activation.BoostID == "" {
+		return nil, fmt.Errorf("invalid boost activation")
+	}
+
+	if activation.RegenMultiplier <= 1 || activation.DurationSeconds <= 0 {
+		return nil, fmt.Errorf("the regen multiplier should be greater than 1 and the duration greater than 0, got: %v, %v", activation.RegenMultiplier, activation.DurationSeconds)
+	}
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	return ps.modifyPlayer(ctx, activation.PlayerID, func(player *data.PlayerData) error {
+
+		// make the energy current first, which also drops the expired boosts
+		updateErr := ps.updateEnergy(player, 0)
+		if updateErr != nil {
+			return updateErr
+		}
+
+		for i, boost := range player.Boosts {
+			if boost.BoostID == activation.BoostID {
+				player.Boosts[i].RegenMultiplier = max(boost.RegenMultiplier, activation.RegenMultiplier)
+				player.Boosts[i].ExpiryTime += activation.DurationSeconds
+				return nil
+			}
+		}
+
+		player.Boosts = append(player.Boosts, data.EnergyBoost{
+			BoostID:         activation.BoostID,
+			RegenMultiplier: activation.RegenMultiplier,
+			ExpiryTime:      player.LastUpdateTime + activation.DurationSeconds,
+		})
+		return nil
+	})
+}
+
+// HandleActivateBoostRequest is a wrapper around the ActivateBoost() method which will be used to field
+// internal (server to server) requests to give a player an energy boost
+func (ps *Server) HandleActivateBoostRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a BoostActivation struct
+	decodedReq := &BoostActivation{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ps.logger.Printf("activate boost request for id: %v, boost: %v", decodedReq.PlayerID, decodedReq.BoostID)
+
+	updatedPlayer, err := ps.ActivateBoost(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not activate boost: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	// create and send the response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(updatedPlayer)
+	if err != nil {
+		errMsg := "error: could not encode updated player data: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// activeBoosts returns the boosts which have not expired at the given time (unix seconds), nil if there are none
+func activeBoosts(boosts []data.EnergyBoost, now int64) []data.EnergyBoost {
+
+	var active []data.EnergyBoost
+	for _, boost := range boosts {
+		if boost.ExpiryTime > now {
+			active = append(active, boost)
+		}
+	}
+	return active
+}
+
+// boostSegment returns the regen multiplier at the given time (the highest one of the active boosts, 1 if there are none),
+// and the time (capped at the given end) at which it may change, when the first of the active boosts expires
+func boostSegment(boosts []data.EnergyBoost, at int64, end int64) (int32, int64) {
+
+	multiplier := int32(1)
+	for _, boost := range boosts {
+		if boost.ExpiryTime > at {
+			multiplier = max(multiplier, boost.RegenMultiplier)
+			end = min(end, boost.ExpiryTime)
+		}
+	}
+	return multiplier, end
+}
+
+// boostedSeconds returns the regeneration time between the given times (unix seconds),
+// every second counts as many times as the regen multiplier at that time (see boostSegment)
+func boostedSeconds(boosts []data.EnergyBoost, from int64, to int64) float64 {
+
+	total := 0.0
+	for from < to {
+		multiplier, segmentEnd := boostSegment(boosts, from, to)
+		total += float64(segmentEnd-from) * float64(multiplier)
+		from = segmentEnd
+	}
+	return total
+}
+
+// boostedSecondsReachedAt returns the time (unix seconds) at which the regeneration time counted from the given time
+// reaches the given amount (the inverse of boostedSeconds)
+func boostedSecondsReachedAt(boosts []data.EnergyBoost, from int64, amount float64) int64 {
+
+	for {
+		multiplier, segmentEnd := boostSegment(boosts, from, math.MaxInt64)
+		segmentSeconds := float64(segmentEnd-from) * float64(multiplier)
+		if amount <= segmentSeconds {
+			return from + int64(math.Ceil(amount/float64(multiplier)))
+		}
+		amount -= segmentSeconds
+		from = segmentEnd
+	}
+}
@@ -0,0 +1,399 @@
+package profile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+var clientNilError = fmt.Errorf("provided profile client pointer is nil")
+
+// ProfileClient implementor can return (and update) the player's live data
+// (implemented by the profile Server itself for in-process use, and by HTTPClient
+// when the profile service runs as its own microservice)
+type ProfileClient interface {
+	GetPlayer(ctx context.Context, playerID string) (*data.PlayerData, error)
+	UpdatePlayerData(ctx context.Context, energyDelta int32, newLevel int32) (*data.PlayerData, error)
+	ApplyLevelResult(ctx context.Context, update *LevelResultUpdate) (*data.PlayerData, error)
+	SpendEnergy(ctx context.Context, playerID string, energy int32) (*data.PlayerData, error)
+	RefundEnergy(ctx context.Context, refund *EnergyRefund) (*data.PlayerData, error)
+	ActivateBoost(ctx context.Context, activation *BoostActivation) (*data.PlayerData, error)
+	Prestige(ctx context.Context, playerID string) (*data.PlayerData, error)
+}
+
+// HTTPClient is the ProfileClient implementation which makes internal (server to server) requests to the profile service
+type HTTPClient struct {
+	baseURL string
+}
+
+// NewHTTPClient returns an initialized pointer to a profile client that talks to the profile service at its address in the startup config (see startup.ServiceURL)
+func NewHTTPClient() *HTTPClient {
+	return &HTTPClient{
+		baseURL: startup.ServiceURL("profile"),
+	}
+}
+
+// GetPlayer makes an internal request to the profile service to get the required player data
+func (hc *HTTPClient) GetPlayer(ctx context.Context, playerID string) (*data.PlayerData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/profile/player-data-internal/%v", hc.baseURL, playerID)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, data.PlayerNotFoundErr{PlayerID: playerID}
+		} else {
+			return nil, fmt.Errorf("internal get player data request was not successful, status code %v", resp.StatusCode)
+		}
+	}
+
+	//decode the response for the player data
+	playerData := &data.PlayerData{}
+	err = json.NewDecoder(resp.Body).Decode(playerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerData, nil
+}
+
+// UpdatePlayerData makes an internal request to the profile service to update the data of the player of the context
+func (hc *HTTPClient) UpdatePlayerData(ctx context.Context, energyDelta int32, newLevel int32) (*data.PlayerData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	playerID, err := playerctx.RequirePlayerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err = json.NewEncoder(reqBody).Encode(&PlayerIDLevelEnergy{
+		PlayerID:    playerID,
+		Level:       newLevel,
+		EnergyDelta: energyDelta,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "PUT", hc.baseURL+"/profile/player-data-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal update player request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the player data
+	playerData := &data.PlayerData{}
+	err = json.NewDecoder(resp.Body).Decode(playerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerData, nil
+}
+
+// SpendEnergy makes an internal request to the profile service to take energy from the required player
+func (hc *HTTPClient) SpendEnergy(ctx context.Context, playerID string, energy int32) (*data.PlayerData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(&EnergySpend{PlayerID: playerID, Energy: energy})
+	if err != nil {
+		return nil, err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/profile/energy-spend-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, data.PlayerNotFoundErr{PlayerID: playerID}
+	case http.StatusConflict:
+		return nil, InsufficientEnergyErr{PlayerID: playerID}
+	default:
+		return nil, fmt.Errorf("internal spend energy request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the player data
+	playerData := &data.PlayerData{}
+	err = json.NewDecoder(resp.Body).Decode(playerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerData, nil
+}
+
+// RefundEnergy makes an internal request to the profile service to give back the energy of a level attempt
+func (hc *HTTPClient) RefundEnergy(ctx context.Context, refund *EnergyRefund) (*data.PlayerData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if refund == nil {
+		return nil, fmt.Errorf("provided energy refund pointer is nil")
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(refund)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/profile/energy-refund-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, data.PlayerNotFoundErr{PlayerID: refund.PlayerID}
+	default:
+		return nil, fmt.Errorf("internal refund energy request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the player data
+	playerData := &data.PlayerData{}
+	err = json.NewDecoder(resp.Body).Decode(playerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerData, nil
+}
+
+// ApplyLevelResult makes an internal request to the profile service to apply a level result to the required player
+func (hc *HTTPClient) ApplyLevelResult(ctx context.Context, update *LevelResultUpdate) (*data.PlayerData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(update)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "PUT", hc.baseURL+"/profile/level-result-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, data.PlayerNotFoundErr{PlayerID: update.PlayerID}
+	default:
+		return nil, fmt.Errorf("internal apply level result request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the player data
+	playerData := &data.PlayerData{}
+	err = json.NewDecoder(resp.Body).Decode(playerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerData, nil
+}
+
+// ActivateBoost makes an internal request to the profile service to give the required player an energy boost
+func (hc *HTTPClient) ActivateBoost(ctx context.Context, activation *BoostActivation) (*data.PlayerData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(activation)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/profile/boost-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, data.PlayerNotFoundErr{PlayerID: activation.PlayerID}
+	default:
+		return nil, fmt.Errorf("internal activate boost request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the player data
+	playerData := &data.PlayerData{}
+	err = json.NewDecoder(resp.Body).Decode(playerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerData, nil
+}
+
+// Prestige makes an internal request to the profile service to take the required player back to the default level
+// at the next prestige rank
+func (hc *HTTPClient) Prestige(ctx context.Context, playerID string) (*data.PlayerData, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/profile/prestige-internal/%v", hc.baseURL, playerID)
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, data.PlayerNotFoundErr{PlayerID: playerID}
+	case http.StatusConflict:
+		return nil, PrestigeNotAllowedErr{PlayerID: playerID}
+	default:
+		return nil, fmt.Errorf("internal prestige request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the player data
+	playerData := &data.PlayerData{}
+	err = json.NewDecoder(resp.Body).Decode(playerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerData, nil
+}
@@ -0,0 +1,114 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+// LevelResultUpdate is used as the request body for the internal request to apply a level result to a player,
+// which is a player data update that also sets the win combo multiplier of the player (see config.BonusConfig)
+type LevelResultUpdate struct {
+	PlayerID        string  `json:"playerID"`
+	Level           int32   `json:"level"`
+	EnergyDelta     int32   `json:"energyDelta"`
+	ComboMultiplier float64 `json:"comboMultiplier"`
+}
+
+// ApplyLevelResult works like UpdatePlayerData, and also sets the combo multiplier of the player in the same write
+func (ps *Server) ApplyLevelResult(ctx context.Context, update *LevelResultUpdate) (*data.PlayerData, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.ApplyLevelResult")
+	defer span.End()
+
+	if update == nil || update.PlayerID == "" {
+		return nil, fmt.Errorf("cannot apply a level result without a player id")
+	}
+
+	if update.ComboMultiplier < 0 {
+		return nil, fmt.Errorf("the combo multiplier cannot be negative, got: %v", update.ComboMultiplier)
+	}
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	player, err := ps.modifyPlayer(ctx, update.PlayerID, func(player *data.PlayerData) error {
+
+		// update energy based on passive energy regeneration & the energy reward
+		updateErr := ps.updateEnergy(player, update.EnergyDelta)
+		if updateErr != nil {
+			return updateErr
+		}
+
+		// update level (if needed)
+		if player.Level < update.Level {
+			player.Level = min(update.Level, config.Config.LevelCount())
+		}
+
+		player.ComboMultiplier = update.ComboMultiplier
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if update.EnergyDelta >= constants.AuditEnergyGrantThreshold {
+		ps.auditRecorder.Record(ctx, audit.ActorSystem, audit.ActionEnergyGrant, update.PlayerID, map[string]int32{"energyDelta": update.EnergyDelta, "energy": player.Energy})
+	}
+
+	return player, nil
+}
+
+// HandleApplyLevelResultRequest is a wrapper around the ApplyLevelResult() method which will
+// be used to field internal (server to server) requests to return the updated player data
+func (ps *Server) HandleApplyLevelResultRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a LevelResultUpdate struct
+	decodedReq := &LevelResultUpdate{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ps.logger.Printf("apply level result request for id: %v", decodedReq.PlayerID)
+
+	updatedPlayer, err := ps.ApplyLevelResult(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not apply level result: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	// create and send the response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(updatedPlayer)
+	if err != nil {
+		errMsg := "error: could not encode updated player data: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,166 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// energyEventsKeepAlive is how often a keep alive comment is sent on an open energy events stream,
+// which is also when the player is read again (in case their energy was spent / granted in the meantime)
+const energyEventsKeepAlive time.Duration = 15 * time.Second
+
+// names of the server-sent events on the energy events stream
+const energyEventName = "energy"
+const energyFullEventName = "energy-full"
+
+// EnergyEvent is the data sent with each event on the energy events stream
+type EnergyEvent struct {
+	PlayerID     string `json:"playerID"`
+	Energy       int32  `json:"energy"`
+	MaxEnergy    int32  `json:"maxEnergy"`
+	SecondsToMax int64  `json:"secondsToMax"`
+}
+
+// HandleEnergyEventsRequest opens a server-sent events stream (for clients that cannot use WebSockets),
+// which sends an 'energy' event with the current energy right away, and an 'energy-full' event
+// when the player's energy reaches the max, after which the stream is closed.
+// The time to max energy is computed from the last update time and the regen rate, so the
+// player is only ever read (never written back) while the stream is open
+func (ps *Server) HandleEnergyEventsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errMsg := "error: streaming is not supported"
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// get the id from the request uri
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+	ps.logger.Printf("energy events requested for id: %v", id)
+
+	event, err := ps.energyEvent(r.Context(), id)
+	if err != nil {
+		errMsg := "get player error: " + err.Error()
+		ps.logger.Println(errMsg)
+		if errors.Is(err, data.PlayerNotFoundErr{PlayerID: id}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		} else {
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	err = writeEvent(w, energyEventName, event)
+	if err != nil {
+		ps.logger.Println("error: could not write energy event: " + err.Error())
+		return
+	}
+	flusher.Flush()
+
+	for event.SecondsToMax != 0 {
+
+		// wait till the energy should be full (or till the next keep alive, whichever comes first)
+		wait := energyEventsKeepAlive
+		if event.SecondsToMax > 0 {
+			wait = min(wait, time.Duration(event.SecondsToMax)*time.Second)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		event, err = ps.energyEvent(r.Context(), id)
+		if err != nil {
+			ps.logger.Println("error: could not read player for energy events: " + err.Error())
+			return
+		}
+
+		if event.SecondsToMax != 0 {
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+
+	err = writeEvent(w, energyFullEventName, event)
+	if err != nil {
+		ps.logger.Println("error: could not write energy full event: " + err.Error())
+		return
+	}
+	flusher.Flush()
+}
+
+// energyEvent reads the player (without writing it back), and returns their current energy
+// and the seconds left till it reaches the max
+func (ps *Server) energyEvent(ctx context.Context, playerID string) (*EnergyEvent, error) {
+
+	player, err := ps.dataClient.ReadPlayer(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ps.clock.Now().UTC().Unix()
+
+	return &EnergyEvent{
+		PlayerID:     playerID,
+		Energy:       ps.regeneratedEnergy(player, now),
+		MaxEnergy:    ps.maxEnergy,
+		SecondsToMax: ps.secondsToMaxEnergy(player, now),
+	}, nil
+}
+
+// secondsToMaxEnergy returns the number of seconds left (from now) till the player's energy reaches the max
+// based on passive energy regeneration (and their energy boosts), 0 if it is already there, and -1 if it never will (no regeneration)
+func (ps *Server) secondsToMaxEnergy(player *data.PlayerData, now int64) int64 {
+
+	if ps.regeneratedEnergy(player, now) >= ps.maxEnergy {
+		return 0
+	}
+
+	if ps.energyRegenPerSecond <= 0 {
+		return -1
+	}
+
+	// energy regenerates from the last update time, so the max is reached at a fixed point in time
+	maxReachedAt := boostedSecondsReachedAt(player.Boosts, player.LastUpdateTime, float64(ps.maxEnergy-player.Energy)/ps.energyRegenPerSecond)
+	return max(maxReachedAt-now, 1)
+}
+
+// writeEvent writes a single server-sent event with the given name and json encoded data
+func writeEvent(w http.ResponseWriter, name string, eventData any) error {
+
+	encoded, err := json.Marshal(eventData)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %v\ndata: %s\n\n", name, encoded)
+	return err
+}
@@ -0,0 +1,171 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"net/http"
+)
+
+// FTUEStepSkippedErr is returned when a player advances their first time user experience (FTUE)
+// by more than one step, the steps have to be completed in order
+type FTUEStepSkippedErr struct {
+	PlayerID    string
+	Step        int32
+	CurrentStep int32
+}
+
+func (err FTUEStepSkippedErr) Error() string {
+	return fmt.Sprintf("player with id: %v cannot complete FTUE step %v before step %v", err.PlayerID, err.Step, err.CurrentStep+1)
+}
+
+// FTUERequestBody is used as the request body for the public request to advance the FTUE of a player
+// (to the step they completed), and for the admin request to reset it (the step is not used)
+type FTUERequestBody struct {
+	PlayerID string `json:"playerID"`
+	Step     int32  `json:"step,omitempty"`
+}
+
+// AdvanceFTUE records that the player completed the given step of the first time user experience,
+// which has to be the step after the last one they completed. Completing a step again changes nothing
+// (so the client can safely retry), and skipping ahead fails with a FTUEStepSkippedErr
+func (ps *Server) AdvanceFTUE(ctx context.Context, playerID string, step int32) (*data.PlayerData, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.AdvanceFTUE")
+	defer span.End()
+
+	if step <= 0 || step > config.Config.FTUESteps {
+		return nil, fmt.Errorf("the FTUE step should be between 1 and %v, got: %v", config.Config.FTUESteps, step)
+	}
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	return ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+
+		if step > player.FTUEStep+1 {
+			return FTUEStepSkippedErr{PlayerID: playerID, Step: step, CurrentStep: player.FTUEStep}
+		}
+
+		player.FTUEStep = max(player.FTUEStep, step)
+		return nil
+	})
+}
+
+// ResetFTUE takes the player back to the start of the first time user experience (like for a support case, or QA),
+// the levels which require an FTUE step are locked for them again till they complete it
+func (ps *Server) ResetFTUE(ctx context.Context, playerID string) (*data.PlayerData, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.ResetFTUE")
+	defer span.End()
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	stepBefore := int32(0)
+	player, err := ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+		stepBefore = player.FTUEStep
+		player.FTUEStep = 0
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ps.auditRecorder.Record(ctx, audit.ActorAdmin, audit.ActionFTUEReset, playerID, map[string]int32{"stepBefore": stepBefore})
+
+	return player, nil
+}
+
+// HandleAdvanceFTUERequest advances the FTUE of the player in the request body to the step they completed,
+// and sends back the updated player data (a conflict status if a step was skipped)
+func (ps *Server) HandleAdvanceFTUERequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request body, which should be a FTUERequestBody struct
+	decodedReq := &FTUERequestBody{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &decodedReq.PlayerID) {
+		return
+	}
+
+	ps.logger.Printf("advance FTUE request for id: %v, step: %v", decodedReq.PlayerID, decodedReq.Step)
+
+	updatedPlayer, err := ps.AdvanceFTUE(r.Context(), decodedReq.PlayerID, decodedReq.Step)
+	if err != nil {
+		errMsg := "error: could not advance the FTUE: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		case FTUEStepSkippedErr:
+			apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict)
+		default:
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		}
+		return
+	}
+
+	ps.writePlayer(w, r, updatedPlayer)
+}
+
+// HandleResetFTUERequest resets the FTUE of the player in the request body (admin only)
+func (ps *Server) HandleResetFTUERequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ps.validateAdmin(w, r) {
+		return
+	}
+
+	// decode the request body, which should be a FTUERequestBody struct
+	decodedReq := &FTUERequestBody{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ps.logger.Printf("admin reset FTUE request for id: %v", decodedReq.PlayerID)
+
+	updatedPlayer, err := ps.ResetFTUE(r.Context(), decodedReq.PlayerID)
+	if err != nil {
+		errMsg := "error: could not reset the FTUE: " + err.Error()
+		ps.logger.Println(errMsg)
+		ps.writeAdminError(w, err, errMsg)
+		return
+	}
+
+	ps.writePlayer(w, r, updatedPlayer)
+}
@@ -0,0 +1,224 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// MergeRequestBody is used as the request body for the admin request to merge a duplicate (secondary) player into
+// a primary one
+type MergeRequestBody struct {
+	PrimaryID   string `json:"primaryID"`
+	SecondaryID string `json:"secondaryID"`
+}
+
+// MergePlayers merges the secondary player into the primary one (like a guest account into the registered account of
+// the same person): their energy is combined (up to the max energy, and their banked energy up to the bank cap), the
+// primary player gets the higher of their levels, FTUE steps and prestige ranks, and the energy boosts of both, and
+// their stats are merged level by level (see data.MergeStats). The secondary player id is retired, with a redirect to
+// the primary one, so logging in to the secondary account logs in to the primary player from then on, and its sessions
+// are signed out (when the request validator of the server can revoke sessions, see validation.SessionRevoker).
+// The actor is recorded in the audit log (the admin, or the player merging their own accounts)
+func (ps *Server) MergePlayers(ctx context.Context, primaryID string, secondaryID string, actor string) (*data.PlayerWithStats, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.MergePlayers")
+	defer span.End()
+
+	if primaryID == "" || secondaryID == "" || primaryID == secondaryID {
+		return nil, fmt.Errorf("a merge needs two different player ids, got: %q and %q", primaryID, secondaryID)
+	}
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	for attempt := 1; ; attempt++ {
+
+		// send requests to the data service to look both players up
+		primary, err := ps.dataClient.ReadPlayer(ctx, primaryID)
+		if err != nil {
+			return nil, err
+		}
+		secondary, err := ps.dataClient.ReadPlayer(ctx, secondaryID)
+		if err != nil {
+			return nil, err
+		}
+
+		merge := &data.PlayerMerge{
+			Expected:          primary.Clone(),
+			ExpectedSecondary: secondary.Clone(),
+			MergeTime:         ps.clock.Now().UTC().Unix(),
+		}
+
+		merge.Merged, err = ps.mergedPlayer(primary, secondary)
+		if err != nil {
+			return nil, err
+		}
+
+		// send request to the data service to write the merge (if neither player has changed)
+		merged, err := ps.dataClient.MergePlayers(ctx, merge)
+		if _, changed := err.(data.PlayerChangedErr); changed && attempt < maxPlayerWriteAttempts {
+			ps.logger.Printf("player id %v or %v changed while being merged, retrying", primaryID, secondaryID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ps.auditRecorder.Record(ctx, actor, audit.ActionAccountMerge, primaryID, map[string]any{
+			"secondaryID": secondaryID, "primaryBefore": merge.Expected, "secondaryBefore": merge.ExpectedSecondary, "merged": merged.Player,
+		})
+
+		// the sessions of the retired player id are signed out (they would only get 404s from now on), the merge is
+		// written already, so a failure is only logged
+		if revoker, ok := ps.requestValidator.(validation.SessionRevoker); ok {
+			err = revoker.RevokePlayerSessions(ctx, secondaryID)
+			if err != nil {
+				ps.logger.Printf("error: could not sign out the sessions of merged player id %v: %v", secondaryID, err)
+			}
+		}
+
+		return merged, nil
+	}
+}
+
+// mergedPlayer returns the primary player with the secondary player merged into it (see MergePlayers),
+// after making the energy of both current
+func (ps *Server) mergedPlayer(primary *data.PlayerData, secondary *data.PlayerData) (data.PlayerData, error) {
+
+	for _, player := range []*data.PlayerData{primary, secondary} {
+		err := ps.updateEnergy(player, 0)
+		if err != nil {
+			return data.PlayerData{}, err
+		}
+	}
+
+	merged := primary.Clone()
+
+	// the combined energy is capped at the max energy, and the combined banked energy at the bank cap
+	// (a primary bank already above the cap is kept as it is, like in BankedEnergy)
+	merged.Energy = min(primary.Energy+secondary.Energy, ps.maxEnergy)
+	merged.BankedEnergy = min(primary.BankedEnergy+secondary.BankedEnergy, max(ps.energyBankCap, primary.BankedEnergy))
+	merged.Level = min(max(primary.Level, secondary.Level), config.Config.LevelCount())
+	merged.FTUEStep = max(primary.FTUEStep, secondary.FTUEStep)
+	merged.PrestigeRank = max(primary.PrestigeRank, secondary.PrestigeRank)
+	merged.Boosts = append(slices.Clone(primary.Boosts), secondary.Boosts...)
+
+	// the merged player is as old as the older of the two (0 is an unknown creation time)
+	if secondary.CreatedTime != 0 && (merged.CreatedTime == 0 || secondary.CreatedTime < merged.CreatedTime) {
+		merged.CreatedTime = secondary.CreatedTime
+	}
+
+	return merged, nil
+}
+
+// HandleAdminMergeRequest merges the secondary player in the request body into the primary one (admin only),
+// and responds with the merged player and their stats
+func (ps *Server) HandleAdminMergeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ps.validateAdmin(w, r) {
+		return
+	}
+
+	// decode the request body, which should be a MergeRequestBody struct
+	decodedReq := &MergeRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ps.logger.Printf("admin merge request of id: %v into id: %v", decodedReq.SecondaryID, decodedReq.PrimaryID)
+
+	merged, err := ps.MergePlayers(r.Context(), decodedReq.PrimaryID, decodedReq.SecondaryID, audit.ActorAdmin)
+	if err != nil {
+		errMsg := "error: could not merge players: " + err.Error()
+		ps.logger.Println(errMsg)
+		ps.writeAdminError(w, err, errMsg)
+		return
+	}
+
+	ps.writeAdminJSON(w, merged, "merged player")
+}
+
+// HandleMergeRequest merges the account of the session in the secondary session header into the account of the
+// session of the request (a player holding sessions of both accounts owns both), and responds with the merged player
+// and their stats
+func (ps *Server) HandleMergeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	primaryID, err := playerctx.RequirePlayerID(r.Context())
+	if err != nil {
+		ps.logger.Println("error: " + err.Error())
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		return
+	}
+
+	// the secondary session is checked like a read, since the request's nonce (if any) is used by its own session
+	secondaryReq, err := http.NewRequestWithContext(r.Context(), http.MethodGet, r.URL.String(), nil)
+	if err != nil {
+		errMsg := "error: could not create the secondary session check: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+	secondaryReq.Header.Set("Session-Id", r.Header.Get(constants.SecondarySessionHeader))
+
+	secondaryID, err := ps.requestValidator.ValidateRequest(secondaryReq)
+	if err != nil {
+		errMsg := "error: invalid secondary session: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden)
+		return
+	}
+
+	ps.logger.Printf("merge request of id: %v into id: %v", secondaryID, primaryID)
+
+	merged, err := ps.MergePlayers(r.Context(), primaryID, secondaryID, primaryID)
+	if err != nil {
+		errMsg := "error: could not merge players: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		case data.PlayerRetiredErr, data.PlayerChangedErr:
+			apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict)
+		default:
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(merged)
+	if err != nil {
+		errMsg := "error: could not encode merged player: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
@@ -0,0 +1,91 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+// PrestigeNotAllowedErr is returned when a player who is not at the last level,
+// or is already at the highest prestige rank, tries to prestige
+type PrestigeNotAllowedErr struct {
+	PlayerID string
+}
+
+func (err PrestigeNotAllowedErr) Error() string {
+	return fmt.Sprintf("player with id: %v cannot prestige, they should be at the last level and below the highest prestige rank", err.PlayerID)
+}
+
+// Prestige takes the player from the last level back to the default level, and raises their prestige rank by one
+// (see config.PrestigeConfig), the check and the reset are done atomically, so a player can only prestige once per run
+func (ps *Server) Prestige(ctx context.Context, playerID string) (*data.PlayerData, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.Prestige")
+	defer span.End()
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	return ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+
+		if player.Level < config.Config.LevelCount() || player.PrestigeRank >= config.Config.Prestige.MaxRank() {
+			return PrestigeNotAllowedErr{PlayerID: playerID}
+		}
+
+		// make the energy current first (the player keeps it)
+		updateErr := ps.updateEnergy(player, 0)
+		if updateErr != nil {
+			return updateErr
+		}
+
+		player.Level = config.Config.DefaultLevel
+		player.PrestigeRank += 1
+		return nil
+	})
+}
+
+// HandlePrestigeRequest is a wrapper around the Prestige() method which will be used to field
+// internal (server to server) requests to prestige a player
+func (ps *Server) HandlePrestigeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// get the id from the request uri
+	id := r.PathValue("id")
+	ps.logger.Printf("prestige request for id: %v", id)
+
+	updatedPlayer, err := ps.Prestige(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not prestige: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case PrestigeNotAllowedErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	// create and send the response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(updatedPlayer)
+	if err != nil {
+		errMsg := "error: could not encode updated player data: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
@@ -2,18 +2,25 @@
 package profile
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"example.com/dice-game-backend/internal/config"
 	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/clock"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"example.com/dice-game-backend/internal/shared/validation"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 )
@@ -21,6 +28,22 @@ import (
 // Profile Specific Errors:
 var serverNilError = fmt.Errorf("provided profile server pointer is nil")
 
+type InsufficientEnergyErr struct {
+	PlayerID string
+}
+
+func (err InsufficientEnergyErr) Error() string {
+	return fmt.Sprintf("player with id: %v does not have enough energy", err.PlayerID)
+}
+
+// maxPlayerWriteAttempts is how many times a player update is tried, when the player data
+// keeps changing (via another profile server) between reading and writing it
+const maxPlayerWriteAttempts = 3
+
+// refundedAttemptExpirySeconds is how long the attempts whose energy was refunded are remembered (see RefundEnergy),
+// much longer than an attempt can be refunded after its entry
+const refundedAttemptExpirySeconds = 24 * 60 * 60
+
 // Profile structs (not used in data storage):
 
 // NewPlayerRequestBody just contains the player ID
@@ -36,33 +59,72 @@ type PlayerIDLevelEnergy struct {
 	EnergyDelta int32  `json:"energyDelta"`
 }
 
+// EnergySpend is used as a request body for the internal request to take energy from a player
+type EnergySpend struct {
+	PlayerID string `json:"playerID"`
+	Energy   int32  `json:"energy"`
+}
+
+// EnergyRefund is used as a request body for the internal request to give back the energy a player spent on a level
+// attempt which never got a result, the actor is the one recorded in the audit log (the system, if it is left out)
+type EnergyRefund struct {
+	PlayerID  string `json:"playerID"`
+	AttemptID string `json:"attemptID,omitempty"`
+	Energy    int32  `json:"energy"`
+	Actor     string `json:"actor,omitempty"`
+}
+
 // Server is the core profile service provider
 type Server struct {
 	playersMutex sync.Mutex
 
+	// the (unix) times the level attempts were refunded, by namespace and attempt id, so each is only refunded once
+	refundedAttempts map[refundedAttemptKey]int64
+
 	defaultLevel         int32
-	maxLevel             int32
 	maxEnergy            int32
+	energyBankCap        int32
 	energyRegenPerSecond float64
 
 	requestValidator validation.RequestValidator
+	dataClient       data.DataClient
+
+	auditRecorder *audit.Recorder
+
+	// the feature flags are checked before serving the newer features (see EnableFeatureFlags)
+	flags *config.FlagChecker
+
+	// the time is read from the clock, which is an offset clock admins can move in the time offset mode
+	// (see EnableTimeOffset)
+	clock      clock.Clock
+	timeOffset *clock.Offset
 
 	logger *log.Logger
 }
 
 // NewServer returns an initialized pointer to the profile server
-func NewServer(rv validation.RequestValidator) *Server {
+func NewServer(rv validation.RequestValidator, dc data.DataClient) *Server {
+
+	logger := log.New(redact.Stdout, "profile: ", log.Ltime|log.LUTC|log.Lmsgprefix)
 
 	ps := &Server{
 		playersMutex: sync.Mutex{},
 
+		refundedAttempts: make(map[refundedAttemptKey]int64),
+
 		defaultLevel:         config.Config.DefaultLevel,
-		maxLevel:             int32(len(config.Config.Levels)),
 		maxEnergy:            config.Config.MaxEnergy,
+		energyBankCap:        config.Config.EnergyBankCap,
 		energyRegenPerSecond: 0,
 
 		requestValidator: rv,
-		logger:           log.New(os.Stdout, "profile: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+		dataClient:       dc,
+
+		auditRecorder: audit.NewRecorder(dc, logger),
+
+		clock: clock.System,
+
+		logger: logger,
 	}
 
 	// avoid divide by zero
@@ -73,54 +135,165 @@ func NewServer(rv validation.RequestValidator) *Server {
 	return ps
 }
 
+// EnableFeatureFlags makes the server check the feature flags of its newer features with the given flag checker
+// (without it, the default flags are used)
+func (ps *Server) EnableFeatureFlags(fc *config.FlagChecker) {
+
+	if ps == nil {
+		return
+	}
+
+	ps.flags = fc
+}
+
+// SetClock changes the clock the server reads the time from (the system clock by default),
+// tests use a frozen clock (see clock.Frozen), it should be called before Run
+func (ps *Server) SetClock(c clock.Clock) {
+
+	if ps == nil || c == nil {
+		return
+	}
+
+	ps.clock = c
+}
+
+// EnableTimeOffsetFromEnv enables the time offset mode if the time offset environment variable
+// (see constants.TimeOffsetEnvVar) is set to true, the server reads the time of its clock as it is if it is not set
+func (ps *Server) EnableTimeOffsetFromEnv() error {
+
+	if ps == nil {
+		return serverNilError
+	}
+
+	enabled, err := clock.OffsetModeFromEnv()
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		ps.EnableTimeOffset(clock.NewOffset(ps.clock, ps.logger))
+	}
+	return nil
+}
+
+// EnableTimeOffset makes the server read the time from the given offset clock, which admins can move ahead or back
+// with the time offset request (servers given the same offset clock move together), it should be called before Run
+func (ps *Server) EnableTimeOffset(oc *clock.Offset) {
+
+	if ps == nil || oc == nil {
+		return
+	}
+
+	ps.logger.Println("the time offset mode is enabled, admins can move the clock of the server")
+	ps.clock = oc
+	ps.timeOffset = oc
+}
+
+// HandleTimeOffsetRequest responds with the time offset of the server (admin only), after changing it for PUT requests,
+// see clock.Offset.HandleOffsetRequest, it responds with 503 if the time offset mode is not enabled
+func (ps *Server) HandleTimeOffsetRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ps.timeOffset.HandleOffsetRequest(w, r)
+}
+
 // Run runs a given profile server on the given port
 func (ps *Server) Run(port string) {
+	log.Fatal(ps.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the profile server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (ps *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ps.serve(ctx, listener)
+}
+
+// RunWithListener runs the profile server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (ps *Server) RunWithListener(listener net.Listener) error {
+	return ps.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the profile server, and serves them on the given listener till the context is done
+func (ps *Server) serve(ctx context.Context, listener net.Listener) error {
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /profile/new-player", ps.HandleNewPlayerRequest)
-	mux.HandleFunc("GET /profile/player-data/{id}", ps.HandlePlayerDataRequest)
-	mux.HandleFunc("PUT /profile/player-data-internal", ps.HandleUpdatePlayerRequest)
+	mux.Handle("POST /profile/new-player", middleware.WithLimits(validation.WithSession(ps.requestValidator, ps.HandleNewPlayerRequest), middleware.DefaultLimits))
+	mux.Handle("GET /profile/player-data/{id}", middleware.WithLimits(validation.WithSession(ps.requestValidator, ps.HandlePlayerDataRequest), middleware.DefaultLimits))
+	mux.Handle("GET /profile/sync/{id}", middleware.WithLimits(validation.WithSession(ps.requestValidator, ps.HandleSyncRequest), middleware.DefaultLimits))
+	mux.Handle("POST /profile/energy-bank/claim", middleware.WithLimits(validation.WithSession(ps.requestValidator, ps.HandleClaimBankedEnergyRequest), middleware.DefaultLimits))
+	mux.Handle("POST /profile/ftue/advance", middleware.WithLimits(validation.WithSession(ps.requestValidator, ps.HandleAdvanceFTUERequest), middleware.DefaultLimits))
+	mux.Handle("POST /profile/merge", middleware.WithLimits(validation.WithSession(ps.requestValidator, ps.HandleMergeRequest), middleware.DefaultLimits))
+	mux.Handle("GET /profile/player-data-internal/{id}", middleware.WithLimits(ps.HandleGetPlayerRequest, middleware.DefaultLimits))
+	mux.Handle("PUT /profile/player-data-internal", middleware.WithLimits(ps.HandleUpdatePlayerRequest, middleware.DefaultLimits))
+	mux.Handle("PUT /profile/level-result-internal", middleware.WithLimits(ps.HandleApplyLevelResultRequest, middleware.DefaultLimits))
+	mux.Handle("POST /profile/energy-spend-internal", middleware.WithLimits(ps.HandleSpendEnergyRequest, middleware.DefaultLimits))
+	mux.Handle("POST /profile/energy-refund-internal", middleware.WithLimits(ps.HandleRefundEnergyRequest, middleware.DefaultLimits))
+	mux.Handle("POST /profile/boost-internal", middleware.WithLimits(ps.HandleActivateBoostRequest, middleware.DefaultLimits))
+	mux.Handle("POST /profile/prestige-internal/{id}", middleware.WithLimits(ps.HandlePrestigeRequest, middleware.DefaultLimits))
+	mux.Handle("GET /profile/admin/player/{id}", middleware.WithLimits(ps.HandleAdminGetPlayerRequest, middleware.DefaultLimits))
+	mux.Handle("PUT /profile/admin/player", middleware.WithLimits(ps.HandleSetPlayerRequest, middleware.DefaultLimits))
+	mux.Handle("POST /profile/admin/grant-energy", middleware.WithLimits(ps.HandleGrantEnergyRequest, middleware.DefaultLimits))
+	mux.Handle("POST /profile/admin/ftue/reset", middleware.WithLimits(ps.HandleResetFTUERequest, middleware.DefaultLimits))
+	mux.Handle("POST /profile/admin/merge", middleware.WithLimits(ps.HandleAdminMergeRequest, middleware.DefaultLimits))
+	mux.Handle("POST /profile/admin/annotations", middleware.WithLimits(ps.HandleAddAnnotationRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /profile/admin/annotations/{id}/{annotationID}", middleware.WithLimits(ps.HandleRemoveAnnotationRequest, middleware.DefaultLimits))
+	mux.Handle("GET /profile/admin/time-offset", middleware.WithLimits(ps.HandleTimeOffsetRequest, middleware.DefaultLimits))
+	mux.Handle("PUT /profile/admin/time-offset", middleware.WithLimits(ps.HandleTimeOffsetRequest, middleware.DefaultLimits))
+
+	// the energy events stream stays open till the energy is full, so it is not given a timeout
+	mux.Handle("GET /profile/energy-events/{id}", middleware.WithLimits(validation.WithSession(ps.requestValidator, ps.HandleEnergyEventsRequest), middleware.RouteLimits{MaxBodyBytes: constants.DefaultMaxRequestBodyBytes}))
 
 	ps.logger.Println("the profile server is up and running...")
 
-	addr := constants.CommonHost + ":" + port
-	log.Fatal(http.ListenAndServe(addr, mux))
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("profile", middleware.WithAccessLog("profile", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
 }
 
 // HandleNewPlayerRequest creates a new player in the map
 func (ps *Server) HandleNewPlayerRequest(w http.ResponseWriter, r *http.Request) {
 
 	if ps == nil {
-		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	err := ps.requestValidator.ValidateRequest(r)
-	if err != nil {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
-		errMsg := "error: session validation error: " + err.Error()
-		ps.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusUnauthorized)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
 	// decode the request body for the player ID
 	decodedReq := &NewPlayerRequestBody{}
-	err = json.NewDecoder(r.Body).Decode(decodedReq)
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
 	if err != nil {
 		errMsg := "error: could not decode player id: " + err.Error()
 		ps.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &decodedReq.PlayerID) {
 		return
 	}
 
 	// create the new player struct from the player ID
+	now := ps.clock.Now().UTC().Unix()
 	newPlayer := &data.PlayerData{
 		PlayerID:       decodedReq.PlayerID,
 		Level:          ps.defaultLevel,
 		Energy:         ps.maxEnergy,
-		LastUpdateTime: time.Now().UTC().Unix(),
+		LastUpdateTime: now,
+		CreatedTime:    now,
+		Segment:        config.Config.Segments.SegmentOf(now, 0, now),
+		Version:        data.PlayerDataVersion,
 	}
 
 	ps.playersMutex.Lock()
@@ -128,22 +301,22 @@ func (ps *Server) HandleNewPlayerRequest(w http.ResponseWriter, r *http.Request)
 
 	// check with the data service to see if the player exists already (they should not)
 	// so successful get here means failure for us!
-	_, err = ps.readPlayerFromDB(decodedReq.PlayerID)
+	_, err = ps.dataClient.ReadPlayer(r.Context(), decodedReq.PlayerID)
 	if err == nil {
 		errMsg := "error: player exists already"
 		ps.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
 		return
 	}
 
 	ps.logger.Printf("creating new player with id: %v", newPlayer.PlayerID)
 
 	// tell the data service to store the new player in the player DB
-	err = ps.writePlayerToDB(newPlayer)
+	err = ps.dataClient.WritePlayer(r.Context(), newPlayer)
 	if err != nil {
 		errMsg := "DB write error: " + err.Error()
 		ps.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
@@ -153,118 +326,175 @@ func (ps *Server) HandleNewPlayerRequest(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		errMsg := "error: could not encode player data: " + err.Error()
 		ps.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 	}
 }
 
-// HandlePlayerDataRequest responds with the player data
+// HandlePlayerDataRequest responds with the player data, with its last update time in the Last-Modified header.
+// A request with an If-Modified-Since header gets a 304 (without the body) if the player data has not changed since then,
+// and HEAD requests only get the headers
 func (ps *Server) HandlePlayerDataRequest(w http.ResponseWriter, r *http.Request) {
 
 	if ps == nil {
-		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	err := ps.requestValidator.ValidateRequest(r)
-	if err != nil {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
-		errMsg := "error: session validation error: " + err.Error()
-		ps.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusUnauthorized)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
 	// get the id from the request uri
 	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
 	ps.logger.Printf("player data requested for id: %v", id)
 
-	player, err := ps.GetPlayer(id)
+	// a player whose data has not changed since the time the client has is not read through GetPlayer,
+	// since that would move the last update time forward (read errors are left to GetPlayer to report)
+	if since, parseErr := http.ParseTime(r.Header.Get("If-Modified-Since")); parseErr == nil {
+		lastModified, unchanged := ps.unchangedSince(r.Context(), id)
+		if unchanged && lastModified <= since.Unix() {
+			w.Header().Set("Last-Modified", time.Unix(lastModified, 0).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	player, err := ps.GetPlayer(r.Context(), id)
 	if err != nil {
 		errMsg := "get player error: " + err.Error()
 		ps.logger.Println(errMsg)
 		if errors.Is(err, data.PlayerNotFoundErr{PlayerID: id}) {
-			http.Error(w, errMsg, http.StatusNotFound)
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
 		} else {
-			http.Error(w, errMsg, http.StatusBadRequest)
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
 		}
 		return
 	}
 
 	// send the response back
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", time.Unix(player.LastUpdateTime, 0).UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+
 	err = json.NewEncoder(w).Encode(player)
 	if err != nil {
 		errMsg := "error: could not encode player data: " + err.Error()
 		ps.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 	}
 }
 
+// unchangedSince returns the last update time of the stored player data, and whether reading the player now
+// would leave it as it is (apart from the time), i.e. no energy has been regenerated and no boost has run out since then
+func (ps *Server) unchangedSince(ctx context.Context, playerID string) (int64, bool) {
+
+	player, err := ps.dataClient.ReadPlayer(ctx, playerID)
+	if err != nil {
+		return 0, false
+	}
+
+	now := ps.clock.Now().UTC().Unix()
+	unchanged := ps.regeneratedEnergy(player, now) == player.Energy && len(activeBoosts(player.Boosts, now)) == len(player.Boosts)
+	return player.LastUpdateTime, unchanged
+}
+
 // GetPlayer returns the player data when requested, with updated energy from the passive regeneration
-func (ps *Server) GetPlayer(playerID string) (*data.PlayerData, error) {
+func (ps *Server) GetPlayer(ctx context.Context, playerID string) (*data.PlayerData, error) {
 
 	if ps == nil {
 		return nil, serverNilError
 	}
 
+	ctx, span := tracing.Start(ctx, "profile.GetPlayer")
+	defer span.End()
+
 	ps.playersMutex.Lock()
 	defer ps.playersMutex.Unlock()
 
-	// send request to the data service to look the player up
-	player, err := ps.readPlayerFromDB(playerID)
-	if err != nil {
-		return nil, err
+	// passive energy regeneration
+	return ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+		return ps.updateEnergy(player, 0)
+	})
+}
+
+// HandleGetPlayerRequest is a wrapper around the GetPlayer() method which will
+// be used to field internal (server to server) requests to return player data
+func (ps *Server) HandleGetPlayerRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// passive energy regeneration
-	err = ps.updateEnergy(player, 0)
+	// get the id from the request uri
+	id := r.PathValue("id")
+	ps.logger.Printf("internal player data request for id: %v", id)
+
+	player, err := ps.GetPlayer(r.Context(), id)
 	if err != nil {
-		return nil, err
+		errMsg := "get player error: " + err.Error()
+		ps.logger.Println(errMsg)
+		if errors.Is(err, data.PlayerNotFoundErr{PlayerID: id}) {
+			http.Error(w, errMsg, http.StatusNotFound)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
 	}
 
-	// send request to the data service to write the player back to the DB
-	err = ps.writePlayerToDB(player)
+	// send the response back
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(player)
 	if err != nil {
-		return nil, err
+		errMsg := "error: could not encode player data: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
 	}
-
-	return player, nil
 }
 
-// UpdatePlayerData will first apply passive energy regeneration to the player,
+// UpdatePlayerData will first apply passive energy regeneration to the player of the context (see playerctx),
 // then apply the given energy delta, and finally change the level of the player if needed
-func (ps *Server) UpdatePlayerData(playerID string, energyDelta int32, newLevel int32) (*data.PlayerData, error) {
+func (ps *Server) UpdatePlayerData(ctx context.Context, energyDelta int32, newLevel int32) (*data.PlayerData, error) {
 
 	if ps == nil {
 		return nil, serverNilError
 	}
 
-	ps.playersMutex.Lock()
-	defer ps.playersMutex.Unlock()
-
-	// send request to the data service to look the player up
-	player, err := ps.readPlayerFromDB(playerID)
+	playerID, err := playerctx.RequirePlayerID(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// update energy based on passive energy regeneration & new energyDelta
-	err = ps.updateEnergy(player, energyDelta)
-	if err != nil {
-		return nil, err
-	}
+	ctx, span := tracing.Start(ctx, "profile.UpdatePlayerData")
+	defer span.End()
 
-	// update level (if needed)
-	if player.Level < newLevel {
-		player.Level = min(newLevel, ps.maxLevel)
-	}
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	player, err := ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+
+		// update energy based on passive energy regeneration & new energyDelta
+		updateErr := ps.updateEnergy(player, energyDelta)
+		if updateErr != nil {
+			return updateErr
+		}
+
+		// update level (if needed)
+		if player.Level < newLevel {
+			player.Level = min(newLevel, config.Config.LevelCount())
+		}
 
-	// send request to the data service to write back the player
-	err = ps.writePlayerToDB(player)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	if energyDelta >= constants.AuditEnergyGrantThreshold {
+		ps.auditRecorder.Record(ctx, audit.ActorSystem, audit.ActionEnergyGrant, playerID, map[string]int32{"energyDelta": energyDelta, "energy": player.Energy})
+	}
+
 	return player, nil
 }
 
@@ -290,7 +520,7 @@ func (ps *Server) HandleUpdatePlayerRequest(w http.ResponseWriter, r *http.Reque
 	ps.logger.Printf("update player data request for id: %v", decodedReq.PlayerID)
 
 	// try to update the player data
-	updatedPlayer, err := ps.UpdatePlayerData(decodedReq.PlayerID, decodedReq.EnergyDelta, decodedReq.Level)
+	updatedPlayer, err := ps.UpdatePlayerData(playerctx.WithPlayerID(r.Context(), decodedReq.PlayerID), decodedReq.EnergyDelta, decodedReq.Level)
 	if err != nil {
 		errMsg := "error: could not update player data: " + err.Error()
 		ps.logger.Println(errMsg)
@@ -308,110 +538,276 @@ func (ps *Server) HandleUpdatePlayerRequest(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-// updateEnergy will update energy values of the given player:
-// first it will update (possibly stale) energy based on passive energy regeneration
-// then it will update it based on the provided energy delta
-func (ps *Server) updateEnergy(player *data.PlayerData, newEnergyDelta int32) error {
+// SpendEnergy takes the given amount of energy from the player (after passive energy regeneration),
+// the check and the debit are done atomically, and it fails with an InsufficientEnergyErr if the player
+// does not have enough energy at the time of the write (so concurrent spends can never overdraw the energy)
+func (ps *Server) SpendEnergy(ctx context.Context, playerID string, energy int32) (*data.PlayerData, error) {
 
-	if player == nil {
-		return fmt.Errorf("nil player data pointer")
+	if ps == nil {
+		return nil, serverNilError
 	}
 
-	now := time.Now().UTC().Unix()
+	ctx, span := tracing.Start(ctx, "profile.SpendEnergy")
+	defer span.End()
 
-	// 1. make energy values current: (update the energy of the player based
-	// on time passed since last update, and the energy regeneration rate)
-	if now > player.LastUpdateTime {
+	if energy <= 0 {
+		return nil, fmt.Errorf("the energy to spend should be greater than 0, got: %v", energy)
+	}
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	return ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+
+		// make the energy current first, then check it
+		updateErr := ps.updateEnergy(player, 0)
+		if updateErr != nil {
+			return updateErr
+		}
+
+		if player.Energy < energy {
+			return InsufficientEnergyErr{PlayerID: playerID}
+		}
+
+		player.Energy -= energy
+		return nil
+	})
+}
 
-		extraEnergy := float64(now-player.LastUpdateTime) * ps.energyRegenPerSecond
-		player.Energy = min(player.Energy+int32(extraEnergy), ps.maxEnergy)
+// HandleSpendEnergyRequest is a wrapper around the SpendEnergy() method which will be used to field
+// internal (server to server) requests to spend energy, responding with a conflict status if there is not enough of it
+func (ps *Server) HandleSpendEnergyRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// 2. update to final value based on provided delta (which can be positive / negative)
-	if newEnergyDelta != 0 {
-		player.Energy = min(player.Energy+newEnergyDelta, ps.maxEnergy)
+	// decode the request body, which should be an EnergySpend struct
+	decodedReq := &EnergySpend{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
 	}
 
-	// 3. make the timestamp current
-	player.LastUpdateTime = now
+	ps.logger.Printf("spend energy request for id: %v", decodedReq.PlayerID)
 
-	return nil
+	updatedPlayer, err := ps.SpendEnergy(r.Context(), decodedReq.PlayerID, decodedReq.Energy)
+	if err != nil {
+		errMsg := "error: could not spend energy: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		case InsufficientEnergyErr:
+			http.Error(w, errMsg, http.StatusConflict)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
+	}
+
+	// create and send the response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(updatedPlayer)
+	if err != nil {
+		errMsg := "error: could not encode updated player data: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
 }
 
-// readPlayerFromDB makes an internal (server to server) request to the data service to read the required player
-func (ps *Server) readPlayerFromDB(playerID string) (*data.PlayerData, error) {
+// refundedAttemptKey identifies a refunded level attempt, the same attempt id in two namespaces being two attempts
+type refundedAttemptKey struct {
+	namespace string
+	attemptID string
+}
 
-	// create a new context
-	ctx, cancel := context.WithTimeout(context.TODO(), constants.InternalRequestDeadlineSeconds*time.Second)
-	defer cancel()
+// RefundEnergy gives back the energy the player spent on a level attempt which never got a result
+// (after passive energy regeneration, what goes above the max energy is banked like any other energy gain),
+// and records the refund in the audit log. The energy of an attempt is only given back once: the refund of an
+// attempt which was already refunded (like a retried request, or the expiry check and an admin refunding the same
+// attempt) gets the player data with no energy added
+func (ps *Server) RefundEnergy(ctx context.Context, refund *EnergyRefund) (*data.PlayerData, error) {
 
-	// create the request
-	reqURL := fmt.Sprintf("%v://%v:%v/data/player-internal/%v", constants.CommonProtocol, constants.CommonHost, constants.DataServerPort, playerID)
-	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
-	if err != nil {
-		return nil, err
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.RefundEnergy")
+	defer span.End()
+
+	if refund == nil {
+		return nil, fmt.Errorf("provided energy refund pointer is nil")
+	}
+
+	if refund.Energy <= 0 {
+		return nil, fmt.Errorf("the energy to refund should be greater than 0, got: %v", refund.Energy)
 	}
 
-	// send the request
-	client := http.DefaultClient
-	resp, err := client.Do(req)
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	now := ps.clock.Now().UTC().Unix()
+	key := refundedAttemptKey{namespace: namespace.FromContext(ctx), attemptID: refund.AttemptID}
+	if _, refunded := ps.refundedAttempts[key]; refunded && refund.AttemptID != "" {
+		ps.logger.Printf("the energy of attempt %v of player id %v was already refunded", refund.AttemptID, refund.PlayerID)
+		return ps.modifyPlayer(ctx, refund.PlayerID, func(player *data.PlayerData) error {
+			return ps.updateEnergy(player, 0)
+		})
+	}
+
+	player, err := ps.modifyPlayer(ctx, refund.PlayerID, func(player *data.PlayerData) error {
+		return ps.updateEnergy(player, refund.Energy)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// check response status
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, data.PlayerNotFoundErr{PlayerID: playerID}
-		} else {
-			return nil, fmt.Errorf("internal read player request was not successful, status code %v", resp.StatusCode)
+	if refund.AttemptID != "" {
+		for refundedKey, refundedAt := range ps.refundedAttempts {
+			if now-refundedAt > refundedAttemptExpirySeconds {
+				delete(ps.refundedAttempts, refundedKey)
+			}
 		}
+		ps.refundedAttempts[key] = now
 	}
 
-	//decode the response for the player data
-	playerData := &data.PlayerData{}
-	err = json.NewDecoder(resp.Body).Decode(playerData)
-	if err != nil {
-		return nil, err
+	actor := refund.Actor
+	if actor == "" {
+		actor = audit.ActorSystem
 	}
+	ps.auditRecorder.Record(ctx, actor, audit.ActionEnergyRefund, refund.PlayerID, map[string]any{
+		"attemptID": refund.AttemptID, "energyDelta": refund.Energy, "energy": player.Energy,
+	})
 
-	return playerData, nil
+	return player, nil
 }
 
-// writePlayerToDB makes an internal (server to server) request to the data service to write the required player entry
-func (ps *Server) writePlayerToDB(player *data.PlayerData) error {
+// HandleRefundEnergyRequest is a wrapper around the RefundEnergy() method which will be used to field
+// internal (server to server) requests to give back the energy of a level attempt
+func (ps *Server) HandleRefundEnergyRequest(w http.ResponseWriter, r *http.Request) {
 
-	// create a new context
-	ctx, cancel := context.WithTimeout(context.TODO(), constants.InternalRequestDeadlineSeconds*time.Second)
-	defer cancel()
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// create the request body
-	reqBody := &bytes.Buffer{}
-	err := json.NewEncoder(reqBody).Encode(player)
+	// decode the request body, which should be an EnergyRefund struct
+	decodedReq := &EnergyRefund{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
 	if err != nil {
-		return err
+		errMsg := "error: could not decode request body: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
 	}
 
-	// create the request
-	reqURL := fmt.Sprintf("%v://%v:%v/data/player-internal", constants.CommonProtocol, constants.CommonHost, constants.DataServerPort)
-	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, reqBody)
+	ps.logger.Printf("refund energy request for id: %v, attempt id: %v, energy: %v", decodedReq.PlayerID, decodedReq.AttemptID, decodedReq.Energy)
+
+	updatedPlayer, err := ps.RefundEnergy(r.Context(), decodedReq)
 	if err != nil {
-		return err
+		errMsg := "error: could not refund energy: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		default:
+			http.Error(w, errMsg, http.StatusBadRequest)
+		}
+		return
 	}
 
-	// send the request
-	client := http.DefaultClient
-	resp, err := client.Do(req)
+	// create and send the response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(updatedPlayer)
 	if err != nil {
-		return err
+		errMsg := "error: could not encode updated player data: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
 	}
-	defer resp.Body.Close()
+}
+
+// modifyPlayer reads the player from the data service, applies the given change to it, and writes it back
+// only if the stored player data has not changed in the meantime (reading it again, and retrying, if it has),
+// so updates made at the same time by different profile servers are never lost
+func (ps *Server) modifyPlayer(ctx context.Context, playerID string, change func(player *data.PlayerData) error) (*data.PlayerData, error) {
 
-	// check response status
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("internal write player request was not successful, status code %v", resp.StatusCode)
+	for attempt := 1; ; attempt++ {
+
+		// send request to the data service to look the player up
+		player, err := ps.dataClient.ReadPlayer(ctx, playerID)
+		if err != nil {
+			return nil, err
+		}
+
+		expected := player.Clone()
+		err = change(player)
+		if err != nil {
+			return nil, err
+		}
+
+		// send request to the data service to write back the player (if it is unchanged)
+		err = ps.dataClient.SwapPlayer(ctx, &data.PlayerSwap{Expected: expected, Updated: *player})
+		if _, changed := err.(data.PlayerChangedErr); changed && attempt < maxPlayerWriteAttempts {
+			ps.logger.Printf("player id %v changed while being updated, retrying", playerID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return player, nil
+	}
+}
+
+// updateEnergy will update energy values of the given player:
+// first it will update (possibly stale) energy based on passive energy regeneration
+// then it will update it based on the provided energy delta
+func (ps *Server) updateEnergy(player *data.PlayerData, newEnergyDelta int32) error {
+
+	if player == nil {
+		return fmt.Errorf("nil player data pointer")
 	}
 
+	now := ps.clock.Now().UTC().Unix()
+
+	// 1. make energy values current: (update the energy of the player based
+	// on time passed since last update, and the energy regeneration rate)
+	player.Energy = ps.regeneratedEnergy(player, now)
+	player.Boosts = activeBoosts(player.Boosts, now)
+
+	// 2. update to final value based on provided delta (which can be positive / negative),
+	// what goes above the max energy is put in the energy bank (up to its cap)
+	if newEnergyDelta != 0 {
+		player.BankedEnergy = BankedEnergy(player, newEnergyDelta, ps.maxEnergy, ps.energyBankCap)
+		player.Energy = min(player.Energy+newEnergyDelta, ps.maxEnergy)
+	}
+
+	// 3. update the segment of the player, a player who was not updated for a while is coming back after a lapse
+	// (checked before the timestamp is made current, since it is the last update time of the player)
+	if config.Config.Segments.Lapsed(player.LastUpdateTime, now) {
+		player.ReturnTime = now
+	}
+	player.Segment = config.Config.Segments.SegmentOf(player.CreatedTime, player.ReturnTime, now)
+
+	// 4. make the timestamp current
+	player.LastUpdateTime = now
+
 	return nil
 }
+
+// regeneratedEnergy returns the energy the given player has at the given time (unix seconds),
+// based on the time passed since their last update, the energy regeneration rate, and their energy boosts
+func (ps *Server) regeneratedEnergy(player *data.PlayerData, now int64) int32 {
+
+	if now <= player.LastUpdateTime {
+		return player.Energy
+	}
+
+	extraEnergy := boostedSeconds(player.Boosts, player.LastUpdateTime, now) * ps.energyRegenPerSecond
+	return min(player.Energy+int32(extraEnergy), ps.maxEnergy)
+}
@@ -2,35 +2,30 @@ package profile
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/config"
 	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/clock"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
 	"example.com/dice-game-backend/internal/shared/testsetup"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
 
-func TestMain(m *testing.M) {
-
-	dataServer := data.NewServer()
-	go dataServer.Run(constants.DataServerPort)
-
-	code := m.Run()
-
-	os.Exit(code)
-}
-
 func TestNewProfileServer(t *testing.T) {
 
-	authServer := auth.NewServer()
-	profileServer := NewServer(authServer)
+	authServer := auth.NewServer(data.NewServer())
+	profileServer := NewServer(authServer, data.NewServer())
 
 	if profileServer == nil {
 		t.Fatal("new profile server should not return a nil server pointer")
@@ -39,10 +34,15 @@ func TestNewProfileServer(t *testing.T) {
 
 func TestServer_GetPlayer(t *testing.T) {
 
-	authServer := auth.NewServer()
-	ps := NewServer(authServer)
+	authServer := auth.NewServer(data.NewServer())
+	ps := NewServer(authServer, data.NewServer())
 
-	err := ps.writePlayerToDB(&data.PlayerData{"player2", 1, 50, time.Now().UTC().Unix()})
+	// the clock is frozen, so the update times are known
+	frozenClock := clock.NewFrozen(time.Now())
+	ps.SetClock(frozenClock)
+	now := frozenClock.Now().UTC().Unix()
+
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: now})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
@@ -55,15 +55,15 @@ func TestServer_GetPlayer(t *testing.T) {
 		expError   error
 	}{
 		{"nil server", nil, "", nil, serverNilError},
-		{"invalid player", ps, "player1", nil, data.PlayerNotFoundErr{"player1"}},
-		{"valid player", ps, "player2", &data.PlayerData{"player2", 1, 50, time.Now().UTC().Unix()}, nil},
-		{"valid player, restore energy", ps, "player2", &data.PlayerData{"player2", 1, 50, time.Now().UTC().Unix()}, nil},
+		{"invalid player", ps, "player1", nil, data.PlayerNotFoundErr{PlayerID: "player1"}},
+		{"valid player", ps, "player2", &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: now, Version: data.PlayerDataVersion}, nil},
+		{"valid player, restore energy", ps, "player2", &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: now, Version: data.PlayerDataVersion}, nil},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			gotPlayer, gotErr := test.server.GetPlayer(test.playerID)
+			gotPlayer, gotErr := test.server.GetPlayer(context.Background(), test.playerID)
 			if gotErr != nil {
 				if errors.Is(gotErr, test.expError) {
 					fmt.Println(gotErr)
@@ -81,18 +81,27 @@ func TestServer_GetPlayer(t *testing.T) {
 
 func TestServer_UpdatePlayerData(t *testing.T) {
 
-	authServer := auth.NewServer()
-	ps := NewServer(authServer)
+	authServer := auth.NewServer(data.NewServer())
+	ps := NewServer(authServer, data.NewServer())
+
+	// the clock is frozen, so the update times are known
+	frozenClock := clock.NewFrozen(time.Now())
+	ps.SetClock(frozenClock)
+	now := frozenClock.Now().UTC().Unix()
 
-	err := ps.writePlayerToDB(&data.PlayerData{"player2", 1, 20, time.Now().UTC().Unix()})
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: now})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
-	err = ps.writePlayerToDB(&data.PlayerData{"player3", 2, 20, time.Now().UTC().Unix()})
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player3", Level: 2, Energy: 20, LastUpdateTime: now})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
-	err = ps.writePlayerToDB(&data.PlayerData{"player4", 10, 50, time.Now().UTC().Unix()})
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player4", Level: 10, Energy: 50, LastUpdateTime: now})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player5", Level: 1, Energy: 10, LastUpdateTime: now - 4*int64(config.Config.EnergyRegenSeconds)})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
@@ -107,16 +116,18 @@ func TestServer_UpdatePlayerData(t *testing.T) {
 		expError    error
 	}{
 		{"nil server", nil, "", 0, 0, nil, serverNilError},
-		{"invalid player", ps, "player1", 0, 0, nil, data.PlayerNotFoundErr{"player1"}},
-		{"valid player, more energy", ps, "player2", 20, 1, &data.PlayerData{"player2", 1, 40, time.Now().UTC().Unix()}, nil},
-		{"valid player, new level", ps, "player3", 10, 3, &data.PlayerData{"player3", 3, 30, time.Now().UTC().Unix()}, nil},
-		{"valid player, max energy, max level, ", ps, "player4", 100, 100, &data.PlayerData{"player4", 10, 50, time.Now().UTC().Unix()}, nil},
+		{"no player in the context", ps, "", 0, 0, nil, playerctx.MissingPlayerIDError},
+		{"invalid player", ps, "player1", 0, 0, nil, data.PlayerNotFoundErr{PlayerID: "player1"}},
+		{"valid player, more energy", ps, "player2", 20, 1, &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 40, LastUpdateTime: now, Version: data.PlayerDataVersion}, nil},
+		{"valid player, new level", ps, "player3", 10, 3, &data.PlayerData{PlayerID: "player3", Level: 3, Energy: 30, LastUpdateTime: now, Version: data.PlayerDataVersion}, nil},
+		{"valid player, max energy, max level, ", ps, "player4", 100, 100, &data.PlayerData{PlayerID: "player4", Level: 10, Energy: 50, LastUpdateTime: now, Version: data.PlayerDataVersion}, nil},
+		{"valid player, regenerated energy", ps, "player5", 5, 1, &data.PlayerData{PlayerID: "player5", Level: 1, Energy: 19, LastUpdateTime: now, Version: data.PlayerDataVersion}, nil},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			gotPlayer, gotErr := test.server.UpdatePlayerData(test.playerID, test.energyDelta, test.newLevel)
+			gotPlayer, gotErr := test.server.UpdatePlayerData(playerctx.WithPlayerID(context.Background(), test.playerID), test.energyDelta, test.newLevel)
 			if gotErr != nil {
 				if errors.Is(gotErr, test.expError) {
 					fmt.Println(gotErr)
@@ -132,6 +143,93 @@ func TestServer_UpdatePlayerData(t *testing.T) {
 	}
 }
 
+func TestServer_SpendEnergy(t *testing.T) {
+
+	authServer := auth.NewServer(data.NewServer())
+	ps := NewServer(authServer, data.NewServer())
+
+	// the clock is frozen, so the update times are known
+	frozenClock := clock.NewFrozen(time.Now())
+	ps.SetClock(frozenClock)
+	now := frozenClock.Now().UTC().Unix()
+
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: now})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		playerID   string
+		energy     int32
+		wantPlayer *data.PlayerData
+		expError   error
+	}{
+		{"nil server", nil, "", 0, nil, serverNilError},
+		{"invalid player", ps, "player1", 5, nil, data.PlayerNotFoundErr{PlayerID: "player1"}},
+		{"valid player, some energy", ps, "player2", 15, &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 5, LastUpdateTime: now, Version: data.PlayerDataVersion}, nil},
+		{"valid player, not enough energy", ps, "player2", 6, nil, InsufficientEnergyErr{PlayerID: "player2"}},
+		{"valid player, all the energy", ps, "player2", 5, &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 0, LastUpdateTime: now, Version: data.PlayerDataVersion}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotPlayer, gotErr := test.server.SpendEnergy(context.Background(), test.playerID, test.energy)
+			if gotErr != nil {
+				if errors.Is(gotErr, test.expError) {
+					fmt.Println(gotErr)
+				} else {
+					t.Fatalf("SpendEnergy() failed with an unexpected error, %v", gotErr)
+				}
+			} else {
+				if !reflect.DeepEqual(gotPlayer, test.wantPlayer) {
+					t.Errorf("SpendEnergy() gave incorrect results, want: %v, got: %v", test.wantPlayer, gotPlayer)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_SpendEnergy_Concurrent(t *testing.T) {
+
+	// two profile servers on the same data server, as if they were separate instances of the profile service
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+	profileServers := []*Server{NewServer(authServer, dataServer), NewServer(authServer, dataServer)}
+
+	err := dataServer.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	spent := make(chan bool, 20)
+	for i := range 20 {
+		go func() {
+			_, spendErr := profileServers[i%2].SpendEnergy(context.Background(), "player1", 3)
+			spent <- spendErr == nil
+		}()
+	}
+
+	spendCount := int32(0)
+	for range 20 {
+		if <-spent {
+			spendCount++
+		}
+	}
+
+	player, err := dataServer.ReadPlayer(context.Background(), "player1")
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	// every successful spend took its energy, and the energy was never overdrawn
+	if spendCount > 6 || player.Energy != 20-3*spendCount {
+		t.Errorf("SpendEnergy() gave incorrect results, %v spends of 3 energy left %v energy (from 20)", spendCount, player.Energy)
+	}
+}
+
 func TestServer_HandleNewPlayerRequest(t *testing.T) {
 
 	as, sID, err := testsetup.SetupTestAuth()
@@ -139,13 +237,21 @@ func TestServer_HandleNewPlayerRequest(t *testing.T) {
 		t.Fatal("auth setup error: " + err.Error())
 	}
 
-	ps := NewServer(as)
+	ps := NewServer(as, data.NewServer())
+
+	// the clock is frozen, so the update times are known
+	frozenClock := clock.NewFrozen(time.Now())
+	ps.SetClock(frozenClock)
+	now := frozenClock.Now().UTC().Unix()
 
-	err = ps.writePlayerToDB(&data.PlayerData{"player2", 1, 20, time.Now().UTC().Unix()})
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: now})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
 
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
 	tests := []struct {
 		name             string
 		server           *Server
@@ -158,8 +264,9 @@ func TestServer_HandleNewPlayerRequest(t *testing.T) {
 		{"nil server", nil, "", "", http.StatusInternalServerError, "", nil},
 		{"blank session id", ps, "", "", http.StatusUnauthorized, "application/json", nil},
 		{"invalid session id", ps, "testSessionID", "", http.StatusUnauthorized, "application/json", nil},
-		{"new player", ps, sID, "player1", http.StatusOK, "application/json", &data.PlayerData{"player1", 1, 50, time.Now().UTC().Unix()}},
-		{"existing player", ps, sID, "player2", http.StatusBadRequest, "application/json", nil},
+		{"another player", ps, sID, "player2", http.StatusForbidden, "application/json", nil},
+		{"new player", ps, sID, "player1", http.StatusOK, "application/json", &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 50, LastUpdateTime: now, CreatedTime: now, Segment: config.SegmentNew, Version: data.PlayerDataVersion}},
+		{"existing player", ps, "session2", "player2", http.StatusBadRequest, "application/json", nil},
 	}
 
 	for _, test := range tests {
@@ -177,7 +284,7 @@ func TestServer_HandleNewPlayerRequest(t *testing.T) {
 			respRec := httptest.NewRecorder()
 
 			profileServer := test.server
-			profileServer.HandleNewPlayerRequest(respRec, newReq)
+			testsetup.WithSession(profileServer, sessions, profileServer.HandleNewPlayerRequest)(respRec, newReq)
 
 			gotStatus := respRec.Result().StatusCode
 
@@ -213,13 +320,16 @@ func TestServer_HandlePlayerDataRequest(t *testing.T) {
 		t.Fatal("auth setup error: " + err.Error())
 	}
 
-	ps := NewServer(as)
+	ps := NewServer(as, data.NewServer())
 
-	err = ps.writePlayerToDB(&data.PlayerData{"player2", 1, 20, time.Now().UTC().Unix()})
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
 
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player2", "session5": "player5"}
+
 	tests := []struct {
 		name             string
 		server           *Server
@@ -232,8 +342,9 @@ func TestServer_HandlePlayerDataRequest(t *testing.T) {
 		{"nil server", nil, "", "", http.StatusInternalServerError, "", nil},
 		{"blank session id", ps, "", "", http.StatusUnauthorized, "application/json", nil},
 		{"invalid session id", ps, "testSessionID", "", http.StatusUnauthorized, "application/json", nil},
-		{"new player", ps, sID, "player5", http.StatusNotFound, "application/json", nil},
-		{"existing player", ps, sID, "player2", http.StatusOK, "application/json", &data.PlayerData{"player2", 1, 20, time.Now().UTC().Unix()}},
+		{"another player", ps, sID, "player5", http.StatusForbidden, "application/json", nil},
+		{"new player", ps, "session5", "player5", http.StatusNotFound, "application/json", nil},
+		{"existing player", ps, sID, "player2", http.StatusOK, "application/json", &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix(), Version: data.PlayerDataVersion}},
 	}
 
 	for _, test := range tests {
@@ -245,7 +356,7 @@ func TestServer_HandlePlayerDataRequest(t *testing.T) {
 			respRec := httptest.NewRecorder()
 
 			profileServer := test.server
-			profileServer.HandlePlayerDataRequest(respRec, newReq)
+			testsetup.WithSession(profileServer, sessions, profileServer.HandlePlayerDataRequest)(respRec, newReq)
 
 			gotStatus := respRec.Result().StatusCode
 
@@ -274,20 +385,115 @@ func TestServer_HandlePlayerDataRequest(t *testing.T) {
 	}
 }
 
+func TestServer_HandlePlayerDataRequest_Conditional(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ps := NewServer(as, data.NewServer())
+
+	// the energy of the first player is full, so their data does not change till it is updated,
+	// while the second player regenerates energy
+	lastUpdate := time.Now().UTC().Unix() - 100
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player1", Level: 1, Energy: ps.maxEnergy, LastUpdateTime: lastUpdate})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 0, LastUpdateTime: lastUpdate})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	httpTime := func(unixTime int64) string { return time.Unix(unixTime, 0).UTC().Format(http.TimeFormat) }
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2", "session5": "player5"}
+	sessionIDs := map[string]string{"player1": sID, "player2": "session2", "player5": "session5"}
+
+	// the players which are read get the current time as their last update time (0 means no Last-Modified header)
+	const readNow = -1
+
+	tests := []struct {
+		name             string
+		method           string
+		playerID         string
+		ifModifiedSince  string
+		wantStatus       int
+		wantLastModified int64
+		wantBody         bool
+	}{
+		{"unchanged since last update", http.MethodGet, "player1", httpTime(lastUpdate), http.StatusNotModified, lastUpdate, false},
+		{"unchanged, later time", http.MethodHead, "player1", httpTime(lastUpdate + 50), http.StatusNotModified, lastUpdate, false},
+		{"changed since an earlier time", http.MethodGet, "player1", httpTime(lastUpdate - 10), http.StatusOK, readNow, true},
+		{"regenerated energy", http.MethodGet, "player2", httpTime(lastUpdate), http.StatusOK, readNow, true},
+		{"invalid time", http.MethodGet, "player2", "yesterday", http.StatusOK, readNow, true},
+		{"head request", http.MethodHead, "player2", "", http.StatusOK, readNow, false},
+		{"unknown player", http.MethodGet, "player5", httpTime(lastUpdate), http.StatusNotFound, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(test.method, "/profile/player-data/", nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Session-Id", sessionIDs[test.playerID])
+			if test.ifModifiedSince != "" {
+				newReq.Header.Set("If-Modified-Since", test.ifModifiedSince)
+			}
+			respRec := httptest.NewRecorder()
+
+			testsetup.WithSession(ps, sessions, ps.HandlePlayerDataRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			gotLastModified := respRec.Result().Header.Get("Last-Modified")
+			switch test.wantLastModified {
+			case 0:
+				if gotLastModified != "" {
+					t.Errorf("handler should not give a last modified header, got: %v", gotLastModified)
+				}
+			case readNow:
+				gotTime, parseErr := http.ParseTime(gotLastModified)
+				if parseErr != nil || time.Since(gotTime) > 2*time.Second {
+					t.Errorf("handler gave incorrect last modified header, want: the current time, got: %v", gotLastModified)
+				}
+			default:
+				if gotLastModified != httpTime(test.wantLastModified) {
+					t.Errorf("handler gave incorrect last modified header, want: %v, got: %v", httpTime(test.wantLastModified), gotLastModified)
+				}
+			}
+
+			if gotBody := respRec.Body.Len() > 0; gotBody != test.wantBody {
+				t.Errorf("handler gave incorrect results, want body: %v, got: %v", test.wantBody, gotBody)
+			}
+		})
+	}
+}
+
 func TestServer_HandleUpdatePlayerRequest(t *testing.T) {
 
-	authServer := auth.NewServer()
-	ps := NewServer(authServer)
+	authServer := auth.NewServer(data.NewServer())
+	ps := NewServer(authServer, data.NewServer())
+
+	// the clock is frozen, so the update times are known
+	frozenClock := clock.NewFrozen(time.Now())
+	ps.SetClock(frozenClock)
+	now := frozenClock.Now().UTC().Unix()
 
-	err := ps.writePlayerToDB(&data.PlayerData{"player8", 1, 20, time.Now().UTC().Unix()})
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player8", Level: 1, Energy: 20, LastUpdateTime: now})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
-	err = ps.writePlayerToDB(&data.PlayerData{"player9", 2, 20, time.Now().UTC().Unix()})
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player9", Level: 2, Energy: 20, LastUpdateTime: now})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
-	err = ps.writePlayerToDB(&data.PlayerData{"player10", 10, 50, time.Now().UTC().Unix()})
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player10", Level: 10, Energy: 50, LastUpdateTime: now})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
@@ -304,9 +510,9 @@ func TestServer_HandleUpdatePlayerRequest(t *testing.T) {
 	}{
 		{"nil server", nil, "", 0, 0, http.StatusInternalServerError, "", nil},
 		{"invalid player", ps, "player7", 0, 0, http.StatusBadRequest, "", nil},
-		{"valid player, more energy", ps, "player8", 20, 1, http.StatusOK, "application/json", &data.PlayerData{"player8", 1, 40, time.Now().UTC().Unix()}},
-		{"valid player, new level", ps, "player9", 10, 3, http.StatusOK, "application/json", &data.PlayerData{"player9", 3, 30, time.Now().UTC().Unix()}},
-		{"valid player, max energy, max level, ", ps, "player10", 100, 100, http.StatusOK, "application/json", &data.PlayerData{"player10", 10, 50, time.Now().UTC().Unix()}},
+		{"valid player, more energy", ps, "player8", 20, 1, http.StatusOK, "application/json", &data.PlayerData{PlayerID: "player8", Level: 1, Energy: 40, LastUpdateTime: now, Version: data.PlayerDataVersion}},
+		{"valid player, new level", ps, "player9", 10, 3, http.StatusOK, "application/json", &data.PlayerData{PlayerID: "player9", Level: 3, Energy: 30, LastUpdateTime: now, Version: data.PlayerDataVersion}},
+		{"valid player, max energy, max level, ", ps, "player10", 100, 100, http.StatusOK, "application/json", &data.PlayerData{PlayerID: "player10", Level: 10, Energy: 50, LastUpdateTime: now, Version: data.PlayerDataVersion}},
 	}
 
 	for _, test := range tests {
@@ -351,3 +557,1154 @@ func TestServer_HandleUpdatePlayerRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_HandleGetPlayerRequest(t *testing.T) {
+
+	ps := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	// the clock is frozen, so the update times are known
+	frozenClock := clock.NewFrozen(time.Now())
+	ps.SetClock(frozenClock)
+	now := frozenClock.Now().UTC().Unix()
+
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: now})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name             string
+		server           *Server
+		playerID         string
+		wantStatus       int
+		wantContentType  string
+		wantResponseBody *data.PlayerData
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError, "", nil},
+		{"invalid player", ps, "player1", http.StatusNotFound, "", nil},
+		{"existing player", ps, "player2", http.StatusOK, "application/json", &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: now, Version: data.PlayerDataVersion}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/profile/player-data-internal/", nil)
+			newReq.SetPathValue("id", test.playerID)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			profileServer.HandleGetPlayerRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotContentType := respRec.Result().Header.Get("Content-Type")
+
+				if gotContentType != test.wantContentType {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantContentType, gotContentType)
+				}
+
+				gotResponseBody := &data.PlayerData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleSetPlayerRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ps := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		body       string
+		wantStatus int
+		wantPlayer *PlayerOverride
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError, nil},
+		{"invalid admin token", ps, "testToken", `{"playerID":"player2","level":2,"energy":10}`, http.StatusUnauthorized, nil},
+		{"malformed body", ps, "adminToken", `{"playerID":`, http.StatusBadRequest, nil},
+		{"invalid player", ps, "adminToken", `{"playerID":"player1","level":2,"energy":10}`, http.StatusNotFound, nil},
+		{"level out of range", ps, "adminToken", `{"playerID":"player2","level":0,"energy":10}`, http.StatusBadRequest, nil},
+		{"energy over max", ps, "adminToken", `{"playerID":"player2","level":2,"energy":51}`, http.StatusBadRequest, nil},
+		{"set", ps, "adminToken", `{"playerID":"player2","level":2,"energy":10}`, http.StatusOK, &PlayerOverride{PlayerID: "player2", Level: 2, Energy: 10}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPut, "/profile/admin/player", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			profileServer.HandleSetPlayerRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if test.wantPlayer != nil {
+				gotPlayer := &data.PlayerData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotPlayer)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				gotOverride := &PlayerOverride{PlayerID: gotPlayer.PlayerID, Level: gotPlayer.Level, Energy: gotPlayer.Energy}
+				if *gotOverride != *test.wantPlayer {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantPlayer, gotOverride)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleGrantEnergyRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ps := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	// no regeneration between the requests, so the energy only changes with the grants
+	ps.energyRegenPerSecond = 0
+
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		body       string
+		wantStatus int
+		wantEnergy int32
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError, 0},
+		{"invalid admin token", ps, "testToken", `{"playerID":"player2","energy":10}`, http.StatusUnauthorized, 0},
+		{"invalid player", ps, "adminToken", `{"playerID":"player1","energy":10}`, http.StatusNotFound, 0},
+		{"no energy", ps, "adminToken", `{"playerID":"player2","energy":0}`, http.StatusBadRequest, 0},
+		{"grant", ps, "adminToken", `{"playerID":"player2","energy":10}`, http.StatusOK, 30},
+		{"grant over max", ps, "adminToken", `{"playerID":"player2","energy":40}`, http.StatusOK, 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/profile/admin/grant-energy", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			profileServer.HandleGrantEnergyRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotPlayer := &data.PlayerData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotPlayer)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotPlayer.Energy != test.wantEnergy {
+					t.Errorf("handler gave incorrect results, want energy: %v, got: %v", test.wantEnergy, gotPlayer.Energy)
+				}
+			}
+		})
+	}
+}
+
+func TestBankedEnergy(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		player      *data.PlayerData
+		energyDelta int32
+		bankCap     int32
+		want        int32
+	}{
+		{"bank off", &data.PlayerData{Energy: 45}, 10, 0, 0},
+		{"reward below max", &data.PlayerData{Energy: 30}, 10, 20, 0},
+		{"reward over max", &data.PlayerData{Energy: 45}, 10, 20, 5},
+		{"reward at max", &data.PlayerData{Energy: 50, BankedEnergy: 5}, 10, 20, 15},
+		{"reward over the cap", &data.PlayerData{Energy: 50, BankedEnergy: 15}, 10, 20, 20},
+		{"bank above a lowered cap", &data.PlayerData{Energy: 50, BankedEnergy: 30}, 10, 20, 30},
+		{"spend", &data.PlayerData{Energy: 50, BankedEnergy: 5}, -10, 20, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := BankedEnergy(test.player, test.energyDelta, 50, test.bankCap)
+			if got != test.want {
+				t.Errorf("BankedEnergy() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_HandleClaimBankedEnergyRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ps := NewServer(as, data.NewServer())
+
+	// no regeneration between the requests, so the energy only changes with the rewards and claims
+	ps.energyRegenPerSecond = 0
+	ps.energyBankCap = 20
+
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player2", "session1": "player1"}
+
+	tests := []struct {
+		name         string
+		server       *Server
+		sessionID    string
+		before       func()
+		body         string
+		wantStatus   int
+		wantEnergy   int32
+		wantBankLeft int32
+	}{
+		{"nil server", nil, "", func() {}, "", http.StatusInternalServerError, 0, 0},
+		{"invalid session id", ps, "testSessionID", func() {}, `{"playerID":"player2"}`, http.StatusUnauthorized, 0, 0},
+		{"another player", ps, sID, func() {}, `{"playerID":"player1"}`, http.StatusForbidden, 0, 0},
+		{"invalid player", ps, "session1", func() {}, `{"playerID":"player1"}`, http.StatusNotFound, 0, 0},
+		{"empty bank", ps, sID, func() {}, `{"playerID":"player2"}`, http.StatusConflict, 0, 0},
+		{"energy full", ps, sID, func() { _, _ = ps.UpdatePlayerData(playerctx.WithPlayerID(context.Background(), "player2"), 45, 1) }, `{"playerID":"player2"}`, http.StatusConflict, 0, 0},
+		{"partial claim", ps, sID, func() { _, _ = ps.SpendEnergy(context.Background(), "player2", 10) }, `{"playerID":"player2"}`, http.StatusOK, 50, 5},
+		{"full claim", ps, sID, func() { _, _ = ps.SpendEnergy(context.Background(), "player2", 10) }, `{"playerID":"player2"}`, http.StatusOK, 45, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			test.before()
+
+			newReq := httptest.NewRequest(http.MethodPost, "/profile/energy-bank/claim", strings.NewReader(test.body))
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			testsetup.WithSession(profileServer, sessions, profileServer.HandleClaimBankedEnergyRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotPlayer := &data.PlayerData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotPlayer)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotPlayer.Energy != test.wantEnergy || gotPlayer.BankedEnergy != test.wantBankLeft {
+					t.Errorf("handler gave incorrect results, want energy: %v and banked energy: %v, got: %v and %v", test.wantEnergy, test.wantBankLeft, gotPlayer.Energy, gotPlayer.BankedEnergy)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleAdvanceFTUERequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ps := NewServer(as, data.NewServer())
+
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player2", "session1": "player1"}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		sessionID  string
+		body       string
+		wantStatus int
+		wantStep   int32
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError, 0},
+		{"invalid session id", ps, "testSessionID", `{"playerID":"player2","step":1}`, http.StatusUnauthorized, 0},
+		{"another player", ps, sID, `{"playerID":"player1","step":1}`, http.StatusForbidden, 0},
+		{"invalid player", ps, "session1", `{"playerID":"player1","step":1}`, http.StatusNotFound, 0},
+		{"invalid step", ps, sID, `{"playerID":"player2","step":4}`, http.StatusBadRequest, 0},
+		{"skipped step", ps, sID, `{"playerID":"player2","step":2}`, http.StatusConflict, 0},
+		{"first step", ps, sID, `{"playerID":"player2","step":1}`, http.StatusOK, 1},
+		{"next step", ps, sID, `{"playerID":"player2","step":2}`, http.StatusOK, 2},
+		{"earlier step", ps, sID, `{"playerID":"player2","step":1}`, http.StatusOK, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/profile/ftue/advance", strings.NewReader(test.body))
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			testsetup.WithSession(profileServer, sessions, profileServer.HandleAdvanceFTUERequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotPlayer := &data.PlayerData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotPlayer)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotPlayer.FTUEStep != test.wantStep {
+					t.Errorf("handler gave incorrect results, want FTUE step: %v, got: %v", test.wantStep, gotPlayer.FTUEStep)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleResetFTUERequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ps := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix(), FTUEStep: 3})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", ps, "testToken", `{"playerID":"player2"}`, http.StatusUnauthorized},
+		{"invalid player", ps, "adminToken", `{"playerID":"player1"}`, http.StatusNotFound},
+		{"reset", ps, "adminToken", `{"playerID":"player2"}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/profile/admin/ftue/reset", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			profileServer.HandleResetFTUERequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotPlayer := &data.PlayerData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotPlayer)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotPlayer.FTUEStep != 0 {
+					t.Errorf("handler gave incorrect results, want FTUE step: 0, got: %v", gotPlayer.FTUEStep)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleAnnotationRequests(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ds := data.NewServer()
+	ps := NewServer(auth.NewServer(ds), ds)
+
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 3, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	addTests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", ps, "testToken", `{"playerID":"player2","kind":"note","text":"called in"}`, http.StatusUnauthorized},
+		{"malformed body", ps, "adminToken", `{"playerID":`, http.StatusBadRequest},
+		{"invalid player", ps, "adminToken", `{"playerID":"player1","kind":"note","text":"called in"}`, http.StatusNotFound},
+		{"unknown kind", ps, "adminToken", `{"playerID":"player2","kind":"rumour","text":"called in"}`, http.StatusBadRequest},
+		{"compensation", ps, "adminToken", `{"playerID":"player2","kind":"compensation","text":"granted 10 energy","reference":"TICKET-7","author":"sam"}`, http.StatusOK},
+		{"suspicion without an author", ps, "adminToken", `{"playerID":"player2","kind":"suspicion","text":"wins every level in one roll"}`, http.StatusOK},
+	}
+
+	added := []data.PlayerAnnotation{}
+	for _, test := range addTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/profile/admin/annotations", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			profileServer.HandleAddAnnotationRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotAnnotation := data.PlayerAnnotation{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(&gotAnnotation)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+				if gotAnnotation.AnnotationID == "" || gotAnnotation.Author == "" || gotAnnotation.CreatedAt == 0 {
+					t.Errorf("handler gave incorrect results, got: %+v", gotAnnotation)
+				}
+				added = append(added, gotAnnotation)
+			}
+		})
+	}
+
+	if len(added) != 2 || added[0].Author != "sam" || added[1].Author != "admin" {
+		t.Fatalf("handler gave incorrect results, want annotations by sam and admin, got: %+v", added)
+	}
+
+	// the admin player view has the annotations, oldest first
+	newReq := httptest.NewRequest(http.MethodGet, "/profile/admin/player/player2", nil)
+	newReq.SetPathValue("id", "player2")
+	newReq.Header.Set("Admin-Token", "adminToken")
+	respRec := httptest.NewRecorder()
+	ps.HandleAdminGetPlayerRequest(respRec, newReq)
+
+	gotView := &AdminPlayerView{}
+	err = json.NewDecoder(respRec.Result().Body).Decode(gotView)
+	if err != nil {
+		t.Fatal("could not decode the response body")
+	}
+	if gotView.Player == nil || gotView.Player.Level != 3 || !reflect.DeepEqual(gotView.Annotations, added) {
+		t.Errorf("handler gave incorrect results, want level 3 and annotations: %+v, got: %+v", added, gotView)
+	}
+
+	removeTests := []struct {
+		name         string
+		adminToken   string
+		annotationID string
+		wantStatus   int
+	}{
+		{"invalid admin token", "testToken", added[1].AnnotationID, http.StatusUnauthorized},
+		{"unknown annotation", "adminToken", "annotation-0", http.StatusNotFound},
+		{"remove", "adminToken", added[1].AnnotationID, http.StatusOK},
+	}
+
+	for _, test := range removeTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodDelete, "/profile/admin/annotations/player2/"+test.annotationID, nil)
+			newReq.SetPathValue("id", "player2")
+			newReq.SetPathValue("annotationID", test.annotationID)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			ps.HandleRemoveAnnotationRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	// both the additions and the removal are in the audit log
+	gotActions := []string{}
+	auditPage, err := ds.ReadAuditEntries(context.Background(), &data.AuditQuery{PlayerID: "player2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range auditPage.Entries {
+		gotActions = append(gotActions, entry.Action)
+	}
+	wantActions := []string{"annotation-add", "annotation-add", "annotation-remove"}
+	if !reflect.DeepEqual(gotActions, wantActions) {
+		t.Errorf("audit log has incorrect entries, want: %v, got: %v", wantActions, gotActions)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+
+	ps := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /profile/player-data-internal/{id}", ps.HandleGetPlayerRequest)
+	mux.HandleFunc("PUT /profile/player-data-internal", ps.HandleUpdatePlayerRequest)
+	mux.HandleFunc("POST /profile/energy-spend-internal", ps.HandleSpendEnergyRequest)
+	mux.HandleFunc("POST /profile/energy-refund-internal", ps.HandleRefundEnergyRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	var hc1 *HTTPClient
+	hc2 := &HTTPClient{baseURL: testServer.URL}
+
+	tests := []struct {
+		name        string
+		client      *HTTPClient
+		playerID    string
+		energyDelta int32
+		newLevel    int32
+		wantPlayer  *data.PlayerData
+		expError    error
+	}{
+		{"nil client", hc1, "player2", 0, 0, nil, clientNilError},
+		{"invalid player", hc2, "player1", 0, 0, nil, data.PlayerNotFoundErr{PlayerID: "player1"}},
+		{"valid player, new level", hc2, "player2", 10, 2, &data.PlayerData{PlayerID: "player2", Level: 2, Energy: 30, LastUpdateTime: time.Now().UTC().Unix(), Version: data.PlayerDataVersion}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			_, gotErr := test.client.GetPlayer(context.Background(), test.playerID)
+			if gotErr != nil {
+				if errors.Is(gotErr, test.expError) {
+					fmt.Println(gotErr)
+					return
+				}
+				t.Fatalf("GetPlayer() failed with an unexpected error, %v", gotErr)
+			}
+
+			gotPlayer, gotErr := test.client.UpdatePlayerData(playerctx.WithPlayerID(context.Background(), test.playerID), test.energyDelta, test.newLevel)
+			if gotErr != nil {
+				t.Fatalf("UpdatePlayerData() failed with an unexpected error, %v", gotErr)
+			}
+
+			if !reflect.DeepEqual(gotPlayer, test.wantPlayer) {
+				t.Errorf("UpdatePlayerData() gave incorrect results, want: %v, got: %v", test.wantPlayer, gotPlayer)
+			}
+		})
+	}
+
+	// spending more energy than the player has is a conflict
+	_, gotErr := hc2.SpendEnergy(context.Background(), "player2", 100)
+	if !errors.Is(gotErr, InsufficientEnergyErr{PlayerID: "player2"}) {
+		t.Errorf("SpendEnergy() gave incorrect results, want: %v, got: %v", InsufficientEnergyErr{PlayerID: "player2"}, gotErr)
+	}
+
+	gotPlayer, gotErr := hc2.SpendEnergy(context.Background(), "player2", 10)
+	if gotErr != nil {
+		t.Fatalf("SpendEnergy() failed with an unexpected error, %v", gotErr)
+	}
+
+	if gotPlayer.Energy != 20 {
+		t.Errorf("SpendEnergy() gave incorrect results, want: %v, got: %v", 20, gotPlayer.Energy)
+	}
+
+	// refunding energy gives it back, and refunds to unknown players are not found
+	_, gotErr = hc2.RefundEnergy(context.Background(), &EnergyRefund{PlayerID: "player1", AttemptID: "attempt1", Energy: 10})
+	if !errors.Is(gotErr, data.PlayerNotFoundErr{PlayerID: "player1"}) {
+		t.Errorf("RefundEnergy() gave incorrect results, want: %v, got: %v", data.PlayerNotFoundErr{PlayerID: "player1"}, gotErr)
+	}
+
+	gotPlayer, gotErr = hc2.RefundEnergy(context.Background(), &EnergyRefund{PlayerID: "player2", AttemptID: "attempt1", Energy: 10})
+	if gotErr != nil {
+		t.Fatalf("RefundEnergy() failed with an unexpected error, %v", gotErr)
+	}
+
+	if gotPlayer.Energy != 30 {
+		t.Errorf("RefundEnergy() gave incorrect results, want: %v, got: %v", 30, gotPlayer.Energy)
+	}
+
+	// the same refund sent again gives back nothing more
+	gotPlayer, gotErr = hc2.RefundEnergy(context.Background(), &EnergyRefund{PlayerID: "player2", AttemptID: "attempt1", Energy: 10})
+	if gotErr != nil {
+		t.Fatalf("RefundEnergy() failed with an unexpected error, %v", gotErr)
+	}
+
+	if gotPlayer.Energy != 30 {
+		t.Errorf("RefundEnergy() should not refund an attempt twice, want: %v, got: %v", 30, gotPlayer.Energy)
+	}
+}
+
+func TestServer_secondsToMaxEnergy(t *testing.T) {
+
+	ps := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	noRegenServer := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+	noRegenServer.energyRegenPerSecond = 0
+
+	var now int64 = 1000
+
+	tests := []struct {
+		name   string
+		server *Server
+		player *data.PlayerData
+		want   int64
+	}{
+		{"max energy", ps, &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 50, LastUpdateTime: now}, 0},
+		{"regenerated to max", ps, &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 40, LastUpdateTime: now - 100}, 0},
+		{"just updated", ps, &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 40, LastUpdateTime: now}, 50},
+		{"partially regenerated", ps, &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 40, LastUpdateTime: now - 10}, 40},
+		{"no regeneration", noRegenServer, &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 40, LastUpdateTime: now}, -1},
+		{"boosted", ps, &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 40, LastUpdateTime: now, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 100}}}, 25},
+		{"boost expires before max", ps, &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 40, LastUpdateTime: now, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 10}}}, 40},
+		{"expired boost", ps, &data.PlayerData{PlayerID: "player1", Level: 1, Energy: 40, LastUpdateTime: now, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now}}}, 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.server.secondsToMaxEnergy(test.player, now)
+			if got != test.want {
+				t.Errorf("secondsToMaxEnergy gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_regeneratedEnergy(t *testing.T) {
+
+	ps := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	var now int64 = 1000
+
+	tests := []struct {
+		name   string
+		player *data.PlayerData
+		want   int32
+	}{
+		{"no boosts", &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 50}, 20},
+		{"active boost", &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 50, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 10}}}, 30},
+		{"boost expired in between", &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 50, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 3, ExpiryTime: now - 40}}}, 24},
+		{"overlapping boosts", &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 50, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 10}, {BoostID: "boost2", RegenMultiplier: 3, ExpiryTime: now - 25}}}, 35},
+		{"capped at max energy", &data.PlayerData{PlayerID: "player1", Energy: 45, LastUpdateTime: now - 50, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 10}}}, 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ps.regeneratedEnergy(test.player, now)
+			if got != test.want {
+				t.Errorf("regeneratedEnergy gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_ActivateBoost(t *testing.T) {
+
+	authServer := auth.NewServer(data.NewServer())
+	ps := NewServer(authServer, data.NewServer())
+
+	now := time.Now().UTC().Unix()
+	err := ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 20, LastUpdateTime: now})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		activation *BoostActivation
+		wantBoosts []data.EnergyBoost
+		expError   error
+	}{
+		{"nil server", nil, &BoostActivation{PlayerID: "player2", BoostID: "boost1", RegenMultiplier: 2, DurationSeconds: 60}, nil, serverNilError},
+		{"invalid player", ps, &BoostActivation{PlayerID: "player1", BoostID: "boost1", RegenMultiplier: 2, DurationSeconds: 60}, nil, data.PlayerNotFoundErr{PlayerID: "player1"}},
+		{"invalid multiplier", ps, &BoostActivation{PlayerID: "player2", BoostID: "boost1", RegenMultiplier: 1, DurationSeconds: 60}, nil, errors.New("")},
+		{"invalid duration", ps, &BoostActivation{PlayerID: "player2", BoostID: "boost1", RegenMultiplier: 2, DurationSeconds: 0}, nil, errors.New("")},
+		{"new boost", ps, &BoostActivation{PlayerID: "player2", BoostID: "boost1", RegenMultiplier: 2, DurationSeconds: 60}, []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 60}}, nil},
+		{"extended boost", ps, &BoostActivation{PlayerID: "player2", BoostID: "boost1", RegenMultiplier: 2, DurationSeconds: 60}, []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 120}}, nil},
+		{"second boost", ps, &BoostActivation{PlayerID: "player2", BoostID: "boost2", RegenMultiplier: 3, DurationSeconds: 30}, []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 120}, {BoostID: "boost2", RegenMultiplier: 3, ExpiryTime: now + 30}}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotPlayer, gotErr := test.server.ActivateBoost(context.Background(), test.activation)
+			if test.expError != nil {
+				if gotErr == nil {
+					t.Fatalf("ActivateBoost() should have failed")
+				}
+				fmt.Println(gotErr)
+				return
+			}
+			if gotErr != nil {
+				t.Fatalf("ActivateBoost() failed with an unexpected error, %v", gotErr)
+			}
+
+			if !reflect.DeepEqual(gotPlayer.Boosts, test.wantBoosts) {
+				t.Errorf("ActivateBoost() gave incorrect results, want: %v, got: %v", test.wantBoosts, gotPlayer.Boosts)
+			}
+		})
+	}
+}
+
+func TestServer_HandleEnergyEventsRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ps := NewServer(as, data.NewServer())
+
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1, Energy: 50, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	err = ps.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player3", Level: 1, Energy: 10, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player2", "session3": "player3", "session5": "player5"}
+
+	tests := []struct {
+		name          string
+		server        *Server
+		sessionID     string
+		playerID      string
+		wantStatus    int
+		wantEvents    []string
+		notWantEvents []string
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError, nil, nil},
+		{"invalid session id", ps, "testSessionID", "", http.StatusUnauthorized, nil, nil},
+		{"another player", ps, sID, "player3", http.StatusForbidden, nil, nil},
+		{"new player", ps, "session5", "player5", http.StatusNotFound, nil, nil},
+		{"max energy player", ps, sID, "player2", http.StatusOK, []string{"event: energy\n", "event: energy-full\n"}, nil},
+		{"regenerating player", ps, "session3", "player3", http.StatusOK, []string{"event: energy\n"}, []string{"event: energy-full\n"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// the client goes away shortly after connecting (the regenerating player's stream would otherwise stay open)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			newReq := httptest.NewRequestWithContext(ctx, http.MethodGet, "/profile/energy-events/", nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			testsetup.WithSession(profileServer, sessions, profileServer.HandleEnergyEventsRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotContentType := respRec.Result().Header.Get("Content-Type")
+				if gotContentType != "text/event-stream" {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", "text/event-stream", gotContentType)
+				}
+
+				gotBody := respRec.Body.String()
+				for _, event := range test.wantEvents {
+					if !strings.Contains(gotBody, event) {
+						t.Errorf("handler response is missing an event, want: %q, got: %q", event, gotBody)
+					}
+				}
+				for _, event := range test.notWantEvents {
+					if strings.Contains(gotBody, event) {
+						t.Errorf("handler response has an unexpected event: %q, got: %q", event, gotBody)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleSyncRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ps := NewServer(as, data.NewServer())
+
+	player2 := data.PlayerData{PlayerID: "player2", Level: 2, Energy: 30, LastUpdateTime: 100, Version: data.PlayerDataVersion}
+	err = ps.dataClient.WritePlayer(context.Background(), &player2)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	levelStats := []data.PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 2}}
+	err = ps.dataClient.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: "player2", PlayerStats: data.PlayerStats{LevelStats: levelStats}})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	player3 := data.PlayerData{PlayerID: "player3", Level: 1, Energy: 50, LastUpdateTime: 100, Version: data.PlayerDataVersion}
+	err = ps.dataClient.WritePlayer(context.Background(), &player3)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	later := time.Now().UTC().Unix() + 3600
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player2", "session3": "player3", "session5": "player5"}
+
+	tests := []struct {
+		name           string
+		server         *Server
+		sessionID      string
+		playerID       string
+		query          string
+		wantStatus     int
+		wantPlayer     *data.PlayerData
+		wantLevelStats []data.PlayerLevelStats
+	}{
+		{"nil server", nil, "", "", "", http.StatusInternalServerError, nil, nil},
+		{"invalid session id", ps, "testSessionID", "player2", "", http.StatusUnauthorized, nil, nil},
+		{"invalid since", ps, sID, "player2", "?since=yesterday", http.StatusBadRequest, nil, nil},
+		{"another player", ps, sID, "player3", "", http.StatusForbidden, nil, nil},
+		{"new player", ps, "session5", "player5", "", http.StatusNotFound, nil, nil},
+		{"first sync", ps, sID, "player2", "", http.StatusOK, &player2, levelStats},
+		{"stats changed", ps, sID, "player2", "?since=101", http.StatusOK, nil, levelStats},
+		{"nothing changed", ps, sID, "player2", fmt.Sprintf("?since=%v", later), http.StatusOK, nil, []data.PlayerLevelStats{}},
+		{"player without stats", ps, "session3", "player3", "?since=100", http.StatusOK, &player3, []data.PlayerLevelStats{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/profile/sync/"+test.playerID+test.query, nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			profileServer := test.server
+			testsetup.WithSession(profileServer, sessions, profileServer.HandleSyncRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponse := &SyncResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponse)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotResponse.SyncTime <= 100 {
+					t.Errorf("handler gave incorrect results, want a current sync time, got: %v", gotResponse.SyncTime)
+				}
+
+				if !reflect.DeepEqual(gotResponse.Player, test.wantPlayer) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantPlayer, gotResponse.Player)
+				}
+
+				if !reflect.DeepEqual(gotResponse.LevelStats, test.wantLevelStats) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantLevelStats, gotResponse.LevelStats)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_reconcileEnergy(t *testing.T) {
+
+	ps := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	var now int64 = 1000
+
+	tests := []struct {
+		name       string
+		player     *data.PlayerData
+		wantPlayer *data.PlayerData
+		wantErr    error
+	}{
+		{"up to date", &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 4}, &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 4}, energyUpToDateError},
+		{"full", &data.PlayerData{PlayerID: "player1", Energy: 50, LastUpdateTime: now - 100}, &data.PlayerData{PlayerID: "player1", Energy: 50, LastUpdateTime: now - 100}, energyUpToDateError},
+		{"keeps progress", &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 13}, &data.PlayerData{PlayerID: "player1", Energy: 12, LastUpdateTime: now - 3}, nil},
+		{"regenerated to max", &data.PlayerData{PlayerID: "player1", Energy: 45, LastUpdateTime: now - 100}, &data.PlayerData{PlayerID: "player1", Energy: 50, LastUpdateTime: now}, nil},
+		{"boosted", &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 14, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 10}}}, &data.PlayerData{PlayerID: "player1", Energy: 15, LastUpdateTime: now - 1, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 2, ExpiryTime: now + 10}}}, nil},
+		{"boost expired before the kept progress", &data.PlayerData{PlayerID: "player1", Energy: 10, LastUpdateTime: now - 50, Boosts: []data.EnergyBoost{{BoostID: "boost1", RegenMultiplier: 3, ExpiryTime: now - 40}}}, &data.PlayerData{PlayerID: "player1", Energy: 24, LastUpdateTime: now}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotErr := ps.reconcileEnergy(test.player, now)
+			if gotErr != test.wantErr {
+				t.Fatalf("reconcileEnergy gave incorrect results, want error: %v, got: %v", test.wantErr, gotErr)
+			}
+			if !reflect.DeepEqual(test.player, test.wantPlayer) {
+				t.Errorf("reconcileEnergy gave incorrect results, want: %+v, got: %+v", test.wantPlayer, test.player)
+			}
+		})
+	}
+}
+
+func TestServer_reconcileAllEnergy(t *testing.T) {
+
+	dataServer := data.NewServer()
+	ps := NewServer(auth.NewServer(dataServer), dataServer)
+
+	var now int64 = 10 * secondsPerDay
+
+	players := []*data.PlayerData{
+		{PlayerID: "player1", Level: 1, Energy: 50, LastUpdateTime: now - 100},
+		{PlayerID: "player2", Level: 1, Energy: 10, LastUpdateTime: now - 2},
+		{PlayerID: "player3", Level: 1, Energy: 10, LastUpdateTime: now - 20},
+		{PlayerID: "player4", Level: 2, Energy: 0, LastUpdateTime: now - 100},
+		{PlayerID: "player5", Level: 1, Energy: 0, LastUpdateTime: now - 3*secondsPerDay},
+	}
+	for _, player := range players {
+		err := dataServer.WritePlayer(context.Background(), player)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name               string
+		maxInactiveSeconds int64
+		wantCount          int
+		wantEnergy         map[string]int32
+	}{
+		{"recently active", 2 * secondsPerDay, 2, map[string]int32{"player1": 50, "player2": 10, "player3": 14, "player4": 20, "player5": 0}},
+		{"all players", 0, 1, map[string]int32{"player1": 50, "player2": 10, "player3": 14, "player4": 20, "player5": 50}},
+		{"nothing left to reconcile", 0, 0, map[string]int32{"player1": 50, "player2": 10, "player3": 14, "player4": 20, "player5": 50}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// a batch size of 2 makes the reconciler go through several pages
+			gotCount, err := ps.reconcileAllEnergy(context.Background(), now, 2, test.maxInactiveSeconds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotCount != test.wantCount {
+				t.Errorf("reconcileAllEnergy gave incorrect results, want: %v players, got: %v", test.wantCount, gotCount)
+			}
+
+			for playerID, wantEnergy := range test.wantEnergy {
+				player, err := dataServer.ReadPlayer(context.Background(), playerID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if player.Energy != wantEnergy {
+					t.Errorf("incorrect energy for %v, want: %v, got: %v", playerID, wantEnergy, player.Energy)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleMergeRequests(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ds := data.NewServer()
+	as := auth.NewServer(ds)
+	ps := NewServer(as, ds)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ps.SetClock(clock.NewFrozen(now))
+	ps.energyBankCap = 20
+
+	// login logs the given user in, and returns their session id and player id
+	serverVersion := "0"
+	login := func(username string, isNewUser bool) (string, string) {
+		body, err := json.Marshal(&auth.LoginRequestBody{IsNewUser: isNewUser, ServerVersion: serverVersion})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body))
+		req.SetBasicAuth(username, "pass1")
+		respRec := httptest.NewRecorder()
+		as.HandleLoginRequest(respRec, req)
+
+		resp := &auth.LoginResponse{}
+		err = json.NewDecoder(respRec.Body).Decode(resp)
+		if err != nil || respRec.Code != http.StatusOK {
+			t.Fatalf("could not log in as %v, got: %v, %v", username, respRec.Code, err)
+		}
+		serverVersion = resp.ServerVersion
+		return respRec.Header().Get("Session-Id"), resp.PlayerID
+	}
+
+	primarySID, primaryID := login("user1", true)
+	secondarySID, secondaryID := login("user2", true)
+
+	for _, player := range []data.PlayerData{
+		{PlayerID: primaryID, Level: 3, Energy: 30, BankedEnergy: 5, FTUEStep: 3, LastUpdateTime: now.Unix(), CreatedTime: now.Unix() - 100},
+		{PlayerID: secondaryID, Level: 6, Energy: 35, BankedEnergy: 5, FTUEStep: 1, LastUpdateTime: now.Unix(), CreatedTime: now.Unix() - 500},
+	} {
+		err := ds.WritePlayer(context.Background(), &player)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for playerID, levelStats := range map[string][]data.PlayerLevelStats{primaryID: {{Level: 1, WinCount: 2, LossCount: 0, BestScore: 3}}, secondaryID: {{Level: 1, WinCount: 1, LossCount: 1, BestScore: 2}, {Level: 2, WinCount: 1, LossCount: 0, BestScore: 4}}} {
+		err := ds.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: playerID, PlayerStats: data.PlayerStats{LevelStats: levelStats}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	adminTests := []struct {
+		name       string
+		adminToken string
+		body       string
+		wantStatus int
+	}{
+		{"invalid admin token", "testToken", fmt.Sprintf(`{"primaryID":%q,"secondaryID":%q}`, primaryID, secondaryID), http.StatusUnauthorized},
+		{"same player", "adminToken", fmt.Sprintf(`{"primaryID":%q,"secondaryID":%q}`, primaryID, primaryID), http.StatusBadRequest},
+		{"unknown secondary", "adminToken", fmt.Sprintf(`{"primaryID":%q,"secondaryID":"player3"}`, primaryID), http.StatusNotFound},
+	}
+
+	for _, test := range adminTests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/profile/admin/merge", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			ps.HandleAdminMergeRequest(respRec, newReq)
+
+			if respRec.Code != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, respRec.Code)
+			}
+		})
+	}
+
+	tests := []struct {
+		name             string
+		secondarySession string
+		wantStatus       int
+	}{
+		{"no secondary session", "", http.StatusForbidden},
+		{"invalid secondary session", "session0", http.StatusForbidden},
+		{"own session", primarySID, http.StatusBadRequest},
+		{"merge", secondarySID, http.StatusOK},
+		{"merge again", secondarySID, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/profile/merge", nil)
+			newReq.Header.Set("Session-Id", primarySID)
+			newReq.Header.Set(constants.SecondarySessionHeader, test.secondarySession)
+			respRec := httptest.NewRecorder()
+
+			testsetup.WithSession(ps, as, ps.HandleMergeRequest)(respRec, newReq)
+
+			if respRec.Code != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, respRec.Code)
+			}
+			if respRec.Code != http.StatusOK {
+				return
+			}
+
+			got := &data.PlayerWithStats{}
+			err := json.NewDecoder(respRec.Body).Decode(got)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			// the energy is capped at the max energy, the banked energy is combined, and the higher level is kept
+			want := data.PlayerData{PlayerID: primaryID, Level: 6, Energy: 50, BankedEnergy: 10, FTUEStep: 3, LastUpdateTime: now.Unix(), CreatedTime: now.Unix() - 500}
+			want.Segment = config.Config.Segments.SegmentOf(want.CreatedTime, 0, now.Unix())
+			if !got.Player.Equal(want) {
+				t.Errorf("handler gave incorrect results, want: %+v, got: %+v", want, got.Player)
+			}
+			wantStats := []data.PlayerLevelStats{{Level: 1, WinCount: 3, LossCount: 1, BestScore: 2}, {Level: 2, WinCount: 1, LossCount: 0, BestScore: 4}}
+			if !reflect.DeepEqual(got.Stats.LevelStats, wantStats) {
+				t.Errorf("handler gave incorrect stats, want: %+v, got: %+v", wantStats, got.Stats.LevelStats)
+			}
+		})
+	}
+
+	// the merge is in the audit log
+	page, err := ds.ReadAuditEntries(context.Background(), &data.AuditQuery{Action: audit.ActionAccountMerge})
+	if err != nil || len(page.Entries) != 1 || page.Entries[0].Actor != primaryID || page.Entries[0].PlayerID != primaryID {
+		t.Errorf("the merge should have been recorded in the audit log, got: %+v, %v", page, err)
+	}
+
+	// the sessions of the retired player id are signed out, the ones of the primary player are kept
+	validationReq := httptest.NewRequest(http.MethodGet, "/profile/player-data", nil)
+	validationReq.Header.Set("Session-Id", secondarySID)
+	if _, err = as.ValidateRequest(validationReq); err == nil {
+		t.Errorf("the session of the secondary player should have been signed out by the merge")
+	}
+	validationReq.Header.Set("Session-Id", primarySID)
+	if _, err = as.ValidateRequest(validationReq); err != nil {
+		t.Errorf("the session of the primary player should still be valid, got: %v", err)
+	}
+
+	// logging in to the secondary account logs in to the primary player
+	_, loggedInID := login("user2", false)
+	if loggedInID != primaryID {
+		t.Errorf("the login of the secondary account should have been redirected, want player id: %v, got: %v", primaryID, loggedInID)
+	}
+}
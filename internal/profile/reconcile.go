@@ -0,0 +1,189 @@
+package profile
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/leader"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// secondsPerDay is used to convert the active days of the energy reconciler
+const secondsPerDay = 24 * 60 * 60
+
+// energyReconcileJob is the name of the lease on the energy reconcile, so only one profile service runs it
+const energyReconcileJob = "profile-energy-reconcile"
+
+// energyUpToDateError is returned by the reconcile change of a player whose energy is up to date already,
+// so the player is not written back
+var energyUpToDateError = fmt.Errorf("energy is up to date")
+
+// EnableEnergyReconcileFromEnv enables the periodic energy reconciler with the interval, batch size and active days
+// given by the environment variables (see constants.EnergyReconcileSecondsEnvVar), it stays disabled if the interval is not set
+func (ps *Server) EnableEnergyReconcileFromEnv() error {
+
+	if ps == nil {
+		return serverNilError
+	}
+
+	intervalEnv := os.Getenv(constants.EnergyReconcileSecondsEnvVar)
+	if intervalEnv == "" {
+		return nil
+	}
+
+	interval, err := strconv.Atoi(intervalEnv)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("%v should be a positive number of seconds, got: %v", constants.EnergyReconcileSecondsEnvVar, intervalEnv)
+	}
+
+	batchSize := constants.EnergyReconcileDefaultBatch
+	if batchEnv := os.Getenv(constants.EnergyReconcileBatchEnvVar); batchEnv != "" {
+		batchSize, err = strconv.Atoi(batchEnv)
+		if err != nil || batchSize <= 0 {
+			return fmt.Errorf("%v should be a positive batch size, got: %v", constants.EnergyReconcileBatchEnvVar, batchEnv)
+		}
+	}
+
+	activeDays := 0
+	if activeDaysEnv := os.Getenv(constants.EnergyReconcileActiveDaysEnvVar); activeDaysEnv != "" {
+		activeDays, err = strconv.Atoi(activeDaysEnv)
+		if err != nil || activeDays <= 0 {
+			return fmt.Errorf("%v should be a positive number of days, got: %v", constants.EnergyReconcileActiveDaysEnvVar, activeDaysEnv)
+		}
+	}
+
+	ps.EnableEnergyReconcile(time.Duration(interval)*time.Second, batchSize, int64(activeDays)*secondsPerDay)
+	return nil
+}
+
+// EnableEnergyReconcile starts a periodic job which brings the stored energy of the players up to date (so consumers of
+// the raw player data in the data service do not see stale energy), reading the players in batches of the given size.
+// A max inactive seconds of 0 reconciles all the players, otherwise only the ones updated within that many seconds
+func (ps *Server) EnableEnergyReconcile(interval time.Duration, batchSize int, maxInactiveSeconds int64) {
+
+	if ps == nil {
+		return
+	}
+
+	ps.logger.Printf("energy reconciler enabled, every %v in batches of %v", interval, batchSize)
+
+	// with several profile services, only the one holding the lease on the job reconciles
+	elector := leader.NewElector(ps.dataClient, "profile", ps.logger)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			timeNow := <-ticker.C
+			if !elector.IsLeader(context.Background(), energyReconcileJob, interval) {
+				continue
+			}
+
+			count, err := ps.reconcileAllEnergy(context.Background(), timeNow.UTC().Unix(), batchSize, maxInactiveSeconds)
+			if err != nil {
+				ps.logger.Println("error in the periodic energy reconcile: " + err.Error())
+				continue
+			}
+			ps.logger.Printf("reconciled the energy of %v players", count)
+		}
+	}()
+}
+
+// reconcileAllEnergy goes through all the players (of the namespace) in batches of the given size, brings the energy
+// of the ones which regenerated some since their last update up to date, and returns how many were written back
+func (ps *Server) reconcileAllEnergy(ctx context.Context, now int64, batchSize int, maxInactiveSeconds int64) (int, error) {
+
+	if ps == nil {
+		return 0, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "profile.ReconcileAllEnergy")
+	defer span.End()
+
+	count := 0
+	cursor := ""
+	for {
+		page, err := ps.dataClient.ListPlayers(ctx, pagination.Request{Cursor: cursor, Limit: batchSize})
+		if err != nil {
+			return count, err
+		}
+
+		for i := range page.Players {
+			listed := &page.Players[i]
+
+			// skip the players which have not regenerated a whole energy point (checked on the listed data, to avoid writes)
+			if maxInactiveSeconds > 0 && listed.LastUpdateTime < now-maxInactiveSeconds {
+				continue
+			}
+			if ps.regeneratedEnergy(listed, now) <= listed.Energy {
+				continue
+			}
+
+			reconciled, err := ps.reconcilePlayerEnergy(ctx, listed.PlayerID, now)
+			if err != nil {
+				// a single player failing (like being archived in the meantime) does not stop the rest
+				ps.logger.Printf("could not reconcile the energy of player id %v: %v", listed.PlayerID, err)
+				continue
+			}
+			if reconciled {
+				count++
+			}
+		}
+
+		if page.NextCursor == "" {
+			return count, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// reconcilePlayerEnergy brings the stored energy of the given player up to date at the given time,
+// and returns whether the player was written back (it is not if the energy was up to date already)
+func (ps *Server) reconcilePlayerEnergy(ctx context.Context, playerID string, now int64) (bool, error) {
+
+	ps.playersMutex.Lock()
+	defer ps.playersMutex.Unlock()
+
+	_, err := ps.modifyPlayer(ctx, playerID, func(player *data.PlayerData) error {
+		return ps.reconcileEnergy(player, now)
+	})
+	if err == energyUpToDateError {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// reconcileEnergy adds the whole energy points the given player regenerated by the given time. Unlike updateEnergy,
+// the regeneration progress towards the next point is kept: the last update time only moves forward by the time the
+// added points took (or to the given time, once the energy is full), so a frequent reconcile never slows down regeneration
+func (ps *Server) reconcileEnergy(player *data.PlayerData, now int64) error {
+
+	if player == nil {
+		return fmt.Errorf("nil player data pointer")
+	}
+
+	energy := ps.regeneratedEnergy(player, now)
+	if energy <= player.Energy {
+		return energyUpToDateError
+	}
+
+	if energy >= ps.maxEnergy {
+		player.LastUpdateTime = now
+	} else {
+		regenTime := float64(energy-player.Energy) / ps.energyRegenPerSecond
+		player.LastUpdateTime = min(boostedSecondsReachedAt(player.Boosts, player.LastUpdateTime, regenTime), now)
+	}
+
+	player.Energy = energy
+	player.Boosts = activeBoosts(player.Boosts, player.LastUpdateTime)
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"net/http"
+	"strconv"
+)
+
+// SyncResponse is used as the client response for the public delta sync api, it holds what changed for the player
+// since the watermark of the request: their player data (left out if it did not change), the level stats which
+// changed, their rating (left out if it did not change), their prestige count and their win streak. The sync time is passed as the since watermark of the
+// next sync request
+type SyncResponse struct {
+	PlayerID      string                  `json:"playerID"`
+	SyncTime      int64                   `json:"syncTime"`
+	Player        *data.PlayerData        `json:"playerData,omitempty"`
+	LevelStats    []data.PlayerLevelStats `json:"levelStats"`
+	Rating        int32                   `json:"rating,omitempty"`
+	PrestigeCount int32                   `json:"prestigeCount,omitempty"`
+	WinStreak     int32                   `json:"winStreak,omitempty"`
+}
+
+// HandleSyncRequest responds with what changed for the requested player at or after the unix time in the since
+// query parameter (everything without it), so returning clients can reconcile their state without reading all of it.
+// The player data is sent as stored (the client applies the energy regeneration since its last update time),
+// so it is only read (never written back)
+func (ps *Server) HandleSyncRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	since := int64(0)
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		var err error
+		since, err = strconv.ParseInt(sinceParam, 10, 64)
+		if err != nil || since < 0 {
+			errMsg := "error: invalid since time in request"
+			ps.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+			return
+		}
+	}
+
+	// get the id from the request uri
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+	ps.logger.Printf("sync requested for id: %v since: %v", id, since)
+
+	// the sync time is taken before reading, and changes are included from the start of the since second,
+	// so a change made while this request is handled is sent (again) on the next sync instead of being missed
+	response := &SyncResponse{PlayerID: id, SyncTime: ps.clock.Now().UTC().Unix(), LevelStats: []data.PlayerLevelStats{}}
+
+	player, err := ps.dataClient.ReadPlayer(r.Context(), id)
+	if err != nil {
+		errMsg := "read player error: " + err.Error()
+		ps.logger.Println(errMsg)
+		if errors.Is(err, data.PlayerNotFoundErr{PlayerID: id}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		} else {
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		}
+		return
+	}
+
+	if player.LastUpdateTime >= since {
+		response.Player = player
+	}
+
+	// a player without stats has not finished a level yet
+	statsDelta, err := ps.dataClient.ReadStatsDelta(r.Context(), id, since)
+	if err != nil && !errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: id}) {
+		errMsg := "read stats delta error: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	if statsDelta != nil {
+		response.LevelStats = statsDelta.LevelStats
+		response.Rating = statsDelta.Rating
+		response.PrestigeCount = statsDelta.PrestigeCount
+		response.WinStreak = statsDelta.WinStreak
+	}
+
+	// send the response back
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		errMsg := "error: could not encode sync response: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
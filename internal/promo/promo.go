@@ -0,0 +1,387 @@
+// Package promo: service which lets admins create promo codes,
+// and lets players redeem them for energy, coins, and items
+
+package promo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Promo Specific Errors:
+var serverNilError = fmt.Errorf("provided promo server pointer is nil")
+
+type RedeemRequestBody struct {
+	PlayerID string `json:"playerID"`
+	Code     string `json:"code"`
+}
+
+// RedeemResponse contains what the redeemed code granted, and everything about the player that it can change
+type RedeemResponse struct {
+	PromoCode data.PromoCode     `json:"promoCode"`
+	Player    data.PlayerData    `json:"playerData"`
+	Wallet    data.WalletData    `json:"wallet"`
+	Inventory data.InventoryData `json:"inventory"`
+}
+
+// RedemptionsPage is a page of the promo code redemption history of a player, with the cursor of the next page
+// (left out on the last page)
+type RedemptionsPage struct {
+	Redemptions []data.PromoRedemption `json:"redemptions"`
+	NextCursor  string                 `json:"nextCursor,omitempty"`
+}
+
+// Server is the core promo service provider
+type Server struct {
+	requestValidator validation.RequestValidator
+	dataClient       data.DataClient
+	profileClient    profile.ProfileClient
+
+	auditRecorder *audit.Recorder
+
+	logger *log.Logger
+}
+
+// NewServer returns an initialized pointer to the promo server
+func NewServer(rv validation.RequestValidator, dc data.DataClient, pc profile.ProfileClient) *Server {
+
+	logger := log.New(redact.Stdout, "promo: ", log.Ltime|log.LUTC|log.Lmsgprefix)
+
+	return &Server{
+		requestValidator: rv,
+		dataClient:       dc,
+		profileClient:    pc,
+
+		auditRecorder: audit.NewRecorder(dc, logger),
+
+		logger: logger,
+	}
+}
+
+// Run runs a given promo server on the given port
+func (ps *Server) Run(port string) {
+	log.Fatal(ps.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the promo server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (ps *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ps.serve(ctx, listener)
+}
+
+// RunWithListener runs the promo server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (ps *Server) RunWithListener(listener net.Listener) error {
+	return ps.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the promo server, and serves them on the given listener till the context is done
+func (ps *Server) serve(ctx context.Context, listener net.Listener) error {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("POST /promo/redeem", middleware.WithLimits(validation.WithSession(ps.requestValidator, ps.HandleRedeemRequest), middleware.DefaultLimits))
+
+	mux.Handle("POST /promo/admin/codes", middleware.WithLimits(ps.HandleCreateCodeRequest, middleware.DefaultLimits))
+	mux.Handle("GET /promo/admin/redemptions/{id}", middleware.WithLimits(ps.HandleGetRedemptionsRequest, middleware.DefaultLimits))
+
+	ps.logger.Println("the promo server is up and running...")
+
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("promo", middleware.WithAccessLog("promo", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
+}
+
+// HandleRedeemRequest is a wrapper around the Redeem() method,
+// it sends back what the code granted, and the updated player state
+func (ps *Server) HandleRedeemRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	redeemRequest := &RedeemRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(redeemRequest)
+	if err != nil {
+		errMsg := "error: could not decode the redeem request: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &redeemRequest.PlayerID) {
+		return
+	}
+	ps.logger.Printf("request to redeem promo code %v by player id %v", redeemRequest.Code, redeemRequest.PlayerID)
+
+	redeemResponse, err := ps.Redeem(r.Context(), redeemRequest.PlayerID, redeemRequest.Code)
+	if err != nil {
+		errMsg := "error: could not redeem the promo code: " + err.Error()
+		ps.logger.Println(errMsg)
+		switch {
+		case errors.As(err, &data.PlayerNotFoundErr{}):
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		case errors.As(err, &data.PromoCodeNotFoundErr{}):
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodePromoCodeNotFound)
+		case errors.As(err, &data.PromoCodeUnavailableErr{}):
+			apierror.Write(w, r, http.StatusGone, apierror.CodePromoCodeUnavailable)
+		case errors.As(err, &data.PromoCodeAlreadyRedeemedErr{}):
+			apierror.Write(w, r, http.StatusConflict, apierror.CodePromoCodeAlreadyRedeemed)
+		default:
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(redeemResponse)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		ps.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// Redeem redeems the promo code for the player, and then grants its contents.
+// The redemption is recorded first (which is what guarantees each player can only redeem a code once),
+// so if granting fails part way, the error is logged and returned, and the code is not redeemable again
+func (ps *Server) Redeem(ctx context.Context, playerID string, code string) (*RedeemResponse, error) {
+
+	if ps == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "promo.Redeem")
+	defer span.End()
+
+	// make sure the player exists before redeeming anything for them
+	player, err := ps.profileClient.GetPlayer(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	promoCode, err := ps.dataClient.RedeemPromoCode(ctx, &data.PromoRedemption{Code: code, PlayerID: playerID, Time: time.Now().UTC().Unix()})
+	if err != nil {
+		return nil, err
+	}
+
+	ps.auditRecorder.Record(ctx, playerID, audit.ActionPromoRedeem, playerID, promoCode)
+
+	response, err := ps.grant(ctx, player, promoCode)
+	if err != nil {
+		ps.logger.Printf("error: promo code %v was redeemed by player id %v, but could not be granted: %v", promoCode.Code, playerID, err)
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// grant applies the energy, coins, and items of the promo code to the player
+func (ps *Server) grant(ctx context.Context, player *data.PlayerData, promoCode *data.PromoCode) (*RedeemResponse, error) {
+
+	var err error
+
+	if promoCode.Energy > 0 {
+		player, err = ps.profileClient.UpdatePlayerData(playerctx.WithPlayerID(ctx, player.PlayerID), promoCode.Energy, player.Level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wallet, err := ps.dataClient.InitWallet(ctx, &data.WalletData{PlayerID: player.PlayerID, Coins: config.Config.DefaultCoins})
+	if err != nil {
+		return nil, err
+	}
+
+	if promoCode.Coins > 0 {
+		wallet, err = ps.dataClient.AdjustWallet(ctx, player.PlayerID, promoCode.Coins)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	inventory, err := ps.dataClient.ReadInventory(ctx, player.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range promoCode.Items {
+
+		// energy boosts are activated right away (each one granted extends the boost), instead of going to the inventory
+		if shopItem, ok := config.Config.ShopItem(item.ItemID); ok && shopItem.Kind == config.ShopItemKindEnergyBoost {
+			activation := &profile.BoostActivation{PlayerID: player.PlayerID, BoostID: item.ItemID, RegenMultiplier: shopItem.RegenMultiplier, DurationSeconds: shopItem.BoostSeconds * int64(item.Count)}
+			player, err = ps.profileClient.ActivateBoost(ctx, activation)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		inventory, err = ps.dataClient.GrantItem(ctx, &data.ItemGrant{PlayerID: player.PlayerID, ItemID: item.ItemID, Count: item.Count})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &RedeemResponse{PromoCode: *promoCode, Player: *player, Wallet: *wallet, Inventory: *inventory}, nil
+}
+
+// HandleCreateCodeRequest lets an admin create a new promo code, the items it grants have to be in the shop catalog
+func (ps *Server) HandleCreateCodeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	// decode the request
+	promoCode := &data.PromoCode{}
+	err = json.NewDecoder(r.Body).Decode(promoCode)
+	if err != nil {
+		errMsg := "error: could not decode the promo code: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	err = validateNewCode(promoCode, time.Now().UTC().Unix())
+	if err != nil {
+		errMsg := "error: invalid promo code: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	promoCode.Code = data.NormalizePromoCode(promoCode.Code)
+	promoCode.Uses = 0
+
+	err = ps.dataClient.CreatePromoCode(r.Context(), promoCode)
+	if err != nil {
+		errMsg := "error: could not create the promo code: " + err.Error()
+		ps.logger.Println(errMsg)
+		if errors.As(err, &data.PromoCodeExistsErr{}) {
+			http.Error(w, errMsg, http.StatusConflict)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ps.auditRecorder.Record(r.Context(), audit.ActorAdmin, audit.ActionPromoCreate, "", promoCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(promoCode)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// validateNewCode checks that the promo code is valid, has not already expired,
+// and that all the items it grants are in the shop catalog
+func validateNewCode(promoCode *data.PromoCode, unixNow int64) error {
+
+	err := promoCode.Validate()
+	if err != nil {
+		return err
+	}
+
+	if promoCode.ExpiryTime != 0 && promoCode.ExpiryTime <= unixNow {
+		return fmt.Errorf("promo code expiry time is in the past")
+	}
+
+	for _, item := range promoCode.Items {
+		_, ok := config.Config.ShopItem(item.ItemID)
+		if !ok {
+			return fmt.Errorf("item: %v is not in the shop catalog", item.ItemID)
+		}
+	}
+
+	return nil
+}
+
+// HandleGetRedemptionsRequest lets an admin look up a page of the promo code redemption history of a player, oldest first by default
+func (ps *Server) HandleGetRedemptionsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ps == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	page, err := pagination.ParseRequest(r.URL.Query())
+	if err != nil {
+		errMsg := "error: invalid page request: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	redemptions, err := ps.dataClient.ReadRedemptions(r.Context(), r.PathValue("id"))
+	if err != nil {
+		errMsg := "error: could not read the redemptions: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	redemptionsPage := &RedemptionsPage{}
+	redemptionsPage.Redemptions, redemptionsPage.NextCursor, err = pagination.PaginateHistory(redemptions, page)
+	if err != nil {
+		errMsg := "error: invalid page request: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(redemptionsPage)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		ps.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,167 @@
+package promo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/testsetup"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewPromoServer(t *testing.T) {
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+
+	ps := NewServer(authServer, dataServer, profile.NewServer(authServer, dataServer))
+
+	if ps == nil {
+		t.Fatal("new promo server should not return a nil server pointer")
+	}
+}
+
+func TestServer_HandleCreateCodeRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+	ps := NewServer(authServer, dataServer, profile.NewServer(authServer, dataServer))
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", ps, "token", `{"code":"promo1","coins":10}`, http.StatusUnauthorized},
+		{"invalid body", ps, "adminToken", "{", http.StatusBadRequest},
+		{"grants nothing", ps, "adminToken", `{"code":"promo1"}`, http.StatusBadRequest},
+		{"already expired", ps, "adminToken", `{"code":"promo1","coins":10,"expiryTime":100}`, http.StatusBadRequest},
+		{"item not in catalog", ps, "adminToken", `{"code":"promo1","items":[{"itemID":"item1","count":1}]}`, http.StatusBadRequest},
+		{"valid code", ps, "adminToken", `{"code":"promo1","coins":10,"items":[{"itemID":"skin-golden","count":1}]}`, http.StatusOK},
+		{"existing code", ps, "adminToken", `{"code":"promo1","energy":10}`, http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/promo/admin/codes", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			promoServer := test.server
+			promoServer.HandleCreateCodeRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
+
+func TestServer_HandleRedeemRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	ps := NewServer(as, dataServer, profileServer)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, profileServer.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
+	promoCode := &data.PromoCode{Code: "promo1", Coins: 25, Items: []data.InventoryItem{{ItemID: "skin-golden", Count: 1}, {ItemID: "boost-regen-2x", Count: 2}}, MaxUses: 1}
+	err = dataServer.CreatePromoCode(context.Background(), promoCode)
+	if err != nil {
+		t.Fatal("promo code setup error: " + err.Error())
+	}
+
+	tests := []struct {
+		name          string
+		server        *Server
+		sessionID     string
+		requestBody   *RedeemRequestBody
+		wantStatus    int
+		wantCoins     int64
+		wantInventory []data.InventoryItem
+		wantBoost     *data.EnergyBoost
+	}{
+		{"nil server", nil, "", nil, http.StatusInternalServerError, 0, nil, nil},
+		{"blank session id", ps, "", nil, http.StatusUnauthorized, 0, nil, nil},
+		{"another player", ps, sID, &RedeemRequestBody{PlayerID: "player2", Code: "promo1"}, http.StatusForbidden, 0, nil, nil},
+		{"unknown player", ps, "session2", &RedeemRequestBody{PlayerID: "player2", Code: "promo1"}, http.StatusNotFound, 0, nil, nil},
+		{"unknown code", ps, sID, &RedeemRequestBody{PlayerID: "player1", Code: "promo2"}, http.StatusNotFound, 0, nil, nil},
+		{"valid code", ps, sID, &RedeemRequestBody{PlayerID: "player1", Code: "promo1"}, http.StatusOK, config.Config.DefaultCoins + 25, []data.InventoryItem{{ItemID: "skin-golden", Count: 1}}, &data.EnergyBoost{BoostID: "boost-regen-2x", RegenMultiplier: 2, ExpiryTime: 7200}},
+		{"repeat redemption", ps, sID, &RedeemRequestBody{PlayerID: "player1", Code: "promo1"}, http.StatusConflict, 0, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err2 := json.NewEncoder(buf).Encode(test.requestBody)
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/promo/redeem", buf)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			promoServer := test.server
+			testsetup.WithSession(promoServer, sessions, promoServer.HandleRedeemRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &RedeemResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotResponseBody.Wallet.Coins != test.wantCoins {
+					t.Errorf("handler gave incorrect coins, want: %v, got: %v", test.wantCoins, gotResponseBody.Wallet.Coins)
+				}
+
+				if !reflect.DeepEqual(gotResponseBody.Inventory.Items, test.wantInventory) {
+					t.Errorf("handler gave incorrect inventory, want: %v, got: %v", test.wantInventory, gotResponseBody.Inventory.Items)
+				}
+
+				// boost expiry times are relative to the last update of the player
+				var gotBoost *data.EnergyBoost
+				if len(gotResponseBody.Player.Boosts) == 1 {
+					gotBoost = &gotResponseBody.Player.Boosts[0]
+					gotBoost.ExpiryTime -= gotResponseBody.Player.LastUpdateTime
+				}
+				if !reflect.DeepEqual(gotBoost, test.wantBoost) {
+					t.Errorf("handler gave incorrect boost, want: %v, got: %v", test.wantBoost, gotBoost)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package referral
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+var clientNilError = fmt.Errorf("provided referral client pointer is nil")
+
+// ReferralClient implementor can complete the referral of a player who won their first level
+// (implemented by the referral Server itself for in-process use, and by HTTPClient
+// when the referral service runs as its own microservice)
+type ReferralClient interface {
+	CompleteReferral(ctx context.Context, playerID string) error
+}
+
+// HTTPClient is the ReferralClient implementation which makes internal (server to server) requests to the referral service
+type HTTPClient struct {
+	baseURL string
+}
+
+// NewHTTPClient returns an initialized pointer to a referral client that talks to the referral service at its address in the startup config (see startup.ServiceURL)
+func NewHTTPClient() *HTTPClient {
+	return &HTTPClient{
+		baseURL: startup.ServiceURL("referral"),
+	}
+}
+
+// CompleteReferral makes an internal request to the referral service to reward the required player and their referrer
+func (hc *HTTPClient) CompleteReferral(ctx context.Context, playerID string) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(&CompleteRequestBody{PlayerID: playerID})
+	if err != nil {
+		return err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/referral/complete-internal", reqBody)
+	if err != nil {
+		return err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("internal complete referral request was not successful, status code %v", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,340 @@
+// Package referral: service which gives every player a referral code to share, lets new players enter the code
+// of the player who referred them, and rewards both of them once the new player wins their first level
+
+package referral
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Referral Specific Errors:
+var serverNilError = fmt.Errorf("provided referral server pointer is nil")
+
+type NotNewPlayerErr struct {
+	PlayerID string
+}
+
+func (err NotNewPlayerErr) Error() string {
+	return fmt.Sprintf("player with id: %v is not a new player", err.PlayerID)
+}
+
+type ClaimRequestBody struct {
+	PlayerID string `json:"playerID"`
+	Code     string `json:"code"`
+}
+
+// CompleteRequestBody is used as a request body for the internal request sent when a player wins their first level
+type CompleteRequestBody struct {
+	PlayerID string `json:"playerID"`
+}
+
+// Server is the core referral service provider
+type Server struct {
+	requestValidator validation.RequestValidator
+	dataClient       data.DataClient
+	profileClient    profile.ProfileClient
+
+	auditRecorder *audit.Recorder
+
+	logger *log.Logger
+}
+
+// NewServer returns an initialized pointer to the referral server
+func NewServer(rv validation.RequestValidator, dc data.DataClient, pc profile.ProfileClient) *Server {
+
+	logger := log.New(redact.Stdout, "referral: ", log.Ltime|log.LUTC|log.Lmsgprefix)
+
+	return &Server{
+		requestValidator: rv,
+		dataClient:       dc,
+		profileClient:    pc,
+
+		auditRecorder: audit.NewRecorder(dc, logger),
+
+		logger: logger,
+	}
+}
+
+// Run runs a given referral server on the given port
+func (rs *Server) Run(port string) {
+	log.Fatal(rs.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the referral server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (rs *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return rs.serve(ctx, listener)
+}
+
+// RunWithListener runs the referral server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (rs *Server) RunWithListener(listener net.Listener) error {
+	return rs.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the referral server, and serves them on the given listener till the context is done
+func (rs *Server) serve(ctx context.Context, listener net.Listener) error {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /referral/code/{id}", middleware.WithLimits(validation.WithSession(rs.requestValidator, rs.HandleReferralCodeRequest), middleware.DefaultLimits))
+	mux.Handle("POST /referral/claim", middleware.WithLimits(validation.WithSession(rs.requestValidator, rs.HandleClaimRequest), middleware.DefaultLimits))
+
+	mux.Handle("POST /referral/complete-internal", middleware.WithLimits(rs.HandleCompleteRequest, middleware.DefaultLimits))
+
+	rs.logger.Println("the referral server is up and running...")
+
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("referral", middleware.WithAccessLog("referral", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
+}
+
+// HandleReferralCodeRequest sends back the referral data of the requested player,
+// with their referral code (which is created the first time it is requested)
+func (rs *Server) HandleReferralCodeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if rs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	playerID := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &playerID) {
+		return
+	}
+	rs.logger.Printf("request for the referral code of player id %v", playerID)
+
+	// make sure the player exists before giving them a code
+	_, err := rs.profileClient.GetPlayer(r.Context(), playerID)
+	if err != nil {
+		errMsg := "error: could not get the referral code: " + err.Error()
+		rs.logger.Println(errMsg)
+		if errors.As(err, &data.PlayerNotFoundErr{}) {
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		} else {
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	referral, err := rs.dataClient.InitReferralCode(r.Context(), playerID)
+	if err != nil {
+		errMsg := "error: could not get the referral code: " + err.Error()
+		rs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(referral)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		rs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandleClaimRequest is a wrapper around the Claim() method,
+// it sends back the referral data of the player who entered the code
+func (rs *Server) HandleClaimRequest(w http.ResponseWriter, r *http.Request) {
+
+	if rs == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	claimRequest := &ClaimRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(claimRequest)
+	if err != nil {
+		errMsg := "error: could not decode the claim request: " + err.Error()
+		rs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &claimRequest.PlayerID) {
+		return
+	}
+	rs.logger.Printf("request to claim referral code %v by player id %v", claimRequest.Code, claimRequest.PlayerID)
+
+	referral, err := rs.Claim(r.Context(), claimRequest.PlayerID, claimRequest.Code, clientIP(r))
+	if err != nil {
+		errMsg := "error: could not claim the referral code: " + err.Error()
+		rs.logger.Println(errMsg)
+		switch {
+		case errors.As(err, &data.PlayerNotFoundErr{}):
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		case errors.As(err, &NotNewPlayerErr{}):
+			apierror.Write(w, r, http.StatusForbidden, apierror.CodeNotNewPlayer)
+		case errors.As(err, &data.ReferralCodeNotFoundErr{}):
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeReferralCodeNotFound)
+		case errors.As(err, &data.ReferralAlreadyClaimedErr{}):
+			apierror.Write(w, r, http.StatusConflict, apierror.CodeReferralAlreadyClaimed)
+		case errors.As(err, &data.SelfReferralErr{}):
+			apierror.Write(w, r, http.StatusForbidden, apierror.CodeSelfReferral)
+		case errors.As(err, &data.ReferralLimitErr{}):
+			apierror.Write(w, r, http.StatusTooManyRequests, apierror.CodeReferralLimit)
+		default:
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(referral)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		rs.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// Claim records that the given player was referred by the owner of the given code. Only new players
+// (who have not unlocked a level yet) can claim a code, and the data service enforces the limits
+// on how many players each code can refer, and how many codes can be claimed from the same ip address
+func (rs *Server) Claim(ctx context.Context, playerID string, code string, ip string) (*data.ReferralData, error) {
+
+	if rs == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "referral.Claim")
+	defer span.End()
+
+	player, err := rs.profileClient.GetPlayer(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if player.Level != config.Config.DefaultLevel {
+		return nil, NotNewPlayerErr{PlayerID: playerID}
+	}
+
+	referral, err := rs.dataClient.ClaimReferral(ctx, &data.ReferralClaim{
+		PlayerID:       playerID,
+		Code:           code,
+		IP:             ip,
+		Time:           time.Now().UTC().Unix(),
+		MaxReferrals:   constants.MaxReferralsPerPlayer,
+		MaxClaimsPerIP: constants.MaxReferralClaimsPerIPPerDay,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rs.auditRecorder.Record(ctx, playerID, audit.ActionReferralClaim, playerID, referral)
+
+	return referral, nil
+}
+
+// HandleCompleteRequest is a wrapper around the CompleteReferral() method,
+// used by the gameplay service when a player wins their first level
+func (rs *Server) HandleCompleteRequest(w http.ResponseWriter, r *http.Request) {
+
+	if rs == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request
+	completeRequest := &CompleteRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(completeRequest)
+	if err != nil {
+		errMsg := "error: could not decode the complete referral request: " + err.Error()
+		rs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	err = rs.CompleteReferral(r.Context(), completeRequest.PlayerID)
+	if err != nil {
+		errMsg := "error: could not complete the referral: " + err.Error()
+		rs.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// CompleteReferral rewards the given player and their referrer (as set in the referral config), if the player
+// was referred and has not been rewarded yet, otherwise it does nothing. The referral is marked as rewarded first
+// (which is what guarantees the rewards are only given once), so if a reward fails, the error is logged and skipped
+func (rs *Server) CompleteReferral(ctx context.Context, playerID string) error {
+
+	if rs == nil {
+		return serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "referral.CompleteReferral")
+	defer span.End()
+
+	referral, err := rs.dataClient.CompleteReferral(ctx, &data.ReferralCompletion{PlayerID: playerID, Time: time.Now().UTC().Unix()})
+	if errors.As(err, &data.ReferralNotFoundErr{}) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rewards := config.Config.Referral
+	rs.grant(ctx, referral.PlayerID, rewards.RefereeEnergyReward, rewards.RefereeCoinReward)
+	rs.grant(ctx, referral.ReferrerID, rewards.ReferrerEnergyReward, rewards.ReferrerCoinReward)
+
+	rs.auditRecorder.Record(ctx, playerID, audit.ActionReferralReward, playerID, referral)
+
+	return nil
+}
+
+// grant gives the given energy and coins to the given player, errors are logged rather than returned
+func (rs *Server) grant(ctx context.Context, playerID string, energy int32, coins int64) {
+
+	if energy > 0 {
+		_, err := rs.profileClient.UpdatePlayerData(playerctx.WithPlayerID(ctx, playerID), energy, 0)
+		if err != nil {
+			rs.logger.Printf("error: could not grant the referral energy reward to player id %v: %v", playerID, err)
+		}
+	}
+
+	if coins > 0 {
+		_, err := rs.dataClient.InitWallet(ctx, &data.WalletData{PlayerID: playerID, Coins: config.Config.DefaultCoins})
+		if err == nil {
+			_, err = rs.dataClient.AdjustWallet(ctx, playerID, coins)
+		}
+		if err != nil {
+			rs.logger.Printf("error: could not grant the referral coin reward to player id %v: %v", playerID, err)
+		}
+	}
+}
+
+// clientIP returns the ip address the given request came from (without the port)
+func clientIP(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,258 @@
+package referral
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/testsetup"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewReferralServer(t *testing.T) {
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+
+	rs := NewServer(authServer, dataServer, profile.NewServer(authServer, dataServer))
+
+	if rs == nil {
+		t.Fatal("new referral server should not return a nil server pointer")
+	}
+}
+
+func TestServer_HandleReferralCodeRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	rs := NewServer(as, dataServer, profileServer)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, profileServer.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		sessionID  string
+		playerID   string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "player1", http.StatusInternalServerError},
+		{"blank session id", rs, "", "player1", http.StatusUnauthorized},
+		{"another player", rs, sID, "player2", http.StatusForbidden},
+		{"unknown player", rs, "session2", "player2", http.StatusNotFound},
+		{"valid player", rs, sID, "player1", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/referral/code/"+test.playerID, nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			referralServer := test.server
+			testsetup.WithSession(referralServer, sessions, referralServer.HandleReferralCodeRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotReferral := &data.ReferralData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotReferral)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotReferral.PlayerID != test.playerID || gotReferral.Code == "" {
+					t.Errorf("handler gave incorrect results, want a code for player: %v, got: %v", test.playerID, gotReferral)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleClaimRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	rs := NewServer(as, dataServer, profileServer)
+
+	for i := range constants.MaxReferralClaimsPerIPPerDay + 3 {
+		_, err = testsetup.SetupTestProfile(fmt.Sprintf("player%v", i+1), sID, profileServer.HandleNewPlayerRequest)
+		if err != nil {
+			t.Fatal("profile setup error: " + err.Error())
+		}
+	}
+
+	// player2 has unlocked a level already
+	_, err = profileServer.UpdatePlayerData(playerctx.WithPlayerID(context.Background(), "player2"), 0, config.Config.DefaultLevel+1)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session of each test player is their own (session1 of player1 and so on)
+	sessions := testsetup.Sessions{}
+	for i := range constants.MaxReferralClaimsPerIPPerDay + 3 {
+		sessions[fmt.Sprintf("session%v", i+1)] = fmt.Sprintf("player%v", i+1)
+	}
+	sessions["session99"] = "player99"
+
+	referrer, err := dataServer.InitReferralCode(context.Background(), "player1")
+	if err != nil {
+		t.Fatal("referral setup error: " + err.Error())
+	}
+
+	tests := []struct {
+		name         string
+		server       *Server
+		sessionID    string
+		requestBody  *ClaimRequestBody
+		remoteAddr   string
+		wantStatus   int
+		wantReferrer string
+	}{
+		{"nil server", nil, "", nil, "10.0.0.1:1000", http.StatusInternalServerError, ""},
+		{"blank session id", rs, "", nil, "10.0.0.1:1000", http.StatusUnauthorized, ""},
+		{"unknown player", rs, "session99", &ClaimRequestBody{PlayerID: "player99", Code: referrer.Code}, "10.0.0.1:1000", http.StatusNotFound, ""},
+		{"not a new player", rs, "session2", &ClaimRequestBody{PlayerID: "player2", Code: referrer.Code}, "10.0.0.1:1000", http.StatusForbidden, ""},
+		{"unknown code", rs, "session3", &ClaimRequestBody{PlayerID: "player3", Code: "ABCD2345"}, "10.0.0.1:1000", http.StatusNotFound, ""},
+		{"own code", rs, "session1", &ClaimRequestBody{PlayerID: "player1", Code: referrer.Code}, "10.0.0.1:1000", http.StatusForbidden, ""},
+		{"another player", rs, "session4", &ClaimRequestBody{PlayerID: "player3", Code: referrer.Code}, "10.0.0.1:1000", http.StatusForbidden, ""},
+		{"valid claim", rs, "session3", &ClaimRequestBody{PlayerID: "player3", Code: referrer.Code}, "10.0.0.1:1000", http.StatusOK, "player1"},
+		{"repeat claim", rs, "session3", &ClaimRequestBody{PlayerID: "player3", Code: referrer.Code}, "10.0.0.2:1000", http.StatusConflict, ""},
+		{"second claim from the ip", rs, "session4", &ClaimRequestBody{PlayerID: "player4", Code: referrer.Code}, "10.0.0.1:2000", http.StatusOK, "player1"},
+		{"third claim from the ip", rs, "session5", &ClaimRequestBody{PlayerID: "player5", Code: referrer.Code}, "10.0.0.1:3000", http.StatusOK, "player1"},
+		{"ip limit reached", rs, "session6", &ClaimRequestBody{PlayerID: "player6", Code: referrer.Code}, "10.0.0.1:4000", http.StatusTooManyRequests, ""},
+		{"other ip", rs, "session6", &ClaimRequestBody{PlayerID: "player6", Code: referrer.Code}, "10.0.0.2:1000", http.StatusOK, "player1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err2 := json.NewEncoder(buf).Encode(test.requestBody)
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/referral/claim", buf)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			newReq.RemoteAddr = test.remoteAddr
+			respRec := httptest.NewRecorder()
+
+			referralServer := test.server
+			testsetup.WithSession(referralServer, sessions, referralServer.HandleClaimRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotReferral := &data.ReferralData{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotReferral)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotReferral.ReferrerID != test.wantReferrer {
+					t.Errorf("handler gave incorrect referrer, want: %v, got: %v", test.wantReferrer, gotReferral.ReferrerID)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_CompleteReferral(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	rs := NewServer(as, dataServer, profileServer)
+
+	for _, playerID := range []string{"player1", "player2", "player3"} {
+		_, err = testsetup.SetupTestProfile(playerID, sID, profileServer.HandleNewPlayerRequest)
+		if err != nil {
+			t.Fatal("profile setup error: " + err.Error())
+		}
+	}
+
+	referrer, err := dataServer.InitReferralCode(context.Background(), "player1")
+	if err != nil {
+		t.Fatal("referral setup error: " + err.Error())
+	}
+
+	_, err = rs.Claim(context.Background(), "player2", referrer.Code, "10.0.0.1")
+	if err != nil {
+		t.Fatal("referral setup error: " + err.Error())
+	}
+
+	rewards := config.Config.Referral
+
+	tests := []struct {
+		name      string
+		server    *Server
+		playerID  string
+		wantErr   bool
+		wantCoins map[string]int64
+	}{
+		{"nil server", nil, "player2", true, nil},
+		{"not referred", rs, "player3", false, map[string]int64{"player1": 0, "player2": 0, "player3": 0}},
+		{"first win", rs, "player2", false, map[string]int64{"player1": rewards.ReferrerCoinReward, "player2": rewards.RefereeCoinReward, "player3": 0}},
+		{"second win", rs, "player2", false, map[string]int64{"player1": rewards.ReferrerCoinReward, "player2": rewards.RefereeCoinReward, "player3": 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			referralServer := test.server
+			err = referralServer.CompleteReferral(context.Background(), test.playerID)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("CompleteReferral() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			for playerID, wantReward := range test.wantCoins {
+				gotCoins := int64(0)
+				wallet, walletErr := dataServer.ReadWallet(context.Background(), playerID)
+				if walletErr == nil {
+					gotCoins = wallet.Coins - config.Config.DefaultCoins
+				}
+
+				if gotCoins != wantReward {
+					t.Errorf("CompleteReferral() gave incorrect coins to player %v, want: %v, got: %v", playerID, wantReward, gotCoins)
+				}
+			}
+		})
+	}
+}
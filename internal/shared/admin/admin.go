@@ -0,0 +1,36 @@
+// Package admin contains the validation used by the admin endpoints of the different services
+package admin
+
+import (
+	"crypto/subtle"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+var adminDisabledError = fmt.Errorf("admin requests are disabled, no admin token has been set")
+var missingAdminTokenError = fmt.Errorf("no admin token header in the request")
+var invalidAdminTokenError = fmt.Errorf("invalid admin token in request")
+
+// ValidateRequest checks that the Admin-Token header of the request matches the admin token
+// set in the environment (see constants.AdminTokenEnvVar)
+func ValidateRequest(req *http.Request) error {
+
+	adminToken := os.Getenv(constants.AdminTokenEnvVar)
+	if adminToken == "" {
+		return adminDisabledError
+	}
+
+	tokenHeader := req.Header["Admin-Token"]
+	if tokenHeader == nil {
+		return missingAdminTokenError
+	}
+
+	// constant time comparison, so the token cannot be guessed based on response times
+	if subtle.ConstantTimeCompare([]byte(tokenHeader[0]), []byte(adminToken)) != 1 {
+		return invalidAdminTokenError
+	}
+
+	return nil
+}
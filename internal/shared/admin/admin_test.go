@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"errors"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateRequest(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		adminToken  string
+		tokenHeader string
+		expError    error
+	}{
+		{"no admin token set", "", "token", adminDisabledError},
+		{"no token header", "token", "", missingAdminTokenError},
+		{"invalid token", "token", "other", invalidAdminTokenError},
+		{"valid token", "token", "token", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			t.Setenv(constants.AdminTokenEnvVar, test.adminToken)
+
+			newReq := httptest.NewRequest(http.MethodPost, "/test/", nil)
+			if test.tokenHeader != "" {
+				newReq.Header.Set("Admin-Token", test.tokenHeader)
+			}
+
+			gotErr := ValidateRequest(newReq)
+			if !errors.Is(gotErr, test.expError) {
+				t.Fatalf("ValidateRequest() gave incorrect results, want: %v, got: %v", test.expError, gotErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Package apierror holds the machine readable error codes of the public (client facing) endpoints, and writes their
+// error responses: a json body with the code, and the message of the code in the language of the request (from the
+// i18n translations). Clients can branch on the code, and show the message or localize it with the message catalog
+// (see NewCatalog), instead of parsing error strings, which also keeps the internals of the backend out of the responses
+package apierror
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/i18n"
+	"net/http"
+	"strings"
+)
+
+// Code is a machine readable error code, it never changes once released (unlike the message)
+type Code string
+
+// the generic codes, used when an error has no specific code
+const (
+	CodeInvalidRequest  Code = "invalid-request"
+	CodeInvalidSession  Code = "invalid-session"
+	CodeForbidden       Code = "forbidden"
+	CodeNotFound        Code = "not-found"
+	CodeConflict        Code = "conflict"
+	CodeTooManyRequests Code = "too-many-requests"
+	CodeRequestTooLarge Code = "request-too-large"
+	CodeInternalError   Code = "internal-error"
+	CodeUnavailable     Code = "unavailable"
+)
+
+// the specific codes of the auth service
+const (
+	CodeBanned               Code = "banned"
+	CodeForceUpdate          Code = "force-update"
+	CodeUsernameTaken        Code = "username-taken"
+	CodeInvalidCredentials   Code = "invalid-credentials"
+	CodeTwoFactorRequired    Code = "two-factor-required"
+	CodeInvalidTwoFactorCode Code = "invalid-two-factor-code"
+	CodeInvalidSocialLogin   Code = "invalid-social-login"
+	CodeChallengeRequired    Code = "challenge-required"
+	CodeInvalidChallenge     Code = "invalid-challenge"
+)
+
+// the specific codes of the gameplay service
+const (
+	CodeInsufficientEnergy Code = "insufficient-energy"
+	CodeFTUEIncomplete     Code = "ftue-incomplete"
+	CodePrestigeNotAllowed Code = "prestige-not-allowed"
+	CodeLevelCooldown      Code = "level-cooldown"
+	CodeDailyAttemptLimit  Code = "daily-attempt-limit"
+	CodeActionThrottled    Code = "action-throttled"
+	CodeClientTimeDrift    Code = "client-time-drift"
+)
+
+// the specific codes of the shop, promo, referral, guilds and stats services
+const (
+	CodeInsufficientCoins        Code = "insufficient-coins"
+	CodeItemAlreadyOwned         Code = "item-already-owned"
+	CodePromoCodeNotFound        Code = "promo-code-not-found"
+	CodePromoCodeUnavailable     Code = "promo-code-unavailable"
+	CodePromoCodeAlreadyRedeemed Code = "promo-code-already-redeemed"
+	CodeReferralCodeNotFound     Code = "referral-code-not-found"
+	CodeReferralAlreadyClaimed   Code = "referral-already-claimed"
+	CodeSelfReferral             Code = "self-referral"
+	CodeReferralLimit            Code = "referral-limit"
+	CodeNotNewPlayer             Code = "not-new-player"
+	CodeInvalidGuildName         Code = "invalid-guild-name"
+	CodeGuildNameTaken           Code = "guild-name-taken"
+	CodeGuildNotFound            Code = "guild-not-found"
+	CodeGuildFull                Code = "guild-full"
+	CodeAlreadyInGuild           Code = "already-in-guild"
+	CodeNotInGuild               Code = "not-in-guild"
+	CodeMilestoneNotFound        Code = "milestone-not-found"
+	CodeMilestoneNotReached      Code = "milestone-not-reached"
+	CodeMilestoneAlreadyClaimed  Code = "milestone-already-claimed"
+)
+
+// codes holds every code, in the order of the catalog
+var codes = []Code{
+	CodeInvalidRequest, CodeInvalidSession, CodeForbidden, CodeNotFound, CodeConflict, CodeTooManyRequests,
+	CodeRequestTooLarge, CodeInternalError, CodeUnavailable,
+	CodeBanned, CodeForceUpdate, CodeUsernameTaken, CodeInvalidCredentials, CodeTwoFactorRequired,
+	CodeInvalidTwoFactorCode, CodeInvalidSocialLogin, CodeChallengeRequired, CodeInvalidChallenge,
+	CodeInsufficientEnergy, CodeFTUEIncomplete, CodePrestigeNotAllowed, CodeLevelCooldown, CodeDailyAttemptLimit,
+	CodeActionThrottled, CodeClientTimeDrift,
+	CodeInsufficientCoins, CodeItemAlreadyOwned, CodePromoCodeNotFound, CodePromoCodeUnavailable,
+	CodePromoCodeAlreadyRedeemed, CodeReferralCodeNotFound, CodeReferralAlreadyClaimed, CodeSelfReferral,
+	CodeReferralLimit, CodeNotNewPlayer, CodeInvalidGuildName, CodeGuildNameTaken, CodeGuildNotFound, CodeGuildFull,
+	CodeAlreadyInGuild, CodeNotInGuild, CodeMilestoneNotFound, CodeMilestoneNotReached, CodeMilestoneAlreadyClaimed,
+}
+
+// Codes returns every error code
+func Codes() []Code {
+	return append([]Code{}, codes...)
+}
+
+// MessageKey returns the translation key of the message of the code, the code in camel case after "error."
+// (like error.insufficientEnergy for insufficient-energy)
+func (code Code) MessageKey() string {
+
+	words := strings.Split(string(code), "-")
+	for i := 1; i < len(words); i++ {
+		words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+	}
+
+	return "error." + strings.Join(words, "")
+}
+
+// Response is the body of the error responses of the public endpoints
+type Response struct {
+	Code  Code   `json:"code"`
+	Error string `json:"error"`
+}
+
+// Message returns the message of the given code in the language of the given request, in the same "error: ..." form
+// as the rest of the error responses. The replacements are pairs of placeholders (like {reason}) and their values
+func Message(r *http.Request, code Code, replacements ...string) string {
+	return i18n.Error(r, code.MessageKey(), replacements...)
+}
+
+// Write responds to the given request with the given status, and a Response with the given code and its message
+// (the details of the error should be logged by the caller instead, since they are not meant for the client)
+func Write(w http.ResponseWriter, r *http.Request, status int, code Code, replacements ...string) {
+
+	// like http.Error, a content length set for the intended response no longer holds
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(&Response{Code: code, Error: Message(r, code, replacements...)})
+}
+
+// Catalog holds the messages of every code in a language, with their placeholders (like {resetTime}) left in,
+// so a client can show the message of any error response in its own language
+type Catalog struct {
+	Language string          `json:"language"`
+	Messages map[Code]string `json:"messages"`
+}
+
+// NewCatalog returns the message catalog in the given language (falling back to the default language)
+func NewCatalog(language string) *Catalog {
+
+	store := i18n.Current()
+
+	catalog := &Catalog{Language: language, Messages: make(map[Code]string, len(codes))}
+	for _, code := range codes {
+		catalog.Messages[code] = store.Text(language, code.MessageKey())
+	}
+
+	return catalog
+}
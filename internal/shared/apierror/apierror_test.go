@@ -0,0 +1,111 @@
+package apierror
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/i18n"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCode_MessageKey(t *testing.T) {
+
+	tests := []struct {
+		name string
+		code Code
+		want string
+	}{
+		{"one word", CodeBanned, "error.banned"},
+		{"two words", CodeGuildFull, "error.guildFull"},
+		{"several words", CodePromoCodeAlreadyRedeemed, "error.promoCodeAlreadyRedeemed"},
+		{"acronym", CodeFTUEIncomplete, "error.ftueIncomplete"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.code.MessageKey()
+			if got != test.want {
+				t.Errorf("MessageKey() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestCodes(t *testing.T) {
+
+	// every code needs a message in the default language (the other languages fall back to it)
+	seen := map[Code]bool{}
+	for _, code := range Codes() {
+		if seen[code] {
+			t.Errorf("code %v is listed more than once", code)
+		}
+		seen[code] = true
+
+		if _, ok := i18n.Current().Lookup(i18n.DefaultLanguage, code.MessageKey()); !ok {
+			t.Errorf("code %v has no message, want a translation for %v", code, code.MessageKey())
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		code           Code
+		replacements   []string
+		wantError      string
+	}{
+		{"default language", "", CodeInsufficientEnergy, nil, "error: not enough energy to enter this level"},
+		{"spanish", "es-MX", CodeGuildFull, nil, "error: este gremio está lleno"},
+		{"replacements", "", CodeForceUpdate, []string{"{minVersion}", "1.2.0"}, "error: this version of the game is no longer supported, please update to version 1.2.0 or later"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Language", test.acceptLanguage)
+			respRec := httptest.NewRecorder()
+
+			Write(respRec, req, http.StatusConflict, test.code, test.replacements...)
+
+			if respRec.Code != http.StatusConflict || respRec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("Write() gave incorrect results, got status: %v, content type: %v", respRec.Code, respRec.Header().Get("Content-Type"))
+			}
+
+			got := &Response{}
+			err := json.NewDecoder(respRec.Body).Decode(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Code != test.code || got.Error != test.wantError {
+				t.Errorf("Write() gave incorrect results, want: %v %q, got: %v %q", test.code, test.wantError, got.Code, got.Error)
+			}
+		})
+	}
+}
+
+func TestNewCatalog(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		language    string
+		code        Code
+		wantMessage string
+	}{
+		{"default language", "en", CodeLevelCooldown, "you can enter this level again at {resetTime}"},
+		{"spanish", "es", CodeNotFound, "lo que buscas no existe"},
+		{"unsupported language", "fr", CodeInternalError, "something went wrong, please try again"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			catalog := NewCatalog(test.language)
+			if len(catalog.Messages) != len(Codes()) || catalog.Messages[test.code] != test.wantMessage {
+				t.Errorf("NewCatalog() gave incorrect results, want %v messages, and %v: %q, got: %v", len(Codes()), test.code, test.wantMessage, catalog.Messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Package audit records sensitive operations (logins, logouts, admin actions, bans, large energy grants, promo codes)
+// in the append-only audit log kept by the data service, and lets admins query that log
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// the actions recorded in the audit log
+const (
+	ActionLogin            = "login"
+	ActionLogout           = "logout"
+	ActionSessionExpire    = "session-expire" // a session swept after being idle for too long (rather than logged out)
+	ActionSignOut          = "sign-out"       // a player signing out one of their sessions remotely
+	ActionBan              = "ban"
+	ActionUnban            = "unban"
+	ActionEnergyGrant      = "energy-grant"
+	ActionEnergyRefund     = "energy-refund" // the energy of a level attempt which never got a result given back
+	ActionStatsRepair      = "stats-repair"
+	ActionStatsReset       = "stats-reset"
+	ActionPlayerSet        = "player-set" // an admin overwriting the level and energy of a player
+	ActionFTUEReset        = "ftue-reset"
+	ActionPromoCreate      = "promo-create"
+	ActionPromoRedeem      = "promo-redeem"
+	ActionTwoFactorEnable  = "2fa-enable"
+	ActionTwoFactorDisable = "2fa-disable"
+	ActionTwoFactorLockout = "2fa-lockout"  // the two factor verification of a player locked after too many invalid codes
+	ActionAccountLink      = "account-link" // a player linking an identity provider (like Google) account
+	ActionReferralClaim    = "referral-claim"
+	ActionReferralReward   = "referral-reward"
+	ActionMilestoneClaim   = "milestone-claim"
+	ActionAnnotationAdd    = "annotation-add" // customer service attaching a note (or a flag) to a player
+	ActionAnnotationRemove = "annotation-remove"
+	ActionAccountMerge     = "account-merge" // a duplicate (secondary) player merged into a primary one, which it redirects to
+)
+
+// the actors used for operations not performed by a player
+const (
+	ActorAdmin  = "admin"
+	ActorSystem = "system"
+)
+
+var recorderNilError = fmt.Errorf("provided audit recorder pointer is nil")
+
+// Recorder appends entries to the audit log through a data client
+type Recorder struct {
+	store  data.DataClient
+	logger *log.Logger
+}
+
+// NewRecorder returns an initialized pointer to an audit recorder which writes to the given store,
+// and reports errors on the logger of the service using it
+func NewRecorder(store data.DataClient, logger *log.Logger) *Recorder {
+	return &Recorder{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Record appends an entry for the given action to the audit log, the payload is stored as json.
+// Recording is best effort: errors are logged, but never fail the operation being audited
+func (rec *Recorder) Record(ctx context.Context, actor string, action string, playerID string, payload any) {
+
+	if rec == nil || rec.store == nil {
+		return
+	}
+
+	entry := &data.AuditEntry{
+		Actor:    actor,
+		Action:   action,
+		PlayerID: playerID,
+	}
+
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			rec.logger.Printf("audit error: could not encode payload for action %v: %v", action, err)
+			return
+		}
+		entry.Payload = encoded
+	}
+
+	err := rec.store.AppendAuditEntry(ctx, entry)
+	if err != nil {
+		rec.logger.Printf("audit error: could not record action %v for player id %v: %v", action, playerID, err)
+	}
+}
+
+// HandleQueryRequest responds with the audit log entries matching the query parameters
+// (playerID, action, afterID, limit) of the request (admin only)
+func (rec *Recorder) HandleQueryRequest(w http.ResponseWriter, r *http.Request) {
+
+	if rec == nil {
+		http.Error(w, recorderNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		rec.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	query, err := data.ParseAuditQuery(r.URL.Query())
+	if err != nil {
+		errMsg := "error: could not parse audit query: " + err.Error()
+		rec.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	auditPage, err := rec.store.ReadAuditEntries(r.Context(), query)
+	if err != nil {
+		errMsg := "audit query error: " + err.Error()
+		rec.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(auditPage)
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		rec.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRecorder_Record(t *testing.T) {
+
+	ds := data.NewServer()
+	rec := NewRecorder(ds, log.New(os.Stdout, "audit test: ", log.Lmsgprefix))
+
+	rec.Record(context.Background(), ActorAdmin, ActionBan, "player1", map[string]string{"reason": "cheating"})
+	rec.Record(context.Background(), "player2", ActionLogout, "player2", nil)
+
+	// a nil recorder should not panic
+	var nilRec *Recorder
+	nilRec.Record(context.Background(), ActorSystem, ActionEnergyGrant, "player1", nil)
+
+	auditPage, err := ds.ReadAuditEntries(context.Background(), &data.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := auditPage.Entries
+
+	if len(entries) != 2 {
+		t.Fatalf("incorrect number of audit entries, want: %v, got: %v", 2, len(entries))
+	}
+
+	want := &data.AuditEntry{ID: 1, Time: entries[0].Time, Actor: ActorAdmin, Action: ActionBan, PlayerID: "player1", Payload: json.RawMessage(`{"reason":"cheating"}`)}
+	if !reflect.DeepEqual(&entries[0], want) {
+		t.Errorf("incorrect audit entry, want: %v, got: %v", want, entries[0])
+	}
+
+	if entries[1].Payload != nil {
+		t.Errorf("expected an empty payload, got: %s", entries[1].Payload)
+	}
+}
+
+func TestRecorder_HandleQueryRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ds := data.NewServer()
+	rec := NewRecorder(ds, log.New(os.Stdout, "audit test: ", log.Lmsgprefix))
+	rec.Record(context.Background(), "player1", ActionLogin, "player1", nil)
+	rec.Record(context.Background(), ActorAdmin, ActionBan, "player2", nil)
+	rec.Record(context.Background(), "player1", ActionLogout, "player1", nil)
+
+	tests := []struct {
+		name       string
+		recorder   *Recorder
+		adminToken string
+		query      string
+		wantStatus int
+		wantLen    int
+	}{
+		{"nil recorder", nil, "", "", http.StatusInternalServerError, 0},
+		{"invalid admin token", rec, "testToken", "", http.StatusUnauthorized, 0},
+		{"invalid limit", rec, "adminToken", "limit=x", http.StatusBadRequest, 0},
+		{"all entries", rec, "adminToken", "", http.StatusOK, 3},
+		{"by player", rec, "adminToken", "playerID=player1", http.StatusOK, 2},
+		{"by action", rec, "adminToken", "action=ban", http.StatusOK, 1},
+		{"first page", rec, "adminToken", "limit=2", http.StatusOK, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/auth/admin/audit?"+test.query, nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			test.recorder.HandleQueryRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotPage := &data.AuditPage{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotPage)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if len(gotPage.Entries) != test.wantLen {
+					t.Errorf("handler gave incorrect number of entries, want: %v, got: %v", test.wantLen, len(gotPage.Entries))
+				}
+			}
+		})
+	}
+}
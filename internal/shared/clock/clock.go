@@ -0,0 +1,171 @@
+// Package clock provides the time source of the servers: the system clock (the default), a frozen clock which only
+// moves when told to (for deterministic tests), and an offset clock which runs ahead of (or behind) another clock by
+// an offset admins can change while running (for QA of timed events, see Offset.HandleOffsetRequest)
+package clock
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock implementor can tell the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock reading the system time
+type systemClock struct{}
+
+// Now implements Clock
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// System is the Clock reading the system time, used by the servers unless they are given another one
+var System Clock = systemClock{}
+
+// Frozen is a Clock which stays at the time it is set to till it is set again (or advanced), safe for concurrent use
+type Frozen struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+// NewFrozen returns an initialized pointer to a clock frozen at the given time
+func NewFrozen(now time.Time) *Frozen {
+	return &Frozen{now: now}
+}
+
+// Now implements Clock
+func (fc *Frozen) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.now
+}
+
+// Set moves the clock to the given time
+func (fc *Frozen) Set(now time.Time) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.now = now
+}
+
+// Advance moves the clock forward by the given duration (back, if it is negative)
+func (fc *Frozen) Advance(duration time.Duration) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.now = fc.now.Add(duration)
+}
+
+var offsetDisabledError = fmt.Errorf("the time offset mode is not enabled")
+
+// OffsetModeFromEnv returns whether the time offset mode environment variable (see constants.TimeOffsetEnvVar)
+// is set to true, the servers read the system time as it is if it is not set
+func OffsetModeFromEnv() (bool, error) {
+
+	offsetEnv := os.Getenv(constants.TimeOffsetEnvVar)
+	if offsetEnv == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(offsetEnv)
+	if err != nil {
+		return false, fmt.Errorf("%v should be true or false, got: %v", constants.TimeOffsetEnvVar, offsetEnv)
+	}
+	return enabled, nil
+}
+
+// Offset is a Clock which tells the time of its base clock moved by an offset (zero at first), safe for concurrent use
+type Offset struct {
+	base          Clock
+	offsetSeconds atomic.Int64
+	logger        *log.Logger
+}
+
+// NewOffset returns an initialized pointer to an offset clock on top of the given base clock,
+// which reports offset changes on the logger of the service using it
+func NewOffset(base Clock, logger *log.Logger) *Offset {
+	return &Offset{
+		base:   base,
+		logger: logger,
+	}
+}
+
+// Now implements Clock
+func (oc *Offset) Now() time.Time {
+	return oc.base.Now().Add(oc.Offset())
+}
+
+// Offset returns the current offset of the clock
+func (oc *Offset) Offset() time.Duration {
+	return time.Duration(oc.offsetSeconds.Load()) * time.Second
+}
+
+// SetOffset changes the offset of the clock to the given duration (truncated to whole seconds)
+func (oc *Offset) SetOffset(offset time.Duration) {
+	oc.offsetSeconds.Store(int64(offset / time.Second))
+}
+
+// OffsetBody is used as the request body for the admin request to change the time offset,
+// and as the response to the time offset requests (along with the resulting time)
+type OffsetBody struct {
+	OffsetSeconds int64 `json:"offsetSeconds"`
+	Now           int64 `json:"now,omitempty"`
+}
+
+// HandleOffsetRequest responds with the offset of the clock, and the time it tells (admin only),
+// after changing the offset to the one in the request body for PUT requests. A nil offset clock (a server not in the
+// time offset mode) responds with 503
+func (oc *Offset) HandleOffsetRequest(w http.ResponseWriter, r *http.Request) {
+
+	if oc == nil {
+		http.Error(w, offsetDisabledError.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		oc.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+
+		// decode the request body, which should be an OffsetBody struct
+		decodedReq := &OffsetBody{}
+		err = json.NewDecoder(r.Body).Decode(decodedReq)
+		if err != nil {
+			errMsg := "error: could not decode request body: " + err.Error()
+			oc.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+
+		oc.SetOffset(time.Duration(decodedReq.OffsetSeconds) * time.Second)
+		oc.logger.Printf("the time offset is now %v seconds", decodedReq.OffsetSeconds)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&OffsetBody{
+		OffsetSeconds: int64(oc.Offset() / time.Second),
+		Now:           oc.Now().UTC().Unix(),
+	})
+	if err != nil {
+		errMsg := "error: could not create response: " + err.Error()
+		oc.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
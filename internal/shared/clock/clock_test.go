@@ -0,0 +1,88 @@
+package clock
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrozen(t *testing.T) {
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	fc := NewFrozen(start)
+
+	if !fc.Now().Equal(start) {
+		t.Fatalf("frozen clock gave incorrect results, want: %v, got: %v", start, fc.Now())
+	}
+
+	fc.Advance(90 * time.Second)
+	if want := start.Add(90 * time.Second); !fc.Now().Equal(want) {
+		t.Errorf("frozen clock gave incorrect results after advancing, want: %v, got: %v", want, fc.Now())
+	}
+
+	fc.Set(start)
+	if !fc.Now().Equal(start) {
+		t.Errorf("frozen clock gave incorrect results after being set, want: %v, got: %v", start, fc.Now())
+	}
+}
+
+func TestOffset_HandleOffsetRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	oc := NewOffset(NewFrozen(start), log.New(os.Stdout, "clock test: ", log.Ltime|log.LUTC|log.Lmsgprefix))
+
+	tests := []struct {
+		name       string
+		clock      *Offset
+		method     string
+		adminToken string
+		body       string
+		wantStatus int
+		wantBody   *OffsetBody
+	}{
+		{"not enabled", nil, http.MethodGet, "adminToken", "", http.StatusServiceUnavailable, nil},
+		{"no admin token", oc, http.MethodGet, "", "", http.StatusUnauthorized, nil},
+		{"read the offset", oc, http.MethodGet, "adminToken", "", http.StatusOK, &OffsetBody{OffsetSeconds: 0, Now: start.Unix()}},
+		{"invalid body", oc, http.MethodPut, "adminToken", "{", http.StatusBadRequest, nil},
+		{"a day ahead", oc, http.MethodPut, "adminToken", `{"offsetSeconds":86400}`, http.StatusOK, &OffsetBody{OffsetSeconds: 86400, Now: start.Unix() + 86400}},
+		{"read the new offset", oc, http.MethodGet, "adminToken", "", http.StatusOK, &OffsetBody{OffsetSeconds: 86400, Now: start.Unix() + 86400}},
+		{"an hour behind", oc, http.MethodPut, "adminToken", `{"offsetSeconds":-3600}`, http.StatusOK, &OffsetBody{OffsetSeconds: -3600, Now: start.Unix() - 3600}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(test.method, "/profile/admin/time-offset", strings.NewReader(test.body))
+			if test.adminToken != "" {
+				newReq.Header.Set("Admin-Token", test.adminToken)
+			}
+			respRec := httptest.NewRecorder()
+
+			test.clock.HandleOffsetRequest(respRec, newReq)
+
+			if respRec.Code != test.wantStatus {
+				t.Fatalf("handler gave incorrect status, want: %v, got: %v", test.wantStatus, respRec.Code)
+			}
+			if test.wantBody == nil {
+				return
+			}
+
+			gotBody := &OffsetBody{}
+			err := json.NewDecoder(respRec.Body).Decode(gotBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *gotBody != *test.wantBody {
+				t.Errorf("handler gave incorrect results, want: %+v, got: %+v", test.wantBody, gotBody)
+			}
+		})
+	}
+}
@@ -10,5 +10,307 @@ const ConfigServerPort = "40003"
 const ProfileServerPort = "40004"
 const StatsServerPort = "40005"
 const GameplayServerPort = "40006"
+const ShopServerPort = "40007"
+const PromoServerPort = "40008"
+const MatchServerPort = "40009"
+const NotificationsServerPort = "40010"
+const ReferralServerPort = "40011"
+const GuildsServerPort = "40012"
+const WebhooksServerPort = "40013"
 
 const InternalRequestDeadlineSeconds = 2
+
+// settings of the adaptive internal timeouts (see tracing.EnableAdaptiveTimeouts): the deadline of the internal requests
+// to a downstream is InternalTimeoutMultiplier times the InternalTimeoutPercentile latency of its latest
+// InternalLatencySamples requests, between the floor and the ceiling. Downstreams with fewer than
+// InternalTimeoutMinSamples recent requests get the fixed InternalRequestDeadlineSeconds
+const InternalTimeoutPercentile = 0.99
+const InternalTimeoutMultiplier = 3
+const InternalLatencySamples = 200
+const InternalTimeoutMinSamples = 20
+const InternalTimeoutFloorMillis = 500
+const InternalTimeoutCeilingMillis = 8000
+
+// settings of the connection pool shared by all the internal requests, the idle (keep-alive) connections
+// to each service are reused, instead of opening a new connection per request
+const InternalMaxIdleConns = 256
+const InternalMaxIdleConnsPerHost = 64
+const InternalIdleConnTimeoutSeconds = 90
+const InternalDialTimeoutSeconds = 1
+
+// request limits applied to the routes of all the servers (can be overridden per route)
+const DefaultRequestTimeoutSeconds = 5
+const DefaultMaxRequestBodyBytes = 16 * 1024 // 16 KB
+const ReadHeaderTimeoutSeconds = 5
+
+// ShutdownTimeoutSeconds is how long a server being shut down waits for its requests in flight to finish,
+// before closing the connections left open (like event streams)
+const ShutdownTimeoutSeconds = 10
+
+// the SLOs of the routes are tracked over a rolling window of SLOWindowMinutes, and a route only breaches its SLO
+// once it handled at least SLOMinRequests requests in the window (so a few slow requests on a quiet route do not count)
+const SLOWindowMinutes = 10
+const SLOMinRequests = 20
+
+// AccessLogSampleRate is the share of requests (from 0 to 1) the access log records by default,
+// requests which fail with a 5xx are always recorded
+const AccessLogSampleRate = 0.1
+
+// CORS related settings for the public endpoints (used by browser / WebGL builds of the client),
+// allowed origins is a comma separated list of origins, "*" allows any origin
+const CORSAllowedOrigins = "*"
+const CORSMaxAgeSeconds = 600
+
+// response compression used by all the servers (can be overridden per service),
+// the level is the gzip level (1 is the fastest, 9 the smallest), and smaller responses than the min bytes are not compressed
+const CompressionLevel = 1
+const CompressionMinBytes = 1024 // 1 KB
+
+// backpressure of the data service: at most DataWorkers requests are handled at the same time, and up to
+// DataQueueSize more wait (for at most DataQueueWaitMillis) for a worker to be free, the rest are rejected with a 429
+// telling the caller to retry after DataRetryAfterSeconds
+const DataWorkers = 64
+const DataQueueSize = 256
+const DataQueueWaitMillis = 500
+const DataRetryAfterSeconds = 1
+
+// the hot stores of the data service (players and player stats) are split into this many shards, each with its own lock
+const DataStoreShards = 32
+
+// AdminTokenEnvVar is the environment variable holding the token expected in the
+// Admin-Token header of admin requests (admin requests are rejected when it is not set)
+const AdminTokenEnvVar = "DICE_ADMIN_TOKEN"
+
+// AuditEnergyGrantThreshold is the smallest energy grant (positive energy delta) that is recorded in the audit log
+const AuditEnergyGrantThreshold = 20
+
+// EntryTokenSecretEnvVar is the environment variable holding the secret used to sign level entry tokens,
+// when it is not set, the gameplay server generates a random secret at startup
+const EntryTokenSecretEnvVar = "DICE_ENTRY_TOKEN_SECRET"
+
+// EntryTokenExpirySeconds is how long a level entry token can be used to submit a level result
+const EntryTokenExpirySeconds = 60 * 60 // 1 hour
+
+// ImprobableStreakProbability flags a player for review when they win levels in a row
+// with a combined probability (of winning all of them) lower than this
+const ImprobableStreakProbability = 1e-6
+
+// MaxResultsPerMinute flags a player for review when they submit more level results than this within a minute
+const MaxResultsPerMinute = 30
+
+// MaxClientTimeDriftSeconds is how far the client time sent with a level result can be from the server time,
+// results from clients whose clock drifted further are rejected (clients can sync with the server time request)
+const MaxClientTimeDriftSeconds = 60
+
+// ConfigSigningKeyEnvVar is the environment variable holding the (base64 encoded, 32 byte) Ed25519 seed used to sign
+// the game config, when it is not set, the config server generates a random key at startup
+const ConfigSigningKeyEnvVar = "DICE_CONFIG_SIGNING_KEY"
+
+// AsyncStatsWorkersEnvVar is the environment variable holding the number of workers for async stats updates,
+// when it is set, the gameplay server queues the stats updates of level results instead of waiting for the stats service
+const AsyncStatsWorkersEnvVar = "DICE_ASYNC_STATS_WORKERS"
+
+// OutboxDirEnvVar is the environment variable holding the directory of the gameplay service's outbox, when it is set,
+// stats updates which fail (like when the stats service is down) are kept there and replayed till they go through,
+// instead of failing the level result
+const OutboxDirEnvVar = "DICE_OUTBOX_DIR"
+
+// DynamicDifficultyEnvVar is the environment variable which turns on the dynamic difficulty in the gameplay service
+// (when set to true), levels are then adjusted for each player based on their recent win rate (see config.DifficultyConfig)
+const DynamicDifficultyEnvVar = "DICE_DYNAMIC_DIFFICULTY"
+
+// ActionThrottlesEnvVar is the environment variable which turns on the throttles of the gameplay actions of each player
+// (when set to true), with the limits in the throttles config (see config.ThrottleConfig)
+const ActionThrottlesEnvVar = "DICE_ACTION_THROTTLES"
+
+// FlagsRefreshSeconds is how long the services keep the feature flags read from the config service,
+// so turning a feature off takes effect everywhere within this time
+const FlagsRefreshSeconds = 10
+
+// RNGSeedEnvVar is the environment variable holding a seed for the server side random numbers (like match targets),
+// when it is set, a seeded (reproducible) generator is used instead of crypto/rand, which is only meant for tests and debugging
+const RNGSeedEnvVar = "DICE_RNG_SEED"
+
+// TimeOffsetEnvVar is the environment variable which turns on the time offset mode of the profile, auth and gameplay
+// services (when set to true), admins can then move their clock ahead or back to test timed events, which is only meant for QA
+const TimeOffsetEnvVar = "DICE_TIME_OFFSET_MODE"
+
+// LevelsDirEnvVar is the environment variable holding the directory of the level content (json) files,
+// when it is set, the levels are loaded from there instead of the default content, and the directory is
+// checked again every LevelContentReloadSeconds, so new levels can be added without restarting the services
+const LevelsDirEnvVar = "DICE_LEVELS_DIR"
+const LevelContentReloadSeconds = 30
+
+// TranslationsDirEnvVar is the environment variable holding the directory of the translation (json) files,
+// when it is set, the translations are loaded from there (at startup) instead of the default ones
+const TranslationsDirEnvVar = "DICE_TRANSLATIONS_DIR"
+
+// NamespaceEnvVar is the environment variable holding the namespace (like an environment or a game title id)
+// of the services, so several of them can share one data service deployment, each with their own players
+// (and all their other data). Services without a namespace use the default (blank) namespace
+const NamespaceEnvVar = "DICE_NAMESPACE"
+
+// ArchiveDirEnvVar is the environment variable holding the directory of the data service's cold store,
+// when it is set, players not updated for ArchiveInactiveDays are moved there from memory (and brought back on access)
+const ArchiveDirEnvVar = "DICE_ARCHIVE_DIR"
+const ArchiveInactiveDays = 30
+
+// BackupDirEnvVar is the environment variable holding the directory the data service writes its backups to,
+// when it is set, a backup of all the data is also taken every BackupIntervalMinutes
+// (after which only the latest BackupsKept backups are kept)
+const BackupDirEnvVar = "DICE_BACKUP_DIR"
+const BackupIntervalMinutes = 60
+const BackupsKept = 48
+
+// EventSourcingEnvVar is the environment variable which turns on event sourcing in the data service (when set to true),
+// every change to a player's data or stats is then also appended to an event stream of the player, with a snapshot
+// of the player's state after every PlayerEventSnapshotInterval events (so past states can be rebuilt quickly)
+const EventSourcingEnvVar = "DICE_EVENT_SOURCING"
+const PlayerEventSnapshotInterval = 50
+
+// RedisAddrEnvVar is the environment variable holding the address (host:port) of a redis server, when it is set,
+// the data service keeps the players and their stats there instead of in memory (so several replicas can share them)
+const RedisAddrEnvVar = "DICE_REDIS_ADDR"
+
+// PostgresDSNEnvVar is the environment variable holding the connection string of a postgres database, when it is set,
+// the data service keeps the players and their stats there instead of in memory (its schema is migrated on startup).
+// The database is opened with the database/sql driver registered as PostgresDriverName, the one of pgx (its stdlib
+// package is imported by the runners of the data service)
+const PostgresDSNEnvVar = "DICE_POSTGRES_DSN"
+const PostgresDriverName = "pgx"
+
+// JobLeasePeriods is how many periods of a singleton background job the lease of the instance running it lasts,
+// it is renewed on every run, so another instance only takes over the job if the leader misses this many runs
+const JobLeasePeriods = 2
+
+// EnergyReconcileSecondsEnvVar is the environment variable holding the interval (in seconds) of the profile service's
+// energy reconciler, when it is set, the stored energy of the players is brought up to date periodically, instead of
+// only when they are read. The players are read from the data service in batches of EnergyReconcileBatchEnvVar
+// (EnergyReconcileDefaultBatch if not set), and if EnergyReconcileActiveDaysEnvVar is set, only the players
+// updated within that many days are reconciled
+const EnergyReconcileSecondsEnvVar = "DICE_ENERGY_RECONCILE_SECONDS"
+const EnergyReconcileBatchEnvVar = "DICE_ENERGY_RECONCILE_BATCH"
+const EnergyReconcileActiveDaysEnvVar = "DICE_ENERGY_RECONCILE_ACTIVE_DAYS"
+const EnergyReconcileDefaultBatch = 100
+
+// StatsExportDirEnvVar is the environment variable holding the directory the stats service exports all the player
+// stats to (as csv files, for offline analysis), the export is only available when it is set. The stats are read
+// from the data service StatsExportChunkSize players at a time, and each chunk is written to its own file
+const StatsExportDirEnvVar = "DICE_STATS_EXPORT_DIR"
+const StatsExportChunkSize = 500
+
+// DataCacheSizeEnvVar is the environment variable holding the number of entries of the read cache in front of the data
+// service (used by the profile and stats services), when it is set, players and player stats are kept in an in-memory
+// LRU cache for DataCacheTTLSecondsEnvVar seconds (DataCacheDefaultTTLSeconds if not set). Writes through the cache
+// invalidate the entries, so only writes from other instances can be seen late (by at most the TTL)
+const DataCacheSizeEnvVar = "DICE_DATA_CACHE_SIZE"
+const DataCacheTTLSecondsEnvVar = "DICE_DATA_CACHE_TTL_SECONDS"
+const DataCacheDefaultTTLSeconds = 5
+
+// DataFollowersEnvVar is the environment variable holding the (comma separated) addresses of the data followers a
+// primary data service ships its writes of players and stats to, in batches of up to ReplicationBatchSize writes.
+// The latest ReplicationLogSize writes are kept, a follower further behind than that gets a full copy instead
+const DataFollowersEnvVar = "DICE_DATA_FOLLOWERS"
+const ReplicationBatchSize = 500
+const ReplicationLogSize = 10000
+const ReplicationRetrySeconds = 1
+
+// DataFollowerEnvVar is the environment variable which makes the data service a read only follower (when set to true),
+// which only takes the writes shipped from its primary
+const DataFollowerEnvVar = "DICE_DATA_FOLLOWER"
+
+// DataReplicaURLEnvVar is the environment variable holding the address of a data follower, when it is set, the read
+// heavy requests of the stats service (the rating leaderboard) go to the follower instead of the primary
+const DataReplicaURLEnvVar = "DICE_DATA_REPLICA_URL"
+
+// GoogleClientIDEnvVar and AppleClientIDEnvVar are the environment variables holding the client ids of the game
+// with Google and Apple, the id tokens used to sign in have to be meant for them. Sign in with a provider is only
+// enabled when its client id is set. The signing keys of the providers are fetched again every JWKSRefreshMinutes
+const GoogleClientIDEnvVar = "DICE_GOOGLE_CLIENT_ID"
+const AppleClientIDEnvVar = "DICE_APPLE_CLIENT_ID"
+const JWKSRefreshMinutes = 60
+
+// LDAPURLEnvVar is the environment variable holding the address of an LDAP directory (ldap://host:port or
+// ldaps://host:port), when it is set the usernames and passwords of the login request are checked with a bind to the
+// directory instead of the built-in ones, as the entry named by LDAPBindDNEnvVar (with a {username} placeholder)
+const LDAPURLEnvVar = "DICE_LDAP_URL"
+const LDAPBindDNEnvVar = "DICE_LDAP_BIND_DN"
+
+// AuthIDPIssuerEnvVar, AuthIDPJWKSURLEnvVar and AuthIDPClientIDEnvVar are the environment variables describing an
+// external identity provider, when they are set the login request also takes its id tokens (with the bearer scheme)
+const AuthIDPIssuerEnvVar = "DICE_AUTH_IDP_ISSUER"
+const AuthIDPJWKSURLEnvVar = "DICE_AUTH_IDP_JWKS_URL"
+const AuthIDPClientIDEnvVar = "DICE_AUTH_IDP_CLIENT_ID"
+
+// LoginQueueThresholdEnvVar is the environment variable holding the number of logins the auth service runs at once,
+// when it is set the logins after that are queued (with a 202 and a ticket to poll) until the running ones finish
+const LoginQueueThresholdEnvVar = "DICE_LOGIN_QUEUE_THRESHOLD"
+
+// RequestNoncesEnvVar is the environment variable which turns on request nonces in the auth service (when set to true),
+// the state changing (POST / PUT) requests it validates then have to carry a nonce in the RequestNonceHeader, issued
+// for their session by the nonce request and accepted only once, so a request captured from the network cannot be
+// replayed. A session holds up to MaxRequestNoncesPerSession unused nonces, which expire after RequestNonceExpirySeconds
+const RequestNoncesEnvVar = "DICE_REQUEST_NONCES"
+const RequestNonceHeader = "Request-Nonce"
+const MaxRequestNoncesPerSession = 20
+const RequestNonceExpirySeconds = 5 * 60 // 5 minutes
+
+// LoginChallengeEnvVar is the environment variable picking the verifier of the login challenge of the auth service:
+// "pow" (a proof of work with LoginChallengeDifficultyBits leading zero bits, issued by auth and valid for
+// LoginChallengeExpirySeconds), or "captcha" (a captcha token, checked with the siteverify api at CaptchaVerifyURLEnvVar,
+// with the secret in CaptchaSecretEnvVar, and the site key in CaptchaSiteKeyEnvVar sent to the clients). The logins
+// creating an account only have to pass the challenge while the login-challenge feature flag is on
+const LoginChallengeEnvVar = "DICE_LOGIN_CHALLENGE"
+const LoginChallengeDifficultyBits = 20
+const LoginChallengeExpirySeconds = 5 * 60 // 5 minutes
+const CaptchaVerifyURLEnvVar = "DICE_CAPTCHA_VERIFY_URL"
+const CaptchaSecretEnvVar = "DICE_CAPTCHA_SECRET"
+const CaptchaSiteKeyEnvVar = "DICE_CAPTCHA_SITE_KEY"
+
+// RequestMethodHeader carries the method of the request being validated, on the internal session validation request
+const RequestMethodHeader = "Request-Method"
+
+// SecondarySessionHeader carries a session of the duplicate (secondary) account on the request of a player to merge it
+// into the account of their session, proving they own both
+const SecondarySessionHeader = "Secondary-Session-Id"
+
+// ServiceSecretEnvVar is the environment variable holding the secret shared by the services, used to sign the
+// service tokens which internal requests have to carry. PreviousServiceSecretEnvVar can hold the secret being
+// rotated out, so tokens signed with it are still accepted while the services are restarted with the new one.
+// When no secret is set, the internal endpoints of the independently run services accept any request
+const ServiceSecretEnvVar = "DICE_SERVICE_SECRET"
+const PreviousServiceSecretEnvVar = "DICE_PREVIOUS_SERVICE_SECRET"
+
+// ServiceTokenExpirySeconds is how long a service token is accepted, a service issues itself a new token
+// once the current one has less than ServiceTokenRefreshSeconds left
+const ServiceTokenExpirySeconds = 5 * 60 // 5 minutes
+const ServiceTokenRefreshSeconds = 60
+
+// LevelDistributionCacheSeconds is how long the stats server reuses a computed level distribution
+const LevelDistributionCacheSeconds = 60
+
+// LeaderboardRefreshSecondsEnvVar is the environment variable holding the interval (in seconds) at which the stats
+// server recomputes its rating leaderboard cache, when it is set, the rating leaderboard is served from a snapshot
+// refreshed at that interval (and right after the rating changes which can move its top), instead of being read each time
+const LeaderboardRefreshSecondsEnvVar = "DICE_LEADERBOARD_REFRESH_SECONDS"
+
+// MaxReferralsPerPlayer is how many new players can claim the referral code of a single player
+const MaxReferralsPerPlayer = 20
+
+// MaxReferralClaimsPerIPPerDay is how many referral codes can be claimed from the same ip address in a (UTC) day
+const MaxReferralClaimsPerIPPerDay = 3
+
+// MaxGuildMembers is how many players a guild can have, and MaxGuildNameLength how many characters its name can have
+const MaxGuildMembers = 30
+const MaxGuildNameLength = 24
+
+// MaxAnnotationsPerPlayer is how many admin annotations a player can have, and MaxAnnotationLength how many characters
+// the text (or the reference) of an annotation can have
+const MaxAnnotationsPerPlayer = 100
+const MaxAnnotationLength = 1000
+
+// MaxSimulatedAttempts is how many attempts (over all the simulated players) a config simulation of the gameplay service can make
+const MaxSimulatedAttempts = 1_000_000
+
+// GuildLeaderboardCacheSeconds is how long the guilds server reuses a computed guild leaderboard
+const GuildLeaderboardCacheSeconds = 60
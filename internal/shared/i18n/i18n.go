@@ -0,0 +1,228 @@
+// Package i18n holds the translations of the player facing text (level names and descriptions, shop item names,
+// and error messages) keyed by language, and picks the language of a request from its Accept-Language header
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultLanguage is the language used when a request does not ask for a supported one,
+// it has to have every translation key, since the other languages fall back to it
+const DefaultLanguage = "en"
+
+// defaultTranslations holds the translations shipped with the backend,
+// which are used unless a translations directory is set in the environment
+//
+//go:embed translations/*.json
+var defaultTranslations embed.FS
+
+// Store holds the translated texts, keyed by language and then by translation key
+type Store struct {
+	texts map[string]map[string]string
+}
+
+// current is the store used by the package level functions
+var current atomic.Pointer[Store]
+
+func init() {
+
+	content, err := fs.Sub(defaultTranslations, "translations")
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := LoadStore(content)
+	if err != nil {
+		panic("invalid default translations: " + err.Error())
+	}
+	current.Store(store)
+}
+
+// LoadStore reads the translations from the json files at the root of the given file system, each file is named
+// after its language (like es.json) and holds an object of translation keys to texts. The default language has to
+// be there, and the other languages cannot have keys which the default language does not have
+func LoadStore(content fs.FS) (*Store, error) {
+
+	fileNames, err := fs.Glob(content, "*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Store{texts: map[string]map[string]string{}}
+	for _, fileName := range fileNames {
+
+		fileBytes, readErr := fs.ReadFile(content, fileName)
+		if readErr != nil {
+			return nil, fmt.Errorf("translations file %v: %v", fileName, readErr)
+		}
+
+		texts := map[string]string{}
+		readErr = json.Unmarshal(fileBytes, &texts)
+		if readErr != nil {
+			return nil, fmt.Errorf("translations file %v: %v", fileName, readErr)
+		}
+
+		language := strings.ToLower(strings.TrimSuffix(path.Base(fileName), ".json"))
+		store.texts[language] = texts
+	}
+
+	defaultTexts, ok := store.texts[DefaultLanguage]
+	if !ok {
+		return nil, fmt.Errorf("no translations found for the default language (%v)", DefaultLanguage)
+	}
+
+	for language, texts := range store.texts {
+		for key := range texts {
+			if _, ok := defaultTexts[key]; !ok {
+				return nil, fmt.Errorf("translations for %v: key %v is missing in the default language", language, key)
+			}
+		}
+	}
+
+	return store, nil
+}
+
+// EnableTranslationsFromEnv replaces the default translations with the ones in the directory set in the environment
+// (if it is set, see constants.TranslationsDirEnvVar)
+func EnableTranslationsFromEnv() error {
+
+	translationsDir := os.Getenv(constants.TranslationsDirEnvVar)
+	if translationsDir == "" {
+		return nil
+	}
+
+	store, err := LoadStore(os.DirFS(translationsDir))
+	if err != nil {
+		return fmt.Errorf("could not load the translations from %v: %v", translationsDir, err)
+	}
+
+	current.Store(store)
+	return nil
+}
+
+// Languages returns the supported languages, in alphabetical order
+func (s *Store) Languages() []string {
+
+	languages := make([]string, 0, len(s.texts))
+	for language := range s.texts {
+		languages = append(languages, language)
+	}
+	slices.Sort(languages)
+
+	return languages
+}
+
+// Lookup returns the text for the given key in the given language (falling back to the default language),
+// and whether there is one. The replacements are pairs of placeholders (like {reason}) and the values they are replaced with
+func (s *Store) Lookup(language string, key string, replacements ...string) (string, bool) {
+
+	text, ok := s.texts[language][key]
+	if !ok {
+		text, ok = s.texts[DefaultLanguage][key]
+	}
+	if !ok {
+		return "", false
+	}
+
+	if len(replacements) > 0 {
+		text = strings.NewReplacer(replacements...).Replace(text)
+	}
+	return text, true
+}
+
+// Text returns the text for the given key in the given language like Lookup, or the key itself if there is none
+func (s *Store) Text(language string, key string, replacements ...string) string {
+
+	text, ok := s.Lookup(language, key, replacements...)
+	if !ok {
+		return key
+	}
+	return text
+}
+
+// Negotiate returns the supported language which best matches the given Accept-Language header value
+// (like "es-MX,es;q=0.9,en;q=0.8"), a region specific language matches its base language (es-MX matches es),
+// and the default language is returned if nothing matches
+func (s *Store) Negotiate(acceptLanguage string) string {
+
+	type candidate struct {
+		language string
+		quality  float64
+	}
+
+	candidates := []candidate{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		language, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		language = strings.ToLower(strings.TrimSpace(language))
+		if language == "" {
+			continue
+		}
+
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if quality > 0 {
+			candidates = append(candidates, candidate{language, quality})
+		}
+	}
+
+	// highest quality first, keeping the order of the header for equal qualities
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	for _, c := range candidates {
+		if c.language == "*" {
+			return DefaultLanguage
+		}
+		if _, ok := s.texts[c.language]; ok {
+			return c.language
+		}
+		base, _, _ := strings.Cut(c.language, "-")
+		if _, ok := s.texts[base]; ok {
+			return base
+		}
+	}
+
+	return DefaultLanguage
+}
+
+// Current returns the translations in use
+func Current() *Store {
+	return current.Load()
+}
+
+// RequestLanguage returns the language the response to the given request should be in (see Store.Negotiate)
+func RequestLanguage(req *http.Request) string {
+	return Current().Negotiate(req.Header.Get("Accept-Language"))
+}
+
+// Error returns the error message for the given translation key, in the language of the given request,
+// in the same "error: ..." form as the rest of the error responses
+func Error(req *http.Request, key string, replacements ...string) string {
+	return "error: " + Current().Text(RequestLanguage(req), key, replacements...)
+}
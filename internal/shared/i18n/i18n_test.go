@@ -0,0 +1,129 @@
+package i18n
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadStore(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		content       fstest.MapFS
+		wantErr       bool
+		wantLanguages int
+	}{
+		{"no default language", fstest.MapFS{"es.json": {Data: []byte(`{"a":"b"}`)}}, true, 0},
+		{"malformed file", fstest.MapFS{"en.json": {Data: []byte(`{"a":`)}}, true, 0},
+		{"key missing in the default language", fstest.MapFS{"en.json": {Data: []byte(`{"a":"b"}`)}, "es.json": {Data: []byte(`{"c":"d"}`)}}, true, 0},
+		{"default language only", fstest.MapFS{"en.json": {Data: []byte(`{"a":"b"}`)}}, false, 1},
+		{"partial translation", fstest.MapFS{"en.json": {Data: []byte(`{"a":"b","c":"d"}`)}, "ES.json": {Data: []byte(`{"a":"e"}`)}}, false, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := LoadStore(test.content)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("LoadStore() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if err == nil && len(store.Languages()) != test.wantLanguages {
+				t.Errorf("LoadStore() gave incorrect results, want: %v languages, got: %v", test.wantLanguages, store.Languages())
+			}
+		})
+	}
+}
+
+func TestStore_Text(t *testing.T) {
+
+	store, err := LoadStore(fstest.MapFS{
+		"en.json": {Data: []byte(`{"greeting":"hello {name}","farewell":"bye"}`)},
+		"es.json": {Data: []byte(`{"greeting":"hola {name}"}`)},
+	})
+	if err != nil {
+		t.Fatal("store setup error: " + err.Error())
+	}
+
+	tests := []struct {
+		name         string
+		language     string
+		key          string
+		replacements []string
+		want         string
+	}{
+		{"translated", "es", "greeting", []string{"{name}", "ana"}, "hola ana"},
+		{"default language", "en", "greeting", []string{"{name}", "ana"}, "hello ana"},
+		{"missing translation", "es", "farewell", nil, "bye"},
+		{"unsupported language", "fr", "farewell", nil, "bye"},
+		{"missing key", "es", "unknown", nil, "unknown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := store.Text(test.language, test.key, test.replacements...)
+			if got != test.want {
+				t.Errorf("Text() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestStore_Negotiate(t *testing.T) {
+
+	store, err := LoadStore(fstest.MapFS{
+		"en.json":    {Data: []byte(`{"a":"b"}`)},
+		"es.json":    {Data: []byte(`{"a":"c"}`)},
+		"pt-br.json": {Data: []byte(`{"a":"d"}`)},
+	})
+	if err != nil {
+		t.Fatal("store setup error: " + err.Error())
+	}
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		want           string
+	}{
+		{"no header", "", "en"},
+		{"supported language", "es", "es"},
+		{"region of a supported language", "es-MX", "es"},
+		{"supported region", "pt-BR", "pt-br"},
+		{"unsupported language", "fr", "en"},
+		{"highest quality first", "fr;q=1, en;q=0.5, es;q=0.8", "es"},
+		{"header order for equal qualities", "es, en", "es"},
+		{"refused language", "es;q=0, fr", "en"},
+		{"any language", "fr, *", "en"},
+		{"malformed quality", "es;q=high, en", "en"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := store.Negotiate(test.acceptLanguage)
+			if got != test.want {
+				t.Errorf("Negotiate() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultTranslations(t *testing.T) {
+
+	store := Current()
+	defaultTexts := store.texts[DefaultLanguage]
+
+	// every language shipped with the backend should be complete
+	for _, language := range store.Languages() {
+		for key := range defaultTexts {
+			if _, ok := store.texts[language][key]; !ok {
+				t.Errorf("default translations for %v are missing the key %v", language, key)
+			}
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept-Language", "es-ES,es;q=0.9")
+	if got := Error(req, "error.itemAlreadyOwned"); got != "error: ya tienes este artículo" {
+		t.Errorf("Error() gave incorrect results, want: %v, got: %v", "error: ya tienes este artículo", got)
+	}
+}
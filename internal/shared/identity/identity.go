@@ -0,0 +1,240 @@
+// Package identity issues and checks the service tokens which authenticate internal (server to server) requests,
+// so the *-internal endpoints only accept requests from the other services of the backend
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceTokenHeader is the request header carrying the service token of an internal request
+const ServiceTokenHeader = "Service-Token"
+
+// minSecretLength is the minimum length (in bytes) of a service secret
+const minSecretLength = 16
+
+// Service Token Errors:
+var missingServiceTokenError = fmt.Errorf("no service token header in the request")
+var malformedServiceTokenError = fmt.Errorf("malformed service token")
+var invalidServiceTokenSignatureError = fmt.Errorf("invalid service token signature")
+var expiredServiceTokenError = fmt.Errorf("service token has expired")
+
+// ServiceTokenClaims are the details encoded (and signed) in a service token
+type ServiceTokenClaims struct {
+	Service   string `json:"service"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// identity holds the name and the secrets of the running service, and the service token it currently sends
+var identity = struct {
+	mutex          sync.Mutex
+	serviceName    string
+	secret         []byte
+	previousSecret []byte
+	token          string
+	tokenExpiresAt int64
+}{}
+
+// Init sets up the identity of the service with the given name, reading the service secrets from the environment
+// (see constants.ServiceSecretEnvVar). Without a secret, service tokens are neither sent nor checked
+func Init(serviceName string) error {
+
+	secret := os.Getenv(constants.ServiceSecretEnvVar)
+	previousSecret := os.Getenv(constants.PreviousServiceSecretEnvVar)
+
+	if secret != "" && len(secret) < minSecretLength {
+		return fmt.Errorf("%v should be at least %v bytes long", constants.ServiceSecretEnvVar, minSecretLength)
+	}
+
+	if previousSecret != "" && secret == "" {
+		return fmt.Errorf("%v is set without %v", constants.PreviousServiceSecretEnvVar, constants.ServiceSecretEnvVar)
+	}
+
+	setIdentity(serviceName, []byte(secret), []byte(previousSecret))
+	return nil
+}
+
+// InitInProcess sets up the identity like Init, but generates a random secret if none is set,
+// which is only useful when all the services run in the same process (and so share the secret)
+func InitInProcess(serviceName string) error {
+
+	err := Init(serviceName)
+	if err != nil || Enabled() {
+		return err
+	}
+
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret) // never returns an error
+
+	setIdentity(serviceName, secret, nil)
+	return nil
+}
+
+// setIdentity replaces the identity of the service, dropping the token issued with the old one
+func setIdentity(serviceName string, secret []byte, previousSecret []byte) {
+
+	identity.mutex.Lock()
+	defer identity.mutex.Unlock()
+
+	identity.serviceName = serviceName
+	identity.secret = secret
+	identity.previousSecret = previousSecret
+	identity.token = ""
+	identity.tokenExpiresAt = 0
+}
+
+// Enabled returns whether the service has a secret, and so sends and checks service tokens
+func Enabled() bool {
+
+	identity.mutex.Lock()
+	defer identity.mutex.Unlock()
+
+	return len(identity.secret) > 0
+}
+
+// Token returns the service token to send with internal requests, a new one is issued
+// when the current one is about to expire (see constants.ServiceTokenRefreshSeconds)
+func Token() (string, error) {
+
+	identity.mutex.Lock()
+	defer identity.mutex.Unlock()
+
+	if len(identity.secret) == 0 {
+		return "", fmt.Errorf("no service secret has been set")
+	}
+
+	unixNow := time.Now().UTC().Unix()
+	if identity.token != "" && identity.tokenExpiresAt-unixNow > constants.ServiceTokenRefreshSeconds {
+		return identity.token, nil
+	}
+
+	claims := &ServiceTokenClaims{
+		Service:   identity.serviceName,
+		ExpiresAt: unixNow + constants.ServiceTokenExpirySeconds,
+	}
+
+	token, err := issueToken(claims, identity.secret)
+	if err != nil {
+		return "", err
+	}
+
+	identity.token = token
+	identity.tokenExpiresAt = claims.ExpiresAt
+	return token, nil
+}
+
+// issueToken returns the service token for the given claims signed with the given secret,
+// the token is of the form base64(claims json).base64(hmac sha256 of the claims json)
+func issueToken(claims *ServiceTokenClaims, secret []byte) (string, error) {
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(sign(payload, secret)), nil
+}
+
+// Verify checks that the given service token was signed with the current (or the previous) service secret,
+// and has not expired. It returns the name of the service the token was issued to
+func Verify(token string) (string, error) {
+
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return "", malformedServiceTokenError
+	}
+
+	encoding := base64.RawURLEncoding
+	payload, err := encoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", malformedServiceTokenError
+	}
+
+	signature, err := encoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", malformedServiceTokenError
+	}
+
+	identity.mutex.Lock()
+	secret, previousSecret := identity.secret, identity.previousSecret
+	identity.mutex.Unlock()
+
+	validSignature := len(secret) > 0 && hmac.Equal(signature, sign(payload, secret))
+	if !validSignature && len(previousSecret) > 0 {
+		validSignature = hmac.Equal(signature, sign(payload, previousSecret))
+	}
+	if !validSignature {
+		return "", invalidServiceTokenSignatureError
+	}
+
+	claims := &ServiceTokenClaims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return "", malformedServiceTokenError
+	}
+
+	if time.Now().UTC().Unix() > claims.ExpiresAt {
+		return "", expiredServiceTokenError
+	}
+
+	return claims.Service, nil
+}
+
+// ValidateRequest checks the service token in the Service-Token header of the request,
+// any request is accepted when the service has no secret
+func ValidateRequest(req *http.Request) error {
+
+	if !Enabled() {
+		return nil
+	}
+
+	tokenHeader := req.Header[ServiceTokenHeader]
+	if tokenHeader == nil {
+		return missingServiceTokenError
+	}
+
+	_, err := Verify(tokenHeader[0])
+	return err
+}
+
+// sign returns the hmac sha256 of the given payload, keyed with the given secret
+func sign(payload []byte, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Transport is an http round tripper which adds the service token to every outgoing (internal) request
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if !Enabled() {
+		return t.Base.RoundTrip(req)
+	}
+
+	token, err := Token()
+	if err != nil {
+		return nil, err
+	}
+
+	// round trippers should not modify the original request
+	req = req.Clone(req.Context())
+	req.Header.Set(ServiceTokenHeader, token)
+
+	return t.Base.RoundTrip(req)
+}
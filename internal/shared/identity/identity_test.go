@@ -0,0 +1,211 @@
+package identity
+
+import (
+	"example.com/dice-game-backend/internal/shared/constants"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInit(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		secret         string
+		previousSecret string
+		wantErr        bool
+		wantEnabled    bool
+	}{
+		{"no secret", "", "", false, false},
+		{"short secret", "testSecret", "", true, false},
+		{"previous secret only", "", "testPreviousSecret1", true, false},
+		{"valid secret", "testServiceSecret1", "", false, true},
+		{"valid secrets", "testServiceSecret1", "testPreviousSecret1", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			t.Setenv(constants.ServiceSecretEnvVar, test.secret)
+			t.Setenv(constants.PreviousServiceSecretEnvVar, test.previousSecret)
+			setIdentity("", nil, nil)
+
+			err := Init("test")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Init() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			if Enabled() != test.wantEnabled {
+				t.Errorf("Init() gave incorrect results, want enabled: %v, got: %v", test.wantEnabled, Enabled())
+			}
+		})
+	}
+}
+
+func TestInitInProcess(t *testing.T) {
+
+	t.Setenv(constants.ServiceSecretEnvVar, "")
+	t.Setenv(constants.PreviousServiceSecretEnvVar, "")
+
+	err := InitInProcess("test")
+	if err != nil {
+		t.Fatal("init error: " + err.Error())
+	}
+
+	if !Enabled() {
+		t.Errorf("a random secret should have been generated")
+	}
+}
+
+func TestVerify(t *testing.T) {
+
+	t.Setenv(constants.ServiceSecretEnvVar, "testServiceSecret1")
+	t.Setenv(constants.PreviousServiceSecretEnvVar, "testPreviousSecret1")
+
+	err := Init("test")
+	if err != nil {
+		t.Fatal("init error: " + err.Error())
+	}
+
+	validToken, err := Token()
+	if err != nil {
+		t.Fatal("token error: " + err.Error())
+	}
+
+	unixNow := time.Now().UTC().Unix()
+
+	previousSecretToken, err := issueToken(&ServiceTokenClaims{Service: "old", ExpiresAt: unixNow + 60}, []byte("testPreviousSecret1"))
+	if err != nil {
+		t.Fatal("token setup error: " + err.Error())
+	}
+
+	otherSecretToken, err := issueToken(&ServiceTokenClaims{Service: "other", ExpiresAt: unixNow + 60}, []byte("testOtherSecret123"))
+	if err != nil {
+		t.Fatal("token setup error: " + err.Error())
+	}
+
+	expiredToken, err := issueToken(&ServiceTokenClaims{Service: "test", ExpiresAt: unixNow - 1}, []byte("testServiceSecret1"))
+	if err != nil {
+		t.Fatal("token setup error: " + err.Error())
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		wantService string
+		wantErr     error
+	}{
+		{"blank token", "", "", malformedServiceTokenError},
+		{"malformed token", "testToken", "", malformedServiceTokenError},
+		{"invalid encoding", "test!.token!", "", malformedServiceTokenError},
+		{"token signed with another secret", otherSecretToken, "", invalidServiceTokenSignatureError},
+		{"expired token", expiredToken, "", expiredServiceTokenError},
+		{"token signed with the previous secret", previousSecretToken, "old", nil},
+		{"valid token", validToken, "test", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotService, gotErr := Verify(test.token)
+			if gotErr != test.wantErr {
+				t.Fatalf("Verify() gave incorrect results, want error: %v, got: %v", test.wantErr, gotErr)
+			}
+
+			if gotService != test.wantService {
+				t.Errorf("Verify() gave incorrect results, want: %v, got: %v", test.wantService, gotService)
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+
+	t.Setenv(constants.ServiceSecretEnvVar, "testServiceSecret1")
+	t.Setenv(constants.PreviousServiceSecretEnvVar, "")
+
+	err := Init("test")
+	if err != nil {
+		t.Fatal("init error: " + err.Error())
+	}
+
+	token1, err := Token()
+	if err != nil {
+		t.Fatal("token error: " + err.Error())
+	}
+
+	// the current token is reused while it has time left
+	token2, err := Token()
+	if err != nil {
+		t.Fatal("token error: " + err.Error())
+	}
+
+	if token1 != token2 {
+		t.Errorf("Token() should reuse the current token, want: %v, got: %v", token1, token2)
+	}
+
+	// and rotated once it is about to expire
+	identity.mutex.Lock()
+	identity.tokenExpiresAt = time.Now().UTC().Unix() + constants.ServiceTokenRefreshSeconds - 1
+	identity.mutex.Unlock()
+
+	token3, err := Token()
+	if err != nil {
+		t.Fatal("token error: " + err.Error())
+	}
+
+	identity.mutex.Lock()
+	gotExpiresAt := identity.tokenExpiresAt
+	identity.mutex.Unlock()
+
+	if gotExpiresAt-time.Now().UTC().Unix() <= constants.ServiceTokenRefreshSeconds {
+		t.Errorf("Token() should have issued a new token, got: %v, expiring at %v", token3, gotExpiresAt)
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+
+	// only accepts requests with a valid service token (when enabled)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := ValidateRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		secret     string
+		client     *http.Client
+		wantStatus int
+	}{
+		{"disabled, plain client", "", http.DefaultClient, http.StatusOK},
+		{"enabled, plain client", "testServiceSecret1", http.DefaultClient, http.StatusUnauthorized},
+		{"enabled, service client", "testServiceSecret1", &http.Client{Transport: &Transport{Base: http.DefaultTransport}}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			t.Setenv(constants.ServiceSecretEnvVar, test.secret)
+			t.Setenv(constants.PreviousServiceSecretEnvVar, "")
+
+			err := Init("test")
+			if err != nil {
+				t.Fatal("init error: " + err.Error())
+			}
+
+			resp, err := test.client.Get(server.URL + "/data/player-internal/p1")
+			if err != nil {
+				t.Fatal("request error: " + err.Error())
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+}
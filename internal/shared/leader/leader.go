@@ -0,0 +1,60 @@
+// Package leader makes sure a singleton background job (like a backup, or a sweep over all the players) runs on only
+// one instance of a service at a time, by holding a lease on the job in the data service while running it
+package leader
+
+import (
+	"context"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"log"
+	"time"
+)
+
+// LeaseClient implementor can acquire leases (like the data service, or a data client)
+type LeaseClient interface {
+	AcquireLease(ctx context.Context, request *data.LeaseRequest) (*data.LeaseData, error)
+}
+
+// Elector decides whether this instance of a service runs each of its singleton jobs
+type Elector struct {
+	client LeaseClient
+	holder string
+	logger *log.Logger
+}
+
+// NewElector returns an initialized pointer to an elector which holds its leases through the given client,
+// as a holder unique to this instance of the given service
+func NewElector(client LeaseClient, service string, logger *log.Logger) *Elector {
+	return &Elector{client: client, holder: data.NewLeaseHolder(service), logger: logger}
+}
+
+// Holder returns the name this instance holds its leases under
+func (e *Elector) Holder() string {
+	if e == nil {
+		return ""
+	}
+	return e.holder
+}
+
+// IsLeader acquires (or renews) the lease on the given job, which runs every period, and returns whether this instance
+// holds it (so should run the job now). The lease lasts a few periods (see data.JobLeaseRequest), so while the leader
+// keeps running the job it keeps the lease, and another instance takes over soon after it goes away.
+// An error acquiring the lease counts as not holding it, so the job is skipped rather than run twice.
+// A nil elector (or one without a client) is always the leader
+func (e *Elector) IsLeader(ctx context.Context, job string, period time.Duration) bool {
+
+	if e == nil || e.client == nil {
+		return true
+	}
+
+	_, err := e.client.AcquireLease(ctx, data.JobLeaseRequest(job, e.holder, period))
+	if err != nil {
+		var heldErr data.LeaseHeldErr
+		if !errors.As(err, &heldErr) {
+			e.logger.Printf("could not acquire the lease on job: %v, skipping it: %v", job, err)
+		}
+		return false
+	}
+
+	return true
+}
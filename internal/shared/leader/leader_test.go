@@ -0,0 +1,55 @@
+package leader
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/data"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// failingClient cannot reach the data service
+type failingClient struct{}
+
+func (failingClient) AcquireLease(ctx context.Context, request *data.LeaseRequest) (*data.LeaseData, error) {
+	return nil, fmt.Errorf("data service unavailable")
+}
+
+func TestElector_IsLeader(t *testing.T) {
+
+	logger := log.New(os.Stdout, "leader test: ", log.Lmsgprefix)
+
+	ds := data.NewServer()
+	first := NewElector(ds, "profile", logger)
+	second := NewElector(ds, "profile", logger)
+
+	tests := []struct {
+		name       string
+		elector    *Elector
+		job        string
+		wantLeader bool
+	}{
+		{"nil elector", nil, "job1", true},
+		{"no client", NewElector(nil, "profile", logger), "job1", true},
+		{"first instance", first, "job1", true},
+		{"second instance", second, "job1", false},
+		{"first instance again", first, "job1", true},
+		{"second instance on another job", second, "job2", true},
+		{"data service unavailable", NewElector(failingClient{}, "profile", logger), "job3", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotLeader := test.elector.IsLeader(context.Background(), test.job, time.Minute)
+			if gotLeader != test.wantLeader {
+				t.Errorf("IsLeader() gave incorrect results, want: %v, got: %v", test.wantLeader, gotLeader)
+			}
+		})
+	}
+
+	if first.Holder() == second.Holder() {
+		t.Errorf("NewElector() gave incorrect results, want unique holders, got: %v twice", first.Holder())
+	}
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// accessLogSampleRate holds the share of requests the access log records (as the bits of a float64)
+var accessLogSampleRate atomic.Uint64
+
+func init() {
+	accessLogSampleRate.Store(math.Float64bits(constants.AccessLogSampleRate))
+}
+
+// SetAccessLogSampleRate sets the share of requests (from 0 to 1) the access log records,
+// 0 only records the requests which fail with a 5xx
+func SetAccessLogSampleRate(rate float64) {
+	accessLogSampleRate.Store(math.Float64bits(min(max(rate, 0), 1)))
+}
+
+// AccessLogSampleRate returns the share of requests the access log records
+func AccessLogSampleRate() float64 {
+	return math.Float64frombits(accessLogSampleRate.Load())
+}
+
+// accessLogOutput is where the access log lines are written
+var accessLogOutput io.Writer = redact.Stdout
+
+// accessLogEntry is the player related part of a request, the rest of the access log line comes from the request itself
+type accessLogEntry struct {
+	PlayerID string `json:"playerID"`
+}
+
+// WithAccessLog wraps the given handler (usually a server's mux) so that a sample of its requests (see
+// SetAccessLogSampleRate) is recorded in the access log of the given service, with the method, route, path, status,
+// latency, the player id (from the 'playerID' of a json request body), the {id} of the route (a player id on most
+// routes), and a short hash of the session id. Session ids are credentials, so they are never logged as they are,
+// and the lines go through redaction like every other log (see redact.Stdout). Requests failing with a 5xx are always recorded
+func WithAccessLog(service string, handler http.Handler) http.Handler {
+
+	logger := log.New(accessLogOutput, service+": ", log.Ltime|log.LUTC|log.Lmsgprefix)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		sampled := rand.Float64() < AccessLogSampleRate()
+		playerID := peekPlayerID(r)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+
+		if !sampled && recorder.status < http.StatusInternalServerError {
+			return
+		}
+
+		// the mux sets the pattern on the request it routes
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+
+		// the player controlled values are quoted, so they cannot break the line
+		line := &strings.Builder{}
+		fmt.Fprintf(line, "access method=%v route=%q path=%q status=%v latencyMs=%v", r.Method, route, r.URL.Path, recorder.status, time.Since(start).Milliseconds())
+		if playerID != "" {
+			fmt.Fprintf(line, " player=%q", playerID)
+		}
+		if id := r.PathValue("id"); id != "" {
+			fmt.Fprintf(line, " id=%q", id)
+		}
+		if sessionID := r.Header.Get("Session-Id"); sessionID != "" {
+			fmt.Fprintf(line, " session=%v", SessionHash(sessionID))
+		}
+
+		logger.Println(line.String())
+	})
+}
+
+// SessionHash returns a short hash of the given session id, which tells the requests of a session apart in the logs,
+// without logging the session id itself
+func SessionHash(sessionID string) string {
+	hash := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(hash[:6])
+}
+
+// peekPlayerID returns the 'playerID' of a json request body (if there is one), the body is read up to the default
+// max body bytes, and put back for the handler as it was
+func peekPlayerID(r *http.Request) string {
+
+	contentType := r.Header.Get("Content-Type")
+	if r.Body == nil || r.Body == http.NoBody || (contentType != "" && !strings.HasPrefix(contentType, "application/json")) {
+		return ""
+	}
+
+	peeked, err := io.ReadAll(io.LimitReader(r.Body, constants.DefaultMaxRequestBodyBytes))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peeked), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	entry := &accessLogEntry{}
+	if json.Unmarshal(peeked, entry) != nil {
+		return ""
+	}
+
+	return entry.PlayerID
+}
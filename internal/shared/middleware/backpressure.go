@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// BackpressureOptions holds the settings used by the backpressure middleware
+type BackpressureOptions struct {
+	Workers    int           // requests handled at the same time
+	QueueSize  int           // requests which can wait for a free worker, any more are rejected right away
+	QueueWait  time.Duration // how long a request can wait for a free worker before it is rejected
+	RetryAfter time.Duration // sent in the Retry-After header of the rejected requests (in whole seconds)
+}
+
+// DataBackpressureOptions are the backpressure settings of the data service
+var DataBackpressureOptions = BackpressureOptions{
+	Workers:    constants.DataWorkers,
+	QueueSize:  constants.DataQueueSize,
+	QueueWait:  constants.DataQueueWaitMillis * time.Millisecond,
+	RetryAfter: constants.DataRetryAfterSeconds * time.Second,
+}
+
+// WithBackpressure wraps the given handler (usually a server's mux) so that requests are handled by a bounded pool
+// of workers, with a bounded queue of requests waiting for a free worker. When the service is overloaded (the queue
+// is full, or a request waited too long) requests are rejected with a 429 and a Retry-After header, instead of piling
+// up on the stores behind the handler. The queued and rejected requests are added to the live stats of the service
+// (as the queuedRequests and rejectedRequests gauges)
+func WithBackpressure(service string, handler http.Handler, options BackpressureOptions) http.Handler {
+
+	workers := make(chan struct{}, max(options.Workers, 1))
+	queued := &atomic.Int64{}
+	rejected := &atomic.Int64{}
+
+	RegisterLiveGauge(service, "queuedRequests", queued.Load)
+	RegisterLiveGauge(service, "rejectedRequests", rejected.Load)
+
+	retryAfter := strconv.Itoa(max(int((options.RetryAfter+time.Second-1)/time.Second), 1))
+	reject := func(w http.ResponseWriter, r *http.Request) {
+		rejected.Add(1)
+		w.Header().Set("Retry-After", retryAfter)
+		apierror.Write(w, r, http.StatusTooManyRequests, apierror.CodeTooManyRequests)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		select {
+		case workers <- struct{}{}:
+			// a worker was free
+		default:
+			if queued.Add(1) > int64(options.QueueSize) {
+				queued.Add(-1)
+				reject(w, r)
+				return
+			}
+
+			timer := time.NewTimer(options.QueueWait)
+			select {
+			case workers <- struct{}{}:
+				queued.Add(-1)
+				timer.Stop()
+			case <-timer.C:
+				queued.Add(-1)
+				reject(w, r)
+				return
+			case <-r.Context().Done():
+				// the caller went away while waiting
+				queued.Add(-1)
+				timer.Stop()
+				return
+			}
+		}
+
+		defer func() { <-workers }()
+		handler.ServeHTTP(w, r)
+	})
+}
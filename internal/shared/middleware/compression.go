@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CompressionOptions holds the settings used by the compression middleware
+// (a zero value for a field means that part is turned off)
+type CompressionOptions struct {
+	Level              int  // gzip level (1 to 9) of the compressed responses
+	MinBytes           int  // responses smaller than this are sent as they are
+	DecompressRequests bool // accept gzip compressed request bodies
+}
+
+// DefaultCompressionOptions are the compression settings used by all the servers (can be overridden per service)
+var DefaultCompressionOptions = CompressionOptions{
+	Level:              constants.CompressionLevel,
+	MinBytes:           constants.CompressionMinBytes,
+	DecompressRequests: true,
+}
+
+// WithCompression wraps the given handler (usually a server's mux) so that json responses are gzip compressed
+// for clients which accept it (see the Accept-Encoding header), and gzip compressed request bodies
+// (see the Content-Encoding header) are decompressed before they reach the handler
+func WithCompression(handler http.Handler, options CompressionOptions) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" && r.Body != nil && r.Body != http.NoBody {
+
+			if !options.DecompressRequests || !strings.EqualFold(encoding, "gzip") {
+				apierror.Write(w, r, http.StatusUnsupportedMediaType, apierror.CodeInvalidRequest)
+				return
+			}
+
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+				return
+			}
+			defer body.Close()
+
+			// the handler (and the body limits) only see the decompressed body
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+
+		if options.Level <= 0 || r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, options: options, status: http.StatusOK}
+		defer cw.close()
+
+		handler.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsGzip checks whether the given Accept-Encoding header value allows gzip (or any encoding) with a non zero quality
+func acceptsGzip(acceptEncoding string) bool {
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		encoding = strings.TrimSpace(encoding)
+		if !strings.EqualFold(encoding, "gzip") && encoding != "*" {
+			continue
+		}
+
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		return quality > 0
+	}
+
+	return false
+}
+
+// compressWriter is a response writer which gzip compresses json responses, the start of the response
+// is held back till it is clear whether it is large enough (see CompressionOptions.MinBytes) to be compressed
+type compressWriter struct {
+	http.ResponseWriter
+	options CompressionOptions
+
+	status      int
+	wroteHeader bool // whether the handler has written the header (held back till the response is started)
+	started     bool // whether the header has been passed on to the wrapped writer
+	held        []byte
+	gz          *gzip.Writer
+}
+
+// WriteHeader holds back the status code till the response is started
+func (cw *compressWriter) WriteHeader(statusCode int) {
+
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = statusCode
+
+	// responses which will not be compressed are started right away
+	if !cw.compressible() {
+		cw.start(false)
+	}
+}
+
+// Write holds back the start of a compressible response till it reaches the minimum size
+func (cw *compressWriter) Write(p []byte) (int, error) {
+
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.started {
+		if cw.gz != nil {
+			return cw.gz.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.held = append(cw.held, p...)
+	if len(cw.held) >= cw.options.MinBytes {
+		err := cw.start(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush starts the response (compressed if it can be) and flushes everything written so far (needed by streaming handlers)
+func (cw *compressWriter) Flush() {
+
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.started {
+		_ = cw.start(true)
+	}
+
+	if cw.gz != nil {
+		_ = cw.gz.Flush()
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer (used by http.ResponseController)
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// compressible checks whether the response is json, with a body, and not encoded by the handler already
+func (cw *compressWriter) compressible() bool {
+
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+
+	if cw.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(cw.Header().Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// start passes the header (and what has been held back of the body) on to the wrapped writer,
+// compressing the body from here on if asked to, and the response is compressible
+func (cw *compressWriter) start(compress bool) error {
+
+	cw.started = true
+
+	if cw.compressible() {
+		cw.Header().Add("Vary", "Accept-Encoding")
+
+		if compress {
+			gz, err := gzip.NewWriterLevel(cw.ResponseWriter, cw.options.Level)
+			if err != nil {
+				return err
+			}
+			cw.gz = gz
+
+			cw.Header().Set("Content-Encoding", "gzip")
+			cw.Header().Del("Content-Length")
+
+			// the compressed body is not byte for byte the one the (strong) ETag was made for
+			if etag := cw.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				cw.Header().Set("ETag", "W/"+etag)
+			}
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	held := cw.held
+	cw.held = nil
+	if len(held) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(held)
+	} else {
+		_, err = cw.ResponseWriter.Write(held)
+	}
+	return err
+}
+
+// close finishes the response: a response which never reached the minimum size is sent as it is,
+// and a compressed one gets the end of the gzip stream
+func (cw *compressWriter) close() {
+
+	if !cw.wroteHeader {
+		// nothing was written by the handler, the server sends the default response
+		return
+	}
+
+	if !cw.started {
+		_ = cw.start(false)
+	}
+
+	if cw.gz != nil {
+		_ = cw.gz.Close()
+	}
+}
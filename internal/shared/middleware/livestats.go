@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LiveStats are the live (point in time) metrics of a service, the number of requests it is currently handling,
+// the current values of the gauges the service registered (see RegisterLiveGauge), and the connections used by
+// the internal requests (of the whole process, so services running in the same process report the same ones)
+type LiveStats struct {
+	Service          string            `json:"service"`
+	RequestsInFlight int64             `json:"requestsInFlight"`
+	Gauges           map[string]int64  `json:"gauges,omitempty"`
+	InternalConns    tracing.ConnStats `json:"internalConns"`
+
+	// the adaptive deadlines (in milliseconds) of the internal requests to each downstream, by host
+	// (only when adaptive timeouts are enabled, see tracing.EnableAdaptiveTimeouts)
+	InternalTimeoutsMillis map[string]int64 `json:"internalTimeoutsMillis,omitempty"`
+}
+
+// serviceLiveStats holds the in-flight request counter, the registered gauges and the SLO windows of a single service
+type serviceLiveStats struct {
+	requestsInFlight atomic.Int64
+	gauges           map[string]func() int64
+	slo              *serviceSLOWindows
+}
+
+// liveStats holds the live stats of every service running in this process, keyed by service name
+// (when all the services run in the same process, each one still keeps its own stats)
+var liveStats = struct {
+	mutex    sync.Mutex
+	services map[string]*serviceLiveStats
+}{services: map[string]*serviceLiveStats{}}
+
+// serviceStats returns the live stats of the given service, creating them if needed
+func serviceStats(service string) *serviceLiveStats {
+
+	liveStats.mutex.Lock()
+	defer liveStats.mutex.Unlock()
+
+	stats, ok := liveStats.services[service]
+	if !ok {
+		stats = &serviceLiveStats{gauges: map[string]func() int64{}, slo: &serviceSLOWindows{routes: map[string]*routeWindow{}}}
+		liveStats.services[service] = stats
+	}
+	return stats
+}
+
+// RegisterLiveGauge adds a named gauge to the live stats of the given service,
+// the gauge function is called every time the live stats are read
+func RegisterLiveGauge(service string, name string, gauge func() int64) {
+
+	stats := serviceStats(service)
+
+	liveStats.mutex.Lock()
+	defer liveStats.mutex.Unlock()
+
+	stats.gauges[name] = gauge
+}
+
+// CurrentLiveStats returns the current live stats of the given service
+func CurrentLiveStats(service string) *LiveStats {
+
+	stats := serviceStats(service)
+
+	liveStats.mutex.Lock()
+	gauges := make(map[string]func() int64, len(stats.gauges))
+	for name, gauge := range stats.gauges {
+		gauges[name] = gauge
+	}
+	liveStats.mutex.Unlock()
+
+	current := &LiveStats{
+		Service:          service,
+		RequestsInFlight: stats.requestsInFlight.Load(),
+		InternalConns:    tracing.InternalConnStats(),
+	}
+
+	if timeouts := tracing.InternalTimeouts(); len(timeouts) > 0 {
+		current.InternalTimeoutsMillis = make(map[string]int64, len(timeouts))
+		for host, timeout := range timeouts {
+			current.InternalTimeoutsMillis[host] = timeout.Milliseconds()
+		}
+	}
+
+	// the gauges are read outside the lock, since they usually take locks of their own
+	if len(gauges) > 0 {
+		current.Gauges = make(map[string]int64, len(gauges))
+		for name, gauge := range gauges {
+			current.Gauges[name] = gauge()
+		}
+	}
+
+	return current
+}
+
+// LiveStatsPath returns the path of the internal endpoint serving the live stats of the given service
+func LiveStatsPath(service string) string {
+	return "/" + service + "/live-stats-internal"
+}
+
+// WithLiveStats wraps the given handler (usually a server's mux) so that the requests it is handling are counted
+// as the requests in flight of the given service, and the status and latency of every request are recorded
+// against the SLO of its route (see SLOTarget). It also answers GET requests to the live stats path (see LiveStatsPath),
+// the SLO path (see SLOPath) and the metrics path of the service itself (these requests are not counted)
+func WithLiveStats(service string, handler http.Handler) http.Handler {
+
+	stats := serviceStats(service)
+	path := LiveStatsPath(service)
+	sloPath := SLOPath(service)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method == http.MethodGet {
+			var response any
+			switch r.URL.Path {
+			case path:
+				response = CurrentLiveStats(service)
+			case sloPath:
+				response = CurrentSLO(service)
+			case MetricsPath:
+				w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+				writeMetrics(w, service)
+				return
+			}
+
+			if response != nil {
+				w.Header().Set("Content-Type", "application/json")
+				err := json.NewEncoder(w).Encode(response)
+				if err != nil {
+					http.Error(w, "error: could not create response: "+err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+
+		stats.requestsInFlight.Add(1)
+		defer stats.requestsInFlight.Add(-1)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler.ServeHTTP(recorder, r)
+
+		// the mux sets the pattern on the request it routes, requests which did not match a route are not recorded
+		if r.Pattern != "" {
+			stats.slo.recordRequest(r.Pattern, recorder.status, time.Since(start), time.Now())
+		}
+	})
+}
+
+// ReadLiveStats makes an internal request for the live stats of the given service, to the server at the given base url
+func ReadLiveStats(ctx context.Context, baseURL string, service string) (*LiveStats, error) {
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, baseURL)
+	defer cancel()
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+LiveStatsPath(service), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal live stats request was not successful, status code %v", resp.StatusCode)
+	}
+
+	// decode the response for the live stats
+	stats := &LiveStats{}
+	err = json.NewDecoder(resp.Body).Decode(stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,277 @@
+// Package middleware contains http middleware shared by the different servers in our module
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RouteLimits holds the limits applied to a single route
+// (a zero value for a field means that limit is not applied)
+type RouteLimits struct {
+	Timeout      time.Duration
+	MaxBodyBytes int64
+}
+
+// DefaultLimits are the limits used by most routes
+var DefaultLimits = RouteLimits{
+	Timeout:      constants.DefaultRequestTimeoutSeconds * time.Second,
+	MaxBodyBytes: constants.DefaultMaxRequestBodyBytes,
+}
+
+// WithLimits wraps the given handler so that the request body cannot be larger than the max body bytes
+// (responding with 413 if it is), and the handler has to finish within the timeout (responding with 503 if not)
+func WithLimits(handler http.HandlerFunc, limits RouteLimits) http.Handler {
+
+	var limited http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if limits.MaxBodyBytes > 0 && r.Body != nil && r.Body != http.NoBody {
+
+			// read the whole (limited) body up front, so oversized payloads are
+			// rejected here rather than in the middle of decoding them in the handler
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes))
+			if err != nil {
+				maxBytesErr := &http.MaxBytesError{}
+				if errors.As(err, &maxBytesErr) {
+					apierror.Write(w, r, http.StatusRequestEntityTooLarge, apierror.CodeRequestTooLarge)
+				} else {
+					apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+				}
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		handler(w, r)
+	})
+
+	if limits.Timeout > 0 {
+		limited = http.TimeoutHandler(limited, limits.Timeout, "error: request timed out")
+	}
+
+	return limited
+}
+
+// NewHTTPServer returns an http server for the given address and handler, with a read header
+// timeout set, so that slow clients cannot hold on to connections while sending headers
+func NewHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: constants.ReadHeaderTimeoutSeconds * time.Second,
+	}
+}
+
+// Serve serves the given handler on the given listener (with the settings of NewHTTPServer) till the context is done,
+// then shuts the server down gracefully: it stops taking new requests, and waits up to ShutdownTimeoutSeconds for the
+// requests in flight, before closing the connections left. It returns nil once the server is shut down, and the error
+// which stopped the server otherwise (like the listener being closed by the caller)
+func Serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+
+	server := NewHTTPServer(listener.Addr().String(), handler)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), constants.ShutdownTimeoutSeconds*time.Second)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		// the requests still in flight (like event streams) are cut off
+		_ = server.Close()
+	}
+
+	<-serveErr // always http.ErrServerClosed after a shutdown
+	return nil
+}
+
+// CORSOptions holds the settings used by the CORS middleware
+type CORSOptions struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	ExposedHeaders []string
+	MaxAgeSeconds  int
+}
+
+// DefaultCORSOptions are the CORS settings used by all the servers, the Session-Id header has to be
+// allowed (it is sent with every validated request) and exposed (it is read from the login response),
+// and the api versioning, request nonce, config caching (ETag) / signature and player data caching (If-Modified-Since)
+// headers are allowed and exposed as well, along with Retry-After (of the login queue and the backpressure)
+var DefaultCORSOptions = CORSOptions{
+	AllowedOrigins: strings.Split(constants.CORSAllowedOrigins, ","),
+	AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+	AllowedHeaders: []string{"Authorization", "Content-Type", "Session-Id", APIVersionHeader, constants.RequestNonceHeader, "If-None-Match", "If-Modified-Since"},
+	ExposedHeaders: []string{"Session-Id", APIVersionHeader, "Deprecation", "Link", "ETag", "Config-Signature", "Retry-After"},
+	MaxAgeSeconds:  constants.CORSMaxAgeSeconds,
+}
+
+// WithCORS wraps the given handler (usually a server's mux) so that the public endpoints
+// (all paths which are not internal) send CORS headers to allowed origins, and answer preflight requests.
+// Internal endpoints (paths containing "-internal") are passed through untouched
+func WithCORS(handler http.Handler, options CORSOptions) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || strings.Contains(r.URL.Path, "-internal") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		allowedOrigin, ok := options.allowedOrigin(origin)
+		if !ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+
+		// preflight request: respond directly, without passing it on to the handler
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(options.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(options.AllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(options.MaxAgeSeconds))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if len(options.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin returns the value of the allow origin header for the given origin, and whether it is allowed at all
+func (options CORSOptions) allowedOrigin(origin string) (string, bool) {
+	for _, allowed := range options.AllowedOrigins {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// WithServiceAuth wraps the given handler (usually a server's mux) so that internal endpoints
+// (paths containing "-internal") reject requests without a valid service token (responding with 401),
+// see identity.ValidateRequest. Public endpoints are passed through untouched
+func WithServiceAuth(handler http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if strings.Contains(r.URL.Path, "-internal") {
+			err := identity.ValidateRequest(r)
+			if err != nil {
+				http.Error(w, "error: service authentication error: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// WithNamespace wraps the given handler (usually a server's mux) so that internal requests are handled in the
+// namespace they carry (see namespace.Header), which is also passed on to the internal requests made while
+// handling them. Requests without one (and all public requests) are handled in the namespace of the service
+func WithNamespace(handler http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if requestNamespace := r.Header.Get(namespace.Header); requestNamespace != "" && strings.Contains(r.URL.Path, "-internal") {
+			err := namespace.Validate(requestNamespace)
+			if err != nil {
+				http.Error(w, "error: invalid namespace: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			r = r.WithContext(namespace.NewContext(r.Context(), requestNamespace))
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// WithTracing wraps the given handler (usually a server's mux) so that every request is handled in a server span,
+// which continues the trace of the caller (from its traceparent header) if there is one. The span is named
+// after the matched route pattern, and the context of the request passed on to the handler holds the span, along with
+// the request id (the trace id, or a random one when the request is not traced, see playerctx.RequestID)
+func WithTracing(handler http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ctx, span := tracing.StartServer(r, r.Method+" "+r.URL.Path)
+		defer span.End()
+
+		requestID := rand.Text()
+		if span.SpanContext().HasTraceID() {
+			requestID = span.SpanContext().TraceID().String()
+		}
+
+		r = r.WithContext(playerctx.WithRequestID(ctx, requestID))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler.ServeHTTP(recorder, r)
+
+		// the mux sets the pattern on the request it routes
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+		}
+		tracing.SetHTTPStatus(span, recorder.status)
+	})
+}
+
+// statusRecorder is a response writer which remembers the status code written to it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	sr.status = statusCode
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush passes flushes on to the wrapped writer (needed by streaming handlers)
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer (used by http.ResponseController)
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
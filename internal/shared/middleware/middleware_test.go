@@ -0,0 +1,896 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithLimits(t *testing.T) {
+
+	// echoes the request body back
+	echoHandler := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(body)
+	}
+
+	// takes longer than the timeouts used in the tests below
+	slowHandler := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	}
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		limits     RouteLimits
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"no limits", echoHandler, RouteLimits{}, "hello", http.StatusOK, "hello"},
+		{"body within limit", echoHandler, RouteLimits{MaxBodyBytes: 5}, "hello", http.StatusOK, "hello"},
+		{"body over limit", echoHandler, RouteLimits{MaxBodyBytes: 4}, "hello", http.StatusRequestEntityTooLarge, ""},
+		{"handler within timeout", slowHandler, RouteLimits{Timeout: time.Second}, "", http.StatusOK, "done"},
+		{"handler over timeout", slowHandler, RouteLimits{Timeout: 10 * time.Millisecond}, "", http.StatusServiceUnavailable, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(test.body))
+			respRec := httptest.NewRecorder()
+
+			WithLimits(test.handler, test.limits).ServeHTTP(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotBody := respRec.Body.String()
+				if gotBody != test.wantBody {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantBody, gotBody)
+				}
+			}
+		})
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+
+	okHandler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("success"))
+	}
+
+	anyOrigin := CORSOptions{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"POST"}, AllowedHeaders: []string{"Session-Id"}, ExposedHeaders: []string{"Session-Id"}, MaxAgeSeconds: 60}
+	oneOrigin := CORSOptions{AllowedOrigins: []string{"https://game.example.com"}, AllowedMethods: []string{"POST"}, AllowedHeaders: []string{"Session-Id"}, MaxAgeSeconds: 60}
+
+	tests := []struct {
+		name            string
+		options         CORSOptions
+		method          string
+		path            string
+		origin          string
+		preflight       bool
+		wantStatus      int
+		wantAllowOrigin string
+		wantAllowHeader string
+	}{
+		{"no origin", anyOrigin, http.MethodPost, "/auth/login", "", false, http.StatusOK, "", ""},
+		{"any origin", anyOrigin, http.MethodPost, "/auth/login", "https://other.example.com", false, http.StatusOK, "*", ""},
+		{"allowed origin", oneOrigin, http.MethodPost, "/auth/login", "https://game.example.com", false, http.StatusOK, "https://game.example.com", ""},
+		{"disallowed origin", oneOrigin, http.MethodPost, "/auth/login", "https://other.example.com", false, http.StatusOK, "", ""},
+		{"internal endpoint", anyOrigin, http.MethodPost, "/auth/validation-internal", "https://game.example.com", false, http.StatusOK, "", ""},
+		{"preflight", anyOrigin, http.MethodOptions, "/auth/login", "https://game.example.com", true, http.StatusNoContent, "*", "Session-Id"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(test.method, test.path, nil)
+			if test.origin != "" {
+				newReq.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				newReq.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			respRec := httptest.NewRecorder()
+
+			WithCORS(http.HandlerFunc(okHandler), test.options).ServeHTTP(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			gotAllowOrigin := respRec.Result().Header.Get("Access-Control-Allow-Origin")
+			if gotAllowOrigin != test.wantAllowOrigin {
+				t.Errorf("handler gave incorrect allow origin header, want: %v, got: %v", test.wantAllowOrigin, gotAllowOrigin)
+			}
+
+			gotAllowHeader := respRec.Result().Header.Get("Access-Control-Allow-Headers")
+			if gotAllowHeader != test.wantAllowHeader {
+				t.Errorf("handler gave incorrect allow headers header, want: %v, got: %v", test.wantAllowHeader, gotAllowHeader)
+			}
+		})
+	}
+}
+
+func TestWithServiceAuth(t *testing.T) {
+
+	t.Setenv(constants.ServiceSecretEnvVar, "testServiceSecret1")
+
+	err := identity.Init("test")
+	if err != nil {
+		t.Fatal("identity setup error: " + err.Error())
+	}
+
+	okHandler := func(w http.ResponseWriter, r *http.Request) {}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/player-internal/{id}", okHandler)
+	mux.HandleFunc("GET /profile/player-data/{id}", okHandler)
+
+	server := httptest.NewServer(WithServiceAuth(mux))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		client     *http.Client
+		wantStatus int
+	}{
+		{"public endpoint, plain client", "/profile/player-data/p1", http.DefaultClient, http.StatusOK},
+		{"internal endpoint, plain client", "/data/player-internal/p1", http.DefaultClient, http.StatusUnauthorized},
+		{"internal endpoint, internal client", "/data/player-internal/p1", tracing.HTTPClient, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			resp, err := test.client.Get(server.URL + test.path)
+			if err != nil {
+				t.Fatal("request error: " + err.Error())
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+
+	// leave the other tests without a service secret
+	t.Setenv(constants.ServiceSecretEnvVar, "")
+	err = identity.Init("test")
+	if err != nil {
+		t.Fatal("identity reset error: " + err.Error())
+	}
+}
+
+func TestWithNamespace(t *testing.T) {
+
+	namespaceHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, namespace.FromContext(r.Context()))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/player-internal/{id}", namespaceHandler)
+	mux.HandleFunc("GET /profile/player-data/{id}", namespaceHandler)
+
+	tests := []struct {
+		name          string
+		path          string
+		namespace     string
+		wantStatus    int
+		wantNamespace string
+	}{
+		{"internal endpoint, no namespace", "/data/player-internal/p1", "", http.StatusOK, ""},
+		{"internal endpoint, namespace", "/data/player-internal/p1", "staging", http.StatusOK, "staging"},
+		{"internal endpoint, invalid namespace", "/data/player-internal/p1", "Staging/1", http.StatusBadRequest, ""},
+		{"public endpoint, namespace", "/profile/player-data/p1", "staging", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.namespace != "" {
+				newReq.Header.Set(namespace.Header, test.namespace)
+			}
+			respRec := httptest.NewRecorder()
+
+			WithNamespace(mux).ServeHTTP(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK && respRec.Body.String() != test.wantNamespace {
+				t.Errorf("handler gave incorrect namespace, want: %v, got: %v", test.wantNamespace, respRec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithTracing(t *testing.T) {
+
+	_, err := tracing.Init("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// record the spans in memory
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	// the inner server stands in for an internal service
+	innerMux := http.NewServeMux()
+	innerMux.HandleFunc("GET /inner/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("success"))
+	})
+	innerServer := httptest.NewServer(WithTracing(innerMux))
+	defer innerServer.Close()
+
+	// the outer server makes an internal request to the inner one while handling its own request
+	outerMux := http.NewServeMux()
+	outerRequestID := ""
+	outerMux.HandleFunc("GET /outer", func(w http.ResponseWriter, r *http.Request) {
+		outerRequestID, _ = playerctx.RequestID(r.Context())
+		req, reqErr := http.NewRequestWithContext(r.Context(), http.MethodGet, innerServer.URL+"/inner/player1", nil)
+		if reqErr != nil {
+			http.Error(w, reqErr.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, reqErr := tracing.HTTPClient.Do(req)
+		if reqErr != nil {
+			http.Error(w, reqErr.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+	})
+
+	newReq := httptest.NewRequest(http.MethodGet, "/outer", nil)
+	respRec := httptest.NewRecorder()
+	WithTracing(outerMux).ServeHTTP(respRec, newReq)
+
+	if respRec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("handler gave incorrect results, want: %v, got: %v", http.StatusOK, respRec.Result().StatusCode)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	outerSpan, clientSpan, innerSpan := spans["GET /outer"], spans["GET /inner/player1"], spans["GET /inner/{id}"]
+	if outerSpan == nil || clientSpan == nil || innerSpan == nil {
+		t.Fatalf("missing spans, got: %v", spans)
+	}
+
+	// all the spans belong to the same trace, and the inner server continues from the client span
+	traceID := outerSpan.SpanContext().TraceID()
+	if clientSpan.SpanContext().TraceID() != traceID || innerSpan.SpanContext().TraceID() != traceID {
+		t.Errorf("spans should share the trace id: %v", traceID)
+	}
+
+	// the request id of a traced request is its trace id
+	if outerRequestID != traceID.String() {
+		t.Errorf("handler got incorrect request id, want: %v, got: %v", traceID, outerRequestID)
+	}
+
+	if clientSpan.Parent().SpanID() != outerSpan.SpanContext().SpanID() {
+		t.Errorf("client span should be a child of the outer server span")
+	}
+
+	if innerSpan.Parent().SpanID() != clientSpan.SpanContext().SpanID() {
+		t.Errorf("inner server span should be a child of the client span")
+	}
+}
+
+func TestWithVersioning(t *testing.T) {
+
+	// echoes the api version and the routed path
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /gameplay/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%v %v", APIVersion(r), r.PathValue("id"))
+	})
+	mux.HandleFunc("GET /data/player-internal/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%v %v", APIVersion(r), r.PathValue("id"))
+	})
+
+	tests := []struct {
+		name            string
+		path            string
+		versionHeader   string
+		wantStatus      int
+		wantBody        string
+		wantDeprecation string
+		wantLink        string
+	}{
+		{"unprefixed", "/gameplay/p1", "", http.StatusOK, "1 p1", "true", "</v2/gameplay/p1>; rel=\"successor-version\""},
+		{"unprefixed with header", "/gameplay/p1", "2", http.StatusOK, "2 p1", "", ""},
+		{"unprefixed with invalid header", "/gameplay/p1", "two", http.StatusBadRequest, "", "", ""},
+		{"version 1 prefix", "/v1/gameplay/p1", "", http.StatusOK, "1 p1", "true", "</v2/gameplay/p1>; rel=\"successor-version\""},
+		{"version 2 prefix", "/v2/gameplay/p1", "", http.StatusOK, "2 p1", "", ""},
+		{"prefix wins over header", "/v2/gameplay/p1", "1", http.StatusOK, "2 p1", "", ""},
+		{"unsupported version prefix", "/v3/gameplay/p1", "", http.StatusBadRequest, "", "", ""},
+		{"internal endpoint", "/data/player-internal/p1", "2", http.StatusOK, "1 p1", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.versionHeader != "" {
+				newReq.Header.Set(APIVersionHeader, test.versionHeader)
+			}
+			respRec := httptest.NewRecorder()
+
+			WithVersioning(mux).ServeHTTP(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotBody := respRec.Body.String()
+				if gotBody != test.wantBody {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantBody, gotBody)
+				}
+			}
+
+			gotDeprecation := respRec.Result().Header.Get("Deprecation")
+			if gotDeprecation != test.wantDeprecation {
+				t.Errorf("handler gave incorrect deprecation header, want: %v, got: %v", test.wantDeprecation, gotDeprecation)
+			}
+
+			gotLink := respRec.Result().Header.Get("Link")
+			if gotLink != test.wantLink {
+				t.Errorf("handler gave incorrect link header, want: %v, got: %v", test.wantLink, gotLink)
+			}
+		})
+	}
+}
+
+func TestWithLiveStats(t *testing.T) {
+
+	// the handler holds the request in flight till it is released
+	entered := make(chan bool)
+	release := make(chan bool)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /test/slow", func(w http.ResponseWriter, r *http.Request) {
+		entered <- true
+		<-release
+	})
+
+	RegisterLiveGauge("test", "testGauge", func() int64 { return 7 })
+
+	handler := WithLiveStats("test", mux)
+
+	done := make(chan bool)
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test/slow", nil))
+		done <- true
+	}()
+	<-entered
+
+	tests := []struct {
+		name string
+		want *LiveStats
+	}{
+		{"request in flight", &LiveStats{Service: "test", RequestsInFlight: 1, Gauges: map[string]int64{"testGauge": 7}}},
+		{"request done", &LiveStats{Service: "test", RequestsInFlight: 0, Gauges: map[string]int64{"testGauge": 7}}},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if i == 1 {
+				release <- true
+				<-done
+			}
+
+			respRec := httptest.NewRecorder()
+			handler.ServeHTTP(respRec, httptest.NewRequest(http.MethodGet, LiveStatsPath("test"), nil))
+
+			got := &LiveStats{}
+			err := json.NewDecoder(respRec.Result().Body).Decode(got)
+			if err != nil {
+				t.Fatal("could not decode the response body")
+			}
+
+			test.want.InternalConns = tracing.InternalConnStats()
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestWithBackpressure(t *testing.T) {
+
+	// the handler holds the first request till it is released
+	entered := make(chan bool)
+	release := make(chan bool)
+	held := true
+	handler := WithBackpressure("backpressure-test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if held {
+			held = false
+			entered <- true
+			<-release
+		}
+	}), BackpressureOptions{Workers: 1, QueueSize: 1, QueueWait: 50 * time.Millisecond, RetryAfter: 1500 * time.Millisecond})
+
+	serve := func() *http.Response {
+		respRec := httptest.NewRecorder()
+		handler.ServeHTTP(respRec, httptest.NewRequest(http.MethodGet, "/test", nil))
+		return respRec.Result()
+	}
+
+	gauge := func(name string) int64 {
+		return CurrentLiveStats("backpressure-test").Gauges[name]
+	}
+
+	// the first request takes the only worker, and the second one waits in the queue
+	firstDone := make(chan *http.Response)
+	go func() { firstDone <- serve() }()
+	<-entered
+
+	queuedDone := make(chan *http.Response)
+	go func() { queuedDone <- serve() }()
+	for gauge("queuedRequests") != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		name           string
+		response       func() *http.Response
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"queue full", serve, http.StatusTooManyRequests, "2"},
+		{"waited too long", func() *http.Response { return <-queuedDone }, http.StatusTooManyRequests, "2"},
+		{"worker released", func() *http.Response { release <- true; return <-firstDone }, http.StatusOK, ""},
+		{"worker free", serve, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			resp := test.response()
+
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, resp.StatusCode)
+			}
+
+			if gotRetryAfter := resp.Header.Get("Retry-After"); gotRetryAfter != test.wantRetryAfter {
+				t.Errorf("handler gave incorrect results, want retry after: %q, got: %q", test.wantRetryAfter, gotRetryAfter)
+			}
+		})
+	}
+
+	if gauge("queuedRequests") != 0 || gauge("rejectedRequests") != 2 {
+		t.Errorf("handler gave incorrect results, want 0 queued and 2 rejected requests, got: %v", CurrentLiveStats("backpressure-test").Gauges)
+	}
+}
+
+func TestServiceSLOWindows(t *testing.T) {
+
+	windows := &serviceSLOWindows{routes: map[string]*routeWindow{}}
+	start := time.Unix(6000, 0)
+
+	// the result route gets slow requests in the first minute, and the ban route gets failures in the second one
+	for i := range constants.SLOMinRequests {
+		windows.recordRequest("POST /gameplay/result", http.StatusOK, 400*time.Millisecond, start)
+		windows.recordRequest("POST /gameplay/entry", http.StatusOK, 10*time.Millisecond, start)
+
+		status := http.StatusOK
+		if i == 0 {
+			status = http.StatusInternalServerError
+		}
+		windows.recordRequest("GET /auth/admin/ban/{id}", status, 10*time.Millisecond, start.Add(time.Minute))
+	}
+	windows.recordRequest("GET /profile/energy-events/{id}", http.StatusOK, time.Hour, start)
+
+	tests := []struct {
+		name         string
+		now          time.Time
+		wantRoutes   []string
+		wantBreaches map[string][]string
+	}{
+		{"all in the window", start.Add(time.Minute), []string{"GET /auth/admin/ban/{id}", "GET /profile/energy-events/{id}", "POST /gameplay/entry", "POST /gameplay/result"},
+			map[string][]string{"GET /auth/admin/ban/{id}": {"success-rate"}, "POST /gameplay/result": {"latency"}}},
+		{"first minute out of the window", start.Add(constants.SLOWindowMinutes * time.Minute), []string{"GET /auth/admin/ban/{id}"},
+			map[string][]string{"GET /auth/admin/ban/{id}": {"success-rate"}}},
+		{"all out of the window", start.Add((constants.SLOWindowMinutes + 1) * time.Minute), []string{}, map[string][]string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			got := windows.current("test", test.now)
+
+			gotRoutes := []string{}
+			gotBreaches := map[string][]string{}
+			for _, routeSLO := range got.Routes {
+				gotRoutes = append(gotRoutes, routeSLO.Route)
+				if len(routeSLO.Breaches) > 0 {
+					gotBreaches[routeSLO.Route] = routeSLO.Breaches
+				}
+			}
+
+			if !reflect.DeepEqual(gotRoutes, test.wantRoutes) {
+				t.Errorf("current() gave incorrect routes, want: %v, got: %v", test.wantRoutes, gotRoutes)
+			}
+			if !reflect.DeepEqual(gotBreaches, test.wantBreaches) {
+				t.Errorf("current() gave incorrect breaches, want: %v, got: %v", test.wantBreaches, gotBreaches)
+			}
+		})
+	}
+}
+
+func TestWithLiveStats_SLO(t *testing.T) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /slotest/ok/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /slotest/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "error: test failure", http.StatusInternalServerError)
+	})
+
+	handler := WithLiveStats("slotest", mux)
+
+	for _, path := range []string{"/slotest/ok/1", "/slotest/ok/2", "/slotest/fail", "/slotest/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		wantContains []string
+	}{
+		{"slo", SLOPath("slotest"), []string{`"route":"GET /slotest/fail","target":{"successRate":0.99,"latencyMillis":500,"latencyRate":0.99},"requests":1,"successRate":0`,
+			`"route":"GET /slotest/ok/{id}","target":{"successRate":0.99,"latencyMillis":500,"latencyRate":0.99},"requests":2,"successRate":1`}},
+		{"metrics", MetricsPath, []string{`dice_requests_in_flight{service="slotest"} 0`, `dice_slo_requests{service="slotest",route="GET /slotest/ok/{id}"} 2`,
+			`dice_slo_success_rate{service="slotest",route="GET /slotest/fail"} 0`, `dice_slo_breached{service="slotest",route="GET /slotest/fail"} 0`}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			respRec := httptest.NewRecorder()
+			handler.ServeHTTP(respRec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			body := respRec.Body.String()
+			for _, want := range test.wantContains {
+				if !strings.Contains(body, want) {
+					t.Errorf("handler gave incorrect results, want: %v in: %v", want, body)
+				}
+			}
+
+			if strings.Contains(body, "unknown") {
+				t.Errorf("handler should not record requests which did not match a route, got: %v", body)
+			}
+		})
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+
+	largeJSON := `{"data":"` + strings.Repeat("a", constants.CompressionMinBytes) + `"}`
+	smallJSON := `{"data":"a"}`
+
+	// responds with the requested content type and body, or echoes the request body back
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /test/json/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"abc"`)
+		fmt.Fprint(w, largeJSON)
+	})
+	mux.HandleFunc("GET /test/json/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, smallJSON)
+	})
+	mux.HandleFunc("GET /test/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, largeJSON)
+	})
+	mux.HandleFunc("POST /test/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(body)
+	})
+
+	handler := WithCompression(mux, DefaultCompressionOptions)
+
+	gzipped := func(body string) string {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		gz.Write([]byte(body))
+		gz.Close()
+		return buf.String()
+	}
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		acceptEncoding  string
+		contentEncoding string
+		requestBody     string
+		wantStatus      int
+		wantEncoding    string
+		wantETag        string
+		wantBody        string
+	}{
+		{"large json, gzip accepted", http.MethodGet, "/test/json/large", "gzip, deflate", "", "", http.StatusOK, "gzip", `W/"abc"`, largeJSON},
+		{"large json, gzip not accepted", http.MethodGet, "/test/json/large", "", "", "", http.StatusOK, "", `"abc"`, largeJSON},
+		{"large json, gzip refused", http.MethodGet, "/test/json/large", "gzip;q=0", "", "", http.StatusOK, "", `"abc"`, largeJSON},
+		{"large json, any encoding accepted", http.MethodGet, "/test/json/large", "*", "", "", http.StatusOK, "gzip", `W/"abc"`, largeJSON},
+		{"small json", http.MethodGet, "/test/json/small", "gzip", "", "", http.StatusOK, "", "", smallJSON},
+		{"large text", http.MethodGet, "/test/text", "gzip", "", "", http.StatusOK, "", "", largeJSON},
+		{"gzip request body", http.MethodPost, "/test/echo", "", "gzip", gzipped("hello"), http.StatusOK, "", "", "hello"},
+		{"corrupt gzip request body", http.MethodPost, "/test/echo", "", "gzip", "hello", http.StatusBadRequest, "", "", ""},
+		{"unsupported request encoding", http.MethodPost, "/test/echo", "", "br", "hello", http.StatusUnsupportedMediaType, "", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(test.method, test.path, strings.NewReader(test.requestBody))
+			if test.acceptEncoding != "" {
+				newReq.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			if test.contentEncoding != "" {
+				newReq.Header.Set("Content-Encoding", test.contentEncoding)
+			}
+			respRec := httptest.NewRecorder()
+
+			handler.ServeHTTP(respRec, newReq)
+
+			if respRec.Code != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, respRec.Code)
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+
+			gotEncoding := respRec.Header().Get("Content-Encoding")
+			if gotEncoding != test.wantEncoding {
+				t.Errorf("handler gave incorrect content encoding, want: %v, got: %v", test.wantEncoding, gotEncoding)
+			}
+
+			gotETag := respRec.Header().Get("ETag")
+			if gotETag != test.wantETag {
+				t.Errorf("handler gave incorrect etag, want: %v, got: %v", test.wantETag, gotETag)
+			}
+
+			var body io.Reader = respRec.Body
+			if gotEncoding == "gzip" {
+				gz, err := gzip.NewReader(respRec.Body)
+				if err != nil {
+					t.Fatal("could not decompress the response body: " + err.Error())
+				}
+				body = gz
+			}
+
+			gotBody, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal("could not read the response body: " + err.Error())
+			}
+			if string(gotBody) != test.wantBody {
+				t.Errorf("handler gave incorrect body, want: %v, got: %v", test.wantBody, string(gotBody))
+			}
+		})
+	}
+}
+
+func TestWithCompression_Flush(t *testing.T) {
+
+	// a streaming handler which flushes a small json response
+	handler := WithCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"event":1}`)
+		flusher.Flush()
+	}), DefaultCompressionOptions)
+
+	newReq := httptest.NewRequest(http.MethodGet, "/test/stream", nil)
+	newReq.Header.Set("Accept-Encoding", "gzip")
+	respRec := httptest.NewRecorder()
+
+	handler.ServeHTTP(respRec, newReq)
+
+	if !respRec.Flushed || respRec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("handler gave incorrect results, want: flushed gzip response, got: flushed %v, encoding %v", respRec.Flushed, respRec.Header().Get("Content-Encoding"))
+	}
+
+	gz, err := gzip.NewReader(respRec.Body)
+	if err != nil {
+		t.Fatal("could not decompress the response body: " + err.Error())
+	}
+	gotBody, err := io.ReadAll(gz)
+	if err != nil || string(gotBody) != `{"event":1}` {
+		t.Errorf("handler gave incorrect body, want: %v, got: %v (%v)", `{"event":1}`, string(gotBody), err)
+	}
+}
+
+func TestServe(t *testing.T) {
+
+	// a handler which waits to be released, so a request can be in flight while the server shuts down
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/test/slow" {
+			close(inFlight)
+			<-release
+		}
+		fmt.Fprint(w, "ok")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("could not listen on an ephemeral port: " + err.Error())
+	}
+	baseURL := "http://" + listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- Serve(ctx, listener, handler)
+	}()
+
+	resp, err := http.Get(baseURL + "/test/fast")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("the server should have served the request, got: %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	// the request in flight when the context is done still gets its response
+	slowResp := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(baseURL + "/test/slow")
+		if err != nil {
+			slowResp <- nil
+			return
+		}
+		slowResp <- resp
+	}()
+	<-inFlight
+
+	cancel()
+	time.Sleep(50 * time.Millisecond) // give the shutdown time to start
+	close(release)
+
+	if resp := <-slowResp; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("the request in flight should have been served, got: %v", resp)
+	} else {
+		resp.Body.Close()
+	}
+
+	select {
+	case err = <-serveErr:
+		if err != nil {
+			t.Errorf("Serve() should return nil after a shutdown, got: %v", err)
+		}
+	case <-time.After(constants.ShutdownTimeoutSeconds * time.Second):
+		t.Fatal("Serve() did not return after the context was done")
+	}
+
+	// the server no longer takes requests
+	resp, err = http.Get(baseURL + "/test/fast")
+	if err == nil {
+		resp.Body.Close()
+		t.Errorf("the server should not take requests after a shutdown, got: %v", resp.StatusCode)
+	}
+}
+
+func TestWithAccessLog(t *testing.T) {
+
+	output := &bytes.Buffer{}
+	defaultOutput := accessLogOutput
+	accessLogOutput = output
+	defer func() {
+		accessLogOutput = defaultOutput
+		SetAccessLogSampleRate(constants.AccessLogSampleRate)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /gameplay/result", func(w http.ResponseWriter, r *http.Request) {
+		// the handler still gets the whole body
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	})
+	mux.HandleFunc("GET /profile/player-data/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "error: data service unavailable", http.StatusServiceUnavailable)
+	})
+	handler := WithAccessLog("test", mux)
+
+	tests := []struct {
+		name       string
+		sampleRate float64
+		method     string
+		path       string
+		body       string
+		wantLogged bool
+		wantFields []string
+	}{
+		{"sampled", 1, http.MethodPost, "/gameplay/result", `{"playerID":"player1","rolls":[1,2]}`, true, []string{`route="POST /gameplay/result"`, "status=200", `player="player1"`, "session=" + SessionHash("session1")}},
+		{"not sampled", 0, http.MethodPost, "/gameplay/result", `{"playerID":"player1"}`, false, nil},
+		{"server error, not sampled", 0, http.MethodGet, "/profile/player-data/player2", "", true, []string{`route="GET /profile/player-data/{id}"`, "status=503", `id="player2"`}},
+		{"unmatched route", 1, http.MethodGet, "/missing", "", true, []string{`route="unmatched"`, "status=404"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			output.Reset()
+			SetAccessLogSampleRate(test.sampleRate)
+
+			newReq := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			newReq.Header.Set("Session-Id", "session1")
+			respRec := httptest.NewRecorder()
+
+			handler.ServeHTTP(respRec, newReq)
+
+			if test.body != "" && respRec.Body.String() != test.body {
+				t.Errorf("the handler got an incorrect body, want: %v, got: %v", test.body, respRec.Body.String())
+			}
+
+			gotLine := output.String()
+			if (gotLine != "") != test.wantLogged {
+				t.Fatalf("the access log gave incorrect results, want logged: %v, got: %q", test.wantLogged, gotLine)
+			}
+
+			for _, field := range test.wantFields {
+				if !strings.Contains(gotLine, field) {
+					t.Errorf("the access log line is missing %v, got: %q", field, gotLine)
+				}
+			}
+
+			if strings.Contains(gotLine, "session1") {
+				t.Errorf("the access log line should not have the session id, got: %q", gotLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SLOTarget is the service level objective of a route: at least SuccessRate of its requests should succeed
+// (not respond with a 5xx), and at least LatencyRate of them should be handled within LatencyMillis.
+// A LatencyMillis of 0 leaves out the latency objective (used for streaming routes)
+type SLOTarget struct {
+	SuccessRate   float64 `json:"successRate"`
+	LatencyMillis int64   `json:"latencyMillis"`
+	LatencyRate   float64 `json:"latencyRate"`
+}
+
+// DefaultSLOTarget is the SLO of the routes which do not have one of their own in SLOTargets
+var DefaultSLOTarget = SLOTarget{SuccessRate: 0.99, LatencyMillis: 500, LatencyRate: 0.99}
+
+// SLOTargets are the SLOs of specific routes (keyed by their route pattern), overriding the default one
+var SLOTargets = map[string]SLOTarget{
+	"POST /gameplay/result":           {SuccessRate: 0.995, LatencyMillis: 250, LatencyRate: 0.99},
+	"POST /gameplay/entry":            {SuccessRate: 0.995, LatencyMillis: 250, LatencyRate: 0.99},
+	"POST /auth/login":                {SuccessRate: 0.995, LatencyMillis: 300, LatencyRate: 0.99},
+	"GET /profile/energy-events/{id}": {SuccessRate: 0.99},
+}
+
+// sloTargetOf returns the SLO of the given route
+func sloTargetOf(route string) SLOTarget {
+	if target, ok := SLOTargets[route]; ok {
+		return target
+	}
+	return DefaultSLOTarget
+}
+
+// RouteSLO is the state of the SLO of a single route over the rolling window, with the objectives it breaches
+// ("success-rate" and / or "latency")
+type RouteSLO struct {
+	Route       string    `json:"route"`
+	Target      SLOTarget `json:"target"`
+	Requests    int64     `json:"requests"`
+	SuccessRate float64   `json:"successRate"`
+	LatencyRate float64   `json:"latencyRate"`
+	Breaches    []string  `json:"breaches,omitempty"`
+}
+
+// ServiceSLO is the state of the SLOs of all the routes of a service which had requests in the rolling window
+type ServiceSLO struct {
+	Service       string     `json:"service"`
+	WindowMinutes int        `json:"windowMinutes"`
+	Routes        []RouteSLO `json:"routes"`
+}
+
+// sloBucket counts the requests of a route in a single minute
+type sloBucket struct {
+	minute   int64
+	requests int64
+	failures int64
+	slow     int64
+}
+
+// routeWindow holds the per minute buckets of a route for the rolling window, a bucket is reused once its minute is
+// out of the window
+type routeWindow struct {
+	buckets [constants.SLOWindowMinutes]sloBucket
+}
+
+// serviceSLOWindows holds the rolling windows of the routes of a single service
+type serviceSLOWindows struct {
+	mutex  sync.Mutex
+	routes map[string]*routeWindow
+}
+
+// recordRequest adds a request to the given route with the given status code and latency, handled at the given time
+func (sw *serviceSLOWindows) recordRequest(route string, status int, latency time.Duration, now time.Time) {
+
+	target := sloTargetOf(route)
+	minute := now.Unix() / 60
+
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	window, ok := sw.routes[route]
+	if !ok {
+		window = &routeWindow{}
+		sw.routes[route] = window
+	}
+
+	bucket := &window.buckets[minute%constants.SLOWindowMinutes]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+
+	bucket.requests++
+	if status >= http.StatusInternalServerError {
+		bucket.failures++
+	}
+	if target.LatencyMillis > 0 && latency > time.Duration(target.LatencyMillis)*time.Millisecond {
+		bucket.slow++
+	}
+}
+
+// current returns the SLOs of the routes with requests in the rolling window ending at the given time, sorted by route
+func (sw *serviceSLOWindows) current(service string, now time.Time) *ServiceSLO {
+
+	minute := now.Unix() / 60
+	current := &ServiceSLO{Service: service, WindowMinutes: constants.SLOWindowMinutes, Routes: []RouteSLO{}}
+
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	for route, window := range sw.routes {
+
+		var requests, failures, slow int64
+		for _, bucket := range window.buckets {
+			if minute-bucket.minute < constants.SLOWindowMinutes {
+				requests += bucket.requests
+				failures += bucket.failures
+				slow += bucket.slow
+			}
+		}
+
+		if requests == 0 {
+			continue
+		}
+
+		routeSLO := RouteSLO{
+			Route:       route,
+			Target:      sloTargetOf(route),
+			Requests:    requests,
+			SuccessRate: float64(requests-failures) / float64(requests),
+			LatencyRate: float64(requests-slow) / float64(requests),
+		}
+
+		if requests >= constants.SLOMinRequests {
+			if routeSLO.SuccessRate < routeSLO.Target.SuccessRate {
+				routeSLO.Breaches = append(routeSLO.Breaches, "success-rate")
+			}
+			if routeSLO.Target.LatencyMillis > 0 && routeSLO.LatencyRate < routeSLO.Target.LatencyRate {
+				routeSLO.Breaches = append(routeSLO.Breaches, "latency")
+			}
+		}
+
+		current.Routes = append(current.Routes, routeSLO)
+	}
+
+	slices.SortFunc(current.Routes, func(a, b RouteSLO) int {
+		return cmp.Compare(a.Route, b.Route)
+	})
+
+	return current
+}
+
+// CurrentSLO returns the current state of the SLOs of the routes of the given service
+func CurrentSLO(service string) *ServiceSLO {
+	return serviceStats(service).slo.current(service, time.Now())
+}
+
+// SLOPath returns the path of the internal endpoint serving the SLOs of the given service
+func SLOPath(service string) string {
+	return "/" + service + "/slo-internal"
+}
+
+// MetricsPath is the path every server answers with the metrics of its service, in the Prometheus text format
+const MetricsPath = "/metrics"
+
+// writeMetrics writes the live stats and the SLOs of the given service in the Prometheus text format
+func writeMetrics(w io.Writer, service string) {
+
+	stats := CurrentLiveStats(service)
+	slo := CurrentSLO(service)
+
+	serviceLabel := "service=" + strconv.Quote(service)
+
+	_, _ = fmt.Fprintf(w, "# HELP dice_requests_in_flight The requests the service is currently handling.\n")
+	_, _ = fmt.Fprintf(w, "# TYPE dice_requests_in_flight gauge\n")
+	_, _ = fmt.Fprintf(w, "dice_requests_in_flight{%v} %v\n", serviceLabel, stats.RequestsInFlight)
+
+	metrics := []struct {
+		name  string
+		help  string
+		value func(routeSLO RouteSLO) float64
+	}{
+		{"dice_slo_requests", "The requests of the route in the SLO window.", func(routeSLO RouteSLO) float64 { return float64(routeSLO.Requests) }},
+		{"dice_slo_success_rate", "The rate of requests of the route which did not fail in the SLO window.", func(routeSLO RouteSLO) float64 { return routeSLO.SuccessRate }},
+		{"dice_slo_latency_rate", "The rate of requests of the route handled within the latency target in the SLO window.", func(routeSLO RouteSLO) float64 { return routeSLO.LatencyRate }},
+		{"dice_slo_breached", "Whether the route breaches its SLO (1) or not (0).", func(routeSLO RouteSLO) float64 {
+			if len(routeSLO.Breaches) > 0 {
+				return 1
+			}
+			return 0
+		}},
+	}
+
+	for _, metric := range metrics {
+		_, _ = fmt.Fprintf(w, "# HELP %v %v\n", metric.name, metric.help)
+		_, _ = fmt.Fprintf(w, "# TYPE %v gauge\n", metric.name)
+		for _, routeSLO := range slo.Routes {
+			_, _ = fmt.Fprintf(w, "%v{%v,route=%v} %v\n", metric.name, serviceLabel, strconv.Quote(routeSLO.Route), metric.value(routeSLO))
+		}
+	}
+}
+
+// ReadSLO makes an internal request for the SLOs of the given service, to the server at the given base url
+func ReadSLO(ctx context.Context, baseURL string, service string) (*ServiceSLO, error) {
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, baseURL)
+	defer cancel()
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+SLOPath(service), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal slo request was not successful, status code %v", resp.StatusCode)
+	}
+
+	// decode the response for the slo
+	slo := &ServiceSLO{}
+	err = json.NewDecoder(resp.Body).Decode(slo)
+	if err != nil {
+		return nil, err
+	}
+
+	return slo, nil
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the api versions the public endpoints can be requested with, either via a path prefix (like /v2/gameplay/result),
+// or via the API-Version header on an unprefixed path. Requests which ask for neither are served as version 1
+const (
+	APIVersion1 = 1
+	APIVersion2 = 2
+)
+
+// LatestAPIVersion is the newest api version, requests for older versions get deprecation headers
+const LatestAPIVersion = APIVersion2
+
+// APIVersionHeader is the request header used to ask for an api version on an unprefixed path,
+// it is also sent back on every response to a public endpoint with the version that was served
+const APIVersionHeader = "API-Version"
+
+// apiVersionKey is the context key holding the api version of a request
+type apiVersionKey struct{}
+
+// VersionShaper implementor is a response which changed shape between api versions,
+// so it returns the value to encode for the api version of the request
+type VersionShaper interface {
+	ForAPIVersion(version int) any
+}
+
+// WithVersioning wraps the given handler (usually a server's mux) so that the public endpoints
+// can be requested with a version prefix, which is stripped before routing (so the routes are only registered once),
+// and the negotiated version is stored in the request context (read it with APIVersion).
+// Requests for versions older than the latest get a Deprecation header, and a Link to the latest version of the path.
+// Internal endpoints (paths containing "-internal") are passed through untouched
+func WithVersioning(handler http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if strings.Contains(r.URL.Path, "-internal") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		version, path, err := negotiateVersion(r)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+			return
+		}
+
+		w.Header().Set(APIVersionHeader, strconv.Itoa(version))
+		if version < LatestAPIVersion {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf("</v%v%v>; rel=\"successor-version\"", LatestAPIVersion, path))
+		}
+
+		versioned := r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version))
+		versionedURL := *r.URL
+		versionedURL.Path = path
+		versionedURL.RawPath = ""
+		versioned.URL = &versionedURL
+
+		handler.ServeHTTP(w, versioned)
+
+		// pass the matched route pattern back out (the tracing middleware names its span after it)
+		r.Pattern = versioned.Pattern
+	})
+}
+
+// negotiateVersion returns the api version asked for by the request (the path prefix wins over the header),
+// and the request path without the version prefix
+func negotiateVersion(r *http.Request) (int, string, error) {
+
+	path := r.URL.Path
+
+	if rest, ok := strings.CutPrefix(path, "/v"); ok {
+		prefix, unversionedPath, found := strings.Cut(rest, "/")
+		version, err := strconv.Atoi(prefix)
+		if found && err == nil {
+			if version < APIVersion1 || version > LatestAPIVersion {
+				return 0, "", fmt.Errorf("unsupported api version: %v", version)
+			}
+			return version, "/" + unversionedPath, nil
+		}
+	}
+
+	header := r.Header.Get(APIVersionHeader)
+	if header == "" {
+		return APIVersion1, path, nil
+	}
+
+	version, err := strconv.Atoi(header)
+	if err != nil || version < APIVersion1 || version > LatestAPIVersion {
+		return 0, "", fmt.Errorf("unsupported api version: %v", header)
+	}
+
+	return version, path, nil
+}
+
+// APIVersion returns the api version negotiated for the given request,
+// requests which did not pass through the versioning middleware are version 1
+func APIVersion(r *http.Request) int {
+	if version, ok := r.Context().Value(apiVersionKey{}).(int); ok {
+		return version
+	}
+	return APIVersion1
+}
+
+// ShapeResponse returns the value to encode for the given response,
+// which is shaped for the api version of the request if the response implements VersionShaper
+func ShapeResponse(r *http.Request, response any) any {
+	if shaper, ok := response.(VersionShaper); ok {
+		return shaper.ForAPIVersion(APIVersion(r))
+	}
+	return response
+}
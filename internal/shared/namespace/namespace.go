@@ -0,0 +1,110 @@
+// Package namespace keeps apart the data of the environments (dev / staging / prod) or game titles sharing one
+// data service deployment: every service runs in a namespace (set in the environment), which is sent along with
+// its internal requests, and the data service keys all the data it stores by namespace
+package namespace
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// Header is the request header carrying the namespace of an internal request
+const Header = "Dice-Namespace"
+
+// maxLength is the maximum length of a namespace
+const maxLength = 32
+
+// current holds the namespace of the running service (the default, blank namespace unless set)
+var current atomic.Value
+
+// contextKey is the key of the namespace of a request in its context
+type contextKey struct{}
+
+func init() {
+	current.Store("")
+}
+
+// Validate checks that the given namespace is at most 32 characters long, and only has lowercase letters,
+// digits, dashes and underscores (so it can be used in keys and file names as it is), the blank namespace is valid
+func Validate(namespace string) error {
+
+	if len(namespace) > maxLength {
+		return fmt.Errorf("namespace %q is longer than %v characters", namespace, maxLength)
+	}
+
+	for _, char := range namespace {
+		if (char < 'a' || char > 'z') && (char < '0' || char > '9') && char != '-' && char != '_' {
+			return fmt.Errorf("namespace %q can only have lowercase letters, digits, dashes and underscores", namespace)
+		}
+	}
+
+	return nil
+}
+
+// EnableFromEnv sets the namespace of the running service to the one in the environment
+// (if it is set, see constants.NamespaceEnvVar)
+func EnableFromEnv() error {
+
+	namespace := os.Getenv(constants.NamespaceEnvVar)
+	if namespace == "" {
+		return nil
+	}
+
+	return Set(namespace)
+}
+
+// Set sets the namespace of the running service
+func Set(namespace string) error {
+
+	err := Validate(namespace)
+	if err != nil {
+		return err
+	}
+
+	current.Store(namespace)
+	return nil
+}
+
+// Current returns the namespace of the running service
+func Current() string {
+	return current.Load().(string)
+}
+
+// NewContext returns a copy of the given context which holds the given namespace
+func NewContext(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, contextKey{}, namespace)
+}
+
+// FromContext returns the namespace held by the given context (the one of the internal request being handled),
+// or the namespace of the running service if there is none
+func FromContext(ctx context.Context) string {
+
+	if namespace, ok := ctx.Value(contextKey{}).(string); ok {
+		return namespace
+	}
+	return Current()
+}
+
+// Transport is an http round tripper which adds the namespace (see FromContext) to every outgoing (internal) request
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	namespace := FromContext(req.Context())
+	if namespace == "" {
+		return t.Base.RoundTrip(req)
+	}
+
+	// round trippers should not modify the original request
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, namespace)
+
+	return t.Base.RoundTrip(req)
+}
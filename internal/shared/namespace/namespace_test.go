@@ -0,0 +1,115 @@
+package namespace
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		namespace string
+		wantErr   bool
+	}{
+		{"default namespace", "", false},
+		{"environment", "staging", false},
+		{"title id", "dice-2_eu", false},
+		{"uppercase", "Staging", true},
+		{"path separator", "../prod", true},
+		{"too long", strings.Repeat("a", maxLength+1), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.namespace)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Validate() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEnableFromEnv(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		envNamespace  string
+		wantErr       bool
+		wantNamespace string
+	}{
+		{"not set", "", false, ""},
+		{"valid namespace", "staging", false, "staging"},
+		{"invalid namespace", "Prod", true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			t.Setenv(constants.NamespaceEnvVar, test.envNamespace)
+			current.Store("")
+			defer current.Store("")
+
+			err := EnableFromEnv()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("EnableFromEnv() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			if Current() != test.wantNamespace {
+				t.Errorf("EnableFromEnv() gave incorrect results, want: %v, got: %v", test.wantNamespace, Current())
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(Header)))
+	}))
+	defer server.Close()
+
+	err := Set("prod")
+	if err != nil {
+		t.Fatal("namespace setup error: " + err.Error())
+	}
+	defer current.Store("")
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		wantNamespace string
+	}{
+		{"namespace of the service", context.Background(), "prod"},
+		{"namespace of the request", NewContext(context.Background(), "staging"), "staging"},
+	}
+
+	client := &http.Client{Transport: &Transport{Base: http.DefaultTransport}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			req, reqErr := http.NewRequestWithContext(test.ctx, http.MethodGet, server.URL, nil)
+			if reqErr != nil {
+				t.Fatal("request error: " + reqErr.Error())
+			}
+
+			resp, reqErr := client.Do(req)
+			if reqErr != nil {
+				t.Fatal("request error: " + reqErr.Error())
+			}
+			defer resp.Body.Close()
+
+			body := &strings.Builder{}
+			_, _ = io.Copy(body, resp.Body)
+			if body.String() != test.wantNamespace {
+				t.Errorf("transport gave incorrect results, want: %v, got: %v", test.wantNamespace, body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Package pagination is shared by all the list endpoints of the backend, so they page through their entries the same way:
+// the 'limit' query parameter sets the page size (DefaultLimit if it is not set, at most MaxLimit), the 'order' query
+// parameter picks ascending or descending order (each listing has its own default), and the 'cursor' query parameter
+// is the (opaque) nextCursor of the previous page, which is left out on the last page
+package pagination
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// page size limits
+const DefaultLimit = 100
+const MaxLimit = 1000
+
+// the orders of a listing, by the keys of its entries
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// InvalidCursorError is returned for a cursor which was not given as the next cursor of a page of the same listing
+var InvalidCursorError = fmt.Errorf("invalid cursor")
+
+// Request picks a page of a listing, blank / zero fields fall back to the defaults (the first page of DefaultLimit
+// entries in the default order of the listing)
+type Request struct {
+	Cursor string
+	Limit  int
+	Order  string
+}
+
+// ParseRequest reads a page request from the 'cursor', 'limit' and 'order' url query values
+func ParseRequest(values url.Values) (Request, error) {
+
+	request := Request{
+		Cursor: values.Get("cursor"),
+		Order:  values.Get("order"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		request.Limit, err = strconv.Atoi(limit)
+		if err != nil || request.Limit < 0 {
+			return Request{}, fmt.Errorf("invalid limit: %v, it should be a number between 1 and %v", limit, MaxLimit)
+		}
+	}
+
+	if request.Order != "" && request.Order != OrderAsc && request.Order != OrderDesc {
+		return Request{}, fmt.Errorf("invalid order: %v, it should be %v or %v", request.Order, OrderAsc, OrderDesc)
+	}
+
+	return request, nil
+}
+
+// Values returns the url query values of the page request (used by the http clients)
+func (request Request) Values() url.Values {
+	values := url.Values{}
+	request.AddTo(values)
+	return values
+}
+
+// AddTo adds the url query values of the page request to the given values (along with the filters of a listing)
+func (request Request) AddTo(values url.Values) {
+	if request.Cursor != "" {
+		values.Set("cursor", request.Cursor)
+	}
+	if request.Limit != 0 {
+		values.Set("limit", strconv.Itoa(request.Limit))
+	}
+	if request.Order != "" {
+		values.Set("order", request.Order)
+	}
+}
+
+// WithDefaultOrder returns the page request with the given order if it does not ask for one
+func (request Request) WithDefaultOrder(order string) Request {
+	if request.Order == "" {
+		request.Order = order
+	}
+	return request
+}
+
+// limit returns the page size asked for, within the limits
+func (request Request) limit() int {
+	if request.Limit <= 0 {
+		return DefaultLimit
+	}
+	return min(request.Limit, MaxLimit)
+}
+
+// EncodeCursor returns the (opaque) cursor of the page which starts after the entry with the given key
+func EncodeCursor[K any](key K) (string, error) {
+
+	keyBytes, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(keyBytes), nil
+}
+
+// DecodeCursor returns the key of the entry the page of the given cursor starts after
+func DecodeCursor[K any](cursor string) (K, error) {
+
+	var key K
+
+	keyBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return key, InvalidCursorError
+	}
+
+	err = json.Unmarshal(keyBytes, &key)
+	if err != nil {
+		return key, InvalidCursorError
+	}
+	return key, nil
+}
+
+// Paginate returns the entries of the requested page, and the cursor of the next page (blank on the last page).
+// The entries should be sorted in ascending order of their keys (as given by keyOf, and compared by compare),
+// and the keys should be unique, so a page starts right after the entry of the cursor even if entries
+// were added or removed since the previous page was read (descending pages are read from the end)
+func Paginate[T any, K any](entries []T, request Request, keyOf func(T) K, compare func(a, b K) int) ([]T, string, error) {
+
+	descending := request.Order == OrderDesc
+	limit := request.limit()
+
+	hasCursor := request.Cursor != ""
+	var afterKey K
+	if hasCursor {
+		var err error
+		afterKey, err = DecodeCursor[K](request.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	page := []T{}
+	for i := range entries {
+
+		entry := entries[i]
+		if descending {
+			entry = entries[len(entries)-1-i]
+		}
+
+		if hasCursor {
+			order := compare(keyOf(entry), afterKey)
+			if (!descending && order <= 0) || (descending && order >= 0) {
+				continue
+			}
+		}
+
+		if len(page) == limit {
+			// there are more entries after this page
+			nextCursor, err := EncodeCursor(keyOf(page[len(page)-1]))
+			return page, nextCursor, err
+		}
+
+		page = append(page, entry)
+	}
+
+	return page, "", nil
+}
+
+// PaginateHistory is Paginate for an append-only history, where the entries are keyed by their position
+// (from 1, in the order they were appended), so a cursor stays valid as new entries are appended
+func PaginateHistory[T any](entries []T, request Request) ([]T, string, error) {
+
+	positions := make([]int, len(entries))
+	for i := range positions {
+		positions[i] = i + 1
+	}
+
+	pagePositions, nextCursor, err := Paginate(positions, request, func(position int) int { return position }, cmp.Compare[int])
+	if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]T, 0, len(pagePositions))
+	for _, position := range pagePositions {
+		page = append(page, entries[position-1])
+	}
+
+	return page, nextCursor, nil
+}
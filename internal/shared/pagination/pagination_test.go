@@ -0,0 +1,167 @@
+package pagination
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		query       string
+		wantRequest Request
+		wantErr     bool
+	}{
+		{"no query", "", Request{}, false},
+		{"all values", "cursor=abc&limit=5&order=desc", Request{Cursor: "abc", Limit: 5, Order: OrderDesc}, false},
+		{"zero limit", "limit=0", Request{}, false},
+		{"limit above max", "limit=5000", Request{Limit: 5000}, false},
+		{"negative limit", "limit=-1", Request{}, true},
+		{"limit not a number", "limit=ten", Request{}, true},
+		{"invalid order", "order=up", Request{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			values, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal("could not parse the query: " + err.Error())
+			}
+
+			gotRequest, err := ParseRequest(values)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseRequest() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			if gotRequest != test.wantRequest {
+				t.Errorf("ParseRequest() gave incorrect results, want: %v, got: %v", test.wantRequest, gotRequest)
+			}
+
+			// the values of a parsed request parse back to the same request
+			if roundTrip, _ := ParseRequest(gotRequest.Values()); roundTrip != gotRequest {
+				t.Errorf("Values() gave incorrect results, want: %v, got: %v", gotRequest, roundTrip)
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+
+	entries := []string{"a", "b", "c", "d", "e"}
+	keyOf := func(entry string) string { return entry }
+
+	cursorOf := func(key string) string {
+		cursor, err := EncodeCursor(key)
+		if err != nil {
+			t.Fatal("could not encode the cursor: " + err.Error())
+		}
+		return cursor
+	}
+
+	tests := []struct {
+		name           string
+		request        Request
+		wantPage       []string
+		wantNextCursor string
+		wantErr        bool
+	}{
+		{"default request", Request{}, entries, "", false},
+		{"first page", Request{Limit: 2}, []string{"a", "b"}, cursorOf("b"), false},
+		{"middle page", Request{Cursor: cursorOf("b"), Limit: 2}, []string{"c", "d"}, cursorOf("d"), false},
+		{"last page", Request{Cursor: cursorOf("d"), Limit: 2}, []string{"e"}, "", false},
+		{"exactly the last page", Request{Cursor: cursorOf("c"), Limit: 2}, []string{"d", "e"}, "", false},
+		{"cursor of a removed entry", Request{Cursor: cursorOf("bb"), Limit: 2}, []string{"c", "d"}, cursorOf("d"), false},
+		{"descending first page", Request{Limit: 2, Order: OrderDesc}, []string{"e", "d"}, cursorOf("d"), false},
+		{"descending last page", Request{Cursor: cursorOf("b"), Order: OrderDesc}, []string{"a"}, "", false},
+		{"invalid cursor", Request{Cursor: "not a cursor"}, nil, "", true},
+		{"cursor of another listing", Request{Cursor: func() string {
+			cursor, _ := EncodeCursor(struct{}{})
+			return cursor
+		}()}, nil, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotPage, gotNextCursor, err := Paginate(entries, test.request, keyOf, strings.Compare)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Paginate() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(gotPage, test.wantPage) {
+				t.Errorf("Paginate() gave incorrect results, want: %v, got: %v", test.wantPage, gotPage)
+			}
+
+			if gotNextCursor != test.wantNextCursor {
+				t.Errorf("Paginate() gave incorrect results, want next cursor: %q, got: %q", test.wantNextCursor, gotNextCursor)
+			}
+		})
+	}
+}
+
+func TestPaginate_Limits(t *testing.T) {
+
+	entries := make([]int, MaxLimit+1)
+	keyOf := func(entry int) int { return entry }
+	for i := range entries {
+		entries[i] = i
+	}
+
+	tests := []struct {
+		name     string
+		request  Request
+		wantSize int
+	}{
+		{"default limit", Request{}, DefaultLimit},
+		{"within the max limit", Request{Limit: 10}, 10},
+		{"above the max limit", Request{Limit: MaxLimit + 1}, MaxLimit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotPage, _, err := Paginate(entries, test.request, keyOf, func(a, b int) int { return a - b })
+			if err != nil {
+				t.Fatal("Paginate() gave an unexpected error: " + err.Error())
+			}
+
+			if len(gotPage) != test.wantSize {
+				t.Errorf("Paginate() gave incorrect results, want: %v entries, got: %v", test.wantSize, len(gotPage))
+			}
+		})
+	}
+}
+
+func TestPaginateHistory(t *testing.T) {
+
+	history := []string{"first", "second", "first"}
+
+	firstPage, nextCursor, err := PaginateHistory(history, Request{Limit: 2})
+	if err != nil {
+		t.Fatal("PaginateHistory() gave an unexpected error: " + err.Error())
+	}
+
+	if !reflect.DeepEqual(firstPage, []string{"first", "second"}) || nextCursor == "" {
+		t.Fatalf("PaginateHistory() gave incorrect results, got: %v, next cursor: %q", firstPage, nextCursor)
+	}
+
+	// entries appended after the first page was read show up on the next page
+	history = append(history, "fourth")
+
+	secondPage, nextCursor, err := PaginateHistory(history, Request{Cursor: nextCursor, Limit: 2})
+	if err != nil {
+		t.Fatal("PaginateHistory() gave an unexpected error: " + err.Error())
+	}
+
+	if !reflect.DeepEqual(secondPage, []string{"first", "fourth"}) || nextCursor != "" {
+		t.Errorf("PaginateHistory() gave incorrect results, got: %v, next cursor: %q", secondPage, nextCursor)
+	}
+}
@@ -0,0 +1,59 @@
+// Package playerctx carries the identity of the request being handled (the player id and the session id of its
+// session, and its request id) in its context, so the functions it reaches do not need them passed along as parameters
+package playerctx
+
+import (
+	"context"
+	"fmt"
+)
+
+// the context keys, typed so they cannot collide with the keys of other packages
+type playerIDKey struct{}
+type sessionIDKey struct{}
+type requestIDKey struct{}
+
+// MissingPlayerIDError is returned by the functions which act on the player of the context, when it has none
+var MissingPlayerIDError = fmt.Errorf("no player id in the context")
+
+// WithPlayerID returns a copy of the given context which holds the given player id
+func WithPlayerID(ctx context.Context, playerID string) context.Context {
+	return context.WithValue(ctx, playerIDKey{}, playerID)
+}
+
+// PlayerID returns the player id the given context holds, and whether it holds one
+func PlayerID(ctx context.Context) (string, bool) {
+	playerID, ok := ctx.Value(playerIDKey{}).(string)
+	return playerID, ok && playerID != ""
+}
+
+// RequirePlayerID returns the player id the given context holds, or MissingPlayerIDError if it holds none
+func RequirePlayerID(ctx context.Context) (string, error) {
+
+	playerID, ok := PlayerID(ctx)
+	if !ok {
+		return "", MissingPlayerIDError
+	}
+	return playerID, nil
+}
+
+// WithSessionID returns a copy of the given context which holds the given session id
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, sessionID)
+}
+
+// SessionID returns the session id the given context holds, and whether it holds one
+func SessionID(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionIDKey{}).(string)
+	return sessionID, ok && sessionID != ""
+}
+
+// WithRequestID returns a copy of the given context which holds the given request id
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request id the given context holds, and whether it holds one
+func RequestID(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey{}).(string)
+	return requestID, ok && requestID != ""
+}
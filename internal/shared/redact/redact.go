@@ -0,0 +1,55 @@
+// Package redact removes credentials (authorization headers, passwords, secrets and tokens) from text before it is
+// logged, all the service loggers write through it (see Stdout), so a credential which ends up in a log message
+// (like in an error from a failed request) does not end up in the logs
+package redact
+
+import (
+	"io"
+	"os"
+	"regexp"
+)
+
+// Placeholder replaces every credential found
+const Placeholder = "[REDACTED]"
+
+// schemeCredential matches the credentials of an authorization header value, like 'Basic dXNyOnB3ZA=='
+var schemeCredential = regexp.MustCompile(`(?i)\b(basic|bearer)\s+[A-Za-z0-9+/=._~-]+`)
+
+// sensitiveKeys are the (case insensitive) keys of the fields whose values are redacted
+const sensitiveKeys = `(?:password|passwd|pwd|secret|authorization|idtoken|accesstoken|refreshtoken|twofactorcode)`
+
+// quotedField and plainField match the value of a sensitive field, as a quoted (like json) or a plain value,
+// like '"password":"hunter2"', 'Authorization: Basic dXNyOnB3ZA==' or 'secret=abc'
+var quotedField = regexp.MustCompile(`(?i)("?\b` + sensitiveKeys + `"?\s*[:=]\s*")[^"]*"`)
+var plainField = regexp.MustCompile(`(?i)("?\b` + sensitiveKeys + `"?\s*[:=]\s*)(?:(?:basic|bearer)\s+)?[^\s,;&"]+`)
+
+// String returns the given text with every credential in it replaced by the placeholder
+func String(text string) string {
+	text = quotedField.ReplaceAllString(text, "${1}"+Placeholder+`"`)
+	text = plainField.ReplaceAllString(text, "${1}"+Placeholder)
+	return schemeCredential.ReplaceAllString(text, "${1} "+Placeholder)
+}
+
+// writer redacts everything written to it before passing it on
+type writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a writer which redacts everything written to it (see String) before writing it to the given writer,
+// a logger writes each message with a single write, so credentials are never split across writes
+func NewWriter(w io.Writer) io.Writer {
+	return &writer{w: w}
+}
+
+func (rw *writer) Write(p []byte) (int, error) {
+
+	_, err := rw.w.Write([]byte(String(string(p))))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Stdout is the redacting writer used by the service loggers
+var Stdout = NewWriter(os.Stdout)
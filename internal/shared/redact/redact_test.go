@@ -0,0 +1,48 @@
+package redact
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"nothing to redact", "received login request for player id: abc", "received login request for player id: abc"},
+		{"basic credentials", "header: Basic dXNyOnB3ZA==", "header: Basic [REDACTED]"},
+		{"authorization header", "Authorization: Basic dXNyOnB3ZA== sent", "Authorization: [REDACTED] sent"},
+		{"bearer token", "calling with bearer abc.def.ghi", "calling with bearer [REDACTED]"},
+		{"json password", `body: {"username":"usr","password":"hunter 2"}`, `body: {"username":"usr","password":"[REDACTED]"}`},
+		{"key value secret", "url?secret=abc&x=1", "url?secret=[REDACTED]&x=1"},
+		{"id token", `{"provider":"google","idToken":"eyJ.abc"}`, `{"provider":"google","idToken":"[REDACTED]"}`},
+		{"two factor code", "twoFactorCode: 123456, device: phone", "twoFactorCode: [REDACTED], device: phone"},
+		{"unrelated token words", "invalid entry token, status code 401", "invalid entry token, status code 401"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := String(test.text)
+			if got != test.want {
+				t.Errorf("String() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+
+	buffer := &bytes.Buffer{}
+	logger := log.New(NewWriter(buffer), "auth: ", 0)
+
+	logger.Printf("could not decode %v", `{"password":"hunter2"}`)
+
+	want := "auth: could not decode {\"password\":\"[REDACTED]\"}\n"
+	if buffer.String() != want {
+		t.Errorf("the logger wrote incorrect results, want: %q, got: %q", want, buffer.String())
+	}
+}
@@ -0,0 +1,94 @@
+package rng
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+// serverSeedLength is the length (in bytes) of a generated server seed
+const serverSeedLength = 32
+
+// FairRoller generates provably fair dice rolls (commit / reveal):
+// the server seed is generated up front, and only its hash (the commitment) is published to the player (say at level entry).
+// Every roll is derived from the server seed, the seed of the player (the client seed) and the number of the roll (the nonce),
+// and once the rolls are done the server seed is revealed (say in the result response), so the player can check that
+// it matches the commitment, and that it produces the same rolls (see VerifyRolls), i.e. the rolls were not rigged
+type FairRoller struct {
+	serverSeed []byte
+	clientSeed string
+}
+
+// NewFairRoller returns an initialized pointer to a fair roller with a new random server seed, and the given client seed
+func NewFairRoller(clientSeed string) *FairRoller {
+
+	serverSeed := make([]byte, serverSeedLength)
+	_, _ = rand.Read(serverSeed) // never returns an error
+
+	return &FairRoller{serverSeed: serverSeed, clientSeed: clientSeed}
+}
+
+// Commitment returns the hash of the server seed (hex encoded sha256), which is published before the rolls
+func (fr *FairRoller) Commitment() string {
+	return commitment(fr.serverSeed)
+}
+
+// ServerSeed returns the server seed (hex encoded), which is revealed after the rolls
+func (fr *FairRoller) ServerSeed() string {
+	return hex.EncodeToString(fr.serverSeed)
+}
+
+// Roll returns the roll with the given nonce (the sum of the faces of the given number of dice with the given sides)
+func (fr *FairRoller) Roll(nonce int, sides int32, count int32) int32 {
+	return fairRoll(fr.serverSeed, fr.clientSeed, nonce, sides, count)
+}
+
+// VerifyRolls checks that the revealed server seed (hex encoded) matches the commitment published before the rolls,
+// and that the rolls (nonces 0, 1, 2...) are the ones derived from it and the client seed
+func VerifyRolls(serverSeedHex string, commitmentHex string, clientSeed string, sides int32, count int32, rolls []int32) error {
+
+	serverSeed, err := hex.DecodeString(serverSeedHex)
+	if err != nil {
+		return fmt.Errorf("malformed server seed: %v", err)
+	}
+
+	if !hmac.Equal([]byte(commitment(serverSeed)), []byte(commitmentHex)) {
+		return fmt.Errorf("the server seed does not match the commitment")
+	}
+
+	for nonce, roll := range rolls {
+		want := fairRoll(serverSeed, clientSeed, nonce, sides, count)
+		if roll != want {
+			return fmt.Errorf("roll %v should be %v, got: %v", nonce, want, roll)
+		}
+	}
+
+	return nil
+}
+
+// commitment returns the hex encoded sha256 of the given server seed
+func commitment(serverSeed []byte) string {
+	sum := sha256.Sum256(serverSeed)
+	return hex.EncodeToString(sum[:])
+}
+
+// fairRoll derives a roll from the hmac sha256 (keyed with the server seed) of "clientSeed:nonce",
+// each die uses the next 4 bytes of the hmac, so at most 8 dice can be rolled at once.
+// The tiny modulo bias (below 1 in 2^28 for the usual dice) is accepted, to keep the scheme easy to verify by hand
+func fairRoll(serverSeed []byte, clientSeed string, nonce int, sides int32, count int32) int32 {
+
+	mac := hmac.New(sha256.New, serverSeed)
+	mac.Write([]byte(clientSeed + ":" + strconv.Itoa(nonce)))
+	sum := mac.Sum(nil)
+
+	roll := int32(0)
+	for die := int32(0); die < count && die < int32(len(sum)/4); die++ {
+		value := binary.BigEndian.Uint32(sum[die*4 : die*4+4])
+		roll += int32(value%uint32(sides)) + 1
+	}
+	return roll
+}
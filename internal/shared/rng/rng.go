@@ -0,0 +1,62 @@
+// Package rng provides the random number generators used for server side dice: one backed by crypto/rand (the default),
+// and a seeded one (reproducible, for load tests and debugging). It also implements a provably fair scheme for server rolls,
+// see FairRoller
+package rng
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	mathrand "math/rand/v2"
+	"sync"
+)
+
+// RNG implementor can generate uniformly distributed random numbers
+type RNG interface {
+	// Int32N returns a random number in [0, n), n should be greater than 0
+	Int32N(n int32) int32
+}
+
+// cryptoSource is a math/rand source which reads from crypto/rand
+type cryptoSource struct{}
+
+// Uint64 implements mathrand.Source
+func (cryptoSource) Uint64() uint64 {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf) // never returns an error
+	return binary.LittleEndian.Uint64(buf)
+}
+
+// CryptoRNG is the RNG backed by crypto/rand, safe for concurrent use
+type CryptoRNG struct {
+	random *mathrand.Rand
+}
+
+// NewCryptoRNG returns an initialized pointer to a crypto/rand backed RNG
+func NewCryptoRNG() *CryptoRNG {
+	return &CryptoRNG{random: mathrand.New(cryptoSource{})}
+}
+
+// Int32N implements RNG
+func (cr *CryptoRNG) Int32N(n int32) int32 {
+	return cr.random.Int32N(n)
+}
+
+// SeededRNG is the RNG backed by a PCG generator with a fixed seed, so it produces the same sequence every time,
+// safe for concurrent use (but then the order of the numbers between the users depends on timing)
+type SeededRNG struct {
+	random *mathrand.Rand
+	mutex  sync.Mutex
+}
+
+// NewSeededRNG returns an initialized pointer to an RNG seeded with the given seed
+func NewSeededRNG(seed uint64) *SeededRNG {
+	return &SeededRNG{random: mathrand.New(mathrand.NewPCG(seed, seed))}
+}
+
+// Int32N implements RNG
+func (sr *SeededRNG) Int32N(n int32) int32 {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	return sr.random.Int32N(n)
+}
@@ -0,0 +1,106 @@
+package rng
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRNG_Int32N(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		random RNG
+	}{
+		{"crypto", NewCryptoRNG()},
+		{"seeded", NewSeededRNG(42)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seen := map[int32]bool{}
+			for i := 0; i < 1000; i++ {
+				got := test.random.Int32N(6)
+				if got < 0 || got >= 6 {
+					t.Fatalf("rng gave incorrect results, want: [0, 6), got: %v", got)
+				}
+				seen[got] = true
+			}
+			if len(seen) != 6 {
+				t.Errorf("rng gave incorrect results, want: %v distinct values, got: %v", 6, len(seen))
+			}
+		})
+	}
+}
+
+func TestSeededRNG_Reproducible(t *testing.T) {
+
+	sequence := func(seed uint64) []int32 {
+		random := NewSeededRNG(seed)
+		numbers := make([]int32, 10)
+		for i := range numbers {
+			numbers[i] = random.Int32N(1000)
+		}
+		return numbers
+	}
+
+	tests := []struct {
+		name      string
+		seed1     uint64
+		seed2     uint64
+		wantEqual bool
+	}{
+		{"same seed", 7, 7, true},
+		{"different seeds", 7, 8, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotEqual := reflect.DeepEqual(sequence(test.seed1), sequence(test.seed2))
+			if gotEqual != test.wantEqual {
+				t.Errorf("rng gave incorrect results, want: %v, got: %v", test.wantEqual, gotEqual)
+			}
+		})
+	}
+}
+
+func TestVerifyRolls(t *testing.T) {
+
+	roller := NewFairRoller("client-seed")
+
+	rolls := make([]int32, 5)
+	for nonce := range rolls {
+		rolls[nonce] = roller.Roll(nonce, 6, 2)
+		if rolls[nonce] < 2 || rolls[nonce] > 12 {
+			t.Fatalf("roller gave incorrect results, want: [2, 12], got: %v", rolls[nonce])
+		}
+	}
+
+	tamperedRolls := append([]int32{}, rolls...)
+	tamperedRolls[2] = tamperedRolls[2]%12 + 1
+
+	otherRoller := NewFairRoller("client-seed")
+
+	tests := []struct {
+		name       string
+		serverSeed string
+		commitment string
+		clientSeed string
+		rolls      []int32
+		wantErr    bool
+	}{
+		{"malformed server seed", "not hex", roller.Commitment(), "client-seed", rolls, true},
+		{"seed does not match the commitment", otherRoller.ServerSeed(), roller.Commitment(), "client-seed", rolls, true},
+		{"different client seed", roller.ServerSeed(), roller.Commitment(), "other-seed", rolls, true},
+		{"tampered roll", roller.ServerSeed(), roller.Commitment(), "client-seed", tamperedRolls, true},
+		{"fair rolls", roller.ServerSeed(), roller.Commitment(), "client-seed", rolls, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyRolls(test.serverSeed, test.commitment, test.clientSeed, 6, 2, test.rolls)
+			if (err != nil) != test.wantErr {
+				t.Errorf("verify gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,455 @@
+// Package startup has the startup config of each service (its port, request timeout, sweep interval, max energy override,
+// and the addresses of the services it calls), which comes from the defaults, a yaml file, the environment,
+// and the command line flags of its runner (each one overriding the ones before it)
+package startup
+
+import (
+	"errors"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AllServices is the name used for the startup config of the all runner, where every service runs in the same process
+// (on its designated port, calling the other services in process), so only the request timeout and the max energy
+// override can be set there
+const AllServices = "all"
+
+// service holds what the startup config of a single service can have
+type service struct {
+	port          string
+	sweeps        bool // whether the service has a periodic sweep (see Config.SweepIntervalSeconds)
+	usesMaxEnergy bool // whether the service uses the max energy of the game config (see Config.MaxEnergy)
+}
+
+// services are the services which have a startup config, with their designated ports
+var services = map[string]service{
+	"auth":          {port: constants.AuthServerPort, sweeps: true},
+	"data":          {port: constants.DataServerPort},
+	"config":        {port: constants.ConfigServerPort, usesMaxEnergy: true},
+	"profile":       {port: constants.ProfileServerPort, usesMaxEnergy: true},
+	"stats":         {port: constants.StatsServerPort},
+	"gameplay":      {port: constants.GameplayServerPort, usesMaxEnergy: true},
+	"shop":          {port: constants.ShopServerPort},
+	"promo":         {port: constants.PromoServerPort},
+	"match":         {port: constants.MatchServerPort, sweeps: true},
+	"notifications": {port: constants.NotificationsServerPort, sweeps: true, usesMaxEnergy: true},
+	"referral":      {port: constants.ReferralServerPort},
+	"guilds":        {port: constants.GuildsServerPort},
+	"webhooks":      {port: constants.WebhooksServerPort, sweeps: true},
+}
+
+// downstream holds the addresses of the other services set for the running service (see Apply and ServiceURL)
+var downstream = map[string]string{}
+var downstreamMutex sync.RWMutex
+
+// Config is the startup config of a single service
+type Config struct {
+	Service string
+
+	// the port the service listens on
+	Port string
+
+	// how long a request to the service can take before it is answered with a 503 (see middleware.DefaultLimits)
+	RequestTimeoutSeconds int
+
+	// how often the service runs its periodic sweep (like the session sweep of the auth service,
+	// or the match sweep of the match service), 0 keeps the service's own default
+	SweepIntervalSeconds int
+
+	// overrides the max energy of the game config, 0 keeps the one in the game config
+	// (set it to the same value for every service which uses it)
+	MaxEnergy int32
+
+	// the share of requests (from 0 to 1) recorded in the access log of the service (see middleware.WithAccessLog)
+	AccessLogSampleRate float64
+
+	// whether the deadlines of the internal requests of the service follow the latencies of each downstream,
+	// instead of being fixed (see tracing.EnableAdaptiveTimeouts)
+	AdaptiveTimeouts bool
+
+	// the base urls of the services this service calls, by service name
+	Downstream map[string]string
+}
+
+// field is a single (non downstream) value of the startup config, with its key in the yaml file,
+// its name in the environment variables (after the service prefix) and its flag
+type field struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+var fields = []field{
+	{"port", "PORT", "port", "the port the service listens on", func(cfg *Config, value string) error {
+		cfg.Port = value
+		return nil
+	}},
+	{"requestTimeoutSeconds", "REQUEST_TIMEOUT_SECONDS", "request-timeout-seconds", "how long a request can take before it times out", func(cfg *Config, value string) error {
+		return parseInt(value, &cfg.RequestTimeoutSeconds)
+	}},
+	{"sweepIntervalSeconds", "SWEEP_INTERVAL_SECONDS", "sweep-interval-seconds", "how often the periodic sweep of the service runs (0 keeps the default)", func(cfg *Config, value string) error {
+		return parseInt(value, &cfg.SweepIntervalSeconds)
+	}},
+	{"maxEnergy", "MAX_ENERGY", "max-energy", "overrides the max energy of the game config (0 keeps the game config value)", func(cfg *Config, value string) error {
+		maxEnergy := 0
+		err := parseInt(value, &maxEnergy)
+		cfg.MaxEnergy = int32(maxEnergy)
+		return err
+	}},
+	{"accessLogSampleRate", "ACCESS_LOG_SAMPLE_RATE", "access-log-sample-rate", "the share of requests recorded in the access log (from 0 to 1)", func(cfg *Config, value string) error {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("%q should be a number", value)
+		}
+		cfg.AccessLogSampleRate = rate
+		return nil
+	}},
+	{"adaptiveTimeouts", "ADAPTIVE_TIMEOUTS", "adaptive-timeouts", "whether the deadlines of the internal requests adapt to the latencies of each downstream", func(cfg *Config, value string) error {
+		enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q should be true or false", value)
+		}
+		cfg.AdaptiveTimeouts = enabled
+		return nil
+	}},
+}
+
+// the downstream addresses, as name=url pairs (comma separated in the environment, a repeatable flag)
+const downstreamKey = "downstream"
+const downstreamEnv = "DOWNSTREAM"
+
+// configFileEnv is the name of the environment variable (after the service prefix) holding the path of the yaml file
+const configFileEnv = "CONFIG_FILE"
+
+// Defaults returns the default startup config of the given service, which are the values from the constants package
+func Defaults(serviceName string) *Config {
+
+	cfg := &Config{
+		Service:               serviceName,
+		Port:                  services[serviceName].port,
+		RequestTimeoutSeconds: constants.DefaultRequestTimeoutSeconds,
+		AccessLogSampleRate:   constants.AccessLogSampleRate,
+		Downstream:            map[string]string{},
+	}
+
+	if serviceName == AllServices {
+		return cfg
+	}
+
+	for name, other := range services {
+		if name != serviceName {
+			cfg.Downstream[name] = defaultURL(other.port)
+		}
+	}
+
+	return cfg
+}
+
+// Load returns the validated startup config of the given service, starting from its defaults, then the values in
+// the yaml file (from the -config flag, or the DICE_<SERVICE>_CONFIG_FILE environment variable), then the ones in the
+// DICE_<SERVICE>_<FIELD> environment variables, and then the ones in the given command line arguments (flags).
+// It also returns whether the -print-config flag was set, in which case the runner should print the config and exit
+func Load(serviceName string, args []string) (*Config, bool, error) {
+
+	if _, ok := services[serviceName]; !ok && serviceName != AllServices {
+		return nil, false, fmt.Errorf("%q is not a known service", serviceName)
+	}
+
+	cfg := Defaults(serviceName)
+	envPrefix := "DICE_" + strings.ToUpper(serviceName) + "_"
+
+	// the flags are only applied after the file and the environment, so they are collected first
+	flagValues := []func() error{}
+	flagSet := flag.NewFlagSet(serviceName, flag.ContinueOnError)
+
+	configFile := flagSet.String("config", os.Getenv(envPrefix+configFileEnv), "a yaml file with the startup config")
+	printConfig := flagSet.Bool("print-config", false, "print the startup config and exit")
+
+	for _, f := range fields {
+		flagSet.Func(f.flag, f.usage, func(value string) error {
+			flagValues = append(flagValues, func() error { return f.set(cfg, value) })
+			return nil
+		})
+	}
+	flagSet.Func(downstreamKey, "the address of a service this service calls, as name=url (can be repeated)", func(value string) error {
+		flagValues = append(flagValues, func() error { return cfg.setDownstream(value) })
+		return nil
+	})
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid startup flags: %w", err)
+	}
+
+	if *configFile != "" {
+		err = cfg.loadFile(*configFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid startup config file %v: %w", *configFile, err)
+		}
+	}
+
+	for _, f := range fields {
+		value, ok := os.LookupEnv(envPrefix + f.env)
+		if !ok {
+			continue
+		}
+
+		err = f.set(cfg, value)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %v: %w", envPrefix+f.env, err)
+		}
+	}
+
+	if value, ok := os.LookupEnv(envPrefix + downstreamEnv); ok {
+		err = cfg.setDownstream(value)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %v: %w", envPrefix+downstreamEnv, err)
+		}
+	}
+
+	for _, setFlag := range flagValues {
+		err = setFlag()
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid startup flag: %w", err)
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return cfg, *printConfig, nil
+}
+
+// Validate checks every value of the startup config, and returns all the problems it found
+func (cfg *Config) Validate() error {
+
+	if cfg == nil {
+		return fmt.Errorf("provided startup config pointer is nil")
+	}
+
+	problems := []error{}
+	svc, known := services[cfg.Service]
+
+	switch {
+	case cfg.Service == AllServices:
+		if cfg.Port != "" {
+			problems = append(problems, fmt.Errorf("port: the services of the all runner use their designated ports"))
+		}
+		if cfg.SweepIntervalSeconds != 0 {
+			problems = append(problems, fmt.Errorf("sweepIntervalSeconds: cannot be set for all the services at once"))
+		}
+		if len(cfg.Downstream) > 0 {
+			problems = append(problems, fmt.Errorf("downstream: the services of the all runner call each other in process"))
+		}
+
+	case !known:
+		return fmt.Errorf("%q is not a known service", cfg.Service)
+
+	default:
+		port, err := strconv.Atoi(cfg.Port)
+		if err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Errorf("port: %q should be a number from 1 to 65535", cfg.Port))
+		}
+		if cfg.SweepIntervalSeconds != 0 && !svc.sweeps {
+			problems = append(problems, fmt.Errorf("sweepIntervalSeconds: the %v service has no periodic sweep", cfg.Service))
+		}
+	}
+
+	if cfg.RequestTimeoutSeconds < 1 {
+		problems = append(problems, fmt.Errorf("requestTimeoutSeconds: %v should be at least 1", cfg.RequestTimeoutSeconds))
+	}
+
+	if cfg.SweepIntervalSeconds < 0 {
+		problems = append(problems, fmt.Errorf("sweepIntervalSeconds: %v should not be negative", cfg.SweepIntervalSeconds))
+	}
+
+	if cfg.MaxEnergy < 0 {
+		problems = append(problems, fmt.Errorf("maxEnergy: %v should not be negative", cfg.MaxEnergy))
+	} else if cfg.MaxEnergy > 0 && cfg.Service != AllServices && !svc.usesMaxEnergy {
+		problems = append(problems, fmt.Errorf("maxEnergy: the %v service does not use the max energy", cfg.Service))
+	}
+
+	if cfg.AccessLogSampleRate < 0 || cfg.AccessLogSampleRate > 1 {
+		problems = append(problems, fmt.Errorf("accessLogSampleRate: %v should be from 0 to 1", cfg.AccessLogSampleRate))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Downstream)) {
+		if _, ok := services[name]; !ok || name == cfg.Service {
+			problems = append(problems, fmt.Errorf("downstream.%v: not a service this service can call", name))
+			continue
+		}
+
+		address, err := url.Parse(cfg.Downstream[name])
+		if err != nil || (address.Scheme != "http" && address.Scheme != "https") || address.Host == "" {
+			problems = append(problems, fmt.Errorf("downstream.%v: %q should be an absolute http(s) url", name, cfg.Downstream[name]))
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+// SweepPeriod returns the sweep interval as a duration (0 means the service keeps its own default)
+func (cfg *Config) SweepPeriod() time.Duration {
+	return time.Duration(cfg.SweepIntervalSeconds) * time.Second
+}
+
+// String returns the startup config in the yaml format of the config file (so the printed config can be used as one)
+func (cfg *Config) String() string {
+
+	builder := &strings.Builder{}
+	if cfg.Service == AllServices {
+		fmt.Fprintf(builder, "# startup config of the all runner\n")
+	} else {
+		fmt.Fprintf(builder, "# startup config of the %v service\n", cfg.Service)
+		fmt.Fprintf(builder, "port: %q\n", cfg.Port)
+	}
+	fmt.Fprintf(builder, "requestTimeoutSeconds: %v\n", cfg.RequestTimeoutSeconds)
+	if services[cfg.Service].sweeps {
+		fmt.Fprintf(builder, "sweepIntervalSeconds: %v\n", cfg.SweepIntervalSeconds)
+	}
+	fmt.Fprintf(builder, "maxEnergy: %v\n", cfg.MaxEnergy)
+	fmt.Fprintf(builder, "accessLogSampleRate: %v\n", cfg.AccessLogSampleRate)
+	fmt.Fprintf(builder, "adaptiveTimeouts: %v\n", cfg.AdaptiveTimeouts)
+
+	if len(cfg.Downstream) > 0 {
+		fmt.Fprintf(builder, "%v:\n", downstreamKey)
+		for _, name := range slices.Sorted(maps.Keys(cfg.Downstream)) {
+			fmt.Fprintf(builder, "  %v: %v\n", name, cfg.Downstream[name])
+		}
+	}
+
+	return builder.String()
+}
+
+// Apply applies the process wide parts of the startup config: the request timeout of the routes, the access log
+// sample rate, the adaptive internal timeouts, and the addresses the service clients use (see ServiceURL). It also makes the standard logger redact
+// credentials (like the service loggers do). It should be called before the servers and clients are created
+func Apply(cfg *Config) {
+
+	if cfg == nil {
+		return
+	}
+
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
+	middleware.DefaultLimits.Timeout = time.Duration(cfg.RequestTimeoutSeconds) * time.Second
+	middleware.SetAccessLogSampleRate(cfg.AccessLogSampleRate)
+	tracing.EnableAdaptiveTimeouts(cfg.AdaptiveTimeouts)
+
+	downstreamMutex.Lock()
+	defer downstreamMutex.Unlock()
+
+	maps.Copy(downstream, cfg.Downstream)
+}
+
+// ServiceURL returns the base url of the given service, which is the one in the startup config of the running service
+// if it is set there (see Apply), or the service's designated port on the common host otherwise
+func ServiceURL(serviceName string) string {
+
+	downstreamMutex.RLock()
+	defer downstreamMutex.RUnlock()
+
+	if address, ok := downstream[serviceName]; ok {
+		return address
+	}
+
+	return defaultURL(services[serviceName].port)
+}
+
+// defaultURL returns the base url of a service on the given port of the common host
+func defaultURL(port string) string {
+	return fmt.Sprintf("%v://%v:%v", constants.CommonProtocol, constants.CommonHost, port)
+}
+
+// loadFile sets the values in the given yaml file
+func (cfg *Config) loadFile(path string) error {
+
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values, err := parseYAML(string(text))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+
+		if key == downstreamKey {
+			addresses, ok := values[key].(map[string]any)
+			if !ok {
+				return fmt.Errorf("%v: should be a map of service names to urls", key)
+			}
+
+			for _, name := range slices.Sorted(maps.Keys(addresses)) {
+				address, isString := addresses[name].(string)
+				if !isString {
+					return fmt.Errorf("%v.%v: should be a url", key, name)
+				}
+				cfg.Downstream[name] = address
+			}
+			continue
+		}
+
+		index := slices.IndexFunc(fields, func(f field) bool { return f.key == key })
+		if index < 0 {
+			return fmt.Errorf("%q is not a startup config key", key)
+		}
+
+		value, isString := values[key].(string)
+		if !isString {
+			return fmt.Errorf("%v: should be a single value", key)
+		}
+
+		err = fields[index].set(cfg, value)
+		if err != nil {
+			return fmt.Errorf("%v: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// setDownstream sets the addresses in the given comma separated name=url pairs
+func (cfg *Config) setDownstream(pairs string) error {
+
+	for _, pair := range strings.Split(pairs, ",") {
+		name, address, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			return fmt.Errorf("%q should be a name=url pair", pair)
+		}
+		cfg.Downstream[name] = address
+	}
+
+	return nil
+}
+
+// parseInt parses the given value into the given int
+func parseInt(value string, target *int) error {
+
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%q should be a whole number", value)
+	}
+
+	*target = parsed
+	return nil
+}
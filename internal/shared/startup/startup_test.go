@@ -0,0 +1,183 @@
+package startup
+
+import (
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseYAML(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		text       string
+		wantValues map[string]any
+		wantErr    bool
+	}{
+		{"empty", "# nothing here\n\n", map[string]any{}, false},
+		{"flat values", "port: 41006 # a comment\nname: \"a # b\"\nother: 'it''s'\n", map[string]any{"port": "41006", "name": "a # b", "other": "it's"}, false},
+		{"nested map", "downstream:\n  stats: http://stats:40005#frag\n  auth: http://auth:40001\nport: 1\n", map[string]any{
+			"downstream": map[string]any{"stats": "http://stats:40005#frag", "auth": "http://auth:40001"},
+			"port":       "1",
+		}, false},
+		{"key without a value", "downstream:\nport: 1\n", map[string]any{"downstream": "", "port": "1"}, false},
+		{"duplicate key", "port: 1\nport: 2\n", nil, true},
+		{"unexpected indentation", "port: 1\n  other: 2\n", nil, true},
+		{"tab indentation", "downstream:\n\tstats: x\n", nil, true},
+		{"list", "downstream:\n  - stats\n", nil, true},
+		{"no colon", "port 1\n", nil, true},
+		{"unterminated quote", "port: \"1\n", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotValues, err := parseYAML(test.text)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseYAML() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+
+			if !test.wantErr && !reflect.DeepEqual(gotValues, test.wantValues) {
+				t.Errorf("parseYAML() gave incorrect results, want: %v, got: %v", test.wantValues, gotValues)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	configFile := filepath.Join(t.TempDir(), "match.yaml")
+	err := os.WriteFile(configFile, []byte("port: 41009\nrequestTimeoutSeconds: 7\nsweepIntervalSeconds: 20\ndownstream:\n  stats: http://stats.internal:40005\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		service         string
+		env             map[string]string
+		args            []string
+		wantErr         bool
+		wantPrint       bool
+		wantPort        string
+		wantTimeout     int
+		wantSweep       int
+		wantStatsURL    string
+		wantMaxEnergy   int32
+		wantDownstreams int
+	}{
+		{"defaults", "match", nil, nil, false, false, "40009", 5, 0, "http://:40005", 0, 12},
+		{"file", "match", nil, []string{"-config", configFile}, false, false, "41009", 7, 20, "http://stats.internal:40005", 0, 12},
+		{"file from env", "match", map[string]string{"DICE_MATCH_CONFIG_FILE": configFile}, nil, false, false, "41009", 7, 20, "http://stats.internal:40005", 0, 12},
+		{"env over file", "match", map[string]string{"DICE_MATCH_PORT": "42009", "DICE_MATCH_DOWNSTREAM": "stats=https://stats.example.com"}, []string{"-config", configFile}, false, false, "42009", 7, 20, "https://stats.example.com", 0, 12},
+		{"flags over env", "match", map[string]string{"DICE_MATCH_PORT": "42009"}, []string{"-port", "43009", "--downstream", "stats=http://a:1", "-print-config"}, false, true, "43009", 5, 0, "http://a:1", 0, 12},
+		{"max energy", "gameplay", map[string]string{"DICE_GAMEPLAY_MAX_ENERGY": "80"}, nil, false, false, "40006", 5, 0, "http://:40005", 80, 12},
+		{"all runner", AllServices, nil, []string{"-max-energy", "60", "-request-timeout-seconds", "9"}, false, false, "", 9, 0, "", 60, 0},
+		{"unknown service", "tournament", nil, nil, true, false, "", 0, 0, "", 0, 0},
+		{"unknown flag", "match", nil, []string{"-verbose"}, true, false, "", 0, 0, "", 0, 0},
+		{"invalid env", "match", map[string]string{"DICE_MATCH_REQUEST_TIMEOUT_SECONDS": "soon"}, nil, true, false, "", 0, 0, "", 0, 0},
+		{"invalid adaptive timeouts", "match", map[string]string{"DICE_MATCH_ADAPTIVE_TIMEOUTS": "sometimes"}, nil, true, false, "", 0, 0, "", 0, 0},
+		{"missing file", "match", nil, []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, true, false, "", 0, 0, "", 0, 0},
+		{"invalid port", "match", nil, []string{"-port", "99999"}, true, false, "", 0, 0, "", 0, 0},
+		{"sweep without a sweep", "shop", nil, []string{"-sweep-interval-seconds", "3"}, true, false, "", 0, 0, "", 0, 0},
+		{"max energy without energy", "shop", nil, []string{"-max-energy", "80"}, true, false, "", 0, 0, "", 0, 0},
+		{"relative downstream", "match", nil, []string{"-downstream", "stats=/stats"}, true, false, "", 0, 0, "", 0, 0},
+		{"unknown downstream", "match", nil, []string{"-downstream", "tournament=http://a:1"}, true, false, "", 0, 0, "", 0, 0},
+		{"all runner port", AllServices, nil, []string{"-port", "40000"}, true, false, "", 0, 0, "", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			gotConfig, gotPrint, err := Load(test.service, test.args)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Load() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+
+			if gotPrint != test.wantPrint || gotConfig.Port != test.wantPort || gotConfig.RequestTimeoutSeconds != test.wantTimeout ||
+				gotConfig.SweepIntervalSeconds != test.wantSweep || gotConfig.Downstream["stats"] != test.wantStatsURL ||
+				gotConfig.MaxEnergy != test.wantMaxEnergy || len(gotConfig.Downstream) != test.wantDownstreams {
+				t.Errorf("Load() gave incorrect results, got print: %v, config: %+v", gotPrint, gotConfig)
+			}
+		})
+	}
+}
+
+func TestConfig_String(t *testing.T) {
+
+	// the printed config can be used as a config file, and loads back to the same config
+	cfg, _, err := Load("notifications", []string{"-sweep-interval-seconds", "30", "-adaptive-timeouts", "true", "-max-energy", "70", "-downstream", "profile=http://profile:40004"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "notifications.yaml")
+	err = os.WriteFile(configFile, []byte(cfg.String()), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotConfig, _, err := Load("notifications", []string{"-config", configFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotConfig, cfg) {
+		t.Errorf("the printed config loaded back incorrectly, want: %+v, got: %+v", cfg, gotConfig)
+	}
+}
+
+func TestApply(t *testing.T) {
+
+	defaultLimits := middleware.DefaultLimits
+	defer func() {
+		middleware.DefaultLimits = defaultLimits
+		downstream = map[string]string{}
+		tracing.EnableAdaptiveTimeouts(false)
+	}()
+
+	cfg, _, err := Load("gameplay", []string{"-request-timeout-seconds", "8", "-adaptive-timeouts", "true", "-downstream", "stats=http://stats:40005"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Apply(cfg)
+
+	if middleware.DefaultLimits.Timeout != 8*time.Second {
+		t.Errorf("Apply() gave incorrect results, want timeout: %v, got: %v", 8*time.Second, middleware.DefaultLimits.Timeout)
+	}
+
+	if !cfg.AdaptiveTimeouts || tracing.InternalTimeouts() == nil {
+		t.Errorf("Apply() gave incorrect results, want adaptive timeouts enabled, got config: %+v", cfg)
+	}
+
+	tests := []struct {
+		name    string
+		service string
+		wantURL string
+	}{
+		{"set in the startup config", "stats", "http://stats:40005"},
+		{"default address", "profile", "http://:40004"},
+		{"the running service", "gameplay", "http://:40006"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotURL := ServiceURL(test.service)
+			if gotURL != test.wantURL {
+				t.Errorf("ServiceURL() gave incorrect results, want: %v, got: %v", test.wantURL, gotURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package startup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the subset of yaml used by the startup config files: 'key: value' lines, and keys without a value
+// followed by a more indented block of 'key: value' lines (nested maps), with # comments, and quoted or plain values.
+// The values are returned as strings, and the nested maps as map[string]any (lists and the other yaml features
+// are not supported, and are reported as errors)
+func parseYAML(text string) (map[string]any, error) {
+
+	// a map being filled, and the indentation of its keys
+	type block struct {
+		indent int
+		values map[string]any
+	}
+
+	root := map[string]any{}
+	blocks := []block{{indent: 0, values: root}}
+
+	// the key (without a value) on the previous line, which opens a nested map if the next line is more indented
+	openKey := ""
+	var openMap map[string]any
+
+	for i, line := range strings.Split(text, "\n") {
+		lineNumber := i + 1
+
+		line = strings.TrimRight(stripComment(line), " \r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %v: tabs cannot be used for indentation", lineNumber)
+		}
+		if strings.HasPrefix(content, "- ") || content == "-" {
+			return nil, fmt.Errorf("line %v: lists are not supported", lineNumber)
+		}
+
+		if openMap != nil {
+			if indent > blocks[len(blocks)-1].indent {
+				blocks = append(blocks, block{indent: indent, values: openMap})
+			} else {
+				// a key without a value or a block under it has a blank value
+				blocks[len(blocks)-1].values[openKey] = ""
+			}
+			openKey, openMap = "", nil
+		}
+
+		for len(blocks) > 1 && indent < blocks[len(blocks)-1].indent {
+			blocks = blocks[:len(blocks)-1]
+		}
+		current := blocks[len(blocks)-1]
+		if indent != current.indent {
+			return nil, fmt.Errorf("line %v: unexpected indentation", lineNumber)
+		}
+
+		key, value, ok := strings.Cut(content, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || (value != "" && !strings.HasPrefix(value, " ")) {
+			return nil, fmt.Errorf("line %v: expected a 'key: value' line", lineNumber)
+		}
+		if _, exists := current.values[key]; exists {
+			return nil, fmt.Errorf("line %v: duplicate key %q", lineNumber, key)
+		}
+
+		value = strings.TrimSpace(value)
+		if value == "" {
+			openKey, openMap = key, map[string]any{}
+			current.values[key] = openMap
+			continue
+		}
+
+		unquoted, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", lineNumber, err)
+		}
+		current.values[key] = unquoted
+	}
+
+	if openMap != nil {
+		blocks[len(blocks)-1].values[openKey] = ""
+	}
+
+	return root, nil
+}
+
+// stripComment removes the # comment (if any) from the given line, a # inside a quoted value (one starting after
+// a space), or not after a space (like in a url fragment) does not start a comment
+func stripComment(line string) string {
+
+	quote := rune(0)
+	for i, char := range line {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case (char == '"' || char == '\'') && (i == 0 || line[i-1] == ' '):
+			quote = char
+		case char == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// unquote returns the given value without its quotes (if it is quoted)
+func unquote(value string) (string, error) {
+
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %v", value)
+		}
+		return unquoted, nil
+
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+
+	case value[0] == '"' || value[0] == '\'':
+		return "", fmt.Errorf("unterminated quoted value %v", value)
+	}
+
+	return value, nil
+}
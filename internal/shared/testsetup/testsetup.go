@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 )
@@ -23,7 +26,7 @@ func SetupTestAuth() (*auth.Server, string, error) {
 	newAuthReq.SetBasicAuth("user1", "pass1")
 	authRespRec := httptest.NewRecorder()
 
-	as := auth.NewServer()
+	as := auth.NewServer(data.NewServer())
 	as.HandleLoginRequest(authRespRec, newAuthReq)
 	sID := authRespRec.Header().Get("Session-Id")
 
@@ -49,3 +52,52 @@ func SetupTestAuthWithInput(as *auth.Server, usr string, pwd string) (string, er
 
 	return sID, nil
 }
+
+// SetupTestProfile creates a new player with the given id through the given new player handler of a profile server,
+// with the given session taken as a session of the new player, and returns the new player data
+// (the handler is passed in, as the profile tests use this package too)
+func SetupTestProfile(playerID string, sessionID string, newPlayerHandler http.HandlerFunc) (*data.PlayerData, error) {
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(map[string]string{"playerID": playerID})
+	if err != nil {
+		return nil, err
+	}
+
+	newReq := httptest.NewRequest(http.MethodPost, "/profile/new-player", buf)
+	newReq.Header.Set("Session-Id", sessionID)
+	respRec := httptest.NewRecorder()
+
+	validation.WithSession(Sessions{sessionID: playerID}, newPlayerHandler)(respRec, newReq)
+
+	newPlayerData := &data.PlayerData{}
+	err = json.NewDecoder(respRec.Result().Body).Decode(newPlayerData)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPlayerData, nil
+}
+
+// WithSession passes the requests through the session validation middleware of the given validator first, like the
+// routes of the servers do (a nil server has no validator, so its handler gets the requests as they are)
+func WithSession[S any](server *S, rv validation.RequestValidator, handler http.HandlerFunc) http.HandlerFunc {
+
+	if server == nil {
+		return handler
+	}
+	return validation.WithSession(rv, handler)
+}
+
+// Sessions is a request validator for the handler tests, which resolves each session id in it to the player id it maps
+// to, so a test can hold a session of each of the players it sets up (any other session id is invalid)
+type Sessions map[string]string
+
+// ValidateRequest returns the player id the session id of the request maps to
+func (s Sessions) ValidateRequest(req *http.Request) (string, error) {
+
+	playerID, ok := s[req.Header.Get("Session-Id")]
+	if !ok {
+		return "", fmt.Errorf("invalid session id")
+	}
+	return playerID, nil
+}
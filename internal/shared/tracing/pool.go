@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"example.com/dice-game-backend/internal/shared/constants"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats count the connections used by the internal requests of this process,
+// the ones opened for a request, and the idle (keep-alive) ones which were reused
+type ConnStats struct {
+	Opened int64 `json:"opened"`
+	Reused int64 `json:"reused"`
+}
+
+// connsOpened and connsReused are updated by every internal request (see PoolTransport)
+var connsOpened atomic.Int64
+var connsReused atomic.Int64
+
+// InternalConnStats returns the connection stats of the internal requests made so far
+func InternalConnStats() ConnStats {
+	return ConnStats{Opened: connsOpened.Load(), Reused: connsReused.Load()}
+}
+
+// NewInternalTransport returns a transport tuned for the internal requests, which are many small requests to a few hosts:
+// it keeps up to InternalMaxIdleConnsPerHost idle connections per service (the default transport keeps 2, so most
+// connections are closed and opened again under load), and does not wait long to open a new connection
+func NewInternalTransport() *http.Transport {
+
+	dialer := &net.Dialer{
+		Timeout:   constants.InternalDialTimeoutSeconds * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          constants.InternalMaxIdleConns,
+		MaxIdleConnsPerHost:   constants.InternalMaxIdleConnsPerHost,
+		IdleConnTimeout:       constants.InternalIdleConnTimeoutSeconds * time.Second,
+		TLSHandshakeTimeout:   constants.InternalDialTimeoutSeconds * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+}
+
+// PoolTransport is an http round tripper which counts whether each request got a new or a reused connection
+// from the base transport (see InternalConnStats)
+type PoolTransport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *PoolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	clientTrace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				connsReused.Add(1)
+			} else {
+				connsOpened.Add(1)
+			}
+		},
+	}
+
+	// round trippers should not modify the original request
+	req = req.Clone(httptrace.WithClientTrace(req.Context(), clientTrace))
+
+	return t.Base.RoundTrip(req)
+}
@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolTransport(t *testing.T) {
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("success"))
+	}))
+	defer testServer.Close()
+
+	client := &http.Client{Transport: &PoolTransport{Base: NewInternalTransport()}}
+	before := InternalConnStats()
+
+	tests := []struct {
+		name       string
+		wantOpened int64
+		wantReused int64
+	}{
+		{"first request", 1, 0},
+		{"second request", 1, 1},
+		{"third request", 1, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			resp, err := client.Get(testServer.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+
+			got := InternalConnStats()
+			if got.Opened-before.Opened != test.wantOpened || got.Reused-before.Reused != test.wantReused {
+				t.Errorf("InternalConnStats() gave incorrect results, want opened: %v, reused: %v, got opened: %v, reused: %v",
+					test.wantOpened, test.wantReused, got.Opened-before.Opened, got.Reused-before.Reused)
+			}
+		})
+	}
+}
+
+// BenchmarkInternalTransport compares the latency of bursts of concurrent internal requests (like the fan out of a
+// leaderboard request) with the default transport, which keeps only 2 idle connections per host (so most connections
+// of a burst are closed, and opened again in the next one), and the internal one. It reports the 99th percentile latency
+// of the requests, and the connections opened per request
+func BenchmarkInternalTransport(b *testing.B) {
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		_, _ = w.Write([]byte("success"))
+	}))
+	defer testServer.Close()
+
+	const burstSize = 32
+
+	benchmarks := []struct {
+		name      string
+		transport http.RoundTripper
+	}{
+		{"default transport", http.DefaultTransport.(*http.Transport).Clone()},
+		{"internal transport", NewInternalTransport()},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+
+			client := &http.Client{Transport: &PoolTransport{Base: bm.transport}}
+
+			before := InternalConnStats()
+			latencies := make([]time.Duration, 0, b.N*burstSize)
+			latenciesMutex := sync.Mutex{}
+
+			b.ResetTimer()
+
+			for range b.N {
+				wg := sync.WaitGroup{}
+				for range burstSize {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+
+						start := time.Now()
+						resp, err := client.Get(testServer.URL)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						_, _ = io.Copy(io.Discard, resp.Body)
+						_ = resp.Body.Close()
+
+						latenciesMutex.Lock()
+						latencies = append(latencies, time.Since(start))
+						latenciesMutex.Unlock()
+					}()
+				}
+				wg.Wait()
+			}
+
+			b.StopTimer()
+
+			b.ReportMetric(float64(InternalConnStats().Opened-before.Opened)/float64(len(latencies)), "conns/req")
+
+			slices.Sort(latencies)
+			if len(latencies) > 0 {
+				b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+			}
+		})
+	}
+}
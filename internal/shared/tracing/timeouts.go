@@ -0,0 +1,152 @@
+package tracing
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"math"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adaptiveTimeouts is whether the deadline of the internal requests to each downstream follows its recent latencies
+// (see EnableAdaptiveTimeouts), rather than being the fixed constants.InternalRequestDeadlineSeconds
+var adaptiveTimeouts atomic.Bool
+
+// latencyWindow holds the latencies of the latest internal requests to a downstream, the oldest one is overwritten
+// once it holds constants.InternalLatencySamples of them
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+// the latency windows of the downstreams, keyed by host (and port)
+var latencyWindows = map[string]*latencyWindow{}
+var latencyMutex sync.Mutex
+
+// timeoutOverrideKey is the context key of the timeout override of the internal requests made with a context
+type timeoutOverrideKey struct{}
+
+// EnableAdaptiveTimeouts turns the adaptive deadlines of the internal requests on (or off): the deadline of the requests
+// to a downstream becomes constants.InternalTimeoutMultiplier times the constants.InternalTimeoutPercentile latency of its
+// latest requests, kept between the floor and the ceiling, so a downstream which slows down under load gets more time
+// instead of failing spuriously, and one which is fast fails fast. It is set by the startup config (see startup.Apply)
+func EnableAdaptiveTimeouts(enabled bool) {
+
+	adaptiveTimeouts.Store(enabled)
+
+	// the latencies are only recorded while enabled, so old ones are not picked up when enabled again
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+	latencyWindows = map[string]*latencyWindow{}
+}
+
+// WithInternalTimeout returns a context whose internal requests (made by the service clients) get the given timeout,
+// instead of the fixed or adaptive one, like for a request known to take long (or one which should give up early)
+func WithInternalTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutOverrideKey{}, timeout)
+}
+
+// InternalContext returns a context with the deadline of an internal request to the service at the given url
+// (see InternalTimeout), unless the given context overrides it (see WithInternalTimeout)
+func InternalContext(ctx context.Context, serviceURL string) (context.Context, context.CancelFunc) {
+
+	if timeout, ok := ctx.Value(timeoutOverrideKey{}).(time.Duration); ok && timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithTimeout(ctx, InternalTimeout(serviceURL))
+}
+
+// InternalTimeout returns the deadline of the internal requests to the service at the given url: the adaptive one if
+// adaptive timeouts are enabled and the service had enough recent requests, or the fixed one otherwise
+func InternalTimeout(serviceURL string) time.Duration {
+
+	fixed := constants.InternalRequestDeadlineSeconds * time.Second
+
+	if !adaptiveTimeouts.Load() {
+		return fixed
+	}
+
+	parsedURL, err := url.Parse(serviceURL)
+	if err != nil {
+		return fixed
+	}
+
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+
+	timeout, ok := latencyWindows[parsedURL.Host].timeout()
+	if !ok {
+		return fixed
+	}
+
+	return timeout
+}
+
+// InternalTimeouts returns the adaptive deadlines of the downstreams with enough recent requests, keyed by host
+// (none, if adaptive timeouts are not enabled)
+func InternalTimeouts() map[string]time.Duration {
+
+	if !adaptiveTimeouts.Load() {
+		return nil
+	}
+
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+
+	timeouts := map[string]time.Duration{}
+	for host, window := range latencyWindows {
+		if timeout, ok := window.timeout(); ok {
+			timeouts[host] = timeout
+		}
+	}
+
+	return timeouts
+}
+
+// recordLatency records the latency of an internal request to the given host, if adaptive timeouts are enabled
+func recordLatency(host string, latency time.Duration) {
+
+	if !adaptiveTimeouts.Load() {
+		return
+	}
+
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+
+	window, ok := latencyWindows[host]
+	if !ok {
+		window = &latencyWindow{}
+		latencyWindows[host] = window
+	}
+
+	if len(window.samples) < constants.InternalLatencySamples {
+		window.samples = append(window.samples, latency)
+		return
+	}
+
+	window.samples[window.next] = latency
+	window.next = (window.next + 1) % constants.InternalLatencySamples
+}
+
+// timeout returns the adaptive deadline of the window, and false if it has too few samples (or is nil)
+func (window *latencyWindow) timeout() (time.Duration, bool) {
+
+	if window == nil || len(window.samples) < constants.InternalTimeoutMinSamples {
+		return 0, false
+	}
+
+	sorted := slices.Clone(window.samples)
+	slices.Sort(sorted)
+
+	index := int(math.Ceil(constants.InternalTimeoutPercentile*float64(len(sorted)))) - 1
+	percentile := sorted[min(max(index, 0), len(sorted)-1)]
+
+	floor := constants.InternalTimeoutFloorMillis * time.Millisecond
+	ceiling := constants.InternalTimeoutCeilingMillis * time.Millisecond
+
+	return min(max(percentile*constants.InternalTimeoutMultiplier, floor), ceiling), true
+}
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"testing"
+	"time"
+)
+
+func TestInternalTimeout(t *testing.T) {
+
+	defer EnableAdaptiveTimeouts(false)
+
+	fixed := constants.InternalRequestDeadlineSeconds * time.Second
+	floor := constants.InternalTimeoutFloorMillis * time.Millisecond
+	ceiling := constants.InternalTimeoutCeilingMillis * time.Millisecond
+
+	tests := []struct {
+		name        string
+		enabled     bool
+		samples     int
+		latency     time.Duration
+		wantTimeout time.Duration
+	}{
+		{"disabled", false, constants.InternalLatencySamples, time.Second, fixed},
+		{"too few samples", true, constants.InternalTimeoutMinSamples - 1, time.Second, fixed},
+		{"adaptive", true, constants.InternalTimeoutMinSamples, time.Second, constants.InternalTimeoutMultiplier * time.Second},
+		{"full window", true, 2 * constants.InternalLatencySamples, 2 * time.Second, constants.InternalTimeoutMultiplier * 2 * time.Second},
+		{"floor", true, constants.InternalTimeoutMinSamples, time.Millisecond, floor},
+		{"ceiling", true, constants.InternalTimeoutMinSamples, time.Minute, ceiling},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			EnableAdaptiveTimeouts(test.enabled)
+			for range test.samples {
+				recordLatency("stats:40005", test.latency)
+			}
+
+			gotTimeout := InternalTimeout("http://stats:40005")
+			if gotTimeout != test.wantTimeout {
+				t.Errorf("InternalTimeout() gave incorrect results, want: %v, got: %v", test.wantTimeout, gotTimeout)
+			}
+
+			// the other downstreams keep the fixed deadline
+			gotTimeout = InternalTimeout("http://profile:40004")
+			if gotTimeout != fixed {
+				t.Errorf("InternalTimeout() gave incorrect results for another downstream, want: %v, got: %v", fixed, gotTimeout)
+			}
+
+			_, gotAdaptive := InternalTimeouts()["stats:40005"]
+			if gotAdaptive != (test.wantTimeout != fixed) {
+				t.Errorf("InternalTimeouts() gave incorrect results, got: %v", InternalTimeouts())
+			}
+		})
+	}
+}
+
+func TestInternalContext(t *testing.T) {
+
+	defer EnableAdaptiveTimeouts(false)
+
+	EnableAdaptiveTimeouts(true)
+	for range constants.InternalTimeoutMinSamples {
+		recordLatency("stats:40005", time.Second)
+	}
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		wantTimeout time.Duration
+	}{
+		{"adaptive", context.Background(), constants.InternalTimeoutMultiplier * time.Second},
+		{"override", WithInternalTimeout(context.Background(), 30*time.Second), 30 * time.Second},
+		{"zero override", WithInternalTimeout(context.Background(), 0), constants.InternalTimeoutMultiplier * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			ctx, cancel := InternalContext(test.ctx, "http://stats:40005")
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("InternalContext() gave a context without a deadline")
+			}
+
+			// allow for the time the test took so far
+			gotTimeout := time.Until(deadline)
+			if gotTimeout > test.wantTimeout || gotTimeout < test.wantTimeout-time.Second {
+				t.Errorf("InternalContext() gave incorrect results, want a timeout of: %v, got: %v", test.wantTimeout, gotTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Package tracing sets up OpenTelemetry tracing for the different servers in our module,
+// and has helpers to start spans and propagate the trace context (traceparent headers) on internal requests
+package tracing
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/shared/identity"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"os"
+	"time"
+)
+
+// instrumentationName is the name of the tracer used across the module
+const instrumentationName = "example.com/dice-game-backend"
+
+// the standard OpenTelemetry environment variables, either of them enables exporting spans over OTLP (http)
+const otlpEndpointEnvVar = "OTEL_EXPORTER_OTLP_ENDPOINT"
+const otlpTracesEndpointEnvVar = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+
+// Init sets up the trace context propagation, and (if an OTLP endpoint is set in the environment)
+// a tracer provider which exports the spans of the given service, so they can be viewed in Jaeger / Tempo etc.
+// The returned function flushes and shuts down the exporter
+func Init(serviceName string) (func(context.Context) error, error) {
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv(otlpEndpointEnvVar) == "" && os.Getenv(otlpTracesEndpointEnvVar) == "" {
+		// spans are not recorded, but the trace context is still propagated
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// the exporter reads its endpoint (and other settings) from the standard environment variables
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts an internal span with the given name, as a child of the span in the given context (if any)
+func Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name)
+}
+
+// StartServer starts a server span for an incoming request, continuing the trace from its traceparent header (if any)
+func StartServer(req *http.Request, name string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// Transport is an http round tripper which wraps every outgoing (internal) request in a client span, and adds
+// the traceparent header to it, so the receiving server continues the same trace
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// HTTPClient is the http client used for internal (server to server) requests,
+// which also carry the service token and the namespace of the sending service.
+// All the internal requests share its pool of keep-alive connections (see NewInternalTransport)
+var HTTPClient = &http.Client{Transport: &Transport{Base: &identity.Transport{Base: &namespace.Transport{Base: &PoolTransport{Base: NewInternalTransport()}}}}}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	ctx, span := otel.Tracer(instrumentationName).Start(req.Context(), req.Method+" "+req.URL.Path, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	// round trippers should not modify the original request
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// failed requests are not recorded, a timed out one would only tell how long its deadline was
+	recordLatency(req.URL.Host, time.Since(start))
+
+	SetHTTPStatus(span, resp.StatusCode)
+
+	return resp, nil
+}
+
+// SetHTTPStatus records the status code of a (server or client) response on the span, marking server errors
+func SetHTTPStatus(span trace.Span, statusCode int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	if statusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+}
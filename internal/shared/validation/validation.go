@@ -1,44 +1,157 @@
-// Package validation adds a custom interface used for validation purposes
-// as well as an implementation suitable for microservices
+// Package validation adds a custom interface used for validation purposes, an implementation suitable
+// for microservices, and the middleware which validates the session of a request before its handler runs
 package validation
 
 import (
 	"context"
+	"example.com/dice-game-backend/internal/shared/apierror"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
-// RequestValidator implementor can validate http requests
+// RequestValidator implementor can validate http requests, and resolve the player id of their session
 // (currently used by auth.Server to validate requests based on valid sessions)
 type RequestValidator interface {
-	ValidateRequest(req *http.Request) error
+	ValidateRequest(req *http.Request) (string, error)
+}
+
+// SessionRevoker implementor can sign out all the sessions of a player, like when their player id is retired by a
+// merge (implemented by auth.Server, and wrapped around RevokePlayerSessions by the microservices)
+type SessionRevoker interface {
+	RevokePlayerSessions(ctx context.Context, playerID string) error
+}
+
+var logger = log.New(redact.Stdout, "validation: ", log.Ltime|log.LUTC|log.Lmsgprefix)
+
+// Middleware returns a middleware which validates the session of every request with the given validator before the
+// wrapped handler runs: requests without a valid session get a 401 (with the invalid-session code), and the handler
+// gets the request with the player id and the session id of the session in its context (see playerctx)
+func Middleware(rv RequestValidator) func(http.Handler) http.Handler {
+
+	return func(handler http.Handler) http.Handler {
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			playerID, err := rv.ValidateRequest(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
+				logger.Println("error: session validation error: " + err.Error())
+				apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+				return
+			}
+
+			ctx := playerctx.WithSessionID(playerctx.WithPlayerID(r.Context(), playerID), r.Header.Get("Session-Id"))
+			handler.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// WithSession wraps the given handler in the middleware of the given validator, as a handler func
+// (so a route needing a valid session can be registered like the others, see middleware.WithLimits)
+func WithSession(rv RequestValidator, handler http.HandlerFunc) http.HandlerFunc {
+	return Middleware(rv)(handler).ServeHTTP
+}
+
+// CheckPlayerID checks that the player id a request acts on (from its body or its path) is the player of the session
+// of the request (see Middleware), and sets a blank one to that player. For the player id of another player it writes
+// a 403 (with the forbidden code), or a 401 if the request has no session player in its context, and returns false
+func CheckPlayerID(w http.ResponseWriter, r *http.Request, playerID *string) bool {
+
+	sessionPlayerID, err := playerctx.RequirePlayerID(r.Context())
+	if err != nil {
+		logger.Println("error: " + err.Error())
+		apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeInvalidSession)
+		return false
+	}
+
+	if *playerID != "" && *playerID != sessionPlayerID {
+		logger.Printf("error: the session of player id %v cannot act on player id %v", sessionPlayerID, *playerID)
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden)
+		return false
+	}
+
+	*playerID = sessionPlayerID
+	return true
 }
 
 // ValidateRequest is an implementation that the servers will use when running as their own microservices
-// They will send an internal request to the auth server, and check the response for errors
-func ValidateRequest(req *http.Request) error {
+// They will send an internal request to the auth server, check the response for errors, and return the player id
+// of the session from the response
+func ValidateRequest(req *http.Request) (string, error) {
 
 	// extract the "Session-Id" header
 	sessionIdHeader := req.Header["Session-Id"]
 	if sessionIdHeader == nil {
-		return fmt.Errorf("no session id header in the request")
+		return "", fmt.Errorf("no session id header in the request")
 	}
 
 	// create a context, then a request with it
 	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
 	defer cancel()
 
-	reqURL := fmt.Sprintf("%v://%v:%v/auth/validation-internal", constants.CommonProtocol, constants.CommonHost, constants.AuthServerPort)
+	originalReq := req
+
+	reqURL := startup.ServiceURL("auth") + "/auth/validation-internal"
 	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, nil)
 	if err != nil {
-		return fmt.Errorf("request creation error: %v \n", err)
+		return "", fmt.Errorf("request creation error: %v \n", err)
 	}
 	req.Header.Set("Session-ID", sessionIdHeader[0])
 
+	// the auth service checks the nonce of state changing requests (if request nonces are enabled)
+	req.Header.Set(constants.RequestMethodHeader, originalReq.Method)
+	if nonce := originalReq.Header.Get(constants.RequestNonceHeader); nonce != "" {
+		req.Header.Set(constants.RequestNonceHeader, nonce)
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request sending error: %v \n", err)
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("validation was not successful")
+	}
+
+	// the body of the response is the player id of the session
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("response reading error: %v \n", err)
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+// RevokePlayerSessions is the implementation of SessionRevoker that the servers will use when running as their own
+// microservices, it sends an internal request to the auth server to sign out all the sessions of the given player
+func RevokePlayerSessions(ctx context.Context, playerID string) error {
+
+	// create a context, then a request with it
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	reqURL := startup.ServiceURL("auth") + "/auth/player-sessions-revoke-internal/" + url.PathEscape(playerID)
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("request creation error: %v \n", err)
+	}
+
 	// send the request
-	client := http.DefaultClient
+	client := tracing.HTTPClient
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("request sending error: %v \n", err)
@@ -47,7 +160,7 @@ func ValidateRequest(req *http.Request) error {
 
 	// check response status
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("validation was not successful")
+		return fmt.Errorf("revoking the sessions was not successful, status code %v", resp.StatusCode)
 	}
 
 	return nil
@@ -1,10 +1,17 @@
-package validation
+package validation_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/data"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -15,11 +22,23 @@ var authServer *auth.Server
 
 func TestMain(m *testing.M) {
 
-	authServer = auth.NewServer()
-	go authServer.Run(constants.AuthServerPort)
+	authServer = auth.NewServer(data.NewServer())
+
+	// the auth server runs on an ephemeral port, which the validator is pointed at (instead of the designated one)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go authServer.RunWithListener(listener)
+
+	cfg := startup.Defaults("stats")
+	cfg.Downstream["auth"] = constants.CommonProtocol + "://" + listener.Addr().String()
+	startup.Apply(cfg)
 
 	code := m.Run()
 
+	listener.Close()
+
 	os.Exit(code)
 }
 
@@ -38,6 +57,11 @@ func TestValidateRequest(t *testing.T) {
 
 	authServer.HandleLoginRequest(authRespRec, newAuthReq)
 	sID := authRespRec.Header().Get("Session-Id")
+	loginResponse := &auth.LoginResponse{}
+	err = json.NewDecoder(authRespRec.Body).Decode(loginResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	newReq := httptest.NewRequest(http.MethodPost, "/test/", nil)
 
@@ -60,13 +84,154 @@ func TestValidateRequest(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			gotErr := ValidateRequest(test.httpRequest)
+			gotPlayerID, gotErr := validation.ValidateRequest(test.httpRequest)
 			if gotErr != nil && !test.shouldFail {
 				t.Fatalf("ValidateRequest() failed with an unexpected error, %v", gotErr)
 			} else if gotErr == nil && test.shouldFail {
 				t.Fatalf("ValidateRequest() should have failed but it did not")
+			} else if gotErr == nil && gotPlayerID != loginResponse.PlayerID {
+				t.Fatalf("ValidateRequest() gave an incorrect player id, want: %v, got: %v", loginResponse.PlayerID, gotPlayerID)
 			}
 		})
 	}
 
 }
+
+func TestRevokePlayerSessions(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(&auth.LoginRequestBody{IsNewUser: true, ServerVersion: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loginReq := httptest.NewRequest(http.MethodPost, "/auth/login", buf)
+	loginReq.SetBasicAuth("user3", "pass3")
+	loginRespRec := httptest.NewRecorder()
+	authServer.HandleLoginRequest(loginRespRec, loginReq)
+
+	loginResponse := &auth.LoginResponse{}
+	err = json.NewDecoder(loginRespRec.Body).Decode(loginResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test/", nil)
+	req.Header.Set("Session-Id", loginRespRec.Header().Get("Session-Id"))
+	if _, err = validation.ValidateRequest(req); err != nil {
+		t.Fatalf("ValidateRequest() failed with an unexpected error, %v", err)
+	}
+
+	err = validation.RevokePlayerSessions(context.Background(), loginResponse.PlayerID)
+	if err != nil {
+		t.Fatalf("RevokePlayerSessions() failed with an unexpected error, %v", err)
+	}
+
+	// the session was signed out by the auth server
+	if _, err = validation.ValidateRequest(req); err == nil {
+		t.Errorf("ValidateRequest() should have failed for a revoked session but it did not")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+
+	as := auth.NewServer(data.NewServer())
+
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(&auth.LoginRequestBody{IsNewUser: true, ServerVersion: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loginReq := httptest.NewRequest(http.MethodPost, "/auth/login", buf)
+	loginReq.SetBasicAuth("user2", "pass2")
+	loginRespRec := httptest.NewRecorder()
+	as.HandleLoginRequest(loginRespRec, loginReq)
+	sID := loginRespRec.Header().Get("Session-Id")
+
+	loginResponse := &auth.LoginResponse{}
+	err = json.NewDecoder(loginRespRec.Body).Decode(loginResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler echoes the player id it finds in the context
+	handler := validation.WithSession(as, func(w http.ResponseWriter, r *http.Request) {
+		playerID, ok := playerctx.PlayerID(r.Context())
+		if !ok {
+			t.Error("the handler should have had the player id in the context")
+		}
+		_, _ = w.Write([]byte(playerID))
+	})
+
+	tests := []struct {
+		name         string
+		sessionID    string
+		wantStatus   int
+		wantPlayerID string
+	}{
+		{"blank session id", "", http.StatusUnauthorized, ""},
+		{"invalid session", "test", http.StatusUnauthorized, ""},
+		{"valid session", sID, http.StatusOK, loginResponse.PlayerID},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/test/", nil)
+			if test.sessionID != "" {
+				req.Header.Set("Session-Id", test.sessionID)
+			}
+			respRec := httptest.NewRecorder()
+			handler(respRec, req)
+
+			if respRec.Code != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, respRec.Code)
+			}
+			if test.wantStatus == http.StatusOK && respRec.Body.String() != test.wantPlayerID {
+				t.Fatalf("handler gave an incorrect player id, want: %v, got: %v", test.wantPlayerID, respRec.Body.String())
+			}
+			if test.wantStatus == http.StatusUnauthorized && respRec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("the unauthorized response should have had the WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestCheckPlayerID(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		sessionPlayer string
+		playerID      string
+		wantOK        bool
+		wantStatus    int
+		wantPlayerID  string
+	}{
+		{"no session player", "", "player1", false, http.StatusUnauthorized, "player1"},
+		{"player of the session", "player1", "player1", true, http.StatusOK, "player1"},
+		{"blank player id", "player1", "", true, http.StatusOK, "player1"},
+		{"another player", "player1", "player2", false, http.StatusForbidden, "player2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/test/", nil)
+			if test.sessionPlayer != "" {
+				req = req.WithContext(playerctx.WithPlayerID(req.Context(), test.sessionPlayer))
+			}
+			respRec := httptest.NewRecorder()
+
+			playerID := test.playerID
+			gotOK := validation.CheckPlayerID(respRec, req, &playerID)
+
+			if gotOK != test.wantOK || respRec.Code != test.wantStatus {
+				t.Fatalf("CheckPlayerID() gave incorrect results, want: %v (%v), got: %v (%v)", test.wantOK, test.wantStatus, gotOK, respRec.Code)
+			}
+			if playerID != test.wantPlayerID {
+				t.Errorf("CheckPlayerID() left an incorrect player id, want: %v, got: %v", test.wantPlayerID, playerID)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Package shop: service which lets players spend the coins in their wallet
+// on the items of the (config driven) catalog, like energy packs and dice skins
+
+package shop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+)
+
+// Shop Specific Errors:
+var serverNilError = fmt.Errorf("provided shop server pointer is nil")
+
+type ItemAlreadyOwnedErr struct {
+	PlayerID string
+	ItemID   string
+}
+
+func (err ItemAlreadyOwnedErr) Error() string {
+	return fmt.Sprintf("player id: %v already owns the item: %v", err.PlayerID, err.ItemID)
+}
+
+type UnknownItemErr struct {
+	ItemID string
+}
+
+func (err UnknownItemErr) Error() string {
+	return fmt.Sprintf("item: %v is not in the catalog", err.ItemID)
+}
+
+type CatalogResponse struct {
+	Items []config.ShopItemConfig `json:"items"`
+}
+
+type PurchaseRequestBody struct {
+	PlayerID string `json:"playerID"`
+	ItemID   string `json:"itemID"`
+}
+
+// PlayerSnapshot is everything about the player that a purchase can change,
+// and is sent back as the purchase response
+type PlayerSnapshot struct {
+	Player    data.PlayerData    `json:"playerData"`
+	Wallet    data.WalletData    `json:"wallet"`
+	Inventory data.InventoryData `json:"inventory"`
+}
+
+// Server is the core shop service provider
+type Server struct {
+	requestValidator validation.RequestValidator
+	dataClient       data.DataClient
+	profileClient    profile.ProfileClient
+
+	logger *log.Logger
+}
+
+// NewServer returns an initialized pointer to the shop server
+func NewServer(rv validation.RequestValidator, dc data.DataClient, pc profile.ProfileClient) *Server {
+	return &Server{
+		requestValidator: rv,
+		dataClient:       dc,
+		profileClient:    pc,
+
+		logger: log.New(redact.Stdout, "shop: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+	}
+}
+
+// Run runs a given shop server on the given port
+func (ss *Server) Run(port string) {
+	log.Fatal(ss.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the shop server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (ss *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ss.serve(ctx, listener)
+}
+
+// RunWithListener runs the shop server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (ss *Server) RunWithListener(listener net.Listener) error {
+	return ss.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the shop server, and serves them on the given listener till the context is done
+func (ss *Server) serve(ctx context.Context, listener net.Listener) error {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /shop/catalog", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandleCatalogRequest), middleware.DefaultLimits))
+	mux.Handle("POST /shop/purchase", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandlePurchaseRequest), middleware.DefaultLimits))
+
+	ss.logger.Println("the shop server is up and running...")
+
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("shop", middleware.WithAccessLog("shop", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
+}
+
+// HandleCatalogRequest responds with all the items that can be bought in the shop
+func (ss *Server) HandleCatalogRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&CatalogResponse{Items: config.Config.ShopItems})
+	if err != nil {
+		errMsg := "error: could not encode the catalog: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandlePurchaseRequest is a wrapper around the Purchase() method,
+// it sends back the updated player snapshot once the purchase goes through
+func (ss *Server) HandlePurchaseRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	purchaseRequest := &PurchaseRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(purchaseRequest)
+	if err != nil {
+		errMsg := "error: could not decode the purchase request: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &purchaseRequest.PlayerID) {
+		return
+	}
+	ss.logger.Printf("request to purchase item %v by player id %v", purchaseRequest.ItemID, purchaseRequest.PlayerID)
+
+	snapshot, err := ss.Purchase(r.Context(), purchaseRequest.PlayerID, purchaseRequest.ItemID)
+	if err != nil {
+		errMsg := "error: could not complete the purchase: " + err.Error()
+		ss.logger.Println(errMsg)
+		switch {
+		case errors.As(err, &UnknownItemErr{}):
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		case errors.As(err, &data.PlayerNotFoundErr{}):
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound)
+		case errors.As(err, &data.InsufficientCoinsErr{}):
+			apierror.Write(w, r, http.StatusPaymentRequired, apierror.CodeInsufficientCoins)
+		case errors.As(err, &ItemAlreadyOwnedErr{}):
+			apierror.Write(w, r, http.StatusConflict, apierror.CodeItemAlreadyOwned)
+		default:
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(snapshot)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// Purchase debits the price of the item from the player's wallet (which starts with the default coins),
+// and then grants the item: energy packs are applied to the player's energy right away,
+// while dice skins go into the player's inventory (and can only be bought once).
+// If granting the item fails, the coins are refunded
+func (ss *Server) Purchase(ctx context.Context, playerID string, itemID string) (*PlayerSnapshot, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "shop.Purchase")
+	defer span.End()
+
+	item, ok := config.Config.ShopItem(itemID)
+	if !ok {
+		return nil, UnknownItemErr{itemID}
+	}
+
+	// make sure the player exists before creating a wallet for them
+	player, err := ss.profileClient.GetPlayer(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ss.dataClient.InitWallet(ctx, &data.WalletData{PlayerID: playerID, Coins: config.Config.DefaultCoins})
+	if err != nil {
+		return nil, err
+	}
+
+	inventory, err := ss.dataClient.ReadInventory(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.Kind == config.ShopItemKindDiceSkin && slices.ContainsFunc(inventory.Items, func(owned data.InventoryItem) bool { return owned.ItemID == itemID }) {
+		return nil, ItemAlreadyOwnedErr{playerID, itemID}
+	}
+
+	// take the coins first, the data service makes sure the wallet can never be overdrawn
+	wallet, err := ss.dataClient.AdjustWallet(ctx, playerID, -item.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	switch item.Kind {
+	case config.ShopItemKindEnergyPack:
+		player, err = ss.profileClient.UpdatePlayerData(playerctx.WithPlayerID(ctx, playerID), item.EnergyAmount, player.Level)
+	case config.ShopItemKindEnergyBoost:
+		player, err = ss.profileClient.ActivateBoost(ctx, &profile.BoostActivation{PlayerID: playerID, BoostID: itemID, RegenMultiplier: item.RegenMultiplier, DurationSeconds: item.BoostSeconds})
+	case config.ShopItemKindDiceSkin, config.ShopItemKindSkipTicket:
+		inventory, err = ss.dataClient.GrantItem(ctx, &data.ItemGrant{PlayerID: playerID, ItemID: itemID, Count: 1})
+	default:
+		err = fmt.Errorf("item: %v has an unknown kind: %v", itemID, item.Kind)
+	}
+
+	if err != nil {
+		_, refundErr := ss.dataClient.AdjustWallet(ctx, playerID, item.Price)
+		if refundErr != nil {
+			ss.logger.Printf("error: could not refund %v coins to player id %v: %v", item.Price, playerID, refundErr)
+		}
+		return nil, err
+	}
+
+	return &PlayerSnapshot{Player: *player, Wallet: *wallet, Inventory: *inventory}, nil
+}
@@ -0,0 +1,251 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/testsetup"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNewShopServer(t *testing.T) {
+
+	dataServer := data.NewServer()
+	authServer := auth.NewServer(dataServer)
+
+	ss := NewServer(authServer, dataServer, profile.NewServer(authServer, dataServer))
+
+	if ss == nil {
+		t.Fatal("new shop server should not return a nil server pointer")
+	}
+}
+
+func TestServer_HandleCatalogRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	ss := NewServer(as, dataServer, profile.NewServer(as, dataServer))
+
+	tests := []struct {
+		name       string
+		server     *Server
+		sessionID  string
+		wantStatus int
+	}{
+		{"nil server", nil, "", http.StatusInternalServerError},
+		{"blank session id", ss, "", http.StatusUnauthorized},
+		{"valid session id", ss, sID, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/shop/catalog", nil)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			shopServer := test.server
+			testsetup.WithSession(shopServer, as, shopServer.HandleCatalogRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &CatalogResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotResponseBody.Items, config.Config.ShopItems) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", config.Config.ShopItems, gotResponseBody.Items)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandlePurchaseRequest(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	ss := NewServer(as, dataServer, profileServer)
+
+	player, err := testsetup.SetupTestProfile("player1", sID, profileServer.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// spend some of the starting energy, so that the energy pack has room to be applied
+	player, err = profileServer.UpdatePlayerData(playerctx.WithPlayerID(context.Background(), "player1"), -20, 0)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
+	energyPack, _ := config.Config.ShopItem("energy-small")
+	diceSkin, _ := config.Config.ShopItem("skin-golden")
+	startingCoins := config.Config.DefaultCoins
+
+	tests := []struct {
+		name          string
+		server        *Server
+		sessionID     string
+		requestBody   *PurchaseRequestBody
+		wantStatus    int
+		wantEnergy    int32
+		wantCoins     int64
+		wantInventory []data.InventoryItem
+	}{
+		{"nil server", nil, "", nil, http.StatusInternalServerError, 0, 0, nil},
+		{"blank session id", ss, "", nil, http.StatusUnauthorized, 0, 0, nil},
+		{"unknown item", ss, sID, &PurchaseRequestBody{PlayerID: "player1", ItemID: "item1"}, http.StatusBadRequest, 0, 0, nil},
+		{"another player", ss, sID, &PurchaseRequestBody{PlayerID: "player2", ItemID: energyPack.ItemID}, http.StatusForbidden, 0, 0, nil},
+		{"unknown player", ss, "session2", &PurchaseRequestBody{PlayerID: "player2", ItemID: energyPack.ItemID}, http.StatusNotFound, 0, 0, nil},
+		{"energy pack", ss, sID, &PurchaseRequestBody{PlayerID: "player1", ItemID: energyPack.ItemID}, http.StatusOK, player.Energy + energyPack.EnergyAmount, startingCoins - energyPack.Price, []data.InventoryItem{}},
+		{"dice skin, insufficient coins", ss, sID, &PurchaseRequestBody{PlayerID: "player1", ItemID: diceSkin.ItemID}, http.StatusPaymentRequired, 0, 0, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			err2 := json.NewEncoder(buf).Encode(test.requestBody)
+			if err2 != nil {
+				t.Fatal("could not encode the request body: " + err2.Error())
+			}
+
+			newReq := httptest.NewRequest(http.MethodPost, "/shop/purchase", buf)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			shopServer := test.server
+			testsetup.WithSession(shopServer, sessions, shopServer.HandlePurchaseRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &PlayerSnapshot{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				// energy may have regenerated a little while the test was running
+				if gotResponseBody.Player.Energy < test.wantEnergy {
+					t.Errorf("handler gave incorrect energy, want at least: %v, got: %v", test.wantEnergy, gotResponseBody.Player.Energy)
+				}
+
+				if gotResponseBody.Wallet.Coins != test.wantCoins {
+					t.Errorf("handler gave incorrect coins, want: %v, got: %v", test.wantCoins, gotResponseBody.Wallet.Coins)
+				}
+
+				if !reflect.DeepEqual(gotResponseBody.Inventory.Items, test.wantInventory) {
+					t.Errorf("handler gave incorrect inventory, want: %v, got: %v", test.wantInventory, gotResponseBody.Inventory.Items)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Purchase(t *testing.T) {
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dataServer := data.NewServer()
+	profileServer := profile.NewServer(as, dataServer)
+	ss := NewServer(as, dataServer, profileServer)
+
+	_, err = testsetup.SetupTestProfile("player1", sID, profileServer.HandleNewPlayerRequest)
+	if err != nil {
+		t.Fatal("profile setup error: " + err.Error())
+	}
+
+	// give the player enough coins for a dice skin
+	diceSkin, _ := config.Config.ShopItem("skin-golden")
+	_, err = dataServer.InitWallet(context.Background(), &data.WalletData{PlayerID: "player1", Coins: diceSkin.Price})
+	if err != nil {
+		t.Fatal("wallet setup error: " + err.Error())
+	}
+
+	snapshot, err := ss.Purchase(context.Background(), "player1", diceSkin.ItemID)
+	if err != nil {
+		t.Fatal("purchase error: " + err.Error())
+	}
+
+	if snapshot.Wallet.Coins != 0 {
+		t.Errorf("purchase gave incorrect coins, want: %v, got: %v", 0, snapshot.Wallet.Coins)
+	}
+
+	wantInventory := []data.InventoryItem{{ItemID: diceSkin.ItemID, Count: 1}}
+	if !reflect.DeepEqual(snapshot.Inventory.Items, wantInventory) {
+		t.Errorf("purchase gave incorrect inventory, want: %v, got: %v", wantInventory, snapshot.Inventory.Items)
+	}
+
+	// a dice skin can only be bought once, and the wallet is left untouched
+	_, err = dataServer.AdjustWallet(context.Background(), "player1", diceSkin.Price)
+	if err != nil {
+		t.Fatal("wallet setup error: " + err.Error())
+	}
+
+	_, err = ss.Purchase(context.Background(), "player1", diceSkin.ItemID)
+	if err == nil || err.Error() != (ItemAlreadyOwnedErr{"player1", diceSkin.ItemID}).Error() {
+		t.Errorf("purchase gave incorrect error, want: %v, got: %v", ItemAlreadyOwnedErr{"player1", diceSkin.ItemID}, err)
+	}
+
+	wallet, err := dataServer.ReadWallet(context.Background(), "player1")
+	if err != nil {
+		t.Fatal("read wallet error: " + err.Error())
+	}
+
+	if wallet.Coins != diceSkin.Price {
+		t.Errorf("wallet has incorrect coins, want: %v, got: %v", diceSkin.Price, wallet.Coins)
+	}
+
+	// an energy boost is activated right away, and does not go to the inventory
+	boost, _ := config.Config.ShopItem("boost-regen-2x")
+	_, err = dataServer.AdjustWallet(context.Background(), "player1", boost.Price-diceSkin.Price)
+	if err != nil {
+		t.Fatal("wallet setup error: " + err.Error())
+	}
+
+	snapshot, err = ss.Purchase(context.Background(), "player1", boost.ItemID)
+	if err != nil {
+		t.Fatal("purchase error: " + err.Error())
+	}
+
+	if len(snapshot.Player.Boosts) != 1 || snapshot.Player.Boosts[0].BoostID != boost.ItemID || snapshot.Player.Boosts[0].RegenMultiplier != boost.RegenMultiplier {
+		t.Errorf("purchase gave incorrect boosts, want: %v, got: %v", boost.ItemID, snapshot.Player.Boosts)
+	}
+
+	if !reflect.DeepEqual(snapshot.Inventory.Items, wantInventory) {
+		t.Errorf("purchase gave incorrect inventory, want: %v, got: %v", wantInventory, snapshot.Inventory.Items)
+	}
+}
@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"net/http"
+)
+
+// LevelStatsChange is how a stats update changed the stats of the level it was for (and the win streak of the player),
+// so clients can show it without diffing the stats from before and after the update themselves. The best score of a
+// level which was never won is the default level score
+type LevelStatsChange struct {
+	Level             int32 `json:"level"`
+	WinCountDelta     int32 `json:"winCountDelta"`
+	LossCountDelta    int32 `json:"lossCountDelta"`
+	PreviousBestScore int32 `json:"previousBestScore"`
+	BestScore         int32 `json:"bestScore"`
+	BestScoreImproved bool  `json:"bestScoreImproved,omitempty"`
+	PreviousWinStreak int32 `json:"previousWinStreak"`
+	WinStreak         int32 `json:"winStreak"`
+}
+
+// StatsUpdate is the result of a stats update: the updated stats of the player, and how the update changed them
+type StatsUpdate struct {
+	Stats  data.PlayerStats `json:"stats"`
+	Change LevelStatsChange `json:"change"`
+}
+
+// ApplyLevelStatsUpdate applies the given level stats delta to the given player stats (see ApplyLevelStatsDelta),
+// and returns how it changed them
+func ApplyLevelStatsUpdate(playerStats *data.PlayerStats, newStatsDelta *data.PlayerLevelStats) LevelStatsChange {
+
+	previous := levelStatsOf(playerStats, newStatsDelta.Level)
+	previousWinStreak := playerStats.WinStreak
+
+	ApplyLevelStatsDelta(playerStats, newStatsDelta)
+
+	updated := levelStatsOf(playerStats, newStatsDelta.Level)
+
+	return LevelStatsChange{
+		Level:             newStatsDelta.Level,
+		WinCountDelta:     updated.WinCount - previous.WinCount,
+		LossCountDelta:    updated.LossCount - previous.LossCount,
+		PreviousBestScore: previous.BestScore,
+		BestScore:         updated.BestScore,
+		BestScoreImproved: updated.BestScore < previous.BestScore,
+		PreviousWinStreak: previousWinStreak,
+		WinStreak:         playerStats.WinStreak,
+	}
+}
+
+// levelStatsOf returns the stats of the given level from the given player stats,
+// or empty ones (with the default level score) if the player has none for it yet
+func levelStatsOf(playerStats *data.PlayerStats, level int32) data.PlayerLevelStats {
+
+	levelIndex := level - 1
+	if levelIndex >= 0 && levelIndex < int32(len(playerStats.LevelStats)) {
+		return playerStats.LevelStats[levelIndex]
+	}
+
+	return data.PlayerLevelStats{Level: level, BestScore: config.Config.DefaultLevelScore}
+}
+
+// HandleUpdatePlayerStatsWithChangeRequest is a wrapper around the UpdatePlayerStats() method which will
+// be used to field internal (server to server) requests to update player stats, responding with how they changed
+func (ss *Server) HandleUpdatePlayerStatsWithChangeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a PlayerIDLevelStats struct
+	decodedReq := &PlayerIDLevelStats{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ss.logger.Printf("update stats request for id: %v", decodedReq.PlayerID)
+
+	// try to update the stats
+	update, err := ss.UpdatePlayerStats(r.Context(), decodedReq.PlayerID, &decodedReq.LevelStatsDelta)
+	if err != nil {
+		errMsg := "error: could not update player stats: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// create and send the response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(update)
+	if err != nil {
+		errMsg := "error: could not encode stats update: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
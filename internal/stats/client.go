@@ -0,0 +1,275 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var clientNilError = fmt.Errorf("provided stats client pointer is nil")
+
+// StatsClient implementor can update a player's level stats and return all their stats (and how the update changed them),
+// record the results of head-to-head matches (which also updates the ratings of the players),
+// return the recent form (wins and losses over the latest attempts) of a player, and count their prestiges
+// (implemented by the stats Server itself for in-process use, and by HTTPClient
+// when the stats service runs as its own microservice)
+type StatsClient interface {
+	ReturnUpdatedPlayerStats(ctx context.Context, newStatsDelta *data.PlayerLevelStats) (*data.PlayerStats, error)
+	UpdatePlayerStats(ctx context.Context, playerID string, newStatsDelta *data.PlayerLevelStats) (*StatsUpdate, error)
+	RecordMatchResult(ctx context.Context, result *MatchResult) error
+	ReturnRecentForm(ctx context.Context, playerID string, attempts int32) (*RecentForm, error)
+	RecordPrestige(ctx context.Context, playerID string) error
+}
+
+// HTTPClient is the StatsClient implementation which makes internal (server to server) requests to the stats service
+type HTTPClient struct {
+	baseURL string
+}
+
+// NewHTTPClient returns an initialized pointer to a stats client that talks to the stats service at its address in the startup config (see startup.ServiceURL)
+func NewHTTPClient() *HTTPClient {
+	return &HTTPClient{
+		baseURL: startup.ServiceURL("stats"),
+	}
+}
+
+// ReturnUpdatedPlayerStats makes an internal request to the stats service to update the stats of the player of the context
+func (hc *HTTPClient) ReturnUpdatedPlayerStats(ctx context.Context, newStatsDelta *data.PlayerLevelStats) (*data.PlayerStats, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	playerID, err := playerctx.RequirePlayerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if newStatsDelta == nil {
+		return nil, fmt.Errorf("provided new stats pointer is nil")
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err = json.NewEncoder(reqBody).Encode(&PlayerIDLevelStats{
+		PlayerID:        playerID,
+		LevelStatsDelta: *newStatsDelta,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/stats/player-stats-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal update stats request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the player stats
+	playerStats := &data.PlayerStats{}
+	err = json.NewDecoder(resp.Body).Decode(playerStats)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerStats, nil
+}
+
+// UpdatePlayerStats makes an internal request to the stats service to update the required player stats,
+// and return them along with how they changed
+func (hc *HTTPClient) UpdatePlayerStats(ctx context.Context, playerID string, newStatsDelta *data.PlayerLevelStats) (*StatsUpdate, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	if newStatsDelta == nil {
+		return nil, fmt.Errorf("provided new stats pointer is nil")
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(&PlayerIDLevelStats{
+		PlayerID:        playerID,
+		LevelStatsDelta: *newStatsDelta,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/stats/player-stats-update-internal", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal update stats request was not successful, status code %v", resp.StatusCode)
+	}
+
+	//decode the response for the stats update
+	update := &StatsUpdate{}
+	err = json.NewDecoder(resp.Body).Decode(update)
+	if err != nil {
+		return nil, err
+	}
+
+	return update, nil
+}
+
+// RecordMatchResult makes an internal request to the stats service to record the result of a match
+func (hc *HTTPClient) RecordMatchResult(ctx context.Context, result *MatchResult) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	if result == nil {
+		return fmt.Errorf("provided match result pointer is nil")
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(result)
+	if err != nil {
+		return err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/stats/match-internal", reqBody)
+	if err != nil {
+		return err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("internal record match result request was not successful, status code %v", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// ReturnRecentForm makes an internal request to the stats service for the recent form of the player
+func (hc *HTTPClient) ReturnRecentForm(ctx context.Context, playerID string, attempts int32) (*RecentForm, error) {
+
+	if hc == nil {
+		return nil, clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := hc.baseURL + "/stats/recent-form-internal/" + url.PathEscape(playerID) + "?attempts=" + strconv.Itoa(int(attempts))
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("internal recent form request was not successful, status code %v", resp.StatusCode)
+	}
+
+	// decode the response for the recent form
+	form := &RecentForm{}
+	err = json.NewDecoder(resp.Body).Decode(form)
+	if err != nil {
+		return nil, err
+	}
+
+	return form, nil
+}
+
+// RecordPrestige makes an internal request to the stats service to count a prestige of the player
+func (hc *HTTPClient) RecordPrestige(ctx context.Context, playerID string) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request
+	reqURL := fmt.Sprintf("%v/stats/prestige-internal/%v", hc.baseURL, url.PathEscape(playerID))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, nil)
+	if err != nil {
+		return err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("internal record prestige request was not successful, status code %v", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+// the invariants checked by the consistency audit
+const (
+	CheckLevelInRange       = "levelInRange"           // the level of the player is between 1 and the level count
+	CheckStatsUnlocked      = "statsForUnlockedLevels" // the player only has stats for the levels they unlocked
+	CheckStatsMatchAttempts = "statsMatchAttempts"     // the win and loss counts of each level match the attempt history
+)
+
+// ConsistencyIssue is a broken invariant of a player (the level is left out for the checks which are not about a level),
+// along with a suggestion of how to repair it
+type ConsistencyIssue struct {
+	PlayerID   string `json:"playerID"`
+	Check      string `json:"check"`
+	Level      int32  `json:"level,omitempty"`
+	Detail     string `json:"detail"`
+	Suggestion string `json:"suggestion"`
+}
+
+// ConsistencyReport is the response of the consistency audit, when it is run on a page of the players,
+// the next cursor is the one of the next page (blank on the last page)
+type ConsistencyReport struct {
+	PlayersChecked int                `json:"playersChecked"`
+	Issues         []ConsistencyIssue `json:"issues"`
+	NextCursor     string             `json:"nextCursor,omitempty"`
+}
+
+// AuditConsistency checks the invariants between the data of the given player (from the profile), their stats and their
+// attempt history, or of the players in the given page of the players listing if no player id is given. Nothing is
+// written, every broken invariant is reported with a suggestion of how to repair it
+func (ss *Server) AuditConsistency(ctx context.Context, playerID string, page pagination.Request) (*ConsistencyReport, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.AuditConsistency")
+	defer span.End()
+
+	report := &ConsistencyReport{Issues: []ConsistencyIssue{}}
+
+	var players []data.PlayerData
+	if playerID != "" {
+		player, err := ss.dataClient.ReadPlayer(ctx, playerID)
+		if err != nil {
+			return nil, err
+		}
+		players = []data.PlayerData{*player}
+	} else {
+		playersPage, err := ss.dataClient.ListPlayers(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		players = playersPage.Players
+		report.NextCursor = playersPage.NextCursor
+	}
+
+	for _, player := range players {
+		issues, err := ss.auditPlayer(ctx, player)
+		if err != nil {
+			return nil, err
+		}
+		report.PlayersChecked += 1
+		report.Issues = append(report.Issues, issues...)
+	}
+
+	return report, nil
+}
+
+// auditPlayer returns the broken invariants of the given player
+func (ss *Server) auditPlayer(ctx context.Context, player data.PlayerData) ([]ConsistencyIssue, error) {
+
+	// hold the lock while the stats and attempts are read, so a stats update is never seen half way through
+	ss.statsMutex.Lock()
+	defer ss.statsMutex.Unlock()
+
+	// stats which are missing entirely are treated as empty
+	plStats := &data.PlayerStats{}
+	readStats, err := ss.dataClient.ReadStats(ctx, player.PlayerID)
+	if err == nil {
+		plStats = readStats
+	} else if !errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: player.PlayerID}) {
+		return nil, err
+	}
+
+	attempts, err := ss.dataClient.ReadAttempts(ctx, player.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+
+	issues := []ConsistencyIssue{}
+	repairSuggestion := fmt.Sprintf("rebuild the stats from the attempt history with POST /stats/admin/repair/%v (with dryRun=true first, to see the changes)", player.PlayerID)
+
+	levelCount := config.Config.LevelCount()
+	if player.Level < 1 || player.Level > levelCount {
+		issues = append(issues, ConsistencyIssue{
+			PlayerID:   player.PlayerID,
+			Check:      CheckLevelInRange,
+			Detail:     fmt.Sprintf("level %v is not between 1 and the level count (%v)", player.Level, levelCount),
+			Suggestion: fmt.Sprintf("set the level to %v with PUT /profile/admin/player", min(max(player.Level, 1), levelCount)),
+		})
+	}
+
+	// the attempt counts of each level (wins and losses)
+	wins := map[int32]int32{}
+	losses := map[int32]int32{}
+	highestAttempted := int32(0)
+	for _, attempt := range attempts {
+		if attempt.Won {
+			wins[attempt.Level] += 1
+		} else {
+			losses[attempt.Level] += 1
+		}
+		highestAttempted = max(highestAttempted, attempt.Level)
+	}
+
+	// a player who prestiged has unlocked every level before
+	unlocked := player.Level
+	if player.PrestigeRank > 0 {
+		unlocked = levelCount
+	}
+
+	for _, levelStats := range plStats.LevelStats {
+		if levelStats.Level >= 1 && levelStats.Level <= unlocked {
+			continue
+		}
+
+		suggestion := repairSuggestion
+		if levelStats.Level > unlocked && levelStats.Level <= highestAttempted && highestAttempted <= levelCount {
+			// the attempt history says the player played the level, so it is the level of the player which is behind
+			suggestion = fmt.Sprintf("the attempt history has attempts up to level %v, so raise the level to %v with PUT /profile/admin/player", highestAttempted, highestAttempted)
+		}
+
+		issues = append(issues, ConsistencyIssue{
+			PlayerID:   player.PlayerID,
+			Check:      CheckStatsUnlocked,
+			Level:      levelStats.Level,
+			Detail:     fmt.Sprintf("there are stats for level %v, but only the levels up to %v are unlocked", levelStats.Level, unlocked),
+			Suggestion: suggestion,
+		})
+	}
+
+	// every level which has stats or attempts is compared
+	statsOf := map[int32]data.PlayerLevelStats{}
+	for _, levelStats := range plStats.LevelStats {
+		statsOf[levelStats.Level] = levelStats
+	}
+
+	for level := int32(1); level <= max(int32(len(plStats.LevelStats)), highestAttempted); level++ {
+		levelStats := statsOf[level]
+		if levelStats.WinCount == wins[level] && levelStats.LossCount == losses[level] {
+			continue
+		}
+
+		issues = append(issues, ConsistencyIssue{
+			PlayerID:   player.PlayerID,
+			Check:      CheckStatsMatchAttempts,
+			Level:      level,
+			Detail:     fmt.Sprintf("the stats have %v wins and %v losses, the attempt history has %v wins and %v losses", levelStats.WinCount, levelStats.LossCount, wins[level], losses[level]),
+			Suggestion: repairSuggestion,
+		})
+	}
+
+	return issues, nil
+}
+
+// HandleConsistencyAuditRequest checks the invariants between the player data, the stats and the attempt history (admin only),
+// of the player given by the 'id' query parameter, or of a page of the players (see pagination), and responds with the report
+func (ss *Server) HandleConsistencyAuditRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	page, err := pagination.ParseRequest(r.URL.Query())
+	if err != nil {
+		errMsg := "error: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	ss.logger.Printf("received consistency audit request, for id: %q", id)
+
+	report, err := ss.AuditConsistency(r.Context(), id, page)
+	if err != nil {
+		errMsg := "consistency audit error: " + err.Error()
+		ss.logger.Println(errMsg)
+		switch err.(type) {
+		case data.PlayerNotFoundErr:
+			http.Error(w, errMsg, http.StatusNotFound)
+		default:
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ss.logger.Printf("consistency audit checked %v players, found %v issues", report.PlayersChecked, len(report.Issues))
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		errMsg := "error: could not encode consistency report: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
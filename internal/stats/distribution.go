@@ -0,0 +1,168 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ScorePercentiles are percentiles of the best scores (rolls it took to win) of the players who have won a level,
+// lower is better, so the 25th percentile is the score a quarter of those players matched or beat
+type ScorePercentiles struct {
+	P25 int32 `json:"p25"`
+	P50 int32 `json:"p50"`
+	P75 int32 `json:"p75"`
+	P90 int32 `json:"p90"`
+}
+
+// LevelDistribution sums up how all the players have done at a level, based on their attempt history,
+// so designers can see which levels are too hard (or too easy)
+type LevelDistribution struct {
+	Level             int32            `json:"level"`
+	Players           int32            `json:"players"`
+	Attempts          int32            `json:"attempts"`
+	Wins              int32            `json:"wins"`
+	WinRate           float64          `json:"winRate"`
+	AverageRollsToWin float64          `json:"averageRollsToWin"`
+	BestScores        ScorePercentiles `json:"bestScorePercentiles"`
+	ComputedAt        int64            `json:"computedAt"`
+}
+
+// HandleLevelDistributionRequest responds with the distribution of the results of all players at the requested level,
+// which is computed at most once every LevelDistributionCacheSeconds per level
+func (ss *Server) HandleLevelDistributionRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	if !ss.flags.Enabled(r.Context(), config.FlagLevelDistribution, "") {
+		errMsg := "error: the level distribution is turned off"
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusServiceUnavailable, apierror.CodeUnavailable)
+		return
+	}
+
+	level, err := strconv.Atoi(r.PathValue("level"))
+	if _, ok := config.Config.Level(int32(level)); err != nil || !ok {
+		errMsg := "error: invalid level in request"
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	distribution, err := ss.LevelDistribution(r.Context(), int32(level))
+	if err != nil {
+		errMsg := "DB read error: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(distribution)
+	if err != nil {
+		errMsg := "error: could not encode level distribution: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// LevelDistribution returns the distribution of the results of all players at the given level,
+// a cached distribution is returned if it was computed less than LevelDistributionCacheSeconds ago
+func (ss *Server) LevelDistribution(ctx context.Context, level int32) (*LevelDistribution, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.LevelDistribution")
+	defer span.End()
+
+	unixNow := time.Now().UTC().Unix()
+
+	ss.distributionsMutex.Lock()
+	cached, ok := ss.distributions[level]
+	ss.distributionsMutex.Unlock()
+
+	if ok && unixNow-cached.ComputedAt < constants.LevelDistributionCacheSeconds {
+		return cached, nil
+	}
+
+	attempts, err := ss.dataClient.ReadLevelAttempts(ctx, level)
+	if err != nil {
+		return nil, err
+	}
+
+	distribution := &LevelDistribution{Level: level, ComputedAt: unixNow}
+
+	bestScores := map[string]int32{}
+	rollsToWin := int64(0)
+	for _, attempt := range attempts {
+		distribution.Attempts++
+		if _, seen := bestScores[attempt.PlayerID]; !seen {
+			bestScores[attempt.PlayerID] = 0
+			distribution.Players++
+		}
+
+		if !attempt.Won {
+			continue
+		}
+
+		distribution.Wins++
+		rollsToWin += int64(attempt.Score)
+		if best := bestScores[attempt.PlayerID]; best == 0 || attempt.Score < best {
+			bestScores[attempt.PlayerID] = attempt.Score
+		}
+	}
+
+	if distribution.Attempts > 0 {
+		distribution.WinRate = float64(distribution.Wins) / float64(distribution.Attempts)
+	}
+
+	if distribution.Wins > 0 {
+		distribution.AverageRollsToWin = float64(rollsToWin) / float64(distribution.Wins)
+	}
+
+	// only the players who have won the level have a best score
+	winnerScores := []int32{}
+	for _, score := range bestScores {
+		if score > 0 {
+			winnerScores = append(winnerScores, score)
+		}
+	}
+	slices.Sort(winnerScores)
+
+	distribution.BestScores = ScorePercentiles{
+		P25: percentile(winnerScores, 25),
+		P50: percentile(winnerScores, 50),
+		P75: percentile(winnerScores, 75),
+		P90: percentile(winnerScores, 90),
+	}
+
+	ss.distributionsMutex.Lock()
+	ss.distributions[level] = distribution
+	ss.distributionsMutex.Unlock()
+
+	return distribution, nil
+}
+
+// percentile returns the given percentile of the sorted values (using the nearest rank method), or 0 if there are none
+func percentile(sorted []int32, p int) int32 {
+
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,520 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFormatCSV is the format of the stats export (also the extension of its chunk files)
+const ExportFormatCSV = "csv"
+
+// the progress of the latest export is kept in this file of the export store, so an interrupted export can resume
+const exportProgressName = "export-progress.json"
+
+// export ids are the time the export started (in this layout) with a prefix, and the chunk files of an export
+// are its id with the number of the chunk, so they sort in the order they were written
+const exportIDPrefix = "stats-export-"
+const exportTimeLayout = "20060102T150405Z"
+
+var exportDisabledError = fmt.Errorf("the stats export is not enabled")
+var exportRunningError = fmt.Errorf("a stats export is running already")
+var noExportError = fmt.Errorf("no stats export was started yet")
+var invalidExportNameError = fmt.Errorf("invalid export file name")
+
+// exportHeader is the header row of every chunk file, each of the other rows holds the stats of a level of a player
+// (players without level stats get a single row, with the level columns left blank)
+var exportHeader = []string{"playerID", "rating", "prestigeCount", "winStreak", "level", "winCount", "lossCount", "bestScore"}
+
+// ExportStore implementor can keep the files of the stats export, like in a directory or an S3 compatible object store,
+// files are identified by their names. Read should fail with an error matching fs.ErrNotExist if there is no such file
+type ExportStore interface {
+	Write(name string, contents []byte) error
+	Read(name string) ([]byte, error)
+}
+
+// FileExportStore is the ExportStore implementation which keeps every export file in a directory
+type FileExportStore struct {
+	dir string
+}
+
+// NewFileExportStore returns an initialized pointer to a file export store, creating its directory if needed
+func NewFileExportStore(dir string) (*FileExportStore, error) {
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileExportStore{dir: dir}, nil
+}
+
+// Write writes the contents to the file (via a temporary file, so a failed write never leaves a partial chunk)
+func (fes *FileExportStore) Write(name string, contents []byte) error {
+
+	path, err := fes.path(name)
+	if err != nil {
+		return err
+	}
+
+	tempPath := path + ".tmp"
+	err = os.WriteFile(tempPath, contents, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+// Read returns the contents of the file
+func (fes *FileExportStore) Read(name string) ([]byte, error) {
+
+	path, err := fes.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+// path returns the path of the file with the given name, making sure it stays inside the store's directory
+func (fes *FileExportStore) path(name string) (string, error) {
+
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", invalidExportNameError
+	}
+
+	return filepath.Join(fes.dir, name), nil
+}
+
+// ExportProgress is the progress of a stats export: the chunk files written so far, and the cursor of the stats listing
+// to continue from, it is saved to the export store after every chunk. Running is only set while the export runs
+type ExportProgress struct {
+	ExportID   string   `json:"exportID"`
+	Format     string   `json:"format"`
+	Namespace  string   `json:"namespace,omitempty"`
+	StartTime  int64    `json:"startTime"`
+	UpdateTime int64    `json:"updateTime"`
+	Cursor     string   `json:"cursor,omitempty"`
+	Files      []string `json:"files"`
+	Players    int      `json:"players"`
+	Rows       int      `json:"rows"`
+	Done       bool     `json:"done"`
+	Running    bool     `json:"running"`
+	LastError  string   `json:"lastError,omitempty"`
+}
+
+// EnableExportFromEnv enables the stats export to a file export store in the directory given by the stats export dir
+// environment variable (see constants.StatsExportDirEnvVar), the export stays disabled if it is not set
+func (ss *Server) EnableExportFromEnv() error {
+
+	if ss == nil {
+		return serverNilError
+	}
+
+	dir := os.Getenv(constants.StatsExportDirEnvVar)
+	if dir == "" {
+		return nil
+	}
+
+	store, err := NewFileExportStore(dir)
+	if err != nil {
+		return err
+	}
+
+	ss.EnableExport(store, constants.StatsExportChunkSize)
+	return nil
+}
+
+// EnableExport sets the store the stats export writes to, and how many players go in each of its chunk files
+// (at most pagination.MaxLimit)
+func (ss *Server) EnableExport(store ExportStore, chunkSize int) {
+
+	if ss == nil {
+		return
+	}
+
+	ss.exportMutex.Lock()
+	defer ss.exportMutex.Unlock()
+
+	ss.exportStore = store
+	ss.exportChunkSize = min(max(chunkSize, 1), pagination.MaxLimit)
+
+	ss.logger.Printf("stats export enabled, in chunks of %v players", ss.exportChunkSize)
+}
+
+// Export exports all the player stats (of the namespace) to csv files in the export store, one chunk of players at
+// a time, and returns its progress. An export which did not finish (like one interrupted by an error or a restart)
+// is continued from its last written chunk, unless restart is set, in which case a new export is started
+func (ss *Server) Export(ctx context.Context, restart bool) (*ExportProgress, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	progress, err := ss.beginExport(ctx, restart)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ss.continueExport(ctx, progress)
+	return progress, err
+}
+
+// StartExport starts (or continues) the export like Export, but in the background,
+// and returns its progress at the start (see ExportStatus for the progress after that)
+func (ss *Server) StartExport(ctx context.Context, restart bool) (*ExportProgress, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	progress, err := ss.beginExport(ctx, restart)
+	if err != nil {
+		return nil, err
+	}
+
+	started := *progress
+	started.Files = append([]string{}, progress.Files...)
+
+	// the export outlives the request, but keeps the namespace it exports
+	go func() {
+		_ = ss.continueExport(namespace.NewContext(context.Background(), progress.Namespace), progress)
+	}()
+
+	return &started, nil
+}
+
+// ExportStatus returns the progress of the latest export (whether it is running, done, or was interrupted)
+func (ss *Server) ExportStatus(ctx context.Context) (*ExportProgress, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "stats.ExportStatus")
+	defer span.End()
+
+	ss.exportMutex.Lock()
+	defer ss.exportMutex.Unlock()
+
+	if ss.exportStore == nil {
+		return nil, exportDisabledError
+	}
+
+	progress, err := ss.readExportProgress()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, noExportError
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	progress.Running = ss.exportRunning
+	return progress, nil
+}
+
+// beginExport marks the export as running, and returns the progress of the export to run: the unfinished one in the
+// store, or a new one (when there is none, the latest one is done, or restart is set), it fails if one is running already
+func (ss *Server) beginExport(ctx context.Context, restart bool) (*ExportProgress, error) {
+
+	ss.exportMutex.Lock()
+	defer ss.exportMutex.Unlock()
+
+	if ss.exportStore == nil {
+		return nil, exportDisabledError
+	}
+
+	if ss.exportRunning {
+		return nil, exportRunningError
+	}
+
+	progress, err := ss.readExportProgress()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err != nil || progress.Done || restart {
+		timeNow := time.Now().UTC()
+		progress = &ExportProgress{
+			ExportID:   exportIDPrefix + timeNow.Format(exportTimeLayout),
+			Format:     ExportFormatCSV,
+			Namespace:  namespace.FromContext(ctx),
+			StartTime:  timeNow.Unix(),
+			UpdateTime: timeNow.Unix(),
+			Files:      []string{},
+		}
+	} else {
+		ss.logger.Printf("continuing stats export %v after %v chunks", progress.ExportID, len(progress.Files))
+	}
+
+	progress.Running = true
+	progress.LastError = ""
+
+	err = ss.writeExportProgress(progress)
+	if err != nil {
+		return nil, err
+	}
+
+	ss.exportRunning = true
+	return progress, nil
+}
+
+// continueExport writes the rest of the chunks of the given export, saving its progress after each one,
+// an error stops the export (its progress keeps the error, so it can be continued later)
+func (ss *Server) continueExport(ctx context.Context, progress *ExportProgress) error {
+
+	ctx, span := tracing.Start(ctx, "stats.Export")
+	defer span.End()
+
+	defer func() {
+		ss.exportMutex.Lock()
+		ss.exportRunning = false
+		ss.exportMutex.Unlock()
+	}()
+
+	// the export is the heaviest read of all, so it goes to the data follower when there is one
+	dataClient := ss.readClient()
+
+	for !progress.Done {
+		page, err := dataClient.ListStats(ctx, pagination.Request{Cursor: progress.Cursor, Limit: ss.exportChunkSize})
+		if err != nil {
+			return ss.failExport(progress, err)
+		}
+
+		if len(page.Stats) > 0 {
+			contents, rows, err := encodeStatsCSV(page.Stats)
+			if err != nil {
+				return ss.failExport(progress, err)
+			}
+
+			name := fmt.Sprintf("%v-%06d.%v", progress.ExportID, len(progress.Files)+1, progress.Format)
+			err = ss.exportStore.Write(name, contents)
+			if err != nil {
+				return ss.failExport(progress, err)
+			}
+
+			progress.Files = append(progress.Files, name)
+			progress.Players += len(page.Stats)
+			progress.Rows += rows
+		}
+
+		progress.Cursor = page.NextCursor
+		progress.Done = page.NextCursor == ""
+		progress.Running = !progress.Done
+		progress.UpdateTime = time.Now().UTC().Unix()
+
+		err = ss.writeExportProgress(progress)
+		if err != nil {
+			return ss.failExport(progress, err)
+		}
+	}
+
+	ss.logger.Printf("stats export %v done, %v players in %v files", progress.ExportID, progress.Players, len(progress.Files))
+	return nil
+}
+
+// failExport records the given error in the progress of the export (as far as it can), and returns it
+func (ss *Server) failExport(progress *ExportProgress, err error) error {
+
+	ss.logger.Printf("error: stats export %v stopped after %v chunks: %v", progress.ExportID, len(progress.Files), err)
+
+	// the progress in the store still points at the last chunk written, only the error is added
+	stored, readErr := ss.readExportProgress()
+	if readErr == nil && stored.ExportID == progress.ExportID {
+		stored.Running = false
+		stored.LastError = err.Error()
+		stored.UpdateTime = time.Now().UTC().Unix()
+		_ = ss.writeExportProgress(stored)
+	}
+
+	progress.Running = false
+	progress.LastError = err.Error()
+	return err
+}
+
+// readExportProgress reads the progress of the latest export from the export store
+func (ss *Server) readExportProgress() (*ExportProgress, error) {
+
+	encoded, err := ss.exportStore.Read(exportProgressName)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &ExportProgress{}
+	err = json.Unmarshal(encoded, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	return progress, nil
+}
+
+// writeExportProgress writes the progress of the export to the export store
+func (ss *Server) writeExportProgress(progress *ExportProgress) error {
+
+	encoded, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	return ss.exportStore.Write(exportProgressName, encoded)
+}
+
+// encodeStatsCSV encodes the given player stats as a csv file (see exportHeader), and returns it with its number of rows
+func encodeStatsCSV(stats []data.PlayerStatsWithID) ([]byte, int, error) {
+
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+
+	err := writer.Write(exportHeader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	itoa := func(value int32) string { return strconv.Itoa(int(value)) }
+
+	rows := 0
+	for _, entry := range stats {
+		player := []string{entry.PlayerID, itoa(entry.PlayerStats.Rating), itoa(entry.PlayerStats.PrestigeCount), itoa(entry.PlayerStats.WinStreak)}
+
+		if len(entry.PlayerStats.LevelStats) == 0 {
+			err = writer.Write(append(player, "", "", "", ""))
+			if err != nil {
+				return nil, 0, err
+			}
+			rows++
+			continue
+		}
+
+		for _, levelStats := range entry.PlayerStats.LevelStats {
+			err = writer.Write(append(player, itoa(levelStats.Level), itoa(levelStats.WinCount), itoa(levelStats.LossCount), itoa(levelStats.BestScore)))
+			if err != nil {
+				return nil, 0, err
+			}
+			rows++
+		}
+	}
+
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		return nil, 0, err
+	}
+
+	return buf.Bytes(), rows, nil
+}
+
+// HandleExportRequest starts an export of all the player stats in the background (admin only), continuing the latest
+// one if it did not finish, or starting a new one if the 'restart' query parameter is true, and responds with its progress
+func (ss *Server) HandleExportRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	restart := false
+	if restartParam := r.URL.Query().Get("restart"); restartParam != "" {
+		restart, err = strconv.ParseBool(restartParam)
+		if err != nil {
+			errMsg := "error: invalid restart parameter: " + err.Error()
+			ss.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ss.logger.Printf("received stats export request, restart: %v", restart)
+
+	progress, err := ss.StartExport(r.Context(), restart)
+	if err != nil {
+		errMsg := "stats export error: " + err.Error()
+		ss.logger.Println(errMsg)
+		ss.writeExportError(w, err, errMsg)
+		return
+	}
+
+	ss.writeExportProgressResponse(w, progress, http.StatusAccepted)
+}
+
+// HandleExportStatusRequest responds with the progress of the latest export of the player stats (admin only)
+func (ss *Server) HandleExportStatusRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	progress, err := ss.ExportStatus(r.Context())
+	if err != nil {
+		errMsg := "stats export status error: " + err.Error()
+		ss.logger.Println(errMsg)
+		ss.writeExportError(w, err, errMsg)
+		return
+	}
+
+	ss.writeExportProgressResponse(w, progress, http.StatusOK)
+}
+
+// writeExportError responds with the status matching the given export error
+func (ss *Server) writeExportError(w http.ResponseWriter, err error, errMsg string) {
+
+	switch {
+	case errors.Is(err, exportDisabledError):
+		http.Error(w, errMsg, http.StatusServiceUnavailable)
+	case errors.Is(err, exportRunningError):
+		http.Error(w, errMsg, http.StatusConflict)
+	case errors.Is(err, noExportError):
+		http.Error(w, errMsg, http.StatusNotFound)
+	default:
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// writeExportProgressResponse responds with the given export progress and status
+func (ss *Server) writeExportProgressResponse(w http.ResponseWriter, progress *ExportProgress, status int) {
+
+	encoded, err := json.Marshal(progress)
+	if err != nil {
+		errMsg := "error: could not encode export progress: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(encoded)
+}
@@ -0,0 +1,102 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// RecentForm holds the wins and losses of a player over their latest attempts (at any level),
+// it is used as the response for the internal recent form request
+type RecentForm struct {
+	PlayerID string `json:"playerID"`
+	Wins     int32  `json:"wins"`
+	Losses   int32  `json:"losses"`
+}
+
+// Attempts returns the number of attempts the recent form is made up of
+func (form *RecentForm) Attempts() int32 {
+	return form.Wins + form.Losses
+}
+
+// WinRate returns the share of the recent attempts which were won (0 when there are no attempts)
+func (form *RecentForm) WinRate() float64 {
+
+	if form.Attempts() == 0 {
+		return 0
+	}
+
+	return float64(form.Wins) / float64(form.Attempts())
+}
+
+// ReturnRecentForm returns the wins and losses of the player over (up to) their latest given number of attempts,
+// taken from their attempt history (a player without any attempts gets an empty recent form)
+func (ss *Server) ReturnRecentForm(ctx context.Context, playerID string, attempts int32) (*RecentForm, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.ReturnRecentForm")
+	defer span.End()
+
+	if attempts <= 0 {
+		return nil, fmt.Errorf("the number of attempts should be greater than 0, got: %v", attempts)
+	}
+
+	history, err := ss.dataClient.ReadAttempts(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	form := &RecentForm{PlayerID: playerID}
+	for _, attempt := range history[max(0, len(history)-int(attempts)):] {
+		if attempt.Won {
+			form.Wins += 1
+		} else {
+			form.Losses += 1
+		}
+	}
+
+	return form, nil
+}
+
+// HandleRecentFormRequest is a wrapper around the ReturnRecentForm() method which will be used to field
+// internal (server to server) requests for the recent form of a player, over the number of attempts in the 'attempts' query parameter
+func (ss *Server) HandleRecentFormRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attempts, err := strconv.ParseInt(r.URL.Query().Get("attempts"), 10, 32)
+	if err != nil {
+		errMsg := "error: invalid attempts parameter: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	ss.logger.Printf("recent form request for id: %v, attempts: %v", id, attempts)
+
+	form, err := ss.ReturnRecentForm(r.Context(), id, int32(attempts))
+	if err != nil {
+		errMsg := "error: could not return the recent form: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(form)
+	if err != nil {
+		errMsg := "error: could not encode the recent form: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
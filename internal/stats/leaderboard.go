@@ -0,0 +1,250 @@
+package stats
+
+import (
+	"context"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ratingSnapshot is the top of the rating leaderboard of a namespace (up to maxLeaderboardLimit entries),
+// as of the time it was computed
+type ratingSnapshot struct {
+	entries    []data.RatingEntry
+	computedAt int64
+}
+
+// leaderboardRefresh is a recompute of the snapshot of a namespace which is in progress, the reads which need it
+// wait for done to be closed, then share its results
+type leaderboardRefresh struct {
+	done     chan struct{}
+	snapshot *ratingSnapshot
+	err      error
+}
+
+// leaderboardCache keeps a snapshot of the rating leaderboard per namespace, the namespaces which have been read are
+// refreshed at every interval, and the ones marked stale (by a rating change which can move their top) right away
+type leaderboardCache struct {
+	snapshots map[string]*ratingSnapshot
+	stale     map[string]bool
+	mutex     sync.Mutex
+
+	// the refreshes in progress, by namespace, so concurrent reads of a namespace share one
+	refreshing map[string]*leaderboardRefresh
+
+	// a refresh of the stale snapshots is requested by sending on this channel (it holds at most one request)
+	refreshRequests chan struct{}
+
+	interval time.Duration
+}
+
+// EnableLeaderboardCacheFromEnv enables the rating leaderboard cache with the refresh interval given by the environment
+// variable (see constants.LeaderboardRefreshSecondsEnvVar), the leaderboard is read on every request if it is not set
+func (ss *Server) EnableLeaderboardCacheFromEnv() error {
+
+	if ss == nil {
+		return serverNilError
+	}
+
+	intervalEnv := os.Getenv(constants.LeaderboardRefreshSecondsEnvVar)
+	if intervalEnv == "" {
+		return nil
+	}
+
+	interval, err := strconv.Atoi(intervalEnv)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("%v should be a positive number of seconds, got: %v", constants.LeaderboardRefreshSecondsEnvVar, intervalEnv)
+	}
+
+	ss.EnableLeaderboardCache(time.Duration(interval) * time.Second)
+	return nil
+}
+
+// EnableLeaderboardCache makes the server serve the rating leaderboard from a snapshot of its top, which is computed
+// for the namespace of the server once it starts serving (pre-warmed), and recomputed at the given interval, as well as
+// right after a rating change which can move the top of the leaderboard (see noteRatingChange). It should be called
+// before Run, the refreshes stop when the server does (see refreshLeaderboardsUntilDone)
+func (ss *Server) EnableLeaderboardCache(interval time.Duration) {
+
+	if ss == nil {
+		return
+	}
+
+	cache := &leaderboardCache{
+		snapshots:       map[string]*ratingSnapshot{},
+		stale:           map[string]bool{},
+		refreshing:      map[string]*leaderboardRefresh{},
+		refreshRequests: make(chan struct{}, 1),
+		interval:        interval,
+	}
+	ss.leaderboard = cache
+
+	ss.logger.Printf("rating leaderboard cache enabled, refreshed every %v", interval)
+
+	// the namespace of the server is warmed up before the first request for it
+	cache.stale[namespace.Current()] = true
+	cache.refreshRequests <- struct{}{}
+}
+
+// refreshLeaderboardsUntilDone refreshes the rating leaderboard snapshots at the interval of the cache, and the stale
+// ones whenever a refresh is requested, till the context is done (it is started by serve, with the context of the server)
+func (ss *Server) refreshLeaderboardsUntilDone(ctx context.Context) {
+
+	ticker := time.NewTicker(ss.leaderboard.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ss.refreshLeaderboards(false)
+		case <-ss.leaderboard.refreshRequests:
+			ss.refreshLeaderboards(true)
+		}
+	}
+}
+
+// RatingLeaderboard returns the given number of the highest rated players (of the namespace of the context), with the
+// time the entries were computed at. With the leaderboard cache enabled, they come from the snapshot of the namespace
+// (which is computed first, if the namespace has none yet), otherwise they are read from the data service
+func (ss *Server) RatingLeaderboard(ctx context.Context, limit int) (*RatingLeaderboard, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.RatingLeaderboard")
+	defer span.End()
+
+	if ss.leaderboard == nil {
+		entries, err := ss.readClient().ReadRatingLeaderboard(ctx, limit)
+		if err != nil {
+			return nil, err
+		}
+		return &RatingLeaderboard{Entries: entries, ComputedAt: time.Now().UTC().Unix()}, nil
+	}
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	ss.leaderboard.mutex.Lock()
+	snapshot, ok := ss.leaderboard.snapshots[requestNamespace]
+	ss.leaderboard.mutex.Unlock()
+
+	if !ok {
+		var err error
+		snapshot, err = ss.refreshLeaderboard(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &RatingLeaderboard{
+		Entries:    slices.Clone(snapshot.entries[:min(limit, len(snapshot.entries))]),
+		ComputedAt: snapshot.computedAt,
+	}, nil
+}
+
+// refreshLeaderboard recomputes the snapshot of the rating leaderboard of the namespace of the context, and returns it.
+// When the namespace is already being refreshed, it waits for that refresh and returns its results instead
+func (ss *Server) refreshLeaderboard(ctx context.Context) (*ratingSnapshot, error) {
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	ss.leaderboard.mutex.Lock()
+	refresh, ok := ss.leaderboard.refreshing[requestNamespace]
+	if ok {
+		ss.leaderboard.mutex.Unlock()
+		<-refresh.done
+		return refresh.snapshot, refresh.err
+	}
+	refresh = &leaderboardRefresh{done: make(chan struct{})}
+	ss.leaderboard.refreshing[requestNamespace] = refresh
+	ss.leaderboard.mutex.Unlock()
+
+	// the read is shared, so it should not fail for all of them when the request which started it goes away
+	entries, err := ss.readClient().ReadRatingLeaderboard(context.WithoutCancel(ctx), maxLeaderboardLimit)
+	if err == nil {
+		refresh.snapshot = &ratingSnapshot{entries: entries, computedAt: time.Now().UTC().Unix()}
+	}
+	refresh.err = err
+
+	ss.leaderboard.mutex.Lock()
+	if err == nil {
+		ss.leaderboard.snapshots[requestNamespace] = refresh.snapshot
+	}
+	delete(ss.leaderboard.refreshing, requestNamespace)
+	ss.leaderboard.mutex.Unlock()
+
+	close(refresh.done)
+	return refresh.snapshot, refresh.err
+}
+
+// refreshLeaderboards recomputes the snapshots of the rating leaderboard of all the namespaces in the cache
+// (or only the stale ones), errors are logged, and the stale snapshots which could not be refreshed stay stale
+func (ss *Server) refreshLeaderboards(staleOnly bool) {
+
+	ss.leaderboard.mutex.Lock()
+	namespaces := []string{}
+	for cachedNamespace := range ss.leaderboard.stale {
+		namespaces = append(namespaces, cachedNamespace)
+	}
+	if !staleOnly {
+		for cachedNamespace := range ss.leaderboard.snapshots {
+			if !ss.leaderboard.stale[cachedNamespace] {
+				namespaces = append(namespaces, cachedNamespace)
+			}
+		}
+	}
+	clear(ss.leaderboard.stale)
+	ss.leaderboard.mutex.Unlock()
+
+	for _, cachedNamespace := range namespaces {
+		_, err := ss.refreshLeaderboard(namespace.NewContext(context.Background(), cachedNamespace))
+		if err != nil {
+			ss.logger.Printf("error: could not refresh the rating leaderboard of namespace %q: %v", cachedNamespace, err)
+
+			ss.leaderboard.mutex.Lock()
+			ss.leaderboard.stale[cachedNamespace] = true
+			ss.leaderboard.mutex.Unlock()
+		}
+	}
+}
+
+// noteRatingChange requests a refresh of the rating leaderboard snapshot of the namespace of the context when the given
+// new rating of the player can change its top: when the player is on it, or the rating would put them on it
+// (small changes further down the leaderboard wait for the next scheduled refresh)
+func (ss *Server) noteRatingChange(ctx context.Context, playerID string, rating int32) {
+
+	if ss.leaderboard == nil {
+		return
+	}
+
+	requestNamespace := namespace.FromContext(ctx)
+
+	ss.leaderboard.mutex.Lock()
+	snapshot, ok := ss.leaderboard.snapshots[requestNamespace]
+	significant := ok && (len(snapshot.entries) < maxLeaderboardLimit || rating >= snapshot.entries[len(snapshot.entries)-1].Rating ||
+		slices.ContainsFunc(snapshot.entries, func(entry data.RatingEntry) bool { return entry.PlayerID == playerID }))
+	if significant {
+		ss.leaderboard.stale[requestNamespace] = true
+	}
+	ss.leaderboard.mutex.Unlock()
+
+	if !significant {
+		return
+	}
+
+	// a refresh already requested covers this change too
+	select {
+	case ss.leaderboard.refreshRequests <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"encoding/json"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// LevelLeaderboard is used as the client response for the public level leaderboard api
+type LevelLeaderboard struct {
+	Level   int32                 `json:"level"`
+	Entries []data.LevelRankEntry `json:"entries"`
+}
+
+// HandleLevelLeaderboardRequest responds with the best players of the requested level (fewest rolls to win first),
+// the 'limit' query parameter sets the number of entries (10 by default, up to 100)
+func (ss *Server) HandleLevelLeaderboardRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	level, err := strconv.Atoi(r.PathValue("level"))
+	if _, ok := config.Config.Level(int32(level)); err != nil || !ok {
+		errMsg := "error: invalid level in request"
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	limit := defaultLeaderboardLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 || limit > maxLeaderboardLimit {
+			errMsg := fmt.Sprintf("error: invalid limit parameter, it should be between 1 and %v", maxLeaderboardLimit)
+			ss.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+			return
+		}
+	}
+
+	entries, err := ss.readClient().ReadLevelLeaderboard(r.Context(), int32(level), limit)
+	if err != nil {
+		errMsg := "DB read error: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&LevelLeaderboard{Level: int32(level), Entries: entries})
+	if err != nil {
+		errMsg := "error: could not encode level leaderboard: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
@@ -0,0 +1,322 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// rating leaderboard sizes
+const defaultLeaderboardLimit = 10
+const maxLeaderboardLimit = 100
+
+// MatchResult holds the match records of both players of a finished head-to-head match
+// (used as the request body for the internal request to record a match result)
+type MatchResult struct {
+	Records [2]data.MatchRecord `json:"records"`
+}
+
+// PlayerMatchHistory is used as the client response for the public get match history api,
+// it holds a page of the match history, with the cursor of the next page (left out on the last page)
+type PlayerMatchHistory struct {
+	PlayerID   string             `json:"playerID"`
+	Matches    []data.MatchRecord `json:"matches"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+// PlayerRating is used as the client response for the public get rating api
+type PlayerRating struct {
+	PlayerID string `json:"playerID"`
+	Rating   int32  `json:"rating"`
+}
+
+// RatingLeaderboard is used as the client response for the public rating leaderboard api,
+// with the (unix) time its entries were computed at, which is in the past when they come from the leaderboard cache
+type RatingLeaderboard struct {
+	Entries    []data.RatingEntry `json:"entries"`
+	ComputedAt int64              `json:"computedAt"`
+}
+
+// validate checks that the records belong to the two players of the same match, with matching outcomes
+func (result *MatchResult) validate() error {
+
+	first, second := result.Records[0], result.Records[1]
+
+	if first.MatchID == "" || first.MatchID != second.MatchID {
+		return fmt.Errorf("match records should be for the same match")
+	}
+
+	if first.PlayerID == "" || first.PlayerID == second.PlayerID || first.OpponentID != second.PlayerID || second.OpponentID != first.PlayerID {
+		return fmt.Errorf("match records should be for two players facing each other")
+	}
+
+	switch {
+	case first.Outcome == data.MatchOutcomeWin && second.Outcome == data.MatchOutcomeLoss:
+	case first.Outcome == data.MatchOutcomeLoss && second.Outcome == data.MatchOutcomeWin:
+	case first.Outcome == data.MatchOutcomeDraw && second.Outcome == data.MatchOutcomeDraw:
+	default:
+		return fmt.Errorf("invalid match outcomes: %v and %v", first.Outcome, second.Outcome)
+	}
+
+	return nil
+}
+
+// RecordMatchResult updates the ratings of both players of a finished head-to-head match,
+// and appends the result (with the rating changes) to the match history of both players
+func (ss *Server) RecordMatchResult(ctx context.Context, result *MatchResult) error {
+
+	if ss == nil {
+		return serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.RecordMatchResult")
+	defer span.End()
+
+	if result == nil {
+		return fmt.Errorf("provided match result pointer is nil")
+	}
+
+	err := result.validate()
+	if err != nil {
+		return err
+	}
+
+	ss.statsMutex.Lock()
+	defer ss.statsMutex.Unlock()
+
+	// read the stats of both players, the ones without stats (or without a rating yet) start from the default rating
+	playerStats := [2]*data.PlayerStats{}
+	ratings := [2]int32{}
+	for i, record := range result.Records {
+		plStats, readErr := ss.dataClient.ReadStats(ctx, record.PlayerID)
+		if readErr != nil {
+			if !errors.Is(readErr, data.PlayerStatsNotFoundErr{PlayerID: record.PlayerID}) {
+				return readErr
+			}
+			plStats = &data.PlayerStats{LevelStats: make([]data.PlayerLevelStats, 0, ss.defaultLevelCount), Version: data.PlayerStatsVersion}
+		}
+
+		playerStats[i] = plStats
+		ratings[i] = plStats.Rating
+		if ratings[i] <= 0 {
+			ratings[i] = config.Config.Match.DefaultRating
+		}
+	}
+
+	score := 0.5
+	switch result.Records[0].Outcome {
+	case data.MatchOutcomeWin:
+		score = 1
+	case data.MatchOutcomeLoss:
+		score = 0
+	}
+	newRatings := updatedRatings(ratings, score, config.Config.Match.RatingKFactor)
+
+	// append the records to the match histories first, and then write the new ratings
+	for i, record := range result.Records {
+		record.Rating = newRatings[i]
+		record.RatingChange = newRatings[i] - ratings[i]
+		err = ss.dataClient.WriteMatchRecord(ctx, &record)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, record := range result.Records {
+		playerStats[i].Rating = newRatings[i]
+		err = ss.dataClient.WriteStats(ctx, &data.PlayerStatsWithID{PlayerID: record.PlayerID, PlayerStats: *playerStats[i]})
+		if err != nil {
+			return err
+		}
+		ss.noteRatingChange(ctx, record.PlayerID, newRatings[i])
+	}
+
+	return nil
+}
+
+// updatedRatings returns the new (ELO) ratings of two players after a match,
+// given the score of the first player (1 for a win, 0.5 for a draw, 0 for a loss).
+// The rating change is zero sum, and ratings never drop below 1 (since 0 means unrated)
+func updatedRatings(ratings [2]int32, score float64, kFactor int32) [2]int32 {
+
+	expected := 1 / (1 + math.Pow(10, float64(ratings[1]-ratings[0])/400))
+	change := int32(math.Round(float64(kFactor) * (score - expected)))
+
+	return [2]int32{max(1, ratings[0]+change), max(1, ratings[1]-change)}
+}
+
+// HandleRecordMatchResultRequest is a wrapper around the RecordMatchResult() method which will
+// be used to field internal (server to server) requests to record match results
+func (ss *Server) HandleRecordMatchResultRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be a MatchResult struct
+	decodedReq := &MatchResult{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ss.logger.Printf("record match result request for match: %v", decodedReq.Records[0].MatchID)
+
+	err = ss.RecordMatchResult(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not record match result: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleMatchHistoryRequest responds with a page of the match history of the requested player, oldest match first by default
+func (ss *Server) HandleMatchHistoryRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// get the player id from the request path
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+	ss.logger.Printf("match history requested for id: %v", id)
+
+	page, err := pagination.ParseRequest(r.URL.Query())
+	if err != nil {
+		errMsg := "error: invalid page request: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	records, err := ss.dataClient.ReadMatchRecords(r.Context(), id)
+	if err != nil {
+		errMsg := "DB read error: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	history := &PlayerMatchHistory{PlayerID: id}
+	history.Matches, history.NextCursor, err = pagination.PaginateHistory(records, page)
+	if err != nil {
+		errMsg := "error: invalid page request: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(history)
+	if err != nil {
+		errMsg := "error: could not encode match history: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandleRatingRequest responds with the rating of the requested player (the default rating if they are unrated)
+func (ss *Server) HandleRatingRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// get the player id from the request path
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+	ss.logger.Printf("rating requested for id: %v", id)
+
+	response := &PlayerRating{PlayerID: id, Rating: config.Config.Match.DefaultRating}
+
+	plStats, err := ss.dataClient.ReadStats(r.Context(), id)
+	if err != nil {
+		if !errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: id}) {
+			errMsg := "DB read error: " + err.Error()
+			ss.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+			return
+		}
+	} else if plStats.Rating > 0 {
+		response.Rating = plStats.Rating
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		errMsg := "error: could not encode rating: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandleRatingLeaderboardRequest responds with the highest rated players,
+// the 'limit' query parameter sets the number of entries (10 by default, up to 100)
+func (ss *Server) HandleRatingLeaderboardRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	limit := defaultLeaderboardLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 || limit > maxLeaderboardLimit {
+			errMsg := fmt.Sprintf("error: invalid limit parameter, it should be between 1 and %v", maxLeaderboardLimit)
+			ss.logger.Println(errMsg)
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+			return
+		}
+	}
+
+	leaderboard, err := ss.RatingLeaderboard(r.Context(), limit)
+	if err != nil {
+		errMsg := "DB read error: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(leaderboard)
+	if err != nil {
+		errMsg := "error: could not encode rating leaderboard: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
@@ -0,0 +1,347 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"example.com/dice-game-backend/internal/shared/validation"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+var milestoneRewardsDisabledError = fmt.Errorf("milestone rewards are not enabled")
+
+type MilestoneNotFoundErr struct {
+	MilestoneID string
+}
+
+func (err MilestoneNotFoundErr) Error() string {
+	return fmt.Sprintf("milestone: %v was not found in the config", err.MilestoneID)
+}
+
+// MilestoneClaimRequestBody is used as the request body for the public request to claim the rewards of a milestone
+type MilestoneClaimRequestBody struct {
+	PlayerID    string `json:"playerID"`
+	MilestoneID string `json:"milestoneID"`
+}
+
+// MilestoneProgress holds the progress of a player towards a milestone of the config, when (unix) they reached it
+// and when they claimed its rewards (both 0 till they do)
+type MilestoneProgress struct {
+	MilestoneID  string `json:"milestoneID"`
+	Kind         string `json:"kind"`
+	Target       int32  `json:"target"`
+	Progress     int32  `json:"progress"`
+	EnergyReward int32  `json:"energyReward"`
+	CoinReward   int64  `json:"coinReward"`
+	ReachTime    int64  `json:"reachTime,omitempty"`
+	ClaimTime    int64  `json:"claimTime,omitempty"`
+}
+
+// MilestonesResponse is used as the client response for the public milestone requests, it holds the progress
+// of the player towards every milestone of the config, in the config order
+type MilestonesResponse struct {
+	PlayerID   string              `json:"playerID"`
+	Milestones []MilestoneProgress `json:"milestones"`
+}
+
+// EnableMilestoneRewards makes the server grant the rewards of the milestones claimed by the players,
+// the energy rewards through the given profile client (without it, milestones are tracked but cannot be claimed)
+func (ss *Server) EnableMilestoneRewards(pc profile.ProfileClient) {
+
+	if ss == nil {
+		return
+	}
+
+	ss.profileClient = pc
+}
+
+// milestoneProgress returns the progress of the given player stats towards milestones of the given kind
+func milestoneProgress(playerStats *data.PlayerStats, kind string) int32 {
+
+	switch kind {
+	case config.MilestoneKindLevel:
+		level := config.Config.DefaultLevel
+		for _, levelStats := range playerStats.LevelStats {
+			if levelStats.WinCount > 0 {
+				level = max(level, levelStats.Level+1)
+			}
+		}
+		return min(level, config.Config.LevelCount())
+
+	case config.MilestoneKindWins:
+		wins := int32(0)
+		for _, levelStats := range playerStats.LevelStats {
+			wins += levelStats.WinCount
+		}
+		return wins
+
+	case config.MilestoneKindWinStreak:
+		return playerStats.WinStreak
+	}
+
+	return 0
+}
+
+// reachedMilestones returns the ids of the milestones of the config whose targets the given player stats meet
+func reachedMilestones(playerStats *data.PlayerStats) []string {
+
+	reached := []string{}
+	for _, milestone := range config.Config.Milestones {
+		if milestoneProgress(playerStats, milestone.Kind) >= milestone.Target {
+			reached = append(reached, milestone.MilestoneID)
+		}
+	}
+	return reached
+}
+
+// recordNewMilestones records the milestones the player reached with their latest stats update (the ones met by
+// the given stats, but not in the given ids reached before the update), errors are logged rather than returned,
+// so they never fail the stats update (the milestones are checked again whenever the player reads or claims them)
+func (ss *Server) recordNewMilestones(ctx context.Context, playerID string, reachedBefore []string, playerStats *data.PlayerStats) {
+
+	newlyReached := []string{}
+	for _, milestoneID := range reachedMilestones(playerStats) {
+		if !slices.Contains(reachedBefore, milestoneID) {
+			newlyReached = append(newlyReached, milestoneID)
+		}
+	}
+
+	if len(newlyReached) == 0 {
+		return
+	}
+
+	_, err := ss.dataClient.ReachMilestones(ctx, &data.MilestoneReach{PlayerID: playerID, MilestoneIDs: newlyReached, Time: time.Now().UTC().Unix()})
+	if err != nil {
+		ss.logger.Printf("error: could not record the milestones reached by player id %v: %v", playerID, err)
+	}
+}
+
+// readMilestonesLocked returns the stats of the given player (empty ones if they have none yet) and their milestones,
+// after recording any milestone their stats meet which was not recorded yet. The stats mutex should be held
+func (ss *Server) readMilestonesLocked(ctx context.Context, playerID string) (*data.PlayerStats, *data.MilestonesData, error) {
+
+	playerStats, err := ss.dataClient.ReadStats(ctx, playerID)
+	if err != nil {
+		if !errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: playerID}) {
+			return nil, nil, err
+		}
+		playerStats = &data.PlayerStats{LevelStats: []data.PlayerLevelStats{}, Version: data.PlayerStatsVersion}
+	}
+
+	milestones, err := ss.dataClient.ReadMilestones(ctx, playerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	missing := []string{}
+	for _, milestoneID := range reachedMilestones(playerStats) {
+		if _, recorded := milestones.State(milestoneID); !recorded {
+			missing = append(missing, milestoneID)
+		}
+	}
+
+	if len(missing) > 0 {
+		milestones, err = ss.dataClient.ReachMilestones(ctx, &data.MilestoneReach{PlayerID: playerID, MilestoneIDs: missing, Time: time.Now().UTC().Unix()})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return playerStats, milestones, nil
+}
+
+// newMilestonesResponse returns the progress of the given player towards every milestone of the config
+func newMilestonesResponse(playerID string, playerStats *data.PlayerStats, milestones *data.MilestonesData) *MilestonesResponse {
+
+	response := &MilestonesResponse{PlayerID: playerID, Milestones: []MilestoneProgress{}}
+
+	for _, milestone := range config.Config.Milestones {
+		progress := MilestoneProgress{
+			MilestoneID:  milestone.MilestoneID,
+			Kind:         milestone.Kind,
+			Target:       milestone.Target,
+			Progress:     milestoneProgress(playerStats, milestone.Kind),
+			EnergyReward: milestone.EnergyReward,
+			CoinReward:   milestone.CoinReward,
+		}
+		if state, reached := milestones.State(milestone.MilestoneID); reached {
+			progress.ReachTime = state.ReachTime
+			progress.ClaimTime = state.ClaimTime
+		}
+		response.Milestones = append(response.Milestones, progress)
+	}
+
+	return response
+}
+
+// ReturnMilestones returns the progress of the given player towards every milestone of the config
+func (ss *Server) ReturnMilestones(ctx context.Context, playerID string) (*MilestonesResponse, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.ReturnMilestones")
+	defer span.End()
+
+	ss.statsMutex.Lock()
+	defer ss.statsMutex.Unlock()
+
+	playerStats, milestones, err := ss.readMilestonesLocked(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMilestonesResponse(playerID, playerStats, milestones), nil
+}
+
+// ClaimMilestone grants the rewards of the given milestone to the given player, and returns their progress towards
+// every milestone. The milestone is marked as claimed first (which is what guarantees the rewards are only given once),
+// so if a reward fails, the error is logged and skipped
+func (ss *Server) ClaimMilestone(ctx context.Context, playerID string, milestoneID string) (*MilestonesResponse, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.ClaimMilestone")
+	defer span.End()
+
+	if ss.profileClient == nil {
+		return nil, milestoneRewardsDisabledError
+	}
+
+	milestone, ok := config.Config.Milestone(milestoneID)
+	if !ok {
+		return nil, MilestoneNotFoundErr{MilestoneID: milestoneID}
+	}
+
+	ss.statsMutex.Lock()
+	defer ss.statsMutex.Unlock()
+
+	playerStats, _, err := ss.readMilestonesLocked(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	milestones, err := ss.dataClient.ClaimMilestone(ctx, &data.MilestoneClaim{PlayerID: playerID, MilestoneID: milestoneID, Time: time.Now().UTC().Unix()})
+	if err != nil {
+		return nil, err
+	}
+
+	if milestone.EnergyReward > 0 {
+		_, err = ss.profileClient.UpdatePlayerData(playerctx.WithPlayerID(ctx, playerID), milestone.EnergyReward, 0)
+		if err != nil {
+			ss.logger.Printf("error: could not grant the milestone energy reward to player id %v: %v", playerID, err)
+		}
+	}
+
+	if milestone.CoinReward > 0 {
+		_, err = ss.dataClient.InitWallet(ctx, &data.WalletData{PlayerID: playerID, Coins: config.Config.DefaultCoins})
+		if err == nil {
+			_, err = ss.dataClient.AdjustWallet(ctx, playerID, milestone.CoinReward)
+		}
+		if err != nil {
+			ss.logger.Printf("error: could not grant the milestone coin reward to player id %v: %v", playerID, err)
+		}
+	}
+
+	ss.auditRecorder.Record(ctx, playerID, audit.ActionMilestoneClaim, playerID, milestone)
+
+	return newMilestonesResponse(playerID, playerStats, milestones), nil
+}
+
+// HandleMilestonesRequest is a wrapper around the ReturnMilestones() method,
+// it sends back the progress of the requested player towards every milestone
+func (ss *Server) HandleMilestonesRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
+	ss.logger.Printf("milestones requested for id: %v", id)
+
+	response, err := ss.ReturnMilestones(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not get the milestones: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// HandleClaimMilestoneRequest is a wrapper around the ClaimMilestone() method,
+// it sends back the progress of the player towards every milestone, after the claim
+func (ss *Server) HandleClaimMilestoneRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		return
+	}
+
+	// decode the request
+	claimRequest := &MilestoneClaimRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(claimRequest)
+	if err != nil {
+		errMsg := "error: could not decode the milestone claim request: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest)
+		return
+	}
+
+	// the request can only act on the player of its session
+	if !validation.CheckPlayerID(w, r, &claimRequest.PlayerID) {
+		return
+	}
+	ss.logger.Printf("request to claim milestone %v by player id %v", claimRequest.MilestoneID, claimRequest.PlayerID)
+
+	response, err := ss.ClaimMilestone(r.Context(), claimRequest.PlayerID, claimRequest.MilestoneID)
+	if err != nil {
+		errMsg := "error: could not claim the milestone: " + err.Error()
+		ss.logger.Println(errMsg)
+		switch {
+		case errors.Is(err, milestoneRewardsDisabledError):
+			apierror.Write(w, r, http.StatusServiceUnavailable, apierror.CodeUnavailable)
+		case errors.As(err, &MilestoneNotFoundErr{}):
+			apierror.Write(w, r, http.StatusNotFound, apierror.CodeMilestoneNotFound)
+		case errors.As(err, &data.MilestoneNotReachedErr{}):
+			apierror.Write(w, r, http.StatusForbidden, apierror.CodeMilestoneNotReached)
+		case errors.As(err, &data.MilestoneAlreadyClaimedErr{}):
+			apierror.Write(w, r, http.StatusConflict, apierror.CodeMilestoneAlreadyClaimed)
+		default:
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		ss.logger.Println(errMsg)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
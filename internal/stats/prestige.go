@@ -0,0 +1,67 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+// RecordPrestige counts a prestige of the player in their stats (a player without stats starts with empty ones)
+func (ss *Server) RecordPrestige(ctx context.Context, playerID string) error {
+
+	if ss == nil {
+		return serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.RecordPrestige")
+	defer span.End()
+
+	ss.statsMutex.Lock()
+	defer ss.statsMutex.Unlock()
+
+	playerStats, err := ss.dataClient.ReadStats(ctx, playerID)
+	if err != nil {
+		if !errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: playerID}) {
+			return err
+		}
+		playerStats = &data.PlayerStats{LevelStats: make([]data.PlayerLevelStats, 0, ss.defaultLevelCount), Version: data.PlayerStatsVersion}
+	}
+
+	playerStats.PrestigeCount += 1
+	return ss.dataClient.WriteStats(ctx, &data.PlayerStatsWithID{PlayerID: playerID, PlayerStats: *playerStats})
+}
+
+// HandleRecordPrestigeRequest is a wrapper around the RecordPrestige() method which will be used to field
+// internal (server to server) requests to count a prestige of a player
+func (ss *Server) HandleRecordPrestigeRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id := r.PathValue("id")
+	ss.logger.Printf("record prestige request for id: %v", id)
+
+	err := ss.RecordPrestige(r.Context(), id)
+	if err != nil {
+		errMsg := "error: could not record prestige: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	// provide the success response, the body is meaningless
+	// (status of 200: operation will be considered a success)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprint(w, "success")
+	if err != nil {
+		errMsg := "error: could not write response: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,279 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"example.com/dice-game-backend/internal/config"
+	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/audit"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// NoAttemptHistoryErr is returned when a player's stats cannot be rebuilt, as they have no attempt history
+type NoAttemptHistoryErr struct {
+	PlayerID string
+}
+
+func (err NoAttemptHistoryErr) Error() string {
+	return fmt.Sprintf("no attempt history found for player id: %v", err.PlayerID)
+}
+
+// LevelStatsDiff holds the stats of a level before and after a repair (nil when there is no entry for that level)
+type LevelStatsDiff struct {
+	Level  int32                  `json:"level"`
+	Before *data.PlayerLevelStats `json:"before"`
+	After  *data.PlayerLevelStats `json:"after"`
+}
+
+// RepairResult describes the outcome of a stats repair, only the levels which changed are in the diffs
+type RepairResult struct {
+	PlayerID string           `json:"playerID"`
+	DryRun   bool             `json:"dryRun"`
+	Attempts int              `json:"attempts"`
+	Before   data.PlayerStats `json:"before"`
+	After    data.PlayerStats `json:"after"`
+	Diffs    []LevelStatsDiff `json:"diffs"`
+}
+
+// RepairPlayerStats rebuilds the stats of the given player from scratch by replaying their attempt history,
+// which fixes any drift caused by past partial failures. In dry run mode, the rebuilt stats are not written
+func (ss *Server) RepairPlayerStats(ctx context.Context, playerID string, dryRun bool) (*RepairResult, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.RepairPlayerStats")
+	defer span.End()
+
+	// hold the lock for the whole repair, so no new attempts are recorded in the middle of it
+	ss.statsMutex.Lock()
+	defer ss.statsMutex.Unlock()
+
+	attempts, err := ss.dataClient.ReadAttempts(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attempts) == 0 {
+		return nil, NoAttemptHistoryErr{PlayerID: playerID}
+	}
+
+	// stats which are missing entirely are treated as empty
+	before := &data.PlayerStats{}
+	plStats, err := ss.dataClient.ReadStats(ctx, playerID)
+	if err == nil {
+		before = plStats
+	}
+
+	// the rating does not come from the attempt history, so it is kept as it is
+	after := rebuildStats(attempts, config.Config.DefaultLevelScore)
+	after.Rating = before.Rating
+	after.PrestigeCount = before.PrestigeCount
+
+	result := &RepairResult{
+		PlayerID: playerID,
+		DryRun:   dryRun,
+		Attempts: len(attempts),
+		Before:   *before,
+		After:    *after,
+		Diffs:    diffStats(before, after),
+	}
+
+	if dryRun || len(result.Diffs) == 0 {
+		return result, nil
+	}
+
+	err = ss.dataClient.WriteStats(ctx, &data.PlayerStatsWithID{PlayerID: playerID, PlayerStats: *after})
+	if err != nil {
+		return nil, err
+	}
+
+	ss.auditRecorder.Record(ctx, audit.ActorAdmin, audit.ActionStatsRepair, playerID, result.Diffs)
+
+	return result, nil
+}
+
+// ResetPlayerStats clears the level stats of the given player (the rating is kept, like in a repair),
+// and returns the stats they had before
+func (ss *Server) ResetPlayerStats(ctx context.Context, playerID string) (*data.PlayerStats, error) {
+
+	if ss == nil {
+		return nil, serverNilError
+	}
+
+	ctx, span := tracing.Start(ctx, "stats.ResetPlayerStats")
+	defer span.End()
+
+	ss.statsMutex.Lock()
+	defer ss.statsMutex.Unlock()
+
+	before, err := ss.dataClient.ReadStats(ctx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	after := data.PlayerStats{LevelStats: []data.PlayerLevelStats{}, Rating: before.Rating, PrestigeCount: before.PrestigeCount, Version: data.PlayerStatsVersion}
+	err = ss.dataClient.WriteStats(ctx, &data.PlayerStatsWithID{PlayerID: playerID, PlayerStats: after})
+	if err != nil {
+		return nil, err
+	}
+
+	ss.auditRecorder.Record(ctx, audit.ActorAdmin, audit.ActionStatsReset, playerID, before)
+
+	return before, nil
+}
+
+// HandleResetStatsRequest clears the level stats of the requested player (admin only),
+// and responds with the stats they had before
+func (ss *Server) HandleResetStatsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	id := r.PathValue("id")
+	ss.logger.Printf("received stats reset request for id: %v", id)
+
+	before, err := ss.ResetPlayerStats(r.Context(), id)
+	if err != nil {
+		errMsg := "stats reset error: " + err.Error()
+		ss.logger.Println(errMsg)
+		if errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: id}) {
+			http.Error(w, errMsg, http.StatusNotFound)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(before)
+	if err != nil {
+		errMsg := "error: could not encode the reset stats: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// HandleRepairStatsRequest rebuilds the stats of the requested player from their attempt history (admin only),
+// the 'dryRun' query parameter (true / false) can be used to only see the diffs, without writing anything
+func (ss *Server) HandleRepairStatsRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ss == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	dryRun := false
+	if dryRunParam := r.URL.Query().Get("dryRun"); dryRunParam != "" {
+		dryRun, err = strconv.ParseBool(dryRunParam)
+		if err != nil {
+			errMsg := "error: invalid dry run parameter: " + err.Error()
+			ss.logger.Println(errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := r.PathValue("id")
+	ss.logger.Printf("received stats repair request for id: %v, dry run: %v", id, dryRun)
+
+	result, err := ss.RepairPlayerStats(r.Context(), id, dryRun)
+	if err != nil {
+		errMsg := "stats repair error: " + err.Error()
+		ss.logger.Println(errMsg)
+		if _, ok := err.(NoAttemptHistoryErr); ok {
+			http.Error(w, errMsg, http.StatusNotFound)
+		} else {
+			http.Error(w, errMsg, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		errMsg := "error: could not encode repair result: " + err.Error()
+		ss.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// rebuildStats replays the given attempts in order, and returns the resulting player stats
+// (levels without any attempts, before the highest attempted level, get an empty entry)
+func rebuildStats(attempts []data.AttemptRecord, defaultLevelScore int32) *data.PlayerStats {
+
+	levelStats := []data.PlayerLevelStats{}
+	winStreak := int32(0)
+
+	for _, attempt := range attempts {
+		if attempt.Level <= 0 {
+			continue
+		}
+
+		for level := int32(len(levelStats)) + 1; level <= attempt.Level; level++ {
+			levelStats = append(levelStats, data.PlayerLevelStats{Level: level, BestScore: defaultLevelScore})
+		}
+
+		entry := &levelStats[attempt.Level-1]
+		if attempt.Won {
+			entry.WinCount += 1
+			entry.BestScore = min(entry.BestScore, attempt.Score)
+			winStreak += 1
+		} else {
+			entry.LossCount += 1
+			winStreak = 0
+		}
+	}
+
+	return &data.PlayerStats{LevelStats: levelStats, WinStreak: winStreak, Version: data.PlayerStatsVersion}
+}
+
+// diffStats returns the per level differences between the two player stats
+func diffStats(before *data.PlayerStats, after *data.PlayerStats) []LevelStatsDiff {
+
+	diffs := []LevelStatsDiff{}
+
+	levelCount := max(len(before.LevelStats), len(after.LevelStats))
+	for index := range levelCount {
+
+		var beforeEntry, afterEntry *data.PlayerLevelStats
+		if index < len(before.LevelStats) {
+			beforeEntry = &before.LevelStats[index]
+		}
+		if index < len(after.LevelStats) {
+			afterEntry = &after.LevelStats[index]
+		}
+
+		if beforeEntry != nil && afterEntry != nil && *beforeEntry == *afterEntry {
+			continue
+		}
+
+		diffs = append(diffs, LevelStatsDiff{Level: int32(index) + 1, Before: beforeEntry, After: afterEntry})
+	}
+
+	return diffs
+}
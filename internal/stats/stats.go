@@ -1,20 +1,28 @@
 // Package stats: provides all functionality related to retrieving, updating, and returning the player's
-// historic data for each level they have played (like win count, loss count, and best score).
+// historic data for each level they have played (like win count, loss count, and best score),
+// as well as their head-to-head match history and rating.
 package stats
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"example.com/dice-game-backend/internal/config"
 	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
+	"example.com/dice-game-backend/internal/shared/apierror"
+	"example.com/dice-game-backend/internal/shared/audit"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/playerctx"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
 	"example.com/dice-game-backend/internal/shared/validation"
+	"example.com/dice-game-backend/internal/webhooks"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 )
@@ -38,57 +46,193 @@ type Server struct {
 	defaultLevelCount int32
 
 	requestValidator validation.RequestValidator
+	dataClient       data.DataClient
+
+	auditRecorder *audit.Recorder
+
+	// the level distributions computed recently (see LevelDistribution)
+	distributions      map[int32]*LevelDistribution
+	distributionsMutex sync.Mutex
+
+	// the feature flags are checked before serving the newer features (see EnableFeatureFlags)
+	flags *config.FlagChecker
+
+	// when webhooks are enabled, new high scores are published to them (see EnableWebhooks)
+	webhookClient webhooks.WebhookClient
+
+	// the read heavy requests go to a data follower when there is one (see EnableReadReplica)
+	replicaClient data.DataClient
+
+	// when the leaderboard cache is enabled, the rating leaderboard is served from its snapshots (see EnableLeaderboardCache)
+	leaderboard *leaderboardCache
+
+	// the energy rewards of the claimed milestones are granted through the profile service (see EnableMilestoneRewards)
+	profileClient profile.ProfileClient
+
+	// all the player stats can be exported to csv files in the export store, for offline analysis (see EnableExport)
+	exportStore     ExportStore
+	exportChunkSize int
+	exportRunning   bool
+	exportMutex     sync.Mutex
 
 	logger *log.Logger
 }
 
 // NewServer returns an initialized pointer to the stats server
-func NewServer(rv validation.RequestValidator) *Server {
+func NewServer(rv validation.RequestValidator, dc data.DataClient) *Server {
+
+	logger := log.New(redact.Stdout, "stats: ", log.Ltime|log.LUTC|log.Lmsgprefix)
+
 	return &Server{
 		statsMutex: sync.Mutex{},
 
-		defaultLevelCount: int32(len(config.Config.Levels)),
+		defaultLevelCount: config.Config.LevelCount(),
 
 		requestValidator: rv,
+		dataClient:       dc,
+
+		auditRecorder: audit.NewRecorder(dc, logger),
 
-		logger: log.New(os.Stdout, "stats: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+		distributions:      map[int32]*LevelDistribution{},
+		distributionsMutex: sync.Mutex{},
+
+		logger: logger,
+	}
+}
+
+// EnableFeatureFlags makes the server check the feature flags of its newer features with the given flag checker
+// (without it, the default flags are used)
+func (ss *Server) EnableFeatureFlags(fc *config.FlagChecker) {
+
+	if ss == nil {
+		return
+	}
+
+	ss.flags = fc
+}
+
+// EnableWebhooks makes the server publish every new high score (a win which beats the player's best score at a level)
+// to the webhooks subscribed to it
+func (ss *Server) EnableWebhooks(wc webhooks.WebhookClient) {
+
+	if ss == nil {
+		return
+	}
+
+	ss.webhookClient = wc
+}
+
+// EnableReadReplica makes the read heavy requests (the rating leaderboard) read from the given client of a data follower,
+// instead of the data service, their responses may then be behind the latest writes by a few writes
+func (ss *Server) EnableReadReplica(rc data.DataClient) {
+
+	if ss == nil {
+		return
+	}
+
+	ss.replicaClient = rc
+}
+
+// readClient returns the client the read heavy requests use: the one of the data follower, if there is one
+func (ss *Server) readClient() data.DataClient {
+
+	if ss.replicaClient != nil {
+		return ss.replicaClient
+	}
+	return ss.dataClient
+}
+
+// publishEvent publishes an event with the given data to the webhooks, if webhooks are enabled,
+// errors are logged rather than returned, so they never fail the stats update
+func (ss *Server) publishEvent(ctx context.Context, eventType string, playerID string, eventData any) {
+
+	if ss.webhookClient == nil {
+		return
+	}
+
+	event, err := webhooks.NewEvent(eventType, playerID, eventData)
+	if err == nil {
+		err = ss.webhookClient.Publish(ctx, event)
+	}
+	if err != nil {
+		ss.logger.Printf("error: could not publish the %v event of player id %v: %v", eventType, playerID, err)
 	}
 }
 
 // Run runs a given stats server on the given port
 func (ss *Server) Run(port string) {
+	log.Fatal(ss.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the stats server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (ss *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ss.serve(ctx, listener)
+}
+
+// RunWithListener runs the stats server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (ss *Server) RunWithListener(listener net.Listener) error {
+	return ss.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the stats server, and serves them on the given listener till the context is done
+func (ss *Server) serve(ctx context.Context, listener net.Listener) error {
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /stats/player-stats/{id}", ss.HandlePlayerStatsRequest)
-	mux.HandleFunc("POST /stats/player-stats-internal", ss.HandleUpdatePlayerStatsRequest)
+	mux.Handle("GET /stats/player-stats/{id}", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandlePlayerStatsRequest), middleware.DefaultLimits))
+	mux.Handle("POST /stats/player-stats-internal", middleware.WithLimits(ss.HandleUpdatePlayerStatsRequest, middleware.DefaultLimits))
+	mux.Handle("POST /stats/player-stats-update-internal", middleware.WithLimits(ss.HandleUpdatePlayerStatsWithChangeRequest, middleware.DefaultLimits))
+
+	mux.Handle("GET /stats/level-distribution/{level}", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandleLevelDistributionRequest), middleware.DefaultLimits))
+	mux.Handle("GET /stats/level-leaderboard/{level}", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandleLevelLeaderboardRequest), middleware.DefaultLimits))
+
+	mux.Handle("GET /stats/matches/{id}", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandleMatchHistoryRequest), middleware.DefaultLimits))
+	mux.Handle("GET /stats/rating/{id}", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandleRatingRequest), middleware.DefaultLimits))
+	mux.Handle("GET /stats/rating-leaderboard", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandleRatingLeaderboardRequest), middleware.DefaultLimits))
+	mux.Handle("POST /stats/match-internal", middleware.WithLimits(ss.HandleRecordMatchResultRequest, middleware.DefaultLimits))
+	mux.Handle("GET /stats/recent-form-internal/{id}", middleware.WithLimits(ss.HandleRecentFormRequest, middleware.DefaultLimits))
+	mux.Handle("POST /stats/prestige-internal/{id}", middleware.WithLimits(ss.HandleRecordPrestigeRequest, middleware.DefaultLimits))
+
+	mux.Handle("GET /stats/milestones/{id}", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandleMilestonesRequest), middleware.DefaultLimits))
+	mux.Handle("POST /stats/milestones/claim", middleware.WithLimits(validation.WithSession(ss.requestValidator, ss.HandleClaimMilestoneRequest), middleware.DefaultLimits))
+
+	mux.Handle("POST /stats/admin/repair/{id}", middleware.WithLimits(ss.HandleRepairStatsRequest, middleware.DefaultLimits))
+	mux.Handle("POST /stats/admin/reset/{id}", middleware.WithLimits(ss.HandleResetStatsRequest, middleware.DefaultLimits))
+	mux.Handle("GET /stats/admin/consistency-audit", middleware.WithLimits(ss.HandleConsistencyAuditRequest, middleware.DefaultLimits))
+	mux.Handle("POST /stats/admin/export", middleware.WithLimits(ss.HandleExportRequest, middleware.DefaultLimits))
+	mux.Handle("GET /stats/admin/export", middleware.WithLimits(ss.HandleExportStatusRequest, middleware.DefaultLimits))
+
+	if ss.leaderboard != nil {
+		go ss.refreshLeaderboardsUntilDone(ctx)
+	}
 
 	ss.logger.Println("the stats server is up and running...")
 
-	addr := constants.CommonHost + ":" + port
-	log.Fatal(http.ListenAndServe(addr, mux))
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("stats", middleware.WithAccessLog("stats", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
 }
 
 // HandlePlayerStatsRequest responds with the player stats data if present
 func (ss *Server) HandlePlayerStatsRequest(w http.ResponseWriter, r *http.Request) {
 
 	if ss == nil {
-		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// check for valid session
-	err := ss.requestValidator.ValidateRequest(r)
-	if err != nil {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"User Visible Realm\"")
-		errMsg := "error: session validation error: " + err.Error()
-		ss.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusUnauthorized)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		return
 	}
 
 	// get the player id from the request path
 	id := r.PathValue("id")
+	if !validation.CheckPlayerID(w, r, &id) {
+		return
+	}
 	ss.logger.Printf("player stats requested for id: %v", id)
 
 	ss.statsMutex.Lock()
@@ -97,14 +241,14 @@ func (ss *Server) HandlePlayerStatsRequest(w http.ResponseWriter, r *http.Reques
 	statsData := &data.PlayerStats{} // create the data struct for the response
 
 	// make a request to the data service to read the stats entry for the player
-	plStats, err := ss.readStatsFromDB(id)
+	plStats, err := ss.dataClient.ReadStats(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: id}) {
 			// entry does not exist yet, we will just send back an empty response for stats
 		} else {
 			errMsg := "DB read error: " + err.Error()
 			ss.logger.Println(errMsg)
-			http.Error(w, errMsg, http.StatusInternalServerError)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
 		}
 	} else {
 		statsData = plStats
@@ -121,17 +265,38 @@ func (ss *Server) HandlePlayerStatsRequest(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		errMsg := "error: could not encode player data: " + err.Error()
 		ss.logger.Println(errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternalError)
+	}
+}
+
+// ReturnUpdatedPlayerStats will update a given PlayerLevelStats entry of the player of the context (see playerctx)
+// and return that player's stats
+func (ss *Server) ReturnUpdatedPlayerStats(ctx context.Context, newStatsDelta *data.PlayerLevelStats) (*data.PlayerStats, error) {
+
+	playerID, err := playerctx.RequirePlayerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	update, err := ss.UpdatePlayerStats(ctx, playerID, newStatsDelta)
+	if err != nil {
+		return nil, err
 	}
+
+	return &update.Stats, nil
 }
 
-// ReturnUpdatedPlayerStats will update a given PlayerLevelStats entry and return that player's stats
-func (ss *Server) ReturnUpdatedPlayerStats(playerID string, newStatsDelta *data.PlayerLevelStats) (*data.PlayerStats, error) {
+// UpdatePlayerStats will update a given PlayerLevelStats entry, and return that player's stats along with how the
+// update changed them
+func (ss *Server) UpdatePlayerStats(ctx context.Context, playerID string, newStatsDelta *data.PlayerLevelStats) (*StatsUpdate, error) {
 
 	if ss == nil {
 		return nil, serverNilError
 	}
 
+	ctx, span := tracing.Start(ctx, "stats.UpdatePlayerStats")
+	defer span.End()
+
 	if newStatsDelta == nil {
 		return nil, fmt.Errorf("provided new stats pointer is nil")
 	}
@@ -144,7 +309,7 @@ func (ss *Server) ReturnUpdatedPlayerStats(playerID string, newStatsDelta *data.
 
 	// make a request to the data service to read the stats entry for the player
 	present := true // to store if there is an entry for the required player id in the stats DB
-	playerStats, err := ss.readStatsFromDB(playerID)
+	playerStats, err := ss.dataClient.ReadStats(ctx, playerID)
 	if err != nil {
 		if errors.Is(err, data.PlayerStatsNotFoundErr{PlayerID: playerID}) {
 			// entry does not exist yet, this can still be a valid case (dealt with below) if the player has no stats yet
@@ -160,6 +325,7 @@ func (ss *Server) ReturnUpdatedPlayerStats(playerID string, newStatsDelta *data.
 			// in that case create an empty player stats struct, and an empty level stats slice in it
 			playerStats = &data.PlayerStats{
 				LevelStats: make([]data.PlayerLevelStats, 0, ss.defaultLevelCount),
+				Version:    data.PlayerStatsVersion,
 			}
 		} else {
 			// forward the error from above
@@ -167,6 +333,74 @@ func (ss *Server) ReturnUpdatedPlayerStats(playerID string, newStatsDelta *data.
 		}
 	}
 
+	// append the attempt to the player's attempt history first, so the stats can always be rebuilt from it
+	attempt := &data.AttemptRecord{
+		PlayerID: playerID,
+		Level:    newStatsDelta.Level,
+		Won:      newStatsDelta.WinCount == 1,
+		Score:    newStatsDelta.BestScore,
+		Time:     time.Now().UTC().Unix(),
+	}
+	err = ss.dataClient.WriteAttempt(ctx, attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	highScore := newHighScore(playerStats, newStatsDelta)
+	reachedBefore := reachedMilestones(playerStats)
+	change := ApplyLevelStatsUpdate(playerStats, newStatsDelta)
+
+	// make a request to the data service to write the stats entry for the player
+	plStatsWithID := &data.PlayerStatsWithID{PlayerID: playerID, PlayerStats: *playerStats}
+	err = ss.dataClient.WriteStats(ctx, plStatsWithID)
+	if err != nil {
+		return nil, err
+	}
+
+	if highScore != nil {
+		ss.publishEvent(ctx, webhooks.EventHighScore, playerID, highScore)
+	}
+
+	ss.recordNewMilestones(ctx, playerID, reachedBefore, playerStats)
+
+	return &StatsUpdate{Stats: *playerStats, Change: change}, nil
+}
+
+// newHighScore returns the high score set by the given delta (before it is applied to the given player stats),
+// if it is a win which beats the player's best score at the level, or their first win of it. Otherwise it returns nil
+func newHighScore(playerStats *data.PlayerStats, newStatsDelta *data.PlayerLevelStats) *webhooks.HighScoreData {
+
+	if newStatsDelta.WinCount != 1 {
+		return nil
+	}
+
+	levelIndex := newStatsDelta.Level - 1
+	if levelIndex >= int32(len(playerStats.LevelStats)) || playerStats.LevelStats[levelIndex].WinCount == 0 {
+		return &webhooks.HighScoreData{Level: newStatsDelta.Level, BestScore: newStatsDelta.BestScore}
+	}
+
+	previousBest := playerStats.LevelStats[levelIndex].BestScore
+	if newStatsDelta.BestScore >= previousBest {
+		return nil
+	}
+
+	return &webhooks.HighScoreData{Level: newStatsDelta.Level, BestScore: newStatsDelta.BestScore, PreviousBestScore: previousBest}
+}
+
+// ApplyLevelStatsDelta updates the entry of the delta's level in the given player stats from the delta
+// (adding its win and loss counts, and keeping the best score of a win), or adds the entry if there is none yet.
+// A win adds to the player's win streak, and a loss ends it
+func ApplyLevelStatsDelta(playerStats *data.PlayerStats, newStatsDelta *data.PlayerLevelStats) {
+
+	if newStatsDelta.WinCount == 1 {
+		playerStats.WinStreak += 1
+	} else if newStatsDelta.LossCount == 1 {
+		playerStats.WinStreak = 0
+	}
+
+	// level to look for
+	levelIndex := newStatsDelta.Level - 1
+
 	// check if an entry exists for that level for that player
 	if levelIndex < int32(len(playerStats.LevelStats)) {
 
@@ -181,15 +415,6 @@ func (ss *Server) ReturnUpdatedPlayerStats(playerID string, newStatsDelta *data.
 		// if not, just get the data from the given stats delta
 		playerStats.LevelStats = append(playerStats.LevelStats, *newStatsDelta)
 	}
-
-	// make a request to the data service to write the stats entry for the player
-	plStatsWithID := &data.PlayerStatsWithID{PlayerID: playerID, PlayerStats: *playerStats}
-	err = ss.writeStatsToDB(plStatsWithID)
-	if err != nil {
-		return nil, err
-	}
-
-	return playerStats, nil
 }
 
 // HandleUpdatePlayerStatsRequest is a wrapper around the ReturnUpdatedPlayerStats() method which will
@@ -214,7 +439,7 @@ func (ss *Server) HandleUpdatePlayerStatsRequest(w http.ResponseWriter, r *http.
 	ss.logger.Printf("update and return stats request for id: %v", decodedReq.PlayerID)
 
 	// try to update the stats
-	updatedStats, err := ss.ReturnUpdatedPlayerStats(decodedReq.PlayerID, &decodedReq.LevelStatsDelta)
+	updatedStats, err := ss.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(r.Context(), decodedReq.PlayerID), &decodedReq.LevelStatsDelta)
 	if err != nil {
 		errMsg := "error: could not update player stats: " + err.Error()
 		ss.logger.Println(errMsg)
@@ -231,81 +456,3 @@ func (ss *Server) HandleUpdatePlayerStatsRequest(w http.ResponseWriter, r *http.
 		http.Error(w, errMsg, http.StatusInternalServerError)
 	}
 }
-
-// readStatsFromDB makes an internal (server to server) request to the data service to read the stats for the required player
-func (ss *Server) readStatsFromDB(playerID string) (*data.PlayerStats, error) {
-
-	// create a new context
-	ctx, cancel := context.WithTimeout(context.TODO(), constants.InternalRequestDeadlineSeconds*time.Second)
-	defer cancel()
-
-	// create the request
-	reqURL := fmt.Sprintf("%v://%v:%v/data/stats-internal/%v", constants.CommonProtocol, constants.CommonHost, constants.DataServerPort, playerID)
-	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	// send the request
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// check response status
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, data.PlayerStatsNotFoundErr{PlayerID: playerID}
-		} else {
-			return nil, fmt.Errorf("internal read stats request was not successful, status code %v", resp.StatusCode)
-		}
-	}
-
-	//decode the response for the player data
-	playerStats := &data.PlayerStats{}
-	err = json.NewDecoder(resp.Body).Decode(playerStats)
-	if err != nil {
-		return nil, err
-	}
-
-	return playerStats, nil
-}
-
-// writeStatsToDB makes an internal (server to server) request to the data service to write the required player's stats entries
-func (ss *Server) writeStatsToDB(plStatsWithID *data.PlayerStatsWithID) error {
-
-	// create a new context
-	ctx, cancel := context.WithTimeout(context.TODO(), constants.InternalRequestDeadlineSeconds*time.Second)
-	defer cancel()
-
-	// create the request body
-	reqBody := &bytes.Buffer{}
-	err := json.NewEncoder(reqBody).Encode(plStatsWithID)
-	if err != nil {
-		return err
-	}
-
-	// create the request
-	reqURL := fmt.Sprintf("%v://%v:%v/data/stats-internal", constants.CommonProtocol, constants.CommonHost, constants.DataServerPort)
-	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, reqBody)
-	if err != nil {
-		return err
-	}
-
-	// send the request
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// check response status
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("internal write stats request was not successful, status code: %v", resp.StatusCode)
-	}
-
-	return nil
-}
@@ -2,34 +2,31 @@ package stats
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"example.com/dice-game-backend/internal/auth"
+	"example.com/dice-game-backend/internal/config"
 	"example.com/dice-game-backend/internal/data"
+	"example.com/dice-game-backend/internal/profile"
 	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/namespace"
+	"example.com/dice-game-backend/internal/shared/pagination"
+	"example.com/dice-game-backend/internal/shared/playerctx"
 	"example.com/dice-game-backend/internal/shared/testsetup"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-func TestMain(m *testing.M) {
-
-	dataServer := data.NewServer()
-	go dataServer.Run(constants.DataServerPort)
-
-	code := m.Run()
-
-	os.Exit(code)
-}
-
 func TestNewStatsServer(t *testing.T) {
 
-	authServer := auth.NewServer()
-	statsServer := NewServer(authServer)
+	authServer := auth.NewServer(data.NewServer())
+	statsServer := NewServer(authServer, data.NewServer())
 
 	if statsServer == nil {
 		t.Fatal("new stats server should not return a nil server pointer")
@@ -40,18 +37,18 @@ func TestServer_ReturnUpdatedPlayerStats(t *testing.T) {
 
 	var s1, s2 *Server
 
-	authServer := auth.NewServer()
-	s2 = NewServer(authServer)
+	authServer := auth.NewServer(data.NewServer())
+	s2 = NewServer(authServer, data.NewServer())
 
-	err := s2.writeStatsToDB(&data.PlayerStatsWithID{"data", data.PlayerStats{nil}})
+	err := s2.dataClient.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: "data", PlayerStats: data.PlayerStats{LevelStats: nil}})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
 
-	err = s2.writeStatsToDB(&data.PlayerStatsWithID{"player3", data.PlayerStats{[]data.PlayerLevelStats{
-		{1, 2, 3, 1},
-		{2, 1, 4, 2},
-		{3, 0, 1, 99},
+	err = s2.dataClient.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: "player3", PlayerStats: data.PlayerStats{LevelStats: []data.PlayerLevelStats{
+		{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1},
+		{Level: 2, WinCount: 1, LossCount: 4, BestScore: 2},
+		{Level: 3, WinCount: 0, LossCount: 1, BestScore: 99},
 	}}})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
@@ -66,25 +63,28 @@ func TestServer_ReturnUpdatedPlayerStats(t *testing.T) {
 		expError  error
 	}{
 		{"nil server", s1, "player1", &data.PlayerLevelStats{}, &data.PlayerStats{}, serverNilError},
-		{"invalid player", s2, "player1", &data.PlayerLevelStats{5, 1, 0, 4}, nil, data.PlayerStatsNotFoundErr{"player1"}},
-		{"valid new player", s2, "player2", &data.PlayerLevelStats{1, 0, 1, 99}, &data.PlayerStats{
-			[]data.PlayerLevelStats{
-				{1, 0, 1, 99},
+		{"invalid player", s2, "player1", &data.PlayerLevelStats{Level: 5, WinCount: 1, LossCount: 0, BestScore: 4}, nil, data.PlayerStatsNotFoundErr{PlayerID: "player1"}},
+		{"valid new player", s2, "player2", &data.PlayerLevelStats{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99}, &data.PlayerStats{
+			LevelStats: []data.PlayerLevelStats{
+				{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99},
 			},
+			Version: data.PlayerStatsVersion,
 		}, nil},
-		{"valid existing player", s2, "player3", &data.PlayerLevelStats{3, 1, 0, 3}, &data.PlayerStats{
-			[]data.PlayerLevelStats{
-				{1, 2, 3, 1},
-				{2, 1, 4, 2},
-				{3, 1, 1, 3},
+		{"valid existing player", s2, "player3", &data.PlayerLevelStats{Level: 3, WinCount: 1, LossCount: 0, BestScore: 3}, &data.PlayerStats{
+			LevelStats: []data.PlayerLevelStats{
+				{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1},
+				{Level: 2, WinCount: 1, LossCount: 4, BestScore: 2},
+				{Level: 3, WinCount: 1, LossCount: 1, BestScore: 3},
 			},
+			WinStreak: 1,
+			Version:   data.PlayerStatsVersion,
 		}, nil},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			gotStats, gotErr := test.server.ReturnUpdatedPlayerStats(test.playerID, test.lvlStats)
+			gotStats, gotErr := test.server.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(context.Background(), test.playerID), test.lvlStats)
 			if gotErr != nil {
 				if errors.Is(gotErr, test.expError) {
 					fmt.Println(gotErr)
@@ -109,17 +109,20 @@ func TestServer_HandlePlayerStatsRequest(t *testing.T) {
 		t.Fatal("auth setup error: " + err.Error())
 	}
 
-	s2 = NewServer(as)
+	s2 = NewServer(as, data.NewServer())
 
-	err = s2.writeStatsToDB(&data.PlayerStatsWithID{"player2", data.PlayerStats{[]data.PlayerLevelStats{
-		{1, 2, 3, 1},
-		{2, 1, 4, 2},
-		{3, 0, 1, 99},
+	err = s2.dataClient.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: "player2", PlayerStats: data.PlayerStats{LevelStats: []data.PlayerLevelStats{
+		{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1},
+		{Level: 2, WinCount: 1, LossCount: 4, BestScore: 2},
+		{Level: 3, WinCount: 0, LossCount: 1, BestScore: 99},
 	}}})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
 
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
 	tests := []struct {
 		name             string
 		server           *Server
@@ -131,12 +134,13 @@ func TestServer_HandlePlayerStatsRequest(t *testing.T) {
 	}{
 		{"nil server", s1, "", "", http.StatusInternalServerError, "", nil},
 		{"valid server, blank session id", s2, "", "", http.StatusUnauthorized, "application/json", nil},
-		{"valid server, valid session id, new user", s2, sID, "player1", http.StatusOK, "application/json", &data.PlayerStatsWithID{"player1", data.PlayerStats{}}},
-		{"valid server, valid session id, existing user", s2, sID, "player2", http.StatusOK, "application/json", &data.PlayerStatsWithID{"player2", data.PlayerStats{[]data.PlayerLevelStats{
-			{1, 2, 3, 1},
-			{2, 1, 4, 2},
-			{3, 0, 1, 99},
-		}}}},
+		{"valid server, valid session id, new user", s2, sID, "player1", http.StatusOK, "application/json", &data.PlayerStatsWithID{PlayerID: "player1", PlayerStats: data.PlayerStats{Version: data.PlayerStatsVersion}}},
+		{"valid server, another player", s2, sID, "player2", http.StatusForbidden, "", nil},
+		{"valid server, valid session id, existing user", s2, "session2", "player2", http.StatusOK, "application/json", &data.PlayerStatsWithID{PlayerID: "player2", PlayerStats: data.PlayerStats{LevelStats: []data.PlayerLevelStats{
+			{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1},
+			{Level: 2, WinCount: 1, LossCount: 4, BestScore: 2},
+			{Level: 3, WinCount: 0, LossCount: 1, BestScore: 99},
+		}, Version: data.PlayerStatsVersion}}},
 	}
 
 	for _, test := range tests {
@@ -148,7 +152,7 @@ func TestServer_HandlePlayerStatsRequest(t *testing.T) {
 			respRec := httptest.NewRecorder()
 
 			statsServer := test.server
-			statsServer.HandlePlayerStatsRequest(respRec, newReq)
+			testsetup.WithSession(statsServer, sessions, statsServer.HandlePlayerStatsRequest)(respRec, newReq)
 
 			gotStatus := respRec.Result().StatusCode
 
@@ -179,17 +183,17 @@ func TestServer_HandlePlayerStatsRequest(t *testing.T) {
 
 func TestServer_HandleUpdatePlayerStatsRequest(t *testing.T) {
 
-	s2 := NewServer(auth.NewServer())
+	s2 := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
 
-	err := s2.writeStatsToDB(&data.PlayerStatsWithID{"player4", data.PlayerStats{nil}})
+	err := s2.dataClient.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: "player4", PlayerStats: data.PlayerStats{LevelStats: nil}})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
 	}
 
-	err = s2.writeStatsToDB(&data.PlayerStatsWithID{"player5", data.PlayerStats{[]data.PlayerLevelStats{
-		{1, 2, 3, 1},
-		{2, 1, 4, 2},
-		{3, 0, 1, 99},
+	err = s2.dataClient.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: "player5", PlayerStats: data.PlayerStats{LevelStats: []data.PlayerLevelStats{
+		{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1},
+		{Level: 2, WinCount: 1, LossCount: 4, BestScore: 2},
+		{Level: 3, WinCount: 0, LossCount: 1, BestScore: 99},
 	}}})
 	if err != nil {
 		t.Fatalf("%v \n", err.Error())
@@ -205,18 +209,21 @@ func TestServer_HandleUpdatePlayerStatsRequest(t *testing.T) {
 		wantResponseBody *data.PlayerStats
 	}{
 		{"nil server", nil, "player1", &data.PlayerLevelStats{}, http.StatusInternalServerError, "", &data.PlayerStats{}},
-		{"invalid player", s2, "player1", &data.PlayerLevelStats{5, 1, 0, 4}, http.StatusBadRequest, "", nil},
-		{"valid new player", s2, "player4", &data.PlayerLevelStats{1, 0, 1, 99}, http.StatusOK, "application/json", &data.PlayerStats{
-			[]data.PlayerLevelStats{
-				{1, 0, 1, 99},
+		{"invalid player", s2, "player1", &data.PlayerLevelStats{Level: 5, WinCount: 1, LossCount: 0, BestScore: 4}, http.StatusBadRequest, "", nil},
+		{"valid new player", s2, "player4", &data.PlayerLevelStats{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99}, http.StatusOK, "application/json", &data.PlayerStats{
+			LevelStats: []data.PlayerLevelStats{
+				{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99},
 			},
+			Version: data.PlayerStatsVersion,
 		}},
-		{"valid existing player", s2, "player5", &data.PlayerLevelStats{3, 1, 0, 3}, http.StatusOK, "application/json", &data.PlayerStats{
-			[]data.PlayerLevelStats{
-				{1, 2, 3, 1},
-				{2, 1, 4, 2},
-				{3, 1, 1, 3},
+		{"valid existing player", s2, "player5", &data.PlayerLevelStats{Level: 3, WinCount: 1, LossCount: 0, BestScore: 3}, http.StatusOK, "application/json", &data.PlayerStats{
+			LevelStats: []data.PlayerLevelStats{
+				{Level: 1, WinCount: 2, LossCount: 3, BestScore: 1},
+				{Level: 2, WinCount: 1, LossCount: 4, BestScore: 2},
+				{Level: 3, WinCount: 1, LossCount: 1, BestScore: 3},
 			},
+			WinStreak: 1,
+			Version:   data.PlayerStatsVersion,
 		}},
 	}
 
@@ -261,3 +268,1429 @@ func TestServer_HandleUpdatePlayerStatsRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPClient(t *testing.T) {
+
+	ss := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /stats/player-stats-internal", ss.HandleUpdatePlayerStatsRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	var hc1 *HTTPClient
+	hc2 := &HTTPClient{baseURL: testServer.URL}
+
+	tests := []struct {
+		name      string
+		client    *HTTPClient
+		playerID  string
+		lvlStats  *data.PlayerLevelStats
+		wantStats *data.PlayerStats
+		expError  error
+	}{
+		{"nil client", hc1, "player1", &data.PlayerLevelStats{}, nil, clientNilError},
+		{"nil stats", hc2, "player1", nil, nil, nil},
+		{"valid new player", hc2, "player2", &data.PlayerLevelStats{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99}, &data.PlayerStats{
+			LevelStats: []data.PlayerLevelStats{
+				{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99},
+			},
+			Version: data.PlayerStatsVersion,
+		}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotStats, gotErr := test.client.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(context.Background(), test.playerID), test.lvlStats)
+			if gotErr != nil {
+				if test.expError == nil || errors.Is(gotErr, test.expError) {
+					fmt.Println(gotErr)
+				} else {
+					t.Fatalf("ReturnUpdatedPlayerStats() failed with an unexpected error, %v", gotErr)
+				}
+			} else {
+				if !reflect.DeepEqual(gotStats, test.wantStats) {
+					t.Errorf("ReturnUpdatedPlayerStats() gave incorrect results, want: %v, got: %v", test.wantStats, gotStats)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPClient_UpdatePlayerStats(t *testing.T) {
+
+	ss := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /stats/player-stats-update-internal", ss.HandleUpdatePlayerStatsWithChangeRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	tests := []struct {
+		name       string
+		lvlStats   *data.PlayerLevelStats
+		wantStats  []data.PlayerLevelStats
+		wantChange LevelStatsChange
+	}{
+		{"first loss", &data.PlayerLevelStats{Level: 1, LossCount: 1, BestScore: 99}, []data.PlayerLevelStats{{Level: 1, LossCount: 1, BestScore: 99}},
+			LevelStatsChange{Level: 1, LossCountDelta: 1, PreviousBestScore: 99, BestScore: 99}},
+		{"first win", &data.PlayerLevelStats{Level: 1, WinCount: 1, BestScore: 4}, []data.PlayerLevelStats{{Level: 1, WinCount: 1, LossCount: 1, BestScore: 4}},
+			LevelStatsChange{Level: 1, WinCountDelta: 1, PreviousBestScore: 99, BestScore: 4, BestScoreImproved: true, WinStreak: 1}},
+		{"win without a better score", &data.PlayerLevelStats{Level: 1, WinCount: 1, BestScore: 5}, []data.PlayerLevelStats{{Level: 1, WinCount: 2, LossCount: 1, BestScore: 4}},
+			LevelStatsChange{Level: 1, WinCountDelta: 1, PreviousBestScore: 4, BestScore: 4, PreviousWinStreak: 1, WinStreak: 2}},
+		{"loss ending the streak", &data.PlayerLevelStats{Level: 1, LossCount: 1, BestScore: 99}, []data.PlayerLevelStats{{Level: 1, WinCount: 2, LossCount: 2, BestScore: 4}},
+			LevelStatsChange{Level: 1, LossCountDelta: 1, PreviousBestScore: 4, BestScore: 4, PreviousWinStreak: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotUpdate, gotErr := hc.UpdatePlayerStats(context.Background(), "player1", test.lvlStats)
+			if gotErr != nil {
+				t.Fatalf("UpdatePlayerStats() failed with an unexpected error, %v", gotErr)
+			}
+
+			if !reflect.DeepEqual(gotUpdate.Stats.LevelStats, test.wantStats) || gotUpdate.Change != test.wantChange {
+				t.Errorf("UpdatePlayerStats() gave incorrect results, want: %v, %v, got: %v, %v", test.wantStats, test.wantChange, gotUpdate.Stats.LevelStats, gotUpdate.Change)
+			}
+		})
+	}
+}
+
+func TestServer_RepairPlayerStats(t *testing.T) {
+
+	var s1 *Server
+
+	ds := data.NewServer()
+	s2 := NewServer(auth.NewServer(data.NewServer()), ds)
+
+	// player2 has stats that drifted from their attempt history (a win on level 2 was never written to the stats)
+	deltas := []data.PlayerLevelStats{
+		{Level: 1, WinCount: 0, LossCount: 1, BestScore: 99},
+		{Level: 1, WinCount: 1, LossCount: 0, BestScore: 2},
+		{Level: 2, WinCount: 1, LossCount: 0, BestScore: 3},
+	}
+	for _, delta := range deltas {
+		_, err := s2.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(context.Background(), "player2"), &delta)
+		if err != nil {
+			t.Fatalf("%v \n", err.Error())
+		}
+	}
+
+	drifted := &data.PlayerStatsWithID{PlayerID: "player2", PlayerStats: data.PlayerStats{LevelStats: []data.PlayerLevelStats{
+		{Level: 1, WinCount: 1, LossCount: 1, BestScore: 2},
+	}, Version: data.PlayerStatsVersion}}
+	err := ds.WriteStats(context.Background(), drifted)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	repairedStats := data.PlayerStats{LevelStats: []data.PlayerLevelStats{
+		{Level: 1, WinCount: 1, LossCount: 1, BestScore: 2},
+		{Level: 2, WinCount: 1, LossCount: 0, BestScore: 3},
+	}, WinStreak: 2, Version: data.PlayerStatsVersion}
+	wantDiffs := []LevelStatsDiff{{Level: 2, Before: nil, After: &data.PlayerLevelStats{Level: 2, WinCount: 1, LossCount: 0, BestScore: 3}}}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		playerID   string
+		dryRun     bool
+		wantResult *RepairResult
+		wantStored *data.PlayerStats
+		expError   error
+	}{
+		{"nil server", s1, "player2", false, nil, nil, serverNilError},
+		{"no history", s2, "player1", false, nil, nil, NoAttemptHistoryErr{PlayerID: "player1"}},
+		{"dry run", s2, "player2", true, &RepairResult{PlayerID: "player2", DryRun: true, Attempts: 3, Before: drifted.PlayerStats, After: repairedStats, Diffs: wantDiffs}, &drifted.PlayerStats, nil},
+		{"repair", s2, "player2", false, &RepairResult{PlayerID: "player2", DryRun: false, Attempts: 3, Before: drifted.PlayerStats, After: repairedStats, Diffs: wantDiffs}, &repairedStats, nil},
+		{"already repaired", s2, "player2", false, &RepairResult{PlayerID: "player2", DryRun: false, Attempts: 3, Before: repairedStats, After: repairedStats, Diffs: []LevelStatsDiff{}}, &repairedStats, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotResult, gotErr := test.server.RepairPlayerStats(context.Background(), test.playerID, test.dryRun)
+			if gotErr != nil {
+				if !errors.Is(gotErr, test.expError) {
+					t.Fatalf("RepairPlayerStats() failed with an unexpected error, %v", gotErr)
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(gotResult, test.wantResult) {
+				t.Errorf("RepairPlayerStats() gave incorrect results, want: %v, got: %v", test.wantResult, gotResult)
+			}
+
+			gotStored, err := ds.ReadStats(context.Background(), test.playerID)
+			if err != nil {
+				t.Fatalf("%v \n", err.Error())
+			}
+
+			if !reflect.DeepEqual(gotStored, test.wantStored) {
+				t.Errorf("RepairPlayerStats() stored incorrect stats, want: %v, got: %v", test.wantStored, gotStored)
+			}
+		})
+	}
+}
+
+func TestServer_HandleRepairStatsRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ss := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	_, err := ss.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(context.Background(), "player2"), &data.PlayerLevelStats{Level: 1, WinCount: 1, LossCount: 0, BestScore: 2})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		playerID   string
+		query      string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", "", http.StatusInternalServerError},
+		{"invalid admin token", ss, "testToken", "player2", "", http.StatusUnauthorized},
+		{"invalid dry run", ss, "adminToken", "player2", "dryRun=maybe", http.StatusBadRequest},
+		{"no history", ss, "adminToken", "player1", "", http.StatusNotFound},
+		{"dry run", ss, "adminToken", "player2", "dryRun=true", http.StatusOK},
+		{"repair", ss, "adminToken", "player2", "", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/stats/admin/repair/?"+test.query, nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			statsServer.HandleRepairStatsRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
+
+func TestServer_HandleResetStatsRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ss := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	err := ss.dataClient.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: "player2", PlayerStats: data.PlayerStats{
+		LevelStats: []data.PlayerLevelStats{{Level: 1, WinCount: 1, LossCount: 0, BestScore: 2}},
+		Rating:     1016,
+	}})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		playerID   string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", ss, "testToken", "player2", http.StatusUnauthorized},
+		{"no stats", ss, "adminToken", "player1", http.StatusNotFound},
+		{"reset", ss, "adminToken", "player2", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/stats/admin/reset/", nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			statsServer.HandleResetStatsRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	// the level stats are gone, the rating is kept
+	gotStats, err := ss.dataClient.ReadStats(context.Background(), "player2")
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+	if len(gotStats.LevelStats) != 0 || gotStats.Rating != 1016 {
+		t.Errorf("HandleResetStatsRequest() gave incorrect results, want no level stats and rating: %v, got: %v", 1016, gotStats)
+	}
+}
+
+func TestServer_ReturnRecentForm(t *testing.T) {
+
+	ds := data.NewServer()
+	ss := NewServer(auth.NewServer(data.NewServer()), ds)
+
+	// the recent form is requested through the http client, so it goes through the internal handler too
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats/recent-form-internal/{id}", ss.HandleRecentFormRequest)
+
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	hc := &HTTPClient{baseURL: testServer.URL}
+
+	// player2 lost their first two attempts, and then won three out of four
+	for _, won := range []bool{false, false, true, true, false, true} {
+		err := ds.WriteAttempt(context.Background(), &data.AttemptRecord{PlayerID: "player2", Level: 1, Won: won})
+		if err != nil {
+			t.Fatalf("%v \n", err.Error())
+		}
+	}
+
+	tests := []struct {
+		name     string
+		playerID string
+		attempts int32
+		wantForm *RecentForm
+		wantErr  bool
+	}{
+		{"no attempts", "player1", 10, &RecentForm{PlayerID: "player1"}, false},
+		{"latest attempts", "player2", 4, &RecentForm{PlayerID: "player2", Wins: 3, Losses: 1}, false},
+		{"fewer attempts than requested", "player2", 10, &RecentForm{PlayerID: "player2", Wins: 3, Losses: 3}, false},
+		{"invalid number of attempts", "player2", 0, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotForm, gotErr := hc.ReturnRecentForm(context.Background(), test.playerID, test.attempts)
+			if (gotErr != nil) != test.wantErr {
+				t.Fatalf("ReturnRecentForm() gave incorrect error, want error: %v, got: %v", test.wantErr, gotErr)
+			}
+
+			if !reflect.DeepEqual(gotForm, test.wantForm) {
+				t.Errorf("ReturnRecentForm() gave incorrect results, want: %v, got: %v", test.wantForm, gotForm)
+			}
+		})
+	}
+}
+
+func TestServer_HandleMatchHistoryRequest(t *testing.T) {
+
+	var s1, s2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	s2 = NewServer(as, data.NewServer())
+
+	result := &MatchResult{Records: [2]data.MatchRecord{
+		{MatchID: "match1", PlayerID: "player2", OpponentID: "player3", Outcome: data.MatchOutcomeWin, Score: 1, OpponentScore: 0, Time: 10},
+		{MatchID: "match1", PlayerID: "player3", OpponentID: "player2", Outcome: data.MatchOutcomeLoss, Score: 0, OpponentScore: 1, Time: 10},
+	}}
+	err = s2.RecordMatchResult(context.Background(), result)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	invalidResult := &MatchResult{Records: [2]data.MatchRecord{
+		{MatchID: "match2", PlayerID: "player2", OpponentID: "player3", Outcome: data.MatchOutcomeWin},
+		{MatchID: "match2", PlayerID: "player3", OpponentID: "player2", Outcome: data.MatchOutcomeWin},
+	}}
+	err = s2.RecordMatchResult(context.Background(), invalidResult)
+	if err == nil {
+		t.Errorf("recording a match with inconsistent outcomes should fail")
+	}
+
+	// the winner gains (and the loser drops) half the k factor, since both started from the default rating
+	record := result.Records[0]
+	record.Rating = config.Config.Match.DefaultRating + config.Config.Match.RatingKFactor/2
+	record.RatingChange = config.Config.Match.RatingKFactor / 2
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2"}
+
+	tests := []struct {
+		name             string
+		server           *Server
+		sessionID        string
+		playerID         string
+		query            string
+		wantStatus       int
+		wantResponseBody *PlayerMatchHistory
+	}{
+		{"nil server", s1, "", "", "", http.StatusInternalServerError, nil},
+		{"valid server, blank session id", s2, "", "", "", http.StatusUnauthorized, nil},
+		{"valid server, valid session id, no matches", s2, sID, "player1", "", http.StatusOK, &PlayerMatchHistory{PlayerID: "player1", Matches: []data.MatchRecord{}}},
+		{"valid server, another player", s2, sID, "player2", "", http.StatusForbidden, nil},
+		{"valid server, valid session id, existing matches", s2, "session2", "player2", "", http.StatusOK, &PlayerMatchHistory{PlayerID: "player2", Matches: []data.MatchRecord{record}}},
+		{"valid server, valid session id, last page", s2, "session2", "player2", "?limit=1&order=desc", http.StatusOK, &PlayerMatchHistory{PlayerID: "player2", Matches: []data.MatchRecord{record}}},
+		{"valid server, valid session id, invalid limit", s2, "session2", "player2", "?limit=-1", http.StatusBadRequest, nil},
+		{"valid server, valid session id, invalid cursor", s2, "session2", "player2", "?cursor=bad", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/stats/matches/"+test.query, nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			testsetup.WithSession(statsServer, sessions, statsServer.HandleMatchHistoryRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &PlayerMatchHistory{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdatedRatings(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		ratings     [2]int32
+		score       float64
+		kFactor     int32
+		wantRatings [2]int32
+	}{
+		{"equal ratings, win", [2]int32{1000, 1000}, 1, 32, [2]int32{1016, 984}},
+		{"equal ratings, loss", [2]int32{1000, 1000}, 0, 32, [2]int32{984, 1016}},
+		{"equal ratings, draw", [2]int32{1000, 1000}, 0.5, 32, [2]int32{1000, 1000}},
+		{"favourite wins", [2]int32{1400, 1000}, 1, 32, [2]int32{1403, 997}},
+		{"underdog wins", [2]int32{1000, 1400}, 1, 32, [2]int32{1029, 1371}},
+		{"underdog draws", [2]int32{1000, 1400}, 0.5, 32, [2]int32{1013, 1387}},
+		{"rating floor", [2]int32{10, 10}, 0, 32, [2]int32{1, 26}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotRatings := updatedRatings(test.ratings, test.score, test.kFactor)
+			if gotRatings != test.wantRatings {
+				t.Errorf("updatedRatings() gave incorrect results, want: %v, got: %v", test.wantRatings, gotRatings)
+			}
+		})
+	}
+}
+
+func TestServer_HandleRatingRequest(t *testing.T) {
+
+	var s1, s2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	s2 = NewServer(as, data.NewServer())
+
+	err = s2.RecordMatchResult(context.Background(), &MatchResult{Records: [2]data.MatchRecord{
+		{MatchID: "match1", PlayerID: "player2", OpponentID: "player3", Outcome: data.MatchOutcomeWin},
+		{MatchID: "match1", PlayerID: "player3", OpponentID: "player2", Outcome: data.MatchOutcomeLoss},
+	}})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	defaultRating := config.Config.Match.DefaultRating
+	halfK := config.Config.Match.RatingKFactor / 2
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{sID: "player1", "session2": "player2", "session3": "player3"}
+
+	tests := []struct {
+		name             string
+		server           *Server
+		sessionID        string
+		playerID         string
+		wantStatus       int
+		wantResponseBody *PlayerRating
+	}{
+		{"nil server", s1, "", "", http.StatusInternalServerError, nil},
+		{"valid server, blank session id", s2, "", "", http.StatusUnauthorized, nil},
+		{"valid server, valid session id, unrated player", s2, sID, "player1", http.StatusOK, &PlayerRating{PlayerID: "player1", Rating: defaultRating}},
+		{"valid server, another player", s2, sID, "player2", http.StatusForbidden, nil},
+		{"valid server, valid session id, winner", s2, "session2", "player2", http.StatusOK, &PlayerRating{PlayerID: "player2", Rating: defaultRating + halfK}},
+		{"valid server, valid session id, loser", s2, "session3", "player3", http.StatusOK, &PlayerRating{PlayerID: "player3", Rating: defaultRating - halfK}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/stats/rating/", nil)
+			newReq.SetPathValue("id", test.playerID)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			testsetup.WithSession(statsServer, sessions, statsServer.HandleRatingRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &PlayerRating{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleRatingLeaderboardRequest(t *testing.T) {
+
+	var s1, s2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	s2 = NewServer(as, data.NewServer())
+
+	err = s2.RecordMatchResult(context.Background(), &MatchResult{Records: [2]data.MatchRecord{
+		{MatchID: "match1", PlayerID: "player2", OpponentID: "player3", Outcome: data.MatchOutcomeWin},
+		{MatchID: "match1", PlayerID: "player3", OpponentID: "player2", Outcome: data.MatchOutcomeLoss},
+	}})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	defaultRating := config.Config.Match.DefaultRating
+	halfK := config.Config.Match.RatingKFactor / 2
+
+	winner := data.RatingEntry{PlayerID: "player2", Rating: defaultRating + halfK}
+	loser := data.RatingEntry{PlayerID: "player3", Rating: defaultRating - halfK}
+
+	// a read replica which has not caught up with the loser yet
+	replica := data.NewServer()
+	err = replica.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: winner.PlayerID, PlayerStats: data.PlayerStats{Rating: winner.Rating}})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	s3 := NewServer(as, data.NewServer())
+	s3.EnableReadReplica(replica)
+
+	tests := []struct {
+		name             string
+		server           *Server
+		sessionID        string
+		query            string
+		wantStatus       int
+		wantResponseBody *RatingLeaderboard
+	}{
+		{"nil server", s1, "", "", http.StatusInternalServerError, nil},
+		{"valid server, blank session id", s2, "", "", http.StatusUnauthorized, nil},
+		{"valid server, invalid limit", s2, sID, "limit=abc", http.StatusBadRequest, nil},
+		{"valid server, limit too large", s2, sID, "limit=101", http.StatusBadRequest, nil},
+		{"valid server, default limit", s2, sID, "", http.StatusOK, &RatingLeaderboard{Entries: []data.RatingEntry{winner, loser}}},
+		{"valid server, limit of 1", s2, sID, "limit=1", http.StatusOK, &RatingLeaderboard{Entries: []data.RatingEntry{winner}}},
+		{"valid server, read replica", s3, sID, "", http.StatusOK, &RatingLeaderboard{Entries: []data.RatingEntry{winner}}},
+	}
+
+	before := time.Now().UTC().Unix()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/stats/rating-leaderboard?"+test.query, nil)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			testsetup.WithSession(statsServer, as, statsServer.HandleRatingLeaderboardRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &RatingLeaderboard{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				// the leaderboard is read on every request (there is no cache), so it was computed during the test
+				if gotResponseBody.ComputedAt < before || gotResponseBody.ComputedAt > time.Now().UTC().Unix() {
+					t.Errorf("handler gave an incorrect computed at time: %v", gotResponseBody.ComputedAt)
+				}
+				gotResponseBody.ComputedAt = 0
+
+				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_LeaderboardCache(t *testing.T) {
+
+	as, _, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ss := NewServer(as, data.NewServer())
+	ss.EnableLeaderboardCache(time.Hour)
+
+	err = ss.RecordMatchResult(context.Background(), &MatchResult{Records: [2]data.MatchRecord{
+		{MatchID: "match1", PlayerID: "player2", OpponentID: "player3", Outcome: data.MatchOutcomeWin},
+		{MatchID: "match1", PlayerID: "player3", OpponentID: "player2", Outcome: data.MatchOutcomeLoss},
+	}})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	// the rating change can move the top of the leaderboard, so its snapshot is refreshed
+	// (right away here, instead of waiting for the refresh request to be picked up)
+	ss.refreshLeaderboards(true)
+
+	before := time.Now().UTC().Unix()
+
+	got, err := ss.RatingLeaderboard(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	defaultRating := config.Config.Match.DefaultRating
+	halfK := config.Config.Match.RatingKFactor / 2
+
+	want := []data.RatingEntry{{PlayerID: "player2", Rating: defaultRating + halfK}}
+	if !reflect.DeepEqual(got.Entries, want) {
+		t.Errorf("cached leaderboard gave incorrect results, want: %v, got: %v", want, got.Entries)
+	}
+	if got.ComputedAt == 0 || got.ComputedAt > before {
+		t.Errorf("cached leaderboard gave an incorrect computed at time: %v", got.ComputedAt)
+	}
+}
+
+// blockingLeaderboardClient is a data client which counts the rating leaderboard reads, and holds them till released
+type blockingLeaderboardClient struct {
+	data.DataClient
+	reads   atomic.Int32
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (blc *blockingLeaderboardClient) ReadRatingLeaderboard(ctx context.Context, limit int) ([]data.RatingEntry, error) {
+
+	if blc.reads.Add(1) == 1 {
+		close(blc.entered)
+	}
+	<-blc.release
+	return blc.DataClient.ReadRatingLeaderboard(ctx, limit)
+}
+
+func TestServer_LeaderboardCache_SharedRefresh(t *testing.T) {
+
+	as, _, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	dc := &blockingLeaderboardClient{DataClient: data.NewServer(), entered: make(chan struct{}), release: make(chan struct{})}
+	ss := NewServer(as, dc)
+	ss.EnableLeaderboardCache(time.Hour)
+
+	// the first reads of a namespace without a snapshot wait for the same refresh
+	ctx := namespace.NewContext(context.Background(), "shared-refresh")
+	readers := 5
+	errs := make(chan error, readers)
+	read := func() {
+		_, readErr := ss.RatingLeaderboard(ctx, 10)
+		errs <- readErr
+	}
+
+	go read()
+	<-dc.entered
+	for range readers - 1 {
+		go read()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(dc.release)
+
+	for range readers {
+		if readErr := <-errs; readErr != nil {
+			t.Fatalf("%v \n", readErr.Error())
+		}
+	}
+	if got := dc.reads.Load(); got != 1 {
+		t.Errorf("concurrent first reads should share one refresh, want: 1 read, got: %v", got)
+	}
+}
+
+func TestServer_LeaderboardCache_StopsWithServer(t *testing.T) {
+
+	ss := NewServer(nil, data.NewServer())
+	ss.EnableLeaderboardCache(time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		ss.refreshLeaderboardsUntilDone(ctx)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the leaderboard refreshes should stop once the context of the server is done")
+	}
+}
+
+func TestServer_NoteRatingChange(t *testing.T) {
+
+	// a full leaderboard, with ratings from 1100 down to 1001
+	entries := []data.RatingEntry{}
+	for i := range maxLeaderboardLimit {
+		entries = append(entries, data.RatingEntry{PlayerID: fmt.Sprintf("player%v", i+1), Rating: int32(1100 - i)})
+	}
+
+	tests := []struct {
+		name      string
+		snapshot  *ratingSnapshot
+		playerID  string
+		rating    int32
+		wantStale bool
+	}{
+		{"no snapshot", nil, "player200", 1200, false},
+		{"leaderboard not full", &ratingSnapshot{entries: entries[:10]}, "player200", 900, true},
+		{"below the leaderboard", &ratingSnapshot{entries: entries}, "player200", 1000, false},
+		{"onto the leaderboard", &ratingSnapshot{entries: entries}, "player200", 1001, true},
+		{"player on the leaderboard", &ratingSnapshot{entries: entries}, "player100", 900, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			ss := &Server{leaderboard: &leaderboardCache{
+				snapshots:       map[string]*ratingSnapshot{},
+				stale:           map[string]bool{},
+				refreshRequests: make(chan struct{}, 1),
+			}}
+			if test.snapshot != nil {
+				ss.leaderboard.snapshots[namespace.Current()] = test.snapshot
+			}
+
+			ss.noteRatingChange(context.Background(), test.playerID, test.rating)
+
+			gotStale := ss.leaderboard.stale[namespace.Current()]
+			if gotStale != test.wantStale {
+				t.Errorf("rating change gave incorrect results, want stale: %v, got: %v", test.wantStale, gotStale)
+			}
+			if gotRequested := len(ss.leaderboard.refreshRequests) == 1; gotRequested != test.wantStale {
+				t.Errorf("rating change gave incorrect results, want refresh requested: %v, got: %v", test.wantStale, gotRequested)
+			}
+		})
+	}
+}
+
+func TestServer_HandleLevelLeaderboardRequest(t *testing.T) {
+
+	var s1, s2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	s2 = NewServer(as, ds)
+
+	first := data.LevelRankEntry{PlayerID: "player2", BestScore: 2, WinCount: 1}
+	second := data.LevelRankEntry{PlayerID: "player3", BestScore: 3, WinCount: 2}
+	for _, entry := range []data.LevelRankEntry{second, first} {
+		err = ds.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: entry.PlayerID, PlayerStats: data.PlayerStats{LevelStats: []data.PlayerLevelStats{{Level: 1, WinCount: entry.WinCount, BestScore: entry.BestScore}}}})
+		if err != nil {
+			t.Fatalf("%v \n", err.Error())
+		}
+	}
+
+	tests := []struct {
+		name             string
+		server           *Server
+		sessionID        string
+		level            string
+		query            string
+		wantStatus       int
+		wantResponseBody *LevelLeaderboard
+	}{
+		{"nil server", s1, "", "1", "", http.StatusInternalServerError, nil},
+		{"valid server, blank session id", s2, "", "1", "", http.StatusUnauthorized, nil},
+		{"valid server, invalid level", s2, sID, "0", "", http.StatusBadRequest, nil},
+		{"valid server, limit too large", s2, sID, "1", "limit=101", http.StatusBadRequest, nil},
+		{"valid server, default limit", s2, sID, "1", "", http.StatusOK, &LevelLeaderboard{Level: 1, Entries: []data.LevelRankEntry{first, second}}},
+		{"valid server, limit of 1", s2, sID, "1", "limit=1", http.StatusOK, &LevelLeaderboard{Level: 1, Entries: []data.LevelRankEntry{first}}},
+		{"valid server, level without wins", s2, sID, "2", "", http.StatusOK, &LevelLeaderboard{Level: 2, Entries: []data.LevelRankEntry{}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/stats/level-leaderboard/"+test.level+"?"+test.query, nil)
+			newReq.SetPathValue("level", test.level)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			testsetup.WithSession(statsServer, as, statsServer.HandleLevelLeaderboardRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &LevelLeaderboard{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleLevelDistributionRequest(t *testing.T) {
+
+	var s1, s2 *Server
+
+	as, sID, err := testsetup.SetupTestAuth()
+	if err != nil {
+		t.Fatal("auth setup error: " + err.Error())
+	}
+
+	ds := data.NewServer()
+	s2 = NewServer(as, ds)
+
+	// s3 has the level distribution turned off by its feature flags
+	cs := config.NewServer(as)
+	err = cs.SetFlag(config.FlagLevelDistribution, config.FlagOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3 := NewServer(as, ds)
+	s3.EnableFeatureFlags(config.NewFlagChecker(cs))
+
+	attempts := []data.AttemptRecord{
+		{PlayerID: "player1", Level: 1, Won: true, Score: 2},
+		{PlayerID: "player1", Level: 1, Won: true, Score: 3},
+		{PlayerID: "player1", Level: 1, Won: false},
+		{PlayerID: "player2", Level: 1, Won: false},
+		{PlayerID: "player2", Level: 1, Won: true, Score: 4},
+		{PlayerID: "player3", Level: 1, Won: false},
+		{PlayerID: "player4", Level: 1, Won: true, Score: 1},
+		{PlayerID: "player1", Level: 2, Won: true, Score: 2},
+	}
+	for _, attempt := range attempts {
+		err = ds.WriteAttempt(context.Background(), &attempt)
+		if err != nil {
+			t.Fatalf("%v \n", err.Error())
+		}
+	}
+
+	tests := []struct {
+		name             string
+		server           *Server
+		sessionID        string
+		level            string
+		wantStatus       int
+		wantResponseBody *LevelDistribution
+	}{
+		{"nil server", s1, "", "1", http.StatusInternalServerError, nil},
+		{"valid server, blank session id", s2, "", "1", http.StatusUnauthorized, nil},
+		{"valid server, invalid level", s2, sID, "abc", http.StatusBadRequest, nil},
+		{"valid server, level 0", s2, sID, "0", http.StatusBadRequest, nil},
+		{"valid server, level 50", s2, sID, "50", http.StatusBadRequest, nil},
+		{"valid server, level 1", s2, sID, "1", http.StatusOK, &LevelDistribution{
+			Level: 1, Players: 4, Attempts: 7, Wins: 4, WinRate: 4.0 / 7, AverageRollsToWin: 2.5,
+			BestScores: ScorePercentiles{P25: 1, P50: 2, P75: 4, P90: 4},
+		}},
+		{"valid server, level 2", s2, sID, "2", http.StatusOK, &LevelDistribution{
+			Level: 2, Players: 1, Attempts: 1, Wins: 1, WinRate: 1, AverageRollsToWin: 2,
+			BestScores: ScorePercentiles{P25: 2, P50: 2, P75: 2, P90: 2},
+		}},
+		{"valid server, unplayed level", s2, sID, "3", http.StatusOK, &LevelDistribution{Level: 3}},
+		{"turned off", s3, sID, "1", http.StatusServiceUnavailable, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/stats/level-distribution/", nil)
+			newReq.SetPathValue("level", test.level)
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			testsetup.WithSession(statsServer, as, statsServer.HandleLevelDistributionRequest)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &LevelDistribution{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotResponseBody.ComputedAt == 0 {
+					t.Errorf("handler gave incorrect results, the computed at time should be set")
+				}
+				gotResponseBody.ComputedAt = 0
+
+				if !reflect.DeepEqual(gotResponseBody, test.wantResponseBody) {
+					t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantResponseBody, gotResponseBody)
+				}
+			}
+		})
+	}
+
+	// a new attempt does not show up till the cached distribution expires
+	err = ds.WriteAttempt(context.Background(), &data.AttemptRecord{PlayerID: "player5", Level: 2, Won: false})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	distribution, err := s2.LevelDistribution(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	if distribution.Attempts != 1 {
+		t.Errorf("LevelDistribution() should have returned the cached distribution, want: %v attempts, got: %v", 1, distribution.Attempts)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		sorted []int32
+		p      int
+		want   int32
+	}{
+		{"no values", []int32{}, 50, 0},
+		{"single value", []int32{3}, 90, 3},
+		{"median of four", []int32{1, 2, 3, 4}, 50, 2},
+		{"90th of ten", []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 90, 9},
+		{"25th of ten", []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 25, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := percentile(test.sorted, test.p)
+			if got != test.want {
+				t.Errorf("percentile() gave incorrect results, want: %v, got: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestServer_AuditConsistency(t *testing.T) {
+
+	ds := data.NewServer()
+	ss := NewServer(auth.NewServer(data.NewServer()), ds)
+	levelCount := config.Config.LevelCount()
+
+	// every player plays a win on level 1 and a loss on level 2 (the stats and the attempt history agree)
+	players := []data.PlayerData{
+		{PlayerID: "consistent", Level: 2},
+		{PlayerID: "drifted", Level: 2},
+		{PlayerID: "levelBehind", Level: 1},
+		{PlayerID: "levelTooHigh", Level: levelCount + 1},
+		{PlayerID: "prestiged", Level: 1, PrestigeRank: 1},
+	}
+	for _, player := range players {
+		err := ds.WritePlayer(context.Background(), &player)
+		if err != nil {
+			t.Fatalf("%v \n", err.Error())
+		}
+		for _, delta := range []data.PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 3}, {Level: 2, LossCount: 1}} {
+			_, err = ss.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(context.Background(), player.PlayerID), &delta)
+			if err != nil {
+				t.Fatalf("%v \n", err.Error())
+			}
+		}
+	}
+
+	// a loss on level 1 of the drifted player was never written to the stats
+	err := ds.WriteAttempt(context.Background(), &data.AttemptRecord{PlayerID: "drifted", Level: 1})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name        string
+		playerID    string
+		wantErr     bool
+		wantChecks  []string
+		wantLevels  []int32
+		wantChecked int
+	}{
+		{"consistent player", "consistent", false, nil, nil, 1},
+		{"stats drifted from the attempts", "drifted", false, []string{CheckStatsMatchAttempts}, []int32{1}, 1},
+		{"level behind the stats", "levelBehind", false, []string{CheckStatsUnlocked}, []int32{2}, 1},
+		{"level too high", "levelTooHigh", false, []string{CheckLevelInRange}, []int32{0}, 1},
+		{"prestiged player", "prestiged", false, nil, nil, 1},
+		{"unknown player", "unknown", true, nil, nil, 0},
+		{"all players", "", false, []string{CheckStatsMatchAttempts, CheckStatsUnlocked, CheckLevelInRange}, []int32{1, 2, 0}, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			report, err := ss.AuditConsistency(context.Background(), test.playerID, pagination.Request{})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("AuditConsistency() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+
+			var gotChecks []string
+			var gotLevels []int32
+			for _, issue := range report.Issues {
+				gotChecks = append(gotChecks, issue.Check)
+				gotLevels = append(gotLevels, issue.Level)
+				if issue.Suggestion == "" {
+					t.Errorf("AuditConsistency() gave an issue without a suggestion: %+v", issue)
+				}
+			}
+
+			if report.PlayersChecked != test.wantChecked || !reflect.DeepEqual(gotChecks, test.wantChecks) || !reflect.DeepEqual(gotLevels, test.wantLevels) {
+				t.Errorf("AuditConsistency() gave incorrect results, want checks: %v at levels: %v, got: %+v", test.wantChecks, test.wantLevels, report)
+			}
+		})
+	}
+}
+
+func TestServer_HandleConsistencyAuditRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ss := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	err := ss.dataClient.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player2", Level: 1})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		query      string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", ss, "testToken", "", http.StatusUnauthorized},
+		{"invalid limit", ss, "adminToken", "limit=abc", http.StatusBadRequest},
+		{"unknown player", ss, "adminToken", "id=player1", http.StatusNotFound},
+		{"single player", ss, "adminToken", "id=player2", http.StatusOK},
+		{"page of players", ss, "adminToken", "limit=10", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodGet, "/stats/admin/consistency-audit?"+test.query, nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			statsServer.HandleConsistencyAuditRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
+
+// failingExportStore is a file export store which fails to write chunk files once it has written the given number of them
+type failingExportStore struct {
+	*FileExportStore
+	chunksLeft int
+}
+
+func (fes *failingExportStore) Write(name string, contents []byte) error {
+
+	if name != exportProgressName {
+		if fes.chunksLeft == 0 {
+			return fmt.Errorf("store unavailable")
+		}
+		fes.chunksLeft--
+	}
+	return fes.FileExportStore.Write(name, contents)
+}
+
+func TestServer_Export(t *testing.T) {
+
+	ds := data.NewServer()
+	ss := NewServer(auth.NewServer(data.NewServer()), ds)
+
+	_, err := ss.Export(context.Background(), false)
+	if !errors.Is(err, exportDisabledError) {
+		t.Fatalf("Export() gave incorrect results, want: %v, got: %v", exportDisabledError, err)
+	}
+
+	// 5 players, with 2 level stats each, except for the last one (who has none)
+	for i := 1; i <= 5; i++ {
+		levelStats := []data.PlayerLevelStats{{Level: 1, WinCount: 1, BestScore: 2}, {Level: 2, LossCount: 1}}
+		if i == 5 {
+			levelStats = []data.PlayerLevelStats{}
+		}
+		err = ds.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: fmt.Sprintf("player%v", i), PlayerStats: data.PlayerStats{LevelStats: levelStats, Rating: 1000}})
+		if err != nil {
+			t.Fatalf("%v \n", err.Error())
+		}
+	}
+
+	fileStore, err := NewFileExportStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	// the store fails after the first chunk, so the export stops there
+	ss.EnableExport(&failingExportStore{FileExportStore: fileStore, chunksLeft: 1}, 2)
+
+	progress, err := ss.Export(context.Background(), false)
+	if err == nil || progress.Done || len(progress.Files) != 1 || progress.Players != 2 || progress.LastError == "" {
+		t.Fatalf("Export() gave incorrect results, want an error after 1 chunk, got: %+v (error: %v)", progress, err)
+	}
+
+	status, err := ss.ExportStatus(context.Background())
+	if err != nil || status.ExportID != progress.ExportID || status.Running || len(status.Files) != 1 || status.LastError == "" {
+		t.Fatalf("ExportStatus() gave incorrect results, want the interrupted export, got: %+v (error: %v)", status, err)
+	}
+
+	// the export continues from the chunk it stopped at
+	ss.EnableExport(fileStore, 2)
+
+	progress, err = ss.Export(context.Background(), false)
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	if progress.ExportID != status.ExportID || !progress.Done || len(progress.Files) != 3 || progress.Players != 5 || progress.Rows != 9 || progress.LastError != "" {
+		t.Fatalf("Export() gave incorrect results, want the export finished in 3 files, with 5 players and 9 rows, got: %+v", progress)
+	}
+
+	lastChunk, err := fileStore.Read(progress.Files[2])
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	want := "playerID,rating,prestigeCount,winStreak,level,winCount,lossCount,bestScore\nplayer5,1000,0,0,,,,\n"
+	if string(lastChunk) != want {
+		t.Errorf("Export() wrote an incorrect chunk, want: %q, got: %q", want, lastChunk)
+	}
+
+	// a finished export is not continued, a new one is started
+	restarted, err := ss.Export(context.Background(), false)
+	if err != nil || !restarted.Done || restarted.Players != 5 {
+		t.Errorf("Export() gave incorrect results, want a new export of 5 players, got: %+v (error: %v)", restarted, err)
+	}
+}
+
+func TestServer_HandleExportRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	disabled := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+
+	ss := NewServer(auth.NewServer(data.NewServer()), data.NewServer())
+	store, err := NewFileExportStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+	ss.EnableExport(store, 10)
+
+	err = ss.dataClient.WriteStats(context.Background(), &data.PlayerStatsWithID{PlayerID: "player1", PlayerStats: data.PlayerStats{LevelStats: []data.PlayerLevelStats{{Level: 1, WinCount: 1}}}})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		method     string
+		adminToken string
+		query      string
+		wantStatus int
+	}{
+		{"nil server", nil, http.MethodPost, "", "", http.StatusInternalServerError},
+		{"invalid admin token", ss, http.MethodPost, "testToken", "", http.StatusUnauthorized},
+		{"export disabled", disabled, http.MethodPost, "adminToken", "", http.StatusServiceUnavailable},
+		{"no export yet", ss, http.MethodGet, "adminToken", "", http.StatusNotFound},
+		{"invalid restart", ss, http.MethodPost, "adminToken", "restart=abc", http.StatusBadRequest},
+		{"export started", ss, http.MethodPost, "adminToken", "restart=true", http.StatusAccepted},
+		{"export status", ss, http.MethodGet, "adminToken", "", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(test.method, "/stats/admin/export?"+test.query, nil)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			statsServer := test.server
+			if test.method == http.MethodPost {
+				statsServer.HandleExportRequest(respRec, newReq)
+			} else {
+				statsServer.HandleExportStatusRequest(respRec, newReq)
+			}
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Errorf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+
+	// the export started above finishes in the background
+	for range 100 {
+		status, err := ss.ExportStatus(context.Background())
+		if err == nil && status.Done && !status.Running {
+			if status.Players != 1 || len(status.Files) != 1 {
+				t.Errorf("the export gave incorrect results, want 1 player in 1 file, got: %+v", status)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("the export did not finish in time")
+}
+
+func TestServer_ClaimMilestone(t *testing.T) {
+
+	ds := data.NewServer()
+	as := auth.NewServer(ds)
+	ss := NewServer(as, ds)
+
+	err := ds.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player1", Level: 1, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	// winning the first 4 levels in a row reaches level 5
+	for level := int32(1); level <= 4; level++ {
+		_, err = ss.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(context.Background(), "player1"), &data.PlayerLevelStats{Level: level, WinCount: 1, BestScore: 2})
+		if err != nil {
+			t.Fatalf("%v \n", err.Error())
+		}
+	}
+
+	milestones, err := ds.ReadMilestones(context.Background(), "player1")
+	if err != nil || len(milestones.Milestones) != 1 || milestones.Milestones[0].MilestoneID != "reach-level-5" {
+		t.Fatalf("the stats updates recorded incorrect milestones, want: reach-level-5, got: %+v (error: %v)", milestones, err)
+	}
+
+	milestone, _ := config.Config.Milestone("reach-level-5")
+
+	tests := []struct {
+		name        string
+		server      *Server
+		rewards     bool
+		milestoneID string
+		wantErr     error
+	}{
+		{"nil server", nil, true, "reach-level-5", serverNilError},
+		{"rewards not enabled", ss, false, "reach-level-5", milestoneRewardsDisabledError},
+		{"unknown milestone", ss, true, "win-1000", MilestoneNotFoundErr{MilestoneID: "win-1000"}},
+		{"not reached", ss, true, "win-100", data.MilestoneNotReachedErr{PlayerID: "player1", MilestoneID: "win-100"}},
+		{"claim", ss, true, "reach-level-5", nil},
+		{"claim again", ss, true, "reach-level-5", data.MilestoneAlreadyClaimedErr{PlayerID: "player1", MilestoneID: "reach-level-5"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if test.rewards {
+				test.server.EnableMilestoneRewards(profile.NewServer(as, ds))
+			} else {
+				test.server.EnableMilestoneRewards(nil)
+			}
+
+			response, gotErr := test.server.ClaimMilestone(context.Background(), "player1", test.milestoneID)
+			if !errors.Is(gotErr, test.wantErr) {
+				t.Fatalf("ClaimMilestone() gave incorrect error, want: %v, got: %v", test.wantErr, gotErr)
+			}
+			if gotErr != nil {
+				return
+			}
+
+			for _, progress := range response.Milestones {
+				if claimed := progress.ClaimTime != 0; claimed != (progress.MilestoneID == test.milestoneID) {
+					t.Errorf("ClaimMilestone() gave incorrect results, got: %+v", progress)
+				}
+			}
+
+			player, err := ds.ReadPlayer(context.Background(), "player1")
+			if err != nil || player.Energy < milestone.EnergyReward {
+				t.Errorf("incorrect energy after the claim, want at least: %v, got: %+v (error: %v)", milestone.EnergyReward, player, err)
+			}
+
+			wallet, err := ds.ReadWallet(context.Background(), "player1")
+			if err != nil || wallet.Coins != config.Config.DefaultCoins+milestone.CoinReward {
+				t.Errorf("incorrect coins after the claim, want: %v, got: %+v (error: %v)", config.Config.DefaultCoins+milestone.CoinReward, wallet, err)
+			}
+		})
+	}
+
+	// a loss ends the win streak, but the progress towards the other milestones stays
+	_, err = ss.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(context.Background(), "player1"), &data.PlayerLevelStats{Level: 5, LossCount: 1, BestScore: 99})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	response, err := ss.ReturnMilestones(context.Background(), "player1")
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	wantProgress := map[string]int32{"reach-level-5": 5, "win-100": 4, "win-streak-10": 0}
+	for _, progress := range response.Milestones {
+		if progress.Progress != wantProgress[progress.MilestoneID] {
+			t.Errorf("ReturnMilestones() gave incorrect progress for %v, want: %v, got: %v", progress.MilestoneID, wantProgress[progress.MilestoneID], progress.Progress)
+		}
+	}
+}
+
+func TestServer_HandleMilestoneRequests(t *testing.T) {
+
+	ds := data.NewServer()
+	as := auth.NewServer(ds)
+	ss := NewServer(as, ds)
+	ss.EnableMilestoneRewards(profile.NewServer(as, ds))
+
+	err := ds.WritePlayer(context.Background(), &data.PlayerData{PlayerID: "player1", Level: 1, LastUpdateTime: time.Now().UTC().Unix()})
+	if err != nil {
+		t.Fatalf("%v \n", err.Error())
+	}
+
+	// winning the first 4 levels in a row reaches level 5
+	for level := int32(1); level <= 4; level++ {
+		_, err = ss.ReturnUpdatedPlayerStats(playerctx.WithPlayerID(context.Background(), "player1"), &data.PlayerLevelStats{Level: level, WinCount: 1, BestScore: 2})
+		if err != nil {
+			t.Fatalf("%v \n", err.Error())
+		}
+	}
+
+	// the session of each test player is their own
+	sessions := testsetup.Sessions{"session1": "player1", "session2": "player2"}
+
+	tests := []struct {
+		name        string
+		sessionID   string
+		claim       *MilestoneClaimRequestBody
+		playerID    string
+		wantStatus  int
+		wantClaimed bool
+	}{
+		{"blank session id", "", nil, "player1", http.StatusUnauthorized, false},
+		{"milestones of another player", "session2", nil, "player1", http.StatusForbidden, false},
+		{"milestones", "session1", nil, "player1", http.StatusOK, false},
+		{"claim for another player", "session2", &MilestoneClaimRequestBody{PlayerID: "player1", MilestoneID: "reach-level-5"}, "", http.StatusForbidden, false},
+		{"claim", "session1", &MilestoneClaimRequestBody{PlayerID: "player1", MilestoneID: "reach-level-5"}, "", http.StatusOK, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			var newReq *http.Request
+			handler := ss.HandleMilestonesRequest
+			if test.claim != nil {
+				buf := &bytes.Buffer{}
+				err = json.NewEncoder(buf).Encode(test.claim)
+				if err != nil {
+					t.Fatal("could not encode the request body: " + err.Error())
+				}
+				newReq = httptest.NewRequest(http.MethodPost, "/stats/milestones/claim", buf)
+				handler = ss.HandleClaimMilestoneRequest
+			} else {
+				newReq = httptest.NewRequest(http.MethodGet, "/stats/milestones/"+test.playerID, nil)
+				newReq.SetPathValue("id", test.playerID)
+			}
+			newReq.Header.Set("Session-Id", test.sessionID)
+			respRec := httptest.NewRecorder()
+
+			testsetup.WithSession(ss, sessions, handler)(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotResponseBody := &MilestonesResponse{}
+				err = json.NewDecoder(respRec.Result().Body).Decode(gotResponseBody)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				for _, progress := range gotResponseBody.Milestones {
+					if progress.MilestoneID == "reach-level-5" && (progress.ClaimTime != 0) != test.wantClaimed {
+						t.Errorf("handler gave incorrect results, want claimed: %v, got: %+v", test.wantClaimed, progress)
+					}
+				}
+			}
+		})
+	}
+}
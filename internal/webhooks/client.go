@@ -0,0 +1,72 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/startup"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"net/http"
+)
+
+var clientNilError = fmt.Errorf("provided webhooks client pointer is nil")
+
+// WebhookClient implementor can publish an event to the webhooks subscribed to it
+// (implemented by the webhooks Server itself for in-process use, and by HTTPClient
+// when the webhooks service runs as its own microservice)
+type WebhookClient interface {
+	Publish(ctx context.Context, event *Event) error
+}
+
+// HTTPClient is the WebhookClient implementation which makes internal (server to server) requests to the webhooks service
+type HTTPClient struct {
+	baseURL string
+}
+
+// NewHTTPClient returns an initialized pointer to a webhooks client that talks to the webhooks service at its address in the startup config (see startup.ServiceURL)
+func NewHTTPClient() *HTTPClient {
+	return &HTTPClient{
+		baseURL: startup.ServiceURL("webhooks"),
+	}
+}
+
+// Publish makes an internal request to the webhooks service to deliver the given event to the webhooks subscribed to it
+func (hc *HTTPClient) Publish(ctx context.Context, event *Event) error {
+
+	if hc == nil {
+		return clientNilError
+	}
+
+	// create a new context
+	ctx, cancel := tracing.InternalContext(ctx, hc.baseURL)
+	defer cancel()
+
+	// create the request body
+	reqBody := &bytes.Buffer{}
+	err := json.NewEncoder(reqBody).Encode(event)
+	if err != nil {
+		return err
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseURL+"/webhooks/event-internal", reqBody)
+	if err != nil {
+		return err
+	}
+
+	// send the request
+	client := tracing.HTTPClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// check response status
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("internal publish event request was not successful, status code %v", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// the statuses of a delivery
+const (
+	DeliveryPending   = "pending"   // not sent yet, or waiting to be retried
+	DeliveryDelivered = "delivered" // the webhook url responded with a 2xx status
+	DeliveryFailed    = "failed"    // every attempt failed, it is not retried anymore
+)
+
+// how long a webhook url has to respond to a delivery
+const deliveryTimeout time.Duration = 5 * time.Second
+
+// a failed delivery is retried till it was attempted this many times, waiting twice as long before each retry
+const (
+	maxDeliveryAttempts = 5
+	retryBaseDelay      = 10 * time.Second
+)
+
+// Delivery is the delivery of an event to a webhook, along with how its attempts went so far
+type Delivery struct {
+	DeliveryID      string `json:"deliveryID"`
+	WebhookID       string `json:"webhookID"`
+	EventID         string `json:"eventID"`
+	EventType       string `json:"eventType"`
+	Status          string `json:"status"`
+	Attempts        int32  `json:"attempts"`
+	LastAttemptTime int64  `json:"lastAttemptTime,omitempty"`
+	LastStatusCode  int    `json:"lastStatusCode,omitempty"`
+	LastError       string `json:"lastError,omitempty"`
+	NextAttemptTime int64  `json:"nextAttemptTime,omitempty"` // unix time of the next attempt, while the delivery is pending
+
+	// the encoded event, and whether it is being sent right now
+	payload  []byte
+	inFlight bool
+}
+
+// Sign returns the signature sent in the Webhook-Signature header of a delivery (after "sha256="): the hex encoded
+// hmac sha256, keyed with the secret of the webhook, of the Webhook-Timestamp header, a '.' and the request body.
+// Receivers should compute it the same way, compare it in constant time, and reject old timestamps
+func Sign(secret string, timestamp int64, payload []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// StartPeriodicDelivery starts sending the deliveries which are due (new ones, and retries) with the given period,
+// till the given context is done
+func (ws *Server) StartPeriodicDelivery(ctx context.Context, checkPeriod time.Duration) {
+
+	if ws == nil {
+		return
+	}
+
+	ticker := time.NewTicker(checkPeriod)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.deliverDue(context.Background(), time.Now().UTC())
+			}
+		}
+	}()
+}
+
+// deliverDue sends the pending deliveries whose next attempt is due at the given time, and records how each attempt went.
+// It returns the number of deliveries which were delivered
+func (ws *Server) deliverDue(ctx context.Context, now time.Time) int {
+
+	ctx, span := tracing.Start(ctx, "webhooks.deliverDue")
+	defer span.End()
+
+	// pick the due deliveries (and the url and secret to send them with) under the lock, but send them without it
+	type dueDelivery struct {
+		delivery *Delivery
+		url      string
+		secret   string
+	}
+
+	ws.webhooksMutex.Lock()
+	due := []dueDelivery{}
+	for webhookID, deliveries := range ws.deliveries {
+		for _, delivery := range deliveries {
+			if delivery.Status == DeliveryPending && !delivery.inFlight && delivery.NextAttemptTime <= now.Unix() {
+				delivery.inFlight = true
+				due = append(due, dueDelivery{delivery: delivery, url: ws.webhooks[webhookID].URL, secret: ws.webhooks[webhookID].Secret})
+			}
+		}
+	}
+	ws.webhooksMutex.Unlock()
+
+	delivered := 0
+	for _, next := range due {
+		statusCode, err := ws.send(ctx, next.url, next.secret, next.delivery, now.Unix())
+
+		ws.webhooksMutex.Lock()
+		delivery := next.delivery
+		delivery.inFlight = false
+		delivery.Attempts += 1
+		delivery.LastAttemptTime = now.Unix()
+		delivery.LastStatusCode = statusCode
+		delivery.LastError = ""
+
+		switch {
+		case err == nil:
+			delivery.Status = DeliveryDelivered
+			delivery.NextAttemptTime = 0
+			delivered += 1
+		case delivery.Attempts >= maxDeliveryAttempts:
+			delivery.Status = DeliveryFailed
+			delivery.LastError = err.Error()
+			delivery.NextAttemptTime = 0
+		default:
+			delivery.LastError = err.Error()
+			delivery.NextAttemptTime = now.Add(retryBaseDelay << (delivery.Attempts - 1)).Unix()
+		}
+		ws.webhooksMutex.Unlock()
+
+		if err != nil {
+			ws.logger.Printf("error: delivery %v of event %v to webhook %v failed (attempt %v): %v", delivery.DeliveryID, delivery.EventID, delivery.WebhookID, delivery.Attempts, err)
+		}
+	}
+
+	return delivered
+}
+
+// send posts the payload of the delivery to the webhook url, signed with the secret of the webhook,
+// and returns the status code of the response (0 if there was none). A status other than 2xx is an error
+func (ws *Server) send(ctx context.Context, url string, secret string, delivery *Delivery, timestamp int64) (int, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(delivery.payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Event", delivery.EventType)
+	req.Header.Set("Webhook-Delivery", delivery.DeliveryID)
+	req.Header.Set("Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("Webhook-Signature", "sha256="+Sign(secret, timestamp, delivery.payload))
+
+	resp, err := ws.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// drain (some of) the body, so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("the webhook url responded with status code %v", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// trimDeliveries drops the oldest deliveries which are done (or the oldest pending ones, if there are none)
+// till there are at most maxDeliveriesKept of them
+func trimDeliveries(deliveries []*Delivery) []*Delivery {
+
+	for len(deliveries) > maxDeliveriesKept {
+		i := slices.IndexFunc(deliveries, func(delivery *Delivery) bool { return delivery.Status != DeliveryPending })
+		if i < 0 {
+			i = 0
+		}
+		deliveries = slices.Delete(deliveries, i, i+1)
+	}
+
+	return deliveries
+}
@@ -0,0 +1,523 @@
+// Package webhooks: service which lets external services (like analytics, community bots and sites) register webhooks,
+// and delivers the gameplay events they subscribed to (like a level won) to them as signed json payloads
+
+package webhooks
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/admin"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"example.com/dice-game-backend/internal/shared/middleware"
+	"example.com/dice-game-backend/internal/shared/redact"
+	"example.com/dice-game-backend/internal/shared/tracing"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the types of the events webhooks can subscribe to
+const (
+	EventLevelWon      = "level-won"      // sent by the gameplay service, the data is a LevelWonData
+	EventHighScore     = "high-score"     // sent by the stats service, the data is a HighScoreData
+	EventMatchFinished = "match-finished" // sent by the match service, the data is a MatchFinishedData
+)
+
+// EventTypes are all the types of events, in the order they are listed in
+var EventTypes = []string{EventLevelWon, EventHighScore, EventMatchFinished}
+
+// how often the deliveries which are due are sent
+const deliveryCheckPeriod time.Duration = 1 * time.Second
+
+// the most deliveries kept per webhook (the oldest ones which are done are dropped first)
+const maxDeliveriesKept = 100
+
+// Webhooks Specific Errors:
+var serverNilError = fmt.Errorf("provided webhooks server pointer is nil")
+
+type WebhookNotFoundErr struct {
+	WebhookID string
+}
+
+func (err WebhookNotFoundErr) Error() string {
+	return fmt.Sprintf("webhook with id: %v was not found", err.WebhookID)
+}
+
+// RegisterRequestBody is used as the request body for the admin request to register a webhook
+type RegisterRequestBody struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Webhook is a url which the events of the subscribed types are delivered to, the payloads are signed
+// with its secret (see Sign), which is only sent back when the webhook is registered
+type Webhook struct {
+	WebhookID   string   `json:"webhookID"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret,omitempty"`
+	CreatedTime int64    `json:"createdTime"`
+}
+
+// Event is something which happened in the game, which is delivered (as it is) to the webhooks subscribed to its type.
+// The id and time are set by the webhooks service when it is published
+type Event struct {
+	EventID  string          `json:"eventID"`
+	Type     string          `json:"type"`
+	Time     int64           `json:"time"`
+	PlayerID string          `json:"playerID,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// LevelWonData is the data of a level won event
+type LevelWonData struct {
+	Level            int32 `json:"level"`
+	Rolls            int32 `json:"rolls"`
+	UnlockedNewLevel bool  `json:"unlockedNewLevel"`
+}
+
+// HighScoreData is the data of a high score event, sent when a player beats their best score at a level
+// (a score is the number of rolls it took to win, so lower is better), the previous best is left out for a first win
+type HighScoreData struct {
+	Level             int32 `json:"level"`
+	BestScore         int32 `json:"bestScore"`
+	PreviousBestScore int32 `json:"previousBestScore,omitempty"`
+}
+
+// MatchFinishedData is the data of a match finished event (the winner is blank for a draw)
+type MatchFinishedData struct {
+	MatchID   string    `json:"matchID"`
+	PlayerIDs [2]string `json:"playerIDs"`
+	WinnerID  string    `json:"winnerID"`
+	Forfeit   bool      `json:"forfeit"`
+}
+
+// NewEvent returns an event of the given type about the given player (blank if it is not about a single player),
+// with the given data encoded as json
+func NewEvent(eventType string, playerID string, eventData any) (*Event, error) {
+
+	encoded, err := json.Marshal(eventData)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode the event data: %v", err)
+	}
+
+	return &Event{Type: eventType, PlayerID: playerID, Data: encoded}, nil
+}
+
+// Server is the core webhooks service provider
+type Server struct {
+
+	// the registered webhooks (by id), and their deliveries (newest last)
+	webhooks      map[string]*Webhook
+	deliveries    map[string][]*Delivery
+	webhooksMutex sync.Mutex
+
+	// used to send the deliveries to the webhook urls (never the internal http client, which adds the service token)
+	httpClient *http.Client
+
+	// how often the due deliveries are sent, see SetSweepPeriod
+	sweepPeriod time.Duration
+
+	logger *log.Logger
+}
+
+// NewServer returns an initialized pointer to the webhooks server
+func NewServer() *Server {
+	return &Server{
+		webhooks:      map[string]*Webhook{},
+		deliveries:    map[string][]*Delivery{},
+		webhooksMutex: sync.Mutex{},
+
+		httpClient: &http.Client{Timeout: deliveryTimeout},
+
+		sweepPeriod: deliveryCheckPeriod,
+
+		logger: log.New(redact.Stdout, "webhooks: ", log.Ltime|log.LUTC|log.Lmsgprefix),
+	}
+}
+
+// SetSweepPeriod changes how often the due deliveries are sent (used by the startup config), it should be called before Run
+func (ws *Server) SetSweepPeriod(period time.Duration) {
+
+	if ws == nil || period <= 0 {
+		return
+	}
+
+	ws.sweepPeriod = period
+}
+
+// Run runs a given webhooks server on the given port
+func (ws *Server) Run(port string) {
+	log.Fatal(ws.Serve(context.Background(), constants.CommonHost+":"+port))
+}
+
+// Serve runs the webhooks server on the given address till the context is done, then shuts it down gracefully,
+// and returns nil once it is shut down (see middleware.Serve)
+func (ws *Server) Serve(ctx context.Context, addr string) error {
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ws.serve(ctx, listener)
+}
+
+// RunWithListener runs the webhooks server on the given listener (like one on an ephemeral port, in tests)
+// till it fails, like when the listener is closed
+func (ws *Server) RunWithListener(listener net.Listener) error {
+	return ws.serve(context.Background(), listener)
+}
+
+// serve registers the routes of the webhooks server, and serves them on the given listener till the context is done
+func (ws *Server) serve(ctx context.Context, listener net.Listener) error {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("POST /webhooks/event-internal", middleware.WithLimits(ws.HandlePublishRequest, middleware.DefaultLimits))
+
+	mux.Handle("POST /webhooks/admin/webhooks", middleware.WithLimits(ws.HandleRegisterRequest, middleware.DefaultLimits))
+	mux.Handle("GET /webhooks/admin/webhooks", middleware.WithLimits(ws.HandleListRequest, middleware.DefaultLimits))
+	mux.Handle("DELETE /webhooks/admin/webhooks/{id}", middleware.WithLimits(ws.HandleDeleteRequest, middleware.DefaultLimits))
+	mux.Handle("GET /webhooks/admin/webhooks/{id}/deliveries", middleware.WithLimits(ws.HandleDeliveriesRequest, middleware.DefaultLimits))
+
+	ws.StartPeriodicDelivery(ctx, ws.sweepPeriod)
+
+	ws.logger.Println("the webhooks server is up and running...")
+
+	handler := middleware.WithTracing(middleware.WithCompression(middleware.WithCORS(middleware.WithVersioning(middleware.WithServiceAuth(middleware.WithNamespace(middleware.WithLiveStats("webhooks", middleware.WithAccessLog("webhooks", mux))))), middleware.DefaultCORSOptions), middleware.DefaultCompressionOptions))
+	return middleware.Serve(ctx, listener, handler)
+}
+
+// Register registers a webhook for the given url (which should be an absolute http or https url),
+// subscribed to the given event types, and returns it along with its newly generated secret
+func (ws *Server) Register(ctx context.Context, request *RegisterRequestBody) (*Webhook, error) {
+
+	if ws == nil {
+		return nil, serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "webhooks.Register")
+	defer span.End()
+
+	if request == nil {
+		return nil, fmt.Errorf("provided register request pointer is nil")
+	}
+
+	webhookURL, err := url.Parse(request.URL)
+	if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+		return nil, fmt.Errorf("the webhook url should be an absolute http or https url, got: %q", request.URL)
+	}
+
+	if len(request.Events) == 0 {
+		return nil, fmt.Errorf("the webhook should subscribe to at least one of the events: %v", EventTypes)
+	}
+	for _, eventType := range request.Events {
+		if !slices.Contains(EventTypes, eventType) {
+			return nil, fmt.Errorf("%q is not one of the events: %v", eventType, EventTypes)
+		}
+	}
+
+	webhook := &Webhook{
+		WebhookID:   newID("webhook-", 8),
+		URL:         request.URL,
+		Events:      slices.Compact(slices.Sorted(slices.Values(request.Events))),
+		Secret:      newID("", 32),
+		CreatedTime: time.Now().UTC().Unix(),
+	}
+
+	ws.webhooksMutex.Lock()
+	defer ws.webhooksMutex.Unlock()
+
+	ws.webhooks[webhook.WebhookID] = webhook
+
+	registered := *webhook
+	return &registered, nil
+}
+
+// List returns the registered webhooks (without their secrets), oldest first
+func (ws *Server) List() []Webhook {
+
+	if ws == nil {
+		return nil
+	}
+
+	ws.webhooksMutex.Lock()
+	defer ws.webhooksMutex.Unlock()
+
+	webhooks := make([]Webhook, 0, len(ws.webhooks))
+	for _, webhook := range ws.webhooks {
+		listed := *webhook
+		listed.Secret = ""
+		webhooks = append(webhooks, listed)
+	}
+
+	slices.SortFunc(webhooks, func(a, b Webhook) int {
+		return cmp.Or(cmp.Compare(a.CreatedTime, b.CreatedTime), strings.Compare(a.WebhookID, b.WebhookID))
+	})
+
+	return webhooks
+}
+
+// Delete removes the webhook with the given id, along with its deliveries (pending ones are not sent)
+func (ws *Server) Delete(webhookID string) error {
+
+	if ws == nil {
+		return serverNilError
+	}
+
+	ws.webhooksMutex.Lock()
+	defer ws.webhooksMutex.Unlock()
+
+	if _, ok := ws.webhooks[webhookID]; !ok {
+		return WebhookNotFoundErr{WebhookID: webhookID}
+	}
+
+	delete(ws.webhooks, webhookID)
+	delete(ws.deliveries, webhookID)
+	return nil
+}
+
+// Publish queues a delivery of the given event to every webhook subscribed to its type (it is not sent right away,
+// see StartPeriodicDelivery), the event id and time are set here
+func (ws *Server) Publish(ctx context.Context, event *Event) error {
+
+	if ws == nil {
+		return serverNilError
+	}
+
+	_, span := tracing.Start(ctx, "webhooks.Publish")
+	defer span.End()
+
+	if event == nil {
+		return fmt.Errorf("provided event pointer is nil")
+	}
+
+	if !slices.Contains(EventTypes, event.Type) {
+		return fmt.Errorf("%q is not one of the events: %v", event.Type, EventTypes)
+	}
+
+	published := *event
+	published.EventID = newID("event-", 8)
+	published.Time = time.Now().UTC().Unix()
+
+	payload, err := json.Marshal(&published)
+	if err != nil {
+		return fmt.Errorf("could not encode the event: %v", err)
+	}
+
+	ws.webhooksMutex.Lock()
+	defer ws.webhooksMutex.Unlock()
+
+	for webhookID, webhook := range ws.webhooks {
+		if !slices.Contains(webhook.Events, published.Type) {
+			continue
+		}
+
+		delivery := &Delivery{
+			DeliveryID:      newID("delivery-", 8),
+			WebhookID:       webhookID,
+			EventID:         published.EventID,
+			EventType:       published.Type,
+			Status:          DeliveryPending,
+			NextAttemptTime: published.Time,
+
+			payload: payload,
+		}
+		ws.deliveries[webhookID] = trimDeliveries(append(ws.deliveries[webhookID], delivery))
+	}
+
+	return nil
+}
+
+// Deliveries returns the deliveries of the webhook with the given id, newest first
+func (ws *Server) Deliveries(webhookID string) ([]Delivery, error) {
+
+	if ws == nil {
+		return nil, serverNilError
+	}
+
+	ws.webhooksMutex.Lock()
+	defer ws.webhooksMutex.Unlock()
+
+	if _, ok := ws.webhooks[webhookID]; !ok {
+		return nil, WebhookNotFoundErr{WebhookID: webhookID}
+	}
+
+	deliveries := make([]Delivery, 0, len(ws.deliveries[webhookID]))
+	for _, delivery := range slices.Backward(ws.deliveries[webhookID]) {
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, nil
+}
+
+// HandlePublishRequest is a wrapper around the Publish() method which will be used to field
+// internal (server to server) requests from the services the events happen in
+func (ws *Server) HandlePublishRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ws == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// decode the request body, which should be an Event struct
+	event := &Event{}
+	err := json.NewDecoder(r.Body).Decode(event)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ws.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	err = ws.Publish(r.Context(), event)
+	if err != nil {
+		errMsg := "error: could not publish the event: " + err.Error()
+		ws.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+}
+
+// HandleRegisterRequest registers the webhook in the request body (admin only),
+// and responds with it, including its secret (which is not shown again)
+func (ws *Server) HandleRegisterRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ws == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ws.validateAdmin(w, r) {
+		return
+	}
+
+	// decode the request body, which should be a RegisterRequestBody struct
+	decodedReq := &RegisterRequestBody{}
+	err := json.NewDecoder(r.Body).Decode(decodedReq)
+	if err != nil {
+		errMsg := "error: could not decode request body: " + err.Error()
+		ws.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ws.logger.Printf("admin register webhook request for url: %v, events: %v", decodedReq.URL, decodedReq.Events)
+
+	webhook, err := ws.Register(r.Context(), decodedReq)
+	if err != nil {
+		errMsg := "error: could not register the webhook: " + err.Error()
+		ws.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	ws.writeResponse(w, webhook)
+}
+
+// HandleListRequest responds with the registered webhooks (admin only)
+func (ws *Server) HandleListRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ws == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ws.validateAdmin(w, r) {
+		return
+	}
+
+	ws.writeResponse(w, ws.List())
+}
+
+// HandleDeleteRequest removes the webhook in the path (admin only)
+func (ws *Server) HandleDeleteRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ws == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ws.validateAdmin(w, r) {
+		return
+	}
+
+	webhookID := r.PathValue("id")
+	ws.logger.Printf("admin delete webhook request for id: %v", webhookID)
+
+	err := ws.Delete(webhookID)
+	if err != nil {
+		errMsg := "error: could not delete the webhook: " + err.Error()
+		ws.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+}
+
+// HandleDeliveriesRequest responds with the deliveries of the webhook in the path, newest first (admin only)
+func (ws *Server) HandleDeliveriesRequest(w http.ResponseWriter, r *http.Request) {
+
+	if ws == nil {
+		http.Error(w, serverNilError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ws.validateAdmin(w, r) {
+		return
+	}
+
+	deliveries, err := ws.Deliveries(r.PathValue("id"))
+	if err != nil {
+		errMsg := "error: could not return the deliveries: " + err.Error()
+		ws.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusNotFound)
+		return
+	}
+
+	ws.writeResponse(w, deliveries)
+}
+
+// validateAdmin responds with a 401 (and returns false) if the request does not have a valid admin token
+func (ws *Server) validateAdmin(w http.ResponseWriter, r *http.Request) bool {
+
+	err := admin.ValidateRequest(r)
+	if err != nil {
+		errMsg := "error: admin validation error: " + err.Error()
+		ws.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// writeResponse encodes the given response as json
+func (ws *Server) writeResponse(w http.ResponseWriter, response any) {
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		errMsg := "error: could not encode the response: " + err.Error()
+		ws.logger.Println(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+	}
+}
+
+// newID returns a random id of the given number of bytes (hex encoded), after the given prefix
+func newID(prefix string, size int) string {
+
+	randomBytes := make([]byte, size)
+	_, _ = rand.Read(randomBytes) // crypto/rand never returns an error
+
+	return prefix + hex.EncodeToString(randomBytes)
+}
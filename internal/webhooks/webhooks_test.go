@@ -0,0 +1,252 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"example.com/dice-game-backend/internal/shared/constants"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingReceiver is a webhook url which checks the signature of each delivery, and records the events
+// it received, it responds with the given status code
+type recordingReceiver struct {
+	secret     string
+	statusCode int
+
+	events      []Event
+	badRequests int
+	mutex       sync.Mutex
+}
+
+func (rr *recordingReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get("Webhook-Timestamp"), 10, 64)
+	event := Event{}
+	if r.Header.Get("Webhook-Signature") != "sha256="+Sign(rr.secret, timestamp, body) || json.Unmarshal(body, &event) != nil {
+		rr.badRequests += 1
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	rr.events = append(rr.events, event)
+	w.WriteHeader(rr.statusCode)
+}
+
+func TestNewWebhooksServer(t *testing.T) {
+
+	ws := NewServer()
+	if ws == nil {
+		t.Fatal("new webhooks server should not return a nil server pointer")
+	}
+}
+
+func TestServer_HandleRegisterRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ws := NewServer()
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		body       string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", ws, "testToken", `{"url":"https://example.com/hook","events":["level-won"]}`, http.StatusUnauthorized},
+		{"relative url", ws, "adminToken", `{"url":"/hook","events":["level-won"]}`, http.StatusBadRequest},
+		{"unsupported scheme", ws, "adminToken", `{"url":"ftp://example.com/hook","events":["level-won"]}`, http.StatusBadRequest},
+		{"no events", ws, "adminToken", `{"url":"https://example.com/hook","events":[]}`, http.StatusBadRequest},
+		{"unknown event", ws, "adminToken", `{"url":"https://example.com/hook","events":["level-lost"]}`, http.StatusBadRequest},
+		{"valid webhook", ws, "adminToken", `{"url":"https://example.com/hook","events":["level-won","high-score","level-won"]}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodPost, "/webhooks/admin/webhooks", strings.NewReader(test.body))
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			webhooksServer := test.server
+			webhooksServer.HandleRegisterRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+
+			if gotStatus == http.StatusOK {
+				gotWebhook := &Webhook{}
+				err := json.NewDecoder(respRec.Result().Body).Decode(gotWebhook)
+				if err != nil {
+					t.Fatal("could not decode the response body")
+				}
+
+				if gotWebhook.WebhookID == "" || gotWebhook.Secret == "" || strings.Join(gotWebhook.Events, ",") != "high-score,level-won" {
+					t.Errorf("handler gave incorrect results, got: %+v", gotWebhook)
+				}
+
+				// the secret is only sent back on registration
+				listed := ws.List()
+				if len(listed) != 1 || listed[0].WebhookID != gotWebhook.WebhookID || listed[0].Secret != "" {
+					t.Errorf("List() gave incorrect results, got: %+v", listed)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_deliverDue(t *testing.T) {
+
+	ws := NewServer()
+	ctx := context.Background()
+
+	receiver := &recordingReceiver{statusCode: http.StatusOK}
+	receiverServer := httptest.NewServer(receiver)
+	defer receiverServer.Close()
+
+	failingReceiver := &recordingReceiver{statusCode: http.StatusInternalServerError}
+	failingServer := httptest.NewServer(failingReceiver)
+	defer failingServer.Close()
+
+	webhook, err := ws.Register(ctx, &RegisterRequestBody{URL: receiverServer.URL, Events: []string{EventLevelWon}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver.secret = webhook.Secret
+
+	failingWebhook, err := ws.Register(ctx, &RegisterRequestBody{URL: failingServer.URL, Events: []string{EventLevelWon, EventHighScore}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failingReceiver.secret = failingWebhook.Secret
+
+	levelWon, err := NewEvent(EventLevelWon, "player1", &LevelWonData{Level: 2, Rolls: 1, UnlockedNewLevel: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	highScore, err := NewEvent(EventHighScore, "player1", &HighScoreData{Level: 2, BestScore: 1, PreviousBestScore: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range []*Event{levelWon, highScore} {
+		err = ws.Publish(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the working webhook gets the level won event (it did not subscribe to high scores),
+	// and the deliveries to the failing webhook are retried, waiting longer after each attempt
+	now := time.Now().UTC()
+	tests := []struct {
+		name          string
+		at            time.Time
+		wantDelivered int
+		wantStatus    string
+		wantAttempts  int32
+	}{
+		{"first attempt", now, 1, DeliveryPending, 1},
+		{"before the first retry", now.Add(5 * time.Second), 0, DeliveryPending, 1},
+		{"first retry", now.Add(10 * time.Second), 0, DeliveryPending, 2},
+		{"second retry", now.Add(30 * time.Second), 0, DeliveryPending, 3},
+		{"third retry", now.Add(70 * time.Second), 0, DeliveryPending, 4},
+		{"last retry", now.Add(150 * time.Second), 0, DeliveryFailed, 5},
+		{"after the last retry", now.Add(time.Hour), 0, DeliveryFailed, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotDelivered := ws.deliverDue(ctx, test.at)
+			if gotDelivered != test.wantDelivered {
+				t.Errorf("deliverDue() gave incorrect results, want: %v, got: %v", test.wantDelivered, gotDelivered)
+			}
+
+			deliveries, deliveriesErr := ws.Deliveries(failingWebhook.WebhookID)
+			if deliveriesErr != nil {
+				t.Fatal(deliveriesErr)
+			}
+			if len(deliveries) != 2 {
+				t.Fatalf("Deliveries() gave incorrect number of deliveries, want: 2, got: %v", len(deliveries))
+			}
+			for _, delivery := range deliveries {
+				if delivery.Status != test.wantStatus || delivery.Attempts != test.wantAttempts || delivery.LastStatusCode != http.StatusInternalServerError {
+					t.Errorf("Deliveries() gave incorrect results, want status: %v after %v attempts, got: %+v", test.wantStatus, test.wantAttempts, delivery)
+				}
+			}
+		})
+	}
+
+	if len(receiver.events) != 1 || receiver.events[0].Type != EventLevelWon || receiver.events[0].PlayerID != "player1" || receiver.events[0].EventID == "" {
+		t.Errorf("the webhook received incorrect events: %+v", receiver.events)
+	}
+	if receiver.badRequests != 0 || failingReceiver.badRequests != 0 {
+		t.Errorf("the webhooks received deliveries with invalid signatures: %v and %v", receiver.badRequests, failingReceiver.badRequests)
+	}
+
+	deliveries, err := ws.Deliveries(webhook.WebhookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != DeliveryDelivered || deliveries[0].EventID != receiver.events[0].EventID {
+		t.Errorf("Deliveries() gave incorrect results, got: %+v", deliveries)
+	}
+}
+
+func TestServer_HandleDeleteRequest(t *testing.T) {
+
+	t.Setenv(constants.AdminTokenEnvVar, "adminToken")
+
+	ws := NewServer()
+	webhook, err := ws.Register(context.Background(), &RegisterRequestBody{URL: "https://example.com/hook", Events: []string{EventMatchFinished}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		adminToken string
+		webhookID  string
+		wantStatus int
+	}{
+		{"nil server", nil, "", "", http.StatusInternalServerError},
+		{"invalid admin token", ws, "testToken", webhook.WebhookID, http.StatusUnauthorized},
+		{"unknown webhook", ws, "adminToken", "webhook-missing", http.StatusNotFound},
+		{"valid webhook", ws, "adminToken", webhook.WebhookID, http.StatusOK},
+		{"deleted webhook", ws, "adminToken", webhook.WebhookID, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			newReq := httptest.NewRequest(http.MethodDelete, "/webhooks/admin/webhooks/"+test.webhookID, nil)
+			newReq.SetPathValue("id", test.webhookID)
+			newReq.Header.Set("Admin-Token", test.adminToken)
+			respRec := httptest.NewRecorder()
+
+			webhooksServer := test.server
+			webhooksServer.HandleDeleteRequest(respRec, newReq)
+
+			gotStatus := respRec.Result().StatusCode
+			if gotStatus != test.wantStatus {
+				t.Fatalf("handler gave incorrect results, want: %v, got: %v", test.wantStatus, gotStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Package rules holds the decisions of a level result: whether the attempt was won, whether it unlocked a new level,
+// and the energy reward it earned (with its bonuses), along with the combo the player builds. It has no dependencies
+// (no config, no services, no http), so the gameplay service and the game client can share the exact same logic,
+// the client to predict a result before the server confirms it. Everything comes in through an Attempt, see Evaluate
+package rules
+
+import (
+	"fmt"
+	"math"
+)
+
+// kinds of bonuses a level result can apply (see Bonuses)
+const (
+	BonusFirstRoll = "first-roll"
+	BonusCombo     = "combo"
+)
+
+// Level holds the settings of a level the rules use
+type Level struct {
+	Level        int32 // the number of the level, starting from 1
+	TotalRolls   int32 // the number of rolls the player gets to hit the target
+	Target       int32 // the roll which wins the level
+	EnergyReward int32 // the energy reward of a win, before the multipliers and bonuses
+}
+
+// Bonuses holds the bonus rules of level wins: a win on the first roll gets FirstRollEnergy extra energy, and each
+// win in a row (at any level) raises the combo multiplier of the player by ComboStep, up to MaxComboMultiplier, which
+// multiplies the energy reward of their next win. A loss resets the combo (a ComboStep of 0 turns combos off)
+type Bonuses struct {
+	FirstRollEnergy    int32
+	ComboStep          float64
+	MaxComboMultiplier float64
+}
+
+// Player holds the state of a player the rules use
+type Player struct {
+	Level           int32   // the highest level the player unlocked
+	ComboMultiplier float64 // the combo multiplier built by the current win combo of the player (0 when they have none)
+}
+
+// Attempt holds everything the rules need to decide the result of an attempt at a level
+type Attempt struct {
+	Level      Level
+	LevelCount int32 // the number of levels in the game, the last one cannot unlock another
+	Player     Player
+	Rolls      []int32 // the rolls the player made, in order, the last one decides the result
+
+	// practice attempts are only played for the win / loss, without rewards, unlocks or combos
+	Practice bool
+
+	// the multipliers of the energy reward (like the prestige rank and the segment tuning of the player),
+	// applied in order, each one rounded, before the bonuses
+	RewardMultipliers []float64
+
+	Bonuses Bonuses
+}
+
+// AppliedBonus is a bonus applied to the energy reward of a level result, with the extra energy it gave
+// (and the multiplier, for combo bonuses), so the client can animate it
+type AppliedBonus struct {
+	Bonus      string  `json:"bonus"`
+	Energy     int32   `json:"energy"`
+	Multiplier float64 `json:"multiplier,omitempty"`
+}
+
+// Outcome is the result of an attempt decided by the rules
+type Outcome struct {
+	Won              bool
+	UnlockedNewLevel bool
+	NewLevel         int32          // the highest level the player unlocked, after the attempt
+	EnergyReward     int32          // the energy the attempt earned, with its multipliers and bonuses
+	Bonuses          []AppliedBonus // the bonuses applied to the energy reward
+	ComboMultiplier  float64        // the combo multiplier of the player after the attempt
+	Score            int32          // the number of rolls the attempt took (lower is better)
+}
+
+type InvalidRollsErr struct {
+	Level      int32
+	Rolls      int32
+	TotalRolls int32
+}
+
+func (err InvalidRollsErr) Error() string {
+	return fmt.Sprintf("level %v takes from 1 to %v rolls, got: %v", err.Level, err.TotalRolls, err.Rolls)
+}
+
+// Evaluate decides the outcome of the given attempt: it is won if its last roll hits the target of the level, a win at
+// the highest level the player unlocked unlocks the next one (if there is one), and a win earns the energy reward of the
+// level with the reward multipliers and the bonuses applied. It fails with an InvalidRollsErr if the attempt has no rolls,
+// or more than the level allows. It does not validate the roll values (those depend on the dice of the level)
+func Evaluate(attempt *Attempt) (*Outcome, error) {
+
+	if attempt == nil {
+		return nil, fmt.Errorf("cannot evaluate a nil attempt")
+	}
+
+	rollCount := int32(len(attempt.Rolls))
+	if rollCount == 0 || rollCount > attempt.Level.TotalRolls {
+		return nil, InvalidRollsErr{Level: attempt.Level.Level, Rolls: rollCount, TotalRolls: attempt.Level.TotalRolls}
+	}
+
+	outcome := &Outcome{
+		Won:             attempt.Rolls[rollCount-1] == attempt.Level.Target,
+		NewLevel:        attempt.Player.Level,
+		ComboMultiplier: attempt.Player.ComboMultiplier,
+		Score:           rollCount,
+	}
+
+	if attempt.Practice {
+		return outcome, nil
+	}
+
+	// a loss resets the combo
+	outcome.ComboMultiplier = 0
+	if !outcome.Won {
+		return outcome, nil
+	}
+
+	outcome.UnlockedNewLevel = attempt.Level.Level == attempt.Player.Level && attempt.Level.Level < attempt.LevelCount
+	if outcome.UnlockedNewLevel {
+		outcome.NewLevel += 1
+	}
+
+	energyReward := attempt.Level.EnergyReward
+	for _, multiplier := range attempt.RewardMultipliers {
+		energyReward = ScaleReward(energyReward, multiplier)
+	}
+
+	outcome.EnergyReward, outcome.Bonuses = attempt.Bonuses.Apply(energyReward, attempt.Player.ComboMultiplier, rollCount)
+	outcome.ComboMultiplier = attempt.Bonuses.NextComboMultiplier(attempt.Player.ComboMultiplier)
+
+	return outcome, nil
+}
+
+// ScaleReward returns the given energy reward multiplied by the given multiplier (rounded)
+func ScaleReward(energyReward int32, multiplier float64) int32 {
+	return int32(math.Round(float64(energyReward) * multiplier))
+}
+
+// Apply applies the bonuses to the energy reward of a win made with the given number of rolls, by a player with the
+// given combo multiplier (built before this win), and returns the energy reward with the bonuses, and the bonuses applied
+func (b Bonuses) Apply(energyReward int32, comboMultiplier float64, rollCount int32) (int32, []AppliedBonus) {
+
+	var bonuses []AppliedBonus
+
+	if comboMultiplier > 1 {
+		comboEnergy := int32(math.Round(float64(energyReward) * (comboMultiplier - 1)))
+		if comboEnergy > 0 {
+			bonuses = append(bonuses, AppliedBonus{Bonus: BonusCombo, Energy: comboEnergy, Multiplier: comboMultiplier})
+			energyReward += comboEnergy
+		}
+	}
+
+	if rollCount == 1 && b.FirstRollEnergy > 0 {
+		bonuses = append(bonuses, AppliedBonus{Bonus: BonusFirstRoll, Energy: b.FirstRollEnergy})
+		energyReward += b.FirstRollEnergy
+	}
+
+	return energyReward, bonuses
+}
+
+// NextComboMultiplier returns the combo multiplier of a player after a win, given their combo multiplier before it
+// (0 when they have no combo yet)
+func (b Bonuses) NextComboMultiplier(comboMultiplier float64) float64 {
+
+	if b.ComboStep <= 0 {
+		return 0
+	}
+
+	return min(max(comboMultiplier, 1)+b.ComboStep, max(b.MaxComboMultiplier, 1))
+}
@@ -0,0 +1,83 @@
+package rules
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+
+	level := Level{Level: 2, TotalRolls: 3, Target: 5, EnergyReward: 10}
+	bonuses := Bonuses{FirstRollEnergy: 2, ComboStep: 0.25, MaxComboMultiplier: 1.5}
+
+	tests := []struct {
+		name        string
+		attempt     *Attempt
+		wantOutcome *Outcome
+		wantErr     bool
+	}{
+		{"win unlocks the next level", &Attempt{Level: level, LevelCount: 4, Player: Player{Level: 2}, Rolls: []int32{3, 5}, Bonuses: bonuses},
+			&Outcome{Won: true, UnlockedNewLevel: true, NewLevel: 3, EnergyReward: 10, ComboMultiplier: 1.25, Score: 2}, false},
+		{"win below the highest level", &Attempt{Level: level, LevelCount: 4, Player: Player{Level: 3}, Rolls: []int32{5}, Bonuses: bonuses},
+			&Outcome{Won: true, NewLevel: 3, EnergyReward: 12, Bonuses: []AppliedBonus{{Bonus: BonusFirstRoll, Energy: 2}}, ComboMultiplier: 1.25, Score: 1}, false},
+		{"win at the last level", &Attempt{Level: level, LevelCount: 2, Player: Player{Level: 2}, Rolls: []int32{1, 2, 5}, Bonuses: bonuses},
+			&Outcome{Won: true, NewLevel: 2, EnergyReward: 10, ComboMultiplier: 1.25, Score: 3}, false},
+		{"reward multipliers", &Attempt{Level: level, LevelCount: 4, Player: Player{Level: 3, ComboMultiplier: 1.25}, Rolls: []int32{1, 5}, RewardMultipliers: []float64{1.25, 1.5}, Bonuses: bonuses},
+			&Outcome{Won: true, NewLevel: 3, EnergyReward: 25, Bonuses: []AppliedBonus{{Bonus: BonusCombo, Energy: 5, Multiplier: 1.25}}, ComboMultiplier: 1.5, Score: 2}, false},
+		{"loss resets the combo", &Attempt{Level: level, LevelCount: 4, Player: Player{Level: 2, ComboMultiplier: 1.5}, Rolls: []int32{1, 2, 3}, Bonuses: bonuses},
+			&Outcome{NewLevel: 2, Score: 3}, false},
+		{"practice win", &Attempt{Level: level, LevelCount: 4, Player: Player{Level: 2, ComboMultiplier: 1.5}, Rolls: []int32{5}, Practice: true, Bonuses: bonuses},
+			&Outcome{Won: true, NewLevel: 2, ComboMultiplier: 1.5, Score: 1}, false},
+		{"no rolls", &Attempt{Level: level, LevelCount: 4, Player: Player{Level: 2}}, nil, true},
+		{"too many rolls", &Attempt{Level: level, LevelCount: 4, Player: Player{Level: 2}, Rolls: []int32{1, 2, 3, 5}}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			gotOutcome, err := Evaluate(test.attempt)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Evaluate() gave incorrect results, want error: %v, got: %v", test.wantErr, err)
+			}
+			if err != nil && !errors.As(err, &InvalidRollsErr{}) {
+				t.Errorf("Evaluate() gave incorrect results, want an InvalidRollsErr, got: %v", err)
+			}
+
+			if !reflect.DeepEqual(gotOutcome, test.wantOutcome) {
+				t.Errorf("Evaluate() gave incorrect results, want: %+v, got: %+v", test.wantOutcome, gotOutcome)
+			}
+		})
+	}
+}
+
+func TestBonuses_Apply(t *testing.T) {
+
+	bonuses := Bonuses{FirstRollEnergy: 2, ComboStep: 0.25, MaxComboMultiplier: 1.5}
+
+	tests := []struct {
+		name          string
+		bonuses       Bonuses
+		combo         float64
+		rollCount     int32
+		wantReward    int32
+		wantBonuses   []AppliedBonus
+		wantNextCombo float64
+	}{
+		{"no combo yet", bonuses, 0, 3, 10, nil, 1.25},
+		{"first roll win", bonuses, 0, 1, 12, []AppliedBonus{{Bonus: BonusFirstRoll, Energy: 2}}, 1.25},
+		{"combo", bonuses, 1.25, 2, 13, []AppliedBonus{{Bonus: BonusCombo, Energy: 3, Multiplier: 1.25}}, 1.5},
+		{"highest combo on the first roll", bonuses, 1.5, 1, 17, []AppliedBonus{{Bonus: BonusCombo, Energy: 5, Multiplier: 1.5}, {Bonus: BonusFirstRoll, Energy: 2}}, 1.5},
+		{"bonuses off", Bonuses{}, 0, 1, 10, nil, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotReward, gotBonuses := test.bonuses.Apply(10, test.combo, test.rollCount)
+			gotNextCombo := test.bonuses.NextComboMultiplier(test.combo)
+			if gotReward != test.wantReward || !reflect.DeepEqual(gotBonuses, test.wantBonuses) || gotNextCombo != test.wantNextCombo {
+				t.Errorf("Apply() gave incorrect results, want: %v, %v, %v, got: %v, %v, %v", test.wantReward, test.wantBonuses, test.wantNextCombo, gotReward, gotBonuses, gotNextCombo)
+			}
+		})
+	}
+}